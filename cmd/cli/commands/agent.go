@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sanskarpan/db-backup/internal/agent"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/pkg/redact"
+	"github.com/spf13/cobra"
+)
+
+// agentCmd runs dumps on a database host for a remote server
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run dumps on this host for a remote backup server",
+	Long: `Run as an agent next to a database the backup server cannot reach.
+
+The agent connects out to agent.server over gRPC with mutual TLS,
+identified by the common name of agent.cert_file, and stays connected,
+reconnecting every agent.reconnect_interval after a failure. Dumps the
+server asks for are run here with the connection profiles of this
+configuration, up to agent.max_concurrent at a time, and streamed back in
+agent.chunk_size chunks. Compression, encryption and upload happen on the
+server, so no storage credentials are needed on this host.
+
+Examples:
+  # Serve every connection profile
+  db-backup agent --server backup.internal:9443
+
+  # Serve only the orders profile
+  db-backup agent --profile orders`,
+	RunE: runAgent,
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	agentCmd.Flags().String("server", "", "server address (overrides agent.server)")
+	agentCmd.Flags().StringSlice("profile", nil, "connection profiles to serve (overrides agent.profiles)")
+}
+
+func runAgent(cmd *cobra.Command, args []string) error {
+	server, _ := cmd.Flags().GetString("server")
+	profiles, _ := cmd.Flags().GetStringSlice("profile")
+
+	cfg := GetConfig()
+	log := GetLogger()
+	agentCfg := cfg.Agent
+	if server != "" {
+		agentCfg.Server = server
+	}
+	if len(profiles) > 0 {
+		agentCfg.Profiles = profiles
+	}
+	if agentCfg.Server == "" {
+		return errors.New("no server to connect to: set agent.server or --server")
+	}
+	for _, name := range agentCfg.Profiles {
+		if _, ok := cfg.Connections[name]; !ok {
+			return fmt.Errorf("unknown connection profile %q", name)
+		}
+	}
+
+	a, err := agent.New(agentCfg, Version, func(ctx context.Context, job agent.Job, w io.Writer) error {
+		return agentDump(ctx, cfg, job, w)
+	}, log)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return a.Run(ctx)
+}
+
+// agentDump dumps the database of a job with its connection profile
+func agentDump(ctx context.Context, cfg *config.Config, job agent.Job, w io.Writer) error {
+	conn, err := profileConnection(cfg, job.Profile, config.PurposeBackup)
+	if err != nil {
+		return err
+	}
+	redact.AddSecrets(conn.Password)
+	if job.Database != "" {
+		conn.Database = job.Database
+	}
+
+	driver, err := database.CreateDriver(conn.Type)
+	if err != nil {
+		return err
+	}
+	if err := driver.Connect(ctx, conn); err != nil {
+		return fmt.Errorf("backup login %s failed: %w", conn.Username, err)
+	}
+	defer driver.Disconnect()
+
+	return driver.StreamBackup(ctx, &database.BackupOptions{
+		Database:         conn.Database,
+		Tables:           job.Tables,
+		ExcludeTables:    job.ExcludeTables,
+		ConsistentBackup: true,
+	}, w)
+}
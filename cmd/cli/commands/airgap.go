@@ -0,0 +1,371 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/airgap"
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/spf13/cobra"
+)
+
+// airgapCmd manages copies of backups in the air-gapped vault
+var airgapCmd = &cobra.Command{
+	Use:   "airgap",
+	Short: "Copy backups to an air-gapped vault",
+	Long: `Copy selected backups to the offline or immutable targets under airgap:
+a directory on removable media, such as an LTFS tape or a USB disk that is
+unmounted and taken offsite once written, or an S3 bucket with Object Lock.
+Copies are verified and recorded, so "db-backup airgap status" tells which
+backups survive the loss of the primary storage, and on which medium.
+
+Examples:
+  # Label a tape mounted at the path of the "tape" target
+  db-backup airgap init tape --label TAPE0042
+
+  # Weekly: copy the newest backup of each database to the tape
+  db-backup airgap export tape
+
+  # Show where a backup was copied to
+  db-backup airgap status 20250101-020000-orders`,
+}
+
+// airgapInitCmd labels a medium
+var airgapInitCmd = &cobra.Command{
+	Use:   "init <target>",
+	Short: "Label the medium mounted at a directory target",
+	Long: `Label the medium mounted at a directory target. Exports refuse to write
+to a target path without a label, so an unmounted tape or disk does not
+quietly fill the local disk under its mount point instead. Copies are
+recorded with the label, which should match the one written on the
+cartridge or disk.
+
+Examples:
+  db-backup airgap init tape --label TAPE0042`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAirGapInit,
+}
+
+// airgapExportCmd copies backups to a target
+var airgapExportCmd = &cobra.Command{
+	Use:   "export <target> [backup-id...]",
+	Short: "Copy backups to an air-gapped target",
+	Long: `Copy backups to an air-gapped target. Without backup IDs, the newest
+completed backups of each database in the target's databases (every
+database when empty) are copied; backups already copied to the target and
+quarantined backups are skipped.
+
+Each artifact is checked against the checksum in the catalog before it is
+copied. Directory targets read every copy back before keeping it; S3
+checks the upload against its SHA-256 and locks it for retention_days.
+Only artifacts on the local filesystem can be exported.
+
+Examples:
+  # Copy the newest backup of each database
+  db-backup airgap export tape
+
+  # Copy the three newest backups of orders
+  db-backup airgap export worm --database orders --latest 3
+
+  # Copy given backups
+  db-backup airgap export tape 20250101-020000-orders 20250101-020000-billing
+
+  # Show what would be copied
+  db-backup airgap export tape --dry-run`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runAirGapExport,
+}
+
+// airgapStatusCmd prints recorded copies
+var airgapStatusCmd = &cobra.Command{
+	Use:   "status [backup-id]",
+	Short: "Show the air-gapped copies of backups",
+	Long: `Show the air-gapped copies of backups: the target, the medium and when
+it was copied, and until when it is locked. Failed copies are listed until
+a later export succeeds.
+
+Examples:
+  # Every copy
+  db-backup airgap status
+
+  # The copies of one backup
+  db-backup airgap status 20250101-020000-orders
+
+  # Copies on one target, as JSON
+  db-backup airgap status --target tape --format json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runAirGapStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(airgapCmd)
+	airgapCmd.AddCommand(airgapInitCmd)
+	airgapCmd.AddCommand(airgapExportCmd)
+	airgapCmd.AddCommand(airgapStatusCmd)
+
+	airgapInitCmd.Flags().String("label", "", "label of the medium, e.g. the barcode of a tape (required)")
+	_ = airgapInitCmd.MarkFlagRequired("label")
+
+	airgapExportCmd.Flags().String("database", "", "only copy backups of this database")
+	airgapExportCmd.Flags().Int("latest", 1, "newest backups of each database to copy when no IDs are given")
+	airgapExportCmd.Flags().Bool("dry-run", false, "show what would be copied without copying")
+
+	airgapStatusCmd.Flags().String("target", "", "only show copies on this target")
+	airgapStatusCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+}
+
+// airgapTarget returns the configured target called name
+func airgapTarget(cfg *config.Config, name string) (config.AirGapTargetConfig, error) {
+	if !cfg.AirGap.Enabled {
+		return config.AirGapTargetConfig{}, fmt.Errorf("the air-gapped vault is not enabled (airgap.enabled)")
+	}
+	t, ok := cfg.AirGap.Targets[name]
+	if !ok {
+		names := make([]string, 0, len(cfg.AirGap.Targets))
+		for n := range cfg.AirGap.Targets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return t, fmt.Errorf("no airgap target %q (configured: %s)", name, strings.Join(names, ", "))
+	}
+	return t, nil
+}
+
+func runAirGapInit(cmd *cobra.Command, args []string) error {
+	label, _ := cmd.Flags().GetString("label")
+	tc, err := airgapTarget(GetConfig(), args[0])
+	if err != nil {
+		return err
+	}
+	if tc.Type != "directory" {
+		return fmt.Errorf("only directory targets are labelled, %s is %s", args[0], tc.Type)
+	}
+	target := &airgap.DirTarget{Dir: tc.Path, Prefix: tc.Prefix}
+	if err := target.Init(label); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Labelled %s as %s\n", tc.Path, label)
+	return nil
+}
+
+func runAirGapExport(cmd *cobra.Command, args []string) error {
+	database, _ := cmd.Flags().GetString("database")
+	latest, _ := cmd.Flags().GetInt("latest")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	cfg := GetConfig()
+	log := GetLogger()
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	name := args[0]
+	tc, err := airgapTarget(cfg, name)
+	if err != nil {
+		return err
+	}
+	target, err := airgap.NewTarget(tc)
+	if err != nil {
+		return err
+	}
+
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+	var selected []*models.BackupMetadata
+	if len(args) > 1 {
+		for _, id := range args[1:] {
+			b, err := repo.Get(ctx, id)
+			if err != nil {
+				return fmt.Errorf("backup %s: %w", id, err)
+			}
+			selected = append(selected, b)
+		}
+	} else {
+		selected, err = airgapCandidates(ctx, cfg, repo.List, tc, database, latest)
+		if err != nil {
+			return err
+		}
+	}
+
+	store := airgap.NewStore(cfg.AirGap)
+	backups := make([]airgap.Backup, 0, len(selected))
+	for _, b := range selected {
+		backups = append(backups, airgap.Backup{ID: b.ID, Database: b.Database, Checksum: b.Checksum})
+	}
+	if len(backups) == 0 {
+		fmt.Println("No backups to copy.")
+		return nil
+	}
+
+	if dryRun {
+		for i, b := range backups {
+			copied, err := store.Copied(b.ID, name)
+			if err != nil {
+				return err
+			}
+			if copied {
+				fmt.Printf("- %-38s already on %s\n", truncate(b.ID, 38), name)
+				continue
+			}
+			fmt.Printf("  %-38s %-16s %s\n", truncate(b.ID, 38), truncate(b.Database, 16), formatBytes(selected[i].Size))
+		}
+		return nil
+	}
+
+	// Artifacts are copied from where the backup wrote them
+	paths := make(map[string]string, len(selected))
+	for _, b := range selected {
+		paths[b.ID] = b.BackupPath
+	}
+	source := func(_ context.Context, b airgap.Backup) (string, func(), error) {
+		path := paths[b.ID]
+		if _, err := os.Stat(path); err != nil {
+			return "", nil, fmt.Errorf("artifact not available locally: %w", err)
+		}
+		return path, func() {}, nil
+	}
+
+	var copied, skipped, failed int
+	start := time.Now()
+	err = airgap.NewExporter(store, source).Export(ctx, name, target, backups, func(r airgap.Result) {
+		switch {
+		case r.Skipped:
+			skipped++
+			fmt.Printf("- %-38s already on %s\n", truncate(r.Backup.ID, 38), name)
+		case r.Err != nil:
+			failed++
+			fmt.Printf("✗ %-38s %v\n", truncate(r.Backup.ID, 38), r.Err)
+			if r.Copy != nil {
+				recordAudit(cfg, log, cliActor(), audit.ActionAirGapCopyFailed, r.Backup.ID, map[string]string{
+					"database": r.Backup.Database,
+					"target":   name,
+					"error":    r.Err.Error(),
+				})
+			}
+		default:
+			copied++
+			c := r.Copy
+			fmt.Printf("✓ %-38s %s on %s\n", truncate(r.Backup.ID, 38), formatBytes(c.Size), c.Medium)
+			recordAudit(cfg, log, cliActor(), audit.ActionAirGapCopied, r.Backup.ID, map[string]string{
+				"database": c.Database,
+				"target":   name,
+				"medium":   c.Medium,
+				"object":   c.Object,
+				"checksum": c.Checksum,
+			})
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nCopied %d backup(s) to %s in %s, %d already there, %d failed\n",
+		copied, target, time.Since(start).Round(time.Second), skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d backup(s) could not be copied", failed)
+	}
+	return nil
+}
+
+// airgapCandidates returns the latest completed backups of each database
+// the target holds, skipping quarantined ones
+func airgapCandidates(ctx context.Context, cfg *config.Config, list func(context.Context, *repository.ListFilter) ([]*models.BackupMetadata, error), tc config.AirGapTargetConfig, database string, latest int) ([]*models.BackupMetadata, error) {
+	if latest < 1 {
+		return nil, fmt.Errorf("--latest must be at least 1")
+	}
+	all, err := list(ctx, &repository.ListFilter{Database: database})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	quarantined, err := quarantinedBackups(cfg)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(tc.Databases))
+	for _, db := range tc.Databases {
+		wanted[db] = true
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].StartTime.After(all[j].StartTime) })
+	var out []*models.BackupMetadata
+	perDatabase := make(map[string]int)
+	for _, b := range all {
+		if b.Status != models.BackupStatusCompleted || quarantined[b.ID] {
+			continue
+		}
+		if len(wanted) > 0 && !wanted[b.Database] {
+			continue
+		}
+		if perDatabase[b.Database] >= latest {
+			continue
+		}
+		perDatabase[b.Database]++
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+func runAirGapStatus(cmd *cobra.Command, args []string) error {
+	targetName, _ := cmd.Flags().GetString("target")
+	format, _ := cmd.Flags().GetString("format")
+
+	cfg := GetConfig()
+	if !cfg.AirGap.Enabled {
+		return fmt.Errorf("the air-gapped vault is not enabled (airgap.enabled)")
+	}
+	store := airgap.NewStore(cfg.AirGap)
+	var copies []airgap.Copy
+	var err error
+	if len(args) == 1 {
+		copies, err = store.Copies(args[0])
+	} else {
+		copies, err = store.List()
+	}
+	if err != nil {
+		return err
+	}
+	if targetName != "" {
+		filtered := copies[:0]
+		for _, c := range copies {
+			if c.Target == targetName {
+				filtered = append(filtered, c)
+			}
+		}
+		copies = filtered
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(copies)
+	case "yaml", "yml":
+		return printYAMLValue(copies)
+	}
+
+	if len(copies) == 0 {
+		fmt.Println("No air-gapped copies recorded.")
+		return nil
+	}
+	fmt.Printf("%-38s %-16s %-10s %-16s %-8s %-10s %-20s %s\n", "BACKUP ID", "DATABASE", "TARGET", "MEDIUM", "STATUS", "SIZE", "COPIED", "LOCKED UNTIL / ERROR")
+	for _, c := range copies {
+		detail := "-"
+		if c.RetainUntil != nil {
+			detail = c.RetainUntil.Local().Format("2006-01-02")
+		}
+		if c.Error != "" {
+			detail = c.Error
+		}
+		fmt.Printf("%-38s %-16s %-10s %-16s %-8s %-10s %-20s %s\n", truncate(c.BackupID, 38), truncate(c.Database, 16),
+			truncate(c.Target, 10), truncate(c.Medium, 16), c.Status, formatBytes(c.Size),
+			c.CopiedAt.Local().Format("2006-01-02 15:04:05"), detail)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sanskarpan/db-backup/internal/apply"
+	"github.com/spf13/cobra"
+)
+
+// applyCmd reconciles a declarative file against the server
+var applyCmd = &cobra.Command{
+	Use:   "apply -f FILE",
+	Short: "Reconcile profiles, schedules, retention and routes from a file",
+	Long: `Make the server's connection profiles, retention policies, schedules
+and notification routes match a declarative file, so they can be reviewed
+and rolled out from git. The changes are previewed as a diff before they
+are made: + creates, ~ updates and - deletes.
+
+Resources missing from the file are only deleted with --prune, and only for
+the sections the file has: a file without notification_routes leaves the
+server's routes alone. ${VAR} references in the file are expanded from the
+environment, so passwords can come from CI secrets. The server does not
+return passwords, so changing only a password shows no change.
+
+The server is --server, DBBACKUP_SERVER_URL or the local server. The API key
+is read from --api-key-file or DBBACKUP_API_KEY.
+
+Examples:
+  # Preview the changes
+  db-backup apply -f backups.yaml --dry-run
+
+  # Apply them, deleting schedules and profiles no longer in the file
+  db-backup apply -f backups.yaml --prune --server https://db-backup.internal:8080`,
+	Args: cobra.NoArgs,
+	RunE: runApply,
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+
+	applyCmd.Flags().StringP("file", "f", "", "file of desired resources, - for stdin")
+	applyCmd.Flags().String("server", os.Getenv("DBBACKUP_SERVER_URL"), "db-backup server URL (default the local server)")
+	applyCmd.Flags().String("api-key-file", "", "file holding the API key (default $DBBACKUP_API_KEY)")
+	applyCmd.Flags().Bool("prune", false, "delete resources of the file's sections that it does not list")
+	applyCmd.Flags().Bool("dry-run", false, "only show the changes")
+
+	applyCmd.MarkFlagRequired("file")
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	file, _ := cmd.Flags().GetString("file")
+	server, _ := cmd.Flags().GetString("server")
+	keyFile, _ := cmd.Flags().GetString("api-key-file")
+	prune, _ := cmd.Flags().GetBool("prune")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	desired, err := apply.Load(file)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", file, err)
+	}
+
+	client, err := newApplyClient(server, keyFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	current, err := client.Current(ctx, desired)
+	if err != nil {
+		return err
+	}
+	changes := apply.Plan(desired, current, apply.PlanOptions{Prune: prune})
+	if len(changes) == 0 {
+		fmt.Println("No changes: the server matches the file.")
+		return nil
+	}
+	apply.WritePlan(os.Stdout, changes)
+	fmt.Printf("\nPlan: %s.\n", apply.Summary(changes))
+	if dryRun {
+		return nil
+	}
+
+	fmt.Println()
+	err = client.Apply(ctx, changes, func(c apply.Change) {
+		fmt.Printf("✓ %s %s %s\n", strings.TrimSuffix(c.Action, "e")+"ed", c.Resource.Kind, c.Resource.Name)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Applied %d changes\n", len(changes))
+	return nil
+}
+
+// newApplyClient returns a client of the server at server, the local server
+// when empty, authenticating with the key in keyFile or DBBACKUP_API_KEY
+func newApplyClient(server, keyFile string) (*apply.Client, error) {
+	server, apiKey, err := serverEndpoint(server, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return apply.NewClient(server, apiKey, nil), nil
+}
+
+// serverEndpoint returns the URL of server, the local server when empty,
+// and the API key in keyFile or DBBACKUP_API_KEY
+func serverEndpoint(server, keyFile string) (string, string, error) {
+	if server == "" {
+		cfg := GetConfig()
+		scheme := "http"
+		if cfg.Server.TLS.Enabled {
+			scheme = "https"
+		}
+		server = fmt.Sprintf("%s://localhost:%d", scheme, cfg.Server.Port)
+	}
+	apiKey := os.Getenv("DBBACKUP_API_KEY")
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read API key: %w", err)
+		}
+		apiKey = strings.TrimSpace(string(data))
+	}
+	return server, apiKey, nil
+}
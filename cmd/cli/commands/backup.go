@@ -2,15 +2,37 @@ package commands
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/sanskarpan/db-backup/internal/backup"
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/backup/runner"
 	"github.com/sanskarpan/db-backup/internal/config"
 	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/events"
+	"github.com/sanskarpan/db-backup/internal/heartbeat"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/metrics"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/notification"
 	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/sanskarpan/db-backup/internal/schemasnap"
+	"github.com/sanskarpan/db-backup/internal/security/cryptopolicy"
+	"github.com/sanskarpan/db-backup/internal/storage/multipart"
+	s3storage "github.com/sanskarpan/db-backup/internal/storage/s3"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	"github.com/sanskarpan/db-backup/internal/vss"
+	"github.com/sanskarpan/db-backup/pkg/redact"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // BackupOptions holds options for the backup command
@@ -34,18 +56,25 @@ type BackupOptions struct {
 	CompressionLevel int
 	Encrypt          bool
 	EncryptionKey    string
+	VSS              string
+	Method           string
 
 	// Storage options
 	Storage     string
 	StoragePath string
 
 	// Metadata
-	Name string
-	Tags []string
+	Name     string
+	Tags     []string
+	Schedule string
 
 	// Flags
 	Notify bool
 	DryRun bool
+
+	// jobID is set on each backup of a job of several, which reports to
+	// the heartbeat monitor once for all of them
+	jobID string
 }
 
 // backupCmd represents the backup command
@@ -77,7 +106,63 @@ Examples:
 
   # Backup specific tables
   db-backup backup --type mysql --host localhost \\
-    --database mydb --tables users,orders,products`,
+    --database mydb --tables users,orders,products
+
+  # Redis RDB snapshot of every logical database
+  db-backup backup --type redis --host cache-1 --password secret
+
+  # ClickHouse native BACKUP to the server's "backups" disk
+  db-backup backup --type clickhouse --host ch-1 --database events
+
+  # CockroachDB native BACKUP, written by the cluster's nodes themselves
+  db-backup backup --type cockroachdb --host crdb-1 --database bank
+
+  # MySQL hot physical backup of the whole server with Percona XtraBackup,
+  # run on the database host; restores prepare it in the restore_dir
+  # metadata for xtrabackup --copy-back
+  db-backup backup --type mysql --all-databases --method physical
+
+  # MariaDB hot physical backup of the whole server with mariabackup, run
+  # on the database host
+  db-backup backup --type mariadb --all-databases
+
+  # etcd snapshot, checked against the hash etcd appends to it
+  db-backup backup --type etcd --host etcd-0
+
+  # InfluxDB bucket, with an all-access API token as the password
+  db-backup backup --type influxdb --database telegraf --password "$INFLUX_TOKEN"
+
+  # Neo4j Enterprise online backup of every database, run on the Neo4j host
+  db-backup backup --type neo4j --all-databases --user neo4j
+
+  # Oracle Data Pump export of two tables of the HR schema, run on the
+  # database host (Data Pump workers follow backup.parallel_operations)
+  db-backup backup --type oracle --database hr --tables employees,jobs
+
+  # Couchbase bucket, backed up with cbbackupmgr into a repository kept
+  # on this host
+  db-backup backup --type couchbase --host cb-1 --database travel-sample
+
+  # TiDB cluster, backed up by BR from every TiKV node straight to the
+  # storage option, e.g. s3://backups/tidb
+  db-backup backup --type tidb --host tidb-1 --all-databases
+
+  # DynamoDB table in us-east-1, exported to S3 from its point-in-time
+  # recovery data by DynamoDB itself
+  db-backup backup --type dynamodb --host us-east-1 --database orders
+
+  # Backup with a connection profile's read-only backup login
+  db-backup backup --profile orders
+
+  # DuckDB file, exported to Parquet while the file stays readable
+  db-backup backup --type duckdb --database /srv/analytics/warehouse.duckdb
+
+  # SQLite on Windows, read from a Volume Shadow Copy while the app runs
+  db-backup backup --type sqlite --database C:\ProgramData\app\app.db --vss always
+
+  # Every SQLite file of an app sharding into one file per tenant, one
+  # backup each, tagged with the job_id they share
+  db-backup backup --type sqlite --database '/srv/app/tenants/*.db'`,
 	RunE: runBackup,
 }
 
@@ -85,12 +170,13 @@ func init() {
 	rootCmd.AddCommand(backupCmd)
 
 	// Database connection flags
-	backupCmd.Flags().StringP("type", "t", "", "database type (mysql|postgres|mongodb|sqlite)")
-	backupCmd.Flags().StringP("host", "h", "localhost", "database host")
+	backupCmd.Flags().StringP("type", "t", "", "database type (mysql|postgres|mongodb|sqlite|redis|clickhouse|cockroachdb|mariadb|etcd|influxdb|neo4j|oracle|duckdb|couchbase|tidb|dynamodb, or one served by a plugin)")
+	backupCmd.Flags().StringP("host", "h", "localhost", "database host or unix socket path")
 	backupCmd.Flags().IntP("port", "P", 0, "database port")
 	backupCmd.Flags().StringP("user", "u", "", "database user")
 	backupCmd.Flags().StringP("password", "p", "", "database password")
 	backupCmd.Flags().StringP("database", "d", "", "database name")
+	backupCmd.Flags().String("profile", "", "connection profile to back up with its backup credentials (flags override it)")
 
 	// Multiple databases
 	backupCmd.Flags().StringSlice("databases", nil, "multiple databases (comma-separated)")
@@ -104,7 +190,13 @@ func init() {
 
 	// Encryption flags
 	backupCmd.Flags().Bool("encrypt", false, "enable encryption")
-	backupCmd.Flags().String("encryption-key", "", "encryption key or key file path")
+	backupCmd.Flags().String("encryption-key", "", "encryption key file path, or the key itself as base64:<key> or hex:<key>")
+
+	// Backup method flags
+	backupCmd.Flags().String("method", "", "backup method (logical|physical); physical copies the data files of the whole server (mysql|mariadb|postgres)")
+
+	// Windows flags
+	backupCmd.Flags().String("vss", "", "read SQLite files from a Volume Shadow Copy (auto|always|never, overrides backup.vss)")
 
 	// Storage flags
 	backupCmd.Flags().String("storage", "", "storage provider (s3|gcs|azure|local)")
@@ -113,13 +205,11 @@ func init() {
 	// Metadata flags
 	backupCmd.Flags().String("name", "", "backup name (auto-generated if not provided)")
 	backupCmd.Flags().StringSlice("tags", nil, "tags for backup (key=value)")
+	backupCmd.Flags().String("schedule", "", "name of the schedule running this backup (used for notification routing)")
 
 	// Other flags
 	backupCmd.Flags().Bool("notify", false, "send notifications")
 	backupCmd.Flags().Bool("dry-run", false, "simulate backup without execution")
-
-	// Mark required flags
-	backupCmd.MarkFlagRequired("type")
 }
 
 func runBackup(cmd *cobra.Command, args []string) error {
@@ -147,6 +237,8 @@ func runBackup(cmd *cobra.Command, args []string) error {
 	// Encryption
 	opts.Encrypt, _ = cmd.Flags().GetBool("encrypt")
 	opts.EncryptionKey, _ = cmd.Flags().GetString("encryption-key")
+	opts.VSS, _ = cmd.Flags().GetString("vss")
+	opts.Method, _ = cmd.Flags().GetString("method")
 
 	// Storage
 	opts.Storage, _ = cmd.Flags().GetString("storage")
@@ -155,20 +247,46 @@ func runBackup(cmd *cobra.Command, args []string) error {
 	// Metadata
 	opts.Name, _ = cmd.Flags().GetString("name")
 	opts.Tags, _ = cmd.Flags().GetStringSlice("tags")
+	opts.Schedule, _ = cmd.Flags().GetString("schedule")
 
 	// Flags
 	opts.Notify, _ = cmd.Flags().GetBool("notify")
 	opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
 
+	// Connection profile, explicit flags take precedence
+	if profile, _ := cmd.Flags().GetString("profile"); profile != "" {
+		if err := applyConnectionProfile(cmd, opts, GetConfig(), profile); err != nil {
+			return err
+		}
+	}
+
+	// Settings configured for the database, explicit flags take precedence
+	defaults := applyDatabaseDefaults(cmd, opts, GetConfig())
+
 	// Validate options
 	if err := validateBackupOptions(opts); err != nil {
 		return err
 	}
 
+	// A directory or glob of SQLite files is backed up a file at a time
+	if opts.Type == "sqlite" && sqliteSet(opts.Database) {
+		return backupSQLiteFiles(context.Background(), opts, GetConfig(), func(fileOpts *BackupOptions) error {
+			return backupDatabase(fileOpts, defaults)
+		})
+	}
+	return backupDatabase(opts, defaults)
+}
+
+// backupDatabase backs up the database of opts and records it in the
+// catalog
+func backupDatabase(opts *BackupOptions, defaults config.BackupDefaults) error {
 	// Get logger and config
 	log := GetLogger()
 	cfg := GetConfig()
 
+	// Credentials passed on the command line must never be logged
+	redact.AddSecrets(opts.Password, opts.EncryptionKey)
+
 	ctx := context.Background()
 
 	log.Info("Starting backup operation", map[string]interface{}{
@@ -184,22 +302,36 @@ func runBackup(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  Host: %s:%d\n", opts.Host, getPort(opts.Type, opts.Port))
 		fmt.Printf("  Database: %s\n", opts.Database)
 		fmt.Printf("  Compression: %s\n", getCompression(opts.Compression, cfg))
+		if opts.Method != "" {
+			fmt.Printf("  Method: %s\n", opts.Method)
+		}
 		if opts.Encrypt {
 			fmt.Printf("  Encryption: enabled\n")
 		}
+		if opts.Storage != "" {
+			fmt.Printf("  Storage: %s\n", opts.Storage)
+		}
 		log.Info("Dry run mode - no actual backup performed")
 		return nil
 	}
 
-	// Create backup engine
-	engineCfg := &backup.Config{
-		TempDirectory:      cfg.Backup.TempDirectory,
-		ParallelOperations: cfg.Backup.ParallelOperations,
-		DefaultCompression: cfg.Backup.DefaultCompression,
-		EnableEncryption:   opts.Encrypt,
-		EncryptionKey:      opts.EncryptionKey,
+	warnLowTempSpace(cfg, log)
+
+	// SQLite files are read from a shadow copy where possible, so the
+	// database and its journal are consistent with each other
+	source := opts.Database
+	if opts.Type == "sqlite" {
+		mode := opts.VSS
+		if mode == "" {
+			mode = cfg.Backup.VSS
+		}
+		path, cleanup, err := shadowSQLite(ctx, cfg, log, mode, opts.Database)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		source = path
 	}
-	engine := backup.NewEngine(engineCfg)
 
 	// Create repository
 	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
@@ -222,44 +354,183 @@ func runBackup(cmd *cobra.Command, args []string) error {
 	// Parse tags
 	tags := parseTags(opts.Tags)
 
-	// Create backup options
-	backupOpts := &backup.CreateOptions{
-		DatabaseType:     dbType,
-		Host:             opts.Host,
-		Port:             port,
-		Username:         opts.User,
-		Password:         opts.Password,
-		Database:         opts.Database,
-		Databases:        opts.Databases,
-		AllDatabases:     opts.AllDatabases,
-		Tables:           opts.Tables,
-		ExcludeTables:    opts.ExcludeTables,
-		Compression:      compression,
-		CompressionLevel: opts.CompressionLevel,
-		Encrypt:          opts.Encrypt,
-		EncryptionKey:    opts.EncryptionKey,
+	// Refuse ciphers the crypto policy does not allow and record the policy
+	// the backup was taken under
+	if opts.Encrypt {
+		if err := cryptopolicy.CheckCipher(cfg.Security.Crypto.Policy, cfg.Backup.Encryption.Algorithm); err != nil {
+			return fmt.Errorf("backup.encryption.algorithm: %w", err)
+		}
+	}
+	for k, v := range cryptopolicy.Tags(cfg.Security.Crypto.Policy, opts.Encrypt, cfg.Backup.Encryption.Algorithm) {
+		tags[k] = v
+	}
+
+	// Refuse the backup once a storage quota it counts against is reached
+	if err := checkQuota(ctx, cfg, log, repo.List, opts.Database, string(dbType), tags); err != nil {
+		return err
+	}
+
+	// Schema-only snapshot, taken before the data and stored with the
+	// backup once it is saved
+	schemaDDL := captureSchema(ctx, cfg, log, opts, dbType, port, source)
+	if schemaDDL != nil {
+		tags[schemasnap.TagChecksum] = schemasnap.Checksum(schemaDDL)
+	}
+
+	// The backup job, streamed through the stages of the pipeline
+	job := &runner.Job{
+		Connection: &database.ConnectionConfig{
+			Type:     dbType,
+			Host:     opts.Host,
+			Port:     port,
+			Username: opts.User,
+			Password: opts.Password,
+			Database: source,
+		},
+		Options: database.BackupOptions{
+			Database:         source,
+			Databases:        opts.Databases,
+			AllDatabases:     opts.AllDatabases,
+			Tables:           opts.Tables,
+			ExcludeTables:    opts.ExcludeTables,
+			ConsistentBackup: true,
+			Physical:         opts.Method == "physical",
+			Compression:      database.CompressionNone, // compressed by the pipeline
+			Parallel:         cfg.Backup.ParallelOperations,
+		},
 		Name:             opts.Name,
 		Tags:             tags,
-		ProgressCallback: func(progress backup.Progress) {
-			fmt.Printf("\r[%s] %.1f%% - %s", progress.Stage, progress.Percentage, progress.Message)
+		Compression:      string(compression),
+		CompressionLevel: opts.CompressionLevel,
+		Cipher:           cfg.Backup.Encryption.Algorithm,
+	}
+	if opts.Encrypt {
+		if opts.EncryptionKey == "" {
+			return fmt.Errorf("encryption is enabled but no encryption key is configured")
+		}
+		if job.EncryptionKey, err = readEncryptionKey(ctx, cfg, opts.EncryptionKey); err != nil {
+			return fmt.Errorf("failed to read encryption key: %w", err)
+		}
+		// Restores read the key from where it was kept at backup time
+		job.Tags[tagEncryptionCipher] = job.Cipher
+		if job.Cipher == "" {
+			job.Tags[tagEncryptionCipher] = stream.CipherAES256GCM
+		}
+		if source := encryptionKeySource(opts.EncryptionKey); source != "" {
+			job.Tags[tagEncryptionKeySource] = source
+		}
+	}
+
+	// OpenTelemetry export, with the database as a resource attribute
+	tel, shutdownTelemetry := setupTelemetry(ctx, cfg, log,
+		attribute.String("db.name", opts.Database),
+		attribute.String("db.system", opts.Type),
+	)
+	defer shutdownTelemetry()
+	ctx, span := tel.Tracer().Start(ctx, "backup")
+	defer span.End()
+	log = log.WithContext(telemetry.SpanFields(ctx)).WithOutput(tel.LogWriter())
+
+	// One child span per pipeline stage, ended before the root span
+	stages := telemetry.NewStages(ctx)
+	defer stages.End()
+
+	// The backup is recorded in the catalog by the index stage, under the
+	// database file rather than the shadow copy read
+	var metadata *models.BackupMetadata
+	upload, err := storageUploader(ctx, cfg, log, opts.Storage)
+	if err != nil {
+		return fmt.Errorf("storage %s: %w", opts.Storage, err)
+	}
+	r, err := runner.New(runner.Options{
+		TempDirectory: cfg.Backup.TempDirectory,
+		Pipeline:      cfg.Backup.Pipeline,
+		Upload:        upload,
+		KeyPrefix:     storageKeyPrefix(opts),
+		AutoTune:      cfg.Backup.AutoTune,
+		OnStage: func(_ *runner.Job, stage string) {
+			stages.Enter(stage)
+			fmt.Printf("\r[%s] %s", stage, opts.Database)
+		},
+		Index: func(ctx context.Context, b *runner.Backup) error {
+			metadata = backupMetadata(b, opts.Storage)
+			metadata.Database = opts.Database
+			if err := repo.Save(ctx, metadata); err != nil {
+				return fmt.Errorf("failed to save metadata: %w", err)
+			}
+			return nil
 		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Event bus
+	bus := newEventBus(ctx, cfg, log)
+	defer bus.Close()
+
+	publishEvent(ctx, bus, log, events.New(events.TypeBackupStarted, opts.Database, map[string]interface{}{
+		"database_type": opts.Type,
+		"host":          opts.Host,
+	}))
+
+	// Dead-man's-switch monitor for this schedule, pinged by the job when
+	// the backup is one of several
+	var heartbeatRun *heartbeat.Run
+	if opts.jobID == "" {
+		heartbeatRun, err = heartbeat.New(cfg.Heartbeats).Begin(ctx, opts.Schedule)
+		logHeartbeatError(log, err)
 	}
 
 	// Create backup
 	fmt.Println("Creating backup...")
 	startTime := time.Now()
 
-	metadata, err := engine.CreateBackup(ctx, backupOpts)
+	b, err := r.Backup(ctx, job)
+	stages.End()
+	collectAbandonedUploads(ctx, cfg, log, upload, storageKeyPrefix(opts))
 	if err != nil {
 		log.Error("Backup failed", err)
+		// Driver errors can echo connection strings; scrub before they leave the process
+		errMsg := redact.String(err.Error())
+		logHeartbeatError(log, heartbeatRun.Failure(ctx, errMsg))
+		publishEvent(ctx, bus, log, events.New(events.TypeBackupFailed, opts.Database, map[string]interface{}{
+			"database_type": opts.Type,
+			"host":          opts.Host,
+			"error":         errMsg,
+			"duration":      time.Since(startTime).Seconds(),
+		}))
+		result := metrics.BackupResult{
+			Database: opts.Database,
+			Type:     opts.Type,
+			Duration: time.Since(startTime),
+		}
+		pushBackupMetrics(ctx, cfg, log, result)
+		tel.RecordBackup(ctx, result)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, errMsg)
+		recordAudit(cfg, log, backupActor(opts), audit.ActionBackupFailed, opts.Database, map[string]string{
+			"database_type": opts.Type,
+			"host":          opts.Host,
+			"error":         errMsg,
+		})
+		if opts.Notify {
+			sendNotification(ctx, cfg, log, &notification.Notification{
+				Event:        notification.EventFailure,
+				Title:        fmt.Sprintf("Backup of %s failed", opts.Database),
+				Message:      errMsg,
+				Database:     opts.Database,
+				DatabaseType: opts.Type,
+				Schedule:     opts.Schedule,
+				Tags:         tags,
+				Timestamp:    time.Now(),
+			})
+		}
 		return fmt.Errorf("backup failed: %w", err)
 	}
 
-	// Save metadata to repository
-	if err := repo.Save(ctx, metadata); err != nil {
-		log.Error("Failed to save metadata", err)
-		return fmt.Errorf("failed to save metadata: %w", err)
-	}
+	recordCatalogEntry(cfg, log, metadata.ID)
+	saveSchema(cfg, log, dbType, metadata.Database, metadata.ID, schemaDDL)
 
 	duration := time.Since(startTime)
 
@@ -274,9 +545,12 @@ func runBackup(cmd *cobra.Command, args []string) error {
 		ratio := float64(metadata.Size-metadata.CompressedSize) / float64(metadata.Size) * 100
 		fmt.Printf("  Compressed Size: %s (%.1f%% reduction)\n", formatBytes(metadata.CompressedSize), ratio)
 	}
-	fmt.Printf("  Tables:          %d\n", len(metadata.Tables))
+	fmt.Printf("  Tables:          %d\n", len(b.Tables))
 	fmt.Printf("  Duration:        %s\n", duration.Round(time.Second))
 	fmt.Printf("  Location:        %s\n", metadata.BackupPath)
+	if b.StorageKey != "" {
+		fmt.Printf("  Uploaded to:     %s %s\n", metadata.StorageType, b.StorageKey)
+	}
 	fmt.Printf("  Checksum:        %s\n", metadata.Checksum[:16]+"...")
 
 	log.Info("Backup completed", map[string]interface{}{
@@ -285,19 +559,218 @@ func runBackup(cmd *cobra.Command, args []string) error {
 		"duration":  duration.Seconds(),
 	})
 
+	logHeartbeatError(log, heartbeatRun.Success(ctx, fmt.Sprintf("backup %s of %s completed (%s)",
+		metadata.ID, metadata.Database, formatBytes(metadata.Size))))
+
+	publishEvent(ctx, bus, log, events.New(events.TypeBackupCompleted, metadata.Database, map[string]interface{}{
+		"backup_id":       metadata.ID,
+		"database_type":   opts.Type,
+		"size":            metadata.Size,
+		"compressed_size": metadata.CompressedSize,
+		"checksum":        metadata.Checksum,
+		"location":        metadata.BackupPath,
+		"duration":        duration.Seconds(),
+	}))
+
+	result := metrics.BackupResult{
+		Database:       metadata.Database,
+		Type:           opts.Type,
+		Success:        true,
+		Duration:       duration,
+		Size:           metadata.Size,
+		CompressedSize: metadata.CompressedSize,
+	}
+	pushBackupMetrics(ctx, cfg, log, result)
+	tel.RecordBackup(ctx, result)
+	recordRecoveryPoint(cfg, log, metadata.Database, startTime)
+	recordAudit(cfg, log, backupActor(opts), audit.ActionBackupCreated, metadata.ID, map[string]string{
+		"database": metadata.Database,
+		"size":     fmt.Sprint(metadata.Size),
+		"checksum": metadata.Checksum,
+		"location": metadata.BackupPath,
+	})
+	checkBackupBaseline(ctx, cfg, log, metadata.Database, metadata.ID, metadata.BackupPath,
+		metadata.Size, metadata.CompressedSize, startTime)
+	scanNewBackup(ctx, cfg, log, metadata.ID, metadata.Database, metadata.BackupPath)
+	refreshRunbook(ctx, cfg, log, metadata.Database)
+	span.SetAttributes(
+		attribute.String("backup.id", metadata.ID),
+		attribute.Int64("backup.size", metadata.Size),
+	)
+
+	if opts.Notify {
+		n := &notification.Notification{
+			Event:        notification.EventSuccess,
+			Title:        fmt.Sprintf("Backup of %s completed", metadata.Database),
+			Message:      fmt.Sprintf("%s in %s", formatBytes(metadata.Size), duration.Round(time.Second)),
+			Database:     metadata.Database,
+			DatabaseType: opts.Type,
+			BackupID:     metadata.ID,
+			Size:         metadata.Size,
+			Schedule:     opts.Schedule,
+			Tags:         tags,
+			Timestamp:    time.Now(),
+		}
+		if days := defaults.Retention.Daily; days > 0 {
+			expiresAt := n.Timestamp.AddDate(0, 0, days)
+			n.ExpiresAt = &expiresAt
+		}
+		// Only embed the manifest when email is set up to attach it, so
+		// queued deliveries stay small otherwise
+		if utils.Contains(cfg.Notifications.Email.Attach, notification.AttachmentManifest) {
+			if manifest, err := json.MarshalIndent(metadata, "", "  "); err == nil {
+				n.Attachments = append(n.Attachments, notification.Attachment{
+					Kind:        notification.AttachmentManifest,
+					Filename:    metadata.ID + "-manifest.json",
+					ContentType: "application/json",
+					Data:        manifest,
+				})
+			}
+		}
+		sendNotification(ctx, cfg, log, n)
+	}
+
 	return nil
 }
 
+// backupMetadata returns the catalog entry of a backup taken by the
+// runner, uploaded to storage unless its storage key is empty
+func backupMetadata(b *runner.Backup, storage string) *models.BackupMetadata {
+	storageType := "local"
+	if b.StorageKey != "" {
+		storageType = storage
+		b.Tags[tagStorageKey] = b.StorageKey
+	}
+	return &models.BackupMetadata{
+		ID:             b.ID,
+		Name:           b.Name,
+		Database:       b.Database,
+		DatabaseType:   b.DatabaseType,
+		StorageType:    storageType,
+		BackupPath:     b.Path,
+		Size:           b.Size,
+		CompressedSize: b.CompressedSize,
+		Checksum:       b.Checksum,
+		StartTime:      b.StartTime,
+		EndTime:        b.EndTime,
+		Status:         models.BackupStatusCompleted,
+		Tags:           b.Tags,
+	}
+}
+
+// storageUploader returns the uploader streaming artifacts to storage
+// provider name as they are written, or nil when they are only kept in
+// the temp directory
+func storageUploader(ctx context.Context, cfg *config.Config, log *logger.Logger, name string) (*multipart.Uploader, error) {
+	var provider multipart.Provider
+	switch name {
+	case "", "local":
+		return nil, nil
+	case "s3":
+		p, err := s3storage.New(ctx, cfg.Storage.Providers.S3)
+		if err != nil {
+			return nil, err
+		}
+		provider = p
+	default:
+		log.Warn("Storage provider does not take multipart uploads, keeping the artifact in the temp directory", map[string]interface{}{
+			"storage": name,
+		})
+		return nil, nil
+	}
+	journal, err := multipart.NewJournal(cfg.Storage.Upload.StateDirectory)
+	if err != nil {
+		return nil, err
+	}
+	return multipart.NewUploader(provider, journal, cfg.Storage.Upload)
+}
+
+// collectAbandonedUploads aborts the uploads under prefix that made no
+// progress in storage.upload.abandon_after, e.g. those of a backup that
+// crashed, so the provider stops billing for their parts. Failures are
+// logged: the next backup tries again.
+func collectAbandonedUploads(ctx context.Context, cfg *config.Config, log *logger.Logger, upload *multipart.Uploader, prefix string) {
+	if upload == nil || cfg.Storage.Upload.AbandonAfter <= 0 {
+		return
+	}
+	res, err := upload.CollectAbandoned(ctx, prefix, cfg.Storage.Upload.AbandonAfter)
+	if err != nil {
+		log.Warn("Failed to clean up abandoned uploads", map[string]interface{}{
+			"prefix": prefix,
+			"error":  err.Error(),
+		})
+		return
+	}
+	if len(res.Aborted) > 0 || len(res.Forgotten) > 0 {
+		log.Info("Cleaned up abandoned uploads", map[string]interface{}{
+			"prefix":    prefix,
+			"aborted":   len(res.Aborted),
+			"forgotten": len(res.Forgotten),
+		})
+	}
+}
+
+// storageKeyPrefix returns the prefix of the storage keys of the artifacts
+// of opts: --storage-path, or else the database, or its type when every
+// database is backed up
+func storageKeyPrefix(opts *BackupOptions) string {
+	prefix := opts.StoragePath
+	if prefix == "" && opts.Database != "" {
+		prefix = filepath.Base(opts.Database)
+	}
+	if prefix == "" {
+		prefix = opts.Type
+	}
+	return strings.Trim(prefix, "/") + "/"
+}
+
+// backupActor names who started a backup for the audit log
+func backupActor(opts *BackupOptions) string {
+	if opts.Schedule != "" {
+		return "schedule:" + opts.Schedule
+	}
+	return cliActor()
+}
+
 func validateBackupOptions(opts *BackupOptions) error {
 	// Validate database type
 	validTypes := map[string]bool{
-		"mysql":    true,
-		"postgres": true,
-		"mongodb":  true,
-		"sqlite":   true,
+		"mysql":       true,
+		"postgres":    true,
+		"mongodb":     true,
+		"sqlite":      true,
+		"redis":       true,
+		"clickhouse":  true,
+		"cockroachdb": true,
+		"mariadb":     true,
+		"etcd":        true,
+		"influxdb":    true,
+		"neo4j":       true,
+		"oracle":      true,
+		"duckdb":      true,
+		"couchbase":   true,
+		"tidb":        true,
+		"dynamodb":    true,
+	}
+	if opts.Type == "" {
+		return fmt.Errorf("database type is required (--type or --profile)")
 	}
-	if !validTypes[opts.Type] {
-		return fmt.Errorf("invalid database type: %s (must be mysql|postgres|mongodb|sqlite)", opts.Type)
+	if !validTypes[opts.Type] && !database.IsRegistered(database.DatabaseType(opts.Type)) {
+		return fmt.Errorf("invalid database type: %s (must be mysql|postgres|mongodb|sqlite|redis|clickhouse|cockroachdb|mariadb|etcd|influxdb|neo4j|oracle|duckdb|couchbase|tidb|dynamodb or a plugin's type)", opts.Type)
+	}
+
+	// Validate the backup method
+	switch opts.Method {
+	case "", "logical":
+	case "physical":
+		if opts.Type != "mysql" && opts.Type != "mariadb" && opts.Type != "postgres" {
+			return fmt.Errorf("physical backups are not supported for %s (mysql|mariadb|postgres)", opts.Type)
+		}
+		if len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0 {
+			return fmt.Errorf("physical backups are of the whole server, --tables and --exclude-tables cannot be used")
+		}
+	default:
+		return fmt.Errorf("invalid --method: %s (must be logical|physical)", opts.Method)
 	}
 
 	// For SQLite, database is a file path
@@ -305,11 +778,25 @@ func validateBackupOptions(opts *BackupOptions) error {
 		if opts.Database == "" {
 			return fmt.Errorf("database file path is required for SQLite")
 		}
+		switch opts.VSS {
+		case "", vss.ModeAuto, vss.ModeAlways, vss.ModeNever:
+		default:
+			return fmt.Errorf("invalid --vss mode: %s (must be auto|always|never)", opts.VSS)
+		}
+		return nil
+	}
+
+	// For DuckDB too, snapshotted with the database's own EXPORT DATABASE
+	if opts.Type == "duckdb" {
+		if opts.Database == "" {
+			return fmt.Errorf("database file path is required for DuckDB")
+		}
 		return nil
 	}
 
-	// Validate database connection options
-	if !opts.AllDatabases && opts.Database == "" && len(opts.Databases) == 0 {
+	// Validate database connection options; Redis snapshots always hold
+	// every logical database
+	if opts.Type != "redis" && !opts.AllDatabases && opts.Database == "" && len(opts.Databases) == 0 {
 		return fmt.Errorf("database name is required (or use --all-databases)")
 	}
 
@@ -351,7 +838,35 @@ func parseDatabaseType(typeStr string) (database.DatabaseType, error) {
 		return database.DatabaseTypeMongoDB, nil
 	case "sqlite":
 		return database.DatabaseTypeSQLite, nil
+	case "redis":
+		return database.DatabaseTypeRedis, nil
+	case "clickhouse":
+		return database.DatabaseTypeClickHouse, nil
+	case "cockroachdb", "cockroach":
+		return database.DatabaseTypeCockroachDB, nil
+	case "mariadb":
+		return database.DatabaseTypeMariaDB, nil
+	case "etcd":
+		return database.DatabaseTypeEtcd, nil
+	case "influxdb":
+		return database.DatabaseTypeInfluxDB, nil
+	case "neo4j":
+		return database.DatabaseTypeNeo4j, nil
+	case "oracle":
+		return database.DatabaseTypeOracle, nil
+	case "duckdb":
+		return database.DatabaseTypeDuckDB, nil
+	case "couchbase":
+		return database.DatabaseTypeCouchbase, nil
+	case "tidb":
+		return database.DatabaseTypeTiDB, nil
+	case "dynamodb":
+		return database.DatabaseTypeDynamoDB, nil
 	default:
+		// Types served by plugins
+		if database.IsRegistered(database.DatabaseType(typeStr)) {
+			return database.DatabaseType(typeStr), nil
+		}
 		return "", fmt.Errorf("unsupported database type: %s", typeStr)
 	}
 }
@@ -378,17 +893,57 @@ func getPort(dbType string, port int) int {
 
 	// Return default ports
 	switch strings.ToLower(dbType) {
-	case "mysql":
+	case "mysql", "mariadb":
 		return 3306
 	case "postgres", "postgresql":
 		return 5432
 	case "mongodb", "mongo":
 		return 27017
+	case "redis":
+		return 6379
+	case "clickhouse":
+		return 8123
+	case "cockroachdb", "cockroach":
+		return 26257
+	case "etcd":
+		return 2379
+	case "influxdb":
+		return 8086
+	case "neo4j":
+		return 7474
+	case "oracle":
+		return 1521
+	case "couchbase":
+		return 8091
+	case "tidb":
+		return 4000
 	default:
 		return 0
 	}
 }
 
+// applyDatabaseDefaults fills the options not given as flags from the
+// backup settings configured for the database, and returns those settings
+func applyDatabaseDefaults(cmd *cobra.Command, opts *BackupOptions, cfg *config.Config) config.BackupDefaults {
+	d := cfg.BackupDefaults(opts.Database, opts.Type, parseTags(opts.Tags))
+	if !cmd.Flags().Changed("compression") {
+		opts.Compression = d.Compression
+	}
+	if !cmd.Flags().Changed("compress-level") {
+		opts.CompressionLevel = d.CompressionLevel
+	}
+	if !cmd.Flags().Changed("encrypt") {
+		opts.Encrypt = d.Encrypt
+	}
+	if opts.Encrypt && !cmd.Flags().Changed("encryption-key") {
+		opts.EncryptionKey = configuredKey(cfg, d.EncryptionKeyFile)
+	}
+	if !cmd.Flags().Changed("storage") {
+		opts.Storage = d.Storage
+	}
+	return d
+}
+
 func getCompression(compression string, cfg *config.Config) string {
 	if compression != "" {
 		return compression
@@ -422,3 +977,147 @@ func formatBytes(bytes int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
+
+// sqliteHeader starts every SQLite database file
+const sqliteHeader = "SQLite format 3\x00"
+
+// sqliteSet reports whether the SQLite database given is a set of files:
+// a directory or a glob pattern
+func sqliteSet(db string) bool {
+	if strings.ContainsAny(db, "*?[") {
+		return true
+	}
+	info, err := os.Stat(db)
+	return err == nil && info.IsDir()
+}
+
+// sqliteFiles returns the SQLite databases of a directory or glob
+// pattern, in order. Only files starting with the SQLite header are
+// returned, so journals and other files next to the databases are left
+// out; their journals are read with them.
+func sqliteFiles(db string) ([]string, error) {
+	pattern := db
+	if !strings.ContainsAny(db, "*?[") {
+		pattern = filepath.Join(db, "*")
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database pattern %s: %w", db, err)
+	}
+	var files []string
+	for _, path := range matches {
+		if ok, err := isSQLiteFile(path); err != nil {
+			return nil, err
+		} else if ok {
+			files = append(files, path)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no SQLite database files in %s", db)
+	}
+	return files, nil
+}
+
+// isSQLiteFile reports whether path is a regular file starting with the
+// SQLite header
+func isSQLiteFile(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	header := make([]byte, len(sqliteHeader))
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false, nil
+	}
+	return string(header) == sqliteHeader, nil
+}
+
+// backupSQLiteFiles backs up each SQLite database of the directory or
+// glob pattern of opts with backupFile, a file at a time so each is
+// consistent on its own. Every file gets its own catalog entry, tagged
+// with a job_id shared by the job; a failed file does not stop the
+// others. The heartbeat monitor sees the job as one run.
+func backupSQLiteFiles(ctx context.Context, opts *BackupOptions, cfg *config.Config, backupFile func(*BackupOptions) error) error {
+	files, err := sqliteFiles(opts.Database)
+	if err != nil {
+		return err
+	}
+	log := GetLogger()
+	jobID := utils.GenerateBackupID()
+	log.Info("Backing up SQLite database files", map[string]interface{}{
+		"pattern": opts.Database,
+		"files":   len(files),
+		"job_id":  jobID,
+	})
+
+	var heartbeatRun *heartbeat.Run
+	if !opts.DryRun {
+		heartbeatRun, err = heartbeat.New(cfg.Heartbeats).Begin(ctx, opts.Schedule)
+		logHeartbeatError(log, err)
+	}
+
+	var failed []string
+	for i, file := range files {
+		fmt.Printf("\n[%d/%d] %s\n", i+1, len(files), file)
+		fileOpts := *opts
+		fileOpts.Database = file
+		fileOpts.Tags = append(append([]string{}, opts.Tags...), "job_id="+jobID)
+		fileOpts.jobID = jobID
+		if err := backupFile(&fileOpts); err != nil {
+			fmt.Printf("✗ %s: %v\n", file, err)
+			failed = append(failed, file)
+		}
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("backup of %d of %d SQLite files failed (job %s): %s", len(failed), len(files), jobID, strings.Join(failed, ", "))
+		logHeartbeatError(log, heartbeatRun.Failure(ctx, err.Error()))
+		return err
+	}
+	logHeartbeatError(log, heartbeatRun.Success(ctx, fmt.Sprintf("backup of %d SQLite files completed (job %s)", len(files), jobID)))
+	fmt.Printf("\n✓ Backed up %d SQLite files (job %s)\n", len(files), jobID)
+	return nil
+}
+
+// shadowSQLite copies a SQLite database and its journals from a Volume
+// Shadow Copy into the temp directory and returns the copy to back up,
+// with a function removing it. In auto mode the live file is backed up
+// when no shadow copy can be made, e.g. off Windows or without elevation.
+func shadowSQLite(ctx context.Context, cfg *config.Config, log *logger.Logger, mode, db string) (string, func(), error) {
+	noop := func() {}
+	if mode == vss.ModeNever || (mode == vss.ModeAuto && !vss.Available()) {
+		return db, noop, nil
+	}
+	if !vss.Available() {
+		return "", noop, vss.ErrUnsupported
+	}
+
+	abs, err := filepath.Abs(db)
+	if err != nil {
+		return "", noop, err
+	}
+	dir, err := os.MkdirTemp(cfg.Backup.TempDirectory, "vss-")
+	if err != nil {
+		return "", noop, fmt.Errorf("failed to create shadow copy directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	copies, err := vss.NewClient().Copy(ctx, dir, vss.SQLiteFiles(abs)...)
+	if err != nil {
+		cleanup()
+		if mode == vss.ModeAlways {
+			return "", noop, err
+		}
+		log.Warn("No shadow copy of the SQLite database, backing up the live file", map[string]interface{}{
+			"database": db,
+			"error":    err.Error(),
+		})
+		return db, noop, nil
+	}
+	log.Info("Reading SQLite database from a shadow copy", map[string]interface{}{"database": db})
+	return copies[0], cleanup, nil
+}
@@ -0,0 +1,318 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/heartbeat"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/restorepoint"
+)
+
+// sqliteDir creates a directory of SQLite files next to their journals
+// and other files, which are not databases
+func sqliteDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.db":     sqliteHeader + "page data",
+		"b.sqlite": sqliteHeader,
+		"a.db-wal": "wal frames",
+		"short":    "SQLite",
+		"notes.db": "not a database",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "tenants.db"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestIsSQLiteFile(t *testing.T) {
+	dir := sqliteDir(t)
+	tests := map[string]bool{
+		"a.db":       true,
+		"b.sqlite":   true,
+		"a.db-wal":   false,
+		"short":      false,
+		"notes.db":   false,
+		"tenants.db": false,
+	}
+	for name, want := range tests {
+		got, err := isSQLiteFile(filepath.Join(dir, name))
+		if err != nil || got != want {
+			t.Errorf("%s: %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := isSQLiteFile(filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+func TestSQLiteSet(t *testing.T) {
+	dir := sqliteDir(t)
+	tests := map[string]bool{
+		dir:                                true,
+		filepath.Join(dir, "*.db"):         true,
+		filepath.Join(dir, "[ab].db"):      true,
+		filepath.Join(dir, "a.db"):         false,
+		filepath.Join(dir, "missing.db"):   false,
+		filepath.Join(dir, "tenants.db"):   true,
+		filepath.Join(dir, "tenant-?.db"):  true,
+		filepath.Join(dir, "missing", "x"): false,
+	}
+	for db, want := range tests {
+		if got := sqliteSet(db); got != want {
+			t.Errorf("sqliteSet(%s) = %v, want %v", db, got, want)
+		}
+	}
+}
+
+func TestSQLiteFiles(t *testing.T) {
+	dir := sqliteDir(t)
+	tests := map[string][]string{
+		dir:                        {"a.db", "b.sqlite"},
+		filepath.Join(dir, "*.db"): {"a.db"},
+		filepath.Join(dir, "?.*"):  {"a.db", "b.sqlite"},
+	}
+	for db, names := range tests {
+		got, err := sqliteFiles(db)
+		if err != nil {
+			t.Fatalf("%s: %v", db, err)
+		}
+		var want []string
+		for _, name := range names {
+			want = append(want, filepath.Join(dir, name))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: files %v, want %v", db, got, want)
+		}
+	}
+
+	for _, db := range []string{filepath.Join(dir, "*.txt"), filepath.Join(dir, "tenants.db"), filepath.Join(dir, "[")} {
+		if files, err := sqliteFiles(db); err == nil {
+			t.Errorf("%s: files %v, want an error", db, files)
+		}
+	}
+}
+
+func TestBackupSQLiteFiles(t *testing.T) {
+	var mu sync.Mutex
+	var pings []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		pings = append(pings, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{}
+	cfg.Heartbeats = config.HeartbeatConfig{
+		Enabled: true,
+		Checks:  map[string]config.HeartbeatCheck{heartbeat.DefaultCheck: {URL: srv.URL + "/check"}},
+	}
+	dir := sqliteDir(t)
+
+	tests := []struct {
+		name    string
+		fail    string
+		wantErr bool
+		pings   []string
+	}{
+		{"all files", "", false, []string{"/check/start", "/check"}},
+		{"one file fails", "a.db", true, []string{"/check/start", "/check/fail"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pings = nil
+			var backedUp []string
+			jobIDs := map[string]bool{}
+			opts := &BackupOptions{Type: "sqlite", Database: dir, Tags: []string{"env=prod"}}
+			err := backupSQLiteFiles(context.Background(), opts, cfg, func(fileOpts *BackupOptions) error {
+				backedUp = append(backedUp, filepath.Base(fileOpts.Database))
+				jobIDs[fileOpts.jobID] = true
+				if len(fileOpts.Tags) != 2 || fileOpts.Tags[1] != "job_id="+fileOpts.jobID {
+					t.Errorf("tags %v", fileOpts.Tags)
+				}
+				if filepath.Base(fileOpts.Database) == tt.fail {
+					return errors.New("disk full")
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v", err)
+			}
+			if err != nil && !strings.Contains(err.Error(), filepath.Join(dir, tt.fail)) {
+				t.Errorf("error %v does not name the failed file", err)
+			}
+
+			// Every file is backed up, after a failed one too
+			if !reflect.DeepEqual(backedUp, []string{"a.db", "b.sqlite"}) {
+				t.Errorf("backed up %v", backedUp)
+			}
+			if len(jobIDs) != 1 || jobIDs[""] {
+				t.Errorf("job IDs %v, want one shared", jobIDs)
+			}
+			if len(opts.Tags) != 1 {
+				t.Errorf("caller's tags changed: %v", opts.Tags)
+			}
+			// The monitor sees one run, not one per file
+			if !reflect.DeepEqual(pings, tt.pings) {
+				t.Errorf("pings %v, want %v", pings, tt.pings)
+			}
+		})
+	}
+}
+
+func TestStorageKeyPrefix(t *testing.T) {
+	tests := map[string]*BackupOptions{
+		"orders/":       {Type: "postgres", Database: "orders"},
+		"app.db/":       {Type: "sqlite", Database: "/srv/app/app.db"},
+		"mysql/":        {Type: "mysql", AllDatabases: true},
+		"prod/backups/": {Type: "postgres", Database: "orders", StoragePath: "/prod/backups/"},
+	}
+	for want, opts := range tests {
+		if got := storageKeyPrefix(opts); got != want {
+			t.Errorf("storageKeyPrefix(%+v) = %q, want %q", opts, got, want)
+		}
+	}
+}
+
+func TestArtifactKey(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, newKey := strings.Repeat("o", 32), strings.Repeat("n", 32)
+	os.WriteFile(filepath.Join(dir, "old.key"), []byte(oldKey+"\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "new.key"), []byte(newKey+"\n"), 0600)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/db-backup/2026-09" || r.Header.Get("X-Vault-Token") != "s.token" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"data":{"key":"%s"}}}`, strings.Repeat("v", 32))
+	}))
+	defer vault.Close()
+
+	cfg := &config.Config{}
+	cfg.Backup.Encryption.KeyFile = filepath.Join(dir, "new.key")
+	cfg.Backup.Encryption.Vault = config.VaultConfig{Address: vault.URL, Token: "s.token", KeyPrefix: "db-backup/", CurrentKey: "2026-10"}
+	ctx := context.Background()
+
+	// The key recorded at backup time wins over the one configured since
+	for _, tc := range []struct {
+		source, flag, want string
+	}{
+		{filepath.Join(dir, "old.key"), "", oldKey},
+		{"", "", newKey},
+		{vaultKeyPrefix + "2026-09", "", strings.Repeat("v", 32)},
+		{filepath.Join(dir, "old.key"), "hex:" + strings.Repeat("66", 32), strings.Repeat("f", 32)},
+	} {
+		b := &models.BackupMetadata{ID: "b1", Tags: map[string]string{}}
+		if tc.source != "" {
+			b.Tags[tagEncryptionKeySource] = tc.source
+		}
+		key, err := artifactKey(ctx, cfg, b, tc.flag)()
+		if err != nil || string(key) != tc.want {
+			t.Errorf("source %q, flag %q: key %q, %v", tc.source, tc.flag, key, err)
+		}
+	}
+
+	if got := encryptionKeySource(filepath.Join(dir, "old.key")); got != filepath.Join(dir, "old.key") {
+		t.Errorf("source of a key file = %q", got)
+	}
+	if got := encryptionKeySource("hex:" + strings.Repeat("66", 32)); got != "" {
+		t.Errorf("source of a key = %q", got)
+	}
+	// A key file that is not there fails rather than becoming the key
+	b := &models.BackupMetadata{ID: "b1", Tags: map[string]string{tagEncryptionKeySource: filepath.Join(dir, "moved-away-after-the-backup.key")}}
+	if key, err := artifactKey(ctx, cfg, b, "")(); err == nil {
+		t.Errorf("missing key file read as %q", key)
+	}
+	cfg.Backup.Encryption.KeyFile, cfg.Backup.Encryption.KeyStore = "", "vault"
+	if got := configuredKey(cfg, ""); got != vaultKeyPrefix+"2026-10" {
+		t.Errorf("configured key in Vault = %q", got)
+	}
+}
+
+func TestDecryptLegacyArtifact(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aead.NonceSize())
+	sealed := aead.Seal(nonce, nonce, []byte("legacy dump"), nil)
+	keyFunc := func() ([]byte, error) { return key, nil }
+
+	r, encrypted, err := decryptArtifact(bytes.NewReader(sealed), "/backups/b1.sql.gz.enc", keyFunc)
+	if err != nil || !encrypted {
+		t.Fatalf("decryptArtifact() encrypted %v, %v", encrypted, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "legacy dump" {
+		t.Errorf("read %q", got)
+	}
+	// Without the extension the artifact is taken as unencrypted
+	r, encrypted, err = decryptArtifact(bytes.NewReader([]byte("plain dump")), "/backups/b2.sql", keyFunc)
+	if got, _ := io.ReadAll(r); err != nil || encrypted || string(got) != "plain dump" {
+		t.Errorf("plain artifact: %q, encrypted %v, %v", got, encrypted, err)
+	}
+}
+
+func TestRestoreCandidatesSkipQuarantined(t *testing.T) {
+	day := time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC)
+	backup := func(id string, at time.Time, base string) *models.BackupMetadata {
+		b := &models.BackupMetadata{ID: id, Database: "orders", Status: models.BackupStatusCompleted, EndTime: at, Tags: map[string]string{}}
+		if base != "" {
+			b.Tags[restorepoint.TagType] = restorepoint.TypeIncremental
+			b.Tags[restorepoint.TagBase] = base
+		}
+		return b
+	}
+	backups := []*models.BackupMetadata{
+		backup("full-1", day, ""),
+		backup("full-2", day.Add(24*time.Hour), ""),
+		backup("incr-2a", day.Add(30*time.Hour), "full-2"),
+	}
+	quarantined := map[string]bool{"full-2": true}
+	asOf := day.Add(36 * time.Hour)
+
+	candidates, byID := restoreCandidates(backups, quarantined)
+	if _, ok := byID["full-2"]; ok {
+		t.Error("restoreCandidates() returned the quarantined backup")
+	}
+	plan, err := restorepoint.Select(candidates, "orders", asOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Backups) != 1 || plan.Backups[0].ID != "full-1" {
+		t.Errorf("plan = %+v, want full-1 alone", plan.Backups)
+	}
+	if err := checkNotQuarantined(plan, quarantined); err != nil {
+		t.Errorf("checkNotQuarantined() = %v", err)
+	}
+
+	all, _ := restoreCandidates(backups, nil)
+	plan, err = restorepoint.Select(all, "orders", asOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNotQuarantined(plan, quarantined); err == nil || !strings.Contains(err.Error(), "full-2") {
+		t.Errorf("checkNotQuarantined() = %v, want the quarantined full-2 refused", err)
+	}
+}
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/bench"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/pkg/redact"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// benchCmd measures backup throughput on this machine
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure dump, compression, encryption and upload speed",
+	Long: `Measure each stage of a backup against the real environment and recommend
+a codec and pipeline sizing.
+
+With --profile, the profile's database is dumped for --duration and the
+dumped bytes are used as the compression sample; otherwise a synthetic
+SQL-like sample is used. Codecs and ciphers are measured on one core at
+backup.compression_level. Uploads are measured by writing parts to
+--upload-dir, or to the local storage path when it is the default provider,
+with the configured part size and concurrency; point --upload-dir at a
+mounted bucket or network share to measure it.
+
+Examples:
+  # Codec and cipher speed only
+  db-backup bench
+
+  # Full run against a profile and a network share
+  db-backup bench --profile orders --upload-dir /mnt/backups
+
+  # Machine-readable results
+  db-backup bench --profile orders --format json`,
+	RunE: runBench,
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+
+	benchCmd.Flags().String("profile", "", "connection profile to dump")
+	benchCmd.Flags().String("database", "", "database to dump (defaults to the profile's)")
+	benchCmd.Flags().Duration("duration", bench.DefaultDuration, "how long each measurement runs")
+	benchCmd.Flags().String("sample-size", "32MB", "bytes of data compressed and encrypted")
+	benchCmd.Flags().String("upload-dir", "", "directory to measure uploads against")
+	benchCmd.Flags().String("upload-size", "256MB", "bytes uploaded")
+	benchCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	profile, _ := cmd.Flags().GetString("profile")
+	dbName, _ := cmd.Flags().GetString("database")
+	duration, _ := cmd.Flags().GetDuration("duration")
+	sampleFlag, _ := cmd.Flags().GetString("sample-size")
+	uploadDir, _ := cmd.Flags().GetString("upload-dir")
+	uploadFlag, _ := cmd.Flags().GetString("upload-size")
+	format, _ := cmd.Flags().GetString("format")
+
+	cfg := GetConfig()
+	sampleSize, err := utils.ParseBytes(sampleFlag)
+	if err != nil || sampleSize <= 0 {
+		return fmt.Errorf("invalid --sample-size %q", sampleFlag)
+	}
+	uploadSize, err := utils.ParseBytes(uploadFlag)
+	if err != nil || uploadSize <= 0 {
+		return fmt.Errorf("invalid --upload-size %q", uploadFlag)
+	}
+	if uploadDir == "" && strings.EqualFold(cfg.Storage.DefaultProvider, "local") {
+		uploadDir = cfg.Storage.Providers.Local.Path
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report := &bench.Report{CPUs: runtime.NumCPU()}
+	var sample []byte
+	if profile != "" {
+		r, s, err := benchDump(ctx, cfg, profile, dbName, duration, int(sampleSize))
+		if err != nil {
+			return err
+		}
+		report.Dump, sample = &r, s
+	}
+	if len(sample) < int(sampleSize)/4 {
+		// Too little real data to judge the codecs on
+		sample = bench.SyntheticSample(int(sampleSize))
+	}
+	report.SampleSize = len(sample)
+
+	report.Compression = bench.Compression(ctx, duration, sample, cfg.Backup.CompressionLevel)
+	report.Encryption = bench.Encryption(ctx, duration, sample)
+
+	partSize, _ := utils.ParseBytes(cfg.Storage.Upload.PartSize)
+	concurrency := cfg.Storage.Upload.Concurrency
+	if uploadDir != "" {
+		r, err := benchUpload(ctx, uploadDir, sample, uploadSize, int(partSize), concurrency)
+		if err != nil {
+			return err
+		}
+		report.Upload = &r
+	}
+	rec := report.Recommend(concurrency)
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(struct {
+			*bench.Report
+			Recommendation bench.Recommendation `json:"recommendation"`
+		}{report, rec})
+	case "yaml", "yml":
+		return printYAMLValue(struct {
+			Report         *bench.Report        `yaml:"report"`
+			Recommendation bench.Recommendation `yaml:"recommendation"`
+		}{report, rec})
+	}
+	printBench(report, rec)
+	return nil
+}
+
+// benchDump dumps a profile's database for up to d
+func benchDump(ctx context.Context, cfg *config.Config, profile, dbName string, d time.Duration, sampleSize int) (bench.Result, []byte, error) {
+	conn, err := profileConnection(cfg, profile, config.PurposeBackup)
+	if err != nil {
+		return bench.Result{}, nil, err
+	}
+	redact.AddSecrets(conn.Password)
+	if dbName != "" {
+		conn.Database = dbName
+	}
+
+	driver, err := database.CreateDriver(conn.Type)
+	if err != nil {
+		return bench.Result{}, nil, err
+	}
+	if err := driver.Connect(ctx, conn); err != nil {
+		return bench.Result{}, nil, fmt.Errorf("backup login %s failed: %w", conn.Username, err)
+	}
+	defer driver.Disconnect()
+
+	r, sample := bench.Dump(ctx, d, sampleSize, func(ctx context.Context, w io.Writer) error {
+		return driver.StreamBackup(ctx, &database.BackupOptions{Database: conn.Database, ConsistentBackup: true}, w)
+	})
+	return r, sample, nil
+}
+
+// benchUpload writes parts as files under a scratch directory in dir,
+// synced to disk as a storage upload would be, and removes them
+func benchUpload(ctx context.Context, dir string, sample []byte, size int64, partSize, concurrency int) (bench.Result, error) {
+	scratch, err := os.MkdirTemp(dir, ".db-backup-bench-")
+	if err != nil {
+		return bench.Result{}, fmt.Errorf("cannot write to %s: %w", dir, err)
+	}
+	defer os.RemoveAll(scratch)
+
+	r := bench.Upload(ctx, sample, size, partSize, concurrency, func(ctx context.Context, p stream.Part) error {
+		f, err := os.Create(filepath.Join(scratch, fmt.Sprintf("part-%05d", p.Number)))
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(p.Data); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+	return r, nil
+}
+
+// printBench prints a report and its recommendation as tables
+func printBench(report *bench.Report, rec bench.Recommendation) {
+	rate := func(r bench.Result) string {
+		if r.Err != "" {
+			return "error: " + r.Err
+		}
+		return formatBytes(int64(r.Rate())) + "/s"
+	}
+
+	fmt.Printf("Sample: %s, %d CPUs\n\n", formatBytes(int64(report.SampleSize)), report.CPUs)
+	fmt.Printf("%-20s %-16s %s\n", "STAGE", "RATE", "DETAIL")
+	if report.Dump != nil {
+		fmt.Printf("%-20s %-16s %s in %s\n", "dump", rate(*report.Dump), formatBytes(report.Dump.Input), report.Dump.Duration.Round(time.Millisecond))
+	}
+	for _, r := range report.Compression {
+		fmt.Printf("%-20s %-16s %.2fx ratio, per core\n", "compress "+r.Name, rate(r), r.Ratio())
+	}
+	for _, r := range report.Encryption {
+		fmt.Printf("%-20s %-16s per core\n", "encrypt "+r.Name, rate(r))
+	}
+	if report.Upload != nil {
+		fmt.Printf("%-20s %-16s %s in %s\n", "upload", rate(*report.Upload), formatBytes(report.Upload.Input), report.Upload.Duration.Round(time.Millisecond))
+	} else {
+		fmt.Printf("%-20s %-16s %s\n", "upload", "-", "not measured (use --upload-dir)")
+	}
+
+	fmt.Println()
+	fmt.Println("Recommendation:")
+	fmt.Printf("  backup.default_compression:       %s\n", rec.Compression)
+	if rec.Encryption != "" {
+		fmt.Printf("  backup.encryption.algorithm:      %s\n", rec.Encryption)
+	}
+	fmt.Printf("  backup.pipeline.dump_workers:     %d\n", rec.DumpWorkers)
+	fmt.Printf("  backup.pipeline.compress_workers: %d\n", rec.CompressWorkers)
+	fmt.Printf("  backup.pipeline.encrypt_workers:  %d\n", rec.EncryptWorkers)
+	fmt.Printf("  storage.upload.concurrency:       %d\n", rec.UploadWorkers)
+	if rec.Rate > 0 {
+		fmt.Printf("  expected rate:                    %s/s (limited by %s)\n", formatBytes(int64(rec.Rate)), rec.Bottleneck)
+	}
+	for _, n := range rec.Notes {
+		fmt.Printf("  - %s\n", n)
+	}
+}
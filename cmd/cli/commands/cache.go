@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/storage/cache"
+	s3storage "github.com/sanskarpan/db-backup/internal/storage/s3"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// cacheCmd groups the artifact cache commands
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and clear the local cache of downloaded artifacts",
+	Long: `Downloaded artifacts are kept under storage.cache.directory, up to
+storage.cache.max_size, so verifying or rehearsing a restore of the same
+backup again skips the download. The least recently used artifacts are
+evicted first.
+
+Examples:
+  # Show how much the cache holds
+  db-backup cache status
+
+  # Free the space, e.g. before rotating encryption keys
+  db-backup cache clear`,
+}
+
+// cacheStatusCmd prints the cache's size
+var cacheStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the size of the artifact cache",
+	RunE:  runCacheStatus,
+}
+
+// cacheClearCmd empties the cache
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove every cached artifact",
+	RunE:  runCacheClear,
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheStatusCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+
+	cacheStatusCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+}
+
+// artifactCache opens the configured cache
+func artifactCache(cfg *config.Config) (*cache.Cache, error) {
+	cc := cfg.Storage.Cache
+	if !cc.Enabled {
+		return nil, fmt.Errorf("the artifact cache is disabled (storage.cache.enabled)")
+	}
+	maxSize, err := utils.ParseBytes(cc.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage.cache.max_size: %w", err)
+	}
+	return cache.Open(cc.Directory, maxSize)
+}
+
+// tagStorageKey records the object key of an artifact uploaded to storage
+const tagStorageKey = "storage_key"
+
+// fetchArtifact returns the path of the artifact of b on local disk and a
+// function releasing it: the artifact itself while it is still where it
+// was written, else a copy downloaded from its storage provider, through
+// the artifact cache when it is enabled
+func fetchArtifact(ctx context.Context, cfg *config.Config, b *models.BackupMetadata) (string, func(), error) {
+	if _, err := os.Stat(b.BackupPath); err == nil {
+		return b.BackupPath, func() {}, nil
+	}
+	key := b.Tags[tagStorageKey]
+	if key == "" {
+		return "", nil, fmt.Errorf("artifact not available locally: %s", b.BackupPath)
+	}
+	download, err := artifactDownload(ctx, cfg, b.StorageType, key)
+	if err != nil {
+		return "", nil, err
+	}
+	if cfg.Storage.Cache.Enabled {
+		c, err := artifactCache(cfg)
+		if err != nil {
+			return "", nil, err
+		}
+		return c.Get(ctx, b.ID, download)
+	}
+
+	tmp, err := os.CreateTemp("", "db-backup-artifact-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	err = download(ctx, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// artifactDownload returns the function downloading the object at key
+// from the storage provider name
+func artifactDownload(ctx context.Context, cfg *config.Config, name, key string) (cache.FetchFunc, error) {
+	switch name {
+	case "s3":
+		p, err := s3storage.New(ctx, cfg.Storage.Providers.S3)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, w io.Writer) error {
+			return p.Download(ctx, key, w)
+		}, nil
+	}
+	return nil, fmt.Errorf("artifacts cannot be downloaded from %s storage", name)
+}
+
+func runCacheStatus(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+
+	c, err := artifactCache(GetConfig())
+	if err != nil {
+		return err
+	}
+	stats := c.Stats()
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(stats)
+	case "yaml", "yml":
+		return printYAMLValue(stats)
+	}
+	used := 0.0
+	if stats.MaxSize > 0 {
+		used = float64(stats.Size) / float64(stats.MaxSize) * 100
+	}
+	fmt.Printf("Directory: %s\n", GetConfig().Storage.Cache.Directory)
+	fmt.Printf("Artifacts: %d\n", stats.Entries)
+	fmt.Printf("Size:      %s of %s (%.1f%%)\n", formatBytes(stats.Size), formatBytes(stats.MaxSize), used)
+	return nil
+}
+
+func runCacheClear(cmd *cobra.Command, args []string) error {
+	c, err := artifactCache(GetConfig())
+	if err != nil {
+		return err
+	}
+	freed := c.Clear()
+	fmt.Printf("✓ Freed %s\n", formatBytes(freed))
+	return nil
+}
@@ -0,0 +1,225 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// configCmd groups configuration inspection commands
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and validate configuration",
+	Long: `Inspect and validate the db-backup configuration.
+
+Examples:
+  # Validate the configuration file and report every problem
+  db-backup config validate --file /etc/db-backup/config.yaml
+
+  # Show settings overridden by environment variables or defaults
+  db-backup config diff
+
+  # Show every effective setting and where it came from
+  db-backup config diff --all --format json
+
+  # Validate config.yaml with the config.prod.yaml overlay merged over it
+  db-backup --env prod config validate
+
+  # Write the JSON Schema of the configuration for editor completion
+  db-backup config schema > config.schema.json
+
+  # Upgrade a config file of an older layout to the current one
+  db-backup config migrate --file config.yaml > config.new.yaml`,
+}
+
+// configValidateCmd validates the configuration
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate configuration and report all errors",
+	RunE:  runConfigValidate,
+}
+
+// configDiffCmd compares effective configuration with the config file
+var configDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare effective configuration against the config file",
+	Long: `Compare the effective configuration (defaults, config file and
+DBBACKUP_* environment overrides combined) against the values written in
+the config file, to debug why a setting is not taking effect.`,
+	RunE: runConfigDiff,
+}
+
+// configSchemaCmd prints the JSON Schema of the configuration
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the configuration file",
+	Long: `Print the JSON Schema the configuration file is validated against
+when it is loaded. Point your editor at it (e.g. with a
+"# yaml-language-server: $schema=config.schema.json" comment) for
+completion and inline errors.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printJSONValue(config.ConfigSchema())
+	},
+}
+
+// configMigrateCmd prints the config file upgraded to the current layout
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the config file to the current layout",
+	Long: `Print the config file upgraded to the current layout version, listing
+the changes on stderr. Files of older layouts are upgraded as they are
+loaded too; migrate makes the upgrade permanent. Comments are not kept, and
+included files and overlays are migrated one at a time with --file.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigMigrate,
+}
+
+// environmentFlag exports --env as DBBACKUP_ENV as soon as the flag is
+// parsed, so the overlay is merged wherever the configuration is loaded
+type environmentFlag struct {
+	name string
+}
+
+func (f *environmentFlag) String() string { return f.name }
+
+func (f *environmentFlag) Set(name string) error {
+	f.name = name
+	return os.Setenv(config.EnvironmentEnv, name)
+}
+
+func (f *environmentFlag) Type() string { return "string" }
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	rootCmd.PersistentFlags().Var(&environmentFlag{}, "env", "environment whose overlay (config.<env>.yaml) is merged over the config file (or "+config.EnvironmentEnv+")")
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configDiffCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	configCmd.AddCommand(configMigrateCmd)
+
+	configCmd.PersistentFlags().StringP("file", "f", "", "config file to inspect (defaults to the standard search paths)")
+
+	configDiffCmd.Flags().Bool("all", false, "show all settings, not only those that differ from the file")
+	configDiffCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	path := configFilePath(cmd)
+
+	// Parse reports where the file does not match the schema, Check
+	// everything else
+	var errs config.FieldErrors
+	cfg, err := config.Parse(path)
+	if err != nil && !errors.As(err, &errs) {
+		return err
+	}
+	if err == nil {
+		errs = config.Check(cfg)
+	}
+	if len(errs) == 0 {
+		fmt.Println("✓ Configuration is valid")
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "✗ Configuration has %d error(s):\n", len(errs))
+	for _, fe := range errs {
+		fmt.Fprintf(os.Stderr, "  - %s: %s\n", fe.Path, fe.Message)
+	}
+	return fmt.Errorf("configuration validation failed")
+}
+
+func runConfigDiff(cmd *cobra.Command, args []string) error {
+	showAll, _ := cmd.Flags().GetBool("all")
+	format, _ := cmd.Flags().GetString("format")
+
+	result, err := config.Diff(configFilePath(cmd))
+	if err != nil {
+		return err
+	}
+
+	settings := make([]config.SettingDiff, 0, len(result.Settings))
+	for _, s := range result.Settings {
+		if showAll || s.Source == config.SourceEnv || s.Changed() {
+			settings = append(settings, s)
+		}
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(settings)
+	case "yaml", "yml":
+		return printYAMLValue(settings)
+	}
+
+	if result.ConfigFile != "" {
+		fmt.Printf("Config file: %s\n\n", result.ConfigFile)
+	} else {
+		fmt.Printf("Config file: (none found, using defaults and environment)\n\n")
+	}
+
+	if len(settings) == 0 {
+		fmt.Println("No differences between config file and effective configuration.")
+		return nil
+	}
+
+	fmt.Printf("%-50s %-25s %-25s %s\n", "KEY", "FILE", "EFFECTIVE", "SOURCE")
+	for _, s := range settings {
+		source := string(s.Source)
+		if s.EnvVar != "" {
+			source += " (" + s.EnvVar + ")"
+		}
+		fmt.Printf("%-50s %-25s %-25s %s\n",
+			truncate(s.Key, 50),
+			truncate(displayValue(s.Key, s.FileValue), 25),
+			truncate(displayValue(s.Key, s.EffectiveValue), 25),
+			source,
+		)
+	}
+
+	return nil
+}
+
+func runConfigMigrate(cmd *cobra.Command, args []string) error {
+	data, changes, err := config.MigrateFile(configFilePath(cmd))
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(os.Stderr, "Already at layout version %d\n", config.CurrentVersion)
+	}
+	for _, change := range changes {
+		fmt.Fprintf(os.Stderr, "  - %s\n", change)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// configFilePath returns the config file selected for the config subcommands,
+// falling back to the global --config flag when one is defined
+func configFilePath(cmd *cobra.Command) string {
+	if path, _ := cmd.Flags().GetString("file"); path != "" {
+		return path
+	}
+	path, _ := cmd.Flags().GetString("config")
+	return path
+}
+
+// displayValue formats a config value for display, masking secrets
+func displayValue(key string, value interface{}) string {
+	if value == nil {
+		return "-"
+	}
+	s := fmt.Sprint(value)
+	lower := strings.ToLower(key)
+	for _, marker := range []string{"password", "secret", "token", "access_key", "account_key"} {
+		if strings.Contains(lower, marker) && s != "" {
+			return "****"
+		}
+	}
+	return s
+}
@@ -0,0 +1,266 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/pkg/redact"
+	"github.com/spf13/cobra"
+)
+
+// connectionsCmd groups the connection profile commands
+var connectionsCmd = &cobra.Command{
+	Use:   "connections",
+	Short: "List and test connection profiles",
+	Long: `Connection profiles, configured under connections, name a database with
+separate logins for backups and restores. The backup login only needs read
+access; restores use their own login, optionally assuming a role with
+write and DDL rights, and are refused for profiles without one.
+
+Examples:
+  # Show the profiles and which logins they use
+  db-backup connections list
+
+  # Check the backup login can connect
+  db-backup connections test orders
+
+  # Check the restore login and role can connect
+  db-backup connections test orders --restore`,
+}
+
+// connectionsListCmd prints the configured profiles
+var connectionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List connection profiles",
+	RunE:  runConnectionsList,
+}
+
+// connectionsTestCmd connects with a profile's credentials
+var connectionsTestCmd = &cobra.Command{
+	Use:   "test <profile>",
+	Short: "Connect with a profile's backup or restore credentials",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runConnectionsTest,
+}
+
+func init() {
+	rootCmd.AddCommand(connectionsCmd)
+	connectionsCmd.AddCommand(connectionsListCmd)
+	connectionsCmd.AddCommand(connectionsTestCmd)
+
+	connectionsListCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+
+	connectionsTestCmd.Flags().Bool("restore", false, "test the restore credentials instead of the backup credentials")
+	connectionsTestCmd.Flags().Duration("timeout", 10*time.Second, "connection timeout")
+}
+
+// applyConnectionProfile fills the connection options of a backup from a
+// profile's backup credentials, leaving options set by flags alone
+func applyConnectionProfile(cmd *cobra.Command, opts *BackupOptions, cfg *config.Config, name string) error {
+	p, creds, err := cfg.Connection(name, config.PurposeBackup)
+	if err != nil {
+		return err
+	}
+	set := func(flag string, dst *string, value string) {
+		if value != "" && !cmd.Flags().Changed(flag) {
+			*dst = value
+		}
+	}
+	set("type", &opts.Type, p.Type)
+	set("host", &opts.Host, p.Host)
+	set("user", &opts.User, creds.User)
+	set("password", &opts.Password, creds.Password)
+	if !cmd.Flags().Changed("databases") && !cmd.Flags().Changed("all-databases") {
+		set("database", &opts.Database, p.Database)
+	}
+	if p.Port != 0 && !cmd.Flags().Changed("port") {
+		opts.Port = p.Port
+	}
+	return nil
+}
+
+// profileConnection returns the driver connection settings of a profile
+// for purpose, including the role restores assume
+func profileConnection(cfg *config.Config, name, purpose string) (*database.ConnectionConfig, error) {
+	p, creds, err := cfg.Connection(name, purpose)
+	if err != nil {
+		return nil, err
+	}
+	dbType, err := parseDatabaseType(p.Type)
+	if err != nil {
+		return nil, err
+	}
+	conn := &database.ConnectionConfig{
+		Type:     dbType,
+		Host:     p.Host,
+		Port:     getPort(p.Type, p.Port),
+		Username: creds.User,
+		Password: creds.Password,
+		Database: p.Database,
+		SSLMode:  p.SSLMode,
+		SSLCA:    p.SSLCA,
+		SSLCert:  p.SSLCert,
+		SSLKey:   p.SSLKey,
+	}
+	if purpose == config.PurposeRestore {
+		conn.Role = creds.Role
+	}
+	if dbType == database.DatabaseTypeCockroachDB {
+		uri, err := cockroachBackupURI(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if uri != "" {
+			conn.Options = map[string]string{"backup_uri": uri}
+		}
+	}
+	return conn, nil
+}
+
+// cockroachBackupURI returns where CockroachDB nodes write native backups:
+// the cockroachdb prefix of the default storage provider, with its
+// credentials. Local storage is out of the nodes' reach, so it leaves the
+// driver's nodelocal default.
+func cockroachBackupURI(cfg *config.Config) (string, error) {
+	providers := cfg.Storage.Providers
+	q := url.Values{}
+	switch cfg.Storage.DefaultProvider {
+	case "s3":
+		s3 := providers.S3
+		if s3.AccessKey != "" {
+			q.Set("AUTH", "specified")
+			q.Set("AWS_ACCESS_KEY_ID", s3.AccessKey)
+			q.Set("AWS_SECRET_ACCESS_KEY", s3.SecretKey)
+			redact.AddSecrets(url.QueryEscape(s3.SecretKey))
+		} else {
+			q.Set("AUTH", "implicit")
+		}
+		if s3.Region != "" {
+			q.Set("AWS_REGION", s3.Region)
+		}
+		if s3.Endpoint != "" {
+			q.Set("AWS_ENDPOINT", s3.Endpoint)
+		}
+		if s3.UsePathStyle {
+			q.Set("AWS_USE_PATH_STYLE", "true")
+		}
+		return (&url.URL{Scheme: "s3", Host: s3.Bucket, Path: "/cockroachdb", RawQuery: q.Encode()}).String(), nil
+	case "gcs":
+		gcs := providers.GCS
+		if gcs.CredentialsFile != "" {
+			data, err := os.ReadFile(gcs.CredentialsFile)
+			if err != nil {
+				return "", fmt.Errorf("storage.providers.gcs.credentials_file: %w", err)
+			}
+			credentials := base64.StdEncoding.EncodeToString(data)
+			q.Set("AUTH", "specified")
+			q.Set("CREDENTIALS", credentials)
+			redact.AddSecrets(url.QueryEscape(credentials))
+		} else {
+			q.Set("AUTH", "implicit")
+		}
+		return (&url.URL{Scheme: "gs", Host: gcs.Bucket, Path: "/cockroachdb", RawQuery: q.Encode()}).String(), nil
+	case "azure":
+		azure := providers.Azure
+		q.Set("AZURE_ACCOUNT_NAME", azure.AccountName)
+		q.Set("AZURE_ACCOUNT_KEY", azure.AccountKey)
+		redact.AddSecrets(url.QueryEscape(azure.AccountKey))
+		return (&url.URL{Scheme: "azure-blob", Host: azure.Container, Path: "/cockroachdb", RawQuery: q.Encode()}).String(), nil
+	}
+	return "", nil
+}
+
+func runConnectionsList(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+
+	profiles := GetConfig().Connections
+	type row struct {
+		Name        string `json:"name" yaml:"name"`
+		Type        string `json:"type" yaml:"type"`
+		Host        string `json:"host,omitempty" yaml:"host,omitempty"`
+		Database    string `json:"database,omitempty" yaml:"database,omitempty"`
+		BackupUser  string `json:"backup_user,omitempty" yaml:"backup_user,omitempty"`
+		RestoreUser string `json:"restore_user,omitempty" yaml:"restore_user,omitempty"`
+		RestoreRole string `json:"restore_role,omitempty" yaml:"restore_role,omitempty"`
+	}
+	rows := make([]row, 0, len(profiles))
+	for name, p := range profiles {
+		host := p.Host
+		if p.Port != 0 {
+			host = fmt.Sprintf("%s:%d", p.Host, p.Port)
+		}
+		rows = append(rows, row{name, p.Type, host, p.Database, p.Backup.User, p.Restore.User, p.Restore.Role})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(rows)
+	case "yaml", "yml":
+		return printYAMLValue(rows)
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("No connection profiles configured.")
+		return nil
+	}
+	fmt.Printf("%-20s %-9s %-28s %-16s %-16s %s\n", "NAME", "TYPE", "HOST", "DATABASE", "BACKUP USER", "RESTORE USER")
+	for _, r := range rows {
+		restore := r.RestoreUser
+		switch {
+		case restore == "" && !config.FileDatabase(r.Type):
+			restore = "- (restores refused)"
+		case r.RestoreRole != "":
+			restore += " (role " + r.RestoreRole + ")"
+		}
+		fmt.Printf("%-20s %-9s %-28s %-16s %-16s %s\n", truncate(r.Name, 20), r.Type, truncate(r.Host, 28),
+			truncate(r.Database, 16), truncate(r.BackupUser, 16), restore)
+	}
+	return nil
+}
+
+func runConnectionsTest(cmd *cobra.Command, args []string) error {
+	restore, _ := cmd.Flags().GetBool("restore")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	purpose := config.PurposeBackup
+	if restore {
+		purpose = config.PurposeRestore
+	}
+	conn, err := profileConnection(GetConfig(), args[0], purpose)
+	if err != nil {
+		return err
+	}
+	redact.AddSecrets(conn.Password)
+	conn.ConnectionTimeout = timeout
+
+	driver, err := database.CreateDriver(conn.Type)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := driver.Connect(ctx, conn); err != nil {
+		return fmt.Errorf("%s login %s failed: %w", purpose, conn.Username, err)
+	}
+	defer driver.Disconnect()
+
+	version, err := driver.GetVersion(ctx)
+	if err != nil {
+		version = "unknown version"
+	}
+	who := conn.Username
+	if conn.Role != "" {
+		who += " as role " + conn.Role
+	}
+	fmt.Printf("✓ %s: %s login %s connected (%s)\n", args[0], purpose, who, version)
+	return nil
+}
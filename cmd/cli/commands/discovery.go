@@ -0,0 +1,221 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/discovery"
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/sanskarpan/db-backup/pkg/client"
+	"github.com/sanskarpan/db-backup/pkg/redact"
+	"github.com/spf13/cobra"
+)
+
+// discoveryCmd groups the database discovery commands
+var discoveryCmd = &cobra.Command{
+	Use:   "discovery",
+	Short: "Enroll new databases of the configured servers into a schedule",
+	Long: `List the databases of each server under discovery.servers with the
+backup login of its profile, and create a schedule on the API server for
+every database no schedule of the profile backs up yet, so a newly created
+database never goes unprotected. The schedule is named
+discovered-<server>-<database>, runs on the server's cron and tags the
+backups with the server's tags and discovered_from, so
+backup.database_defaults and backup.quotas entries can match them.
+
+Databases matching an exclude pattern, or no include pattern when some are
+set, are never enrolled. Schedules are only ever created: one that was
+edited, disabled or deleted by hand is not touched, and a deleted one is
+only recreated if the database is still there and not excluded.
+
+The server is --server, DBBACKUP_SERVER_URL or the local server. The API key
+is read from --api-key-file or DBBACKUP_API_KEY.
+
+Examples:
+  # Show what would be enrolled
+  db-backup discovery run --dry-run
+
+  # Enroll the new databases once (cron)
+  db-backup discovery run
+
+  # Enroll every discovery.interval until stopped
+  db-backup discovery watch`,
+}
+
+// discoveryRunCmd enrolls the new databases once
+var discoveryRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Enroll the databases not backed up yet",
+	RunE:  runDiscoveryRun,
+}
+
+// discoveryWatchCmd keeps enrolling until interrupted
+var discoveryWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Enroll new databases every discovery interval until stopped",
+	RunE:  runDiscoveryWatch,
+}
+
+func init() {
+	rootCmd.AddCommand(discoveryCmd)
+	discoveryCmd.AddCommand(discoveryRunCmd)
+	discoveryCmd.AddCommand(discoveryWatchCmd)
+
+	discoveryCmd.PersistentFlags().String("server", os.Getenv("DBBACKUP_SERVER_URL"), "db-backup server URL (default the local server)")
+	discoveryCmd.PersistentFlags().String("api-key-file", "", "file holding the API key (default $DBBACKUP_API_KEY)")
+
+	discoveryRunCmd.Flags().Bool("dry-run", false, "only show what would be enrolled")
+	discoveryRunCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+}
+
+// discoveryLister lists the databases of a profile's server with its
+// backup login
+func discoveryLister(cfg *config.Config) discovery.Lister {
+	return func(ctx context.Context, profile string) ([]string, error) {
+		conn, err := profileConnection(cfg, profile, config.PurposeBackup)
+		if err != nil {
+			return nil, err
+		}
+		redact.AddSecrets(conn.Password)
+
+		driver, err := database.CreateDriver(conn.Type)
+		if err != nil {
+			return nil, err
+		}
+		if err := driver.Connect(ctx, conn); err != nil {
+			return nil, fmt.Errorf("backup login %s failed: %w", conn.Username, err)
+		}
+		defer driver.Disconnect()
+		return driver.GetDatabases(ctx)
+	}
+}
+
+// newDiscoverer returns the configured discoverer, enrolling through the
+// API server named by the command's flags
+func newDiscoverer(cmd *cobra.Command) (*discovery.Discoverer, error) {
+	cfg := GetConfig()
+	if !cfg.Discovery.Enabled {
+		return nil, fmt.Errorf("database discovery is not enabled (discovery.enabled)")
+	}
+	server, _ := cmd.Flags().GetString("server")
+	keyFile, _ := cmd.Flags().GetString("api-key-file")
+	server, apiKey, err := serverEndpoint(server, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	api := client.New(server, client.WithAPIKey(apiKey), client.WithUserAgent("db-backup-discovery"))
+	return discovery.New(cfg.Discovery, discoveryLister(cfg), api), nil
+}
+
+// reportEnrollments audits and announces newly enrolled databases
+func reportEnrollments(ctx context.Context, enrollments []discovery.Enrollment) {
+	cfg := GetConfig()
+	log := GetLogger()
+
+	var names []string
+	for _, e := range enrollments {
+		if e.Status != discovery.StatusEnrolled {
+			continue
+		}
+		recordAudit(cfg, log, cliActor(), audit.ActionDatabaseEnrolled, e.Database, map[string]string{
+			"server":   e.Server,
+			"schedule": e.Schedule,
+		})
+		names = append(names, e.Database)
+	}
+	if len(names) == 0 {
+		return
+	}
+	sendNotification(ctx, cfg, log, &notification.Notification{
+		Event:     notification.EventSuccess,
+		Title:     fmt.Sprintf("Enrolled %d new database(s) into backups", len(names)),
+		Message:   strings.Join(names, ", "),
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+func runDiscoveryRun(cmd *cobra.Command, args []string) error {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	format, _ := cmd.Flags().GetString("format")
+
+	d, err := newDiscoverer(cmd)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	enrollments, discoverErr := d.Discover(ctx, dryRun)
+	if !dryRun {
+		reportEnrollments(ctx, enrollments)
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		if err := printJSONValue(enrollments); err != nil {
+			return err
+		}
+		return discoverErr
+	case "yaml", "yml":
+		if err := printYAMLValue(enrollments); err != nil {
+			return err
+		}
+		return discoverErr
+	}
+
+	if len(enrollments) > 0 {
+		fmt.Printf("%-16s %-24s %-10s %s\n", "SERVER", "DATABASE", "STATUS", "SCHEDULE")
+	}
+	var enrolled int
+	for _, e := range enrollments {
+		status := e.Status
+		if status == discovery.StatusEnrolled {
+			enrolled++
+			if dryRun {
+				status = "would enroll"
+			}
+		}
+		fmt.Printf("%-16s %-24s %-10s %s\n", truncate(e.Server, 16), truncate(e.Database, 24), status, e.Schedule)
+	}
+	if dryRun {
+		fmt.Printf("Dry run: %d database(s) would be enrolled\n", enrolled)
+	} else {
+		fmt.Printf("✓ Enrolled %d new database(s)\n", enrolled)
+	}
+	return discoverErr
+}
+
+func runDiscoveryWatch(cmd *cobra.Command, args []string) error {
+	log := GetLogger()
+	cfg := GetConfig()
+
+	d, err := newDiscoverer(cmd)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info("Discovering databases", map[string]interface{}{
+		"interval": cfg.Discovery.Interval.String(),
+		"servers":  len(cfg.Discovery.Servers),
+	})
+	return d.Run(ctx, func(ctx context.Context, enrollments []discovery.Enrollment) {
+		for _, e := range enrollments {
+			log.Info("Enrolled database", map[string]interface{}{
+				"server":   e.Server,
+				"database": e.Database,
+				"schedule": e.Schedule,
+			})
+		}
+		reportEnrollments(ctx, enrollments)
+	}, func(err error) {
+		log.Warn("Database discovery failed", map[string]interface{}{"error": err.Error()})
+	})
+}
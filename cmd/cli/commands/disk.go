@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/diskspace"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/spf13/cobra"
+)
+
+// diskCmd groups disk space commands
+var diskCmd = &cobra.Command{
+	Use:   "disk",
+	Short: "Monitor free disk space and clean up abandoned temp files",
+	Long: `Monitor free space on the temp directory, local storage and any paths
+listed under backup.disk_watchdog.paths, and remove temp files left behind
+by crashed jobs.
+
+A path is low on space when its free share drops below min_free_percent or
+its free bytes below min_free_space. Temp entries nothing has written to for
+temp_max_age are treated as abandoned.
+
+Examples:
+  # Show free space on every watched path
+  db-backup disk status
+
+  # List abandoned temp files without removing them
+  db-backup disk clean --dry-run
+
+  # Run the watchdog in the foreground, alerting through the notifiers
+  db-backup disk watch`,
+}
+
+// diskStatusCmd prints free space per watched path
+var diskStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show free space on the watched paths",
+	RunE:  runDiskStatus,
+}
+
+// diskCleanCmd removes abandoned temp entries
+var diskCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove temp files abandoned by crashed jobs",
+	RunE:  runDiskClean,
+}
+
+// diskWatchCmd runs the watchdog until interrupted
+var diskWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Periodically clean the temp directory and alert on low disk space",
+	RunE:  runDiskWatch,
+}
+
+func init() {
+	rootCmd.AddCommand(diskCmd)
+	diskCmd.AddCommand(diskStatusCmd)
+	diskCmd.AddCommand(diskCleanCmd)
+	diskCmd.AddCommand(diskWatchCmd)
+
+	diskStatusCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+	diskStatusCmd.Flags().Bool("fail-on-low", false, "exit non-zero when any path is low on space")
+
+	diskCleanCmd.Flags().Bool("dry-run", false, "only list the entries that would be removed")
+	diskCleanCmd.Flags().Duration("max-age", 0, "override backup.disk_watchdog.temp_max_age")
+}
+
+func runDiskStatus(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	failOnLow, _ := cmd.Flags().GetBool("fail-on-low")
+
+	w, err := diskspace.New(GetConfig())
+	if err != nil {
+		return err
+	}
+	statuses := w.Status()
+
+	switch strings.ToLower(format) {
+	case "json":
+		err = printJSONValue(statuses)
+	case "yaml", "yml":
+		err = printYAMLValue(statuses)
+	default:
+		fmt.Printf("%-40s %-12s %-12s %-8s %s\n", "PATH", "FREE", "TOTAL", "FREE %", "STATUS")
+		for _, s := range statuses {
+			if s.Err != "" {
+				fmt.Printf("%-40s %-12s %-12s %-8s error: %s\n", truncate(s.Path, 40), "-", "-", "-", s.Err)
+				continue
+			}
+			status := "ok"
+			if s.Low {
+				status = "LOW: " + s.Reason
+			}
+			fmt.Printf("%-40s %-12s %-12s %-8s %s\n", truncate(s.Path, 40), formatBytes(int64(s.Free)),
+				formatBytes(int64(s.Total)), fmt.Sprintf("%.1f%%", s.FreePercent), status)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if failOnLow {
+		var low []string
+		for _, s := range statuses {
+			if s.Low {
+				low = append(low, s.Path)
+			}
+		}
+		if len(low) > 0 {
+			return fmt.Errorf("low disk space on: %s", strings.Join(low, ", "))
+		}
+	}
+	return nil
+}
+
+func runDiskClean(cmd *cobra.Command, args []string) error {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	maxAge, _ := cmd.Flags().GetDuration("max-age")
+
+	cfg := *GetConfig()
+	if maxAge > 0 {
+		cfg.Backup.DiskWatchdog.TempMaxAge = maxAge
+	}
+	if cfg.Backup.DiskWatchdog.TempMaxAge <= 0 {
+		return fmt.Errorf("temp cleanup is disabled (backup.disk_watchdog.temp_max_age is 0); pass --max-age")
+	}
+
+	w, err := diskspace.New(&cfg)
+	if err != nil {
+		return err
+	}
+	removed, err := w.Cleanup(time.Now(), dryRun)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, r := range removed {
+		total += r.Size
+		fmt.Printf("  %s  %s  last written %s\n", r.Path, formatBytes(r.Size), r.ModTime.Format(time.RFC3339))
+	}
+	if dryRun {
+		fmt.Printf("✓ Dry run: %d abandoned entr(ies), %s would be freed\n", len(removed), formatBytes(total))
+	} else {
+		fmt.Printf("✓ Removed %d abandoned entr(ies), freed %s\n", len(removed), formatBytes(total))
+	}
+	return nil
+}
+
+func runDiskWatch(cmd *cobra.Command, args []string) error {
+	log := GetLogger()
+	cfg := GetConfig()
+
+	w, err := diskspace.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info("Disk watchdog started", map[string]interface{}{
+		"interval":     cfg.Backup.DiskWatchdog.Interval.String(),
+		"temp_max_age": cfg.Backup.DiskWatchdog.TempMaxAge.String(),
+	})
+	w.Run(ctx, func(ctx context.Context, n *notification.Notification) error {
+		log.Warn(n.Title, map[string]interface{}{"message": n.Message})
+		sendNotification(ctx, cfg, log, n)
+		return nil
+	}, func(err error) {
+		log.Error("Disk watchdog check failed", err)
+	})
+	log.Info("Disk watchdog stopped")
+	return nil
+}
+
+// warnLowTempSpace logs a warning before a backup when the temp directory
+// is already low on space, so an ENOSPC failure does not come unannounced
+func warnLowTempSpace(cfg *config.Config, log *logger.Logger) {
+	if !cfg.Backup.DiskWatchdog.Enabled || cfg.Backup.TempDirectory == "" {
+		return
+	}
+	tempOnly := *cfg
+	tempOnly.Storage.Providers.Local.Enabled = false
+	tempOnly.Backup.DiskWatchdog.Paths = nil
+	w, err := diskspace.New(&tempOnly)
+	if err != nil {
+		return
+	}
+	for _, s := range w.Status() {
+		if s.Low {
+			log.Warn("Temp directory is low on disk space", map[string]interface{}{
+				"path":   s.Path,
+				"free":   formatBytes(int64(s.Free)),
+				"reason": s.Reason,
+			})
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/drcopy"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/spf13/cobra"
+)
+
+// drCmd manages disaster recovery copies
+var drCmd = &cobra.Command{
+	Use:   "dr",
+	Short: "Replicate backups to another region or account",
+	Long: `Replicate completed backups to the buckets of the copy jobs under dr.jobs,
+each in another region or account with credentials and retention of its
+own. Copies are recorded per backup, so "db-backup dr status", "db-backup
+list" and GET /api/v1/backups/:id/dr tell which backups survive the loss of
+the primary region.
+
+Examples:
+  # Copy new backups and delete expired copies, once
+  db-backup dr run
+
+  # Keep doing so every dr.interval
+  db-backup dr run --watch
+
+  # Show the DR copies of a backup
+  db-backup dr status 20250101-020000-orders`,
+}
+
+// drRunCmd runs copy jobs
+var drRunCmd = &cobra.Command{
+	Use:   "run [job...]",
+	Short: "Copy new backups and delete expired copies",
+	Long: `Run copy jobs, every job when none is named. Each job copies the
+completed backups of its databases that it has not copied yet, skipping
+quarantined backups and backups older than its retention_days, then deletes
+its copies whose retention has passed. Failed copies are retried on the
+next run.
+
+Each artifact is checked against the checksum in the catalog before it is
+copied, and S3 checks the upload against its SHA-256. Only artifacts on the
+local filesystem can be copied.
+
+Examples:
+  # Run every job once, e.g. from cron after the nightly backups
+  db-backup dr run
+
+  # Run one job for one database
+  db-backup dr run us-west --database orders
+
+  # Show what would be copied and deleted
+  db-backup dr run --dry-run
+
+  # Run every dr.interval until interrupted
+  db-backup dr run --watch`,
+	RunE: runDRRun,
+}
+
+// drStatusCmd prints recorded copies
+var drStatusCmd = &cobra.Command{
+	Use:   "status [backup-id]",
+	Short: "Show the DR copies of backups",
+	Long: `Show the DR copies of backups: the job, bucket and region, when it was
+copied and when the copy expires. Failed copies are listed with their
+error and attempts until a later run succeeds.
+
+Examples:
+  # Every copy
+  db-backup dr status
+
+  # The copies of one backup
+  db-backup dr status 20250101-020000-orders
+
+  # Failed copies of one job, as JSON
+  db-backup dr status --job us-west --status failed --format json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runDRStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(drCmd)
+	drCmd.AddCommand(drRunCmd)
+	drCmd.AddCommand(drStatusCmd)
+
+	drRunCmd.Flags().String("database", "", "only copy backups of this database")
+	drRunCmd.Flags().Bool("dry-run", false, "show what would be copied and deleted without doing it")
+	drRunCmd.Flags().Bool("watch", false, "run every dr.interval until interrupted")
+
+	drStatusCmd.Flags().String("job", "", "only show copies by this job")
+	drStatusCmd.Flags().String("status", "", "only show copies with this status (copied|failed|expired)")
+	drStatusCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+}
+
+// drJobs returns the named jobs, every job when names is empty
+func drJobs(cfg *config.Config, names []string) ([]*drcopy.Job, error) {
+	if !cfg.DR.Enabled {
+		return nil, fmt.Errorf("DR copies are not enabled (dr.enabled)")
+	}
+	jobs, err := drcopy.Jobs(cfg.DR)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return jobs, nil
+	}
+	byName := make(map[string]*drcopy.Job, len(jobs))
+	configured := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		byName[j.Name] = j
+		configured = append(configured, j.Name)
+	}
+	selected := make([]*drcopy.Job, 0, len(names))
+	for _, name := range names {
+		j, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("no dr job %q (configured: %s)", name, strings.Join(configured, ", "))
+		}
+		selected = append(selected, j)
+	}
+	return selected, nil
+}
+
+func runDRRun(cmd *cobra.Command, args []string) error {
+	database, _ := cmd.Flags().GetString("database")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	watch, _ := cmd.Flags().GetBool("watch")
+
+	cfg := GetConfig()
+	log := GetLogger()
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	jobs, err := drJobs(cfg, args)
+	if err != nil {
+		return err
+	}
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+	store := drcopy.NewStore(cfg.DR)
+
+	pass := func() error {
+		selected, err := drCandidates(ctx, cfg, repo.List, database)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return drDryRun(store, jobs, selected)
+		}
+		return drPass(ctx, cfg, store, jobs, selected)
+	}
+	if !watch || dryRun {
+		return pass()
+	}
+
+	if cfg.DR.Interval <= 0 {
+		return fmt.Errorf("dr.interval must be positive")
+	}
+	ticker := time.NewTicker(cfg.DR.Interval)
+	defer ticker.Stop()
+	for {
+		if err := pass(); err != nil && ctx.Err() == nil {
+			log.Warn("DR copy run failed", map[string]interface{}{"error": err.Error()})
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// drCandidates returns the completed backups that are not quarantined
+func drCandidates(ctx context.Context, cfg *config.Config, list func(context.Context, *repository.ListFilter) ([]*models.BackupMetadata, error), database string) ([]*models.BackupMetadata, error) {
+	all, err := list(ctx, &repository.ListFilter{Database: database})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	quarantined, err := quarantinedBackups(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var out []*models.BackupMetadata
+	for _, b := range all {
+		if b.Status == models.BackupStatusCompleted && !quarantined[b.ID] {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+// drBackups converts catalog entries for the replicator
+func drBackups(selected []*models.BackupMetadata) []drcopy.Backup {
+	backups := make([]drcopy.Backup, 0, len(selected))
+	for _, b := range selected {
+		backups = append(backups, drcopy.Backup{ID: b.ID, Database: b.Database, Checksum: b.Checksum, CreatedAt: b.StartTime})
+	}
+	return backups
+}
+
+// drPass runs every job once and fails when any copy or delete did
+func drPass(ctx context.Context, cfg *config.Config, store *drcopy.Store, jobs []*drcopy.Job, selected []*models.BackupMetadata) error {
+	log := GetLogger()
+
+	// Artifacts are copied from where the backup wrote them
+	paths := make(map[string]string, len(selected))
+	for _, b := range selected {
+		paths[b.ID] = b.BackupPath
+	}
+	source := func(_ context.Context, b drcopy.Backup) (string, func(), error) {
+		path := paths[b.ID]
+		if _, err := os.Stat(path); err != nil {
+			return "", nil, fmt.Errorf("artifact not available locally: %w", err)
+		}
+		return path, func() {}, nil
+	}
+	r := drcopy.NewReplicator(store, source)
+	backups := drBackups(selected)
+
+	var failed int
+	for _, j := range jobs {
+		var copied, expired int
+		start := time.Now()
+		err := r.Copy(ctx, j, backups, func(res drcopy.Result) {
+			switch {
+			case res.Skipped:
+			case res.Err != nil:
+				failed++
+				fmt.Printf("✗ %-38s %s: %v\n", truncate(res.Backup.ID, 38), j.Name, res.Err)
+				if res.Copy != nil {
+					recordAudit(cfg, log, cliActor(), audit.ActionDRCopyFailed, res.Backup.ID, map[string]string{
+						"database": res.Backup.Database,
+						"job":      j.Name,
+						"error":    res.Err.Error(),
+					})
+				}
+			default:
+				copied++
+				c := res.Copy
+				fmt.Printf("✓ %-38s %s to %s (%s)\n", truncate(res.Backup.ID, 38), formatBytes(c.Size), j.Target, c.Region)
+				recordAudit(cfg, log, cliActor(), audit.ActionDRCopied, res.Backup.ID, map[string]string{
+					"database": c.Database,
+					"job":      j.Name,
+					"bucket":   c.Bucket,
+					"region":   c.Region,
+					"object":   c.Object,
+					"checksum": c.Checksum,
+				})
+			}
+		})
+		if err != nil {
+			return err
+		}
+		err = r.Expire(ctx, j, func(res drcopy.Result) {
+			if res.Err != nil {
+				failed++
+				fmt.Printf("✗ %-38s %s: failed to delete expired copy: %v\n", truncate(res.Backup.ID, 38), j.Name, res.Err)
+				return
+			}
+			expired++
+			fmt.Printf("- %-38s expired on %s\n", truncate(res.Backup.ID, 38), j.Target)
+			recordAudit(cfg, log, cliActor(), audit.ActionDRCopyExpired, res.Backup.ID, map[string]string{
+				"database": res.Backup.Database,
+				"job":      j.Name,
+				"object":   res.Copy.Object,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: copied %d backup(s) to %s in %s, deleted %d expired copies\n",
+			j.Name, copied, j.Target, time.Since(start).Round(time.Second), expired)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d DR copies or deletions failed", failed)
+	}
+	return nil
+}
+
+// drDryRun prints what each job would copy and delete
+func drDryRun(store *drcopy.Store, jobs []*drcopy.Job, selected []*models.BackupMetadata) error {
+	now := time.Now()
+	for _, j := range jobs {
+		fmt.Printf("%s (%s, %s):\n", j.Name, j.Target, j.Region)
+		for _, b := range selected {
+			if !j.Holds(b.Database) || (j.Retention > 0 && !b.StartTime.Add(j.Retention).After(now)) {
+				continue
+			}
+			c, err := store.Get(b.ID, j.Name)
+			if err != nil {
+				return err
+			}
+			if c != nil && c.Status != drcopy.StatusFailed {
+				continue
+			}
+			fmt.Printf("  copy   %-38s %-16s %s\n", truncate(b.ID, 38), truncate(b.Database, 16), formatBytes(b.Size))
+		}
+		due, err := store.Due(j.Name, now)
+		if err != nil {
+			return err
+		}
+		for _, c := range due {
+			fmt.Printf("  delete %-38s %-16s expired %s\n", truncate(c.BackupID, 38), truncate(c.Database, 16), c.ExpiresAt.Local().Format("2006-01-02"))
+		}
+	}
+	return nil
+}
+
+// drCopied returns how many jobs hold a copy of each backup, for the
+// catalog listing; nil when DR copies are disabled
+func drCopied(cfg *config.Config) (map[string]int, error) {
+	if !cfg.DR.Enabled {
+		return nil, nil
+	}
+	copies, err := drcopy.NewStore(cfg.DR).List()
+	if err != nil {
+		return nil, err
+	}
+	copied := make(map[string]int)
+	for _, c := range copies {
+		if c.Status == drcopy.StatusCopied {
+			copied[c.BackupID]++
+		}
+	}
+	return copied, nil
+}
+
+func runDRStatus(cmd *cobra.Command, args []string) error {
+	job, _ := cmd.Flags().GetString("job")
+	status, _ := cmd.Flags().GetString("status")
+	format, _ := cmd.Flags().GetString("format")
+
+	cfg := GetConfig()
+	if !cfg.DR.Enabled {
+		return fmt.Errorf("DR copies are not enabled (dr.enabled)")
+	}
+	store := drcopy.NewStore(cfg.DR)
+	var copies []drcopy.Copy
+	var err error
+	if len(args) == 1 {
+		copies, err = store.Copies(args[0])
+	} else {
+		copies, err = store.List()
+	}
+	if err != nil {
+		return err
+	}
+	filtered := copies[:0]
+	for _, c := range copies {
+		if (job == "" || c.Job == job) && (status == "" || c.Status == status) {
+			filtered = append(filtered, c)
+		}
+	}
+	copies = filtered
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(copies)
+	case "yaml", "yml":
+		return printYAMLValue(copies)
+	}
+
+	if len(copies) == 0 {
+		fmt.Println("No DR copies recorded.")
+		return nil
+	}
+	fmt.Printf("%-38s %-16s %-10s %-12s %-8s %-10s %-20s %s\n", "BACKUP ID", "DATABASE", "JOB", "REGION", "STATUS", "SIZE", "COPIED", "EXPIRES / ERROR")
+	for _, c := range copies {
+		detail := "-"
+		if c.ExpiresAt != nil {
+			detail = c.ExpiresAt.Local().Format("2006-01-02")
+		}
+		if c.Error != "" {
+			detail = fmt.Sprintf("%s (%d attempts)", c.Error, c.Attempts)
+		}
+		fmt.Printf("%-38s %-16s %-10s %-12s %-8s %-10s %-20s %s\n", truncate(c.BackupID, 38), truncate(c.Database, 16),
+			truncate(c.Job, 10), truncate(c.Region, 12), c.Status, formatBytes(c.Size),
+			c.CopiedAt.Local().Format("2006-01-02 15:04:05"), detail)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/secrets"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// Tags recording how a backup was encrypted. The key source says where
+// the master key is kept, so restores read the key the backup was taken
+// with rather than whichever is configured by then: a key file, a secret
+// reference such as vault:secret/db#key, or a key of the Vault key store.
+// It is never the key itself, so a key passed on the command line leaves
+// none and has to be passed again to restore.
+const (
+	tagEncryptionKeySource = "encryption_key_source"
+	tagEncryptionCipher    = "encryption_cipher"
+)
+
+// vaultKeyPrefix marks a key of the Vault key store
+// (backup.encryption.key_store: vault) by name, e.g. vault-key:2026-10
+const vaultKeyPrefix = "vault-key:"
+
+// configuredKey returns the key source backups of a database are
+// encrypted with when --encryption-key is not given: its key file, or the
+// current key of the Vault key store
+func configuredKey(cfg *config.Config, keyFile string) string {
+	if keyFile == "" && cfg.Backup.Encryption.KeyStore == "vault" && cfg.Backup.Encryption.Vault.CurrentKey != "" {
+		return vaultKeyPrefix + cfg.Backup.Encryption.Vault.CurrentKey
+	}
+	return keyFile
+}
+
+// readEncryptionKey returns the master key of keyOrSource: a key of the
+// Vault key store, a secret reference, a key file or a key given inline as
+// base64:<key> or hex:<key>
+func readEncryptionKey(ctx context.Context, cfg *config.Config, keyOrSource string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(keyOrSource, vaultKeyPrefix):
+		key, err := vaultKey(ctx, cfg.Backup.Encryption.Vault, strings.TrimPrefix(keyOrSource, vaultKeyPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return stream.ParseKey(key)
+	case secrets.Default().IsReference(keyOrSource):
+		key, _, err := secrets.Default().Resolve(ctx, keyOrSource)
+		if err != nil {
+			return nil, err
+		}
+		return stream.ParseKey(key)
+	}
+	return stream.ReadKey(keyOrSource)
+}
+
+// vaultKey reads the key called name from the Vault key store, kept at
+// <mount_path>/<key_prefix><name>
+func vaultKey(ctx context.Context, v config.VaultConfig, name string) (string, error) {
+	mount := strings.Trim(v.MountPath, "/")
+	if mount == "" {
+		mount = "secret"
+	}
+	resolver := secrets.NewVaultResolver(secrets.VaultOptions{
+		Address:   v.Address,
+		Token:     v.Token,
+		Namespace: v.Namespace,
+	})
+	key, err := resolver.Resolve(ctx, mount+"/"+v.KeyPrefix+name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read encryption key %s from Vault: %w", name, err)
+	}
+	return key, nil
+}
+
+// encryptionKeySource returns the key source to record for a backup
+// encrypted with keyOrSource, empty for a key given inline
+func encryptionKeySource(keyOrSource string) string {
+	switch {
+	case strings.HasPrefix(keyOrSource, vaultKeyPrefix) || secrets.Default().IsReference(keyOrSource):
+		return keyOrSource
+	case stream.IsInlineKey(keyOrSource):
+		return ""
+	}
+	if abs, err := filepath.Abs(keyOrSource); err == nil {
+		return abs
+	}
+	return keyOrSource
+}
+
+// keySourceDescription says where the key of source is kept, for people
+// restoring by hand
+func keySourceDescription(cfg *config.Config, source string) string {
+	if name, ok := strings.CutPrefix(source, vaultKeyPrefix); ok {
+		return fmt.Sprintf("key %s of the Vault key store at %s", name, cfg.Backup.Encryption.Vault.Address)
+	}
+	return source
+}
+
+// artifactKey returns a function reading the master key the backup b was
+// encrypted with: key when given, else the key source recorded with the
+// backup, else the key its database is configured to be encrypted with,
+// for backups taken before sources were recorded
+func artifactKey(ctx context.Context, cfg *config.Config, b *models.BackupMetadata, key string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		source := key
+		if source == "" {
+			source = b.Tags[tagEncryptionKeySource]
+		}
+		if source == "" {
+			source = configuredKey(cfg, cfg.BackupDefaults(b.Database, string(b.DatabaseType), b.Tags).EncryptionKeyFile)
+		}
+		if source == "" {
+			return nil, fmt.Errorf("%s is encrypted and no encryption key is configured: pass it with --encryption-key", b.ID)
+		}
+		return readEncryptionKey(ctx, cfg, source)
+	}
+}
+
+// decryptArtifact returns the artifact at path, read from r, decrypted
+// when it is encrypted. Artifacts encrypted whole by earlier releases have
+// no header to recognise them by and are told apart by their .enc
+// extension.
+func decryptArtifact(r io.Reader, path string, key func() ([]byte, error)) (io.Reader, bool, error) {
+	plain, encrypted, err := stream.Decrypt(r, key)
+	if err != nil || encrypted || !strings.HasSuffix(path, ".enc") {
+		return plain, encrypted, err
+	}
+	k, err := key()
+	if err != nil {
+		return nil, true, err
+	}
+	plain, err = stream.DecryptLegacy(plain, k)
+	return plain, true, err
+}
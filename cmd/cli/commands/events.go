@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/events"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/metrics"
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/sanskarpan/db-backup/internal/sla"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// newEventBus builds the event bus from config. Event delivery is best
+// effort, so a misconfigured bus is logged and replaced by a no-op bus
+// rather than failing the command.
+func newEventBus(ctx context.Context, cfg *config.Config, log *logger.Logger) *events.Bus {
+	bus, err := events.NewBusFromConfig(ctx, cfg.Events)
+	if err != nil {
+		log.Warn("Event bus disabled", map[string]interface{}{"error": err.Error()})
+		return nil
+	}
+	return bus
+}
+
+// publishEvent publishes an event, logging delivery failures. Events carry
+// the trace and span IDs of the run so consumers can jump to the trace.
+func publishEvent(ctx context.Context, bus *events.Bus, log *logger.Logger, event *events.Event) {
+	for k, v := range telemetry.SpanFields(ctx) {
+		if event.Data == nil {
+			event.Data = make(map[string]interface{})
+		}
+		event.Data[k] = v
+	}
+	if err := bus.Publish(ctx, event); err != nil {
+		log.Warn("Failed to publish event", map[string]interface{}{
+			"type":  string(event.Type),
+			"error": err.Error(),
+		})
+	}
+}
+
+// publishAlert publishes an alert.raised event for the notification n
+// announcing an alert raised by source, e.g. freshness or ransomware
+func publishAlert(ctx context.Context, cfg *config.Config, log *logger.Logger, source string, n *notification.Notification) {
+	bus := newEventBus(ctx, cfg, log)
+	defer bus.Close()
+	publishEvent(ctx, bus, log, events.NewAlert(source, n))
+}
+
+// newNotificationManager builds the notification channels, routed through
+// the persistent delivery queue when it is enabled
+func newNotificationManager(cfg *config.Config) (*notification.Manager, error) {
+	manager := notification.NewManager(cfg.Notifications)
+	if cfg.Notifications.Queue.Enabled && len(manager.Notifiers()) > 0 {
+		queue, err := notification.NewQueue(cfg.Notifications.Queue, manager.Notifiers())
+		if err != nil {
+			return nil, err
+		}
+		manager.SetQueue(queue)
+	}
+	return manager, nil
+}
+
+// sendNotification delivers a notification to every enabled channel,
+// logging delivery failures. Queued deliveries get an immediate first
+// attempt; anything that fails stays queued for `db-backup notify flush`
+// or the API server to retry.
+func sendNotification(ctx context.Context, cfg *config.Config, log *logger.Logger, n *notification.Notification) {
+	manager, err := newNotificationManager(cfg)
+	if err == nil {
+		err = manager.Send(ctx, n)
+	}
+	if err == nil && manager.Queue() != nil {
+		_, err = manager.Queue().ProcessDue(ctx)
+	}
+	if err != nil {
+		log.Warn("Failed to send notification", map[string]interface{}{
+			"title": n.Title,
+			"error": err.Error(),
+		})
+	}
+}
+
+// logHeartbeatError logs a failed dead-man's-switch ping. Pings never fail
+// the backup itself.
+func logHeartbeatError(log *logger.Logger, err error) {
+	if err != nil {
+		log.Warn("Heartbeat ping failed", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// pushBackupMetrics records a backup run with the configured metrics
+// backend: StatsD, or a Pushgateway for Prometheus since a CLI run has no
+// long-lived /metrics endpoint. Like events, metrics never fail the backup.
+func pushBackupMetrics(ctx context.Context, cfg *config.Config, log *logger.Logger, result metrics.BackupResult) {
+	if !cfg.Metrics.Enabled {
+		return
+	}
+
+	if cfg.Metrics.Backend == "statsd" {
+		sd, err := metrics.NewStatsD(cfg.Metrics.StatsD)
+		if err == nil {
+			sd.RecordBackup(result)
+			err = sd.Close()
+		}
+		if err != nil {
+			log.Warn("Failed to send metrics", map[string]interface{}{
+				"address": cfg.Metrics.StatsD.Address,
+				"error":   err.Error(),
+			})
+		}
+		return
+	}
+
+	pg := cfg.Metrics.Pushgateway
+	if !pg.Enabled {
+		return
+	}
+	m := metrics.New()
+	m.RecordBackup(result)
+	if err := m.Push(ctx, pg, map[string]string{"database": result.Database}); err != nil {
+		log.Warn("Failed to push metrics", map[string]interface{}{
+			"url":   pg.URL,
+			"error": err.Error(),
+		})
+	}
+}
+
+// recordRecoveryPoint records a successful backup for RPO tracking. Like
+// metrics, SLA bookkeeping never fails the backup.
+func recordRecoveryPoint(cfg *config.Config, log *logger.Logger, database string, at time.Time) {
+	if !cfg.SLA.Enabled {
+		return
+	}
+	if err := sla.New(cfg.SLA).RecordBackup(database, at, false); err != nil {
+		log.Warn("Failed to record recovery point", map[string]interface{}{
+			"database": database,
+			"error":    err.Error(),
+		})
+	}
+}
+
+// setupTelemetry starts OpenTelemetry export for this run. Like events,
+// telemetry is best effort: setup failures are logged and the run continues
+// without it. The returned shutdown func flushes buffered data.
+func setupTelemetry(ctx context.Context, cfg *config.Config, log *logger.Logger, attrs ...attribute.KeyValue) (*telemetry.Telemetry, func()) {
+	tel, err := telemetry.Setup(ctx, cfg.Tracing, Version, attrs...)
+	if err != nil {
+		log.Warn("Telemetry export disabled", map[string]interface{}{"error": err.Error()})
+	}
+	return tel, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := tel.Shutdown(shutdownCtx); err != nil {
+			log.Warn("Failed to flush telemetry", map[string]interface{}{"error": err.Error()})
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/metrics"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/sanskarpan/db-backup/internal/sla"
+	"github.com/spf13/cobra"
+)
+
+// exporterCmd serves catalog metrics without the API server
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Serve Prometheus metrics derived from the backup catalog",
+	Long: `Serve Prometheus metrics derived from the backup catalog, for hosts
+that run backups from cron or the scheduler but not the API server.
+
+The catalog is read on every scrape, so the metrics follow backups taken by
+any process sharing the metadata directory:
+
+  dbbackup_catalog_last_success_timestamp_seconds
+  dbbackup_catalog_last_success_age_seconds
+  dbbackup_catalog_last_failure_timestamp_seconds
+  dbbackup_catalog_backups{status="success|failure"}
+  dbbackup_catalog_backup_size_bytes
+  dbbackup_catalog_backup_compressed_size_bytes
+  dbbackup_catalog_stored_bytes
+
+With SLA tracking enabled, dbbackup_catalog_verification_status tells
+whether the newest successful backup of each database is verified.
+
+Examples:
+  # Serve on metrics.prometheus.port and path
+  db-backup exporter
+
+  # Alert when a database has no successful backup for a day
+  #   dbbackup_catalog_last_success_age_seconds > 86400
+  db-backup exporter --port 9187 --path /metrics`,
+	RunE: runExporter,
+}
+
+func init() {
+	rootCmd.AddCommand(exporterCmd)
+
+	exporterCmd.Flags().Int("port", 0, "port to listen on (default metrics.prometheus.port)")
+	exporterCmd.Flags().String("path", "", "metrics path (default metrics.prometheus.path)")
+}
+
+func runExporter(cmd *cobra.Command, args []string) error {
+	cfg := GetConfig()
+	log := GetLogger()
+
+	promCfg := cfg.Metrics.Prometheus
+	if port, _ := cmd.Flags().GetInt("port"); port != 0 {
+		promCfg.Port = port
+	}
+	if path, _ := cmd.Flags().GetString("path"); path != "" {
+		promCfg.Path = path
+	}
+	if promCfg.Port == 0 {
+		return fmt.Errorf("no port to listen on: set metrics.prometheus.port or --port")
+	}
+
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+	var tracker *sla.Tracker
+	if cfg.SLA.Enabled {
+		tracker = sla.New(cfg.SLA)
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m := metrics.New()
+	if err := m.WatchCatalog(func() ([]metrics.CatalogStatus, error) {
+		return catalogStatus(ctx, repo.List, tracker)
+	}); err != nil {
+		return err
+	}
+	log.Info("Serving catalog metrics", map[string]interface{}{
+		"port":      promCfg.Port,
+		"path":      exporterPath(promCfg),
+		"directory": cfg.Backup.MetadataDirectory,
+	})
+	return m.Serve(ctx, promCfg)
+}
+
+// catalogStatus summarises the catalog, with the verifications recorded
+// by the SLA tracker when there is one
+func catalogStatus(ctx context.Context, list func(context.Context, *repository.ListFilter) ([]*models.BackupMetadata, error), tracker *sla.Tracker) ([]metrics.CatalogStatus, error) {
+	backups, err := list(ctx, &repository.ListFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	entries := make([]metrics.CatalogEntry, 0, len(backups))
+	for _, b := range backups {
+		// Backups still running are not counted
+		if b.Status != models.BackupStatusCompleted && b.Status != models.BackupStatusFailed {
+			continue
+		}
+		entries = append(entries, catalogEntry(b))
+	}
+
+	var verified map[string]time.Time
+	if tracker != nil {
+		if verified, err = tracker.LastVerified(); err != nil {
+			return nil, err
+		}
+	}
+	return metrics.SummarizeCatalog(entries, verified), nil
+}
+
+// catalogEntry returns the metrics view of a finished backup
+func catalogEntry(b *models.BackupMetadata) metrics.CatalogEntry {
+	return metrics.CatalogEntry{
+		Database:       b.Database,
+		Type:           string(b.DatabaseType),
+		Success:        b.Status == models.BackupStatusCompleted,
+		Size:           b.Size,
+		CompressedSize: b.CompressedSize,
+		StartedAt:      b.StartTime,
+		FinishedAt:     b.EndTime,
+	}
+}
+
+// exporterPath is the path the metrics are served on
+func exporterPath(cfg config.PrometheusConfig) string {
+	if cfg.Path == "" {
+		return "/metrics"
+	}
+	return cfg.Path
+}
@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/freshness"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/spf13/cobra"
+)
+
+// freshnessCmd groups the backup freshness watchdog commands
+var freshnessCmd = &cobra.Command{
+	Use:   "freshness",
+	Short: "Alert when a database goes too long without a successful backup",
+	Long: `Watch how long each database has gone without a successful backup.
+
+The expected interval of a database is the median gap between its recent
+backups in the catalog, or freshness.expected.<database> when set, with
+freshness.expected.default for databases without enough history. A
+database is stale once freshness.tolerance intervals pass without a
+successful backup. Databases named under freshness.expected are stale
+when they have never been backed up at all.
+
+Only the catalog is looked at, never the schedules, so a disabled, deleted
+or misconfigured schedule raises the same alert as a failing one. Run the
+check from cron, or keep "freshness watch" running, on a host other than
+the scheduler's.
+
+Examples:
+  # Show the freshness of every database
+  db-backup freshness status
+
+  # Alert on databases going stale or fresh again (cron, every 5 minutes)
+  db-backup freshness check
+
+  # Check every freshness.check_interval until stopped
+  db-backup freshness watch`,
+}
+
+// freshnessStatusCmd prints the freshness of every database
+var freshnessStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the time since the last successful backup of every database",
+	RunE:  runFreshnessStatus,
+}
+
+// freshnessCheckCmd sends alerts for databases going stale or fresh again
+var freshnessCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Notify about databases that went stale or were backed up again since the last check",
+	RunE:  runFreshnessCheck,
+}
+
+// freshnessWatchCmd keeps checking until interrupted
+var freshnessWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Check freshness every check interval until stopped",
+	RunE:  runFreshnessWatch,
+}
+
+func init() {
+	rootCmd.AddCommand(freshnessCmd)
+	freshnessCmd.AddCommand(freshnessStatusCmd)
+	freshnessCmd.AddCommand(freshnessCheckCmd)
+	freshnessCmd.AddCommand(freshnessWatchCmd)
+
+	freshnessStatusCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+
+	freshnessCheckCmd.Flags().Bool("fail-on-stale", false, "exit non-zero while any database is stale")
+}
+
+// freshnessSource lists the completed backups of the catalog
+func freshnessSource(list func(context.Context, *repository.ListFilter) ([]*models.BackupMetadata, error)) freshness.Source {
+	return func(ctx context.Context) ([]freshness.Backup, error) {
+		backups, err := list(ctx, &repository.ListFilter{})
+		if err != nil {
+			return nil, err
+		}
+		out := make([]freshness.Backup, 0, len(backups))
+		for _, b := range backups {
+			if b.Status != models.BackupStatusCompleted {
+				continue
+			}
+			at := b.EndTime
+			if at.IsZero() {
+				at = b.StartTime
+			}
+			out = append(out, freshness.Backup{Database: b.Database, Time: at})
+		}
+		return out, nil
+	}
+}
+
+// freshnessWatchdog returns the configured watchdog over the catalog
+func freshnessWatchdog() (*freshness.Watchdog, error) {
+	cfg := GetConfig()
+	if !cfg.Freshness.Enabled {
+		return nil, fmt.Errorf("the freshness watchdog is not enabled (freshness.enabled)")
+	}
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+	return freshness.New(cfg.Freshness, freshnessSource(repo.List)), nil
+}
+
+func runFreshnessStatus(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+
+	w, err := freshnessWatchdog()
+	if err != nil {
+		return err
+	}
+	statuses, err := w.Status(context.Background(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(statuses)
+	case "yaml", "yml":
+		return printYAMLValue(statuses)
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("No backups in the catalog yet.")
+		return nil
+	}
+	fmt.Printf("%-24s %-14s %-14s %-8s %s\n", "DATABASE", "LAST BACKUP", "EXPECTED", "SOURCE", "STATUS")
+	for _, s := range statuses {
+		age := "never"
+		if s.HasBackup() {
+			age = formatObjective(s.Age) + " ago"
+		}
+		source := "config"
+		if s.Learned {
+			source = "learned"
+		}
+		status := "ok"
+		switch {
+		case s.Expected <= 0:
+			status, source = "unknown", "-"
+		case s.Stale:
+			status = "STALE"
+		}
+		fmt.Printf("%-24s %-14s %-14s %-8s %s\n", truncate(s.Database, 24), age, formatObjective(s.Expected), source, status)
+	}
+	return nil
+}
+
+func runFreshnessCheck(cmd *cobra.Command, args []string) error {
+	failOnStale, _ := cmd.Flags().GetBool("fail-on-stale")
+
+	log := GetLogger()
+	cfg := GetConfig()
+
+	w, err := freshnessWatchdog()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	now := time.Now()
+	alerts, err := w.Check(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, a := range alerts {
+		n := a.Notification()
+		log.Warn(n.Title, map[string]interface{}{
+			"database":  a.Status.Database,
+			"recovered": a.Recovered,
+			"reminder":  a.Reminder,
+		})
+		sendNotification(ctx, cfg, log, n)
+		if !a.Recovered {
+			publishAlert(ctx, cfg, log, "freshness", n)
+		}
+	}
+	fmt.Printf("✓ Checked backup freshness, %d alert(s) sent\n", len(alerts))
+
+	if failOnStale {
+		statuses, err := w.Status(ctx, now)
+		if err != nil {
+			return err
+		}
+		var stale []string
+		for _, s := range statuses {
+			if s.Stale {
+				stale = append(stale, s.Database)
+			}
+		}
+		if len(stale) > 0 {
+			return fmt.Errorf("no recent backup of: %s", strings.Join(stale, ", "))
+		}
+	}
+	return nil
+}
+
+func runFreshnessWatch(cmd *cobra.Command, args []string) error {
+	log := GetLogger()
+	cfg := GetConfig()
+
+	w, err := freshnessWatchdog()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info("Watching backup freshness", map[string]interface{}{
+		"interval": cfg.Freshness.CheckInterval.String(),
+	})
+	return w.Run(ctx, func(ctx context.Context, n *notification.Notification) error {
+		log.Warn(n.Title, map[string]interface{}{"database": n.Database})
+		sendNotification(ctx, cfg, log, n)
+		// Recoveries are announced but raise no alert
+		if n.Event != notification.EventSuccess {
+			publishAlert(ctx, cfg, log, "freshness", n)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/history"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd charts the run history of a database
+var historyCmd = &cobra.Command{
+	Use:   "history <database>",
+	Short: "Chart backup size, duration and compression ratio over time",
+	Long: `Chart how backup size, duration and compression ratio develop over time
+and highlight runs that break the trend, for capacity planning.
+
+A run is a trend break when it differs from the mean of the preceding
+--window runs by more than --threshold standard deviations and by at least
+--min-change.
+
+Examples:
+  # ASCII charts of the last 30 runs
+  db-backup history orders
+
+  # Three months of history as JSON for a dashboard
+  db-backup history orders --since 2160h --limit 0 --format json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().String("format", "ascii", "output format (ascii|json|yaml)")
+	historyCmd.Flags().Int("limit", 30, "number of most recent runs to analyse (0 for all)")
+	historyCmd.Flags().Duration("since", 0, "only analyse runs within this period (e.g. 720h)")
+	historyCmd.Flags().Int("width", 40, "width of the ASCII bars")
+	historyCmd.Flags().Int("window", 7, "number of preceding runs each run is compared with")
+	historyCmd.Flags().Float64("threshold", 3, "standard deviations from the window mean that count as a break")
+	historyCmd.Flags().Float64("min-change", 0.2, "minimum relative change that counts as a break")
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	limit, _ := cmd.Flags().GetInt("limit")
+	since, _ := cmd.Flags().GetDuration("since")
+	width, _ := cmd.Flags().GetInt("width")
+	opts := history.DefaultOptions()
+	opts.Window, _ = cmd.Flags().GetInt("window")
+	opts.Threshold, _ = cmd.Flags().GetFloat64("threshold")
+	opts.MinChange, _ = cmd.Flags().GetFloat64("min-change")
+
+	cfg := GetConfig()
+	database := args[0]
+
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+
+	// Newest first so --limit keeps the most recent runs
+	filter := &repository.ListFilter{
+		Database:  database,
+		Limit:     limit,
+		SortBy:    "date",
+		SortOrder: "desc",
+	}
+	if since > 0 {
+		from := time.Now().Add(-since)
+		filter.From = &from
+	}
+	backups, err := repo.List(context.Background(), filter)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var points []history.Point
+	for _, b := range backups {
+		// Failed runs have no meaningful size
+		if b.Size <= 0 {
+			continue
+		}
+		p := history.Point{
+			Time:           b.StartTime,
+			BackupID:       b.ID,
+			Size:           b.Size,
+			CompressedSize: b.CompressedSize,
+		}
+		if !b.EndTime.IsZero() && b.EndTime.After(b.StartTime) {
+			p.Duration = b.EndTime.Sub(b.StartTime)
+		}
+		points = append(points, p)
+	}
+	if len(points) == 0 {
+		return fmt.Errorf("no completed backups found for %s", database)
+	}
+
+	report := history.Analyze(database, points, opts)
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(report)
+	case "yaml", "yml":
+		return printYAMLValue(report)
+	default:
+		history.RenderASCII(os.Stdout, report, width)
+		return nil
+	}
+}
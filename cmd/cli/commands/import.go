@@ -0,0 +1,229 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/importer"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	storageplugin "github.com/sanskarpan/db-backup/internal/storage/plugin"
+	"github.com/spf13/cobra"
+)
+
+// importCmd registers backups taken by other tools in the catalog
+var importCmd = &cobra.Command{
+	Use:   "import [directory]",
+	Short: "Register backups taken by other tools",
+	Long: `Scan a directory, or a bucket of a plugin storage provider, for backups
+taken by pgBackRest, wal-g or mysqldump and register each one in the
+catalog, so it is listed, restorable and kept under retention like a
+backup db-backup took. Nothing is copied: the catalog entry points at the
+backup where it is.
+
+The engine, database, timestamps and sizes come from the tool's own
+metadata. pgBackRest and wal-g backups are identified by the checksum of
+their manifest or sentinel; dumps are read in full to checksum them and
+to check they are complete. Importing the same backup again updates its
+entry instead of adding another.
+
+Examples:
+  # Show what would be imported from a pgBackRest repository
+  db-backup import /var/lib/pgbackrest --dry-run
+
+  # Import the dumps of a directory
+  db-backup import /srv/dumps
+
+  # Import a wal-g bucket served by the "s3-archive" plugin provider
+  db-backup import --provider s3-archive --prefix wal-g/`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runImport,
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().String("provider", "", "scan this plugin storage provider instead of a directory")
+	importCmd.Flags().String("prefix", "", "scan the objects under this prefix of --provider")
+	importCmd.Flags().String("database", "", "record the backups under this database name")
+	importCmd.Flags().Bool("dry-run", false, "list the backups found without registering them")
+	importCmd.Flags().String("format", "table", "output format (table|json)")
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	providerName, _ := cmd.Flags().GetString("provider")
+	prefix, _ := cmd.Flags().GetString("prefix")
+	dbName, _ := cmd.Flags().GetString("database")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	format, _ := cmd.Flags().GetString("format")
+
+	log := GetLogger()
+	cfg := GetConfig()
+	ctx := context.Background()
+
+	var src importer.Source
+	switch {
+	case providerName != "" && len(args) > 0:
+		return fmt.Errorf("give either a directory or --provider, not both")
+	case providerName != "":
+		pc, ok := cfg.Storage.Providers.Plugins[providerName]
+		if !ok || !pc.Enabled {
+			return fmt.Errorf("no enabled plugin provider %s in storage.providers.plugins", providerName)
+		}
+		plugins, err := storageplugin.Find(cfg.Plugins.Directory)
+		if err != nil {
+			return fmt.Errorf("plugins.directory: %w", err)
+		}
+		path := plugins[providerName]
+		if path == "" {
+			return fmt.Errorf("no %s%s in %s", storageplugin.Prefix, providerName, cfg.Plugins.Directory)
+		}
+		provider, err := storageplugin.Open(ctx, providerName, path, pc.Options, cfg.Plugins.HandshakeTimeout)
+		if err != nil {
+			return err
+		}
+		defer provider.Close()
+		src = importer.Provider(providerName, provider, prefix)
+	case len(args) > 0:
+		if prefix != "" {
+			return fmt.Errorf("--prefix only applies to --provider")
+		}
+		src = importer.Dir(args[0])
+	default:
+		return fmt.Errorf("give the directory to scan, or --provider")
+	}
+
+	artifacts, problems, err := importer.Scan(ctx, src)
+	if err != nil {
+		return fmt.Errorf("failed to scan: %w", err)
+	}
+	if dbName != "" {
+		for _, a := range artifacts {
+			a.Database = dbName
+		}
+	}
+
+	if strings.ToLower(format) == "json" {
+		if err := printJSONValue(map[string]interface{}{
+			"artifacts": artifacts,
+			"problems":  problems,
+			"dry_run":   dryRun,
+		}); err != nil {
+			return err
+		}
+	} else {
+		printImportTable(artifacts, problems)
+	}
+	if dryRun || len(artifacts) == 0 {
+		return nil
+	}
+
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+	imported := 0
+	for _, a := range artifacts {
+		if a.Database == "" {
+			log.Warn("Skipping backup with no database name, use --database", map[string]interface{}{
+				"location": a.Location,
+			})
+			continue
+		}
+		metadata := importMetadata(src.Name(), a)
+		if err := repo.Save(ctx, metadata); err != nil {
+			return fmt.Errorf("failed to save metadata of %s: %w", a.Location, err)
+		}
+		recordCatalogEntry(cfg, log, metadata.ID)
+		recordAudit(cfg, log, cliActor(), audit.ActionBackupImported, metadata.ID, map[string]string{
+			"database": metadata.Database,
+			"format":   a.Format,
+			"checksum": metadata.Checksum,
+			"location": metadata.BackupPath,
+		})
+		imported++
+	}
+	if strings.ToLower(format) != "json" {
+		fmt.Printf("✓ Imported %d of %d backup(s)\n", imported, len(artifacts))
+	}
+	if imported < len(artifacts) {
+		return fmt.Errorf("%d backup(s) have no database name, give one with --database", len(artifacts)-imported)
+	}
+	return nil
+}
+
+// importMetadata returns the catalog entry of a backup found by the
+// importer in the storage named source. Tool-specific details are kept in
+// import.* tags.
+func importMetadata(source string, a *importer.Artifact) *models.BackupMetadata {
+	tags := map[string]string{"import.format": a.Format}
+	for k, v := range map[string]string{
+		"import.label":       a.Label,
+		"import.type":        a.Type,
+		"import.prior":       a.Prior,
+		"import.version":     a.Version,
+		"import.compression": a.Compression,
+	} {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+	name := a.Label
+	if name == "" {
+		name = a.Location[strings.LastIndexAny(a.Location, `/\`)+1:]
+	}
+	compressed := a.StoredSize
+	if compressed == 0 {
+		compressed = a.Size
+	}
+	return &models.BackupMetadata{
+		ID:             a.ID(source),
+		Name:           name,
+		Database:       a.Database,
+		DatabaseType:   database.DatabaseType(a.Engine),
+		StorageType:    source,
+		BackupPath:     a.Location,
+		Size:           a.Size,
+		CompressedSize: compressed,
+		Checksum:       a.Checksum,
+		StartTime:      a.StartTime,
+		EndTime:        a.EndTime,
+		Status:         models.BackupStatusCompleted,
+		Tags:           tags,
+	}
+}
+
+// printImportTable prints the backups found and those that could not be
+// read
+func printImportTable(artifacts []*importer.Artifact, problems []importer.Problem) {
+	if len(artifacts) == 0 {
+		fmt.Println("No backups found.")
+	} else {
+		fmt.Println("FORMAT       DATABASE       TYPE       SIZE        DATE                  LOCATION")
+		fmt.Println("────────────────────────────────────────────────────────────────────────────────────────────────────────────────")
+		for _, a := range artifacts {
+			kind := a.Engine
+			if a.Type != "" {
+				kind += "/" + a.Type
+			}
+			fmt.Printf("%-12s %-14s %-10s %-11s %-21s %s\n",
+				a.Format,
+				truncate(a.Database, 14),
+				truncate(kind, 10),
+				formatBytes(a.Size),
+				a.StartTime.Local().Format("2006-01-02 15:04:05"),
+				a.Location,
+			)
+		}
+		fmt.Println()
+	}
+	for _, p := range problems {
+		fmt.Printf("✗ %s: %s\n", p.Location, p.Error)
+	}
+	if len(problems) > 0 {
+		fmt.Println()
+	}
+}
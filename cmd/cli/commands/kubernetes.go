@@ -0,0 +1,237 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/execdump"
+	"github.com/sanskarpan/db-backup/internal/kubernetes"
+	"github.com/sanskarpan/db-backup/pkg/redact"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// kubernetesCmd groups the pod discovery commands
+var kubernetesCmd = &cobra.Command{
+	Use:     "kubernetes",
+	Aliases: []string{"k8s"},
+	Short:   "Discover and dump database pods",
+	Long: `Find database pods through the Kubernetes API and dump them through a pod
+exec, using the dump tools shipped in their image. Pods are recognised like
+docker containers: by image or a db-backup.type annotation or label, with
+db-backup.database, db-backup.user and db-backup.password-env overriding
+what is read from the container's env, and db-backup.container picking the
+container of pods running several database images.
+
+The password is read by a shell inside the pod from the variable the image
+uses, so secrets never leave the cluster. Variables set through envFrom are
+not visible in the pod spec; name them with db-backup.password-env.
+
+With --snapshot, the persistent volume claims the database container mounts
+are also captured as CSI VolumeSnapshots once the dump is complete. They
+are annotated with the SHA-256 of the dump so the two copies can be matched.
+
+The account used needs get and list on pods, create and get on pods/exec,
+and, for --snapshot, create and get on volumesnapshots.snapshot.storage.k8s.io.
+
+Examples:
+  # List the database pods of every namespace
+  db-backup kubernetes list
+
+  # Dump a StatefulSet pod and snapshot its volume
+  db-backup kubernetes dump shop/postgres-0 --output shop.dump --snapshot
+
+  # Dump the mysql container of a pod in the current namespace to stdout
+  db-backup k8s dump orders-db-7d9f --container mysql --output - | gzip > orders.sql.gz`,
+}
+
+// kubernetesListCmd prints the discovered pods
+var kubernetesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List database pods",
+	RunE:  runKubernetesList,
+}
+
+// kubernetesDumpCmd dumps a pod through a pod exec
+var kubernetesDumpCmd = &cobra.Command{
+	Use:   "dump [namespace/]<pod>",
+	Short: "Dump a database pod through a pod exec",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runKubernetesDump,
+}
+
+func init() {
+	rootCmd.AddCommand(kubernetesCmd)
+	kubernetesCmd.AddCommand(kubernetesListCmd)
+	kubernetesCmd.AddCommand(kubernetesDumpCmd)
+
+	kubernetesCmd.PersistentFlags().String("kubeconfig", "", "kubeconfig file (overrides kubernetes.kubeconfig)")
+	kubernetesCmd.PersistentFlags().String("context", "", "kubeconfig context (overrides kubernetes.context)")
+
+	kubernetesListCmd.Flags().StringP("namespace", "n", "", "namespace to search (overrides kubernetes.namespace)")
+	kubernetesListCmd.Flags().StringP("selector", "l", "", "label selector (overrides kubernetes.label_selector)")
+	kubernetesListCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+
+	kubernetesDumpCmd.Flags().StringP("output", "o", "", "file to write the dump to, - for stdout")
+	kubernetesDumpCmd.Flags().StringP("container", "c", "", "database container of the pod")
+	kubernetesDumpCmd.Flags().StringP("database", "d", "", "database to dump (defaults to the pod's)")
+	kubernetesDumpCmd.Flags().StringSlice("tables", nil, "specific tables to dump")
+	kubernetesDumpCmd.Flags().StringSlice("exclude-tables", nil, "tables to exclude from the dump")
+	kubernetesDumpCmd.Flags().Bool("snapshot", false, "also snapshot the pod's volumes after the dump")
+	kubernetesDumpCmd.MarkFlagRequired("output")
+}
+
+// kubernetesClient connects to the configured cluster
+func kubernetesClient(cmd *cobra.Command) (*kubernetes.Client, error) {
+	cfg := GetConfig().Kubernetes
+	kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+	if kubeconfig == "" {
+		kubeconfig = cfg.Kubeconfig
+	}
+	kubeContext, _ := cmd.Flags().GetString("context")
+	if kubeContext == "" {
+		kubeContext = cfg.Context
+	}
+	return kubernetes.Connect(kubeconfig, kubeContext)
+}
+
+func runKubernetesList(cmd *cobra.Command, args []string) error {
+	cfg := GetConfig().Kubernetes
+	format, _ := cmd.Flags().GetString("format")
+	namespace, _ := cmd.Flags().GetString("namespace")
+	if namespace == "" {
+		namespace = cfg.Namespace
+	}
+	selector, _ := cmd.Flags().GetString("selector")
+	if selector == "" {
+		selector = cfg.LabelSelector
+	}
+
+	client, err := kubernetesClient(cmd)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	targets, err := client.Discover(ctx, namespace, selector)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(targets)
+	case "yaml", "yml":
+		return printYAMLValue(targets)
+	}
+
+	if len(targets) == 0 {
+		fmt.Println("No database pods found.")
+		return nil
+	}
+	fmt.Printf("%-36s %-16s %-9s %-16s %-16s %s\n", "POD", "CONTAINER", "TYPE", "DATABASE", "USER", "VOLUMES")
+	for _, t := range targets {
+		fmt.Printf("%-36s %-16s %-9s %-16s %-16s %s\n", truncate(t.Name(), 36), truncate(t.Container, 16), t.Type,
+			truncate(t.Database, 16), truncate(t.User, 16), strings.Join(t.PVCs, ","))
+	}
+	return nil
+}
+
+func runKubernetesDump(cmd *cobra.Command, args []string) error {
+	cfg := GetConfig().Kubernetes
+	output, _ := cmd.Flags().GetString("output")
+	container, _ := cmd.Flags().GetString("container")
+	dbName, _ := cmd.Flags().GetString("database")
+	tables, _ := cmd.Flags().GetStringSlice("tables")
+	excludeTables, _ := cmd.Flags().GetStringSlice("exclude-tables")
+	snapshot, _ := cmd.Flags().GetBool("snapshot")
+
+	client, err := kubernetesClient(cmd)
+	if err != nil {
+		return err
+	}
+	namespace, pod, ok := strings.Cut(args[0], "/")
+	if !ok {
+		pod, namespace = namespace, cfg.Namespace
+		if namespace == "" {
+			namespace = client.Namespace()
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	target, err := client.Find(ctx, namespace, pod, container)
+	if err != nil {
+		return err
+	}
+	redact.AddSecrets(target.Password)
+	if snapshot && len(target.PVCs) == 0 {
+		return fmt.Errorf("container %s of %s mounts no persistent volume claim to snapshot", target.Container, target.Name())
+	}
+
+	// Progress goes to stderr when the dump itself goes to stdout
+	var w, status io.Writer = os.Stdout, os.Stderr
+	var f *os.File
+	if output != "-" {
+		if f, err = os.Create(output); err != nil {
+			return err
+		}
+		defer f.Close()
+		w, status = f, os.Stdout
+	}
+
+	start := time.Now()
+	hw := stream.NewHashWriter(w)
+	err = client.Dump(ctx, target, execdump.Options{
+		Database:      dbName,
+		Tables:        tables,
+		ExcludeTables: excludeTables,
+	}, hw)
+	if err == nil && f != nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		if f != nil {
+			os.Remove(output)
+		}
+		return err
+	}
+
+	fmt.Fprintf(status, "✓ Dumped %s/%s (%s) in %s\n", target.Name(), target.Container, target.Type, time.Since(start).Round(time.Second))
+	fmt.Fprintf(status, "  Size:     %s\n", utils.FormatBytes(hw.Written()))
+	fmt.Fprintf(status, "  Checksum: %s\n", hw.Sum())
+	if !snapshot {
+		return nil
+	}
+
+	snapshots, err := client.Snapshot(ctx, target, kubernetes.SnapshotOptions{
+		Class:      cfg.SnapshotClass,
+		DumpSHA256: hw.Sum(),
+	})
+	if err != nil {
+		return err
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, cfg.SnapshotTimeout)
+	defer cancel()
+	err = client.WaitReady(waitCtx, snapshots, 2*time.Second)
+	for _, s := range snapshots {
+		state := "ready"
+		if !s.ReadyToUse {
+			state = "not ready"
+		}
+		fmt.Fprintf(status, "  Snapshot: %s/%s of %s (%s", s.Namespace, s.Name, s.PVC, state)
+		if s.RestoreSize != "" {
+			fmt.Fprintf(status, ", %s", s.RestoreSize)
+		}
+		fmt.Fprintln(status, ")")
+	}
+	return err
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/sanskarpan/db-backup/internal/config"
 	"github.com/sanskarpan/db-backup/internal/models"
 	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/sanskarpan/db-backup/internal/repository/index"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
@@ -25,6 +29,7 @@ type ListOptions struct {
 	Limit    int
 	Sort     string
 	Order    string
+	Stats    bool
 }
 
 // listCmd represents the list command
@@ -52,7 +57,10 @@ Examples:
   db-backup list --format json
 
   # List and sort by size
-  db-backup list --sort size --order desc --limit 10`,
+  db-backup list --sort size --order desc --limit 10
+
+  # Count and size the production backups per database
+  db-backup list --tags "env=production" --stats`,
 	RunE: runList,
 }
 
@@ -72,6 +80,7 @@ func init() {
 	listCmd.Flags().Int("limit", 50, "limit results")
 	listCmd.Flags().String("sort", "date", "sort by (date|size|name)")
 	listCmd.Flags().String("order", "desc", "sort order (asc|desc)")
+	listCmd.Flags().Bool("stats", false, "summarise the matching backups instead of listing them")
 }
 
 func runList(cmd *cobra.Command, args []string) error {
@@ -88,6 +97,7 @@ func runList(cmd *cobra.Command, args []string) error {
 	opts.Limit, _ = cmd.Flags().GetInt("limit")
 	opts.Sort, _ = cmd.Flags().GetString("sort")
 	opts.Order, _ = cmd.Flags().GetString("order")
+	opts.Stats, _ = cmd.Flags().GetBool("stats")
 
 	// Get logger and config
 	log := GetLogger()
@@ -102,14 +112,14 @@ func runList(cmd *cobra.Command, args []string) error {
 		"limit":    opts.Limit,
 	})
 
-	// Create repository
-	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	// The index answers the listing without reading every metadata file
+	idx, err := openIndex(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create repository: %w", err)
+		return fmt.Errorf("failed to read backup index: %w", err)
 	}
 
 	// Build filter
-	filter := &repository.ListFilter{
+	filter := &index.Filter{
 		Database:     opts.Database,
 		DatabaseType: opts.Type,
 		StorageType:  opts.Storage,
@@ -140,24 +150,126 @@ func runList(cmd *cobra.Command, args []string) error {
 		filter.Tags = parseTags(opts.Tags)
 	}
 
-	// List backups
-	backups, err := repo.List(ctx, filter)
-	if err != nil {
-		return fmt.Errorf("failed to list backups: %w", err)
+	format := strings.ToLower(opts.Format)
+	if opts.Stats {
+		stats := idx.Stats(filter)
+		switch format {
+		case "json":
+			return printJSONValue(stats)
+		case "yaml", "yml":
+			return printYAMLValue(stats)
+		default:
+			printStats(stats)
+			return nil
+		}
 	}
 
+	entries := idx.List(filter)
+
 	// Display results based on format
-	switch strings.ToLower(opts.Format) {
-	case "json":
-		return printJSON(backups)
-	case "yaml", "yml":
+	switch format {
+	case "json", "yaml", "yml":
+		// Machine-readable output keeps the full metadata of each backup
+		backups, err := loadMetadata(ctx, cfg, entries)
+		if err != nil {
+			return err
+		}
+		if format == "json" {
+			return printJSON(backups)
+		}
 		return printYAML(backups)
 	default:
-		return printTable(backups)
+		quarantined, err := quarantinedBackups(cfg)
+		if err != nil {
+			log.Warn("Failed to read quarantine", map[string]interface{}{"error": err.Error()})
+		}
+		drCopies, err := drCopied(cfg)
+		if err != nil {
+			log.Warn("Failed to read DR copy state", map[string]interface{}{"error": err.Error()})
+		}
+		return printTable(entries, quarantined, drCopies)
+	}
+}
+
+// openIndex opens the index of the metadata directory and brings it up to
+// date, reading only the metadata files changed since the last run
+func openIndex(cfg *config.Config) (*index.Index, error) {
+	idx, err := index.Open(cfg.Backup.MetadataDirectory, decodeIndexEntry)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := idx.Refresh(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// decodeIndexEntry extracts the index entry of a backup metadata file.
+// Other JSON files in the directory have no ID and are skipped.
+func decodeIndexEntry(data []byte) (index.Entry, error) {
+	var b models.BackupMetadata
+	if err := json.Unmarshal(data, &b); err != nil {
+		return index.Entry{}, err
+	}
+	if b.ID == "" {
+		return index.Entry{}, fmt.Errorf("not backup metadata")
+	}
+	return index.Entry{
+		ID:             b.ID,
+		Name:           b.Name,
+		Database:       b.Database,
+		DatabaseType:   string(b.DatabaseType),
+		StorageType:    b.StorageType,
+		Status:         string(b.Status),
+		Size:           b.Size,
+		CompressedSize: b.CompressedSize,
+		StartTime:      b.StartTime,
+		Tags:           b.Tags,
+	}, nil
+}
+
+// loadMetadata reads the full metadata of the listed backups
+func loadMetadata(ctx context.Context, cfg *config.Config, entries []index.Entry) ([]*models.BackupMetadata, error) {
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+	backups := make([]*models.BackupMetadata, 0, len(entries))
+	for _, e := range entries {
+		b, err := repo.Get(ctx, e.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup %s: %w", e.ID, err)
+		}
+		backups = append(backups, b)
 	}
+	return backups, nil
 }
 
-func printTable(backups []*models.BackupMetadata) error {
+// printStats prints the summary of the matching backups
+func printStats(s index.Stats) {
+	fmt.Printf("Backups:          %d\n", s.Count)
+	if s.Count == 0 {
+		return
+	}
+	fmt.Printf("Total size:       %s\n", formatBytes(s.TotalSize))
+	fmt.Printf("Compressed size:  %s\n", formatBytes(s.CompressedSize))
+	fmt.Printf("Oldest:           %s\n", s.Oldest.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Newest:           %s\n", s.Newest.Format("2006-01-02 15:04:05"))
+	fmt.Println()
+	fmt.Println("DATABASE                 BACKUPS  SIZE")
+	databases := make([]string, 0, len(s.ByDatabase))
+	for db := range s.ByDatabase {
+		databases = append(databases, db)
+	}
+	sort.Strings(databases)
+	for _, db := range databases {
+		fmt.Printf("%-24s %7d  %s\n", truncate(db, 24), s.ByDatabase[db], formatBytes(s.SizeByDatabase[db]))
+	}
+}
+
+// printTable prints backups as a table, marking quarantined ones. With DR
+// copies enabled, a DR column counts the jobs holding a copy of each.
+func printTable(backups []index.Entry, quarantined map[string]bool, drCopies map[string]int) error {
 	if len(backups) == 0 {
 		fmt.Println("No backups found.")
 		return nil
@@ -165,18 +277,34 @@ func printTable(backups []*models.BackupMetadata) error {
 
 	fmt.Println("Available backups:")
 	fmt.Println()
-	fmt.Println("ID                                     DATABASE       TYPE       SIZE        DATE                  STATUS")
+	header := "ID                                     DATABASE       TYPE       SIZE        DATE                  STATUS"
+	if drCopies != nil {
+		header += "        DR"
+	}
+	fmt.Println(header)
 	fmt.Println("────────────────────────────────────────────────────────────────────────────────────────────────────────────────")
 
 	for _, b := range backups {
-		fmt.Printf("%-38s %-14s %-10s %-11s %-21s %s\n",
+		status := b.Status
+		if quarantined[b.ID] {
+			status = "QUARANTINED"
+		}
+		line := fmt.Sprintf("%-38s %-14s %-10s %-11s %-21s %s",
 			truncate(b.ID, 38),
 			truncate(b.Database, 14),
-			string(b.DatabaseType),
+			b.DatabaseType,
 			formatBytes(b.Size),
 			b.StartTime.Format("2006-01-02 15:04:05"),
-			string(b.Status),
+			status,
 		)
+		if drCopies != nil {
+			dr := "-"
+			if n := drCopies[b.ID]; n > 0 {
+				dr = fmt.Sprintf("%d", n)
+			}
+			line = fmt.Sprintf("%-112s %s", line, dr)
+		}
+		fmt.Println(line)
 	}
 
 	fmt.Println()
@@ -0,0 +1,237 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/spf13/cobra"
+)
+
+// notifyCmd groups notification commands
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Manage notifications",
+	Long: `Manage notification delivery.
+
+Examples:
+  # Send the email digest if its daily/weekly slot has passed
+  db-backup notify digest
+
+  # Send the digest now, regardless of schedule
+  db-backup notify digest --force
+
+  # Show what the next digest would contain without sending it
+  db-backup notify digest --preview --format json
+
+  # Retry queued deliveries that are due
+  db-backup notify flush
+
+  # Inspect and retry dead-lettered deliveries
+  db-backup notify queue --dead-letters
+  db-backup notify retry ntf_abc123`,
+}
+
+// notifyDigestCmd sends the scheduled email digest
+var notifyDigestCmd = &cobra.Command{
+	Use:   "digest",
+	Short: "Send the scheduled email digest",
+	Long: `Send the batched email digest once its scheduled slot has passed.
+
+Run this frequently (for example every 15 minutes from cron or a systemd
+timer); it only sends when a digest is due according to
+notifications.email.digest.frequency, send_at and weekday.`,
+	RunE: runNotifyDigest,
+}
+
+// notifyFlushCmd delivers due queued notifications
+var notifyFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "Deliver queued notifications that are due for retry",
+	RunE:  runNotifyFlush,
+}
+
+// notifyQueueCmd lists queued and dead-lettered notifications
+var notifyQueueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "List pending or dead-lettered notification deliveries",
+	RunE:  runNotifyQueue,
+}
+
+// notifyRetryCmd requeues a dead-lettered delivery
+var notifyRetryCmd = &cobra.Command{
+	Use:   "retry <delivery-id>",
+	Short: "Move a dead-lettered delivery back to the queue",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runNotifyRetry,
+}
+
+// notifyDiscardCmd deletes a dead-lettered delivery
+var notifyDiscardCmd = &cobra.Command{
+	Use:   "discard <delivery-id>",
+	Short: "Delete a dead-lettered delivery",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runNotifyDiscard,
+}
+
+func init() {
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.AddCommand(notifyDigestCmd)
+	notifyCmd.AddCommand(notifyFlushCmd)
+	notifyCmd.AddCommand(notifyQueueCmd)
+	notifyCmd.AddCommand(notifyRetryCmd)
+	notifyCmd.AddCommand(notifyDiscardCmd)
+
+	notifyDigestCmd.Flags().Bool("force", false, "send the digest even if it is not due")
+	notifyDigestCmd.Flags().Bool("preview", false, "print the pending digest instead of sending it")
+	notifyDigestCmd.Flags().String("format", "html", "preview format (html|json|yaml)")
+
+	notifyQueueCmd.Flags().Bool("dead-letters", false, "list dead-lettered deliveries instead of pending ones")
+	notifyQueueCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+}
+
+func runNotifyDigest(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+	preview, _ := cmd.Flags().GetBool("preview")
+	format, _ := cmd.Flags().GetString("format")
+
+	log := GetLogger()
+	cfg := GetConfig()
+
+	if !cfg.Notifications.Email.Enabled || !cfg.Notifications.Email.Digest.Enabled {
+		return fmt.Errorf("email digest is not enabled (notifications.email.digest.enabled)")
+	}
+
+	digest := notification.NewDigestNotifier(cfg.Notifications.Email)
+	now := time.Now()
+
+	if preview {
+		report, err := digest.Preview(now)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(format) {
+		case "json":
+			return printJSONValue(report)
+		case "yaml", "yml":
+			return printYAMLValue(report)
+		default:
+			fmt.Println(report.HTML())
+			return nil
+		}
+	}
+
+	report, err := digest.Flush(context.Background(), now, force)
+	if err != nil {
+		return fmt.Errorf("failed to send digest: %w", err)
+	}
+	if report == nil {
+		fmt.Println("Digest is not due yet")
+		return nil
+	}
+
+	log.Info("Digest sent", map[string]interface{}{
+		"events":    report.Total,
+		"succeeded": report.Succeeded,
+		"failed":    report.Failed,
+	})
+	fmt.Printf("✓ Digest sent (%d events, %d failed)\n", report.Total, report.Failed)
+	return nil
+}
+
+// notificationQueue returns the configured delivery queue
+func notificationQueue() (*notification.Queue, error) {
+	manager, err := newNotificationManager(GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	if manager.Queue() == nil {
+		return nil, fmt.Errorf("notification queue is not enabled or no channels are configured")
+	}
+	return manager.Queue(), nil
+}
+
+func runNotifyFlush(cmd *cobra.Command, args []string) error {
+	queue, err := notificationQueue()
+	if err != nil {
+		return err
+	}
+
+	delivered, err := queue.ProcessDue(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to process queue: %w", err)
+	}
+	pending, err := queue.Pending()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Delivered %d notification(s), %d still pending\n", delivered, len(pending))
+	return nil
+}
+
+func runNotifyQueue(cmd *cobra.Command, args []string) error {
+	deadLetters, _ := cmd.Flags().GetBool("dead-letters")
+	format, _ := cmd.Flags().GetString("format")
+
+	queue, err := notificationQueue()
+	if err != nil {
+		return err
+	}
+
+	var deliveries []*notification.Delivery
+	if deadLetters {
+		deliveries, err = queue.DeadLetters()
+	} else {
+		deliveries, err = queue.Pending()
+	}
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(deliveries)
+	case "yaml", "yml":
+		return printYAMLValue(deliveries)
+	}
+
+	if len(deliveries) == 0 {
+		fmt.Println("No deliveries found.")
+		return nil
+	}
+	fmt.Printf("%-24s %-14s %-8s %-20s %s\n", "ID", "CHANNEL", "ATTEMPTS", "NEXT ATTEMPT", "LAST ERROR")
+	for _, d := range deliveries {
+		next := d.NextAttempt.Format("2006-01-02 15:04:05")
+		if deadLetters {
+			next = "-"
+		}
+		fmt.Printf("%-24s %-14s %-8d %-20s %s\n", d.ID, d.Channel, d.Attempts, next, truncate(d.LastError, 60))
+	}
+	return nil
+}
+
+func runNotifyRetry(cmd *cobra.Command, args []string) error {
+	queue, err := notificationQueue()
+	if err != nil {
+		return err
+	}
+	if err := queue.Retry(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Delivery %s requeued\n", args[0])
+	return nil
+}
+
+func runNotifyDiscard(cmd *cobra.Command, args []string) error {
+	queue, err := notificationQueue()
+	if err != nil {
+		return err
+	}
+	if err := queue.Discard(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Delivery %s discarded\n", args[0])
+	return nil
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/spf13/cobra"
+)
+
+// oplogCmd groups the oplog archive commands
+var oplogCmd = &cobra.Command{
+	Use:   "oplog",
+	Short: "Archive the MongoDB oplog between full backups",
+	Long: `The oplog archive copies every oplog entry of a replica set off the server as
+it is written, independently of scheduled backups, so a restore can be
+rolled forward to any moment since the last full backup.
+
+The backup login needs to read local.oplog.rs, e.g. through the backup
+role. Entries go to files in the BSON format of mongodump's oplog.bson;
+each checkpoint lists the files closed since the previous one in the
+archive manifest (archive.json) with their checksums. A stopped tail
+carries on from the last entry archived, as long as the oplog still holds
+it.
+
+Pass the archive directory as the oplog_dir metadata of a point-in-time
+restore: the base backup is restored, then the archived entries are
+replayed onto it with mongorestore --oplogReplay up to the target. Take
+full backups with consistent backups on (mongodump --oplog) so the replay
+starts where the backup ends.
+
+Examples:
+  # Archive the oplog of the orders replica set
+  db-backup oplog tail orders --output /backups/orders-oplog`,
+}
+
+// oplogTailCmd copies the oplog until interrupted
+var oplogTailCmd = &cobra.Command{
+	Use:   "tail <profile>",
+	Short: "Copy the oplog into an archive directory",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runOplogTail,
+}
+
+func init() {
+	rootCmd.AddCommand(oplogCmd)
+	oplogCmd.AddCommand(oplogTailCmd)
+
+	oplogTailCmd.Flags().StringP("output", "o", "", "archive directory")
+	oplogTailCmd.Flags().Duration("checkpoint-interval", database.DefaultCheckpointInterval, "record the archive position this often")
+	oplogTailCmd.MarkFlagRequired("output")
+}
+
+func runOplogTail(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	interval, _ := cmd.Flags().GetDuration("checkpoint-interval")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Archiving the oplog into %s (Ctrl-C to stop)\n", output)
+	if err := archiveLogs(ctx, args[0], &database.ArchiveOptions{OutputDir: output, CheckpointInterval: interval}); err != nil {
+		return fmt.Errorf("oplog archiving failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/pitr"
+	"github.com/spf13/cobra"
+)
+
+// pitrCmd groups the WAL archive commands
+var pitrCmd = &cobra.Command{
+	Use:   "pitr",
+	Short: "Keep the PostgreSQL WAL archive for point-in-time restores",
+	Long: `The WAL archive holds every WAL segment written since the oldest physical
+backup kept, so a restore can replay it up to any moment since then.
+
+WAL reaches the archive one of two ways:
+  - pitr receive streams it over a replication connection with
+    pg_receivewal, ideally from a replication slot so nothing is lost while
+    the receiver is down. The backup login needs the REPLICATION attribute.
+  - The server hands over each segment it completes through archive_command,
+    running pitr archive-wal on its own host with archive_mode=on.
+
+Every segment is listed in the archive manifest (archive.json) with its
+checksum. A point-in-time restore of a physical backup (--point-in-time
+with the restore_dir and wal_dir metadata) extracts it and sets it up to
+replay the archive up to the target through pitr restore-wal, then promote
+itself once a server is started on it.
+
+Examples:
+  # Stream the WAL of the orders cluster from a replication slot
+  db-backup pitr receive orders --output /backups/orders-wal --slot db_backup_wal
+
+  # Or let the server archive it, in postgresql.conf
+  archive_command = 'db-backup pitr archive-wal %p --archive /backups/orders-wal'
+
+  # Fetch a segment for a recovering server, in postgresql.conf
+  restore_command = 'db-backup pitr restore-wal %f %p --archive /backups/orders-wal'`,
+}
+
+// pitrReceiveCmd streams WAL until interrupted
+var pitrReceiveCmd = &cobra.Command{
+	Use:   "receive <profile>",
+	Short: "Stream WAL into an archive directory with pg_receivewal",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runPITRReceive,
+}
+
+// pitrArchiveWALCmd is the archive_command helper
+var pitrArchiveWALCmd = &cobra.Command{
+	Use:   "archive-wal <path>",
+	Short: "Copy a completed WAL segment into the archive (archive_command)",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runPITRArchiveWAL,
+}
+
+// pitrRestoreWALCmd is the restore_command helper
+var pitrRestoreWALCmd = &cobra.Command{
+	Use:   "restore-wal <file> <path>",
+	Short: "Copy a WAL segment out of the archive (restore_command)",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runPITRRestoreWAL,
+}
+
+func init() {
+	rootCmd.AddCommand(pitrCmd)
+	pitrCmd.AddCommand(pitrReceiveCmd)
+	pitrCmd.AddCommand(pitrArchiveWALCmd)
+	pitrCmd.AddCommand(pitrRestoreWALCmd)
+
+	pitrReceiveCmd.Flags().StringP("output", "o", "", "archive directory")
+	pitrReceiveCmd.Flags().String("slot", "", "replication slot to stream from, created when missing")
+	pitrReceiveCmd.Flags().Duration("checkpoint-interval", database.DefaultCheckpointInterval, "record the archive position this often")
+	pitrReceiveCmd.MarkFlagRequired("output")
+
+	for _, c := range []*cobra.Command{pitrArchiveWALCmd, pitrRestoreWALCmd} {
+		c.Flags().String("archive", "", "archive directory")
+		c.MarkFlagRequired("archive")
+	}
+}
+
+func runPITRReceive(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	slot, _ := cmd.Flags().GetString("slot")
+	interval, _ := cmd.Flags().GetDuration("checkpoint-interval")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := &database.ArchiveOptions{OutputDir: output, CheckpointInterval: interval}
+	if slot != "" {
+		opts.Options = map[string]string{pitr.OptionSlot: slot}
+	}
+	fmt.Printf("Archiving WAL into %s (Ctrl-C to stop)\n", output)
+	if err := archiveLogs(ctx, args[0], opts); err != nil {
+		return fmt.Errorf("WAL archiving failed: %w", err)
+	}
+	return nil
+}
+
+// runPITRArchiveWAL stays quiet on success: the server logs its output
+func runPITRArchiveWAL(cmd *cobra.Command, args []string) error {
+	archive, _ := cmd.Flags().GetString("archive")
+	if err := pitr.ArchiveWAL(cmd.Context(), args[0], archive); err != nil {
+		return fmt.Errorf("archiving %s failed: %w", args[0], err)
+	}
+	return nil
+}
+
+func runPITRRestoreWAL(cmd *cobra.Command, args []string) error {
+	archive, _ := cmd.Flags().GetString("archive")
+	return pitr.RestoreWAL(cmd.Context(), archive, args[0], args[1])
+}
@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/sanskarpan/db-backup/internal/quota"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/spf13/cobra"
+)
+
+// quotaCmd groups the storage quota commands
+var quotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "Show the storage quotas of tenants and databases",
+	Long: `Show how much of the storage quotas configured under backup.quotas is used.
+
+A backup warns once the usage of a quota it counts against reaches
+backup.quotas.warn_percent, and is refused once the quota is reached.
+
+Examples:
+  # Usage of every quota
+  db-backup quota status
+
+  # As JSON, for scripts
+  db-backup quota status --format json`,
+}
+
+// quotaStatusCmd prints the usage of every quota
+var quotaStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the usage of every storage quota",
+	RunE:  runQuotaStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(quotaCmd)
+	quotaCmd.AddCommand(quotaStatusCmd)
+
+	quotaStatusCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+}
+
+// quotaSource counts the completed backups of the catalog against the
+// quotas, at their stored size
+func quotaSource(list func(context.Context, *repository.ListFilter) ([]*models.BackupMetadata, error)) quota.Source {
+	return func(ctx context.Context) ([]quota.Backup, error) {
+		backups, err := list(ctx, &repository.ListFilter{})
+		if err != nil {
+			return nil, err
+		}
+		out := make([]quota.Backup, 0, len(backups))
+		for _, b := range backups {
+			if b.Status != models.BackupStatusCompleted {
+				continue
+			}
+			size := b.Size
+			if b.CompressedSize > 0 {
+				size = b.CompressedSize
+			}
+			out = append(out, quota.Backup{
+				ID:           b.ID,
+				Database:     b.Database,
+				DatabaseType: string(b.DatabaseType),
+				Size:         size,
+				Tags:         b.Tags,
+			})
+		}
+		return out, nil
+	}
+}
+
+// checkQuota refuses a backup once a quota it counts against is reached,
+// and warns about the quotas past backup.quotas.warn_percent
+func checkQuota(ctx context.Context, cfg *config.Config, log *logger.Logger, list func(context.Context, *repository.ListFilter) ([]*models.BackupMetadata, error), db, dbType string, tags map[string]string) error {
+	if len(cfg.Backup.Quotas.Limits) == 0 {
+		return nil
+	}
+	m, err := quota.New(cfg.Backup.Quotas, quotaSource(list))
+	if err != nil {
+		return err
+	}
+	usages, err := m.Check(ctx, db, dbType, tags)
+	if err != nil {
+		return err
+	}
+	for _, u := range usages {
+		if u.Status != quota.StatusWarning {
+			continue
+		}
+		log.Warn("Storage quota nearly used", map[string]interface{}{
+			"quota":    u.Name,
+			"database": db,
+			"percent":  fmt.Sprintf("%.1f", u.Percent()),
+		})
+		sendNotification(ctx, cfg, log, &notification.Notification{
+			Event:     notification.EventWarning,
+			Title:     fmt.Sprintf("Storage quota %s is %.0f%% used", u.Name, u.Percent()),
+			Message:   u.String(),
+			Database:  db,
+			Severity:  "warning",
+			Timestamp: time.Now().UTC(),
+		})
+	}
+	return nil
+}
+
+func runQuotaStatus(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+
+	cfg := GetConfig()
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+	m, err := quota.New(cfg.Backup.Quotas, quotaSource(repo.List))
+	if err != nil {
+		return err
+	}
+	usages, err := m.Usage(context.Background())
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(usages)
+	case "yaml", "yml":
+		return printYAMLValue(usages)
+	}
+
+	if len(usages) == 0 {
+		fmt.Println("No storage quotas configured (backup.quotas.limits).")
+		return nil
+	}
+	fmt.Printf("%-24s %-24s %-16s %s\n", "QUOTA", "SIZE", "BACKUPS", "STATUS")
+	for _, u := range usages {
+		size := formatBytes(u.Bytes)
+		if u.MaxBytes > 0 {
+			size = fmt.Sprintf("%s / %s", size, formatBytes(u.MaxBytes))
+		}
+		backups := fmt.Sprint(u.Backups)
+		if u.MaxBackups > 0 {
+			backups = fmt.Sprintf("%d / %d", u.Backups, u.MaxBackups)
+		}
+		fmt.Printf("%-24s %-24s %-16s %s\n", truncate(u.Name, 24), size, backups, u.Status)
+	}
+	return nil
+}
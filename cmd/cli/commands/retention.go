@@ -0,0 +1,313 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/apply"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/costopt"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/sanskarpan/db-backup/internal/sla"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// retentionCmd groups the retention cost commands
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Weigh retention and storage tiers against a storage budget",
+	Long: `Recommend retention policies and storage tiers that keep the monthly
+storage cost of the catalog within backup.retention_optimizer.monthly_budget.
+
+The backups of a database older than the ones restored in the past, the
+share backup.retention_optimizer.coverage of them, are moved to the
+cheapest colder tier of their provider that pays off after the expected
+retrievals. While the catalog still costs more than the budget, the
+retention of the databases is shortened where that saves the most, but
+never below the age of the oldest backup ever restored or the RPO of the
+database (sla.objectives). Prices come from
+backup.retention_optimizer.prices.
+
+"db-backup restore" records its restores in the restore history; record
+the others with "retention restored".
+Tier changes are recommendations only; retention changes are applied
+through the server API with --apply or mode: apply.
+
+Examples:
+  # Show the recommendations
+  db-backup retention optimize
+
+  # Write the retention changes for review, then apply them
+  db-backup retention optimize --output retention.yaml
+  db-backup apply -f retention.yaml
+
+  # Record that a backup was restored
+  db-backup retention restored 20260612-020000-orders`,
+}
+
+// retentionOptimizeCmd prints the recommendations
+var retentionOptimizeCmd = &cobra.Command{
+	Use:   "optimize",
+	Short: "Recommend retention and storage tiers within the budget",
+	Args:  cobra.NoArgs,
+	RunE:  runRetentionOptimize,
+}
+
+// retentionRestoredCmd records a restore in the restore history
+var retentionRestoredCmd = &cobra.Command{
+	Use:   "restored <backup-id>",
+	Short: "Record that a backup was restored",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runRetentionRestored,
+}
+
+func init() {
+	rootCmd.AddCommand(retentionCmd)
+	retentionCmd.AddCommand(retentionOptimizeCmd)
+	retentionCmd.AddCommand(retentionRestoredCmd)
+
+	retentionOptimizeCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+	retentionOptimizeCmd.Flags().String("output", "", "write the retention changes to FILE for db-backup apply")
+	retentionOptimizeCmd.Flags().Bool("apply", false, "apply the retention changes (default for mode: apply)")
+	retentionOptimizeCmd.Flags().String("server", os.Getenv("DBBACKUP_SERVER_URL"), "db-backup server URL (default the local server)")
+	retentionOptimizeCmd.Flags().String("api-key-file", "", "file holding the API key (default $DBBACKUP_API_KEY)")
+
+	retentionRestoredCmd.Flags().String("at", "", "when it was restored, RFC 3339 (default now)")
+}
+
+// restoreHistoryPath returns the restore history file
+func restoreHistoryPath(cfg *config.Config) string {
+	if cfg.Backup.RetentionOptimizer.RestoreHistory != "" {
+		return cfg.Backup.RetentionOptimizer.RestoreHistory
+	}
+	return filepath.Join(cfg.Backup.MetadataDirectory, costopt.HistoryFile)
+}
+
+// databaseRPO returns the RPO of a database from sla.objectives. Viper
+// lowercases map keys, so the lookup is case-insensitive.
+func databaseRPO(cfg *config.Config, db string) time.Duration {
+	for _, name := range []string{db, strings.ToLower(db), sla.DefaultObjective} {
+		if o, ok := cfg.SLA.Objectives[name]; ok {
+			return o.RPO
+		}
+	}
+	return 0
+}
+
+// costInput collects the completed backups of the catalog, with the
+// retention and RPO of their databases
+func costInput(ctx context.Context, cfg *config.Config, list func(context.Context, *repository.ListFilter) ([]*models.BackupMetadata, error)) (costopt.Input, error) {
+	opt := cfg.Backup.RetentionOptimizer
+	in := costopt.Input{
+		Now:      time.Now(),
+		Prices:   opt.Prices,
+		Budget:   opt.MonthlyBudget,
+		Coverage: opt.Coverage,
+	}
+	backups, err := list(ctx, &repository.ListFilter{})
+	if err != nil {
+		return in, err
+	}
+	newest := make(map[string]*models.BackupMetadata)
+	for _, b := range backups {
+		if b.Status != models.BackupStatusCompleted {
+			continue
+		}
+		size := b.Size
+		if b.CompressedSize > 0 {
+			size = b.CompressedSize
+		}
+		provider := b.StorageType
+		if provider == "" {
+			provider = cfg.Storage.DefaultProvider
+		}
+		in.Backups = append(in.Backups, costopt.Backup{
+			ID:       b.ID,
+			Database: b.Database,
+			Provider: provider,
+			Size:     size,
+			Time:     b.StartTime,
+		})
+		if n := newest[b.Database]; n == nil || b.StartTime.After(n.StartTime) {
+			newest[b.Database] = b
+		}
+	}
+	for db, b := range newest {
+		r := cfg.BackupDefaults(db, string(b.DatabaseType), b.Tags).Retention
+		in.Databases = append(in.Databases, costopt.Database{
+			Name:      db,
+			Retention: costopt.Retention{Daily: r.Daily, Weekly: r.Weekly, Monthly: r.Monthly},
+			RPO:       databaseRPO(cfg, db),
+		})
+	}
+
+	in.Restores, err = costopt.ReadRestores(restoreHistoryPath(cfg))
+	return in, err
+}
+
+func runRetentionOptimize(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	output, _ := cmd.Flags().GetString("output")
+	doApply, _ := cmd.Flags().GetBool("apply")
+	server, _ := cmd.Flags().GetString("server")
+	keyFile, _ := cmd.Flags().GetString("api-key-file")
+
+	cfg := GetConfig()
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	in, err := costInput(ctx, cfg, repo.List)
+	if err != nil {
+		return err
+	}
+	report := costopt.Analyze(in)
+
+	switch strings.ToLower(format) {
+	case "json":
+		err = printJSONValue(report)
+	case "yaml", "yml":
+		err = printYAMLValue(report)
+	default:
+		printCostReport(report)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The retention changes, as a file for db-backup apply
+	changes := make(map[string]costopt.Retention)
+	for _, rec := range report.Databases {
+		if rec.NewRetention != nil {
+			changes[rec.Database] = *rec.NewRetention
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	data, err := yaml.Marshal(map[string]any{"retention": changes})
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		if err := os.WriteFile(output, data, 0640); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+		fmt.Printf("✓ Retention changes written to %s\n", output)
+	}
+	if !doApply && cfg.Backup.RetentionOptimizer.Mode != "apply" {
+		return nil
+	}
+
+	desired, err := apply.Parse(data)
+	if err != nil {
+		return err
+	}
+	client, err := newApplyClient(server, keyFile)
+	if err != nil {
+		return err
+	}
+	current, err := client.Current(ctx, desired)
+	if err != nil {
+		return err
+	}
+	plan := apply.Plan(desired, current, apply.PlanOptions{})
+	if len(plan) == 0 {
+		fmt.Println("No changes: the server's retention already matches.")
+		return nil
+	}
+	fmt.Println()
+	apply.WritePlan(os.Stdout, plan)
+	err = client.Apply(ctx, plan, func(c apply.Change) {
+		fmt.Printf("✓ %s %s %s\n", strings.TrimSuffix(c.Action, "e")+"ed", c.Resource.Kind, c.Resource.Name)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Applied %d retention changes\n", len(plan))
+	return nil
+}
+
+// printCostReport prints the recommendations as a table
+func printCostReport(report *costopt.Report) {
+	if len(report.Databases) == 0 {
+		fmt.Println("No completed backups in the catalog.")
+		return
+	}
+	fmt.Printf("%-24s %-8s %-12s %-10s %-10s %s\n", "DATABASE", "BACKUPS", "SIZE", "COST", "PROJECTED", "RECOMMENDATION")
+	for _, rec := range report.Databases {
+		var advice []string
+		if r := rec.NewRetention; r != nil {
+			advice = append(advice, fmt.Sprintf("retention daily %d, weekly %d, monthly %d", r.Daily, r.Weekly, r.Monthly))
+		}
+		for _, t := range rec.Tiers {
+			advice = append(advice, fmt.Sprintf("%s %s after %d days", t.Provider, t.Tier, t.AfterDays))
+		}
+		if len(advice) == 0 {
+			advice = append(advice, "-")
+		}
+		fmt.Printf("%-24s %-8d %-12s %-10.2f %-10.2f %s\n",
+			truncate(rec.Database, 24), rec.Backups, formatBytes(rec.Bytes), rec.Cost, rec.ProjectedCost, strings.Join(advice, "; "))
+	}
+
+	fmt.Printf("\nMonthly cost: %.2f now, %.2f projected", report.Cost, report.ProjectedCost)
+	if report.Budget > 0 {
+		status := "within"
+		if !report.WithinBudget {
+			status = "over"
+		}
+		fmt.Printf(", %s the budget of %.2f", status, report.Budget)
+	}
+	fmt.Println()
+	if !report.WithinBudget {
+		fmt.Println("Retention cannot be shortened further without losing backups restored in the past or the RPO.")
+	}
+}
+
+func runRetentionRestored(cmd *cobra.Command, args []string) error {
+	at, _ := cmd.Flags().GetString("at")
+
+	restoredAt := time.Now().UTC()
+	if at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return fmt.Errorf("invalid --at: %w", err)
+		}
+		restoredAt = t
+	}
+
+	cfg := GetConfig()
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+	b, err := repo.Get(context.Background(), args[0])
+	if err != nil {
+		return fmt.Errorf("backup not found: %w", err)
+	}
+
+	path := restoreHistoryPath(cfg)
+	err = costopt.RecordRestore(path, costopt.Restore{
+		Database:   b.Database,
+		BackupID:   b.ID,
+		BackupTime: b.StartTime,
+		RestoredAt: restoredAt,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Recorded the restore of %s (%s old) in %s\n", b.ID, restoredAt.Sub(b.StartTime).Round(time.Hour), path)
+	return nil
+}
@@ -0,0 +1,253 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/drcopy"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/models"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/sanskarpan/db-backup/internal/restorepoint"
+	"github.com/sanskarpan/db-backup/internal/runbook"
+	"github.com/sanskarpan/db-backup/internal/sla"
+	"github.com/spf13/cobra"
+)
+
+// runbookCmd groups the recovery runbook commands
+var runbookCmd = &cobra.Command{
+	Use:   "runbook",
+	Short: "Generate the recovery runbook of each database",
+	Long: `Generate the recovery runbook of each database: where the artifacts of its
+newest recovery point live, including their DR copies, the keys and logins
+a restore needs, the exact restore commands, and the expected duration
+from the restore rehearsals recorded with "sla rehearsal".
+
+With runbooks.enabled, the runbook of a database is rewritten after each
+of its backups, into runbooks.directory as <database>.md for people and
+<database>.json for tools, and served on /api/v1/runbooks. Keep a copy of
+the directory where it can be read when the backup host is gone.
+
+Examples:
+  # Rewrite the runbook of every database in the catalog
+  db-backup runbook generate
+
+  # Print the runbook of orders
+  db-backup runbook show orders`,
+}
+
+// runbookGenerateCmd rewrites runbooks from the catalog
+var runbookGenerateCmd = &cobra.Command{
+	Use:   "generate [database...]",
+	Short: "Rewrite the runbooks of the given databases, or of all of them",
+	RunE:  runRunbookGenerate,
+}
+
+// runbookShowCmd prints a runbook
+var runbookShowCmd = &cobra.Command{
+	Use:   "show <database>",
+	Short: "Print the runbook of a database",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runRunbookShow,
+}
+
+func init() {
+	rootCmd.AddCommand(runbookCmd)
+	runbookCmd.AddCommand(runbookGenerateCmd)
+	runbookCmd.AddCommand(runbookShowCmd)
+
+	runbookShowCmd.Flags().String("format", "markdown", "output format (markdown|json|yaml)")
+	runbookShowCmd.Flags().Bool("fresh", false, "generate it from the catalog instead of reading the saved one")
+}
+
+// runbookBackups returns the completed backups of the catalog that are not
+// quarantined for runbooks
+func runbookBackups(backups []*models.BackupMetadata, quarantined map[string]bool) []runbook.Backup {
+	candidates, byID := restoreCandidates(backups, quarantined)
+	out := make([]runbook.Backup, 0, len(candidates))
+	for _, p := range candidates {
+		b := byID[p.ID]
+		out = append(out, runbook.Backup{
+			Backup:   p,
+			Storage:  b.StorageType,
+			Location: b.BackupPath,
+			Size:     b.Size,
+			Checksum: b.Checksum,
+		})
+	}
+	return out
+}
+
+// generateRunbook writes the runbook of database from the catalog
+// backups, the DR copies and the restore rehearsals
+func generateRunbook(cfg *config.Config, backups []*models.BackupMetadata, database string) (*runbook.Runbook, error) {
+	quarantined, err := quarantinedBackups(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quarantine: %w", err)
+	}
+	in := runbook.Input{
+		Database: database,
+		Backups:  runbookBackups(backups, quarantined),
+		Copies:   make(map[string][]string),
+		Now:      time.Now(),
+	}
+
+	var newest *models.BackupMetadata
+	for _, b := range backups {
+		if b.Database == database && b.Status == models.BackupStatusCompleted && (newest == nil || b.StartTime.After(newest.StartTime)) {
+			newest = b
+		}
+	}
+	if newest == nil {
+		return nil, fmt.Errorf("%w of %s", restorepoint.ErrNoBackup, database)
+	}
+	in.DatabaseType = string(newest.DatabaseType)
+
+	if p, ok := cfg.Connections[strings.ToLower(database)]; ok {
+		in.Profile, in.RestoreUser = database, p.Restore.User
+	}
+	if source := newest.Tags[tagEncryptionKeySource]; source != "" {
+		in.EncryptionKey = keySourceDescription(cfg, source)
+	} else if newest.Tags[tagEncryptionCipher] != "" {
+		in.EncryptionKey = "the key given to backup --encryption-key, not recorded: pass it to restore --encryption-key"
+	} else if d := cfg.BackupDefaults(database, string(newest.DatabaseType), newest.Tags); d.Encrypt {
+		in.EncryptionKey = keySourceDescription(cfg, configuredKey(cfg, d.EncryptionKeyFile))
+	}
+
+	if cfg.DR.Enabled {
+		copies, err := drcopy.NewStore(cfg.DR).List()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range copies {
+			if c.Database == database && c.Status == drcopy.StatusCopied {
+				in.Copies[c.BackupID] = append(in.Copies[c.BackupID], fmt.Sprintf("s3://%s/%s (%s, DR job %s)", c.Bucket, c.Object, c.Region, c.Job))
+			}
+		}
+	}
+
+	if cfg.SLA.Enabled {
+		tracker := sla.New(cfg.SLA)
+		statuses, err := tracker.Status(in.Now)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range statuses {
+			if s.Database == database {
+				in.Estimate, in.Rehearsals = s.TimeEstimate, s.Rehearsals
+			}
+		}
+		in.RTO = tracker.Objective(database).RTO
+	}
+	return runbook.Generate(in)
+}
+
+// refreshRunbook rewrites the runbook of database after a backup. Like
+// the other post-backup steps it is best effort: a failure is logged and
+// the backup still succeeds.
+func refreshRunbook(ctx context.Context, cfg *config.Config, log *logger.Logger, database string) {
+	if !cfg.Runbooks.Enabled {
+		return
+	}
+	err := func() error {
+		repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+		if err != nil {
+			return err
+		}
+		backups, err := repo.List(ctx, &repository.ListFilter{Database: database})
+		if err != nil {
+			return err
+		}
+		rb, err := generateRunbook(cfg, backups, database)
+		if err != nil {
+			return err
+		}
+		return runbook.NewStore(cfg.Runbooks.Directory).Save(rb)
+	}()
+	if err != nil {
+		log.Warn("Failed to refresh the recovery runbook", map[string]interface{}{
+			"database": database,
+			"error":    err.Error(),
+		})
+	}
+}
+
+func runRunbookGenerate(cmd *cobra.Command, args []string) error {
+	cfg := GetConfig()
+	ctx := context.Background()
+
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+	backups, err := repo.List(ctx, &repository.ListFilter{})
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	databases := args
+	if len(databases) == 0 {
+		seen := make(map[string]bool)
+		for _, b := range backups {
+			if b.Status == models.BackupStatusCompleted && !seen[b.Database] {
+				seen[b.Database] = true
+				databases = append(databases, b.Database)
+			}
+		}
+	}
+
+	store := runbook.NewStore(cfg.Runbooks.Directory)
+	var failed []string
+	for _, database := range databases {
+		rb, err := generateRunbook(cfg, backups, database)
+		if err == nil {
+			err = store.Save(rb)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %s: %v\n", database, err)
+			failed = append(failed, database)
+			continue
+		}
+		fmt.Printf("✓ %s: recovery point %s, %d artifact(s)\n", database, rb.RecoveryPoint.Format(time.RFC3339), len(rb.Artifacts))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to generate the runbooks of: %s", strings.Join(failed, ", "))
+	}
+	fmt.Printf("Runbooks written to %s\n", cfg.Runbooks.Directory)
+	return nil
+}
+
+func runRunbookShow(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	fresh, _ := cmd.Flags().GetBool("fresh")
+
+	cfg := GetConfig()
+	rb, err := runbook.NewStore(cfg.Runbooks.Directory).Get(args[0])
+	if fresh || errors.Is(err, runbook.ErrNotFound) {
+		repo, rerr := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+		if rerr != nil {
+			return fmt.Errorf("failed to create repository: %w", rerr)
+		}
+		backups, rerr := repo.List(context.Background(), &repository.ListFilter{Database: args[0]})
+		if rerr != nil {
+			return fmt.Errorf("failed to list backups: %w", rerr)
+		}
+		rb, err = generateRunbook(cfg, backups, args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(rb)
+	case "yaml", "yml":
+		return printYAMLValue(rb)
+	}
+	return rb.WriteMarkdown(os.Stdout)
+}
@@ -0,0 +1,264 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/sanskarpan/db-backup/internal/security/malware"
+	"github.com/sanskarpan/db-backup/internal/security/quarantine"
+	"github.com/spf13/cobra"
+)
+
+// scanCmd scans backup artifacts for malware
+var scanCmd = &cobra.Command{
+	Use:   "scan [backup-id...]",
+	Short: "Scan backup artifacts with ClamAV and YARA rules",
+	Long: `Scan backup artifacts for malware with a clamd daemon and/or YARA rules,
+configured under security.malware. Results are recorded, matches raise a
+threat alert and quarantine the backup when the quarantine is enabled.
+
+Without arguments, the backups created within --since are scanned; run it
+from cron to rescan with updated signatures. Only artifacts on the local
+filesystem can be scanned.
+
+Examples:
+  # Scan two backups
+  db-backup security scan 20250101-020000-orders 20250102-020000-orders
+
+  # Nightly: scan the last day's backups that were not scanned yet
+  db-backup security scan --since 24h --unscanned
+
+  # Scan any file, e.g. a dump about to be imported
+  db-backup security scan --path /tmp/vendor-dump.sql
+
+  # Show recorded scan results
+  db-backup security scan results`,
+	RunE: runScan,
+}
+
+// scanResultsCmd prints recorded scan results
+var scanResultsCmd = &cobra.Command{
+	Use:   "results",
+	Short: "Show recorded scan results",
+	RunE:  runScanResults,
+}
+
+func init() {
+	securityCmd.AddCommand(scanCmd)
+	scanCmd.AddCommand(scanResultsCmd)
+
+	scanCmd.Flags().String("path", "", "scan a file instead of backups")
+	scanCmd.Flags().Duration("since", 24*time.Hour, "scan backups created within this period when no IDs are given")
+	scanCmd.Flags().String("database", "", "only scan backups of this database")
+	scanCmd.Flags().Bool("unscanned", false, "skip backups that were scanned before")
+	scanCmd.Flags().Bool("fail-on-threat", false, "exit non-zero when a threat is found")
+
+	scanResultsCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+}
+
+// malwareEngine returns the configured scan engine
+func malwareEngine(cfg *config.Config) (*malware.Engine, error) {
+	if !cfg.Security.Malware.Enabled {
+		return nil, fmt.Errorf("malware scanning is not enabled (security.malware.enabled)")
+	}
+	return malware.New(cfg.Security.Malware)
+}
+
+// scanArtifact scans one artifact, records the result and raises a threat
+// alert on matches, quarantining the backup when the quarantine is enabled
+func scanArtifact(ctx context.Context, cfg *config.Config, log *logger.Logger, engine *malware.Engine, backupID, database, path string) malware.Result {
+	result := engine.Scan(ctx, backupID, database, path)
+	if err := engine.Record(result); err != nil {
+		log.Warn("Failed to record scan result", map[string]interface{}{
+			"path":  path,
+			"error": err.Error(),
+		})
+	}
+	if err := result.Err(); err != nil {
+		log.Warn("Malware scan incomplete", map[string]interface{}{
+			"path":  path,
+			"error": err.Error(),
+		})
+	}
+	if !result.Infected() {
+		return result
+	}
+
+	log.Warn("Malware found in backup artifact", map[string]interface{}{
+		"backup_id": backupID,
+		"database":  database,
+		"path":      path,
+		"threats":   strings.Join(result.Threats(), ","),
+	})
+	sendNotification(ctx, cfg, log, result.Notification())
+
+	if backupID == "" || !cfg.Security.Quarantine.Enabled {
+		return result
+	}
+	entry := quarantine.Entry{
+		BackupID:      backupID,
+		Database:      database,
+		Reason:        "Malware found: " + strings.Join(result.Threats(), ", "),
+		QuarantinedAt: time.Now().UTC(),
+	}
+	added, err := quarantine.New(cfg.Security.Quarantine).Quarantine(entry, "malware-scan")
+	if err != nil {
+		log.Error("Failed to quarantine infected backup", err, map[string]interface{}{
+			"backup_id": backupID,
+		})
+	} else if added {
+		recordAudit(cfg, log, "malware-scan", audit.ActionQuarantined, backupID, map[string]string{
+			"database": database,
+			"reason":   entry.Reason,
+		})
+		sendNotification(ctx, cfg, log, entry.Notification())
+	}
+	return result
+}
+
+// scanNewBackup scans a freshly written artifact when on_backup scanning is
+// enabled. It is best effort and never fails the backup.
+func scanNewBackup(ctx context.Context, cfg *config.Config, log *logger.Logger, backupID, database, path string) {
+	if !cfg.Security.Malware.Enabled || !cfg.Security.Malware.OnBackup {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		log.Warn("Skipping malware scan, artifact is not on the local filesystem", map[string]interface{}{
+			"backup_id": backupID,
+			"path":      path,
+		})
+		return
+	}
+	engine, err := malware.New(cfg.Security.Malware)
+	if err != nil {
+		log.Warn("Malware scanning unavailable", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	fmt.Println("Scanning backup for malware...")
+	result := scanArtifact(ctx, cfg, log, engine, backupID, database, path)
+	if result.Infected() {
+		fmt.Printf("✗ Malware found: %s\n", strings.Join(result.Threats(), ", "))
+	}
+}
+
+func runScan(cmd *cobra.Command, args []string) error {
+	path, _ := cmd.Flags().GetString("path")
+	since, _ := cmd.Flags().GetDuration("since")
+	database, _ := cmd.Flags().GetString("database")
+	unscanned, _ := cmd.Flags().GetBool("unscanned")
+	failOnThreat, _ := cmd.Flags().GetBool("fail-on-threat")
+
+	cfg := GetConfig()
+	log := GetLogger()
+	ctx := context.Background()
+
+	engine, err := malwareEngine(cfg)
+	if err != nil {
+		return err
+	}
+
+	type target struct{ id, database, path string }
+	var targets []target
+	if path != "" {
+		targets = append(targets, target{path: path})
+	} else {
+		repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+		if err != nil {
+			return fmt.Errorf("failed to create repository: %w", err)
+		}
+		if len(args) > 0 {
+			for _, id := range args {
+				b, err := repo.Get(ctx, id)
+				if err != nil {
+					return fmt.Errorf("backup %s: %w", id, err)
+				}
+				targets = append(targets, target{b.ID, b.Database, b.BackupPath})
+			}
+		} else {
+			from := time.Now().Add(-since)
+			backups, err := repo.List(ctx, &repository.ListFilter{Database: database, From: &from})
+			if err != nil {
+				return fmt.Errorf("failed to list backups: %w", err)
+			}
+			for _, b := range backups {
+				if unscanned {
+					if at, err := engine.LastScan(b.ID); err == nil && !at.IsZero() {
+						continue
+					}
+				}
+				targets = append(targets, target{b.ID, b.Database, b.BackupPath})
+			}
+		}
+	}
+
+	var infected, skipped int
+	for _, t := range targets {
+		if _, err := os.Stat(t.path); err != nil {
+			fmt.Printf("- %-38s skipped, artifact not available locally\n", truncate(t.id, 38))
+			skipped++
+			continue
+		}
+		r := scanArtifact(ctx, cfg, log, engine, t.id, t.database, t.path)
+		name := t.id
+		if name == "" {
+			name = t.path
+		}
+		switch r.Status() {
+		case "infected":
+			infected++
+			fmt.Printf("✗ %-38s %s\n", truncate(name, 38), strings.Join(r.Threats(), ", "))
+		case "error":
+			fmt.Printf("! %-38s scan incomplete: %v\n", truncate(name, 38), r.Err())
+		default:
+			fmt.Printf("✓ %-38s clean (%s)\n", truncate(name, 38), r.Duration.Round(time.Millisecond))
+		}
+	}
+	fmt.Printf("\nScanned %d artifact(s), %d infected, %d skipped\n", len(targets)-skipped, infected, skipped)
+
+	if failOnThreat && infected > 0 {
+		return fmt.Errorf("malware found in %d artifact(s)", infected)
+	}
+	return nil
+}
+
+func runScanResults(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+
+	engine, err := malwareEngine(GetConfig())
+	if err != nil {
+		return err
+	}
+	results, err := engine.Results()
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(results)
+	case "yaml", "yml":
+		return printYAMLValue(results)
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No scans recorded yet.")
+		return nil
+	}
+	fmt.Printf("%-38s %-16s %-20s %-9s %s\n", "BACKUP ID", "DATABASE", "SCANNED", "STATUS", "THREATS")
+	for _, r := range results {
+		name := r.BackupID
+		if name == "" {
+			name = r.Path
+		}
+		fmt.Printf("%-38s %-16s %-20s %-9s %s\n", truncate(name, 38), truncate(r.Database, 16),
+			r.ScannedAt.Local().Format("2006-01-02 15:04:05"), r.Status(), strings.Join(r.Threats(), ", "))
+	}
+	return nil
+}
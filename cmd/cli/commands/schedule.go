@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/scheduler/export"
+	"github.com/spf13/cobra"
+)
+
+// scheduleCmd groups schedule management commands
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Manage backup schedules",
+}
+
+// scheduleExportCmd renders a schedule as OS-level scheduling artifacts
+var scheduleExportCmd = &cobra.Command{
+	Use:   "export --name NAME --cron EXPR [flags] -- BACKUP-ARGS...",
+	Short: "Export a schedule as systemd units, a crontab entry or a Kubernetes CronJob",
+	Long: `Render a backup schedule as OS-level scheduling artifacts, for users
+who prefer systemd timers, cron or Kubernetes CronJobs over the built-in
+scheduler. Everything after "--" is passed to db-backup on each run.
+
+Examples:
+  # systemd service and timer for a nightly PostgreSQL backup
+  db-backup schedule export --name nightly-orders --cron "0 2 * * *" \\
+    --format systemd --user dbbackup --env-file /etc/db-backup/env \\
+    --output-dir /etc/systemd/system \\
+    -- backup --type postgres --database orders
+
+  # /etc/cron.d entry
+  db-backup schedule export --name hourly-cache --cron "0 * * * *" \\
+    --format cron --user dbbackup -- backup --type mysql --database cache
+
+  # Kubernetes CronJob using credentials from a secret
+  db-backup schedule export --name nightly-users --cron "30 1 * * *" \\
+    --format k8s-cronjob --image ghcr.io/acme/db-backup:1.4 \\
+    --namespace backups --secret db-credentials \\
+    -- backup --type mongodb --database users`,
+	RunE: runScheduleExport,
+}
+
+func init() {
+	rootCmd.AddCommand(scheduleCmd)
+	scheduleCmd.AddCommand(scheduleExportCmd)
+
+	scheduleExportCmd.Flags().String("name", "", "schedule name (lowercase, used for unit/resource names)")
+	scheduleExportCmd.Flags().String("cron", "", "cron expression (5 fields or @daily, @hourly, ...)")
+	scheduleExportCmd.Flags().String("format", "systemd", "output format (systemd|cron|k8s-cronjob)")
+	scheduleExportCmd.Flags().String("output-dir", "", "write artifacts to this directory instead of stdout")
+	scheduleExportCmd.Flags().String("timezone", "", "IANA timezone for the schedule")
+
+	// systemd / cron flags
+	scheduleExportCmd.Flags().String("binary", "/usr/local/bin/db-backup", "path to the db-backup binary")
+	scheduleExportCmd.Flags().String("user", "", "user to run the backup as")
+	scheduleExportCmd.Flags().String("env-file", "", "environment file holding credentials")
+	scheduleExportCmd.Flags().StringToString("env", nil, "extra environment variables (KEY=VALUE)")
+
+	// Kubernetes flags
+	scheduleExportCmd.Flags().String("image", "db-backup:latest", "container image")
+	scheduleExportCmd.Flags().String("namespace", "default", "Kubernetes namespace")
+	scheduleExportCmd.Flags().String("secret", "", "Kubernetes secret exposed as environment variables")
+	scheduleExportCmd.Flags().String("config-map", "", "Kubernetes config map mounted at /config")
+
+	scheduleExportCmd.MarkFlagRequired("name")
+	scheduleExportCmd.MarkFlagRequired("cron")
+}
+
+func runScheduleExport(cmd *cobra.Command, args []string) error {
+	job := &export.Job{Args: args}
+
+	job.Name, _ = cmd.Flags().GetString("name")
+	job.Cron, _ = cmd.Flags().GetString("cron")
+	job.Timezone, _ = cmd.Flags().GetString("timezone")
+	job.Binary, _ = cmd.Flags().GetString("binary")
+	job.User, _ = cmd.Flags().GetString("user")
+	job.EnvFile, _ = cmd.Flags().GetString("env-file")
+	job.Env, _ = cmd.Flags().GetStringToString("env")
+	job.Image, _ = cmd.Flags().GetString("image")
+	job.Namespace, _ = cmd.Flags().GetString("namespace")
+	job.Secret, _ = cmd.Flags().GetString("secret")
+	job.ConfigMap, _ = cmd.Flags().GetString("config-map")
+
+	// Tag scheduled backups with the schedule name for notification routing
+	if len(job.Args) > 0 && job.Args[0] == "backup" && !hasFlag(job.Args, "--schedule") {
+		job.Args = append(job.Args, "--schedule", job.Name)
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	outputDir, _ := cmd.Flags().GetString("output-dir")
+
+	artifacts, err := export.Render(job, export.Format(format))
+	if err != nil {
+		return err
+	}
+
+	if outputDir == "" {
+		for i, a := range artifacts {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("# --- %s ---\n", a.Filename)
+			fmt.Print(a.Content)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	for _, a := range artifacts {
+		path := filepath.Join(outputDir, a.Filename)
+		if err := os.WriteFile(path, []byte(a.Content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		fmt.Printf("✓ Wrote %s\n", path)
+	}
+
+	return nil
+}
+
+// hasFlag reports whether a long flag is present in an argument list
+func hasFlag(args []string, flag string) bool {
+	for _, a := range args {
+		if a == flag || strings.HasPrefix(a, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
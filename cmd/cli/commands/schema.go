@@ -0,0 +1,247 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/schemasnap"
+	"github.com/spf13/cobra"
+)
+
+// schemaCmd browses the schema snapshots taken before each backup
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Browse and compare the schema snapshots of backups",
+	Long: `Browse and compare the schema-only snapshots taken before each backup,
+without restoring data.
+
+Each backup records the checksum of its snapshot in the schema_checksum
+tag; backups of an unchanged schema share one stored snapshot.`,
+}
+
+var schemaHistoryCmd = &cobra.Command{
+	Use:   "history <database>",
+	Short: "List the schema snapshots of a database and where the schema changed",
+	Long: `List the schema snapshots of a database, oldest first, marking the backups
+taken after a schema change.
+
+Examples:
+  # Schema history of the orders database
+  db-backup schema history orders
+
+  # Only the PostgreSQL database of that name, as JSON
+  db-backup schema history orders --type postgres --format json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSchemaHistory,
+}
+
+var schemaDiffCmd = &cobra.Command{
+	Use:   "diff <database> [from-backup] [to-backup]",
+	Short: "Show how the schema changed between two backups",
+	Long: `Show how the schema of a database changed between two backups as a
+unified diff. Without backup IDs the last two snapshots are compared, and
+with one that backup is compared with the latest.
+
+Examples:
+  # What changed in the schema since the previous backup
+  db-backup schema diff orders
+
+  # Between two backups
+  db-backup schema diff orders backup_20260301_020000_a1b2 backup_20260315_020000_c3d4
+
+  # The full schema of one backup
+  db-backup schema diff orders backup_20260315_020000_c3d4 --show`,
+	Args: cobra.RangeArgs(1, 3),
+	RunE: runSchemaDiff,
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+	schemaCmd.AddCommand(schemaHistoryCmd)
+	schemaCmd.AddCommand(schemaDiffCmd)
+
+	schemaCmd.PersistentFlags().String("type", "", "database type, when databases of several types share the name")
+	schemaHistoryCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+	schemaDiffCmd.Flags().Bool("show", false, "print the schema of the backup instead of a diff")
+}
+
+// schemaStore returns the store of the schema snapshots
+func schemaStore(cfg *config.Config) *schemasnap.Store {
+	dir := cfg.Backup.SchemaSnapshots.Directory
+	if dir == "" {
+		dir = filepath.Join(cfg.Backup.MetadataDirectory, "schemas")
+	}
+	return schemasnap.NewStore(dir)
+}
+
+// captureSchema dumps the schema of what a backup takes, before its data.
+// The snapshot is best effort: nil is returned when it is disabled, the
+// driver cannot take one or taking it fails, and the backup goes ahead.
+func captureSchema(ctx context.Context, cfg *config.Config, log *logger.Logger, opts *BackupOptions, dbType database.DatabaseType, port int, source string) []byte {
+	if !cfg.Backup.SchemaSnapshots.Enabled {
+		return nil
+	}
+	driver, err := database.CreateDriver(dbType)
+	if err != nil {
+		return nil
+	}
+	dumper, ok := driver.(database.SchemaDumper)
+	if !ok {
+		return nil
+	}
+	fail := func(err error) []byte {
+		log.Warn("Skipping the schema snapshot", map[string]interface{}{
+			"database": opts.Database,
+			"error":    err.Error(),
+		})
+		return nil
+	}
+	err = driver.Connect(ctx, &database.ConnectionConfig{
+		Type:     dbType,
+		Host:     opts.Host,
+		Port:     port,
+		Username: opts.User,
+		Password: opts.Password,
+		Database: source,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	defer driver.Disconnect()
+
+	var ddl bytes.Buffer
+	err = dumper.DumpSchema(ctx, &database.BackupOptions{
+		Database:      source,
+		Databases:     opts.Databases,
+		AllDatabases:  opts.AllDatabases,
+		Tables:        opts.Tables,
+		ExcludeTables: opts.ExcludeTables,
+	}, &ddl)
+	if err != nil {
+		return fail(err)
+	}
+	return ddl.Bytes()
+}
+
+// saveSchema stores the schema snapshot of a saved backup
+func saveSchema(cfg *config.Config, log *logger.Logger, dbType database.DatabaseType, db, backupID string, ddl []byte) {
+	if ddl == nil {
+		return
+	}
+	_, err := schemaStore(cfg).Save(schemasnap.Entry{
+		BackupID:     backupID,
+		DatabaseType: string(dbType),
+		Database:     db,
+	}, ddl)
+	if err != nil {
+		log.Warn("Failed to save the schema snapshot", map[string]interface{}{
+			"backup_id": backupID,
+			"error":     err.Error(),
+		})
+	}
+}
+
+func runSchemaHistory(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	dbType, _ := cmd.Flags().GetString("type")
+
+	entries, err := schemaStore(GetConfig()).History(dbType, args[0])
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(entries)
+	case "yaml", "yml":
+		return printYAMLValue(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Printf("No schema snapshots recorded for %s.\n", args[0])
+		return nil
+	}
+	fmt.Printf("%-38s %-12s %-20s %-10s %-16s %s\n", "BACKUP ID", "TYPE", "TAKEN", "SIZE", "CHECKSUM", "CHANGE")
+	previous := make(map[string]string)
+	for _, e := range entries {
+		change := "changed"
+		switch prev, ok := previous[e.DatabaseType]; {
+		case !ok:
+			change = "first"
+		case prev == e.Checksum:
+			change = "-"
+		}
+		previous[e.DatabaseType] = e.Checksum
+		fmt.Printf("%-38s %-12s %-20s %-10s %-16s %s\n", truncate(e.BackupID, 38), e.DatabaseType,
+			e.CreatedAt.Local().Format("2006-01-02 15:04:05"), formatBytes(e.Size), e.Checksum[:16], change)
+	}
+	return nil
+}
+
+func runSchemaDiff(cmd *cobra.Command, args []string) error {
+	dbType, _ := cmd.Flags().GetString("type")
+	show, _ := cmd.Flags().GetBool("show")
+	store := schemaStore(GetConfig())
+
+	entries, err := store.History(dbType, args[0])
+	if err != nil {
+		return err
+	}
+	find := func(id string) (schemasnap.Entry, error) {
+		return store.Find(dbType, args[0], id)
+	}
+
+	var from, to schemasnap.Entry
+	switch {
+	case show && len(args) != 2:
+		return fmt.Errorf("--show takes the ID of one backup")
+	case show || len(args) == 2:
+		if from, err = find(args[1]); err != nil {
+			return err
+		}
+		if !show {
+			to = entries[len(entries)-1]
+		}
+	case len(args) == 3:
+		if from, err = find(args[1]); err != nil {
+			return err
+		}
+		if to, err = find(args[2]); err != nil {
+			return err
+		}
+	default:
+		if len(entries) < 2 {
+			return fmt.Errorf("%s has %d schema snapshot(s), two are needed for a diff", args[0], len(entries))
+		}
+		from, to = entries[len(entries)-2], entries[len(entries)-1]
+	}
+	if from.DatabaseType != to.DatabaseType && !show {
+		return fmt.Errorf("backups %s and %s are of different database types, choose one with --type", from.BackupID, to.BackupID)
+	}
+
+	fromDDL, err := store.Read(from)
+	if err != nil {
+		return err
+	}
+	if show {
+		_, err := os.Stdout.Write(fromDDL)
+		return err
+	}
+	toDDL, err := store.Read(to)
+	if err != nil {
+		return err
+	}
+	diff := schemasnap.Diff(from.BackupID, to.BackupID, fromDDL, toDDL)
+	if diff == "" {
+		fmt.Printf("The schema of %s is the same in %s and %s.\n", args[0], from.BackupID, to.BackupID)
+		return nil
+	}
+	fmt.Print(diff)
+	return nil
+}
@@ -0,0 +1,317 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/repository"
+	"github.com/sanskarpan/db-backup/internal/security/quarantine"
+	"github.com/sanskarpan/db-backup/internal/security/ransomware"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// securityCmd groups security commands
+var securityCmd = &cobra.Command{
+	Use:   "security",
+	Short: "Ransomware detection, quarantine, malware scanning, access policy and audit log",
+}
+
+// securityBaselineCmd groups the ransomware baseline commands
+var securityBaselineCmd = &cobra.Command{
+	Use:   "baseline",
+	Short: "Manage the per-database entropy and compression baselines",
+	Long: `Every backup's byte entropy and compression ratio are compared with a
+baseline learned from the recent backups of the same database. A backup
+whose entropy or ratio rises well beyond the baseline, as when the source
+data was encrypted by ransomware, raises an alert and is kept out of the
+baseline. Settings live under security.ransomware.baseline.
+
+Examples:
+  # Show what each database's backups normally look like
+  db-backup security baseline show
+
+  # Learn a baseline from the last 30 backups instead of waiting for new ones
+  db-backup security baseline learn orders
+
+  # Relearn after an intended change, e.g. enabling table compression
+  db-backup security baseline reset orders`,
+}
+
+// securityBaselineShowCmd prints the learned baselines
+var securityBaselineShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the learned baseline of every database",
+	RunE:  runSecurityBaselineShow,
+}
+
+// securityBaselineLearnCmd seeds a baseline from the backup history
+var securityBaselineLearnCmd = &cobra.Command{
+	Use:   "learn <database>",
+	Short: "Learn a database's baseline from its existing backups",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSecurityBaselineLearn,
+}
+
+// securityBaselineResetCmd forgets a baseline
+var securityBaselineResetCmd = &cobra.Command{
+	Use:   "reset <database>",
+	Short: "Forget a database's baseline so it is learned again",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSecurityBaselineReset,
+}
+
+func init() {
+	rootCmd.AddCommand(securityCmd)
+	securityCmd.AddCommand(securityBaselineCmd)
+	securityBaselineCmd.AddCommand(securityBaselineShowCmd)
+	securityBaselineCmd.AddCommand(securityBaselineLearnCmd)
+	securityBaselineCmd.AddCommand(securityBaselineResetCmd)
+
+	securityBaselineShowCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+
+	securityBaselineLearnCmd.Flags().Int("limit", 0, "number of most recent backups to learn from (defaults to the baseline window)")
+	securityBaselineLearnCmd.Flags().Bool("skip-entropy", false, "learn compression ratios only, without reading the artifacts")
+}
+
+// baselineStore returns the configured baseline store
+func baselineStore(cfg *config.Config) (*ransomware.BaselineStore, error) {
+	if !cfg.Security.Ransomware.Baseline.Enabled {
+		return nil, fmt.Errorf("ransomware baselines are not enabled (security.ransomware.baseline.enabled)")
+	}
+	return ransomware.NewBaselineStore(cfg.Security.Ransomware.Baseline), nil
+}
+
+// baselineSample measures one backup artifact. Entropy is only measured for
+// artifacts on the local filesystem; the ratio comes from the metadata.
+func baselineSample(cfg *config.Config, backupID, path string, size, compressedSize int64, at time.Time) (ransomware.Sample, error) {
+	s := ransomware.Sample{At: at, BackupID: backupID}
+	if size > 0 && compressedSize > 0 {
+		s.Ratio = float64(compressedSize) / float64(size)
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	var limit int64
+	if v := cfg.Security.Ransomware.Baseline.SampleSize; v != "" {
+		n, err := utils.ParseBytes(v)
+		if err != nil {
+			return s, fmt.Errorf("invalid sample_size: %w", err)
+		}
+		limit = n
+	}
+	entropy, err := ransomware.MeasureFile(path, limit)
+	if err != nil {
+		return s, err
+	}
+	s.Entropy = entropy
+	return s, nil
+}
+
+// checkBackupBaseline compares a finished backup with its database's
+// baseline and alerts when it deviates. Like the other post-backup hooks it
+// is best effort and never fails the backup.
+func checkBackupBaseline(ctx context.Context, cfg *config.Config, log *logger.Logger, database, backupID, path string, size, compressedSize int64, at time.Time) {
+	if !cfg.Security.Ransomware.Baseline.Enabled {
+		return
+	}
+	sample, err := baselineSample(cfg, backupID, path, size, compressedSize, at)
+	if err != nil {
+		// The ratio alone is still worth checking
+		log.Warn("Failed to measure backup entropy", map[string]interface{}{
+			"backup_id": backupID,
+			"error":     err.Error(),
+		})
+	}
+
+	verdict, err := ransomware.NewBaselineStore(cfg.Security.Ransomware.Baseline).Observe(database, sample)
+	if err != nil {
+		log.Warn("Failed to check backup against its baseline", map[string]interface{}{
+			"database": database,
+			"error":    err.Error(),
+		})
+		return
+	}
+	if !verdict.Suspicious() {
+		return
+	}
+
+	n := verdict.Notification()
+	fields := map[string]interface{}{
+		"database":  database,
+		"backup_id": backupID,
+	}
+	for _, d := range verdict.Deviations {
+		fields[d.Metric] = d.Value
+		fields[d.Metric+"_expected"] = d.Expected
+	}
+	log.Warn(n.Title, fields)
+	sendNotification(ctx, cfg, log, n)
+	publishAlert(ctx, cfg, log, "ransomware", n)
+
+	if !cfg.Security.Quarantine.Enabled {
+		return
+	}
+	details := make(map[string]string, len(n.Fields))
+	for k, v := range n.Fields {
+		details[k] = v
+	}
+	entry := quarantine.Entry{
+		BackupID:      backupID,
+		Database:      database,
+		Reason:        "Deviates from the ransomware baseline",
+		Details:       details,
+		QuarantinedAt: time.Now().UTC(),
+	}
+	added, err := quarantine.New(cfg.Security.Quarantine).Quarantine(entry, "ransomware-baseline")
+	if err != nil {
+		log.Error("Failed to quarantine suspicious backup", err, map[string]interface{}{
+			"backup_id": backupID,
+		})
+		return
+	}
+	if added {
+		log.Warn("Backup quarantined", map[string]interface{}{
+			"database":  database,
+			"backup_id": backupID,
+		})
+		recordAudit(cfg, log, "ransomware-baseline", audit.ActionQuarantined, backupID, map[string]string{
+			"database": database,
+			"reason":   entry.Reason,
+		})
+		sendNotification(ctx, cfg, log, entry.Notification())
+	}
+}
+
+func runSecurityBaselineShow(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+
+	store, err := baselineStore(GetConfig())
+	if err != nil {
+		return err
+	}
+	baselines, err := store.Baselines()
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(baselines)
+	case "yaml", "yml":
+		return printYAMLValue(baselines)
+	}
+
+	if len(baselines) == 0 {
+		fmt.Println("No baselines learned yet.")
+		return nil
+	}
+	fmt.Printf("%-24s %-8s %-18s %-18s %-8s %s\n", "DATABASE", "BACKUPS", "ENTROPY", "RATIO", "FLAGGED", "STATUS")
+	for _, b := range baselines {
+		entropy, ratio := "-", "-"
+		if b.Entropy.Samples > 0 {
+			entropy = fmt.Sprintf("%.2f ± %.2f", b.Entropy.Mean, b.Entropy.StdDev)
+		}
+		if b.Ratio.Samples > 0 {
+			ratio = fmt.Sprintf("%.1f%% ± %.1f", b.Ratio.Mean*100, b.Ratio.StdDev*100)
+		}
+		status := "active"
+		if b.Learning {
+			status = "learning"
+		}
+		fmt.Printf("%-24s %-8d %-18s %-18s %-8d %s\n", truncate(b.Database, 24),
+			max(b.Entropy.Samples, b.Ratio.Samples), entropy, ratio, b.Flagged, status)
+	}
+	return nil
+}
+
+func runSecurityBaselineLearn(cmd *cobra.Command, args []string) error {
+	limit, _ := cmd.Flags().GetInt("limit")
+	skipEntropy, _ := cmd.Flags().GetBool("skip-entropy")
+
+	cfg := GetConfig()
+	log := GetLogger()
+	database := args[0]
+
+	store, err := baselineStore(cfg)
+	if err != nil {
+		return err
+	}
+	if limit <= 0 {
+		limit = cfg.Security.Ransomware.Baseline.Window
+	}
+
+	repo, err := repository.NewFileRepository(cfg.Backup.MetadataDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+	backups, err := repo.List(context.Background(), &repository.ListFilter{
+		Database:  database,
+		Limit:     limit,
+		SortBy:    "date",
+		SortOrder: "desc",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var samples []ransomware.Sample
+	for _, b := range backups {
+		// Failed runs have no meaningful size
+		if b.Size <= 0 {
+			continue
+		}
+		path := b.BackupPath
+		if skipEntropy {
+			path = ""
+		}
+		s, err := baselineSample(cfg, b.ID, path, b.Size, b.CompressedSize, b.StartTime)
+		if err != nil {
+			log.Warn("Failed to measure backup entropy", map[string]interface{}{
+				"backup_id": b.ID,
+				"error":     err.Error(),
+			})
+		}
+		samples = append(samples, s)
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("no completed backups found for %s", database)
+	}
+
+	baseline, err := store.Learn(database, samples)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Learned baseline for %s from %d backup(s)\n", database, len(samples))
+	if baseline.Entropy.Samples > 0 {
+		fmt.Printf("  Entropy:           %.2f ± %.2f bits/byte\n", baseline.Entropy.Mean, baseline.Entropy.StdDev)
+	}
+	if baseline.Ratio.Samples > 0 {
+		fmt.Printf("  Compression ratio: %.1f%% ± %.1f\n", baseline.Ratio.Mean*100, baseline.Ratio.StdDev*100)
+	}
+	if baseline.Learning {
+		fmt.Printf("  Still learning: %d backup(s) are needed before deviations are flagged\n",
+			cfg.Security.Ransomware.Baseline.MinSamples)
+	}
+	return nil
+}
+
+func runSecurityBaselineReset(cmd *cobra.Command, args []string) error {
+	cfg := GetConfig()
+	store, err := baselineStore(cfg)
+	if err != nil {
+		return err
+	}
+	if err := store.Reset(args[0]); err != nil {
+		return err
+	}
+	recordAudit(cfg, GetLogger(), cliActor(), audit.ActionBaselineReset, args[0], nil)
+	fmt.Printf("✓ Baseline for %s reset, it will be learned from the next backups\n", args[0])
+	return nil
+}
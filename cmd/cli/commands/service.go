@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/winservice"
+	"github.com/spf13/cobra"
+)
+
+// serviceCmd groups the Windows service commands
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Install and control db-backup as a Windows service",
+	Long: `Run a long-running command, such as agent or disk watch, as a Windows
+service started at boot and restarted a minute after it fails. Everything
+after "--" is the command the service runs. Stopping the service stops the
+command the way Ctrl+C does.
+
+Services start in the Windows directory: relative paths in the configuration
+are resolved against --workdir instead, %ProgramData%\db-backup by default,
+where the configuration is also looked for when --config is not given.
+Services have no console: set logging.output to file and logging.file.path.
+
+Examples:
+  # Run the agent as a service under the Network Service account
+  db-backup service install --name db-backup-agent --account "NT AUTHORITY\NetworkService" -- agent
+
+  # Watch disk space with an explicit configuration file
+  db-backup --config D:\db-backup\config.yaml service install --name db-backup-disk -- disk watch
+
+  db-backup service start db-backup-agent
+  db-backup service status db-backup-agent
+  db-backup service uninstall db-backup-agent`,
+}
+
+// serviceInstallCmd registers the service
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install [flags] -- COMMAND [ARGS...]",
+	Short: "Install a Windows service running a db-backup command",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runServiceInstall,
+}
+
+// serviceUninstallCmd stops and removes the service
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall <name>",
+	Short: "Stop and remove a Windows service",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runServiceUninstall,
+}
+
+// serviceStartCmd starts the service
+var serviceStartCmd = &cobra.Command{
+	Use:   "start <name>",
+	Short: "Start a Windows service",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := winservice.Start(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Started %s\n", args[0])
+		return nil
+	},
+}
+
+// serviceStopCmd stops the service
+var serviceStopCmd = &cobra.Command{
+	Use:   "stop <name>",
+	Short: "Stop a Windows service",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), winservice.StopTimeout+10*time.Second)
+		defer cancel()
+		if err := winservice.Stop(ctx, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Stopped %s\n", args[0])
+		return nil
+	},
+}
+
+// serviceStatusCmd prints the state of the service
+var serviceStatusCmd = &cobra.Command{
+	Use:   "status <name>",
+	Short: "Show the state of a Windows service",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, err := winservice.Status(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", args[0], state)
+		return nil
+	},
+}
+
+// serviceRunCmd is what the service control manager starts
+var serviceRunCmd = &cobra.Command{
+	Use:    "run [flags] -- COMMAND [ARGS...]",
+	Short:  "Run a command under the Windows service control manager",
+	Hidden: true,
+	Args:   cobra.MinimumNArgs(1),
+	RunE:   runServiceRun,
+}
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceInstallCmd)
+	serviceCmd.AddCommand(serviceUninstallCmd)
+	serviceCmd.AddCommand(serviceStartCmd)
+	serviceCmd.AddCommand(serviceStopCmd)
+	serviceCmd.AddCommand(serviceStatusCmd)
+	serviceCmd.AddCommand(serviceRunCmd)
+
+	serviceInstallCmd.Flags().String("name", "db-backup", "service name")
+	serviceInstallCmd.Flags().String("display-name", "", "name shown in the Services console (defaults to the service name)")
+	serviceInstallCmd.Flags().String("description", "", "description shown in the Services console")
+	serviceInstallCmd.Flags().String("start", winservice.StartAuto, "start type (auto|delayed|manual)")
+	serviceInstallCmd.Flags().String("account", "", "account to run as, LocalSystem when empty")
+	serviceInstallCmd.Flags().String("password", "", "password of the account")
+	serviceInstallCmd.Flags().String("workdir", "", `working directory (default %ProgramData%\db-backup)`)
+
+	serviceRunCmd.Flags().String("name", "db-backup", "service name")
+	serviceRunCmd.Flags().String("workdir", "", "working directory")
+}
+
+// defaultServiceDir is where services keep their configuration and data
+func defaultServiceDir() string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "db-backup")
+}
+
+func runServiceInstall(cmd *cobra.Command, args []string) error {
+	name, _ := cmd.Flags().GetString("name")
+	displayName, _ := cmd.Flags().GetString("display-name")
+	description, _ := cmd.Flags().GetString("description")
+	startType, _ := cmd.Flags().GetString("start")
+	account, _ := cmd.Flags().GetString("account")
+	password, _ := cmd.Flags().GetString("password")
+	workdir, _ := cmd.Flags().GetString("workdir")
+	configPath, _ := cmd.Flags().GetString("config")
+
+	// The command is resolved now so a typo fails here, not at boot
+	if target, _, err := rootCmd.Find(args); err != nil || target == rootCmd || target.RunE == nil {
+		return fmt.Errorf("unknown command %q to run as a service", args[0])
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate db-backup: %w", err)
+	}
+	if workdir == "" {
+		workdir = defaultServiceDir()
+	}
+	if workdir, err = filepath.Abs(workdir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(workdir, 0750); err != nil {
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+
+	var serviceArgs []string
+	if configPath != "" {
+		abs, err := filepath.Abs(configPath)
+		if err != nil {
+			return err
+		}
+		serviceArgs = append(serviceArgs, "--config", abs)
+	}
+	serviceArgs = append(serviceArgs, "service", "run", "--name", name, "--workdir", workdir, "--")
+	serviceArgs = append(serviceArgs, args...)
+
+	if displayName == "" {
+		displayName = name
+	}
+	if description == "" {
+		description = "db-backup " + args[0]
+	}
+	err = winservice.Install(winservice.Config{
+		Name:        name,
+		DisplayName: displayName,
+		Description: description,
+		Executable:  exe,
+		Args:        serviceArgs,
+		StartType:   startType,
+		Account:     account,
+		Password:    password,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Installed service %s\n", name)
+	fmt.Printf("  Command: %s %v\n", exe, serviceArgs)
+	fmt.Printf("  Start it with: db-backup service start %s\n", name)
+	return nil
+}
+
+func runServiceUninstall(cmd *cobra.Command, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), winservice.StopTimeout+10*time.Second)
+	defer cancel()
+	if err := winservice.Remove(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Removed service %s\n", args[0])
+	return nil
+}
+
+func runServiceRun(cmd *cobra.Command, args []string) error {
+	name, _ := cmd.Flags().GetString("name")
+	workdir, _ := cmd.Flags().GetString("workdir")
+
+	if ok, err := winservice.IsService(); err != nil || !ok {
+		return errors.New("service run is started by the service control manager; run the command directly instead")
+	}
+	if workdir != "" {
+		if err := os.Chdir(workdir); err != nil {
+			return err
+		}
+	}
+
+	target, rest, err := rootCmd.Find(args)
+	if err != nil {
+		return err
+	}
+	if target == rootCmd || target.RunE == nil {
+		return fmt.Errorf("unknown command %q to run as a service", args[0])
+	}
+	if err := target.ParseFlags(rest); err != nil {
+		return err
+	}
+	targetArgs := target.Flags().Args()
+	if err := target.ValidateArgs(targetArgs); err != nil {
+		return err
+	}
+
+	log := GetLogger()
+	return winservice.Run(name, func(ctx context.Context) error {
+		log.Info("Service started", map[string]interface{}{"service": name, "command": target.CommandPath()})
+		target.SetContext(ctx)
+		err := target.RunE(target, targetArgs)
+		if err != nil {
+			log.Error("Service command failed", err, map[string]interface{}{"service": name})
+		}
+		return err
+	})
+}
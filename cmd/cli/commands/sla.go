@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/sla"
+	"github.com/spf13/cobra"
+)
+
+// slaCmd groups RPO/RTO commands
+var slaCmd = &cobra.Command{
+	Use:   "sla",
+	Short: "Track recovery point and recovery time objectives",
+	Long: `Track recovery point (RPO) and recovery time (RTO) objectives per database.
+
+The recovery point age is the time since the newest usable backup; the
+recovery time estimate is the slowest of the recent restore rehearsals.
+Objectives are configured under sla.objectives.
+
+Examples:
+  # Show the recovery posture of every database
+  db-backup sla status
+
+  # Alert on new breaches (run from cron, e.g. every 5 minutes)
+  db-backup sla check
+
+  # Record a restore rehearsal measured by an external script
+  db-backup sla rehearsal orders --duration 14m32s --size 12884901888`,
+}
+
+// slaStatusCmd prints the RPO/RTO status
+var slaStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show recovery point age and recovery time estimate per database",
+	RunE:  runSLAStatus,
+}
+
+// slaCheckCmd sends alerts for breaches and recoveries
+var slaCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Notify about objectives that were breached or met again since the last check",
+	RunE:  runSLACheck,
+}
+
+// slaRehearsalCmd records a measured restore
+var slaRehearsalCmd = &cobra.Command{
+	Use:   "rehearsal <database>",
+	Short: "Record a restore rehearsal for the recovery time estimate",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSLARehearsal,
+}
+
+func init() {
+	rootCmd.AddCommand(slaCmd)
+	slaCmd.AddCommand(slaStatusCmd)
+	slaCmd.AddCommand(slaCheckCmd)
+	slaCmd.AddCommand(slaRehearsalCmd)
+
+	slaStatusCmd.Flags().String("format", "table", "output format (table|json|yaml)")
+
+	slaCheckCmd.Flags().Bool("fail-on-breach", false, "exit non-zero while any objective is breached")
+
+	slaRehearsalCmd.Flags().Duration("duration", 0, "time the restore took")
+	slaRehearsalCmd.Flags().Int64("size", 0, "restored size in bytes")
+	slaRehearsalCmd.Flags().Bool("failed", false, "the rehearsal failed (not used for the estimate)")
+	_ = slaRehearsalCmd.MarkFlagRequired("duration")
+}
+
+// slaTracker returns the configured tracker
+func slaTracker() (*sla.Tracker, error) {
+	cfg := GetConfig()
+	if !cfg.SLA.Enabled {
+		return nil, fmt.Errorf("SLA tracking is not enabled (sla.enabled)")
+	}
+	return sla.New(cfg.SLA), nil
+}
+
+func runSLAStatus(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+
+	tracker, err := slaTracker()
+	if err != nil {
+		return err
+	}
+	statuses, err := tracker.Status(time.Now())
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return printJSONValue(statuses)
+	case "yaml", "yml":
+		return printYAMLValue(statuses)
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("No databases tracked yet.")
+		return nil
+	}
+	fmt.Printf("%-24s %-14s %-10s %-14s %-10s %s\n", "DATABASE", "POINT AGE", "RPO", "RTO ESTIMATE", "RTO", "STATUS")
+	for _, s := range statuses {
+		age := "never"
+		if s.HasRecoveryPoint() {
+			age = formatObjective(s.PointAge)
+		}
+		estimate := "-"
+		if s.Rehearsals > 0 {
+			estimate = formatObjective(s.TimeEstimate)
+		}
+		status := "ok"
+		switch {
+		case s.RPOBreached && s.RTOBreached:
+			status = "RPO+RTO BREACHED"
+		case s.RPOBreached:
+			status = "RPO BREACHED"
+		case s.RTOBreached:
+			status = "RTO BREACHED"
+		}
+		fmt.Printf("%-24s %-14s %-10s %-14s %-10s %s\n", truncate(s.Database, 24), age,
+			formatObjective(s.RPO), estimate, formatObjective(s.RTO), status)
+	}
+	return nil
+}
+
+func runSLACheck(cmd *cobra.Command, args []string) error {
+	failOnBreach, _ := cmd.Flags().GetBool("fail-on-breach")
+
+	log := GetLogger()
+	cfg := GetConfig()
+
+	tracker, err := slaTracker()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	breaches, err := tracker.Check(now)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, b := range breaches {
+		n := b.Notification()
+		log.Warn(n.Title, map[string]interface{}{
+			"database":  b.Status.Database,
+			"objective": b.Kind,
+			"recovered": b.Recovered,
+		})
+		sendNotification(ctx, cfg, log, n)
+	}
+	fmt.Printf("✓ Checked objectives, %d change(s) notified\n", len(breaches))
+
+	if failOnBreach {
+		statuses, err := tracker.Status(now)
+		if err != nil {
+			return err
+		}
+		var breached []string
+		for _, s := range statuses {
+			if s.RPOBreached || s.RTOBreached {
+				breached = append(breached, s.Database)
+			}
+		}
+		if len(breached) > 0 {
+			return fmt.Errorf("objectives breached for: %s", strings.Join(breached, ", "))
+		}
+	}
+	return nil
+}
+
+func runSLARehearsal(cmd *cobra.Command, args []string) error {
+	duration, _ := cmd.Flags().GetDuration("duration")
+	size, _ := cmd.Flags().GetInt64("size")
+	failed, _ := cmd.Flags().GetBool("failed")
+
+	if duration <= 0 {
+		return fmt.Errorf("--duration must be positive")
+	}
+	tracker, err := slaTracker()
+	if err != nil {
+		return err
+	}
+	err = tracker.RecordRehearsal(args[0], sla.Rehearsal{
+		At:       time.Now(),
+		Duration: duration,
+		Size:     size,
+		Success:  !failed,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Rehearsal recorded for %s (%s)\n", args[0], duration)
+	return nil
+}
+
+// formatObjective prints a duration, or "-" when unset
+func formatObjective(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	if d >= time.Minute {
+		return d.Round(time.Minute).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -5,10 +5,22 @@ import (
 	"github.com/sanskarpan/db-backup/cmd/cli/commands"
 
 	// Register database drivers
+	_ "github.com/sanskarpan/db-backup/internal/database/clickhouse"
+	_ "github.com/sanskarpan/db-backup/internal/database/cockroachdb"
+	_ "github.com/sanskarpan/db-backup/internal/database/couchbase"
+	_ "github.com/sanskarpan/db-backup/internal/database/dynamodb"
+	_ "github.com/sanskarpan/db-backup/internal/database/etcd"
+	_ "github.com/sanskarpan/db-backup/internal/database/filedb"
+	_ "github.com/sanskarpan/db-backup/internal/database/influxdb"
+	_ "github.com/sanskarpan/db-backup/internal/database/mariadb"
 	_ "github.com/sanskarpan/db-backup/internal/database/mongodb"
 	_ "github.com/sanskarpan/db-backup/internal/database/mysql"
+	_ "github.com/sanskarpan/db-backup/internal/database/neo4j"
+	_ "github.com/sanskarpan/db-backup/internal/database/oracle"
 	_ "github.com/sanskarpan/db-backup/internal/database/postgres"
+	_ "github.com/sanskarpan/db-backup/internal/database/redis"
 	_ "github.com/sanskarpan/db-backup/internal/database/sqlite"
+	_ "github.com/sanskarpan/db-backup/internal/database/tidb"
 )
 
 func main() {
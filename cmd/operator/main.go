@@ -0,0 +1,66 @@
+// Package main is the entry point of the Kubernetes operator, which
+// reconciles DatabaseBackup, BackupSchedule and DatabaseRestore resources
+// against the db-backup server API
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/operator"
+)
+
+func main() {
+	server := flag.String("server", envOr("DBBACKUP_SERVER_URL", "http://db-backup:8080"), "db-backup server URL")
+	keyFile := flag.String("api-key-file", envOr("DBBACKUP_API_KEY_FILE", "/etc/db-backup-operator/api-key"), "file holding the API key used to call the server")
+	namespace := flag.String("namespace", os.Getenv("WATCH_NAMESPACE"), "namespace to watch (all namespaces when empty)")
+	interval := flag.Duration("interval", operator.DefaultInterval, "time between reconcile passes")
+	restoreTimeout := flag.Duration("restore-timeout", operator.DefaultRestoreTimeout, "maximum duration of a restore")
+	kubeServer := flag.String("kube-server", "", "Kubernetes API URL when running outside the cluster, e.g. through kubectl proxy")
+	logLevel := flag.String("log-level", envOr("DBBACKUP_LOG_LEVEL", "info"), "log level (debug|info|warn|error)")
+	flag.Parse()
+
+	log := logger.New(logger.Config{Level: *logLevel, Format: "json", Output: "stdout"})
+
+	key, err := os.ReadFile(*keyFile)
+	if err != nil {
+		log.Fatal("Failed to read API key", err)
+	}
+	apiKey := strings.TrimSpace(string(key))
+	if apiKey == "" {
+		log.Fatal("Failed to read API key", fmt.Errorf("%s is empty", *keyFile))
+	}
+
+	var kube *operator.Kube
+	if *kubeServer != "" {
+		kube = operator.NewKube(*kubeServer, "", nil)
+	} else if kube, err = operator.InCluster(); err != nil {
+		log.Fatal("Failed to configure the Kubernetes client", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	op := operator.New(kube, operator.NewClient(*server, apiKey, nil), operator.Options{
+		Namespace:      *namespace,
+		Interval:       *interval,
+		RestoreTimeout: *restoreTimeout,
+	}, log)
+	if err := op.Run(ctx); err != nil {
+		log.Fatal("Operator stopped", err)
+	}
+}
+
+// envOr returns the environment variable key, or def when unset
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
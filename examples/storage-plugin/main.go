@@ -0,0 +1,167 @@
+// Command dbbackup-storage-example is the reference storage provider
+// plugin. It stores objects as files under a directory, such as the file
+// share a tape gateway exposes, and shows what every provider must get
+// right: keys confined to the provider's namespace, uploads that are never
+// visible half written, and ErrNotFound for missing objects.
+//
+// Build and install it next to db-backup:
+//
+//	go build -o /etc/db-backup/plugins/dbbackup-storage-example ./examples/storage-plugin
+//
+// and enable it with
+//
+//	storage:
+//	  providers:
+//	    plugins:
+//	      example:
+//	        enabled: true
+//	        options:
+//	          root: /mnt/tape-gateway/backups
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/pkg/storageplugin"
+)
+
+func main() {
+	storageplugin.Serve(&dirProvider{})
+}
+
+// dirProvider stores every object as a file under root
+type dirProvider struct {
+	root string
+}
+
+func (p *dirProvider) Configure(ctx context.Context, options map[string]string) error {
+	root := options["root"]
+	if root == "" {
+		return fmt.Errorf("option root is required")
+	}
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return err
+	}
+	p.root = root
+	return nil
+}
+
+// path returns the file of key, refusing keys that would leave root
+func (p *dirProvider) path(key string) (string, error) {
+	if p.root == "" {
+		return "", fmt.Errorf("not configured")
+	}
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || clean != "/"+key {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(p.root, filepath.FromSlash(clean)), nil
+}
+
+// Upload writes a temporary file and renames it into place once complete,
+// so a failed upload never replaces an object
+func (p *dirProvider) Upload(ctx context.Context, key string, r io.Reader, size int64) error {
+	name, err := p.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("received %d bytes of %d", n, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (p *dirProvider) Download(ctx context.Context, key string, w io.Writer) error {
+	name, err := p.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return notFound(key, err)
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (p *dirProvider) Stat(ctx context.Context, key string) (*storageplugin.Object, error) {
+	name, err := p.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, notFound(key, err)
+	}
+	return &storageplugin.Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (p *dirProvider) List(ctx context.Context, prefix string) ([]storageplugin.Object, error) {
+	if p.root == "" {
+		return nil, fmt.Errorf("not configured")
+	}
+	var objects []storageplugin.Object
+	err := filepath.WalkDir(p.root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return err
+		}
+		rel, err := filepath.Rel(p.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, storageplugin.Object{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (p *dirProvider) Delete(ctx context.Context, key string) error {
+	name, err := p.path(key)
+	if err != nil {
+		return err
+	}
+	return notFound(key, os.Remove(name))
+}
+
+// notFound reports a missing file as storageplugin.ErrNotFound
+func notFound(key string, err error) error {
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", key, storageplugin.ErrNotFound)
+	}
+	return err
+}
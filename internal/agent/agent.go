@@ -0,0 +1,268 @@
+// Package agent runs dumps on remote database hosts for a central server
+// that cannot reach them. `db-backup agent` runs next to the database,
+// where the client tools and credentials live, and connects out to the
+// server over gRPC with mutual TLS, so no database port has to be opened.
+// The server sends jobs down that connection and each dump streams back
+// in chunks, checked against a SHA-256 the agent sends last.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/security/mtls"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// DumpFunc writes the dump of a job to w
+type DumpFunc func(ctx context.Context, job Job, w io.Writer) error
+
+// Agent connects to the server and runs the jobs it sends
+type Agent struct {
+	config    config.AgentConfig
+	dump      DumpFunc
+	logger    *logger.Logger
+	creds     credentials.TransportCredentials
+	chunkSize int
+	version   string
+
+	// slots bounds the dumps running at once
+	slots   chan struct{}
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	jobs    sync.WaitGroup
+}
+
+// New creates an agent. version is reported to the server.
+func New(cfg config.AgentConfig, version string, dump DumpFunc, log *logger.Logger) (*Agent, error) {
+	tlsCfg, err := mtls.ClientConfig(cfg.CAFile, cfg.CertFile, cfg.KeyFile, cfg.ServerName)
+	if err != nil {
+		return nil, err
+	}
+	if len(tlsCfg.Certificates) == 0 {
+		return nil, errors.New("agent needs a client certificate (agent.cert_file and agent.key_file)")
+	}
+	return newAgent(cfg, version, dump, log, credentials.NewTLS(tlsCfg))
+}
+
+func newAgent(cfg config.AgentConfig, version string, dump DumpFunc, log *logger.Logger, creds credentials.TransportCredentials) (*Agent, error) {
+	chunkSize, err := utils.ParseBytes(cfg.ChunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent.chunk_size: %w", err)
+	}
+	return &Agent{
+		config:    cfg,
+		dump:      dump,
+		logger:    log,
+		creds:     creds,
+		chunkSize: int(chunkSize),
+		version:   version,
+		slots:     make(chan struct{}, max(cfg.MaxConcurrent, 1)),
+		running:   make(map[string]context.CancelFunc),
+	}, nil
+}
+
+// Run stays connected to the server, reconnecting after failures, until
+// ctx is cancelled. Jobs still running then are cancelled and waited for.
+func (a *Agent) Run(ctx context.Context) error {
+	conn, err := grpc.NewClient(a.config.Server,
+		grpc.WithTransportCredentials(a.creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: 30 * time.Second, Timeout: 20 * time.Second, PermitWithoutStream: true}),
+	)
+	if err != nil {
+		return fmt.Errorf("invalid server address %q: %w", a.config.Server, err)
+	}
+	defer conn.Close()
+	defer a.jobs.Wait()
+
+	for {
+		err := a.session(ctx, conn)
+		if ctx.Err() != nil {
+			return nil
+		}
+		a.logger.Warn("Disconnected from server", map[string]interface{}{
+			"server": a.config.Server,
+			"error":  err.Error(),
+			"retry":  a.config.ReconnectInterval.String(),
+		})
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(a.config.ReconnectInterval):
+		}
+	}
+}
+
+// session runs one Connect stream, starting the jobs it receives
+func (a *Agent) session(ctx context.Context, conn *grpc.ClientConn) error {
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s, err := conn.NewStream(sctx, &serviceDesc.Streams[0], methodConnect)
+	if err != nil {
+		return err
+	}
+	name, _ := os.Hostname()
+	if err := s.SendMsg(&Hello{Name: name, Version: a.version, Profiles: a.config.Profiles}); err != nil {
+		return err
+	}
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+
+	connected := false
+	for {
+		var job Job
+		if err := s.RecvMsg(&job); err != nil {
+			return err
+		}
+		if !connected {
+			// The first message proves the server accepted the session
+			connected = true
+			a.logger.Info("Connected to server", map[string]interface{}{"server": a.config.Server})
+		}
+		if job.Cancel {
+			a.cancel(job.ID)
+			continue
+		}
+		a.start(ctx, conn, job)
+	}
+}
+
+// start runs a job in the background. Jobs outlive the session that
+// started them: their upload fails on its own if the connection is gone.
+func (a *Agent) start(ctx context.Context, conn *grpc.ClientConn, job Job) {
+	ctx, cancel := context.WithCancel(ctx)
+	a.mu.Lock()
+	a.running[job.ID] = cancel
+	a.mu.Unlock()
+
+	a.jobs.Add(1)
+	go func() {
+		defer a.jobs.Done()
+		defer func() {
+			a.mu.Lock()
+			delete(a.running, job.ID)
+			a.mu.Unlock()
+			cancel()
+		}()
+		select {
+		case a.slots <- struct{}{}:
+			defer func() { <-a.slots }()
+		case <-ctx.Done():
+			return
+		}
+		if err := a.run(ctx, conn, job); err != nil {
+			a.logger.Error("Dump failed", err, map[string]interface{}{
+				"job":      job.ID,
+				"profile":  job.Profile,
+				"database": job.Database,
+			})
+		}
+	}()
+}
+
+// cancel stops a running job
+func (a *Agent) cancel(id string) {
+	a.mu.Lock()
+	cancel, ok := a.running[id]
+	a.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// run dumps a job and uploads it
+func (a *Agent) run(ctx context.Context, conn *grpc.ClientConn, job Job) error {
+	up, err := conn.NewStream(ctx, &serviceDesc.Streams[1], methodUpload)
+	if err != nil {
+		return err
+	}
+	started := time.Now()
+
+	var dumpErr error
+	w := &chunkSender{jobID: job.ID, size: a.chunkSize, send: func(c *Chunk) error { return up.SendMsg(c) }}
+	hw := stream.NewHashWriter(w)
+	if len(a.config.Profiles) > 0 && !slices.Contains(a.config.Profiles, job.Profile) {
+		dumpErr = fmt.Errorf("profile %q is not served by this agent", job.Profile)
+	} else if dumpErr = a.dump(ctx, job, hw); dumpErr == nil {
+		dumpErr = w.flush()
+	}
+
+	last := &Chunk{JobID: job.ID, Done: true, Size: hw.Written(), Checksum: hw.Sum()}
+	if dumpErr != nil {
+		last = &Chunk{JobID: job.ID, Error: dumpErr.Error()}
+	}
+	if err := up.SendMsg(last); err != nil && dumpErr == nil {
+		dumpErr = err
+	}
+	if err := up.CloseSend(); err != nil && dumpErr == nil {
+		dumpErr = err
+	}
+	var result UploadResult
+	if err := up.RecvMsg(&result); err != nil && dumpErr == nil {
+		dumpErr = err
+	}
+	if dumpErr != nil {
+		return dumpErr
+	}
+	a.logger.Info("Dump uploaded", map[string]interface{}{
+		"job":      job.ID,
+		"profile":  job.Profile,
+		"database": job.Database,
+		"size":     utils.FormatBytes(result.Received),
+		"duration": time.Since(started).String(),
+	})
+	return nil
+}
+
+// chunkSender sends what is written to it as Data chunks of up to size
+// bytes, in order
+type chunkSender struct {
+	jobID string
+	size  int
+	send  func(*Chunk) error
+	buf   []byte
+}
+
+func (w *chunkSender) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.size)
+		}
+		n := min(len(p), w.size-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p, written = p[n:], written+n
+		if len(w.buf) == w.size {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush sends the buffered data
+func (w *chunkSender) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.send(&Chunk{JobID: w.jobID, Data: w.buf})
+	w.buf = w.buf[:0]
+	return err
+}
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/logger"
+)
+
+// startHub serves an insecure hub on a local port and connects an agent
+// using dump to it
+func startHub(t *testing.T, cfg config.AgentConfig, dump DumpFunc) *Hub {
+	t.Helper()
+	log := logger.New(logger.Config{Level: "fatal"}).WithOutput(io.Discard)
+
+	h := NewHub(log)
+	h.insecure = true
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := h.server()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cfg.Server = lis.Addr().String()
+	if cfg.ChunkSize == "" {
+		cfg.ChunkSize = "64KB"
+	}
+	cfg.ReconnectInterval = 50 * time.Millisecond
+	a, err := newAgent(cfg, "test", dump, log, insecure.NewCredentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.Agents()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("agent did not connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return h
+}
+
+func agentName(t *testing.T, h *Hub) string {
+	t.Helper()
+	agents := h.Agents()
+	if len(agents) != 1 {
+		t.Fatalf("agents = %v", agents)
+	}
+	return agents[0].Name
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	want := bytes.Repeat([]byte("INSERT INTO orders VALUES (1);\n"), 10000)
+	var got Job
+	h := startHub(t, config.AgentConfig{MaxConcurrent: 1}, func(ctx context.Context, job Job, w io.Writer) error {
+		got = job
+		// Uneven writes, so chunks span them
+		for off := 0; off < len(want); off += 1000 {
+			if _, err := w.Write(want[off:min(off+1000, len(want))]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	r, err := h.Dump(context.Background(), agentName(t, h), Job{Profile: "orders", Database: "shop", Tables: []string{"orders"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("received %d bytes, want %d", len(data), len(want))
+	}
+	if got.Profile != "orders" || got.Database != "shop" || len(got.Tables) != 1 || got.ID == "" {
+		t.Errorf("job = %+v", got)
+	}
+}
+
+func TestDumpError(t *testing.T) {
+	h := startHub(t, config.AgentConfig{MaxConcurrent: 1}, func(ctx context.Context, job Job, w io.Writer) error {
+		w.Write([]byte("partial"))
+		return errors.New("pg_dump: connection refused")
+	})
+
+	r, err := h.Dump(context.Background(), agentName(t, h), Job{Profile: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("err = %v, want the dump error", err)
+	}
+}
+
+func TestDumpUnservedProfile(t *testing.T) {
+	h := startHub(t, config.AgentConfig{MaxConcurrent: 1, Profiles: []string{"orders"}}, func(ctx context.Context, job Job, w io.Writer) error {
+		t.Error("dump ran for a profile the agent does not serve")
+		return nil
+	})
+
+	r, err := h.Dump(context.Background(), agentName(t, h), Job{Profile: "billing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "not served") {
+		t.Fatalf("err = %v, want profile not served", err)
+	}
+}
+
+func TestDumpCancel(t *testing.T) {
+	started, stopped := make(chan struct{}), make(chan struct{})
+	h := startHub(t, config.AgentConfig{MaxConcurrent: 1}, func(ctx context.Context, job Job, w io.Writer) error {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := h.Dump(ctx, agentName(t, h), Job{Profile: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	<-started
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dump kept running on the agent after cancel")
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("cancelled dump read without error")
+	}
+}
+
+func TestDumpNotConnected(t *testing.T) {
+	h := NewHub(logger.New(logger.Config{Level: "fatal"}).WithOutput(io.Discard))
+	if _, err := h.Dump(context.Background(), "db1", Job{Profile: "orders"}); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("err = %v, want ErrNotConnected", err)
+	}
+}
+
+func TestChunkSender(t *testing.T) {
+	var chunks []int
+	w := &chunkSender{jobID: "j", size: 4, send: func(c *Chunk) error {
+		chunks = append(chunks, len(c.Data))
+		return nil
+	}}
+	w.Write([]byte("abc"))
+	w.Write([]byte("defghij"))
+	w.flush()
+	if want := []int{4, 4, 2}; len(chunks) != len(want) || chunks[0] != 4 || chunks[1] != 4 || chunks[2] != 2 {
+		t.Fatalf("chunks = %v, want %v", chunks, want)
+	}
+}
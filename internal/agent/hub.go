@@ -0,0 +1,379 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/security/mtls"
+)
+
+var (
+	// ErrNotConnected is returned for jobs sent to an agent that is not
+	// connected
+	ErrNotConnected = errors.New("agent is not connected")
+	// ErrDisconnected fails a dump whose agent went away
+	ErrDisconnected = errors.New("agent disconnected")
+	// ErrChecksum fails a dump that arrived corrupted
+	ErrChecksum = errors.New("dump checksum mismatch")
+)
+
+// Info describes a connected agent
+type Info struct {
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	Address     string    `json:"address"`
+	Profiles    []string  `json:"profiles,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// session is the Connect stream of an agent
+type session struct {
+	info Info
+	jobs chan Job
+	done chan struct{}
+}
+
+// transfer is a dump on its way from an agent to the caller of Dump
+type transfer struct {
+	agent   string
+	pw      *io.PipeWriter
+	started bool
+	done    chan struct{}
+}
+
+// Hub is the server side of agent connections. Agents connect to it,
+// identified by their client certificate, and Dump hands them jobs and
+// returns the dumps they stream back.
+type Hub struct {
+	logger *logger.Logger
+	// insecure names agents after their Hello when they present no
+	// certificate; only tests set it
+	insecure bool
+
+	mu        sync.Mutex
+	sessions  map[string]*session
+	transfers map[string]*transfer
+}
+
+// NewHub creates a hub without agents
+func NewHub(log *logger.Logger) *Hub {
+	return &Hub{
+		logger:    log,
+		sessions:  make(map[string]*session),
+		transfers: make(map[string]*transfer),
+	}
+}
+
+// Server returns a gRPC server for the hub. tlsCfg must require and
+// verify client certificates: they are how agents are told apart.
+func (h *Hub) Server(tlsCfg *tls.Config) (*grpc.Server, error) {
+	if tlsCfg == nil || tlsCfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		return nil, errors.New("agent connections require mutual TLS")
+	}
+	return h.server(grpc.Creds(credentials.NewTLS(tlsCfg))), nil
+}
+
+func (h *Hub) server(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ForceServerCodec(codec{}),
+		// Chunks are up to 8MB (agent.chunk_size) plus framing
+		grpc.MaxRecvMsgSize(16<<20),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: 10 * time.Second, PermitWithoutStream: true}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: time.Minute, Timeout: 20 * time.Second}),
+	)
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&serviceDesc, h)
+	return s
+}
+
+// ListenAndServe accepts agents on cfg.Listen until ctx is cancelled
+func (h *Hub) ListenAndServe(ctx context.Context, cfg config.AgentHubConfig) error {
+	tlsConfig := cfg.TLS
+	tlsConfig.ClientAuth = mtls.ClientAuthRequire
+	tlsCfg, err := mtls.ServerConfig(tlsConfig)
+	if err != nil {
+		return err
+	}
+	s, err := h.Server(tlsCfg)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for agents: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		// Connect streams never end on their own, so don't wait for them
+		s.Stop()
+	}()
+	h.logger.Info("Accepting agents", map[string]interface{}{"address": lis.Addr().String()})
+	if err := s.Serve(lis); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// Agents lists the connected agents by name
+func (h *Hub) Agents() []Info {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]Info, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		out = append(out, s.info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Dump has agent dump a database and returns the dump as it streams in.
+// Reading fails if the dump fails, the agent disconnects or the data does
+// not match the checksum the agent sent. Closing the reader or cancelling
+// ctx stops the dump on the agent.
+func (h *Hub) Dump(ctx context.Context, agent string, job Job) (io.ReadCloser, error) {
+	job.ID, job.Cancel = newJobID(), false
+
+	h.mu.Lock()
+	s, ok := h.sessions[agent]
+	if !ok {
+		h.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrNotConnected, agent)
+	}
+	pr, pw := io.Pipe()
+	t := &transfer{agent: agent, pw: pw, done: make(chan struct{})}
+	h.transfers[job.ID] = t
+	h.mu.Unlock()
+
+	select {
+	case s.jobs <- job:
+	case <-s.done:
+		h.finish(job.ID, fmt.Errorf("%w: %s", ErrDisconnected, agent))
+		return nil, fmt.Errorf("%w: %s", ErrDisconnected, agent)
+	case <-ctx.Done():
+		h.finish(job.ID, ctx.Err())
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			// Best effort: the agent also stops when its upload fails
+			select {
+			case s.jobs <- Job{ID: job.ID, Cancel: true}:
+			case <-s.done:
+			case <-time.After(5 * time.Second):
+			}
+			h.finish(job.ID, ctx.Err())
+		}
+	}()
+	return &dumpReader{PipeReader: pr, cancel: cancel}, nil
+}
+
+// dumpReader stops the dump when closed
+type dumpReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *dumpReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+// finish ends a transfer, failing its reader unless err is nil
+func (h *Hub) finish(id string, err error) {
+	h.mu.Lock()
+	t, ok := h.transfers[id]
+	delete(h.transfers, id)
+	h.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err != nil {
+		t.pw.CloseWithError(err)
+	} else {
+		t.pw.Close()
+	}
+	close(t.done)
+}
+
+// connect serves an agent's session: jobs are sent down the stream until
+// the agent disconnects. A second connection of the same agent replaces
+// the first.
+func (h *Hub) connect(hello *Hello, stream grpc.ServerStream) error {
+	name := h.identity(stream.Context(), hello.Name)
+	if name == "" {
+		return status.Error(codes.Unauthenticated, "agents must present a client certificate")
+	}
+	s := &session{
+		info: Info{
+			Name:        name,
+			Version:     hello.Version,
+			Profiles:    hello.Profiles,
+			ConnectedAt: time.Now(),
+		},
+		jobs: make(chan Job),
+		done: make(chan struct{}),
+	}
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		s.info.Address = p.Addr.String()
+	}
+
+	h.mu.Lock()
+	if old, ok := h.sessions[name]; ok {
+		close(old.done)
+	}
+	h.sessions[name] = s
+	h.mu.Unlock()
+	h.logger.Info("Agent connected", map[string]interface{}{
+		"agent":   name,
+		"address": s.info.Address,
+		"version": hello.Version,
+	})
+
+	var err error
+	for err == nil {
+		select {
+		case job := <-s.jobs:
+			err = stream.SendMsg(&job)
+		case <-stream.Context().Done():
+			err = stream.Context().Err()
+		case <-s.done:
+			err = status.Error(codes.Aborted, "replaced by a newer connection of the same agent")
+		}
+	}
+
+	h.mu.Lock()
+	current := h.sessions[name] == s
+	var orphans []string
+	if current {
+		delete(h.sessions, name)
+		close(s.done)
+		// Jobs the agent never started will not be started now
+		for id, t := range h.transfers {
+			if t.agent == name && !t.started {
+				orphans = append(orphans, id)
+			}
+		}
+	}
+	h.mu.Unlock()
+	for _, id := range orphans {
+		h.finish(id, fmt.Errorf("%w: %s", ErrDisconnected, name))
+	}
+	h.logger.Info("Agent disconnected", map[string]interface{}{
+		"agent": name,
+		"error": err.Error(),
+	})
+	return err
+}
+
+// upload receives one job's dump and feeds it to the Dump reader
+func (h *Hub) upload(stream grpc.ServerStream) error {
+	name := h.identity(stream.Context(), "")
+	if name == "" && !h.insecure {
+		return status.Error(codes.Unauthenticated, "agents must present a client certificate")
+	}
+
+	var (
+		t        *transfer
+		jobID    string
+		received int64
+		sum      hash.Hash
+	)
+	for {
+		var c Chunk
+		err := stream.RecvMsg(&c)
+		if err != nil {
+			if t != nil {
+				if errors.Is(err, io.EOF) {
+					err = errors.New("upload ended before the dump finished")
+				}
+				h.finish(jobID, fmt.Errorf("%w: %s: %v", ErrDisconnected, t.agent, err))
+			}
+			return err
+		}
+
+		if t == nil {
+			h.mu.Lock()
+			t = h.transfers[c.JobID]
+			if t != nil && (name == "" || t.agent == name) {
+				t.started = true
+			} else {
+				t = nil
+			}
+			h.mu.Unlock()
+			if t == nil {
+				return status.Errorf(codes.NotFound, "no job %s for this agent", c.JobID)
+			}
+			jobID, sum = c.JobID, sha256.New()
+		}
+
+		switch {
+		case c.Error != "":
+			h.finish(jobID, fmt.Errorf("agent %s: %s", t.agent, c.Error))
+			return stream.SendMsg(&UploadResult{Received: received})
+		case len(c.Data) > 0:
+			if _, err := t.pw.Write(c.Data); err != nil {
+				// The reader was closed: the dump is no longer wanted
+				h.finish(jobID, err)
+				return status.Error(codes.Canceled, "dump no longer wanted")
+			}
+			sum.Write(c.Data)
+			received += int64(len(c.Data))
+		}
+		if c.Done {
+			var err error
+			if c.Size != received || !strings.EqualFold(c.Checksum, hex.EncodeToString(sum.Sum(nil))) {
+				err = fmt.Errorf("%w: agent %s sent %d bytes, received %d", ErrChecksum, t.agent, c.Size, received)
+			}
+			h.finish(jobID, err)
+			return stream.SendMsg(&UploadResult{Received: received})
+		}
+	}
+}
+
+// identity names the agent of a stream after its client certificate. An
+// insecure hub falls back to the name the agent gave.
+func (h *Hub) identity(ctx context.Context, claimed string) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			if id := mtls.Identity(&info.State); id != "" {
+				return id
+			}
+		}
+	}
+	if h.insecure {
+		return claimed
+	}
+	return ""
+}
+
+// newJobID returns a random job ID
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
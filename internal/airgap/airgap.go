@@ -0,0 +1,219 @@
+// Package airgap copies backups to an air-gapped vault: removable media
+// such as an LTFS tape or a USB disk, which is unmounted and taken offsite
+// once written, or an S3 bucket with Object Lock, which nothing can delete
+// before the retention date. Every copy is verified, and the copies of each
+// backup are tracked so it is known which backups survive the loss of the
+// primary storage, and on which medium.
+package airgap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// LabelFile names the file "db-backup airgap init" writes at the root of a
+// directory target, identifying the medium mounted there
+const LabelFile = ".dbbackup-airgap"
+
+var (
+	// ErrNoMedium is returned by directory targets whose path holds no
+	// labelled medium, which is usually an unmounted tape or disk
+	ErrNoMedium = errors.New("no vault medium is mounted")
+	// ErrExists is returned when the object being copied already exists on
+	// the target; vault copies are never replaced
+	ErrExists = errors.New("the object already exists in the vault")
+)
+
+// Target is somewhere backups are copied to
+type Target interface {
+	// Medium identifies what the target writes to: the label of the
+	// mounted medium, or the bucket. It fails when the medium is missing.
+	Medium(ctx context.Context) (string, error)
+	// Put copies the file at path to the object name, whose SHA-256 is
+	// checksum, locked until retainUntil where the target supports it
+	Put(ctx context.Context, name, path, checksum string, retainUntil time.Time) error
+	// Retention returns how long copies are locked; 0 when they are not
+	Retention() time.Duration
+	String() string
+}
+
+// NewTarget creates the target in cfg
+func NewTarget(cfg config.AirGapTargetConfig) (Target, error) {
+	switch cfg.Type {
+	case "directory":
+		return &DirTarget{Dir: cfg.Path, Prefix: cfg.Prefix}, nil
+	case "s3":
+		sink, err := audit.NewS3Sink(cfg.S3, cfg.LockMode)
+		if err != nil {
+			return nil, fmt.Errorf("air-gap target: %w", err)
+		}
+		return &S3Target{
+			sink:      sink,
+			bucket:    cfg.S3.Bucket,
+			prefix:    cfg.Prefix,
+			retention: time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown air-gap target type %q", cfg.Type)
+}
+
+// Label is the content of the label file
+type Label struct {
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DirTarget copies backups into a directory, the mount point of a medium
+// labelled with "db-backup airgap init". Copies are written under a
+// temporary name, read back and checked, then renamed and made read-only.
+type DirTarget struct {
+	Dir    string
+	Prefix string
+}
+
+// Init labels the medium mounted at the target directory. A medium is
+// labelled once.
+func (d *DirTarget) Init(label string) error {
+	if label == "" {
+		return errors.New("a label is required")
+	}
+	data, err := json.MarshalIndent(Label{Label: label, CreatedAt: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(d.Dir, LabelFile), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0400)
+	if os.IsExist(err) {
+		existing, _ := d.Medium(context.Background())
+		return fmt.Errorf("%s is already labelled %q", d.Dir, existing)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Medium implements Target, returning the label of the mounted medium
+func (d *DirTarget) Medium(_ context.Context) (string, error) {
+	data, err := os.ReadFile(filepath.Join(d.Dir, LabelFile))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w at %s (mount it, or label a new one with \"db-backup airgap init\")", ErrNoMedium, d.Dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	var l Label
+	if err := json.Unmarshal(data, &l); err != nil || l.Label == "" {
+		return "", fmt.Errorf("invalid air-gap label in %s", d.Dir)
+	}
+	return l.Label, nil
+}
+
+// Put implements Target. Directory targets have no retention lock, the
+// medium being offline is the protection.
+func (d *DirTarget) Put(ctx context.Context, name, src, checksum string, _ time.Time) error {
+	dst := filepath.Join(d.Dir, filepath.FromSlash(path.Join(d.Prefix, name)))
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%w: %s", ErrExists, dst)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return err
+	}
+	partial := dst + ".partial"
+	if err := copyFile(ctx, partial, src); err != nil {
+		os.Remove(partial)
+		return err
+	}
+
+	// Read the copy back: what matters is what the medium holds
+	sum, _, err := stream.HashFile(ctx, partial)
+	if err == nil && sum != checksum {
+		err = fmt.Errorf("the copy of %s on %s does not match its checksum", name, d.Dir)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	if err := os.Rename(partial, dst); err != nil {
+		return err
+	}
+	return os.Chmod(dst, 0400)
+}
+
+// Retention implements Target
+func (d *DirTarget) Retention() time.Duration {
+	return 0
+}
+
+func (d *DirTarget) String() string {
+	return d.Dir
+}
+
+// copyFile copies src to a new file dst and syncs it
+func copyFile(ctx context.Context, dst, src string) error {
+	in, err := os.Open(src) // #nosec G304 -- artifact path from backup metadata
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Copy(ctx, out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// S3Target copies backups to a bucket with Object Lock, each object locked
+// for the target's retention. The upload is checked by S3 against the
+// SHA-256 it is sent with. Objects are uploaded in a single request, so
+// artifacts are limited to 5GiB.
+type S3Target struct {
+	sink      *audit.S3Sink
+	bucket    string
+	prefix    string
+	retention time.Duration
+}
+
+// Medium implements Target
+func (s *S3Target) Medium(_ context.Context) (string, error) {
+	return s.sink.String(), nil
+}
+
+// Put implements Target
+func (s *S3Target) Put(ctx context.Context, name, src, _ string, retainUntil time.Time) error {
+	return s.sink.PutFile(ctx, path.Join(s.prefix, name), src, retainUntil)
+}
+
+// Retention implements Target
+func (s *S3Target) Retention() time.Duration {
+	return s.retention
+}
+
+func (s *S3Target) String() string {
+	return "s3://" + path.Join(s.bucket, s.prefix)
+}
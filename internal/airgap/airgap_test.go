@@ -0,0 +1,184 @@
+package airgap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// artifacts writes one artifact per backup and returns a source for them
+func artifacts(t *testing.T, data map[string][]byte) Source {
+	dir := t.TempDir()
+	for id, content := range data {
+		if err := os.MkdirAll(filepath.Join(dir, id), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, id, "dump.sql.gz"), content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func(_ context.Context, b Backup) (string, func(), error) {
+		return filepath.Join(dir, b.ID, "dump.sql.gz"), func() {}, nil
+	}
+}
+
+func TestDirTargetNeedsLabel(t *testing.T) {
+	d := &DirTarget{Dir: t.TempDir()}
+	if _, err := d.Medium(context.Background()); !errors.Is(err, ErrNoMedium) {
+		t.Fatalf("got %v", err)
+	}
+	if err := d.Init("TAPE0042"); err != nil {
+		t.Fatal(err)
+	}
+	label, err := d.Medium(context.Background())
+	if err != nil || label != "TAPE0042" {
+		t.Errorf("got %q, %v", label, err)
+	}
+	if err := d.Init("TAPE0043"); err == nil || !strings.Contains(err.Error(), "TAPE0042") {
+		t.Errorf("relabelled: %v", err)
+	}
+}
+
+func TestExportToDirectory(t *testing.T) {
+	ctx := context.Background()
+	data := map[string][]byte{"b1": []byte("first dump"), "b2": []byte("second dump")}
+	store := NewStore(config.AirGapConfig{StateFile: filepath.Join(t.TempDir(), "airgap.json")})
+	x := NewExporter(store, artifacts(t, data))
+
+	target := &DirTarget{Dir: t.TempDir(), Prefix: "dbbackup"}
+	backups := []Backup{
+		{ID: "b1", Database: "orders", Checksum: checksum(data["b1"])},
+		{ID: "b2", Database: "orders", Checksum: checksum([]byte("something else"))},
+	}
+
+	// Nothing is copied until a medium is mounted
+	if err := x.Export(ctx, "tape", target, backups, func(Result) { t.Error("copied without a medium") }); !errors.Is(err, ErrNoMedium) {
+		t.Fatalf("got %v", err)
+	}
+	if err := target.Init("TAPE0042"); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []Result
+	if err := x.Export(ctx, "tape", target, backups, func(r Result) { results = append(results, r) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Err != nil || results[1].Err == nil {
+		t.Fatalf("got %+v", results)
+	}
+	c := results[0].Copy
+	if c.Status != StatusCopied || c.Medium != "TAPE0042" || c.Object != "orders/b1/dump.sql.gz" || c.Size != int64(len(data["b1"])) {
+		t.Errorf("got %+v", c)
+	}
+	copied := filepath.Join(target.Dir, "dbbackup", "orders", "b1", "dump.sql.gz")
+	if got, err := os.ReadFile(copied); err != nil || string(got) != "first dump" {
+		t.Errorf("copy: got %q, %v", got, err)
+	}
+	if info, err := os.Stat(copied); err != nil || info.Mode().Perm() != 0o400 {
+		t.Errorf("copy is not read-only: %v, %v", info, err)
+	}
+	// The artifact that does not match the catalog is not copied
+	if _, err := os.Stat(filepath.Join(target.Dir, "dbbackup", "orders", "b2")); !os.IsNotExist(err) {
+		t.Errorf("corrupt artifact copied: %v", err)
+	}
+
+	copies, err := store.Copies("b2")
+	if err != nil || len(copies) != 1 || copies[0].Status != StatusFailed || copies[0].Error == "" {
+		t.Errorf("failure: got %+v, %v", copies, err)
+	}
+
+	// A second run skips the copied backup and retries the failed one
+	results = nil
+	if err := x.Export(ctx, "tape", target, backups, func(r Result) { results = append(results, r) }); err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Skipped || results[1].Skipped {
+		t.Errorf("got %+v", results)
+	}
+	if ok, _ := store.Copied("b1", "tape"); !ok {
+		t.Error("b1 is not recorded as copied")
+	}
+}
+
+func TestDirTargetNeverReplaces(t *testing.T) {
+	ctx := context.Background()
+	src := filepath.Join(t.TempDir(), "dump")
+	if err := os.WriteFile(src, []byte("dump"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d := &DirTarget{Dir: t.TempDir()}
+	if err := d.Put(ctx, "db/b1/dump", src, checksum([]byte("dump")), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, "db/b1/dump", src, checksum([]byte("dump")), time.Time{}); !errors.Is(err, ErrExists) {
+		t.Errorf("got %v", err)
+	}
+	// A copy that reads back wrong is discarded
+	if err := d.Put(ctx, "db/b2/dump", src, checksum([]byte("other")), time.Time{}); err == nil {
+		t.Error("expected a checksum error")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(d.Dir, "db", "b2")); len(entries) != 0 {
+		t.Errorf("left behind %v", entries)
+	}
+}
+
+func TestExportToS3(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	target, err := NewTarget(config.AirGapTargetConfig{
+		Type:   "s3",
+		Prefix: "vault",
+		S3: config.AuditS3Config{
+			Bucket: "backups-worm", Region: "eu-west-1", Endpoint: srv.URL, UsePathStyle: true,
+			AccessKey: "AKID", SecretKey: "secret",
+		},
+		RetentionDays: 90,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string][]byte{"b1": []byte("first dump")}
+	store := NewStore(config.AirGapConfig{StateFile: filepath.Join(t.TempDir(), "airgap.json")})
+	x := NewExporter(store, artifacts(t, data))
+	now := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	x.now = func() time.Time { return now }
+
+	var result Result
+	err = x.Export(context.Background(), "worm", target, []Backup{{ID: "b1", Database: "orders"}}, func(r Result) { result = r })
+	if err != nil || result.Err != nil {
+		t.Fatal(err, result.Err)
+	}
+	if got.URL.Path != "/backups-worm/vault/orders/b1/dump.sql.gz" || string(body) != "first dump" {
+		t.Errorf("request = %s %q", got.URL.Path, body)
+	}
+	want := now.Add(90 * 24 * time.Hour)
+	if v := got.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"); v != want.Format(time.RFC3339) {
+		t.Errorf("retain until %q", v)
+	}
+	if c := result.Copy; c.RetainUntil == nil || !c.RetainUntil.Equal(want) || c.Medium != "s3://backups-worm" {
+		t.Errorf("got %+v", c)
+	}
+}
@@ -0,0 +1,131 @@
+package airgap
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// Backup is a backup to copy to the vault
+type Backup struct {
+	ID       string
+	Database string
+	Checksum string // SHA-256 recorded in the catalog; empty skips the check
+}
+
+// Source makes the artifact of a backup available as a local file,
+// returning a function that releases it once copied
+type Source func(ctx context.Context, b Backup) (path string, release func(), err error)
+
+// Result is the outcome of copying one backup
+type Result struct {
+	Backup  Backup
+	Copy    *Copy // nil when skipped, or when the copy was interrupted
+	Skipped bool  // already copied to the target
+	Err     error
+}
+
+// Exporter copies backups to vault targets and records the copies
+type Exporter struct {
+	Store  *Store
+	Source Source
+	now    func() time.Time
+}
+
+// NewExporter creates an exporter reading artifacts from source
+func NewExporter(store *Store, source Source) *Exporter {
+	return &Exporter{Store: store, Source: source, now: time.Now}
+}
+
+// Export copies backups to the target called name, skipping those already
+// copied there, and passes each result to report. It fails before copying
+// anything when the target's medium is missing; a failed copy is recorded
+// and reported without stopping the export.
+func (x *Exporter) Export(ctx context.Context, name string, t Target, backups []Backup, report func(Result)) error {
+	medium, err := t.Medium(ctx)
+	if err != nil {
+		return err
+	}
+	for _, b := range backups {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report(x.export(ctx, name, medium, t, b))
+	}
+	return nil
+}
+
+func (x *Exporter) export(ctx context.Context, name, medium string, t Target, b Backup) Result {
+	r := Result{Backup: b}
+	copied, err := x.Store.Copied(b.ID, name)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	if copied {
+		r.Skipped = true
+		return r
+	}
+
+	c := Copy{
+		BackupID: b.ID,
+		Database: b.Database,
+		Target:   name,
+		Medium:   medium,
+		Status:   StatusCopied,
+		CopiedAt: x.now().UTC(),
+	}
+	r.Err = x.copy(ctx, t, b, &c)
+	if r.Err != nil && ctx.Err() != nil {
+		// Interrupted, not failed
+		return r
+	}
+	if r.Err != nil {
+		c.Status, c.Error = StatusFailed, r.Err.Error()
+	}
+	if err := x.Store.Record(c); err != nil && r.Err == nil {
+		r.Err = err
+	}
+	r.Copy = &c
+	return r
+}
+
+// copy copies the artifact of b to t, filling in c
+func (x *Exporter) copy(ctx context.Context, t Target, b Backup, c *Copy) error {
+	src, release, err := x.Source(ctx, b)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// A corrupt artifact is better found now than when the vault is needed
+	sum, size, err := stream.HashFile(ctx, src)
+	if err != nil {
+		return err
+	}
+	if b.Checksum != "" && sum != b.Checksum {
+		return fmt.Errorf("the artifact does not match the checksum in the catalog")
+	}
+
+	c.Object = ObjectName(b, src)
+	var retainUntil time.Time
+	if d := t.Retention(); d > 0 {
+		retainUntil = c.CopiedAt.Add(d)
+		c.RetainUntil = &retainUntil
+	}
+	if err := t.Put(ctx, c.Object, src, sum, retainUntil); err != nil {
+		return err
+	}
+	c.Size, c.Checksum = size, sum
+	return nil
+}
+
+// ObjectName is where the artifact at src of a backup is copied to:
+// <database>/<backup ID>/<file name>
+func ObjectName(b Backup, src string) string {
+	return path.Join(b.Database, b.ID, filepath.Base(src))
+}
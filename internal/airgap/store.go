@@ -0,0 +1,163 @@
+package airgap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Copy statuses
+const (
+	StatusCopied = "copied"
+	StatusFailed = "failed"
+)
+
+// Copy is a backup copied to a vault target, or the last failed attempt
+// at it
+type Copy struct {
+	BackupID    string     `json:"backup_id"`
+	Database    string     `json:"database"`
+	Target      string     `json:"target"`
+	Medium      string     `json:"medium,omitempty"` // medium label, or bucket
+	Object      string     `json:"object,omitempty"`
+	Status      string     `json:"status"`
+	Size        int64      `json:"size,omitempty"`
+	Checksum    string     `json:"checksum,omitempty"`
+	CopiedAt    time.Time  `json:"copied_at"`
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// state is the persisted copy status, by backup ID then target
+type state struct {
+	Copies map[string]map[string]*Copy `json:"copies"`
+}
+
+// Store keeps the copy status of each backup in a state file
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore creates a store
+func NewStore(cfg config.AirGapConfig) *Store {
+	return &Store{path: cfg.StateFile}
+}
+
+// Record stores the outcome of a copy. A failure never replaces a
+// successful copy to the same target.
+func (s *Store) Record(c Copy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+	copies := st.Copies[c.BackupID]
+	if copies == nil {
+		copies = make(map[string]*Copy)
+		st.Copies[c.BackupID] = copies
+	}
+	if existing, ok := copies[c.Target]; ok && existing.Status == StatusCopied && c.Status != StatusCopied {
+		return nil
+	}
+	copies[c.Target] = &c
+	return s.save(st)
+}
+
+// Copied reports whether a backup was copied to a target
+func (s *Store) Copied(backupID, target string) (bool, error) {
+	copies, err := s.Copies(backupID)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range copies {
+		if c.Target == target && c.Status == StatusCopied {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Copies returns the copies of a backup, newest first
+func (s *Store) Copies(backupID string) ([]Copy, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var out []Copy
+	for _, c := range all {
+		if c.BackupID == backupID {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// List returns every copy, newest first
+func (s *Store) List() ([]Copy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	var out []Copy
+	for _, copies := range st.Copies {
+		for _, c := range copies {
+			out = append(out, *c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CopiedAt.Equal(out[j].CopiedAt) {
+			return out[i].CopiedAt.After(out[j].CopiedAt)
+		}
+		return out[i].Target < out[j].Target
+	})
+	return out, nil
+}
+
+func (s *Store) load() (*state, error) {
+	st := &state{Copies: make(map[string]map[string]*Copy)}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read air-gap state: %w", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse air-gap state: %w", err)
+	}
+	if st.Copies == nil {
+		st.Copies = make(map[string]map[string]*Copy)
+	}
+	return st, nil
+}
+
+// save writes the state atomically
+func (s *Store) save(st *state) error {
+	if s.path == "" {
+		return fmt.Errorf("air-gap state_file is not configured")
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal air-gap state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0750); err != nil {
+		return fmt.Errorf("failed to create air-gap state directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write air-gap state: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sanskarpan/db-backup/internal/audit"
+)
+
+// SetAuditLog records security-relevant API actions, such as releases,
+// revocations and policy denials, in the hash-chained audit log
+func (s *Server) SetAuditLog(l *audit.Log) {
+	s.auditLog = l
+}
+
+// recordAudit appends an event for the caller to the audit log, logging
+// rather than failing the request when it cannot be written
+func (s *Server) recordAudit(c *gin.Context, action, resource string, details map[string]string) {
+	if s.auditLog == nil {
+		return
+	}
+	if _, err := s.auditLog.Append(s.requestActor(c), action, resource, details); err != nil {
+		s.logger.Error("Failed to record audit event", err, map[string]interface{}{
+			"action":   action,
+			"resource": resource,
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sanskarpan/db-backup/internal/auth"
+	"github.com/sanskarpan/db-backup/internal/auth/ldap"
+	"github.com/sanskarpan/db-backup/internal/auth/session"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+var errLoginDisabled = errors.New("no login backend is configured")
+
+// LoginRequest is the body of POST /auth/login
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// LoginResponse carries the session token issued after a login
+type LoginResponse struct {
+	Token     string         `json:"token"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	User      *auth.Identity `json:"user"`
+	// SessionID identifies the token in the session store, when enabled
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// SetAuthenticator enables username/password logins on /auth/login, e.g.
+// against LDAP or Active Directory
+func (s *Server) SetAuthenticator(a auth.Authenticator) {
+	s.authenticator = a
+}
+
+// ConfigureLogin enables /auth/login with the LDAP / Active Directory
+// backend of security.ldap. Logins stay disabled when it is not enabled.
+func (s *Server) ConfigureLogin(cfg config.SecurityConfig) error {
+	a, err := ldap.FromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to set up LDAP logins: %w", err)
+	}
+	if a != nil {
+		s.SetAuthenticator(a)
+	}
+	return nil
+}
+
+// handleLogin verifies the credentials with the configured backend and
+// issues a JWT carrying the user's role, recorded in the session store when
+// one is set. Locked out users and IPs are refused before the backend is
+// asked.
+func (s *Server) handleLogin(c *gin.Context) {
+	if s.authenticator == nil {
+		s.respondError(c, http.StatusNotFound, errLoginDisabled, "Login unavailable")
+		return
+	}
+
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.respondError(c, http.StatusBadRequest, err, "Invalid login request")
+		return
+	}
+	if !s.checkLockout(c, req.Username) {
+		return
+	}
+
+	identity, err := s.authenticator.Authenticate(c.Request.Context(), req.Username, req.Password)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		s.loginFailed(c, req.Username)
+		s.respondError(c, http.StatusUnauthorized, err, "Login failed")
+		return
+	case errors.Is(err, auth.ErrNoRole):
+		s.respondError(c, http.StatusForbidden, err, "Login failed")
+		return
+	case err != nil:
+		s.respondError(c, http.StatusBadGateway, err, "Authentication backend unavailable")
+		return
+	}
+	if s.lockout != nil {
+		s.lockout.Success(req.Username, c.ClientIP())
+	}
+
+	ttl := s.config.JWTExpiration
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	expiresAt := time.Now().Add(ttl)
+	signed, sess, err := s.issueToken(c, session.KindToken, identity.Username, identity.Role, identity.Provider,
+		jwt.MapClaims{"groups": identity.Groups, "provider": identity.Provider}, expiresAt)
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to issue token")
+		return
+	}
+
+	s.logger.Info("User logged in", map[string]interface{}{
+		"username": identity.Username,
+		"role":     identity.Role,
+		"provider": identity.Provider,
+	})
+	resp := LoginResponse{Token: signed, ExpiresAt: expiresAt, User: identity}
+	if sess != nil {
+		resp.SessionID = sess.ID
+	}
+	s.respondSuccess(c, resp)
+}
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sanskarpan/db-backup/internal/security/mtls"
+)
+
+// ClientIdentityKey is the gin context key holding the name from a verified
+// client certificate when the server runs with mutual TLS
+const ClientIdentityKey = "client_identity"
+
+// clientCertMiddleware records which machine made the request. Certificates
+// are verified, checked against the CRL and the allowed names during the
+// TLS handshake, so only the identity is left to pick up here.
+func (s *Server) clientCertMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id := mtls.Identity(c.Request.TLS); id != "" {
+			c.Set(ClientIdentityKey, id)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sanskarpan/db-backup/internal/drcopy"
+)
+
+var errDRDisabled = errors.New("DR copies are not enabled")
+
+// SetDRCopies exposes the DR copy status of backups through
+// /backups/:id/dr and /dr/copies
+func (s *Server) SetDRCopies(store *drcopy.Store) {
+	s.drCopies = store
+}
+
+// drStore returns the DR copy store, responding with 503 when disabled
+func (s *Server) drStore(c *gin.Context) (*drcopy.Store, bool) {
+	if s.drCopies == nil {
+		s.respondError(c, http.StatusServiceUnavailable, errDRDisabled, "DR copies unavailable")
+		return nil, false
+	}
+	return s.drCopies, true
+}
+
+// handleGetBackupDRCopies returns the DR copies of a backup; copied is
+// true when at least one job holds a copy
+func (s *Server) handleGetBackupDRCopies(c *gin.Context) {
+	store, ok := s.drStore(c)
+	if !ok {
+		return
+	}
+	copies, err := store.Copies(c.Param("id"))
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to get DR copies")
+		return
+	}
+	copied := false
+	for _, cp := range copies {
+		if cp.Status == drcopy.StatusCopied {
+			copied = true
+		}
+	}
+	s.respondSuccess(c, gin.H{"backup_id": c.Param("id"), "copies": copies, "copied": copied})
+}
+
+// handleListDRCopies lists DR copies, filtered by ?job= and ?status=
+func (s *Server) handleListDRCopies(c *gin.Context) {
+	store, ok := s.drStore(c)
+	if !ok {
+		return
+	}
+	copies, err := store.List()
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to list DR copies")
+		return
+	}
+	job, status := c.Query("job"), c.Query("status")
+	filtered := make([]drcopy.Copy, 0, len(copies))
+	failed := 0
+	for _, cp := range copies {
+		if (job != "" && cp.Job != job) || (status != "" && cp.Status != status) {
+			continue
+		}
+		if cp.Status == drcopy.StatusFailed {
+			failed++
+		}
+		filtered = append(filtered, cp)
+	}
+	s.respondSuccess(c, gin.H{"copies": filtered, "count": len(filtered), "failed": failed})
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errFreshnessDisabled = errors.New("the backup freshness watchdog is not enabled")
+
+// handleGetFreshnessStats reports how long every database has gone
+// without a successful backup against its expected interval
+func (s *Server) handleGetFreshnessStats(c *gin.Context) {
+	if s.freshness == nil {
+		s.respondError(c, http.StatusServiceUnavailable, errFreshnessDisabled, "Freshness watchdog unavailable")
+		return
+	}
+	statuses, err := s.freshness.Status(c.Request.Context(), time.Now())
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to compute backup freshness")
+		return
+	}
+
+	stale := 0
+	for _, st := range statuses {
+		if st.Stale {
+			stale++
+		}
+	}
+	s.respondSuccess(c, gin.H{"databases": statuses, "count": len(statuses), "stale": stale})
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/auth/lockout"
+	"github.com/sanskarpan/db-backup/internal/events"
+)
+
+var (
+	errLockoutDisabled = errors.New("login lockout is not enabled")
+	errUnlockTarget    = errors.New("exactly one of user or ip is required")
+)
+
+// UnlockRequest is the body of POST /auth/lockouts/unlock
+type UnlockRequest struct {
+	User string `json:"user"`
+	IP   string `json:"ip"`
+}
+
+// SetLockout enables brute-force protection on /auth/login: users and
+// client IPs with too many failed logins are locked out, and lockouts and
+// suspicious patterns are announced
+func (s *Server) SetLockout(t *lockout.Tracker) {
+	s.lockout = t
+}
+
+// lockoutTracker returns the lockout tracker, responding with 503 when
+// disabled
+func (s *Server) lockoutTracker(c *gin.Context) (*lockout.Tracker, bool) {
+	if s.lockout == nil {
+		s.respondError(c, http.StatusServiceUnavailable, errLockoutDisabled, "Lockout unavailable")
+		return nil, false
+	}
+	return s.lockout, true
+}
+
+// checkLockout responds with 429 and Retry-After when the user or the
+// caller's IP is locked out
+func (s *Server) checkLockout(c *gin.Context, username string) bool {
+	if s.lockout == nil {
+		return true
+	}
+	err := s.lockout.Check(username, c.ClientIP())
+	var locked *lockout.LockedError
+	if !errors.As(err, &locked) {
+		return true
+	}
+	retry := math.Ceil(locked.RetryAfter(time.Now()).Seconds())
+	c.Header("Retry-After", strconv.Itoa(int(retry)))
+	s.respondError(c, http.StatusTooManyRequests, err, "Login failed")
+	return false
+}
+
+// loginFailed counts a failed login and announces the lockouts and
+// suspicious patterns it raises
+func (s *Server) loginFailed(c *gin.Context, username string) {
+	if s.lockout == nil {
+		return
+	}
+	for _, a := range s.lockout.Failure(username, c.ClientIP()) {
+		s.logger.Warn(a.Notification().Title, map[string]interface{}{
+			"kind":     a.Kind,
+			"username": a.User,
+			"ip":       a.IP,
+			"failures": a.Failures,
+			"distinct": a.Distinct,
+		})
+		resource := "user:" + a.User
+		if a.User == "" {
+			resource = "ip:" + a.IP
+		}
+		details := map[string]string{"alert": a.Kind, "failures": strconv.Itoa(a.Failures)}
+		if !a.Until.IsZero() {
+			details["until"] = a.Until.UTC().Format(time.RFC3339)
+		}
+		if a.Distinct > 0 {
+			details["distinct"] = strconv.Itoa(a.Distinct)
+		}
+		s.recordAudit(c, audit.ActionLoginLocked, resource, details)
+		n := a.Notification()
+		s.announce(c, n)
+		if err := s.events.Publish(c.Request.Context(), events.NewAlert("lockout", n)); err != nil {
+			s.logger.Warn("Failed to publish event", map[string]interface{}{
+				"type":  string(events.TypeAlertRaised),
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+// handleListLockouts lists the users and IPs with recent failed logins or
+// lockouts
+func (s *Server) handleListLockouts(c *gin.Context) {
+	tracker, ok := s.lockoutTracker(c)
+	if !ok || !s.requireAdmin(c) {
+		return
+	}
+	lockouts := tracker.Lockouts()
+	s.respondSuccess(c, gin.H{"lockouts": lockouts, "count": len(lockouts)})
+}
+
+// handleUnlock lifts the lockout of a user or client IP, e.g. after
+// confirming a user only mistyped their password
+func (s *Server) handleUnlock(c *gin.Context) {
+	tracker, ok := s.lockoutTracker(c)
+	if !ok || !s.requireAdmin(c) {
+		return
+	}
+	var req UnlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.respondError(c, http.StatusBadRequest, err, "Invalid unlock request")
+		return
+	}
+	kind, key := lockout.KindUser, req.User
+	switch {
+	case req.User != "" && req.IP == "":
+	case req.IP != "" && req.User == "":
+		kind, key = lockout.KindIP, req.IP
+	default:
+		s.respondError(c, http.StatusBadRequest, errUnlockTarget, "Invalid unlock request")
+		return
+	}
+
+	if !tracker.Unlock(kind, key) {
+		s.respondError(c, http.StatusNotFound, fmt.Errorf("no failed logins recorded for %s %s", kind, key), "Failed to unlock")
+		return
+	}
+	s.logger.Warn("Login lockout lifted", map[string]interface{}{
+		"kind":  kind,
+		"key":   key,
+		"actor": s.requestActor(c),
+	})
+	s.recordAudit(c, audit.ActionLoginUnlocked, kind+":"+key, nil)
+	s.respondSuccessWithMessage(c, "Unlocked", gin.H{"kind": kind, "key": key})
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+var errQueueDisabled = errors.New("notification queue is not enabled")
+
+// queue returns the notification queue, responding with 503 when disabled
+func (s *Server) queue(c *gin.Context) (*notification.Queue, bool) {
+	if s.notifyQueue == nil {
+		s.respondError(c, http.StatusServiceUnavailable, errQueueDisabled, "Notification queue unavailable")
+		return nil, false
+	}
+	return s.notifyQueue, true
+}
+
+// handleListNotificationQueue lists deliveries awaiting retry
+func (s *Server) handleListNotificationQueue(c *gin.Context) {
+	q, ok := s.queue(c)
+	if !ok {
+		return
+	}
+	deliveries, err := q.Pending()
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to list queued notifications")
+		return
+	}
+	s.respondSuccess(c, gin.H{"deliveries": deliveries, "count": len(deliveries)})
+}
+
+// handleFlushNotificationQueue attempts every due delivery now
+func (s *Server) handleFlushNotificationQueue(c *gin.Context) {
+	q, ok := s.queue(c)
+	if !ok {
+		return
+	}
+	delivered, err := q.ProcessDue(c.Request.Context())
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to process notification queue")
+		return
+	}
+	s.respondSuccess(c, gin.H{"delivered": delivered})
+}
+
+// handleListDeadLetters lists deliveries that exhausted their retries
+func (s *Server) handleListDeadLetters(c *gin.Context) {
+	q, ok := s.queue(c)
+	if !ok {
+		return
+	}
+	deliveries, err := q.DeadLetters()
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to list dead letters")
+		return
+	}
+	s.respondSuccess(c, gin.H{"deliveries": deliveries, "count": len(deliveries)})
+}
+
+// handleGetDeadLetter returns a single dead-lettered delivery
+func (s *Server) handleGetDeadLetter(c *gin.Context) {
+	q, ok := s.queue(c)
+	if !ok {
+		return
+	}
+	d, err := q.DeadLetter(c.Param("id"))
+	if err != nil {
+		s.respondError(c, deadLetterStatus(err), err, "Failed to get dead letter")
+		return
+	}
+	s.respondSuccess(c, d)
+}
+
+// handleRetryDeadLetter moves a dead-lettered delivery back to the queue
+func (s *Server) handleRetryDeadLetter(c *gin.Context) {
+	q, ok := s.queue(c)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+	if err := q.Retry(id); err != nil {
+		s.respondError(c, deadLetterStatus(err), err, "Failed to retry dead letter")
+		return
+	}
+	s.respondSuccessWithMessage(c, "Delivery requeued", gin.H{"id": id})
+}
+
+// handleDiscardDeadLetter deletes a dead-lettered delivery
+func (s *Server) handleDiscardDeadLetter(c *gin.Context) {
+	q, ok := s.queue(c)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+	if err := q.Discard(id); err != nil {
+		s.respondError(c, deadLetterStatus(err), err, "Failed to discard dead letter")
+		return
+	}
+	s.respondSuccessWithMessage(c, "Delivery discarded", gin.H{"id": id})
+}
+
+// deadLetterStatus maps queue lookup errors to HTTP status codes
+func deadLetterStatus(err error) int {
+	switch {
+	case errors.Is(err, notification.ErrDeliveryNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, notification.ErrInvalidDeliveryID):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sanskarpan/db-backup/internal/quota"
+)
+
+var errQuotasDisabled = errors.New("no storage quotas are configured")
+
+// handleGetQuotaStats reports the usage of every storage quota
+func (s *Server) handleGetQuotaStats(c *gin.Context) {
+	if s.quotas == nil || !s.quotas.Enabled() {
+		s.respondError(c, http.StatusServiceUnavailable, errQuotasDisabled, "Storage quotas unavailable")
+		return
+	}
+	usages, err := s.quotas.Usage(c.Request.Context())
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to compute quota usage")
+		return
+	}
+
+	warning, exceeded := 0, 0
+	for _, u := range usages {
+		switch u.Status {
+		case quota.StatusWarning:
+			warning++
+		case quota.StatusExceeded:
+			exceeded++
+		}
+	}
+	s.respondSuccess(c, gin.H{"quotas": usages, "count": len(usages), "warning": warning, "exceeded": exceeded})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sanskarpan/db-backup/internal/runbook"
+)
+
+var errRunbooksDisabled = errors.New("recovery runbooks are not enabled")
+
+// SetRunbooks exposes the recovery runbooks through /runbooks
+func (s *Server) SetRunbooks(store *runbook.Store) {
+	s.runbooks = store
+}
+
+// runbookStore returns the runbook store, responding with 503 when
+// disabled
+func (s *Server) runbookStore(c *gin.Context) (*runbook.Store, bool) {
+	if s.runbooks == nil {
+		s.respondError(c, http.StatusServiceUnavailable, errRunbooksDisabled, "Recovery runbooks unavailable")
+		return nil, false
+	}
+	return s.runbooks, true
+}
+
+// handleListRunbooks lists the runbook of every database
+func (s *Server) handleListRunbooks(c *gin.Context) {
+	store, ok := s.runbookStore(c)
+	if !ok {
+		return
+	}
+	runbooks, err := store.List()
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to list runbooks")
+		return
+	}
+	s.respondSuccess(c, gin.H{"runbooks": runbooks, "count": len(runbooks)})
+}
+
+// handleGetRunbook returns the runbook of a database, rendered as Markdown
+// with ?format=markdown
+func (s *Server) handleGetRunbook(c *gin.Context) {
+	store, ok := s.runbookStore(c)
+	if !ok {
+		return
+	}
+	rb, err := store.Get(c.Param("database"))
+	if errors.Is(err, runbook.ErrNotFound) {
+		s.respondError(c, http.StatusNotFound, err, "Runbook not found")
+		return
+	}
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to get runbook")
+		return
+	}
+
+	switch strings.ToLower(c.Query("format")) {
+	case "markdown", "md":
+		var b strings.Builder
+		if err := rb.WriteMarkdown(&b); err != nil {
+			s.respondError(c, http.StatusInternalServerError, err, "Failed to render runbook")
+			return
+		}
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(b.String()))
+	default:
+		s.respondSuccess(c, rb)
+	}
+}
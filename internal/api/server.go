@@ -2,15 +2,31 @@
 package api
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/sanskarpan/db-backup/internal/api/middleware"
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/auth"
+	"github.com/sanskarpan/db-backup/internal/auth/lockout"
+	"github.com/sanskarpan/db-backup/internal/auth/policy"
+	"github.com/sanskarpan/db-backup/internal/auth/session"
 	"github.com/sanskarpan/db-backup/internal/backup"
 	"github.com/sanskarpan/db-backup/internal/catalog"
+	"github.com/sanskarpan/db-backup/internal/drcopy"
+	"github.com/sanskarpan/db-backup/internal/events"
+	"github.com/sanskarpan/db-backup/internal/freshness"
 	"github.com/sanskarpan/db-backup/internal/health"
 	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/metrics"
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/sanskarpan/db-backup/internal/quota"
 	"github.com/sanskarpan/db-backup/internal/restore"
+	"github.com/sanskarpan/db-backup/internal/runbook"
 	"github.com/sanskarpan/db-backup/internal/scheduler"
+	"github.com/sanskarpan/db-backup/internal/security/quarantine"
 	"github.com/sanskarpan/db-backup/internal/security/ransomware"
+	"github.com/sanskarpan/db-backup/internal/sla"
 )
 
 // Server represents the API server
@@ -22,6 +38,20 @@ type Server struct {
 	healthChecker *health.Checker
 	detector      *ransomware.Detector
 	searchEngine  *catalog.SearchEngine
+	notifyQueue   *notification.Queue
+	metrics       *metrics.Metrics
+	slaTracker    *sla.Tracker
+	quotas        *quota.Manager
+	freshness     *freshness.Watchdog
+	authenticator auth.Authenticator
+	quarantine    *quarantine.Store
+	drCopies      *drcopy.Store
+	runbooks      *runbook.Store
+	sessions      session.Store
+	policy        *policy.Engine
+	auditLog      *audit.Log
+	lockout       *lockout.Tracker
+	events        *events.Bus
 	logger        *logger.Logger
 }
 
@@ -33,6 +63,7 @@ type Config struct {
 	EnableCORS    bool
 	EnableSwagger bool
 	JWTSecret     string
+	JWTExpiration time.Duration
 	RateLimit     int
 }
 
@@ -59,6 +90,55 @@ func NewServer(
 	}
 }
 
+// SetNotificationQueue exposes the notification delivery queue through the
+// /notifications endpoints
+func (s *Server) SetNotificationQueue(q *notification.Queue) {
+	s.notifyQueue = q
+}
+
+// SetEventBus publishes the alerts the server raises, such as login
+// lockouts, as alert.raised events
+func (s *Server) SetEventBus(b *events.Bus) {
+	s.events = b
+}
+
+// SetSLATracker exposes RPO/RTO status through /stats/sla
+func (s *Server) SetSLATracker(t *sla.Tracker) {
+	s.slaTracker = t
+}
+
+// SetFreshness exposes the backup freshness watchdog through
+// /stats/freshness
+func (s *Server) SetFreshness(w *freshness.Watchdog) {
+	s.freshness = w
+}
+
+// SetQuotas exposes the usage of the storage quotas through /stats/quotas
+func (s *Server) SetQuotas(m *quota.Manager) {
+	s.quotas = m
+}
+
+// SetMetrics exposes Prometheus metrics on /api/v1/metrics. Call it after
+// SetNotificationQueue and SetSLATracker so the queue depth and RPO/RTO
+// status are exported too.
+func (s *Server) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+	if s.notifyQueue != nil {
+		if err := m.WatchQueue(s.notifyQueue.Depth); err != nil {
+			s.logger.Warn("Failed to export notification queue depth", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+	if s.slaTracker != nil {
+		if err := m.WatchRecovery(s.recoveryStatus); err != nil {
+			s.logger.Warn("Failed to export RPO/RTO status", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
 // SetupRoutes configures all API routes
 func (s *Server) SetupRoutes(router *gin.Engine) {
 	// Middleware - Order matters!
@@ -66,28 +146,36 @@ func (s *Server) SetupRoutes(router *gin.Engine) {
 	// 1. Logging middleware (first to log all requests)
 	router.Use(s.loggingMiddleware())
 
-	// 2. Security headers (apply to all responses)
+	// 2. Client certificate identity (mutual TLS)
+	router.Use(s.clientCertMiddleware())
+
+	// 3. Security headers (apply to all responses)
 	router.Use(middleware.DefaultSecurityHeaders())
 
-	// 3. CORS (if enabled)
+	// 4. CORS (if enabled)
 	if s.config.EnableCORS {
 		router.Use(s.corsMiddleware())
 	}
 
-	// 4. Request size limits (prevent DoS attacks)
+	// 5. Request size limits (prevent DoS attacks)
 	router.Use(middleware.DefaultMaxBodySize())
 
-	// 5. CSRF protection (with exemptions for health/metrics endpoints)
+	// 6. CSRF protection (with exemptions for health/metrics endpoints)
 	exemptPaths := []string{
 		"/health",
 		"/api/v1/health",
 		"/api/v1/ready",
 		"/api/v1/version",
 		"/api/v1/metrics",
+		"/api/v1/auth/login",
 	}
 	router.Use(middleware.CSRFProtectionWithExemptions(exemptPaths))
 
-	// API v1 routes
+	// 7. Session revocation (if a session store is set)
+	router.Use(s.sessionMiddleware())
+
+	// API v1 routes. Routes wrapped in authorize are also checked against
+	// the authorization policy, if one is set.
 	v1 := router.Group("/api/v1")
 	{
 		// Health and readiness
@@ -95,61 +183,104 @@ func (s *Server) SetupRoutes(router *gin.Engine) {
 		v1.GET("/ready", s.handleReady)
 		v1.GET("/version", s.handleVersion)
 
+		// Username/password login (LDAP / Active Directory)
+		v1.POST("/auth/login", s.handleLogin)
+
+		// Sessions and API keys (revocation requires an admin token)
+		v1.POST("/auth/logout", s.handleLogout)
+		v1.GET("/auth/sessions", s.authorize("sessions.list"), s.handleListSessions)
+		v1.DELETE("/auth/sessions/:id", s.authorize("sessions.revoke"), s.handleRevokeSession)
+		v1.POST("/auth/sessions/revoke", s.authorize("sessions.revoke"), s.handleRevokeSessions)
+		v1.POST("/auth/api-keys", s.authorize("apikeys.create"), s.handleCreateAPIKey)
+		v1.GET("/auth/lockouts", s.authorize("lockouts.list"), s.handleListLockouts)
+		v1.POST("/auth/lockouts/unlock", s.authorize("lockouts.unlock"), s.handleUnlock)
+
 		// Backup operations
 		backups := v1.Group("/backups")
 		{
-			backups.POST("", s.handleCreateBackup)
-			backups.GET("", s.handleListBackups)
-			backups.GET("/:id", s.handleGetBackup)
-			backups.DELETE("/:id", s.handleDeleteBackup)
-			backups.POST("/:id/restore", s.handleRestoreBackup)
-			backups.GET("/:id/download", s.handleDownloadBackup)
+			backups.POST("", s.authorize("backup.create"), s.handleCreateBackup)
+			backups.GET("", s.authorize("backup.list"), s.handleListBackups)
+			backups.GET("/:id", s.authorize("backup.read"), s.handleGetBackup)
+			backups.DELETE("/:id", s.authorize("backup.delete"), s.handleDeleteBackup)
+			backups.POST("/:id/restore", s.authorize("backup.restore"), s.handleRestoreBackup)
+			backups.GET("/:id/download", s.authorize("backup.download"), s.handleDownloadBackup)
+			backups.GET("/:id/dr", s.authorize("backup.read"), s.handleGetBackupDRCopies)
 		}
 
+		// Disaster recovery copies
+		v1.GET("/dr/copies", s.authorize("dr.read"), s.handleListDRCopies)
+
+		// Recovery runbooks
+		v1.GET("/runbooks", s.authorize("runbook.read"), s.handleListRunbooks)
+		v1.GET("/runbooks/:database", s.authorize("runbook.read"), s.handleGetRunbook)
+
 		// Schedule management
 		schedules := v1.Group("/schedules")
 		{
-			schedules.POST("", s.handleCreateSchedule)
-			schedules.GET("", s.handleListSchedules)
-			schedules.GET("/:id", s.handleGetSchedule)
-			schedules.PUT("/:id", s.handleUpdateSchedule)
-			schedules.DELETE("/:id", s.handleDeleteSchedule)
-			schedules.POST("/:id/enable", s.handleEnableSchedule)
-			schedules.POST("/:id/disable", s.handleDisableSchedule)
-			schedules.POST("/:id/run", s.handleRunSchedule)
+			schedules.POST("", s.authorize("schedule.create"), s.handleCreateSchedule)
+			schedules.GET("", s.authorize("schedule.list"), s.handleListSchedules)
+			schedules.GET("/:id", s.authorize("schedule.read"), s.handleGetSchedule)
+			schedules.PUT("/:id", s.authorize("schedule.update"), s.handleUpdateSchedule)
+			schedules.DELETE("/:id", s.authorize("schedule.delete"), s.handleDeleteSchedule)
+			schedules.POST("/:id/enable", s.authorize("schedule.enable"), s.handleEnableSchedule)
+			schedules.POST("/:id/disable", s.authorize("schedule.disable"), s.handleDisableSchedule)
+			schedules.POST("/:id/run", s.authorize("schedule.run"), s.handleRunSchedule)
 		}
 
 		// Statistics and monitoring
-		v1.GET("/stats", s.handleGetStats)
-		v1.GET("/stats/storage", s.handleGetStorageStats)
+		if s.metrics != nil {
+			v1.GET("/metrics", gin.WrapH(s.metrics.Handler()))
+		}
+		v1.GET("/stats", s.authorize("stats.read"), s.handleGetStats)
+		v1.GET("/stats/storage", s.authorize("stats.read"), s.handleGetStorageStats)
+		v1.GET("/stats/sla", s.authorize("stats.read"), s.handleGetSLAStats)
+		v1.GET("/stats/freshness", s.authorize("stats.read"), s.handleGetFreshnessStats)
+		v1.GET("/stats/quotas", s.authorize("stats.read"), s.handleGetQuotaStats)
 
 		// Security endpoints
 		security := v1.Group("/security")
 		{
 			// Ransomware detection
-			security.POST("/scan/file", s.handleScanFile)
-			security.POST("/scan/directory", s.handleScanDirectory)
-			security.GET("/stats", s.handleGetSecurityStats)
+			security.POST("/scan/file", s.authorize("security.scan"), s.handleScanFile)
+			security.POST("/scan/directory", s.authorize("security.scan"), s.handleScanDirectory)
+			security.GET("/stats", s.authorize("security.read"), s.handleGetSecurityStats)
 
 			// Threat alerts
-			security.GET("/alerts", s.handleListThreatAlerts)
-			security.GET("/alerts/:id", s.handleGetThreatAlert)
-			security.PUT("/alerts/:id", s.handleUpdateThreatAlert)
+			security.GET("/alerts", s.authorize("security.alerts.read"), s.handleListThreatAlerts)
+			security.GET("/alerts/:id", s.authorize("security.alerts.read"), s.handleGetThreatAlert)
+			security.PUT("/alerts/:id", s.authorize("security.alerts.update"), s.handleUpdateThreatAlert)
+
+			// Quarantined backups
+			security.GET("/quarantine", s.authorize("quarantine.read"), s.handleListQuarantine)
+			security.GET("/quarantine/audit", s.authorize("quarantine.read"), s.handleQuarantineAudit)
+			security.GET("/quarantine/:id", s.authorize("quarantine.read"), s.handleGetQuarantine)
+			security.POST("/quarantine/:id/release", s.authorize("quarantine.release"), s.handleReleaseQuarantine)
 
 			// Immutable storage configuration
-			security.GET("/storage/providers", s.handleListStorageProviders)
-			security.GET("/storage/providers/:id", s.handleGetStorageProvider)
-			security.PUT("/storage/providers/:id", s.handleUpdateStorageProvider)
+			security.GET("/storage/providers", s.authorize("storage.read"), s.handleListStorageProviders)
+			security.GET("/storage/providers/:id", s.authorize("storage.read"), s.handleGetStorageProvider)
+			security.PUT("/storage/providers/:id", s.authorize("storage.update"), s.handleUpdateStorageProvider)
+		}
+
+		// Notification delivery queue
+		notifications := v1.Group("/notifications")
+		{
+			notifications.GET("/queue", s.authorize("notifications.read"), s.handleListNotificationQueue)
+			notifications.POST("/queue/flush", s.authorize("notifications.flush"), s.handleFlushNotificationQueue)
+			notifications.GET("/dead-letters", s.authorize("notifications.read"), s.handleListDeadLetters)
+			notifications.GET("/dead-letters/:id", s.authorize("notifications.read"), s.handleGetDeadLetter)
+			notifications.POST("/dead-letters/:id/retry", s.authorize("notifications.retry"), s.handleRetryDeadLetter)
+			notifications.DELETE("/dead-letters/:id", s.authorize("notifications.discard"), s.handleDiscardDeadLetter)
 		}
 
 		// Catalog and search endpoints
 		catalogRoutes := v1.Group("/catalog")
 		{
-			catalogRoutes.POST("/search", s.handleSearchCatalog)
-			catalogRoutes.GET("/search", s.handleSearchCatalogSimple)
-			catalogRoutes.GET("/suggest", s.handleSuggestCatalog)
-			catalogRoutes.GET("/stats", s.handleGetCatalogStats)
-			catalogRoutes.GET("/query-examples", s.handleQueryExamples)
+			catalogRoutes.POST("/search", s.authorize("catalog.search"), s.handleSearchCatalog)
+			catalogRoutes.GET("/search", s.authorize("catalog.search"), s.handleSearchCatalogSimple)
+			catalogRoutes.GET("/suggest", s.authorize("catalog.search"), s.handleSuggestCatalog)
+			catalogRoutes.GET("/stats", s.authorize("catalog.read"), s.handleGetCatalogStats)
+			catalogRoutes.GET("/query-examples", s.authorize("catalog.read"), s.handleQueryExamples)
 		}
 	}
 
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/auth"
+	"github.com/sanskarpan/db-backup/internal/auth/session"
+)
+
+var (
+	errSessionsDisabled = errors.New("the session store is not enabled")
+	errSessionRevoked   = errors.New("token has been revoked or its session has expired")
+	errNotAdmin         = errors.New("an admin token is required")
+	errRevokeTarget     = errors.New("exactly one of subject or kind is required")
+)
+
+// RevokeSessionsRequest is the body of POST /auth/sessions/revoke
+type RevokeSessionsRequest struct {
+	Subject string `json:"subject"`
+	Kind    string `json:"kind"`
+}
+
+// APIKeyRequest is the body of POST /auth/api-keys
+type APIKeyRequest struct {
+	Name string `json:"name" binding:"required"`
+	Role string `json:"role" binding:"required"`
+	// TTL is a Go duration; empty issues a key that never expires
+	TTL string `json:"ttl"`
+}
+
+// SetSessionStore records issued tokens so they can be revoked before they
+// expire. With a store set, bearer tokens are only accepted while their
+// session is active and the /auth/sessions endpoints are available.
+func (s *Server) SetSessionStore(store session.Store) {
+	s.sessions = store
+}
+
+// sessionStore returns the session store, responding with 503 when disabled
+func (s *Server) sessionStore(c *gin.Context) (session.Store, bool) {
+	if s.sessions == nil {
+		s.respondError(c, http.StatusServiceUnavailable, errSessionsDisabled, "Sessions unavailable")
+		return nil, false
+	}
+	return s.sessions, true
+}
+
+// bearerClaims returns the verified claims of the request's bearer token
+func (s *Server) bearerClaims(c *gin.Context) (jwt.MapClaims, bool) {
+	bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return nil, false
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(bearer, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(s.config.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+// sessionMiddleware rejects bearer tokens whose session was revoked. Tokens
+// issued without a jti predate the session store and are rejected too, so
+// enabling it invalidates every untracked token.
+func (s *Server) sessionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.sessions == nil || !strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ") {
+			c.Next()
+			return
+		}
+		claims, ok := s.bearerClaims(c)
+		if !ok {
+			s.respondError(c, http.StatusUnauthorized, jwt.ErrTokenInvalidClaims, "Invalid token")
+			c.Abort()
+			return
+		}
+		jti, _ := claims["jti"].(string)
+		if jti == "" {
+			s.respondError(c, http.StatusUnauthorized, errSessionRevoked, "Invalid token")
+			c.Abort()
+			return
+		}
+		if _, err := s.sessions.Get(c.Request.Context(), jti); err != nil {
+			if errors.Is(err, session.ErrNotFound) {
+				s.respondError(c, http.StatusUnauthorized, errSessionRevoked, "Invalid token")
+			} else {
+				// Fail closed: a revoked token must not slip through an outage
+				s.respondError(c, http.StatusServiceUnavailable, err, "Session store unavailable")
+			}
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAdmin responds with 403 unless the bearer token carries the admin
+// role
+func (s *Server) requireAdmin(c *gin.Context) bool {
+	claims, ok := s.bearerClaims(c)
+	if ok {
+		role, _ := claims["role"].(string)
+		if r, valid := auth.ParseRole(role); valid && r.Allows(auth.RoleAdmin) {
+			return true
+		}
+	}
+	s.respondError(c, http.StatusForbidden, errNotAdmin, "Forbidden")
+	return false
+}
+
+// issueToken signs a token for subject and, with a session store set,
+// records its session. A zero expiresAt issues a token without exp.
+func (s *Server) issueToken(c *gin.Context, kind session.Kind, subject string, role auth.Role, provider string, claims jwt.MapClaims, expiresAt time.Time) (string, *session.Session, error) {
+	now := time.Now()
+	claims["sub"] = subject
+	claims["role"] = string(role)
+	claims["iat"] = now.Unix()
+	if !expiresAt.IsZero() {
+		claims["exp"] = expiresAt.Unix()
+	}
+
+	var sess *session.Session
+	if s.sessions != nil {
+		id, err := session.NewID()
+		if err != nil {
+			return "", nil, err
+		}
+		claims["jti"] = id
+		claims["kind"] = string(kind)
+		sess = &session.Session{
+			ID:        id,
+			Kind:      kind,
+			Subject:   subject,
+			Role:      string(role),
+			Provider:  provider,
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			IssuedAt:  now.UTC(),
+			ExpiresAt: expiresAt.UTC(),
+		}
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.config.JWTSecret))
+	if err != nil {
+		return "", nil, err
+	}
+	if sess != nil {
+		if err := s.sessions.Create(c.Request.Context(), sess); err != nil {
+			return "", nil, err
+		}
+	}
+	return signed, sess, nil
+}
+
+// handleListSessions lists the active sessions and API keys
+func (s *Server) handleListSessions(c *gin.Context) {
+	store, ok := s.sessionStore(c)
+	if !ok || !s.requireAdmin(c) {
+		return
+	}
+	sessions, err := store.List(c.Request.Context())
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to list sessions")
+		return
+	}
+	if subject := c.Query("subject"); subject != "" {
+		filtered := sessions[:0]
+		for _, sess := range sessions {
+			if sess.Subject == subject {
+				filtered = append(filtered, sess)
+			}
+		}
+		sessions = filtered
+	}
+	s.respondSuccess(c, gin.H{"sessions": sessions, "count": len(sessions)})
+}
+
+// handleRevokeSession revokes a single session
+func (s *Server) handleRevokeSession(c *gin.Context) {
+	store, ok := s.sessionStore(c)
+	if !ok || !s.requireAdmin(c) {
+		return
+	}
+	id := c.Param("id")
+	if err := store.Revoke(c.Request.Context(), id); err != nil {
+		s.respondError(c, sessionStatus(err), err, "Failed to revoke session")
+		return
+	}
+	s.logger.Warn("Session revoked", map[string]interface{}{
+		"session_id": id,
+		"actor":      s.requestActor(c),
+	})
+	s.recordAudit(c, audit.ActionSessionRevoked, id, nil)
+	s.respondSuccessWithMessage(c, "Session revoked", gin.H{"id": id})
+}
+
+// handleRevokeSessions revokes every session of a user, or every session of
+// a kind, e.g. {"kind": "api_key"} after a key leak
+func (s *Server) handleRevokeSessions(c *gin.Context) {
+	store, ok := s.sessionStore(c)
+	if !ok || !s.requireAdmin(c) {
+		return
+	}
+	var req RevokeSessionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.respondError(c, http.StatusBadRequest, err, "Invalid revoke request")
+		return
+	}
+
+	var n int
+	var err error
+	switch {
+	case req.Subject != "" && req.Kind == "":
+		n, err = store.RevokeSubject(c.Request.Context(), req.Subject)
+	case req.Kind != "" && req.Subject == "":
+		kind := session.Kind(req.Kind)
+		if kind != session.KindToken && kind != session.KindAPIKey {
+			s.respondError(c, http.StatusBadRequest, errors.New("kind must be token or api_key"), "Invalid revoke request")
+			return
+		}
+		n, err = store.RevokeKind(c.Request.Context(), kind)
+	default:
+		s.respondError(c, http.StatusBadRequest, errRevokeTarget, "Invalid revoke request")
+		return
+	}
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to revoke sessions")
+		return
+	}
+
+	s.logger.Warn("Sessions revoked", map[string]interface{}{
+		"subject": req.Subject,
+		"kind":    req.Kind,
+		"revoked": n,
+		"actor":   s.requestActor(c),
+	})
+	target := "subject:" + req.Subject
+	if req.Kind != "" {
+		target = "kind:" + req.Kind
+	}
+	s.recordAudit(c, audit.ActionSessionRevoked, target, map[string]string{"revoked": strconv.Itoa(n)})
+	s.respondSuccessWithMessage(c, "Sessions revoked", gin.H{"revoked": n})
+}
+
+// handleLogout revokes the session of the calling token
+func (s *Server) handleLogout(c *gin.Context) {
+	store, ok := s.sessionStore(c)
+	if !ok {
+		return
+	}
+	claims, ok := s.bearerClaims(c)
+	jti, _ := claims["jti"].(string)
+	if !ok || jti == "" {
+		s.respondError(c, http.StatusUnauthorized, errSessionRevoked, "Not logged in")
+		return
+	}
+	if err := store.Revoke(c.Request.Context(), jti); err != nil {
+		s.respondError(c, sessionStatus(err), err, "Failed to log out")
+		return
+	}
+	s.respondSuccessWithMessage(c, "Logged out", nil)
+}
+
+// handleCreateAPIKey issues an API key: a token of kind api_key that can be
+// revoked with the other keys in one call
+func (s *Server) handleCreateAPIKey(c *gin.Context) {
+	if _, ok := s.sessionStore(c); !ok || !s.requireAdmin(c) {
+		return
+	}
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.respondError(c, http.StatusBadRequest, err, "Invalid API key request")
+		return
+	}
+	role, ok := auth.ParseRole(req.Role)
+	if !ok {
+		s.respondError(c, http.StatusBadRequest, errors.New("role must be viewer, operator or admin"), "Invalid API key request")
+		return
+	}
+	var expiresAt time.Time
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			s.respondError(c, http.StatusBadRequest, errors.New("ttl must be a positive duration"), "Invalid API key request")
+			return
+		}
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	key, sess, err := s.issueToken(c, session.KindAPIKey, req.Name, role, "api_key", jwt.MapClaims{}, expiresAt)
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to issue API key")
+		return
+	}
+	s.logger.Info("API key issued", map[string]interface{}{
+		"name":       req.Name,
+		"role":       role,
+		"session_id": sess.ID,
+		"actor":      s.requestActor(c),
+	})
+	s.recordAudit(c, audit.ActionAPIKeyCreated, sess.ID, map[string]string{
+		"name": req.Name,
+		"role": string(role),
+	})
+	s.respondSuccess(c, gin.H{"key": key, "session": sess})
+}
+
+// sessionStatus maps session store errors to HTTP status codes
+func sessionStatus(err error) int {
+	if errors.Is(err, session.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sanskarpan/db-backup/internal/metrics"
+)
+
+var errSLADisabled = errors.New("SLA tracking is not enabled")
+
+// handleGetSLAStats reports the recovery point age and recovery time
+// estimate of every database against its objectives
+func (s *Server) handleGetSLAStats(c *gin.Context) {
+	if s.slaTracker == nil {
+		s.respondError(c, http.StatusServiceUnavailable, errSLADisabled, "SLA tracking unavailable")
+		return
+	}
+	statuses, err := s.slaTracker.Status(time.Now())
+	if err != nil {
+		s.respondError(c, http.StatusInternalServerError, err, "Failed to compute SLA status")
+		return
+	}
+
+	breached := 0
+	for _, st := range statuses {
+		if st.RPOBreached || st.RTOBreached {
+			breached++
+		}
+	}
+	s.respondSuccess(c, gin.H{"databases": statuses, "count": len(statuses), "breached": breached})
+}
+
+// recoveryStatus adapts the SLA tracker to the metrics collector
+func (s *Server) recoveryStatus() ([]metrics.RecoveryStatus, error) {
+	statuses, err := s.slaTracker.Status(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	out := make([]metrics.RecoveryStatus, 0, len(statuses))
+	for _, st := range statuses {
+		out = append(out, metrics.RecoveryStatus{
+			Database:         st.Database,
+			HasRecoveryPoint: st.HasRecoveryPoint(),
+			PointAge:         st.PointAge,
+			RPO:              st.RPO,
+			RPOBreached:      st.RPOBreached,
+			TimeEstimate:     st.TimeEstimate,
+			RTO:              st.RTO,
+			RTOBreached:      st.RTOBreached,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,211 @@
+// Package apply reconciles a declarative file of connection profiles,
+// retention policies, schedules and notification routes against the
+// db-backup server API, so they can be kept in git and rolled out by CI.
+//
+// A file declares resources by name:
+//
+//	connections:
+//	  orders:
+//	    type: postgres
+//	    host: orders-db
+//	    backup: {user: backup, password: ${ORDERS_BACKUP_PASSWORD}}
+//	retention:
+//	  orders: {daily: 7, weekly: 4, monthly: 12}
+//	schedules:
+//	  nightly-orders:
+//	    cron: "0 2 * * *"
+//	    backup: {profile: orders}
+//	notification_routes:
+//	  - name: orders-failures
+//	    databases: [orders]
+//	    events: [backup.failed]
+//	    channels: [pagerduty]
+//
+// ${VAR} references are expanded from the environment so secrets stay out
+// of the file. Resources are matched to the server's by name; only the
+// fields a file sets are compared, so fields the server fills in never
+// show up as changes.
+package apply
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Kind is a type of resource the server manages
+type Kind struct {
+	Name    string // singular, as shown in plans
+	Section string // key of the section in files
+	Path    string // collection path under /api/v1
+	ListKey string // key of the items in list responses
+}
+
+// Kinds in the order they are created and updated: schedules refer to
+// connection profiles and retention policies, so those come first.
+// Deletions run in the reverse order.
+var Kinds = []Kind{
+	{Name: "connection", Section: "connections", Path: "/connections", ListKey: "connections"},
+	{Name: "retention", Section: "retention", Path: "/retention/policies", ListKey: "policies"},
+	{Name: "schedule", Section: "schedules", Path: "/schedules", ListKey: "schedules"},
+	{Name: "notification_route", Section: "notification_routes", Path: "/notifications/routes", ListKey: "routes"},
+}
+
+// Resource is a named resource of a kind. Spec holds its fields as
+// decoded from JSON.
+type Resource struct {
+	Kind string
+	Name string
+	ID   string // server-side ID, empty for desired resources
+	Spec map[string]any
+}
+
+// State is a set of resources by kind name. Kinds absent from a desired
+// state are left alone, even when pruning.
+type State map[string][]Resource
+
+// document is the layout of a file
+type document struct {
+	Connections        map[string]map[string]any `yaml:"connections"`
+	Retention          map[string]map[string]any `yaml:"retention"`
+	Schedules          map[string]map[string]any `yaml:"schedules"`
+	NotificationRoutes []map[string]any          `yaml:"notification_routes"`
+}
+
+// Load reads a desired state from a file, "-" for stdin
+func Load(path string) (State, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	state, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return state, nil
+}
+
+// Parse decodes a desired state, expanding ${VAR} references from the
+// environment
+func Parse(data []byte) (State, error) {
+	dec := yaml.NewDecoder(bytes.NewReader([]byte(os.ExpandEnv(string(data)))))
+	dec.KnownFields(true)
+	var doc document
+	if err := dec.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	state := State{}
+	named := func(kind string, items map[string]map[string]any) error {
+		if items == nil {
+			return nil
+		}
+		resources := make([]Resource, 0, len(items))
+		for name, spec := range items {
+			if name == "" {
+				return fmt.Errorf("%s with an empty name", kind)
+			}
+			r, err := resource(kind, name, spec)
+			if err != nil {
+				return err
+			}
+			resources = append(resources, r)
+		}
+		sort.Slice(resources, func(i, j int) bool { return resources[i].Name < resources[j].Name })
+		state[kind] = resources
+		return nil
+	}
+	if err := named("connection", doc.Connections); err != nil {
+		return nil, err
+	}
+	if err := named("retention", doc.Retention); err != nil {
+		return nil, err
+	}
+	if err := named("schedule", doc.Schedules); err != nil {
+		return nil, err
+	}
+
+	// Routes are evaluated in order, so their position is part of them
+	if doc.NotificationRoutes != nil {
+		routes := make([]Resource, 0, len(doc.NotificationRoutes))
+		seen := map[string]bool{}
+		for i, spec := range doc.NotificationRoutes {
+			name, _ := spec["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("notification route %d has no name", i+1)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("notification route %q is declared twice", name)
+			}
+			seen[name] = true
+			spec["position"] = i
+			r, err := resource("notification_route", name, spec)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, r)
+		}
+		state["notification_route"] = routes
+	}
+
+	for _, kind := range Kinds {
+		for _, r := range state[kind.Name] {
+			if err := validate(r); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", r.Kind, r.Name, err)
+			}
+		}
+	}
+	return state, nil
+}
+
+// resource builds a desired resource, normalizing its spec through JSON so
+// it compares equal to what the server returns
+func resource(kind, name string, spec map[string]any) (Resource, error) {
+	if spec == nil {
+		spec = map[string]any{}
+	}
+	spec["name"] = name
+	normalized, err := normalize(spec)
+	if err != nil {
+		return Resource{}, fmt.Errorf("%s %s: %w", kind, name, err)
+	}
+	return Resource{Kind: kind, Name: name, Spec: normalized}, nil
+}
+
+// validate checks the fields the server cannot do without
+func validate(r Resource) error {
+	has := func(field string) bool {
+		v, ok := r.Spec[field]
+		return ok && v != nil && v != ""
+	}
+	switch r.Kind {
+	case "connection":
+		if !has("type") {
+			return errors.New("type is required")
+		}
+	case "retention":
+		if !has("daily") && !has("weekly") && !has("monthly") {
+			return errors.New("at least one of daily, weekly or monthly is required")
+		}
+	case "schedule":
+		if !has("cron") {
+			return errors.New("cron is required")
+		}
+	case "notification_route":
+		if !has("channels") {
+			return errors.New("channels is required")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package apply
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const desiredFile = `
+connections:
+  orders:
+    type: postgres
+    host: orders-db
+    backup:
+      user: backup
+      password: ${APPLY_TEST_PASSWORD}
+retention:
+  orders: {daily: 14, weekly: 4}
+schedules:
+  nightly-orders:
+    cron: "0 3 * * *"
+    enabled: true
+    backup: {profile: orders}
+notification_routes:
+  - name: orders-failures
+    databases: [orders]
+    channels: [pagerduty]
+`
+
+func TestParse(t *testing.T) {
+	t.Setenv("APPLY_TEST_PASSWORD", "s3cret")
+	state, err := Parse([]byte(desiredFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := state["connection"][0]
+	if conn.Name != "orders" || conn.Spec["backup"].(map[string]any)["password"] != "s3cret" {
+		t.Errorf("connection = %+v", conn)
+	}
+	if daily := state["retention"][0].Spec["daily"]; daily != float64(14) {
+		t.Errorf("daily = %#v", daily)
+	}
+	if pos := state["notification_route"][0].Spec["position"]; pos != float64(0) {
+		t.Errorf("position = %#v", pos)
+	}
+
+	tests := map[string]string{
+		"unknown section":    "schedule:\n  a: {cron: '@daily'}\n",
+		"schedule no cron":   "schedules:\n  a: {enabled: true}\n",
+		"connection type":    "connections:\n  a: {host: db}\n",
+		"route without name": "notification_routes:\n  - channels: [slack]\n",
+		"duplicate route":    "notification_routes:\n  - {name: a, channels: [slack]}\n  - {name: a, channels: [email]}\n",
+		"empty retention":    "retention:\n  a: {}\n",
+	}
+	for name, doc := range tests {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	t.Setenv("APPLY_TEST_PASSWORD", "s3cret")
+	desired, err := Parse([]byte(desiredFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := State{
+		// Unchanged apart from the password the server does not return
+		"connection": {{Kind: "connection", Name: "orders", ID: "c1", Spec: map[string]any{
+			"id": "c1", "name": "orders", "type": "postgres", "host": "orders-db",
+			"backup": map[string]any{"user": "backup"},
+		}}},
+		"retention": {{Kind: "retention", Name: "orders", ID: "r1", Spec: map[string]any{
+			"name": "orders", "daily": float64(7), "weekly": float64(4),
+		}}},
+		"schedule": {
+			{Kind: "schedule", Name: "nightly-orders", ID: "s1", Spec: map[string]any{
+				"name": "nightly-orders", "cron": "0 2 * * *", "enabled": true,
+				"backup": map[string]any{"profile": "orders"}, "next_run": "2026-01-01T02:00:00Z",
+			}},
+			{Kind: "schedule", Name: "legacy", ID: "s2", Spec: map[string]any{"name": "legacy"}},
+		},
+	}
+
+	changes := Plan(desired, current, PlanOptions{})
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.Action+" "+c.Resource.Kind+" "+c.Resource.Name)
+	}
+	want := []string{"update retention orders", "update schedule nightly-orders", "create notification_route orders-failures"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("plan = %v, want %v", got, want)
+	}
+	if f := changes[0].Fields; len(f) != 1 || f[0].Path != "daily" || f[0].Old != float64(7) || changes[0].ID != "r1" {
+		t.Errorf("retention change = %+v", changes[0])
+	}
+
+	changes = Plan(desired, current, PlanOptions{Prune: true})
+	if last := changes[len(changes)-1]; last.Action != ActionDelete || last.Resource.Name != "legacy" || last.ID != "s2" {
+		t.Errorf("last change = %+v", last)
+	}
+	if Summary(changes) != "1 to create, 2 to update, 1 to delete" {
+		t.Errorf("summary = %s", Summary(changes))
+	}
+
+	// Kinds a file does not declare are never pruned
+	changes = Plan(State{"connection": nil}, current, PlanOptions{Prune: true})
+	if len(changes) != 1 || changes[0].Resource.Kind != "connection" {
+		t.Errorf("plan = %+v", changes)
+	}
+}
+
+func TestWritePlanHidesSecrets(t *testing.T) {
+	t.Setenv("APPLY_TEST_PASSWORD", "s3cret")
+	desired, err := Parse([]byte(desiredFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	WritePlan(&buf, Plan(desired, State{}, PlanOptions{}))
+	out := buf.String()
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("plan shows the password:\n%s", out)
+	}
+	for _, line := range []string{"+ connection orders", "    backup.password: (sensitive)", `    cron: "0 3 * * *"`} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("plan has no %q:\n%s", line, out)
+		}
+	}
+}
+
+// fakeServer serves the resource collections of the API
+type fakeServer struct {
+	mu       sync.Mutex
+	requests []string
+	items    map[string][]map[string]any // collection path
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	f.requests = append(f.requests, r.Method+" "+path)
+	for _, kind := range Kinds {
+		if path != kind.Path || r.Method != http.MethodGet {
+			continue
+		}
+		items, ok := f.items[kind.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{kind.ListKey: items, "count": len(items)}})
+		return
+	}
+	w.Write([]byte(`{"data":{}}`))
+}
+
+func TestClient(t *testing.T) {
+	t.Setenv("APPLY_TEST_PASSWORD", "s3cret")
+	fake := &fakeServer{items: map[string][]map[string]any{
+		"/connections":          {},
+		"/retention/policies":   {{"id": "r1", "name": "orders", "daily": 7, "weekly": 4}},
+		"/schedules":            {{"id": "s1", "name": "nightly-orders", "cron": "0 3 * * *", "enabled": true, "backup": map[string]any{"profile": "orders"}}, {"id": "s2", "name": "legacy"}},
+		"/notifications/routes": {},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	desired, err := Parse([]byte(desiredFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(srv.URL, "key", nil)
+	ctx := context.Background()
+	current, err := client.Current(ctx, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := Plan(desired, current, PlanOptions{Prune: true})
+	fake.requests = nil
+	var applied int
+	if err := client.Apply(ctx, changes, func(Change) { applied++ }); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"POST /connections",
+		"PUT /retention/policies/r1",
+		"POST /notifications/routes",
+		"DELETE /schedules/s2",
+	}
+	if strings.Join(fake.requests, ",") != strings.Join(want, ",") || applied != len(want) {
+		t.Errorf("requests = %v, want %v", fake.requests, want)
+	}
+
+	// A server without an API for a kind says so
+	delete(fake.items, "/notifications/routes")
+	if _, err := client.Current(ctx, desired); err == nil || !strings.Contains(err.Error(), ErrUnsupported.Error()) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package apply
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sanskarpan/db-backup/pkg/client"
+)
+
+// ErrUnsupported is returned when the server has no API for a kind
+var ErrUnsupported = errors.New("not supported by the server")
+
+// Client reads and changes resources through the server API
+type Client struct {
+	api *client.Client
+}
+
+// NewClient creates a client of the server at baseURL, e.g.
+// http://db-backup:8080, authenticating with an API key
+func NewClient(baseURL, apiKey string, hc *http.Client) *Client {
+	return &Client{api: client.New(baseURL, client.WithAPIKey(apiKey), client.WithHTTPClient(hc))}
+}
+
+// Current fetches the server's resources of the kinds desired declares
+func (c *Client) Current(ctx context.Context, desired State) (State, error) {
+	current := State{}
+	for _, kind := range Kinds {
+		if _, ok := desired[kind.Name]; !ok {
+			continue
+		}
+		resources, err := c.List(ctx, kind)
+		if err != nil {
+			return nil, err
+		}
+		current[kind.Name] = resources
+	}
+	return current, nil
+}
+
+// List returns the server's resources of a kind
+func (c *Client) List(ctx context.Context, kind Kind) ([]Resource, error) {
+	var data map[string]json.RawMessage
+	if err := c.api.Do(ctx, http.MethodGet, kind.Path, nil, &data); err != nil {
+		// Only collections are read, so a missing one is not routed at all
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusMethodNotAllowed) {
+			err = fmt.Errorf("%w: %v", ErrUnsupported, err)
+		}
+		return nil, fmt.Errorf("failed to list %s: %w", kind.Section, err)
+	}
+	var items []map[string]any
+	if raw, ok := data[kind.ListKey]; ok {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", kind.Section, err)
+		}
+	}
+	resources := make([]Resource, 0, len(items))
+	for _, spec := range items {
+		name, _ := spec["name"].(string)
+		if name == "" {
+			continue
+		}
+		id, _ := spec["id"].(string)
+		resources = append(resources, Resource{Kind: kind.Name, Name: name, ID: id, Spec: spec})
+	}
+	return resources, nil
+}
+
+// Apply makes the changes in order, stopping at the first that fails.
+// done is called after each change, if not nil.
+func (c *Client) Apply(ctx context.Context, changes []Change, done func(Change)) error {
+	kinds := map[string]Kind{}
+	for _, k := range Kinds {
+		kinds[k.Name] = k
+	}
+	for _, ch := range changes {
+		kind := kinds[ch.Resource.Kind]
+		id := ch.ID
+		if id == "" {
+			id = ch.Resource.Name
+		}
+		var err error
+		switch ch.Action {
+		case ActionCreate:
+			err = c.api.Do(ctx, http.MethodPost, kind.Path, ch.Resource.Spec, nil)
+		case ActionUpdate:
+			err = c.api.Do(ctx, http.MethodPut, kind.Path+"/"+url.PathEscape(id), ch.Resource.Spec, nil)
+		case ActionDelete:
+			err = c.api.Do(ctx, http.MethodDelete, kind.Path+"/"+url.PathEscape(id), nil, nil)
+		default:
+			err = fmt.Errorf("unknown action %q", ch.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to %s %s %s: %w", ch.Action, ch.Resource.Kind, ch.Resource.Name, err)
+		}
+		if done != nil {
+			done(ch)
+		}
+	}
+	return nil
+}
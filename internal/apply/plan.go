@@ -0,0 +1,197 @@
+package apply
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Actions of a change
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// FieldDiff is a field that differs between the server and the file. Old
+// is nil for fields the server does not have.
+type FieldDiff struct {
+	Path string // dotted, e.g. backup.user
+	Old  any
+	New  any
+}
+
+// Change is what applying does to one resource
+type Change struct {
+	Action   string
+	Resource Resource // desired, or current for deletions
+	ID       string   // server-side ID of updated and deleted resources
+	Fields   []FieldDiff
+}
+
+// PlanOptions controls which changes are planned
+type PlanOptions struct {
+	// Prune deletes server resources of the kinds a file declares that it
+	// does not list
+	Prune bool
+}
+
+// Plan returns the changes that turn current into desired: creations and
+// updates in the order of Kinds, then deletions in the reverse order
+func Plan(desired, current State, opts PlanOptions) []Change {
+	var changes, deletions []Change
+	for _, kind := range Kinds {
+		want, declared := desired[kind.Name]
+		if !declared {
+			continue
+		}
+		have := map[string]Resource{}
+		for _, r := range current[kind.Name] {
+			have[r.Name] = r
+		}
+		wanted := map[string]bool{}
+		for _, r := range want {
+			wanted[r.Name] = true
+			existing, ok := have[r.Name]
+			if !ok {
+				changes = append(changes, Change{Action: ActionCreate, Resource: r, Fields: diff("", nil, r.Spec)})
+				continue
+			}
+			if fields := diff("", existing.Spec, r.Spec); len(fields) > 0 {
+				changes = append(changes, Change{Action: ActionUpdate, Resource: r, ID: existing.ID, Fields: fields})
+			}
+		}
+		if !opts.Prune {
+			continue
+		}
+		var stale []Change
+		for _, r := range current[kind.Name] {
+			if !wanted[r.Name] {
+				stale = append(stale, Change{Action: ActionDelete, Resource: r, ID: r.ID})
+			}
+		}
+		sort.Slice(stale, func(i, j int) bool { return stale[i].Resource.Name < stale[j].Resource.Name })
+		deletions = append(stale, deletions...)
+	}
+	return append(changes, deletions...)
+}
+
+// diff lists the fields of want that differ from have. Nested objects are
+// compared field by field; lists and scalars as a whole.
+func diff(prefix string, have, want map[string]any) []FieldDiff {
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var fields []FieldDiff
+	for _, k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		w := want[k]
+		h, ok := have[k]
+		if wm, isMap := w.(map[string]any); isMap {
+			hm, _ := h.(map[string]any)
+			fields = append(fields, diff(path, hm, wm)...)
+			continue
+		}
+		if ok && reflect.DeepEqual(h, w) {
+			continue
+		}
+		// Servers do not return secrets, so their absence is not a change
+		if !ok && have != nil && sensitive(k) {
+			continue
+		}
+		var old any
+		if ok {
+			old = h
+		}
+		fields = append(fields, FieldDiff{Path: path, Old: old, New: w})
+	}
+	return fields
+}
+
+// sensitive reports whether a field holds a secret, which plans never show
+func sensitive(field string) bool {
+	field = strings.ToLower(field)
+	for _, s := range []string{"password", "secret", "token", "key"} {
+		if strings.Contains(field, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary counts the changes by action, e.g. "1 to create, 0 to update, 2
+// to delete"
+func Summary(changes []Change) string {
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Action]++
+	}
+	return fmt.Sprintf("%d to create, %d to update, %d to delete",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete])
+}
+
+// WritePlan prints the changes as a diff preview: a line per resource,
+// marked + for creations, ~ for updates and - for deletions, followed by
+// the fields that change as "path: old => new". Secrets are never shown.
+func WritePlan(w io.Writer, changes []Change) {
+	for _, c := range changes {
+		var sign string
+		switch c.Action {
+		case ActionCreate:
+			sign = "+"
+		case ActionUpdate:
+			sign = "~"
+		case ActionDelete:
+			sign = "-"
+		}
+		fmt.Fprintf(w, "%s %s %s\n", sign, c.Resource.Kind, c.Resource.Name)
+		for _, f := range c.Fields {
+			if f.Path == "name" {
+				continue
+			}
+			switch {
+			case c.Action == ActionCreate:
+				fmt.Fprintf(w, "    %s: %s\n", f.Path, display(f.Path, f.New))
+			case f.Old == nil:
+				fmt.Fprintf(w, "    %s: (unset) => %s\n", f.Path, display(f.Path, f.New))
+			default:
+				fmt.Fprintf(w, "    %s: %s => %s\n", f.Path, display(f.Path, f.Old), display(f.Path, f.New))
+			}
+		}
+	}
+}
+
+// display renders a field value, hiding secrets
+func display(path string, v any) string {
+	if sensitive(path[strings.LastIndex(path, ".")+1:]) {
+		return "(sensitive)"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// normalize round-trips a value through JSON, so values decoded from YAML
+// and from API responses have the same Go types
+func normalize(spec map[string]any) (map[string]any, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,241 @@
+// Package audit keeps a tamper-evident record of what happened to the
+// backups: who ran, restored, deleted, quarantined or released what, and
+// when. Events are appended to a JSON-lines file where each event carries
+// the SHA-256 hash of its predecessor, so editing or removing an event
+// breaks the chain from that point on. Batches of the log are signed and
+// exported to write-once storage (see Exporter), which keeps the history
+// out of reach even of an administrator of this server.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Actions recorded by db-backup
+const (
+	ActionBackupCreated       = "backup.created"
+	ActionBackupFailed        = "backup.failed"
+	ActionBackupImported      = "backup.imported"
+	ActionBackupRestored      = "backup.restored"
+	ActionRestoreFailed       = "backup.restore_failed"
+	ActionAirGapCopied        = "airgap.copied"
+	ActionAirGapCopyFailed    = "airgap.copy_failed"
+	ActionDRCopied            = "dr.copied"
+	ActionDRCopyFailed        = "dr.copy_failed"
+	ActionDRCopyExpired       = "dr.expired"
+	ActionQuarantined         = "quarantine.added"
+	ActionReleased            = "quarantine.released"
+	ActionBaselineReset       = "baseline.reset"
+	ActionSessionRevoked      = "session.revoked"
+	ActionAPIKeyCreated       = "apikey.created"
+	ActionPolicyDenied        = "policy.denied"
+	ActionAuditExported       = "audit.exported"
+	ActionAuditExportFailed   = "audit.export_failed"
+	ActionLoginLocked         = "login.locked"
+	ActionLoginUnlocked       = "login.unlocked"
+	ActionCatalogAnchored     = "catalog.anchored"
+	ActionCatalogAnchorFailed = "catalog.anchor_failed"
+	ActionCatalogTampered     = "catalog.tampered"
+	ActionCatalogAdopted      = "catalog.adopted"
+	ActionDatabaseEnrolled    = "discovery.enrolled"
+)
+
+// genesis is the predecessor hash of the first event
+const genesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// maxEventSize bounds a single line of the log
+const maxEventSize = 1 << 20
+
+// ErrBroken is returned when the hash chain does not verify
+var ErrBroken = errors.New("audit log hash chain is broken")
+
+// Event is one audited action
+type Event struct {
+	Seq      int64             `json:"seq"`
+	Time     time.Time         `json:"time"`
+	Actor    string            `json:"actor"`
+	Action   string            `json:"action"`
+	Resource string            `json:"resource,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	PrevHash string            `json:"prev_hash"`
+	Hash     string            `json:"hash,omitempty"`
+}
+
+// computeHash hashes the event without its own hash; PrevHash is part of
+// the input, which links the chain
+func (e Event) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log is the append-only audit log file
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// New opens the configured audit log
+func New(cfg config.AuditConfig) *Log {
+	return &Log{path: cfg.LogFile}
+}
+
+// Path returns the log file
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append records an event and returns it with its sequence number and
+// hashes filled in
+func (l *Log) Append(actor, action, resource string, details map[string]string) (Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	last, err := l.last()
+	if err != nil {
+		return Event{}, err
+	}
+	e := Event{
+		Seq:      last.Seq + 1,
+		Time:     time.Now().UTC(),
+		Actor:    actor,
+		Action:   action,
+		Resource: resource,
+		Details:  details,
+		PrevHash: last.Hash,
+	}
+	if e.PrevHash == "" {
+		e.PrevHash = genesis
+	}
+	if e.Hash, err = e.computeHash(); err != nil {
+		return Event{}, err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return Event{}, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0750); err != nil {
+		return Event{}, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return Event{}, fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return e, f.Sync()
+}
+
+// Events returns the events with a sequence number above after
+func (l *Log) Events(after int64) ([]Event, error) {
+	var events []Event
+	err := l.scan(func(e Event) error {
+		if e.Seq > after {
+			events = append(events, e)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// Verify walks the whole log and checks every hash and link. It returns
+// the number of events verified and an error wrapping ErrBroken at the
+// first event that does not verify.
+func (l *Log) Verify() (int64, error) {
+	var n int64
+	prev := Event{Hash: genesis}
+	err := l.scan(func(e Event) error {
+		if err := verifyLink(prev, e); err != nil {
+			return err
+		}
+		prev = e
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// verifyLink checks that e is intact and directly follows prev
+func verifyLink(prev, e Event) error {
+	if e.Seq != prev.Seq+1 {
+		return fmt.Errorf("%w: event %d follows event %d", ErrBroken, e.Seq, prev.Seq)
+	}
+	if e.PrevHash != prev.Hash {
+		return fmt.Errorf("%w: event %d does not link to event %d", ErrBroken, e.Seq, prev.Seq)
+	}
+	hash, err := e.computeHash()
+	if err != nil {
+		return err
+	}
+	if hash != e.Hash {
+		return fmt.Errorf("%w: event %d was modified", ErrBroken, e.Seq)
+	}
+	return nil
+}
+
+// last returns the newest event, or a zero event for an empty log
+func (l *Log) last() (Event, error) {
+	var last Event
+	err := l.scan(func(e Event) error {
+		last = e
+		return nil
+	})
+	return last, err
+}
+
+// scan calls fn for every event in order. A missing log has no events.
+func (l *Log) scan(fn func(Event) error) error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer f.Close()
+	return scanEvents(f, fn)
+}
+
+func scanEvents(r io.Reader, fn func(Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("%w: line %d is not an event: %v", ErrBroken, line, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return nil
+}
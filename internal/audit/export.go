@@ -0,0 +1,406 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Manifest describes and signs one exported batch of events. Manifests
+// link to their predecessor, so a missing batch is as visible as a
+// modified one.
+type Manifest struct {
+	FirstSeq int64  `json:"first_seq"`
+	LastSeq  int64  `json:"last_seq"`
+	Events   int    `json:"events"`
+	HeadHash string `json:"head_hash"` // hash of the last event
+	// EventsSHA256 is the hash of the batch's events object
+	EventsSHA256 string    `json:"events_sha256"`
+	EventsObject string    `json:"events_object"`
+	PrevManifest string    `json:"prev_manifest"` // SHA-256 of the previous manifest object
+	ExportedAt   time.Time `json:"exported_at"`
+	Host         string    `json:"host"`
+	KeyID        string    `json:"key_id"`
+	Signature    string    `json:"signature,omitempty"`
+}
+
+// signedBytes is what the signature covers: the manifest without it
+func (m Manifest) signedBytes() ([]byte, error) {
+	m.Signature = ""
+	return json.Marshal(m)
+}
+
+// Export is the result of one export run
+type Export struct {
+	Manifest       Manifest  `json:"manifest"`
+	ManifestObject string    `json:"manifest_object"`
+	RetainUntil    time.Time `json:"retain_until"`
+}
+
+// exportState remembers where the last export stopped
+type exportState struct {
+	LastSeq         int64     `json:"last_seq"`
+	LastHash        string    `json:"last_hash"`
+	LastManifest    string    `json:"last_manifest"`
+	LastManifestSHA string    `json:"last_manifest_sha256"`
+	LastExportAt    time.Time `json:"last_export_at"`
+	Batches         int       `json:"batches"`
+}
+
+// Sink stores exported objects on write-once storage. Put must fail rather
+// than overwrite an existing object.
+type Sink interface {
+	Put(ctx context.Context, name string, data []byte, retainUntil time.Time) error
+	String() string
+}
+
+// Exporter ships new audit events to a Sink in signed batches
+type Exporter struct {
+	config config.AuditExportConfig
+	log    *Log
+	sink   Sink
+	key    ed25519.PrivateKey
+	host   string
+}
+
+// NewExporter creates the configured exporter
+func NewExporter(cfg config.AuditConfig) (*Exporter, error) {
+	key, err := LoadSigningKey(cfg.Export.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := NewSink(cfg.Export)
+	if err != nil {
+		return nil, err
+	}
+	return NewExporterWithSink(cfg, sink, key), nil
+}
+
+// NewExporterWithSink creates an exporter writing to sink
+func NewExporterWithSink(cfg config.AuditConfig, sink Sink, key ed25519.PrivateKey) *Exporter {
+	host, _ := os.Hostname()
+	return &Exporter{config: cfg.Export, log: New(cfg), sink: sink, key: key, host: host}
+}
+
+// Sink returns where batches are exported to
+func (x *Exporter) Sink() Sink {
+	return x.sink
+}
+
+// Export verifies the log and ships the events added since the last
+// export as one signed batch. It returns nil when there is nothing new.
+// The export is refused when the chain does not verify or no longer
+// extends what was exported before, i.e. history was rewritten.
+func (x *Exporter) Export(ctx context.Context, now time.Time) (*Export, error) {
+	st, err := x.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := x.log.Verify(); err != nil {
+		return nil, err
+	}
+	// Read from the last exported event so a log rewritten since the last
+	// export is refused even when it has not grown
+	from := max(st.LastSeq-1, 0)
+	events, err := x.log.Events(from)
+	if err != nil {
+		return nil, err
+	}
+	if st.LastSeq > 0 {
+		if len(events) == 0 || events[0].Seq != st.LastSeq || events[0].Hash != st.LastHash {
+			return nil, fmt.Errorf("%w: the log no longer contains the last exported event %d", ErrBroken, st.LastSeq)
+		}
+		events = events[1:]
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	eventsData := buf.Bytes()
+	eventsSum := sha256.Sum256(eventsData)
+
+	first, last := events[0], events[len(events)-1]
+	base := fmt.Sprintf("%s%s/audit-%012d-%012d", x.config.Prefix, now.UTC().Format("2006/01/02"), first.Seq, last.Seq)
+	prevManifest := st.LastManifestSHA
+	if prevManifest == "" {
+		prevManifest = genesis
+	}
+	m := Manifest{
+		FirstSeq:     first.Seq,
+		LastSeq:      last.Seq,
+		Events:       len(events),
+		HeadHash:     last.Hash,
+		EventsSHA256: hex.EncodeToString(eventsSum[:]),
+		EventsObject: base + ".jsonl",
+		PrevManifest: prevManifest,
+		ExportedAt:   now.UTC(),
+		Host:         x.host,
+		KeyID:        KeyID(x.key.Public().(ed25519.PublicKey)),
+	}
+	signed, err := m.signedBytes()
+	if err != nil {
+		return nil, err
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(x.key, signed))
+	manifestData, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	retainUntil := now.UTC().AddDate(0, 0, x.config.RetentionDays)
+	if err := x.sink.Put(ctx, m.EventsObject, eventsData, retainUntil); err != nil {
+		return nil, fmt.Errorf("failed to export audit events: %w", err)
+	}
+	manifestObject := base + ".manifest.json"
+	if err := x.sink.Put(ctx, manifestObject, manifestData, retainUntil); err != nil {
+		return nil, fmt.Errorf("failed to export audit manifest: %w", err)
+	}
+
+	manifestSum := sha256.Sum256(manifestData)
+	st.LastSeq = last.Seq
+	st.LastHash = last.Hash
+	st.LastManifest = manifestObject
+	st.LastManifestSHA = hex.EncodeToString(manifestSum[:])
+	st.LastExportAt = now.UTC()
+	st.Batches++
+	if err := x.saveState(st); err != nil {
+		return nil, err
+	}
+	return &Export{Manifest: m, ManifestObject: manifestObject, RetainUntil: retainUntil}, nil
+}
+
+// Run exports every interval until the context is cancelled. Failures are
+// reported through onError and retried at the next interval.
+func (x *Exporter) Run(ctx context.Context, onExport func(*Export), onError func(error)) {
+	ticker := time.NewTicker(x.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		exp, err := x.Export(ctx, time.Now())
+		if err != nil {
+			onError(err)
+		} else if exp != nil {
+			onExport(exp)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (x *Exporter) loadState() (*exportState, error) {
+	st := &exportState{}
+	data, err := os.ReadFile(x.config.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit export state: %w", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse audit export state: %w", err)
+	}
+	return st, nil
+}
+
+func (x *Exporter) saveState(st *exportState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit export state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(x.config.StateFile), 0750); err != nil {
+		return fmt.Errorf("failed to create audit export state directory: %w", err)
+	}
+	tmp := x.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write audit export state: %w", err)
+	}
+	return os.Rename(tmp, x.config.StateFile)
+}
+
+// VerifyBatch checks an exported batch: the manifest signature, the events
+// hash, and the hash chain within the batch. prevManifest is the raw
+// previous manifest object, or nil for the first batch; when given, the
+// batch must link to it.
+func VerifyBatch(pub ed25519.PublicKey, manifestData, eventsData, prevManifest []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(manifestData, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest signature encoding: %w", err)
+	}
+	signed, err := m.signedBytes()
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pub, signed, sig) {
+		return nil, fmt.Errorf("manifest signature does not verify with key %s", KeyID(pub))
+	}
+
+	sum := sha256.Sum256(eventsData)
+	if hex.EncodeToString(sum[:]) != m.EventsSHA256 {
+		return nil, fmt.Errorf("events object does not match the manifest hash")
+	}
+	if prevManifest != nil {
+		prevSum := sha256.Sum256(prevManifest)
+		if hex.EncodeToString(prevSum[:]) != m.PrevManifest {
+			return nil, fmt.Errorf("manifest does not link to the previous manifest")
+		}
+	}
+
+	var prev *Event
+	var n int
+	err = scanEvents(bytes.NewReader(eventsData), func(e Event) error {
+		if prev == nil {
+			if e.Seq != m.FirstSeq {
+				return fmt.Errorf("%w: batch starts at event %d, manifest says %d", ErrBroken, e.Seq, m.FirstSeq)
+			}
+			hash, err := e.computeHash()
+			if err != nil {
+				return err
+			}
+			if hash != e.Hash {
+				return fmt.Errorf("%w: event %d was modified", ErrBroken, e.Seq)
+			}
+		} else if err := verifyLink(*prev, e); err != nil {
+			return err
+		}
+		prev = &e
+		n++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if prev == nil || n != m.Events || prev.Seq != m.LastSeq || prev.Hash != m.HeadHash {
+		return nil, fmt.Errorf("%w: batch does not end at event %d", ErrBroken, m.LastSeq)
+	}
+	return &m, nil
+}
+
+// GenerateKey creates an Ed25519 signing key at path and returns the
+// PEM-encoded public key to hand to whoever verifies the exports
+func GenerateKey(path string) ([]byte, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing key: %w", err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, err
+	}
+	return EncodePublicKey(pub)
+}
+
+// EncodePublicKey PEM-encodes a public key
+func EncodePublicKey(pub ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// LoadSigningKey reads a PEM Ed25519 private key
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an Ed25519 key", path)
+	}
+	return priv, nil
+}
+
+// LoadPublicKey reads a PEM Ed25519 public key, or derives it from a
+// private key file
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	if strings.Contains(string(data), "PRIVATE KEY") {
+		priv, err := LoadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return priv.Public().(ed25519.PublicKey), nil
+	}
+	block, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an Ed25519 key", path)
+	}
+	return pub, nil
+}
+
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s does not contain a PEM %s", path, blockType)
+	}
+	return block, nil
+}
+
+// KeyID is a short fingerprint of a public key
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
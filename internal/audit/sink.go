@@ -0,0 +1,333 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// NewSink returns the S3 sink when a bucket is configured, else the
+// directory sink
+func NewSink(cfg config.AuditExportConfig) (Sink, error) {
+	if cfg.S3.Bucket != "" {
+		s, err := NewS3Sink(cfg.S3, cfg.LockMode)
+		if err != nil {
+			return nil, fmt.Errorf("audit export: %w", err)
+		}
+		return s, nil
+	}
+	if cfg.Directory != "" {
+		return &DirSink{Dir: cfg.Directory}, nil
+	}
+	return nil, fmt.Errorf("audit export needs s3.bucket or directory")
+}
+
+// DirSink writes batches read-only into a directory, typically a mount of
+// write-once media. Existing files are never replaced.
+type DirSink struct {
+	Dir string
+}
+
+// Put implements Sink
+func (d *DirSink) Put(_ context.Context, name string, data []byte, _ time.Time) error {
+	path := filepath.Join(d.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0400)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (d *DirSink) String() string {
+	return d.Dir
+}
+
+// S3Sink uploads batches to a bucket with Object Lock enabled. Every
+// object is written with a retention date in compliance or governance
+// mode, so it cannot be deleted or overwritten before then; in compliance
+// mode not even by the bucket owner.
+//
+// Requests are signed with AWS Signature Version 4 directly; a single
+// PutObject does not justify the SDK.
+type S3Sink struct {
+	config    config.AuditS3Config
+	lockMode  string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+	now       func() time.Time
+}
+
+// NewS3Sink creates an S3 sink. Credentials not in the config come from
+// the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables.
+func NewS3Sink(cfg config.AuditS3Config, lockMode string) (*S3Sink, error) {
+	s := &S3Sink{
+		config:    cfg,
+		lockMode:  strings.ToUpper(lockMode),
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		client:    &http.Client{Timeout: 2 * time.Minute},
+		now:       time.Now,
+	}
+	if s.lockMode == "" {
+		s.lockMode = "COMPLIANCE"
+	}
+	if s.accessKey == "" {
+		s.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s.token = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("no S3 credentials (set s3.access_key/secret_key or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("s3.region is required")
+	}
+	return s, nil
+}
+
+func (s *S3Sink) String() string {
+	return "s3://" + s.config.Bucket
+}
+
+// objectURL returns the URL of an object, virtual-hosted style unless
+// path style is configured
+func (s *S3Sink) objectURL(name string) (*url.URL, error) {
+	endpoint := s.config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.config.Region)
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if s.config.UsePathStyle {
+		u.Path += "/" + s.config.Bucket + "/" + name
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path += "/" + name
+	}
+	return u, nil
+}
+
+// Put implements Sink. If-None-Match refuses to replace an object that
+// already exists under the name. A zero retainUntil writes the object
+// unlocked, for buckets without Object Lock.
+func (s *S3Sink) Put(ctx context.Context, name string, data []byte, retainUntil time.Time) error {
+	sum := sha256.Sum256(data)
+	return s.put(ctx, s.client, name, bytes.NewReader(data), int64(len(data)), sum[:], retainUntil)
+}
+
+// maxPutSize is the largest object a single PutObject can write
+const maxPutSize = 5 << 30
+
+// PutFile uploads the file at path like Put, for files too large to hold
+// in memory. The file is read twice: once for the SHA-256 the request is
+// signed and checked with, then for the upload itself, which the context
+// bounds instead of the client timeout.
+func (s *S3Sink) PutFile(ctx context.Context, name, path string, retainUntil time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if size > maxPutSize {
+		return fmt.Errorf("s3 put %s: %d bytes is over the 5GiB single upload limit", name, size)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	client := &http.Client{Transport: s.client.Transport}
+	return s.put(ctx, client, name, f, size, h.Sum(nil), retainUntil)
+}
+
+// put uploads size bytes of body whose SHA-256 is sum
+func (s *S3Sink) put(ctx context.Context, client *http.Client, name string, body io.Reader, size int64, sum []byte, retainUntil time.Time) error {
+	u, err := s.objectURL(name)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("If-None-Match", "*")
+	req.Header.Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(sum))
+	if !retainUntil.IsZero() {
+		req.Header.Set("X-Amz-Object-Lock-Mode", s.lockMode)
+		req.Header.Set("X-Amz-Object-Lock-Retain-Until-Date", retainUntil.UTC().Format(time.RFC3339))
+	}
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	s.sign(req, hex.EncodeToString(sum), s.now())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3 put %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 put %s failed with status %d: %s", name, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Delete deletes an object. Deleting an object that does not exist
+// succeeds, as it does in S3; deleting a locked one fails.
+func (s *S3Sink) Delete(ctx context.Context, name string) error {
+	u, err := s.objectURL(name)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	empty := sha256.Sum256(nil)
+	s.sign(req, hex.EncodeToString(empty[:]), s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3 delete %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 delete %s failed with status %d: %s", name, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds a SigV4 Authorization header covering every header set so far
+func (s *S3Sink) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath URI-encodes each path segment the way S3 expects
+func escapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = uriEncode(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but the SigV4 unreserved characters
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
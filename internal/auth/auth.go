@@ -0,0 +1,70 @@
+// Package auth defines the identities and roles of users logging in to
+// the API server, independent of the backend that verified them
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Role grants a set of API permissions
+type Role string
+
+// Roles from least to most privileged
+const (
+	// RoleViewer can list and inspect backups, schedules and statistics
+	RoleViewer Role = "viewer"
+	// RoleOperator can additionally run backups, restores and schedules
+	RoleOperator Role = "operator"
+	// RoleAdmin can additionally change configuration and security settings
+	RoleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// ParseRole validates a role name
+func ParseRole(s string) (Role, bool) {
+	r := Role(strings.ToLower(strings.TrimSpace(s)))
+	_, ok := roleRank[r]
+	return r, ok
+}
+
+// Allows reports whether r includes the permissions of required
+func (r Role) Allows(required Role) bool {
+	return roleRank[r] >= roleRank[required] && roleRank[r] > 0
+}
+
+// Highest returns the most privileged of the roles, or "" when none is set
+func Highest(roles ...Role) Role {
+	var best Role
+	for _, r := range roles {
+		if roleRank[r] > roleRank[best] {
+			best = r
+		}
+	}
+	return best
+}
+
+// Identity is an authenticated user
+type Identity struct {
+	Username string   `json:"username"`
+	DN       string   `json:"dn,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Role     Role     `json:"role"`
+	Provider string   `json:"provider"`
+}
+
+// Authenticator verifies a username and password
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*Identity, error)
+}
+
+var (
+	// ErrInvalidCredentials is returned for unknown users and wrong passwords
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrNoRole is returned when a valid user is not in any group mapped to
+	// a role and no default role is configured
+	ErrNoRole = errors.New("user is not assigned a role")
+)
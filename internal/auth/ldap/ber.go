@@ -0,0 +1,209 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The subset of BER (X.690) needed for LDAPv3 messages: definite lengths
+// and single-byte tags, which covers every element of RFC 4511.
+
+// Tag classes and the constructed bit
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Universal tags
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10 | constructed
+	tagSet         = 0x11 | constructed
+)
+
+// maxMessageSize bounds a single response so a misbehaving server cannot
+// exhaust memory
+const maxMessageSize = 16 << 20
+
+// element is a decoded BER element; constructed elements have children
+type element struct {
+	tag      byte
+	value    []byte
+	children []*element
+}
+
+func (e *element) isConstructed() bool {
+	return e.tag&constructed != 0
+}
+
+// str returns the value as a string
+func (e *element) str() string {
+	return string(e.value)
+}
+
+// int returns the value of an INTEGER or ENUMERATED
+func (e *element) int() int64 {
+	var n int64
+	for i, b := range e.value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int64(b)
+	}
+	return n
+}
+
+// child returns the i-th child or an empty element
+func (e *element) child(i int) *element {
+	if i < len(e.children) {
+		return e.children[i]
+	}
+	return &element{}
+}
+
+// encode serialises a primitive element
+func encode(tag byte, value []byte) []byte {
+	out := append([]byte{tag}, encodeLength(len(value))...)
+	return append(out, value...)
+}
+
+// encodeConstructed serialises a constructed element from encoded children
+func encodeConstructed(tag byte, children ...[]byte) []byte {
+	var body []byte
+	for _, c := range children {
+		body = append(body, c...)
+	}
+	return encode(tag|constructed, body)
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for v := n; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func encodeInt(tag byte, n int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		// Stop once the remaining bits are pure sign extension
+		if (n == 0 && b[0]&0x80 == 0) || (n == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return encode(tag, b)
+}
+
+func encodeString(tag byte, s string) []byte {
+	return encode(tag, []byte(s))
+}
+
+func encodeBool(b bool) []byte {
+	if b {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0x00})
+}
+
+// readElement reads one complete element from r
+func readElement(r *bufio.Reader) (*element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, errors.New("ldap: multi-byte tags are not supported")
+	}
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxMessageSize {
+		return nil, fmt.Errorf("ldap: message of %d bytes exceeds limit", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return parseElement(tag, buf)
+}
+
+func readLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+	n := int(first & 0x7f)
+	if n == 0 || n > 4 {
+		return 0, errors.New("ldap: unsupported length encoding")
+	}
+	length := 0
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	return length, nil
+}
+
+// decode parses one element from the start of data and returns the rest
+func decode(data []byte) (*element, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	tag := data[0]
+	if tag&0x1f == 0x1f {
+		return nil, nil, errors.New("ldap: multi-byte tags are not supported")
+	}
+	length, offset := int(data[1]), 2
+	if length >= 0x80 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return nil, nil, errors.New("ldap: unsupported length encoding")
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+	if length < 0 || len(data)-offset < length {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	e, err := parseElement(tag, data[offset:offset+length])
+	return e, data[offset+length:], err
+}
+
+func parseElement(tag byte, value []byte) (*element, error) {
+	e := &element{tag: tag, value: value}
+	if !e.isConstructed() {
+		return e, nil
+	}
+	for rest := value; len(rest) > 0; {
+		child, next, err := decode(rest)
+		if err != nil {
+			return nil, err
+		}
+		e.children = append(e.children, child)
+		rest = next
+	}
+	return e, nil
+}
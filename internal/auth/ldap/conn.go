@@ -0,0 +1,309 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Protocol operation tags (RFC 4511 section 4.2 onwards)
+const (
+	opBindRequest      = classApplication | 0
+	opBindResponse     = classApplication | constructed | 1
+	opUnbindRequest    = classApplication | 2
+	opSearchRequest    = classApplication | 3
+	opSearchEntry      = classApplication | constructed | 4
+	opSearchDone       = classApplication | constructed | 5
+	opSearchReference  = classApplication | constructed | 19
+	opExtendedRequest  = classApplication | 23
+	opExtendedResponse = classApplication | constructed | 24
+)
+
+// Result codes
+const (
+	resultSuccess      = 0
+	resultSizeLimit    = 4
+	resultInvalidCreds = 49
+)
+
+const (
+	oidStartTLS       = "1.3.6.1.4.1.1466.20037"
+	protocolVersion   = 3
+	scopeWholeSubtree = 2
+	derefNever        = 0
+	defaultPlainPort  = "389"
+	defaultTLSPort    = "636"
+)
+
+// ResultError is a non-success result code returned by the server
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Entry is a search result; attribute names are lower-cased
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get returns the first value of an attribute
+func (e *Entry) Get(attr string) string {
+	if v := e.Attributes[strings.ToLower(attr)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// conn is one LDAP connection; operations on it are sequential
+type conn struct {
+	netConn net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	msgID   int64
+	// boundAs is the DN of the last successful bind
+	boundAs string
+	bound   bool
+	broken  bool
+}
+
+// dial connects to an ldap:// or ldaps:// URL, upgrading plain connections
+// with StartTLS when requested
+func dial(ctx context.Context, rawURL string, startTLS bool, tlsCfg *tls.Config, timeout time.Duration) (*conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid url: %w", err)
+	}
+	host, port := u.Hostname(), u.Port()
+
+	d := &net.Dialer{Timeout: timeout}
+	var nc net.Conn
+	switch strings.ToLower(u.Scheme) {
+	case "ldaps":
+		if port == "" {
+			port = defaultTLSPort
+		}
+		td := &tls.Dialer{NetDialer: d, Config: withServerName(tlsCfg, host)}
+		nc, err = td.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	case "ldap":
+		if port == "" {
+			port = defaultPlainPort
+		}
+		nc, err = d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported url scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: failed to connect to %s: %w", u.Host, err)
+	}
+
+	c := &conn{netConn: nc, r: bufio.NewReader(nc), timeout: timeout}
+	if startTLS && strings.EqualFold(u.Scheme, "ldap") {
+		if err := c.startTLS(ctx, withServerName(tlsCfg, host)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func withServerName(cfg *tls.Config, host string) *tls.Config {
+	cfg = cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	return cfg
+}
+
+// startTLS upgrades the connection (RFC 4511 section 4.14)
+func (c *conn) startTLS(ctx context.Context, cfg *tls.Config) error {
+	op := encodeConstructed(opExtendedRequest, encodeString(classContext|0, oidStartTLS))
+	resp, err := c.do(ctx, op, opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := resultOf(resp); err != nil {
+		return fmt.Errorf("ldap: StartTLS refused: %w", err)
+	}
+
+	tc := tls.Client(c.netConn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("ldap: TLS handshake failed: %w", err)
+	}
+	c.netConn = tc
+	c.r = bufio.NewReader(tc)
+	return nil
+}
+
+// bind authenticates the connection with a simple bind
+func (c *conn) bind(ctx context.Context, dn, password string) error {
+	op := encodeConstructed(opBindRequest,
+		encodeInt(tagInteger, protocolVersion),
+		encodeString(tagOctetString, dn),
+		encodeString(classContext|0, password),
+	)
+	resp, err := c.do(ctx, op, opBindResponse)
+	if err != nil {
+		return err
+	}
+	c.bound = false
+	if err := resultOf(resp); err != nil {
+		return err
+	}
+	c.boundAs, c.bound = dn, true
+	return nil
+}
+
+// search runs a subtree search and collects the entries
+func (c *conn) search(ctx context.Context, baseDN, filter string, attrs []string, sizeLimit int) ([]*Entry, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrList := make([][]byte, 0, len(attrs))
+	for _, a := range attrs {
+		if a != "" {
+			attrList = append(attrList, encodeString(tagOctetString, a))
+		}
+	}
+	op := encodeConstructed(opSearchRequest,
+		encodeString(tagOctetString, baseDN),
+		encodeInt(tagEnumerated, scopeWholeSubtree),
+		encodeInt(tagEnumerated, derefNever),
+		encodeInt(tagInteger, int64(sizeLimit)),
+		encodeInt(tagInteger, int64(c.timeout/time.Second)),
+		encodeBool(false),
+		compiled,
+		encodeConstructed(tagSequence, attrList...),
+	)
+
+	id, err := c.send(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for {
+		resp, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.tag {
+		case opSearchEntry:
+			entries = append(entries, parseEntry(resp))
+		case opSearchReference:
+			// Referrals to other servers are not followed
+		case opSearchDone:
+			if err := resultOf(resp); err != nil {
+				var re *ResultError
+				if errors.As(err, &re) && re.Code == resultSizeLimit {
+					return entries, nil
+				}
+				return nil, err
+			}
+			return entries, nil
+		default:
+			c.broken = true
+			return nil, fmt.Errorf("ldap: unexpected response 0x%02x to search", resp.tag)
+		}
+	}
+}
+
+// close unbinds and closes the connection
+func (c *conn) close() {
+	if !c.broken {
+		_, _ = c.send(context.Background(), encode(opUnbindRequest, nil))
+	}
+	_ = c.netConn.Close()
+}
+
+// do sends a request and reads its single response
+func (c *conn) do(ctx context.Context, op []byte, want byte) (*element, error) {
+	id, err := c.send(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if resp.tag != want {
+		c.broken = true
+		return nil, fmt.Errorf("ldap: unexpected response 0x%02x", resp.tag)
+	}
+	return resp, nil
+}
+
+func (c *conn) send(ctx context.Context, op []byte) (int64, error) {
+	c.msgID++
+	msg := encodeConstructed(tagSequence, encodeInt(tagInteger, c.msgID), op)
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.netConn.SetDeadline(deadline); err != nil {
+		c.broken = true
+		return 0, err
+	}
+	if _, err := c.netConn.Write(msg); err != nil {
+		c.broken = true
+		return 0, fmt.Errorf("ldap: write failed: %w", err)
+	}
+	return c.msgID, nil
+}
+
+// receive reads messages until one answers id
+func (c *conn) receive(id int64) (*element, error) {
+	for {
+		msg, err := readElement(c.r)
+		if err != nil {
+			c.broken = true
+			return nil, fmt.Errorf("ldap: read failed: %w", err)
+		}
+		if msg.tag != tagSequence || len(msg.children) < 2 {
+			c.broken = true
+			return nil, errors.New("ldap: malformed message")
+		}
+		switch msgID := msg.child(0).int(); {
+		case msgID == id:
+			return msg.child(1), nil
+		case msgID == 0:
+			// Unsolicited notification, e.g. notice of disconnection
+			c.broken = true
+			return nil, fmt.Errorf("ldap: server closed the connection: %v", resultOf(msg.child(1)))
+		}
+	}
+}
+
+// resultOf turns an LDAPResult into an error
+func resultOf(op *element) error {
+	code := op.child(0).int()
+	if code == resultSuccess {
+		return nil
+	}
+	return &ResultError{Code: code, Message: op.child(2).str()}
+}
+
+func parseEntry(op *element) *Entry {
+	e := &Entry{DN: op.child(0).str(), Attributes: make(map[string][]string)}
+	for _, attr := range op.child(1).children {
+		name := strings.ToLower(attr.child(0).str())
+		for _, v := range attr.child(1).children {
+			e.Attributes[name] = append(e.Attributes[name], v.str())
+		}
+	}
+	return e
+}
@@ -0,0 +1,205 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Filter choice tags (RFC 4511 section 4.5.1)
+const (
+	filterAnd            = classContext | 0
+	filterOr             = classContext | 1
+	filterNot            = classContext | 2
+	filterEquality       = classContext | 3
+	filterSubstrings     = classContext | 4
+	filterGreaterOrEqual = classContext | 5
+	filterLessOrEqual    = classContext | 6
+	filterPresent        = classContext | 7
+	filterApprox         = classContext | 8
+)
+
+// EscapeFilter escapes a value for use in a search filter (RFC 4515), so
+// a username like "*)(uid=*" cannot widen the search
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter encodes the string form of a search filter (RFC 4515)
+func compileFilter(s string) ([]byte, error) {
+	p := &filterParser{s: strings.TrimSpace(s)}
+	out, err := p.filter()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", s, err)
+	}
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q at %d", s, p.s[p.pos:], p.pos)
+	}
+	return out, nil
+}
+
+type filterParser struct {
+	s   string
+	pos int
+}
+
+func (p *filterParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *filterParser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("expected %q at %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) filter() ([]byte, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	var err error
+	switch p.peek() {
+	case '&':
+		p.pos++
+		out, err = p.list(filterAnd)
+	case '|':
+		p.pos++
+		out, err = p.list(filterOr)
+	case '!':
+		p.pos++
+		var inner []byte
+		if inner, err = p.filter(); err == nil {
+			out = encodeConstructed(filterNot, inner)
+		}
+	default:
+		out, err = p.item()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, p.expect(')')
+}
+
+func (p *filterParser) list(tag byte) ([]byte, error) {
+	var items [][]byte
+	for p.peek() == '(' {
+		f, err := p.filter()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, f)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("empty filter list at %d", p.pos)
+	}
+	return encodeConstructed(tag, items...), nil
+}
+
+func (p *filterParser) item() ([]byte, error) {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune("=~<>()", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	attr := p.s[start:p.pos]
+	if attr == "" {
+		return nil, fmt.Errorf("missing attribute at %d", start)
+	}
+
+	tag := byte(filterEquality)
+	switch p.peek() {
+	case '~':
+		tag = filterApprox
+		p.pos++
+	case '>':
+		tag = filterGreaterOrEqual
+		p.pos++
+	case '<':
+		tag = filterLessOrEqual
+		p.pos++
+	}
+	if err := p.expect('='); err != nil {
+		return nil, err
+	}
+
+	start = p.pos
+	for p.pos < len(p.s) && p.s[p.pos] != ')' && p.s[p.pos] != '(' {
+		p.pos++
+	}
+	raw := p.s[start:p.pos]
+
+	if tag == filterEquality && raw == "*" {
+		return encodeString(filterPresent, attr), nil
+	}
+	if tag == filterEquality && strings.Contains(raw, "*") {
+		return substrings(attr, raw)
+	}
+	value, err := unescapeFilter(raw)
+	if err != nil {
+		return nil, err
+	}
+	return encodeConstructed(tag, encodeString(tagOctetString, attr), encodeString(tagOctetString, value)), nil
+}
+
+// substrings encodes a value with wildcards: initial*any*...*final
+func substrings(attr, raw string) ([]byte, error) {
+	parts := strings.Split(raw, "*")
+	var subs [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		value, err := unescapeFilter(part)
+		if err != nil {
+			return nil, err
+		}
+		choice := byte(classContext | 1) // any
+		switch i {
+		case 0:
+			choice = classContext | 0 // initial
+		case len(parts) - 1:
+			choice = classContext | 2 // final
+		}
+		subs = append(subs, encodeString(choice, value))
+	}
+	return encodeConstructed(filterSubstrings, encodeString(tagOctetString, attr), encodeConstructed(tagSequence, subs...)), nil
+}
+
+// unescapeFilter decodes \XX escapes in a filter value
+func unescapeFilter(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("incomplete escape in %q", s)
+		}
+		decoded, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
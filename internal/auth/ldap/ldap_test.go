@@ -0,0 +1,320 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/auth"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+const (
+	serviceDN       = "cn=svc-backup,ou=services,dc=example,dc=com"
+	servicePassword = "svc-pass"
+)
+
+// fakeDirectory is a minimal LDAP server holding a fixed set of entries,
+// built on the same BER codec as the client
+type fakeDirectory struct {
+	entries   map[string]map[string][]string // DN -> attributes
+	passwords map[string]string              // DN -> password
+	accepted  atomic.Int32
+	listener  net.Listener
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	t.Helper()
+	d := &fakeDirectory{
+		entries: map[string]map[string][]string{
+			"uid=alice,ou=people,dc=example,dc=com": {
+				"uid": {"alice"}, "mail": {"alice@example.com"},
+				"memberof": {"cn=DBA,ou=groups,dc=example,dc=com"},
+			},
+			"uid=bob,ou=people,dc=example,dc=com": {
+				"uid": {"bob"}, "mail": {"bob@example.com"},
+			},
+			"cn=backup-operators,ou=groups,dc=example,dc=com": {
+				"cn":     {"backup-operators"},
+				"member": {"uid=bob,ou=people,dc=example,dc=com"},
+			},
+		},
+		passwords: map[string]string{
+			serviceDN:                               servicePassword,
+			"uid=alice,ou=people,dc=example,dc=com": "alice-pass",
+			"uid=bob,ou=people,dc=example,dc=com":   "bob-pass",
+		},
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.listener = l
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			d.accepted.Add(1)
+			go d.serve(c)
+		}
+	}()
+	return d
+}
+
+func (d *fakeDirectory) url() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+func (d *fakeDirectory) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		msg, err := readElement(r)
+		if err != nil {
+			return
+		}
+		id, op := msg.child(0).int(), msg.child(1)
+		reply := func(resp []byte) {
+			_, _ = c.Write(encodeConstructed(tagSequence, encodeInt(tagInteger, id), resp))
+		}
+
+		switch op.tag {
+		case opBindRequest | constructed:
+			dn, password := op.child(1).str(), op.child(2).str()
+			code := int64(resultInvalidCreds)
+			if pw, ok := d.passwords[dn]; ok && pw == password {
+				code = resultSuccess
+			}
+			reply(ldapResult(opBindResponse, code))
+		case opSearchRequest | constructed:
+			base, filter := strings.ToLower(op.child(0).str()), op.child(6)
+			for dn, attrs := range d.entries {
+				if !strings.HasSuffix(strings.ToLower(dn), base) || !matches(filter, attrs) {
+					continue
+				}
+				var list [][]byte
+				for name, values := range attrs {
+					var vals [][]byte
+					for _, v := range values {
+						vals = append(vals, encodeString(tagOctetString, v))
+					}
+					list = append(list, encodeConstructed(tagSequence, encodeString(tagOctetString, name), encodeConstructed(tagSet, vals...)))
+				}
+				reply(encodeConstructed(opSearchEntry, encodeString(tagOctetString, dn), encodeConstructed(tagSequence, list...)))
+			}
+			reply(ldapResult(opSearchDone, resultSuccess))
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+func ldapResult(tag byte, code int64) []byte {
+	return encodeConstructed(tag, encodeInt(tagEnumerated, code), encodeString(tagOctetString, ""), encodeString(tagOctetString, ""))
+}
+
+// matches evaluates and, or, not, equality and presence filters
+func matches(f *element, attrs map[string][]string) bool {
+	switch f.tag {
+	case filterAnd | constructed:
+		for _, c := range f.children {
+			if !matches(c, attrs) {
+				return false
+			}
+		}
+		return true
+	case filterOr | constructed:
+		for _, c := range f.children {
+			if matches(c, attrs) {
+				return true
+			}
+		}
+		return false
+	case filterNot | constructed:
+		return !matches(f.child(0), attrs)
+	case filterEquality | constructed:
+		for _, v := range attrs[strings.ToLower(f.child(0).str())] {
+			if strings.EqualFold(v, f.child(1).str()) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return len(attrs[strings.ToLower(f.str())]) > 0
+	}
+	return false
+}
+
+func testConfig(d *fakeDirectory) config.LDAPConfig {
+	return config.LDAPConfig{
+		URL:               d.url(),
+		BindDN:            serviceDN,
+		BindPassword:      servicePassword,
+		UserBaseDN:        "ou=people,dc=example,dc=com",
+		UserFilter:        "(&(uid=*)(uid={username}))",
+		EmailAttribute:    "mail",
+		MemberOfAttribute: "memberOf",
+		GroupBaseDN:       "ou=groups,dc=example,dc=com",
+		GroupFilter:       "(member={dn})",
+		// viper lower-cases map keys
+		GroupRoles: map[string]string{
+			"dba": "admin",
+			"cn=backup-operators,ou=groups,dc=example,dc=com": "operator",
+		},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	d := newFakeDirectory(t)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantRole auth.Role
+		wantErr  error
+	}{
+		{name: "memberOf group by name", username: "alice", password: "alice-pass", wantRole: auth.RoleAdmin},
+		{name: "group search by DN", username: "bob", password: "bob-pass", wantRole: auth.RoleOperator},
+		{name: "wrong password", username: "alice", password: "nope", wantErr: auth.ErrInvalidCredentials},
+		{name: "empty password", username: "alice", password: "", wantErr: auth.ErrInvalidCredentials},
+		{name: "unknown user", username: "mallory", password: "x", wantErr: auth.ErrInvalidCredentials},
+		{name: "filter injection", username: "*", password: "alice-pass", wantErr: auth.ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(testConfig(d))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer a.Close()
+
+			id, err := a.Authenticate(context.Background(), tt.username, tt.password)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if id.Role != tt.wantRole {
+				t.Errorf("role = %s, want %s", id.Role, tt.wantRole)
+			}
+			if id.Email != tt.username+"@example.com" {
+				t.Errorf("email = %q", id.Email)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRoleMapping(t *testing.T) {
+	d := newFakeDirectory(t)
+	cfg := testConfig(d)
+	cfg.GroupRoles = nil
+
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(context.Background(), "bob", "bob-pass"); !errors.Is(err, auth.ErrNoRole) {
+		t.Errorf("without mapped groups error = %v, want ErrNoRole", err)
+	}
+
+	cfg.DefaultRole = "viewer"
+	if a, err = New(cfg); err != nil {
+		t.Fatal(err)
+	}
+	id, err := a.Authenticate(context.Background(), "bob", "bob-pass")
+	if err != nil || id.Role != auth.RoleViewer {
+		t.Errorf("with default role = %v, %v, want viewer", id, err)
+	}
+}
+
+func TestAuthenticateReusesConnections(t *testing.T) {
+	d := newFakeDirectory(t)
+	a, err := New(testConfig(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		if _, err := a.Authenticate(context.Background(), user, user+"-pass"); err != nil {
+			t.Fatalf("Authenticate(%s) error = %v", user, err)
+		}
+	}
+	if n := d.accepted.Load(); n != 1 {
+		t.Errorf("opened %d connections, want 1", n)
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		want    []byte
+		wantErr bool
+	}{
+		{filter: "(uid=bob)", want: []byte{0xa3, 0x0a, 0x04, 0x03, 'u', 'i', 'd', 0x04, 0x03, 'b', 'o', 'b'}},
+		{filter: "(mail=*)", want: []byte{0x87, 0x04, 'm', 'a', 'i', 'l'}},
+		{filter: `(cn=a\2ab)`, want: []byte{0xa3, 0x09, 0x04, 0x02, 'c', 'n', 0x04, 0x03, 'a', '*', 'b'}},
+		{filter: "(cn=ad*ns)", want: []byte{0xa4, 0x0e, 0x04, 0x02, 'c', 'n', 0x30, 0x08, 0x80, 0x02, 'a', 'd', 0x82, 0x02, 'n', 's'}},
+		{filter: "(&(objectClass=person)(!(uid=root)))"},
+		{filter: "(|(uid=a)(uid=b))"},
+		{filter: "uid=bob", wantErr: true},
+		{filter: "(uid=bob", wantErr: true},
+		{filter: "(&)", wantErr: true},
+		{filter: `(cn=a\2)`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := compileFilter(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != nil && !bytes.Equal(got, tt.want) {
+				t.Errorf("compileFilter() = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	if got, want := EscapeFilter(`*)(uid=*\`), `\2a\29\28uid=\2a\5c`; got != want {
+		t.Errorf("EscapeFilter() = %s, want %s", got, want)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	d := newFakeDirectory(t)
+	cfg := config.SecurityConfig{LDAP: testConfig(d)}
+
+	a, err := FromConfig(cfg)
+	if err != nil || a != nil {
+		t.Fatalf("FromConfig() with LDAP disabled = %v, %v", a, err)
+	}
+
+	cfg.LDAP.Enabled = true
+	if a, err = FromConfig(cfg); err != nil || a == nil {
+		t.Fatalf("FromConfig() = %v, %v", a, err)
+	}
+	defer a.Close()
+	if id, err := a.Authenticate(context.Background(), "alice", "alice-pass"); err != nil || id.Role != auth.RoleAdmin {
+		t.Errorf("Authenticate() = %v, %v", id, err)
+	}
+
+	cfg.LDAP.DefaultRole = "root"
+	if _, err := FromConfig(cfg); err == nil {
+		t.Error("FromConfig() accepted an unknown role")
+	}
+}
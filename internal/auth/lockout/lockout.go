@@ -0,0 +1,387 @@
+// Package lockout protects logins against brute force. Failed logins are
+// counted per user and per client IP within a sliding window; reaching the
+// limit locks the user or IP out, for twice as long with every further
+// lockout. It complements the request rate limiter, which cannot tell a
+// slow password guesser from a busy client. It also watches for password
+// spraying (one IP failing against many users) and distributed guessing
+// (one user failing from many IPs) and reports both as alerts.
+//
+// The counters live in process memory; every API server replica keeps its
+// own. At most maxRecords users and as many IPs are tracked: logins with
+// made-up usernames evict the least recently failing records instead of
+// growing memory without bound.
+package lockout
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+// Kinds of lockout
+const (
+	KindUser = "user"
+	KindIP   = "ip"
+)
+
+// Alert kinds
+const (
+	AlertUserLocked  = "user_locked"
+	AlertIPLocked    = "ip_locked"
+	AlertSpray       = "password_spray"
+	AlertDistributed = "distributed_attack"
+)
+
+// ErrLocked is wrapped by LockedError
+var ErrLocked = errors.New("too many failed logins")
+
+// LockedError reports a login refused because the user or client IP is
+// locked out
+type LockedError struct {
+	Kind  string
+	Key   string
+	Until time.Time
+}
+
+// Error implements the error interface. It does not say whether the user
+// or the IP is locked, so it cannot be used to probe for usernames.
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%v, try again after %s", ErrLocked, e.Until.UTC().Format(time.RFC3339))
+}
+
+// Unwrap returns ErrLocked
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// RetryAfter is how long the lockout lasts from now
+func (e *LockedError) RetryAfter(now time.Time) time.Duration {
+	if d := e.Until.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Alert reports a lockout or a suspicious pattern of failed logins
+type Alert struct {
+	Kind     string    `json:"kind"`
+	User     string    `json:"user,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Failures int       `json:"failures"`
+	Distinct int       `json:"distinct,omitempty"` // users for a spray, IPs for a distributed attack
+	Until    time.Time `json:"until,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Notification describes the alert for the notification channels
+func (a Alert) Notification() *notification.Notification {
+	n := &notification.Notification{
+		Event:     notification.EventWarning,
+		Severity:  "warning",
+		Fields:    map[string]string{"Failures": fmt.Sprint(a.Failures)},
+		Timestamp: a.At,
+	}
+	switch a.Kind {
+	case AlertUserLocked:
+		n.Title = fmt.Sprintf("Login locked for user %s", a.User)
+		n.Message = fmt.Sprintf("%d failed logins, the last from %s. Locked until %s.", a.Failures, a.IP, a.Until.UTC().Format(time.RFC3339))
+	case AlertIPLocked:
+		n.Title = fmt.Sprintf("Logins locked for client %s", a.IP)
+		n.Message = fmt.Sprintf("%d failed logins from %s. Locked until %s.", a.Failures, a.IP, a.Until.UTC().Format(time.RFC3339))
+	case AlertSpray:
+		n.Severity = "critical"
+		n.Title = fmt.Sprintf("Password spraying from %s", a.IP)
+		n.Message = fmt.Sprintf("Failed logins against %d different users from %s.", a.Distinct, a.IP)
+	case AlertDistributed:
+		n.Severity = "critical"
+		n.Title = fmt.Sprintf("Distributed password guessing against %s", a.User)
+		n.Message = fmt.Sprintf("Failed logins for %s from %d different addresses.", a.User, a.Distinct)
+	}
+	if a.User != "" {
+		n.Fields["User"] = a.User
+	}
+	if a.IP != "" {
+		n.Fields["Client IP"] = a.IP
+	}
+	return n
+}
+
+// Lockout describes the failure record of a user or client IP
+type Lockout struct {
+	Kind     string    `json:"kind"`
+	Key      string    `json:"key"`
+	Failures int       `json:"failures"` // within the window
+	Lockouts int       `json:"lockouts"` // so far, each doubling the next
+	Until    time.Time `json:"until,omitempty"`
+	Locked   bool      `json:"locked"`
+}
+
+// maxRecords is the most users, and the most client IPs, tracked at once
+const maxRecords = 10000
+
+// evictScan is how many of the least recently failing records eviction
+// looks through for one that is not locked out
+const evictScan = 64
+
+// record tracks the failures of one user or IP
+type record struct {
+	key      string
+	elem     *list.Element
+	failures []time.Time
+	// peers are the IPs a user failed from, or the users an IP failed
+	// against, with the time of the last failure
+	peers    map[string]time.Time
+	lockouts int
+	until    time.Time
+	alerted  time.Time
+	last     time.Time
+}
+
+// records holds the records of users or IPs, the most recently failing
+// first
+type records struct {
+	byKey map[string]*record
+	order *list.List
+	limit int
+}
+
+func newRecords(limit int) *records {
+	return &records{byKey: make(map[string]*record), order: list.New(), limit: limit}
+}
+
+// touch returns the record of key, created when missing, as the most
+// recently failing one. Adding a record to a full set evicts the least
+// recently failing record that is not locked out, or the least recently
+// failing one when those near the end all are.
+func (rs *records) touch(key string, now time.Time) *record {
+	if r, ok := rs.byKey[key]; ok {
+		rs.order.MoveToFront(r.elem)
+		return r
+	}
+	if len(rs.byKey) >= rs.limit {
+		victim := rs.order.Back()
+		for e, n := victim, 0; e != nil && n < evictScan; e, n = e.Prev(), n+1 {
+			if !now.Before(e.Value.(*record).until) {
+				victim = e
+				break
+			}
+		}
+		rs.delete(victim.Value.(*record).key)
+	}
+	r := &record{key: key, peers: make(map[string]time.Time)}
+	r.elem = rs.order.PushFront(r)
+	rs.byKey[key] = r
+	return r
+}
+
+func (rs *records) get(key string) (*record, bool) {
+	r, ok := rs.byKey[key]
+	return r, ok
+}
+
+// delete forgets key and reports whether it was tracked
+func (rs *records) delete(key string) bool {
+	r, ok := rs.byKey[key]
+	if ok {
+		rs.order.Remove(r.elem)
+		delete(rs.byKey, key)
+	}
+	return ok
+}
+
+// Tracker counts failed logins and decides lockouts
+type Tracker struct {
+	config config.LockoutConfig
+
+	mu        sync.Mutex
+	users     *records
+	ips       *records
+	lastPrune time.Time
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// New creates a tracker
+func New(cfg config.LockoutConfig) *Tracker {
+	return &Tracker{
+		config: cfg,
+		users:  newRecords(maxRecords),
+		ips:    newRecords(maxRecords),
+		now:    time.Now,
+	}
+}
+
+// normalize makes usernames case- and whitespace-insensitive, as most
+// directories are
+func normalize(user string) string {
+	return strings.ToLower(strings.TrimSpace(user))
+}
+
+// Check returns a *LockedError when the user or the client IP is locked out
+func (t *Tracker) Check(user, ip string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+
+	if r, ok := t.ips.get(ip); ok && now.Before(r.until) {
+		return &LockedError{Kind: KindIP, Key: ip, Until: r.until}
+	}
+	if r, ok := t.users.get(normalize(user)); ok && now.Before(r.until) {
+		return &LockedError{Kind: KindUser, Key: normalize(user), Until: r.until}
+	}
+	return nil
+}
+
+// Failure records a failed login and returns the alerts it raises: a new
+// lockout of the user or IP, password spraying or distributed guessing
+func (t *Tracker) Failure(user, ip string) []Alert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.prune(now)
+	user = normalize(user)
+
+	var alerts []Alert
+	u := t.fail(t.users, user, ip, now)
+	if t.lock(u, t.config.MaxAttempts, now) {
+		alerts = append(alerts, Alert{Kind: AlertUserLocked, User: user, IP: ip, Failures: t.config.MaxAttempts, Until: u.until, At: now})
+	}
+	i := t.fail(t.ips, ip, user, now)
+	if t.lock(i, t.config.MaxAttemptsPerIP, now) {
+		alerts = append(alerts, Alert{Kind: AlertIPLocked, IP: ip, Failures: t.config.MaxAttemptsPerIP, Until: i.until, At: now})
+	}
+
+	if n := len(i.peers); n >= t.config.SprayThreshold && now.Sub(i.alerted) >= t.config.Window {
+		i.alerted = now
+		alerts = append(alerts, Alert{Kind: AlertSpray, IP: ip, Failures: len(i.failures), Distinct: n, At: now})
+	}
+	if n := len(u.peers); n >= t.config.SprayThreshold && now.Sub(u.alerted) >= t.config.Window {
+		u.alerted = now
+		alerts = append(alerts, Alert{Kind: AlertDistributed, User: user, Failures: len(u.failures), Distinct: n, At: now})
+	}
+	return alerts
+}
+
+// Success clears the failures of a user after a successful login. The
+// client IP's failures are kept, so an attacker holding one valid account
+// cannot use it to reset the IP limit.
+func (t *Tracker) Success(user, _ string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.users.delete(normalize(user))
+}
+
+// Unlock lifts the lockout of a user or client IP and forgets its
+// failures. It reports whether there was anything to forget.
+func (t *Tracker) Unlock(kind, key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rs := t.ips
+	if kind == KindUser {
+		rs = t.users
+		key = normalize(key)
+	}
+	return rs.delete(key)
+}
+
+// Lockouts lists the users and IPs with recent failures or lockouts,
+// locked ones first
+func (t *Tracker) Lockouts() []Lockout {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.prune(now)
+
+	var out []Lockout
+	for kind, rs := range map[string]*records{KindUser: t.users, KindIP: t.ips} {
+		for key, r := range rs.byKey {
+			t.expire(r, now)
+			l := Lockout{Kind: kind, Key: key, Failures: len(r.failures), Lockouts: r.lockouts, Locked: now.Before(r.until)}
+			if l.Locked {
+				l.Until = r.until
+			}
+			out = append(out, l)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Locked != out[j].Locked {
+			return out[i].Locked
+		}
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// fail records one failure against key, from or against peer
+func (t *Tracker) fail(rs *records, key, peer string, now time.Time) *record {
+	r := rs.touch(key, now)
+	t.expire(r, now)
+	r.failures = append(r.failures, now)
+	if peer != "" {
+		r.peers[peer] = now
+	}
+	r.last = now
+	return r
+}
+
+// lock locks r out when it reached limit failures and is not locked yet,
+// doubling the duration with every lockout
+func (t *Tracker) lock(r *record, limit int, now time.Time) bool {
+	if len(r.failures) < limit || now.Before(r.until) {
+		return false
+	}
+	d := t.config.BaseDuration
+	for i := 0; i < r.lockouts && d < t.config.MaxDuration; i++ {
+		d *= 2
+	}
+	d = min(d, t.config.MaxDuration)
+	r.until = now.Add(d)
+	r.lockouts++
+	r.failures = nil
+	return true
+}
+
+// expire drops failures and peers older than the window
+func (t *Tracker) expire(r *record, now time.Time) {
+	cutoff := now.Add(-t.config.Window)
+	kept := r.failures[:0]
+	for _, at := range r.failures {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	r.failures = kept
+	for peer, at := range r.peers {
+		if !at.After(cutoff) {
+			delete(r.peers, peer)
+		}
+	}
+}
+
+// prune forgets records idle for longer than the window plus the longest
+// lockout; the lockout count, and so the escalation, starts over then
+func (t *Tracker) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < time.Minute {
+		return
+	}
+	t.lastPrune = now
+	idle := t.config.Window + t.config.MaxDuration
+	for _, rs := range []*records{t.users, t.ips} {
+		for key, r := range rs.byKey {
+			if now.Sub(r.last) > idle && !now.Before(r.until) {
+				rs.delete(key)
+			}
+		}
+	}
+}
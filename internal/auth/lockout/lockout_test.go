@@ -0,0 +1,208 @@
+package lockout
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func newTestTracker(now *time.Time) *Tracker {
+	t := New(config.LockoutConfig{
+		Enabled:          true,
+		MaxAttempts:      3,
+		MaxAttemptsPerIP: 10,
+		Window:           15 * time.Minute,
+		BaseDuration:     time.Minute,
+		MaxDuration:      5 * time.Minute,
+		SprayThreshold:   4,
+	})
+	t.now = func() time.Time { return *now }
+	return t
+}
+
+func alertKinds(alerts []Alert) []string {
+	var kinds []string
+	for _, a := range alerts {
+		kinds = append(kinds, a.Kind)
+	}
+	return kinds
+}
+
+func TestUserLockoutEscalates(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+
+	for round, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		// A new IP each round, so only the user limit is reached
+		ip := fmt.Sprintf("10.0.0.%d", round)
+		var alerts []Alert
+		for i := 0; i < 3; i++ {
+			if err := tr.Check("Alice", ip); err != nil {
+				t.Fatalf("locked before the limit: %v", err)
+			}
+			alerts = tr.Failure(" alice ", ip)
+		}
+		if len(alerts) != 1 || alerts[0].Kind != AlertUserLocked {
+			t.Fatalf("alerts = %v, want user_locked", alertKinds(alerts))
+		}
+
+		err := tr.Check("ALICE", "10.0.1.1")
+		var locked *LockedError
+		if !errors.As(err, &locked) || !errors.Is(err, ErrLocked) {
+			t.Fatalf("Check() = %v, want a lockout", err)
+		}
+		if locked.Kind != KindUser || locked.Until.Sub(now) != want {
+			t.Fatalf("locked %s for %s, want user for %s", locked.Kind, locked.Until.Sub(now), want)
+		}
+		now = locked.Until
+	}
+
+	if err := tr.Check("bob", "10.0.0.0"); err != nil {
+		t.Fatalf("other users must not be locked: %v", err)
+	}
+}
+
+func TestSuccessResetsUserOnly(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+
+	tr.Failure("alice", "10.0.0.1")
+	tr.Failure("alice", "10.0.0.1")
+	tr.Success("alice", "10.0.0.1")
+	if alerts := tr.Failure("alice", "10.0.0.1"); len(alerts) != 0 {
+		t.Fatalf("failures survived a successful login: %v", alertKinds(alerts))
+	}
+
+	for _, l := range tr.Lockouts() {
+		if l.Kind == KindIP && l.Failures != 3 {
+			t.Fatalf("IP failures = %d, want 3", l.Failures)
+		}
+	}
+}
+
+func TestFailuresExpire(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+
+	tr.Failure("alice", "10.0.0.1")
+	tr.Failure("alice", "10.0.0.1")
+	now = now.Add(16 * time.Minute)
+	if alerts := tr.Failure("alice", "10.0.0.1"); len(alerts) != 0 {
+		t.Fatalf("expired failures counted: %v", alertKinds(alerts))
+	}
+}
+
+func TestPasswordSpray(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+
+	var sprays int
+	for i := 0; i < 10; i++ {
+		alerts := tr.Failure(fmt.Sprintf("user%d", i), "203.0.113.9")
+		for _, a := range alerts {
+			switch a.Kind {
+			case AlertSpray:
+				sprays++
+				if a.Distinct != 4 || a.IP != "203.0.113.9" {
+					t.Fatalf("spray alert = %+v", a)
+				}
+			case AlertIPLocked:
+				if i != 9 {
+					t.Fatalf("IP locked after %d failures", i+1)
+				}
+			default:
+				t.Fatalf("unexpected alert %s", a.Kind)
+			}
+		}
+	}
+	if sprays != 1 {
+		t.Fatalf("spray alerts = %d, want 1 per window", sprays)
+	}
+
+	var locked *LockedError
+	if err := tr.Check("newuser", "203.0.113.9"); !errors.As(err, &locked) || locked.Kind != KindIP {
+		t.Fatalf("Check() = %v, want the IP locked", err)
+	}
+}
+
+func TestDistributedAttack(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+	tr.config.MaxAttempts = 100
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, alertKinds(tr.Failure("admin", fmt.Sprintf("198.51.100.%d", i)))...)
+	}
+	if len(got) != 1 || got[0] != AlertDistributed {
+		t.Fatalf("alerts = %v, want distributed_attack", got)
+	}
+	n := tr.Failure("admin", "198.51.100.1")
+	if len(n) != 0 {
+		t.Fatalf("alerted twice within the window: %v", alertKinds(n))
+	}
+}
+
+func TestUnlockAndList(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+
+	for i := 0; i < 3; i++ {
+		tr.Failure("alice", "10.0.0.1")
+	}
+	tr.Failure("bob", "10.0.0.2")
+
+	list := tr.Lockouts()
+	if len(list) != 4 || !list[0].Locked || list[0].Key != "alice" {
+		t.Fatalf("Lockouts() = %+v, want alice locked first", list)
+	}
+
+	if !tr.Unlock(KindUser, "Alice") {
+		t.Fatal("Unlock() found nothing")
+	}
+	if err := tr.Check("alice", "10.0.0.1"); err != nil {
+		t.Fatalf("still locked after Unlock(): %v", err)
+	}
+	if tr.Unlock(KindIP, "192.0.2.1") {
+		t.Fatal("Unlock() of an unknown IP reported success")
+	}
+}
+
+func TestRecordsBounded(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := newTestTracker(&now)
+	tr.users, tr.ips = newRecords(5), newRecords(5)
+
+	for i := 0; i < 3; i++ {
+		tr.Failure("alice", "10.0.0.1")
+	}
+	// Made-up usernames from many addresses
+	for i := 0; i < 50; i++ {
+		now = now.Add(time.Second)
+		tr.Failure(fmt.Sprintf("nobody%d", i), fmt.Sprintf("10.1.0.%d", i))
+	}
+	if n := len(tr.users.byKey); n != 5 || tr.users.order.Len() != 5 {
+		t.Fatalf("tracking %d users, want 5", n)
+	}
+	if len(tr.ips.byKey) != 5 {
+		t.Fatalf("tracking %d IPs, want 5", len(tr.ips.byKey))
+	}
+	// Locked users are evicted last
+	if err := tr.Check("alice", "10.9.9.9"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("lockout of alice evicted: %v", err)
+	}
+	if _, ok := tr.users.get("nobody0"); ok {
+		t.Error("least recently failing user not evicted")
+	}
+}
+
+func TestAlertNotification(t *testing.T) {
+	a := Alert{Kind: AlertSpray, IP: "203.0.113.9", Failures: 12, Distinct: 8, At: time.Now()}
+	n := a.Notification()
+	if n.Severity != "critical" || n.Fields["Client IP"] != "203.0.113.9" || n.Title == "" {
+		t.Fatalf("Notification() = %+v", n)
+	}
+}
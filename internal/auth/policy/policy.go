@@ -0,0 +1,336 @@
+// Package policy evaluates authorization rules beyond role checks, such as
+// "restores to prod-* hosts require the dba group during business hours".
+// Rules come from security.policy and are tried in order like firewall
+// rules: the first rule whose action, attributes and conditions all match
+// decides, otherwise the default effect applies.
+//
+// A deny rule also matches a request missing one of its attributes: a
+// client leaving out target_host must not get past a rule keyed on it. An
+// allow rule only matches requests carrying all of its attributes.
+package policy
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Effect is the outcome of a rule
+type Effect string
+
+const (
+	// Allow lets the request through
+	Allow Effect = "allow"
+	// Deny rejects the request
+	Deny Effect = "deny"
+)
+
+// Request describes one authorization check
+type Request struct {
+	// Action names the operation, e.g. backup.restore
+	Action  string
+	Subject string
+	Role    string
+	Groups  []string
+	// Client is the mutual TLS identity of the caller
+	Client string
+	IP     net.IP
+	// Attributes describe the resource, e.g. database or target_host. Keys
+	// are lower-case.
+	Attributes map[string]string
+	Time       time.Time
+}
+
+// Decision is the result of Evaluate
+type Decision struct {
+	Effect Effect `json:"effect"`
+	// Rule is the name of the deciding rule, empty for the default effect
+	Rule string `json:"rule,omitempty"`
+}
+
+// Allowed reports whether the request may proceed
+func (d Decision) Allowed() bool {
+	return d.Effect == Allow
+}
+
+// String describes the decision for logs and error messages
+func (d Decision) String() string {
+	if d.Rule == "" {
+		return string(d.Effect) + " by default"
+	}
+	return fmt.Sprintf("%s by rule %q", d.Effect, d.Rule)
+}
+
+// Engine evaluates compiled rules; it is safe for concurrent use
+type Engine struct {
+	rules         []rule
+	defaultEffect Effect
+}
+
+type rule struct {
+	name       string
+	effect     Effect
+	actions    []string
+	attributes map[string]string
+	when       condition
+}
+
+type condition struct {
+	subjects []string
+	roles    []string
+	groups   []string
+	clients  []string
+	networks []*net.IPNet
+	days     map[time.Weekday]bool
+	hours    *clockRange
+	location *time.Location
+}
+
+// clockRange is a daily window in minutes after midnight; from > to wraps
+// midnight
+type clockRange struct {
+	from, to int
+}
+
+func (r clockRange) contains(minute int) bool {
+	if r.from <= r.to {
+		return minute >= r.from && minute < r.to
+	}
+	return minute >= r.from || minute < r.to
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// New compiles the configured rules
+func New(cfg config.PolicyConfig) (*Engine, error) {
+	e := &Engine{defaultEffect: Allow}
+	if cfg.DefaultEffect != "" {
+		effect, err := parseEffect(cfg.DefaultEffect)
+		if err != nil {
+			return nil, fmt.Errorf("default_effect: %w", err)
+		}
+		e.defaultEffect = effect
+	}
+
+	for i, rc := range cfg.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("rules[%d]", i)
+		}
+		r, err := compile(name, rc)
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %s: %w", name, err)
+		}
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+func compile(name string, rc config.PolicyRule) (rule, error) {
+	effect, err := parseEffect(rc.Effect)
+	if err != nil {
+		return rule{}, err
+	}
+	if len(rc.Actions) == 0 {
+		return rule{}, fmt.Errorf("no actions")
+	}
+	r := rule{
+		name:       name,
+		effect:     effect,
+		actions:    rc.Actions,
+		attributes: make(map[string]string, len(rc.Attributes)),
+	}
+	for _, pattern := range rc.Actions {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return rule{}, fmt.Errorf("action %q: %w", pattern, err)
+		}
+	}
+	for key, pattern := range rc.Attributes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return rule{}, fmt.Errorf("attribute %s: %w", key, err)
+		}
+		r.attributes[strings.ToLower(key)] = pattern
+	}
+
+	w := rc.When
+	r.when = condition{
+		subjects: w.Subjects,
+		roles:    lower(w.Roles),
+		groups:   w.Groups,
+		clients:  w.Clients,
+		location: time.Local,
+	}
+	for _, cidr := range w.SourceCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return rule{}, err
+		}
+		r.when.networks = append(r.when.networks, network)
+	}
+	if len(w.Days) > 0 {
+		r.when.days = make(map[time.Weekday]bool, len(w.Days))
+		for _, d := range w.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return rule{}, fmt.Errorf("unknown day %q (want mon..sun)", d)
+			}
+			r.when.days[day] = true
+		}
+	}
+	if w.Hours != "" {
+		hours, err := parseClockRange(w.Hours)
+		if err != nil {
+			return rule{}, err
+		}
+		r.when.hours = &hours
+	}
+	if w.Timezone != "" {
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return rule{}, err
+		}
+		r.when.location = loc
+	}
+	return r, nil
+}
+
+func parseEffect(s string) (Effect, error) {
+	switch Effect(strings.ToLower(s)) {
+	case Allow:
+		return Allow, nil
+	case Deny:
+		return Deny, nil
+	}
+	return "", fmt.Errorf("unknown effect %q (want allow or deny)", s)
+}
+
+// parseClockRange parses "09:00-18:00"
+func parseClockRange(s string) (clockRange, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return clockRange{}, fmt.Errorf("hours %q: want HH:MM-HH:MM", s)
+	}
+	f, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return clockRange{}, fmt.Errorf("hours %q: %w", s, err)
+	}
+	t, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return clockRange{}, fmt.Errorf("hours %q: %w", s, err)
+	}
+	return clockRange{from: f.Hour()*60 + f.Minute(), to: t.Hour()*60 + t.Minute()}, nil
+}
+
+func lower(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.ToLower(v)
+	}
+	return out
+}
+
+// Evaluate returns the decision of the first matching rule, or the default
+// effect
+func (e *Engine) Evaluate(req Request) Decision {
+	if req.Time.IsZero() {
+		req.Time = time.Now()
+	}
+	for _, r := range e.rules {
+		if r.matches(req) {
+			return Decision{Effect: r.effect, Rule: r.name}
+		}
+	}
+	return Decision{Effect: e.defaultEffect}
+}
+
+func (r rule) matches(req Request) bool {
+	if !matchAny(r.actions, req.Action) {
+		return false
+	}
+	for key, pattern := range r.attributes {
+		value, ok := req.Attributes[key]
+		if !ok {
+			if r.effect == Deny {
+				continue
+			}
+			return false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+	return r.when.holds(req)
+}
+
+func (c condition) holds(req Request) bool {
+	if len(c.subjects) > 0 && !matchAny(c.subjects, req.Subject) {
+		return false
+	}
+	if len(c.roles) > 0 && !contains(c.roles, strings.ToLower(req.Role)) {
+		return false
+	}
+	if len(c.groups) > 0 && !containsAny(c.groups, req.Groups) {
+		return false
+	}
+	if len(c.clients) > 0 && (req.Client == "" || !matchAny(c.clients, req.Client)) {
+		return false
+	}
+	if len(c.networks) > 0 && !inNetworks(c.networks, req.IP) {
+		return false
+	}
+
+	now := req.Time.In(c.location)
+	if c.days != nil && !c.days[now.Weekday()] {
+		return false
+	}
+	if c.hours != nil && !c.hours.contains(now.Hour()*60+now.Minute()) {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if matched, _ := path.Match(p, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(want, have []string) bool {
+	for _, h := range have {
+		if contains(want, h) {
+			return true
+		}
+	}
+	return false
+}
+
+func inNetworks(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
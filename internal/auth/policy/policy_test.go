@@ -0,0 +1,162 @@
+package policy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// prodRestores is the example from the docs: restores to prod hosts need
+// the dba group during business hours
+var prodRestores = config.PolicyConfig{
+	DefaultEffect: "allow",
+	Rules: []config.PolicyRule{
+		{
+			Name:       "prod-restore-dba-business-hours",
+			Effect:     "allow",
+			Actions:    []string{"backup.restore"},
+			Attributes: map[string]string{"target_host": "prod-*"},
+			When: config.PolicyCondition{
+				Groups:   []string{"dba"},
+				Days:     []string{"mon", "tue", "wed", "thu", "fri"},
+				Hours:    "09:00-18:00",
+				Timezone: "UTC",
+			},
+		},
+		{
+			Name:       "prod-restore-otherwise",
+			Effect:     "deny",
+			Actions:    []string{"backup.restore"},
+			Attributes: map[string]string{"target_host": "prod-*"},
+		},
+		{
+			Name:    "viewers-cannot-touch-schedules",
+			Effect:  "deny",
+			Actions: []string{"schedule.*"},
+			When:    config.PolicyCondition{SourceCIDRs: []string{"0.0.0.0/0"}, Roles: []string{"Viewer"}},
+		},
+	},
+}
+
+func TestEvaluate(t *testing.T) {
+	e, err := New(prodRestores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wednesday
+	noon := time.Date(2025, 1, 8, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2025, 1, 8, 22, 0, 0, 0, time.UTC)
+	saturday := time.Date(2025, 1, 11, 12, 0, 0, 0, time.UTC)
+	prod := map[string]string{"target_host": "prod-db-1", "id": "b1"}
+	staging := map[string]string{"target_host": "staging-db-1"}
+
+	tests := []struct {
+		name string
+		req  Request
+		want Decision
+	}{
+		{
+			name: "dba in business hours",
+			req:  Request{Action: "backup.restore", Groups: []string{"dba"}, Attributes: prod, Time: noon},
+			want: Decision{Effect: Allow, Rule: "prod-restore-dba-business-hours"},
+		},
+		{
+			name: "dba at night",
+			req:  Request{Action: "backup.restore", Groups: []string{"dba"}, Attributes: prod, Time: night},
+			want: Decision{Effect: Deny, Rule: "prod-restore-otherwise"},
+		},
+		{
+			name: "dba on saturday",
+			req:  Request{Action: "backup.restore", Groups: []string{"dba"}, Attributes: prod, Time: saturday},
+			want: Decision{Effect: Deny, Rule: "prod-restore-otherwise"},
+		},
+		{
+			name: "admin without the group",
+			req:  Request{Action: "backup.restore", Role: "admin", Attributes: prod, Time: noon},
+			want: Decision{Effect: Deny, Rule: "prod-restore-otherwise"},
+		},
+		{
+			name: "staging restore",
+			req:  Request{Action: "backup.restore", Attributes: staging, Time: night},
+			want: Decision{Effect: Allow},
+		},
+		{
+			name: "restore without a target host",
+			req:  Request{Action: "backup.restore", Time: night},
+			want: Decision{Effect: Deny, Rule: "prod-restore-otherwise"},
+		},
+		{
+			name: "dba restore without a target host",
+			req:  Request{Action: "backup.restore", Groups: []string{"dba"}, Time: noon},
+			want: Decision{Effect: Deny, Rule: "prod-restore-otherwise"},
+		},
+		{
+			name: "viewer running a schedule",
+			req:  Request{Action: "schedule.run", Role: "viewer", IP: net.ParseIP("10.0.0.1"), Time: noon},
+			want: Decision{Effect: Deny, Rule: "viewers-cannot-touch-schedules"},
+		},
+		{
+			name: "condition on a missing IP",
+			req:  Request{Action: "schedule.run", Role: "viewer", Time: noon},
+			want: Decision{Effect: Allow},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.Evaluate(tt.req); got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultDeny(t *testing.T) {
+	e, err := New(config.PolicyConfig{
+		DefaultEffect: "deny",
+		Rules: []config.PolicyRule{
+			{Name: "ci", Effect: "allow", Actions: []string{"backup.create", "backup.list"}, When: config.PolicyCondition{Clients: []string{"ci-*"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := e.Evaluate(Request{Action: "backup.create", Client: "ci-runner-3"}); !d.Allowed() {
+		t.Errorf("ci client decision = %v", d)
+	}
+	if d := e.Evaluate(Request{Action: "backup.delete", Client: "ci-runner-3"}); d.Allowed() || d.String() != "deny by default" {
+		t.Errorf("unlisted action decision = %v", d)
+	}
+	if d := e.Evaluate(Request{Action: "backup.create"}); d.Allowed() {
+		t.Errorf("request without a client identity decision = %v", d)
+	}
+}
+
+func TestClockRangeWrapsMidnight(t *testing.T) {
+	r, err := parseClockRange("22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for minute, want := range map[int]bool{23 * 60: true, 2 * 60: true, 6 * 60: false, 12 * 60: false} {
+		if got := r.contains(minute); got != want {
+			t.Errorf("contains(%d) = %v, want %v", minute, got, want)
+		}
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	for name, rule := range map[string]config.PolicyRule{
+		"effect":   {Effect: "maybe", Actions: []string{"x"}},
+		"actions":  {Effect: "deny"},
+		"day":      {Effect: "deny", Actions: []string{"x"}, When: config.PolicyCondition{Days: []string{"funday"}}},
+		"hours":    {Effect: "deny", Actions: []string{"x"}, When: config.PolicyCondition{Hours: "9-5"}},
+		"cidr":     {Effect: "deny", Actions: []string{"x"}, When: config.PolicyCondition{SourceCIDRs: []string{"10.0.0.0"}}},
+		"timezone": {Effect: "deny", Actions: []string{"x"}, When: config.PolicyCondition{Timezone: "Mars/Olympus"}},
+		"pattern":  {Effect: "deny", Actions: []string{"backup.["}},
+	} {
+		if _, err := New(config.PolicyConfig{Rules: []config.PolicyRule{rule}}); err == nil {
+			t.Errorf("New() accepted an invalid %s", name)
+		}
+	}
+}
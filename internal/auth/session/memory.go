@@ -0,0 +1,109 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in process memory. Sessions are lost on
+// restart and not shared between API server replicas; use the redis or
+// database backend for those.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]*Session)}
+}
+
+// Create implements Store
+func (m *MemoryStore) Create(_ context.Context, s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	copied := *s
+	m.sessions[s.ID] = &copied
+	return nil
+}
+
+// Get implements Store
+func (m *MemoryStore) Get(_ context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.Expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	copied := *s
+	return &copied, nil
+}
+
+// List implements Store
+func (m *MemoryStore) List(_ context.Context) ([]*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	out := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		copied := *s
+		out = append(out, &copied)
+	}
+	sortNewestFirst(out)
+	return out, nil
+}
+
+// Revoke implements Store
+func (m *MemoryStore) Revoke(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+// RevokeSubject implements Store
+func (m *MemoryStore) RevokeSubject(_ context.Context, subject string) (int, error) {
+	return m.revokeWhere(func(s *Session) bool { return s.Subject == subject }), nil
+}
+
+// RevokeKind implements Store
+func (m *MemoryStore) RevokeKind(_ context.Context, kind Kind) (int, error) {
+	return m.revokeWhere(func(s *Session) bool { return s.Kind == kind }), nil
+}
+
+// Close implements Store
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) revokeWhere(match func(*Session) bool) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, s := range m.sessions {
+		if match(s) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n
+}
+
+// expire drops expired sessions; the caller holds the lock
+func (m *MemoryStore) expire(now time.Time) {
+	for id, s := range m.sessions {
+		if s.Expired(now) {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+func sortNewestFirst(sessions []*Session) {
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].IssuedAt.After(sessions[j].IssuedAt) })
+}
@@ -0,0 +1,354 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisOptions configures the Redis backend
+type RedisOptions struct {
+	Address  string
+	Password string
+	DB       int
+	// KeyPrefix namespaces the keys, e.g. when the instance is shared
+	KeyPrefix string
+	Timeout   time.Duration
+}
+
+// RedisStore keeps sessions in Redis so every API server replica sees a
+// revocation at once. A session is stored as session:<id> with a TTL of its
+// remaining lifetime and indexed in per-subject and per-kind sets, which are
+// pruned lazily.
+//
+// It speaks the RESP protocol directly over a single connection; the
+// handful of commands needed do not justify a client dependency.
+type RedisStore struct {
+	opts RedisOptions
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// maxBulkSize bounds a single reply value
+const maxBulkSize = 16 << 20
+
+// NewRedisStore connects to Redis and checks the connection
+func NewRedisStore(ctx context.Context, opts RedisOptions) (*RedisStore, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = "db-backup:sessions:"
+	}
+	s := &RedisStore{opts: opts}
+	if _, err := s.do(ctx, "PING"); err != nil {
+		return nil, fmt.Errorf("failed to connect to session redis at %s: %w", opts.Address, err)
+	}
+	return s, nil
+}
+
+func (s *RedisStore) sessionKey(id string) string {
+	return s.opts.KeyPrefix + "session:" + id
+}
+
+func (s *RedisStore) subjectKey(subject string) string {
+	return s.opts.KeyPrefix + "subject:" + subject
+}
+
+func (s *RedisStore) kindKey(kind Kind) string {
+	return s.opts.KeyPrefix + "kind:" + string(kind)
+}
+
+// Create implements Store
+func (s *RedisStore) Create(ctx context.Context, sess *Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	args := []string{"SET", s.sessionKey(sess.ID), string(data)}
+	if !sess.ExpiresAt.IsZero() {
+		ttl := time.Until(sess.ExpiresAt).Milliseconds()
+		if ttl <= 0 {
+			return nil
+		}
+		args = append(args, "PX", strconv.FormatInt(ttl, 10))
+	}
+	if _, err := s.do(ctx, args...); err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	if _, err := s.do(ctx, "SADD", s.subjectKey(sess.Subject), sess.ID); err != nil {
+		return fmt.Errorf("failed to index session: %w", err)
+	}
+	if _, err := s.do(ctx, "SADD", s.kindKey(sess.Kind), sess.ID); err != nil {
+		return fmt.Errorf("failed to index session: %w", err)
+	}
+	return nil
+}
+
+// Get implements Store
+func (s *RedisStore) Get(ctx context.Context, id string) (*Session, error) {
+	reply, err := s.do(ctx, "GET", s.sessionKey(id))
+	if err != nil {
+		return nil, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var sess Session
+	if err := json.Unmarshal([]byte(data), &sess); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", id, err)
+	}
+	if sess.Expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return &sess, nil
+}
+
+// List implements Store
+func (s *RedisStore) List(ctx context.Context) ([]*Session, error) {
+	var out []*Session
+	for _, kind := range []Kind{KindToken, KindAPIKey} {
+		sessions, err := s.members(ctx, s.kindKey(kind))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sessions...)
+	}
+	sortNewestFirst(out)
+	return out, nil
+}
+
+// Revoke implements Store
+func (s *RedisStore) Revoke(ctx context.Context, id string) error {
+	reply, err := s.do(ctx, "DEL", s.sessionKey(id))
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeSubject implements Store
+func (s *RedisStore) RevokeSubject(ctx context.Context, subject string) (int, error) {
+	return s.revokeSet(ctx, s.subjectKey(subject))
+}
+
+// RevokeKind implements Store
+func (s *RedisStore) RevokeKind(ctx context.Context, kind Kind) (int, error) {
+	return s.revokeSet(ctx, s.kindKey(kind))
+}
+
+// Close implements Store
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	return nil
+}
+
+// members returns the active sessions indexed in a set, dropping the IDs
+// of sessions that expired or were revoked through another index
+func (s *RedisStore) members(ctx context.Context, set string) ([]*Session, error) {
+	ids, err := s.smembers(ctx, set)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Session
+	for _, id := range ids {
+		sess, err := s.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			if _, err := s.do(ctx, "SREM", set, id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sess)
+	}
+	return out, nil
+}
+
+// revokeSet revokes every session indexed in a set and drops the set
+func (s *RedisStore) revokeSet(ctx context.Context, set string) (int, error) {
+	ids, err := s.smembers(ctx, set)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		reply, err := s.do(ctx, "DEL", s.sessionKey(id))
+		if err != nil {
+			return n, fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if deleted, _ := reply.(int64); deleted > 0 {
+			n++
+		}
+	}
+	if _, err := s.do(ctx, "DEL", set); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (s *RedisStore) smembers(ctx context.Context, set string) ([]string, error) {
+	reply, err := s.do(ctx, "SMEMBERS", set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session index: %w", err)
+	}
+	items, _ := reply.([]interface{})
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		if id, ok := item.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// do sends one command and reads its reply, reconnecting first when the
+// previous command broke the connection. Replies are string (nil for a
+// null bulk), int64 or []interface{}.
+func (s *RedisStore) do(ctx context.Context, args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := s.roundTrip(ctx, args)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		s.reset()
+	}
+	return reply, err
+}
+
+func (s *RedisStore) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: s.opts.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", s.opts.Address)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.r = bufio.NewReader(conn)
+
+	if s.opts.Password != "" {
+		if _, err := s.roundTrip(ctx, []string{"AUTH", s.opts.Password}); err != nil {
+			s.reset()
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	if s.opts.DB != 0 {
+		if _, err := s.roundTrip(ctx, []string{"SELECT", strconv.Itoa(s.opts.DB)}); err != nil {
+			s.reset()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisStore) reset() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = nil
+	s.r = nil
+}
+
+func (s *RedisStore) roundTrip(ctx context.Context, args []string) (interface{}, error) {
+	deadline := time.Now().Add(s.opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := s.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(s.r)
+}
+
+// readReply decodes one RESP2 reply
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > maxBulkSize {
+			return nil, fmt.Errorf("redis: bulk reply of %d bytes exceeds the limit", n)
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,137 @@
+// Package session keeps a server-side record of every issued API token so
+// a token can be revoked before it expires: one session, every session of
+// a user, or every API key at once. Tokens are only accepted while their
+// session exists, so a revocation takes effect on the next request.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Kind is the kind of credential a session backs
+type Kind string
+
+const (
+	// KindToken is a login session (JWT)
+	KindToken Kind = "token"
+	// KindAPIKey is a long-lived API key
+	KindAPIKey Kind = "api_key"
+)
+
+// ErrNotFound is returned for sessions that do not exist, were revoked or
+// have expired
+var ErrNotFound = errors.New("session not found or revoked")
+
+// Session is one issued credential
+type Session struct {
+	ID        string    `json:"id"`
+	Kind      Kind      `json:"kind"`
+	Subject   string    `json:"subject"`
+	Role      string    `json:"role,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the session has expired at now
+func (s *Session) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// Store records sessions. Get must only return sessions that are active.
+type Store interface {
+	Create(ctx context.Context, s *Session) error
+	Get(ctx context.Context, id string) (*Session, error)
+	// List returns the active sessions, newest first
+	List(ctx context.Context) ([]*Session, error)
+	Revoke(ctx context.Context, id string) error
+	// RevokeSubject revokes every session of a user and returns how many
+	RevokeSubject(ctx context.Context, subject string) (int, error)
+	// RevokeKind revokes every session of a kind, e.g. all API keys
+	RevokeKind(ctx context.Context, kind Kind) (int, error)
+	Close() error
+}
+
+// NewID returns a random session ID, used as the token's jti claim
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Open creates the store selected by security.sessions.backend: memory,
+// redis (database.redis) or database (database.metadata)
+func Open(ctx context.Context, cfg *config.Config) (Store, error) {
+	sc := cfg.Security.Sessions
+	switch strings.ToLower(sc.Backend) {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "redis":
+		r := cfg.Database.Redis
+		return NewRedisStore(ctx, RedisOptions{
+			Address:   net.JoinHostPort(r.Host, strconv.Itoa(r.Port)),
+			Password:  r.Password,
+			DB:        r.DB,
+			KeyPrefix: sc.KeyPrefix,
+		})
+	case "database":
+		driver, dsn, err := metadataDSN(cfg.Database.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open session database: %w", err)
+		}
+		store, err := NewSQLStore(ctx, db, driver, sc.Table)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		store.closeDB = true
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown session backend %q (want memory, redis or database)", sc.Backend)
+}
+
+// metadataDSN returns the database/sql driver and DSN of the metadata
+// database
+func metadataDSN(m config.MetadataDBConfig) (string, string, error) {
+	switch strings.ToLower(m.Type) {
+	case "postgres", "postgresql":
+		u := url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(m.User, m.Password),
+			Host:   net.JoinHostPort(m.Host, strconv.Itoa(m.Port)),
+			Path:   "/" + m.Name,
+		}
+		q := url.Values{}
+		if m.SSLMode != "" {
+			q.Set("sslmode", m.SSLMode)
+		}
+		u.RawQuery = q.Encode()
+		return "postgres", u.String(), nil
+	case "mysql", "mariadb":
+		return "mysql", fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true",
+			m.User, m.Password, net.JoinHostPort(m.Host, strconv.Itoa(m.Port)), m.Name), nil
+	case "sqlite", "sqlite3":
+		return "sqlite3", m.Name, nil
+	}
+	return "", "", fmt.Errorf("unsupported metadata database type %q for sessions", m.Type)
+}
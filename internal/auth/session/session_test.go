@@ -0,0 +1,276 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// fakeRedis implements the handful of commands RedisStore uses. TTLs are
+// honoured on read.
+type fakeRedis struct {
+	mu       sync.Mutex
+	password string
+	strings  map[string]string
+	expiry   map[string]time.Time
+	sets     map[string]map[string]bool
+}
+
+func startFakeRedis(t *testing.T, password string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("tcp listener unavailable: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeRedis{
+		password: password,
+		strings:  map[string]string{},
+		expiry:   map[string]time.Time{},
+		sets:     map[string]map[string]bool{},
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			_, _ = io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		if cmd == "AUTH" {
+			if args[1] != f.password {
+				_, _ = io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authed = true
+		}
+		_, _ = io.WriteString(conn, f.exec(cmd, args[1:]))
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	reply, err := readReply(r)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("bad command %v", reply)
+	}
+	args := make([]string, len(items))
+	for i, item := range items {
+		args[i], _ = item.(string)
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) exec(cmd string, args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, at := range f.expiry {
+		if !time.Now().Before(at) {
+			delete(f.strings, key)
+			delete(f.expiry, key)
+		}
+	}
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "SET":
+		f.strings[args[0]] = args[1]
+		delete(f.expiry, args[0])
+		if len(args) == 4 && strings.EqualFold(args[2], "PX") {
+			ms, _ := strconv.Atoi(args[3])
+			f.expiry[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.strings[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "DEL":
+		n := 0
+		for _, key := range args {
+			if _, ok := f.strings[key]; ok {
+				delete(f.strings, key)
+				n++
+			}
+			if _, ok := f.sets[key]; ok {
+				delete(f.sets, key)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "SADD":
+		if f.sets[args[0]] == nil {
+			f.sets[args[0]] = map[string]bool{}
+		}
+		f.sets[args[0]][args[1]] = true
+		return ":1\r\n"
+	case "SREM":
+		delete(f.sets[args[0]], args[1])
+		return ":1\r\n"
+	case "SMEMBERS":
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(f.sets[args[0]]))
+		for m := range f.sets[args[0]] {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(m), m)
+		}
+		return b.String()
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// testStore runs the behaviour every backend must share
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	sessions := []*Session{
+		{ID: "a1", Kind: KindToken, Subject: "alice", Role: "admin", IssuedAt: now.Add(-3 * time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{ID: "a2", Kind: KindToken, Subject: "alice", Role: "admin", IssuedAt: now.Add(-2 * time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{ID: "b1", Kind: KindToken, Subject: "bob", Role: "viewer", IssuedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{ID: "k1", Kind: KindAPIKey, Subject: "ci", Role: "operator", IssuedAt: now},
+		{ID: "k2", Kind: KindAPIKey, Subject: "cron", Role: "operator", IssuedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "old", Kind: KindToken, Subject: "bob", IssuedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+	}
+	for _, sess := range sessions {
+		if err := s.Create(ctx, sess); err != nil {
+			t.Fatalf("Create(%s) error = %v", sess.ID, err)
+		}
+	}
+
+	got, err := s.Get(ctx, "a1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Subject != "alice" || got.Role != "admin" || !got.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Get() = %+v", got)
+	}
+	if _, err := s.Get(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of expired session error = %v, want ErrNotFound", err)
+	}
+
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 5 {
+		t.Fatalf("List() returned %d sessions, want 5", len(list))
+	}
+	if list[len(list)-1].ID != "a1" {
+		t.Errorf("List() is not newest first: last = %s", list[len(list)-1].ID)
+	}
+
+	if err := s.Revoke(ctx, "b1"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if err := s.Revoke(ctx, "b1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Revoke() error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(ctx, "b1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of revoked session error = %v, want ErrNotFound", err)
+	}
+
+	if n, err := s.RevokeSubject(ctx, "alice"); err != nil || n != 2 {
+		t.Errorf("RevokeSubject() = %d, %v, want 2", n, err)
+	}
+	if n, err := s.RevokeKind(ctx, KindAPIKey); err != nil || n != 2 {
+		t.Errorf("RevokeKind() = %d, %v, want 2", n, err)
+	}
+	if list, _ := s.List(ctx); len(list) != 0 {
+		t.Errorf("List() after revoking everything = %d sessions", len(list))
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestRedisStore(t *testing.T) {
+	addr := startFakeRedis(t, "s3cret")
+	s, err := NewRedisStore(context.Background(), RedisOptions{Address: addr, Password: "s3cret", DB: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testStore(t, s)
+
+	if _, err := NewRedisStore(context.Background(), RedisOptions{Address: addr, Password: "wrong"}); err == nil {
+		t.Error("NewRedisStore() with a wrong password succeeded")
+	}
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Each connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	s, err := NewSQLStore(context.Background(), db, "sqlite3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+
+	if _, err := NewSQLStore(context.Background(), db, "sqlite3", "sessions; DROP TABLE x"); err == nil {
+		t.Error("NewSQLStore() accepted an unsafe table name")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	s := &SQLStore{driver: "postgres"}
+	if got := s.rebind("a = ? AND b > ?"); got != "a = $1 AND b > $2" {
+		t.Errorf("rebind() = %s", got)
+	}
+}
+
+func TestMetadataDSN(t *testing.T) {
+	driver, dsn, err := metadataDSN(config.MetadataDBConfig{
+		Type: "postgres", Host: "db", Port: 5432, Name: "backup", User: "app", Password: "p@ss", SSLMode: "require",
+	})
+	if err != nil || driver != "postgres" || dsn != "postgres://app:p%40ss@db:5432/backup?sslmode=require" {
+		t.Errorf("metadataDSN() = %s, %s, %v", driver, dsn, err)
+	}
+	if _, _, err := metadataDSN(config.MetadataDBConfig{Type: "oracle"}); err == nil {
+		t.Error("metadataDSN() accepted an unsupported type")
+	}
+}
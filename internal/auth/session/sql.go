@@ -0,0 +1,202 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// Drivers for the metadata database
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// tableName restricts the configurable table name to a safe identifier
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLStore keeps sessions in a table of the metadata database. Revoked
+// rows are kept with revoked_at set, so the table doubles as a record of
+// who was logged in when; expired rows are deleted on Create.
+type SQLStore struct {
+	db      *sql.DB
+	driver  string
+	table   string
+	closeDB bool
+}
+
+// NewSQLStore creates the sessions table if needed. driver is the
+// database/sql driver name and selects the placeholder style.
+func NewSQLStore(ctx context.Context, db *sql.DB, driver, table string) (*SQLStore, error) {
+	if table == "" {
+		table = "auth_sessions"
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid session table name %q", table)
+	}
+	s := &SQLStore{db: db, driver: driver, table: table}
+
+	schema := `CREATE TABLE IF NOT EXISTS ` + table + ` (
+		id VARCHAR(64) PRIMARY KEY,
+		kind VARCHAR(16) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		role VARCHAR(32) NOT NULL,
+		provider VARCHAR(32) NOT NULL,
+		client_ip VARCHAR(64) NOT NULL,
+		user_agent VARCHAR(512) NOT NULL,
+		issued_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		revoked_at BIGINT
+	)`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, fmt.Errorf("failed to create session table: %w", err)
+	}
+	return s, nil
+}
+
+// rebind rewrites ? placeholders for drivers that number them
+func (s *SQLStore) rebind(query string) string {
+	if s.driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *SQLStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.rebind(query), args...)
+}
+
+// Unix milliseconds keep the schema portable; zero means "never"
+func toMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func fromMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+// Create implements Store
+func (s *SQLStore) Create(ctx context.Context, sess *Session) error {
+	now := time.Now().UnixMilli()
+	if _, err := s.exec(ctx, `DELETE FROM `+s.table+` WHERE expires_at > 0 AND expires_at <= ?`, now); err != nil {
+		return fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	_, err := s.exec(ctx, `INSERT INTO `+s.table+`
+		(id, kind, subject, role, provider, client_ip, user_agent, issued_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sess.ID, string(sess.Kind), sess.Subject, sess.Role, sess.Provider, sess.ClientIP, sess.UserAgent,
+		toMillis(sess.IssuedAt), toMillis(sess.ExpiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+const activeCondition = `revoked_at IS NULL AND (expires_at = 0 OR expires_at > ?)`
+
+// Get implements Store
+func (s *SQLStore) Get(ctx context.Context, id string) (*Session, error) {
+	rows, err := s.query(ctx, `WHERE id = ? AND `+activeCondition, id, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+// List implements Store
+func (s *SQLStore) List(ctx context.Context) ([]*Session, error) {
+	return s.query(ctx, `WHERE `+activeCondition+` ORDER BY issued_at DESC`, time.Now().UnixMilli())
+}
+
+// Revoke implements Store
+func (s *SQLStore) Revoke(ctx context.Context, id string) error {
+	n, err := s.revokeWhere(ctx, `id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeSubject implements Store
+func (s *SQLStore) RevokeSubject(ctx context.Context, subject string) (int, error) {
+	return s.revokeWhere(ctx, `subject = ?`, subject)
+}
+
+// RevokeKind implements Store
+func (s *SQLStore) RevokeKind(ctx context.Context, kind Kind) (int, error) {
+	return s.revokeWhere(ctx, `kind = ?`, string(kind))
+}
+
+// Close implements Store; the database is only closed when Open created it
+func (s *SQLStore) Close() error {
+	if s.closeDB {
+		return s.db.Close()
+	}
+	return nil
+}
+
+func (s *SQLStore) revokeWhere(ctx context.Context, cond string, arg interface{}) (int, error) {
+	now := time.Now().UnixMilli()
+	res, err := s.exec(ctx, `UPDATE `+s.table+` SET revoked_at = ? WHERE `+cond+` AND `+activeCondition, now, arg, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+func (s *SQLStore) query(ctx context.Context, where string, args ...interface{}) ([]*Session, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, kind, subject, role, provider, client_ip, user_agent,
+		issued_at, expires_at FROM `+s.table+` `+where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*Session
+	for rows.Next() {
+		var sess Session
+		var kind string
+		var issued, expires int64
+		if err := rows.Scan(&sess.ID, &kind, &sess.Subject, &sess.Role, &sess.Provider, &sess.ClientIP,
+			&sess.UserAgent, &issued, &expires); err != nil {
+			return nil, fmt.Errorf("failed to read sessions: %w", err)
+		}
+		sess.Kind = Kind(kind)
+		sess.IssuedAt = fromMillis(issued)
+		sess.ExpiresAt = fromMillis(expires)
+		out = append(out, &sess)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sessions: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,254 @@
+// Package autotune compresses backups with zstd while adjusting the
+// compression level and encoder workers to the machine the backup runs
+// on. The stream is written as a sequence of independent zstd frames, each
+// encoded with the settings current when it starts, which any zstd decoder
+// reads as one stream. At every interval the writer compares the CPU
+// utilisation of the machine with the target and where it spent its own
+// time: saturated CPUs lower the level, compression waiting on the output
+// with cores to spare raises it, and compression being the slowest stage
+// with idle cores adds encoder workers.
+package autotune
+
+import (
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// frameSize is the input encoded into one frame, and so the granularity
+// at which settings change
+const frameSize = 8 << 20
+
+// Decision records a change of settings
+type Decision struct {
+	After   time.Duration `json:"after"` // since the writer was created
+	Level   string        `json:"level"`
+	Workers int           `json:"workers"`
+	CPU     float64       `json:"cpu"` // machine utilisation, -1 when unknown
+	Reason  string        `json:"reason"`
+}
+
+// Writer compresses into w, tuning itself as it goes. It is not safe for
+// concurrent use.
+type Writer struct {
+	out      *timedWriter
+	cfg      config.AutoTuneConfig
+	minLevel zstd.EncoderLevel
+	maxLevel zstd.EncoderLevel
+
+	level      zstd.EncoderLevel
+	workers    int
+	maxWorkers int
+	enc        *zstd.Encoder
+	encLevel   zstd.EncoderLevel
+	encWorkers int
+
+	buf       []byte
+	in        int64
+	busy      time.Duration // encoding time, including output writes, since the last tune
+	started   bool
+	start     time.Time
+	lastTune  time.Time
+	cpuBusy   uint64
+	cpuTotal  uint64
+	decisions []Decision
+
+	// Injected by tests
+	now      func() time.Time
+	cpuTimes func() (busy, total uint64, err error)
+}
+
+// NewWriter returns a writer compressing into w at level (1-9) and tuning
+// within cfg's bounds. The caller must Close it to flush the last frame.
+func NewWriter(w io.Writer, level int, cfg config.AutoTuneConfig) *Writer {
+	zw := &Writer{
+		out:        &timedWriter{w: w},
+		cfg:        cfg,
+		minLevel:   zstd.EncoderLevelFromZstd(max(cfg.MinLevel, 1)),
+		maxLevel:   zstd.EncoderLevelFromZstd(max(cfg.MaxLevel, cfg.MinLevel, 1)),
+		workers:    1,
+		maxWorkers: cfg.MaxWorkers,
+		buf:        make([]byte, 0, frameSize),
+		now:        time.Now,
+		cpuTimes:   cpuTimes,
+	}
+	if zw.maxWorkers <= 0 {
+		zw.maxWorkers = runtime.NumCPU()
+	}
+	zw.level = min(max(zstd.EncoderLevelFromZstd(level), zw.minLevel), zw.maxLevel)
+	return zw
+}
+
+// Write buffers p, encoding a frame whenever a full one is buffered
+func (zw *Writer) Write(p []byte) (int, error) {
+	if !zw.started {
+		zw.begin()
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(zw.buf[len(zw.buf):cap(zw.buf)], p)
+		zw.buf = zw.buf[:len(zw.buf)+n]
+		p = p[n:]
+		written += n
+		if len(zw.buf) == cap(zw.buf) {
+			if err := zw.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close encodes the buffered input. An empty stream is written as one
+// empty frame.
+func (zw *Writer) Close() error {
+	if !zw.started {
+		zw.begin()
+	}
+	if len(zw.buf) > 0 || zw.in == 0 {
+		return zw.flush()
+	}
+	return nil
+}
+
+func (zw *Writer) begin() {
+	zw.started = true
+	zw.start = zw.now()
+	zw.out.now = zw.now
+	zw.lastTune = zw.start
+	zw.cpuBusy, zw.cpuTotal, _ = zw.cpuTimes()
+	zw.decide(-1, "initial")
+}
+
+// flush encodes the buffer as one frame, then retunes if an interval has
+// passed
+func (zw *Writer) flush() error {
+	if err := zw.encoder(); err != nil {
+		return err
+	}
+	began := zw.now()
+	zw.enc.Reset(zw.out)
+	if _, err := zw.enc.Write(zw.buf); err != nil {
+		return err
+	}
+	if err := zw.enc.Close(); err != nil {
+		return err
+	}
+	zw.busy += zw.now().Sub(began)
+	zw.in += int64(len(zw.buf))
+	zw.buf = zw.buf[:0]
+
+	if zw.now().Sub(zw.lastTune) >= zw.cfg.Interval {
+		zw.tune()
+	}
+	return nil
+}
+
+// encoder makes sure the encoder matches the current settings
+func (zw *Writer) encoder() error {
+	if zw.enc != nil && zw.encLevel == zw.level && zw.encWorkers == zw.workers {
+		return nil
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zw.level), zstd.WithEncoderConcurrency(zw.workers), zstd.WithZeroFrames(true))
+	if err != nil {
+		return err
+	}
+	zw.enc, zw.encLevel, zw.encWorkers = enc, zw.level, zw.workers
+	return nil
+}
+
+// tune adjusts the settings from the interval just ended
+func (zw *Writer) tune() {
+	now := zw.now()
+	elapsed := now.Sub(zw.lastTune)
+	waiting := zw.out.take()
+	compressing := zw.busy - waiting
+	zw.busy = 0
+	zw.lastTune = now
+
+	cpu := -1.0
+	if busy, total, err := zw.cpuTimes(); err == nil && total > zw.cpuTotal {
+		cpu = float64(busy-zw.cpuBusy) / float64(total-zw.cpuTotal)
+		zw.cpuBusy, zw.cpuTotal = busy, total
+	}
+	saturated := cpu >= zw.cfg.TargetCPU
+	// Room for more work: measured below target with some margin, or
+	// unknown while the writer is not compressing all the time
+	spare := (cpu >= 0 && cpu < zw.cfg.TargetCPU-0.15) || (cpu < 0 && compressing < elapsed/2)
+
+	switch {
+	case saturated && zw.level > zw.minLevel:
+		zw.level--
+		zw.decide(cpu, "CPUs saturated")
+	case compressing > waiting && spare && zw.workers < zw.maxWorkers:
+		zw.workers++
+		zw.decide(cpu, "compression is the slowest stage")
+	case waiting > compressing && spare && zw.level < zw.maxLevel:
+		zw.level++
+		zw.decide(cpu, "output is the slowest stage")
+	}
+}
+
+func (zw *Writer) decide(cpu float64, reason string) {
+	zw.decisions = append(zw.decisions, Decision{
+		After:   zw.now().Sub(zw.start),
+		Level:   zw.level.String(),
+		Workers: zw.workers,
+		CPU:     cpu,
+		Reason:  reason,
+	})
+}
+
+// Decisions returns the settings used, the initial ones first
+func (zw *Writer) Decisions() []Decision {
+	return zw.decisions
+}
+
+// Metadata summarises the tuning for the backup's metadata
+func (zw *Writer) Metadata() map[string]string {
+	m := map[string]string{
+		"compression_autotune":         "true",
+		"compression_level_final":      zw.level.String(),
+		"compression_workers_final":    strconv.Itoa(zw.workers),
+		"compression_autotune_changes": strconv.Itoa(max(len(zw.decisions)-1, 0)),
+	}
+	if len(zw.decisions) > 0 {
+		m["compression_level_initial"] = zw.decisions[0].Level
+	}
+	levels := make(map[string]bool)
+	var used []string
+	for _, d := range zw.decisions {
+		if !levels[d.Level] {
+			levels[d.Level] = true
+			used = append(used, d.Level)
+		}
+	}
+	m["compression_levels_used"] = strings.Join(used, ",")
+	return m
+}
+
+// timedWriter measures the time spent waiting on the output
+type timedWriter struct {
+	w       io.Writer
+	now     func() time.Time
+	waiting time.Duration
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	began := t.now()
+	n, err := t.w.Write(p)
+	t.waiting += t.now().Sub(began)
+	return n, err
+}
+
+// take returns the time waited since the last call
+func (t *timedWriter) take() time.Duration {
+	d := t.waiting
+	t.waiting = 0
+	return d
+}
@@ -0,0 +1,149 @@
+package autotune
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// clock is a fake clock advancing by tick on every reading
+type clock struct {
+	mu   sync.Mutex
+	t    time.Time
+	tick time.Duration
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(c.tick)
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// slowWriter takes delay of fake time per write
+type slowWriter struct {
+	bytes.Buffer
+	clock *clock
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	w.clock.advance(w.delay)
+	return w.Buffer.Write(p)
+}
+
+// cpu reports a fixed utilisation
+func cpu(utilisation float64) func() (uint64, uint64, error) {
+	var busy, total uint64
+	return func() (uint64, uint64, error) {
+		total += 1000
+		busy += uint64(utilisation * 1000)
+		return busy, total, nil
+	}
+}
+
+var tuning = config.AutoTuneConfig{Enabled: true, MinLevel: 1, MaxLevel: 9, MaxWorkers: 4, TargetCPU: 0.85, Interval: time.Second}
+
+func newTestWriter(out io.Writer, c *clock, utilisation float64, level int) *Writer {
+	zw := NewWriter(out, level, tuning)
+	zw.now = c.now
+	zw.cpuTimes = cpu(utilisation)
+	return zw
+}
+
+func input(frames int) []byte {
+	return bytes.Repeat([]byte("INSERT INTO t VALUES (1, 'abc');\n"), frames*frameSize/33+1)
+}
+
+func decode(t *testing.T, data []byte) []byte {
+	t.Helper()
+	dec, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	out, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSaturatedCPULowersLevel(t *testing.T) {
+	c := &clock{tick: time.Second}
+	out := &slowWriter{clock: c}
+	zw := newTestWriter(out, c, 0.99, 9)
+	data := input(4)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decode(t, out.Bytes()), data) {
+		t.Fatal("frames do not decode to the input")
+	}
+	if got := zw.level; got != zstd.SpeedFastest {
+		t.Fatalf("level = %s, want fastest", got)
+	}
+	m := zw.Metadata()
+	if m["compression_level_initial"] != "better" || m["compression_level_final"] != "fastest" || m["compression_autotune_changes"] != "2" {
+		t.Fatalf("Metadata() = %v", m)
+	}
+}
+
+func TestSlowOutputRaisesLevel(t *testing.T) {
+	c := &clock{}
+	out := &slowWriter{clock: c, delay: 2 * time.Second}
+	zw := newTestWriter(out, c, 0.3, 1)
+	data := input(4)
+	zw.Write(data)
+	zw.Close()
+
+	if !bytes.Equal(decode(t, out.Bytes()), data) {
+		t.Fatal("frames do not decode to the input")
+	}
+	if zw.level != zstd.SpeedBetterCompression || zw.workers != 1 {
+		t.Fatalf("level %s with %d workers, want better with 1", zw.level, zw.workers)
+	}
+	for _, d := range zw.Decisions()[1:] {
+		if d.Reason != "output is the slowest stage" || d.CPU < 0.29 || d.CPU > 0.31 {
+			t.Fatalf("decision %+v", d)
+		}
+	}
+}
+
+func TestSlowCompressionAddsWorkers(t *testing.T) {
+	c := &clock{tick: time.Second}
+	out := &slowWriter{clock: c}
+	zw := newTestWriter(out, c, 0.3, 3)
+	zw.Write(input(6))
+	zw.Close()
+
+	if zw.workers != tuning.MaxWorkers || zw.level != zstd.SpeedDefault {
+		t.Fatalf("level %s with %d workers, want default with %d", zw.level, zw.workers, tuning.MaxWorkers)
+	}
+}
+
+func TestEmptyStream(t *testing.T) {
+	var out bytes.Buffer
+	zw := NewWriter(&out, 3, tuning)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() == 0 || len(decode(t, out.Bytes())) != 0 {
+		t.Fatal("an empty stream must still be a valid zstd frame")
+	}
+}
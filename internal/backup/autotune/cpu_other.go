@@ -0,0 +1,11 @@
+//go:build !linux
+
+package autotune
+
+import "errors"
+
+// cpuTimes is only implemented on Linux; elsewhere tuning relies on where
+// the writer spends its time
+func cpuTimes() (busy, total uint64, err error) {
+	return 0, 0, errors.New("CPU utilisation is not available on this platform")
+}
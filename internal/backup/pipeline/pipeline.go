@@ -0,0 +1,256 @@
+// Package pipeline runs backup jobs through a fixed sequence of stages
+// (dump, compress, encrypt, upload, index), each served by its own pool of
+// workers. Stages are connected by queues no longer than their worker
+// count, so a slow stage holds back the stages before it instead of
+// letting finished dumps pile up on disk or in memory. Jobs are admitted
+// round-robin across keys, typically the database server, with a cap on
+// the jobs in flight per key: hundreds of small databases on one server
+// cannot starve the others, or overload that server with parallel dumps.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Stage names of a backup, in order
+const (
+	StageDump     = "dump"
+	StageCompress = "compress"
+	StageEncrypt  = "encrypt"
+	StageUpload   = "upload"
+	StageIndex    = "index"
+)
+
+// StageOrder lists the backup stages in the order they run
+var StageOrder = []string{StageDump, StageCompress, StageEncrypt, StageUpload, StageIndex}
+
+// Workers returns the configured worker count of each backup stage
+func Workers(cfg config.PipelineConfig) map[string]int {
+	return map[string]int{
+		StageDump:     cfg.DumpWorkers,
+		StageCompress: cfg.CompressWorkers,
+		StageEncrypt:  cfg.EncryptWorkers,
+		StageUpload:   cfg.UploadWorkers,
+		StageIndex:    cfg.IndexWorkers,
+	}
+}
+
+// Stage processes one job and hands the result to the next stage
+type Stage[T any] struct {
+	Name    string
+	Workers int
+	Run     func(ctx context.Context, job T) (T, error)
+}
+
+// Job is a unit of work. Key groups jobs for fair scheduling, e.g. the
+// host of the database.
+type Job[T any] struct {
+	Key   string
+	Value T
+}
+
+// Result reports a job that left the pipeline, finished or failed
+type Result[T any] struct {
+	Key   string
+	Value T
+	// Stage is the stage that failed, empty when the job never started
+	Stage string
+	Err   error
+	// Durations holds the time spent in each stage the job ran
+	Durations map[string]time.Duration
+	// Queued is the time between Run and the job's admission
+	Queued time.Duration
+}
+
+// Options tunes admission
+type Options struct {
+	// MaxPerKey caps the jobs of one key in flight across all stages;
+	// 0 means no cap
+	MaxPerKey int
+	// KeyLimits overrides MaxPerKey for some keys
+	KeyLimits map[string]int
+}
+
+// limit returns the in-flight cap of key, 0 meaning none
+func (o Options) limit(key string) int {
+	if n, ok := o.KeyLimits[key]; ok {
+		return n
+	}
+	return o.MaxPerKey
+}
+
+// Pipeline runs jobs through its stages
+type Pipeline[T any] struct {
+	stages  []Stage[T]
+	options Options
+}
+
+// New creates a pipeline. Stages with fewer than one worker get one.
+func New[T any](opts Options, stages ...Stage[T]) (*Pipeline[T], error) {
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("pipeline needs at least one stage")
+	}
+	for i := range stages {
+		if stages[i].Run == nil {
+			return nil, fmt.Errorf("stage %q has no Run function", stages[i].Name)
+		}
+		stages[i].Workers = max(stages[i].Workers, 1)
+	}
+	return &Pipeline[T]{stages: stages, options: opts}, nil
+}
+
+// item is a job travelling through the stages
+type item[T any] struct {
+	key       string
+	value     T
+	queued    time.Duration
+	durations map[string]time.Duration
+}
+
+// Run processes jobs and calls onResult once per job, from one goroutine
+// at a time, as each job leaves the pipeline. It returns when every job
+// has been reported. When ctx is cancelled, jobs not yet admitted are
+// reported with ctx's error and running jobs are left to stop on their
+// own context.
+func (p *Pipeline[T]) Run(ctx context.Context, jobs []Job[T], onResult func(Result[T])) {
+	if len(jobs) == 0 {
+		return
+	}
+	start := time.Now()
+
+	var resultMu sync.Mutex
+	emit := func(it *item[T], stage string, err error) {
+		resultMu.Lock()
+		defer resultMu.Unlock()
+		onResult(Result[T]{
+			Key:       it.key,
+			Value:     it.value,
+			Stage:     stage,
+			Err:       err,
+			Durations: it.durations,
+			Queued:    it.queued,
+		})
+	}
+	// report emits the result of an admitted job and frees its slot
+	finished := make(chan string, len(jobs))
+	report := func(it *item[T], stage string, err error) {
+		emit(it, stage, err)
+		finished <- it.key
+	}
+
+	// One queue in front of every stage, as long as its worker count
+	queues := make([]chan *item[T], len(p.stages))
+	for i, s := range p.stages {
+		queues[i] = make(chan *item[T], s.Workers)
+	}
+	var workers sync.WaitGroup
+	for i, s := range p.stages {
+		for w := 0; w < s.Workers; w++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for it := range queues[i] {
+					if err := ctx.Err(); err != nil {
+						report(it, s.Name, err)
+						continue
+					}
+					began := time.Now()
+					value, err := s.Run(ctx, it.value)
+					it.durations[s.Name] = time.Since(began)
+					it.value = value
+					switch {
+					case err != nil:
+						report(it, s.Name, err)
+					case i == len(p.stages)-1:
+						report(it, "", nil)
+					default:
+						// Blocks while the next stage is busy: backpressure
+						queues[i+1] <- it
+					}
+				}
+			}()
+		}
+	}
+
+	p.admit(ctx, jobs, start, queues[0], finished, emit)
+
+	for _, q := range queues {
+		close(q)
+	}
+	workers.Wait()
+}
+
+// admit feeds the first stage, picking keys round-robin and holding back
+// keys at their in-flight cap, until every job has finished. Jobs left
+// when ctx is cancelled are emitted as failed without being admitted.
+func (p *Pipeline[T]) admit(ctx context.Context, jobs []Job[T], start time.Time, first chan<- *item[T],
+	finished <-chan string, emit func(*item[T], string, error)) {
+
+	var keys []string
+	pending := make(map[string][]Job[T])
+	for _, j := range jobs {
+		if _, ok := pending[j.Key]; !ok {
+			keys = append(keys, j.Key)
+		}
+		pending[j.Key] = append(pending[j.Key], j)
+	}
+	inFlight := make(map[string]int)
+	remaining := len(jobs)
+	next := 0
+
+	// pick returns the index in keys of the next key with an admissible job
+	pick := func() (int, bool) {
+		for n := 0; n < len(keys); n++ {
+			i := (next + n) % len(keys)
+			k := keys[i]
+			if limit := p.options.limit(k); len(pending[k]) > 0 && (limit <= 0 || inFlight[k] < limit) {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+
+	done := ctx.Done()
+	for remaining > 0 {
+		if err := ctx.Err(); err != nil && done != nil {
+			done = nil
+			for _, k := range keys {
+				for _, j := range pending[k] {
+					remaining--
+					emit(&item[T]{key: j.Key, value: j.Value, queued: time.Since(start), durations: map[string]time.Duration{}}, "", err)
+				}
+				pending[k] = nil
+			}
+			// Every job may have been reported
+			continue
+		}
+
+		// A nil channel never receives, so with nothing to admit the select
+		// only waits for a job to finish
+		var send chan<- *item[T]
+		var it *item[T]
+		i, ok := pick()
+		if ok {
+			j := pending[keys[i]][0]
+			send = first
+			it = &item[T]{key: j.Key, value: j.Value, queued: time.Since(start), durations: make(map[string]time.Duration)}
+		}
+
+		select {
+		case send <- it:
+			k := keys[i]
+			pending[k] = pending[k][1:]
+			inFlight[k]++
+			next = i + 1
+		case k := <-finished:
+			inFlight[k]--
+			remaining--
+		case <-done:
+		}
+	}
+}
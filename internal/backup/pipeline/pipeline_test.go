@@ -0,0 +1,235 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type job struct {
+	host, name string
+	steps      []string
+}
+
+func stage(name string, workers int, delay time.Duration, hook func(job) error) Stage[job] {
+	return Stage[job]{
+		Name:    name,
+		Workers: workers,
+		Run: func(ctx context.Context, j job) (job, error) {
+			if hook != nil {
+				if err := hook(j); err != nil {
+					return j, err
+				}
+			}
+			time.Sleep(delay)
+			j.steps = append(j.steps, name)
+			return j, nil
+		},
+	}
+}
+
+func TestRunsEveryJobThroughEveryStage(t *testing.T) {
+	p, err := New(Options{},
+		stage(StageDump, 3, time.Millisecond, nil),
+		stage(StageCompress, 2, time.Millisecond, nil),
+		stage(StageUpload, 2, time.Millisecond, nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jobs []Job[job]
+	for i := 0; i < 50; i++ {
+		jobs = append(jobs, Job[job]{Key: "db1", Value: job{name: fmt.Sprint(i)}})
+	}
+	seen := map[string]bool{}
+	p.Run(context.Background(), jobs, func(r Result[job]) {
+		if r.Err != nil {
+			t.Errorf("job %s failed in %s: %v", r.Value.name, r.Stage, r.Err)
+		}
+		if fmt.Sprint(r.Value.steps) != "[dump compress upload]" {
+			t.Errorf("job %s ran %v", r.Value.name, r.Value.steps)
+		}
+		if len(r.Durations) != 3 {
+			t.Errorf("durations = %v", r.Durations)
+		}
+		seen[r.Value.name] = true
+	})
+	if len(seen) != 50 {
+		t.Fatalf("%d jobs reported, want 50", len(seen))
+	}
+}
+
+func TestFairAcrossKeysWithCap(t *testing.T) {
+	var mu sync.Mutex
+	active := map[string]int{}
+	var order []string
+	var overCap atomic.Bool
+
+	dump := stage(StageDump, 4, 5*time.Millisecond, func(j job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, j.host)
+		return nil
+	})
+	track := Stage[job]{Name: "track", Workers: 4, Run: func(ctx context.Context, j job) (job, error) { return j, nil }}
+	// Count in flight from admission to the end of the last stage
+	first := dump.Run
+	dump.Run = func(ctx context.Context, j job) (job, error) {
+		mu.Lock()
+		active[j.host]++
+		if active[j.host] > 1 {
+			overCap.Store(true)
+		}
+		mu.Unlock()
+		return first(ctx, j)
+	}
+
+	p, _ := New(Options{MaxPerKey: 1}, dump, track)
+	var jobs []Job[job]
+	for i := 0; i < 20; i++ {
+		jobs = append(jobs, Job[job]{Key: "big", Value: job{host: "big"}})
+	}
+	jobs = append(jobs, Job[job]{Key: "small", Value: job{host: "small"}})
+
+	p.Run(context.Background(), jobs, func(r Result[job]) {
+		mu.Lock()
+		active[r.Value.host]--
+		mu.Unlock()
+	})
+
+	if overCap.Load() {
+		t.Fatal("more than one job of a key was in flight")
+	}
+	for i, h := range order {
+		if h == "small" {
+			if i > 1 {
+				t.Fatalf("the small host ran at position %d behind the big one", i)
+			}
+			return
+		}
+	}
+	t.Fatal("the small host never ran")
+}
+
+func TestKeyLimitsOverrideCap(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := map[string]int{}, map[string]int{}
+	run := func(ctx context.Context, j job) (job, error) {
+		mu.Lock()
+		active[j.host]++
+		peak[j.host] = max(peak[j.host], active[j.host])
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		active[j.host]--
+		mu.Unlock()
+		return j, nil
+	}
+	p, _ := New(Options{MaxPerKey: 1, KeyLimits: map[string]int{"wide": 3}}, Stage[job]{Name: StageDump, Workers: 6, Run: run})
+	var jobs []Job[job]
+	for i := 0; i < 12; i++ {
+		jobs = append(jobs, Job[job]{Key: "wide", Value: job{host: "wide"}}, Job[job]{Key: "narrow", Value: job{host: "narrow"}})
+	}
+	p.Run(context.Background(), jobs, func(Result[job]) {})
+
+	if peak["narrow"] != 1 || peak["wide"] != 3 {
+		t.Fatalf("peak in flight = %v, want narrow 1 and wide 3", peak)
+	}
+}
+
+func TestFailureStopsJob(t *testing.T) {
+	p, _ := New(Options{},
+		stage(StageDump, 2, 0, nil),
+		stage(StageEncrypt, 2, 0, func(j job) error {
+			if j.name == "bad" {
+				return errors.New("no key")
+			}
+			return nil
+		}),
+		stage(StageUpload, 2, 0, nil),
+	)
+	jobs := []Job[job]{{Key: "a", Value: job{name: "good"}}, {Key: "a", Value: job{name: "bad"}}}
+	p.Run(context.Background(), jobs, func(r Result[job]) {
+		switch r.Value.name {
+		case "bad":
+			if r.Stage != StageEncrypt || r.Err == nil || len(r.Value.steps) != 1 {
+				t.Errorf("bad job: stage %q, err %v, steps %v", r.Stage, r.Err, r.Value.steps)
+			}
+		case "good":
+			if r.Err != nil {
+				t.Errorf("good job failed: %v", r.Err)
+			}
+		}
+	})
+}
+
+func TestCancelReportsPendingJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p, _ := New(Options{MaxPerKey: 1}, Stage[job]{Name: StageDump, Workers: 1, Run: func(ctx context.Context, j job) (job, error) {
+		cancel()
+		<-ctx.Done()
+		return j, ctx.Err()
+	}})
+	var jobs []Job[job]
+	for i := 0; i < 5; i++ {
+		jobs = append(jobs, Job[job]{Key: "a", Value: job{name: fmt.Sprint(i)}})
+	}
+
+	var reported, notStarted int
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx, jobs, func(r Result[job]) {
+			reported++
+			if !errors.Is(r.Err, context.Canceled) {
+				t.Errorf("job %s: %v", r.Value.name, r.Err)
+			}
+			if r.Stage == "" {
+				notStarted++
+			}
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not return after cancellation")
+	}
+	if reported != 5 || notStarted != 4 {
+		t.Fatalf("reported %d, %d not started; want 5 and 4", reported, notStarted)
+	}
+}
+
+func TestCancelledBeforeRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, _ := New(Options{}, stage(StageDump, 1, 0, nil))
+
+	var reported int
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx, []Job[job]{{Key: "a"}, {Key: "b"}}, func(r Result[job]) { reported++ })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not return")
+	}
+	if reported != 2 {
+		t.Fatalf("reported %d jobs, want 2", reported)
+	}
+}
+
+func TestNewValidates(t *testing.T) {
+	if _, err := New[job](Options{}); err == nil {
+		t.Fatal("accepted a pipeline without stages")
+	}
+	if _, err := New(Options{}, Stage[job]{Name: StageDump}); err == nil {
+		t.Fatal("accepted a stage without Run")
+	}
+}
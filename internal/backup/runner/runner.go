@@ -0,0 +1,585 @@
+// Package runner takes backups on the staged pipeline. A backup streams
+// from the database dump through compression and encryption into its
+// artifact, which is uploaded to storage in parts as it is written: each
+// stage runs in a goroutine of its own and hands its output to the next
+// through a pipe, so the stages of one backup overlap instead of writing
+// intermediate files. A backup holds a slot of every stage it is
+// streaming through, the workers configured for the stage, so a stage
+// caps the backups it runs at once and a backup waiting for a slot holds
+// back the stages before it. Backups are admitted round-robin across
+// database servers, with backup.pipeline.max_per_host in flight per server.
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/backup/autotune"
+	"github.com/sanskarpan/db-backup/internal/backup/pipeline"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/storage/multipart"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// defaultZstdLevel is the level auto-tuned zstd starts at when the job
+// sets none, zstd's own default
+const defaultZstdLevel = 3
+
+// Job is a backup to take
+type Job struct {
+	// Key groups jobs for fair scheduling; empty means the database host
+	Key        string
+	Connection *database.ConnectionConfig
+	Options    database.BackupOptions
+	Name       string // generated when empty
+	Tags       map[string]string
+
+	Compression      string // gzip, zstd, lz4 or none
+	CompressionLevel int    // 1-9, 0 for the codec's default
+
+	// EncryptionKey is the master key the artifact is encrypted with, nil
+	// to leave it unencrypted
+	EncryptionKey []byte
+	Cipher        string // aes-256-gcm (default) or chacha20-poly1305
+}
+
+// Backup describes the artifact of a job
+type Backup struct {
+	ID             string
+	Name           string
+	Database       string
+	DatabaseType   database.DatabaseType
+	Path           string // of the artifact
+	StorageKey     string // the artifact was uploaded to, empty when kept local only
+	Size           int64  // of the dump
+	CompressedSize int64  // of the artifact
+	Checksum       string // hex SHA-256 of the artifact
+	Tables         []string
+	StartTime      time.Time
+	EndTime        time.Time
+	Tags           map[string]string // the job's, and what the stages recorded
+}
+
+// Result reports a job that left the pipeline
+type Result struct {
+	Job    *Job
+	Backup *Backup
+	// Stage is the stage that failed, empty when the job succeeded or
+	// never started
+	Stage string
+	Err   error
+	// Durations holds the time each stage the job ran took
+	Durations map[string]time.Duration
+}
+
+// Options configures a runner
+type Options struct {
+	TempDirectory string // artifacts are written here
+	Pipeline      config.PipelineConfig
+	// Upload uploads the artifacts while they are written, in parts, to a
+	// storage provider under KeyPrefix; nil keeps them local only
+	Upload    *multipart.Uploader
+	KeyPrefix string
+	// AutoTune tunes zstd compression to the machine as the backup runs;
+	// the settings it ends on are recorded in the backup's tags
+	AutoTune config.AutoTuneConfig
+	// OnStage is called as a backup enters each stage it runs
+	OnStage func(job *Job, stage string)
+	// Index records a finished backup, e.g. in the metadata repository
+	Index func(ctx context.Context, b *Backup) error
+}
+
+// Runner takes backups
+type Runner struct {
+	options  Options
+	slots    map[string]chan struct{}
+	pipeline *pipeline.Pipeline[*task]
+}
+
+// New creates a runner
+func New(opts Options) (*Runner, error) {
+	r := &Runner{options: opts, slots: make(map[string]chan struct{})}
+	workers := pipeline.Workers(opts.Pipeline)
+	for _, name := range pipeline.StageOrder {
+		r.slots[name] = make(chan struct{}, max(workers[name], 1))
+	}
+	p, err := pipeline.New(pipeline.Options{MaxPerKey: opts.Pipeline.MaxPerHost},
+		pipeline.Stage[*task]{Name: pipeline.StageDump, Workers: workers[pipeline.StageDump], Run: r.dump},
+		pipeline.Stage[*task]{Name: pipeline.StageCompress, Workers: workers[pipeline.StageCompress], Run: r.compress},
+		pipeline.Stage[*task]{Name: pipeline.StageEncrypt, Workers: workers[pipeline.StageEncrypt], Run: r.encrypt},
+		pipeline.Stage[*task]{Name: pipeline.StageUpload, Workers: workers[pipeline.StageUpload], Run: r.upload},
+		pipeline.Stage[*task]{Name: pipeline.StageIndex, Workers: workers[pipeline.StageIndex], Run: r.index},
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.pipeline = p
+	return r, nil
+}
+
+// task is a job travelling through the stages. Its context is cancelled
+// when the job fails, which stops the stages still streaming.
+type task struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	job    *Job
+	backup *Backup
+
+	out      io.Reader        // output of the last stage started
+	pipes    []*io.PipeReader // between the stages started
+	segments []*segment       // stages streaming in the background
+	dumped   int64            // bytes of the dump, once it finished
+	took     map[string]time.Duration
+
+	mu     sync.Mutex
+	failed *stageError // the first stage to fail
+}
+
+// segment is a stage streaming in the background
+type segment struct {
+	stage string
+	took  time.Duration
+	done  chan error
+}
+
+// stageError attributes the failure of a streaming stage
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.stage + ": " + e.err.Error() }
+
+func (e *stageError) Unwrap() error { return e.err }
+
+// Run takes the backups of jobs and calls onResult once per job, from one
+// goroutine at a time, as each finishes. The artifacts of failed jobs are
+// removed.
+func (r *Runner) Run(ctx context.Context, jobs []*Job, onResult func(Result)) {
+	items := make([]pipeline.Job[*task], len(jobs))
+	for i, j := range jobs {
+		t := &task{job: j, took: make(map[string]time.Duration)}
+		t.ctx, t.cancel = context.WithCancel(ctx)
+		t.backup = newBackup(j)
+		key := j.Key
+		if key == "" && j.Connection != nil {
+			key = j.Connection.Host
+		}
+		items[i] = pipeline.Job[*task]{Key: key, Value: t}
+	}
+
+	r.pipeline.Run(ctx, items, func(res pipeline.Result[*task]) {
+		t := res.Value
+		out := Result{Job: t.job, Backup: t.backup, Stage: res.Stage, Err: res.Err, Durations: res.Durations}
+		if res.Err != nil {
+			t.abort(res.Err)
+			var se *stageError
+			if errors.As(res.Err, &se) {
+				out.Stage, out.Err = se.stage, se.err
+			}
+		}
+		t.cancel()
+		// The streaming stages returned as soon as they started
+		for stage, d := range t.took {
+			out.Durations[stage] = d
+		}
+		onResult(out)
+	})
+}
+
+// Backup takes the backup of one job
+func (r *Runner) Backup(ctx context.Context, job *Job) (*Backup, error) {
+	var res Result
+	r.Run(ctx, []*Job{job}, func(r Result) { res = r })
+	if res.Err != nil {
+		if res.Stage != "" {
+			return nil, fmt.Errorf("%s failed: %w", res.Stage, res.Err)
+		}
+		return nil, res.Err
+	}
+	return res.Backup, nil
+}
+
+// newBackup describes the artifact job is about to produce
+func newBackup(j *Job) *Backup {
+	b := &Backup{
+		ID:       utils.GenerateBackupID(),
+		Name:     j.Name,
+		Database: j.Options.Database,
+		Tags:     make(map[string]string, len(j.Tags)),
+	}
+	if j.Connection != nil {
+		b.DatabaseType = j.Connection.Type
+	}
+	for k, v := range j.Tags {
+		b.Tags[k] = v
+	}
+	return b
+}
+
+// acquire takes a slot of stage
+func (r *Runner) acquire(ctx context.Context, stage string) error {
+	select {
+	case r.slots[stage] <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) release(stage string) {
+	<-r.slots[stage]
+}
+
+func (r *Runner) enter(t *task, stage string) {
+	if r.options.OnStage != nil {
+		r.options.OnStage(t.job, stage)
+	}
+}
+
+// stream starts stage in the background: run writes its output to the
+// pipe the next stage reads. The stage's slot is held until run returns.
+func (r *Runner) stream(t *task, stage string, run func(w io.Writer) error) {
+	in, _ := t.out.(*io.PipeReader)
+	pr, pw := io.Pipe()
+	seg := &segment{stage: stage, done: make(chan error, 1)}
+	started := time.Now()
+	go func() {
+		defer r.release(stage)
+		err := run(pw)
+		if err != nil {
+			t.fail(stage, err)
+			if in != nil {
+				// Unblock the stage writing to this one
+				in.CloseWithError(err)
+			}
+		}
+		pw.CloseWithError(err)
+		seg.took = time.Since(started)
+		seg.done <- err
+	}()
+	t.out = pr
+	t.pipes = append(t.pipes, pr)
+	t.segments = append(t.segments, seg)
+}
+
+// fail records the failure of stage unless another stage failed first:
+// a failure closes the pipes around the stage, so the stages next to it
+// fail too, echoing it
+func (t *task) fail(stage string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failed == nil {
+		t.failed = &stageError{stage: stage, err: err}
+	}
+}
+
+// wait waits for every stage streaming in the background and returns the
+// first failure
+func (t *task) wait() error {
+	for _, seg := range t.segments {
+		<-seg.done
+		t.took[seg.stage] = seg.took
+	}
+	t.segments = nil
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failed == nil {
+		return nil
+	}
+	return t.failed
+}
+
+// abort stops the stages still streaming
+func (t *task) abort(err error) {
+	t.cancel()
+	for _, p := range t.pipes {
+		p.CloseWithError(err)
+	}
+	t.wait()
+}
+
+// dump connects to the database and starts streaming the dump
+func (r *Runner) dump(_ context.Context, t *task) (*task, error) {
+	j := t.job
+	if j.Connection == nil {
+		return t, fmt.Errorf("no database connection")
+	}
+	if err := r.acquire(t.ctx, pipeline.StageDump); err != nil {
+		return t, err
+	}
+	r.enter(t, pipeline.StageDump)
+
+	driver, err := database.CreateDriver(j.Connection.Type)
+	if err == nil {
+		err = driver.Connect(t.ctx, j.Connection)
+	}
+	if err != nil {
+		r.release(pipeline.StageDump)
+		return t, err
+	}
+	t.backup.StartTime = time.Now()
+	if t.backup.Name == "" {
+		t.backup.Name = fmt.Sprintf("%s-%s", j.Options.Database, t.backup.StartTime.Format("20060102-150405"))
+	}
+	t.backup.Tables = tables(t.ctx, driver, &j.Options)
+
+	opts := j.Options
+	r.stream(t, pipeline.StageDump, func(w io.Writer) error {
+		defer driver.Disconnect()
+		cw := &countingWriter{w: w}
+		err := driver.StreamBackup(t.ctx, &opts, cw)
+		t.dumped = cw.n
+		return err
+	})
+	return t, nil
+}
+
+// tables lists the tables a dump holds, best effort: the ones selected,
+// or else those of the database
+func tables(ctx context.Context, driver database.Driver, opts *database.BackupOptions) []string {
+	if len(opts.Tables) > 0 {
+		out := make([]string, 0, len(opts.Tables))
+		for _, t := range opts.Tables {
+			if !utils.Contains(opts.ExcludeTables, t) {
+				out = append(out, t)
+			}
+		}
+		return out
+	}
+	if opts.Database == "" || opts.AllDatabases || len(opts.Databases) > 0 {
+		return nil
+	}
+	all, err := driver.GetTables(ctx, opts.Database)
+	if err != nil {
+		return nil
+	}
+	out := all[:0]
+	for _, t := range all {
+		if !utils.Contains(opts.ExcludeTables, t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// compress starts compressing the dump
+func (r *Runner) compress(_ context.Context, t *task) (*task, error) {
+	j := t.job
+	if j.Compression == "" || j.Compression == "none" {
+		return t, nil
+	}
+	if err := r.acquire(t.ctx, pipeline.StageCompress); err != nil {
+		return t, err
+	}
+	r.enter(t, pipeline.StageCompress)
+	in := t.out
+	r.stream(t, pipeline.StageCompress, func(w io.Writer) error {
+		if j.Compression == "zstd" && r.options.AutoTune.Enabled {
+			return r.autotune(t, w, in)
+		}
+		zw, err := stream.Compress(w, j.Compression, j.CompressionLevel)
+		if err != nil {
+			return err
+		}
+		if _, err := stream.Copy(t.ctx, zw, in); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	})
+	return t, nil
+}
+
+// autotune compresses the dump with zstd, tuning the level and encoder
+// workers as it goes, and records what it settled on in the backup's tags
+func (r *Runner) autotune(t *task, w io.Writer, in io.Reader) error {
+	level := t.job.CompressionLevel
+	if level == 0 {
+		level = defaultZstdLevel
+	}
+	zw := autotune.NewWriter(w, level, r.options.AutoTune)
+	if _, err := stream.Copy(t.ctx, zw, in); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	for k, v := range zw.Metadata() {
+		t.backup.Tags[k] = v
+	}
+	return nil
+}
+
+// encrypt starts encrypting the compressed dump in authenticated chunks
+func (r *Runner) encrypt(_ context.Context, t *task) (*task, error) {
+	j := t.job
+	if j.EncryptionKey == nil {
+		return t, nil
+	}
+	if err := r.acquire(t.ctx, pipeline.StageEncrypt); err != nil {
+		return t, err
+	}
+	r.enter(t, pipeline.StageEncrypt)
+	cipherName := j.Cipher
+	if cipherName == "" {
+		cipherName = stream.CipherAES256GCM
+	}
+
+	in := t.out
+	r.stream(t, pipeline.StageEncrypt, func(w io.Writer) error {
+		ew, err := stream.NewEncryptWriter(w, j.EncryptionKey, cipherName, 0)
+		if err != nil {
+			return err
+		}
+		if _, err := stream.Copy(t.ctx, ew, in); err != nil {
+			return err
+		}
+		return ew.Close()
+	})
+	return t, nil
+}
+
+// upload writes the artifact, uploading it to storage at the same time,
+// and waits for the stages streaming into it
+func (r *Runner) upload(_ context.Context, t *task) (*task, error) {
+	if err := r.acquire(t.ctx, pipeline.StageUpload); err != nil {
+		return t, err
+	}
+	defer r.release(pipeline.StageUpload)
+	r.enter(t, pipeline.StageUpload)
+
+	name := t.backup.ID + artifactExt(t.job)
+	path := filepath.Join(r.options.TempDirectory, name)
+	partial := path + ".partial"
+	var upload *uploadWriter
+	if r.options.Upload != nil {
+		key := r.options.KeyPrefix + name
+		s, err := r.options.Upload.Stream(t.ctx, key)
+		if err != nil {
+			return t, err
+		}
+		upload, t.backup.StorageKey = &uploadWriter{s: s}, key
+	}
+
+	if err := r.write(t, partial, upload); err != nil {
+		t.fail(pipeline.StageUpload, err)
+		if pr, ok := t.out.(*io.PipeReader); ok {
+			// Unblock the stages writing to the artifact
+			pr.CloseWithError(err)
+		}
+	}
+	err := t.wait()
+	if err == nil && upload != nil && upload.err == nil {
+		_, upload.err = upload.s.Complete(t.ctx)
+	}
+	if err == nil {
+		err = os.Rename(partial, path)
+	}
+	if err == nil && upload != nil && upload.err != nil {
+		// Upload the parts that failed while streaming from the artifact
+		_, err = upload.s.Resume(t.ctx, path)
+	}
+	if err != nil {
+		if upload != nil {
+			upload.s.Abort(context.WithoutCancel(t.ctx))
+		}
+		os.Remove(partial)
+		os.Remove(path)
+		return t, err
+	}
+	t.backup.Path = path
+	t.backup.Size = t.dumped
+	t.backup.EndTime = time.Now()
+	return t, nil
+}
+
+// write copies the output of the last stage to the file at path, and to
+// upload unless nil, hashing it on the way
+func (r *Runner) write(t *task, path string, upload *uploadWriter) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600) // #nosec G304 -- artifact in the temp directory
+	if err != nil {
+		return err
+	}
+	var w io.Writer = f
+	if upload != nil {
+		w = io.MultiWriter(f, upload)
+	}
+	hw := stream.NewHashWriter(w)
+	if _, err := stream.Copy(t.ctx, hw, t.out); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	t.backup.CompressedSize = hw.Written()
+	t.backup.Checksum = hw.Sum()
+	return nil
+}
+
+// index records the finished backup
+func (r *Runner) index(ctx context.Context, t *task) (*task, error) {
+	if r.options.Index == nil {
+		return t, nil
+	}
+	r.enter(t, pipeline.StageIndex)
+	if err := r.options.Index(ctx, t.backup); err != nil {
+		os.Remove(t.backup.Path)
+		return t, err
+	}
+	return t, nil
+}
+
+// artifactExt returns the file extension of the artifact of j
+func artifactExt(j *Job) string {
+	ext := ".dump"
+	switch j.Compression {
+	case "gzip":
+		ext += ".gz"
+	case "zstd":
+		ext += ".zst"
+	case "lz4":
+		ext += ".lz4"
+	}
+	if j.EncryptionKey != nil {
+		ext += ".enc"
+	}
+	return ext
+}
+
+// uploadWriter streams the artifact to an upload. Once a part fails it
+// drops what follows, keeping the error, so the artifact is still written
+// in full and the upload can resume from it.
+type uploadWriter struct {
+	s   *multipart.Stream
+	err error
+}
+
+func (w *uploadWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.s.Write(p)
+	}
+	return len(p), nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,397 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/backup/pipeline"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/storage/multipart"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// fakeDriver dumps the database name repeated to size bytes, failing
+// after failAfter bytes when set
+type fakeDriver struct {
+	database.Driver
+	size      int
+	failAfter int
+}
+
+func (d *fakeDriver) Connect(ctx context.Context, cfg *database.ConnectionConfig) error {
+	if cfg.Host == "unreachable" {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (d *fakeDriver) Disconnect() error { return nil }
+
+func (d *fakeDriver) GetTables(ctx context.Context, db string) ([]string, error) {
+	return []string{"orders", "customers", "audit"}, nil
+}
+
+func (d *fakeDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, w io.Writer) error {
+	dump := dumpOf(opts.Database, d.size)
+	if d.failAfter > 0 {
+		w.Write(dump[:d.failAfter])
+		return errors.New("pg_dump: lost connection")
+	}
+	_, err := w.Write(dump)
+	return err
+}
+
+func dumpOf(db string, size int) []byte {
+	return bytes.Repeat([]byte(db+";"), size/(len(db)+1)+1)[:size]
+}
+
+var registerOnce sync.Once
+
+func register() {
+	registerOnce.Do(func() {
+		database.RegisterDriver("fake", func() database.Driver { return &fakeDriver{size: 3 << 20} })
+		database.RegisterDriver("fake-failing", func() database.Driver { return &fakeDriver{size: 3 << 20, failAfter: 1 << 20} })
+	})
+}
+
+func job(host, db string, typ database.DatabaseType) *Job {
+	return &Job{
+		Connection:  &database.ConnectionConfig{Type: typ, Host: host},
+		Options:     database.BackupOptions{Database: db},
+		Compression: "zstd",
+	}
+}
+
+// readArtifact decrypts and decompresses the artifact of b
+func readArtifact(t *testing.T, b *Backup, key []byte) []byte {
+	t.Helper()
+	f, err := os.Open(b.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	plain, encrypted, err := stream.Decrypt(f, func() ([]byte, error) { return key, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted != (key != nil) {
+		t.Errorf("%s encrypted = %v", b.ID, encrypted)
+	}
+	r, _, err := stream.Decompress(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRunStreamsThroughEveryStage(t *testing.T) {
+	register()
+	dir := t.TempDir()
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	var mu sync.Mutex
+	entered := map[string][]string{}
+	indexed := map[string]bool{}
+	r, err := New(Options{
+		TempDirectory: dir,
+		// One worker a stage: the backups queue up behind each other
+		Pipeline: config.PipelineConfig{DumpWorkers: 1, CompressWorkers: 1, EncryptWorkers: 1, UploadWorkers: 1, IndexWorkers: 1, MaxPerHost: 1},
+		OnStage: func(j *Job, stage string) {
+			mu.Lock()
+			defer mu.Unlock()
+			entered[j.Options.Database] = append(entered[j.Options.Database], stage)
+		},
+		Index: func(ctx context.Context, b *Backup) error {
+			indexed[b.ID] = true
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jobs []*Job
+	for i := 0; i < 6; i++ {
+		j := job(fmt.Sprintf("db-%d", i%2), fmt.Sprintf("shop%d", i), "fake")
+		if i%2 == 0 {
+			j.EncryptionKey = key
+			j.Compression = "gzip"
+		}
+		jobs = append(jobs, j)
+	}
+
+	var results []Result
+	r.Run(context.Background(), jobs, func(res Result) { results = append(results, res) })
+	if len(results) != len(jobs) {
+		t.Fatalf("%d results for %d jobs", len(results), len(jobs))
+	}
+	for _, res := range results {
+		b, db := res.Backup, res.Job.Options.Database
+		if res.Err != nil {
+			t.Errorf("%s failed in %s: %v", db, res.Stage, res.Err)
+			continue
+		}
+		var k []byte
+		if res.Job.EncryptionKey != nil {
+			k = key
+		}
+		if data := readArtifact(t, b, k); !bytes.Equal(data, dumpOf(db, 3<<20)) {
+			t.Errorf("%s: artifact holds %d bytes", db, len(data))
+		}
+		sum, size, err := stream.HashFile(context.Background(), b.Path)
+		if err != nil || sum != b.Checksum || size != b.CompressedSize {
+			t.Errorf("%s: artifact %s of %d bytes, recorded %s of %d: %v", db, sum, size, b.Checksum, b.CompressedSize, err)
+		}
+		if b.Size != 3<<20 || b.CompressedSize >= b.Size || len(b.Tables) != 3 || !indexed[b.ID] {
+			t.Errorf("%s: %+v", db, b)
+		}
+		want := "[dump compress upload index]"
+		if res.Job.EncryptionKey != nil {
+			want = "[dump compress encrypt upload index]"
+			if !strings.HasSuffix(b.Path, ".dump.gz.enc") {
+				t.Errorf("%s: artifact %s", db, b.Path)
+			}
+		}
+		if got := fmt.Sprint(entered[db]); got != want {
+			t.Errorf("%s entered %s, want %s", db, got, want)
+		}
+		if res.Durations[pipeline.StageDump] <= 0 || res.Durations[pipeline.StageUpload] <= 0 {
+			t.Errorf("%s durations = %v", db, res.Durations)
+		}
+	}
+}
+
+func TestRunCleansUpFailures(t *testing.T) {
+	register()
+	dir := t.TempDir()
+	r, err := New(Options{
+		TempDirectory: dir,
+		Index: func(ctx context.Context, b *Backup) error {
+			if b.Database == "readonly" {
+				return errors.New("metadata directory is read-only")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := []*Job{
+		job("db-1", "broken", "fake-failing"),
+		job("unreachable", "shop", "fake"),
+		job("db-1", "readonly", "fake"),
+		job("db-1", "shop", "fake"),
+		job("db-1", "badcodec", "fake"),
+	}
+	jobs[1].Compression = "none"
+	jobs[4].Compression = "brotli"
+	failures := map[string]string{}
+	var ok *Backup
+	r.Run(context.Background(), jobs, func(res Result) {
+		if res.Err != nil {
+			failures[res.Job.Options.Database+"@"+res.Job.Connection.Host] = res.Stage + ": " + res.Err.Error()
+		} else {
+			ok = res.Backup
+		}
+	})
+
+	want := map[string]string{
+		"broken@db-1":      "dump: pg_dump: lost connection",
+		"shop@unreachable": "dump: connection refused",
+		"readonly@db-1":    "index: metadata directory is read-only",
+		"badcodec@db-1":    `compress: unknown compression "brotli"`,
+	}
+	if fmt.Sprint(failures) != fmt.Sprint(want) {
+		t.Errorf("failures = %v, want %v", failures, want)
+	}
+	// Only the artifact of the backup that succeeded is left
+	entries, _ := os.ReadDir(dir)
+	if ok == nil || len(entries) != 1 || entries[0].Name() != ok.ID+".dump.zst" {
+		t.Errorf("left %v", entries)
+	}
+
+	b, err := r.Backup(context.Background(), job("db-2", "broken", "fake-failing"))
+	if b != nil || err == nil || err.Error() != "dump failed: pg_dump: lost connection" {
+		t.Errorf("Backup() = %v, %v", b, err)
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	register()
+	dir := t.TempDir()
+	r, err := New(Options{TempDirectory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var failed int
+	r.Run(ctx, []*Job{job("db-1", "shop", "fake"), job("db-1", "shop2", "fake")}, func(res Result) {
+		if errors.Is(res.Err, context.Canceled) {
+			failed++
+		}
+	})
+	if entries, _ := os.ReadDir(dir); failed != 2 || len(entries) != 0 {
+		t.Errorf("%d cancelled, left %v", failed, entries)
+	}
+}
+
+func TestRunAutoTunesZstd(t *testing.T) {
+	register()
+	r, err := New(Options{
+		TempDirectory: t.TempDir(),
+		AutoTune:      config.AutoTuneConfig{Enabled: true, MinLevel: 1, MaxLevel: 6, MaxWorkers: 2, TargetCPU: 0.8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := job("db-1", "shop", "fake")
+	j.CompressionLevel = 9
+	b, err := r.Backup(context.Background(), j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := readArtifact(t, b, nil); !bytes.Equal(data, dumpOf("shop", 3<<20)) {
+		t.Errorf("artifact holds %d bytes", len(data))
+	}
+	// The level is capped to the tuning bounds and recorded with the workers
+	if b.Tags["compression_autotune"] != "true" || b.Tags["compression_level_initial"] != "better" ||
+		b.Tags["compression_level_final"] == "" || b.Tags["compression_workers_final"] == "" {
+		t.Errorf("tags = %v", b.Tags)
+	}
+
+	// Other codecs are not tuned
+	j.Compression = "gzip"
+	if b, err = r.Backup(context.Background(), j); err != nil || b.Tags["compression_autotune"] != "" {
+		t.Errorf("Backup() = %v, %v", b, err)
+	}
+}
+
+// memoryStorage keeps multipart uploads in memory
+type memoryStorage struct {
+	mu       sync.Mutex
+	uploads  map[string]map[int][]byte
+	objects  map[string][]byte
+	failPart int
+	failOnce bool // fail failPart only once
+}
+
+func (m *memoryStorage) Name() string { return "memory" }
+
+func (m *memoryStorage) CreateMultipart(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[key] = map[int][]byte{}
+	return key, nil
+}
+
+func (m *memoryStorage) UploadPart(ctx context.Context, key, id string, n int, data []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n == m.failPart {
+		if m.failOnce {
+			m.failPart = 0
+		}
+		return "", errors.New("connection reset")
+	}
+	m.uploads[id][n] = append([]byte(nil), data...)
+	return fmt.Sprint(n), nil
+}
+
+func (m *memoryStorage) CompleteMultipart(ctx context.Context, key, id string, parts []multipart.CompletedPart) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var obj []byte
+	for _, p := range parts {
+		obj = append(obj, m.uploads[id][p.Number]...)
+	}
+	m.objects[key] = obj
+	delete(m.uploads, id)
+	return nil
+}
+
+func (m *memoryStorage) AbortMultipart(ctx context.Context, key, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, id)
+	return nil
+}
+
+func (m *memoryStorage) ListMultipart(ctx context.Context, prefix string) ([]multipart.PendingUpload, error) {
+	return nil, nil
+}
+
+func TestRunUploadsWhileWriting(t *testing.T) {
+	register()
+	dir := t.TempDir()
+	storage := &memoryStorage{uploads: map[string]map[int][]byte{}, objects: map[string][]byte{}}
+	journal, err := multipart.NewJournal(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploader, err := multipart.NewUploader(storage, journal, config.UploadConfig{PartSize: "64KB", Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(Options{TempDirectory: dir, Upload: uploader, KeyPrefix: "db-1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j := job("db-1", "shop", "fake")
+	j.Compression = "none"
+	b, err := r.Backup(context.Background(), j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, _ := os.ReadFile(b.Path)
+	if b.StorageKey != "db-1/"+filepath.Base(b.Path) || !bytes.Equal(storage.objects[b.StorageKey], local) ||
+		!bytes.Equal(local, dumpOf("shop", 3<<20)) {
+		t.Fatalf("uploaded %d bytes to %q, wrote %d", len(storage.objects[b.StorageKey]), b.StorageKey, len(local))
+	}
+
+	// A part failing while streaming is uploaded again from the artifact
+	storage.failPart, storage.failOnce = 5, true
+	resumed, err := r.Backup(context.Background(), j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local, _ := os.ReadFile(resumed.Path); !bytes.Equal(storage.objects[resumed.StorageKey], local) {
+		t.Fatalf("uploaded %d bytes of %d after a failed part", len(storage.objects[resumed.StorageKey]), len(local))
+	}
+	os.Remove(resumed.Path)
+
+	// A part that keeps failing fails the backup and aborts the upload
+	storage.failPart, storage.failOnce = 5, false
+	_, err = r.Backup(context.Background(), j)
+	if err == nil || !strings.Contains(err.Error(), "upload failed: failed to upload part 5: connection reset") {
+		t.Fatalf("Backup() = %v", err)
+	}
+	var left []string
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	sort.Strings(left)
+	if len(storage.uploads) != 0 || fmt.Sprint(left) != fmt.Sprintf("[%s uploads]", filepath.Base(b.Path)) {
+		t.Errorf("left %d uploads and %v", len(storage.uploads), left)
+	}
+}
@@ -0,0 +1,183 @@
+// Package verify checks many backups concurrently, for fleet-wide
+// verification runs that must finish inside a maintenance window. Backups
+// are spread round-robin across storage providers, each provider with its
+// own cap on verifications in flight and on how many start per second, so
+// a throttled provider slows only its own backups. Verifications still
+// waiting when the window closes are skipped rather than started; those
+// already running are allowed to finish.
+package verify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/backup/pipeline"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// ErrWindowClosed is the error of backups skipped because the maintenance
+// window closed before their turn
+var ErrWindowClosed = errors.New("verification window closed")
+
+// stage names the single pipeline stage verifications run in
+const stage = "verify"
+
+// Target is a backup to verify
+type Target struct {
+	BackupID   string
+	Database   string
+	Provider   string // storage provider holding the artifact
+	BackupTime time.Time
+}
+
+// Func verifies one backup, returning why it failed
+type Func func(ctx context.Context, t Target) error
+
+// Result is the outcome of one backup
+type Result struct {
+	Target   Target
+	Err      error
+	Skipped  bool // never started, Err says why
+	Duration time.Duration
+}
+
+// Summary totals a run
+type Summary struct {
+	Verified int
+	Failed   int
+	Skipped  int
+	Duration time.Duration
+}
+
+// Runner verifies backups with the configured limits
+type Runner struct {
+	config config.VerifyConfig
+	now    func() time.Time
+}
+
+// New creates a runner
+func New(cfg config.VerifyConfig) *Runner {
+	return &Runner{config: cfg, now: time.Now}
+}
+
+// Run verifies targets, in the given order within each provider, and
+// calls onResult once per target from one goroutine at a time. It returns
+// when every target is reported. Cancelling ctx stops the verifications
+// in flight; the window only stops new ones from starting.
+func (r *Runner) Run(ctx context.Context, targets []Target, verify Func, onResult func(Result)) Summary {
+	start := r.now()
+	admit := ctx
+	if r.config.Window > 0 {
+		var cancel context.CancelFunc
+		admit, cancel = context.WithDeadline(ctx, start.Add(r.config.Window))
+		defer cancel()
+	}
+
+	limits := make(map[string]int, len(r.config.Providers))
+	limiters := make(map[string]*limiter)
+	for name, l := range r.config.Providers {
+		if l.MaxConcurrent > 0 {
+			limits[name] = l.MaxConcurrent
+		}
+		if l.MaxPerSecond > 0 {
+			limiters[name] = newLimiter(l.MaxPerSecond)
+		}
+	}
+
+	p, err := pipeline.New(pipeline.Options{MaxPerKey: r.config.MaxPerProvider, KeyLimits: limits},
+		pipeline.Stage[Target]{
+			Name:    stage,
+			Workers: r.config.Workers,
+			Run: func(_ context.Context, t Target) (Target, error) {
+				if l := limiters[t.Provider]; l != nil {
+					if err := l.wait(admit); err != nil {
+						return t, errSkipped{err}
+					}
+				}
+				// Running verifications only stop with the caller's context
+				return t, verify(ctx, t)
+			},
+		})
+	if err != nil {
+		// Only possible with no stages
+		panic(err)
+	}
+
+	jobs := make([]pipeline.Job[Target], len(targets))
+	for i, t := range targets {
+		jobs[i] = pipeline.Job[Target]{Key: t.Provider, Value: t}
+	}
+
+	var sum Summary
+	p.Run(admit, jobs, func(res pipeline.Result[Target]) {
+		duration, ran := res.Durations[stage]
+		out := Result{Target: res.Value, Err: res.Err, Duration: duration}
+		var skipped errSkipped
+		switch {
+		case errors.As(res.Err, &skipped):
+			out.Skipped, out.Err, out.Duration = true, windowError(skipped.err), 0
+		case res.Err != nil && !ran:
+			// Still queued when the window closed or ctx was cancelled
+			out.Skipped, out.Err = true, windowError(res.Err)
+		}
+		switch {
+		case out.Skipped:
+			sum.Skipped++
+		case out.Err != nil:
+			sum.Failed++
+		default:
+			sum.Verified++
+		}
+		onResult(out)
+	})
+	sum.Duration = r.now().Sub(start)
+	return sum
+}
+
+// errSkipped marks a verification that gave up waiting for its turn
+type errSkipped struct{ err error }
+
+func (e errSkipped) Error() string { return e.err.Error() }
+
+func windowError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrWindowClosed
+	}
+	return err
+}
+
+// limiter spaces the starts of one provider's verifications
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(perSecond float64) *limiter {
+	return &limiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next start is allowed
+func (l *limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	if d := time.Until(at); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// tracker records the verifications in flight per provider
+type tracker struct {
+	mu     sync.Mutex
+	active map[string]int
+	peak   map[string]int
+	starts map[string][]time.Time
+}
+
+func newTracker() *tracker {
+	return &tracker{active: map[string]int{}, peak: map[string]int{}, starts: map[string][]time.Time{}}
+}
+
+func (tr *tracker) verify(delay time.Duration, fail string) Func {
+	return func(ctx context.Context, t Target) error {
+		tr.mu.Lock()
+		tr.active[t.Provider]++
+		tr.peak[t.Provider] = max(tr.peak[t.Provider], tr.active[t.Provider])
+		tr.starts[t.Provider] = append(tr.starts[t.Provider], time.Now())
+		tr.mu.Unlock()
+		defer func() {
+			tr.mu.Lock()
+			tr.active[t.Provider]--
+			tr.mu.Unlock()
+		}()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if t.BackupID == fail {
+			return errors.New("checksum mismatch")
+		}
+		return nil
+	}
+}
+
+func targets(provider string, n int) []Target {
+	var out []Target
+	for i := 0; i < n; i++ {
+		out = append(out, Target{BackupID: fmt.Sprintf("%s-%d", provider, i), Provider: provider})
+	}
+	return out
+}
+
+func TestRunVerifiesConcurrentlyWithinLimits(t *testing.T) {
+	tr := newTracker()
+	r := New(config.VerifyConfig{
+		Workers:        6,
+		MaxPerProvider: 2,
+		Providers:      map[string]config.VerifyLimitConfig{"local": {MaxConcurrent: 4}},
+	})
+	all := append(targets("s3", 8), targets("local", 8)...)
+
+	reported := map[string]bool{}
+	sum := r.Run(context.Background(), all, tr.verify(10*time.Millisecond, "s3-3"), func(res Result) {
+		reported[res.Target.BackupID] = true
+		if res.Target.BackupID == "s3-3" && (res.Err == nil || res.Skipped) {
+			t.Errorf("s3-3: err %v, skipped %v", res.Err, res.Skipped)
+		}
+	})
+
+	if len(reported) != 16 || sum.Verified != 15 || sum.Failed != 1 || sum.Skipped != 0 {
+		t.Fatalf("reported %d, summary %+v", len(reported), sum)
+	}
+	if tr.peak["s3"] != 2 || tr.peak["local"] != 4 {
+		t.Fatalf("peak in flight = %v, want s3 2 and local 4", tr.peak)
+	}
+}
+
+func TestRunRateLimitsProvider(t *testing.T) {
+	tr := newTracker()
+	r := New(config.VerifyConfig{
+		Workers:   4,
+		Providers: map[string]config.VerifyLimitConfig{"gcs": {MaxPerSecond: 50}},
+	})
+	r.Run(context.Background(), append(targets("gcs", 5), targets("local", 5)...), tr.verify(0, ""), func(Result) {})
+
+	gcs := tr.starts["gcs"]
+	if len(gcs) != 5 {
+		t.Fatalf("%d gcs verifications, want 5", len(gcs))
+	}
+	// Five starts at 50/s span at least four 20ms intervals
+	if span := gcs[4].Sub(gcs[0]); span < 70*time.Millisecond {
+		t.Fatalf("gcs starts spanned %v, want about 80ms", span)
+	}
+}
+
+func TestRunSkipsAfterWindow(t *testing.T) {
+	tr := newTracker()
+	r := New(config.VerifyConfig{Workers: 1, MaxPerProvider: 1, Window: 30 * time.Millisecond})
+
+	var results []Result
+	sum := r.Run(context.Background(), targets("azure", 10), tr.verify(20*time.Millisecond, ""), func(res Result) {
+		results = append(results, res)
+	})
+
+	if sum.Verified < 1 || sum.Verified > 3 || sum.Verified+sum.Skipped != 10 || sum.Failed != 0 {
+		t.Fatalf("summary %+v", sum)
+	}
+	for _, res := range results {
+		if res.Skipped && !errors.Is(res.Err, ErrWindowClosed) {
+			t.Fatalf("%s skipped with %v", res.Target.BackupID, res.Err)
+		}
+		if !res.Skipped && res.Err != nil {
+			t.Fatalf("%s failed with %v: running verifications must finish", res.Target.BackupID, res.Err)
+		}
+	}
+}
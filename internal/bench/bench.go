@@ -0,0 +1,263 @@
+// Package bench measures the stages of a backup on the machine it runs
+// on: how fast the database can be dumped, how fast and how well each
+// codec compresses the dump, what encryption costs and how fast parts can
+// be uploaded. Codec and cipher figures are per core, so they can be
+// compared with the dump and upload rates to size the pipeline's workers;
+// Recommend turns a report into settings for backup.default_compression
+// and backup.pipeline.
+package bench
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/sanskarpan/db-backup/internal/security/cryptopolicy"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// DefaultDuration is how long each measurement runs
+const DefaultDuration = 2 * time.Second
+
+// frameSize is the plaintext encrypted under one nonce when measuring
+// ciphers, that of encrypted artifacts
+const frameSize = stream.DefaultChunkSize
+
+// Codecs lists the compression codecs measured, as configured in
+// backup.default_compression
+var Codecs = []string{"gzip", "zstd", "lz4"}
+
+// Ciphers lists the encryption algorithms measured, as configured in
+// backup.encryption.algorithm
+var Ciphers = []string{"aes-256-gcm", "chacha20-poly1305"}
+
+// Result is one measurement
+type Result struct {
+	Name     string        `json:"name"`
+	Input    int64         `json:"input_bytes"`
+	Output   int64         `json:"output_bytes"`
+	Duration time.Duration `json:"duration"`
+	Err      string        `json:"error,omitempty"`
+}
+
+// Rate is the input processed per second
+func (r Result) Rate() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Input) / r.Duration.Seconds()
+}
+
+// Ratio is the input size over the output size, 1 when nothing was written
+func (r Result) Ratio() float64 {
+	if r.Output <= 0 {
+		return 1
+	}
+	return float64(r.Input) / float64(r.Output)
+}
+
+// Report holds the measurements of one run. Dump and Upload are nil when
+// they were not measured.
+type Report struct {
+	Dump        *Result  `json:"dump,omitempty"`
+	Compression []Result `json:"compression"`
+	Encryption  []Result `json:"encryption"`
+	Upload      *Result  `json:"upload,omitempty"`
+	SampleSize  int      `json:"sample_size"`
+	CPUs        int      `json:"cpus"`
+}
+
+// countingWriter counts the bytes written through it, keeping the first
+// ones as a sample
+type countingWriter struct {
+	n      int64
+	sample []byte
+	limit  int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	if room := w.limit - len(w.sample); room > 0 {
+		w.sample = append(w.sample, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// Dump measures how fast dump writes for up to d, returning the first
+// sampleSize bytes written as a sample of real data to compress. A dump cut
+// short by the time limit is not an error.
+func Dump(ctx context.Context, d time.Duration, sampleSize int, dump func(ctx context.Context, w io.Writer) error) (Result, []byte) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	w := &countingWriter{limit: sampleSize}
+	start := time.Now()
+	err := dump(ctx, w)
+	r := Result{Name: "dump", Input: w.n, Output: w.n, Duration: time.Since(start)}
+	if err != nil && ctx.Err() == nil {
+		r.Err = err.Error()
+	}
+	return r, w.sample
+}
+
+// repeat runs fn over sample until d has passed or ctx is done, and
+// reports the input and output bytes
+func repeat(ctx context.Context, name string, d time.Duration, sample []byte, fn func(out io.Writer) error) Result {
+	r := Result{Name: name}
+	if len(sample) == 0 {
+		r.Err = "empty sample"
+		return r
+	}
+	var out countingWriter
+	start := time.Now()
+	for r.Duration < d && ctx.Err() == nil {
+		if err := fn(&out); err != nil {
+			r.Err = err.Error()
+			break
+		}
+		r.Input += int64(len(sample))
+		r.Duration = time.Since(start)
+	}
+	r.Output = out.n
+	return r
+}
+
+// Compression measures every codec at level (1-9) on one core
+func Compression(ctx context.Context, d time.Duration, sample []byte, level int) []Result {
+	results := make([]Result, 0, len(Codecs))
+	for _, codec := range Codecs {
+		results = append(results, repeat(ctx, codec, d, sample, func(out io.Writer) error {
+			w, err := newCompressor(codec, level, out)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(sample); err != nil {
+				return err
+			}
+			return w.Close()
+		}))
+	}
+	return results
+}
+
+// newCompressor returns a single-threaded writer for codec
+func newCompressor(codec string, level int, w io.Writer) (io.WriteCloser, error) {
+	level = min(max(level, 1), 9)
+	switch codec {
+	case "gzip":
+		return gzip.NewWriterLevel(w, level)
+	case "zstd":
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+	case "lz4":
+		zw := lz4.NewWriter(w)
+		if err := zw.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(1<<(8+level))), lz4.ConcurrencyOption(1)); err != nil {
+			return nil, err
+		}
+		return zw, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", codec)
+}
+
+// Encryption measures every cipher allowed by the crypto policy on one
+// core, sealing the sample in frames under fresh nonces
+func Encryption(ctx context.Context, d time.Duration, sample []byte) []Result {
+	results := make([]Result, 0, len(Ciphers))
+	for _, name := range Ciphers {
+		if err := cryptopolicy.CheckCipher(cryptopolicy.Current(), name); err != nil {
+			continue
+		}
+		aead, err := newAEAD(name)
+		if err != nil {
+			results = append(results, Result{Name: name, Err: err.Error()})
+			continue
+		}
+		nonce := make([]byte, aead.NonceSize())
+		buf := make([]byte, 0, frameSize+aead.Overhead())
+		results = append(results, repeat(ctx, name, d, sample, func(out io.Writer) error {
+			for off := 0; off < len(sample); off += frameSize {
+				if _, err := rand.Read(nonce); err != nil {
+					return err
+				}
+				sealed := aead.Seal(buf[:0], nonce, sample[off:min(off+frameSize, len(sample))], nil)
+				out.Write(nonce)
+				out.Write(sealed)
+			}
+			return nil
+		}))
+	}
+	return results
+}
+
+func newAEAD(name string) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	switch name {
+	case "aes-256-gcm":
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case "chacha20-poly1305":
+		return chacha20poly1305.New(key)
+	}
+	return nil, fmt.Errorf("unknown cipher %q", name)
+}
+
+// Upload measures uploading size bytes of sample data through put, in
+// parts of partSize with up to concurrency in flight, as a backup upload
+// does
+func Upload(ctx context.Context, sample []byte, size int64, partSize, concurrency int, put stream.PartFunc) Result {
+	r := Result{Name: "upload"}
+	if len(sample) == 0 {
+		r.Err = "empty sample"
+		return r
+	}
+	w := stream.NewChunkWriter(ctx, partSize, concurrency, put)
+	start := time.Now()
+	var err error
+	for r.Input < size && err == nil {
+		n := min(int64(len(sample)), size-r.Input)
+		_, err = w.Write(sample[:n])
+		r.Input += n
+	}
+	if err != nil {
+		w.Abort()
+	} else {
+		err = w.Close()
+	}
+	r.Duration = time.Since(start)
+	r.Output = w.Written()
+	if err != nil && !errors.Is(err, context.Canceled) {
+		r.Err = err.Error()
+	}
+	return r
+}
+
+// SyntheticSample returns size bytes resembling a SQL dump, for runs that
+// do not dump a database
+func SyntheticSample(size int) []byte {
+	var b bytes.Buffer
+	b.Grow(size + 256)
+	b.WriteString("COPY public.events (id, account_id, kind, payload, created_at) FROM stdin;\n")
+	kinds := []string{"login", "purchase", "refund", "signup", "logout"}
+	seed := make([]byte, 8)
+	for i := 0; b.Len() < size; i++ {
+		rand.Read(seed)
+		fmt.Fprintf(&b, "%d\t%d\t%s\t{\"session\":\"%x\",\"amount\":%d}\t2026-01-%02d %02d:%02d:%02d+00\n",
+			i+1, 1000+i%977, kinds[i%len(kinds)], seed, (i*37)%10000, 1+i%28, i%24, i%60, (i*7)%60)
+	}
+	return b.Bytes()[:size]
+}
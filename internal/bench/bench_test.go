@@ -0,0 +1,120 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+func TestCompressionMeasuresEveryCodec(t *testing.T) {
+	sample := SyntheticSample(256 << 10)
+	if len(sample) != 256<<10 {
+		t.Fatalf("sample is %d bytes", len(sample))
+	}
+	results := Compression(context.Background(), 20*time.Millisecond, sample, 3)
+	if len(results) != len(Codecs) {
+		t.Fatalf("%d results", len(results))
+	}
+	for _, r := range results {
+		if r.Err != "" || r.Input < int64(len(sample)) || r.Rate() <= 0 {
+			t.Errorf("%s: %+v", r.Name, r)
+		}
+		if r.Ratio() < 1.5 {
+			t.Errorf("%s compressed a SQL-like sample only %.2fx", r.Name, r.Ratio())
+		}
+	}
+}
+
+func TestEncryptionAddsOverhead(t *testing.T) {
+	sample := SyntheticSample(200 << 10)
+	for _, r := range Encryption(context.Background(), 10*time.Millisecond, sample) {
+		if r.Err != "" || r.Output <= r.Input {
+			t.Errorf("%s: %+v", r.Name, r)
+		}
+	}
+}
+
+func TestDumpKeepsSampleAndToleratesTimeout(t *testing.T) {
+	r, sample := Dump(context.Background(), 30*time.Millisecond, 1000, func(ctx context.Context, w io.Writer) error {
+		chunk := []byte(strings.Repeat("x", 300))
+		for {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Millisecond):
+			}
+		}
+	})
+	if r.Err != "" || r.Input == 0 || len(sample) != 1000 {
+		t.Fatalf("Dump() = %+v with a %d byte sample", r, len(sample))
+	}
+
+	r, _ = Dump(context.Background(), time.Second, 10, func(ctx context.Context, w io.Writer) error {
+		return errors.New("access denied")
+	})
+	if r.Err != "access denied" {
+		t.Fatalf("failed dump reported %q", r.Err)
+	}
+}
+
+func TestUploadSendsEveryByte(t *testing.T) {
+	var got atomic.Int64
+	r := Upload(context.Background(), SyntheticSample(10<<10), 100<<10, 16<<10, 3, func(ctx context.Context, p stream.Part) error {
+		got.Add(int64(len(p.Data)))
+		return nil
+	})
+	if r.Err != "" || r.Input != 100<<10 || got.Load() != 100<<10 {
+		t.Fatalf("Upload() = %+v, %d bytes received", r, got.Load())
+	}
+}
+
+func result(name string, rate, ratio float64) Result {
+	return Result{Name: name, Input: int64(rate), Output: int64(rate / ratio), Duration: time.Second}
+}
+
+func TestRecommend(t *testing.T) {
+	report := &Report{
+		CPUs: 4,
+		Dump: &Result{Name: "dump", Input: 200e6, Output: 200e6, Duration: time.Second},
+		Compression: []Result{
+			result("gzip", 30e6, 4.0),
+			result("zstd", 150e6, 4.5),
+			result("lz4", 600e6, 2.5),
+		},
+		Encryption: []Result{result("aes-256-gcm", 2000e6, 1)},
+		Upload:     &Result{Name: "upload", Input: 100e6, Output: 100e6, Duration: time.Second},
+	}
+	rec := report.Recommend(4)
+	// zstd: min(600 compress, 200 dump, 450 upload) = 200 and the best ratio
+	if rec.Compression != "zstd" || rec.Bottleneck != "dump" || rec.Rate != 200e6 {
+		t.Fatalf("Recommend() = %+v", rec)
+	}
+	if rec.CompressWorkers != 2 || rec.EncryptWorkers != 1 || rec.Encryption != "aes-256-gcm" {
+		t.Fatalf("workers = %+v", rec)
+	}
+
+	// A slow uplink favours the best ratio even over a faster codec
+	report.Upload = &Result{Name: "upload", Input: 10e6, Output: 10e6, Duration: time.Second}
+	if rec := report.Recommend(4); rec.Compression != "zstd" || rec.Bottleneck != "upload" {
+		t.Fatalf("Recommend() with slow upload = %+v", rec)
+	}
+
+	// Without a dump or upload, the fastest codec wins unless another is close
+	report.Dump, report.Upload = nil, nil
+	if rec := report.Recommend(4); rec.Compression != "lz4" || rec.Bottleneck != "compression" {
+		t.Fatalf("Recommend() with codecs only = %+v", rec)
+	}
+
+	if rec := (&Report{}).Recommend(1); rec.Compression != "none" {
+		t.Fatalf("Recommend() without measurements = %+v", rec)
+	}
+}
@@ -0,0 +1,132 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+)
+
+// Recommendation is the configuration a report suggests
+type Recommendation struct {
+	Compression     string   `json:"compression"`
+	Encryption      string   `json:"encryption,omitempty"`
+	DumpWorkers     int      `json:"dump_workers"`
+	CompressWorkers int      `json:"compress_workers"`
+	EncryptWorkers  int      `json:"encrypt_workers"`
+	UploadWorkers   int      `json:"upload_workers"`
+	Bottleneck      string   `json:"bottleneck"`
+	Rate            float64  `json:"rate"` // expected dump bytes per second end to end
+	Notes           []string `json:"notes,omitempty"`
+}
+
+// stageRate is the rate of one stage, in dump bytes per second
+type stageRate struct {
+	name string
+	rate float64
+}
+
+// closeEnough is how much end-to-end speed is traded for a better ratio:
+// codecs within this share of the fastest are ranked by ratio
+const closeEnough = 0.9
+
+// Recommend picks the codec giving the fastest backup once dump, codec,
+// cipher and upload rates are combined, preferring the smaller output among
+// codecs nearly as fast, and sizes the workers of each stage to keep up
+// with the dump. uploadWorkers is the concurrency the upload was measured
+// with.
+func (r *Report) Recommend(uploadWorkers int) Recommendation {
+	cpus := max(r.CPUs, 1)
+	rec := Recommendation{DumpWorkers: 1, UploadWorkers: max(uploadWorkers, 1)}
+
+	var cipher *Result
+	for i := range r.Encryption {
+		e := &r.Encryption[i]
+		if e.Err == "" && (cipher == nil || e.Rate() > cipher.Rate()) {
+			cipher = e
+		}
+	}
+	if cipher != nil {
+		rec.Encryption = cipher.Name
+	}
+
+	type candidate struct {
+		res        Result
+		rate       float64
+		bottleneck string
+	}
+	var candidates []candidate
+	for _, c := range r.Compression {
+		if c.Err != "" || c.Rate() == 0 {
+			continue
+		}
+		// Every stage's rate in dump bytes per second, with all cores on it
+		stages := []stageRate{{"compression", c.Rate() * float64(cpus)}}
+		if r.Dump != nil && r.Dump.Err == "" && r.Dump.Rate() > 0 {
+			stages = append(stages, stageRate{"dump", r.Dump.Rate()})
+		}
+		if cipher != nil {
+			// Ciphers see compressed bytes
+			stages = append(stages, stageRate{"encryption", cipher.Rate() * float64(cpus) * c.Ratio()})
+		}
+		if r.Upload != nil && r.Upload.Err == "" && r.Upload.Rate() > 0 {
+			stages = append(stages, stageRate{"upload", r.Upload.Rate() * c.Ratio()})
+		}
+		best := candidate{res: c, rate: math.Inf(1)}
+		for _, s := range stages {
+			if s.rate < best.rate {
+				best.rate, best.bottleneck = s.rate, s.name
+			}
+		}
+		candidates = append(candidates, best)
+	}
+	if len(candidates) == 0 {
+		rec.Compression = "none"
+		rec.Notes = append(rec.Notes, "no codec could be measured")
+		return rec
+	}
+
+	fastest := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.rate > fastest.rate {
+			fastest = c
+		}
+	}
+	chosen := fastest
+	for _, c := range candidates {
+		if c.rate >= fastest.rate*closeEnough && c.res.Ratio() > chosen.res.Ratio() {
+			chosen = c
+		}
+	}
+	rec.Compression = chosen.res.Name
+	rec.Rate = chosen.rate
+	rec.Bottleneck = chosen.bottleneck
+
+	// Enough workers per stage to process the expected rate
+	workers := func(perCore float64) int {
+		if perCore <= 0 {
+			return 1
+		}
+		return min(max(int(math.Ceil(chosen.rate/perCore)), 1), cpus)
+	}
+	rec.CompressWorkers = workers(chosen.res.Rate())
+	rec.EncryptWorkers = 1
+	if cipher != nil {
+		rec.EncryptWorkers = workers(cipher.Rate() * chosen.res.Ratio())
+	}
+
+	switch chosen.bottleneck {
+	case "dump":
+		rec.DumpWorkers = min(2, cpus)
+		rec.Notes = append(rec.Notes, "the database dump is the slowest stage: run more dumps in parallel (backup.pipeline.dump_workers) if the server has headroom")
+	case "upload":
+		rec.Notes = append(rec.Notes, fmt.Sprintf("uploads are the slowest stage: try more concurrency than %d (storage.upload.concurrency) or a codec with a better ratio", rec.UploadWorkers))
+	case "compression":
+		rec.Notes = append(rec.Notes, "compression uses every core: a lower compression level or a faster codec would speed up backups")
+	case "encryption":
+		rec.Notes = append(rec.Notes, "encryption uses every core: check the CPU supports AES instructions")
+	}
+	if chosen.res.Name != fastest.res.Name {
+		rec.Notes = append(rec.Notes, fmt.Sprintf("%s is up to %.0f%% faster but compresses less (%.1fx against %.1fx)",
+			fastest.res.Name, (fastest.rate/chosen.rate-1)*100, fastest.res.Ratio(), chosen.res.Ratio()))
+	}
+	return rec
+}
@@ -0,0 +1,1194 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database/plugin"
+	"github.com/sanskarpan/db-backup/internal/secrets"
+	"github.com/sanskarpan/db-backup/internal/security/cryptopolicy"
+	storageplugin "github.com/sanskarpan/db-backup/internal/storage/plugin"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// FieldError describes a validation failure for a single configuration key
+type FieldError struct {
+	Path    string `json:"path" yaml:"path"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// Error implements the error interface
+func (e FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// FieldErrors is a list of configuration validation failures
+type FieldErrors []FieldError
+
+// Error implements the error interface
+func (e FieldErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// checker accumulates field errors
+type checker struct {
+	errs FieldErrors
+}
+
+func (c *checker) add(path, format string, args ...interface{}) {
+	c.errs = append(c.errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) required(path, value string) {
+	if strings.TrimSpace(value) == "" {
+		c.add(path, "is required")
+	}
+}
+
+func (c *checker) oneOf(path, value string, allowed ...string) {
+	if value == "" {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	c.add(path, "must be one of %s, got %q", strings.Join(allowed, "|"), value)
+}
+
+func (c *checker) fileExists(path, file string) {
+	if file == "" {
+		return
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		c.add(path, "file not found: %s", file)
+	}
+}
+
+// Check validates the full configuration and reports every problem found,
+// each tagged with the dotted key it applies to. Unlike Load it never
+// creates directories or otherwise modifies the filesystem.
+func Check(cfg *Config) FieldErrors {
+	c := &checker{}
+
+	checkServer(c, cfg)
+	checkLogging(c, cfg)
+	checkBackup(c, cfg)
+	checkConnections(c, cfg)
+	checkAgent(c, cfg)
+	checkDocker(c, cfg)
+	checkKubernetes(c, cfg)
+	checkPlugins(c, cfg)
+	checkStorage(c, cfg)
+	checkNotifications(c, cfg)
+	checkEvents(c, cfg)
+	checkHeartbeats(c, cfg)
+	checkSLA(c, cfg)
+	checkFreshness(c, cfg)
+	checkRunbooks(c, cfg)
+	checkDiscovery(c, cfg)
+	checkAirGap(c, cfg)
+	checkDR(c, cfg)
+	checkObservability(c, cfg)
+	checkSecurity(c, cfg)
+
+	return c.errs
+}
+
+func checkServer(c *checker, cfg *Config) {
+	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
+		c.add("server.port", "must be between 1 and 65535, got %d", cfg.Server.Port)
+	}
+	c.oneOf("server.mode", cfg.Server.Mode, "development", "production")
+
+	if cfg.Server.TLS.Enabled {
+		c.required("server.tls.cert_file", cfg.Server.TLS.CertFile)
+		c.required("server.tls.key_file", cfg.Server.TLS.KeyFile)
+		c.fileExists("server.tls.cert_file", cfg.Server.TLS.CertFile)
+		c.fileExists("server.tls.key_file", cfg.Server.TLS.KeyFile)
+
+		t := cfg.Server.TLS
+		c.oneOf("server.tls.client_auth", strings.ToLower(t.ClientAuth), "none", "optional", "require")
+		if mode := strings.ToLower(t.ClientAuth); mode == "optional" || mode == "require" {
+			c.required("server.tls.client_ca_file", t.ClientCAFile)
+		}
+		c.fileExists("server.tls.client_ca_file", t.ClientCAFile)
+		c.fileExists("server.tls.crl_file", t.CRLFile)
+		if t.CRLFile != "" && t.ClientCAFile == "" {
+			c.add("server.tls.crl_file", "requires server.tls.client_ca_file to verify the list")
+		}
+	}
+
+	if a := cfg.Server.Agents; a.Enabled {
+		c.required("server.agents.listen", a.Listen)
+		c.required("server.agents.tls.cert_file", a.TLS.CertFile)
+		c.required("server.agents.tls.key_file", a.TLS.KeyFile)
+		c.required("server.agents.tls.client_ca_file", a.TLS.ClientCAFile)
+		c.fileExists("server.agents.tls.cert_file", a.TLS.CertFile)
+		c.fileExists("server.agents.tls.key_file", a.TLS.KeyFile)
+		c.fileExists("server.agents.tls.client_ca_file", a.TLS.ClientCAFile)
+		c.fileExists("server.agents.tls.crl_file", a.TLS.CRLFile)
+	}
+}
+
+func checkLogging(c *checker, cfg *Config) {
+	c.oneOf("logging.level", cfg.Logging.Level, "debug", "info", "warn", "error", "fatal", "panic")
+	c.oneOf("logging.format", cfg.Logging.Format, "json", "text")
+	c.oneOf("logging.output", cfg.Logging.Output, "stdout", "file")
+	if cfg.Logging.Output == "file" {
+		c.required("logging.file.path", cfg.Logging.File.Path)
+	}
+}
+
+func checkBackup(c *checker, cfg *Config) {
+	b := cfg.Backup
+	c.oneOf("backup.default_compression", b.DefaultCompression, "gzip", "zstd", "lz4", "none")
+	if b.CompressionLevel != 0 && (b.CompressionLevel < 1 || b.CompressionLevel > 9) {
+		c.add("backup.compression_level", "must be between 1 and 9, got %d", b.CompressionLevel)
+	}
+	if b.ParallelOperations < 1 {
+		c.add("backup.parallel_operations", "must be at least 1")
+	}
+	c.oneOf("backup.vss", b.VSS, "auto", "always", "never")
+	if b.Retention.Daily < 0 {
+		c.add("backup.retention.daily", "must not be negative")
+	}
+	if b.Retention.Weekly < 0 {
+		c.add("backup.retention.weekly", "must not be negative")
+	}
+	if b.Retention.Monthly < 0 {
+		c.add("backup.retention.monthly", "must not be negative")
+	}
+
+	if b.Encryption.Enabled {
+		c.oneOf("backup.encryption.algorithm", b.Encryption.Algorithm, "aes-256-gcm", "chacha20-poly1305")
+		c.oneOf("backup.encryption.key_store", b.Encryption.KeyStore, "file", "vault")
+		if b.Encryption.KeyStore == "vault" {
+			c.required("backup.encryption.vault.address", b.Encryption.Vault.Address)
+		} else {
+			c.required("backup.encryption.key_file", b.Encryption.KeyFile)
+			c.fileExists("backup.encryption.key_file", b.Encryption.KeyFile)
+		}
+	}
+
+	checkDatabaseDefaults(c, b)
+	checkQuotas(c, b.Quotas)
+	checkRetentionOptimizer(c, b.RetentionOptimizer)
+
+	var bufferSize, maxMemory int64
+	if b.BufferSize != "" {
+		n, err := utils.ParseBytes(b.BufferSize)
+		if err != nil {
+			c.add("backup.buffer_size", "%v", err)
+		} else if n < 4<<10 {
+			c.add("backup.buffer_size", "must be at least 4KB")
+		}
+		bufferSize = n
+	}
+	if b.MaxMemory != "" {
+		n, err := utils.ParseBytes(b.MaxMemory)
+		if err != nil {
+			c.add("backup.max_memory", "%v", err)
+		}
+		maxMemory = n
+	}
+	if bufferSize > 0 && maxMemory > 0 && maxMemory < bufferSize {
+		c.add("backup.max_memory", "must be at least backup.buffer_size")
+	}
+
+	workers := []struct {
+		key string
+		n   int
+	}{
+		{"dump_workers", b.Pipeline.DumpWorkers},
+		{"compress_workers", b.Pipeline.CompressWorkers},
+		{"encrypt_workers", b.Pipeline.EncryptWorkers},
+		{"upload_workers", b.Pipeline.UploadWorkers},
+		{"index_workers", b.Pipeline.IndexWorkers},
+	}
+	for _, w := range workers {
+		if w.n < 1 {
+			c.add("backup.pipeline."+w.key, "must be at least 1")
+		}
+	}
+	if b.Pipeline.MaxPerHost < 0 {
+		c.add("backup.pipeline.max_per_host", "must not be negative")
+	}
+
+	if t := b.AutoTune; t.Enabled {
+		if t.MinLevel < 1 || t.MinLevel > 9 {
+			c.add("backup.auto_tune.min_level", "must be between 1 and 9, got %d", t.MinLevel)
+		}
+		if t.MaxLevel < 1 || t.MaxLevel > 9 {
+			c.add("backup.auto_tune.max_level", "must be between 1 and 9, got %d", t.MaxLevel)
+		}
+		if t.MinLevel > t.MaxLevel {
+			c.add("backup.auto_tune.min_level", "must not exceed max_level")
+		}
+		if t.MaxWorkers < 0 {
+			c.add("backup.auto_tune.max_workers", "must not be negative")
+		}
+		if t.TargetCPU <= 0 || t.TargetCPU > 1 {
+			c.add("backup.auto_tune.target_cpu", "must be above 0 and at most 1, got %g", t.TargetCPU)
+		}
+		if t.Interval < time.Second {
+			c.add("backup.auto_tune.interval", "must be at least 1s")
+		}
+	}
+
+	if b.Verify.Workers < 1 {
+		c.add("backup.verify.workers", "must be at least 1")
+	}
+	if b.Verify.MaxPerProvider < 0 {
+		c.add("backup.verify.max_per_provider", "must not be negative")
+	}
+	if b.Verify.Window < 0 {
+		c.add("backup.verify.window", "must not be negative")
+	}
+	providers := make([]string, 0, len(b.Verify.Providers))
+	for name := range b.Verify.Providers {
+		providers = append(providers, name)
+	}
+	sort.Strings(providers)
+	for _, name := range providers {
+		l := b.Verify.Providers[name]
+		path := "backup.verify.providers." + name
+		c.oneOf(path, name, "s3", "gcs", "azure", "local")
+		if l.MaxConcurrent < 0 {
+			c.add(path+".max_concurrent", "must not be negative")
+		}
+		if l.MaxPerSecond < 0 {
+			c.add(path+".max_per_second", "must not be negative")
+		}
+	}
+
+	if w := b.DiskWatchdog; w.Enabled {
+		if w.MinFreePercent < 0 || w.MinFreePercent >= 100 {
+			c.add("backup.disk_watchdog.min_free_percent", "must be between 0 and 100, got %g", w.MinFreePercent)
+		}
+		if w.MinFreeSpace != "" {
+			if _, err := utils.ParseBytes(w.MinFreeSpace); err != nil {
+				c.add("backup.disk_watchdog.min_free_space", "%v", err)
+			}
+		}
+		if w.TempMaxAge < 0 {
+			c.add("backup.disk_watchdog.temp_max_age", "must not be negative")
+		}
+	}
+}
+
+// checkDatabaseDefaults validates backup.database_defaults; their storage
+// providers are checked with the providers
+func checkDatabaseDefaults(c *checker, b BackupConfig) {
+	for i, d := range b.DatabaseDefaults {
+		path := fmt.Sprintf("backup.database_defaults[%d]", i)
+		checkPatterns := func(field string, patterns []string) {
+			for j, pattern := range patterns {
+				if err := validPattern(pattern); err != nil {
+					c.add(fmt.Sprintf("%s.%s[%d]", path, field, j), "is not a valid pattern: %v", err)
+				}
+			}
+		}
+		checkPatterns("databases", d.Databases)
+		checkPatterns("database_types", d.DatabaseTypes)
+		c.oneOf(path+".compression", d.Compression, "gzip", "zstd", "lz4", "none")
+		if d.CompressionLevel != 0 && (d.CompressionLevel < 1 || d.CompressionLevel > 9) {
+			c.add(path+".compression_level", "must be between 1 and 9, got %d", d.CompressionLevel)
+		}
+		if d.Retention.Daily < 0 || d.Retention.Weekly < 0 || d.Retention.Monthly < 0 {
+			c.add(path+".retention", "must not be negative")
+		}
+		c.fileExists(path+".encryption_key_file", d.EncryptionKeyFile)
+		if d.Encrypt != nil && *d.Encrypt && d.EncryptionKeyFile == "" && b.Encryption.KeyFile == "" {
+			c.add(path+".encryption_key_file", "is required to encrypt when backup.encryption.key_file is not set")
+		}
+	}
+}
+
+// checkQuotas validates backup.quotas
+func checkQuotas(c *checker, q QuotaConfig) {
+	if len(q.Limits) > 0 && (q.WarnPercent <= 0 || q.WarnPercent > 100) {
+		c.add("backup.quotas.warn_percent", "must be above 0 and at most 100, got %g", q.WarnPercent)
+	}
+	names := make(map[string]bool, len(q.Limits))
+	for i, l := range q.Limits {
+		path := fmt.Sprintf("backup.quotas.limits[%d]", i)
+		c.required(path+".name", l.Name)
+		if names[l.Name] {
+			c.add(path+".name", "%q is used by another limit", l.Name)
+		}
+		names[l.Name] = true
+		for j, pattern := range l.Databases {
+			if err := validPattern(pattern); err != nil {
+				c.add(fmt.Sprintf("%s.databases[%d]", path, j), "is not a valid pattern: %v", err)
+			}
+		}
+		if l.MaxSize == "" && l.MaxBackups == 0 {
+			c.add(path, "needs max_size or max_backups")
+		}
+		if l.MaxSize != "" {
+			if n, err := utils.ParseBytes(l.MaxSize); err != nil {
+				c.add(path+".max_size", "%v", err)
+			} else if n <= 0 {
+				c.add(path+".max_size", "must be positive")
+			}
+		}
+		if l.MaxBackups < 0 {
+			c.add(path+".max_backups", "must not be negative")
+		}
+	}
+}
+
+// checkRetentionOptimizer validates backup.retention_optimizer
+func checkRetentionOptimizer(c *checker, o RetentionOptimizerConfig) {
+	c.oneOf("backup.retention_optimizer.mode", o.Mode, "advisory", "apply")
+	if o.MonthlyBudget < 0 {
+		c.add("backup.retention_optimizer.monthly_budget", "must not be negative")
+	}
+	if o.Coverage <= 0 || o.Coverage > 1 {
+		c.add("backup.retention_optimizer.coverage", "must be above 0 and at most 1, got %g", o.Coverage)
+	}
+	providers := make([]string, 0, len(o.Prices))
+	for name := range o.Prices {
+		providers = append(providers, name)
+	}
+	sort.Strings(providers)
+	for _, name := range providers {
+		for i, t := range o.Prices[name] {
+			path := fmt.Sprintf("backup.retention_optimizer.prices.%s[%d]", name, i)
+			c.required(path+".tier", t.Tier)
+			if t.PerGBMonth < 0 || t.RetrievalPerGB < 0 || t.MinDays < 0 {
+				c.add(path, "prices and min_days must not be negative")
+			}
+		}
+	}
+}
+
+// checkConnections validates the connection profiles. A profile's backup
+// and restore logins must differ, otherwise the backup principal would
+// hold the write rights restores need.
+func checkConnections(c *checker, cfg *Config) {
+	names := make([]string, 0, len(cfg.Connections))
+	for name := range cfg.Connections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Installed plugins add database types
+	var plugins []string
+	found, _ := plugin.Find(cfg.Plugins.Directory)
+	for dbType := range found {
+		plugins = append(plugins, string(dbType))
+	}
+	sort.Strings(plugins)
+
+	for _, name := range names {
+		p := cfg.Connections[name]
+		path := "connections." + name
+		c.required(path+".type", p.Type)
+		c.oneOf(path+".type", p.Type, append([]string{"mysql", "postgres", "mongodb", "sqlite", "redis", "clickhouse", "cockroachdb", "mariadb", "etcd", "influxdb", "neo4j", "oracle", "duckdb", "couchbase", "tidb", "dynamodb"}, plugins...)...)
+		if FileDatabase(p.Type) {
+			c.required(path+".database", p.Database)
+			continue
+		}
+		c.required(path+".host", p.Host)
+		if p.Port < 0 || p.Port > 65535 {
+			c.add(path+".port", "must be between 0 and 65535, got %d", p.Port)
+		}
+		c.required(path+".backup.user", p.Backup.User)
+		c.fileExists(path+".ssl_ca", p.SSLCA)
+		c.fileExists(path+".ssl_cert", p.SSLCert)
+		c.fileExists(path+".ssl_key", p.SSLKey)
+		if p.SSLKey != "" && p.SSLCert == "" {
+			c.add(path+".ssl_key", "needs ssl_cert")
+		}
+		if p.Restore.User != "" && p.Restore.User == p.Backup.User {
+			c.add(path+".restore.user", "must differ from backup.user so the backup login holds no write rights")
+		}
+		if p.Backup.Role != "" {
+			c.add(path+".backup.role", "is not supported, grant the read rights to the backup user directly")
+		}
+		if role := p.Restore.Role; role != "" {
+			if p.Type == "mongodb" {
+				c.add(path+".restore.role", "is not supported for mongodb, grant the roles to the restore user instead")
+			} else if p.Type == "redis" {
+				c.add(path+".restore.role", "is not supported for redis, grant the ACL permissions to the restore user instead")
+			} else if p.Type == "clickhouse" {
+				c.add(path+".restore.role", "is not supported for clickhouse, grant the roles to the restore user as default roles instead")
+			} else if p.Type == "cockroachdb" {
+				c.add(path+".restore.role", "is not supported for cockroachdb, grant the role to the restore user instead")
+			} else if p.Type == "etcd" {
+				c.add(path+".restore.role", "is not supported for etcd, snapshots are restored into a new data directory")
+			} else if p.Type == "influxdb" {
+				c.add(path+".restore.role", "is not supported for influxdb, use a token with write access to the buckets as the restore password instead")
+			} else if p.Type == "neo4j" {
+				c.add(path+".restore.role", "is not supported for neo4j, grant the admin rights to the restore user instead")
+			} else if p.Type == "oracle" {
+				c.add(path+".restore.role", "is not supported for oracle, grant DATAPUMP_IMP_FULL_DATABASE to the restore user instead")
+			} else if p.Type == "couchbase" {
+				c.add(path+".restore.role", "is not supported for couchbase, give the restore user the Data Backup & Restore role instead")
+			} else if p.Type == "tidb" {
+				c.add(path+".restore.role", "is not supported for tidb, grant the RESTORE_ADMIN privilege to the restore user instead")
+			} else if p.Type == "dynamodb" {
+				c.add(path+".restore.role", "is not supported for dynamodb, allow dynamodb:ImportTable to the restore credentials instead")
+			} else if err := validation.ValidateRoleName(role); err != nil {
+				c.add(path+".restore.role", "%v", err)
+			}
+		}
+	}
+}
+
+// checkAgent validates the agent settings, used only when agent.server is
+// set
+func checkAgent(c *checker, cfg *Config) {
+	a := cfg.Agent
+	if a.Server == "" {
+		return
+	}
+	c.required("agent.cert_file", a.CertFile)
+	c.required("agent.key_file", a.KeyFile)
+	c.fileExists("agent.cert_file", a.CertFile)
+	c.fileExists("agent.key_file", a.KeyFile)
+	c.fileExists("agent.ca_file", a.CAFile)
+	for _, name := range a.Profiles {
+		if _, ok := cfg.Connections[name]; !ok {
+			c.add("agent.profiles", "unknown connection profile %q", name)
+		}
+	}
+	if a.MaxConcurrent < 1 {
+		c.add("agent.max_concurrent", "must be at least 1, got %d", a.MaxConcurrent)
+	}
+	// Chunks are single gRPC messages, which the server accepts up to 16MB
+	if size, err := utils.ParseBytes(a.ChunkSize); err != nil {
+		c.add("agent.chunk_size", "%v", err)
+	} else if size < 64<<10 || size > 8<<20 {
+		c.add("agent.chunk_size", "must be between 64KB and 8MB, got %s", a.ChunkSize)
+	}
+	if a.ReconnectInterval <= 0 {
+		c.add("agent.reconnect_interval", "must be positive, got %s", a.ReconnectInterval)
+	}
+}
+
+func checkDocker(c *checker, cfg *Config) {
+	d := cfg.Docker
+	if d.Host != "" {
+		scheme, _, _ := strings.Cut(d.Host, "://")
+		if scheme != "unix" && scheme != "tcp" && scheme != "http" {
+			c.add("docker.host", "must be a unix:// or tcp:// address, got %q", d.Host)
+		}
+	}
+	for _, l := range d.Labels {
+		if k, _, _ := strings.Cut(l, "="); strings.TrimSpace(k) == "" {
+			c.add("docker.labels", "label filter %q has no key", l)
+		}
+	}
+}
+
+func checkKubernetes(c *checker, cfg *Config) {
+	k := cfg.Kubernetes
+	c.fileExists("kubernetes.kubeconfig", k.Kubeconfig)
+	if k.SnapshotTimeout <= 0 {
+		c.add("kubernetes.snapshot_timeout", "must be positive, got %s", k.SnapshotTimeout)
+	}
+}
+
+func checkPlugins(c *checker, cfg *Config) {
+	if cfg.Plugins.HandshakeTimeout <= 0 {
+		c.add("plugins.handshake_timeout", "must be positive, got %s", cfg.Plugins.HandshakeTimeout)
+	}
+	if _, err := plugin.Find(cfg.Plugins.Directory); err != nil {
+		c.add("plugins.directory", "%v", err)
+	}
+}
+
+func checkStorage(c *checker, cfg *Config) {
+	p := cfg.Storage.Providers
+	enabled := map[string]bool{
+		"s3":    p.S3.Enabled,
+		"gcs":   p.GCS.Enabled,
+		"azure": p.Azure.Enabled,
+		"local": p.Local.Enabled,
+	}
+
+	if p.S3.Enabled {
+		c.required("storage.providers.s3.region", p.S3.Region)
+		c.required("storage.providers.s3.bucket", p.S3.Bucket)
+	}
+	if p.GCS.Enabled {
+		c.required("storage.providers.gcs.bucket", p.GCS.Bucket)
+		c.fileExists("storage.providers.gcs.credentials_file", p.GCS.CredentialsFile)
+	}
+	if p.Azure.Enabled {
+		c.required("storage.providers.azure.account_name", p.Azure.AccountName)
+		c.required("storage.providers.azure.container", p.Azure.Container)
+	}
+	if p.Local.Enabled {
+		c.required("storage.providers.local.path", p.Local.Path)
+	}
+
+	plugins, _ := storageplugin.Find(cfg.Plugins.Directory)
+	names := make([]string, 0, len(p.Plugins))
+	for name := range p.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, builtin := enabled[name]; builtin {
+			c.add("storage.providers.plugins."+name, "%s is a built-in provider", name)
+			continue
+		}
+		enabled[name] = p.Plugins[name].Enabled
+		if p.Plugins[name].Enabled && plugins[name] == "" {
+			c.add("storage.providers.plugins."+name, "no %s%s in plugins.directory %s", storageplugin.Prefix, name, cfg.Plugins.Directory)
+		}
+	}
+
+	anyEnabled := false
+	for _, on := range enabled {
+		anyEnabled = anyEnabled || on
+	}
+	if !anyEnabled {
+		c.add("storage.providers", "at least one storage provider must be enabled")
+	}
+
+	if def := cfg.Storage.DefaultProvider; def != "" {
+		if _, known := enabled[def]; !known {
+			c.add("storage.default_provider", "unknown provider %q", def)
+		} else if !enabled[def] {
+			c.add("storage.default_provider", "provider %q is not enabled", def)
+		}
+	}
+
+	for i, d := range cfg.Backup.DatabaseDefaults {
+		path := fmt.Sprintf("backup.database_defaults[%d].storage", i)
+		if d.Storage == "" {
+			continue
+		}
+		if _, known := enabled[d.Storage]; !known {
+			c.add(path, "unknown provider %q", d.Storage)
+		} else if !enabled[d.Storage] {
+			c.add(path, "provider %q is not enabled", d.Storage)
+		}
+	}
+
+	if u := cfg.Storage.Upload; u.PartSize != "" {
+		if n, err := utils.ParseBytes(u.PartSize); err != nil {
+			c.add("storage.upload.part_size", "%v", err)
+		} else if n < 5<<20 {
+			c.add("storage.upload.part_size", "must be at least 5MB, the smallest part S3 accepts")
+		}
+	}
+	if cfg.Storage.Upload.Concurrency < 1 {
+		c.add("storage.upload.concurrency", "must be at least 1")
+	}
+	if cfg.Storage.Upload.AbandonAfter < 0 {
+		c.add("storage.upload.abandon_after", "must not be negative")
+	}
+
+	if cc := cfg.Storage.Cache; cc.Enabled {
+		c.required("storage.cache.directory", cc.Directory)
+		if n, err := utils.ParseBytes(cc.MaxSize); err != nil {
+			c.add("storage.cache.max_size", "%v", err)
+		} else if n <= 0 {
+			c.add("storage.cache.max_size", "must be positive")
+		}
+	}
+}
+
+func checkNotifications(c *checker, cfg *Config) {
+	n := cfg.Notifications
+	if n.Slack.Enabled {
+		c.required("notifications.slack.webhook_url", n.Slack.WebhookURL)
+	}
+	if n.Email.Enabled {
+		c.required("notifications.email.smtp_host", n.Email.SMTPHost)
+		c.required("notifications.email.from", n.Email.From)
+		if len(n.Email.To) == 0 {
+			c.add("notifications.email.to", "at least one recipient is required")
+		}
+		if n.Email.SMTPPort < 1 || n.Email.SMTPPort > 65535 {
+			c.add("notifications.email.smtp_port", "must be between 1 and 65535, got %d", n.Email.SMTPPort)
+		}
+		c.oneOf("notifications.email.tls_mode", strings.ToLower(n.Email.TLSMode), "starttls", "tls", "none")
+		c.oneOf("notifications.email.auth_method", strings.ToLower(n.Email.AuthMethod), "plain", "login", "xoauth2", "none")
+		if strings.EqualFold(n.Email.AuthMethod, "xoauth2") {
+			o := n.Email.OAuth2
+			if o.AccessToken == "" && o.RefreshToken == "" {
+				c.add("notifications.email.oauth2", "access_token or refresh_token is required for xoauth2")
+			}
+			if o.RefreshToken != "" {
+				c.required("notifications.email.oauth2.token_url", o.TokenURL)
+				c.required("notifications.email.oauth2.client_id", o.ClientID)
+			}
+		}
+		for i, kind := range n.Email.Attach {
+			c.oneOf(fmt.Sprintf("notifications.email.attach[%d]", i), kind, "verification_report", "manifest")
+		}
+		if n.Email.MaxAttachmentSize < 0 {
+			c.add("notifications.email.max_attachment_size", "must not be negative")
+		}
+		if d := n.Email.Digest; d.Enabled {
+			c.oneOf("notifications.email.digest.frequency", d.Frequency, "daily", "weekly")
+			if _, err := time.Parse("15:04", d.SendAt); d.SendAt != "" && err != nil {
+				c.add("notifications.email.digest.send_at", "must be HH:MM, got %q", d.SendAt)
+			}
+			if d.Frequency == "weekly" {
+				c.oneOf("notifications.email.digest.weekday", strings.ToLower(d.Weekday),
+					"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday",
+					"sun", "mon", "tue", "wed", "thu", "fri", "sat")
+			}
+			c.required("notifications.email.digest.spool_file", d.SpoolFile)
+			if d.ExpirationWindow < 0 {
+				c.add("notifications.email.digest.expiration_window", "must not be negative")
+			}
+		}
+	}
+	if n.Webhook.Enabled {
+		c.required("notifications.webhook.url", n.Webhook.URL)
+		c.oneOf("notifications.webhook.method", n.Webhook.Method, "POST", "PUT", "PATCH")
+		if n.Webhook.Template != "" && n.Webhook.TemplateFile != "" {
+			c.add("notifications.webhook.template_file", "cannot be combined with template")
+		}
+		c.fileExists("notifications.webhook.template_file", n.Webhook.TemplateFile)
+		for i, code := range n.Webhook.SuccessCodes {
+			if code < 100 || code > 599 {
+				c.add(fmt.Sprintf("notifications.webhook.success_codes[%d]", i), "must be an HTTP status code, got %d", code)
+			}
+		}
+		if r := n.Webhook.Retry; r.MaxBackoff > 0 && r.MaxBackoff < r.InitialBackoff {
+			c.add("notifications.webhook.retry.max_backoff", "must not be less than initial_backoff")
+		}
+	}
+	if n.Discord.Enabled {
+		c.required("notifications.discord.webhook_url", n.Discord.WebhookURL)
+	}
+	if n.Telegram.Enabled {
+		c.required("notifications.telegram.bot_token", n.Telegram.BotToken)
+		c.required("notifications.telegram.chat_id", n.Telegram.ChatID)
+	}
+	if n.PagerDuty.Enabled {
+		c.required("notifications.pagerduty.routing_key", n.PagerDuty.RoutingKey)
+		c.oneOf("notifications.pagerduty.severity", n.PagerDuty.Severity, "critical", "error", "warning", "info")
+	}
+	checkRouting(c, n)
+	if q := n.Queue; q.Enabled {
+		c.required("notifications.queue.directory", q.Directory)
+		if q.MaxAttempts < 1 {
+			c.add("notifications.queue.max_attempts", "must be at least 1")
+		}
+		if q.MaxBackoff > 0 && q.MaxBackoff < q.InitialBackoff {
+			c.add("notifications.queue.max_backoff", "must not be less than initial_backoff")
+		}
+	}
+	checkNotifyOn(c, "notifications.slack.notify_on", n.Slack.NotifyOn)
+	checkNotifyOn(c, "notifications.webhook.notify_on", n.Webhook.NotifyOn)
+	checkNotifyOn(c, "notifications.discord.notify_on", n.Discord.NotifyOn)
+	checkNotifyOn(c, "notifications.telegram.notify_on", n.Telegram.NotifyOn)
+	checkNotifyOn(c, "notifications.pagerduty.notify_on", n.PagerDuty.NotifyOn)
+}
+
+// checkRouting verifies routing rules and escalation levels only reference
+// enabled channels
+func checkRouting(c *checker, n NotificationConfig) {
+	enabled := map[string]bool{
+		"slack":     n.Slack.Enabled,
+		"email":     n.Email.Enabled,
+		"webhook":   n.Webhook.Enabled,
+		"discord":   n.Discord.Enabled,
+		"telegram":  n.Telegram.Enabled,
+		"pagerduty": n.PagerDuty.Enabled,
+	}
+	checkChannels := func(path string, channels []string) {
+		for i, ch := range channels {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			if on, known := enabled[ch]; !known {
+				c.add(p, "unknown channel %q", ch)
+			} else if !on {
+				c.add(p, "channel %q is not enabled", ch)
+			}
+		}
+	}
+
+	if n.Routing.Enabled {
+		checkChannels("notifications.routing.default_channels", n.Routing.DefaultChannels)
+		for i, rule := range n.Routing.Rules {
+			path := fmt.Sprintf("notifications.routing.rules[%d]", i)
+			checkChannels(path+".channels", rule.Channels)
+			checkNotifyOn(c, path+".events", rule.Events)
+		}
+	}
+
+	if n.Escalation.Enabled {
+		c.required("notifications.escalation.state_file", n.Escalation.StateFile)
+		if len(n.Escalation.Levels) == 0 {
+			c.add("notifications.escalation.levels", "at least one level is required")
+		}
+		for i, level := range n.Escalation.Levels {
+			path := fmt.Sprintf("notifications.escalation.levels[%d]", i)
+			if level.After < 1 {
+				c.add(path+".after", "must be at least 1")
+			}
+			checkChannels(path+".channels", level.Channels)
+			c.oneOf(path+".severity", level.Severity, "critical", "error", "warning", "info")
+		}
+	}
+}
+
+func checkNotifyOn(c *checker, path string, events []string) {
+	for i, e := range events {
+		c.oneOf(fmt.Sprintf("%s[%d]", path, i), e, "success", "failure", "warning", "all", "*")
+	}
+}
+
+func checkEvents(c *checker, cfg *Config) {
+	e := cfg.Events
+	if !e.Enabled {
+		return
+	}
+	if e.Kafka.Enabled {
+		c.required("events.kafka.rest_proxy_url", e.Kafka.RestProxyURL)
+		c.required("events.kafka.topic", e.Kafka.Topic)
+	}
+	if e.NATS.Enabled {
+		c.required("events.nats.url", e.NATS.URL)
+	}
+	if e.SNS.Enabled {
+		c.required("events.sns.region", e.SNS.Region)
+		if !strings.HasPrefix(e.SNS.TopicARN, "arn:") {
+			c.add("events.sns.topic_arn", "must be a topic ARN, got %q", e.SNS.TopicARN)
+		}
+		if (e.SNS.AccessKey == "") != (e.SNS.SecretKey == "") {
+			c.add("events.sns.secret_key", "access_key and secret_key must be set together")
+		}
+	}
+	if !e.Kafka.Enabled && !e.NATS.Enabled && !e.SNS.Enabled {
+		c.add("events", "at least one publisher (kafka, nats, sns) must be enabled")
+	}
+}
+
+func checkHeartbeats(c *checker, cfg *Config) {
+	h := cfg.Heartbeats
+	if !h.Enabled {
+		return
+	}
+	if len(h.Checks) == 0 {
+		c.add("heartbeats.checks", "at least one check is required")
+	}
+	for name, check := range h.Checks {
+		path := "heartbeats.checks." + name
+		c.oneOf(path+".provider", check.Provider, "healthchecks", "cronitor", "generic")
+		if check.Provider == "generic" {
+			if check.StartURL == "" && check.SuccessURL == "" && check.FailureURL == "" {
+				c.add(path, "at least one of start_url, success_url, failure_url is required")
+			}
+		} else {
+			c.required(path+".url", check.URL)
+		}
+	}
+}
+
+func checkSLA(c *checker, cfg *Config) {
+	s := cfg.SLA
+	if !s.Enabled {
+		return
+	}
+	c.required("sla.state_file", s.StateFile)
+	if s.RehearsalSamples < 1 {
+		c.add("sla.rehearsal_samples", "must be at least 1")
+	}
+	for name, o := range s.Objectives {
+		if o.RPO < 0 {
+			c.add("sla.objectives."+name+".rpo", "must not be negative")
+		}
+		if o.RTO < 0 {
+			c.add("sla.objectives."+name+".rto", "must not be negative")
+		}
+	}
+}
+
+func checkFreshness(c *checker, cfg *Config) {
+	f := cfg.Freshness
+	if !f.Enabled {
+		return
+	}
+	c.required("freshness.state_file", f.StateFile)
+	if f.Tolerance < 1 {
+		c.add("freshness.tolerance", "must be at least 1, got %g", f.Tolerance)
+	}
+	if f.MinSamples < 2 {
+		c.add("freshness.min_samples", "must be at least 2, got %d", f.MinSamples)
+	}
+	if f.RepeatInterval < 0 {
+		c.add("freshness.repeat_interval", "must not be negative")
+	}
+	for name, d := range f.Expected {
+		if d <= 0 {
+			c.add("freshness.expected."+name, "must be positive")
+		}
+	}
+}
+
+func checkRunbooks(c *checker, cfg *Config) {
+	if cfg.Runbooks.Enabled {
+		c.required("runbooks.directory", cfg.Runbooks.Directory)
+	}
+}
+
+func checkDiscovery(c *checker, cfg *Config) {
+	d := cfg.Discovery
+	if !d.Enabled {
+		return
+	}
+	if d.Interval <= 0 {
+		c.add("discovery.interval", "must be positive")
+	}
+	if len(d.Servers) == 0 {
+		c.add("discovery.servers", "at least one server is required")
+	}
+	for name, s := range d.Servers {
+		path := "discovery.servers." + name
+		c.required(path+".profile", s.Profile)
+		if _, ok := cfg.Connections[strings.ToLower(s.Profile)]; s.Profile != "" && !ok {
+			c.add(path+".profile", "no connection profile %q", s.Profile)
+		}
+		c.required(path+".cron", s.Cron)
+		checkPatterns := func(field string, patterns []string) {
+			for i, pattern := range patterns {
+				if err := validPattern(pattern); err != nil {
+					c.add(fmt.Sprintf("%s.%s[%d]", path, field, i), "is not a valid pattern: %v", err)
+				}
+			}
+		}
+		checkPatterns("include", s.Include)
+		checkPatterns("exclude", s.Exclude)
+	}
+}
+
+func checkAirGap(c *checker, cfg *Config) {
+	a := cfg.AirGap
+	if !a.Enabled {
+		return
+	}
+	c.required("airgap.state_file", a.StateFile)
+	if len(a.Targets) == 0 {
+		c.add("airgap.targets", "at least one target is required")
+	}
+	for name, t := range a.Targets {
+		path := "airgap.targets." + name
+		c.oneOf(path+".type", t.Type, "directory", "s3")
+		switch t.Type {
+		case "directory":
+			c.required(path+".path", t.Path)
+		case "s3":
+			c.required(path+".s3.bucket", t.S3.Bucket)
+			c.required(path+".s3.region", t.S3.Region)
+			if t.LockMode != "" {
+				c.oneOf(path+".lock_mode", strings.ToLower(t.LockMode), "compliance", "governance")
+			}
+			if t.RetentionDays < 1 {
+				c.add(path+".retention_days", "must be at least 1")
+			}
+		}
+	}
+}
+
+func checkDR(c *checker, cfg *Config) {
+	d := cfg.DR
+	if !d.Enabled {
+		return
+	}
+	c.required("dr.state_file", d.StateFile)
+	if d.Interval <= 0 {
+		c.add("dr.interval", "must be positive")
+	}
+	if len(d.Jobs) == 0 {
+		c.add("dr.jobs", "at least one job is required")
+	}
+	for name, j := range d.Jobs {
+		path := "dr.jobs." + name
+		c.required(path+".s3.bucket", j.S3.Bucket)
+		c.required(path+".s3.region", j.S3.Region)
+		if j.RetentionDays < 0 {
+			c.add(path+".retention_days", "must not be negative")
+		}
+		if j.LockMode != "" {
+			c.oneOf(path+".lock_mode", strings.ToLower(j.LockMode), "compliance", "governance")
+			if j.RetentionDays < 1 {
+				c.add(path+".retention_days", "must be at least 1 when copies are locked")
+			}
+		}
+	}
+}
+
+func checkObservability(c *checker, cfg *Config) {
+	if cfg.Metrics.Enabled {
+		c.oneOf("metrics.backend", cfg.Metrics.Backend, "prometheus", "statsd")
+		if cfg.Metrics.Backend == "statsd" {
+			sd := cfg.Metrics.StatsD
+			c.required("metrics.statsd.address", sd.Address)
+			c.oneOf("metrics.statsd.flavor", sd.Flavor, "dogstatsd", "statsd")
+			for i, tag := range sd.Tags {
+				if !strings.Contains(tag, ":") {
+					c.add(fmt.Sprintf("metrics.statsd.tags[%d]", i), "must be key:value, got %q", tag)
+				}
+			}
+		}
+		if cfg.Metrics.Prometheus.Port < 1 || cfg.Metrics.Prometheus.Port > 65535 {
+			c.add("metrics.prometheus.port", "must be between 1 and 65535, got %d", cfg.Metrics.Prometheus.Port)
+		}
+		if cfg.Metrics.Prometheus.Path != "" && !strings.HasPrefix(cfg.Metrics.Prometheus.Path, "/") {
+			c.add("metrics.prometheus.path", "must start with /")
+		}
+		if pg := cfg.Metrics.Pushgateway; pg.Enabled {
+			c.required("metrics.pushgateway.url", pg.URL)
+			c.required("metrics.pushgateway.job", pg.Job)
+			if pg.Password != "" && pg.Username == "" {
+				c.add("metrics.pushgateway.username", "is required when password is set")
+			}
+		}
+	}
+
+	t := cfg.Tracing
+	if t.Enabled {
+		c.oneOf("tracing.provider", t.Provider, "otlp")
+		c.oneOf("tracing.sampling.type", t.Sampling.Type, "always", "never", "probability", "rate_limiting")
+		if t.Sampling.Rate < 0 || t.Sampling.Rate > 1 {
+			c.add("tracing.sampling.rate", "must be between 0.0 and 1.0, got %g", t.Sampling.Rate)
+		}
+	}
+	if (t.Enabled && t.Provider == "otlp") || t.OTLP.Metrics.Enabled || t.OTLP.Logs.Enabled {
+		c.required("tracing.otlp.endpoint", t.OTLP.Endpoint)
+	}
+	if t.OTLP.Metrics.Enabled && t.OTLP.Metrics.Interval < time.Second {
+		c.add("tracing.otlp.metrics.interval", "must be at least 1s")
+	}
+	if t.OTLP.Logs.Enabled {
+		c.oneOf("tracing.otlp.logs.level", t.OTLP.Logs.Level, "debug", "info", "warn", "error")
+	}
+}
+
+func checkSecurity(c *checker, cfg *Config) {
+	s := cfg.Security
+	switch {
+	case s.JWT.Secret == "":
+		c.add("security.jwt.secret", "is required (set DBBACKUP_SECURITY_JWT_SECRET)")
+	case len(s.JWT.Secret) < 32 && !secrets.Default().IsReference(s.JWT.Secret):
+		c.add("security.jwt.secret", "must be at least 32 characters long")
+	}
+
+	if s.RateLimiting.Enabled && s.RateLimiting.RequestsPerMinute < 1 {
+		c.add("security.rate_limiting.requests_per_minute", "must be at least 1 when rate limiting is enabled")
+	}
+
+	if l := s.Lockout; l.Enabled {
+		if l.MaxAttempts < 1 {
+			c.add("security.lockout.max_attempts", "must be at least 1")
+		}
+		if l.MaxAttemptsPerIP < 1 {
+			c.add("security.lockout.max_attempts_per_ip", "must be at least 1")
+		}
+		if l.Window <= 0 {
+			c.add("security.lockout.window", "must be positive")
+		}
+		if l.BaseDuration <= 0 {
+			c.add("security.lockout.base_duration", "must be positive")
+		}
+		if l.MaxDuration < l.BaseDuration {
+			c.add("security.lockout.max_duration", "must not be shorter than base_duration")
+		}
+		if l.SprayThreshold < 2 {
+			c.add("security.lockout.spray_threshold", "must be at least 2")
+		}
+	}
+
+	if s.OAuth2.Enabled {
+		for name, p := range s.OAuth2.Providers {
+			if !p.Enabled {
+				continue
+			}
+			c.required("security.oauth2.providers."+name+".client_id", p.ClientID)
+			c.required("security.oauth2.providers."+name+".client_secret", p.ClientSecret)
+		}
+	}
+
+	for i, pattern := range s.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			c.add(fmt.Sprintf("security.redaction.patterns[%d]", i), "is not a valid regular expression: %v", err)
+		}
+	}
+
+	if l := s.LDAP; l.Enabled {
+		c.required("security.ldap.url", l.URL)
+		c.required("security.ldap.user_base_dn", l.UserBaseDN)
+		if l.URL != "" && !strings.HasPrefix(l.URL, "ldap://") && !strings.HasPrefix(l.URL, "ldaps://") {
+			c.add("security.ldap.url", "must start with ldap:// or ldaps://, got %q", l.URL)
+		}
+		if l.StartTLS && strings.HasPrefix(l.URL, "ldaps://") {
+			c.add("security.ldap.start_tls", "cannot be combined with an ldaps:// url")
+		}
+		if !strings.Contains(l.UserFilter, "{username}") {
+			c.add("security.ldap.user_filter", "must contain {username}")
+		}
+		c.fileExists("security.ldap.ca_file", l.CAFile)
+		for group, role := range l.GroupRoles {
+			c.oneOf("security.ldap.group_roles."+group, strings.ToLower(role), "admin", "operator", "viewer")
+		}
+		c.oneOf("security.ldap.default_role", strings.ToLower(l.DefaultRole), "admin", "operator", "viewer")
+		if l.PoolSize < 1 {
+			c.add("security.ldap.pool_size", "must be at least 1")
+		}
+	}
+
+	if v := s.Secrets.Vault.KVVersion; v != 0 && v != 1 && v != 2 {
+		c.add("security.secrets.vault.kv_version", "must be 1 or 2, got %d", v)
+	}
+	if s.Secrets.Timeout < 0 {
+		c.add("security.secrets.timeout", "must not be negative")
+	}
+
+	if s.Quarantine.Enabled {
+		c.required("security.quarantine.state_file", s.Quarantine.StateFile)
+	}
+
+	if s.Sessions.Enabled {
+		c.oneOf("security.sessions.backend", strings.ToLower(s.Sessions.Backend), "memory", "redis", "database")
+		if strings.EqualFold(s.Sessions.Backend, "database") {
+			c.required("database.metadata.type", cfg.Database.Metadata.Type)
+		}
+	}
+
+	checkCrypto(c, cfg)
+
+	if a := s.Audit; a.Enabled {
+		c.required("security.audit.log_file", a.LogFile)
+		if e := a.Export; e.Enabled {
+			c.required("security.audit.export.state_file", e.StateFile)
+			c.required("security.audit.export.signing_key_file", e.SigningKeyFile)
+			c.fileExists("security.audit.export.signing_key_file", e.SigningKeyFile)
+			if e.S3.Bucket == "" && e.Directory == "" {
+				c.add("security.audit.export", "needs s3.bucket or directory when enabled")
+			}
+			if e.S3.Bucket != "" {
+				c.required("security.audit.export.s3.region", e.S3.Region)
+			}
+			c.oneOf("security.audit.export.lock_mode", strings.ToLower(e.LockMode), "compliance", "governance")
+			if e.RetentionDays < 1 {
+				c.add("security.audit.export.retention_days", "must be at least 1")
+			}
+			if e.Interval < time.Minute {
+				c.add("security.audit.export.interval", "must be at least 1m")
+			}
+		}
+	}
+
+	if p := s.Policy; p.Enabled {
+		checkPolicy(c, p)
+	}
+
+	if m := s.Malware; m.Enabled {
+		c.required("security.malware.state_file", m.StateFile)
+		if m.Clamd.Address == "" && len(m.YARA.Rules) == 0 {
+			c.add("security.malware", "needs clamd.address and/or yara.rules when enabled")
+		}
+		for i, rules := range m.YARA.Rules {
+			c.fileExists(fmt.Sprintf("security.malware.yara.rules[%d]", i), rules)
+		}
+	}
+
+	if b := s.Ransomware.Baseline; b.Enabled {
+		c.required("security.ransomware.baseline.state_file", b.StateFile)
+		if b.Window < 2 {
+			c.add("security.ransomware.baseline.window", "must be at least 2")
+		}
+		if b.MinSamples < 2 || b.MinSamples > b.Window {
+			c.add("security.ransomware.baseline.min_samples", "must be between 2 and window (%d), got %d", b.Window, b.MinSamples)
+		}
+		if b.Threshold <= 0 {
+			c.add("security.ransomware.baseline.threshold", "must be positive")
+		}
+		if b.MinEntropyChange < 0 || b.MinEntropyChange > 8 {
+			c.add("security.ransomware.baseline.min_entropy_change", "must be between 0 and 8 bits per byte")
+		}
+		if b.MinRatioChange < 0 {
+			c.add("security.ransomware.baseline.min_ratio_change", "must not be negative")
+		}
+		if b.SampleSize != "" {
+			if _, err := utils.ParseBytes(b.SampleSize); err != nil {
+				c.add("security.ransomware.baseline.sample_size", "%v", err)
+			}
+		}
+	}
+}
+
+// checkPolicy validates the authorization rules
+func checkPolicy(c *checker, p PolicyConfig) {
+	c.oneOf("security.policy.default_effect", strings.ToLower(p.DefaultEffect), "allow", "deny")
+	for i, r := range p.Rules {
+		path := fmt.Sprintf("security.policy.rules[%d]", i)
+		c.required(path+".effect", r.Effect)
+		c.oneOf(path+".effect", strings.ToLower(r.Effect), "allow", "deny")
+		if len(r.Actions) == 0 {
+			c.add(path+".actions", "must name at least one action")
+		}
+		for j, cidr := range r.When.SourceCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				c.add(fmt.Sprintf("%s.when.source_cidrs[%d]", path, j), "%v", err)
+			}
+		}
+		for j, day := range r.When.Days {
+			c.oneOf(fmt.Sprintf("%s.when.days[%d]", path, j), strings.ToLower(day), "mon", "tue", "wed", "thu", "fri", "sat", "sun")
+		}
+		if h := r.When.Hours; h != "" {
+			from, to, ok := strings.Cut(h, "-")
+			_, errFrom := time.Parse("15:04", strings.TrimSpace(from))
+			_, errTo := time.Parse("15:04", strings.TrimSpace(to))
+			if !ok || errFrom != nil || errTo != nil {
+				c.add(path+".when.hours", "must look like 09:00-18:00, got %q", h)
+			}
+		}
+		if tz := r.When.Timezone; tz != "" {
+			if _, err := time.LoadLocation(tz); err != nil {
+				c.add(path+".when.timezone", "%v", err)
+			}
+		}
+	}
+}
+
+// checkCrypto refuses configurations the crypto policy does not allow
+func checkCrypto(c *checker, cfg *Config) {
+	cc := cfg.Security.Crypto
+	if !cryptopolicy.Valid(cc.Policy) {
+		c.add("security.crypto.policy", "must be one of default|fips, got %q", cc.Policy)
+		return
+	}
+	if cc.RequireFIPSModule && !cryptopolicy.ModuleEnabled() {
+		c.add("security.crypto.require_fips_module", "Go's FIPS 140 module is not active (run with GODEBUG=fips140=on)")
+	}
+	if !strings.EqualFold(cc.Policy, cryptopolicy.FIPS) {
+		return
+	}
+
+	if e := cfg.Backup.Encryption; e.Enabled {
+		if err := cryptopolicy.CheckCipher(cc.Policy, e.Algorithm); err != nil {
+			c.add("backup.encryption.algorithm", "%v", err)
+		}
+	}
+	// Unverified TLS defeats approved ciphers
+	if cfg.Security.LDAP.Enabled && cfg.Security.LDAP.InsecureSkipVerify {
+		c.add("security.ldap.insecure_skip_verify", "is not allowed under the fips crypto policy")
+	}
+	if cfg.Notifications.Email.Enabled && cfg.Notifications.Email.InsecureSkipVerify {
+		c.add("notifications.email.insecure_skip_verify", "is not allowed under the fips crypto policy")
+	}
+}
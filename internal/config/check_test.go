@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// minimalConfig is the smallest configuration Check accepts, with local
+// storage under dir
+func minimalConfig(dir string) string {
+	return `storage:
+  providers:
+    local:
+      enabled: true
+      path: ` + filepath.Join(dir, "backups") + `
+backup:
+  temp_directory: ` + filepath.Join(dir, "tmp") + `
+security:
+  jwt:
+    secret: 0123456789abcdef0123456789abcdef
+`
+}
+
+func TestCheckMinimal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(writeFiles(t, map[string]string{"config.yaml": minimalConfig(dir)}), "config.yaml")
+	cfg, err := Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Check(cfg); len(errs) != 0 {
+		t.Fatalf("Check() = %v", errs)
+	}
+	// Check never touches the filesystem
+	if _, err := os.Stat(filepath.Join(dir, "backups")); !os.IsNotExist(err) {
+		t.Error("Check created the storage directory")
+	}
+}
+
+func TestCheckReportsEveryField(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := Parse(filepath.Join(writeFiles(t, map[string]string{"config.yaml": minimalConfig(dir)}), "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Server.Port = 0
+	cfg.Server.TLS.Enabled = true
+	cfg.Server.TLS.CertFile = filepath.Join(dir, "missing.pem")
+	cfg.Backup.ParallelOperations = 0
+	cfg.Backup.Encryption.Enabled = true
+	cfg.Storage.Providers.Local.Enabled = false
+	cfg.Security.JWT.Secret = "changeme"
+
+	want := map[string]string{
+		"server.port":                "must be between 1 and 65535, got 0",
+		"server.tls.cert_file":       "file not found: " + cfg.Server.TLS.CertFile,
+		"server.tls.key_file":        "is required",
+		"backup.parallel_operations": "must be at least 1",
+		"backup.encryption.key_file": "is required",
+		"security.jwt.secret":        "must be at least 32 characters long",
+	}
+	got := map[string]string{}
+	for _, fe := range Check(cfg) {
+		if _, seen := got[fe.Path]; !seen {
+			got[fe.Path] = fe.Message
+		}
+	}
+	for path, msg := range want {
+		if got[path] != msg {
+			t.Errorf("%s: %q, want %q", path, got[path], msg)
+		}
+	}
+	if _, ok := got["storage.providers"]; !ok {
+		t.Errorf("no error for storage without providers: %v", got)
+	}
+}
+
+// Load rejects exactly what Check reports, and only then creates the
+// directories the configuration needs
+func TestLoadUsesCheck(t *testing.T) {
+	dir := t.TempDir()
+	bad := strings.Replace(minimalConfig(dir), "0123456789abcdef0123456789abcdef", "short", 1)
+	path := filepath.Join(writeFiles(t, map[string]string{"config.yaml": bad}), "config.yaml")
+	cfg, err := Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked := Check(cfg)
+
+	_, err = Load(path)
+	var errs FieldErrors
+	if !errors.As(err, &errs) || errs.Error() != checked.Error() {
+		t.Fatalf("Load() = %v, Check() = %v", err, checked)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "backups")); !os.IsNotExist(err) {
+		t.Error("invalid config created the storage directory")
+	}
+
+	path = filepath.Join(writeFiles(t, map[string]string{"config.yaml": minimalConfig(dir)}), "config.yaml")
+	if _, err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []string{"backups", "tmp"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			t.Errorf("%s not created: %v", sub, err)
+		}
+	}
+}
@@ -2,41 +2,121 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
 	"github.com/spf13/viper"
+	"github.com/sanskarpan/db-backup/internal/database/plugin"
 	"github.com/sanskarpan/db-backup/internal/logger"
+	"github.com/sanskarpan/db-backup/internal/security/cryptopolicy"
+	"github.com/sanskarpan/db-backup/pkg/redact"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
 )
 
 // Config represents the complete application configuration
 type Config struct {
-	Server        ServerConfig        `mapstructure:"server"`
-	Database      DatabaseConfig      `mapstructure:"database"`
-	Logging       logger.Config       `mapstructure:"logging"`
-	Backup        BackupConfig        `mapstructure:"backup"`
-	Storage       StorageConfig       `mapstructure:"storage"`
-	Notifications NotificationConfig  `mapstructure:"notifications"`
-	Metrics       MetricsConfig       `mapstructure:"metrics"`
-	Tracing       TracingConfig       `mapstructure:"tracing"`
-	Security      SecurityConfig      `mapstructure:"security"`
+	Server        ServerConfig                 `mapstructure:"server"`
+	Database      DatabaseConfig               `mapstructure:"database"`
+	Connections   map[string]ConnectionProfile `mapstructure:"connections"`
+	Logging       logger.Config                `mapstructure:"logging"`
+	Backup        BackupConfig                 `mapstructure:"backup"`
+	Storage       StorageConfig                `mapstructure:"storage"`
+	Notifications NotificationConfig           `mapstructure:"notifications"`
+	Events        EventsConfig                 `mapstructure:"events"`
+	Heartbeats    HeartbeatConfig              `mapstructure:"heartbeats"`
+	SLA           SLAConfig                    `mapstructure:"sla"`
+	Freshness     FreshnessConfig              `mapstructure:"freshness"`
+	Runbooks      RunbookConfig                `mapstructure:"runbooks"`
+	Discovery     DiscoveryConfig              `mapstructure:"discovery"`
+	AirGap        AirGapConfig                 `mapstructure:"airgap"`
+	DR            DRConfig                     `mapstructure:"dr"`
+	Metrics       MetricsConfig                `mapstructure:"metrics"`
+	Tracing       TracingConfig                `mapstructure:"tracing"`
+	Security      SecurityConfig               `mapstructure:"security"`
+	Agent         AgentConfig                  `mapstructure:"agent"`
+	Docker        DockerConfig                 `mapstructure:"docker"`
+	Kubernetes    KubernetesConfig             `mapstructure:"kubernetes"`
+	Plugins       PluginsConfig                `mapstructure:"plugins"`
+
+	// secretFiles maps secret settings read from a <key>_file to the file
+	secretFiles map[string]string
 }
 
 // ServerConfig holds server configuration
 type ServerConfig struct {
-	Host string    `mapstructure:"host"`
-	Port int       `mapstructure:"port"`
-	Mode string    `mapstructure:"mode"` // development, production
-	TLS  TLSConfig `mapstructure:"tls"`
+	Host   string         `mapstructure:"host"`
+	Port   int            `mapstructure:"port"`
+	Mode   string         `mapstructure:"mode"` // development, production
+	TLS    TLSConfig      `mapstructure:"tls"`
+	Agents AgentHubConfig `mapstructure:"agents"`
 }
 
 // TLSConfig holds TLS configuration
 type TLSConfig struct {
-	Enabled  bool   `mapstructure:"enabled"`
-	CertFile string `mapstructure:"cert_file"`
-	KeyFile  string `mapstructure:"key_file"`
+	Enabled      bool     `mapstructure:"enabled"`
+	CertFile     string   `mapstructure:"cert_file"`
+	KeyFile      string   `mapstructure:"key_file"`
+	ClientAuth   string   `mapstructure:"client_auth"`    // none, optional, require
+	ClientCAFile string   `mapstructure:"client_ca_file"` // CA bundle client certificates must chain to
+	CRLFile      string   `mapstructure:"crl_file"`       // PEM or DER revocation list, reloaded when it changes
+	AllowedNames []string `mapstructure:"allowed_names"`  // client certificate CNs or DNS SANs allowed in; empty allows any
+}
+
+// AgentHubConfig configures the gRPC listener remote agents connect to.
+// Agents must present a client certificate chaining to tls.client_ca_file,
+// and are known by its name; tls.client_auth is always require.
+type AgentHubConfig struct {
+	Enabled bool      `mapstructure:"enabled"`
+	Listen  string    `mapstructure:"listen"`
+	TLS     TLSConfig `mapstructure:"tls"`
+}
+
+// AgentConfig configures `db-backup agent`, which runs dumps next to a
+// database for a server that cannot reach it. The agent dials out, so only
+// the server's agent port has to be reachable.
+type AgentConfig struct {
+	Server            string        `mapstructure:"server"`      // host:port of the server's agent listener
+	ServerName        string        `mapstructure:"server_name"` // expected in the server certificate, defaults to the host
+	CAFile            string        `mapstructure:"ca_file"`
+	CertFile          string        `mapstructure:"cert_file"`
+	KeyFile           string        `mapstructure:"key_file"`
+	Profiles          []string      `mapstructure:"profiles"` // connection profiles served, empty for all
+	MaxConcurrent     int           `mapstructure:"max_concurrent"`
+	ChunkSize         string        `mapstructure:"chunk_size"`
+	ReconnectInterval time.Duration `mapstructure:"reconnect_interval"`
+}
+
+// DockerConfig configures discovery of database containers, which are
+// dumped with docker exec using the tools in their image
+type DockerConfig struct {
+	Host   string   `mapstructure:"host"`   // unix:// or tcp:// daemon address, defaults to DOCKER_HOST or the local socket
+	Labels []string `mapstructure:"labels"` // only containers with all of these labels, "key" or "key=value"
+}
+
+// KubernetesConfig configures discovery of database pods, which are dumped
+// through a pod exec and optionally captured as CSI VolumeSnapshots
+type KubernetesConfig struct {
+	Kubeconfig      string        `mapstructure:"kubeconfig"`     // in-cluster service account, $KUBECONFIG or ~/.kube/config when empty
+	Context         string        `mapstructure:"context"`        // kubeconfig context, the current one when empty
+	Namespace       string        `mapstructure:"namespace"`      // empty for all namespaces
+	LabelSelector   string        `mapstructure:"label_selector"` // only pods matching it, e.g. "db-backup.enable=true"
+	SnapshotClass   string        `mapstructure:"snapshot_class"` // VolumeSnapshotClass, the cluster default when empty
+	SnapshotTimeout time.Duration `mapstructure:"snapshot_timeout"`
+}
+
+// PluginsConfig configures database drivers and storage providers shipped
+// as separate executables. Every dbbackup-driver-<type> in Directory is
+// registered at startup as the driver of <type>; a dbbackup-storage-<name>
+// serves the provider enabled under storage.providers.plugins.<name>.
+type PluginsConfig struct {
+	Directory        string        `mapstructure:"directory"`         // a missing directory holds no plugins
+	HandshakeTimeout time.Duration `mapstructure:"handshake_timeout"` // for a started plugin to announce its socket
 }
 
 // DatabaseConfig holds database configuration for metadata storage
@@ -65,15 +145,169 @@ type RedisConfig struct {
 	DB       int    `mapstructure:"db"`
 }
 
+// ConnectionProfile describes a database backed up and restored by name.
+// Backups and restores log in with separate credentials, so the principal
+// that runs unattended backups needs read access only and never holds
+// write or DDL rights on the database.
+type ConnectionProfile struct {
+	Type     string                `mapstructure:"type"` // mysql, postgres, mongodb, sqlite, redis, clickhouse, cockroachdb, mariadb, etcd, influxdb, neo4j, oracle, duckdb, couchbase, tidb, dynamodb
+	Host     string                `mapstructure:"host"` // or a unix socket path, e.g. /var/run/postgresql
+	Port     int                   `mapstructure:"port"`
+	Database string                `mapstructure:"database"`
+	SSLMode  string                `mapstructure:"ssl_mode"` // disable, require, verify-ca or verify-full
+	SSLCA    string                `mapstructure:"ssl_ca"`   // CA certificate verifying the server
+	SSLCert  string                `mapstructure:"ssl_cert"` // client certificate
+	SSLKey   string                `mapstructure:"ssl_key"`  // key of the client certificate, when not in ssl_cert
+	Backup   ConnectionCredentials `mapstructure:"backup"`
+	Restore  ConnectionCredentials `mapstructure:"restore"`
+}
+
+// ConnectionCredentials is a login. Restore logins may assume a role after
+// login, so the login itself can be kept without privileges.
+type ConnectionCredentials struct {
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Role     string `mapstructure:"role"` // restore only: PostgreSQL or MySQL role holding write and DDL rights
+}
+
 // BackupConfig holds backup configuration
 type BackupConfig struct {
-	DefaultCompression string            `mapstructure:"default_compression"`
-	CompressionLevel   int               `mapstructure:"compression_level"`
-	Encryption         EncryptionConfig  `mapstructure:"encryption"`
-	Retention          RetentionConfig   `mapstructure:"retention"`
-	TempDirectory      string            `mapstructure:"temp_directory"`
-	MetadataDirectory  string            `mapstructure:"metadata_directory"`
-	ParallelOperations int               `mapstructure:"parallel_operations"`
+	DefaultCompression string                   `mapstructure:"default_compression"`
+	CompressionLevel   int                      `mapstructure:"compression_level"`
+	Encryption         EncryptionConfig         `mapstructure:"encryption"`
+	Retention          RetentionConfig          `mapstructure:"retention"`
+	TempDirectory      string                   `mapstructure:"temp_directory"`
+	MetadataDirectory  string                   `mapstructure:"metadata_directory"`
+	ParallelOperations int                      `mapstructure:"parallel_operations"`
+	MaxMemory          string                   `mapstructure:"max_memory"`  // cap on the buffers held by artifact copies, e.g. "256MB"
+	BufferSize         string                   `mapstructure:"buffer_size"` // size of each copy buffer, e.g. "1MB"
+	Pipeline           PipelineConfig           `mapstructure:"pipeline"`
+	AutoTune           AutoTuneConfig           `mapstructure:"auto_tune"`
+	Verify             VerifyConfig             `mapstructure:"verify"`
+	DiskWatchdog       DiskWatchdogConfig       `mapstructure:"disk_watchdog"`
+	SchemaSnapshots    SchemaSnapshotConfig     `mapstructure:"schema_snapshots"`
+	Quotas             QuotaConfig              `mapstructure:"quotas"`
+	RetentionOptimizer RetentionOptimizerConfig `mapstructure:"retention_optimizer"`
+	VSS                string                   `mapstructure:"vss"` // auto, always or never: read SQLite files from a shadow copy on Windows
+	DatabaseDefaults   []DatabaseDefaults       `mapstructure:"database_defaults"`
+}
+
+// DatabaseDefaults sets backup settings for the databases it matches.
+// They apply under the options given for a backup, and every matching
+// entry applies, later entries over earlier ones. Empty match fields match
+// everything; patterns use shell glob syntax, and unset settings keep the
+// value from before.
+type DatabaseDefaults struct {
+	Databases         []string          `mapstructure:"databases"`      // e.g. ["prod-*", "orders"]
+	DatabaseTypes     []string          `mapstructure:"database_types"` // e.g. ["postgres"]
+	Tags              map[string]string `mapstructure:"tags"`           // all must match; values may be globs
+	Compression       string            `mapstructure:"compression"`    // gzip, zstd, lz4 or none
+	CompressionLevel  int               `mapstructure:"compression_level"`
+	Encrypt           *bool             `mapstructure:"encrypt"`
+	EncryptionKeyFile string            `mapstructure:"encryption_key_file"` // backup.encryption.key_file when unset
+	Storage           string            `mapstructure:"storage"`             // storage provider, e.g. s3
+	Retention         RetentionConfig   `mapstructure:"retention"`
+}
+
+// PipelineConfig holds the workers of each backup stage when many
+// databases are backed up in one run, and how many backups of one
+// database server may be in flight at once
+type PipelineConfig struct {
+	DumpWorkers     int `mapstructure:"dump_workers"`
+	CompressWorkers int `mapstructure:"compress_workers"`
+	EncryptWorkers  int `mapstructure:"encrypt_workers"`
+	UploadWorkers   int `mapstructure:"upload_workers"`
+	IndexWorkers    int `mapstructure:"index_workers"`
+	MaxPerHost      int `mapstructure:"max_per_host"` // 0 means no cap
+}
+
+// AutoTuneConfig holds the tuning of zstd compression while a backup
+// runs: the level drops when the machine's CPUs are saturated and rises
+// when compression waits on the upload with cores to spare, and encoder
+// workers are added while compression is the slowest stage
+type AutoTuneConfig struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	MinLevel   int           `mapstructure:"min_level"`   // 1-9, like compression_level
+	MaxLevel   int           `mapstructure:"max_level"`   // 1-9
+	MaxWorkers int           `mapstructure:"max_workers"` // encoder workers; 0 means one per CPU
+	TargetCPU  float64       `mapstructure:"target_cpu"`  // CPU utilisation above which the level drops, 0-1
+	Interval   time.Duration `mapstructure:"interval"`
+}
+
+// VerifyConfig holds how many backups are verified at once. Each storage
+// provider gets its own cap on verifications in flight and on how many
+// start per second, so one slow or throttled provider cannot hold up the
+// others. Verifications not started within window of the run's start are
+// skipped, to keep fleet-wide runs inside a maintenance window.
+type VerifyConfig struct {
+	Workers        int                          `mapstructure:"workers"`
+	MaxPerProvider int                          `mapstructure:"max_per_provider"` // 0 means no cap
+	Window         time.Duration                `mapstructure:"window"`           // 0 means no limit
+	Providers      map[string]VerifyLimitConfig `mapstructure:"providers"`        // by provider: s3, gcs, azure, local
+}
+
+// VerifyLimitConfig holds the limits of one storage provider
+type VerifyLimitConfig struct {
+	MaxConcurrent int     `mapstructure:"max_concurrent"` // overrides max_per_provider
+	MaxPerSecond  float64 `mapstructure:"max_per_second"` // verifications started per second; 0 means no limit
+}
+
+// DiskWatchdogConfig holds free-space monitoring for the temp directory
+// and local storage, and cleanup of temp files abandoned by crashed jobs
+type DiskWatchdogConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	Interval       time.Duration `mapstructure:"interval"`
+	MinFreePercent float64       `mapstructure:"min_free_percent"` // alert below this share of free space
+	MinFreeSpace   string        `mapstructure:"min_free_space"`   // alert below this many free bytes, e.g. "5GB"
+	TempMaxAge     time.Duration `mapstructure:"temp_max_age"`     // temp entries untouched this long are removed; 0 disables cleanup
+	Paths          []string      `mapstructure:"paths"`            // extra paths to monitor
+}
+
+// SchemaSnapshotConfig holds the schema-only snapshots taken before each
+// backup, stored once per distinct schema
+type SchemaSnapshotConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Directory string `mapstructure:"directory"` // defaults to "schemas" in the metadata directory
+}
+
+// QuotaConfig holds the storage quotas of tenants and databases, checked
+// when a backup starts: a backup warns once usage reaches warn_percent of
+// a limit, and is refused at the limit
+type QuotaConfig struct {
+	WarnPercent float64      `mapstructure:"warn_percent"`
+	Limits      []QuotaLimit `mapstructure:"limits"`
+}
+
+// QuotaLimit caps the storage of the backups it matches, matched like
+// database_defaults by their database, type and tags. Every matching limit
+// applies; a tenant is typically the backups sharing a tag.
+type QuotaLimit struct {
+	Name          string            `mapstructure:"name"` // tenant or label usage is reported under
+	Databases     []string          `mapstructure:"databases"`
+	DatabaseTypes []string          `mapstructure:"database_types"`
+	Tags          map[string]string `mapstructure:"tags"`        // e.g. {tenant: acme}
+	MaxSize       string            `mapstructure:"max_size"`    // stored bytes, e.g. "500GB"
+	MaxBackups    int               `mapstructure:"max_backups"` // 0 means no cap
+}
+
+// RetentionOptimizerConfig holds what "db-backup retention optimize" weighs
+// retention and storage tiers against: a monthly storage budget, the price
+// tables of the storage providers and how old the backups restored in the
+// past were
+type RetentionOptimizerConfig struct {
+	Mode           string                        `mapstructure:"mode"`            // advisory only recommends, apply also applies the retention changes
+	MonthlyBudget  float64                       `mapstructure:"monthly_budget"`  // in the currency of prices
+	RestoreHistory string                        `mapstructure:"restore_history"` // defaults to restores.jsonl in the metadata directory
+	Coverage       float64                       `mapstructure:"coverage"`        // share of past restores that must stay in the hot tier
+	Prices         map[string][]StorageTierPrice `mapstructure:"prices"`          // by storage provider, hottest tier first
+}
+
+// StorageTierPrice is the price of one storage tier of a provider
+type StorageTierPrice struct {
+	Tier           string  `mapstructure:"tier"` // e.g. STANDARD or GLACIER_IR
+	PerGBMonth     float64 `mapstructure:"per_gb_month"`
+	RetrievalPerGB float64 `mapstructure:"retrieval_per_gb"`
+	MinDays        int     `mapstructure:"min_days"` // minimum storage duration billed
 }
 
 // EncryptionConfig holds encryption configuration
@@ -100,7 +334,7 @@ type VaultConfig struct {
 // KeyRotationConfig holds key rotation configuration
 type KeyRotationConfig struct {
 	Enabled          bool   `mapstructure:"enabled"`
-	RotationInterval string `mapstructure:"rotation_interval"` // e.g., "720h" (30 days)
+	Interval         string `mapstructure:"interval"` // e.g., "720h" (30 days)
 	AutoRotate       bool   `mapstructure:"auto_rotate"`
 	ReencryptOnRotate bool  `mapstructure:"reencrypt_on_rotate"`
 }
@@ -116,6 +350,30 @@ type RetentionConfig struct {
 type StorageConfig struct {
 	DefaultProvider string                 `mapstructure:"default_provider"`
 	Providers       StorageProviders       `mapstructure:"providers"`
+	Upload          UploadConfig           `mapstructure:"upload"`
+	Cache           CacheConfig            `mapstructure:"cache"`
+}
+
+// UploadConfig holds how dumps are uploaded while they are produced: the
+// stream is cut into parts of part_size, up to concurrency of which are
+// uploaded at once. Memory use is (concurrency+1) * part_size. Uploads of
+// finished artifacts journal their progress in state_directory so they
+// resume after an interruption; uploads without progress for
+// abandon_after are aborted provider-side.
+type UploadConfig struct {
+	PartSize       string        `mapstructure:"part_size"` // e.g. "16MB"; S3 requires at least 5MB
+	Concurrency    int           `mapstructure:"concurrency"`
+	StateDirectory string        `mapstructure:"state_directory"`
+	AbandonAfter   time.Duration `mapstructure:"abandon_after"` // 0 keeps abandoned uploads
+}
+
+// CacheConfig holds the local cache of downloaded artifacts, which spares
+// repeated verifications and restore rehearsals of one backup a download
+// from cold storage each
+type CacheConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Directory string `mapstructure:"directory"`
+	MaxSize   string `mapstructure:"max_size"` // e.g. "10GB"; least recently used artifacts are evicted beyond it
 }
 
 // StorageProviders holds all storage provider configurations
@@ -124,6 +382,8 @@ type StorageProviders struct {
 	GCS   GCSConfig   `mapstructure:"gcs"`
 	Azure AzureConfig `mapstructure:"azure"`
 	Local LocalConfig `mapstructure:"local"`
+	// Providers served by plugins, by the <name> of their executable
+	Plugins map[string]StoragePluginConfig `mapstructure:"plugins"`
 }
 
 // S3Config holds AWS S3 configuration
@@ -153,6 +413,12 @@ type AzureConfig struct {
 	Container   string `mapstructure:"container"`
 }
 
+// StoragePluginConfig holds a storage provider served by a plugin
+type StoragePluginConfig struct {
+	Enabled bool              `mapstructure:"enabled"`
+	Options map[string]string `mapstructure:"options"` // passed to the plugin's Configure
+}
+
 // LocalConfig holds local storage configuration
 type LocalConfig struct {
 	Enabled bool   `mapstructure:"enabled"`
@@ -161,9 +427,67 @@ type LocalConfig struct {
 
 // NotificationConfig holds notification configuration
 type NotificationConfig struct {
-	Slack   SlackConfig   `mapstructure:"slack"`
-	Email   EmailConfig   `mapstructure:"email"`
-	Webhook WebhookConfig `mapstructure:"webhook"`
+	Slack      SlackConfig             `mapstructure:"slack"`
+	Email      EmailConfig             `mapstructure:"email"`
+	Webhook    WebhookConfig           `mapstructure:"webhook"`
+	Discord    DiscordConfig           `mapstructure:"discord"`
+	Telegram   TelegramConfig          `mapstructure:"telegram"`
+	PagerDuty  PagerDutyConfig         `mapstructure:"pagerduty"`
+	Queue      NotificationQueueConfig `mapstructure:"queue"`
+	Routing    RoutingConfig           `mapstructure:"routing"`
+	Escalation EscalationConfig        `mapstructure:"escalation"`
+}
+
+// EscalationConfig holds the escalation policy for repeated failures.
+// Consecutive failures are counted per database and schedule and reset on
+// the next success.
+type EscalationConfig struct {
+	Enabled   bool              `mapstructure:"enabled"`
+	StateFile string            `mapstructure:"state_file"`
+	Levels    []EscalationLevel `mapstructure:"levels"`
+}
+
+// EscalationLevel applies once a database has failed After times in a row.
+// The highest matching level wins.
+type EscalationLevel struct {
+	After    int      `mapstructure:"after"`
+	Channels []string `mapstructure:"channels"` // added to the routed channels
+	Severity string   `mapstructure:"severity"` // critical, error, warning, info
+	Mentions []string `mapstructure:"mentions"` // e.g. "@oncall", "<@&role-id>"
+}
+
+// RoutingConfig holds notification routing rules. Rules are evaluated in
+// order; the first matching rule selects the channels unless it sets
+// continue, in which case later matching rules add their channels too.
+type RoutingConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	DefaultChannels []string      `mapstructure:"default_channels"` // when no rule matches; empty means all
+	Rules           []RoutingRule `mapstructure:"rules"`
+}
+
+// RoutingRule routes matching notifications to a set of channels. Empty
+// match fields match everything; patterns use shell glob syntax.
+type RoutingRule struct {
+	Name          string            `mapstructure:"name"`
+	Databases     []string          `mapstructure:"databases"`      // e.g. ["prod-*", "orders"]
+	DatabaseTypes []string          `mapstructure:"database_types"` // e.g. ["postgres"]
+	Schedules     []string          `mapstructure:"schedules"`
+	Tags          map[string]string `mapstructure:"tags"` // all must match; values may be globs
+	Events        []string          `mapstructure:"events"`
+	Channels      []string          `mapstructure:"channels"`
+	Continue      bool              `mapstructure:"continue"`
+}
+
+// NotificationQueueConfig holds the persistent delivery queue configuration.
+// Failed deliveries are retried with exponential backoff and moved to the
+// dead-letter list after MaxAttempts.
+type NotificationQueueConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	Directory      string        `mapstructure:"directory"`
+	MaxAttempts    int           `mapstructure:"max_attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+	PollInterval   time.Duration `mapstructure:"poll_interval"`
 }
 
 // SlackConfig holds Slack notification configuration
@@ -176,13 +500,44 @@ type SlackConfig struct {
 
 // EmailConfig holds email notification configuration
 type EmailConfig struct {
-	Enabled  bool     `mapstructure:"enabled"`
-	SMTPHost string   `mapstructure:"smtp_host"`
-	SMTPPort int      `mapstructure:"smtp_port"`
-	Username string   `mapstructure:"username"`
-	Password string   `mapstructure:"password"`
-	From     string   `mapstructure:"from"`
-	To       []string `mapstructure:"to"`
+	Enabled            bool              `mapstructure:"enabled"`
+	SMTPHost           string            `mapstructure:"smtp_host"`
+	SMTPPort           int               `mapstructure:"smtp_port"`
+	Username           string            `mapstructure:"username"`
+	Password           string            `mapstructure:"password"`
+	From               string            `mapstructure:"from"`
+	To                 []string          `mapstructure:"to"`
+	TLSMode            string            `mapstructure:"tls_mode"`    // starttls, tls (implicit), none
+	AuthMethod         string            `mapstructure:"auth_method"` // plain, login, xoauth2, none
+	InsecureSkipVerify bool              `mapstructure:"insecure_skip_verify"`
+	OAuth2             EmailOAuth2Config `mapstructure:"oauth2"`
+	Attach             []string          `mapstructure:"attach"` // verification_report, manifest
+	MaxAttachmentSize  int64             `mapstructure:"max_attachment_size"`
+	Digest             DigestConfig      `mapstructure:"digest"`
+}
+
+// EmailOAuth2Config holds XOAUTH2 credentials for Office 365 and Gmail.
+// Either a static access token or a refresh token flow can be used.
+type EmailOAuth2Config struct {
+	AccessToken  string   `mapstructure:"access_token"`
+	TokenURL     string   `mapstructure:"token_url"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	RefreshToken string   `mapstructure:"refresh_token"`
+	Scopes       []string `mapstructure:"scopes"`
+}
+
+// DigestConfig holds email digest configuration. When enabled, email
+// notifications are batched and sent as a single summary on a schedule
+// instead of one email per event.
+type DigestConfig struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	Frequency        string        `mapstructure:"frequency"` // daily, weekly
+	SendAt           string        `mapstructure:"send_at"`   // HH:MM, local time
+	Weekday          string        `mapstructure:"weekday"`   // for weekly digests
+	SpoolFile        string        `mapstructure:"spool_file"`
+	ExpirationWindow time.Duration `mapstructure:"expiration_window"`
+	Subject          string        `mapstructure:"subject"`
 }
 
 // WebhookConfig holds webhook notification configuration
@@ -191,12 +546,230 @@ type WebhookConfig struct {
 	URL     string            `mapstructure:"url"`
 	Method  string            `mapstructure:"method"`
 	Headers map[string]string `mapstructure:"headers"`
+	// Secret enables HMAC-SHA256 signing of "<timestamp>.<body>"
+	Secret          string             `mapstructure:"secret"`
+	SignatureHeader string             `mapstructure:"signature_header"`
+	Template        string             `mapstructure:"template"`      // Go template rendering the JSON payload
+	TemplateFile    string             `mapstructure:"template_file"` // alternative to template
+	SuccessCodes    []int              `mapstructure:"success_codes"` // default: any 2xx
+	Timeout         time.Duration      `mapstructure:"timeout"`
+	Retry           WebhookRetryConfig `mapstructure:"retry"`
+	NotifyOn        []string           `mapstructure:"notify_on"`
 }
 
+// WebhookRetryConfig controls retries of failed webhook requests. Network
+// errors, 429 and 5xx responses are retried with exponential backoff.
+type WebhookRetryConfig struct {
+	MaxAttempts    int           `mapstructure:"max_attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+}
+
+// DiscordConfig holds Discord webhook notification configuration
+type DiscordConfig struct {
+	Enabled          bool     `mapstructure:"enabled"`
+	WebhookURL       string   `mapstructure:"webhook_url"`
+	Username         string   `mapstructure:"username"`
+	AvatarURL        string   `mapstructure:"avatar_url"`
+	MentionOnFailure string   `mapstructure:"mention_on_failure"` // e.g. "<@&role-id>"
+	NotifyOn         []string `mapstructure:"notify_on"`
+}
+
+// TelegramConfig holds Telegram bot notification configuration
+type TelegramConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	BotToken      string   `mapstructure:"bot_token"`
+	ChatID        string   `mapstructure:"chat_id"`
+	ThreadID      int      `mapstructure:"thread_id"` // Forum topic, optional
+	APIURL        string   `mapstructure:"api_url"`   // Override for self-hosted Bot API servers
+	SilentSuccess bool     `mapstructure:"silent_success"`
+	NotifyOn      []string `mapstructure:"notify_on"`
+}
+
+// PagerDutyConfig holds PagerDuty Events API v2 configuration
+type PagerDutyConfig struct {
+	Enabled          bool     `mapstructure:"enabled"`
+	RoutingKey       string   `mapstructure:"routing_key"`
+	Severity         string   `mapstructure:"severity"` // critical, error, warning, info
+	APIURL           string   `mapstructure:"api_url"`
+	ResolveOnSuccess bool     `mapstructure:"resolve_on_success"`
+	NotifyOn         []string `mapstructure:"notify_on"`
+}
+
+// EventsConfig holds event bus configuration
+type EventsConfig struct {
+	Enabled bool              `mapstructure:"enabled"`
+	Source  string            `mapstructure:"source"` // CloudEvents source, defaults to db-backup/<hostname>
+	Types   []string          `mapstructure:"types"`  // e.g. ["backup.*", "alert.raised"]; empty means all
+	Kafka   KafkaEventsConfig `mapstructure:"kafka"`
+	NATS    NATSEventsConfig  `mapstructure:"nats"`
+	SNS     SNSEventsConfig   `mapstructure:"sns"`
+}
+
+// KafkaEventsConfig holds Kafka REST Proxy publisher configuration
+type KafkaEventsConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	RestProxyURL string `mapstructure:"rest_proxy_url"`
+	Topic        string `mapstructure:"topic"`
+	Username     string `mapstructure:"username"`
+	Password     string `mapstructure:"password"`
+}
+
+// NATSEventsConfig holds NATS publisher configuration
+type NATSEventsConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	URL           string `mapstructure:"url"`
+	SubjectPrefix string `mapstructure:"subject_prefix"`
+	Token         string `mapstructure:"token"`
+	Username      string `mapstructure:"username"`
+	Password      string `mapstructure:"password"`
+}
+
+// SNSEventsConfig holds Amazon SNS publisher configuration
+type SNSEventsConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Region    string `mapstructure:"region"`
+	TopicARN  string `mapstructure:"topic_arn"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	Endpoint  string `mapstructure:"endpoint"`
+}
+
+// HeartbeatConfig holds dead-man's-switch monitor configuration. Checks
+// are keyed by schedule name; the "default" check covers runs without a
+// schedule of their own.
+type HeartbeatConfig struct {
+	Enabled bool                      `mapstructure:"enabled"`
+	Timeout time.Duration             `mapstructure:"timeout"`
+	Checks  map[string]HeartbeatCheck `mapstructure:"checks"`
+}
+
+// HeartbeatCheck holds the ping target for one schedule
+type HeartbeatCheck struct {
+	Provider   string `mapstructure:"provider"` // healthchecks, cronitor, generic
+	URL        string `mapstructure:"url"`      // healthchecks ping URL or Cronitor telemetry URL
+	StartURL   string `mapstructure:"start_url"`
+	SuccessURL string `mapstructure:"success_url"`
+	FailureURL string `mapstructure:"failure_url"`
+}
+
+// SLAConfig holds recovery point (RPO) and recovery time (RTO) objectives.
+// Objectives are keyed by database name; the "default" entry covers
+// databases without one.
+type SLAConfig struct {
+	Enabled             bool                    `mapstructure:"enabled"`
+	StateFile           string                  `mapstructure:"state_file"`
+	CheckInterval       time.Duration           `mapstructure:"check_interval"`
+	RequireVerification bool                    `mapstructure:"require_verification"` // only verified backups count as recovery points
+	RehearsalSamples    int                     `mapstructure:"rehearsal_samples"`    // recent restore rehearsals used for the RTO estimate
+	Objectives          map[string]SLAObjective `mapstructure:"objectives"`
+}
+
+// SLAObjective holds the objectives for one database. Zero disables a check.
+type SLAObjective struct {
+	RPO time.Duration `mapstructure:"rpo"` // maximum age of the newest recovery point
+	RTO time.Duration `mapstructure:"rto"` // maximum measured restore time
+}
+
+// FreshnessConfig holds the backup freshness watchdog. It learns from the
+// catalog how often each database is backed up and alerts when one goes
+// too long without a successful backup, whatever the schedules say, so a
+// disabled or misconfigured schedule is still caught. Expected intervals
+// are keyed by database name; the "default" entry covers databases
+// without one and without enough history to learn from.
+type FreshnessConfig struct {
+	Enabled        bool                     `mapstructure:"enabled"`
+	StateFile      string                   `mapstructure:"state_file"`
+	CheckInterval  time.Duration            `mapstructure:"check_interval"`
+	Tolerance      float64                  `mapstructure:"tolerance"`       // expected intervals a database may miss before it is stale
+	MinSamples     int                      `mapstructure:"min_samples"`     // backups needed to learn the interval
+	RepeatInterval time.Duration            `mapstructure:"repeat_interval"` // remind while still stale, 0 alerts once
+	Expected       map[string]time.Duration `mapstructure:"expected"`        // interval by database, overriding the learned one
+}
+
+// RunbookConfig holds the recovery runbooks: one per database, saying
+// where its artifacts live, the keys and logins a restore needs, the
+// commands to run and how long the restore rehearsals took. They are
+// written to directory after each backup of the database.
+type RunbookConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Directory string `mapstructure:"directory"` // <database>.json and <database>.md
+}
+
+// DiscoveryConfig enrolls the databases of servers into a schedule as
+// they are created, so a new database is never left without backups.
+// Servers are keyed by name; each lists its databases with the backup
+// login of a connection profile.
+type DiscoveryConfig struct {
+	Enabled  bool                       `mapstructure:"enabled"`
+	Interval time.Duration              `mapstructure:"interval"` // between discoveries of "discovery watch"
+	Servers  map[string]DiscoveryServer `mapstructure:"servers"`
+}
+
+// DiscoveryServer is a server whose databases are enrolled. Enrolled
+// databases are backed up with its profile on its schedule, their backups
+// tagged with Tags, which backup.database_defaults and backup.quotas
+// entries can match on.
+type DiscoveryServer struct {
+	Profile  string            `mapstructure:"profile"` // connection profile listing and backing up the databases
+	Include  []string          `mapstructure:"include"` // database patterns enrolled, all when empty
+	Exclude  []string          `mapstructure:"exclude"` // database patterns never enrolled, e.g. "test_*"
+	Cron     string            `mapstructure:"cron"`    // schedule of the enrolled databases
+	Timezone string            `mapstructure:"timezone"`
+	Tags     map[string]string `mapstructure:"tags"`
+}
+
+// AirGapConfig holds the air-gapped vault: offline or immutable targets
+// "db-backup airgap export" copies selected backups to, out of reach of
+// whoever can delete primary storage. The copies of each backup are
+// tracked in state_file.
+type AirGapConfig struct {
+	Enabled   bool                          `mapstructure:"enabled"`
+	StateFile string                        `mapstructure:"state_file"`
+	Targets   map[string]AirGapTargetConfig `mapstructure:"targets"`
+}
+
+// AirGapTargetConfig is one vault target: a directory on removable media,
+// typically an LTFS tape or a USB disk, or an S3 bucket with Object Lock
+type AirGapTargetConfig struct {
+	Type          string        `mapstructure:"type"` // directory or s3
+	Path          string        `mapstructure:"path"` // mount point of the medium
+	Prefix        string        `mapstructure:"prefix"`
+	S3            AuditS3Config `mapstructure:"s3"`
+	LockMode      string        `mapstructure:"lock_mode"`      // s3: compliance or governance
+	RetentionDays int           `mapstructure:"retention_days"` // s3: how long copies are locked
+	Databases     []string      `mapstructure:"databases"`      // exported when no backups are named; empty for all
+}
+
+// DRConfig holds disaster recovery copy jobs, which "db-backup dr run"
+// uses to replicate completed backups to a bucket in another region or
+// account. Copies are tracked per backup in state_file.
+type DRConfig struct {
+	Enabled   bool                   `mapstructure:"enabled"`
+	StateFile string                 `mapstructure:"state_file"`
+	Interval  time.Duration          `mapstructure:"interval"` // between passes of "dr run --watch"
+	Jobs      map[string]DRJobConfig `mapstructure:"jobs"`
+}
+
+// DRJobConfig is one copy job. Its credentials are its own, so the
+// account holding the copies need not trust the primary one, and its
+// retention is independent of the primary storage's.
+type DRJobConfig struct {
+	S3            AuditS3Config `mapstructure:"s3"`
+	Prefix        string        `mapstructure:"prefix"`
+	RetentionDays int           `mapstructure:"retention_days"` // copies are deleted after; 0 keeps them
+	LockMode      string        `mapstructure:"lock_mode"`      // compliance or governance locks copies for retention_days
+	Databases     []string      `mapstructure:"databases"`      // empty for all
+}
+
+
 // MetricsConfig holds metrics configuration
 type MetricsConfig struct {
-	Enabled    bool             `mapstructure:"enabled"`
-	Prometheus PrometheusConfig `mapstructure:"prometheus"`
+	Enabled     bool              `mapstructure:"enabled"`
+	Backend     string            `mapstructure:"backend"` // prometheus, statsd
+	Prometheus  PrometheusConfig  `mapstructure:"prometheus"`
+	Pushgateway PushgatewayConfig `mapstructure:"pushgateway"`
+	StatsD      StatsDConfig      `mapstructure:"statsd"`
 }
 
 // PrometheusConfig holds Prometheus configuration
@@ -205,17 +778,41 @@ type PrometheusConfig struct {
 	Path string `mapstructure:"path"`
 }
 
+// StatsDConfig holds StatsD configuration. The dogstatsd flavor sends tags
+// natively; plain statsd folds the tag values into the metric name.
+type StatsDConfig struct {
+	Address       string   `mapstructure:"address"` // host:port (UDP)
+	Prefix        string   `mapstructure:"prefix"`
+	Flavor        string   `mapstructure:"flavor"` // dogstatsd, statsd
+	Tags          []string `mapstructure:"tags"`   // global tags, "key:value"
+	MaxPacketSize int      `mapstructure:"max_packet_size"`
+}
+
+// PushgatewayConfig holds Prometheus Pushgateway configuration. CLI runs
+// have no long-lived /metrics endpoint, so they push their metrics when the
+// run ends instead.
+type PushgatewayConfig struct {
+	Enabled  bool              `mapstructure:"enabled"`
+	URL      string            `mapstructure:"url"`
+	Job      string            `mapstructure:"job"`
+	Grouping map[string]string `mapstructure:"grouping"` // extra grouping labels, e.g. instance
+	Username string            `mapstructure:"username"`
+	Password string            `mapstructure:"password"`
+	Timeout  time.Duration     `mapstructure:"timeout"`
+}
+
 // TracingConfig holds tracing configuration
 type TracingConfig struct {
 	Enabled      bool           `mapstructure:"enabled"`
-	Provider     string         `mapstructure:"provider"` // "jaeger", "zipkin", "otlp"
+	Provider     string         `mapstructure:"provider"` // "otlp"
 	ServiceName  string         `mapstructure:"service_name"`
 	Environment  string         `mapstructure:"environment"`
 	Sampling     SamplingConfig `mapstructure:"sampling"`
-	Jaeger       JaegerConfig   `mapstructure:"jaeger"`
 	OTLP         OTLPConfig     `mapstructure:"otlp"`
 	BatchTimeout time.Duration  `mapstructure:"batch_timeout"`
 	MaxQueueSize int            `mapstructure:"max_queue_size"`
+	// ResourceAttributes are added to every exported span, metric and log
+	ResourceAttributes map[string]string `mapstructure:"resource_attributes"`
 }
 
 // SamplingConfig holds trace sampling configuration
@@ -225,20 +822,27 @@ type SamplingConfig struct {
 	Limit int     `mapstructure:"limit"` // For rate limiting sampler (traces per second)
 }
 
-// JaegerConfig holds Jaeger configuration
-type JaegerConfig struct {
-	Endpoint    string `mapstructure:"endpoint"`
-	AgentHost   string `mapstructure:"agent_host"`
-	AgentPort   int    `mapstructure:"agent_port"`
-	ServiceName string `mapstructure:"service_name"`
-	Tags        map[string]string `mapstructure:"tags"`
-}
-
 // OTLPConfig holds OpenTelemetry Protocol configuration
 type OTLPConfig struct {
 	Endpoint string            `mapstructure:"endpoint"`
 	Insecure bool              `mapstructure:"insecure"`
 	Headers  map[string]string `mapstructure:"headers"`
+	Metrics  OTLPMetricsConfig `mapstructure:"metrics"`
+	Logs     OTLPLogsConfig    `mapstructure:"logs"`
+}
+
+// OTLPMetricsConfig enables exporting backup metrics over OTLP, alongside
+// or instead of Prometheus
+type OTLPMetricsConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// OTLPLogsConfig enables exporting logs over OTLP. Records carry the trace
+// and span IDs of the operation that logged them.
+type OTLPLogsConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Level   string `mapstructure:"level"` // minimum level to export
 }
 
 // SecurityConfig holds security configuration
@@ -247,6 +851,231 @@ type SecurityConfig struct {
 	OAuth2       OAuth2Config       `mapstructure:"oauth2"`
 	APIKeys      APIKeysConfig      `mapstructure:"api_keys"`
 	RateLimiting RateLimitingConfig `mapstructure:"rate_limiting"`
+	Lockout      LockoutConfig      `mapstructure:"lockout"`
+	Redaction    RedactionConfig    `mapstructure:"redaction"`
+	Secrets      SecretsConfig      `mapstructure:"secrets"`
+	LDAP         LDAPConfig         `mapstructure:"ldap"`
+	Ransomware   RansomwareConfig   `mapstructure:"ransomware"`
+	Quarantine   QuarantineConfig   `mapstructure:"quarantine"`
+	Malware      MalwareConfig      `mapstructure:"malware"`
+	Sessions     SessionsConfig     `mapstructure:"sessions"`
+	Policy       PolicyConfig       `mapstructure:"policy"`
+	Crypto       CryptoConfig       `mapstructure:"crypto"`
+	Audit        AuditConfig        `mapstructure:"audit"`
+	CatalogChain CatalogChainConfig `mapstructure:"catalog_chain"`
+}
+
+// AuditConfig holds the audit log: an append-only, hash-chained record of
+// backups, restores and security actions, exported in signed batches to
+// write-once storage so the history cannot be rewritten from this server
+type AuditConfig struct {
+	Enabled bool              `mapstructure:"enabled"`
+	LogFile string            `mapstructure:"log_file"`
+	Export  AuditExportConfig `mapstructure:"export"`
+}
+
+// AuditExportConfig holds the export of the audit log to an S3 bucket with
+// Object Lock, or to a directory on write-once media when no bucket is set
+type AuditExportConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	Interval       time.Duration `mapstructure:"interval"` // for "db-backup security audit export --watch"
+	StateFile      string        `mapstructure:"state_file"`
+	SigningKeyFile string        `mapstructure:"signing_key_file"` // Ed25519 key from "db-backup security audit keygen"
+	Prefix         string        `mapstructure:"prefix"`
+	RetentionDays  int           `mapstructure:"retention_days"`
+	LockMode       string        `mapstructure:"lock_mode"` // compliance or governance
+	Directory      string        `mapstructure:"directory"`
+	S3             AuditS3Config `mapstructure:"s3"`
+}
+
+// AuditS3Config is the Object Lock bucket audit batches are exported to.
+// Credentials default to the AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY
+// environment variables; they should only allow s3:PutObject.
+type AuditS3Config struct {
+	Bucket       string `mapstructure:"bucket"`
+	Region       string `mapstructure:"region"`
+	Endpoint     string `mapstructure:"endpoint"`
+	AccessKey    string `mapstructure:"access_key"`
+	SecretKey    string `mapstructure:"secret_key"`
+	UsePathStyle bool   `mapstructure:"use_path_style"`
+}
+
+// CatalogChainConfig holds the hash chain over the backup catalog: every
+// saved metadata entry is linked to its predecessor, and the head of the
+// chain is anchored on the write-once storage audit batches are exported
+// to (security.audit.export), so deleted or edited entries are detectable
+type CatalogChainConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	AnchorInterval time.Duration `mapstructure:"anchor_interval"` // for "db-backup security catalog anchor --watch"
+	AnchorPrefix   string        `mapstructure:"anchor_prefix"`
+}
+
+// CryptoConfig selects the crypto policy. Under "fips" only FIPS
+// 140-approved algorithms may be configured, TLS is limited to approved
+// suites and curves, and backups record the policy in their metadata.
+type CryptoConfig struct {
+	Policy string `mapstructure:"policy"` // default or fips
+	// RequireFIPSModule refuses to start unless Go's FIPS 140 module is
+	// active (GODEBUG=fips140=on)
+	RequireFIPSModule bool `mapstructure:"require_fips_module"`
+}
+
+// PolicyConfig holds authorization rules evaluated on every API request on
+// top of the role checks. Rules are tried in order and the first whose
+// action, attributes and conditions all match decides; DefaultEffect
+// applies when none does.
+type PolicyConfig struct {
+	Enabled       bool         `mapstructure:"enabled"`
+	DefaultEffect string       `mapstructure:"default_effect"` // allow or deny
+	Rules         []PolicyRule `mapstructure:"rules"`
+}
+
+// PolicyRule allows or denies matching requests
+type PolicyRule struct {
+	Name   string `mapstructure:"name"`
+	Effect string `mapstructure:"effect"` // allow or deny
+	// Actions are action names or globs, e.g. backup.restore or schedule.*
+	Actions []string `mapstructure:"actions"`
+	// Attributes are globs matched against request attributes: route
+	// parameters, top-level JSON body fields and query parameters, e.g.
+	// target_host: "prod-*". Keys are lower-case. A deny rule also matches
+	// requests missing one of its attributes.
+	Attributes map[string]string `mapstructure:"attributes"`
+	When       PolicyCondition   `mapstructure:"when"`
+}
+
+// PolicyCondition restricts who may match a rule and when. Every non-empty
+// field must hold; lists match when any entry does.
+type PolicyCondition struct {
+	Subjects    []string `mapstructure:"subjects"` // token subjects, globs allowed
+	Roles       []string `mapstructure:"roles"`
+	Groups      []string `mapstructure:"groups"`
+	Clients     []string `mapstructure:"clients"` // mutual TLS identities, globs allowed
+	SourceCIDRs []string `mapstructure:"source_cidrs"`
+	Days        []string `mapstructure:"days"`     // mon..sun
+	Hours       string   `mapstructure:"hours"`    // e.g. 09:00-18:00; may wrap midnight
+	Timezone    string   `mapstructure:"timezone"` // for days and hours; defaults to local time
+}
+
+// SessionsConfig holds the server-side session store. When enabled, every
+// issued token is recorded and only accepted while its session is active,
+// so tokens can be revoked before they expire.
+type SessionsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Backend is memory, redis (database.redis) or database
+	// (database.metadata). Use redis or database with several API servers.
+	Backend   string `mapstructure:"backend"`
+	KeyPrefix string `mapstructure:"key_prefix"`
+	Table     string `mapstructure:"table"`
+}
+
+// MalwareConfig holds malware scanning of backup artifacts with ClamAV
+// and/or YARA rules. A match raises a threat alert and quarantines the
+// backup when the quarantine is enabled.
+type MalwareConfig struct {
+	Enabled   bool        `mapstructure:"enabled"`
+	OnBackup  bool        `mapstructure:"on_backup"` // scan each new artifact right after it is written
+	StateFile string      `mapstructure:"state_file"`
+	Clamd     ClamdConfig `mapstructure:"clamd"`
+	YARA      YARAConfig  `mapstructure:"yara"`
+}
+
+// ClamdConfig points at a clamd daemon; artifacts are streamed to it
+type ClamdConfig struct {
+	Address string        `mapstructure:"address"` // unix:///run/clamav/clamd.ctl or tcp://clamav:3310
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// YARAConfig holds YARA rule files run with the yara command line tool
+type YARAConfig struct {
+	Binary  string        `mapstructure:"binary"`
+	Rules   []string      `mapstructure:"rules"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// QuarantineConfig holds the quarantine for backups flagged as suspicious.
+// Quarantined backups are skipped by restore-latest and prune until they
+// are released explicitly; every quarantine and release is audited.
+type QuarantineConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	StateFile     string `mapstructure:"state_file"`
+	RequireReason bool   `mapstructure:"require_reason"` // a release must say why the backup is trusted
+}
+
+// RansomwareConfig holds ransomware detection settings
+type RansomwareConfig struct {
+	Baseline RansomwareBaselineConfig `mapstructure:"baseline"`
+}
+
+// RansomwareBaselineConfig learns what each database's backups normally look
+// like (byte entropy and compression ratio) and flags backups that deviate
+// from it, instead of fixed thresholds that misfire on dumps which are
+// already compressed.
+type RansomwareBaselineConfig struct {
+	Enabled          bool    `mapstructure:"enabled"`
+	StateFile        string  `mapstructure:"state_file"`
+	Window           int     `mapstructure:"window"`             // recent backups the baseline is learned from
+	MinSamples       int     `mapstructure:"min_samples"`        // backups learned before deviations are flagged
+	Threshold        float64 `mapstructure:"threshold"`          // standard deviations from the baseline that count as a deviation
+	MinEntropyChange float64 `mapstructure:"min_entropy_change"` // minimum rise in bits per byte
+	MinRatioChange   float64 `mapstructure:"min_ratio_change"`   // minimum relative rise of compressed / raw size
+	SampleSize       string  `mapstructure:"sample_size"`        // bytes read from each artifact to measure entropy
+}
+
+// LDAPConfig holds the LDAP / Active Directory login backend. Users are
+// found with a service account bind and a search, then verified by binding
+// as the user; their groups map to API roles.
+type LDAPConfig struct {
+	Enabled            bool              `mapstructure:"enabled"`
+	URL                string            `mapstructure:"url"` // ldap://host:389 or ldaps://host:636
+	StartTLS           bool              `mapstructure:"start_tls"`
+	CAFile             string            `mapstructure:"ca_file"`
+	InsecureSkipVerify bool              `mapstructure:"insecure_skip_verify"`
+	BindDN             string            `mapstructure:"bind_dn"` // service account; empty binds anonymously
+	BindPassword       string            `mapstructure:"bind_password"`
+	UserBaseDN         string            `mapstructure:"user_base_dn"`
+	UserFilter         string            `mapstructure:"user_filter"` // {username} is replaced, e.g. (sAMAccountName={username})
+	EmailAttribute     string            `mapstructure:"email_attribute"`
+	MemberOfAttribute  string            `mapstructure:"member_of_attribute"` // group DNs on the user entry, e.g. memberOf
+	GroupBaseDN        string            `mapstructure:"group_base_dn"`
+	GroupFilter        string            `mapstructure:"group_filter"` // {dn} and {username} are replaced, e.g. (member={dn})
+	GroupAttribute     string            `mapstructure:"group_attribute"`
+	GroupRoles         map[string]string `mapstructure:"group_roles"` // group CN or DN -> admin, operator, viewer
+	DefaultRole        string            `mapstructure:"default_role"` // for users in no mapped group; empty denies them
+	PoolSize           int               `mapstructure:"pool_size"`
+	Timeout            time.Duration     `mapstructure:"timeout"`
+}
+
+// SecretsConfig configures the backends behind secret references such as
+// "vault:secret/db#password" or "aws-sm:<arn>#password" in config values.
+// Its own values may only reference files or environment variables.
+type SecretsConfig struct {
+	Timeout time.Duration      `mapstructure:"timeout"` // for resolving all references at load time
+	Vault   SecretsVaultConfig `mapstructure:"vault"`
+	AWS     SecretsAWSConfig   `mapstructure:"aws"`
+}
+
+// SecretsVaultConfig holds the Vault connection for "vault:" references;
+// empty values fall back to VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE
+type SecretsVaultConfig struct {
+	Address   string `mapstructure:"address"`
+	Token     string `mapstructure:"token"`
+	Namespace string `mapstructure:"namespace"`
+	KVVersion int    `mapstructure:"kv_version"` // 1 or 2
+}
+
+// SecretsAWSConfig holds the Secrets Manager settings for "aws-sm:"
+// references
+type SecretsAWSConfig struct {
+	Region   string `mapstructure:"region"`
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+// RedactionConfig extends the secret scrubbing applied to logs, error
+// metadata and notifications
+type RedactionConfig struct {
+	Keys     []string `mapstructure:"keys"`     // extra field names whose values are secret
+	Patterns []string `mapstructure:"patterns"` // regular expressions whose matches are masked
 }
 
 // JWTConfig holds JWT configuration
@@ -285,51 +1114,169 @@ type RateLimitingConfig struct {
 	RequestsPerMinute  int  `mapstructure:"requests_per_minute"`
 }
 
+// LockoutConfig holds brute-force protection for logins. Failed logins are
+// counted per user and per client IP; reaching the limit within the window
+// locks the user or IP out, for twice as long with every further lockout.
+type LockoutConfig struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	MaxAttempts      int           `mapstructure:"max_attempts"`        // failures per user within window
+	MaxAttemptsPerIP int           `mapstructure:"max_attempts_per_ip"` // failures per client IP within window
+	Window           time.Duration `mapstructure:"window"`
+	BaseDuration     time.Duration `mapstructure:"base_duration"` // first lockout
+	MaxDuration      time.Duration `mapstructure:"max_duration"`
+	// SprayThreshold raises an alert when one IP fails against this many
+	// users, or one user fails from this many IPs, within window
+	SprayThreshold int `mapstructure:"spray_threshold"`
+}
+
 // Load loads configuration from file and environment variables
 func Load(configPath string) (*Config, error) {
+	config, err := Parse(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace vault:, aws-sm:, file: and env: references with their values
+	resolved, err := config.ResolveSecrets(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate configuration
+	if err := validate(config); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	// Restrict TLS set up from now on to the configured crypto policy
+	if err := cryptopolicy.Set(config.Security.Crypto.Policy); err != nil {
+		return nil, err
+	}
+
+	// Scrub configured credentials from everything logged from now on
+	if err := redact.Configure(config.Security.Redaction.Keys, config.Security.Redaction.Patterns); err != nil {
+		return nil, err
+	}
+	redact.AddSecrets(config.SecretValues()...)
+	redact.AddSecrets(resolved...)
+
+	// Bound the buffers used to copy, hash and scan artifacts
+	if err := configureStreams(config.Backup); err != nil {
+		return nil, err
+	}
+
+	// Register the drivers of installed plugins
+	if _, err := plugin.Discover(config.Plugins.Directory, config.Plugins.HandshakeTimeout); err != nil {
+		return nil, fmt.Errorf("plugins.directory: %w", err)
+	}
+
+	return config, nil
+}
+
+// configureStreams sizes the shared artifact buffer pool. Unset values keep
+// the defaults.
+func configureStreams(b BackupConfig) error {
+	bufferSize, maxMemory := int64(stream.DefaultBufferSize), int64(stream.DefaultMaxMemory)
+	var err error
+	if b.BufferSize != "" {
+		if bufferSize, err = utils.ParseBytes(b.BufferSize); err != nil {
+			return fmt.Errorf("backup.buffer_size: %w", err)
+		}
+	}
+	if b.MaxMemory != "" {
+		if maxMemory, err = utils.ParseBytes(b.MaxMemory); err != nil {
+			return fmt.Errorf("backup.max_memory: %w", err)
+		}
+	}
+	return stream.Configure(int(bufferSize), maxMemory)
+}
+
+// Parse loads configuration from file and environment variables without
+// validating it or creating any directories
+func Parse(configPath string) (*Config, error) {
+	v, err := newViper(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject unknown keys, mistyped values and sections enabled without
+	// the keys they need, each reported with its full path
+	if errs := validateSettings(v); len(errs) > 0 {
+		return nil, errs
+	}
+
+	// Unmarshal config
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	// Read the secrets given as <key>_file from their files
+	if err := config.applySecretFiles(v.AllSettings()); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// newViper creates a viper instance with defaults, environment overrides
+// and the config file (if any) loaded
+func newViper(configPath string) (*viper.Viper, error) {
 	v := viper.New()
 
 	// Set default values
 	setDefaults(v)
 
 	// Set config file path
-	if configPath != "" {
-		v.SetConfigFile(configPath)
-	} else {
-		// Search for config in common locations
-		v.SetConfigName("config")
-		v.SetConfigType("yaml")
-		v.AddConfigPath(".")
-		v.AddConfigPath("./config")
-		v.AddConfigPath("/etc/db-backup/")
-		v.AddConfigPath("$HOME/.db-backup/")
-	}
+	setConfigFile(v, configPath)
 
 	// Enable environment variable override
 	v.SetEnvPrefix("DBBACKUP")
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
 
-	// Read config file
-	if err := v.ReadInConfig(); err != nil {
+	// AutomaticEnv only applies to keys viper already knows about, so bind
+	// every key of the Config struct explicitly
+	bindEnvs(v)
+
+	// Read config file, decrypting it first if it is sops-encrypted
+	if err := readConfigFile(v); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
 			return nil, fmt.Errorf("failed to read config file: %w", err)
 		}
 		// Config file not found, use defaults and environment variables
+		if env := Environment(); env != "" {
+			return nil, fmt.Errorf("environment %s selected but no config file found", env)
+		}
+		return v, nil
 	}
 
-	// Unmarshal config
-	var config Config
-	if err := v.Unmarshal(&config); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	// Merge the file's includes under it and the environment's overlay
+	// over it
+	if err := applyLayers(v); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	// Validate configuration
-	if err := validate(&config); err != nil {
-		return nil, fmt.Errorf("config validation failed: %w", err)
+	return v, nil
+}
+
+// setConfigFile points viper at an explicit file or the default search paths
+func setConfigFile(v *viper.Viper, configPath string) {
+	if configPath != "" {
+		v.SetConfigFile(configPath)
+		return
 	}
 
-	return &config, nil
+	// Search for config in common locations
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath(".")
+	v.AddConfigPath("./config")
+	if dir := os.Getenv("ProgramData"); runtime.GOOS == "windows" && dir != "" {
+		v.AddConfigPath(filepath.Join(dir, "db-backup"))
+	} else {
+		v.AddConfigPath("/etc/db-backup/")
+	}
+	v.AddConfigPath("$HOME/.db-backup/")
 }
 
 // setDefaults sets default configuration values
@@ -339,6 +1286,20 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("server.port", 8080)
 	v.SetDefault("server.mode", "development")
 	v.SetDefault("server.tls.enabled", false)
+	v.SetDefault("server.tls.client_auth", "none")
+	v.SetDefault("server.agents.enabled", false)
+	v.SetDefault("server.agents.listen", ":9443")
+
+	// Agent defaults
+	v.SetDefault("agent.max_concurrent", 1)
+	v.SetDefault("agent.chunk_size", "1MB")
+	v.SetDefault("agent.reconnect_interval", "10s")
+
+	v.SetDefault("kubernetes.snapshot_timeout", "10m")
+
+	// Plugin defaults
+	v.SetDefault("plugins.directory", "/etc/db-backup/plugins")
+	v.SetDefault("plugins.handshake_timeout", "10s")
 
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
@@ -349,21 +1310,133 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("backup.default_compression", "zstd")
 	v.SetDefault("backup.compression_level", 3)
 	v.SetDefault("backup.encryption.enabled", false)
+	v.SetDefault("backup.encryption.algorithm", "aes-256-gcm")
 	v.SetDefault("backup.retention.daily", 7)
 	v.SetDefault("backup.retention.weekly", 4)
 	v.SetDefault("backup.retention.monthly", 12)
-	v.SetDefault("backup.temp_directory", "/tmp/backups")
+	v.SetDefault("backup.temp_directory", filepath.Join(os.TempDir(), "backups"))
 	v.SetDefault("backup.parallel_operations", 4)
+	v.SetDefault("backup.max_memory", "256MB")
+	v.SetDefault("backup.buffer_size", "1MB")
+	v.SetDefault("backup.pipeline.dump_workers", 4)
+	v.SetDefault("backup.pipeline.compress_workers", 4)
+	v.SetDefault("backup.pipeline.encrypt_workers", 2)
+	v.SetDefault("backup.pipeline.upload_workers", 4)
+	v.SetDefault("backup.pipeline.index_workers", 1)
+	v.SetDefault("backup.pipeline.max_per_host", 2)
+	v.SetDefault("backup.vss", "auto")
+	v.SetDefault("backup.auto_tune.enabled", false)
+	v.SetDefault("backup.auto_tune.min_level", 1)
+	v.SetDefault("backup.auto_tune.max_level", 9)
+	v.SetDefault("backup.auto_tune.max_workers", 0)
+	v.SetDefault("backup.auto_tune.target_cpu", 0.85)
+	v.SetDefault("backup.auto_tune.interval", "5s")
+	v.SetDefault("backup.verify.workers", 4)
+	v.SetDefault("backup.verify.max_per_provider", 4)
+	v.SetDefault("backup.verify.window", "0s")
+	v.SetDefault("backup.disk_watchdog.enabled", false)
+	v.SetDefault("backup.disk_watchdog.interval", "1m")
+	v.SetDefault("backup.disk_watchdog.min_free_percent", 10)
+	v.SetDefault("backup.disk_watchdog.temp_max_age", "24h")
+	v.SetDefault("backup.schema_snapshots.enabled", true)
+	v.SetDefault("backup.quotas.warn_percent", 80)
+	v.SetDefault("backup.retention_optimizer.mode", "advisory")
+	v.SetDefault("backup.retention_optimizer.coverage", 0.95)
 
 	// Storage defaults
 	v.SetDefault("storage.default_provider", "local")
+	v.SetDefault("storage.upload.part_size", "16MB")
+	v.SetDefault("storage.upload.concurrency", 4)
+	v.SetDefault("storage.upload.state_directory", "./data/uploads")
+	v.SetDefault("storage.upload.abandon_after", "72h")
+	v.SetDefault("storage.cache.enabled", false)
+	v.SetDefault("storage.cache.directory", "./data/cache")
+	v.SetDefault("storage.cache.max_size", "10GB")
 	v.SetDefault("storage.providers.local.enabled", true)
 	v.SetDefault("storage.providers.local.path", "./backups")
 
+	// Notification defaults
+	v.SetDefault("notifications.email.smtp_port", 587)
+	v.SetDefault("notifications.email.tls_mode", "starttls")
+	v.SetDefault("notifications.email.max_attachment_size", 10*1024*1024)
+	v.SetDefault("notifications.email.digest.frequency", "daily")
+	v.SetDefault("notifications.email.digest.send_at", "08:00")
+	v.SetDefault("notifications.email.digest.weekday", "monday")
+	v.SetDefault("notifications.email.digest.spool_file", "./data/digest.json")
+	v.SetDefault("notifications.email.digest.expiration_window", "72h")
+	v.SetDefault("notifications.webhook.method", "POST")
+	v.SetDefault("notifications.webhook.signature_header", "X-DBBackup-Signature")
+	v.SetDefault("notifications.webhook.timeout", "15s")
+	v.SetDefault("notifications.webhook.retry.max_attempts", 3)
+	v.SetDefault("notifications.webhook.retry.initial_backoff", "2s")
+	v.SetDefault("notifications.webhook.retry.max_backoff", "30s")
+	v.SetDefault("notifications.pagerduty.severity", "critical")
+	v.SetDefault("notifications.pagerduty.resolve_on_success", true)
+	v.SetDefault("notifications.pagerduty.notify_on", []string{"failure", "success"})
+	v.SetDefault("notifications.escalation.state_file", "./data/escalation.json")
+	v.SetDefault("notifications.queue.enabled", true)
+	v.SetDefault("notifications.queue.directory", "./data/notification-queue")
+	v.SetDefault("notifications.queue.max_attempts", 8)
+	v.SetDefault("notifications.queue.initial_backoff", "30s")
+	v.SetDefault("notifications.queue.max_backoff", "1h")
+	v.SetDefault("notifications.queue.poll_interval", "15s")
+
+	// Events defaults
+	v.SetDefault("events.enabled", false)
+	v.SetDefault("events.kafka.topic", "db-backup-events")
+	v.SetDefault("events.nats.subject_prefix", "dbbackup")
+
+	// Heartbeat defaults
+	v.SetDefault("heartbeats.enabled", false)
+	v.SetDefault("heartbeats.timeout", "10s")
+
+	// SLA defaults
+	v.SetDefault("sla.enabled", false)
+	v.SetDefault("sla.state_file", "./data/sla.json")
+	v.SetDefault("sla.check_interval", "5m")
+	v.SetDefault("sla.rehearsal_samples", 5)
+
+	// Freshness watchdog defaults
+	v.SetDefault("freshness.enabled", false)
+	v.SetDefault("freshness.state_file", "./data/freshness.json")
+	v.SetDefault("freshness.check_interval", "5m")
+	v.SetDefault("freshness.tolerance", 1.5)
+	v.SetDefault("freshness.min_samples", 4)
+	v.SetDefault("freshness.repeat_interval", "24h")
+
+	// Recovery runbook defaults
+	v.SetDefault("runbooks.enabled", false)
+	v.SetDefault("runbooks.directory", "./data/runbooks")
+
+	// Database discovery defaults
+	v.SetDefault("discovery.enabled", false)
+	v.SetDefault("discovery.interval", "1h")
+
+	// Air gap defaults
+	v.SetDefault("airgap.enabled", false)
+	v.SetDefault("airgap.state_file", "./data/airgap.json")
+
+	// DR copy defaults
+	v.SetDefault("dr.enabled", false)
+	v.SetDefault("dr.state_file", "./data/dr.json")
+	v.SetDefault("dr.interval", "15m")
+
 	// Metrics defaults
 	v.SetDefault("metrics.enabled", true)
 	v.SetDefault("metrics.prometheus.port", 9090)
 	v.SetDefault("metrics.prometheus.path", "/metrics")
+	v.SetDefault("metrics.backend", "prometheus")
+	v.SetDefault("metrics.pushgateway.job", "db-backup")
+	v.SetDefault("metrics.statsd.address", "127.0.0.1:8125")
+	v.SetDefault("metrics.statsd.prefix", "dbbackup")
+	v.SetDefault("metrics.statsd.flavor", "dogstatsd")
+	v.SetDefault("metrics.statsd.max_packet_size", 1432)
+	v.SetDefault("metrics.pushgateway.timeout", "10s")
+
+	// Tracing and OpenTelemetry defaults
+	v.SetDefault("tracing.service_name", "db-backup")
+	v.SetDefault("tracing.otlp.metrics.interval", "60s")
+	v.SetDefault("tracing.otlp.logs.level", "info")
 
 	// Security defaults
 	v.SetDefault("security.jwt.expiration", "24h")
@@ -374,78 +1447,78 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("security.api_keys.enabled", false)
 	v.SetDefault("security.rate_limiting.enabled", true)
 	v.SetDefault("security.rate_limiting.requests_per_minute", 100)
+	v.SetDefault("security.lockout.enabled", true)
+	v.SetDefault("security.lockout.max_attempts", 5)
+	v.SetDefault("security.lockout.max_attempts_per_ip", 20)
+	v.SetDefault("security.lockout.window", "15m")
+	v.SetDefault("security.lockout.base_duration", "1m")
+	v.SetDefault("security.lockout.max_duration", "1h")
+	v.SetDefault("security.lockout.spray_threshold", 10)
+	v.SetDefault("security.secrets.timeout", "30s")
+	v.SetDefault("security.secrets.vault.kv_version", 2)
+	v.SetDefault("security.ldap.enabled", false)
+	v.SetDefault("security.ldap.user_filter", "(uid={username})")
+	v.SetDefault("security.ldap.email_attribute", "mail")
+	v.SetDefault("security.ldap.group_attribute", "cn")
+	v.SetDefault("security.ldap.pool_size", 4)
+	v.SetDefault("security.ldap.timeout", "10s")
+	v.SetDefault("security.ransomware.baseline.enabled", false)
+	v.SetDefault("security.ransomware.baseline.state_file", "./data/ransomware-baseline.json")
+	v.SetDefault("security.ransomware.baseline.window", 30)
+	v.SetDefault("security.ransomware.baseline.min_samples", 5)
+	v.SetDefault("security.ransomware.baseline.threshold", 3.0)
+	v.SetDefault("security.ransomware.baseline.min_entropy_change", 0.5)
+	v.SetDefault("security.ransomware.baseline.min_ratio_change", 0.25)
+	v.SetDefault("security.ransomware.baseline.sample_size", "64MB")
+	v.SetDefault("security.quarantine.enabled", false)
+	v.SetDefault("security.quarantine.state_file", "./data/quarantine.json")
+	v.SetDefault("security.quarantine.require_reason", true)
+	v.SetDefault("security.sessions.enabled", false)
+	v.SetDefault("security.sessions.backend", "memory")
+	v.SetDefault("security.sessions.key_prefix", "db-backup:sessions:")
+	v.SetDefault("security.sessions.table", "auth_sessions")
+	v.SetDefault("security.policy.enabled", false)
+	v.SetDefault("security.policy.default_effect", "allow")
+	v.SetDefault("security.crypto.policy", "default")
+	v.SetDefault("security.crypto.require_fips_module", false)
+	v.SetDefault("security.audit.enabled", false)
+	v.SetDefault("security.audit.log_file", "./data/audit.log")
+	v.SetDefault("security.audit.export.enabled", false)
+	v.SetDefault("security.audit.export.interval", "1h")
+	v.SetDefault("security.audit.export.state_file", "./data/audit-export.json")
+	v.SetDefault("security.audit.export.prefix", "audit/")
+	v.SetDefault("security.audit.export.retention_days", 2555)
+	v.SetDefault("security.audit.export.lock_mode", "compliance")
+	v.SetDefault("security.catalog_chain.enabled", false)
+	v.SetDefault("security.catalog_chain.anchor_interval", "1h")
+	v.SetDefault("security.catalog_chain.anchor_prefix", "catalog/")
+	v.SetDefault("security.malware.enabled", false)
+	v.SetDefault("security.malware.on_backup", true)
+	v.SetDefault("security.malware.state_file", "./data/malware-scans.json")
+	v.SetDefault("security.malware.clamd.timeout", "10m")
+	v.SetDefault("security.malware.yara.binary", "yara")
+	v.SetDefault("security.malware.yara.timeout", "10m")
 }
 
-// validate validates the configuration
+// validate checks the configuration against the rules of Check, so Load
+// and "config validate" never disagree, then creates the directories the
+// configuration needs
 func validate(config *Config) error {
-	// Validate server config
-	if config.Server.Port < 1 || config.Server.Port > 65535 {
-		return fmt.Errorf("invalid server port: %d", config.Server.Port)
+	if errs := Check(config); len(errs) > 0 {
+		return errs
 	}
 
-	// Validate TLS config
-	if config.Server.TLS.Enabled {
-		if config.Server.TLS.CertFile == "" || config.Server.TLS.KeyFile == "" {
-			return fmt.Errorf("TLS enabled but cert_file or key_file not specified")
-		}
-		if _, err := os.Stat(config.Server.TLS.CertFile); os.IsNotExist(err) {
-			return fmt.Errorf("TLS cert file not found: %s", config.Server.TLS.CertFile)
-		}
-		if _, err := os.Stat(config.Server.TLS.KeyFile); os.IsNotExist(err) {
-			return fmt.Errorf("TLS key file not found: %s", config.Server.TLS.KeyFile)
-		}
-	}
-
-	// Validate backup config
-	if config.Backup.ParallelOperations < 1 {
-		return fmt.Errorf("parallel_operations must be at least 1")
-	}
-
-	// Validate temp directory
 	if config.Backup.TempDirectory != "" {
 		if err := os.MkdirAll(config.Backup.TempDirectory, 0755); err != nil {
 			return fmt.Errorf("failed to create temp directory: %w", err)
 		}
 	}
-
-	// Validate storage config
-	hasEnabledProvider := false
-	if config.Storage.Providers.S3.Enabled {
-		hasEnabledProvider = true
-	}
-	if config.Storage.Providers.GCS.Enabled {
-		hasEnabledProvider = true
-	}
-	if config.Storage.Providers.Azure.Enabled {
-		hasEnabledProvider = true
-	}
 	if config.Storage.Providers.Local.Enabled {
-		hasEnabledProvider = true
-		// Create local storage directory if it doesn't exist
 		if err := os.MkdirAll(config.Storage.Providers.Local.Path, 0755); err != nil {
 			return fmt.Errorf("failed to create local storage directory: %w", err)
 		}
 	}
 
-	if !hasEnabledProvider {
-		return fmt.Errorf("at least one storage provider must be enabled")
-	}
-
-	// Validate JWT secret - CRITICAL FOR SECURITY
-	if config.Security.JWT.Secret == "" {
-		return fmt.Errorf("JWT secret is required. Set via DBBACKUP_SECURITY_JWT_SECRET environment variable or config file")
-	}
-	if len(config.Security.JWT.Secret) < 32 {
-		return fmt.Errorf("JWT secret must be at least 32 characters long for security")
-	}
-	// Warn about common insecure values
-	insecureSecrets := []string{"secret", "password", "test", "changeme", "default", "jwt-secret", "your-secret-key"}
-	for _, insecure := range insecureSecrets {
-		if config.Security.JWT.Secret == insecure {
-			return fmt.Errorf("JWT secret appears to be insecure (%q). Please use a strong random secret", insecure)
-		}
-	}
-
 	return nil
 }
 // ValidateConfig validates critical configuration parameters
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/pkg/redact"
+)
+
+// Purposes a connection profile's credentials are used for
+const (
+	PurposeBackup  = "backup"
+	PurposeRestore = "restore"
+)
+
+var (
+	// ErrUnknownConnection is returned for a profile that is not configured
+	ErrUnknownConnection = errors.New("unknown connection profile")
+	// ErrNoCredentials is returned when a profile has no login for the
+	// requested purpose
+	ErrNoCredentials = errors.New("no credentials configured")
+)
+
+// Connection returns the named connection profile and the credentials to
+// use for purpose. Restores never fall back to the backup credentials: a
+// profile without a restore login cannot be restored to, which keeps the
+// backup login read-only in practice as well as on paper.
+func (c *Config) Connection(name, purpose string) (ConnectionProfile, ConnectionCredentials, error) {
+	// Viper lower-cases map keys
+	p, ok := c.Connections[strings.ToLower(name)]
+	if !ok {
+		return ConnectionProfile{}, ConnectionCredentials{}, fmt.Errorf("%w: %s", ErrUnknownConnection, name)
+	}
+
+	var creds ConnectionCredentials
+	switch purpose {
+	case PurposeBackup:
+		creds = p.Backup
+	case PurposeRestore:
+		creds = p.Restore
+	default:
+		return ConnectionProfile{}, ConnectionCredentials{}, fmt.Errorf("unknown credentials purpose %q", purpose)
+	}
+	// SQLite and DuckDB databases are files and have no logins
+	if creds.User == "" && !FileDatabase(p.Type) {
+		return ConnectionProfile{}, ConnectionCredentials{}, fmt.Errorf("%w: connections.%s.%s.user is not set",
+			ErrNoCredentials, strings.ToLower(name), purpose)
+	}
+	// A password read from a file is read again, so a rotated password is
+	// used from the next connection on
+	key := "connections." + strings.ToLower(name) + "." + purpose + ".password"
+	if file, ok := c.SecretFile(key); ok {
+		password, err := readSecretFile(file)
+		if err != nil {
+			return ConnectionProfile{}, ConnectionCredentials{}, fmt.Errorf("%s%s: %w", key, SecretFileSuffix, err)
+		}
+		if password != creds.Password {
+			redact.AddSecrets(password)
+			creds.Password = password
+		}
+	}
+	return p, creds, nil
+}
+
+// FileDatabase reports whether databases of type t are files, with no
+// server or logins: the profile's database is the file's path
+func FileDatabase(t string) bool {
+	return t == "sqlite" || t == "duckdb"
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConnection(t *testing.T) {
+	cfg := &Config{Connections: map[string]ConnectionProfile{
+		"orders": {
+			Type:    "postgres",
+			Host:    "db.internal",
+			Backup:  ConnectionCredentials{User: "backup_reader", Password: "r"},
+			Restore: ConnectionCredentials{User: "restore_admin", Password: "w", Role: "orders_owner"},
+		},
+		"reports": {
+			Type:   "mysql",
+			Host:   "reports.internal",
+			Backup: ConnectionCredentials{User: "backup_reader"},
+		},
+		"cache": {Type: "sqlite", Database: "/var/lib/app/cache.db"},
+	}}
+
+	_, creds, err := cfg.Connection("Orders", PurposeRestore)
+	if err != nil || creds.User != "restore_admin" || creds.Role != "orders_owner" {
+		t.Errorf("Connection(orders, restore) = %+v, %v", creds, err)
+	}
+	if _, creds, err = cfg.Connection("orders", PurposeBackup); err != nil || creds.User != "backup_reader" {
+		t.Errorf("Connection(orders, backup) = %+v, %v", creds, err)
+	}
+	// No fallback to the backup login
+	if _, _, err := cfg.Connection("reports", PurposeRestore); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Connection(reports, restore) error = %v, want ErrNoCredentials", err)
+	}
+	if _, _, err := cfg.Connection("cache", PurposeRestore); err != nil {
+		t.Errorf("Connection(cache, restore) error = %v", err)
+	}
+	if _, _, err := cfg.Connection("nope", PurposeBackup); !errors.Is(err, ErrUnknownConnection) {
+		t.Errorf("Connection(nope) error = %v, want ErrUnknownConnection", err)
+	}
+}
+
+func TestCheckConnections(t *testing.T) {
+	cfg := &Config{Connections: map[string]ConnectionProfile{
+		"shared": {
+			Type:    "postgres",
+			Host:    "db.internal",
+			Backup:  ConnectionCredentials{User: "app"},
+			Restore: ConnectionCredentials{User: "app", Role: "owner; DROP ROLE x"},
+		},
+		"docs": {
+			Type:   "mongodb",
+			Host:   "mongo.internal",
+			Backup: ConnectionCredentials{User: "backup", Role: "backup"},
+		},
+	}}
+	c := &checker{}
+	checkConnections(c, cfg)
+
+	want := []string{
+		"connections.docs.backup.role",
+		"connections.shared.restore.user",
+		"connections.shared.restore.role",
+	}
+	var got []string
+	for _, e := range c.errs {
+		got = append(got, e.Path)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("errors = %v, want %v", c.errs, want)
+	}
+}
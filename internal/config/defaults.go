@@ -0,0 +1,125 @@
+package config
+
+import (
+	"path"
+	"strings"
+)
+
+// BackupDefaults are the backup settings of a database: the global ones,
+// under those of every backup.database_defaults entry matching it
+type BackupDefaults struct {
+	Compression       string
+	CompressionLevel  int
+	Encrypt           bool
+	EncryptionKeyFile string
+	Storage           string
+	Retention         RetentionConfig
+}
+
+// BackupDefaults returns the backup settings of database db of type
+// dbType, tagged with tags
+func (c *Config) BackupDefaults(db, dbType string, tags map[string]string) BackupDefaults {
+	d := BackupDefaults{
+		Compression:       c.Backup.DefaultCompression,
+		CompressionLevel:  c.Backup.CompressionLevel,
+		EncryptionKeyFile: c.Backup.Encryption.KeyFile,
+		Storage:           c.Storage.DefaultProvider,
+		Retention:         c.Backup.Retention,
+	}
+	for _, e := range c.Backup.DatabaseDefaults {
+		if !e.matches(db, dbType, tags) {
+			continue
+		}
+		if e.Compression != "" {
+			d.Compression = e.Compression
+		}
+		if e.CompressionLevel != 0 {
+			d.CompressionLevel = e.CompressionLevel
+		}
+		if e.Encrypt != nil {
+			d.Encrypt = *e.Encrypt
+		}
+		if e.EncryptionKeyFile != "" {
+			d.EncryptionKeyFile = e.EncryptionKeyFile
+		}
+		if e.Storage != "" {
+			d.Storage = e.Storage
+		}
+		if e.Retention.Daily != 0 {
+			d.Retention.Daily = e.Retention.Daily
+		}
+		if e.Retention.Weekly != 0 {
+			d.Retention.Weekly = e.Retention.Weekly
+		}
+		if e.Retention.Monthly != 0 {
+			d.Retention.Monthly = e.Retention.Monthly
+		}
+	}
+	return d
+}
+
+// matches reports whether every populated match field of e matches
+func (e DatabaseDefaults) matches(db, dbType string, tags map[string]string) bool {
+	return matchDatabase(e.Databases, e.DatabaseTypes, e.Tags, db, dbType, tags)
+}
+
+// Matches reports whether the backups of database db of type dbType,
+// tagged with tags, count against l
+func (l QuotaLimit) Matches(db, dbType string, tags map[string]string) bool {
+	return matchDatabase(l.Databases, l.DatabaseTypes, l.Tags, db, dbType, tags)
+}
+
+// Enrolls reports whether database db of the server is enrolled: it
+// matches an include pattern and no exclude pattern
+func (s DiscoveryServer) Enrolls(db string) bool {
+	if len(s.Exclude) > 0 && matchAny(s.Exclude, db) {
+		return false
+	}
+	return matchAny(s.Include, db)
+}
+
+// matchDatabase reports whether a database matches the database and type
+// patterns and every tag pattern
+func matchDatabase(databases, dbTypes []string, tagPatterns map[string]string, db, dbType string, tags map[string]string) bool {
+	if !matchAny(databases, db) || !matchAny(dbTypes, dbType) {
+		return false
+	}
+	for key, pattern := range tagPatterns {
+		value, ok := tags[key]
+		if !ok || !globMatch(pattern, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether value matches one of the glob patterns. An empty
+// pattern list matches everything.
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if globMatch(p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// validPattern returns the syntax error of a glob pattern
+func validPattern(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// globMatch matches shell-style patterns case-insensitively; malformed
+// patterns fall back to an exact comparison
+func globMatch(pattern, value string) bool {
+	pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+	ok, err := path.Match(pattern, value)
+	if err != nil {
+		return pattern == value
+	}
+	return ok
+}
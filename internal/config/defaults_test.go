@@ -0,0 +1,127 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupDefaults(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"config.yaml": `backup:
+  default_compression: gzip
+  retention:
+    daily: 7
+    weekly: 4
+  database_defaults:
+    - databases: ["prod-*"]
+      compression: zstd
+      encrypt: true
+      storage: s3
+      retention:
+        daily: 30
+    - database_types: [postgres]
+      tags:
+        tier: "gold*"
+      compression_level: 9
+    - databases: [prod-scratch]
+      encrypt: false
+`})
+	cfg, err := Parse(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The loader's defaults, under the file
+	base := BackupDefaults{Compression: "gzip", CompressionLevel: 3, Storage: "local", Retention: RetentionConfig{Daily: 7, Weekly: 4, Monthly: 12}}
+	prod := base
+	prod.Compression, prod.Encrypt, prod.Storage, prod.Retention.Daily = "zstd", true, "s3", 30
+	// Every matching entry applies, later ones over earlier ones
+	scratch := prod
+	scratch.CompressionLevel, scratch.Encrypt = 9, false
+
+	for _, tc := range []struct {
+		db, dbType string
+		tags       map[string]string
+		want       BackupDefaults
+	}{
+		{"orders", "mysql", nil, base},
+		{"PROD-orders", "mysql", nil, prod},
+		{"prod-scratch", "postgres", map[string]string{"tier": "gold-eu"}, scratch},
+		// All tags given must match
+		{"orders", "postgres", map[string]string{"tier": "silver"}, base},
+	} {
+		if got := cfg.BackupDefaults(tc.db, tc.dbType, tc.tags); got != tc.want {
+			t.Errorf("BackupDefaults(%s, %s, %v) = %+v, want %+v", tc.db, tc.dbType, tc.tags, got, tc.want)
+		}
+	}
+}
+
+func TestCheckDatabaseDefaults(t *testing.T) {
+	on := true
+	c := &checker{}
+	checkDatabaseDefaults(c, BackupConfig{DatabaseDefaults: []DatabaseDefaults{
+		{Databases: []string{"prod-*"}, Compression: "zstd", Encrypt: &on, EncryptionKeyFile: "/etc/db-backup/prod.key"},
+		{Databases: []string{"ok", "[bad"}, Compression: "brotli", CompressionLevel: 12, Encrypt: &on},
+	}})
+
+	want := []string{
+		"backup.database_defaults[0].encryption_key_file",
+		"backup.database_defaults[1].databases[1]",
+		"backup.database_defaults[1].compression",
+		"backup.database_defaults[1].compression_level",
+		"backup.database_defaults[1].encryption_key_file",
+	}
+	var got []string
+	for _, e := range c.errs {
+		got = append(got, e.Path)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("errors = %v, want %v", c.errs, want)
+	}
+}
+
+func TestDiscoveryServerEnrolls(t *testing.T) {
+	s := DiscoveryServer{Include: []string{"shop_*", "billing"}, Exclude: []string{"*_test"}}
+	for db, want := range map[string]bool{
+		"shop_eu":      true,
+		"Billing":      true,
+		"shop_eu_test": false,
+		"analytics":    false,
+	} {
+		if got := s.Enrolls(db); got != want {
+			t.Errorf("Enrolls(%s) = %v, want %v", db, got, want)
+		}
+	}
+	if !(DiscoveryServer{}).Enrolls("anything") {
+		t.Error("a server without patterns does not enroll every database")
+	}
+}
+
+func TestCheckDiscovery(t *testing.T) {
+	c := &checker{}
+	checkDiscovery(c, &Config{
+		Connections: map[string]ConnectionProfile{"pg-main": {Type: "postgres"}},
+		Discovery: DiscoveryConfig{
+			Enabled:  true,
+			Interval: time.Hour,
+			Servers: map[string]DiscoveryServer{
+				"main":  {Profile: "pg-main", Cron: "0 2 * * *", Exclude: []string{"[bad"}},
+				"other": {Profile: "missing"},
+			},
+		},
+	})
+
+	got := map[string]bool{}
+	for _, e := range c.errs {
+		got[e.Path] = true
+	}
+	for _, path := range []string{"discovery.servers.main.exclude[0]", "discovery.servers.other.profile", "discovery.servers.other.cron"} {
+		if !got[path] {
+			t.Errorf("no error for %s: %v", path, c.errs)
+		}
+	}
+	if len(c.errs) != 3 {
+		t.Errorf("errors = %v", c.errs)
+	}
+}
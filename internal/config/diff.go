@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// envPrefix is the prefix used for environment variable overrides
+const envPrefix = "DBBACKUP"
+
+// Source identifies where an effective configuration value came from
+type Source string
+
+const (
+	// SourceDefault means the value is a built-in default
+	SourceDefault Source = "default"
+	// SourceFile means the value was read from the config file
+	SourceFile Source = "file"
+	// SourceEnv means the value was overridden by an environment variable
+	SourceEnv Source = "env"
+)
+
+// SettingDiff describes a single configuration key and how its effective
+// value relates to the value written in the config file
+type SettingDiff struct {
+	Key            string      `json:"key" yaml:"key"`
+	FileValue      interface{} `json:"file_value,omitempty" yaml:"file_value,omitempty"`
+	EffectiveValue interface{} `json:"effective_value,omitempty" yaml:"effective_value,omitempty"`
+	Source         Source      `json:"source" yaml:"source"`
+	EnvVar         string      `json:"env_var,omitempty" yaml:"env_var,omitempty"`
+}
+
+// Changed reports whether the effective value differs from the file value
+func (d SettingDiff) Changed() bool {
+	return fmt.Sprint(d.FileValue) != fmt.Sprint(d.EffectiveValue)
+}
+
+// DiffResult holds the outcome of comparing file and effective configuration
+type DiffResult struct {
+	ConfigFile string        `json:"config_file" yaml:"config_file"`
+	Settings   []SettingDiff `json:"settings" yaml:"settings"`
+}
+
+// Diff compares the effective configuration (defaults, file and environment
+// overrides combined) against the raw contents of the config file
+func Diff(configPath string) (*DiffResult, error) {
+	effective, err := newViper(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DiffResult{ConfigFile: effective.ConfigFileUsed()}
+
+	// File values are those of every file read: includes and the
+	// environment's overlay too
+	fileValues := make(map[string]interface{})
+	if result.ConfigFile != "" {
+		settings, err := fileSettings(result.ConfigFile, Environment())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		flatten("", settings, fileValues)
+	}
+
+	effectiveValues := make(map[string]interface{})
+	flatten("", effective.AllSettings(), effectiveValues)
+
+	for _, key := range unionKeys(fileValues, effectiveValues) {
+		fileValue, inFile := fileValues[key]
+		setting := SettingDiff{
+			Key:            key,
+			FileValue:      fileValue,
+			EffectiveValue: effectiveValues[key],
+			Source:         SourceDefault,
+		}
+
+		envVar := EnvVarName(key)
+		if _, ok := os.LookupEnv(envVar); ok {
+			setting.Source = SourceEnv
+			setting.EnvVar = envVar
+		} else if inFile {
+			setting.Source = SourceFile
+		}
+
+		result.Settings = append(result.Settings, setting)
+	}
+
+	return result, nil
+}
+
+// EnvVarName returns the environment variable that overrides a config key
+func EnvVarName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Keys returns the dotted key of every leaf setting in the Config struct
+func Keys() []string {
+	var keys []string
+	collectKeys("", reflect.TypeOf(Config{}), &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+// bindEnvs binds every known config key, and the <key>_file of each secret,
+// to its environment variable
+func bindEnvs(v *viper.Viper) {
+	for _, key := range Keys() {
+		_ = v.BindEnv(key)
+	}
+	for _, key := range secretFileKeys() {
+		_ = v.BindEnv(key)
+	}
+}
+
+// collectKeys walks a struct type and records the mapstructure paths of its leaves
+func collectKeys(prefix string, t reflect.Type, keys *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		key := tag
+		if prefix != "" {
+			key = prefix + "." + tag
+		}
+
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			collectKeys(key, field.Type, keys)
+			continue
+		}
+		*keys = append(*keys, key)
+	}
+}
+
+// flatten converts nested settings maps into dotted keys
+func flatten(prefix string, settings map[string]interface{}, out map[string]interface{}) {
+	for k, v := range settings {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			flatten(key, nested, out)
+			continue
+		}
+		out[key] = v
+	}
+}
+
+// unionKeys returns the sorted union of keys in both maps
+func unionKeys(a, b map[string]interface{}) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		seen[k] = true
+	}
+	for k := range b {
+		seen[k] = true
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestBindEnvsBindsEveryKey(t *testing.T) {
+	// Without AutomaticEnv only explicit bindings are read
+	v := viper.New()
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	bindEnvs(v)
+
+	keys := append(Keys(), secretFileKeys()...)
+	if len(keys) < 100 {
+		t.Fatalf("only %d keys collected", len(keys))
+	}
+	for _, key := range keys {
+		t.Setenv(EnvVarName(key), "from-env")
+		if got := v.Get(key); got != "from-env" {
+			t.Errorf("%s is not bound to %s", key, EnvVarName(key))
+		}
+	}
+}
+
+func TestLoadReadsEnvWithoutDefault(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(writeFiles(t, map[string]string{"config.yaml": minimalConfig(dir)}), "config.yaml")
+	// Neither key has a default or a value in the file
+	t.Setenv("DBBACKUP_STORAGE_PROVIDERS_S3_ENDPOINT", "http://minio:9000")
+	t.Setenv("DBBACKUP_NOTIFICATIONS_SLACK_CHANNEL", "#backups")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Storage.Providers.S3.Endpoint != "http://minio:9000" || cfg.Notifications.Slack.Channel != "#backups" {
+		t.Errorf("environment ignored: %q, %q", cfg.Storage.Providers.S3.Endpoint, cfg.Notifications.Slack.Channel)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(writeFiles(t, map[string]string{"config.yaml": minimalConfig(dir) + `server:
+  port: 9000
+  host: 10.0.0.1
+`}), "config.yaml")
+	t.Setenv("DBBACKUP_SERVER_HOST", "127.0.0.1")
+
+	result, err := Diff(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ConfigFile != path {
+		t.Errorf("config file %q", result.ConfigFile)
+	}
+	settings := map[string]SettingDiff{}
+	for _, s := range result.Settings {
+		settings[s.Key] = s
+	}
+
+	want := map[string]SettingDiff{
+		"server.port": {Key: "server.port", FileValue: 9000, EffectiveValue: 9000, Source: SourceFile},
+		"server.host": {Key: "server.host", FileValue: "10.0.0.1", EffectiveValue: "127.0.0.1", Source: SourceEnv, EnvVar: "DBBACKUP_SERVER_HOST"},
+		"server.mode": {Key: "server.mode", EffectiveValue: "development", Source: SourceDefault},
+	}
+	for key, w := range want {
+		got, ok := settings[key]
+		if !ok {
+			t.Errorf("%s missing", key)
+			continue
+		}
+		if got.Source != w.Source || got.EnvVar != w.EnvVar ||
+			fmt.Sprint(got.FileValue) != fmt.Sprint(w.FileValue) || fmt.Sprint(got.EffectiveValue) != fmt.Sprint(w.EffectiveValue) {
+			t.Errorf("%s = %+v, want %+v", key, got, w)
+		}
+	}
+	if !settings["server.host"].Changed() || settings["server.port"].Changed() {
+		t.Error("Changed() does not compare the file and effective values")
+	}
+}
+
+func TestEnvVarName(t *testing.T) {
+	if got := EnvVarName("storage.providers.s3.access_key"); got != "DBBACKUP_STORAGE_PROVIDERS_S3_ACCESS_KEY" {
+		t.Errorf("EnvVarName() = %s", got)
+	}
+}
@@ -0,0 +1,249 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the layout of config files this release reads. Files
+// declare theirs with the version key; files without one are version 1.
+// Files of older layouts are upgraded as they are read, with a summary of
+// the changes printed to MigrationOutput.
+const CurrentVersion = 2
+
+// versionKey holds the layout version of a config file
+const versionKey = "version"
+
+// MigrationOutput receives the summary of the changes made to config files
+// of older layouts as they are read
+var MigrationOutput io.Writer = os.Stderr
+
+// migration upgrades the settings of one config file from version from to
+// the next. keys are the settings it may change, so files holding none of
+// them are read as they are.
+type migration struct {
+	from    int
+	keys    []string
+	migrate func(settings map[string]interface{}) []string
+}
+
+// migrations are the upgrades to CurrentVersion, in order
+var migrations = []migration{
+	{
+		from:    1,
+		keys:    []string{"backup.encryption.key_rotation.rotation_interval", "tracing.jaeger", "tracing.provider"},
+		migrate: migrateV1,
+	},
+}
+
+// migrateV1 upgrades version 1: key_rotation.rotation_interval is named
+// interval like every other interval, and the Jaeger and Zipkin tracing
+// providers, replaced by OTLP which both accept, are gone with the jaeger
+// section
+func migrateV1(settings map[string]interface{}) []string {
+	changes := moveKey(settings, "backup.encryption.key_rotation.rotation_interval", "backup.encryption.key_rotation.interval")
+
+	tracing, _ := settings["tracing"].(map[string]interface{})
+	if tracing == nil {
+		return changes
+	}
+	provider, _ := tracing["provider"].(string)
+	if jaeger, ok := tracing["jaeger"].(map[string]interface{}); ok {
+		changes = append(changes, moveKey(settings, "tracing.jaeger.service_name", "tracing.service_name")...)
+		changes = append(changes, moveKey(settings, "tracing.jaeger.tags", "tracing.resource_attributes")...)
+		if endpoint := jaegerOTLPEndpoint(jaeger); provider == "jaeger" && endpoint != "" {
+			otlp, _ := tracing["otlp"].(map[string]interface{})
+			if otlp == nil {
+				otlp = map[string]interface{}{}
+				tracing["otlp"] = otlp
+			}
+			if isEmpty(otlp["endpoint"]) {
+				otlp["endpoint"] = endpoint
+				changes = append(changes, fmt.Sprintf("tracing.jaeger endpoint replaced by tracing.otlp.endpoint %s, Jaeger's OTLP/gRPC port", endpoint))
+			}
+		}
+		delete(tracing, "jaeger")
+		changes = append(changes, "tracing.jaeger removed, spans are exported over OTLP")
+	}
+	switch provider {
+	case "jaeger":
+		tracing["provider"] = "otlp"
+		changes = append(changes, "tracing.provider jaeger replaced by otlp, which Jaeger accepts")
+	case "zipkin":
+		tracing["provider"] = "otlp"
+		changes = append(changes, "tracing.provider zipkin replaced by otlp: point tracing.otlp.endpoint at an OpenTelemetry collector exporting to Zipkin")
+	}
+	return changes
+}
+
+// jaegerOTLPEndpoint returns the OTLP/gRPC endpoint of the Jaeger a
+// version 1 jaeger section points at, "" when it names none
+func jaegerOTLPEndpoint(jaeger map[string]interface{}) string {
+	host := ""
+	if endpoint, _ := jaeger["endpoint"].(string); endpoint != "" {
+		if u, err := url.Parse(endpoint); err == nil {
+			host = u.Hostname()
+		}
+	}
+	if host == "" {
+		host, _ = jaeger["agent_host"].(string)
+	}
+	if host == "" {
+		return ""
+	}
+	return net.JoinHostPort(host, "4317")
+}
+
+// moveKey moves the setting at the dotted path from to the path to. A
+// value already at to wins, and the old one is dropped.
+func moveKey(settings map[string]interface{}, from, to string) []string {
+	parent, key := lookupParent(settings, from, false)
+	if parent == nil {
+		return nil
+	}
+	value, ok := parent[key]
+	if !ok {
+		return nil
+	}
+	delete(parent, key)
+	target, targetKey := lookupParent(settings, to, true)
+	if _, set := target[targetKey]; set && !isEmpty(target[targetKey]) {
+		return []string{fmt.Sprintf("%s removed, %s is set", from, to)}
+	}
+	target[targetKey] = value
+	return []string{fmt.Sprintf("%s renamed to %s", from, to)}
+}
+
+// lookupParent returns the map holding the dotted path in settings and the
+// last key of the path, creating the maps on the way when create is set
+func lookupParent(settings map[string]interface{}, path string, create bool) (map[string]interface{}, string) {
+	keys := strings.Split(path, ".")
+	m := settings
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			if !create {
+				return nil, ""
+			}
+			next = map[string]interface{}{}
+			m[k] = next
+		}
+		m = next
+	}
+	return m, keys[len(keys)-1]
+}
+
+// fileVersion returns the layout version of the settings of a config file
+func fileVersion(settings map[string]interface{}) (int, error) {
+	value, ok := settings[versionKey]
+	if !ok {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(fmt.Sprint(value)))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("%s: must be a layout version such as %d, got %s", versionKey, CurrentVersion, describe(value))
+	}
+	if version > CurrentVersion {
+		return 0, fmt.Errorf("%s: the file is of layout version %d, this release reads version %d and older", versionKey, version, CurrentVersion)
+	}
+	return version, nil
+}
+
+// migrate upgrades the settings of a config file to CurrentVersion in
+// place and returns the version it was of and the changes made
+func migrate(settings map[string]interface{}) (int, []string, error) {
+	version, err := fileVersion(settings)
+	if err != nil {
+		return 0, nil, err
+	}
+	var changes []string
+	for _, m := range migrations {
+		if m.from >= version {
+			changes = append(changes, m.migrate(settings)...)
+		}
+	}
+	if version < CurrentVersion && len(changes) > 0 {
+		settings[versionKey] = CurrentVersion
+	}
+	return version, changes, nil
+}
+
+// migrateLayer upgrades the settings read from the config file at path,
+// printing what changed
+func migrateLayer(path string, settings map[string]interface{}) error {
+	version, changes, err := migrate(settings)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	fmt.Fprintf(MigrationOutput, "%s is of config layout version %d, upgraded to %d as it was read:\n", path, version, CurrentVersion)
+	for _, change := range changes {
+		fmt.Fprintf(MigrationOutput, "  - %s\n", change)
+	}
+	fmt.Fprintf(MigrationOutput, "Run \"db-backup config migrate --file %s\" to update the file.\n", path)
+	return nil
+}
+
+// needsMigration reports whether the config file read into v is of an
+// older layout holding settings that changed since, or of a version it
+// cannot be read as
+func needsMigration(v *viper.Viper) bool {
+	version := 1
+	if v.InConfig(versionKey) {
+		n, err := strconv.Atoi(strings.TrimSpace(v.GetString(versionKey)))
+		if err != nil || n < 1 || n > CurrentVersion {
+			return true
+		}
+		version = n
+	}
+	for _, m := range migrations {
+		if m.from < version {
+			continue
+		}
+		for _, key := range m.keys {
+			if v.InConfig(key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MigrateFile returns the config file at path, or the one found in the
+// standard search paths when path is empty, upgraded to CurrentVersion as
+// YAML, and the changes made. Includes and overlays are files of their own
+// and are not followed. Comments are not kept.
+func MigrateFile(path string) ([]byte, []string, error) {
+	v := viper.New()
+	setConfigFile(v, path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, nil, err
+	}
+	path = v.ConfigFileUsed()
+	if isSOPSEncrypted(v) {
+		return nil, nil, fmt.Errorf("%s is encrypted with sops: decrypt it with sops --decrypt, migrate it and encrypt it again", path)
+	}
+	settings := v.AllSettings()
+	_, changes, err := migrate(settings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	settings[versionKey] = CurrentVersion
+	data, err := yaml.Marshal(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(changes)
+	return data, changes, nil
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const v1Config = `server:
+  port: 8080
+backup:
+  encryption:
+    key_rotation:
+      enabled: true
+      rotation_interval: 720h
+tracing:
+  enabled: true
+  provider: jaeger
+  jaeger:
+    endpoint: http://jaeger.internal:14268/api/traces
+    service_name: backups
+    tags:
+      team: dba
+`
+
+// captureMigrations collects what migrations print for the test
+func captureMigrations(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var out bytes.Buffer
+	saved := MigrationOutput
+	MigrationOutput = &out
+	t.Cleanup(func() { MigrationOutput = saved })
+	return &out
+}
+
+func TestParseMigratesOlderLayouts(t *testing.T) {
+	out := captureMigrations(t)
+	dir := writeFiles(t, map[string]string{"config.yaml": v1Config})
+	path := filepath.Join(dir, "config.yaml")
+
+	cfg, err := Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Backup.Encryption.KeyRotation.Interval; got != "720h" {
+		t.Errorf("key_rotation.interval = %q", got)
+	}
+	tr := cfg.Tracing
+	if tr.Provider != "otlp" || tr.ServiceName != "backups" || tr.OTLP.Endpoint != "jaeger.internal:4317" {
+		t.Errorf("tracing = %s %s %s", tr.Provider, tr.ServiceName, tr.OTLP.Endpoint)
+	}
+	if tr.ResourceAttributes["team"] != "dba" {
+		t.Errorf("resource_attributes = %v", tr.ResourceAttributes)
+	}
+	summary := out.String()
+	for _, want := range []string{
+		path + " is of config layout version 1, upgraded to 2",
+		"rotation_interval renamed to backup.encryption.key_rotation.interval",
+		"tracing.provider jaeger replaced by otlp",
+		"config migrate --file " + path,
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary lacks %q:\n%s", want, summary)
+		}
+	}
+}
+
+func TestParseCurrentLayoutUntouched(t *testing.T) {
+	out := captureMigrations(t)
+	dir := writeFiles(t, map[string]string{
+		// Unversioned but nothing that changed since
+		"old.yaml": "server:\n  port: 9000\ntracing:\n  provider: otlp\n",
+		"new.yaml": "version: 2\nserver:\n  port: 9000\n",
+	})
+	for _, name := range []string{"old.yaml", "new.yaml"} {
+		cfg, err := Parse(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.Server.Port != 9000 {
+			t.Errorf("%s: port = %d", name, cfg.Server.Port)
+		}
+	}
+	if out.Len() > 0 {
+		t.Errorf("printed %q", out.String())
+	}
+}
+
+func TestParseMigratesIncludes(t *testing.T) {
+	out := captureMigrations(t)
+	dir := writeFiles(t, map[string]string{
+		"config.yaml":         "version: 2\nincludes:\n  - conf.d/*.yaml\n",
+		"conf.d/tracing.yaml": "tracing:\n  provider: zipkin\n",
+	})
+
+	cfg, err := Parse(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tracing.Provider != "otlp" {
+		t.Errorf("provider = %q", cfg.Tracing.Provider)
+	}
+	if !strings.Contains(out.String(), filepath.Join(dir, "conf.d", "tracing.yaml")) {
+		t.Errorf("summary does not name the include:\n%s", out.String())
+	}
+}
+
+func TestParseRejectsUnknownVersions(t *testing.T) {
+	captureMigrations(t)
+	dir := writeFiles(t, map[string]string{
+		"newer.yaml": "version: 3\n",
+		"bad.yaml":   "version: two\n",
+	})
+	for name, want := range map[string]string{
+		"newer.yaml": "layout version 3, this release reads version 2 and older",
+		"bad.yaml":   "must be a layout version",
+	} {
+		_, err := Parse(filepath.Join(dir, name))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", name, err, want)
+		}
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	captureMigrations(t)
+	dir := writeFiles(t, map[string]string{"config.yaml": v1Config})
+
+	data, changes, err := MigrateFile(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 6 {
+		t.Errorf("changes = %q", changes)
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings[versionKey] != CurrentVersion {
+		t.Errorf("version = %v", settings[versionKey])
+	}
+	tracing := settings["tracing"].(map[string]interface{})
+	if _, ok := tracing["jaeger"]; ok {
+		t.Error("tracing.jaeger kept")
+	}
+	// The migrated file reads back without changes
+	dir = writeFiles(t, map[string]string{"config.yaml": string(data)})
+	if _, changes, err := MigrateFile(filepath.Join(dir, "config.yaml")); err != nil || len(changes) != 0 {
+		t.Errorf("remigrated: %q, %v", changes, err)
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvironmentEnv selects the environment whose overlay is merged over the
+// config file: with prod, config.prod.yaml next to config.yaml. It is read
+// from the environment only (the CLI's --env flag sets it), since it is
+// needed before the config file can be read.
+const EnvironmentEnv = envPrefix + "_ENV"
+
+// includesKey lists the files a config file is assembled from
+const includesKey = "includes"
+
+// Environment returns the selected environment, empty for none
+func Environment() string {
+	return strings.TrimSpace(os.Getenv(EnvironmentEnv))
+}
+
+// OverlayPath returns the overlay of env for the config file at path:
+// the environment's name inserted before the extension
+func OverlayPath(path, env string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + env + ext
+}
+
+// applyLayers replaces the settings read from the config file in v with
+// the file's layers: its includes, the file itself, then the overlay of
+// the selected environment. v is left alone when there is nothing to
+// merge or migrate, so plain files read exactly as before.
+func applyLayers(v *viper.Viper) error {
+	path := v.ConfigFileUsed()
+	env := Environment()
+	if env != "" && strings.ContainsAny(env, `/\`) {
+		return fmt.Errorf("invalid environment %q", env)
+	}
+	if !v.IsSet(includesKey) && env == "" && !needsMigration(v) {
+		return nil
+	}
+	settings, err := fileSettings(path, env)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	// YAML reads back whatever format the layers were written in
+	v.SetConfigType("yaml")
+	return v.ReadConfig(bytes.NewReader(data))
+}
+
+// fileSettings returns the settings of the config file at path merged
+// with its includes and, when env is set, the environment's overlay
+func fileSettings(path, env string) (map[string]interface{}, error) {
+	settings, err := readLayer(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if env == "" {
+		return settings, nil
+	}
+	overlay := OverlayPath(path, env)
+	if _, err := os.Stat(overlay); err != nil {
+		return nil, fmt.Errorf("environment %s: no overlay %s: %w", env, overlay, err)
+	}
+	layer, err := readLayer(overlay, nil)
+	if err != nil {
+		return nil, err
+	}
+	deepMerge(settings, layer)
+	return settings, nil
+}
+
+// readLayer reads the file at path, sops-encrypted or not and upgraded to
+// CurrentVersion, over the files it includes. Includes are paths or globs relative to the including
+// file, merged in order; a glob may match nothing. stack holds the files
+// being read, to refuse include cycles.
+func readLayer(path string, stack []string) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range stack {
+		if p == abs {
+			return nil, fmt.Errorf("config include cycle: %s", strings.Join(append(stack, abs), " -> "))
+		}
+	}
+	stack = append(stack, abs)
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := readConfigFile(v); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	own := v.AllSettings()
+	if err := migrateLayer(path, own); err != nil {
+		return nil, err
+	}
+	includes := v.GetStringSlice(includesKey)
+	delete(own, includesKey)
+
+	settings := make(map[string]interface{})
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		files := []string{include}
+		if strings.ContainsAny(include, "*?[") {
+			if files, err = filepath.Glob(include); err != nil {
+				return nil, fmt.Errorf("%s: includes: %w", path, err)
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			layer, err := readLayer(file, stack)
+			if err != nil {
+				return nil, err
+			}
+			deepMerge(settings, layer)
+		}
+	}
+	deepMerge(settings, own)
+	return settings, nil
+}
+
+// deepMerge merges src into dst: maps are merged key by key, anything
+// else, lists included, replaces what dst holds
+func deepMerge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				deepMerge(dm, sm)
+				continue
+			}
+			copied := make(map[string]interface{}, len(sm))
+			deepMerge(copied, sm)
+			dst[k] = copied
+			continue
+		}
+		dst[k] = v
+	}
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, by name, into a new directory and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParseIncludesAndOverlay(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml": `includes:
+  - conf.d/*.yaml
+server:
+  port: 8080
+  host: 127.0.0.1
+backup:
+  parallel_operations: 2
+`,
+		"conf.d/10-storage.yaml": `storage:
+  default_provider: s3
+server:
+  port: 9000
+`,
+		"conf.d/20-notify.yaml": `notifications:
+  slack:
+    channel: "#db"
+`,
+		"config.prod.yaml": `server:
+  host: 0.0.0.0
+backup:
+  parallel_operations: 8
+`,
+	})
+	path := filepath.Join(dir, "config.yaml")
+
+	cfg, err := Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file wins over what it includes
+	if cfg.Server.Port != 8080 || cfg.Server.Host != "127.0.0.1" {
+		t.Errorf("server = %s:%d", cfg.Server.Host, cfg.Server.Port)
+	}
+	if cfg.Storage.DefaultProvider != "s3" || cfg.Notifications.Slack.Channel != "#db" {
+		t.Errorf("included settings missing: %q %q", cfg.Storage.DefaultProvider, cfg.Notifications.Slack.Channel)
+	}
+
+	// The overlay wins over both, leaving the rest of each section alone
+	t.Setenv(EnvironmentEnv, "prod")
+	cfg, err = Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Host != "0.0.0.0" || cfg.Server.Port != 8080 || cfg.Backup.ParallelOperations != 8 {
+		t.Errorf("server = %s:%d, parallel operations %d", cfg.Server.Host, cfg.Server.Port, cfg.Backup.ParallelOperations)
+	}
+	if cfg.Storage.DefaultProvider != "s3" {
+		t.Errorf("storage.default_provider = %q", cfg.Storage.DefaultProvider)
+	}
+
+	result, err := Diff(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range result.Settings {
+		if s.Key == "server.host" && (s.Source != SourceFile || s.FileValue != "0.0.0.0") {
+			t.Errorf("diff: %+v", s)
+		}
+		if s.Key == includesKey {
+			t.Errorf("diff lists %s", includesKey)
+		}
+	}
+}
+
+func TestParseMissingOverlay(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"config.yaml": "server:\n  port: 8080\n"})
+	t.Setenv(EnvironmentEnv, "staging")
+	_, err := Parse(filepath.Join(dir, "config.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "config.staging.yaml") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestParseIncludeErrors(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"missing": {"config.yaml": "includes: [missing.yaml]\n"},
+		"cycle": {
+			"config.yaml": "includes: [a.yaml]\n",
+			"a.yaml":      "includes: [config.yaml]\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, files)
+			if _, err := Parse(filepath.Join(dir, "config.yaml")); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestOverlayPath(t *testing.T) {
+	if got := OverlayPath("/etc/db-backup/config.yaml", "prod"); got != "/etc/db-backup/config.prod.yaml" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,422 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// SchemaID identifies the JSON Schema of the configuration file
+const SchemaID = "https://github.com/sanskarpan/db-backup/config.schema.json"
+
+// Schema is the subset of JSON Schema (draft 2020-12) describing the
+// configuration file. Type is a type name or a list of them;
+// AdditionalProperties is false for sections, whose keys are all known,
+// and the schema of the values of maps.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	MinLength            int                `json:"minLength,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	If                   *Schema            `json:"if,omitempty"`
+	Then                 *Schema            `json:"then,omitempty"`
+}
+
+// durationPattern matches Go durations such as 90s or 1h30m
+const durationPattern = `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$|^0$`
+
+// schemaEnums lists the values of keys taking one of a fixed set, matched
+// as written
+var schemaEnums = map[string][]string{
+	"server.mode":                          {"development", "production"},
+	"logging.level":                        {"debug", "info", "warn", "error", "fatal", "panic"},
+	"logging.format":                       {"json", "text"},
+	"logging.output":                       {"stdout", "file"},
+	"backup.default_compression":           {"gzip", "zstd", "lz4", "none"},
+	"backup.vss":                           {"auto", "always", "never"},
+	"backup.encryption.algorithm":          {"aes-256-gcm", "chacha20-poly1305"},
+	"backup.encryption.key_store":          {"file", "vault"},
+	"notifications.webhook.method":         {"POST", "PUT", "PATCH"},
+	"notifications.pagerduty.severity":     {"critical", "error", "warning", "info"},
+	"notifications.email.digest.frequency": {"daily", "weekly"},
+	"metrics.backend":                      {"prometheus", "statsd"},
+	"tracing.provider":                     {"otlp"},
+}
+
+// schemaRequiredWhenEnabled lists, by section, the keys a section needs
+// once its enabled key is true
+var schemaRequiredWhenEnabled = map[string][]string{
+	"server.tls":              {"cert_file", "key_file"},
+	"server.agents":           {"listen"},
+	"storage.providers.s3":    {"region", "bucket"},
+	"storage.providers.gcs":   {"bucket"},
+	"storage.providers.azure": {"account_name", "container"},
+	"storage.providers.local": {"path"},
+	"storage.cache":           {"directory"},
+	"notifications.slack":     {"webhook_url"},
+	"notifications.email":     {"smtp_host", "from"},
+	"notifications.webhook":   {"url"},
+	"notifications.discord":   {"webhook_url"},
+	"notifications.telegram":  {"bot_token", "chat_id"},
+	"notifications.pagerduty": {"routing_key"},
+	"notifications.queue":     {"directory"},
+	"security.ldap":           {"url", "user_base_dn"},
+	"security.audit":          {"log_file"},
+}
+
+// ConfigSchema returns the JSON Schema of the configuration file,
+// generated from the Config struct
+func ConfigSchema() *Schema {
+	s := schemaFor(reflect.TypeOf(Config{}))
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+	s.ID = SchemaID
+	s.Title = "db-backup configuration"
+	// Read by the loader, not part of Config
+	s.Properties[includesKey] = &Schema{Type: "array", Items: &Schema{Type: "string"}}
+	s.Properties[versionKey] = &Schema{Type: "integer"}
+
+	for path, values := range schemaEnums {
+		if p := s.lookup(path); p != nil {
+			p.Enum = values
+		}
+	}
+	for path, keys := range schemaRequiredWhenEnabled {
+		section := s.lookup(path)
+		if section == nil {
+			continue
+		}
+		then := &Schema{Properties: make(map[string]*Schema, len(keys))}
+		for _, k := range keys {
+			then.Properties[k] = &Schema{MinLength: 1}
+			// A secret can be given as a file instead
+			if file := k + SecretFileSuffix; section.Properties[file] != nil {
+				then.Properties[file] = &Schema{MinLength: 1}
+				then.AllOf = append(then.AllOf, &Schema{AnyOf: []*Schema{{Required: []string{k}}, {Required: []string{file}}}})
+				continue
+			}
+			then.Required = append(then.Required, k)
+		}
+		section.AllOf = append(section.AllOf, &Schema{
+			If:   &Schema{Properties: map[string]*Schema{"enabled": {Const: true}}, Required: []string{"enabled"}},
+			Then: then,
+		})
+	}
+	return s
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// schemaFor returns the schema of values of type t
+func schemaFor(t reflect.Type) *Schema {
+	if t == durationType {
+		return &Schema{Type: []string{"string", "integer"}, Pattern: durationPattern}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem())}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema), AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("mapstructure"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			s.Properties[tag] = schemaFor(t.Field(i).Type)
+			if isSecretField(t.Field(i)) {
+				s.Properties[tag+SecretFileSuffix] = &Schema{Type: "string"}
+			}
+		}
+		return s
+	}
+	return &Schema{}
+}
+
+// lookup returns the schema of a dotted key
+func (s *Schema) lookup(path string) *Schema {
+	for _, k := range strings.Split(path, ".") {
+		if s = s.Properties[k]; s == nil {
+			return nil
+		}
+	}
+	return s
+}
+
+// validateSettings checks the effective settings of v against the schema.
+// Values are checked as leniently as they are decoded: environment
+// variables are strings, so "8080" is a valid integer.
+func validateSettings(v *viper.Viper) FieldErrors {
+	c := &checker{}
+	ConfigSchema().validate(c, "", v.AllSettings())
+	sort.SliceStable(c.errs, func(i, j int) bool { return c.errs[i].Path < c.errs[j].Path })
+	return c.errs
+}
+
+// validate checks value, found at path, against s
+func (s *Schema) validate(c *checker, path string, value interface{}) {
+	if value == nil {
+		return
+	}
+	if !s.typeMatches(value) {
+		c.add(path, "must be %s, got %s", typeNames(s.Type), describe(value))
+		return
+	}
+	if len(s.Enum) > 0 {
+		if str := fmt.Sprint(value); str != "" && !contains(s.Enum, str) {
+			c.add(path, "must be one of %s, got %q", strings.Join(s.Enum, "|"), str)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := join(path, k)
+			switch {
+			case s.Properties[k] != nil:
+				s.Properties[k].validate(c, child, v[k])
+			case s.AdditionalProperties == false:
+				c.add(child, "unknown key%s", suggestion(k, s.Properties))
+			default:
+				if elem, ok := s.AdditionalProperties.(*Schema); ok {
+					elem.validate(c, child, v[k])
+				}
+			}
+		}
+		for _, rule := range s.AllOf {
+			if rule.If != nil && truthy(v["enabled"]) {
+				for _, k := range rule.Then.Required {
+					if isEmpty(v[k]) {
+						c.add(join(path, k), "is required when enabled")
+					}
+				}
+				for _, alternatives := range rule.Then.AllOf {
+					if !anySet(alternatives.AnyOf, v) {
+						k := alternatives.AnyOf[0].Required[0]
+						c.add(join(path, k), "is required when enabled (or %s%s)", k, SecretFileSuffix)
+					}
+				}
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(c, fmt.Sprintf("%s[%d]", path, i), item)
+			}
+		}
+	}
+}
+
+// typeMatches reports whether value decodes as one of the schema's types
+func (s *Schema) typeMatches(value interface{}) bool {
+	var types []string
+	switch t := s.Type.(type) {
+	case string:
+		types = []string{t}
+	case []string:
+		types = t
+	default:
+		return true
+	}
+	for _, t := range types {
+		if decodes(t, s.Pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodes reports whether value decodes as typ, converting strings the way
+// the loader does
+func decodes(typ, pattern string, value interface{}) bool {
+	rv := reflect.ValueOf(value)
+	str, isString := value.(string)
+	switch typ {
+	case "object":
+		return rv.Kind() == reflect.Map
+	case "array":
+		// A string is split into a list
+		return rv.Kind() == reflect.Slice || isString
+	case "boolean":
+		if isString {
+			_, err := strconv.ParseBool(str)
+			return err == nil || str == ""
+		}
+		return rv.Kind() == reflect.Bool
+	case "integer":
+		if isString {
+			_, err := strconv.ParseInt(strings.TrimSpace(str), 0, 64)
+			return err == nil || str == ""
+		}
+		if rv.Kind() == reflect.Float64 || rv.Kind() == reflect.Float32 {
+			return rv.Float() == float64(int64(rv.Float()))
+		}
+		return rv.CanInt() || rv.CanUint()
+	case "number":
+		if isString {
+			_, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+			return err == nil || str == ""
+		}
+		return rv.CanInt() || rv.CanUint() || rv.CanFloat()
+	case "string":
+		if isString && pattern == durationPattern {
+			_, err := time.ParseDuration(str)
+			return err == nil || str == ""
+		}
+		return rv.Kind() != reflect.Map && rv.Kind() != reflect.Slice
+	}
+	return true
+}
+
+// typeNames describes the types of a schema for an error message
+func typeNames(t interface{}) string {
+	switch t := t.(type) {
+	case string:
+		return article(t)
+	case []string:
+		if len(t) == 2 && t[0] == "string" && t[1] == "integer" {
+			return "a duration such as 30s"
+		}
+		names := make([]string, len(t))
+		for i, n := range t {
+			names[i] = article(n)
+		}
+		return strings.Join(names, " or ")
+	}
+	return "a value"
+}
+
+func article(typ string) string {
+	switch typ {
+	case "integer", "array", "object":
+		return "an " + map[string]string{"integer": "integer", "array": "array", "object": "object (a section)"}[typ]
+	}
+	return "a " + typ
+}
+
+// describe names the type and value of value for an error message
+func describe(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case map[string]interface{}:
+		return "a section"
+	case []interface{}:
+		return "a list"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// suggestion proposes the known key closest to an unknown one
+func suggestion(key string, known map[string]*Schema) string {
+	best, bestDistance := "", len(key)/2+1
+	for k := range known {
+		if d := distance(key, k); d < bestDistance || (d == bestDistance && k < best) {
+			best, bestDistance = k, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+// distance returns the Levenshtein distance between a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// truthy reports whether an enabled value is true
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// anySet reports whether section sets every required key of one of
+// alternatives
+func anySet(alternatives []*Schema, section map[string]interface{}) bool {
+	for _, a := range alternatives {
+		set := true
+		for _, k := range a.Required {
+			set = set && !isEmpty(section[k])
+		}
+		if set {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty reports whether a required value is missing
+func isEmpty(value interface{}) bool {
+	return value == nil || strings.TrimSpace(fmt.Sprint(value)) == ""
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSchemaTablesMatchConfig(t *testing.T) {
+	s := ConfigSchema()
+	for path := range schemaEnums {
+		if s.lookup(path) == nil {
+			t.Errorf("enum of unknown key %s", path)
+		}
+	}
+	for path, keys := range schemaRequiredWhenEnabled {
+		section := s.lookup(path)
+		if section == nil || section.Properties["enabled"] == nil {
+			t.Errorf("%s is not a section with an enabled key", path)
+			continue
+		}
+		for _, k := range keys {
+			if section.Properties[k] == nil {
+				t.Errorf("%s requires unknown key %s", path, k)
+			}
+		}
+	}
+	if _, err := json.Marshal(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExampleMatchesSchema(t *testing.T) {
+	example, err := os.ReadFile(filepath.Join("..", "..", "config.yaml.example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeFiles(t, map[string]string{"config.yaml": string(example)})
+	if _, err := Parse(filepath.Join(dir, "config.yaml")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseReportsSchemaErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"config.yaml": `server:
+  port: eighty
+  mode: staging
+storage:
+  providers:
+    s3:
+      enabled: true
+      bucket: backups
+notifications:
+  slack:
+    webhok_url: https://hooks.example.com
+  webhook:
+    timeout: soon
+`})
+
+	_, err := Parse(filepath.Join(dir, "config.yaml"))
+	var errs FieldErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got %v", err)
+	}
+	want := []string{
+		`notifications.slack.webhok_url: unknown key, did you mean webhook_url?`,
+		`notifications.webhook.timeout: must be a duration such as 30s, got "soon"`,
+		`server.mode: must be one of development|production, got "staging"`,
+		`server.port: must be an integer, got "eighty"`,
+		`storage.providers.s3.region: is required when enabled`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %v", errs)
+	}
+	for i, w := range want {
+		if errs[i].Error() != w {
+			t.Errorf("error %d = %q, want %q", i, errs[i].Error(), w)
+		}
+	}
+}
+
+func TestBlankValueIsMissing(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"config.yaml": `storage:
+  providers:
+    local:
+      enabled: true
+      path: " "
+`})
+	if _, err := Parse(filepath.Join(dir, "config.yaml")); err == nil {
+		t.Error("accepted the local provider without a path")
+	}
+}
+
+func TestEnvironmentValuesMatchSchema(t *testing.T) {
+	t.Setenv("DBBACKUP_SERVER_PORT", "9090")
+	t.Setenv("DBBACKUP_STORAGE_PROVIDERS_LOCAL_ENABLED", "true")
+	t.Setenv("DBBACKUP_STORAGE_PROVIDERS_LOCAL_PATH", "/var/backups")
+	cfg, err := Parse(filepath.Join(writeFiles(t, map[string]string{"config.yaml": "{}\n"}), "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9090 {
+		t.Errorf("port = %d", cfg.Server.Port)
+	}
+
+}
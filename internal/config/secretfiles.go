@@ -0,0 +1,184 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/pkg/redact"
+)
+
+// SecretFileSuffix turns the key of a secret setting into the key naming
+// the file to read it from, the way Docker and Kubernetes mount secrets:
+//
+//	password_file: /run/secrets/db_password
+//	DBBACKUP_STORAGE_PROVIDERS_S3_SECRET_KEY_FILE=/run/secrets/s3_secret_key
+const SecretFileSuffix = "_file"
+
+// secretKeys decides which settings are secrets: the redactor's built-in
+// key names, so the keys accepted do not depend on security.redaction.keys
+var secretKeys = redact.New()
+
+// isSecretField reports whether a struct field is a secret setting, which
+// can be read from a file instead
+func isSecretField(field reflect.StructField) bool {
+	tag := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+	return field.Type.Kind() == reflect.String && tag != "" && tag != "-" && secretKeys.IsSensitiveKey(tag)
+}
+
+// secretFileKeys returns the <key>_file keys of the secret settings of
+// sections, those outside maps, which environment variables can set
+func secretFileKeys() []string {
+	var keys []string
+	collectSecretFileKeys("", reflect.TypeOf(Config{}), &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+func collectSecretFileKeys(prefix string, t reflect.Type, keys *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		switch {
+		case isSecretField(field):
+			*keys = append(*keys, joinKey(prefix, tag+SecretFileSuffix))
+		case field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)):
+			collectSecretFileKeys(joinKey(prefix, tag), field.Type, keys)
+		}
+	}
+}
+
+// readSecretFile reads a secret from a file. The trailing newline most
+// tools write is not part of the secret.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path configured by the operator
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// applySecretFiles sets every secret setting whose <key>_file is set in
+// settings to the content of that file, and records the file by key
+func (c *Config) applySecretFiles(settings map[string]interface{}) error {
+	c.secretFiles = make(map[string]string)
+	return applySecretFiles(reflect.ValueOf(c).Elem(), settings, "", c.secretFiles)
+}
+
+// applySecretFiles walks a settable config value alongside the settings it
+// was decoded from; path is the dotted key used in error messages
+func applySecretFiles(v reflect.Value, settings interface{}, path string, files map[string]string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		values, _ := settings.(map[string]interface{})
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("mapstructure"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			key := joinKey(path, tag)
+			if !isSecretField(t.Field(i)) {
+				if err := applySecretFiles(v.Field(i), values[tag], key, files); err != nil {
+					return err
+				}
+				continue
+			}
+			file, _ := values[tag+SecretFileSuffix].(string)
+			if file == "" {
+				continue
+			}
+			if v.Field(i).String() != "" {
+				return fmt.Errorf("%s: set either %s or %s%s, not both", key, tag, tag, SecretFileSuffix)
+			}
+			secret, err := readSecretFile(file)
+			if err != nil {
+				return fmt.Errorf("%s%s: %w", key, SecretFileSuffix, err)
+			}
+			v.Field(i).SetString(secret)
+			files[key] = file
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		values, _ := settings.(map[string]interface{})
+		// Map entries are not addressable, so update a copy and store it back
+		iter := v.MapRange()
+		for iter.Next() {
+			name := fmt.Sprint(iter.Key())
+			entry := reflect.New(v.Type().Elem()).Elem()
+			entry.Set(iter.Value())
+			if err := applySecretFiles(entry, values[name], joinKey(path, name), files); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), entry)
+		}
+	case reflect.Slice:
+		items, _ := settings.([]interface{})
+		for i := 0; i < v.Len() && i < len(items); i++ {
+			if err := applySecretFiles(v.Index(i), items[i], fmt.Sprintf("%s[%d]", path, i), files); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SecretFile returns the file the secret setting key was read from
+func (c *Config) SecretFile(key string) (string, bool) {
+	file, ok := c.secretFiles[key]
+	return file, ok
+}
+
+// WatchSecretFiles checks the files secrets were read from every interval
+// and calls rotated with the keys of those whose content changed, so
+// long-running processes can pick up rotated secrets, e.g. by loading the
+// configuration again. New values are registered with the redactor before
+// rotated is called. It returns when ctx is done.
+func (c *Config) WatchSecretFiles(ctx context.Context, interval time.Duration, rotated func(keys []string)) {
+	if len(c.secretFiles) == 0 {
+		return
+	}
+	sums := make(map[string][sha256.Size]byte, len(c.secretFiles))
+	for key, file := range c.secretFiles {
+		if secret, err := readSecretFile(file); err == nil {
+			sums[key] = sha256.Sum256([]byte(secret))
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var changed []string
+		for key, file := range c.secretFiles {
+			// A file being swapped in may be missing for a moment
+			secret, err := readSecretFile(file)
+			if err != nil {
+				continue
+			}
+			if sum := sha256.Sum256([]byte(secret)); sum != sums[key] {
+				sums[key] = sum
+				redact.AddSecrets(secret)
+				changed = append(changed, key)
+			}
+		}
+		if len(changed) > 0 {
+			sort.Strings(changed)
+			rotated(changed)
+		}
+	}
+}
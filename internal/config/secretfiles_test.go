@@ -0,0 +1,109 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"secrets/db_password":   "s3cret\n",
+		"secrets/s3_secret_key": "AKIA-secret",
+		"config.yaml": `connections:
+  orders:
+    type: postgres
+    host: db-1
+    backup:
+      user: backup
+      password_file: secrets/db_password
+notifications:
+  slack:
+    enabled: true
+    webhook_url_file: secrets/webhook
+`,
+	})
+	// Relative to the working directory, like every other path
+	t.Chdir(dir)
+	if err := os.WriteFile("secrets/webhook", []byte("https://hooks.slack.com/services/T0/B0/x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DBBACKUP_STORAGE_PROVIDERS_S3_SECRET_KEY_FILE", filepath.Join(dir, "secrets", "s3_secret_key"))
+
+	cfg, err := Parse("config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, creds, err := cfg.Connection("orders", PurposeBackup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Password != "s3cret" {
+		t.Errorf("password = %q", creds.Password)
+	}
+	if cfg.Storage.Providers.S3.SecretKey != "AKIA-secret" {
+		t.Errorf("secret key = %q", cfg.Storage.Providers.S3.SecretKey)
+	}
+	if cfg.Notifications.Slack.WebhookURL != "https://hooks.slack.com/services/T0/B0/x" {
+		t.Errorf("webhook = %q", cfg.Notifications.Slack.WebhookURL)
+	}
+	if file, ok := cfg.SecretFile("connections.orders.backup.password"); !ok || file != "secrets/db_password" {
+		t.Errorf("got %q, %v", file, ok)
+	}
+
+	// Rotated: read again by the next connection, and reported
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rotated := make(chan []string, 1)
+	go cfg.WatchSecretFiles(ctx, 10*time.Millisecond, func(keys []string) { rotated <- keys })
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile("secrets/db_password", []byte("rotated\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, creds, _ = cfg.Connection("orders", PurposeBackup); creds.Password != "rotated" {
+		t.Errorf("password = %q after rotation", creds.Password)
+	}
+	select {
+	case keys := <-rotated:
+		if strings.Join(keys, ",") != "connections.orders.backup.password" {
+			t.Errorf("rotated %v", keys)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("rotation not reported")
+	}
+}
+
+func TestSecretFileErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config string
+		want   string
+	}{
+		"both": {
+			config: "security:\n  ldap:\n    bind_password: x\n    bind_password_file: /run/secrets/ldap\n",
+			want:   "security.ldap.bind_password: set either bind_password or bind_password_file, not both",
+		},
+		"missing file": {
+			config: "notifications:\n  telegram:\n    bot_token_file: /nonexistent/bot_token\n",
+			want:   "notifications.telegram.bot_token_file: open /nonexistent/bot_token",
+		},
+		"neither": {
+			config: "notifications:\n  slack:\n    enabled: true\n",
+			want:   "notifications.slack.webhook_url: is required when enabled (or webhook_url_file)",
+		},
+		"not a secret": {
+			config: "server:\n  host_file: /etc/hostname\n",
+			want:   "server.host_file: unknown key",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"config.yaml": tc.config})
+			_, err := Parse(filepath.Join(dir, "config.yaml"))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/secrets"
+	"github.com/sanskarpan/db-backup/pkg/redact"
+)
+
+// SecretValues returns every non-empty credential in the configuration:
+// string settings whose key names a secret (password, token, secret_key,
+// ...), including those nested in maps such as OAuth2 providers. They are
+// registered with the redactor so they never reach logs or notifications.
+func (c *Config) SecretValues() []string {
+	var values []string
+	collectSecrets(reflect.ValueOf(*c), &values)
+	return values
+}
+
+// collectSecrets walks a config value and appends the secret strings
+func collectSecrets(v reflect.Value, values *[]string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("mapstructure"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			field := v.Field(i)
+			if field.Kind() == reflect.String {
+				if redact.IsSensitiveKey(tag) && field.String() != "" {
+					*values = append(*values, field.String())
+				}
+				continue
+			}
+			collectSecrets(field, values)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if iter.Value().Kind() == reflect.String {
+				if redact.IsSensitiveKey(iter.Key().String()) && iter.Value().String() != "" {
+					*values = append(*values, iter.Value().String())
+				}
+				continue
+			}
+			collectSecrets(iter.Value(), values)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			collectSecrets(v.Index(i), values)
+		}
+	}
+}
+
+// ResolveSecrets replaces every setting that references an external secret
+// (vault:, aws-sm:, file:, env: or a scheme registered with the secrets
+// package) with the secret itself, and returns the resolved values so they
+// can be redacted. security.secrets is resolved first and from files and
+// the environment only, since it configures the remote backends.
+func (c *Config) ResolveSecrets(ctx context.Context) ([]string, error) {
+	timeout := c.Security.Secrets.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var resolved []string
+	backends := reflect.ValueOf(&c.Security.Secrets).Elem()
+	if err := resolveRefs(ctx, secrets.NewLocalRegistry(), backends, "security.secrets", &resolved); err != nil {
+		return nil, err
+	}
+
+	s := c.Security.Secrets
+	secrets.Register("vault", secrets.NewVaultResolver(secrets.VaultOptions{
+		Address:   s.Vault.Address,
+		Token:     s.Vault.Token,
+		Namespace: s.Vault.Namespace,
+		KVVersion: s.Vault.KVVersion,
+	}))
+	secrets.Register("aws-sm", secrets.NewAWSResolver(secrets.AWSOptions{
+		Region:   s.AWS.Region,
+		Endpoint: s.AWS.Endpoint,
+	}))
+
+	if err := resolveRefs(ctx, secrets.Default(), reflect.ValueOf(c).Elem(), "", &resolved); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// resolveRefs walks a settable config value and resolves references in
+// place; path is the dotted key used in error messages
+func resolveRefs(ctx context.Context, reg *secrets.Registry, v reflect.Value, path string, resolved *[]string) error {
+	switch v.Kind() {
+	case reflect.String:
+		value, ok, err := reg.Resolve(ctx, v.String())
+		if err != nil {
+			return fmt.Errorf("failed to resolve secret reference in %s: %w", path, err)
+		}
+		if ok {
+			v.SetString(value)
+			*resolved = append(*resolved, value)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("mapstructure"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			if err := resolveRefs(ctx, reg, v.Field(i), joinKey(path, tag), resolved); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// Map entries are not addressable, so resolve a copy and store it back
+		iter := v.MapRange()
+		for iter.Next() {
+			entry := reflect.New(v.Type().Elem()).Elem()
+			entry.Set(iter.Value())
+			if err := resolveRefs(ctx, reg, entry, joinKey(path, fmt.Sprint(iter.Key())), resolved); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), entry)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveRefs(ctx, reg, v.Index(i), fmt.Sprintf("%s[%d]", path, i), resolved); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() && v.Elem().CanSet() {
+			return resolveRefs(ctx, reg, v.Elem(), path, resolved)
+		}
+	}
+	return nil
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// sopsBinaryEnv overrides the sops executable used to decrypt config files.
+// It is read from the environment only, since it is needed before the
+// config file can be read.
+const sopsBinaryEnv = envPrefix + "_SOPS_BINARY"
+
+// sopsTimeout bounds a decryption, which may call out to KMS or a PGP agent
+const sopsTimeout = 2 * time.Minute
+
+// readConfigFile reads the config file into v, transparently decrypting it
+// when it was encrypted with sops (age, PGP, AWS/GCP KMS or Azure Key Vault
+// keys, whichever the file was encrypted for). Keys are looked up by sops
+// itself, e.g. from SOPS_AGE_KEY_FILE or the AWS credential chain.
+func readConfigFile(v *viper.Viper) error {
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	if !isSOPSEncrypted(v) {
+		return nil
+	}
+
+	plain, err := decryptSOPS(v.ConfigFileUsed())
+	if err != nil {
+		return err
+	}
+	return v.ReadConfig(bytes.NewReader(plain))
+}
+
+// isSOPSEncrypted reports whether the loaded file carries sops metadata: a
+// top-level "sops" map in YAML and JSON, flat "sops_*" keys in dotenv and
+// INI files
+func isSOPSEncrypted(v *viper.Viper) bool {
+	return v.IsSet("sops.mac") || v.IsSet("sops_mac")
+}
+
+// decryptSOPS runs "sops --decrypt" on path and returns the plaintext in
+// the same format as the file
+func decryptSOPS(path string) ([]byte, error) {
+	binary := os.Getenv(sopsBinaryEnv)
+	if binary == "" {
+		binary = "sops"
+	}
+	if _, err := exec.LookPath(binary); err != nil {
+		return nil, fmt.Errorf("config file %s is encrypted with sops but %s was not found (install sops or set %s): %w",
+			path, binary, sopsBinaryEnv, err)
+	}
+
+	args := []string{"--decrypt"}
+	if format := sopsFormat(path); format != "" {
+		args = append(args, "--input-type", format, "--output-type", format)
+	}
+	args = append(args, path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sopsTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...) // #nosec G204 -- binary is operator supplied
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("failed to decrypt config file %s: sops timed out after %s", path, sopsTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to decrypt config file %s: %s", path, msg)
+		}
+		return nil, fmt.Errorf("failed to decrypt config file %s: %w", path, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// sopsFormat maps a config file extension to a sops --input-type. Files
+// named like config.enc.yaml are matched on their last extension.
+func sopsFormat(path string) string {
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")) {
+	case "yaml", "yml":
+		return "yaml"
+	case "json":
+		return "json"
+	case "env":
+		return "dotenv"
+	case "ini":
+		return "ini"
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const encryptedConfig = `server:
+    port: ENC[AES256_GCM,data:Vt8=,iv:aXY=,tag:dGFn,type:int]
+security:
+    jwt:
+        secret: ENC[AES256_GCM,data:c2VjcmV0,iv:aXY=,tag:dGFn,type:str]
+sops:
+    age:
+        - recipient: age1qyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqs3290gq
+    lastmodified: "2025-01-01T00:00:00Z"
+    mac: ENC[AES256_GCM,data:bWFj,iv:aXY=,tag:dGFn,type:str]
+    version: 3.9.0
+`
+
+const decryptedConfig = `server:
+    port: 9443
+security:
+    jwt:
+        secret: decrypted-secret-value
+`
+
+// fakeSOPS installs a script standing in for the sops binary that prints
+// output and records its arguments
+func fakeSOPS(t *testing.T, output string, exitCode int) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sops binary is a shell script")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "plain")
+	if err := os.WriteFile(out, []byte(output), 0600); err != nil {
+		t.Fatal(err)
+	}
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\ncat " + out + "\nexit " + strconv.Itoa(exitCode) + "\n"
+	bin := filepath.Join(dir, "sops")
+	if err := os.WriteFile(bin, []byte(script), 0700); err != nil { // #nosec G306 -- test executable
+		t.Fatal(err)
+	}
+	t.Setenv(sopsBinaryEnv, bin)
+	return argsFile
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseSOPSEncrypted(t *testing.T) {
+	argsFile := fakeSOPS(t, decryptedConfig, 0)
+	path := writeConfig(t, "config.enc.yaml", encryptedConfig)
+
+	cfg, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Server.Port != 9443 {
+		t.Errorf("server.port = %d, want 9443", cfg.Server.Port)
+	}
+	if cfg.Security.JWT.Secret != "decrypted-secret-value" {
+		t.Errorf("security.jwt.secret = %q, want decrypted value", cfg.Security.JWT.Secret)
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "--decrypt --input-type yaml --output-type yaml " + path; strings.TrimSpace(string(args)) != want {
+		t.Errorf("sops args = %q, want %q", strings.TrimSpace(string(args)), want)
+	}
+}
+
+func TestParsePlainConfigSkipsSOPS(t *testing.T) {
+	t.Setenv(sopsBinaryEnv, filepath.Join(t.TempDir(), "missing-sops"))
+	path := writeConfig(t, "config.yaml", decryptedConfig)
+
+	cfg, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Server.Port != 9443 {
+		t.Errorf("server.port = %d, want 9443", cfg.Server.Port)
+	}
+}
+
+func TestParseSOPSErrors(t *testing.T) {
+	t.Run("decryption fails", func(t *testing.T) {
+		fakeSOPS(t, "", 1)
+		path := writeConfig(t, "config.yaml", encryptedConfig)
+		if _, err := Parse(path); err == nil || !strings.Contains(err.Error(), "failed to decrypt") {
+			t.Errorf("Parse() error = %v, want decryption failure", err)
+		}
+	})
+
+	t.Run("binary missing", func(t *testing.T) {
+		t.Setenv(sopsBinaryEnv, filepath.Join(t.TempDir(), "missing-sops"))
+		path := writeConfig(t, "config.yaml", encryptedConfig)
+		if _, err := Parse(path); err == nil || !strings.Contains(err.Error(), sopsBinaryEnv) {
+			t.Errorf("Parse() error = %v, want hint about %s", err, sopsBinaryEnv)
+		}
+	})
+}
+
+func TestSOPSFormat(t *testing.T) {
+	tests := map[string]string{
+		"config.yaml":     "yaml",
+		"config.enc.yml":  "yaml",
+		"config.JSON":     "json",
+		"secrets.env":     "dotenv",
+		"config.ini":      "ini",
+		"config.toml":     "",
+		"/etc/db-backup/": "",
+	}
+	for path, want := range tests {
+		if got := sopsFormat(path); got != want {
+			t.Errorf("sopsFormat(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+// Package costopt recommends storage tiers and retention for the backups
+// in the catalog, so their storage stays within a monthly budget. Backups
+// older than the ones restored in the past are moved to colder, cheaper
+// tiers of their provider; while the catalog still costs more than the
+// budget, retention is shortened where that saves the most. Neither goes
+// below what past restores and the recovery point objective of a database
+// need.
+//
+// Costs are modelled on the catalog as it is: the backups a retention
+// policy keeps are picked from it grandfather-father-son style, the newest
+// backup of each of the last daily days, weekly weeks and monthly months.
+package costopt
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+const (
+	gb  = 1 << 30
+	day = 24 * time.Hour
+	// month is the billing month of the price tables
+	month = 30 * day
+)
+
+// DefaultHotDays is how long backups stay in the hot tier of a database
+// without restore history
+const DefaultHotDays = 30
+
+// Backup is a backup in the catalog
+type Backup struct {
+	ID       string
+	Database string
+	Provider string
+	Size     int64 // stored bytes
+	Time     time.Time
+}
+
+// Restore is a past restore of a backup
+type Restore struct {
+	Database   string    `json:"database"`
+	BackupID   string    `json:"backup_id"`
+	BackupTime time.Time `json:"backup_time"`
+	RestoredAt time.Time `json:"restored_at"`
+}
+
+// Age is how old the backup was when it was restored
+func (r Restore) Age() time.Duration {
+	return r.RestoredAt.Sub(r.BackupTime)
+}
+
+// Retention is a grandfather-father-son retention policy; all zero keeps
+// every backup
+type Retention struct {
+	Daily   int `json:"daily" yaml:"daily"`
+	Weekly  int `json:"weekly" yaml:"weekly"`
+	Monthly int `json:"monthly" yaml:"monthly"`
+}
+
+// span is the age of the oldest backup the policy keeps, at most
+func (r Retention) span() time.Duration {
+	if r == (Retention{}) {
+		return math.MaxInt64
+	}
+	return max(time.Duration(r.Daily)*day, time.Duration(r.Weekly)*7*day, time.Duration(r.Monthly)*month)
+}
+
+// Database is the retention and recovery point objective of a database
+type Database struct {
+	Name      string
+	Retention Retention
+	RPO       time.Duration
+}
+
+// Input is what the recommendations are made from
+type Input struct {
+	Now       time.Time
+	Backups   []Backup
+	Restores  []Restore
+	Databases []Database // databases without an entry keep every backup
+	Prices    map[string][]config.StorageTierPrice
+	Budget    float64 // monthly; 0 only recommends tiers
+	Coverage  float64 // share of past restores kept in the hot tier
+}
+
+// TierChange moves the backups of a provider older than AfterDays to a
+// colder tier
+type TierChange struct {
+	Provider  string `json:"provider"`
+	Tier      string `json:"tier"`
+	AfterDays int    `json:"after_days"`
+}
+
+// Recommendation is what is recommended for one database
+type Recommendation struct {
+	Database      string       `json:"database"`
+	Backups       int          `json:"backups"`
+	Bytes         int64        `json:"bytes"`
+	Cost          float64      `json:"cost"`           // monthly, as stored now
+	ProjectedCost float64      `json:"projected_cost"` // monthly, with the recommendations
+	Retention     Retention    `json:"retention"`      // as configured
+	NewRetention  *Retention   `json:"new_retention,omitempty"`
+	Tiers         []TierChange `json:"tiers,omitempty"`
+	// HotDays is how old the backups restored in the past were, covering
+	// the configured share of restores, and at least the RPO
+	HotDays int `json:"hot_days"`
+	// KeepDays is the age of the oldest backup ever restored, retention is
+	// never shortened below it or the RPO
+	KeepDays int `json:"keep_days"`
+	Restores int `json:"restores"`
+}
+
+// Changed reports whether anything is recommended
+func (r Recommendation) Changed() bool {
+	return r.NewRetention != nil || len(r.Tiers) > 0
+}
+
+// Report holds the recommendations for every database
+type Report struct {
+	Budget        float64          `json:"budget,omitempty"`
+	Cost          float64          `json:"cost"`
+	ProjectedCost float64          `json:"projected_cost"`
+	WithinBudget  bool             `json:"within_budget"`
+	Databases     []Recommendation `json:"databases"`
+}
+
+// plan is what is considered for one database
+type plan struct {
+	db        Database
+	backups   []Backup
+	retention Retention
+	tiers     map[string]int // index of the colder tier by provider
+	hot       time.Duration
+	keep      time.Duration
+	minDaily  int
+	oldRate   float64 // restores per month of backups older than hot
+	restores  int
+}
+
+// Analyze recommends tiers and retention for the backups of in
+func Analyze(in Input) *Report {
+	if in.Now.IsZero() {
+		in.Now = time.Now()
+	}
+	if in.Coverage <= 0 || in.Coverage > 1 {
+		in.Coverage = 0.95
+	}
+
+	plans := make(map[string]*plan)
+	for _, db := range in.Databases {
+		plans[db.Name] = &plan{db: db, retention: db.Retention}
+	}
+	for _, b := range in.Backups {
+		p := plans[b.Database]
+		if p == nil {
+			p = &plan{db: Database{Name: b.Database}}
+			plans[b.Database] = p
+		}
+		p.backups = append(p.backups, b)
+	}
+	for _, p := range plans {
+		p.history(in)
+		p.tiers = p.chooseTiers(in, p.retention)
+	}
+
+	// Shorten retention where it saves the most until within budget
+	total := func() float64 {
+		sum := 0.0
+		for _, p := range plans {
+			sum += p.cost(in, p.retention, p.tiers)
+		}
+		return sum
+	}
+	for in.Budget > 0 && total() > in.Budget {
+		var best *plan
+		var bestRetention Retention
+		var bestTiers map[string]int
+		bestSaving := 0.0
+		for _, p := range plans {
+			current := p.cost(in, p.retention, p.tiers)
+			for _, r := range p.shorter() {
+				tiers := p.chooseTiers(in, r)
+				if saving := current - p.cost(in, r, tiers); saving > bestSaving+1e-9 {
+					best, bestRetention, bestTiers, bestSaving = p, r, tiers, saving
+				}
+			}
+		}
+		if best == nil {
+			break
+		}
+		best.retention, best.tiers = bestRetention, bestTiers
+	}
+
+	report := &Report{Budget: in.Budget}
+	for _, p := range plans {
+		rec := Recommendation{
+			Database:      p.db.Name,
+			Backups:       len(p.backups),
+			Cost:          p.cost(in, Retention{}, nil),
+			ProjectedCost: p.cost(in, p.retention, p.tiers),
+			Retention:     p.db.Retention,
+			HotDays:       days(p.hot),
+			KeepDays:      days(p.keep),
+			Restores:      p.restores,
+		}
+		for _, b := range p.backups {
+			rec.Bytes += b.Size
+		}
+		if p.retention != p.db.Retention {
+			r := p.retention
+			rec.NewRetention = &r
+		}
+		for provider, tier := range p.tiers {
+			rec.Tiers = append(rec.Tiers, TierChange{
+				Provider:  provider,
+				Tier:      in.Prices[provider][tier].Tier,
+				AfterDays: days(p.hot),
+			})
+		}
+		sort.Slice(rec.Tiers, func(i, j int) bool { return rec.Tiers[i].Provider < rec.Tiers[j].Provider })
+		report.Cost += rec.Cost
+		report.ProjectedCost += rec.ProjectedCost
+		report.Databases = append(report.Databases, rec)
+	}
+	sort.Slice(report.Databases, func(i, j int) bool { return report.Databases[i].Database < report.Databases[j].Database })
+	report.WithinBudget = in.Budget <= 0 || report.ProjectedCost <= in.Budget
+	return report
+}
+
+// history works out from the past restores of the database how long its
+// backups stay hot and are kept at least
+func (p *plan) history(in Input) {
+	var ages []time.Duration
+	var first time.Time
+	for _, r := range in.Restores {
+		if r.Database != p.db.Name || r.Age() < 0 {
+			continue
+		}
+		ages = append(ages, r.Age())
+		if first.IsZero() || r.RestoredAt.Before(first) {
+			first = r.RestoredAt
+		}
+	}
+	p.restores = len(ages)
+	sort.Slice(ages, func(i, j int) bool { return ages[i] < ages[j] })
+
+	p.hot = DefaultHotDays * day
+	if len(ages) > 0 {
+		p.hot = ages[int(math.Ceil(in.Coverage*float64(len(ages))))-1]
+		p.keep = ages[len(ages)-1]
+	}
+	p.hot = roundDays(max(p.hot, p.db.RPO, day))
+	p.keep = roundDays(max(p.keep, p.db.RPO))
+	p.minDaily = max(1, days(roundDays(p.db.RPO)))
+
+	if len(ages) > 0 {
+		older := 0
+		for _, age := range ages {
+			if age > p.hot {
+				older++
+			}
+		}
+		months := max(in.Now.Sub(first).Hours()/month.Hours(), 1)
+		p.oldRate = float64(older) / months
+	}
+}
+
+// shorter returns the policies one step shorter than the current one that
+// still keep what past restores and the RPO need
+func (p *plan) shorter() []Retention {
+	r := p.retention
+	if r == (Retention{}) {
+		return nil
+	}
+	var out []Retention
+	ok := func(c Retention) bool {
+		return c != (Retention{}) && c.span() >= p.keep && (c.Daily >= min(p.minDaily, r.Daily))
+	}
+	for _, c := range []Retention{
+		{r.Daily, r.Weekly, r.Monthly - 1},
+		{r.Daily, r.Weekly - 1, r.Monthly},
+		{r.Daily - 1, r.Weekly, r.Monthly},
+	} {
+		if c.Daily >= 0 && c.Weekly >= 0 && c.Monthly >= 0 && ok(c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// chooseTiers picks, for every provider with colder tiers, the cheapest
+// tier for the backups older than the hot window under retention r. A
+// tier is only considered when the backups stay in it for its minimum
+// storage duration.
+func (p *plan) chooseTiers(in Input, r Retention) map[string]int {
+	providers := make(map[string]bool)
+	for _, b := range p.backups {
+		providers[b.Provider] = true
+	}
+	tiers := make(map[string]int)
+	for provider := range providers {
+		prices := in.Prices[provider]
+		best, bestCost := 0, p.providerCost(in, r, provider, 0)
+		for t := 1; t < len(prices); t++ {
+			if r.span()-p.hot < time.Duration(prices[t].MinDays)*day {
+				continue
+			}
+			if c := p.providerCost(in, r, provider, t); c < bestCost {
+				best, bestCost = t, c
+			}
+		}
+		if best > 0 {
+			tiers[provider] = best
+		}
+	}
+	return tiers
+}
+
+// cost is the monthly cost of the backups retention r keeps, with the
+// backups older than the hot window in tiers
+func (p *plan) cost(in Input, r Retention, tiers map[string]int) float64 {
+	providers := make(map[string]bool)
+	for _, b := range p.backups {
+		providers[b.Provider] = true
+	}
+	sum := 0.0
+	for provider := range providers {
+		sum += p.providerCost(in, r, provider, tiers[provider])
+	}
+	return sum
+}
+
+// providerCost is the monthly cost of the backups of one provider that
+// retention r keeps, those older than the hot window in the given tier,
+// with the expected retrievals from that tier
+func (p *plan) providerCost(in Input, r Retention, provider string, tier int) float64 {
+	prices := in.Prices[provider]
+	if len(prices) == 0 {
+		return 0
+	}
+	var sum, oldBytes float64
+	old := 0
+	for _, b := range retained(p.backups, r) {
+		if b.Provider != provider {
+			continue
+		}
+		price := prices[0].PerGBMonth
+		if tier > 0 && in.Now.Sub(b.Time) > p.hot {
+			price = prices[tier].PerGBMonth
+			oldBytes += float64(b.Size)
+			old++
+		}
+		sum += float64(b.Size) / gb * price
+	}
+	if tier > 0 && old > 0 {
+		sum += p.oldRate * oldBytes / float64(old) / gb * prices[tier].RetrievalPerGB
+	}
+	return sum
+}
+
+// retained returns the backups retention r keeps: the newest of each of
+// the last r.Daily days, r.Weekly ISO weeks and r.Monthly months
+func retained(backups []Backup, r Retention) []Backup {
+	if r == (Retention{}) {
+		return backups
+	}
+	sorted := append([]Backup(nil), backups...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.After(sorted[j].Time) })
+
+	keep := make([]bool, len(sorted))
+	pick := func(n int, bucket func(time.Time) [2]int) {
+		seen := make(map[[2]int]bool)
+		for i, b := range sorted {
+			if len(seen) == n {
+				return
+			}
+			k := bucket(b.Time.UTC())
+			if !seen[k] {
+				seen[k] = true
+				keep[i] = true
+			}
+		}
+	}
+	pick(r.Daily, func(t time.Time) [2]int { return [2]int{t.Year(), t.YearDay()} })
+	pick(r.Weekly, func(t time.Time) [2]int { y, w := t.ISOWeek(); return [2]int{y, w} })
+	pick(r.Monthly, func(t time.Time) [2]int { return [2]int{t.Year(), int(t.Month())} })
+
+	var out []Backup
+	for i, b := range sorted {
+		if keep[i] {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// roundDays rounds d up to whole days
+func roundDays(d time.Duration) time.Duration {
+	return time.Duration(math.Ceil(float64(d)/float64(day))) * day
+}
+
+// days returns d in whole days, rounded up
+func days(d time.Duration) int {
+	return int(roundDays(d) / day)
+}
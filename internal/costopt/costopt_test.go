@@ -0,0 +1,126 @@
+package costopt
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+var now = time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+
+// daily returns a backup of 1GB a day for the last n days
+func daily(db string, n int) []Backup {
+	var out []Backup
+	for i := 0; i < n; i++ {
+		out = append(out, Backup{ID: fmt.Sprintf("%s-%d", db, i), Database: db, Provider: "s3", Size: gb, Time: now.Add(-time.Duration(i) * day)})
+	}
+	return out
+}
+
+var prices = map[string][]config.StorageTierPrice{
+	"s3": {
+		{Tier: "STANDARD", PerGBMonth: 0.023},
+		{Tier: "GLACIER_IR", PerGBMonth: 0.004, RetrievalPerGB: 0.03, MinDays: 90},
+	},
+}
+
+func TestRetained(t *testing.T) {
+	backups := daily("orders", 120)
+	if got := len(retained(backups, Retention{})); got != 120 {
+		t.Errorf("keep all retained %d, want 120", got)
+	}
+	// Today and yesterday, plus the newest of May, April and March
+	if got := len(retained(backups, Retention{Daily: 2, Monthly: 4})); got != 5 {
+		t.Errorf("retained %d, want 5", got)
+	}
+}
+
+func TestAnalyzeTiers(t *testing.T) {
+	report := Analyze(Input{
+		Now:       now,
+		Backups:   daily("orders", 200),
+		Databases: []Database{{Name: "orders"}},
+		Prices:    prices,
+	})
+	rec := report.Databases[0]
+	want := []TierChange{{Provider: "s3", Tier: "GLACIER_IR", AfterDays: DefaultHotDays}}
+	if !reflect.DeepEqual(rec.Tiers, want) {
+		t.Errorf("tiers = %+v, want %+v", rec.Tiers, want)
+	}
+	if rec.NewRetention != nil {
+		t.Errorf("retention changed without a budget: %+v", rec.NewRetention)
+	}
+	if rec.ProjectedCost >= rec.Cost || !report.WithinBudget {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestAnalyzeTierMinimumDuration(t *testing.T) {
+	// Backups older than the hot window are deleted before 90 days
+	report := Analyze(Input{
+		Now:       now,
+		Backups:   daily("orders", 60),
+		Databases: []Database{{Name: "orders", Retention: Retention{Daily: 60}}},
+		Prices:    prices,
+	})
+	if tiers := report.Databases[0].Tiers; len(tiers) != 0 {
+		t.Errorf("tiers = %+v, want none", tiers)
+	}
+}
+
+func TestAnalyzeBudget(t *testing.T) {
+	restores := []Restore{
+		{Database: "orders", BackupTime: now.Add(-130 * day), RestoredAt: now.Add(-30 * day)},
+	}
+	report := Analyze(Input{
+		Now:      now,
+		Backups:  daily("orders", 365),
+		Restores: restores,
+		Databases: []Database{
+			{Name: "orders", Retention: Retention{Daily: 30, Monthly: 12}, RPO: 36 * time.Hour},
+		},
+		Prices: map[string][]config.StorageTierPrice{"s3": prices["s3"][:1]},
+		Budget: 0.01,
+	})
+	rec := report.Databases[0]
+	if rec.HotDays != 100 || rec.KeepDays != 100 {
+		t.Errorf("hot %d, keep %d days, want 100", rec.HotDays, rec.KeepDays)
+	}
+	r := rec.NewRetention
+	if r == nil {
+		t.Fatal("retention not shortened")
+	}
+	// Four months still cover the backup restored at 100 days
+	if r.Monthly != 4 || r.Daily < 2 {
+		t.Errorf("new retention = %+v", r)
+	}
+	if rec.ProjectedCost >= rec.Cost || report.WithinBudget {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestRestoreHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata", HistoryFile)
+	restores, err := ReadRestores(path)
+	if err != nil || len(restores) != 0 {
+		t.Fatalf("missing history: %v, %v", restores, err)
+	}
+
+	want := Restore{Database: "orders", BackupID: "b1", BackupTime: now.Add(-48 * time.Hour), RestoredAt: now}
+	for i := 0; i < 2; i++ {
+		if err := RecordRestore(path, want); err != nil {
+			t.Fatal(err)
+		}
+	}
+	restores, err = ReadRestores(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restores) != 2 || !restores[1].RestoredAt.Equal(now) || restores[1].Age() != 48*time.Hour {
+		t.Errorf("restores = %+v", restores)
+	}
+}
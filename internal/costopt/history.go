@@ -0,0 +1,62 @@
+package costopt
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// HistoryFile is the restore history in the metadata directory, when
+// restore_history is not set
+const HistoryFile = "restores.jsonl"
+
+// RecordRestore appends a restore to the history at path, one JSON object
+// per line
+func RecordRestore(path string, r Restore) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create restore history directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open restore history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write restore history: %w", err)
+	}
+	return f.Sync()
+}
+
+// ReadRestores reads the history at path; a missing file is an empty
+// history
+func ReadRestores(path string) ([]Restore, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open restore history: %w", err)
+	}
+	defer f.Close()
+
+	var restores []Restore
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Restore
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		restores = append(restores, r)
+	}
+	return restores, scanner.Err()
+}
@@ -0,0 +1,409 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// ChangeManifestFile is the manifest of a change stream, in its directory
+const ChangeManifestFile = "changes.json"
+
+// changeFormat identifies the layout of change streams
+const changeFormat = "changes-v1"
+
+// ChangePartialFile is the segment being written. It only holds whole
+// transactions the server may already have discarded, so replays read it
+// after the closed segments.
+const ChangePartialFile = "changes.partial"
+
+// Defaults of change capture
+const (
+	DefaultSegmentInterval = 5 * time.Minute
+	DefaultSegmentBytes    = 64 << 20
+	DefaultPollInterval    = time.Second
+)
+
+// ChangeCapturer is implemented by drivers that can capture the changes
+// committed between full backups as a stream of segment files, and replay
+// them onto a restored backup for a recovery point close to the failure.
+type ChangeCapturer interface {
+	// CaptureChanges streams committed transactions into opts.OutputDir
+	// until ctx ends. Each poll's transactions are synced to disk before
+	// the server is told it may discard them.
+	CaptureChanges(ctx context.Context, opts *CaptureOptions) error
+	// ApplyChanges replays the transactions of a change stream in order
+	ApplyChanges(ctx context.Context, opts *ApplyChangesOptions) (*ApplyChangesResult, error)
+	// DropCapture removes the server-side position of a stream, which
+	// otherwise keeps the server from recycling its log
+	DropCapture(ctx context.Context, name string) error
+}
+
+// CaptureOptions holds the options of a change capture
+type CaptureOptions struct {
+	Name            string            // server-side position, e.g. a replication slot
+	Options         map[string]string // driver settings, e.g. the decoding plugin
+	OutputDir       string
+	SegmentInterval time.Duration // close a segment holding changes this often
+	SegmentBytes    int64         // or once it is this large
+	PollInterval    time.Duration
+	// OnSegment is called with each closed segment, e.g. to upload it
+	OnSegment func(ChangeSegment) error
+}
+
+// ApplyChangesOptions holds the options of a change replay
+type ApplyChangesOptions struct {
+	SourceDir string
+	// StartAfter skips the transactions committed at or before this
+	// position, e.g. the one recorded by the full backup replayed onto
+	StartAfter string
+	// Until stops the replay before the first transaction committed later
+	Until *time.Time
+}
+
+// ApplyChangesResult contains the result of a change replay
+type ApplyChangesResult struct {
+	Transactions int64
+	Changes      int64
+	Skipped      int64
+	LastPosition string
+	LastCommit   time.Time
+}
+
+// Row change operations
+const (
+	ChangeInsert   = "insert"
+	ChangeUpdate   = "update"
+	ChangeDelete   = "delete"
+	ChangeTruncate = "truncate"
+)
+
+// ChangeTx is a committed transaction of a change stream
+type ChangeTx struct {
+	Position string    `json:"position"` // of the commit, e.g. an LSN
+	ID       string    `json:"id,omitempty"`
+	Commit   time.Time `json:"commit"`
+	Changes  []Change  `json:"changes"`
+}
+
+// Change is a change to the rows of a table
+type Change struct {
+	Op     string `json:"op"`
+	Schema string `json:"schema,omitempty"`
+	Table  string `json:"table"`
+	// Columns is the new row; columns left out are unchanged
+	Columns []ChangeColumn `json:"columns,omitempty"`
+	// Identity is the key of the old row, for updates and deletes
+	Identity []ChangeColumn `json:"identity,omitempty"`
+}
+
+// ChangeColumn is a column value in its text form, nil for NULL
+type ChangeColumn struct {
+	Name  string  `json:"name"`
+	Type  string  `json:"type,omitempty"`
+	Value *string `json:"value"`
+}
+
+// ChangeManifest describes a change stream
+type ChangeManifest struct {
+	Format       string            `json:"format"`
+	DatabaseType DatabaseType      `json:"database_type"`
+	Database     string            `json:"database"`
+	Name         string            `json:"name"`
+	Options      map[string]string `json:"options,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Segments     []ChangeSegment   `json:"segments"`
+}
+
+// ChangeSegment is one closed file of a change stream
+type ChangeSegment struct {
+	File         string    `json:"file"`
+	First        string    `json:"first"` // position of the first commit
+	Last         string    `json:"last"`
+	FirstCommit  time.Time `json:"first_commit"`
+	LastCommit   time.Time `json:"last_commit"`
+	Transactions int64     `json:"transactions"`
+	Changes      int64     `json:"changes"`
+	Size         int64     `json:"size"`
+	Checksum     string    `json:"checksum"`
+}
+
+// ChangeWriter appends transactions to a change stream: to the partial
+// segment, which Cut closes into the next numbered segment
+type ChangeWriter struct {
+	dir      string
+	manifest *ChangeManifest
+	file     *os.File
+	partial  ChangeSegment
+	opened   time.Time
+}
+
+// OpenChangeWriter opens the change stream in dir, creating it from header
+// when it does not exist. A partial segment left by an interrupted capture
+// is kept, less any transaction cut short.
+func OpenChangeWriter(dir string, header ChangeManifest) (*ChangeWriter, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	m, err := ReadChangeManifest(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		m = &header
+		m.Format = changeFormat
+		m.CreatedAt = time.Now().UTC()
+		if err := writeChangeManifest(dir, m); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case m.DatabaseType != header.DatabaseType || m.Database != header.Database || m.Name != header.Name:
+		return nil, fmt.Errorf("%s holds the changes of %s %s/%s", dir, m.DatabaseType, m.Database, m.Name)
+	}
+
+	w := &ChangeWriter{dir: dir, manifest: m}
+	if err := w.recover(); err != nil {
+		return nil, fmt.Errorf("recovering %s: %w", ChangePartialFile, err)
+	}
+	return w, nil
+}
+
+// recover truncates the partial segment after its last whole transaction
+// and reopens it for appending
+func (w *ChangeWriter) recover() error {
+	path := filepath.Join(w.dir, ChangePartialFile)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var seg ChangeSegment
+	end, err := readChangeTxs(f, func(tx *ChangeTx) error {
+		seg.add(tx)
+		return nil
+	})
+	if err == nil {
+		err = f.Truncate(end)
+	}
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	seg.Size = end
+	w.file, w.partial, w.opened = f, seg, time.Now()
+	return nil
+}
+
+// Manifest returns the manifest of the stream
+func (w *ChangeWriter) Manifest() *ChangeManifest {
+	return w.manifest
+}
+
+// Last returns the position of the last transaction written, or "" when
+// the stream is empty
+func (w *ChangeWriter) Last() string {
+	if w.partial.Transactions > 0 {
+		return w.partial.Last
+	}
+	if n := len(w.manifest.Segments); n > 0 {
+		return w.manifest.Segments[n-1].Last
+	}
+	return ""
+}
+
+// Append writes a transaction to the partial segment
+func (w *ChangeWriter) Append(tx *ChangeTx) error {
+	if w.file == nil {
+		f, err := os.OpenFile(filepath.Join(w.dir, ChangePartialFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+		if err != nil {
+			return err
+		}
+		w.file, w.partial, w.opened = f, ChangeSegment{}, time.Now()
+	}
+	line, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+	n, err := w.file.Write(append(line, '\n'))
+	w.partial.Size += int64(n)
+	if err != nil {
+		return err
+	}
+	w.partial.add(tx)
+	return nil
+}
+
+// Sync makes the transactions appended so far durable
+func (w *ChangeWriter) Sync() error {
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Due reports whether the partial segment holds changes and is older than
+// interval or larger than size
+func (w *ChangeWriter) Due(interval time.Duration, size int64) bool {
+	if w.partial.Transactions == 0 {
+		return false
+	}
+	return time.Since(w.opened) >= interval || w.partial.Size >= size
+}
+
+// Cut closes the partial segment into the next numbered segment and lists
+// it in the manifest. It returns nil when the partial segment is empty.
+func (w *ChangeWriter) Cut(ctx context.Context) (*ChangeSegment, error) {
+	if w.file == nil || w.partial.Transactions == 0 {
+		return nil, nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return nil, err
+	}
+	if err := w.file.Close(); err != nil {
+		return nil, err
+	}
+	w.file = nil
+
+	seg := w.partial
+	seg.File = fmt.Sprintf("changes-%06d.jsonl", len(w.manifest.Segments)+1)
+	partial := filepath.Join(w.dir, ChangePartialFile)
+	checksum, size, err := stream.HashFile(ctx, partial)
+	if err != nil {
+		return nil, err
+	}
+	seg.Checksum, seg.Size = checksum, size
+	if err := os.Rename(partial, filepath.Join(w.dir, seg.File)); err != nil {
+		return nil, err
+	}
+	w.manifest.Segments = append(w.manifest.Segments, seg)
+	if err := writeChangeManifest(w.dir, w.manifest); err != nil {
+		return nil, err
+	}
+	w.partial = ChangeSegment{}
+	return &seg, nil
+}
+
+// Close closes the partial segment, which the next writer carries on
+func (w *ChangeWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// add counts tx in the segment
+func (s *ChangeSegment) add(tx *ChangeTx) {
+	if s.Transactions == 0 {
+		s.First, s.FirstCommit = tx.Position, tx.Commit
+	}
+	s.Last, s.LastCommit = tx.Position, tx.Commit
+	s.Transactions++
+	s.Changes += int64(len(tx.Changes))
+}
+
+// ReadChanges calls fn with the transactions of the change stream in dir
+// in commit order: those of the closed segments, each checked against its
+// checksum first, then those of the partial segment
+func ReadChanges(ctx context.Context, dir string, fn func(*ChangeTx) error) (*ChangeManifest, error) {
+	m, err := ReadChangeManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range m.Segments {
+		path := filepath.Join(dir, filepath.Base(seg.File))
+		checksum, _, err := stream.HashFile(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if checksum != seg.Checksum {
+			return nil, fmt.Errorf("segment %s: checksum mismatch", seg.File)
+		}
+		if err := readChangeFile(path, fn); err != nil {
+			return nil, fmt.Errorf("segment %s: %w", seg.File, err)
+		}
+	}
+	err = readChangeFile(filepath.Join(dir, ChangePartialFile), fn)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", ChangePartialFile, err)
+	}
+	return m, nil
+}
+
+// readChangeFile calls fn with the whole transactions of a segment file
+func readChangeFile(path string, fn func(*ChangeTx) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = readChangeTxs(f, fn)
+	return err
+}
+
+// readChangeTxs calls fn with each whole transaction of r and returns the
+// offset after the last one; a trailing line cut short is ignored
+func readChangeTxs(r io.Reader, fn func(*ChangeTx) error) (int64, error) {
+	br := bufio.NewReader(r)
+	var end int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return end, nil
+		}
+		if err != nil {
+			return end, err
+		}
+		var tx ChangeTx
+		if err := json.Unmarshal(bytes.TrimSpace(line), &tx); err != nil {
+			return end, fmt.Errorf("at offset %d: %w", end, err)
+		}
+		if err := fn(&tx); err != nil {
+			return end, err
+		}
+		end += int64(len(line))
+	}
+}
+
+// ReadChangeManifest reads the manifest of the change stream in dir
+func ReadChangeManifest(dir string) (*ChangeManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ChangeManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m ChangeManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", ChangeManifestFile, err)
+	}
+	if m.Format != changeFormat {
+		return nil, fmt.Errorf("%s: unsupported format %q", ChangeManifestFile, m.Format)
+	}
+	return &m, nil
+}
+
+// writeChangeManifest replaces the manifest of the change stream in dir
+func writeChangeManifest(dir string, m *ChangeManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ChangeManifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, ChangeManifestFile))
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func changeTx(pos string, tables ...string) *ChangeTx {
+	tx := &ChangeTx{Position: pos, ID: pos, Commit: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)}
+	for _, t := range tables {
+		v := "1"
+		tx.Changes = append(tx.Changes, Change{Op: ChangeInsert, Table: t, Columns: []ChangeColumn{{Name: "id", Value: &v}}})
+	}
+	return tx
+}
+
+func readPositions(t *testing.T, dir string) []string {
+	t.Helper()
+	var got []string
+	if _, err := ReadChanges(context.Background(), dir, func(tx *ChangeTx) error {
+		got = append(got, tx.Position)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestChangeWriter(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	header := ChangeManifest{DatabaseType: DatabaseTypePostgreSQL, Database: "orders", Name: "slot"}
+	w, err := OpenChangeWriter(dir, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg, err := w.Cut(ctx); seg != nil || err != nil {
+		t.Fatalf("Cut of an empty stream = %v, %v", seg, err)
+	}
+	for _, tx := range []*ChangeTx{changeTx("0/10", "a"), changeTx("0/20", "a", "b")} {
+		if err := w.Append(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seg, err := w.Cut(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.File != "changes-000001.jsonl" || seg.First != "0/10" || seg.Last != "0/20" || seg.Transactions != 2 || seg.Changes != 3 || seg.Checksum == "" {
+		t.Errorf("segment = %+v", seg)
+	}
+	if err := w.Append(changeTx("0/30", "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A capture killed while writing leaves half a transaction behind
+	f, err := os.OpenFile(filepath.Join(dir, ChangePartialFile), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"position":"0/40","chan`)
+	f.Close()
+
+	if got := strings.Join(readPositions(t, dir), ","); got != "0/10,0/20,0/30" {
+		t.Errorf("replayed %s, want the closed segment then the partial one", got)
+	}
+
+	w, err = OpenChangeWriter(dir, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Last() != "0/30" {
+		t.Errorf("Last() = %q after recovery, want 0/30", w.Last())
+	}
+	if err := w.Append(changeTx("0/40", "d")); err != nil {
+		t.Fatal(err)
+	}
+	if seg, err := w.Cut(ctx); err != nil || seg.File != "changes-000002.jsonl" || seg.First != "0/30" || seg.Transactions != 2 {
+		t.Fatalf("second segment = %+v, %v", seg, err)
+	}
+	if got := strings.Join(readPositions(t, dir), ","); got != "0/10,0/20,0/30,0/40" {
+		t.Errorf("replayed %s", got)
+	}
+
+	other := header
+	other.Name = "other_slot"
+	if _, err := OpenChangeWriter(dir, other); err == nil {
+		t.Error("opened the stream of another slot")
+	}
+}
+
+func TestReadChangesChecksum(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	w, err := OpenChangeWriter(dir, ChangeManifest{DatabaseType: DatabaseTypePostgreSQL, Name: "slot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append(changeTx("0/10", "a"))
+	seg, err := w.Cut(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, seg.File)
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), `"a"`, `"b"`, 1)), 0o640)
+
+	_, err = ReadChanges(ctx, dir, func(*ChangeTx) error {
+		t.Error("replayed a tampered segment")
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("err = %v, want a checksum mismatch", err)
+	}
+}
@@ -0,0 +1,723 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// ChunkManifestFile is the manifest of a chunked export, in its directory
+const ChunkManifestFile = "manifest.json"
+
+// chunkFormat identifies the layout of chunked exports
+const chunkFormat = "chunked-v1"
+
+// Defaults of chunked exports
+const (
+	DefaultRowsPerChunk = 1_000_000
+	defaultBatchRows    = 500
+)
+
+// ChunkedDumper is implemented by drivers that can export large tables as
+// many chunk files, cut along the primary key, that are dumped and
+// restored in parallel. Only table data is exported: the schema must exist
+// when the chunks are restored, e.g. from a schema-only backup.
+type ChunkedDumper interface {
+	DumpTableChunks(ctx context.Context, opts *ChunkedDumpOptions) (*ChunkManifest, error)
+	RestoreTableChunks(ctx context.Context, opts *ChunkedRestoreOptions) (*ChunkManifest, error)
+}
+
+// ChunkedDumpOptions holds the options of a chunked export
+type ChunkedDumpOptions struct {
+	Tables       []string
+	OutputDir    string
+	RowsPerChunk int64
+	Parallel     int
+}
+
+// ChunkedRestoreOptions holds the options of a chunked restore
+type ChunkedRestoreOptions struct {
+	SourceDir string
+	Tables    []string // restore only these tables of the export
+	Parallel  int
+	BatchRows int // rows per INSERT statement
+}
+
+// ChunkManifest describes a chunked export
+type ChunkManifest struct {
+	Format       string         `json:"format"`
+	DatabaseType DatabaseType   `json:"database_type"`
+	CreatedAt    time.Time      `json:"created_at"`
+	Tables       []ChunkedTable `json:"tables"`
+}
+
+// ChunkedTable describes the chunks of one table
+type ChunkedTable struct {
+	Name       string        `json:"name"`
+	Columns    []ChunkColumn `json:"columns"`
+	PrimaryKey []string      `json:"primary_key"`
+	Rows       int64         `json:"rows"`
+	Chunks     []TableChunk  `json:"chunks"`
+}
+
+// ChunkColumn describes a column of a chunked table
+type ChunkColumn struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Binary bool   `json:"binary,omitempty"`
+}
+
+// TableChunk is one file of a chunked table, holding the rows with a
+// primary key above the previous chunk's last key up to its own
+type TableChunk struct {
+	File     string `json:"file"`
+	Rows     int64  `json:"rows"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// ChunkDialect adapts chunked exports to a database's SQL
+type ChunkDialect interface {
+	// QuoteIdent quotes a possibly schema-qualified identifier
+	QuoteIdent(name string) string
+	// Placeholder returns the nth (1-based) query parameter
+	Placeholder(n int) string
+	// MaxParams is the most parameters one statement may carry
+	MaxParams() int
+	// PrimaryKey returns the primary key columns of a table, in order
+	PrimaryKey(ctx context.Context, db *sql.DB, table string) ([]string, error)
+	// Binary reports whether a column type holds raw bytes
+	Binary(columnType string) bool
+	// Snapshot starts the transactions the chunks are read in
+	Snapshot(ctx context.Context, db *sql.DB) (ChunkSnapshot, error)
+	// ForeignKeys returns, for each of tables, the others of tables it
+	// references through foreign keys, itself included
+	ForeignKeys(ctx context.Context, db *sql.DB, tables []string) (map[string][]string, error)
+	// ForeignKeyChecks turns the foreign key checks of a session off or
+	// back on
+	ForeignKeyChecks(ctx context.Context, conn *sql.Conn, on bool) error
+}
+
+// ChunkSnapshot opens read transactions, sharing one snapshot where the
+// database supports it
+type ChunkSnapshot interface {
+	Begin(ctx context.Context) (*sql.Tx, error)
+	Close() error
+}
+
+// DumpChunks exports tables as chunk files into opts.OutputDir and writes
+// the manifest. Chunk boundaries are found by walking the primary key
+// index, then up to opts.Parallel chunks are read at once.
+func DumpChunks(ctx context.Context, db *sql.DB, dbType DatabaseType, d ChunkDialect, opts *ChunkedDumpOptions) (*ChunkManifest, error) {
+	if len(opts.Tables) == 0 {
+		return nil, fmt.Errorf("chunked export needs at least one table")
+	}
+	rowsPerChunk := opts.RowsPerChunk
+	if rowsPerChunk <= 0 {
+		rowsPerChunk = DefaultRowsPerChunk
+	}
+	if err := os.MkdirAll(opts.OutputDir, 0750); err != nil {
+		return nil, err
+	}
+
+	snap, err := d.Snapshot(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start snapshot: %w", err)
+	}
+	defer snap.Close()
+
+	manifest := &ChunkManifest{Format: chunkFormat, DatabaseType: dbType, CreatedAt: time.Now().UTC()}
+	type task struct {
+		table  int
+		chunk  int
+		lo, hi []any
+	}
+	var tasks []task
+	for _, name := range opts.Tables {
+		if err := validation.ValidateTableName(name); err != nil {
+			return nil, fmt.Errorf("invalid table name %q: %w", name, err)
+		}
+		t, bounds, err := planChunks(ctx, db, snap, d, name, rowsPerChunk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := os.MkdirAll(filepath.Join(opts.OutputDir, name), 0750); err != nil {
+			return nil, err
+		}
+		ti := len(manifest.Tables)
+		for i := 0; i <= len(bounds); i++ {
+			tk := task{table: ti, chunk: i}
+			if i > 0 {
+				tk.lo = bounds[i-1]
+			}
+			if i < len(bounds) {
+				tk.hi = bounds[i]
+			}
+			t.Chunks = append(t.Chunks, TableChunk{File: filepath.Join(name, fmt.Sprintf("%s.%05d.tsv", name, i+1))})
+			tasks = append(tasks, tk)
+		}
+		manifest.Tables = append(manifest.Tables, t)
+	}
+
+	// Every chunk but the last of a table holds rowsPerChunk rows; the
+	// last ones, smaller, are left for the end
+	size := func(tk task) int64 {
+		if tk.hi == nil {
+			return 0
+		}
+		return rowsPerChunk
+	}
+	err = LargestFirst(ctx, tasks, size, opts.Parallel, func(ctx context.Context, tk task) error {
+		t := &manifest.Tables[tk.table]
+		c := &t.Chunks[tk.chunk]
+		if err := dumpChunk(ctx, snap, d, t, tk.lo, tk.hi, filepath.Join(opts.OutputDir, c.File), c); err != nil {
+			return fmt.Errorf("%s: %w", c.File, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range manifest.Tables {
+		t := &manifest.Tables[i]
+		for _, c := range t.Chunks {
+			t.Rows += c.Rows
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(opts.OutputDir, ChunkManifestFile), data, 0600); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// planChunks reads a table's columns and primary key and returns the last
+// key of every chunk but the final one
+func planChunks(ctx context.Context, db *sql.DB, snap ChunkSnapshot, d ChunkDialect, name string, rowsPerChunk int64) (ChunkedTable, [][]any, error) {
+	t := ChunkedTable{Name: name}
+	pk, err := d.PrimaryKey(ctx, db, name)
+	if err != nil {
+		return t, nil, err
+	}
+	if len(pk) == 0 {
+		return t, nil, fmt.Errorf("table has no primary key to chunk along")
+	}
+	t.PrimaryKey = pk
+
+	tx, err := snap.Begin(ctx)
+	if err != nil {
+		return t, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM "+d.QuoteIdent(name)+" WHERE 1 = 0")
+	if err != nil {
+		return t, nil, err
+	}
+	types, err := rows.ColumnTypes()
+	rows.Close()
+	if err != nil {
+		return t, nil, err
+	}
+	for _, ct := range types {
+		t.Columns = append(t.Columns, ChunkColumn{Name: ct.Name(), Type: ct.DatabaseTypeName(), Binary: d.Binary(ct.DatabaseTypeName())})
+	}
+
+	keys := quoteList(d, pk)
+	var bounds [][]any
+	var last []any
+	for {
+		query := "SELECT " + keys + " FROM " + d.QuoteIdent(name)
+		var args []any
+		if last != nil {
+			query += " WHERE " + keyAfter(d, pk, 1)
+			args = last
+		}
+		query += fmt.Sprintf(" ORDER BY %s LIMIT 1 OFFSET %d", keys, rowsPerChunk-1)
+
+		key := make([]any, len(pk))
+		ptrs := make([]any, len(pk))
+		for i := range key {
+			ptrs[i] = &key[i]
+		}
+		err := tx.QueryRowContext(ctx, query, args...).Scan(ptrs...)
+		if errors.Is(err, sql.ErrNoRows) {
+			return t, bounds, nil
+		}
+		if err != nil {
+			return t, nil, err
+		}
+		for i, v := range key {
+			// Drivers reuse byte slices between scans
+			if b, ok := v.([]byte); ok {
+				key[i] = append([]byte(nil), b...)
+			}
+		}
+		bounds = append(bounds, key)
+		last = key
+	}
+}
+
+// dumpChunk writes the rows with a key above lo and up to hi
+func dumpChunk(ctx context.Context, snap ChunkSnapshot, d ChunkDialect, t *ChunkedTable, lo, hi []any, path string, c *TableChunk) error {
+	tx, err := snap.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cols := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		cols[i] = col.Name
+	}
+	query := "SELECT " + quoteList(d, cols) + " FROM " + d.QuoteIdent(t.Name)
+	var where []string
+	var args []any
+	if lo != nil {
+		where = append(where, keyAfter(d, t.PrimaryKey, len(args)+1))
+		args = append(args, lo...)
+	}
+	if hi != nil {
+		where = append(where, fmt.Sprintf("(%s) <= (%s)", quoteList(d, t.PrimaryKey), placeholders(d, len(args)+1, len(hi))))
+		args = append(args, hi...)
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + quoteList(d, t.PrimaryKey)
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600) // #nosec G304 -- path within the export directory
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hw := stream.NewHashWriter(f)
+	w := bufio.NewWriterSize(hw, stream.Default().BufferSize())
+
+	values := make([]sql.RawBytes, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		WriteCopyRow(w, values)
+		c.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	c.Size = hw.Written()
+	c.Checksum = hw.Sum()
+	return nil
+}
+
+// RestoreChunks loads the chunk files of an export into existing tables,
+// up to opts.Parallel chunks at once and the largest first, each in its
+// own transaction. Tables are loaded after the tables they reference
+// through foreign keys, so rows only ever reference rows already there;
+// tables in a reference cycle, themselves included, are loaded with
+// foreign key checks off.
+func RestoreChunks(ctx context.Context, db *sql.DB, d ChunkDialect, opts *ChunkedRestoreOptions) (*ChunkManifest, error) {
+	manifest, err := ReadChunkManifest(opts.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool, len(opts.Tables))
+	for _, t := range opts.Tables {
+		want[t] = true
+	}
+
+	tables := make(map[string]*ChunkedTable)
+	var names []string
+	chunks := 0
+	for i := range manifest.Tables {
+		t := &manifest.Tables[i]
+		if len(want) > 0 && !want[t.Name] {
+			continue
+		}
+		if err := validation.ValidateTableName(t.Name); err != nil {
+			return nil, fmt.Errorf("invalid table name %q in manifest: %w", t.Name, err)
+		}
+		tables[t.Name] = t
+		names = append(names, t.Name)
+		chunks += len(t.Chunks)
+	}
+	if chunks == 0 {
+		return nil, fmt.Errorf("no chunks to restore in %s", opts.SourceDir)
+	}
+	refs, err := d.ForeignKeys(ctx, db, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys: %w", err)
+	}
+	levels, cyclic := restoreLevels(names, refs)
+
+	batchRows := opts.BatchRows
+	if batchRows <= 0 {
+		batchRows = defaultBatchRows
+	}
+	type task struct {
+		table *ChunkedTable
+		chunk TableChunk
+	}
+	for _, level := range levels {
+		var tasks []task
+		for _, name := range level {
+			for _, c := range tables[name].Chunks {
+				tasks = append(tasks, task{tables[name], c})
+			}
+		}
+		size := func(tk task) int64 { return tk.chunk.Size }
+		err = LargestFirst(ctx, tasks, size, opts.Parallel, func(ctx context.Context, tk task) error {
+			if err := restoreChunk(ctx, db, d, tk.table, filepath.Join(opts.SourceDir, tk.chunk.File), tk.chunk, batchRows, !cyclic[tk.table.Name]); err != nil {
+				return fmt.Errorf("%s: %w", tk.chunk.File, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// restoreLevels groups tables into levels restored one after the other,
+// each table in a later level than every table it references. The tables
+// of a reference cycle share a level and are reported as cyclic: some of
+// their rows have to go in before the rows they reference.
+func restoreLevels(tables []string, refs map[string][]string) ([][]string, map[string]bool) {
+	// Tarjan's algorithm numbers the strongly connected components of the
+	// reference graph so that referenced components come first
+	var (
+		index     = make(map[string]int)
+		low       = make(map[string]int)
+		onStack   = make(map[string]bool)
+		component = make(map[string]int)
+		stack     []string
+		members   [][]string
+	)
+	var visit func(t string)
+	visit = func(t string) {
+		index[t], low[t] = len(index), len(index)
+		stack = append(stack, t)
+		onStack[t] = true
+		for _, r := range refs[t] {
+			if _, seen := index[r]; !seen {
+				visit(r)
+				low[t] = min(low[t], low[r])
+			} else if onStack[r] {
+				low[t] = min(low[t], index[r])
+			}
+		}
+		if low[t] != index[t] {
+			return
+		}
+		var scc []string
+		for {
+			m := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[m] = false
+			component[m] = len(members)
+			scc = append(scc, m)
+			if m == t {
+				break
+			}
+		}
+		members = append(members, scc)
+	}
+	known := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		known[t] = true
+	}
+	for _, t := range tables {
+		if _, seen := index[t]; !seen {
+			visit(t)
+		}
+	}
+
+	cyclic := make(map[string]bool)
+	depth := make([]int, len(members))
+	for c, scc := range members {
+		for _, t := range scc {
+			for _, r := range refs[t] {
+				if !known[r] {
+					continue
+				}
+				if component[r] == c {
+					if len(scc) > 1 || r == t {
+						cyclic[t] = true
+					}
+					continue
+				}
+				depth[c] = max(depth[c], depth[component[r]]+1)
+			}
+		}
+	}
+	var levels [][]string
+	for _, t := range tables {
+		d := depth[component[t]]
+		for len(levels) <= d {
+			levels = append(levels, nil)
+		}
+		levels[d] = append(levels[d], t)
+	}
+	return levels, cyclic
+}
+
+// ReadChunkManifest reads the manifest of a chunked export
+func ReadChunkManifest(dir string) (*ChunkManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ChunkManifestFile)) // #nosec G304 -- export directory given by the operator
+	if err != nil {
+		return nil, err
+	}
+	var m ChunkManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid chunk manifest: %w", err)
+	}
+	if m.Format != chunkFormat {
+		return nil, fmt.Errorf("unsupported chunk manifest format %q", m.Format)
+	}
+	return &m, nil
+}
+
+// restoreChunk inserts one chunk file in batches, checking it against the
+// manifest as it is read, with foreign key checks off unless checked
+func restoreChunk(ctx context.Context, db *sql.DB, d ChunkDialect, t *ChunkedTable, path string, c TableChunk, batchRows int, checked bool) error {
+	f, err := os.Open(path) // #nosec G304 -- chunk file listed in the manifest
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hw := stream.NewHashWriter(nil)
+	r := bufio.NewReaderSize(io.TeeReader(f, hw), stream.Default().BufferSize())
+
+	cols := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		cols[i] = col.Name
+	}
+	batchRows = max(min(batchRows, d.MaxParams()/len(cols)), 1)
+	prefix := "INSERT INTO " + d.QuoteIdent(t.Name) + " (" + quoteList(d, cols) + ") VALUES "
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !checked {
+		if err := d.ForeignKeyChecks(ctx, conn, false); err != nil {
+			return fmt.Errorf("failed to turn foreign key checks off: %w", err)
+		}
+		// The connection goes back to the pool after the chunk
+		defer d.ForeignKeyChecks(context.WithoutCancel(ctx), conn, true)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var batch []any
+	var n int
+	var rows int64
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+		tuples := make([]string, n)
+		for i := range tuples {
+			tuples[i] = "(" + placeholders(d, i*len(cols)+1, len(cols)) + ")"
+		}
+		if _, err := tx.ExecContext(ctx, prefix+strings.Join(tuples, ", "), batch...); err != nil {
+			return err
+		}
+		batch, n = batch[:0], 0
+		return nil
+	}
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			values, perr := readRow(line, t.Columns)
+			if perr != nil {
+				return fmt.Errorf("row %d: %w", rows+1, perr)
+			}
+			batch = append(batch, values...)
+			n++
+			rows++
+			if n == batchRows {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if rows != c.Rows || hw.Sum() != c.Checksum {
+		return fmt.Errorf("chunk does not match the manifest (%d rows read, %d expected)", rows, c.Rows)
+	}
+	return tx.Commit()
+}
+
+// Chunk files hold one row per line, in the text format of PostgreSQL's
+// COPY: tab-separated values with backslash escapes, \N for NULL
+
+// WriteCopyRow appends a row to w in the text format of COPY
+func WriteCopyRow(w *bufio.Writer, values []sql.RawBytes) {
+	for i, v := range values {
+		if i > 0 {
+			w.WriteByte('\t')
+		}
+		if v == nil {
+			w.WriteString(`\N`)
+			continue
+		}
+		for _, b := range v {
+			switch b {
+			case '\\':
+				w.WriteString(`\\`)
+			case '\t':
+				w.WriteString(`\t`)
+			case '\n':
+				w.WriteString(`\n`)
+			case '\r':
+				w.WriteString(`\r`)
+			default:
+				w.WriteByte(b)
+			}
+		}
+	}
+	w.WriteByte('\n')
+}
+
+// readRow parses a chunk file line into query parameters: nil for NULL,
+// []byte for binary columns and strings otherwise
+func readRow(line []byte, columns []ChunkColumn) ([]any, error) {
+	fields, err := ParseCopyRow(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != len(columns) {
+		return nil, fmt.Errorf("%d values for %d columns", len(fields), len(columns))
+	}
+	values := make([]any, len(fields))
+	for i, v := range fields {
+		switch {
+		case v == nil:
+			values[i] = nil
+		case columns[i].Binary:
+			values[i] = v
+		default:
+			values[i] = string(v)
+		}
+	}
+	return values, nil
+}
+
+// ParseCopyRow parses a line in the text format of COPY into its values,
+// nil for NULL
+func ParseCopyRow(line []byte) ([][]byte, error) {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	fields := bytes.Split(line, []byte{'\t'})
+	values := make([][]byte, len(fields))
+	for i, f := range fields {
+		if string(f) == `\N` {
+			continue
+		}
+		v, err := unescape(f)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func unescape(f []byte) ([]byte, error) {
+	if bytes.IndexByte(f, '\\') < 0 {
+		return append([]byte{}, f...), nil
+	}
+	out := make([]byte, 0, len(f))
+	for i := 0; i < len(f); i++ {
+		if f[i] != '\\' {
+			out = append(out, f[i])
+			continue
+		}
+		i++
+		if i == len(f) {
+			return nil, fmt.Errorf("dangling escape")
+		}
+		switch f[i] {
+		case '\\':
+			out = append(out, '\\')
+		case 't':
+			out = append(out, '\t')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		default:
+			return nil, fmt.Errorf("unknown escape \\%c", f[i])
+		}
+	}
+	return out, nil
+}
+
+// quoteList quotes and joins column names
+func quoteList(d ChunkDialect, cols []string) string {
+	quoted := make([]string, len(cols))
+	for i, c := range cols {
+		quoted[i] = d.QuoteIdent(c)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// placeholders returns n parameters starting at the first-th
+func placeholders(d ChunkDialect, first, n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = d.Placeholder(first + i)
+	}
+	return strings.Join(p, ", ")
+}
+
+// keyAfter compares the primary key with a row of parameters
+func keyAfter(d ChunkDialect, pk []string, first int) string {
+	return fmt.Sprintf("(%s) > (%s)", quoteList(d, pk), placeholders(d, first, len(pk)))
+}
@@ -0,0 +1,405 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteDialect runs chunked exports against SQLite in tests
+type sqliteDialect struct{}
+
+func (sqliteDialect) QuoteIdent(name string) string { return `"` + name + `"` }
+func (sqliteDialect) Placeholder(int) string        { return "?" }
+func (sqliteDialect) MaxParams() int                { return 999 }
+func (sqliteDialect) Binary(columnType string) bool { return strings.EqualFold(columnType, "BLOB") }
+
+func (sqliteDialect) PrimaryKey(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pk []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		pk = append(pk, name)
+	}
+	return pk, rows.Err()
+}
+
+func (sqliteDialect) Snapshot(_ context.Context, db *sql.DB) (ChunkSnapshot, error) {
+	return sqliteSnapshot{db}, nil
+}
+
+func (sqliteDialect) ForeignKeys(context.Context, *sql.DB, []string) (map[string][]string, error) {
+	return nil, nil
+}
+
+func (sqliteDialect) ForeignKeyChecks(ctx context.Context, conn *sql.Conn, on bool) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA foreign_keys = %t", on))
+	return err
+}
+
+type sqliteSnapshot struct{ db *sql.DB }
+
+func (s sqliteSnapshot) Begin(ctx context.Context) (*sql.Tx, error) { return s.db.BeginTx(ctx, nil) }
+func (sqliteSnapshot) Close() error                                 { return nil }
+
+// openSQLite opens a database in a temporary file with the statements run
+func openSQLite(t *testing.T, statements ...string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// One connection, so parallel chunks never find the file locked
+	db.SetMaxOpenConns(1)
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return db
+}
+
+func TestRestoreLevels(t *testing.T) {
+	tables := []string{"line_items", "orders", "customers", "employees", "regions", "stores", "audit"}
+	refs := map[string][]string{
+		"line_items": {"orders"},
+		"orders":     {"customers", "stores"},
+		"customers":  {"regions"},
+		"employees":  {"employees", "stores"},  // manager_id
+		"stores":     {"regions", "employees"}, // manager_id, a cycle
+		"audit":      {"users"},                // not restored
+	}
+	levels, cyclic := restoreLevels(tables, refs)
+	want := [][]string{
+		{"regions", "audit"},
+		{"customers", "employees", "stores"},
+		{"orders"},
+		{"line_items"},
+	}
+	if !reflect.DeepEqual(levels, want) {
+		t.Errorf("levels = %v, want %v", levels, want)
+	}
+	if !reflect.DeepEqual(cyclic, map[string]bool{"employees": true, "stores": true}) {
+		t.Errorf("cyclic = %v", cyclic)
+	}
+
+	levels, cyclic = restoreLevels([]string{"b", "a"}, nil)
+	if !reflect.DeepEqual(levels, [][]string{{"b", "a"}}) || len(cyclic) != 0 {
+		t.Errorf("levels = %v, cyclic = %v without references", levels, cyclic)
+	}
+}
+
+func TestCopyRowRoundTrip(t *testing.T) {
+	values := []sql.RawBytes{[]byte("1"), nil, []byte(""), []byte("tab\there\nline \\N")}
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	WriteCopyRow(w, values)
+	w.Flush()
+	if got := buf.String(); got != "1\t\\N\t\ttab\\there\\nline \\\\N\n" {
+		t.Errorf("row = %q", got)
+	}
+	got, err := ParseCopyRow(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(values) || got[1] != nil || got[2] == nil {
+		t.Fatalf("parsed %q", got)
+	}
+	for i, v := range values {
+		if !bytes.Equal(got[i], v) {
+			t.Errorf("value %d = %q, want %q", i, got[i], v)
+		}
+	}
+}
+
+func TestCopyRowEscapes(t *testing.T) {
+	binary := make([]byte, 256)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+	tests := []struct {
+		name  string
+		value sql.RawBytes
+		line  string
+	}{
+		{"null", nil, `\N`},
+		{"empty", sql.RawBytes{}, ""},
+		{"literal null marker", []byte(`\N`), `\\N`},
+		{"tab", []byte("a\tb"), `a\tb`},
+		{"newline", []byte("a\nb\r\n"), `a\nb\r\n`},
+		{"backslashes", []byte(`C:\dir\\share\`), `C:\\dir\\\\share\\`},
+		{"binary", binary, ""},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		WriteCopyRow(w, []sql.RawBytes{tt.value})
+		w.Flush()
+		line := buf.Bytes()
+		if bytes.Count(line, []byte{'\n'}) != 1 || bytes.IndexByte(line, '\t') >= 0 {
+			t.Errorf("%s: row %q is not one line of one field", tt.name, line)
+		}
+		if tt.line != "" && string(line) != tt.line+"\n" {
+			t.Errorf("%s: row %q, want %q", tt.name, line, tt.line)
+		}
+
+		// Binary columns come back as bytes, the others as strings
+		for _, binaryColumn := range []bool{false, true} {
+			got, err := readRow(line, []ChunkColumn{{Name: "v", Binary: binaryColumn}})
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			switch v := got[0].(type) {
+			case nil:
+				if tt.value != nil {
+					t.Errorf("%s: read back as NULL", tt.name)
+				}
+			case []byte:
+				if !binaryColumn || !bytes.Equal(v, tt.value) || tt.value == nil {
+					t.Errorf("%s: read back %q", tt.name, v)
+				}
+			case string:
+				if binaryColumn || v != string(tt.value) || tt.value == nil {
+					t.Errorf("%s: read back %q", tt.name, v)
+				}
+			default:
+				t.Errorf("%s: read back a %T", tt.name, v)
+			}
+		}
+	}
+}
+
+func TestReadRowErrors(t *testing.T) {
+	columns := []ChunkColumn{{Name: "id"}, {Name: "name"}}
+	for _, line := range []string{
+		"1\n",
+		"1\tname\textra\n",
+		"1\tdangling \\\n",
+		"1\tunknown \\x41\n",
+	} {
+		if values, err := readRow([]byte(line), columns); err == nil {
+			t.Errorf("%q: read %v", line, values)
+		}
+	}
+	if _, err := unescape([]byte(`\`)); err == nil {
+		t.Error("lone backslash unescaped")
+	}
+	if got, err := unescape([]byte(`plain`)); err != nil || string(got) != "plain" {
+		t.Errorf("unescape(plain) = %q, %v", got, err)
+	}
+}
+
+func TestPlanChunks(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t,
+		"CREATE TABLE empty (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE one (id INTEGER PRIMARY KEY)",
+		"INSERT INTO one VALUES (7)",
+		"CREATE TABLE five (id INTEGER PRIMARY KEY)",
+		"INSERT INTO five VALUES (1), (2), (3), (4), (5)",
+		"CREATE TABLE names (code TEXT PRIMARY KEY, n INTEGER)",
+		"INSERT INTO names VALUES ('delta', 1), ('alpha', 2), ('charlie', 3), ('bravo', 4)",
+		"CREATE TABLE pairs (region TEXT, id INTEGER, PRIMARY KEY (region, id))",
+		"INSERT INTO pairs VALUES ('eu', 2), ('us', 1), ('eu', 1), ('us', 2)",
+		"CREATE TABLE heap (v TEXT)",
+	)
+	snap := sqliteSnapshot{db}
+
+	tests := []struct {
+		table        string
+		rowsPerChunk int64
+		bounds       string
+	}{
+		{"empty", 2, "[]"},
+		{"one", 2, "[]"},
+		{"one", 1, "[[7]]"}, // the last chunk is left empty
+		{"five", 2, "[[2] [4]]"},
+		{"five", 5, "[[5]]"},
+		{"five", 10, "[]"},
+		{"names", 3, "[[charlie]]"},
+		{"pairs", 1, "[[eu 1] [eu 2] [us 1] [us 2]]"},
+	}
+	for _, tt := range tests {
+		table, bounds, err := planChunks(ctx, db, snap, sqliteDialect{}, tt.table, tt.rowsPerChunk)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.table, err)
+		}
+		if got := fmt.Sprint(bounds); got != tt.bounds {
+			t.Errorf("%s by %d: bounds %s, want %s", tt.table, tt.rowsPerChunk, got, tt.bounds)
+		}
+		if len(table.PrimaryKey) == 0 || len(table.Columns) == 0 {
+			t.Errorf("%s: table %+v", tt.table, table)
+		}
+	}
+
+	if _, _, err := planChunks(ctx, db, snap, sqliteDialect{}, "heap", 2); err == nil {
+		t.Error("chunked a table without a primary key")
+	}
+}
+
+func TestDumpRestoreChunks(t *testing.T) {
+	ctx := context.Background()
+	schema := "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, data BLOB)"
+	src := openSQLite(t, schema)
+	want := map[int64][2]any{}
+	for i := int64(1); i <= 25; i++ {
+		name, data := any(fmt.Sprintf("item\t%d\n\\", i)), any([]byte{0, byte(i), '\t', '\n', '\\'})
+		switch i % 5 {
+		case 0:
+			name, data = nil, nil
+		case 1:
+			name, data = "", []byte{}
+		}
+		if _, err := src.Exec("INSERT INTO items VALUES (?, ?, ?)", i, name, data); err != nil {
+			t.Fatal(err)
+		}
+		want[i] = [2]any{name, data}
+	}
+
+	dir := t.TempDir()
+	manifest, err := DumpChunks(ctx, src, DatabaseTypeSQLite, sqliteDialect{}, &ChunkedDumpOptions{
+		Tables: []string{"items"}, OutputDir: dir, RowsPerChunk: 10, Parallel: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(manifest.Tables[0].Chunks); n != 3 || manifest.Tables[0].Rows != 25 {
+		t.Fatalf("%d chunks of %d rows", n, manifest.Tables[0].Rows)
+	}
+
+	dst := openSQLite(t, schema)
+	if _, err := RestoreChunks(ctx, dst, sqliteDialect{}, &ChunkedRestoreOptions{SourceDir: dir, Parallel: 3, BatchRows: 4}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := dst.Query("SELECT id, name, data FROM items ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var id int64
+		var name sql.NullString
+		var data []byte
+		if err := rows.Scan(&id, &name, &data); err != nil {
+			t.Fatal(err)
+		}
+		n++
+		w := want[id]
+		switch {
+		case w[0] == nil:
+			if name.Valid || data != nil {
+				t.Errorf("row %d: NULLs restored as %q, %q", id, name.String, data)
+			}
+		case !name.Valid || name.String != w[0] || data == nil || !bytes.Equal(data, w[1].([]byte)):
+			t.Errorf("row %d: restored %q, %q; want %q, %q", id, name.String, data, w[0], w[1])
+		}
+	}
+	if n != 25 {
+		t.Errorf("restored %d rows", n)
+	}
+}
+
+func TestRestoreChunksChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	schema := "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"
+	src := openSQLite(t, schema, "INSERT INTO items VALUES (1, 'a'), (2, 'b'), (3, 'c')")
+	dir := t.TempDir()
+	if _, err := DumpChunks(ctx, src, DatabaseTypeSQLite, sqliteDialect{}, &ChunkedDumpOptions{
+		Tables: []string{"items"}, OutputDir: dir, RowsPerChunk: 2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A chunk changed after the export, same number of rows
+	chunk := filepath.Join(dir, "items", "items.00001.tsv")
+	data, _ := os.ReadFile(chunk)
+	if err := os.WriteFile(chunk, bytes.Replace(data, []byte("a"), []byte("z"), 1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dst := openSQLite(t, schema)
+	_, err := RestoreChunks(ctx, dst, sqliteDialect{}, &ChunkedRestoreOptions{SourceDir: dir})
+	if err == nil || !strings.Contains(err.Error(), "does not match the manifest") {
+		t.Fatalf("restore of a changed chunk: %v", err)
+	}
+	// The chunk's transaction is rolled back
+	var n int
+	dst.QueryRow("SELECT count(*) FROM items WHERE name = 'z'").Scan(&n)
+	if n != 0 {
+		t.Error("rows of a changed chunk restored")
+	}
+
+	// So is a manifest whose checksum was changed
+	os.WriteFile(chunk, data, 0o600)
+	m, _ := ReadChunkManifest(dir)
+	m.Tables[0].Chunks[1].Checksum = strings.Repeat("0", 64)
+	raw, _ := json.Marshal(m)
+	os.WriteFile(filepath.Join(dir, ChunkManifestFile), raw, 0o600)
+	if _, err := RestoreChunks(ctx, openSQLite(t, schema), sqliteDialect{}, &ChunkedRestoreOptions{SourceDir: dir}); err == nil {
+		t.Error("restored against a changed manifest checksum")
+	}
+}
+
+// cancellingDialect cancels the export once its chunks start being read
+type cancellingDialect struct {
+	sqliteDialect
+	cancel context.CancelFunc
+	begun  *atomic.Int32
+}
+
+func (d cancellingDialect) Snapshot(_ context.Context, db *sql.DB) (ChunkSnapshot, error) {
+	return cancellingSnapshot{sqliteSnapshot{db}, d}, nil
+}
+
+type cancellingSnapshot struct {
+	sqliteSnapshot
+	d cancellingDialect
+}
+
+func (s cancellingSnapshot) Begin(ctx context.Context) (*sql.Tx, error) {
+	// The first transaction plans the chunks
+	if s.d.begun.Add(1) == 2 {
+		s.d.cancel()
+	}
+	return s.sqliteSnapshot.Begin(ctx)
+}
+
+func TestChunksCancelled(t *testing.T) {
+	src := openSQLite(t, "CREATE TABLE items (id INTEGER PRIMARY KEY)", "INSERT INTO items VALUES (1), (2), (3), (4), (5), (6)")
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	d := cancellingDialect{cancel: cancel, begun: new(atomic.Int32)}
+	_, err := DumpChunks(ctx, src, DatabaseTypeSQLite, d, &ChunkedDumpOptions{
+		Tables: []string{"items"}, OutputDir: dir, RowsPerChunk: 1, Parallel: 2,
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled dump: %v", err)
+	}
+	// Chunks not started when the export was cancelled are skipped
+	if n := d.begun.Load(); n > 3 {
+		t.Errorf("%d transactions begun after the cancel", n-2)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ChunkManifestFile)); err == nil {
+		t.Error("cancelled dump wrote a manifest")
+	}
+}
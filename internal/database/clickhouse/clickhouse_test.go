@@ -0,0 +1,166 @@
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// fakeServer answers the queries the driver sends and records the
+// statements that change something
+type fakeServer struct {
+	mu         sync.Mutex
+	statements []string
+	polls      int
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-ClickHouse-User") != "backup" || r.Header.Get("X-ClickHouse-Key") != "secret" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "Code: 516. DB::Exception: backup: Authentication failed. (AUTHENTICATION_FAILED)")
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	sql := string(body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case sql == "SELECT 1":
+		fmt.Fprintln(w, `{"1":1}`)
+	case strings.HasPrefix(sql, "SELECT version()"):
+		fmt.Fprintln(w, `{"version":"24.3.2.23"}`)
+	case strings.HasPrefix(sql, "BACKUP "), strings.HasPrefix(sql, "RESTORE "), strings.HasPrefix(sql, "DROP "):
+		f.statements = append(f.statements, sql)
+		if strings.HasPrefix(sql, "BACKUP ") {
+			fmt.Fprintln(w, `{"id":"b-1","status":"CREATING_BACKUP"}`)
+		} else if strings.HasPrefix(sql, "RESTORE ") {
+			fmt.Fprintln(w, `{"id":"r-1","status":"RESTORING"}`)
+		}
+	case strings.Contains(sql, "FROM system.backups"):
+		f.polls++
+		switch id := r.URL.Query().Get("param_id"); {
+		case id == "b-1" && f.polls == 1:
+			fmt.Fprintln(w, `{"id":"b-1","status":"CREATING_BACKUP"}`)
+		case id == "b-1":
+			fmt.Fprintln(w, `{"id":"b-1","status":"BACKUP_CREATED","uncompressed_size":"1000","compressed_size":"400"}`)
+		case id == "r-1":
+			fmt.Fprintln(w, `{"id":"r-1","status":"RESTORED"}`)
+		}
+	case strings.Contains(sql, "FROM system.tables WHERE database IN"):
+		fmt.Fprintln(w, `{"database":"shop","name":"orders","total_rows":"10","total_bytes":"600"}`)
+		fmt.Fprintln(w, `{"database":"shop","name":"events","total_rows":"5","total_bytes":"400"}`)
+	case strings.Contains(sql, "FROM system.databases"):
+		fmt.Fprintln(w, `{"name":"INFORMATION_SCHEMA"}`)
+		fmt.Fprintln(w, `{"name":"shop"}`)
+		fmt.Fprintln(w, `{"name":"system"}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Code: 62. DB::Exception: unexpected query %q", sql)
+	}
+}
+
+func connect(t *testing.T, options map[string]string) (*ClickHouseDriver, *fakeServer) {
+	t.Helper()
+	pollInterval = time.Millisecond
+	f := &fakeServer{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	portNum, _ := strconv.Atoi(port)
+
+	d := NewClickHouseDriver()
+	config := &database.ConnectionConfig{Host: host, Port: portNum, Username: "backup", Password: "secret", Options: options}
+	if err := d.Connect(context.Background(), config); err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+	return d, f
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	d, f := connect(t, map[string]string{"s3_url": "https://bucket.s3.amazonaws.com/ch/", "s3_access_key_id": "AKIA", "s3_secret_access_key": "s'cret"})
+	ctx := context.Background()
+
+	out := filepath.Join(t.TempDir(), "manifest.json")
+	result, err := d.Backup(ctx, &database.BackupOptions{Database: "shop", ExcludeTables: []string{"events"}, OutputPath: out})
+	if err != nil {
+		t.Fatalf("Backup() = %v", err)
+	}
+	if result.Size != 1000 || result.CompressedSize != 400 || result.Checksum == "" || len(result.Tables) != 1 || result.Tables[0].Name != "shop.orders" {
+		t.Errorf("Backup() = %+v", result)
+	}
+	want := "BACKUP DATABASE `shop` EXCEPT TABLES `shop`.`events` TO S3('https://bucket.s3.amazonaws.com/ch/" + result.ID + "', 'AKIA', 's\\'cret') ASYNC"
+	if f.statements[0] != want {
+		t.Errorf("statement = %s, want %s", f.statements[0], want)
+	}
+	manifest, _ := os.ReadFile(out)
+	if bytes.Contains(manifest, []byte("AKIA")) || !bytes.Contains(manifest, []byte(ManifestFormat)) {
+		t.Errorf("manifest = %s", manifest)
+	}
+
+	// Restored under another name, replacing it
+	f.statements = nil
+	if _, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: out, Database: "shop_copy", DropExisting: true}); err != nil {
+		t.Fatalf("Restore() = %v", err)
+	}
+	wantStatements := []string{
+		"DROP DATABASE IF EXISTS `shop_copy` SYNC",
+		"RESTORE DATABASE `shop` AS `shop_copy` EXCEPT TABLES `shop`.`events` FROM S3('https://bucket.s3.amazonaws.com/ch/" + result.ID + "', 'AKIA', 's\\'cret') ASYNC",
+	}
+	if strings.Join(f.statements, "\n") != strings.Join(wantStatements, "\n") {
+		t.Errorf("statements = %q", f.statements)
+	}
+
+	if err := d.StreamRestore(ctx, &database.RestoreOptions{}, strings.NewReader("-- MySQL dump")); err == nil || !strings.Contains(err.Error(), "manifest") {
+		t.Errorf("StreamRestore() of a SQL dump = %v", err)
+	}
+}
+
+func TestBackupTablesToDisk(t *testing.T) {
+	d, f := connect(t, nil)
+	var buf bytes.Buffer
+	err := d.StreamBackup(context.Background(), &database.BackupOptions{Databases: []string{"shop", "logs"}, Tables: []string{"shop.orders", "logs.access"}}, &buf)
+	if err != nil {
+		t.Fatalf("StreamBackup() = %v", err)
+	}
+	if !strings.HasPrefix(f.statements[0], "BACKUP TABLE `shop`.`orders`, TABLE `logs`.`access` TO Disk('backups', '") {
+		t.Errorf("statement = %s", f.statements[0])
+	}
+	m, err := readManifest(&buf)
+	if err != nil || m.ID != "b-1" || m.Destination.Disk != DefaultDisk {
+		t.Errorf("manifest = %+v, %v", m, err)
+	}
+
+	// Unqualified tables are ambiguous across databases
+	err = d.StreamBackup(context.Background(), &database.BackupOptions{Databases: []string{"shop", "logs"}, Tables: []string{"orders"}}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "database.table") {
+		t.Errorf("StreamBackup() of unqualified tables = %v", err)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	d, _ := connect(t, nil)
+	databases, err := d.GetDatabases(context.Background())
+	if err != nil || strings.Join(databases, ",") != "shop" {
+		t.Errorf("GetDatabases() = %v, %v", databases, err)
+	}
+
+	d.config.Password = "wrong"
+	bad := NewClickHouseDriver()
+	if err := bad.Connect(context.Background(), d.config); err == nil || !strings.Contains(err.Error(), "Authentication failed") {
+		t.Errorf("Connect() with the wrong password = %v", err)
+	}
+}
@@ -0,0 +1,623 @@
+// Package clickhouse provides the ClickHouse database driver. Backups use
+// the server's own BACKUP and RESTORE statements, so the data goes from
+// ClickHouse straight to a backup disk or an S3 bucket and never through
+// this host. What db-backup stores is a small JSON manifest naming that
+// location; restores read it and run RESTORE from the same place.
+//
+// Connections use the HTTP interface, port 8123 or 8443 with TLS. The
+// destination is set with connection options: backup_disk names a disk
+// allowed in the server's backups.allowed_disk ("backups" by default), or
+// s3_url, with s3_access_key_id and s3_secret_access_key unless the server
+// has credentials of its own, sends backups to S3.
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// ManifestFormat identifies the manifests written by this driver
+const ManifestFormat = "clickhouse-native-backup/v1"
+
+// DefaultDisk is the backup disk used when backup_disk is not set
+const DefaultDisk = "backups"
+
+// pollInterval is how often a running BACKUP or RESTORE is checked
+var pollInterval = time.Second
+
+// systemDatabases are never backed up by --all-databases
+var systemDatabases = map[string]bool{"system": true, "information_schema": true, "INFORMATION_SCHEMA": true}
+
+// Destination is where the server wrote a backup
+type Destination struct {
+	Disk  string `json:"disk,omitempty"`
+	S3URL string `json:"s3_url,omitempty"`
+	Path  string `json:"path"`
+}
+
+// Manifest describes a native backup. It is the artifact db-backup stores.
+type Manifest struct {
+	Format         string      `json:"format"`
+	ID             string      `json:"id"` // of the backup in system.backups
+	Destination    Destination `json:"destination"`
+	Databases      []string    `json:"databases,omitempty"`
+	Tables         []string    `json:"tables,omitempty"`         // database.table
+	ExcludeTables  []string    `json:"exclude_tables,omitempty"` // database.table
+	Version        string      `json:"version"`
+	CreatedAt      time.Time   `json:"created_at"`
+	Size           int64       `json:"size"`
+	CompressedSize int64       `json:"compressed_size"`
+}
+
+// ClickHouseDriver implements the database.Driver interface for ClickHouse
+type ClickHouseDriver struct {
+	client *client
+	config *database.ConnectionConfig
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeClickHouse, func() database.Driver {
+		return NewClickHouseDriver()
+	})
+}
+
+// NewClickHouseDriver creates a new ClickHouse driver instance
+func NewClickHouseDriver() *ClickHouseDriver {
+	return &ClickHouseDriver{}
+}
+
+// Connect checks the server answers with the credentials given
+func (d *ClickHouseDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	c, err := newClient(config)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if _, err := c.query(ctx, "SELECT 1", nil); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.client = c
+	d.config = config
+	return nil
+}
+
+// Disconnect releases idle connections
+func (d *ClickHouseDriver) Disconnect() error {
+	if d.client != nil {
+		d.client.hc.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Ping tests the connection
+func (d *ClickHouseDriver) Ping(ctx context.Context) error {
+	if d.client == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	_, err := d.client.query(ctx, "SELECT 1", nil)
+	return err
+}
+
+// Backup runs a native backup and writes its manifest to opts.OutputPath.
+// Size and CompressedSize are those of the data the server wrote.
+func (d *ClickHouseDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	m, err := d.backup(ctx, result.ID, opts)
+	if err != nil {
+		return fail(err)
+	}
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	err = writeManifest(output, m)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	result.Tables, _ = d.tables(ctx, m)
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = m.Version
+	result.Size = m.Size
+	result.CompressedSize = m.CompressedSize
+	result.Checksum = output.Sum()
+	metadata := map[string]string{
+		"clickhouse_backup_id":   m.ID,
+		"clickhouse_destination": m.Destination.String(),
+	}
+	for k, v := range result.Metadata {
+		metadata[k] = v
+	}
+	result.Metadata = metadata
+	result.Status = database.BackupStatusSuccess
+
+	return result, nil
+}
+
+// StreamBackup runs a native backup and writes its manifest to writer
+func (d *ClickHouseDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	m, err := d.backup(ctx, utils.GenerateBackupID(), opts)
+	if err != nil {
+		return err
+	}
+	return writeManifest(writer, m)
+}
+
+// GetBackupSize returns the size on disk of the active parts backed up
+func (d *ClickHouseDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	m, err := d.spec(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	tables, err := d.tables(ctx, m)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, t := range tables {
+		size += t.DataSize
+	}
+	return size, nil
+}
+
+// Restore restores the backup whose manifest is opts.SourceBackup
+func (d *ClickHouseDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+
+	file, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+	defer file.Close()
+
+	restored, err := d.restore(ctx, opts, file)
+	if err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err)
+	}
+
+	tables, _ := d.tables(ctx, restored)
+	for _, t := range tables {
+		result.RestoredTables = append(result.RestoredTables, t.Name)
+		result.RowsRestored += t.RowCount
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+
+	return result, nil
+}
+
+// StreamRestore restores the backup whose manifest is read from reader
+func (d *ClickHouseDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	_, err := d.restore(ctx, opts, reader)
+	return err
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *ClickHouseDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	file, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer file.Close()
+	m, err := readManifest(file)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if opts.Database != "" && len(m.Databases) > 1 {
+		return pkgErrors.ErrValidationFailed("the backup holds several databases and cannot be restored under one name")
+	}
+	if err := d.Ping(ctx); err != nil {
+		return pkgErrors.ErrValidationFailed("database connection failed")
+	}
+	return nil
+}
+
+// GetDatabases returns the user databases
+func (d *ClickHouseDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	names, err := d.client.column(ctx, "SELECT name FROM system.databases ORDER BY name", nil, "name")
+	if err != nil {
+		return nil, err
+	}
+	databases := names[:0]
+	for _, name := range names {
+		if !systemDatabases[name] {
+			databases = append(databases, name)
+		}
+	}
+	return databases, nil
+}
+
+// GetTables returns the tables of a database
+func (d *ClickHouseDriver) GetTables(ctx context.Context, db string) ([]string, error) {
+	return d.client.column(ctx, "SELECT name FROM system.tables WHERE database = {db:String} ORDER BY name",
+		map[string]string{"db": db}, "name")
+}
+
+// GetTableSize returns the size on disk of a table's active parts
+func (d *ClickHouseDriver) GetTableSize(ctx context.Context, db, table string) (int64, error) {
+	rows, err := d.client.query(ctx,
+		"SELECT sum(bytes_on_disk) AS size FROM system.parts WHERE active AND database = {db:String} AND table = {table:String}",
+		map[string]string{"db": db, "table": table})
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return integer(rows[0]["size"]), nil
+}
+
+// GetVersion returns the ClickHouse server version
+func (d *ClickHouseDriver) GetVersion(ctx context.Context) (string, error) {
+	versions, err := d.client.column(ctx, "SELECT version() AS version", nil, "version")
+	if err != nil || len(versions) == 0 {
+		return "", err
+	}
+	return versions[0], nil
+}
+
+// GetType returns the database type
+func (d *ClickHouseDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeClickHouse
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *ClickHouseDriver) SupportsIncremental() bool {
+	return false
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *ClickHouseDriver) SupportsPITR() bool {
+	return false
+}
+
+// backup runs BACKUP to a new location named id and waits for it
+func (d *ClickHouseDriver) backup(ctx context.Context, id string, opts *database.BackupOptions) (*Manifest, error) {
+	m, err := d.spec(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.Destination = d.destination(id)
+	m.Version, _ = d.GetVersion(ctx)
+	m.CreatedAt = time.Now().UTC()
+
+	status, err := d.run(ctx, "BACKUP "+m.elements("")+" TO "+d.destinationSQL(m.Destination)+" ASYNC")
+	if err != nil {
+		return nil, err
+	}
+	m.ID = str(status["id"])
+	m.Size = integer(status["uncompressed_size"])
+	if m.Size == 0 {
+		m.Size = integer(status["total_size"])
+	}
+	m.CompressedSize = integer(status["compressed_size"])
+	return m, nil
+}
+
+// restore runs RESTORE of the manifest read from r, into opts.Target()
+// when set, and returns what was restored
+func (d *ClickHouseDriver) restore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) (*Manifest, error) {
+	m, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the tables asked for, out of those backed up
+	source := ""
+	if len(m.Databases) == 1 {
+		source = m.Databases[0]
+	}
+	if len(opts.Tables) > 0 {
+		if m.Tables, err = qualify(source, opts.Tables); err != nil {
+			return nil, err
+		}
+	}
+	exclude, err := qualify(source, opts.ExcludeTables)
+	if err != nil {
+		return nil, err
+	}
+	m.ExcludeTables = append(m.ExcludeTables, exclude...)
+
+	target := ""
+	if name := opts.Target(); name != "" && name != source {
+		if source == "" {
+			return nil, errors.New("the backup holds several databases and cannot be restored under one name")
+		}
+		target = name
+	}
+	restored := m.renamed(target)
+
+	// RESTORE refuses tables that hold data, so replacing them means
+	// dropping them first
+	if opts.DropExisting {
+		for _, stmt := range restored.drops() {
+			if _, err := d.client.query(ctx, stmt, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if _, err := d.run(ctx, "RESTORE "+m.elements(target)+" FROM "+d.destinationSQL(m.Destination)+" ASYNC"); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// run starts an ASYNC BACKUP or RESTORE and waits for it to finish,
+// returning its row of system.backups
+func (d *ClickHouseDriver) run(ctx context.Context, stmt string) (map[string]any, error) {
+	rows, err := d.client.query(ctx, stmt, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || str(rows[0]["id"]) == "" {
+		return nil, errors.New("the server returned no backup id")
+	}
+	id := str(rows[0]["id"])
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		rows, err := d.client.query(ctx, "SELECT * FROM system.backups WHERE id = {id:String}", map[string]string{"id": id})
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("backup %s is missing from system.backups", id)
+		}
+		switch status := str(rows[0]["status"]); status {
+		case "BACKUP_CREATED", "RESTORED":
+			return rows[0], nil
+		case "CREATING_BACKUP", "RESTORING":
+		default:
+			// BACKUP_FAILED, RESTORE_FAILED or cancelled
+			return nil, fmt.Errorf("%s: %s", status, str(rows[0]["error"]))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// spec returns a manifest of what opts back up. Tables are qualified with
+// their database.
+func (d *ClickHouseDriver) spec(ctx context.Context, opts *database.BackupOptions) (*Manifest, error) {
+	m := &Manifest{Format: ManifestFormat}
+	switch {
+	case opts.AllDatabases:
+		databases, err := d.GetDatabases(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m.Databases = databases
+	case len(opts.Databases) > 0:
+		m.Databases = opts.Databases
+	case opts.Database != "":
+		m.Databases = []string{opts.Database}
+	default:
+		return nil, errors.New("no database to back up")
+	}
+	if len(m.Databases) == 0 {
+		return nil, errors.New("the server has no user databases")
+	}
+	source := ""
+	if len(m.Databases) == 1 {
+		source = m.Databases[0]
+	}
+	var err error
+	if m.Tables, err = qualify(source, opts.Tables); err != nil {
+		return nil, err
+	}
+	if m.ExcludeTables, err = qualify(source, opts.ExcludeTables); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// destination returns the location of the backup named id
+func (d *ClickHouseDriver) destination(id string) Destination {
+	if url := d.config.Options["s3_url"]; url != "" {
+		return Destination{S3URL: strings.TrimSuffix(url, "/"), Path: id}
+	}
+	disk := d.config.Options["backup_disk"]
+	if disk == "" {
+		disk = DefaultDisk
+	}
+	return Destination{Disk: disk, Path: id}
+}
+
+// destinationSQL returns the TO or FROM clause of a destination, with the
+// S3 credentials of the connection
+func (d *ClickHouseDriver) destinationSQL(dest Destination) string {
+	if dest.S3URL == "" {
+		return fmt.Sprintf("Disk(%s, %s)", literal(dest.Disk), literal(dest.Path))
+	}
+	args := []string{literal(dest.S3URL + "/" + dest.Path)}
+	if key := d.config.Options["s3_access_key_id"]; key != "" {
+		args = append(args, literal(key), literal(d.config.Options["s3_secret_access_key"]))
+	}
+	return "S3(" + strings.Join(args, ", ") + ")"
+}
+
+// String describes a destination without credentials
+func (dest Destination) String() string {
+	if dest.S3URL != "" {
+		return dest.S3URL + "/" + dest.Path
+	}
+	return "disk " + dest.Disk + ":" + dest.Path
+}
+
+// elements returns the list of what BACKUP or RESTORE handles. A target
+// restores the only database under another name.
+func (m *Manifest) elements(target string) string {
+	var elems []string
+	if len(m.Tables) > 0 {
+		for _, t := range m.Tables {
+			db, table, _ := strings.Cut(t, ".")
+			elem := "TABLE " + ident(db) + "." + ident(table)
+			if target != "" {
+				elem += " AS " + ident(target) + "." + ident(table)
+			}
+			elems = append(elems, elem)
+		}
+		return strings.Join(elems, ", ")
+	}
+	for _, db := range m.Databases {
+		elem := "DATABASE " + ident(db)
+		if target != "" {
+			elem += " AS " + ident(target)
+		}
+		var except []string
+		for _, t := range m.ExcludeTables {
+			if tdb, table, _ := strings.Cut(t, "."); tdb == db {
+				except = append(except, ident(db)+"."+ident(table))
+			}
+		}
+		if len(except) > 0 {
+			elem += " EXCEPT TABLES " + strings.Join(except, ", ")
+		}
+		elems = append(elems, elem)
+	}
+	return strings.Join(elems, ", ")
+}
+
+// renamed returns the manifest's contents under the target database
+func (m *Manifest) renamed(target string) *Manifest {
+	r := *m
+	if target == "" {
+		return &r
+	}
+	r.Databases = []string{target}
+	r.Tables = nil
+	for _, t := range m.Tables {
+		_, table, _ := strings.Cut(t, ".")
+		r.Tables = append(r.Tables, target+"."+table)
+	}
+	return &r
+}
+
+// drops returns the statements dropping what a restore of m recreates
+func (m *Manifest) drops() []string {
+	var stmts []string
+	if len(m.Tables) > 0 {
+		for _, t := range m.Tables {
+			db, table, _ := strings.Cut(t, ".")
+			stmts = append(stmts, "DROP TABLE IF EXISTS "+ident(db)+"."+ident(table)+" SYNC")
+		}
+		return stmts
+	}
+	for _, db := range m.Databases {
+		stmts = append(stmts, "DROP DATABASE IF EXISTS "+ident(db)+" SYNC")
+	}
+	return stmts
+}
+
+// tables returns the tables m covers with their row counts and sizes
+func (d *ClickHouseDriver) tables(ctx context.Context, m *Manifest) ([]database.TableInfo, error) {
+	quoted := make([]string, len(m.Databases))
+	for i, db := range m.Databases {
+		quoted[i] = literal(db)
+	}
+	rows, err := d.client.query(ctx,
+		"SELECT database, name, total_rows, total_bytes FROM system.tables WHERE database IN {dbs:Array(String)}",
+		map[string]string{"dbs": "[" + strings.Join(quoted, ",") + "]"})
+	if err != nil {
+		return nil, err
+	}
+
+	only := map[string]bool{}
+	for _, t := range m.Tables {
+		only[t] = true
+	}
+	excluded := map[string]bool{}
+	for _, t := range m.ExcludeTables {
+		excluded[t] = true
+	}
+	var tables []database.TableInfo
+	for _, row := range rows {
+		name := str(row["database"]) + "." + str(row["name"])
+		if (len(only) > 0 && !only[name]) || excluded[name] {
+			continue
+		}
+		tables = append(tables, database.TableInfo{
+			Name:     name,
+			RowCount: integer(row["total_rows"]),
+			DataSize: integer(row["total_bytes"]),
+		})
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables, nil
+}
+
+// qualify prefixes table names without a database with db, the only
+// database of a backup, or "" when there are several
+func qualify(db string, tables []string) ([]string, error) {
+	qualified := make([]string, 0, len(tables))
+	for _, t := range tables {
+		if !strings.Contains(t, ".") {
+			if db == "" {
+				return nil, fmt.Errorf("table %s: name tables database.table when there are several databases", t)
+			}
+			t = db + "." + t
+		}
+		qualified = append(qualified, t)
+	}
+	return qualified, nil
+}
+
+// writeManifest writes m as indented JSON
+func writeManifest(w io.Writer, m *Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// readManifest reads a manifest, refusing other artifacts
+func readManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(r, 1<<20)).Decode(&m); err != nil || m.Format != ManifestFormat {
+		return nil, errors.New("not a ClickHouse backup manifest")
+	}
+	if m.Destination.Path == "" || (m.Destination.Disk == "" && m.Destination.S3URL == "") {
+		return nil, errors.New("the manifest names no backup location")
+	}
+	return &m, nil
+}
@@ -0,0 +1,272 @@
+package cockroachdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// fakeCluster answers the statements the driver sends and records those
+// that change something
+type fakeCluster struct {
+	mu         sync.Mutex
+	statements []string
+	backups    map[string][]string // full backups by collection URI
+	layers     map[string][]time.Time
+	clock      int64
+}
+
+var (
+	fake     *fakeCluster
+	fullInto = regexp.MustCompile(`INTO '([^']*)' AS OF`)
+	incrInto = regexp.MustCompile(`INTO '([^']*)' IN '([^']*)'`)
+)
+
+func init() {
+	sql.Register("cockroachdb-fake", fakeDriver{})
+	sqlDriver = "cockroachdb-fake"
+}
+
+func (f *fakeCluster) query(stmt string) ([]string, [][]driver.Value, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case stmt == "SELECT version()":
+		return []string{"version"}, [][]driver.Value{{"CockroachDB CCL v23.2.4 (x86_64-pc-linux-gnu, built 2024/04/03 00:00:00, go1.21.8 X:nocoverageredesign)"}}, nil
+	case stmt == "SELECT cluster_logical_timestamp()::STRING":
+		f.clock++
+		return []string{"ts"}, [][]driver.Value{{fmt.Sprintf("17041104000000000%02d.0000000000", f.clock)}}, nil
+	case strings.HasPrefix(stmt, "SHOW BACKUPS IN '"):
+		var rows [][]driver.Value
+		for _, p := range f.backups[strings.TrimSuffix(strings.TrimPrefix(stmt, "SHOW BACKUPS IN '"), "'")] {
+			rows = append(rows, []driver.Value{p})
+		}
+		return []string{"path"}, rows, nil
+	case strings.HasPrefix(stmt, "BACKUP "):
+		f.statements = append(f.statements, stmt)
+		subdir := ""
+		if m := incrInto.FindStringSubmatch(stmt); m != nil {
+			subdir = m[1]
+		} else if m := fullInto.FindStringSubmatch(stmt); m != nil {
+			subdir = fmt.Sprintf("/2024/01/01-00000%d.00", len(f.backups[m[1]]))
+			f.backups[m[1]] = append(f.backups[m[1]], subdir)
+		}
+		f.layers[subdir] = append(f.layers[subdir], time.Date(2024, 1, 1, 0, 0, int(f.clock), 0, time.UTC))
+		return []string{"job_id", "status", "fraction_completed", "rows", "index_entries", "bytes"},
+			[][]driver.Value{{int64(42), "succeeded", 1.0, int64(15), int64(0), int64(2048)}}, nil
+	case strings.Contains(stmt, "FROM [SHOW BACKUP FROM '"):
+		subdir := strings.SplitN(strings.SplitN(stmt, "FROM [SHOW BACKUP FROM '", 2)[1], "'", 2)[0]
+		var rows [][]driver.Value
+		for i, end := range f.layers[subdir] {
+			rows = append(rows,
+				[]driver.Value{"bank", "public", "accounts", end, int64(10 + i), int64(1000)},
+				[]driver.Value{"bank", "audit", "events", end, int64(5), int64(500)})
+		}
+		return []string{"database_name", "parent_schema_name", "object_name", "end_time", "rows", "size_bytes"}, rows, nil
+	case strings.HasPrefix(stmt, `SELECT schema_name, table_name FROM [SHOW TABLES FROM "bank"]`):
+		return []string{"schema_name", "table_name"}, [][]driver.Value{{"audit", "events"}, {"public", "accounts"}, {"public", "sessions"}}, nil
+	case strings.HasPrefix(stmt, "RESTORE "), strings.HasPrefix(stmt, "DROP "):
+		f.statements = append(f.statements, stmt)
+		return nil, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected statement %q", stmt)
+}
+
+func connect(t *testing.T, options map[string]string) *CockroachDBDriver {
+	t.Helper()
+	fake = &fakeCluster{backups: map[string][]string{}, layers: map[string][]time.Time{}}
+	d := NewCockroachDBDriver()
+	if err := d.Connect(context.Background(), &database.ConnectionConfig{
+		Host: "crdb-1", Port: 26257, Username: "backup", Options: options,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Disconnect() })
+	return d
+}
+
+func (f *fakeCluster) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stmts := f.statements
+	f.statements = nil
+	return stmts
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	d := connect(t, map[string]string{"backup_uri": "s3://bucket/crdb?AUTH=specified&AWS_SECRET_ACCESS_KEY=secret"})
+	uri := "s3://bucket/crdb/databases/bank?AUTH=specified&AWS_SECRET_ACCESS_KEY=secret"
+	dir := t.TempDir()
+
+	// The first incremental backup has no full backup to build on
+	full, err := d.Backup(ctx, &database.BackupOptions{Database: "bank", Incremental: true, OutputPath: filepath.Join(dir, "full.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := fake.take()
+	want := `BACKUP DATABASE "bank" INTO '` + uri + `' AS OF SYSTEM TIME '1704110400000000001.0000000000'`
+	if len(stmts) != 1 || stmts[0] != want {
+		t.Fatalf("full backup ran %q, want %q", stmts, want)
+	}
+	if full.Metadata["cockroachdb_backup_type"] != TypeFull || full.Size != 2048 || full.DatabaseVersion != "23.2.4" || len(full.Tables) != 2 {
+		t.Errorf("full backup = %+v", full)
+	}
+
+	incr, err := d.Backup(ctx, &database.BackupOptions{Database: "bank", Incremental: true, OutputPath: filepath.Join(dir, "incr.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts = fake.take()
+	want = `BACKUP DATABASE "bank" INTO '/2024/01/01-000000.00' IN '` + uri + `' AS OF SYSTEM TIME '1704110400000000002.0000000000'`
+	if len(stmts) != 1 || stmts[0] != want {
+		t.Fatalf("incremental backup ran %q, want %q", stmts, want)
+	}
+	if incr.Metadata["cockroachdb_backup_type"] != TypeIncremental || incr.Tables[0].Name != "bank.accounts" || incr.Tables[0].RowCount != 11 {
+		t.Errorf("incremental backup = %+v", incr)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "incr.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("the manifest holds the storage credentials:\n%s", data)
+	}
+
+	// Restored as of the incremental backup, under another name
+	result, err := d.Restore(ctx, &database.RestoreOptions{
+		Database:     "bank_copy",
+		SourceBackup: filepath.Join(dir, "incr.json"),
+		DropExisting: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts = fake.take()
+	wantStmts := []string{
+		`DROP DATABASE IF EXISTS "bank_copy" CASCADE`,
+		`RESTORE DATABASE "bank" FROM '/2024/01/01-000000.00' IN '` + uri + `' AS OF SYSTEM TIME '1704110400000000002.0000000000' WITH new_db_name = 'bank_copy'`,
+	}
+	if strings.Join(stmts, "\n") != strings.Join(wantStmts, "\n") {
+		t.Errorf("restore ran\n%s\nwant\n%s", strings.Join(stmts, "\n"), strings.Join(wantStmts, "\n"))
+	}
+	if strings.Join(result.RestoredTables, ",") != "bank_copy.accounts,bank_copy.audit.events" || result.RowsRestored != 16 {
+		t.Errorf("restore = %+v", result)
+	}
+
+	// Tables of the backup into another database
+	var manifest bytes.Buffer
+	manifest.Write(data)
+	if err := d.StreamRestore(ctx, &database.RestoreOptions{Database: "scratch", Tables: []string{"accounts"}}, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	want = `RESTORE TABLE "bank"."accounts" FROM '/2024/01/01-000000.00' IN '` + uri + `' AS OF SYSTEM TIME '1704110400000000002.0000000000' WITH into_db = 'scratch'`
+	if stmts := fake.take(); len(stmts) != 1 || stmts[0] != want {
+		t.Errorf("table restore ran %q, want %q", stmts, want)
+	}
+}
+
+func TestBackupCluster(t *testing.T) {
+	d := connect(t, nil)
+	var out bytes.Buffer
+	if err := d.StreamBackup(context.Background(), &database.BackupOptions{AllDatabases: true}, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := `BACKUP INTO 'nodelocal://1/db-backup/cluster' AS OF SYSTEM TIME '1704110400000000001.0000000000'`
+	if stmts := fake.take(); len(stmts) != 1 || stmts[0] != want {
+		t.Fatalf("cluster backup ran %q, want %q", stmts, want)
+	}
+
+	// A database of a cluster backup
+	if err := d.StreamRestore(context.Background(), &database.RestoreOptions{Database: "bank"}, bytes.NewReader(out.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	want = `RESTORE DATABASE "bank" FROM '/2024/01/01-000000.00' IN 'nodelocal://1/db-backup/cluster' AS OF SYSTEM TIME '1704110400000000001.0000000000'`
+	if stmts := fake.take(); len(stmts) != 1 || stmts[0] != want {
+		t.Errorf("database restore ran %q, want %q", stmts, want)
+	}
+
+	// Backups are only restored from where they were taken
+	other := connect(t, map[string]string{"backup_uri": "gs://elsewhere/crdb?AUTH=implicit"})
+	err := other.StreamRestore(context.Background(), &database.RestoreOptions{}, bytes.NewReader(out.Bytes()))
+	if err == nil || !strings.Contains(err.Error(), "backup_uri points at gs://elsewhere/crdb") {
+		t.Errorf("restore from another collection: %v", err)
+	}
+}
+
+func TestBackupExcludeTables(t *testing.T) {
+	d := connect(t, nil)
+	err := d.StreamBackup(context.Background(), &database.BackupOptions{Database: "bank", ExcludeTables: []string{"sessions"}}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := fake.take()
+	if len(stmts) != 1 || !strings.HasPrefix(stmts[0], `BACKUP TABLE "bank"."audit"."events", "bank"."accounts" INTO 'nodelocal://1/db-backup/tables/`) {
+		t.Errorf("backup ran %q", stmts)
+	}
+}
+
+func TestConnectionString(t *testing.T) {
+	got := buildConnectionString(&database.ConnectionConfig{
+		Host: "crdb-1", Port: 26257, Username: "backup", Password: `it's`,
+		Options: map[string]string{"sslrootcert": "/certs/ca.crt"},
+	})
+	want := `host='crdb-1' port=26257 user='backup' dbname='defaultdb' sslmode='require' application_name=db-backup password='it\'s' sslrootcert='/certs/ca.crt'`
+	if got != want {
+		t.Errorf("buildConnectionString() = %s, want %s", got, want)
+	}
+}
+
+// fakeDriver is a database/sql driver answering from fake
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (fakeConn) QueryContext(_ context.Context, stmt string, _ []driver.NamedValue) (driver.Rows, error) {
+	columns, rows, err := fake.query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+func (fakeConn) ExecContext(_ context.Context, stmt string, _ []driver.NamedValue) (driver.Result, error) {
+	_, _, err := fake.query(stmt)
+	return driver.RowsAffected(0), err
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
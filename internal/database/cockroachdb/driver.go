@@ -0,0 +1,778 @@
+// Package cockroachdb provides the CockroachDB database driver. Backups use
+// the cluster's own BACKUP and RESTORE statements instead of pg_dump: every
+// node writes its share of the data straight to the backup collection, so
+// nothing passes through this host. What db-backup stores is a small JSON
+// manifest naming the backup; restores read it and run RESTORE from the
+// same place.
+//
+// Connections use the PostgreSQL wire protocol on port 26257. The
+// collection is set with the backup_uri connection option, any URI
+// CockroachDB can write to (s3://, gs://, azure-blob://, nodelocal://,
+// userfile://, external://) with its credentials in the query string;
+// nodelocal://1/db-backup is used without it. The cluster, each set of
+// databases and each set of tables backed up get a collection of their
+// own under it, and incremental backups are appended to the latest full
+// backup there.
+package cockroachdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// DefaultBackupURI is the collection root used when backup_uri is not set:
+// the external I/O directory of node 1
+const DefaultBackupURI = "nodelocal://1/db-backup"
+
+// sqlDriver is the database/sql driver connections are opened with
+var sqlDriver = "postgres"
+
+// systemDatabases are never listed as user databases
+var systemDatabases = map[string]bool{"system": true}
+
+// versionPattern finds the release in version(), e.g. "CockroachDB CCL
+// v23.2.4 (x86_64-pc-linux-gnu, ...)"
+var versionPattern = regexp.MustCompile(`v(\d+\.\d+\.\d+\S*)`)
+
+// CockroachDBDriver implements the database.Driver interface for CockroachDB
+type CockroachDBDriver struct {
+	db     *sql.DB
+	config *database.ConnectionConfig
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeCockroachDB, func() database.Driver {
+		return NewCockroachDBDriver()
+	})
+}
+
+// NewCockroachDBDriver creates a new CockroachDB driver instance
+func NewCockroachDBDriver() *CockroachDBDriver {
+	return &CockroachDBDriver{}
+}
+
+// Connect establishes a connection to the cluster
+func (d *CockroachDBDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	db, err := sql.Open(sqlDriver, buildConnectionString(config))
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if config.MaxConnections > 0 {
+		db.SetMaxOpenConns(config.MaxConnections)
+	} else {
+		db.SetMaxOpenConns(4)
+	}
+	db.SetConnMaxLifetime(time.Hour)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.db = db
+	d.config = config
+	return nil
+}
+
+// Disconnect closes the connection
+func (d *CockroachDBDriver) Disconnect() error {
+	if d.db != nil {
+		return d.db.Close()
+	}
+	return nil
+}
+
+// Ping tests the connection
+func (d *CockroachDBDriver) Ping(ctx context.Context) error {
+	if d.db == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	return d.db.PingContext(ctx)
+}
+
+// Backup runs a native backup and writes its manifest to opts.OutputPath.
+// opts.Incremental appends it to the latest full backup of the same
+// targets, or takes a full backup when there is none yet.
+func (d *CockroachDBDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	m, err := d.backup(ctx, opts)
+	if err != nil {
+		return fail(err)
+	}
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	err = writeManifest(output, m)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, t := range m.Contents {
+		result.Tables = append(result.Tables, database.TableInfo{Name: t.Name, RowCount: t.Rows, DataSize: t.Size})
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = m.Version
+	result.Size = m.Size
+	result.Checksum = output.Sum()
+	metadata := map[string]string{
+		"cockroachdb_backup_type": m.Type,
+		"cockroachdb_location":    m.Location,
+		"cockroachdb_subdir":      m.Subdir,
+		"cockroachdb_as_of":       m.AsOf,
+	}
+	for k, v := range result.Metadata {
+		metadata[k] = v
+	}
+	result.Metadata = metadata
+	result.Status = database.BackupStatusSuccess
+
+	return result, nil
+}
+
+// StreamBackup runs a native backup and writes its manifest to writer
+func (d *CockroachDBDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	m, err := d.backup(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return writeManifest(writer, m)
+}
+
+// GetBackupSize returns the size of the ranges backed up. Ranges may hold
+// several tables, so the size of tables is approximate.
+func (d *CockroachDBDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	m, err := d.spec(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	if m.Cluster {
+		return d.rangeSize(ctx, "SHOW CLUSTER RANGES WITH DETAILS")
+	}
+	var size int64
+	for _, t := range m.Tables {
+		n, err := d.rangeSize(ctx, "SHOW RANGES FROM TABLE "+qualifiedIdent(t)+" WITH DETAILS")
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	for _, db := range m.Databases {
+		n, err := d.rangeSize(ctx, "SHOW RANGES FROM DATABASE "+ident(db)+" WITH DETAILS")
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// Restore restores the backup whose manifest is opts.SourceBackup
+func (d *CockroachDBDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+
+	file, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+	defer file.Close()
+
+	restored, err := d.restore(ctx, opts, file)
+	if err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err)
+	}
+
+	for _, t := range restored {
+		result.RestoredTables = append(result.RestoredTables, t.Name)
+		result.RowsRestored += t.Rows
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+
+	return result, nil
+}
+
+// StreamRestore restores the backup whose manifest is read from reader
+func (d *CockroachDBDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	_, err := d.restore(ctx, opts, reader)
+	return err
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *CockroachDBDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	file, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer file.Close()
+	m, err := readManifest(file)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if _, err := d.collection(m); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if opts.PointInTime != nil {
+		return pkgErrors.ErrValidationFailed("point-in-time restores are not supported, restore the backup taken at the time instead")
+	}
+	if err := d.Ping(ctx); err != nil {
+		return pkgErrors.ErrValidationFailed("database connection failed")
+	}
+	return nil
+}
+
+// GetDatabases returns the user databases
+func (d *CockroachDBDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	rows, err := d.query(ctx, "SELECT database_name FROM [SHOW DATABASES] ORDER BY database_name")
+	if err != nil {
+		return nil, err
+	}
+	var databases []string
+	for _, row := range rows {
+		if name := str(row["database_name"]); !systemDatabases[name] {
+			databases = append(databases, name)
+		}
+	}
+	return databases, nil
+}
+
+// GetTables returns the tables of a database, qualified with their schema
+// outside the public one
+func (d *CockroachDBDriver) GetTables(ctx context.Context, db string) ([]string, error) {
+	rows, err := d.query(ctx, "SELECT schema_name, table_name FROM [SHOW TABLES FROM "+ident(db)+"] WHERE type = 'table' ORDER BY schema_name, table_name")
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(rows))
+	for _, row := range rows {
+		name := str(row["table_name"])
+		if schema := str(row["schema_name"]); schema != "public" {
+			name = schema + "." + name
+		}
+		tables = append(tables, name)
+	}
+	return tables, nil
+}
+
+// GetTableSize returns the approximate size of a table's ranges
+func (d *CockroachDBDriver) GetTableSize(ctx context.Context, db, table string) (int64, error) {
+	return d.rangeSize(ctx, "SHOW RANGES FROM TABLE "+qualifiedIdent(db+"."+table)+" WITH DETAILS")
+}
+
+// GetVersion returns the CockroachDB release, e.g. 23.2.4
+func (d *CockroachDBDriver) GetVersion(ctx context.Context) (string, error) {
+	var version string
+	if err := d.db.QueryRowContext(ctx, "SELECT version()").Scan(&version); err != nil {
+		return "", err
+	}
+	if m := versionPattern.FindStringSubmatch(version); m != nil {
+		return m[1], nil
+	}
+	return version, nil
+}
+
+// GetType returns the database type
+func (d *CockroachDBDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeCockroachDB
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *CockroachDBDriver) SupportsIncremental() bool {
+	return true
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *CockroachDBDriver) SupportsPITR() bool {
+	return false
+}
+
+// backup runs BACKUP of what opts name and returns its manifest
+func (d *CockroachDBDriver) backup(ctx context.Context, opts *database.BackupOptions) (*Manifest, error) {
+	m, err := d.spec(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.Collection = m.collection()
+	uri, err := d.collection(m)
+	if err != nil {
+		return nil, err
+	}
+	m.Version, _ = d.GetVersion(ctx)
+	m.CreatedAt = time.Now().UTC()
+
+	// The timestamp the backup is taken at names it in its chain
+	if err := d.db.QueryRowContext(ctx, "SELECT cluster_logical_timestamp()::STRING").Scan(&m.AsOf); err != nil {
+		return nil, err
+	}
+
+	m.Type = TypeFull
+	into := "INTO " + literal(uri)
+	if opts.Incremental {
+		// Without a full backup of the same targets yet, this one is full
+		if subdir, err := d.latest(ctx, uri); err == nil && subdir != "" {
+			m.Type, m.Subdir = TypeIncremental, subdir
+			into = "INTO " + literal(subdir) + " IN " + literal(uri)
+		}
+	}
+	stmt := strings.TrimSpace("BACKUP "+m.targets()) + " " + into + " AS OF SYSTEM TIME " + literal(m.AsOf)
+	rows, err := d.query(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 {
+		m.JobID = str(rows[0]["job_id"])
+		m.Size = integer(rows[0]["bytes"])
+	}
+
+	if m.Subdir == "" {
+		if m.Subdir, err = d.latest(ctx, uri); err != nil {
+			return nil, err
+		}
+		if m.Subdir == "" {
+			return nil, errors.New("the backup is missing from its collection")
+		}
+	}
+	m.Contents, _ = d.contents(ctx, uri, m)
+	return m, nil
+}
+
+// restore runs RESTORE of the manifest read from r, into opts.Target()
+// when set, and returns the tables restored
+func (d *CockroachDBDriver) restore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) ([]Table, error) {
+	m, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PointInTime != nil {
+		return nil, errors.New("point-in-time restores are not supported, restore the backup taken at the time instead")
+	}
+	uri, err := d.collection(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// The whole backup, or only the database or tables asked for
+	source := ""
+	if len(m.Databases) == 1 {
+		source = m.Databases[0]
+	} else if m.Cluster && opts.Database != "" {
+		source = opts.Database
+	} else if len(m.Tables) > 0 {
+		source = commonDatabase(m.Tables)
+	}
+	restored := &Manifest{Cluster: m.Cluster, Databases: m.Databases, Tables: m.Tables}
+	if len(opts.Tables) > 0 {
+		if restored.Tables, err = qualify(source, opts.Tables); err != nil {
+			return nil, err
+		}
+		restored.Databases, restored.Cluster = nil, false
+	} else if m.Cluster && opts.Database != "" {
+		restored.Databases, restored.Cluster = []string{opts.Database}, false
+	}
+	if len(opts.ExcludeTables) > 0 {
+		if len(restored.Tables) == 0 {
+			return nil, errors.New("tables cannot be excluded from whole databases, list the tables to restore instead")
+		}
+		exclude, err := qualify(source, opts.ExcludeTables)
+		if err != nil {
+			return nil, err
+		}
+		restored.Tables = without(restored.Tables, exclude)
+		if len(restored.Tables) == 0 {
+			return nil, errors.New("every table of the backup is excluded")
+		}
+	}
+
+	// Restored under another database name
+	var with []string
+	target := ""
+	if name := opts.Target(); name != "" && name != source {
+		if source == "" {
+			return nil, errors.New("the backup holds several databases and cannot be restored under one name")
+		}
+		target = name
+		if len(restored.Tables) > 0 {
+			with = append(with, "into_db = "+literal(target))
+		} else {
+			with = append(with, "new_db_name = "+literal(target))
+		}
+	}
+
+	// RESTORE refuses objects that exist, so replacing them means
+	// dropping them first. A cluster restore needs an empty cluster.
+	if opts.DropExisting && !restored.Cluster {
+		for _, stmt := range drops(restored, source, target) {
+			if _, err := d.db.ExecContext(ctx, stmt); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	stmt := strings.TrimSpace("RESTORE "+restored.targets()) + " FROM " + literal(m.Subdir) + " IN " + literal(uri) +
+		" AS OF SYSTEM TIME " + literal(m.AsOf)
+	if len(with) > 0 {
+		stmt += " WITH " + strings.Join(with, ", ")
+	}
+	if _, err := d.db.ExecContext(ctx, stmt); err != nil {
+		return nil, err
+	}
+	return restoredContents(m, restored, source, target), nil
+}
+
+// spec returns a manifest of what opts back up. Tables are qualified with
+// their database; excluded tables turn a database backup into a backup of
+// its other tables.
+func (d *CockroachDBDriver) spec(ctx context.Context, opts *database.BackupOptions) (*Manifest, error) {
+	m := &Manifest{Format: ManifestFormat}
+	switch {
+	case opts.AllDatabases:
+		m.Cluster = true
+	case len(opts.Databases) > 0:
+		m.Databases = opts.Databases
+	case opts.Database != "":
+		m.Databases = []string{opts.Database}
+	default:
+		return nil, errors.New("no database to back up")
+	}
+	source := ""
+	if len(m.Databases) == 1 {
+		source = m.Databases[0]
+	}
+	var err error
+	if m.Tables, err = qualify(source, opts.Tables); err != nil {
+		return nil, err
+	}
+	if len(opts.ExcludeTables) == 0 {
+		if len(m.Tables) > 0 {
+			m.Databases, m.Cluster = nil, false
+		}
+		return m, nil
+	}
+	if m.Cluster {
+		return nil, errors.New("tables cannot be excluded from a cluster backup")
+	}
+	exclude, err := qualify(source, opts.ExcludeTables)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Tables) == 0 {
+		for _, db := range m.Databases {
+			tables, err := d.GetTables(ctx, db)
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range tables {
+				m.Tables = append(m.Tables, db+"."+t)
+			}
+		}
+	}
+	m.Tables = without(m.Tables, exclude)
+	if len(m.Tables) == 0 {
+		return nil, errors.New("every table is excluded")
+	}
+	m.Databases = nil
+	return m, nil
+}
+
+// collection returns the URI of m's collection under backup_uri, checking
+// it is the one the backup was taken to
+func (d *CockroachDBDriver) collection(m *Manifest) (string, error) {
+	base := d.config.Options["backup_uri"]
+	if base == "" {
+		base = DefaultBackupURI
+	}
+	uri, location, err := collectionURI(base, m.Collection)
+	if err != nil {
+		return "", err
+	}
+	if m.Location == "" {
+		m.Location = location
+	} else if m.Location != location {
+		return "", fmt.Errorf("the backup is in %s, but backup_uri points at %s", m.Location, redactURI(base))
+	}
+	return uri, nil
+}
+
+// latest returns the latest full backup in a collection, "" when there is
+// none
+func (d *CockroachDBDriver) latest(ctx context.Context, uri string) (string, error) {
+	rows, err := d.query(ctx, "SHOW BACKUPS IN "+literal(uri))
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	for _, row := range rows {
+		// Named after when they were taken, e.g. /2024/01/02-030405.00
+		if path := str(row["path"]); path > latest {
+			latest = path
+		}
+	}
+	return latest, nil
+}
+
+// contents returns the tables of the backup m describes, as of m.AsOf
+func (d *CockroachDBDriver) contents(ctx context.Context, uri string, m *Manifest) ([]Table, error) {
+	rows, err := d.query(ctx, "SELECT database_name, parent_schema_name, object_name, end_time, rows, size_bytes FROM [SHOW BACKUP FROM "+
+		literal(m.Subdir)+" IN "+literal(uri)+"] WHERE object_type = 'table'")
+	if err != nil {
+		return nil, err
+	}
+	// Each backup of the chain lists its tables; this one is the last
+	var last time.Time
+	for _, row := range rows {
+		if end := timestamp(row["end_time"]); end.After(last) {
+			last = end
+		}
+	}
+	var tables []Table
+	for _, row := range rows {
+		if !timestamp(row["end_time"]).Equal(last) {
+			continue
+		}
+		name := str(row["database_name"]) + "."
+		if schema := str(row["parent_schema_name"]); schema != "public" {
+			name += schema + "."
+		}
+		tables = append(tables, Table{
+			Name: name + str(row["object_name"]),
+			Rows: integer(row["rows"]),
+			Size: integer(row["size_bytes"]),
+		})
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables, nil
+}
+
+// rangeSize sums the range_size column of a SHOW RANGES statement
+func (d *CockroachDBDriver) rangeSize(ctx context.Context, show string) (int64, error) {
+	var size int64
+	err := d.db.QueryRowContext(ctx, "SELECT COALESCE(sum(range_size), 0)::INT8 FROM ["+show+"]").Scan(&size)
+	return size, err
+}
+
+// query runs a statement and returns its rows by column name
+func (d *CockroachDBDriver) query(ctx context.Context, stmt string) ([]map[string]any, error) {
+	rows, err := d.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]any
+	for rows.Next() {
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, col := range columns {
+			row[col] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// drops returns the statements dropping what a restore of m recreates
+// under target, or under their own names
+func drops(m *Manifest, source, target string) []string {
+	var stmts []string
+	if len(m.Tables) > 0 {
+		for _, t := range m.Tables {
+			if target != "" {
+				t = target + strings.TrimPrefix(t, source)
+			}
+			stmts = append(stmts, "DROP TABLE IF EXISTS "+qualifiedIdent(t))
+		}
+		return stmts
+	}
+	for _, db := range m.Databases {
+		if target != "" {
+			db = target
+		}
+		stmts = append(stmts, "DROP DATABASE IF EXISTS "+ident(db)+" CASCADE")
+	}
+	return stmts
+}
+
+// restoredContents returns the tables of m a restore of restored brings
+// back, under target when set
+func restoredContents(m, restored *Manifest, source, target string) []Table {
+	only := map[string]bool{}
+	for _, t := range restored.Tables {
+		only[t] = true
+	}
+	dbs := map[string]bool{}
+	for _, db := range restored.Databases {
+		dbs[db] = true
+	}
+	var tables []Table
+	for _, t := range m.Contents {
+		db, _, _ := strings.Cut(t.Name, ".")
+		switch {
+		case len(only) > 0 && !only[t.Name]:
+			continue
+		case len(only) == 0 && len(dbs) > 0 && !dbs[db]:
+			continue
+		}
+		if target != "" {
+			t.Name = target + strings.TrimPrefix(t.Name, source)
+		}
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+// commonDatabase returns the database of qualified tables when they are all
+// in one, "" otherwise
+func commonDatabase(tables []string) string {
+	common := ""
+	for _, t := range tables {
+		db, _, _ := strings.Cut(t, ".")
+		if common != "" && db != common {
+			return ""
+		}
+		common = db
+	}
+	return common
+}
+
+// without returns the tables not in exclude
+func without(tables, exclude []string) []string {
+	excluded := map[string]bool{}
+	for _, t := range exclude {
+		excluded[t] = true
+	}
+	var kept []string
+	for _, t := range tables {
+		if !excluded[t] {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// buildConnectionString builds a lib/pq connection string. CockroachDB
+// only accepts passwords over TLS, so the SSL mode defaults to require;
+// set it to disable for clusters started with --insecure.
+func buildConnectionString(config *database.ConnectionConfig) string {
+	if config.ConnectionString != "" {
+		return config.ConnectionString
+	}
+	sslMode := config.SSLMode
+	if sslMode == "" {
+		sslMode = "require"
+	}
+	dbName := config.Database
+	if dbName == "" {
+		dbName = "defaultdb"
+	}
+	params := []string{
+		"host=" + quoteParam(config.Host),
+		"port=" + strconv.Itoa(config.Port),
+		"user=" + quoteParam(config.Username),
+		"dbname=" + quoteParam(dbName),
+		"sslmode=" + quoteParam(sslMode),
+		"application_name=db-backup",
+	}
+	if config.Password != "" {
+		params = append(params, "password="+quoteParam(config.Password))
+	}
+	if config.ConnectionTimeout > 0 {
+		params = append(params, fmt.Sprintf("connect_timeout=%d", int(config.ConnectionTimeout.Seconds())))
+	}
+	for _, key := range []string{"sslrootcert", "sslcert", "sslkey"} {
+		if v := config.Options[key]; v != "" {
+			params = append(params, key+"="+quoteParam(v))
+		}
+	}
+	return strings.Join(params, " ")
+}
+
+// quoteParam quotes a connection string value
+func quoteParam(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// str converts a column value to a string
+func str(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// timestamp converts a column value to a time
+func timestamp(v any) time.Time {
+	if t, ok := v.(time.Time); ok {
+		return t
+	}
+	t, _ := time.Parse("2006-01-02 15:04:05.999999999", str(v))
+	return t
+}
+
+// integer converts a column value to an integer
+func integer(v any) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	n, _ := strconv.ParseInt(str(v), 10, 64)
+	return n
+}
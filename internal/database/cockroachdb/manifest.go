@@ -0,0 +1,178 @@
+package cockroachdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestFormat identifies the manifests written by this driver
+const ManifestFormat = "cockroachdb-native-backup/v1"
+
+// Backup types recorded in manifests
+const (
+	TypeFull        = "full"
+	TypeIncremental = "incremental"
+)
+
+// Manifest describes a native backup. It is the artifact db-backup stores.
+type Manifest struct {
+	Format string `json:"format"`
+	// Location is the collection the backup is in, without credentials
+	Location string `json:"location"`
+	// Collection is the path of that collection under backup_uri
+	Collection string `json:"collection"`
+	// Subdir is the full backup of the chain, which incremental backups
+	// are appended to
+	Subdir string `json:"subdir"`
+	// AsOf is the cluster timestamp the backup was taken at. Restores stop
+	// at it, so an incremental backup is restored without the ones taken
+	// after it.
+	AsOf      string    `json:"as_of"`
+	Type      string    `json:"type"`
+	Cluster   bool      `json:"cluster,omitempty"`
+	Databases []string  `json:"databases,omitempty"`
+	Tables    []string  `json:"tables,omitempty"` // database.table or database.schema.table
+	Contents  []Table   `json:"contents,omitempty"`
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+	JobID     string    `json:"job_id,omitempty"`
+}
+
+// Table is a table in a backup, as of the backup
+type Table struct {
+	Name string `json:"name"` // database.table or database.schema.table
+	Rows int64  `json:"rows"`
+	Size int64  `json:"size"`
+}
+
+// targets returns the targets of BACKUP, or RESTORE of the whole backup
+func (m *Manifest) targets() string {
+	switch {
+	case len(m.Tables) > 0:
+		return "TABLE " + names(m.Tables)
+	case len(m.Databases) > 0:
+		return "DATABASE " + names(m.Databases)
+	}
+	// The whole cluster
+	return ""
+}
+
+// collection returns the path of the collection holding the backups of
+// m's targets. Incremental backups are only appended to a full backup of
+// the same targets, so each set of targets has a collection of its own.
+func (m *Manifest) collection() string {
+	switch {
+	case len(m.Tables) > 0:
+		tables := append([]string(nil), m.Tables...)
+		sort.Strings(tables)
+		sum := sha256.Sum256([]byte(strings.Join(tables, "\n")))
+		return "tables/" + hex.EncodeToString(sum[:8])
+	case len(m.Databases) > 0:
+		databases := make([]string, len(m.Databases))
+		for i, db := range m.Databases {
+			databases[i] = url.PathEscape(db)
+		}
+		sort.Strings(databases)
+		return "databases/" + strings.Join(databases, "+")
+	}
+	return "cluster"
+}
+
+// collectionURI returns the URI of collection under base, and the same
+// without credentials
+func collectionURI(base, collection string) (uri, location string, err error) {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" {
+		return "", "", fmt.Errorf("backup_uri %q is not a URI", redactURI(base))
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + collection
+	u.RawPath = ""
+	uri = u.String()
+	u.User = nil
+	u.RawQuery = ""
+	return uri, u.String(), nil
+}
+
+// redactURI drops the query, which holds the credentials of cloud
+// storage, and any password from a URI
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "<invalid URI>"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
+
+// qualify prefixes table names without a database with db, the only
+// database of a backup, or "" when there are several
+func qualify(db string, tables []string) ([]string, error) {
+	qualified := make([]string, 0, len(tables))
+	for _, t := range tables {
+		if !strings.Contains(t, ".") {
+			if db == "" {
+				return nil, fmt.Errorf("table %s: name tables database.table when there are several databases", t)
+			}
+			t = db + "." + t
+		}
+		qualified = append(qualified, t)
+	}
+	return qualified, nil
+}
+
+// names returns a list of quoted, possibly qualified, names
+func names(list []string) string {
+	quoted := make([]string, len(list))
+	for i, name := range list {
+		quoted[i] = qualifiedIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// qualifiedIdent quotes each part of a dotted name
+func qualifiedIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = ident(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// ident quotes an identifier
+func ident(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// literal quotes a string constant
+func literal(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// writeManifest writes m as indented JSON
+func writeManifest(w io.Writer, m *Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// readManifest reads a manifest, refusing other artifacts
+func readManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(r, 1<<20)).Decode(&m); err != nil || m.Format != ManifestFormat {
+		return nil, errors.New("not a CockroachDB backup manifest")
+	}
+	if m.Collection == "" || m.Subdir == "" || m.AsOf == "" {
+		return nil, errors.New("the manifest names no backup location")
+	}
+	return &m, nil
+}
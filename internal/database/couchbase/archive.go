@@ -0,0 +1,158 @@
+package couchbase
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// ManifestFormat identifies the archives written by this driver
+const ManifestFormat = "couchbase-cbbackupmgr/v1"
+
+// Kinds of backup
+const (
+	KindFull        = "full"
+	KindIncremental = "incremental"
+)
+
+const archiveDir = "archive/"
+
+// Manifest describes an archive. It is its first entry, so restores know
+// what they are restoring before any data.
+type Manifest struct {
+	Format     string    `json:"format"`
+	Kind       string    `json:"kind"`
+	Archive    string    `json:"archive"`           // cbbackupmgr archive directory
+	Repository string    `json:"repository"`        // repository in the archive
+	Backup     string    `json:"backup"`            // backup in the repository
+	Chain      []string  `json:"chain,omitempty"`   // backups an incremental builds on, its full backup first
+	Buckets    []string  `json:"buckets,omitempty"` // empty for every bucket
+	Version    string    `json:"version,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// writeArchive writes the manifest, then the files of the manifest's
+// backup under archive, to w: the archive's and the repository's own
+// files, then the backup's directory
+func writeArchive(w io.Writer, m *Manifest, archive string) error {
+	tw := tar.NewWriter(w)
+	if err := database.WriteManifest(tw, m); err != nil {
+		return err
+	}
+
+	for _, dir := range []string{archive, repoDir(archive, m.Repository)} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				if err := writeFile(tw, archive, filepath.Join(dir, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	err := filepath.WalkDir(filepath.Join(repoDir(archive, m.Repository), m.Backup), func(file string, e fs.DirEntry, err error) error {
+		if err != nil || !e.Type().IsRegular() {
+			return err
+		}
+		return writeFile(tw, archive, file)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeFile writes file, under archive, as its entry
+func writeFile(tw *tar.Writer, archive, file string) error {
+	rel, err := filepath.Rel(archive, file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file) // #nosec G304 -- file of the backup just taken
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(header(archiveDir+filepath.ToSlash(rel), info.Size())); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func header(name string, size int64) *tar.Header {
+	return &tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+}
+
+// validate checks the kind of the archive m describes and the names of
+// its backups, which become paths of the restore
+func (m *Manifest) validate() error {
+	if m.Kind != KindFull && m.Kind != KindIncremental {
+		return fmt.Errorf("unsupported backup kind %q", m.Kind)
+	}
+	if !validName(m.Repository) || !validName(m.Backup) {
+		return fmt.Errorf("invalid backup %s/%s", m.Repository, m.Backup)
+	}
+	for _, b := range m.Chain {
+		if !validName(b) {
+			return fmt.Errorf("invalid backup %s/%s", m.Repository, b)
+		}
+	}
+	return nil
+}
+
+// extractArchive writes the files read from tr under dir, rebuilding the
+// cbbackupmgr archive there
+func extractArchive(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, ok := strings.CutPrefix(hdr.Name, archiveDir)
+		if !ok || rel == "" || !fs.ValidPath(rel) || path.Clean(rel) != rel {
+			return fmt.Errorf("unexpected archive entry %s", hdr.Name)
+		}
+		file := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return err
+		}
+		if err := database.ExtractFile(file, tr, 0o600); err != nil {
+			return err
+		}
+	}
+}
+
+// repoDir returns the directory of a repository in an archive
+func repoDir(archive, repo string) string {
+	return filepath.Join(archive, repo)
+}
+
+// validName reports whether name can name a repository or a backup, both
+// directories of the archive
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,102 @@
+package couchbase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+)
+
+// repository is what cbbackupmgr info says of a repository
+type repository struct {
+	Name    string         `json:"name"`
+	Backups []backupRecord `json:"backups"` // oldest first
+}
+
+// backupRecord is a backup of a repository, named by its date
+type backupRecord struct {
+	Date     string `json:"date"`
+	Type     string `json:"type"` // FULL, INCR or MERGE - FULL
+	Complete bool   `json:"complete"`
+	Buckets  []struct {
+		Name  string `json:"name"`
+		Size  int64  `json:"size"`
+		Items int64  `json:"items"`
+	} `json:"buckets"`
+}
+
+// full reports whether the backup holds every document rather than the
+// changes since the one before it
+func (b backupRecord) full() bool {
+	return strings.Contains(b.Type, "FULL")
+}
+
+// cbbackupmgr returns the path of cbbackupmgr
+func cbbackupmgr() (string, error) {
+	path, err := exec.LookPath("cbbackupmgr")
+	if err != nil {
+		return "", errors.New("cbbackupmgr is not installed")
+	}
+	return path, nil
+}
+
+// run runs a cbbackupmgr subcommand and returns its output. The
+// credentials go through the environment rather than the command line.
+func (d *CouchbaseDriver) run(ctx context.Context, args ...string) ([]byte, error) {
+	tool, err := cbbackupmgr()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Env = append(os.Environ(), "CB_USERNAME="+d.config.Username, "CB_PASSWORD="+d.config.Password)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	run := telemetry.StartCommand(ctx, cmd)
+	output, err := cmd.Output()
+	run.End(err, -1)
+	if err != nil {
+		detail := strings.TrimSpace(stderr.String())
+		if detail == "" {
+			detail = strings.TrimSpace(string(output))
+		}
+		return nil, fmt.Errorf("cbbackupmgr %s failed: %w: %s", args[0], err, detail)
+	}
+	return output, nil
+}
+
+// clusterArgs returns the arguments pointing cbbackupmgr at the cluster
+func (d *CouchbaseDriver) clusterArgs() []string {
+	args := []string{"--cluster", d.client.base}
+	switch d.config.TLSMode() {
+	case database.SSLDisable:
+	case database.SSLRequire:
+		args = append(args, "--no-ssl-verify")
+	default:
+		if d.config.SSLCA != "" {
+			args = append(args, "--cacert", d.config.SSLCA)
+		}
+	}
+	return args
+}
+
+// info returns the backups of a repository, nil when it does not exist
+func (d *CouchbaseDriver) info(ctx context.Context, archive, repo string) (*repository, error) {
+	if _, err := os.Stat(repoDir(archive, repo)); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	output, err := d.run(ctx, "info", "--archive", archive, "--repo", repo, "--json")
+	if err != nil {
+		return nil, err
+	}
+	var r repository
+	if err := json.Unmarshal(output, &r); err != nil {
+		return nil, fmt.Errorf("invalid cbbackupmgr info output: %w", err)
+	}
+	return &r, nil
+}
@@ -0,0 +1,310 @@
+package couchbase
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// fakeCbbackupmgr installs a cbbackupmgr that keeps repositories as
+// directories, one per backup holding its type, and logs every run
+func fakeCbbackupmgr(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake cbbackupmgr is a shell script")
+	}
+	bin := t.TempDir()
+	log := filepath.Join(t.TempDir(), "cbbackupmgr.log")
+	script := `#!/bin/sh
+echo "$CB_USERNAME $*" >> "$CB_LOG"
+cmd=$1; shift
+while [ $# -gt 0 ]; do
+  case $1 in
+    --archive) a=$2; shift ;;
+    --repo) r=$2; shift ;;
+    --backups) range=$2; shift ;;
+    --start) start=$2; shift ;;
+    --end) end=$2; shift ;;
+    --full-backup) full=1 ;;
+  esac
+  shift
+done
+repo="$a/$r"
+case $cmd in
+  config)
+    mkdir -p "$repo" && echo archive > "$a/.backup" && echo repo > "$repo/backup-meta.json" ;;
+  backup)
+    seq=$(cat "$repo/.seq" 2>/dev/null || echo 0)
+    echo $((seq + 1)) > "$repo/.seq"
+    name=$(printf '2024-01-01T00_00_%02dZ' "$seq")
+    type=INCR
+    if [ -n "$full" ] || [ -z "$(ls "$repo" | grep -v meta)" ]; then type=FULL; fi
+    mkdir "$repo/$name" && echo $type > "$repo/$name/type" && echo data > "$repo/$name/shard.sst" ;;
+  info)
+    printf '{"name":"%s","backups":[' "$r"
+    sep=
+    for d in $(ls "$repo" | grep -v meta); do
+      printf '%s{"date":"%s","type":"%s","complete":true,"buckets":[{"name":"travel","size":10,"items":2}]}' "$sep" "$d" "$(cat "$repo/$d/type")"
+      sep=,
+    done
+    echo ']}' ;;
+  remove)
+    from=${range%,*}; to=${range#*,}
+    for d in $(ls "$repo" | grep -v meta); do
+      if ! expr "$d" \< "$from" > /dev/null && ! expr "$d" \> "$to" > /dev/null; then rm -r "$repo/$d"; fi
+    done ;;
+  restore)
+    for d in $(ls "$repo" | grep -v meta); do
+      if ! expr "$d" \< "$start" > /dev/null && ! expr "$d" \> "$end" > /dev/null; then
+        echo "restored $d $(cat "$repo/$d/type")" >> "$CB_LOG" || exit 1
+      fi
+    done ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "cbbackupmgr"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("CB_LOG", log)
+	return log
+}
+
+// fakeCluster serves the REST API of a cluster with a travel, a beer and
+// a memcached bucket
+func fakeCluster(t *testing.T) *url.URL {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pools", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"implementationVersion":"7.2.4-7070-enterprise"}`)
+	})
+	mux.HandleFunc("/pools/default/buckets", func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "backup" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `[{"name":"travel","bucketType":"membase","basicStats":{"diskUsed":100,"itemCount":5}},
+			{"name":"sessions","bucketType":"memcached","basicStats":{}},
+			{"name":"beer","bucketType":"membase","basicStats":{"diskUsed":50,"itemCount":3}}]`)
+	})
+	mux.HandleFunc("/pools/default/buckets/travel/scopes", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"scopes":[{"name":"_default","collections":[{"name":"_default"}]},
+			{"name":"inventory","collections":[{"name":"airline"},{"name":"hotel"}]}]}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+	return u
+}
+
+func connect(t *testing.T, options map[string]string) (*CouchbaseDriver, string) {
+	u := fakeCluster(t)
+	port, _ := strconv.Atoi(u.Port())
+	archive := filepath.Join(t.TempDir(), "archive")
+	all := map[string]string{"archive": archive}
+	for k, v := range options {
+		all[k] = v
+	}
+	d := NewCouchbaseDriver()
+	err := d.Connect(context.Background(), &database.ConnectionConfig{
+		Host: u.Hostname(), Port: port, Username: "backup", Password: "secret", Options: all,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, archive
+}
+
+// entries returns the names of the entries of the archive at path
+func entries(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			sort.Strings(names)
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func readLog(t *testing.T, log string) string {
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(log)
+	return string(data)
+}
+
+func TestIncrementalBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	log := fakeCbbackupmgr(t)
+	d, archive := connect(t, nil)
+	dir := t.TempDir()
+
+	full := filepath.Join(dir, "full.tar")
+	result, err := d.Backup(ctx, &database.BackupOptions{Database: "travel", OutputPath: full, Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Metadata["couchbase_backup_kind"] != KindFull || result.Metadata["couchbase_repository"] != "db-backup-travel" ||
+		result.DatabaseVersion != "7.2.4-7070-enterprise" || result.Tables[0].RowCount != 2 {
+		t.Errorf("got %+v", result)
+	}
+	calls := readLog(t, log)
+	if !strings.Contains(calls, "backup config --archive "+archive+" --repo db-backup-travel --include-data travel") {
+		t.Errorf("repository not created for the bucket:\n%s", calls)
+	}
+
+	incr := filepath.Join(dir, "incr.tar")
+	result, err = d.Backup(ctx, &database.BackupOptions{Database: "travel", OutputPath: incr, Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Metadata["couchbase_backup_kind"] != KindIncremental || strings.Contains(readLog(t, log), "--full-backup") {
+		t.Errorf("got %+v", result.Metadata)
+	}
+	// Only the new backup is archived
+	want := []string{
+		"archive/.backup",
+		"archive/db-backup-travel/.seq",
+		"archive/db-backup-travel/2024-01-01T00_00_01Z/shard.sst",
+		"archive/db-backup-travel/2024-01-01T00_00_01Z/type",
+		"archive/db-backup-travel/backup-meta.json",
+		"manifest.json",
+	}
+	if got := entries(t, incr); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("archive holds %v", got)
+	}
+
+	// Under a new name, replaying the full backup first
+	restore, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: incr, Database: "travel_copy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls = readLog(t, log)
+	for _, want := range []string{
+		"--start 2024-01-01T00_00_00Z --end 2024-01-01T00_00_01Z",
+		"--include-data travel --map-data travel=travel_copy",
+		"restored 2024-01-01T00_00_00Z FULL\nrestored 2024-01-01T00_00_01Z INCR",
+	} {
+		if !strings.Contains(calls, want) {
+			t.Errorf("restore did not run with %q:\n%s", want, calls)
+		}
+	}
+	if strings.Join(restore.RestoredTables, ",") != "travel_copy" {
+		t.Errorf("restored %v", restore.RestoredTables)
+	}
+
+	// Over the bucket, only overwriting its documents when asked to
+	opts := &database.RestoreOptions{SourceBackup: full, Database: "travel"}
+	if err := d.ValidateRestore(ctx, opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("got %v", err)
+	}
+	opts.DropExisting = true
+	if _, err := d.Restore(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if calls := readLog(t, log); !strings.Contains(calls, "--force-updates") {
+		t.Errorf("restore did not overwrite:\n%s", calls)
+	}
+
+	// Not once the full backup is gone from the archive
+	if err := os.RemoveAll(filepath.Join(archive, "db-backup-travel", "2024-01-01T00_00_00Z")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: incr, Database: "travel_copy"}); err == nil ||
+		!strings.Contains(err.Error(), "no longer holds") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestKeepFullBackups(t *testing.T) {
+	ctx := context.Background()
+	fakeCbbackupmgr(t)
+	d, archive := connect(t, map[string]string{"keep_full_backups": "2"})
+	dir := t.TempDir()
+
+	for i, incremental := range []bool{false, true, false, false} {
+		out := filepath.Join(dir, fmt.Sprintf("%d.tar", i))
+		if _, err := d.Backup(ctx, &database.BackupOptions{AllDatabases: true, OutputPath: out, Incremental: incremental}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first full backup and its incremental made room for the third
+	// full one
+	backups, _ := filepath.Glob(filepath.Join(archive, "db-backup", "2024-*"))
+	for i := range backups {
+		backups[i] = filepath.Base(backups[i])
+	}
+	if strings.Join(backups, ",") != "2024-01-01T00_00_02Z,2024-01-01T00_00_03Z" {
+		t.Errorf("repository holds %v", backups)
+	}
+}
+
+func TestCollections(t *testing.T) {
+	ctx := context.Background()
+	log := fakeCbbackupmgr(t)
+	d, _ := connect(t, nil)
+
+	out := filepath.Join(t.TempDir(), "travel.tar")
+	if _, err := d.Backup(ctx, &database.BackupOptions{Database: "travel", ExcludeTables: []string{"inventory.hotel"}, OutputPath: out}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := readLog(t, log); !strings.Contains(calls, "--include-data travel._default._default,travel.inventory.airline") {
+		t.Errorf("repository does not exclude the collection:\n%s", calls)
+	}
+	if _, err := d.Backup(ctx, &database.BackupOptions{AllDatabases: true, Tables: []string{"inventory.airline"}, OutputPath: out + ".all"}); err == nil {
+		t.Error("chose collections of every bucket")
+	}
+
+	opts := &database.RestoreOptions{SourceBackup: out, Database: "travel", DropExisting: true, ExcludeTables: []string{"inventory.airline"}}
+	if _, err := d.Restore(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if calls := readLog(t, log); !strings.Contains(calls, "--exclude-data travel.inventory.airline") || strings.Contains(calls, "--include-data") {
+		t.Errorf("restore does not exclude the collection:\n%s", calls)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	fakeCbbackupmgr(t)
+	d, _ := connect(t, nil)
+
+	buckets, err := d.GetDatabases(ctx)
+	if err != nil || strings.Join(buckets, ",") != "beer,travel" {
+		t.Errorf("got %v, %v", buckets, err)
+	}
+	collections, err := d.GetTables(ctx, "travel")
+	if err != nil || strings.Join(collections, ",") != "_default._default,inventory.airline,inventory.hotel" {
+		t.Errorf("got %v, %v", collections, err)
+	}
+	size, err := d.GetBackupSize(ctx, &database.BackupOptions{Databases: []string{"travel"}})
+	if err != nil || size != 100 {
+		t.Errorf("got %d, %v", size, err)
+	}
+	if err := d.Connect(ctx, &database.ConnectionConfig{Host: "localhost", Port: 8091}); err == nil {
+		t.Error("connected without an archive directory")
+	}
+}
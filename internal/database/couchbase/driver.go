@@ -0,0 +1,688 @@
+// Package couchbase provides the Couchbase database driver. Backups are
+// taken with cbbackupmgr into a backup repository of an archive directory
+// on this host, set with the archive option. The archive persists between
+// backups: the first backup of a repository is full, and later ones with
+// --incremental hold only what changed since the one before.
+//
+// Each set of buckets (and collections) backed up gets a repository of its
+// own, created on first use and named after the set, or the repository
+// option. keep_full_backups bounds how many full backups, each with the
+// incrementals built on it, a repository keeps: older ones are removed
+// before a new full backup is taken.
+//
+// A backup is a tar archive: a manifest, then the files cbbackupmgr wrote
+// for that backup. Restores rebuild the repository from it and run
+// cbbackupmgr restore; an incremental also needs the backups it builds on,
+// read from the archive directory. The cluster is reached over its REST
+// API (port 8091) to list buckets and collections.
+package couchbase
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// defaultRepository names the repository of backups of every bucket
+const defaultRepository = "db-backup"
+
+// CouchbaseDriver implements the database.Driver interface for Couchbase
+type CouchbaseDriver struct {
+	client  *client
+	config  *database.ConnectionConfig
+	archive string
+	keep    int // full backups a repository keeps, 0 for all
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeCouchbase, func() database.Driver {
+		return NewCouchbaseDriver()
+	})
+}
+
+// NewCouchbaseDriver creates a new Couchbase driver instance
+func NewCouchbaseDriver() *CouchbaseDriver {
+	return &CouchbaseDriver{}
+}
+
+// Connect checks the cluster answers and cbbackupmgr is installed
+func (d *CouchbaseDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	archive := config.Options["archive"]
+	if archive == "" {
+		return pkgErrors.ErrDatabaseConnection(errors.New("set the archive option to the directory cbbackupmgr keeps its backup repositories in"))
+	}
+	keep := 0
+	if s := config.Options["keep_full_backups"]; s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return pkgErrors.ErrDatabaseConnection(fmt.Errorf("keep_full_backups %q is not a number of backups", s))
+		}
+		keep = n
+	}
+	if _, err := cbbackupmgr(); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	c, err := newClient(config)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if _, err := c.cluster(ctx); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.client, d.config, d.archive, d.keep = c, config, archive, keep
+	return nil
+}
+
+// Disconnect releases idle connections
+func (d *CouchbaseDriver) Disconnect() error {
+	if d.client != nil {
+		d.client.hc.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Ping tests the connection
+func (d *CouchbaseDriver) Ping(ctx context.Context) error {
+	if d.client == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	_, err := d.client.cluster(ctx)
+	return err
+}
+
+// Backup takes a backup into its repository and writes an archive of it
+// to opts.OutputPath. opts.Incremental takes one holding the changes since
+// the repository's latest backup, or a full one when it has none yet.
+func (d *CouchbaseDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	m, record, err := d.backup(ctx, opts, output)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = m.Version
+	result.Size = output.Written()
+	result.Checksum = output.Sum()
+	for _, b := range record.Buckets {
+		result.Tables = append(result.Tables, database.TableInfo{Name: b.Name, RowCount: b.Items, DataSize: b.Size})
+	}
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{
+		"couchbase_backup_kind": m.Kind,
+		"couchbase_repository":  m.Repository,
+		"couchbase_backup":      m.Backup,
+	})
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// StreamBackup takes a backup and streams an archive of it to writer
+func (d *CouchbaseDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	_, _, err := d.backup(ctx, opts, writer)
+	return err
+}
+
+// backupData returns what opts back up, as cbbackupmgr's include data:
+// the buckets named, or every bucket (nil), narrowed to collections
+// within one bucket by opts.Tables and opts.ExcludeTables
+func (d *CouchbaseDriver) backupData(ctx context.Context, opts *database.BackupOptions) ([]string, error) {
+	var buckets []string
+	switch {
+	case opts.AllDatabases:
+	case len(opts.Databases) > 0:
+		buckets = opts.Databases
+	case opts.Database != "":
+		buckets = []string{opts.Database}
+	}
+	if len(opts.Tables) == 0 && len(opts.ExcludeTables) == 0 {
+		return buckets, nil
+	}
+	if len(buckets) != 1 {
+		return nil, errors.New("collections can only be chosen within one bucket")
+	}
+	collections := opts.Tables
+	if len(collections) == 0 {
+		// Repositories cannot both include and exclude data, so exclusions
+		// are applied to the bucket's collections
+		var err error
+		if collections, err = d.client.collections(ctx, buckets[0]); err != nil {
+			return nil, err
+		}
+	}
+	collections = slices.DeleteFunc(slices.Clone(collections), func(c string) bool {
+		return slices.Contains(opts.ExcludeTables, c)
+	})
+	if len(collections) == 0 {
+		return nil, errors.New("every collection is excluded")
+	}
+	return qualify(buckets[0], collections)
+}
+
+// qualify returns the scope.collection names of bucket as
+// bucket.scope.collection
+func qualify(bucket string, collections []string) ([]string, error) {
+	qualified := make([]string, 0, len(collections))
+	for _, c := range collections {
+		if strings.Count(c, ".") != 1 {
+			return nil, fmt.Errorf("collection %q is not scope.collection", c)
+		}
+		qualified = append(qualified, bucket+"."+c)
+	}
+	return qualified, nil
+}
+
+// repositoryName returns the repository option, else the name of the
+// repository backing up data
+func (d *CouchbaseDriver) repositoryName(data []string) string {
+	if name := d.config.Options["repository"]; name != "" {
+		return name
+	}
+	switch {
+	case len(data) == 0:
+		return defaultRepository
+	case len(data) == 1 && validName(data[0]) && !strings.Contains(data[0], "."):
+		return defaultRepository + "-" + data[0]
+	}
+	// Sets of buckets and collections get a stable name of their own
+	sorted := slices.Clone(data)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+	return defaultRepository + "-" + hex.EncodeToString(sum[:6])
+}
+
+// backup takes a backup into the repository of opts, creating it first if
+// needed, and writes the archive of the new backup to w
+func (d *CouchbaseDriver) backup(ctx context.Context, opts *database.BackupOptions, w io.Writer) (*Manifest, *backupRecord, error) {
+	data, err := d.backupData(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	repo := d.repositoryName(data)
+	if !validName(repo) {
+		return nil, nil, fmt.Errorf("invalid repository name %q", repo)
+	}
+	before, err := d.info(ctx, d.archive, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if before == nil {
+		if err := d.createRepository(ctx, repo, data); err != nil {
+			return nil, nil, err
+		}
+		before = &repository{Name: repo}
+	}
+	full := !opts.Incremental || len(before.Backups) == 0
+	if full {
+		if err := d.prune(ctx, repo, before); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	args := append([]string{"backup", "--archive", d.archive, "--repo", repo, "--no-progress-bar"}, d.clusterArgs()...)
+	if full {
+		args = append(args, "--full-backup")
+	}
+	if opts.Parallel > 0 {
+		args = append(args, "--threads", strconv.Itoa(opts.Parallel))
+	}
+	if _, err := d.run(ctx, args...); err != nil {
+		return nil, nil, err
+	}
+
+	after, err := d.info(ctx, d.archive, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if after == nil || len(after.Backups) == 0 {
+		return nil, nil, errors.New("the backup is missing from its repository")
+	}
+	latest := len(after.Backups) - 1
+	record := after.Backups[latest]
+	if !record.Complete {
+		return nil, nil, fmt.Errorf("backup %s of repository %s is incomplete", record.Date, repo)
+	}
+
+	version, _ := d.GetVersion(ctx)
+	m := &Manifest{
+		Format:     ManifestFormat,
+		Kind:       KindFull,
+		Archive:    d.archive,
+		Repository: repo,
+		Backup:     record.Date,
+		Version:    version,
+		CreatedAt:  time.Now().UTC(),
+	}
+	for _, b := range record.Buckets {
+		m.Buckets = append(m.Buckets, b.Name)
+	}
+	if !record.full() {
+		m.Kind, m.Chain = KindIncremental, chain(after.Backups[:latest])
+		if len(m.Chain) == 0 {
+			return nil, nil, fmt.Errorf("incremental backup %s of repository %s has no full backup before it", record.Date, repo)
+		}
+	}
+	if err := writeArchive(w, m, d.archive); err != nil {
+		return nil, nil, err
+	}
+	return m, &record, nil
+}
+
+// chain returns the backups the next one builds on: the latest full
+// backup of backups, and every one after it
+func chain(backups []backupRecord) []string {
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].full() {
+			names := make([]string, 0, len(backups)-i)
+			for _, b := range backups[i:] {
+				names = append(names, b.Date)
+			}
+			return names
+		}
+	}
+	return nil
+}
+
+// createRepository creates the repository backing up data
+func (d *CouchbaseDriver) createRepository(ctx context.Context, repo string, data []string) error {
+	if err := os.MkdirAll(d.archive, 0o700); err != nil {
+		return err
+	}
+	args := []string{"config", "--archive", d.archive, "--repo", repo}
+	if len(data) > 0 {
+		args = append(args, "--include-data", strings.Join(data, ","))
+	}
+	_, err := d.run(ctx, args...)
+	return err
+}
+
+// prune makes room for a new full backup: it removes the oldest backups of
+// the repository so that, with the new one, it keeps keep_full_backups
+// full backups and their incrementals
+func (d *CouchbaseDriver) prune(ctx context.Context, repo string, r *repository) error {
+	if d.keep == 0 {
+		return nil
+	}
+	var fulls []int
+	for i, b := range r.Backups {
+		if b.full() {
+			fulls = append(fulls, i)
+		}
+	}
+	if len(fulls) < d.keep {
+		return nil
+	}
+	// Everything before the oldest full backup kept
+	end := len(r.Backups) - 1
+	if d.keep > 1 {
+		end = fulls[len(fulls)-d.keep+1] - 1
+	}
+	if end < 0 {
+		return nil
+	}
+	_, err := d.run(ctx, "remove", "--archive", d.archive, "--repo", repo,
+		"--backups", r.Backups[0].Date+","+r.Backups[end].Date)
+	return err
+}
+
+// GetBackupSize returns the disk usage of the buckets backed up, an upper
+// bound of a full backup's size
+func (d *CouchbaseDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	data, err := d.backupData(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	buckets, err := d.client.buckets(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, b := range buckets {
+		if len(data) == 0 || slices.ContainsFunc(data, func(name string) bool {
+			return name == b.Name || strings.HasPrefix(name, b.Name+".")
+		}) {
+			total += b.BasicStats.DiskUsed
+		}
+	}
+	return total, nil
+}
+
+// Restore restores the buckets of an archive
+func (d *CouchbaseDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+	fail := func(err error) (*database.RestoreResult, error) {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	restored, err := d.restore(ctx, opts, f)
+	if err != nil {
+		return fail(err)
+	}
+	result.RestoredTables = restored
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+	return result, nil
+}
+
+// StreamRestore restores the buckets of an archive read from reader
+func (d *CouchbaseDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	_, err := d.restore(ctx, opts, reader)
+	return err
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *CouchbaseDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer f.Close()
+	m, err := database.ReadManifest(tar.NewReader(f), ManifestFormat, (*Manifest).validate)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	p, err := planRestore(m, opts)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if err := d.checkTargets(ctx, m, p, opts.DropExisting); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if err := d.checkChain(m); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	return nil
+}
+
+// restorePlan is what to restore and where
+type restorePlan struct {
+	source  string   // bucket of the backup restored, empty for all
+	target  string   // bucket source is restored as
+	include []string // buckets or bucket.scope.collection restored, empty for all
+	exclude []string // or those not restored
+}
+
+// targets returns the buckets restored into
+func (p *restorePlan) targets(m *Manifest) []string {
+	if p.source == "" {
+		return m.Buckets
+	}
+	return []string{p.target}
+}
+
+// planRestore decides what to restore: the restore database when the
+// backup holds that bucket, the only bucket of the backup under the
+// restore database's name, or every bucket of the backup. The
+// source_database metadata picks the bucket of a backup of several to
+// rename. Tables choose scope.collection names within the bucket.
+func planRestore(m *Manifest, opts *database.RestoreOptions) (*restorePlan, error) {
+	switch {
+	case opts.PointInTime != nil:
+		return nil, errors.New("point-in-time restores are not supported for couchbase, restore the backup taken at the time instead")
+	case len(m.Buckets) == 0:
+		return nil, errors.New("the backup holds no buckets")
+	}
+
+	p := &restorePlan{}
+	source := opts.Metadata["source_database"]
+	switch {
+	case source != "":
+		if !slices.Contains(m.Buckets, source) {
+			return nil, fmt.Errorf("the backup holds no bucket %s (it holds %s)", source, strings.Join(m.Buckets, ", "))
+		}
+	case opts.Target() == "" || slices.Contains(m.Buckets, opts.Database):
+		source = opts.Database
+	case len(m.Buckets) == 1:
+		source = m.Buckets[0]
+	default:
+		return nil, fmt.Errorf("the backup holds %s: set the source_database metadata to restore one of them as %s",
+			strings.Join(m.Buckets, ", "), opts.Target())
+	}
+	if source != "" {
+		p.source, p.target = source, opts.Target()
+		if p.target == "" {
+			p.target = source
+		}
+	}
+
+	if len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0 {
+		if p.source == "" {
+			if len(m.Buckets) != 1 {
+				return nil, errors.New("collections can only be chosen within one bucket, name it as the restore database")
+			}
+			p.source, p.target = m.Buckets[0], m.Buckets[0]
+		}
+		tables := slices.DeleteFunc(slices.Clone(opts.Tables), func(c string) bool {
+			return slices.Contains(opts.ExcludeTables, c)
+		})
+		var err error
+		switch {
+		case len(opts.Tables) > 0 && len(tables) == 0:
+			return nil, errors.New("every collection is excluded")
+		case len(tables) > 0:
+			p.include, err = qualify(p.source, tables)
+		default:
+			// cbbackupmgr cannot both include and exclude data: the other
+			// buckets are excluded too
+			for _, b := range m.Buckets {
+				if b != p.source {
+					p.exclude = append(p.exclude, b)
+				}
+			}
+			var excluded []string
+			excluded, err = qualify(p.source, opts.ExcludeTables)
+			p.exclude = append(p.exclude, excluded...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.source != "" && len(p.include) == 0 && len(p.exclude) == 0 {
+		p.include = []string{p.source}
+	}
+	return p, nil
+}
+
+// checkTargets fails when a bucket restored into exists and is not to be
+// overwritten
+func (d *CouchbaseDriver) checkTargets(ctx context.Context, m *Manifest, p *restorePlan, drop bool) error {
+	if drop {
+		return nil
+	}
+	buckets, err := d.client.buckets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, b := range buckets {
+		if slices.Contains(p.targets(m), b.Name) {
+			return fmt.Errorf("bucket %s already exists, use --drop-existing to overwrite its documents", b.Name)
+		}
+	}
+	return nil
+}
+
+// checkChain fails when the archive directory no longer holds a backup an
+// incremental builds on
+func (d *CouchbaseDriver) checkChain(m *Manifest) error {
+	for _, b := range m.Chain {
+		if _, err := os.Stat(filepath.Join(repoDir(d.archive, m.Repository), b)); err != nil {
+			return fmt.Errorf("incremental backup %s builds on backup %s, which repository %s of %s no longer holds",
+				m.Backup, b, m.Repository, d.archive)
+		}
+	}
+	return nil
+}
+
+// restore rebuilds the backup's repository from the archive read from r,
+// with the backups an incremental builds on linked in from the archive
+// directory, and restores it
+func (d *CouchbaseDriver) restore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) ([]string, error) {
+	tr := tar.NewReader(r)
+	m, err := database.ReadManifest(tr, ManifestFormat, (*Manifest).validate)
+	if err != nil {
+		return nil, err
+	}
+	p, err := planRestore(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkTargets(ctx, m, p, opts.DropExisting); err != nil {
+		return nil, err
+	}
+	if err := d.checkChain(m); err != nil {
+		return nil, err
+	}
+
+	spool, err := os.MkdirTemp("", "couchbase-restore-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(spool)
+	if err := extractArchive(tr, spool); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(repoDir(spool, m.Repository), m.Backup)); err != nil {
+		return nil, fmt.Errorf("the archive holds no backup %s", m.Backup)
+	}
+	for _, b := range m.Chain {
+		if err := os.Symlink(filepath.Join(repoDir(d.archive, m.Repository), b), filepath.Join(repoDir(spool, m.Repository), b)); err != nil {
+			return nil, err
+		}
+	}
+
+	start := m.Backup
+	if len(m.Chain) > 0 {
+		start = m.Chain[0]
+	}
+	args := append([]string{"restore", "--archive", spool, "--repo", m.Repository, "--no-progress-bar",
+		"--start", start, "--end", m.Backup, "--auto-create-buckets"}, d.clusterArgs()...)
+	if len(p.include) > 0 {
+		args = append(args, "--include-data", strings.Join(p.include, ","))
+	}
+	if len(p.exclude) > 0 {
+		args = append(args, "--exclude-data", strings.Join(p.exclude, ","))
+	}
+	if p.source != "" && p.target != p.source {
+		args = append(args, "--map-data", p.source+"="+p.target)
+	}
+	if opts.DropExisting {
+		// Documents of the backup replace newer ones of the bucket
+		args = append(args, "--force-updates")
+	}
+	if opts.Parallel > 0 {
+		args = append(args, "--threads", strconv.Itoa(opts.Parallel))
+	}
+	if _, err := d.run(ctx, args...); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Tables) > 0 {
+		restored := make([]string, 0, len(opts.Tables))
+		for _, c := range opts.Tables {
+			restored = append(restored, p.target+"."+c)
+		}
+		return restored, nil
+	}
+	return p.targets(m), nil
+}
+
+// GetDatabases returns the buckets cbbackupmgr can back up: memcached
+// buckets hold no data to back up
+func (d *CouchbaseDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	buckets, err := d.client.buckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(buckets))
+	for _, b := range buckets {
+		if b.BucketType != "memcached" {
+			names = append(names, b.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetTables returns the collections of a bucket as scope.collection
+func (d *CouchbaseDriver) GetTables(ctx context.Context, database string) ([]string, error) {
+	return d.client.collections(ctx, database)
+}
+
+// GetTableSize is not supported, the REST API sizes buckets only
+func (d *CouchbaseDriver) GetTableSize(ctx context.Context, database, table string) (int64, error) {
+	return 0, pkgErrors.New(pkgErrors.ErrorTypeDatabase, "couchbase does not size collections")
+}
+
+// GetVersion returns the version of the cluster
+func (d *CouchbaseDriver) GetVersion(ctx context.Context) (string, error) {
+	c, err := d.client.cluster(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.Version, nil
+}
+
+// GetType returns the database type
+func (d *CouchbaseDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeCouchbase
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *CouchbaseDriver) SupportsIncremental() bool {
+	return true // cbbackupmgr repositories
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *CouchbaseDriver) SupportsPITR() bool {
+	return false
+}
@@ -0,0 +1,135 @@
+package couchbase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// client reads the cluster through the REST API (port 8091, 18091 with
+// TLS)
+type client struct {
+	base     string
+	username string
+	password string
+	hc       *http.Client
+}
+
+// newClient builds a client of the cluster in config
+func newClient(config *database.ConnectionConfig) (*client, error) {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := config.ClientTLS()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		scheme, transport.TLSClientConfig = "https", tlsConfig
+	}
+	timeout := config.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	transport.DialContext = (&net.Dialer{Timeout: timeout}).DialContext
+	return &client{
+		base:     scheme + "://" + net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		username: config.Username,
+		password: config.Password,
+		hc:       &http.Client{Transport: transport},
+	}, nil
+}
+
+// cluster is what /pools says of the cluster
+type cluster struct {
+	Version string `json:"implementationVersion"` // e.g. 7.2.4-7070-enterprise
+}
+
+// bucket is a bucket of /pools/default/buckets
+type bucket struct {
+	Name       string `json:"name"`
+	BucketType string `json:"bucketType"` // membase (Couchbase), memcached or ephemeral
+	BasicStats struct {
+		DiskUsed  int64 `json:"diskUsed"`
+		ItemCount int64 `json:"itemCount"`
+	} `json:"basicStats"`
+}
+
+// scopes is the collection manifest of a bucket
+type scopes struct {
+	Scopes []struct {
+		Name        string `json:"name"`
+		Collections []struct {
+			Name string `json:"name"`
+		} `json:"collections"`
+	} `json:"scopes"`
+}
+
+// get decodes the JSON document at path into v
+func (c *client) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return errors.New("authentication failed")
+	default:
+		return fmt.Errorf("GET %s returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response to GET %s: %w", path, err)
+	}
+	return nil
+}
+
+// cluster returns the version of the cluster
+func (c *client) cluster(ctx context.Context) (*cluster, error) {
+	var cl cluster
+	if err := c.get(ctx, "/pools", &cl); err != nil {
+		return nil, err
+	}
+	if cl.Version == "" {
+		return nil, errors.New("not a Couchbase cluster")
+	}
+	return &cl, nil
+}
+
+// buckets returns the buckets of the cluster
+func (c *client) buckets(ctx context.Context) ([]bucket, error) {
+	var buckets []bucket
+	if err := c.get(ctx, "/pools/default/buckets", &buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// collections returns the collections of a bucket as scope.collection
+func (c *client) collections(ctx context.Context, name string) ([]string, error) {
+	var s scopes
+	if err := c.get(ctx, "/pools/default/buckets/"+url.PathEscape(name)+"/scopes", &s); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, scope := range s.Scopes {
+		for _, collection := range scope.Collections {
+			names = append(names, scope.Name+"."+collection.Name)
+		}
+	}
+	return names, nil
+}
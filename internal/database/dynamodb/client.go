@@ -0,0 +1,336 @@
+package dynamodb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// apiVersion is the DynamoDB API version requests target
+const apiVersion = "20120810"
+
+// pollInterval is how often running exports, imports and table deletions
+// are checked on
+var pollInterval = 15 * time.Second
+
+// client calls the DynamoDB JSON API, signed with SigV4
+type client struct {
+	endpoint string
+	region   string
+	creds    aws.CredentialsProvider
+	signer   *v4.Signer
+	hc       *http.Client
+}
+
+// newClient builds a client of the region in config. Credentials are the
+// user and password as an access key when set, the default AWS chain
+// (environment, shared config, instance role) otherwise.
+func newClient(ctx context.Context, config *database.ConnectionConfig) (*client, error) {
+	region := config.Host
+	if region == "localhost" {
+		// The CLI's default host: take the region from the AWS config
+		region = ""
+	}
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		loadOpts = append(loadOpts, awsconfig.WithRegion(region))
+	}
+	if profile := config.Options["profile"]; profile != "" {
+		loadOpts = append(loadOpts, awsconfig.WithSharedConfigProfile(profile))
+	}
+	if config.Username != "" {
+		loadOpts = append(loadOpts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(config.Username, config.Password, config.Options["session_token"])))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS credentials: %w", err)
+	}
+	if awsCfg.Region == "" {
+		return nil, errors.New("set the host to the AWS region of the tables, e.g. us-east-1")
+	}
+
+	endpoint := config.Options["endpoint"]
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://dynamodb.%s.amazonaws.com/", awsCfg.Region)
+	}
+	timeout := config.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &client{
+		endpoint: endpoint,
+		region:   awsCfg.Region,
+		creds:    awsCfg.Credentials,
+		signer:   v4.NewSigner(),
+		hc:       &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// apiError is an error returned by DynamoDB
+type apiError struct {
+	Op      string
+	Type    string `json:"__type"` // e.g. com.amazonaws.dynamodb.v20120810#ResourceNotFoundException
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Op + ": " + e.code() + ": " + e.Message
+}
+
+// code returns the name of the error, e.g. ResourceNotFoundException
+func (e *apiError) code() string {
+	return e.Type[strings.LastIndex(e.Type, "#")+1:]
+}
+
+// errorCode returns the code of a DynamoDB error, "" for other errors
+func errorCode(err error) string {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e.code()
+	}
+	return ""
+}
+
+// call runs operation op with the request in and decodes the response
+// into out
+func (c *client) call(ctx context.Context, op string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: failed to create request: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_"+apiVersion+"."+op)
+
+	creds, err := c.creds.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "dynamodb", c.region, time.Now()); err != nil {
+		return fmt.Errorf("%s: failed to sign request: %w", op, err)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: request failed: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		apiErr := &apiError{Op: op}
+		if json.Unmarshal(respBody, apiErr) != nil || apiErr.Type == "" {
+			return fmt.Errorf("%s failed with status %d: %s", op, resp.StatusCode, strings.TrimSpace(string(respBody)))
+		}
+		if apiErr.Message == "" {
+			// Some errors spell it Message
+			var alt struct{ Message string }
+			json.Unmarshal(respBody, &alt)
+			apiErr.Message = alt.Message
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: failed to decode response: %w", op, err)
+	}
+	return nil
+}
+
+// attributeDefinition is the type of a key attribute
+type attributeDefinition struct {
+	AttributeName string
+	AttributeType string // S, N or B
+}
+
+// keyElement is an attribute of a key
+type keyElement struct {
+	AttributeName string
+	KeyType       string // HASH or RANGE
+}
+
+// throughput is the provisioned capacity of a table or index
+type throughput struct {
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+}
+
+// projection is what a secondary index copies of the items
+type projection struct {
+	ProjectionType   string
+	NonKeyAttributes []string `json:",omitempty"`
+}
+
+// secondaryIndex is a global secondary index
+type secondaryIndex struct {
+	IndexName             string
+	KeySchema             []keyElement
+	Projection            projection
+	ProvisionedThroughput *throughput `json:",omitempty"`
+}
+
+// tableDescription is what DescribeTable says of a table
+type tableDescription struct {
+	TableName             string
+	TableArn              string
+	TableStatus           string
+	TableSizeBytes        int64
+	ItemCount             int64
+	AttributeDefinitions  []attributeDefinition
+	KeySchema             []keyElement
+	ProvisionedThroughput *throughput
+	BillingModeSummary    *struct {
+		BillingMode string
+	}
+	GlobalSecondaryIndexes []secondaryIndex
+	SSEDescription         *struct {
+		SSEType         string
+		KMSMasterKeyArn string
+	}
+}
+
+// exportDescription is what DescribeExport says of an export
+type exportDescription struct {
+	ExportArn       string
+	ExportStatus    string // IN_PROGRESS, COMPLETED or FAILED
+	ExportManifest  string // key of manifest-summary.json
+	ExportTime      float64
+	BilledSizeBytes int64
+	ItemCount       int64
+	FailureCode     string
+	FailureMessage  string
+}
+
+// importDescription is what DescribeImport says of an import
+type importDescription struct {
+	ImportArn         string
+	ImportStatus      string // IN_PROGRESS, COMPLETED, CANCELLING, CANCELLED or FAILED
+	ImportedItemCount int64
+	ErrorCount        int64
+	FailureCode       string
+	FailureMessage    string
+}
+
+// tables returns the names of the tables of the region
+func (c *client) tables(ctx context.Context) ([]string, error) {
+	var names []string
+	in := map[string]interface{}{}
+	for {
+		var out struct {
+			TableNames             []string
+			LastEvaluatedTableName string
+		}
+		if err := c.call(ctx, "ListTables", in, &out); err != nil {
+			return nil, err
+		}
+		names = append(names, out.TableNames...)
+		if out.LastEvaluatedTableName == "" {
+			return names, nil
+		}
+		in["ExclusiveStartTableName"] = out.LastEvaluatedTableName
+	}
+}
+
+// describe returns the description of a table
+func (c *client) describe(ctx context.Context, table string) (*tableDescription, error) {
+	var out struct{ Table tableDescription }
+	if err := c.call(ctx, "DescribeTable", map[string]string{"TableName": table}, &out); err != nil {
+		return nil, err
+	}
+	return &out.Table, nil
+}
+
+// exists reports whether a table exists
+func (c *client) exists(ctx context.Context, table string) (bool, error) {
+	_, err := c.describe(ctx, table)
+	if errorCode(err) == "ResourceNotFoundException" {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// deleteTable deletes a table and waits until it is gone
+func (c *client) deleteTable(ctx context.Context, table string) error {
+	if err := c.call(ctx, "DeleteTable", map[string]string{"TableName": table}, nil); err != nil {
+		return err
+	}
+	return wait(ctx, func() (bool, error) {
+		exists, err := c.exists(ctx, table)
+		return !exists, err
+	})
+}
+
+// waitExport waits for an export to finish and returns its description
+func (c *client) waitExport(ctx context.Context, arn string) (*exportDescription, error) {
+	var out struct{ ExportDescription exportDescription }
+	err := wait(ctx, func() (bool, error) {
+		if err := c.call(ctx, "DescribeExport", map[string]string{"ExportArn": arn}, &out); err != nil {
+			return false, err
+		}
+		e := out.ExportDescription
+		if e.ExportStatus == "FAILED" {
+			return false, fmt.Errorf("export %s failed: %s: %s", arn, e.FailureCode, e.FailureMessage)
+		}
+		return e.ExportStatus == "COMPLETED", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &out.ExportDescription, nil
+}
+
+// waitImport waits for an import to finish and returns its description
+func (c *client) waitImport(ctx context.Context, arn string) (*importDescription, error) {
+	var out struct{ ImportTableDescription importDescription }
+	err := wait(ctx, func() (bool, error) {
+		if err := c.call(ctx, "DescribeImport", map[string]string{"ImportArn": arn}, &out); err != nil {
+			return false, err
+		}
+		i := out.ImportTableDescription
+		switch i.ImportStatus {
+		case "FAILED", "CANCELLING", "CANCELLED":
+			return false, fmt.Errorf("import %s %s: %s: %s", arn, strings.ToLower(i.ImportStatus), i.FailureCode, i.FailureMessage)
+		}
+		return i.ImportStatus == "COMPLETED", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &out.ImportTableDescription, nil
+}
+
+// wait calls done every pollInterval until it reports true or fails
+func wait(ctx context.Context, done func() (bool, error)) error {
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
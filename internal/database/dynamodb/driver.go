@@ -0,0 +1,521 @@
+// Package dynamodb provides the Amazon DynamoDB driver. Backups are table
+// exports: ExportTableToPointInTime writes each table to S3 from the
+// table's continuous backups, without reading its capacity or passing the
+// data through this host. What db-backup stores is a small JSON manifest
+// naming the exports; restores read it and recreate each table from its
+// export with ImportTable.
+//
+// Each table is a database: --database names one, --all-databases backs up
+// every table of the region. The host is the region (the AWS config's when
+// it is localhost), the user and password an access key (the default AWS
+// chain when unset). Options:
+//
+//   - s3_bucket: bucket exports are written to (required for backups)
+//   - s3_prefix: key prefix of exports, db-backup/dynamodb by default;
+//     each table's go under <prefix>/<table>
+//   - s3_bucket_owner: account owning the bucket, when another one does
+//   - s3_kms_key: KMS key exports are encrypted with, the bucket's
+//     default encryption otherwise
+//   - export_format: DYNAMODB_JSON (default) or ION
+//   - profile, session_token: AWS shared config profile, session token of
+//     a temporary access key
+//   - endpoint: DynamoDB endpoint, e.g. a VPC endpoint
+//
+// Exports need point-in-time recovery enabled on the table. ImportTable
+// always creates the table, so restores refuse tables that exist unless
+// they are dropped first; local secondary indexes, which ImportTable
+// cannot create, are not recreated.
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// DefaultPrefix is the key prefix of exports when s3_prefix is not set
+const DefaultPrefix = "db-backup/dynamodb"
+
+// version is the DynamoDB API version, all there is to report of a
+// managed service
+const version = "2012-08-10"
+
+// DynamoDBDriver implements the database.Driver interface for DynamoDB
+type DynamoDBDriver struct {
+	client *client
+	config *database.ConnectionConfig
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeDynamoDB, func() database.Driver {
+		return NewDynamoDBDriver()
+	})
+}
+
+// NewDynamoDBDriver creates a new DynamoDB driver instance
+func NewDynamoDBDriver() *DynamoDBDriver {
+	return &DynamoDBDriver{}
+}
+
+// Connect checks the credentials can list the region's tables
+func (d *DynamoDBDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	if f := config.Options["export_format"]; f != "" && f != "DYNAMODB_JSON" && f != "ION" {
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("export_format %q is not DYNAMODB_JSON or ION", f))
+	}
+	c, err := newClient(ctx, config)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if err := c.call(ctx, "ListTables", map[string]int{"Limit": 1}, nil); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.client, d.config = c, config
+	return nil
+}
+
+// Disconnect releases idle connections
+func (d *DynamoDBDriver) Disconnect() error {
+	if d.client != nil {
+		d.client.hc.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Ping tests the connection
+func (d *DynamoDBDriver) Ping(ctx context.Context) error {
+	if d.client == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	return d.client.call(ctx, "ListTables", map[string]int{"Limit": 1}, nil)
+}
+
+// Backup exports the tables opts name and writes the manifest of the
+// exports to opts.OutputPath
+func (d *DynamoDBDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	m, err := d.backup(ctx, opts)
+	if err != nil {
+		return fail(err)
+	}
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	err = writeManifest(output, m)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	arns := make([]string, len(m.Tables))
+	for i, t := range m.Tables {
+		result.Tables = append(result.Tables, database.TableInfo{Name: t.Name, RowCount: t.Items, DataSize: t.Size})
+		arns[i] = t.ExportArn
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = version
+	result.Size = m.Size
+	result.Checksum = output.Sum()
+	metadata := map[string]string{
+		"dynamodb_region":  m.Region,
+		"dynamodb_bucket":  m.Bucket,
+		"dynamodb_exports": strings.Join(arns, ","),
+	}
+	for k, v := range result.Metadata {
+		metadata[k] = v
+	}
+	result.Metadata = metadata
+	result.Status = database.BackupStatusSuccess
+
+	return result, nil
+}
+
+// StreamBackup exports the tables opts name and writes the manifest of the
+// exports to writer
+func (d *DynamoDBDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	m, err := d.backup(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return writeManifest(writer, m)
+}
+
+// GetBackupSize returns the size of the tables, which DynamoDB updates
+// about every six hours
+func (d *DynamoDBDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	names, err := d.targets(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, name := range names {
+		t, err := d.client.describe(ctx, name)
+		if err != nil {
+			return 0, err
+		}
+		size += t.TableSizeBytes
+	}
+	return size, nil
+}
+
+// Restore recreates the tables of the backup whose manifest is
+// opts.SourceBackup
+func (d *DynamoDBDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+
+	file, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+	defer file.Close()
+
+	restored, err := d.restore(ctx, opts, file)
+	if err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err)
+	}
+
+	for _, t := range restored {
+		result.RestoredTables = append(result.RestoredTables, t.Definition.TableName)
+		result.RowsRestored += t.Items
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+
+	return result, nil
+}
+
+// StreamRestore recreates the tables of the backup whose manifest is read
+// from reader
+func (d *DynamoDBDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	_, err := d.restore(ctx, opts, reader)
+	return err
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *DynamoDBDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	file, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer file.Close()
+	m, err := readManifest(file)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if _, err := restorePlan(m, opts); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if opts.PointInTime != nil {
+		return pkgErrors.ErrValidationFailed("point-in-time restores are not supported, restore the backup taken at the time instead")
+	}
+	if err := d.Ping(ctx); err != nil {
+		return pkgErrors.ErrValidationFailed("database connection failed")
+	}
+	return nil
+}
+
+// GetDatabases returns the tables of the region
+func (d *DynamoDBDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	return d.client.tables(ctx)
+}
+
+// GetTables returns the table itself, each table being a database
+func (d *DynamoDBDriver) GetTables(ctx context.Context, db string) ([]string, error) {
+	t, err := d.client.describe(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return []string{t.TableName}, nil
+}
+
+// GetTableSize returns the size of a table
+func (d *DynamoDBDriver) GetTableSize(ctx context.Context, db, table string) (int64, error) {
+	t, err := d.client.describe(ctx, table)
+	if err != nil {
+		return 0, err
+	}
+	return t.TableSizeBytes, nil
+}
+
+// GetVersion returns the DynamoDB API version
+func (d *DynamoDBDriver) GetVersion(ctx context.Context) (string, error) {
+	return version, nil
+}
+
+// GetType returns the database type
+func (d *DynamoDBDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeDynamoDB
+}
+
+// SupportsIncremental returns whether incremental backups are supported.
+// Incremental exports hold item changes, which ImportTable cannot read.
+func (d *DynamoDBDriver) SupportsIncremental() bool {
+	return false
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *DynamoDBDriver) SupportsPITR() bool {
+	return false
+}
+
+// backup exports the tables opts name and returns the manifest of the
+// exports. Every export is started before any is waited for, so they run
+// side by side.
+func (d *DynamoDBDriver) backup(ctx context.Context, opts *database.BackupOptions) (*Manifest, error) {
+	bucket := d.config.Options["s3_bucket"]
+	if bucket == "" {
+		return nil, errors.New("set the s3_bucket option to the bucket tables are exported to")
+	}
+	names, err := d.targets(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(d.config.Options["s3_prefix"], "/")
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	m := &Manifest{
+		Format:       ManifestFormat,
+		Region:       d.client.region,
+		Bucket:       bucket,
+		BucketOwner:  d.config.Options["s3_bucket_owner"],
+		ExportFormat: d.config.Options["export_format"],
+		CreatedAt:    time.Now().UTC(),
+	}
+	if m.ExportFormat == "" {
+		m.ExportFormat = "DYNAMODB_JSON"
+	}
+
+	for _, name := range names {
+		t, err := d.client.describe(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		in := map[string]string{
+			"TableArn":     t.TableArn,
+			"S3Bucket":     bucket,
+			"S3Prefix":     prefix + "/" + name,
+			"ExportFormat": m.ExportFormat,
+		}
+		if m.BucketOwner != "" {
+			in["S3BucketOwner"] = m.BucketOwner
+		}
+		if key := d.config.Options["s3_kms_key"]; key != "" {
+			in["S3SseAlgorithm"], in["S3SseKmsKeyId"] = "KMS", key
+		}
+		var out struct{ ExportDescription exportDescription }
+		if err := d.client.call(ctx, "ExportTableToPointInTime", in, &out); err != nil {
+			if errorCode(err) == "PointInTimeRecoveryUnavailableException" {
+				return nil, fmt.Errorf("table %s: enable point-in-time recovery, exports are taken from it: %w", name, err)
+			}
+			return nil, err
+		}
+		m.Tables = append(m.Tables, Table{Name: name, ExportArn: out.ExportDescription.ExportArn, Definition: definition(t)})
+	}
+
+	for i := range m.Tables {
+		t := &m.Tables[i]
+		e, err := d.client.waitExport(ctx, t.ExportArn)
+		if err != nil {
+			return nil, err
+		}
+		sec, frac := math.Modf(e.ExportTime)
+		t.ExportTime = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+		t.DataPrefix = dataPrefix(e.ExportManifest)
+		t.Items, t.Size = e.ItemCount, e.BilledSizeBytes
+		m.Size += t.Size
+	}
+	return m, nil
+}
+
+// restore recreates the tables of the manifest read from r and returns
+// them, with the number of items imported
+func (d *DynamoDBDriver) restore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) ([]Table, error) {
+	m, err := readManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PointInTime != nil {
+		return nil, errors.New("point-in-time restores are not supported, restore the backup taken at the time instead")
+	}
+	tables, err := restorePlan(m, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// ImportTable creates the table, so it must not exist
+	for _, t := range tables {
+		name := t.Definition.TableName
+		exists, err := d.client.exists(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		if !opts.DropExisting {
+			return nil, fmt.Errorf("table %s exists, drop it or restore under another name", name)
+		}
+		if err := d.client.deleteTable(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	arns := make([]string, len(tables))
+	for i, t := range tables {
+		source := map[string]string{"S3Bucket": m.Bucket, "S3KeyPrefix": t.DataPrefix}
+		if m.BucketOwner != "" {
+			source["S3BucketOwner"] = m.BucketOwner
+		}
+		in := map[string]interface{}{
+			"S3BucketSource":          source,
+			"InputFormat":             m.ExportFormat,
+			"InputCompressionType":    "GZIP",
+			"TableCreationParameters": t.Definition,
+		}
+		var out struct{ ImportTableDescription importDescription }
+		if err := d.client.call(ctx, "ImportTable", in, &out); err != nil {
+			return nil, err
+		}
+		arns[i] = out.ImportTableDescription.ImportArn
+	}
+	for i := range tables {
+		imported, err := d.client.waitImport(ctx, arns[i])
+		if err != nil {
+			return nil, err
+		}
+		if imported.ErrorCount > 0 {
+			return nil, fmt.Errorf("table %s: %d items could not be imported, see the import's CloudWatch logs", tables[i].Definition.TableName, imported.ErrorCount)
+		}
+		tables[i].Items = imported.ImportedItemCount
+	}
+	return tables, nil
+}
+
+// targets returns the tables opts back up: the tables, else the
+// databases, every table of the region with opts.AllDatabases, less those
+// excluded
+func (d *DynamoDBDriver) targets(ctx context.Context, opts *database.BackupOptions) ([]string, error) {
+	var names []string
+	switch {
+	case len(opts.Tables) > 0:
+		names = opts.Tables
+	case opts.AllDatabases:
+		var err error
+		if names, err = d.client.tables(ctx); err != nil {
+			return nil, err
+		}
+	case len(opts.Databases) > 0:
+		names = opts.Databases
+	case opts.Database != "":
+		names = []string{opts.Database}
+	default:
+		return nil, errors.New("no table to back up")
+	}
+	names = without(names, opts.ExcludeTables)
+	if len(names) == 0 {
+		return nil, errors.New("every table is excluded")
+	}
+	return names, nil
+}
+
+// restorePlan returns the tables of m a restore recreates, named as they
+// are created. opts.Database names a table of the backup to restore alone,
+// or the new name of the only table restored, which opts.TargetDatabase
+// names otherwise.
+func restorePlan(m *Manifest, opts *database.RestoreOptions) ([]Table, error) {
+	byName := map[string]Table{}
+	var names []string
+	for _, t := range m.Tables {
+		byName[t.Name] = t
+		names = append(names, t.Name)
+	}
+	rename := ""
+	only := opts.Tables
+	if _, ok := byName[opts.Database]; ok {
+		if len(only) == 0 {
+			only = []string{opts.Database}
+		}
+	} else {
+		rename = opts.Database
+	}
+	if opts.Renamed() {
+		rename = opts.TargetDatabase
+	}
+	if len(only) > 0 {
+		for _, name := range only {
+			if _, ok := byName[name]; !ok {
+				return nil, fmt.Errorf("table %s is not in the backup", name)
+			}
+		}
+		names = only
+	}
+	names = without(names, opts.ExcludeTables)
+	if len(names) == 0 {
+		return nil, errors.New("every table of the backup is excluded")
+	}
+	if rename != "" && len(names) > 1 {
+		return nil, errors.New("the backup holds several tables and cannot be restored under one name")
+	}
+
+	tables := make([]Table, len(names))
+	for i, name := range names {
+		tables[i] = byName[name]
+		if rename != "" {
+			tables[i].Definition.TableName = rename
+		}
+	}
+	return tables, nil
+}
+
+// without returns the names not in exclude
+func without(names, exclude []string) []string {
+	excluded := map[string]bool{}
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+	var kept []string
+	for _, name := range names {
+		if !excluded[name] {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,322 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func init() {
+	pollInterval = time.Millisecond
+}
+
+// fakeDynamoDB answers the DynamoDB operations the driver calls. Exports
+// and imports complete on their second DescribeExport or DescribeImport.
+type fakeDynamoDB struct {
+	mu      sync.Mutex
+	tables  map[string]map[string]interface{}
+	noPITR  map[string]bool
+	calls   []string
+	exports map[string]map[string]string
+	imports map[string]map[string]interface{}
+	polls   map[string]int
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIATEST/") ||
+		!strings.Contains(auth, "/us-east-1/dynamodb/aws4_request") {
+		http.Error(w, `{"__type":"com.amazon.coral.service#UnrecognizedClientException","message":"bad signature"}`, http.StatusBadRequest)
+		return
+	}
+	op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	var in map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&in)
+	f.calls = append(f.calls, op)
+
+	fail := func(code, message string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.dynamodb.v20120810#" + code, "message": message})
+	}
+	reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+	str := func(key string) string { s, _ := in[key].(string); return s }
+
+	switch op {
+	case "ListTables":
+		names := []string{}
+		for name := range f.tables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		reply(map[string]interface{}{"TableNames": names})
+	case "DescribeTable":
+		t, ok := f.tables[str("TableName")]
+		if !ok {
+			fail("ResourceNotFoundException", "Requested resource not found")
+			return
+		}
+		reply(map[string]interface{}{"Table": t})
+	case "DeleteTable":
+		delete(f.tables, str("TableName"))
+		reply(map[string]interface{}{})
+	case "ExportTableToPointInTime":
+		name := strings.TrimPrefix(str("TableArn"), "arn:aws:dynamodb:us-east-1:123456789012:table/")
+		if f.noPITR[name] {
+			fail("PointInTimeRecoveryUnavailableException", "Point in time recovery is not enabled for table '"+name+"'")
+			return
+		}
+		arn := "arn:aws:dynamodb:us-east-1:123456789012:table/" + name + "/export/01700000000000-" + name
+		export := map[string]string{}
+		for k, v := range in {
+			export[k], _ = v.(string)
+		}
+		f.exports[arn] = export
+		reply(map[string]interface{}{"ExportDescription": map[string]string{"ExportArn": arn, "ExportStatus": "IN_PROGRESS"}})
+	case "DescribeExport":
+		arn := str("ExportArn")
+		export := f.exports[arn]
+		f.polls[arn]++
+		d := map[string]interface{}{"ExportArn": arn, "ExportStatus": "IN_PROGRESS"}
+		if f.polls[arn] > 1 {
+			d["ExportStatus"] = "COMPLETED"
+			d["ExportTime"] = 1.7e9
+			d["ExportManifest"] = export["S3Prefix"] + "/AWSDynamoDB/01700000000000-abc/manifest-summary.json"
+			d["ItemCount"] = 42
+			d["BilledSizeBytes"] = 4096
+		}
+		reply(map[string]interface{}{"ExportDescription": d})
+	case "ImportTable":
+		params := in["TableCreationParameters"].(map[string]interface{})
+		arn := "arn:aws:dynamodb:us-east-1:123456789012:table/" + params["TableName"].(string) + "/import/01"
+		f.imports[arn] = in
+		f.tables[params["TableName"].(string)] = map[string]interface{}{"TableName": params["TableName"]}
+		reply(map[string]interface{}{"ImportTableDescription": map[string]string{"ImportArn": arn, "ImportStatus": "IN_PROGRESS"}})
+	case "DescribeImport":
+		arn := str("ImportArn")
+		f.polls[arn]++
+		d := map[string]interface{}{"ImportArn": arn, "ImportStatus": "IN_PROGRESS"}
+		if f.polls[arn] > 1 {
+			d["ImportStatus"], d["ImportedItemCount"] = "COMPLETED", 42
+		}
+		reply(map[string]interface{}{"ImportTableDescription": d})
+	default:
+		fail("UnknownOperationException", op)
+	}
+}
+
+// take returns the operations called since the last take
+func (f *fakeDynamoDB) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+// table is the DescribeTable answer for a table keyed on pk and sk, with a
+// global and a local secondary index
+func table(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"TableName":      name,
+		"TableArn":       "arn:aws:dynamodb:us-east-1:123456789012:table/" + name,
+		"TableStatus":    "ACTIVE",
+		"TableSizeBytes": 1000,
+		"ItemCount":      42,
+		"AttributeDefinitions": []map[string]string{
+			{"AttributeName": "pk", "AttributeType": "S"},
+			{"AttributeName": "sk", "AttributeType": "S"},
+			{"AttributeName": "email", "AttributeType": "S"},
+			{"AttributeName": "created", "AttributeType": "N"},
+		},
+		"KeySchema": []map[string]string{
+			{"AttributeName": "pk", "KeyType": "HASH"},
+			{"AttributeName": "sk", "KeyType": "RANGE"},
+		},
+		"BillingModeSummary":    map[string]string{"BillingMode": "PAY_PER_REQUEST"},
+		"ProvisionedThroughput": map[string]int{"ReadCapacityUnits": 0, "WriteCapacityUnits": 0, "NumberOfDecreasesToday": 0},
+		"GlobalSecondaryIndexes": []map[string]interface{}{{
+			"IndexName":             "by-email",
+			"KeySchema":             []map[string]string{{"AttributeName": "email", "KeyType": "HASH"}},
+			"Projection":            map[string]string{"ProjectionType": "KEYS_ONLY"},
+			"ProvisionedThroughput": map[string]int{"ReadCapacityUnits": 0, "WriteCapacityUnits": 0},
+			"IndexStatus":           "ACTIVE",
+		}},
+		"LocalSecondaryIndexes": []map[string]interface{}{{
+			"IndexName":  "by-created",
+			"KeySchema":  []map[string]string{{"AttributeName": "pk", "KeyType": "HASH"}, {"AttributeName": "created", "KeyType": "RANGE"}},
+			"Projection": map[string]string{"ProjectionType": "ALL"},
+		}},
+	}
+}
+
+func connect(t *testing.T, options map[string]string) (*DynamoDBDriver, *fakeDynamoDB) {
+	t.Helper()
+	// Keep the shared AWS config of the host out of the test
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	fake := &fakeDynamoDB{
+		tables:  map[string]map[string]interface{}{"orders": table("orders"), "customers": table("customers")},
+		noPITR:  map[string]bool{},
+		exports: map[string]map[string]string{},
+		imports: map[string]map[string]interface{}{},
+		polls:   map[string]int{},
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	if options == nil {
+		options = map[string]string{}
+	}
+	options["endpoint"] = server.URL
+	d := NewDynamoDBDriver()
+	if err := d.Connect(context.Background(), &database.ConnectionConfig{
+		Host: "us-east-1", Username: "AKIATEST", Password: "secret", Options: options,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Disconnect() })
+	fake.take()
+	return d, fake
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	d, fake := connect(t, map[string]string{"s3_bucket": "backups", "s3_prefix": "/dynamo/", "s3_kms_key": "alias/backups"})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup.json")
+
+	result, err := d.Backup(ctx, &database.BackupOptions{AllDatabases: true, OutputPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both exports are started before either is waited for
+	calls := fake.take()
+	want := []string{"ListTables", "DescribeTable", "ExportTableToPointInTime", "DescribeTable", "ExportTableToPointInTime",
+		"DescribeExport", "DescribeExport", "DescribeExport", "DescribeExport"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q", calls)
+	}
+	export := fake.exports["arn:aws:dynamodb:us-east-1:123456789012:table/orders/export/01700000000000-orders"]
+	if export["S3Bucket"] != "backups" || export["S3Prefix"] != "dynamo/orders" || export["ExportFormat"] != "DYNAMODB_JSON" ||
+		export["S3SseAlgorithm"] != "KMS" || export["S3SseKmsKeyId"] != "alias/backups" {
+		t.Errorf("export = %v", export)
+	}
+	if result.Size != 8192 || len(result.Tables) != 2 || result.Tables[0].Name != "customers" || result.Tables[0].RowCount != 42 ||
+		result.DatabaseVersion != "2012-08-10" || result.Metadata["dynamodb_region"] != "us-east-1" || result.Checksum == "" {
+		t.Errorf("result = %+v", result)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := readManifest(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := m.Tables[1]
+	if orders.DataPrefix != "dynamo/orders/AWSDynamoDB/01700000000000-abc/data/" || !orders.ExportTime.Equal(time.Unix(1.7e9, 0)) {
+		t.Errorf("orders = %+v", orders)
+	}
+	// The attribute of the local index, which cannot be recreated, is left
+	// out, and on-demand tables have no throughput
+	def := orders.Definition
+	if len(def.AttributeDefinitions) != 3 || def.BillingMode != "PAY_PER_REQUEST" || def.ProvisionedThroughput != nil ||
+		len(def.GlobalSecondaryIndexes) != 1 || def.GlobalSecondaryIndexes[0].ProvisionedThroughput != nil {
+		t.Errorf("definition = %+v", def)
+	}
+
+	// The tables exist
+	if _, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: path}); err == nil || !strings.Contains(err.Error(), "exists") {
+		t.Errorf("restore over existing tables: %v", err)
+	}
+	fake.take()
+
+	// One table under a new name
+	restored, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: path, Tables: []string{"orders"}, Database: "orders-copy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.RestoredTables, []string{"orders-copy"}) || restored.RowsRestored != 42 {
+		t.Errorf("restored = %+v", restored)
+	}
+	in := fake.imports["arn:aws:dynamodb:us-east-1:123456789012:table/orders-copy/import/01"]
+	source := in["S3BucketSource"].(map[string]interface{})
+	if source["S3Bucket"] != "backups" || source["S3KeyPrefix"] != orders.DataPrefix || in["InputFormat"] != "DYNAMODB_JSON" ||
+		in["InputCompressionType"] != "GZIP" {
+		t.Errorf("import = %v", in)
+	}
+
+	fake.take()
+
+	// Dropped first
+	if _, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: path, Database: "customers", DropExisting: true}); err != nil {
+		t.Fatal(err)
+	}
+	calls = fake.take()
+	if calls[0] != "DescribeTable" || calls[1] != "DeleteTable" || calls[len(calls)-1] != "DescribeImport" {
+		t.Errorf("drop and restore called %q", calls)
+	}
+}
+
+func TestBackupNeedsPITR(t *testing.T) {
+	d, fake := connect(t, map[string]string{"s3_bucket": "backups"})
+	fake.noPITR["orders"] = true
+	_, err := d.Backup(context.Background(), &database.BackupOptions{Database: "orders", OutputPath: filepath.Join(t.TempDir(), "b.json")})
+	if err == nil || !strings.Contains(err.Error(), "enable point-in-time recovery") {
+		t.Errorf("err = %v", err)
+	}
+
+	d, _ = connect(t, nil)
+	_, err = d.Backup(context.Background(), &database.BackupOptions{Database: "orders", OutputPath: filepath.Join(t.TempDir(), "b.json")})
+	if err == nil || !strings.Contains(err.Error(), "s3_bucket") {
+		t.Errorf("without a bucket: %v", err)
+	}
+}
+
+func TestRestorePlan(t *testing.T) {
+	m := &Manifest{Tables: []Table{
+		{Name: "customers", Definition: tableCreation{TableName: "customers"}},
+		{Name: "orders", Definition: tableCreation{TableName: "orders"}},
+	}}
+	for _, tc := range []struct {
+		opts database.RestoreOptions
+		want []string
+		err  string
+	}{
+		{opts: database.RestoreOptions{}, want: []string{"customers", "orders"}},
+		{opts: database.RestoreOptions{Database: "orders"}, want: []string{"orders"}},
+		{opts: database.RestoreOptions{ExcludeTables: []string{"orders"}, Database: "archive"}, want: []string{"archive"}},
+		{opts: database.RestoreOptions{Database: "archive"}, err: "several tables"},
+		{opts: database.RestoreOptions{Tables: []string{"invoices"}}, err: "not in the backup"},
+		{opts: database.RestoreOptions{ExcludeTables: []string{"orders", "customers"}}, err: "every table"},
+	} {
+		tables, err := restorePlan(m, &tc.opts)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%+v: err = %v, want %q", tc.opts, err, tc.err)
+			}
+			continue
+		}
+		var names []string
+		for _, table := range tables {
+			names = append(names, table.Definition.TableName)
+		}
+		if err != nil || !reflect.DeepEqual(names, tc.want) {
+			t.Errorf("%+v: %q, %v", tc.opts, names, err)
+		}
+	}
+}
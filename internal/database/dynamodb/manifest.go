@@ -0,0 +1,138 @@
+package dynamodb
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"time"
+)
+
+// ManifestFormat identifies the manifests written by this driver
+const ManifestFormat = "dynamodb-export/v1"
+
+// Manifest describes the exports of a backup. It is the artifact db-backup
+// stores; the data stays where DynamoDB exported it.
+type Manifest struct {
+	Format      string `json:"format"`
+	Region      string `json:"region"`
+	Bucket      string `json:"bucket"`
+	BucketOwner string `json:"bucket_owner,omitempty"`
+	// ExportFormat is DYNAMODB_JSON or ION
+	ExportFormat string    `json:"export_format"`
+	Tables       []Table   `json:"tables"`
+	CreatedAt    time.Time `json:"created_at"`
+	Size         int64     `json:"size"`
+}
+
+// Table is the export of a table
+type Table struct {
+	Name       string    `json:"name"`
+	ExportArn  string    `json:"export_arn"`
+	ExportTime time.Time `json:"export_time"`
+	// DataPrefix is the key prefix of the exported data files, which
+	// ImportTable reads
+	DataPrefix string `json:"data_prefix"`
+	Items      int64  `json:"items"`
+	Size       int64  `json:"size"`
+	// Definition recreates the table on restore
+	Definition tableCreation `json:"definition"`
+}
+
+// tableCreation is the table ImportTable creates. ImportTable cannot
+// create local secondary indexes, so they are not kept.
+type tableCreation struct {
+	TableName              string
+	AttributeDefinitions   []attributeDefinition
+	KeySchema              []keyElement
+	BillingMode            string
+	ProvisionedThroughput  *throughput       `json:",omitempty"`
+	GlobalSecondaryIndexes []secondaryIndex  `json:",omitempty"`
+	SSESpecification       *sseSpecification `json:",omitempty"`
+}
+
+// sseSpecification is the encryption of a table with a KMS key
+type sseSpecification struct {
+	Enabled        bool
+	SSEType        string
+	KMSMasterKeyId string `json:",omitempty"`
+}
+
+// definition returns how to recreate the table t describes
+func definition(t *tableDescription) tableCreation {
+	c := tableCreation{
+		TableName:   t.TableName,
+		KeySchema:   t.KeySchema,
+		BillingMode: "PROVISIONED",
+	}
+	if t.BillingModeSummary != nil && t.BillingModeSummary.BillingMode != "" {
+		c.BillingMode = t.BillingModeSummary.BillingMode
+	}
+	provisioned := c.BillingMode == "PROVISIONED"
+	if provisioned && t.ProvisionedThroughput != nil {
+		c.ProvisionedThroughput = &throughput{
+			ReadCapacityUnits:  t.ProvisionedThroughput.ReadCapacityUnits,
+			WriteCapacityUnits: t.ProvisionedThroughput.WriteCapacityUnits,
+		}
+	}
+
+	// Only the attributes of the keys kept may be defined
+	used := map[string]bool{}
+	for _, k := range t.KeySchema {
+		used[k.AttributeName] = true
+	}
+	for _, index := range t.GlobalSecondaryIndexes {
+		if !provisioned {
+			index.ProvisionedThroughput = nil
+		} else if index.ProvisionedThroughput != nil {
+			index.ProvisionedThroughput = &throughput{
+				ReadCapacityUnits:  index.ProvisionedThroughput.ReadCapacityUnits,
+				WriteCapacityUnits: index.ProvisionedThroughput.WriteCapacityUnits,
+			}
+		}
+		c.GlobalSecondaryIndexes = append(c.GlobalSecondaryIndexes, index)
+		for _, k := range index.KeySchema {
+			used[k.AttributeName] = true
+		}
+	}
+	for _, a := range t.AttributeDefinitions {
+		if used[a.AttributeName] {
+			c.AttributeDefinitions = append(c.AttributeDefinitions, a)
+		}
+	}
+
+	if sse := t.SSEDescription; sse != nil && sse.SSEType == "KMS" {
+		c.SSESpecification = &sseSpecification{Enabled: true, SSEType: "KMS", KMSMasterKeyId: sse.KMSMasterKeyArn}
+	}
+	return c
+}
+
+// dataPrefix returns the key prefix of the data files of the export whose
+// manifest-summary.json is at manifestKey
+func dataPrefix(manifestKey string) string {
+	return path.Dir(manifestKey) + "/data/"
+}
+
+// writeManifest writes m as indented JSON
+func writeManifest(w io.Writer, m *Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// readManifest reads a manifest, refusing other artifacts
+func readManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(r, 1<<20)).Decode(&m); err != nil || m.Format != ManifestFormat {
+		return nil, errors.New("not a DynamoDB export manifest")
+	}
+	if m.Bucket == "" || len(m.Tables) == 0 {
+		return nil, errors.New("the manifest names no export")
+	}
+	for _, t := range m.Tables {
+		if t.Name == "" || t.DataPrefix == "" || len(t.Definition.KeySchema) == 0 {
+			return nil, errors.New("the manifest names no export")
+		}
+	}
+	return &m, nil
+}
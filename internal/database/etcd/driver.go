@@ -0,0 +1,317 @@
+// Package etcd provides the etcd database driver, for the cluster state of
+// Kubernetes and other etcd users. Backups are snapshots taken with the
+// clientv3 Maintenance.Snapshot RPC, served as JSON by etcd's gRPC gateway
+// on the client port (2379), so no etcd tooling is needed to take them.
+// A snapshot holds the whole keyspace of the member it is read from,
+// followed by a SHA-256 of it that is checked after every backup and
+// before every restore.
+//
+// TLS follows ssl_mode, with the ssl_ca, ssl_cert and ssl_key
+// certificates; a username logs in with etcd authentication.
+//
+// Restoring a snapshot creates the data directory of a new member with
+// etcdutl snapshot restore (etcdctl before etcd 3.5), in the restore_dir
+// restore metadata; name, initial_cluster, initial_cluster_token and
+// initial_advertise_peer_urls are passed on from the metadata too. Running
+// members are never touched: start etcd on the new data directory, with
+// --initial-cluster-state new, once every member is restored.
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// memberFlags are the restore metadata passed on to etcdutl as flags
+var memberFlags = []string{"name", "initial_cluster", "initial_cluster_token", "initial_advertise_peer_urls"}
+
+// EtcdDriver implements the database.Driver interface for etcd
+type EtcdDriver struct {
+	client *client
+	config *database.ConnectionConfig
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeEtcd, func() database.Driver {
+		return NewEtcdDriver()
+	})
+}
+
+// NewEtcdDriver creates a new etcd driver instance
+func NewEtcdDriver() *EtcdDriver {
+	return &EtcdDriver{}
+}
+
+// Connect logs in to the member and checks it answers
+func (d *EtcdDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	c, err := newClient(config)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if config.Username != "" {
+		if err := c.authenticate(ctx, config.Username, config.Password); err != nil {
+			return pkgErrors.ErrDatabaseConnection(err)
+		}
+	}
+	if _, err := c.status(ctx); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.client = c
+	d.config = config
+	return nil
+}
+
+// Disconnect releases idle connections
+func (d *EtcdDriver) Disconnect() error {
+	if d.client != nil {
+		d.client.hc.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Ping tests the connection
+func (d *EtcdDriver) Ping(ctx context.Context) error {
+	if d.client == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	_, err := d.client.status(ctx)
+	return err
+}
+
+// Backup writes a snapshot of the keyspace to opts.OutputPath and verifies
+// its hash
+func (d *EtcdDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	keys, _ := d.client.count(ctx)
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	verifier := newHashVerifier()
+	revision, version, err := d.client.snapshot(ctx, io.MultiWriter(output, verifier))
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := verifier.Verify(); err != nil {
+		return fail(err)
+	}
+
+	if version == "" {
+		version, _ = d.GetVersion(ctx)
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = version
+	result.Size = output.Written()
+	result.Checksum = output.Sum()
+	result.Tables = []database.TableInfo{{Name: "keys", RowCount: keys, DataSize: result.Size}}
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"etcd_revision": strconv.FormatInt(revision, 10)})
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// StreamBackup streams a snapshot to writer, failing at the end when its
+// hash does not match
+func (d *EtcdDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	verifier := newHashVerifier()
+	if _, _, err := d.client.snapshot(ctx, io.MultiWriter(writer, verifier)); err != nil {
+		return err
+	}
+	return verifier.Verify()
+}
+
+// GetBackupSize returns the size of the member's backend database, which
+// is the size of a snapshot
+func (d *EtcdDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	s, err := d.client.status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(s.DBSize), nil
+}
+
+// Restore creates a member data directory from a snapshot
+func (d *EtcdDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+	if err := d.restore(ctx, opts, opts.SourceBackup); err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+	return result, nil
+}
+
+// StreamRestore creates a member data directory from a snapshot read from
+// reader. The restore tools read files, so it is spooled next to the data
+// directory first.
+func (d *EtcdDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	if err := checkRestore(opts); err != nil {
+		return err
+	}
+	dataDir := filepath.Clean(opts.Metadata["restore_dir"])
+	tmp, err := os.CreateTemp(filepath.Dir(dataDir), ".etcd-snapshot-*.db")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, reader)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return d.restore(ctx, opts, tmp.Name())
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *EtcdDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	if _, err := os.Stat(opts.SourceBackup); os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err := checkRestore(opts); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if err := verifySnapshot(opts.SourceBackup); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if _, err := restoreTool(); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	return nil
+}
+
+// GetDatabases returns nothing: etcd has a single keyspace
+func (d *EtcdDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+// GetTables returns nothing: etcd stores keys, not tables
+func (d *EtcdDriver) GetTables(ctx context.Context, database string) ([]string, error) {
+	return nil, nil
+}
+
+// GetTableSize is not supported, etcd has no tables
+func (d *EtcdDriver) GetTableSize(ctx context.Context, database, table string) (int64, error) {
+	return 0, pkgErrors.New(pkgErrors.ErrorTypeDatabase, "etcd has no tables")
+}
+
+// GetVersion returns the etcd version of the member
+func (d *EtcdDriver) GetVersion(ctx context.Context) (string, error) {
+	s, err := d.client.status(ctx)
+	if err != nil {
+		return "", err
+	}
+	return s.Version, nil
+}
+
+// GetType returns the database type
+func (d *EtcdDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeEtcd
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *EtcdDriver) SupportsIncremental() bool {
+	return false // snapshots are always full
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *EtcdDriver) SupportsPITR() bool {
+	return false
+}
+
+// restore verifies the snapshot at path and restores it into the data
+// directory in the restore_dir metadata
+func (d *EtcdDriver) restore(ctx context.Context, opts *database.RestoreOptions, path string) error {
+	if err := checkRestore(opts); err != nil {
+		return err
+	}
+	if err := verifySnapshot(path); err != nil {
+		return err
+	}
+	tool, err := restoreTool()
+	if err != nil {
+		return err
+	}
+	args := []string{"snapshot", "restore", path, "--data-dir", opts.Metadata["restore_dir"]}
+	for _, key := range memberFlags {
+		if v := opts.Metadata[key]; v != "" {
+			// e.g. initial_cluster as --initial-cluster
+			args = append(args, "--"+strings.ReplaceAll(key, "_", "-"), v)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Env = append(os.Environ(), "ETCDCTL_API=3")
+	run := telemetry.StartCommand(ctx, cmd)
+	output, err := cmd.CombinedOutput()
+	run.End(err, -1)
+	if err != nil {
+		return fmt.Errorf("%s snapshot restore failed: %w: %s", tool, err, output)
+	}
+	return nil
+}
+
+// checkRestore checks opts can be honored by restoring a snapshot
+func checkRestore(opts *database.RestoreOptions) error {
+	dir := opts.Metadata["restore_dir"]
+	switch {
+	case dir == "":
+		return errors.New("snapshots are restored into a new data directory: set the restore_dir metadata")
+	case len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0:
+		return errors.New("snapshots restore the whole keyspace, keys cannot be chosen")
+	case opts.PointInTime != nil:
+		return errors.New("point-in-time restores are not supported for etcd")
+	case opts.Renamed():
+		return fmt.Errorf("snapshots restore the whole keyspace: %w", database.ErrRenameUnsupported)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("restore_dir %s already exists, etcd restores into a new data directory", dir)
+	}
+	return nil
+}
+
+// restoreTool returns etcdutl, or etcdctl on releases before 3.5
+func restoreTool() (string, error) {
+	for _, tool := range []string{"etcdutl", "etcdctl"} {
+		if path, err := exec.LookPath(tool); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("etcdutl is not installed")
+}
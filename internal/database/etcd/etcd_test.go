@@ -0,0 +1,232 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// testDB is a bolt database as far as the checks go: a page header and
+// the meta magic
+func testDB(size int) []byte {
+	db := make([]byte, size)
+	copy(db[16:], boltMagic)
+	for i := 20; i < size; i++ {
+		db[i] = byte(i)
+	}
+	return db
+}
+
+// snapshotOf is db as Maintenance.Snapshot sends it
+func snapshotOf(db []byte) []byte {
+	sum := sha256.Sum256(db)
+	return append(append([]byte(nil), db...), sum[:]...)
+}
+
+// fakeGateway answers the RPCs the driver calls, sending snapshot in
+// chunks of chunk bytes
+func fakeGateway(t *testing.T, snapshot []byte, chunk int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/auth/authenticate" && r.Header.Get("Authorization") != "token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"etcdserver: invalid auth token","code":16,"message":"etcdserver: invalid auth token"}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/v3/auth/authenticate":
+			var req map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req["name"] != "root" || req["password"] != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"etcdserver: authentication failed, invalid user ID or password","code":3,"message":"etcdserver: authentication failed, invalid user ID or password"}`)
+				return
+			}
+			fmt.Fprint(w, `{"header":{"revision":"42"},"token":"token-1"}`)
+		case "/v3/maintenance/status":
+			fmt.Fprintf(w, `{"version":"3.5.12","db_size":"%d"}`, len(snapshot))
+		case "/v3/kv/range":
+			var req struct {
+				Key       []byte `json:"key"`
+				RangeEnd  []byte `json:"range_end"`
+				CountOnly bool   `json:"count_only"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if !bytes.Equal(req.Key, []byte{0}) || !bytes.Equal(req.RangeEnd, []byte{0}) || !req.CountOnly {
+				t.Errorf("unexpected range %+v", req)
+			}
+			fmt.Fprint(w, `{"header":{"revision":"42"},"count":"7"}`)
+		case "/v3/maintenance/snapshot":
+			enc := json.NewEncoder(w)
+			for off := 0; off < len(snapshot); off += chunk {
+				end := min(off+chunk, len(snapshot))
+				result := map[string]any{
+					"header":          map[string]string{"revision": "42"},
+					"remaining_bytes": strconv.Itoa(len(snapshot) - end),
+					"blob":            snapshot[off:end],
+				}
+				if end == len(snapshot) {
+					result["version"] = "3.5.0"
+				}
+				_ = enc.Encode(map[string]any{"result": result})
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func connect(t *testing.T, srv *httptest.Server, password string) (*EtcdDriver, error) {
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	p, _ := strconv.Atoi(port)
+	d := NewEtcdDriver()
+	return d, d.Connect(context.Background(), &database.ConnectionConfig{
+		Host: host, Port: p, Username: "root", Password: password,
+	})
+}
+
+func TestBackup(t *testing.T) {
+	snapshot := snapshotOf(testDB(10000))
+	d, err := connect(t, fakeGateway(t, snapshot, 4096), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "etcd.db")
+	result, err := d.Backup(context.Background(), &database.BackupOptions{OutputPath: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, snapshot) {
+		t.Error("the backup is not the snapshot")
+	}
+	if result.Size != int64(len(snapshot)) || result.DatabaseVersion != "3.5.0" || result.Metadata["etcd_revision"] != "42" {
+		t.Errorf("got %+v", result)
+	}
+	if len(result.Tables) != 1 || result.Tables[0].RowCount != 7 {
+		t.Errorf("tables: got %+v", result.Tables)
+	}
+	if err := verifySnapshot(out); err != nil {
+		t.Error(err)
+	}
+
+	size, err := d.GetBackupSize(context.Background(), &database.BackupOptions{})
+	if err != nil || size != int64(len(snapshot)) {
+		t.Errorf("size: got %d, %v", size, err)
+	}
+}
+
+func TestBackupRejectsCorruptSnapshot(t *testing.T) {
+	snapshot := snapshotOf(testDB(5000))
+	snapshot[100] ^= 0xff
+	d, err := connect(t, fakeGateway(t, snapshot, 1000), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = d.StreamBackup(context.Background(), &database.BackupOptions{}, &buf)
+	if err == nil || !strings.Contains(err.Error(), "hash does not match") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestConnectWrongPassword(t *testing.T) {
+	_, err := connect(t, fakeGateway(t, nil, 1), "wrong")
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestHashVerifier(t *testing.T) {
+	db := testDB(300)
+	for _, tt := range []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"snapshot", snapshotOf(db), true},
+		{"no hash", db, false},
+		{"not bolt", snapshotOf(make([]byte, 300)), false},
+		{"short", []byte("etcd"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Odd write sizes cross the hash boundary
+			v := newHashVerifier()
+			for data := tt.data; len(data) > 0; {
+				n := min(7, len(data))
+				v.Write(data[:n])
+				data = data[n:]
+			}
+			if err := v.Verify(); (err == nil) != tt.ok {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake etcdutl is a shell script")
+	}
+	bin := t.TempDir()
+	log := filepath.Join(bin, "etcdutl.log")
+	script := "#!/bin/sh\necho \"$@\" > " + log + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "etcdutl"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "snapshot.db")
+	if err := os.WriteFile(source, snapshotOf(testDB(1000)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dataDir := filepath.Join(dir, "member")
+	opts := &database.RestoreOptions{SourceBackup: source, Metadata: map[string]string{
+		"restore_dir":     dataDir,
+		"name":            "etcd-0",
+		"initial_cluster": "etcd-0=https://10.0.0.1:2380",
+	}}
+
+	d := NewEtcdDriver()
+	if err := d.ValidateRestore(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Restore(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	args, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "snapshot restore " + source + " --data-dir " + dataDir + " --name etcd-0 --initial-cluster etcd-0=https://10.0.0.1:2380\n"
+	if string(args) != want {
+		t.Errorf("got %q, want %q", args, want)
+	}
+
+	// The data directory must be new
+	if err := os.Mkdir(dataDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ValidateRestore(context.Background(), opts); err == nil {
+		t.Error("expected an error for an existing data directory")
+	}
+}
@@ -0,0 +1,210 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// client calls the clientv3 API through etcd's gRPC gateway, which serves
+// every RPC as JSON on the client port under /v3/
+type client struct {
+	base  string
+	token string // from Auth.Authenticate, when authentication is on
+	hc    *http.Client
+}
+
+// newClient builds a client of the member in config
+func newClient(config *database.ConnectionConfig) (*client, error) {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := config.ClientTLS()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		scheme, transport.TLSClientConfig = "https", tlsConfig
+	}
+	timeout := config.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	transport.DialContext = (&net.Dialer{Timeout: timeout}).DialContext
+	return &client{
+		base: scheme + "://" + net.JoinHostPort(config.Host, strconv.Itoa(config.Port)) + "/v3/",
+		hc:   &http.Client{Transport: transport},
+	}, nil
+}
+
+// authenticate exchanges the user's password for the token sent with
+// every later call
+func (c *client) authenticate(ctx context.Context, username, password string) error {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.call(ctx, "auth/authenticate", map[string]string{"name": username, "password": password}, &resp); err != nil {
+		return err
+	}
+	c.token = resp.Token
+	return nil
+}
+
+// status is the answer of Maintenance.Status
+type status struct {
+	Version string `json:"version"`
+	DBSize  int64s `json:"db_size"`
+}
+
+// status returns the state of the member
+func (c *client) status(ctx context.Context) (*status, error) {
+	var resp status
+	if err := c.call(ctx, "maintenance/status", struct{}{}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// count returns the number of keys in the keyspace
+func (c *client) count(ctx context.Context) (int64, error) {
+	// From "\x00" to "\x00" is every key
+	req := map[string]any{"key": []byte{0}, "range_end": []byte{0}, "count_only": true}
+	var resp struct {
+		Count int64s `json:"count"`
+	}
+	if err := c.call(ctx, "kv/range", req, &resp); err != nil {
+		return 0, err
+	}
+	return int64(resp.Count), nil
+}
+
+// snapshotHeader describes the snapshot being sent
+type snapshotHeader struct {
+	Revision int64s `json:"revision"`
+}
+
+// snapshot streams a snapshot of the member's backend to w, as
+// Maintenance.Snapshot sends it: the bolt database followed by its SHA-256.
+// It returns the revision of the snapshot and the version of the member.
+func (c *client) snapshot(ctx context.Context, w io.Writer) (revision int64, version string, err error) {
+	resp, err := c.post(ctx, "maintenance/snapshot", struct{}{})
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	// Each message is one {"result": ...} or {"error": ...} object
+	dec := json.NewDecoder(resp.Body)
+	done := false
+	for {
+		var msg struct {
+			Result *struct {
+				Header         *snapshotHeader `json:"header"`
+				RemainingBytes int64s          `json:"remaining_bytes"`
+				Blob           []byte          `json:"blob"`
+				Version        string          `json:"version"`
+			} `json:"result"`
+			Error *gatewayError `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, "", fmt.Errorf("invalid snapshot stream: %w", err)
+		}
+		if msg.Error != nil {
+			return 0, "", msg.Error
+		}
+		if msg.Result == nil {
+			continue
+		}
+		r := msg.Result
+		if r.Header != nil && revision == 0 {
+			revision = int64(r.Header.Revision)
+		}
+		if r.Version != "" {
+			version = r.Version
+		}
+		if _, err := w.Write(r.Blob); err != nil {
+			return 0, "", err
+		}
+		done = r.RemainingBytes == 0
+	}
+	if !done {
+		return 0, "", errors.New("the snapshot stream ended early")
+	}
+	return revision, version, nil
+}
+
+// call posts req to an RPC and decodes its answer into resp
+func (c *client) call(ctx context.Context, rpc string, req, resp any) error {
+	r, err := c.post(ctx, rpc, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("invalid %s response: %w", rpc, err)
+	}
+	return nil
+}
+
+// post sends req to an RPC, returning the response when it succeeded
+func (c *client) post(ctx context.Context, rpc string, req any) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+rpc, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", c.token)
+	}
+	resp, err := c.hc.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		var gwErr gatewayError
+		if json.Unmarshal(data, &gwErr) == nil && gwErr.Message != "" {
+			return nil, &gwErr
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+// gatewayError is a failed RPC, e.g. "etcdserver: user name is empty"
+type gatewayError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+func (e *gatewayError) Error() string {
+	return e.Message
+}
+
+// int64s decodes 64-bit integers, which the gateway sends as strings
+type int64s int64
+
+func (n *int64s) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = int64s(v)
+	return nil
+}
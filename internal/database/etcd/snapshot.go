@@ -0,0 +1,65 @@
+package etcd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"os"
+)
+
+// boltMagic is the magic number of bolt databases, in the meta page right
+// after the 16-byte page header, little-endian
+var boltMagic = []byte{0xed, 0xda, 0x0c, 0xed}
+
+// hashVerifier checks the SHA-256 etcd appends to a snapshot, the last
+// sha256.Size bytes, against the database written before it
+type hashVerifier struct {
+	h    hash.Hash
+	tail []byte // the last bytes seen, possibly the hash
+	head []byte // the first bytes, for the bolt magic
+	n    int64
+}
+
+func newHashVerifier() *hashVerifier {
+	return &hashVerifier{h: sha256.New()}
+}
+
+func (v *hashVerifier) Write(p []byte) (int, error) {
+	if len(v.head) < 20 {
+		v.head = append(v.head, p[:min(len(p), 20-len(v.head))]...)
+	}
+	v.n += int64(len(p))
+	v.tail = append(v.tail, p...)
+	if extra := len(v.tail) - sha256.Size; extra > 0 {
+		v.h.Write(v.tail[:extra])
+		v.tail = append(v.tail[:0], v.tail[extra:]...)
+	}
+	return len(p), nil
+}
+
+// Verify checks everything written is a bolt database followed by its hash
+func (v *hashVerifier) Verify() error {
+	if v.n <= sha256.Size || len(v.head) < 20 || !bytes.Equal(v.head[16:20], boltMagic) {
+		return errors.New("not an etcd snapshot")
+	}
+	if !bytes.Equal(v.h.Sum(nil), v.tail) {
+		return errors.New("the snapshot hash does not match its contents, it is corrupt or was not taken with the snapshot API")
+	}
+	return nil
+}
+
+// verifySnapshot checks the snapshot at path
+func verifySnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	v := newHashVerifier()
+	if _, err := io.Copy(v, f); err != nil {
+		return err
+	}
+	return v.Verify()
+}
@@ -35,3 +35,11 @@ func CreateDriver(dbType DatabaseType) (Driver, error) {
 
 	return factory(), nil
 }
+
+// IsRegistered reports whether a driver is registered for a database type
+func IsRegistered(dbType DatabaseType) bool {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	_, ok := driverRegistry[dbType]
+	return ok
+}
@@ -0,0 +1,105 @@
+package filedb
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// ManifestFormat identifies the archives written by this driver
+const ManifestFormat = "filedb-snapshot/v1"
+
+const snapshotDir = "snapshot/"
+
+// Manifest describes an archive. It is its first entry, so restores know
+// what they are restoring before any data.
+type Manifest struct {
+	Format    string    `json:"format"`
+	Type      string    `json:"type"`   // database type
+	Method    string    `json:"method"` // how the engine took the snapshot
+	Source    string    `json:"source"` // name of the database file
+	Version   string    `json:"version,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// writeArchive writes the manifest, then every file under dir, to w
+func writeArchive(w io.Writer, m *Manifest, dir string) error {
+	tw := tar.NewWriter(w)
+	if err := database.WriteManifest(tw, m); err != nil {
+		return err
+	}
+
+	err := filepath.WalkDir(dir, func(file string, e fs.DirEntry, err error) error {
+		if err != nil || !e.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		return writeFile(tw, snapshotDir+filepath.ToSlash(rel), file)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeFile writes file as the entry name
+func writeFile(tw *tar.Writer, name, file string) error {
+	f, err := os.Open(file) // #nosec G304 -- spool file of this backup
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(header(name, info.Size())); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func header(name string, size int64) *tar.Header {
+	return &tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+}
+
+// extractArchive writes the snapshot files read from tr under dir
+func extractArchive(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, ok := strings.CutPrefix(hdr.Name, snapshotDir)
+		if !ok || rel == "" || !fs.ValidPath(rel) || path.Clean(rel) != rel {
+			return fmt.Errorf("unexpected archive entry %s", hdr.Name)
+		}
+		file := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return err
+		}
+		if err := database.ExtractFile(file, tr, 0o600); err != nil {
+			return err
+		}
+	}
+}
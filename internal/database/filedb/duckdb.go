@@ -0,0 +1,191 @@
+package filedb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+)
+
+// Snapshot methods of DuckDB, chosen with the snapshot_method option
+const (
+	// MethodExport runs EXPORT DATABASE: the schema and the data as
+	// Parquet (or CSV, with the export_format option), which any later
+	// DuckDB imports. The default.
+	MethodExport = "export"
+	// MethodCopy runs COPY FROM DATABASE into a new database file,
+	// quicker to restore but only readable by the same storage version
+	MethodCopy = "copy"
+)
+
+const (
+	exportDir    = "export"
+	snapshotFile = "database.duckdb"
+)
+
+// DuckDB is the Engine of DuckDB database files, driven through the
+// duckdb CLI. The file is attached read-only to an in-memory database and
+// snapshotted in one statement, so the snapshot is consistent. DuckDB
+// refuses to attach a file another process holds open for writing; the
+// backup then fails rather than read a file mid-write.
+type DuckDB struct{}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeDuckDB, func() database.Driver {
+		return NewDriver(DuckDB{})
+	})
+}
+
+// Type returns the DuckDB database type
+func (DuckDB) Type() database.DatabaseType {
+	return database.DatabaseTypeDuckDB
+}
+
+// Version returns the version of the duckdb CLI
+func (DuckDB) Version(ctx context.Context) (string, error) {
+	tool, err := duckdbTool()
+	if err != nil {
+		return "", err
+	}
+	output, err := exec.CommandContext(ctx, tool, "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("duckdb -version failed: %w", err)
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(output)), " ")
+	return version, nil
+}
+
+// Tables returns the base tables of the database, schema-qualified
+// outside the main schema
+func (DuckDB) Tables(ctx context.Context, path string) ([]string, error) {
+	output, err := runDuckDB(ctx, "", attach(path, "db", true)+
+		"SELECT CASE WHEN table_schema = 'main' THEN table_name ELSE table_schema || '.' || table_name END "+
+		"FROM information_schema.tables WHERE table_catalog = 'db' AND table_type = 'BASE TABLE' ORDER BY 1;")
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			tables = append(tables, line)
+		}
+	}
+	return tables, nil
+}
+
+// Snapshot exports the database, or copies it into a new file
+func (DuckDB) Snapshot(ctx context.Context, path, dir string, options map[string]string) (string, error) {
+	method := options["snapshot_method"]
+	switch method {
+	case "", MethodExport:
+		format := strings.ToUpper(options["export_format"])
+		switch format {
+		case "":
+			format = "PARQUET"
+		case "PARQUET", "CSV":
+		default:
+			return "", fmt.Errorf("export_format %q is not parquet or csv", options["export_format"])
+		}
+		// A relative export directory keeps load.sql's paths valid
+		// wherever the export is imported from
+		_, err := runDuckDB(ctx, dir, attach(path, "src", true)+"USE src; EXPORT DATABASE "+quote(exportDir)+" (FORMAT "+format+");")
+		return MethodExport, err
+	case MethodCopy:
+		_, err := runDuckDB(ctx, dir, attach(path, "src", true)+attach(filepath.Join(dir, snapshotFile), "snapshot", false)+
+			"COPY FROM DATABASE src TO snapshot;")
+		return MethodCopy, err
+	}
+	return "", fmt.Errorf("snapshot_method %q is not export or copy", method)
+}
+
+// Restore imports an export into target, or copies the snapshot file
+func (DuckDB) Restore(ctx context.Context, method, dir, target string) error {
+	switch method {
+	case MethodExport:
+		_, err := runDuckDB(ctx, dir, attach(target, "restored", false)+"USE restored; IMPORT DATABASE "+quote(exportDir)+";")
+		return err
+	case MethodCopy:
+		return copyFile(filepath.Join(dir, snapshotFile), target)
+	}
+	return fmt.Errorf("unsupported snapshot method %q", method)
+}
+
+// Companions returns the write-ahead log
+func (DuckDB) Companions(path string) []string {
+	return []string{path + ".wal"}
+}
+
+// duckdbTool returns the path of the duckdb CLI
+func duckdbTool() (string, error) {
+	path, err := exec.LookPath("duckdb")
+	if err != nil {
+		return "", errors.New("duckdb is not installed")
+	}
+	return path, nil
+}
+
+// runDuckDB runs sql in an in-memory duckdb in dir and returns its output,
+// one row per line
+func runDuckDB(ctx context.Context, dir, sql string) (string, error) {
+	tool, err := duckdbTool()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, tool, "-batch", "-noheader", "-list", "-c", sql)
+	cmd.Dir = dir
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	run := telemetry.StartCommand(ctx, cmd)
+	output, err := cmd.Output()
+	run.End(err, -1)
+	if err != nil {
+		return "", fmt.Errorf("duckdb failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
+
+// attach returns the statement attaching the database at path as name
+func attach(path, name string, readOnly bool) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	statement := "ATTACH " + quote(path) + " AS " + name
+	if readOnly {
+		statement += " (READ_ONLY)"
+	}
+	return statement + "; "
+}
+
+// quote quotes a string literal
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// copyFile copies src to the new file dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src) // #nosec G304 -- spool file of this restore
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
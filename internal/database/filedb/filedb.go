@@ -0,0 +1,349 @@
+// Package filedb provides a driver for embedded databases kept in a file,
+// such as DuckDB, on top of an Engine that knows the database's format.
+// Live files are never copied as they are: the engine takes an
+// online-safe snapshot with the database's own tooling (EXPORT DATABASE
+// and COPY FROM DATABASE for DuckDB), consistent even while the file is
+// written to.
+//
+// A backup is a tar archive: a manifest, then the files of the snapshot.
+// Restores rebuild the database next to the target file and move it into
+// place once complete, so a failed restore leaves the target untouched.
+package filedb
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Engine takes and restores snapshots of one kind of database file
+type Engine interface {
+	// Type returns the database type the engine serves
+	Type() database.DatabaseType
+	// Version returns the version of the engine's tooling
+	Version(ctx context.Context) (string, error)
+	// Tables returns the tables of the database at path
+	Tables(ctx context.Context, path string) ([]string, error)
+	// Snapshot writes a consistent copy of the database at path into
+	// the empty directory dir and returns the method it used, which
+	// Restore is given back
+	Snapshot(ctx context.Context, path, dir string, options map[string]string) (string, error)
+	// Restore builds the database file target, which does not exist,
+	// from a snapshot taken with method in dir
+	Restore(ctx context.Context, method, dir, target string) error
+	// Companions returns the files kept next to the database at path,
+	// e.g. its write-ahead log
+	Companions(path string) []string
+}
+
+// Driver implements the database.Driver interface for the files of an
+// Engine. The connection's database is the path of the file.
+type Driver struct {
+	engine Engine
+	config *database.ConnectionConfig
+}
+
+// NewDriver creates a driver for the files of engine
+func NewDriver(engine Engine) *Driver {
+	return &Driver{engine: engine}
+}
+
+// Connect checks the database file exists and the engine's tooling is
+// installed
+func (d *Driver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	if config.Database == "" {
+		return pkgErrors.ErrDatabaseConnection(errors.New("the database file path is required"))
+	}
+	if _, err := os.Stat(config.Database); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if _, err := d.engine.Version(ctx); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.config = config
+	return nil
+}
+
+// Disconnect does nothing: the file is only opened by the engine's tools
+func (d *Driver) Disconnect() error {
+	return nil
+}
+
+// Ping checks the database file is still there
+func (d *Driver) Ping(ctx context.Context) error {
+	if d.config == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	_, err := os.Stat(d.config.Database)
+	return err
+}
+
+// Backup writes an archive of a snapshot of the database to
+// opts.OutputPath
+func (d *Driver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	m, err := d.backup(ctx, opts, output)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	tables, err := d.engine.Tables(ctx, d.config.Database)
+	if err != nil {
+		return fail(err)
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = m.Version
+	result.Size = output.Written()
+	result.Checksum = output.Sum()
+	for _, t := range tables {
+		result.Tables = append(result.Tables, database.TableInfo{Name: t})
+	}
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"snapshot_method": m.Method})
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// StreamBackup streams an archive of a snapshot of the database to writer
+func (d *Driver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	_, err := d.backup(ctx, opts, writer)
+	return err
+}
+
+// backup snapshots the database into a spool directory and writes the
+// archive of the snapshot to w
+func (d *Driver) backup(ctx context.Context, opts *database.BackupOptions, w io.Writer) (*Manifest, error) {
+	if len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0 {
+		return nil, fmt.Errorf("%s backups hold the whole database file, tables cannot be chosen", d.engine.Type())
+	}
+	version, err := d.engine.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	spool, err := os.MkdirTemp("", string(d.engine.Type())+"-backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(spool)
+	method, err := d.engine.Snapshot(ctx, d.config.Database, spool, d.config.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Format:    ManifestFormat,
+		Type:      string(d.engine.Type()),
+		Method:    method,
+		Source:    filepath.Base(d.config.Database),
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := writeArchive(w, m, spool); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetBackupSize returns the size of the database file and its companions
+func (d *Driver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	var total int64
+	for _, path := range append([]string{d.config.Database}, d.engine.Companions(d.config.Database)...) {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) && path != d.config.Database {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// Restore rebuilds the database file from an archive
+func (d *Driver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+	fail := func(err error) (*database.RestoreResult, error) {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	target, err := d.restore(ctx, opts, f)
+	if err != nil {
+		return fail(err)
+	}
+	result.RestoredTables, _ = d.engine.Tables(ctx, target)
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+	return result, nil
+}
+
+// StreamRestore rebuilds the database file from an archive read from
+// reader
+func (d *Driver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	_, err := d.restore(ctx, opts, reader)
+	return err
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *Driver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer f.Close()
+	m, err := database.ReadManifest[Manifest](tar.NewReader(f), ManifestFormat, nil)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if _, err := d.checkRestore(m, opts); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	return nil
+}
+
+// checkRestore checks the archive can be restored with opts and returns
+// the file to restore: the restore database, else the connection's
+func (d *Driver) checkRestore(m *Manifest, opts *database.RestoreOptions) (string, error) {
+	switch {
+	case m.Type != string(d.engine.Type()):
+		return "", fmt.Errorf("the backup is of a %s database, not %s", m.Type, d.engine.Type())
+	case len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0:
+		return "", errors.New("backups restore the whole database file, tables cannot be chosen")
+	case opts.PointInTime != nil:
+		return "", fmt.Errorf("point-in-time restores are not supported for %s", d.engine.Type())
+	}
+	target := opts.Target()
+	if target == "" {
+		target = d.config.Database
+	}
+	if _, err := os.Stat(target); err == nil && !opts.DropExisting {
+		return "", fmt.Errorf("database file %s already exists, use --drop-existing to replace it", target)
+	}
+	return target, nil
+}
+
+// restore extracts the snapshot from r, has the engine build the database
+// beside the target, then moves it over the target and drops the old
+// file's companions
+func (d *Driver) restore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) (string, error) {
+	tr := tar.NewReader(r)
+	m, err := database.ReadManifest[Manifest](tr, ManifestFormat, nil)
+	if err != nil {
+		return "", err
+	}
+	target, err := d.checkRestore(m, opts)
+	if err != nil {
+		return "", err
+	}
+	spool, err := os.MkdirTemp("", string(d.engine.Type())+"-restore-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(spool)
+	if err := extractArchive(tr, spool); err != nil {
+		return "", err
+	}
+
+	// Built in the target's directory, so the final rename stays on one
+	// file system
+	staging, err := os.MkdirTemp(filepath.Dir(target), ".restore-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+	built := filepath.Join(staging, filepath.Base(target))
+	if err := d.engine.Restore(ctx, m.Method, spool, built); err != nil {
+		return "", err
+	}
+	for _, companion := range d.engine.Companions(target) {
+		if err := os.Remove(companion); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	if err := os.Rename(built, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// GetDatabases returns the database file
+func (d *Driver) GetDatabases(ctx context.Context) ([]string, error) {
+	return []string{d.config.Database}, nil
+}
+
+// GetTables returns the tables of the database file
+func (d *Driver) GetTables(ctx context.Context, database string) ([]string, error) {
+	if database == "" {
+		database = d.config.Database
+	}
+	return d.engine.Tables(ctx, database)
+}
+
+// GetTableSize is not supported, tables share the database file
+func (d *Driver) GetTableSize(ctx context.Context, database, table string) (int64, error) {
+	return 0, pkgErrors.New(pkgErrors.ErrorTypeDatabase, fmt.Sprintf("%s does not size tables", d.engine.Type()))
+}
+
+// GetVersion returns the version of the engine's tooling
+func (d *Driver) GetVersion(ctx context.Context) (string, error) {
+	return d.engine.Version(ctx)
+}
+
+// GetType returns the database type
+func (d *Driver) GetType() database.DatabaseType {
+	return d.engine.Type()
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *Driver) SupportsIncremental() bool {
+	return false // every snapshot is full
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *Driver) SupportsPITR() bool {
+	return false
+}
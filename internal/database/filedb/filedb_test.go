@@ -0,0 +1,165 @@
+package filedb
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// fakeDuckDB installs a duckdb that exports two files, copies a database
+// into a file holding "copy", and imports an export by writing its schema
+// to the attached target
+func fakeDuckDB(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake duckdb is a shell script")
+	}
+	bin := t.TempDir()
+	script := `#!/bin/sh
+[ "$1" = -version ] && { echo "v1.1.3 19864453f7"; exit 0; }
+for sql; do :; done
+case $sql in
+  *information_schema*) printf 'orders\nsales.items\n' ;;
+  *"EXPORT DATABASE"*) mkdir export && echo "CREATE TABLE orders" > export/schema.sql && echo rows > export/orders.parquet ;;
+  *"COPY FROM DATABASE"*) echo copy > database.duckdb ;;
+  *"IMPORT DATABASE"*)
+    target=$(echo "$sql" | sed -n "s/.*ATTACH '\([^']*\)' AS restored.*/\1/p")
+    cat export/schema.sql > "$target" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "duckdb"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func connect(t *testing.T, options map[string]string) (*Driver, string) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.duckdb")
+	if err := os.WriteFile(path, []byte("live"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDriver(DuckDB{})
+	if err := d.Connect(context.Background(), &database.ConnectionConfig{Database: path, Options: options}); err != nil {
+		t.Fatal(err)
+	}
+	return d, path
+}
+
+// entries returns the entries of the archive at path
+func entries(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	contents := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		contents[hdr.Name] = string(data)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExportAndImport(t *testing.T) {
+	ctx := context.Background()
+	fakeDuckDB(t)
+	d, path := connect(t, nil)
+
+	out := filepath.Join(t.TempDir(), "app.tar")
+	result, err := d.Backup(ctx, &database.BackupOptions{OutputPath: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DatabaseVersion != "v1.1.3" || result.Metadata["snapshot_method"] != MethodExport || len(result.Tables) != 2 {
+		t.Errorf("got %+v", result)
+	}
+	contents := entries(t, out)
+	if contents["snapshot/export/schema.sql"] != "CREATE TABLE orders\n" || contents["snapshot/export/orders.parquet"] != "rows\n" {
+		t.Errorf("archive: got %v", contents)
+	}
+
+	// Into a new file
+	copyPath := filepath.Join(t.TempDir(), "copy.duckdb")
+	restore, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: out, Database: copyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, copyPath) != "CREATE TABLE orders\n" || strings.Join(restore.RestoredTables, ",") != "orders,sales.items" {
+		t.Errorf("restored %v", restore.RestoredTables)
+	}
+
+	// Over the live file, only with DropExisting, dropping its WAL
+	opts := &database.RestoreOptions{SourceBackup: out}
+	if err := d.ValidateRestore(ctx, opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("got %v", err)
+	}
+	if err := os.WriteFile(path+".wal", []byte("wal"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts.DropExisting = true
+	if _, err := d.Restore(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, path) != "CREATE TABLE orders\n" {
+		t.Errorf("got %q", readFile(t, path))
+	}
+	if _, err := os.Stat(path + ".wal"); !os.IsNotExist(err) {
+		t.Errorf("the WAL is still there: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("left %d files beside the database", len(entries))
+	}
+}
+
+func TestCopySnapshot(t *testing.T) {
+	ctx := context.Background()
+	fakeDuckDB(t)
+	d, _ := connect(t, map[string]string{"snapshot_method": MethodCopy})
+
+	out := filepath.Join(t.TempDir(), "app.tar")
+	if _, err := d.Backup(ctx, &database.BackupOptions{OutputPath: out}); err != nil {
+		t.Fatal(err)
+	}
+	copyPath := filepath.Join(t.TempDir(), "copy.duckdb")
+	if _, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: out, Database: copyPath}); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, copyPath) != "copy\n" {
+		t.Errorf("got %q", readFile(t, copyPath))
+	}
+}
+
+func TestRejectedOptions(t *testing.T) {
+	ctx := context.Background()
+	fakeDuckDB(t)
+	d, _ := connect(t, map[string]string{"snapshot_method": "raw"})
+	out := filepath.Join(t.TempDir(), "app.tar")
+	if _, err := d.Backup(ctx, &database.BackupOptions{OutputPath: out}); err == nil {
+		t.Error("backed up with an unknown snapshot method")
+	}
+	if _, err := d.Backup(ctx, &database.BackupOptions{OutputPath: out, Tables: []string{"orders"}}); err == nil {
+		t.Error("backed up chosen tables")
+	}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ManifestEntry is the first entry of the archives drivers write
+// themselves. It describes the archive, so restores know what they are
+// restoring before any data.
+const ManifestEntry = "manifest.json"
+
+// maxManifestSize bounds what ReadManifest reads of a manifest
+const maxManifestSize = 1 << 20
+
+// WithMetadata returns a copy of metadata with values set over it. The
+// metadata of a BackupResult belongs to the caller's options, so drivers
+// add their keys to a copy.
+func WithMetadata(metadata, values map[string]string) map[string]string {
+	merged := make(map[string]string, len(metadata)+len(values))
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	for k, v := range values {
+		merged[k] = v
+	}
+	return merged
+}
+
+// ExtractFile writes the archive entry read from r to a new file at path.
+// An existing file is never overwritten, so an entry repeated in an
+// archive fails the restore instead of replacing what was written.
+func ExtractFile(path string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteManifest writes m as JSON to tw, as the manifest entry. It is
+// written before any other entry.
+func WriteManifest(tw *tar.Writer, m any) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    ManifestEntry,
+		Mode:    0o600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// ReadManifest reads the manifest, the first entry of the archive in tr.
+// Its format field must be format; validate, when set, checks the rest.
+func ReadManifest[M any](tr *tar.Reader, format string, validate func(*M) error) (*M, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("not a %s archive: %w", format, err)
+	}
+	if hdr.Name != ManifestEntry {
+		return nil, fmt.Errorf("not a %s archive: starts with %s", format, hdr.Name)
+	}
+	data, err := io.ReadAll(io.LimitReader(tr, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if header.Format != format {
+		return nil, fmt.Errorf("unsupported backup format %q", header.Format)
+	}
+	var m M
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if validate != nil {
+		if err := validate(&m); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
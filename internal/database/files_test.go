@@ -0,0 +1,88 @@
+package database
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithMetadata(t *testing.T) {
+	metadata := map[string]string{"label": "nightly", "dump_format": "plain"}
+	got := WithMetadata(metadata, map[string]string{"dump_format": "native", "snapshot": "0000-1"})
+
+	want := map[string]string{"label": "nightly", "dump_format": "native", "snapshot": "0000-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata = %v, want %v", got, want)
+	}
+	if metadata["dump_format"] != "plain" || len(metadata) != 2 {
+		t.Errorf("caller's metadata changed: %v", metadata)
+	}
+	if got := WithMetadata(nil, map[string]string{"a": "b"}); got["a"] != "b" {
+		t.Errorf("nil metadata = %v", got)
+	}
+}
+
+func TestExtractFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry")
+	if err := ExtractFile(path, strings.NewReader("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "data" {
+		t.Errorf("extracted %q", got)
+	}
+	if err := ExtractFile(path, strings.NewReader("other"), 0o600); err == nil {
+		t.Error("overwrote an extracted file")
+	}
+}
+
+func TestManifest(t *testing.T) {
+	type manifest struct {
+		Format string `json:"format"`
+		Kind   string `json:"kind"`
+	}
+	archive := func(entry string, m any) *tar.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if entry == ManifestEntry {
+			if err := WriteManifest(tw, m); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := tw.WriteHeader(&tar.Header{Name: entry, Mode: 0o600}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return tar.NewReader(&buf)
+	}
+	validate := func(m *manifest) error {
+		if m.Kind != "full" {
+			return fmt.Errorf("unsupported backup kind %q", m.Kind)
+		}
+		return nil
+	}
+
+	m, err := ReadManifest(archive(ManifestEntry, manifest{Format: "test/v1", Kind: "full"}), "test/v1", validate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Kind != "full" {
+		t.Errorf("manifest = %+v", m)
+	}
+
+	for name, tr := range map[string]*tar.Reader{
+		"other format":  archive(ManifestEntry, manifest{Format: "test/v2", Kind: "full"}),
+		"invalid":       archive(ManifestEntry, manifest{Format: "test/v1", Kind: "partial"}),
+		"no manifest":   archive("data.bin", nil),
+		"empty archive": tar.NewReader(bytes.NewReader(nil)),
+	} {
+		if _, err := ReadManifest(tr, "test/v1", validate); err == nil {
+			t.Errorf("%s: read a manifest", name)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package influxdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// errNotFound is a 404 answer: an API the server does not have, or a
+// shard deleted since the metadata was read
+var errNotFound = errors.New("not found")
+
+// client calls the InfluxDB 2.x HTTP API with an API token
+type client struct {
+	base  string
+	token string
+	hc    *http.Client
+}
+
+// newClient builds a client of the server in config. The token is the
+// password of the connection.
+func newClient(config *database.ConnectionConfig) (*client, error) {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := config.ClientTLS()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		scheme, transport.TLSClientConfig = "https", tlsConfig
+	}
+	timeout := config.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	transport.DialContext = (&net.Dialer{Timeout: timeout}).DialContext
+	return &client{
+		base:  scheme + "://" + net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		token: config.Password,
+		hc:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// health is the answer of /health
+type health struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Version string `json:"version"`
+}
+
+// health returns the state of the server
+func (c *client) health(ctx context.Context) (*health, error) {
+	var h health
+	if err := c.getJSON(ctx, "/health", nil, &h); err != nil {
+		return nil, err
+	}
+	if h.Status != "pass" {
+		return nil, fmt.Errorf("server is not ready: %s", h.Message)
+	}
+	return &h, nil
+}
+
+// bucket is a bucket as /api/v2/buckets lists it
+type bucket struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"` // user or system
+	OrgID string `json:"orgID"`
+}
+
+// buckets returns the buckets the token can read, all of them when name
+// is empty
+func (c *client) buckets(ctx context.Context, name string) ([]bucket, error) {
+	const page = 100
+	var all []bucket
+	for offset := 0; ; offset += page {
+		q := url.Values{"limit": {strconv.Itoa(page)}, "offset": {strconv.Itoa(offset)}}
+		if name != "" {
+			q.Set("name", name)
+		}
+		var resp struct {
+			Buckets []bucket `json:"buckets"`
+		}
+		if err := c.getJSON(ctx, "/api/v2/buckets", q, &resp); err != nil {
+			return nil, err
+		}
+		all = append(all, resp.Buckets...)
+		if len(resp.Buckets) < page {
+			return all, nil
+		}
+	}
+}
+
+// deleteBucket deletes a bucket and its data
+func (c *client) deleteBucket(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/api/v2/buckets/"+url.PathEscape(id), nil, nil, "")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// orgID returns the ID of the organization called name
+func (c *client) orgID(ctx context.Context, name string) (string, error) {
+	var resp struct {
+		Orgs []struct {
+			ID string `json:"id"`
+		} `json:"orgs"`
+	}
+	if err := c.getJSON(ctx, "/api/v2/orgs", url.Values{"org": {name}}, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Orgs) == 0 {
+		return "", fmt.Errorf("no organization %q", name)
+	}
+	return resp.Orgs[0].ID, nil
+}
+
+// measurements returns the measurements of a bucket, with a Flux query
+func (c *client) measurements(ctx context.Context, orgID, bucketName string) ([]string, error) {
+	query := map[string]any{
+		"query":   fmt.Sprintf(`import "influxdata/influxdb/schema" schema.measurements(bucket: %s)`, strconv.Quote(bucketName)),
+		"type":    "flux",
+		"dialect": map[string]any{"header": true, "annotations": []string{}},
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/api/v2/query", url.Values{"orgID": {orgID}}, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Annotated CSV without annotations: a header row, then one row per
+	// measurement; several tables are separated by blank lines
+	r := csv.NewReader(resp.Body)
+	r.FieldsPerRecord = -1
+	var names []string
+	column := -1
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid query response: %w", err)
+		}
+		if column < 0 || (column < len(record) && record[column] == "_value") {
+			for i, field := range record {
+				if field == "_value" {
+					column = i
+				}
+			}
+			continue
+		}
+		if column < len(record) {
+			names = append(names, record[column])
+		}
+	}
+}
+
+// shardSizes returns the size on disk of the shards of each bucket, by
+// bucket ID, from the storage_shard_disk_size metric
+func (c *client) shardSizes(ctx context.Context) (map[string]int64, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/metrics", nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// e.g. storage_shard_disk_size{bucket="0a5e...",engine="tsm1",id="3",...} 1.234e+06
+	sizes := make(map[string]int64)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		labels, ok := strings.CutPrefix(line, "storage_shard_disk_size{")
+		if !ok {
+			continue
+		}
+		end := strings.LastIndexByte(labels, '}')
+		if end < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(labels[end+1:]), 64)
+		if err != nil {
+			continue
+		}
+		for _, label := range strings.Split(labels[:end], ",") {
+			if id, ok := strings.CutPrefix(label, `bucket="`); ok {
+				sizes[strings.TrimSuffix(id, `"`)] += int64(value)
+			}
+		}
+	}
+	return sizes, scanner.Err()
+}
+
+// backupMetadata reads /api/v2/backup/metadata: it streams the KV store to
+// kv and the SQL store to sql, and returns the manifest of every bucket
+func (c *client) backupMetadata(ctx context.Context, kv, sql io.Writer) ([]json.RawMessage, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/v2/backup/metadata", nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return nil, fmt.Errorf("invalid backup metadata response: %q", resp.Header.Get("Content-Type"))
+	}
+	var buckets []json.RawMessage
+	seen := map[string]bool{}
+	parts := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup metadata response: %w", err)
+		}
+		name := part.FormName()
+		switch name {
+		case "kv":
+			_, err = io.Copy(kv, part)
+		case "sql":
+			_, err = io.Copy(sql, part)
+		case "buckets":
+			err = json.NewDecoder(part).Decode(&buckets)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("backup metadata %s: %w", name, err)
+		}
+		seen[name] = true
+	}
+	for _, name := range []string{"kv", "sql", "buckets"} {
+		if !seen[name] {
+			return nil, fmt.Errorf("backup metadata has no %s part", name)
+		}
+	}
+	return buckets, nil
+}
+
+// backupShard streams the TSM snapshot of a shard to w, as a tar archive
+func (c *client) backupShard(ctx context.Context, id int64, w io.Writer) error {
+	resp, err := c.do(ctx, http.MethodGet, "/api/v2/backup/shards/"+strconv.FormatInt(id, 10), nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// restoreKV replaces the KV store, returning the operator token of the
+// restored instance
+func (c *client) restoreKV(ctx context.Context, r io.Reader) (string, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/api/v2/restore/kv", nil, r, "application/octet-stream")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var restored struct {
+		Token string `json:"token"`
+	}
+	// Older servers answer 204 without a body
+	if err := json.NewDecoder(resp.Body).Decode(&restored); err != nil && err != io.EOF {
+		return "", fmt.Errorf("invalid kv restore response: %w", err)
+	}
+	return restored.Token, nil
+}
+
+// restoreSQL replaces the SQL store
+func (c *client) restoreSQL(ctx context.Context, r io.Reader) error {
+	resp, err := c.do(ctx, http.MethodPost, "/api/v2/restore/sql", nil, r, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// shardMapping maps a shard in a backup to the shard created for it
+type shardMapping struct {
+	OldID int64 `json:"oldId"`
+	NewID int64 `json:"newId"`
+}
+
+// restoreBucketMetadata creates a bucket from its manifest, returning the
+// shards created for the shards in the manifest
+func (c *client) restoreBucketMetadata(ctx context.Context, manifest []byte) ([]shardMapping, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/api/v2/restore/bucketMetadata", nil, bytes.NewReader(manifest), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var restored struct {
+		ShardMappings []shardMapping `json:"shardMappings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&restored); err != nil {
+		return nil, fmt.Errorf("invalid bucket restore response: %w", err)
+	}
+	return restored.ShardMappings, nil
+}
+
+// restoreShard loads a shard's TSM snapshot, a tar archive, into shard id
+func (c *client) restoreShard(ctx context.Context, id int64, r io.Reader) error {
+	resp, err := c.do(ctx, http.MethodPost, "/api/v2/restore/shards/"+strconv.FormatInt(id, 10), nil, r, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// getJSON decodes the answer of a GET into resp
+func (c *client) getJSON(ctx context.Context, path string, q url.Values, resp any) error {
+	r, err := c.do(ctx, http.MethodGet, path, q, nil, "")
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("invalid %s response: %w", path, err)
+	}
+	return nil
+}
+
+// do sends a request, returning the response when it succeeded
+func (c *client) do(ctx context.Context, method, path string, q url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.base + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s %s: %w", method, path, errNotFound)
+		}
+		// Errors are {"code": "unauthorized", "message": "..."}
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("%s %s: %s: %s", method, path, apiErr.Code, apiErr.Message)
+		}
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
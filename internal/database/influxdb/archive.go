@@ -0,0 +1,168 @@
+package influxdb
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ManifestFormat identifies the archives written by this driver
+const ManifestFormat = "influxdb-backup/v1"
+
+// How an archive was taken
+const (
+	// KindAPI archives hold the stores and shards read from the backup
+	// API, and are restored through the restore API
+	KindAPI = "api"
+	// KindCLI archives hold the directory written by "influx backup", and
+	// are restored with "influx restore"
+	KindCLI = "influx-cli"
+)
+
+// Entries of an archive after the manifest, in this order
+const (
+	kvEntry   = "kv.bolt"
+	sqlEntry  = "sql.sqlite"
+	shardsDir = "shards/"
+	cliDir    = "influx/"
+)
+
+// Manifest describes an archive. It is its first entry, so restores know
+// what they are restoring before any data.
+type Manifest struct {
+	Format string `json:"format"`
+	Kind   string `json:"kind"`
+	// Full archives hold every bucket and the KV and SQL stores, with the
+	// users, tokens, dashboards and tasks
+	Full    bool     `json:"full"`
+	Buckets []string `json:"buckets"`
+	// BucketManifests are the manifests of the buckets as the backup API
+	// returned them, sent back as they are to recreate each bucket
+	BucketManifests []json.RawMessage `json:"bucket_manifests,omitempty"`
+	Version         string            `json:"version"`
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+// bucketManifest is what the driver reads of a bucket manifest
+type bucketManifest struct {
+	OrganizationID    string `json:"organizationID"`
+	OrganizationName  string `json:"organizationName"`
+	BucketID          string `json:"bucketID"`
+	BucketName        string `json:"bucketName"`
+	RetentionPolicies []struct {
+		ShardGroups []struct {
+			Shards []struct {
+				ID int64 `json:"id"`
+			} `json:"shards"`
+		} `json:"shardGroups"`
+	} `json:"retentionPolicies"`
+}
+
+// shards returns the IDs of the bucket's shards
+func (b *bucketManifest) shards() []int64 {
+	var ids []int64
+	for _, rp := range b.RetentionPolicies {
+		for _, sg := range rp.ShardGroups {
+			for _, s := range sg.Shards {
+				ids = append(ids, s.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// parseBucketManifest reads a bucket manifest
+func parseBucketManifest(raw json.RawMessage) (*bucketManifest, error) {
+	var b bucketManifest
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return nil, fmt.Errorf("invalid bucket manifest: %w", err)
+	}
+	if b.BucketName == "" {
+		return nil, errors.New("invalid bucket manifest: no bucket name")
+	}
+	return &b, nil
+}
+
+// renamedManifest returns raw with the bucket renamed to name and moved to
+// the organization orgID. Fields the driver does not know are kept.
+func renamedManifest(raw json.RawMessage, name, orgID string) ([]byte, error) {
+	var m map[string]any
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("invalid bucket manifest: %w", err)
+	}
+	m["bucketName"] = name
+	m["organizationID"] = orgID
+	return json.Marshal(m)
+}
+
+// archiveWriter writes the entries of an archive
+type archiveWriter struct {
+	tw *tar.Writer
+}
+
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	return &archiveWriter{tw: tar.NewWriter(w)}
+}
+
+// writeFile writes file as the entry name
+func (a *archiveWriter) writeFile(name, file string) (int64, error) {
+	f, err := os.Open(file) // #nosec G304 -- spool file of this backup
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := a.header(name, info.Size()); err != nil {
+		return 0, err
+	}
+	return io.Copy(a.tw, f)
+}
+
+func (a *archiveWriter) header(name string, size int64) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+}
+
+// Close finishes the archive
+func (a *archiveWriter) Close() error {
+	return a.tw.Close()
+}
+
+// shardEntry returns the entry of a shard
+func shardEntry(id int64) string {
+	return shardsDir + strconv.FormatInt(id, 10) + ".tar"
+}
+
+// shardID returns the ID of the shard in entry name
+func shardID(name string) (int64, bool) {
+	if !strings.HasPrefix(name, shardsDir) || !strings.HasSuffix(name, ".tar") {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, shardsDir), ".tar"), 10, 64)
+	return id, err == nil
+}
+
+// cliFile returns the path under dir of an entry of a CLI archive. The
+// directory written by influx backup is flat, anything else is refused.
+func cliFile(dir, name string) (string, bool) {
+	base := strings.TrimPrefix(name, cliDir)
+	if base == name || base != path.Base(base) || base == ".." {
+		return "", false
+	}
+	return filepath.Join(dir, base), true
+}
@@ -0,0 +1,150 @@
+package influxdb
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+)
+
+// influxCLI returns the path of the influx CLI
+func influxCLI() (string, error) {
+	path, err := exec.LookPath("influx")
+	if err != nil {
+		return "", errors.New("the influx CLI is not installed")
+	}
+	return path, nil
+}
+
+// backupCLI writes an archive of the directory "influx backup" writes for
+// names, every bucket when nil, to w
+func (d *InfluxDBDriver) backupCLI(ctx context.Context, names []string, version, spool string, w io.Writer) (*Manifest, []database.TableInfo, error) {
+	if _, err := influxCLI(); err != nil {
+		return nil, nil, fmt.Errorf("the server has no backup API (InfluxDB 2.0) and %w", err)
+	}
+	if len(names) > 1 {
+		return nil, nil, errors.New("the influx CLI backs up one bucket or all of them")
+	}
+	dir := filepath.Join(spool, "influx")
+	args := []string{"backup", dir}
+	if len(names) == 1 {
+		args = append(args, "--bucket", names[0])
+	}
+	if err := d.runCLI(ctx, args); err != nil {
+		return nil, nil, err
+	}
+
+	m := &Manifest{
+		Format:    ManifestFormat,
+		Kind:      KindCLI,
+		Full:      names == nil,
+		Buckets:   names,
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+	}
+	if m.Full {
+		buckets, err := d.client.buckets(ctx, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, b := range buckets {
+			m.Buckets = append(m.Buckets, b.Name)
+		}
+		sort.Strings(m.Buckets)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	archive := newArchiveWriter(w)
+	if err := database.WriteManifest(archive.tw, m); err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if _, err := archive.writeFile(cliDir+e.Name(), filepath.Join(dir, e.Name())); err != nil {
+			return nil, nil, err
+		}
+	}
+	tables := make([]database.TableInfo, 0, len(m.Buckets))
+	for _, name := range m.Buckets {
+		tables = append(tables, database.TableInfo{Name: name})
+	}
+	return m, tables, archive.Close()
+}
+
+// restoreCLI extracts the directory of a CLI archive and restores it with
+// "influx restore"
+func (d *InfluxDBDriver) restoreCLI(ctx context.Context, p *restorePlan, tr *tar.Reader) ([]string, error) {
+	dir, err := os.MkdirTemp("", "influxdb-restore-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		path, ok := cliFile(dir, hdr.Name)
+		if !ok {
+			return nil, fmt.Errorf("unexpected archive entry %s", hdr.Name)
+		}
+		if err := database.ExtractFile(path, tr, 0o600); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.full {
+		return p.buckets, d.runCLI(ctx, []string{"restore", dir, "--full"})
+	}
+	var restored []string
+	for _, name := range p.buckets {
+		args := []string{"restore", dir, "--bucket", name}
+		if p.target != "" {
+			args = append(args, "--new-bucket", p.target)
+			name = p.target
+		}
+		if err := d.runCLI(ctx, args); err != nil {
+			return restored, err
+		}
+		restored = append(restored, name)
+	}
+	return restored, nil
+}
+
+// runCLI runs the influx CLI against the server. The host and token are
+// passed in the environment, out of sight of ps.
+func (d *InfluxDBDriver) runCLI(ctx context.Context, args []string) error {
+	tool, err := influxCLI()
+	if err != nil {
+		return err
+	}
+	if d.config.SSLMode == "require" {
+		args = append(args, "--skip-verify")
+	}
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Env = append(os.Environ(), "INFLUX_HOST="+d.client.base, "INFLUX_TOKEN="+d.client.token)
+	run := telemetry.StartCommand(ctx, cmd)
+	output, err := cmd.CombinedOutput()
+	run.End(err, -1)
+	if err != nil {
+		return fmt.Errorf("influx %s failed: %w: %s", args[0], err, output)
+	}
+	return nil
+}
@@ -0,0 +1,615 @@
+// Package influxdb provides the InfluxDB 2.x database driver. Buckets are
+// its databases. Backups read the KV and SQL stores and the TSM shards of
+// each bucket through the backup API of InfluxDB 2.1 and later, and store
+// them in one tar archive (see Manifest). Servers without the API, 2.0,
+// are backed up with "influx backup" when the influx CLI is installed.
+//
+// The API token is the password of the connection; backups need an
+// operator token, or an all-access token for bucket backups. TLS follows
+// ssl_mode, with the ssl_ca certificate. The org connection option
+// is the organization buckets are restored into, the one they were backed
+// up from by default.
+//
+// Naming buckets backs them up alone; otherwise every bucket is backed up,
+// with the stores holding users, tokens, dashboards and tasks. Restores
+// are per bucket: the bucket restore metadata, or the database when it is
+// a bucket of the backup, picks one bucket, which the database renames;
+// without either every user bucket is restored. A bucket that exists is
+// deleted first when dropping existing data, otherwise the restore fails.
+// A full backup restored while dropping existing data and without a bucket
+// replaces the whole instance, after which the operator token of the
+// backup applies.
+package influxdb
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// InfluxDBDriver implements the database.Driver interface for InfluxDB 2.x
+type InfluxDBDriver struct {
+	client *client
+	config *database.ConnectionConfig
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeInfluxDB, func() database.Driver {
+		return NewInfluxDBDriver()
+	})
+}
+
+// NewInfluxDBDriver creates a new InfluxDB driver instance
+func NewInfluxDBDriver() *InfluxDBDriver {
+	return &InfluxDBDriver{}
+}
+
+// Connect checks the server is ready and accepts the token
+func (d *InfluxDBDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	c, err := newClient(config)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if _, err := c.health(ctx); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if _, err := c.buckets(ctx, ""); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.client = c
+	d.config = config
+	return nil
+}
+
+// Disconnect releases idle connections
+func (d *InfluxDBDriver) Disconnect() error {
+	if d.client != nil {
+		d.client.hc.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Ping tests the connection
+func (d *InfluxDBDriver) Ping(ctx context.Context) error {
+	if d.client == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	_, err := d.client.health(ctx)
+	return err
+}
+
+// Backup writes an archive of the buckets in opts to opts.OutputPath
+func (d *InfluxDBDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	m, tables, err := d.backup(ctx, opts, output)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = m.Version
+	result.Size = output.Written()
+	result.Checksum = output.Sum()
+	result.Tables = tables
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"influxdb_backup_kind": m.Kind})
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// StreamBackup streams an archive of the buckets in opts to writer
+func (d *InfluxDBDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	_, _, err := d.backup(ctx, opts, writer)
+	return err
+}
+
+// GetBackupSize returns the size on disk of the shards backed up
+func (d *InfluxDBDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	sizes, err := d.client.shardSizes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	names := bucketNames(opts)
+	buckets, err := d.client.buckets(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, b := range buckets {
+		if names == nil || slices.Contains(names, b.Name) {
+			size += sizes[b.ID]
+		}
+	}
+	return size, nil
+}
+
+// Restore restores buckets from an archive
+func (d *InfluxDBDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+	fail := func(err error) (*database.RestoreResult, error) {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+
+	file, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return fail(err)
+	}
+	defer file.Close()
+	restored, err := d.restore(ctx, opts, file)
+	if err != nil {
+		return fail(err)
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.RestoredTables = restored
+	result.Status = database.RestoreStatusSuccess
+	return result, nil
+}
+
+// StreamRestore restores buckets from an archive read from reader
+func (d *InfluxDBDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	_, err := d.restore(ctx, opts, reader)
+	return err
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *InfluxDBDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	file, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer file.Close()
+	m, err := database.ReadManifest[Manifest](tar.NewReader(file), ManifestFormat, nil)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if _, err := planRestore(m, opts); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if m.Kind == KindCLI {
+		if _, err := influxCLI(); err != nil {
+			return pkgErrors.ErrValidationFailed(err.Error())
+		}
+	}
+	if err := d.Ping(ctx); err != nil {
+		return pkgErrors.ErrValidationFailed("database connection failed")
+	}
+	return nil
+}
+
+// GetDatabases returns the user buckets
+func (d *InfluxDBDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	buckets, err := d.client.buckets(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, b := range buckets {
+		if b.Type != "system" {
+			names = append(names, b.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetTables returns the measurements of a bucket
+func (d *InfluxDBDriver) GetTables(ctx context.Context, db string) ([]string, error) {
+	buckets, err := d.client.buckets(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no bucket %q", db)
+	}
+	names, err := d.client.measurements(ctx, buckets[0].OrgID, db)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetTableSize is not supported, InfluxDB only reports the size of shards
+func (d *InfluxDBDriver) GetTableSize(ctx context.Context, db, table string) (int64, error) {
+	return 0, pkgErrors.New(pkgErrors.ErrorTypeDatabase, "InfluxDB does not report the size of measurements")
+}
+
+// GetVersion returns the InfluxDB version, e.g. 2.7.5
+func (d *InfluxDBDriver) GetVersion(ctx context.Context) (string, error) {
+	h, err := d.client.health(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(h.Version, "v"), nil
+}
+
+// GetType returns the database type
+func (d *InfluxDBDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeInfluxDB
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *InfluxDBDriver) SupportsIncremental() bool {
+	return false
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *InfluxDBDriver) SupportsPITR() bool {
+	return false
+}
+
+// backup writes an archive of the buckets in opts to w, returning its
+// manifest and the size of each bucket
+func (d *InfluxDBDriver) backup(ctx context.Context, opts *database.BackupOptions, w io.Writer) (*Manifest, []database.TableInfo, error) {
+	names := bucketNames(opts)
+	version, _ := d.GetVersion(ctx)
+
+	// The stores and each shard are spooled to learn their size, which
+	// tar headers come with
+	spool, err := os.MkdirTemp("", "influxdb-backup-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(spool)
+
+	kvPath, sqlPath := filepath.Join(spool, kvEntry), filepath.Join(spool, sqlEntry)
+	manifests, err := d.backupMetadata(ctx, kvPath, sqlPath)
+	if errors.Is(err, errNotFound) {
+		// InfluxDB 2.0 has no backup API
+		return d.backupCLI(ctx, names, version, spool, w)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := &Manifest{
+		Format:    ManifestFormat,
+		Kind:      KindAPI,
+		Full:      names == nil,
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+	}
+	var buckets []*bucketManifest
+	for _, raw := range manifests {
+		b, err := parseBucketManifest(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.Full || slices.Contains(names, b.BucketName) {
+			m.Buckets = append(m.Buckets, b.BucketName)
+			m.BucketManifests = append(m.BucketManifests, raw)
+			buckets = append(buckets, b)
+		}
+	}
+	for _, name := range names {
+		if !slices.Contains(m.Buckets, name) {
+			return nil, nil, fmt.Errorf("no bucket %q", name)
+		}
+	}
+
+	archive := newArchiveWriter(w)
+	if err := database.WriteManifest(archive.tw, m); err != nil {
+		return nil, nil, err
+	}
+	if m.Full {
+		if _, err := archive.writeFile(kvEntry, kvPath); err != nil {
+			return nil, nil, err
+		}
+		if _, err := archive.writeFile(sqlEntry, sqlPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	tables := make([]database.TableInfo, 0, len(buckets))
+	for _, b := range buckets {
+		var size int64
+		for _, id := range b.shards() {
+			n, err := d.backupShard(ctx, archive, spool, id)
+			if err != nil {
+				return nil, nil, fmt.Errorf("bucket %s, shard %d: %w", b.BucketName, id, err)
+			}
+			size += n
+		}
+		tables = append(tables, database.TableInfo{Name: b.BucketName, DataSize: size})
+	}
+	return m, tables, archive.Close()
+}
+
+// backupMetadata spools the KV and SQL stores to kvPath and sqlPath and
+// returns the bucket manifests
+func (d *InfluxDBDriver) backupMetadata(ctx context.Context, kvPath, sqlPath string) ([]json.RawMessage, error) {
+	kv, err := os.Create(kvPath)
+	if err != nil {
+		return nil, err
+	}
+	defer kv.Close()
+	sql, err := os.Create(sqlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+	manifests, err := d.client.backupMetadata(ctx, kv, sql)
+	if err != nil {
+		return nil, err
+	}
+	if err := kv.Close(); err != nil {
+		return nil, err
+	}
+	return manifests, sql.Close()
+}
+
+// backupShard adds a shard to the archive, returning its size. Shards
+// deleted by retention since the metadata was read are skipped.
+func (d *InfluxDBDriver) backupShard(ctx context.Context, archive *archiveWriter, spool string, id int64) (int64, error) {
+	path := filepath.Join(spool, "shard.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(path)
+	err = d.client.backupShard(ctx, id, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, errNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return archive.writeFile(shardEntry(id), path)
+}
+
+// restorePlan is what a restore restores
+type restorePlan struct {
+	full    bool     // replace the whole instance
+	buckets []string // by their name in the backup
+	target  string   // new name of the only bucket restored; empty keeps it
+}
+
+// planRestore works out what opts restore of the archive described by m
+func planRestore(m *Manifest, opts *database.RestoreOptions) (*restorePlan, error) {
+	switch {
+	case len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0:
+		return nil, errors.New("buckets are restored whole, measurements cannot be chosen")
+	case opts.PointInTime != nil:
+		return nil, errors.New("point-in-time restores are not supported for InfluxDB")
+	}
+
+	source := opts.Metadata["bucket"]
+	if source == "" && slices.Contains(m.Buckets, opts.Database) {
+		source = opts.Database
+	}
+	if m.Full && opts.DropExisting && source == "" && opts.Database == "" {
+		return &restorePlan{full: true, buckets: m.Buckets}, nil
+	}
+
+	p := &restorePlan{}
+	if source != "" {
+		if !slices.Contains(m.Buckets, source) {
+			return nil, fmt.Errorf("the backup has no bucket %q", source)
+		}
+		p.buckets = []string{source}
+	} else {
+		for _, name := range m.Buckets {
+			if !systemBucket(name) {
+				p.buckets = append(p.buckets, name)
+			}
+		}
+	}
+	if len(p.buckets) == 0 {
+		return nil, errors.New("the backup holds no user buckets")
+	}
+	if target := opts.Target(); target != "" && target != p.buckets[0] {
+		if len(p.buckets) > 1 {
+			return nil, errors.New("the backup holds several buckets and cannot be restored under one name: pick one with the bucket metadata")
+		}
+		p.target = target
+	}
+	return p, nil
+}
+
+// restore restores the archive read from r, returning the buckets restored
+func (d *InfluxDBDriver) restore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) ([]string, error) {
+	tr := tar.NewReader(r)
+	m, err := database.ReadManifest[Manifest](tr, ManifestFormat, nil)
+	if err != nil {
+		return nil, err
+	}
+	p, err := planRestore(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	if m.Kind == KindCLI {
+		return d.restoreCLI(ctx, p, tr)
+	}
+	if p.full {
+		return p.buckets, d.restoreFull(ctx, tr)
+	}
+	return d.restoreBuckets(ctx, m, p, opts.DropExisting, tr)
+}
+
+// restoreFull replaces the stores, then loads every shard under its own ID
+func (d *InfluxDBDriver) restoreFull(ctx context.Context, tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch id, isShard := shardID(hdr.Name); {
+		case hdr.Name == kvEntry:
+			token, err := d.client.restoreKV(ctx, tr)
+			if err != nil {
+				return fmt.Errorf("restoring the KV store: %w", err)
+			}
+			// The tokens are those of the backup now
+			if token != "" {
+				d.client.token = token
+			}
+		case hdr.Name == sqlEntry:
+			if err := d.client.restoreSQL(ctx, tr); err != nil {
+				return fmt.Errorf("restoring the SQL store: %w", err)
+			}
+		case isShard:
+			if err := d.client.restoreShard(ctx, id, tr); err != nil {
+				return fmt.Errorf("restoring shard %d: %w", id, err)
+			}
+		}
+	}
+}
+
+// restoreBuckets creates the buckets of the plan, then loads their shards
+// into the shards created for them
+func (d *InfluxDBDriver) restoreBuckets(ctx context.Context, m *Manifest, p *restorePlan, drop bool, tr *tar.Reader) ([]string, error) {
+	type restore struct {
+		name     string
+		manifest []byte
+	}
+	var restores []restore
+	for _, raw := range m.BucketManifests {
+		b, err := parseBucketManifest(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(p.buckets, b.BucketName) {
+			continue
+		}
+		name := b.BucketName
+		if p.target != "" {
+			name = p.target
+		}
+		org := d.config.Options["org"]
+		if org == "" {
+			org = b.OrganizationName
+		}
+		orgID, err := d.client.orgID(ctx, org)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.clearBucket(ctx, orgID, name, drop); err != nil {
+			return nil, err
+		}
+		manifest, err := renamedManifest(raw, name, orgID)
+		if err != nil {
+			return nil, err
+		}
+		restores = append(restores, restore{name, manifest})
+	}
+
+	shards := make(map[int64]int64)
+	var restored []string
+	for _, r := range restores {
+		mappings, err := d.client.restoreBucketMetadata(ctx, r.manifest)
+		if err != nil {
+			return restored, fmt.Errorf("creating bucket %s: %w", r.name, err)
+		}
+		for _, s := range mappings {
+			shards[s.OldID] = s.NewID
+		}
+		restored = append(restored, r.name)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return restored, nil
+		}
+		if err != nil {
+			return restored, err
+		}
+		id, ok := shardID(hdr.Name)
+		if !ok {
+			continue
+		}
+		if newID, ok := shards[id]; ok {
+			if err := d.client.restoreShard(ctx, newID, tr); err != nil {
+				return restored, fmt.Errorf("restoring shard %d: %w", id, err)
+			}
+		}
+	}
+}
+
+// clearBucket makes room for restoring the bucket called name into an
+// organization: an existing bucket is deleted when drop is set
+func (d *InfluxDBDriver) clearBucket(ctx context.Context, orgID, name string, drop bool) error {
+	existing, err := d.client.buckets(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, b := range existing {
+		if b.OrgID != orgID || b.Name != name {
+			continue
+		}
+		if !drop {
+			return fmt.Errorf("bucket %s already exists: restore it under another name, or drop existing data", name)
+		}
+		if err := d.client.deleteBucket(ctx, b.ID); err != nil {
+			return fmt.Errorf("dropping bucket %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// bucketNames returns the buckets opts back up, nil for all of them
+func bucketNames(opts *database.BackupOptions) []string {
+	switch {
+	case opts.AllDatabases:
+		return nil
+	case len(opts.Databases) > 0:
+		return opts.Databases
+	case opts.Database != "":
+		return []string{opts.Database}
+	}
+	return nil
+}
+
+// systemBucket reports whether a bucket is one InfluxDB creates for
+// itself, such as _monitoring and _tasks
+func systemBucket(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
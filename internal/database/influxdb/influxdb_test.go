@@ -0,0 +1,303 @@
+package influxdb
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+const bucketsManifest = `[
+ {"organizationID":"org1","organizationName":"acme","bucketID":"b1","bucketName":"telegraf","defaultRetentionPolicy":"autogen",
+  "retentionPolicies":[{"name":"autogen","shardGroups":[{"id":1,"shards":[{"id":11},{"id":12}]}]}]},
+ {"organizationID":"org1","organizationName":"acme","bucketID":"b2","bucketName":"_monitoring",
+  "retentionPolicies":[{"name":"autogen","shardGroups":[{"id":2,"shards":[{"id":21}]}]}]}
+]`
+
+// fakeServer is an InfluxDB answering the APIs the driver calls, and
+// recording what is restored
+type fakeServer struct {
+	mu       sync.Mutex
+	token    string
+	buckets  []bucket
+	deleted  []string
+	kv, sql  string
+	manifest map[string]any
+	shards   map[int64]string // restored shards by ID
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path != "/health" && r.Header.Get("Authorization") != "Token "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"code":"unauthorized","message":"unauthorized access"}`)
+		return
+	}
+	switch path := r.URL.Path; {
+	case path == "/health":
+		fmt.Fprint(w, `{"name":"influxdb","message":"ready for queries and writes","status":"pass","version":"v2.7.5"}`)
+	case path == "/api/v2/buckets":
+		var out []bucket
+		for _, b := range f.buckets {
+			if name := r.URL.Query().Get("name"); name == "" || name == b.Name {
+				out = append(out, b)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"buckets": out})
+	case strings.HasPrefix(path, "/api/v2/buckets/") && r.Method == http.MethodDelete:
+		f.deleted = append(f.deleted, strings.TrimPrefix(path, "/api/v2/buckets/"))
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/api/v2/orgs":
+		fmt.Fprint(w, `{"orgs":[{"id":"org9","name":"acme"}]}`)
+	case path == "/metrics":
+		fmt.Fprint(w, "# TYPE storage_shard_disk_size gauge\n"+
+			`storage_shard_disk_size{bucket="b1",engine="tsm1",id="11"} 1000`+"\n"+
+			`storage_shard_disk_size{bucket="b1",engine="tsm1",id="12"} 2.5e+03`+"\n"+
+			`storage_shard_disk_size{bucket="b2",engine="tsm1",id="21"} 100`+"\n")
+	case path == "/api/v2/backup/metadata":
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", mw.FormDataContentType())
+		for _, part := range []struct{ name, body string }{{"kv", "bolt-kv"}, {"sql", "sqlite-db"}, {"buckets", bucketsManifest}} {
+			pw, _ := mw.CreateFormField(part.name)
+			io.WriteString(pw, part.body)
+		}
+		mw.Close()
+	case strings.HasPrefix(path, "/api/v2/backup/shards/"):
+		id := strings.TrimPrefix(path, "/api/v2/backup/shards/")
+		if id == "12" {
+			// Deleted by retention since the metadata was read
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "tsm-"+id)
+	case path == "/api/v2/restore/kv":
+		data, _ := io.ReadAll(r.Body)
+		f.kv = string(data)
+		f.token = "restored-token"
+		fmt.Fprint(w, `{"token":"restored-token"}`)
+	case path == "/api/v2/restore/sql":
+		data, _ := io.ReadAll(r.Body)
+		f.sql = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/api/v2/restore/bucketMetadata":
+		_ = json.NewDecoder(r.Body).Decode(&f.manifest)
+		fmt.Fprint(w, `{"id":"b7","name":"restored","shardMappings":[{"oldId":11,"newId":111},{"oldId":12,"newId":112}]}`)
+	case strings.HasPrefix(path, "/api/v2/restore/shards/"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(path, "/api/v2/restore/shards/"), 10, 64)
+		data, _ := io.ReadAll(r.Body)
+		f.shards[id] = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func connect(t *testing.T, f *fakeServer, options map[string]string) *InfluxDBDriver {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	p, _ := strconv.Atoi(port)
+	d := NewInfluxDBDriver()
+	if err := d.Connect(context.Background(), &database.ConnectionConfig{
+		Host: host, Port: p, Password: f.token, Options: options,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		token:   "operator-token",
+		buckets: []bucket{{ID: "b1", Name: "telegraf", Type: "user", OrgID: "org9"}, {ID: "b2", Name: "_monitoring", Type: "system", OrgID: "org9"}},
+		shards:  map[int64]string{},
+	}
+}
+
+// entries returns the entries of the archive at path
+func entries(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		out[hdr.Name] = string(data)
+	}
+}
+
+func TestBackupAndRestoreBucket(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeServer()
+	d := connect(t, f, nil)
+
+	if dbs, err := d.GetDatabases(ctx); err != nil || len(dbs) != 1 || dbs[0] != "telegraf" {
+		t.Errorf("databases: got %v, %v", dbs, err)
+	}
+	if size, err := d.GetBackupSize(ctx, &database.BackupOptions{Database: "telegraf"}); err != nil || size != 3500 {
+		t.Errorf("size: got %d, %v", size, err)
+	}
+
+	out := filepath.Join(t.TempDir(), "influx.tar")
+	result, err := d.Backup(ctx, &database.BackupOptions{Database: "telegraf", OutputPath: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DatabaseVersion != "2.7.5" || len(result.Tables) != 1 || result.Tables[0].Name != "telegraf" || result.Tables[0].DataSize != 6 {
+		t.Errorf("got %+v", result)
+	}
+	got := entries(t, out)
+	// A bucket backup leaves out the stores, and the deleted shard
+	if len(got) != 2 || got["shards/11.tar"] != "tsm-11" {
+		t.Fatalf("archive: got %v", got)
+	}
+
+	opts := &database.RestoreOptions{SourceBackup: out, Database: "telegraf_restored"}
+	if err := d.ValidateRestore(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	restore, err := d.Restore(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restore.RestoredTables) != 1 || restore.RestoredTables[0] != "telegraf_restored" {
+		t.Errorf("restored %v", restore.RestoredTables)
+	}
+	if f.manifest["bucketName"] != "telegraf_restored" || f.manifest["organizationID"] != "org9" || f.manifest["defaultRetentionPolicy"] != "autogen" {
+		t.Errorf("bucket manifest: got %v", f.manifest)
+	}
+	if len(f.shards) != 1 || f.shards[111] != "tsm-11" {
+		t.Errorf("shards: got %v", f.shards)
+	}
+	if f.kv != "" {
+		t.Error("a bucket restore replaced the KV store")
+	}
+}
+
+func TestRestoreExistingBucket(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeServer()
+	d := connect(t, f, nil)
+	out := filepath.Join(t.TempDir(), "influx.tar")
+	if _, err := d.Backup(ctx, &database.BackupOptions{Database: "telegraf", OutputPath: out}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &database.RestoreOptions{SourceBackup: out}
+	if _, err := d.Restore(ctx, opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("got %v", err)
+	}
+	opts.DropExisting = true
+	if _, err := d.Restore(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.deleted) != 1 || f.deleted[0] != "b1" || f.manifest["bucketName"] != "telegraf" {
+		t.Errorf("deleted %v, manifest %v", f.deleted, f.manifest)
+	}
+}
+
+func TestFullBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeServer()
+	d := connect(t, f, nil)
+	out := filepath.Join(t.TempDir(), "influx.tar")
+	result, err := d.Backup(ctx, &database.BackupOptions{AllDatabases: true, OutputPath: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Tables) != 2 {
+		t.Errorf("tables: got %+v", result.Tables)
+	}
+	got := entries(t, out)
+	if got[kvEntry] != "bolt-kv" || got[sqlEntry] != "sqlite-db" || got["shards/21.tar"] != "tsm-21" {
+		t.Fatalf("archive: got %v", got)
+	}
+
+	// The whole instance is replaced, shards keep their IDs
+	if _, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: out, DropExisting: true}); err != nil {
+		t.Fatal(err)
+	}
+	if f.kv != "bolt-kv" || f.sql != "sqlite-db" || f.shards[11] != "tsm-11" || f.shards[21] != "tsm-21" {
+		t.Errorf("restored kv %q, sql %q, shards %v", f.kv, f.sql, f.shards)
+	}
+	if d.client.token != "restored-token" {
+		t.Errorf("token: got %q", d.client.token)
+	}
+}
+
+func TestPlanRestore(t *testing.T) {
+	full := &Manifest{Full: true, Buckets: []string{"_monitoring", "cpu", "mem"}}
+	one := &Manifest{Buckets: []string{"cpu"}}
+	for _, tt := range []struct {
+		name     string
+		m        *Manifest
+		opts     database.RestoreOptions
+		full     bool
+		buckets  string
+		target   string
+		wantFail bool
+	}{
+		{name: "user buckets", m: full, buckets: "cpu,mem"},
+		{name: "whole instance", m: full, opts: database.RestoreOptions{DropExisting: true}, full: true, buckets: "_monitoring,cpu,mem"},
+		{name: "bucket by database", m: full, opts: database.RestoreOptions{Database: "mem", DropExisting: true}, buckets: "mem"},
+		{name: "bucket renamed", m: full, opts: database.RestoreOptions{Database: "cpu_copy", Metadata: map[string]string{"bucket": "cpu"}}, buckets: "cpu", target: "cpu_copy"},
+		{name: "only bucket renamed", m: one, opts: database.RestoreOptions{Database: "cpu_copy"}, buckets: "cpu", target: "cpu_copy"},
+		{name: "several renamed", m: full, opts: database.RestoreOptions{Database: "copy"}, wantFail: true},
+		{name: "missing bucket", m: full, opts: database.RestoreOptions{Metadata: map[string]string{"bucket": "disk"}}, wantFail: true},
+		{name: "measurements", m: one, opts: database.RestoreOptions{Tables: []string{"usage"}}, wantFail: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := planRestore(tt.m, &tt.opts)
+			if tt.wantFail {
+				if err == nil {
+					t.Errorf("got %+v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.full != tt.full || strings.Join(p.buckets, ",") != tt.buckets || p.target != tt.target {
+				t.Errorf("got %+v", p)
+			}
+		})
+	}
+}
+
+func TestCLIFile(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"influx/20240101T000000Z.bolt": true,
+		"influx/../../etc/passwd":      false,
+		"influx/a/b":                   false,
+		"influx/":                      false,
+		"shards/1.tar":                 false,
+	} {
+		if _, got := cliFile("/tmp/x", name); got != ok {
+			t.Errorf("%s: got %v", name, got)
+		}
+	}
+}
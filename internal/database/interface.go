@@ -3,6 +3,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 
@@ -13,10 +14,22 @@ import (
 type DatabaseType string
 
 const (
-	DatabaseTypeMySQL      DatabaseType = "mysql"
-	DatabaseTypePostgreSQL DatabaseType = "postgres"
-	DatabaseTypeMongoDB    DatabaseType = "mongodb"
-	DatabaseTypeSQLite     DatabaseType = "sqlite"
+	DatabaseTypeMySQL       DatabaseType = "mysql"
+	DatabaseTypePostgreSQL  DatabaseType = "postgres"
+	DatabaseTypeMongoDB     DatabaseType = "mongodb"
+	DatabaseTypeSQLite      DatabaseType = "sqlite"
+	DatabaseTypeRedis       DatabaseType = "redis"
+	DatabaseTypeClickHouse  DatabaseType = "clickhouse"
+	DatabaseTypeCockroachDB DatabaseType = "cockroachdb"
+	DatabaseTypeMariaDB     DatabaseType = "mariadb"
+	DatabaseTypeEtcd        DatabaseType = "etcd"
+	DatabaseTypeInfluxDB    DatabaseType = "influxdb"
+	DatabaseTypeNeo4j       DatabaseType = "neo4j"
+	DatabaseTypeOracle      DatabaseType = "oracle"
+	DatabaseTypeDuckDB      DatabaseType = "duckdb"
+	DatabaseTypeCouchbase   DatabaseType = "couchbase"
+	DatabaseTypeTiDB        DatabaseType = "tidb"
+	DatabaseTypeDynamoDB    DatabaseType = "dynamodb"
 )
 
 // Driver interface that all database drivers must implement
@@ -48,15 +61,21 @@ type Driver interface {
 	SupportsPITR() bool
 }
 
-// ConnectionConfig holds database connection configuration
+// ConnectionConfig holds database connection configuration. PostgreSQL
+// assumes Role in every session; MySQL activates it for restores only, so
+// roles used for backups must be granted as default roles.
 type ConnectionConfig struct {
 	Type              DatabaseType
-	Host              string
+	Host              string // or the path of a unix socket
 	Port              int
 	Username          string
 	Password          string
+	Role              string // assumed after login, e.g. a restore role holding write and DDL rights
 	Database          string
-	SSLMode           string
+	SSLMode           string // disable, require, verify-ca or verify-full
+	SSLCA             string // CA certificate file verifying the server
+	SSLCert           string // client certificate file
+	SSLKey            string // private key file of the client certificate
 	ConnectionString  string
 	Options           map[string]string
 	ConnectionTimeout time.Duration
@@ -72,6 +91,7 @@ type BackupOptions struct {
 	ExcludeTables    []string
 	Incremental      bool
 	ConsistentBackup bool
+	Physical         bool // copy the server's data files instead of dumping, where supported
 	OutputPath       string
 	Compression      CompressionType
 	Parallel         int
@@ -79,9 +99,13 @@ type BackupOptions struct {
 	Metadata         map[string]string
 }
 
-// RestoreOptions holds restore operation options
+// RestoreOptions holds restore operation options. Database is the
+// database of the backup to restore and TargetDatabase, when set, the name
+// it is restored under, created when missing, so a backup of prod can be
+// restored as prod_verify on the same server.
 type RestoreOptions struct {
 	Database       string
+	TargetDatabase string
 	SourceBackup   string
 	Tables         []string
 	ExcludeTables  []string
@@ -92,6 +116,23 @@ type RestoreOptions struct {
 	Metadata       map[string]string
 }
 
+// ErrRenameUnsupported is returned for backups that cannot be restored
+// under another database name, such as whole-server snapshots
+var ErrRenameUnsupported = errors.New("the backup cannot be restored under another database name")
+
+// Target returns the name the database is restored under
+func (o *RestoreOptions) Target() string {
+	if o.TargetDatabase != "" {
+		return o.TargetDatabase
+	}
+	return o.Database
+}
+
+// Renamed reports whether the database is restored under another name
+func (o *RestoreOptions) Renamed() bool {
+	return o.TargetDatabase != "" && o.TargetDatabase != o.Database
+}
+
 // BackupResult contains the result of a backup operation
 type BackupResult struct {
 	ID              string
@@ -102,7 +143,7 @@ type BackupResult struct {
 	CompressedSize  int64
 	DatabaseVersion string
 	Tables          []TableInfo
-	Checksum        string
+	Checksum        string // hex SHA-256 of the dump file, computed while it is written
 	Metadata        map[string]string
 	Status          BackupStatus
 	Error           error
@@ -0,0 +1,247 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// LogManifestFile is the manifest of a log archive, in its directory
+const LogManifestFile = "archive.json"
+
+// logFormat identifies the layout of log archives
+const logFormat = "log-archive-v1"
+
+// DefaultCheckpointInterval is how often a log archive records how far it got
+const DefaultCheckpointInterval = 30 * time.Second
+
+// LogArchiver is implemented by drivers that can copy the server's
+// transaction log as it is written, independently of full backups, so a
+// restore can be rolled forward close to the failure.
+type LogArchiver interface {
+	// ArchiveLogs copies the log into opts.OutputDir until ctx ends,
+	// checkpointing the archive every opts.CheckpointInterval. An archive
+	// that holds a checkpoint carries on from there.
+	ArchiveLogs(ctx context.Context, opts *ArchiveOptions) error
+}
+
+// ArchiveOptions holds the options of a log archiver
+type ArchiveOptions struct {
+	OutputDir string
+	// StartFile is the first log file copied into an empty archive; the
+	// oldest one the server holds by default
+	StartFile          string
+	CheckpointInterval time.Duration
+	Options            map[string]string // driver settings
+	// OnCheckpoint is called after each checkpoint, e.g. to upload the
+	// files closed since the previous one
+	OnCheckpoint func(LogCheckpoint) error
+}
+
+// LogManifest describes a log archive
+type LogManifest struct {
+	Format       string       `json:"format"`
+	DatabaseType DatabaseType `json:"database_type"`
+	Server       string       `json:"server"`
+	CreatedAt    time.Time    `json:"created_at"`
+	Files        []LogFile    `json:"files"`
+	// Checkpoint is the position reached in the file being copied
+	Checkpoint *LogCheckpoint `json:"checkpoint,omitempty"`
+}
+
+// ArchivedUntil returns the time the archive is known to hold the log
+// until: that of its last file or checkpoint
+func (m *LogManifest) ArchivedUntil() time.Time {
+	var t time.Time
+	if n := len(m.Files); n > 0 {
+		t = m.Files[n-1].ArchivedAt
+	}
+	if m.Checkpoint != nil && m.Checkpoint.Time.After(t) {
+		t = m.Checkpoint.Time
+	}
+	return t
+}
+
+// LogFile is a log file the server has closed, copied whole
+type LogFile struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// LogCheckpoint records how far an archive got
+type LogCheckpoint struct {
+	File string    `json:"file"`
+	Size int64     `json:"size"`
+	Time time.Time `json:"time"`
+	// Closed lists the files closed since the previous checkpoint
+	Closed []LogFile `json:"-"`
+}
+
+// LogArchive is the directory a log archiver copies files into
+type LogArchive struct {
+	dir      string
+	manifest *LogManifest
+}
+
+// OpenLogArchive opens the log archive in dir, creating it from header
+// when it does not exist
+func OpenLogArchive(dir string, header LogManifest) (*LogArchive, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	m, err := ReadLogManifest(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		m = &header
+		m.Format = logFormat
+		m.CreatedAt = time.Now().UTC()
+		if err := writeLogManifest(dir, m); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case m.DatabaseType != header.DatabaseType || m.Server != header.Server:
+		return nil, fmt.Errorf("%s holds the log of %s %s", dir, m.DatabaseType, m.Server)
+	}
+	return &LogArchive{dir: dir, manifest: m}, nil
+}
+
+// Dir returns the directory of the archive
+func (a *LogArchive) Dir() string {
+	return a.dir
+}
+
+// Manifest returns the manifest of the archive
+func (a *LogArchive) Manifest() *LogManifest {
+	return a.manifest
+}
+
+// Checkpoint lists the files of the archive that sort before current, the
+// file being copied, as closed and records the size current has reached
+func (a *LogArchive) Checkpoint(ctx context.Context, current string) (*LogCheckpoint, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, err
+	}
+	archived := make(map[string]bool, len(a.manifest.Files))
+	for _, f := range a.manifest.Files {
+		archived[f.Name] = true
+	}
+
+	cp := &LogCheckpoint{File: current, Time: time.Now().UTC()}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || strings.HasPrefix(name, LogManifestFile) || archived[name] || name >= current {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		checksum, size, err := stream.HashFile(ctx, filepath.Join(a.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		cp.Closed = append(cp.Closed, LogFile{Name: name, Size: size, Checksum: checksum, ArchivedAt: cp.Time})
+	}
+
+	if fi, err := os.Stat(filepath.Join(a.dir, current)); err == nil {
+		cp.Size = fi.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	a.manifest.Files = append(a.manifest.Files, cp.Closed...)
+	a.manifest.Checkpoint = cp
+	if err := writeLogManifest(a.dir, a.manifest); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Add lists name, a closed file already written to the archive, and
+// checkpoints the archive at its end. It is for servers that hand their
+// log over a file at a time instead of being copied from.
+func (a *LogArchive) Add(ctx context.Context, name string) (*LogFile, error) {
+	checksum, size, err := stream.HashFile(ctx, filepath.Join(a.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	f := LogFile{Name: name, Size: size, Checksum: checksum, ArchivedAt: time.Now().UTC()}
+	cp := &LogCheckpoint{File: name, Size: size, Time: f.ArchivedAt, Closed: []LogFile{f}}
+	a.manifest.Files = append(a.manifest.Files, f)
+	a.manifest.Checkpoint = cp
+	if err := writeLogManifest(a.dir, a.manifest); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Lookup returns the listing of the closed file name, if any
+func (a *LogArchive) Lookup(name string) (LogFile, bool) {
+	for _, f := range a.manifest.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return LogFile{}, false
+}
+
+// VerifyLogArchive checks the closed files of the log archive in dir
+// against their checksums
+func VerifyLogArchive(ctx context.Context, dir string) (*LogManifest, error) {
+	m, err := ReadLogManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		checksum, _, err := stream.HashFile(ctx, filepath.Join(dir, filepath.Base(f.Name)))
+		if err != nil {
+			return nil, err
+		}
+		if checksum != f.Checksum {
+			return nil, fmt.Errorf("log file %s: checksum mismatch", f.Name)
+		}
+	}
+	return m, nil
+}
+
+// ReadLogManifest reads the manifest of the log archive in dir
+func ReadLogManifest(dir string) (*LogManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, LogManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m LogManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", LogManifestFile, err)
+	}
+	if m.Format != logFormat {
+		return nil, fmt.Errorf("%s: unsupported format %q", LogManifestFile, m.Format)
+	}
+	return &m, nil
+}
+
+// writeLogManifest replaces the manifest of the log archive in dir
+func writeLogManifest(dir string, m *LogManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, LogManifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, LogManifestFile))
+}
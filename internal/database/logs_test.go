@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogArchiveCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	header := LogManifest{DatabaseType: DatabaseTypeMySQL, Server: "3e11fa47-71ca-11e1-9e33-c80aa9429562"}
+	a, err := OpenLogArchive(dir, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("binlog.000007", "\xfebin one")
+	cp, err := a.Checkpoint(ctx, "binlog.000007")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Closed) != 0 || cp.Size != 8 {
+		t.Errorf("checkpoint = %+v, want binlog.000007 open at 8 bytes", cp)
+	}
+
+	write("binlog.000007", "\xfebin one two")
+	write("binlog.000008", "\xfebin")
+	write("binlog.000009", "")
+	cp, err = a.Checkpoint(ctx, "binlog.000009")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closed []string
+	for _, f := range cp.Closed {
+		closed = append(closed, f.Name)
+	}
+	if strings.Join(closed, ",") != "binlog.000007,binlog.000008" || cp.Closed[0].Size != 12 || cp.Closed[0].Checksum == "" {
+		t.Errorf("closed = %+v", cp.Closed)
+	}
+
+	a, err = OpenLogArchive(dir, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := a.Manifest()
+	if len(m.Files) != 2 || m.Checkpoint == nil || m.Checkpoint.File != "binlog.000009" {
+		t.Errorf("reopened manifest = %+v", m)
+	}
+	if cp, err := a.Checkpoint(ctx, "binlog.000009"); err != nil || len(cp.Closed) != 0 {
+		t.Errorf("checkpoint without a rotation = %+v, %v", cp, err)
+	}
+
+	if _, err := VerifyLogArchive(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	write("binlog.000008", "\xfebin tampered")
+	if _, err := VerifyLogArchive(ctx, dir); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("err = %v, want a checksum mismatch", err)
+	}
+
+	other := header
+	other.Server = "another"
+	if _, err := OpenLogArchive(dir, other); err == nil {
+		t.Error("opened the archive of another server")
+	}
+}
@@ -0,0 +1,236 @@
+// Package mariadb provides the MariaDB database driver. Connections,
+// metadata and logical dumps are the MySQL driver's, mysqldump being
+// compatible with MariaDB; whole-server backups are taken hot with
+// mariabackup instead when it can reach the server's data directory, which
+// also allows incremental backups.
+//
+// The backup_method connection option chooses: auto (the default) takes
+// physical backups of --all-databases and incremental backups when
+// mariabackup is installed next to the server, logical dumps otherwise;
+// physical and logical force one.
+package mariadb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/database/mysql"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+)
+
+// Backup methods of the backup_method option
+const (
+	MethodAuto     = "auto"
+	MethodPhysical = "physical"
+	MethodLogical  = "logical"
+)
+
+// MariaDBDriver implements the database.Driver interface for MariaDB
+type MariaDBDriver struct {
+	*mysql.MySQLDriver
+	config *database.ConnectionConfig
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeMariaDB, func() database.Driver {
+		return NewMariaDBDriver()
+	})
+}
+
+// NewMariaDBDriver creates a new MariaDB driver instance
+func NewMariaDBDriver() *MariaDBDriver {
+	return &MariaDBDriver{MySQLDriver: mysql.NewMySQLDriver()}
+}
+
+// Connect establishes a connection to the MariaDB server
+func (d *MariaDBDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	switch config.Options["backup_method"] {
+	case "", MethodAuto, MethodPhysical, MethodLogical:
+	default:
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("backup_method %q is not auto, physical or logical", config.Options["backup_method"]))
+	}
+	// The MySQL driver passes options on in its DSN, where the server would
+	// refuse this one
+	mysqlConfig := *config
+	mysqlConfig.Options = make(map[string]string, len(config.Options))
+	for k, v := range config.Options {
+		if k != "backup_method" {
+			mysqlConfig.Options[k] = v
+		}
+	}
+	if err := d.MySQLDriver.Connect(ctx, &mysqlConfig); err != nil {
+		return err
+	}
+	d.config = config
+	return nil
+}
+
+// Backup takes a physical backup with mariabackup or a logical dump,
+// depending on backup_method. Physical backups are of the whole server;
+// opts.Incremental makes one incremental when the to_lsn of the backup it
+// builds on is given as the mariabackup_base_lsn metadata.
+func (d *MariaDBDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	physical, err := d.physical(ctx, opts)
+	if err != nil {
+		return &database.BackupResult{Status: database.BackupStatusFailed, Error: err}, pkgErrors.ErrDatabaseBackup(err)
+	}
+	if !physical {
+		result, err := d.MySQLDriver.Backup(ctx, opts)
+		if result != nil && err == nil {
+			result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"backup_method": MethodLogical})
+		}
+		return result, err
+	}
+	return d.physicalBackup(ctx, opts)
+}
+
+// StreamBackup streams a physical backup or a logical dump to writer
+func (d *MariaDBDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	physical, err := d.physical(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if !physical {
+		return d.MySQLDriver.StreamBackup(ctx, opts, writer)
+	}
+	_, err = d.runBackup(ctx, opts, os.TempDir(), writer)
+	return err
+}
+
+// Restore restores a logical dump into the server, or prepares a physical
+// backup in the restore_dir metadata for mariabackup --copy-back
+func (d *MariaDBDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+	fail := func(err error) (*database.RestoreResult, error) {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err)
+	}
+
+	file, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return fail(err)
+	}
+	defer file.Close()
+	physical, err := isPhysical(bufio.NewReader(file))
+	if err != nil {
+		return fail(err)
+	}
+	if !physical {
+		file.Close()
+		return d.MySQLDriver.Restore(ctx, opts)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	if err := d.prepare(ctx, opts, file); err != nil {
+		return fail(err)
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+	return result, nil
+}
+
+// StreamRestore restores a logical dump, or prepares a physical backup,
+// read from reader
+func (d *MariaDBDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	br := bufio.NewReader(reader)
+	physical, err := isPhysical(br)
+	if err != nil {
+		return err
+	}
+	if !physical {
+		return d.MySQLDriver.StreamRestore(ctx, opts, br)
+	}
+	return d.prepare(ctx, opts, br)
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *MariaDBDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	file, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer file.Close()
+	physical, err := isPhysical(bufio.NewReader(file))
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if !physical {
+		return d.MySQLDriver.ValidateRestore(ctx, opts)
+	}
+	if err := checkPrepare(opts); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	for _, tool := range []string{"mbstream", "mariabackup"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return pkgErrors.ErrValidationFailed(tool + " is not installed")
+		}
+	}
+	return nil
+}
+
+// GetType returns the database type
+func (d *MariaDBDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeMariaDB
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *MariaDBDriver) SupportsIncremental() bool {
+	return true // physical backups, with mariabackup
+}
+
+// physical reports whether opts are backed up with mariabackup.
+// opts.Physical forces it like backup_method=physical: the MySQL driver
+// taking the logical dumps would read it as a request for xtrabackup.
+func (d *MariaDBDriver) physical(ctx context.Context, opts *database.BackupOptions) (bool, error) {
+	method := d.config.Options["backup_method"]
+	if opts.Physical {
+		method = MethodPhysical
+	}
+	if method == MethodLogical {
+		return false, nil
+	}
+	partial := len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0
+	if method == MethodPhysical {
+		if partial {
+			return false, errors.New("physical backups are of the whole server, tables cannot be chosen")
+		}
+		return true, d.canBackup(ctx)
+	}
+	// Whole-server and incremental backups, when mariabackup can take them
+	if partial || (!opts.AllDatabases && !opts.Incremental) {
+		return false, nil
+	}
+	return d.canBackup(ctx) == nil, nil
+}
+
+// canBackup checks mariabackup is installed and the server's data
+// directory is on this host, which it reads
+func (d *MariaDBDriver) canBackup(ctx context.Context) error {
+	if _, err := exec.LookPath("mariabackup"); err != nil {
+		return errors.New("mariabackup is not installed")
+	}
+	datadir, err := d.ServerVariable(ctx, "datadir")
+	if err != nil {
+		return fmt.Errorf("failed to read the data directory: %w", err)
+	}
+	if _, err := os.Stat(datadir); err != nil {
+		return fmt.Errorf("the data directory %s is not on this host: mariabackup runs next to the server", datadir)
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package mariadb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// xbstreamMagic starts the archives mariabackup --stream=xbstream writes
+var xbstreamMagic = []byte("XBSTCK01")
+
+// BaseLSNKey is the backup metadata holding the to_lsn of the backup an
+// incremental backup builds on. Physical backups record theirs under
+// ToLSNKey.
+const (
+	BaseLSNKey = "mariabackup_base_lsn"
+	ToLSNKey   = "mariabackup_to_lsn"
+)
+
+// checkpointFiles are the names of the LSN file mariabackup writes, before
+// and since MariaDB 10.11
+var checkpointFiles = []string{"mariadb_backup_checkpoints", "xtrabackup_checkpoints"}
+
+// checkpoints is the LSN range of a physical backup
+type checkpoints struct {
+	Type    string // full-backuped, incremental or full-prepared
+	FromLSN int64
+	ToLSN   int64
+}
+
+// physicalBackup streams a mariabackup archive to opts.OutputPath
+func (d *MariaDBDriver) physicalBackup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	defer outputFile.Close()
+	output := stream.NewHashWriter(outputFile)
+	cp, err := d.runBackup(ctx, opts, filepath.Dir(opts.OutputPath), output)
+	if err != nil {
+		return fail(err)
+	}
+	info, err := outputFile.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	result.DatabaseVersion, _ = d.GetVersion(ctx)
+	result.Tables = d.tables(ctx)
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Size = info.Size()
+	result.Checksum = output.Sum()
+	backupType := "full"
+	if cp.Type == "incremental" {
+		backupType = "incremental"
+	}
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{
+		"backup_method":           MethodPhysical,
+		"mariabackup_backup_type": backupType,
+		"mariabackup_from_lsn":    strconv.FormatInt(cp.FromLSN, 10),
+		ToLSNKey:                  strconv.FormatInt(cp.ToLSN, 10),
+	})
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// runBackup runs mariabackup --backup, streaming the archive to w, and
+// returns the LSN range it covers. Its working files go under dir.
+func (d *MariaDBDriver) runBackup(ctx context.Context, opts *database.BackupOptions, dir string, w io.Writer) (*checkpoints, error) {
+	work, err := os.MkdirTemp(dir, "mariabackup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+	defaults, err := d.defaultsFile(work)
+	if err != nil {
+		return nil, err
+	}
+
+	lsnDir := filepath.Join(work, "lsn")
+	args := []string{
+		"--defaults-extra-file=" + defaults, // must come first
+		"--backup",
+		"--stream=xbstream",
+		"--target-dir=" + filepath.Join(work, "target"),
+		"--extra-lsndir=" + lsnDir,
+	}
+	if opts.Incremental {
+		if lsn := opts.Metadata[BaseLSNKey]; lsn != "" {
+			if _, err := strconv.ParseInt(lsn, 10, 64); err != nil {
+				return nil, fmt.Errorf("%s %q is not an LSN", BaseLSNKey, lsn)
+			}
+			args = append(args, "--incremental-lsn="+lsn)
+		}
+	}
+	if opts.Parallel > 1 {
+		args = append(args, fmt.Sprintf("--parallel=%d", opts.Parallel))
+	}
+
+	cmd := exec.CommandContext(ctx, "mariabackup", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	run := telemetry.StartCommand(ctx, cmd)
+	cmd.Stdout = run.Count(w)
+	err = cmd.Run()
+	run.End(err, run.Counted())
+	if err != nil {
+		return nil, fmt.Errorf("mariabackup failed: %w: %s", err, tail(stderr.Bytes()))
+	}
+	return readCheckpoints(lsnDir)
+}
+
+// prepare extracts a physical backup into the restore_dir metadata and
+// prepares it. A full backup needs an empty directory; an incremental one
+// is applied to the prepared backup there, which must end where it starts.
+// The server is never touched: stop it and run mariabackup --copy-back
+// --target-dir=<restore_dir> to put the result in place.
+func (d *MariaDBDriver) prepare(ctx context.Context, opts *database.RestoreOptions, r io.Reader) error {
+	if err := checkPrepare(opts); err != nil {
+		return err
+	}
+	target := opts.Metadata["restore_dir"]
+	if err := os.MkdirAll(target, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(target)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		if err := runTool(ctx, r, "mbstream", "-x", "-C", target); err != nil {
+			return err
+		}
+		return runTool(ctx, nil, "mariabackup", "--prepare", "--target-dir="+target)
+	}
+
+	// An incremental backup, applied to the full one prepared before
+	base, err := readCheckpoints(target)
+	if err != nil {
+		return fmt.Errorf("restore_dir %s is neither empty nor a prepared backup: %w", target, err)
+	}
+	incremental, err := os.MkdirTemp(filepath.Dir(filepath.Clean(target)), ".mariabackup-incremental-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(incremental)
+	if err := runTool(ctx, r, "mbstream", "-x", "-C", incremental); err != nil {
+		return err
+	}
+	cp, err := readCheckpoints(incremental)
+	if err != nil {
+		return err
+	}
+	if cp.Type != "incremental" {
+		return fmt.Errorf("restore_dir %s already holds a backup; full backups are restored into an empty directory", target)
+	}
+	if cp.FromLSN != base.ToLSN {
+		return fmt.Errorf("the incremental backup starts at LSN %d but the backup in %s ends at %d; apply the backups in order", cp.FromLSN, target, base.ToLSN)
+	}
+	return runTool(ctx, nil, "mariabackup", "--prepare", "--target-dir="+target, "--incremental-dir="+incremental)
+}
+
+// checkPrepare checks opts can be honored by preparing a physical backup
+func checkPrepare(opts *database.RestoreOptions) error {
+	switch {
+	case opts.Metadata["restore_dir"] == "":
+		return errors.New("physical backups are prepared in a directory: set the restore_dir metadata")
+	case len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0:
+		return errors.New("physical backups restore the whole server, tables cannot be chosen")
+	case opts.Renamed():
+		return fmt.Errorf("physical backups restore the whole server: %w", database.ErrRenameUnsupported)
+	case opts.PointInTime != nil:
+		return errors.New("point-in-time restores are not supported for physical backups")
+	}
+	return nil
+}
+
+// runTool runs a MariaDB tool with stdin, returning the end of its output
+// on failure
+func runTool(ctx context.Context, stdin io.Reader, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = stdin
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	run := telemetry.StartCommand(ctx, cmd)
+	err := cmd.Run()
+	run.End(err, -1)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, tail(output.Bytes()))
+	}
+	return nil
+}
+
+// defaultsFile writes the connection settings, password included, to an
+// option file in dir readable by this user only, so they stay out of the
+// process list
+func (d *MariaDBDriver) defaultsFile(dir string) (string, error) {
+	quote := func(v string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	}
+	var b strings.Builder
+	b.WriteString("[client]\n")
+	fmt.Fprintf(&b, "host=%s\nport=%d\nuser=%s\n", quote(d.config.Host), d.config.Port, quote(d.config.Username))
+	if d.config.Password != "" {
+		fmt.Fprintf(&b, "password=%s\n", quote(d.config.Password))
+	}
+	path := filepath.Join(dir, "client.cnf")
+	return path, os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+// tables returns every table of the server, qualified with its database
+func (d *MariaDBDriver) tables(ctx context.Context) []database.TableInfo {
+	databases, err := d.GetDatabases(ctx)
+	if err != nil {
+		return nil
+	}
+	var tables []database.TableInfo
+	for _, db := range databases {
+		names, err := d.GetTables(ctx, db)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			size, _ := d.GetTableSize(ctx, db, name)
+			tables = append(tables, database.TableInfo{Name: db + "." + name, DataSize: size})
+		}
+	}
+	return tables
+}
+
+// isPhysical reports whether the artifact r starts is a mariabackup
+// archive
+func isPhysical(r *bufio.Reader) (bool, error) {
+	head, err := r.Peek(len(xbstreamMagic))
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.Equal(head, xbstreamMagic), nil
+}
+
+// readCheckpoints reads the LSN file of a backup in dir
+func readCheckpoints(dir string) (*checkpoints, error) {
+	var data []byte
+	var err error
+	for _, name := range checkpointFiles {
+		if data, err = os.ReadFile(filepath.Join(dir, name)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no %s: %w", checkpointFiles[0], err)
+	}
+	return parseCheckpoints(data)
+}
+
+// parseCheckpoints parses "key = value" lines, e.g.
+//
+//	backup_type = incremental
+//	from_lsn = 1608376
+//	to_lsn = 1612840
+func parseCheckpoints(data []byte) (*checkpoints, error) {
+	cp := &checkpoints{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), "=")
+		if !ok {
+			continue
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		var err error
+		switch k {
+		case "backup_type":
+			cp.Type = v
+		case "from_lsn":
+			cp.FromLSN, err = strconv.ParseInt(v, 10, 64)
+		case "to_lsn":
+			cp.ToLSN, err = strconv.ParseInt(v, 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", k, err)
+		}
+	}
+	if cp.Type == "" || cp.ToLSN == 0 {
+		return nil, errors.New("invalid checkpoints file")
+	}
+	return cp, nil
+}
+
+// tail returns the last lines of a tool's output, where its error is
+func tail(output []byte) string {
+	const max = 4096
+	if len(output) > max {
+		output = output[len(output)-max:]
+		if i := bytes.IndexByte(output, '\n'); i >= 0 {
+			output = output[i+1:]
+		}
+	}
+	return string(bytes.TrimSpace(output))
+}
@@ -0,0 +1,152 @@
+package mariadb
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestParseCheckpoints(t *testing.T) {
+	cp, err := parseCheckpoints([]byte("backup_type = incremental\nfrom_lsn = 1608376\nto_lsn = 1612840\nlast_lsn = 1612849\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Type != "incremental" || cp.FromLSN != 1608376 || cp.ToLSN != 1612840 {
+		t.Errorf("got %+v", cp)
+	}
+
+	for _, data := range []string{"", "backup_type = full-backuped\n", "backup_type = full-backuped\nto_lsn = x\n"} {
+		if _, err := parseCheckpoints([]byte(data)); err == nil {
+			t.Errorf("%q: expected an error", data)
+		}
+	}
+}
+
+func TestIsPhysical(t *testing.T) {
+	for input, want := range map[string]bool{
+		"XBSTCK01\x00\x00rest": true,
+		"-- MariaDB dump":      false,
+		"XBST":                 false,
+		"":                     false,
+	} {
+		got, err := isPhysical(bufio.NewReader(strings.NewReader(input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%q: got %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestDefaultsFile(t *testing.T) {
+	d := &MariaDBDriver{config: &database.ConnectionConfig{Host: "db-1", Port: 3306, Username: "backup", Password: `p"a\ss`}}
+	path, err := d.defaultsFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[client]\nhost=\"db-1\"\nport=3306\nuser=\"backup\"\npassword=\"p\\\"a\\\\ss\"\n"
+	if string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("mode %v, want 0600", info.Mode().Perm())
+		}
+	}
+}
+
+func TestConnectRejectsUnknownMethod(t *testing.T) {
+	err := NewMariaDBDriver().Connect(context.Background(), &database.ConnectionConfig{Options: map[string]string{"backup_method": "snapshot"}})
+	if err == nil || !strings.Contains(err.Error(), "snapshot") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCheckPrepare(t *testing.T) {
+	for _, opts := range []*database.RestoreOptions{
+		{},
+		{Metadata: map[string]string{"restore_dir": "/restore"}, Tables: []string{"shop.orders"}},
+	} {
+		if err := checkPrepare(opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+	if err := checkPrepare(&database.RestoreOptions{Metadata: map[string]string{"restore_dir": "/restore"}}); err != nil {
+		t.Error(err)
+	}
+}
+
+// fakeTools puts mbstream and mariabackup scripts first in PATH: mbstream
+// extracts its input, minus the archive header, as the checkpoints file and
+// mariabackup logs its arguments
+func fakeTools(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+	bin := t.TempDir()
+	log := filepath.Join(bin, "mariabackup.log")
+	scripts := map[string]string{
+		"mbstream":    "#!/bin/sh\ntail -c +9 > \"$3/xtrabackup_checkpoints\"\n",
+		"mariabackup": "#!/bin/sh\necho \"$@\" >> " + log + "\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func archive(checkpoints string) *strings.Reader {
+	return strings.NewReader(string(xbstreamMagic) + checkpoints)
+}
+
+func TestPrepareIncrementalChain(t *testing.T) {
+	log := fakeTools(t)
+	ctx := context.Background()
+	d := NewMariaDBDriver()
+	target := filepath.Join(t.TempDir(), "restore")
+	opts := &database.RestoreOptions{Metadata: map[string]string{"restore_dir": target}}
+
+	if err := d.prepare(ctx, opts, archive("backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 100\n")); err != nil {
+		t.Fatal(err)
+	}
+	// The fake prepare leaves the full backup's checkpoints, ending at 100
+	err := d.prepare(ctx, opts, archive("backup_type = incremental\nfrom_lsn = 90\nto_lsn = 200\n"))
+	if err == nil || !strings.Contains(err.Error(), "apply the backups in order") {
+		t.Errorf("out of order incremental: got %v", err)
+	}
+	err = d.prepare(ctx, opts, archive("backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 300\n"))
+	if err == nil || !strings.Contains(err.Error(), "empty directory") {
+		t.Errorf("second full backup: got %v", err)
+	}
+	if err := d.prepare(ctx, opts, archive("backup_type = incremental\nfrom_lsn = 100\nto_lsn = 200\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(calls) != 2 || calls[0] != "--prepare --target-dir="+target ||
+		!strings.HasPrefix(calls[1], "--prepare --target-dir="+target+" --incremental-dir=") {
+		t.Errorf("mariabackup calls: %q", calls)
+	}
+}
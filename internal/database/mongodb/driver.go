@@ -13,6 +13,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
 	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
 	"github.com/sanskarpan/db-backup/pkg/utils"
 	"github.com/sanskarpan/db-backup/pkg/validation"
@@ -23,6 +24,8 @@ type MongoDBDriver struct {
 	client      *mongo.Client
 	config      *database.ConnectionConfig
 	pitrManager *PITRManager
+	certKeyFile string // client certificate and key of the tools
+	tempKeyFile bool   // certKeyFile was written by Connect
 }
 
 func init() {
@@ -46,26 +49,50 @@ func (d *MongoDBDriver) Connect(ctx context.Context, config *database.Connection
 		ApplyURI(connectionString).
 		SetMaxPoolSize(uint64(config.MaxConnections))
 
+	tlsConfig, err := config.ClientTLS()
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if tlsConfig != nil {
+		clientOpts.SetTLSConfig(tlsConfig)
+	}
+	certKeyFile, temporary, err := writeCertificateKeyFile(config)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	removeKeyFile := func() {
+		if temporary {
+			os.Remove(certKeyFile)
+		}
+	}
+
 	// Connect to MongoDB
 	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
+		removeKeyFile()
 		return pkgErrors.ErrDatabaseConnection(err)
 	}
 
 	// Test connection
 	if err := client.Ping(ctx, nil); err != nil {
 		client.Disconnect(ctx)
+		removeKeyFile()
 		return pkgErrors.ErrDatabaseConnection(err)
 	}
 
 	d.client = client
 	d.config = config
+	d.certKeyFile, d.tempKeyFile = certKeyFile, temporary
 	d.pitrManager = NewPITRManager(d)
 	return nil
 }
 
 // Disconnect closes the database connection
 func (d *MongoDBDriver) Disconnect() error {
+	if d.tempKeyFile {
+		os.Remove(d.certKeyFile)
+		d.certKeyFile, d.tempKeyFile = "", false
+	}
 	if d.client != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -84,10 +111,16 @@ func (d *MongoDBDriver) Ping(ctx context.Context) error {
 
 // Backup creates a backup of the MongoDB database
 func (d *MongoDBDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	// Collections are scheduled by size when a database is dumped on
+	// several workers
+	if scheduled(opts) {
+		return d.scheduledBackup(ctx, opts)
+	}
+
 	result := &database.BackupResult{
 		ID:        utils.GenerateBackupID(),
 		StartTime: time.Now(),
-		Metadata:  opts.Metadata,
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
 		Status:    database.BackupStatusInProgress,
 	}
 
@@ -102,6 +135,10 @@ func (d *MongoDBDriver) Backup(ctx context.Context, opts *database.BackupOptions
 	// Create mongodump command
 	cmd := exec.CommandContext(ctx, "mongodump", args...)
 
+	// Trace the run; the span records the exit code and dump size
+	run := telemetry.StartCommand(ctx, cmd)
+	defer func() { run.End(result.Error, result.Size) }()
+
 	// Capture stderr for errors
 	stderrPipe, err := cmd.StderrPipe()
 	if err != nil {
@@ -207,6 +244,9 @@ func (d *MongoDBDriver) Restore(ctx context.Context, opts *database.RestoreOptio
 	// Create command
 	cmd := exec.CommandContext(ctx, "mongorestore", args...)
 
+	run := telemetry.StartCommand(ctx, cmd)
+	defer func() { run.End(result.Error, -1) }()
+
 	// Capture stderr
 	stderrPipe, _ := cmd.StderrPipe()
 
@@ -239,6 +279,13 @@ func (d *MongoDBDriver) restoreWithPITR(ctx context.Context, opts *database.Rest
 		Status:    database.RestoreStatusInProgress,
 	}
 
+	// The oplog is replayed into the namespaces it was written for
+	if opts.Renamed() {
+		result.Status = database.RestoreStatusFailed
+		result.Error = fmt.Errorf("point-in-time restores replay the oplog under its own database names: %w", database.ErrRenameUnsupported)
+		return result, result.Error
+	}
+
 	// Extract oplog directory from metadata
 	oplogDir, ok := opts.Metadata["oplog_dir"]
 	if !ok || oplogDir == "" {
@@ -247,6 +294,11 @@ func (d *MongoDBDriver) restoreWithPITR(ctx context.Context, opts *database.Rest
 		return result, result.Error
 	}
 
+	// Oplog archives kept by ArchiveLogs are replayed onto the base backup
+	if _, err := database.ReadLogManifest(oplogDir); err == nil {
+		return d.recoverOplog(ctx, opts, oplogDir)
+	}
+
 	// Create PITR restore options
 	pitrOpts := &PITRRestoreOptions{
 		Database:         opts.Database,
@@ -405,6 +457,7 @@ func (d *MongoDBDriver) buildMongoDumpArgs(opts *database.BackupOptions) ([]stri
 	if d.config.Password != "" {
 		args = append(args, "--password", d.config.Password)
 	}
+	args = append(args, d.tlsArgs()...)
 
 	// Validate and add database name
 	if opts.Database != "" {
@@ -452,12 +505,29 @@ func (d *MongoDBDriver) buildMongoRestoreArgs(opts *database.RestoreOptions) ([]
 	if d.config.Password != "" {
 		args = append(args, "--password", d.config.Password)
 	}
+	args = append(args, d.tlsArgs()...)
 
 	// Validate and add database name
 	if opts.Database != "" {
 		if err := validation.ValidateDatabaseName(opts.Database); err != nil {
 			return nil, fmt.Errorf("invalid database name %q: %w", opts.Database, err)
 		}
+	}
+	if opts.Renamed() {
+		// The namespaces of the database are renamed as they are
+		// restored; MongoDB creates the target database with them
+		if opts.Database == "" {
+			return nil, fmt.Errorf("set the database of the backup restored as %s", opts.TargetDatabase)
+		}
+		if err := validation.ValidateDatabaseName(opts.TargetDatabase); err != nil {
+			return nil, fmt.Errorf("invalid database name %q: %w", opts.TargetDatabase, err)
+		}
+		args = append(args,
+			"--nsInclude", opts.Database+".*",
+			"--nsFrom", opts.Database+".*",
+			"--nsTo", opts.TargetDatabase+".*",
+		)
+	} else if opts.Database != "" {
 		args = append(args, "--db", opts.Database)
 	}
 
@@ -0,0 +1,136 @@
+package mongodb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func entry(t *testing.T, ts primitive.Timestamp, ns string) bson.Raw {
+	t.Helper()
+	data, err := bson.Marshal(bson.D{{Key: "ts", Value: ts}, {Key: "op", Value: "i"}, {Key: "ns", Value: ns}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestOplogArchiveResume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	archive, err := database.OpenLogArchive(dir, database.LogManifest{DatabaseType: database.DatabaseTypeMongoDB, Server: "rs0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &oplogWriter{dir: dir}
+	for i := uint32(1); i <= 3; i++ {
+		ts := primitive.Timestamp{T: 1760000000, I: i}
+		if err := w.write(ts, entry(t, ts, "shop.orders")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The file gets too old, the next entry starts another one
+	w.opened = time.Now().Add(-oplogFileAge)
+	second := primitive.Timestamp{T: 1760000600, I: 1}
+	w.write(second, entry(t, second, "shop.orders"))
+	if err := checkpointOplog(ctx, archive, w, nil); err != nil {
+		t.Fatal(err)
+	}
+	m := archive.Manifest()
+	if len(m.Files) != 1 || m.Files[0].Name != "oplog-1760000000-0000000001.bson" || m.Checkpoint.File != oplogFileName(second) {
+		t.Fatalf("manifest = %+v, checkpoint %+v", m.Files, m.Checkpoint)
+	}
+	w.close()
+
+	// A crash tears the entry being written
+	torn := entry(t, primitive.Timestamp{T: 1760000600, I: 2}, "shop.orders")
+	f, _ := os.OpenFile(filepath.Join(dir, oplogFileName(second)), os.O_APPEND|os.O_WRONLY, 0)
+	f.Write(torn[:len(torn)-3])
+	f.Close()
+
+	w, last, err := resumeOplog(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Equal(second) || w.name != oplogFileName(second) {
+		t.Errorf("resumed at %v in %s", last, w.name)
+	}
+	next := primitive.Timestamp{T: 1760000600, I: 3}
+	w.write(next, entry(t, next, "shop.orders"))
+	w.close()
+	f, _ = os.Open(filepath.Join(dir, oplogFileName(second)))
+	size, last, err := lastOplogEntry(f)
+	f.Close()
+	if err != nil || !last.Equal(next) || size != int64(2*len(torn)) {
+		t.Errorf("file holds %d bytes up to %v, %v", size, last, err)
+	}
+
+	// A file left without entries gives way to the last closed one
+	os.WriteFile(filepath.Join(dir, oplogFileName(second)), nil, 0o640)
+	w, last, err = resumeOplog(archive)
+	if err != nil || !last.Equal(primitive.Timestamp{T: 1760000000, I: 3}) || w.f != nil {
+		t.Errorf("resumed at %v, %v", last, err)
+	}
+}
+
+func TestWriteReplayOplog(t *testing.T) {
+	dir := t.TempDir()
+	write := func(entries ...bson.Raw) string {
+		ts, _ := oplogTimestamp(entries[0])
+		var data []byte
+		for _, e := range entries {
+			data = append(data, e...)
+		}
+		os.WriteFile(filepath.Join(dir, oplogFileName(ts)), data, 0o640)
+		return oplogFileName(ts)
+	}
+	ts := func(sec, i uint32) primitive.Timestamp { return primitive.Timestamp{T: sec, I: i} }
+	files := []string{
+		write(entry(t, ts(100, 1), "shop.orders"), entry(t, ts(150, 1), "shop.orders")),
+		write(entry(t, ts(200, 1), "shop.orders"), entry(t, ts(250, 1), "crm.leads"), entry(t, ts(260, 1), "admin.$cmd")),
+		write(entry(t, ts(300, 1), "shop.orders"), entry(t, ts(300, 2), "shop.items"), entry(t, ts(301, 1), "shop.orders")),
+		write(entry(t, ts(400, 1), "shop.orders")),
+	}
+
+	replay := func(start primitive.Timestamp, target int64, db string) []primitive.Timestamp {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "oplog.bson")
+		if err := writeReplayOplog(dir, files, start, time.Unix(target, 0), db, path); err != nil {
+			t.Fatal(err)
+		}
+		f, _ := os.Open(path)
+		defer f.Close()
+		var got []primitive.Timestamp
+		readOplog(f, func(e bson.Raw) error {
+			ts, _ := oplogTimestamp(e)
+			got = append(got, ts)
+			return nil
+		})
+		return got
+	}
+	if got := replay(ts(210, 0), 300, "shop"); !reflect.DeepEqual(got, []primitive.Timestamp{ts(260, 1), ts(300, 1), ts(300, 2)}) {
+		t.Errorf("replayed %v", got)
+	}
+	if got := replay(primitive.Timestamp{}, 200, ""); !reflect.DeepEqual(got, []primitive.Timestamp{ts(100, 1), ts(150, 1), ts(200, 1)}) {
+		t.Errorf("replayed %v without a start", got)
+	}
+}
+
+func TestOplogFileName(t *testing.T) {
+	ts := primitive.Timestamp{T: 1760000000, I: 42}
+	if got, ok := oplogFileStart(oplogFileName(ts)); !ok || !got.Equal(ts) {
+		t.Errorf("oplogFileStart = %v, %v", got, ok)
+	}
+	for _, name := range []string{"archive.json", "oplog-1-2.bson", "oplog-1760000000-0000000042.bson.tmp"} {
+		if _, ok := oplogFileStart(name); ok {
+			t.Errorf("%s taken for an oplog file", name)
+		}
+	}
+}
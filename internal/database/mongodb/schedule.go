@@ -0,0 +1,128 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// dumpCollection is a collection dumped by its own mongodump
+type dumpCollection struct {
+	Name string
+	Size int64 // collStats size, 0 for views
+}
+
+func (c dumpCollection) size() int64 { return c.Size }
+
+// scheduled reports whether opts are dumped one collection per mongodump,
+// largest first: mongodump --numParallelCollections takes collections in
+// its own order, so a big one started last keeps one worker busy long
+// after the others are done. It takes a single database on several
+// workers; --oplog is only taken by dumps of the whole instance.
+func scheduled(opts *database.BackupOptions) bool {
+	return opts.Parallel > 1 && opts.Database != "" && !opts.ConsistentBackup
+}
+
+// scheduledBackup dumps opts.Database into opts.OutputPath with one
+// mongodump per collection on opts.Parallel workers. The collections land
+// in the layout a single mongodump of the database writes, so the dump
+// restores the same way.
+func (d *MongoDBDriver) scheduledBackup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	collections, err := d.dumpCollections(ctx, opts)
+	if err != nil {
+		return fail(fmt.Errorf("listing collections: %w", err))
+	}
+	err = database.LargestFirst(ctx, collections, dumpCollection.size, opts.Parallel, func(ctx context.Context, c dumpCollection) error {
+		collOpts := *opts
+		collOpts.Tables = []string{c.Name}
+		collOpts.Parallel = 0
+		args, err := d.buildMongoDumpArgs(&collOpts)
+		if err != nil {
+			return err
+		}
+		cmd := exec.CommandContext(ctx, "mongodump", args...)
+		run := telemetry.StartCommand(ctx, cmd)
+		out, err := cmd.CombinedOutput()
+		run.End(err, -1)
+		if err != nil {
+			return fmt.Errorf("mongodump of %s failed: %w: %s", c.Name, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	totalSize, err := dirSize(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	result.DatabaseVersion, _ = d.GetVersion(ctx)
+	for _, c := range collections {
+		result.Tables = append(result.Tables, database.TableInfo{Name: c.Name, DataSize: c.Size})
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Size = totalSize
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// dumpCollections lists the collections and views of opts.Database to
+// dump, with their sizes
+func (d *MongoDBDriver) dumpCollections(ctx context.Context, opts *database.BackupOptions) ([]dumpCollection, error) {
+	specs, err := d.client.Database(opts.Database).ListCollectionSpecifications(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var collections []dumpCollection
+	for _, spec := range selectCollections(specs, opts) {
+		c := dumpCollection{Name: spec.Name}
+		if spec.Type != "view" {
+			if c.Size, err = d.GetTableSize(ctx, opts.Database, spec.Name); err != nil {
+				return nil, fmt.Errorf("sizing %s: %w", spec.Name, err)
+			}
+		}
+		collections = append(collections, c)
+	}
+	return collections, nil
+}
+
+// selectCollections returns the collections of specs opts dumps. Like
+// mongodump, it leaves out the system collections but system.js; views
+// are dumped as their definitions.
+func selectCollections(specs []*mongo.CollectionSpecification, opts *database.BackupOptions) []*mongo.CollectionSpecification {
+	var selected []*mongo.CollectionSpecification
+	for _, spec := range specs {
+		switch {
+		case strings.HasPrefix(spec.Name, "system.") && spec.Name != "system.js":
+		case len(opts.Tables) > 0 && !slices.Contains(opts.Tables, spec.Name):
+		case slices.Contains(opts.ExcludeTables, spec.Name):
+		default:
+			selected = append(selected, spec)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,42 @@
+package mongodb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestSelectCollections(t *testing.T) {
+	var specs []*mongo.CollectionSpecification
+	for _, name := range []string{"orders", "items", "system.views", "system.js", "daily_totals", "audit"} {
+		specs = append(specs, &mongo.CollectionSpecification{Name: name, Type: "collection"})
+	}
+	names := func(opts *database.BackupOptions) []string {
+		var got []string
+		for _, spec := range selectCollections(specs, opts) {
+			got = append(got, spec.Name)
+		}
+		return got
+	}
+	if got := names(&database.BackupOptions{ExcludeTables: []string{"audit"}}); !reflect.DeepEqual(got, []string{"orders", "items", "system.js", "daily_totals"}) {
+		t.Errorf("selected %v", got)
+	}
+	if got := names(&database.BackupOptions{Tables: []string{"items", "orders", "missing"}}); !reflect.DeepEqual(got, []string{"orders", "items"}) {
+		t.Errorf("selected %v of the tables", got)
+	}
+}
+
+func TestScheduled(t *testing.T) {
+	for opts, want := range map[*database.BackupOptions]bool{
+		{Database: "shop", Parallel: 4}:                         true,
+		{Database: "shop", Parallel: 1}:                         false,
+		{Parallel: 4, AllDatabases: true}:                       false,
+		{Database: "shop", Parallel: 4, ConsistentBackup: true}: false,
+	} {
+		if got := scheduled(opts); got != want {
+			t.Errorf("scheduled(%+v) = %v", opts, got)
+		}
+	}
+}
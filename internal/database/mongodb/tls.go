@@ -0,0 +1,68 @@
+package mongodb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// writeCertificateKeyFile returns the PEM file holding the client
+// certificate and its key, as the MongoDB tools take them. With a separate
+// key file both are copied to a temporary file readable by this user only,
+// which the caller removes; "" is returned without a client certificate.
+func writeCertificateKeyFile(config *database.ConnectionConfig) (path string, temporary bool, err error) {
+	if config.SSLCert == "" {
+		return "", false, nil
+	}
+	if config.SSLKey == "" || config.SSLKey == config.SSLCert {
+		return config.SSLCert, false, nil
+	}
+	cert, err := os.ReadFile(config.SSLCert)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+	key, err := os.ReadFile(config.SSLKey)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read client key: %w", err)
+	}
+	f, err := os.CreateTemp("", "mongodb-client-*.pem")
+	if err != nil {
+		return "", false, err
+	}
+	pem := append(append(bytes.TrimRight(cert, "\n"), '\n'), key...)
+	if _, err := f.Write(pem); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", false, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", false, err
+	}
+	return f.Name(), true, nil
+}
+
+// tlsArgs returns the TLS options of the mongodump and mongorestore
+// commands
+func (d *MongoDBDriver) tlsArgs() []string {
+	mode := d.config.TLSMode()
+	if mode == database.SSLDisable {
+		return nil
+	}
+	args := []string{"--tls"}
+	if d.config.SSLCA != "" {
+		args = append(args, "--tlsCAFile="+d.config.SSLCA)
+	}
+	if d.certKeyFile != "" {
+		args = append(args, "--tlsCertificateKeyFile="+d.certKeyFile)
+	}
+	switch mode {
+	case database.SSLRequire:
+		args = append(args, "--tlsInsecure")
+	case database.SSLVerifyCA:
+		args = append(args, "--tlsAllowInvalidHostnames")
+	}
+	return args
+}
@@ -0,0 +1,63 @@
+package mongodb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestWriteCertificateKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(cert, []byte("CERT"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(key, []byte("KEY\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	path, temporary, err := writeCertificateKeyFile(&database.ConnectionConfig{SSLCert: cert})
+	if err != nil || path != cert || temporary {
+		t.Errorf("combined file: got %s, %v, %v", path, temporary, err)
+	}
+
+	path, temporary, err = writeCertificateKeyFile(&database.ConnectionConfig{SSLCert: cert, SSLKey: key})
+	if err != nil || !temporary {
+		t.Fatalf("separate key: got %s, %v, %v", path, temporary, err)
+	}
+	defer os.Remove(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "CERT\nKEY\n" {
+		t.Errorf("combined PEM = %q", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("combined PEM mode = %v", info.Mode())
+	}
+}
+
+func TestTLSArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      database.ConnectionConfig
+		certKeyFile string
+		want        []string
+	}{
+		{"unset", database.ConnectionConfig{}, "", nil},
+		{"require", database.ConnectionConfig{SSLMode: "require"}, "", []string{"--tls", "--tlsInsecure"}},
+		{"verify-ca", database.ConnectionConfig{SSLMode: "verify-ca", SSLCA: "/tls/ca.pem"}, "", []string{"--tls", "--tlsCAFile=/tls/ca.pem", "--tlsAllowInvalidHostnames"}},
+		{"client certificate", database.ConnectionConfig{SSLCA: "/tls/ca.pem", SSLCert: "/tls/client.pem"}, "/tls/client.pem",
+			[]string{"--tls", "--tlsCAFile=/tls/ca.pem", "--tlsCertificateKeyFile=/tls/client.pem"}},
+	}
+	for _, tt := range tests {
+		d := &MongoDBDriver{config: &tt.config, certKeyFile: tt.certKeyFile}
+		if got := d.tlsArgs(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: tlsArgs() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+)
+
+// OptionServerID is the archive option holding the server ID mysqlbinlog
+// registers as a replica with. It must differ from those of the source and
+// of every other replica, or the source drops one of the connections.
+const OptionServerID = "server_id"
+
+// defaultServerID is the replica server ID used when none is configured,
+// far above the small numbers servers are usually given
+const defaultServerID = 4_000_000_017
+
+// reconnectDelay is the wait before reconnecting after the source dropped
+// the replication connection
+const reconnectDelay = 5 * time.Second
+
+// ArchiveLogs copies the binary log into opts.OutputDir as the source
+// writes it. mysqlbinlog connects as a replica and keeps each file under
+// its own name; every checkpoint lists the files the source has rotated
+// away from in the archive manifest, with their checksums. The archive
+// doubles as the binlog_dir of a point-in-time restore.
+//
+// A dropped connection is retried from the file being copied, which is
+// fetched again from its start. The source must still hold it: keep
+// binlog_expire_logs_seconds above the longest outage to ride out.
+func (d *MySQLDriver) ArchiveLogs(ctx context.Context, opts *database.ArchiveOptions) error {
+	if d.db == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	if opts.OutputDir == "" {
+		return pkgErrors.ErrValidationFailed("an output directory is required to archive binary logs")
+	}
+	serverID, err := binlogServerID(opts.Options)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	logBin, err := d.ServerVariable(ctx, "log_bin")
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if !strings.EqualFold(logBin, "ON") {
+		return pkgErrors.ErrValidationFailed("binary logging is disabled on the server")
+	}
+	server, _ := d.ServerVariable(ctx, "server_uuid")
+	if server == "" {
+		server = fmt.Sprintf("%s:%d", d.config.Host, d.config.Port)
+	}
+
+	archive, err := database.OpenLogArchive(opts.OutputDir, database.LogManifest{
+		DatabaseType: database.DatabaseTypeMySQL,
+		Server:       server,
+	})
+	if err != nil {
+		return err
+	}
+	start, err := d.binlogStart(ctx, archive.Manifest(), opts.StartFile)
+	if err != nil {
+		return err
+	}
+	interval := opts.CheckpointInterval
+	if interval <= 0 {
+		interval = database.DefaultCheckpointInterval
+	}
+
+	for {
+		progressed, err := d.streamBinlogs(ctx, archive, start, serverID, interval, opts.OnCheckpoint)
+		if ctx.Err() != nil {
+			return err
+		}
+		// A connection that never got anywhere will not get further
+		var exit *exec.ExitError
+		if !progressed || !errors.As(err, &exit) {
+			return err
+		}
+		start = archive.Manifest().Checkpoint.File
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// binlogStart returns the file to start copying from: the one an earlier
+// run was copying, start, or else the oldest the source holds
+func (d *MySQLDriver) binlogStart(ctx context.Context, m *database.LogManifest, start string) (string, error) {
+	if m.Checkpoint != nil && m.Checkpoint.File != "" {
+		return m.Checkpoint.File, nil
+	}
+	if start != "" {
+		if err := checkBinlogName(start); err != nil {
+			return "", pkgErrors.ErrValidationFailed(err.Error())
+		}
+		return start, nil
+	}
+
+	rows, err := d.db.QueryContext(ctx, "SHOW BINARY LOGS")
+	if err != nil {
+		return "", pkgErrors.ErrDatabaseConnection(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("the source holds no binary logs")
+	}
+	// Log_name comes first; the other columns vary between versions
+	values := make([]any, len(columns))
+	var name string
+	values[0] = &name
+	for i := 1; i < len(values); i++ {
+		values[i] = new(any)
+	}
+	if err := rows.Scan(values...); err != nil {
+		return "", err
+	}
+	return name, checkBinlogName(name)
+}
+
+// streamBinlogs runs mysqlbinlog from start until it exits or ctx ends,
+// checkpointing the archive every interval and once more at the end. It
+// reports whether the archive moved on.
+func (d *MySQLDriver) streamBinlogs(ctx context.Context, archive *database.LogArchive, start string, serverID uint32, interval time.Duration, onCheckpoint func(database.LogCheckpoint) error) (bool, error) {
+	before := database.LogCheckpoint{File: start}
+	if cp := archive.Manifest().Checkpoint; cp != nil {
+		before = *cp
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, "mysqlbinlog", d.buildMySQLBinlogArgs(archive.Dir(), start, serverID)...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", d.config.Password))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	run := telemetry.StartCommand(ctx, cmd)
+	if err := cmd.Start(); err != nil {
+		run.End(err, -1)
+		return false, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var err error
+	for stopped := false; !stopped; {
+		select {
+		case err = <-done:
+			stopped = true
+		case <-ticker.C:
+			if err = checkpointBinlogs(ctx, archive, onCheckpoint); err != nil {
+				cancel()
+				<-done
+				run.End(err, -1)
+				return false, err
+			}
+		}
+	}
+	run.End(err, -1)
+
+	// The last checkpoint has to land even when ctx ended the copy
+	if cerr := checkpointBinlogs(context.WithoutCancel(ctx), archive, onCheckpoint); cerr != nil {
+		return false, cerr
+	}
+	cp := archive.Manifest().Checkpoint
+	progressed := cp != nil && (cp.File != before.File || cp.Size > before.Size)
+	if ctx.Err() != nil {
+		return progressed, nil
+	}
+	if err == nil {
+		err = errors.New("exited")
+	}
+	return progressed, fmt.Errorf("mysqlbinlog failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+}
+
+// checkpointBinlogs checkpoints the archive at the file mysqlbinlog is
+// writing, if any
+func checkpointBinlogs(ctx context.Context, archive *database.LogArchive, onCheckpoint func(database.LogCheckpoint) error) error {
+	current, err := currentBinlog(archive.Dir())
+	if err != nil || current == "" {
+		return err
+	}
+	cp, err := archive.Checkpoint(ctx, current)
+	if err != nil {
+		return fmt.Errorf("checkpoint failed: %w", err)
+	}
+	if onCheckpoint != nil {
+		return onCheckpoint(*cp)
+	}
+	return nil
+}
+
+// currentBinlog returns the last binary log file in dir, the one being
+// written, or "" before the first one appears
+func currentBinlog(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var current string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && !strings.HasPrefix(name, database.LogManifestFile) && name > current {
+			current = name
+		}
+	}
+	return current, nil
+}
+
+// buildMySQLBinlogArgs builds the mysqlbinlog arguments copying the binary
+// log from start into dir, without ever stopping
+func (d *MySQLDriver) buildMySQLBinlogArgs(dir, start string, serverID uint32) []string {
+	args := append(d.serverArgs(),
+		fmt.Sprintf("--user=%s", d.config.Username),
+	)
+	args = append(args, d.sslArgs()...)
+	return append(args,
+		"--read-from-remote-server",
+		"--raw",        // keep the files as the source wrote them
+		"--stop-never", // wait for new events instead of exiting
+		fmt.Sprintf("--connection-server-id=%d", serverID),
+		"--verify-binlog-checksum",
+		"--result-file="+dir+string(filepath.Separator),
+		start,
+	)
+}
+
+// binlogServerID returns the configured replica server ID
+func binlogServerID(options map[string]string) (uint32, error) {
+	value := options[OptionServerID]
+	if value == "" {
+		return defaultServerID, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("%s %q is not a server ID", OptionServerID, value)
+	}
+	return uint32(id), nil
+}
+
+// checkBinlogName refuses binary log names that would not stay inside the
+// archive directory
+func checkBinlogName(name string) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid binary log name %q", name)
+	}
+	return nil
+}
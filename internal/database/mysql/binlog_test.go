@@ -0,0 +1,64 @@
+package mysql
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestBuildMySQLBinlogArgs(t *testing.T) {
+	d := &MySQLDriver{config: &database.ConnectionConfig{Host: "db1", Port: 3306, Username: "repl", Password: "secret"}}
+	args := strings.Join(d.buildMySQLBinlogArgs("/archive", "binlog.000042", 77), " ")
+	for _, want := range []string{"--host=db1", "--read-from-remote-server", "--raw", "--stop-never", "--connection-server-id=77", "--result-file=/archive/ binlog.000042"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q lack %q", args, want)
+		}
+	}
+	if strings.Contains(args, "secret") {
+		t.Error("the password is on the command line")
+	}
+}
+
+func TestBinlogServerID(t *testing.T) {
+	if id, err := binlogServerID(nil); err != nil || id != defaultServerID {
+		t.Errorf("default server ID = %d, %v", id, err)
+	}
+	if id, err := binlogServerID(map[string]string{OptionServerID: "900"}); err != nil || id != 900 {
+		t.Errorf("server ID = %d, %v", id, err)
+	}
+	for _, bad := range []string{"0", "-1", "4294967296", "x"} {
+		if _, err := binlogServerID(map[string]string{OptionServerID: bad}); err == nil {
+			t.Errorf("accepted server ID %q", bad)
+		}
+	}
+}
+
+func TestCheckBinlogName(t *testing.T) {
+	for _, name := range []string{"binlog.000001", "mysql-bin.000123"} {
+		if err := checkBinlogName(name); err != nil {
+			t.Errorf("checkBinlogName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../binlog.000001", "/var/lib/mysql/binlog.000001", ".hidden", "--help"} {
+		if err := checkBinlogName(name); err == nil {
+			t.Errorf("accepted %q", name)
+		}
+	}
+}
+
+func TestCurrentBinlog(t *testing.T) {
+	dir := t.TempDir()
+	if current, err := currentBinlog(dir); err != nil || current != "" {
+		t.Errorf("current of an empty archive = %q, %v", current, err)
+	}
+	for _, name := range []string{"binlog.000011", "binlog.000012", database.LogManifestFile, database.LogManifestFile + ".tmp"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o640)
+	}
+	os.Mkdir(filepath.Join(dir, "zz"), 0o750)
+	if current, err := currentBinlog(dir); err != nil || current != "binlog.000012" {
+		t.Errorf("current = %q, %v", current, err)
+	}
+}
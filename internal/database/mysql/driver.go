@@ -2,6 +2,7 @@
 package mysql
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
@@ -12,7 +13,9 @@ import (
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
 	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
 	"github.com/sanskarpan/db-backup/pkg/utils"
 	"github.com/sanskarpan/db-backup/pkg/validation"
 )
@@ -37,6 +40,28 @@ func NewMySQLDriver() *MySQLDriver {
 
 // Connect establishes a connection to the MySQL database
 func (d *MySQLDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	if config.Role != "" {
+		if err := validation.ValidateRoleName(config.Role); err != nil {
+			return pkgErrors.ErrDatabaseConnection(fmt.Errorf("invalid role %q: %w", config.Role, err))
+		}
+	}
+	switch config.Options["backup_method"] {
+	case "", MethodLogical, MethodPhysical:
+	default:
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("backup_method %q is not logical or physical", config.Options["backup_method"]))
+	}
+	switch config.Options["dump_format"] {
+	case "", FormatSQL, FormatNative:
+	default:
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("dump_format %q is not sql or native", config.Options["dump_format"]))
+	}
+
+	if config.ConnectionString == "" {
+		if err := registerTLS(config); err != nil {
+			return pkgErrors.ErrDatabaseConnection(err)
+		}
+	}
+
 	// Build DSN (Data Source Name)
 	dsn := d.buildDSN(config)
 
@@ -84,15 +109,34 @@ func (d *MySQLDriver) Ping(ctx context.Context) error {
 	return d.db.PingContext(ctx)
 }
 
-// Backup creates a backup of the MySQL database
+// Backup creates a backup of the MySQL database: a mysqldump, or a hot
+// physical backup of the whole server with xtrabackup when opts.Physical
+// or the backup_method connection option asks for one. opts.Incremental
+// makes a physical backup incremental when the to_lsn of the backup it
+// builds on is given as the xtrabackup_base_lsn metadata. The dump_format
+// connection option native dumps without mysqldump, as is done when it is
+// not installed.
 func (d *MySQLDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
 	result := &database.BackupResult{
 		ID:        utils.GenerateBackupID(),
 		StartTime: time.Now(),
-		Metadata:  opts.Metadata,
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
 		Status:    database.BackupStatusInProgress,
 	}
 
+	physical, err := d.physical(ctx, opts)
+	if err != nil {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+	if physical {
+		return d.physicalBackup(ctx, opts)
+	}
+	if d.nativeDump() {
+		return d.nativeBackup(ctx, opts)
+	}
+
 	// Build mysqldump command
 	args, err := d.buildMySQLDumpArgs(opts)
 	if err != nil {
@@ -107,6 +151,10 @@ func (d *MySQLDriver) Backup(ctx context.Context, opts *database.BackupOptions)
 	// Set password via environment variable for security
 	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", d.config.Password))
 
+	// Trace the run; the span records the exit code and dump size
+	run := telemetry.StartCommand(ctx, cmd)
+	defer func() { run.End(result.Error, result.Size) }()
+
 	// Create output file
 	outputFile, err := os.Create(opts.OutputPath)
 	if err != nil {
@@ -116,8 +164,9 @@ func (d *MySQLDriver) Backup(ctx context.Context, opts *database.BackupOptions)
 	}
 	defer outputFile.Close()
 
-	// Set command output to file
-	cmd.Stdout = outputFile
+	// Set command output to file, hashing the dump as it is written
+	output := stream.NewHashWriter(outputFile)
+	cmd.Stdout = output
 
 	// Capture stderr for errors
 	stderrPipe, err := cmd.StderrPipe()
@@ -165,6 +214,7 @@ func (d *MySQLDriver) Backup(ctx context.Context, opts *database.BackupOptions)
 	result.EndTime = time.Now()
 	result.Duration = result.EndTime.Sub(result.StartTime)
 	result.Size = fileInfo.Size()
+	result.Checksum = output.Sum()
 	result.DatabaseVersion = version
 	result.Tables = tables
 	result.Status = database.BackupStatusSuccess
@@ -174,6 +224,18 @@ func (d *MySQLDriver) Backup(ctx context.Context, opts *database.BackupOptions)
 
 // StreamBackup streams a backup to the provided writer
 func (d *MySQLDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	physical, err := d.physical(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if physical {
+		_, err = d.runXtrabackup(ctx, opts, os.TempDir(), writer)
+		return err
+	}
+	if d.nativeDump() {
+		return d.runNativeDump(ctx, opts, writer)
+	}
+
 	args, err := d.buildMySQLDumpArgs(opts)
 	if err != nil {
 		return err
@@ -181,9 +243,12 @@ func (d *MySQLDriver) StreamBackup(ctx context.Context, opts *database.BackupOpt
 
 	cmd := exec.CommandContext(ctx, "mysqldump", args...)
 	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", d.config.Password))
-	cmd.Stdout = writer
+	run := telemetry.StartCommand(ctx, cmd)
+	cmd.Stdout = run.Count(writer)
 
-	return cmd.Run()
+	err = cmd.Run()
+	run.End(err, run.Counted())
+	return err
 }
 
 // GetBackupSize estimates the size of a backup
@@ -203,7 +268,8 @@ func (d *MySQLDriver) GetBackupSize(ctx context.Context, opts *database.BackupOp
 	return totalSize, nil
 }
 
-// Restore restores a MySQL database from backup
+// Restore restores a MySQL database from backup, or prepares a physical
+// backup in the restore_dir metadata for xtrabackup --copy-back
 func (d *MySQLDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
 	result := &database.RestoreResult{
 		StartTime: time.Now(),
@@ -222,29 +288,53 @@ func (d *MySQLDriver) Restore(ctx context.Context, opts *database.RestoreOptions
 		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
 	}
 
-	// Validate database name if provided
-	if opts.Database != "" {
-		if err := validation.ValidateDatabaseName(opts.Database); err != nil {
+	// Physical backups are prepared without the server
+	if physical, err := isPhysicalFile(opts.SourceBackup); err != nil || physical {
+		if err == nil {
+			err = prepareFile(ctx, opts)
+		}
+		if err != nil {
 			result.Status = database.RestoreStatusFailed
 			result.Error = err
 			return result, pkgErrors.ErrDatabaseRestore(err)
 		}
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		result.Status = database.RestoreStatusSuccess
+		return result, nil
 	}
 
-	// Build mysql command
-	args := []string{
-		fmt.Sprintf("--host=%s", d.config.Host),
-		fmt.Sprintf("--port=%d", d.config.Port),
-		fmt.Sprintf("--user=%s", d.config.Username),
+	// Dumps taken without mysqldump are replayed without the mysql client
+	if native, err := isNativeDumpFile(opts.SourceBackup); err != nil || native {
+		if err == nil {
+			err = d.restoreNativeDumpFile(ctx, opts)
+		}
+		if err != nil {
+			result.Status = database.RestoreStatusFailed
+			result.Error = err
+			return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+		}
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		result.Status = database.RestoreStatusSuccess
+		return result, nil
 	}
 
+	// Validate database name if provided
 	if opts.Database != "" {
-		args = append(args, opts.Database)
+		if err := validation.ValidateDatabaseName(opts.Database); err != nil {
+			result.Status = database.RestoreStatusFailed
+			result.Error = err
+			return result, pkgErrors.ErrDatabaseRestore(err)
+		}
 	}
 
-	// Create command
-	cmd := exec.CommandContext(ctx, "mysql", args...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", d.config.Password))
+	// Build mysql command
+	args := append(d.serverArgs(),
+		fmt.Sprintf("--user=%s", d.config.Username),
+	)
+	args = append(args, d.sslArgs()...)
+	args = append(args, d.roleArgs()...)
 
 	// Open backup file
 	backupFile, err := os.Open(opts.SourceBackup)
@@ -255,8 +345,23 @@ func (d *MySQLDriver) Restore(ctx context.Context, opts *database.RestoreOptions
 	}
 	defer backupFile.Close()
 
+	target, dump, err := d.clientTarget(ctx, opts, backupFile)
+	if err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err)
+	}
+	args = append(args, target...)
+
+	// Create command
+	cmd := exec.CommandContext(ctx, "mysql", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", d.config.Password))
+
+	run := telemetry.StartCommand(ctx, cmd)
+	defer func() { run.End(result.Error, -1) }()
+
 	// Set stdin to backup file
-	cmd.Stdin = backupFile
+	cmd.Stdin = dump
 
 	// Capture stderr
 	stderrPipe, pipeErr := cmd.StderrPipe()
@@ -296,6 +401,13 @@ func (d *MySQLDriver) restoreWithPITR(ctx context.Context, opts *database.Restor
 		Status:    database.RestoreStatusInProgress,
 	}
 
+	// The binary logs are replayed into the databases they were written for
+	if opts.Renamed() {
+		result.Status = database.RestoreStatusFailed
+		result.Error = fmt.Errorf("point-in-time restores replay the binary log under its own database names: %w", database.ErrRenameUnsupported)
+		return result, result.Error
+	}
+
 	// Extract binary log directory from metadata
 	binlogDir, ok := opts.Metadata["binlog_dir"]
 	if !ok || binlogDir == "" {
@@ -327,23 +439,45 @@ func (d *MySQLDriver) restoreWithPITR(ctx context.Context, opts *database.Restor
 	return result, nil
 }
 
-// StreamRestore restores from a reader
+// StreamRestore restores from a reader, or prepares a physical backup read
+// from it
 func (d *MySQLDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
-	args := []string{
-		fmt.Sprintf("--host=%s", d.config.Host),
-		fmt.Sprintf("--port=%d", d.config.Port),
-		fmt.Sprintf("--user=%s", d.config.Username),
+	br := bufio.NewReader(reader)
+	physical, err := isPhysical(br)
+	if err != nil {
+		return err
+	}
+	if physical {
+		return prepare(ctx, opts, br)
+	}
+	native, err := isNativeDump(br)
+	if err != nil {
+		return err
+	}
+	if native {
+		return d.restoreNativeDump(ctx, opts, br)
 	}
 
-	if opts.Database != "" {
-		args = append(args, opts.Database)
+	args := append(d.serverArgs(),
+		fmt.Sprintf("--user=%s", d.config.Username),
+	)
+	args = append(args, d.sslArgs()...)
+	args = append(args, d.roleArgs()...)
+
+	target, dump, err := d.clientTarget(ctx, opts, br)
+	if err != nil {
+		return err
 	}
+	args = append(args, target...)
 
 	cmd := exec.CommandContext(ctx, "mysql", args...)
 	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", d.config.Password))
-	cmd.Stdin = reader
+	cmd.Stdin = dump
 
-	return cmd.Run()
+	run := telemetry.StartCommand(ctx, cmd)
+	err = cmd.Run()
+	run.End(err, -1)
+	return err
 }
 
 // ValidateRestore validates that a restore can be performed
@@ -353,6 +487,23 @@ func (d *MySQLDriver) ValidateRestore(ctx context.Context, opts *database.Restor
 		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
 	}
 
+	// Physical backups are prepared without the server
+	physical, err := isPhysicalFile(opts.SourceBackup)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if physical {
+		if err := checkPrepare(opts); err != nil {
+			return pkgErrors.ErrValidationFailed(err.Error())
+		}
+		for _, tool := range []string{"xbstream", "xtrabackup"} {
+			if _, err := exec.LookPath(tool); err != nil {
+				return pkgErrors.ErrValidationFailed(tool + " is not installed")
+			}
+		}
+		return nil
+	}
+
 	// Check database connection
 	if err := d.Ping(ctx); err != nil {
 		return pkgErrors.ErrValidationFailed("database connection failed")
@@ -423,6 +574,14 @@ func (d *MySQLDriver) GetVersion(ctx context.Context) (string, error) {
 	return version, err
 }
 
+// ServerVariable returns the value of a global system variable, e.g.
+// datadir
+func (d *MySQLDriver) ServerVariable(ctx context.Context, name string) (string, error) {
+	var variable, value string
+	err := d.db.QueryRowContext(ctx, "SHOW GLOBAL VARIABLES LIKE ?", name).Scan(&variable, &value)
+	return value, err
+}
+
 // GetType returns the database type
 func (d *MySQLDriver) GetType() database.DatabaseType {
 	return database.DatabaseTypeMySQL
@@ -438,23 +597,42 @@ func (d *MySQLDriver) SupportsPITR() bool {
 	return true // MySQL supports PITR via binary logs
 }
 
+// roleArgs activates the configured role in the mysql client. Restore
+// credentials usually hold their write rights through a role that is not
+// a default role, so it has to be set explicitly.
+func (d *MySQLDriver) roleArgs() []string {
+	if d.config.Role == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("--init-command=SET ROLE '%s'", d.config.Role)}
+}
+
 // buildDSN builds a MySQL DSN connection string
 func (d *MySQLDriver) buildDSN(config *database.ConnectionConfig) string {
 	if config.ConnectionString != "" {
 		return config.ConnectionString
 	}
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&timeout=%s",
+	address := fmt.Sprintf("tcp(%s:%d)", config.Host, config.Port)
+	if socket := config.UnixSocket(); socket != "" {
+		address = fmt.Sprintf("unix(%s)", socket)
+	}
+	dsn := fmt.Sprintf("%s:%s@%s/%s?parseTime=true&timeout=%s",
 		config.Username,
 		config.Password,
-		config.Host,
-		config.Port,
+		address,
 		config.Database,
 		config.ConnectionTimeout.String(),
 	)
+	if name := tlsConfigName(config); name != "" {
+		dsn += "&tls=" + name
+	}
 
 	if config.Options != nil {
 		for k, v := range config.Options {
+			if k == "backup_method" || k == "dump_format" {
+				continue // the driver's own, the server would refuse them
+			}
 			dsn += fmt.Sprintf("&%s=%s", k, v)
 		}
 	}
@@ -464,16 +642,15 @@ func (d *MySQLDriver) buildDSN(config *database.ConnectionConfig) string {
 
 // buildMySQLDumpArgs builds mysqldump command arguments
 func (d *MySQLDriver) buildMySQLDumpArgs(opts *database.BackupOptions) ([]string, error) {
-	args := []string{
-		fmt.Sprintf("--host=%s", d.config.Host),
-		fmt.Sprintf("--port=%d", d.config.Port),
+	args := append(d.serverArgs(),
 		fmt.Sprintf("--user=%s", d.config.Username),
 		"--single-transaction",  // Consistent snapshot
 		"--routines",             // Include stored procedures
 		"--triggers",             // Include triggers
 		"--events",               // Include events
 		"--skip-lock-tables",     // Don't lock tables
-	}
+	)
+	args = append(args, d.sslArgs()...)
 
 	// Database selection
 	if opts.AllDatabases {
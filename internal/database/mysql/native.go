@@ -0,0 +1,630 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// Dump formats of the dump_format connection option
+const (
+	FormatSQL    = "sql"    // mysqldump, the default with it installed
+	FormatNative = "native" // read with SELECT, without mysqldump
+)
+
+// nativeHeader starts the dumps taken without mysqldump
+const nativeHeader = "-- db-backup native MySQL dump"
+
+// nativeStatementSize is the size INSERT statements of dumps taken without
+// mysqldump are cut at, well below the default max_allowed_packet
+const nativeStatementSize = 1 << 20
+
+// systemSchemas are left out of dumps of all databases taken without
+// mysqldump: they belong to the server
+var systemSchemas = []string{"information_schema", "mysql", "performance_schema", "sys"}
+
+// nativeColumn is a column of a table dumped without mysqldump
+type nativeColumn struct {
+	Name string
+	Kind byte // n(umber), b(inary) or s(tring)
+}
+
+// columnKind returns how the values of a column of the information_schema
+// DATA_TYPE typ are written: numbers as they are read, binary data as hex
+// literals, the rest as strings
+func columnKind(typ string) byte {
+	switch strings.ToLower(typ) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "decimal", "numeric", "float", "double", "year":
+		return 'n'
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "bit",
+		"geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection", "geomcollection":
+		return 'b'
+	}
+	return 's'
+}
+
+// nativeDump reports whether dumps are taken without mysqldump: when the
+// dump_format connection option asks for it, or by default when mysqldump
+// is not installed, as in a container image without the client tools
+func (d *MySQLDriver) nativeDump() bool {
+	switch d.config.Options["dump_format"] {
+	case FormatNative:
+		return true
+	case "":
+		_, err := exec.LookPath("mysqldump")
+		return err != nil
+	}
+	return false
+}
+
+// nativeBackup writes a dump taken without mysqldump to opts.OutputPath
+func (d *MySQLDriver) nativeBackup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err).WithMetadata("output_path", opts.OutputPath)
+	}
+
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	defer outputFile.Close()
+	output := stream.NewHashWriter(outputFile)
+	if err := d.runNativeDump(ctx, opts, output); err != nil {
+		return fail(err)
+	}
+	info, err := outputFile.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	result.DatabaseVersion, _ = d.GetVersion(ctx)
+	if opts.Database != "" && len(opts.Databases) == 0 && !opts.AllDatabases {
+		result.Tables, _ = d.getTableInfo(ctx, opts.Database)
+	} else {
+		result.Tables = d.serverTables(ctx)
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Size = info.Size()
+	result.Checksum = output.Sum()
+	// The caller's metadata is not ours to change
+	result.Metadata = maps.Clone(result.Metadata)
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]string)
+	}
+	result.Metadata["dump_format"] = FormatNative
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// runNativeDump writes a dump of opts to w without mysqldump, as SQL the
+// mysql client replays too. Like mysqldump --single-transaction, the data
+// is read in one consistent snapshot, so InnoDB tables are consistent with
+// each other without being locked. A single database is dumped without
+// CREATE DATABASE, to restore under any name; several are dumped with it.
+func (d *MySQLDriver) runNativeDump(ctx context.Context, opts *database.BackupOptions, w io.Writer) error {
+	schemas, create, err := d.nativeSchemas(ctx, opts)
+	if err != nil {
+		return err
+	}
+	for _, table := range append(append([]string{}, opts.Tables...), opts.ExcludeTables...) {
+		if err := validation.ValidateTableName(table); err != nil {
+			return fmt.Errorf("invalid table name %q: %w", table, err)
+		}
+	}
+
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Timestamps are dumped in UTC, and SHOW CREATE quotes with backticks
+	for _, statement := range []string{
+		"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"SET SESSION time_zone = '+00:00'",
+		"SET SESSION sql_mode = ''",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY",
+	} {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("starting the snapshot: %w", err)
+		}
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+
+	bw := bufio.NewWriterSize(w, stream.Default().BufferSize())
+	version, _ := d.GetVersion(ctx)
+	fmt.Fprintf(bw, "%s\n-- Server version: %s\n-- Dumped at %s\n\n", nativeHeader, version, time.Now().UTC().Format(time.RFC3339))
+	bw.WriteString("SET NAMES utf8mb4;\nSET TIME_ZONE = '+00:00';\nSET FOREIGN_KEY_CHECKS = 0;\nSET UNIQUE_CHECKS = 0;\nSET SQL_MODE = 'NO_AUTO_VALUE_ON_ZERO';\n")
+	for _, schema := range schemas {
+		if err := dumpSchema(ctx, conn, bw, schema, create, true, opts); err != nil {
+			return fmt.Errorf("dumping %s: %w", schema, err)
+		}
+	}
+	bw.WriteString("\nSET FOREIGN_KEY_CHECKS = 1;\nSET UNIQUE_CHECKS = 1;\n-- Dump completed\n")
+	return bw.Flush()
+}
+
+// nativeSchemas returns the databases opts dumps, and whether they are
+// created by the dump
+func (d *MySQLDriver) nativeSchemas(ctx context.Context, opts *database.BackupOptions) ([]string, bool, error) {
+	switch {
+	case opts.AllDatabases:
+		databases, err := d.GetDatabases(ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("listing databases: %w", err)
+		}
+		var schemas []string
+		for _, db := range databases {
+			if !slices.Contains(systemSchemas, db) {
+				schemas = append(schemas, db)
+			}
+		}
+		return schemas, true, nil
+	case len(opts.Databases) > 0:
+		for _, db := range opts.Databases {
+			if err := validation.ValidateDatabaseName(db); err != nil {
+				return nil, false, fmt.Errorf("invalid database name %q: %w", db, err)
+			}
+		}
+		return opts.Databases, true, nil
+	case opts.Database != "":
+		if err := validation.ValidateDatabaseName(opts.Database); err != nil {
+			return nil, false, fmt.Errorf("invalid database name %q: %w", opts.Database, err)
+		}
+		return []string{opts.Database}, false, nil
+	}
+	return nil, false, errors.New("no database to dump was given")
+}
+
+// dumpSchema writes the tables, views, routines, triggers and events of
+// schema, with the rows of the tables when data is set. Like mysqldump,
+// the table selection of opts applies to a single database only.
+func dumpSchema(ctx context.Context, conn *sql.Conn, w *bufio.Writer, schema string, create, data bool, opts *database.BackupOptions) error {
+	if create {
+		var name, ddl string
+		if err := conn.QueryRowContext(ctx, "SHOW CREATE DATABASE IF NOT EXISTS "+quoteName(schema)).Scan(&name, &ddl); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n%s;\nUSE %s;\n", ddl, quoteName(schema))
+	}
+	// SHOW CREATE leaves out the name of the current database, so the
+	// definitions restore into any
+	if _, err := conn.ExecContext(ctx, "USE "+quoteName(schema)); err != nil {
+		return err
+	}
+
+	var tables, views []string
+	err := queryRows(ctx, conn, "SELECT TABLE_NAME, TABLE_TYPE FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME", []any{schema}, func(scan func(...any) error) error {
+		var name, typ string
+		if err := scan(&name, &typ); err != nil {
+			return err
+		}
+		switch {
+		case !create && len(opts.Tables) > 0 && !slices.Contains(opts.Tables, name):
+		case !create && slices.Contains(opts.ExcludeTables, name):
+		case typ == "VIEW":
+			views = append(views, name)
+		default:
+			tables = append(tables, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		var name, ddl string
+		if err := conn.QueryRowContext(ctx, "SHOW CREATE TABLE "+quoteName(table)).Scan(&name, &ddl); err != nil {
+			return err
+		}
+		if !data {
+			// The next value of the counter moves with the rows
+			ddl = autoIncrement.ReplaceAllString(ddl, "")
+		}
+		fmt.Fprintf(w, "\n--\n-- Table %s\n--\n\nDROP TABLE IF EXISTS %s;\n%s;\n", table, quoteName(table), ddl)
+		if !data {
+			continue
+		}
+		if err := dumpRows(ctx, conn, w, schema, table); err != nil {
+			return fmt.Errorf("dumping %s: %w", table, err)
+		}
+	}
+
+	// Views after the tables, each after the views it selects from
+	definitions := make(map[string]string, len(views))
+	for _, view := range views {
+		var name, ddl, charset, collation string
+		if err := conn.QueryRowContext(ctx, "SHOW CREATE VIEW "+quoteName(view)).Scan(&name, &ddl, &charset, &collation); err != nil {
+			return err
+		}
+		definitions[view] = ddl
+	}
+	for _, view := range viewOrder(views, definitions) {
+		fmt.Fprintf(w, "\nDROP VIEW IF EXISTS %s;\n%s;\n", quoteName(view), definitions[view])
+	}
+
+	// Routines, triggers and events have bodies of several statements,
+	// kept whole by another delimiter
+	type object struct{ kind, name, ddl string }
+	var objects []object
+	collect := func(query, kind string, column int, keep func(table string) bool) error {
+		var names []string
+		err := queryRows(ctx, conn, query, []any{schema}, func(scan func(...any) error) error {
+			var name, table string
+			if err := scan(&name, &table); err != nil {
+				return err
+			}
+			if keep(table) {
+				names = append(names, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			rows, err := conn.QueryContext(ctx, "SHOW CREATE "+kind+" "+quoteName(name))
+			if err != nil {
+				return err
+			}
+			ddl, err := scanColumn(rows, column)
+			if err != nil {
+				return fmt.Errorf("reading %s %s: %w", strings.ToLower(kind), name, err)
+			}
+			objects = append(objects, object{kind, name, ddl})
+		}
+		return nil
+	}
+	whole := func(string) bool { return create || len(opts.Tables) == 0 }
+	err = collect("SELECT ROUTINE_NAME, '' FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ? AND ROUTINE_TYPE = 'FUNCTION' ORDER BY 1", "FUNCTION", 2, whole)
+	if err == nil {
+		err = collect("SELECT ROUTINE_NAME, '' FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ? AND ROUTINE_TYPE = 'PROCEDURE' ORDER BY 1", "PROCEDURE", 2, whole)
+	}
+	if err == nil {
+		err = collect("SELECT TRIGGER_NAME, EVENT_OBJECT_TABLE FROM information_schema.TRIGGERS WHERE TRIGGER_SCHEMA = ? ORDER BY EVENT_OBJECT_TABLE, ACTION_ORDER", "TRIGGER", 2,
+			func(table string) bool { return slices.Contains(tables, table) })
+	}
+	if err == nil {
+		err = collect("SELECT EVENT_NAME, '' FROM information_schema.EVENTS WHERE EVENT_SCHEMA = ? ORDER BY 1", "EVENT", 3, whole)
+	}
+	if err != nil {
+		return err
+	}
+	if len(objects) > 0 {
+		w.WriteString("\nDELIMITER ;;\n")
+		for _, o := range objects {
+			fmt.Fprintf(w, "DROP %s IF EXISTS %s;;\n%s;;\n", o.kind, quoteName(o.name), o.ddl)
+		}
+		w.WriteString("DELIMITER ;\n")
+	}
+	return nil
+}
+
+// dumpRows writes the rows of table as INSERT statements of at most
+// nativeStatementSize bytes. Generated columns are computed again by the
+// restore, so they are left out.
+func dumpRows(ctx context.Context, conn *sql.Conn, w *bufio.Writer, schema, table string) error {
+	var columns []nativeColumn
+	err := queryRows(ctx, conn, `SELECT COLUMN_NAME, DATA_TYPE FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND EXTRA NOT LIKE '%GENERATED%'
+		ORDER BY ORDINAL_POSITION`, []any{schema, table}, func(scan func(...any) error) error {
+		var c nativeColumn
+		var typ string
+		if err := scan(&c.Name, &typ); err != nil {
+			return err
+		}
+		c.Kind = columnKind(typ)
+		columns = append(columns, c)
+		return nil
+	})
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = quoteName(c.Name)
+	}
+	list := strings.Join(names, ", ")
+	rows, err := conn.QueryContext(ctx, "SELECT "+list+" FROM "+quoteName(schema)+"."+quoteName(table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	values := make([]sql.RawBytes, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	insert := "INSERT INTO " + quoteName(table) + " (" + list + ") VALUES\n"
+	var statement []byte
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		if len(statement) == 0 {
+			statement = append(statement, insert...)
+		} else {
+			statement = append(statement, ",\n"...)
+		}
+		statement = appendRow(statement, columns, values)
+		if len(statement) >= nativeStatementSize {
+			w.Write(statement)
+			w.WriteString(";\n")
+			statement = statement[:0]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(statement) > 0 {
+		w.Write(statement)
+		w.WriteString(";\n")
+	}
+	return nil
+}
+
+// appendRow appends the values of a row as a parenthesized SQL list
+func appendRow(b []byte, columns []nativeColumn, values []sql.RawBytes) []byte {
+	b = append(b, '(')
+	for i, v := range values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		switch {
+		case v == nil:
+			b = append(b, "NULL"...)
+		case columns[i].Kind == 'n':
+			b = append(b, v...)
+		case columns[i].Kind == 'b' && len(v) > 0:
+			b = append(b, "0x"...)
+			b = hex.AppendEncode(b, v)
+		default:
+			b = appendString(b, v)
+		}
+	}
+	return append(b, ')')
+}
+
+// appendString appends s as a quoted MySQL string literal, escaped as
+// mysqldump does, so a row stays on one line
+func appendString(b, s []byte) []byte {
+	b = append(b, '\'')
+	for _, c := range s {
+		switch c {
+		case 0:
+			b = append(b, '\\', '0')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case 0x1a:
+			b = append(b, '\\', 'Z')
+		case '\'', '"', '\\':
+			b = append(b, '\\', c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return append(b, '\'')
+}
+
+// viewOrder orders views so each comes after the views its definition
+// refers to. Definitions name the views they select from with backticks.
+func viewOrder(views []string, definitions map[string]string) []string {
+	var ordered []string
+	done := make(map[string]bool, len(views))
+	var visit func(view string, path map[string]bool)
+	visit = func(view string, path map[string]bool) {
+		if done[view] || path[view] {
+			return
+		}
+		path[view] = true
+		for _, other := range views {
+			if other != view && strings.Contains(definitions[view], quoteName(other)) {
+				visit(other, path)
+			}
+		}
+		done[view] = true
+		ordered = append(ordered, view)
+	}
+	for _, view := range views {
+		visit(view, make(map[string]bool))
+	}
+	return ordered
+}
+
+// quoteName quotes an identifier with backticks
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// queryRows runs a query on conn, calling fn for each row with the
+// function scanning it
+func queryRows(ctx context.Context, conn *sql.Conn, query string, args []any, fn func(scan func(...any) error) error) error {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// scanColumn returns the column at index i of the single row of rows,
+// which it closes. SHOW CREATE statements return their definition among
+// a varying number of columns.
+func scanColumn(rows *sql.Rows, i int) (string, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", sql.ErrNoRows
+	}
+	values := make([]sql.NullString, len(columns))
+	ptrs := make([]any, len(columns))
+	for j := range values {
+		ptrs[j] = &values[j]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return "", err
+	}
+	if i >= len(values) || !values[i].Valid {
+		return "", errors.New("no definition returned, the SHOW_ROUTINE privilege or ownership may be missing")
+	}
+	return values[i].String, nil
+}
+
+// isNativeDump reports whether r starts with a dump taken without
+// mysqldump, without consuming it
+func isNativeDump(r *bufio.Reader) (bool, error) {
+	header, err := r.Peek(len(nativeHeader))
+	if errors.Is(err, io.EOF) || errors.Is(err, bufio.ErrBufferFull) {
+		return false, nil
+	}
+	return string(header) == nativeHeader, err
+}
+
+// isNativeDumpFile reports whether the file at path is a dump taken
+// without mysqldump
+func isNativeDumpFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isNativeDump(bufio.NewReader(f))
+}
+
+// restoreNativeDump replays the dump taken without mysqldump read from r
+// into opts.Database, or the databases it creates, without the mysql
+// client: statement by statement, on one connection. With
+// opts.TargetDatabase, only that database is restored, under the new name.
+func (d *MySQLDriver) restoreNativeDump(ctx context.Context, opts *database.RestoreOptions, r io.Reader) error {
+	if opts.Database != "" {
+		if err := validation.ValidateDatabaseName(opts.Database); err != nil {
+			return fmt.Errorf("invalid database name %q: %w", opts.Database, err)
+		}
+	}
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if d.config.Role != "" {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET ROLE '%s'", d.config.Role)); err != nil {
+			return err
+		}
+	}
+	var rn *renamer
+	if opts.Renamed() {
+		if err := d.ensureDatabase(ctx, opts.TargetDatabase); err != nil {
+			return err
+		}
+		rn = &renamer{from: opts.Database, to: opts.TargetDatabase}
+	}
+	if target := opts.Target(); target != "" {
+		if _, err := conn.ExecContext(ctx, "USE "+quoteName(target)); err != nil {
+			return err
+		}
+	}
+	return splitStatements(r, func(statement string) error {
+		if rn != nil {
+			if statement = rn.statement(statement); statement == "" {
+				return nil
+			}
+		}
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			first, _, _ := strings.Cut(statement, "\n")
+			if len(first) > 120 {
+				first = first[:120] + "..."
+			}
+			return fmt.Errorf("%s: %w", first, err)
+		}
+		return nil
+	})
+}
+
+// splitStatements calls fn with each statement of a dump taken without
+// mysqldump. Statements end with the delimiter at the end of a line; the
+// DELIMITER command changes it as in the mysql client, and comments
+// between statements are skipped.
+func splitStatements(r io.Reader, fn func(statement string) error) error {
+	br := bufio.NewReaderSize(r, stream.Default().BufferSize())
+	delimiter := ";"
+	var statement strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case statement.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "--")):
+		case statement.Len() == 0 && strings.HasPrefix(trimmed, "DELIMITER "):
+			delimiter = strings.TrimSpace(strings.TrimPrefix(trimmed, "DELIMITER "))
+		case strings.HasSuffix(trimmed, delimiter):
+			statement.WriteString(strings.TrimSuffix(trimmed, delimiter))
+			if err := fn(statement.String()); err != nil {
+				return err
+			}
+			statement.Reset()
+		default:
+			statement.WriteString(line)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	if strings.TrimSpace(statement.String()) != "" {
+		return errors.New("the dump ends within a statement, it is truncated")
+	}
+	return nil
+}
+
+// restoreNativeDumpFile replays the dump taken without mysqldump in
+// opts.SourceBackup
+func (d *MySQLDriver) restoreNativeDumpFile(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.restoreNativeDump(ctx, opts, f)
+}
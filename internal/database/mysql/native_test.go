@@ -0,0 +1,112 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestAppendRow(t *testing.T) {
+	columns := []nativeColumn{
+		{Name: "id", Kind: columnKind("bigint")},
+		{Name: "note", Kind: columnKind("varchar")},
+		{Name: "data", Kind: columnKind("BLOB")},
+		{Name: "empty", Kind: columnKind("varbinary")},
+		{Name: "deleted_at", Kind: columnKind("datetime")},
+	}
+	values := []sql.RawBytes{[]byte("42"), []byte("it's a \"line\"\n\\ \x00\x1a"), {0xde, 0xad}, {}, nil}
+	got := string(appendRow(nil, columns, values))
+	want := `(42,'it\'s a \"line\"\n\\ \0\Z',0xdead,'',NULL)`
+	if got != want {
+		t.Errorf("row = %s, want %s", got, want)
+	}
+}
+
+func TestViewOrder(t *testing.T) {
+	views := []string{"a_totals", "b_orders", "c_top"}
+	definitions := map[string]string{
+		"a_totals": "CREATE VIEW `a_totals` AS select sum(`b_orders`.`total`) AS `total` from `b_orders`",
+		"b_orders": "CREATE VIEW `b_orders` AS select `orders`.`total` AS `total` from `orders`",
+		"c_top":    "CREATE VIEW `c_top` AS select `a_totals`.`total` AS `total` from `a_totals`",
+	}
+	if got := viewOrder(views, definitions); !reflect.DeepEqual(got, []string{"b_orders", "a_totals", "c_top"}) {
+		t.Errorf("order = %v", got)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	dump := nativeHeader + "\n-- Server version: 8.0.36\n\nSET NAMES utf8mb4;\n" +
+		"CREATE TABLE `orders` (\n  `id` int NOT NULL\n);\n" +
+		"INSERT INTO `orders` (`id`) VALUES\n(1),\n(2);\n" +
+		"\nDELIMITER ;;\nDROP TRIGGER IF EXISTS `stamp`;;\nCREATE TRIGGER `stamp` BEFORE INSERT ON `orders` FOR EACH ROW BEGIN\n  SET NEW.id = NEW.id;\nEND;;\nDELIMITER ;\n" +
+		"-- Dump completed\n"
+	var got []string
+	err := splitStatements(strings.NewReader(dump), func(statement string) error {
+		got = append(got, statement)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"SET NAMES utf8mb4",
+		"CREATE TABLE `orders` (\n  `id` int NOT NULL\n)",
+		"INSERT INTO `orders` (`id`) VALUES\n(1),\n(2)",
+		"DROP TRIGGER IF EXISTS `stamp`",
+		"CREATE TRIGGER `stamp` BEFORE INSERT ON `orders` FOR EACH ROW BEGIN\n  SET NEW.id = NEW.id;\nEND",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %q", got)
+	}
+	if err := splitStatements(strings.NewReader("INSERT INTO `orders` VALUES\n(1),\n"), func(string) error { return nil }); err == nil {
+		t.Error("accepted a truncated dump")
+	}
+}
+
+func TestIsNativeDump(t *testing.T) {
+	for input, want := range map[string]bool{
+		nativeHeader + "\n-- Server version: 8.0.36\n": true,
+		"-- MySQL dump 10.13":                          false,
+		"":                                             false,
+	} {
+		got, err := isNativeDump(bufio.NewReader(strings.NewReader(input)))
+		if err != nil || got != want {
+			t.Errorf("%q: got %v, %v", input, got, err)
+		}
+	}
+}
+
+func TestNativeDumpOption(t *testing.T) {
+	d := NewMySQLDriver()
+	d.config = &database.ConnectionConfig{Options: map[string]string{"dump_format": FormatNative}}
+	if !d.nativeDump() {
+		t.Error("dump_format native uses mysqldump")
+	}
+	d.config.Options["dump_format"] = FormatSQL
+	if d.nativeDump() {
+		t.Error("dump_format sql dumps without mysqldump")
+	}
+	d.config.Options["dump_format"] = ""
+	t.Setenv("PATH", t.TempDir())
+	if !d.nativeDump() {
+		t.Error("used mysqldump, which is not installed")
+	}
+
+	dsn := d.buildDSN(&database.ConnectionConfig{
+		Host: "db-1", Port: 3306, Username: "backup", ConnectionTimeout: time.Second,
+		Options: map[string]string{"dump_format": FormatNative},
+	})
+	if strings.Contains(dsn, "dump_format") {
+		t.Errorf("dsn = %s", dsn)
+	}
+	err := NewMySQLDriver().Connect(context.Background(), &database.ConnectionConfig{Options: map[string]string{"dump_format": "tab"}})
+	if err == nil || !strings.Contains(err.Error(), "tab") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// renamer renames a database in a dump. Dumps of one database name none
+// and are restored under any name as they are; dumps of several, taken
+// with --databases or natively, create and USE each, and only the
+// statements of the database restored are kept. Without a source
+// database, the first one of the dump is restored.
+type renamer struct {
+	from, to string
+	other    bool // within the statements of another database
+}
+
+// useName returns the database a USE statement selects
+func useName(statement string) (string, bool) {
+	rest, ok := strings.CutPrefix(statement, "USE ")
+	if !ok {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimSpace(rest), ";")
+	if len(name) > 1 && name[0] == '`' && name[len(name)-1] == '`' {
+		name = strings.ReplaceAll(name[1:len(name)-1], "``", "`")
+	}
+	return name, true
+}
+
+// use reports whether a USE of name selects the database restored
+func (r *renamer) use(name string) bool {
+	if r.from == "" {
+		r.from = name
+	}
+	r.other = name != r.from
+	return !r.other
+}
+
+// createsSource reports whether a CREATE DATABASE statement creates the
+// database restored
+func (r *renamer) createsSource(statement string) bool {
+	return r.from != "" && strings.Contains(statement, quoteName(r.from))
+}
+
+// statement returns a statement of a native dump renamed, or "" when it
+// belongs to another database. The target exists already, so CREATE
+// DATABASE statements are all dropped.
+func (r *renamer) statement(statement string) string {
+	if name, ok := useName(statement); ok {
+		if r.use(name) {
+			return "USE " + quoteName(r.to)
+		}
+		return ""
+	}
+	if r.other || strings.HasPrefix(statement, "CREATE DATABASE ") {
+		return ""
+	}
+	return statement
+}
+
+// line returns a line of a mysqldump dump renamed. mysqldump writes its
+// CREATE DATABASE and USE statements on lines of their own. The USE
+// statements of other databases are kept for mysql --one-database, which
+// skips what follows them.
+func (r *renamer) line(line string) string {
+	if name, ok := useName(line); ok {
+		if r.use(name) {
+			return "USE " + quoteName(r.to) + ";\n"
+		}
+		return line
+	}
+	if strings.HasPrefix(line, "CREATE DATABASE ") {
+		if r.createsSource(line) {
+			return strings.Replace(line, quoteName(r.from), quoteName(r.to), 1)
+		}
+		return "-- " + line
+	}
+	return line
+}
+
+// renamedDump returns the mysqldump dump read from r, renamed
+func renamedDump(r io.Reader, from, to string) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(renameLines(pw, r, &renamer{from: from, to: to}))
+	}()
+	return pr
+}
+
+// renameLines copies r to w, renaming the lines starting a statement.
+// Lines longer than the buffer, such as extended INSERTs, are copied a
+// buffer at a time.
+func renameLines(w io.Writer, r io.Reader, rn *renamer) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	start := true
+	for {
+		chunk, err := br.ReadSlice('\n')
+		out := chunk
+		if start && len(chunk) > 0 && err != bufio.ErrBufferFull {
+			out = []byte(rn.line(string(chunk)))
+		}
+		if _, werr := w.Write(out); werr != nil {
+			return werr
+		}
+		switch err {
+		case nil:
+			start = true
+		case bufio.ErrBufferFull:
+			start = false
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// clientTarget returns the arguments naming the database the mysql client
+// restores dump into, and the dump to feed it. A dump restored under
+// another name is renamed and its target created first.
+func (d *MySQLDriver) clientTarget(ctx context.Context, opts *database.RestoreOptions, dump io.Reader) ([]string, io.Reader, error) {
+	if !opts.Renamed() {
+		if opts.Database == "" {
+			return nil, dump, nil
+		}
+		return []string{opts.Database}, dump, nil
+	}
+	if err := d.ensureDatabase(ctx, opts.TargetDatabase); err != nil {
+		return nil, nil, err
+	}
+	return []string{"--one-database", opts.TargetDatabase}, renamedDump(dump, opts.Database, opts.TargetDatabase), nil
+}
+
+// ensureDatabase creates the database name unless it exists
+func (d *MySQLDriver) ensureDatabase(ctx context.Context, name string) error {
+	if err := validation.ValidateDatabaseName(name); err != nil {
+		return fmt.Errorf("invalid database name %q: %w", name, err)
+	}
+	if _, err := d.db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS "+quoteName(name)); err != nil {
+		return fmt.Errorf("creating database %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package mysql
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRenamedDump(t *testing.T) {
+	dump := strings.Join([]string{
+		"-- Current Database: `prod`",
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `prod` /*!40100 DEFAULT CHARACTER SET utf8mb4 */;",
+		"USE `prod`;",
+		"INSERT INTO `users` VALUES (1,'USE `prod`;');",
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `audit`;",
+		"USE `audit`;",
+		"INSERT INTO `events` VALUES (1);",
+		"",
+	}, "\n")
+	out, err := io.ReadAll(renamedDump(strings.NewReader(dump), "prod", "prod_verify"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"-- Current Database: `prod`",
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `prod_verify` /*!40100 DEFAULT CHARACTER SET utf8mb4 */;",
+		"USE `prod_verify`;",
+		"INSERT INTO `users` VALUES (1,'USE `prod`;');",
+		"-- CREATE DATABASE /*!32312 IF NOT EXISTS*/ `audit`;",
+		"USE `audit`;",
+		"INSERT INTO `events` VALUES (1);",
+		"",
+	}, "\n")
+	if string(out) != want {
+		t.Errorf("renamed dump:\n%s\nwant:\n%s", out, want)
+	}
+
+	// Single-database dumps name no database
+	single := "DROP TABLE IF EXISTS `users`;\nCREATE TABLE `users` (`id` int);\n"
+	out, _ = io.ReadAll(renamedDump(strings.NewReader(single), "prod", "prod_verify"))
+	if string(out) != single {
+		t.Errorf("single-database dump changed:\n%s", out)
+	}
+}
+
+func TestRenamerStatements(t *testing.T) {
+	rn := &renamer{to: "copy"}
+	statements := []string{
+		"CREATE DATABASE IF NOT EXISTS `shop`",
+		"USE `shop`",
+		"CREATE TABLE `orders` (`id` int)",
+		"CREATE DATABASE IF NOT EXISTS `crm`",
+		"USE `crm`",
+		"CREATE TABLE `contacts` (`id` int)",
+		"USE `shop`",
+		"INSERT INTO `orders` VALUES (1)",
+	}
+	var got []string
+	for _, s := range statements {
+		if s = rn.statement(s); s != "" {
+			got = append(got, s)
+		}
+	}
+	want := []string{
+		"USE `copy`",
+		"CREATE TABLE `orders` (`id` int)",
+		"USE `copy`",
+		"INSERT INTO `orders` VALUES (1)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// autoIncrement matches the table option SHOW CREATE TABLE gives the next
+// value of an AUTO_INCREMENT column with
+var autoIncrement = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// DumpSchema writes the definitions of the databases of opts to w, as a
+// dump taken without mysqldump holds them, less the rows and the header
+// naming the server and the time of the dump
+func (d *MySQLDriver) DumpSchema(ctx context.Context, opts *database.BackupOptions, w io.Writer) error {
+	schemas, create, err := d.nativeSchemas(ctx, opts)
+	if err != nil {
+		return err
+	}
+	for _, table := range append(append([]string{}, opts.Tables...), opts.ExcludeTables...) {
+		if err := validation.ValidateTableName(table); err != nil {
+			return fmt.Errorf("invalid table name %q: %w", table, err)
+		}
+	}
+
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// SHOW CREATE quotes with backticks
+	if _, err := conn.ExecContext(ctx, "SET SESSION sql_mode = ''"); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, schema := range schemas {
+		if err := dumpSchema(ctx, conn, bw, schema, create, false, opts); err != nil {
+			return fmt.Errorf("dumping the schema of %s: %w", schema, err)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,11 @@
+package mysql
+
+import "testing"
+
+func TestAutoIncrementOption(t *testing.T) {
+	ddl := "CREATE TABLE `orders` (\n  `id` int NOT NULL AUTO_INCREMENT,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB AUTO_INCREMENT=1042 DEFAULT CHARSET=utf8mb4"
+	want := "CREATE TABLE `orders` (\n  `id` int NOT NULL AUTO_INCREMENT,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	if got := autoIncrement.ReplaceAllString(ddl, ""); got != want {
+		t.Errorf("ddl = %q", got)
+	}
+}
@@ -0,0 +1,15 @@
+package mysql
+
+import "fmt"
+
+// serverArgs returns the options of the client tools selecting the server:
+// its unix socket, or its host and port
+func (d *MySQLDriver) serverArgs() []string {
+	if socket := d.config.UnixSocket(); socket != "" {
+		return []string{"--protocol=SOCKET", "--socket=" + socket}
+	}
+	return []string{
+		fmt.Sprintf("--host=%s", d.config.Host),
+		fmt.Sprintf("--port=%d", d.config.Port),
+	}
+}
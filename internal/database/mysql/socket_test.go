@@ -0,0 +1,32 @@
+package mysql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestUnixSocket(t *testing.T) {
+	config := &database.ConnectionConfig{Host: "/run/mysqld/mysqld.sock", Port: 3306, Username: "backup", Database: "orders", ConnectionTimeout: time.Second}
+	d := &MySQLDriver{config: config}
+
+	if dsn := d.buildDSN(config); !strings.HasPrefix(dsn, "backup:@unix(/run/mysqld/mysqld.sock)/orders?") {
+		t.Errorf("DSN = %s", dsn)
+	}
+	want := []string{"--protocol=SOCKET", "--socket=/run/mysqld/mysqld.sock"}
+	if got := d.serverArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("serverArgs() = %q, want %q", got, want)
+	}
+
+	config.Host = "db-1"
+	if dsn := d.buildDSN(config); !strings.HasPrefix(dsn, "backup:@tcp(db-1:3306)/orders?") {
+		t.Errorf("DSN = %s", dsn)
+	}
+	want = []string{"--host=db-1", "--port=3306"}
+	if got := d.serverArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("serverArgs() = %q, want %q", got, want)
+	}
+}
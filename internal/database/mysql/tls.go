@@ -0,0 +1,79 @@
+package mysql
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// tlsConfigName returns the name the TLS settings of config are
+// registered with the MySQL driver under, "" when it is not encrypted.
+// Connections with the same settings share one registration.
+func tlsConfigName(config *database.ConnectionConfig) string {
+	if config.TLSMode() == database.SSLDisable {
+		return ""
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00%s\x00%s\x00%s",
+		config.Host, config.TLSMode(), config.SSLCA, config.SSLCert, config.SSLKey))
+	return fmt.Sprintf("db-backup-%x", sum[:8])
+}
+
+// registerTLS registers the TLS settings of config with the MySQL driver,
+// for the DSN buildDSN builds to refer to
+func registerTLS(config *database.ConnectionConfig) error {
+	name := tlsConfigName(config)
+	if name == "" {
+		return nil
+	}
+	tlsConfig, err := config.ClientTLS()
+	if err != nil {
+		return err
+	}
+	return mysqldriver.RegisterTLSConfig(name, tlsConfig)
+}
+
+// sslArgs returns the SSL options of the client tools. The tools keep
+// their own default when no SSL setting is configured. MariaDB's tools,
+// which the MariaDB driver runs through this one, have no --ssl-mode and
+// verify the server with --ssl-verify-server-cert instead.
+func (d *MySQLDriver) sslArgs() []string {
+	mode := d.config.TLSMode()
+	mariadb := d.config.Type == database.DatabaseTypeMariaDB
+	if mode == database.SSLDisable {
+		switch {
+		case d.config.SSLMode == "":
+			return nil
+		case mariadb:
+			return []string{"--skip-ssl"}
+		}
+		return []string{"--ssl-mode=DISABLED"}
+	}
+
+	var args []string
+	if d.config.SSLCA != "" {
+		args = append(args, "--ssl-ca="+d.config.SSLCA)
+	}
+	if d.config.SSLCert != "" {
+		key := d.config.SSLKey
+		if key == "" {
+			key = d.config.SSLCert
+		}
+		args = append(args, "--ssl-cert="+d.config.SSLCert, "--ssl-key="+key)
+	}
+	if mariadb {
+		args = append(args, "--ssl")
+		if mode != database.SSLRequire {
+			args = append(args, "--ssl-verify-server-cert")
+		}
+		return args
+	}
+	switch mode {
+	case database.SSLVerifyCA:
+		return append(args, "--ssl-mode=VERIFY_CA")
+	case database.SSLVerifyFull:
+		return append(args, "--ssl-mode=VERIFY_IDENTITY")
+	}
+	return append(args, "--ssl-mode=REQUIRED")
+}
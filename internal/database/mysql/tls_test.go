@@ -0,0 +1,74 @@
+package mysql
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestSSLArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		config database.ConnectionConfig
+		want   []string
+	}{
+		{"unset", database.ConnectionConfig{}, nil},
+		{"disable", database.ConnectionConfig{SSLMode: "disable"}, []string{"--ssl-mode=DISABLED"}},
+		{"require", database.ConnectionConfig{SSLMode: "require"}, []string{"--ssl-mode=REQUIRED"}},
+		{"client certificate", database.ConnectionConfig{SSLCA: "/tls/ca.pem", SSLCert: "/tls/client.pem", SSLKey: "/tls/client.key"},
+			[]string{"--ssl-ca=/tls/ca.pem", "--ssl-cert=/tls/client.pem", "--ssl-key=/tls/client.key", "--ssl-mode=VERIFY_IDENTITY"}},
+		{"combined key", database.ConnectionConfig{SSLMode: "verify-ca", SSLCert: "/tls/client.pem"},
+			[]string{"--ssl-cert=/tls/client.pem", "--ssl-key=/tls/client.pem", "--ssl-mode=VERIFY_CA"}},
+		{"mariadb disable", database.ConnectionConfig{Type: database.DatabaseTypeMariaDB, SSLMode: "disable"}, []string{"--skip-ssl"}},
+		{"mariadb require", database.ConnectionConfig{Type: database.DatabaseTypeMariaDB, SSLMode: "require"}, []string{"--ssl"}},
+		{"mariadb verify", database.ConnectionConfig{Type: database.DatabaseTypeMariaDB, SSLCA: "/tls/ca.pem"},
+			[]string{"--ssl-ca=/tls/ca.pem", "--ssl", "--ssl-verify-server-cert"}},
+	}
+	for _, tt := range tests {
+		d := &MySQLDriver{config: &tt.config}
+		if got := d.sslArgs(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: sslArgs() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildDSNTLS(t *testing.T) {
+	config := &database.ConnectionConfig{Host: "db-1", Port: 3306, Username: "backup", ConnectionTimeout: time.Second}
+	if dsn := NewMySQLDriver().buildDSN(config); strings.Contains(dsn, "tls=") {
+		t.Errorf("unencrypted dsn = %s", dsn)
+	}
+	config.SSLMode = "require"
+	if err := registerTLS(config); err != nil {
+		t.Fatal(err)
+	}
+	name := tlsConfigName(config)
+	if dsn := NewMySQLDriver().buildDSN(config); !strings.HasSuffix(dsn, "&tls="+name) {
+		t.Errorf("dsn = %s, want tls=%s", dsn, name)
+	}
+	other := *config
+	other.Host = "db-2"
+	if tlsConfigName(&other) == name {
+		t.Error("connections to different hosts share TLS settings")
+	}
+}
+
+func TestDefaultsFileSSL(t *testing.T) {
+	d := &MySQLDriver{config: &database.ConnectionConfig{Host: "db-1", Port: 3306, Username: "backup", SSLCA: "/tls/my ca.pem"}}
+	path, err := d.defaultsFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"ssl-ca=\"/tls/my ca.pem\"\n", "ssl-mode=\"VERIFY_IDENTITY\"\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("option file lacks %q:\n%s", want, data)
+		}
+	}
+}
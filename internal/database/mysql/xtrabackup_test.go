@@ -0,0 +1,155 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestParseCheckpoints(t *testing.T) {
+	cp, err := parseCheckpoints([]byte("backup_type = log-applied\nfrom_lsn = 0\nto_lsn = 1612840\nlast_lsn = 1612849\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Type != "log-applied" || cp.FromLSN != 0 || cp.ToLSN != 1612840 {
+		t.Errorf("got %+v", cp)
+	}
+	for _, data := range []string{"", "backup_type = full-backuped\n", "backup_type = full-backuped\nto_lsn = x\n"} {
+		if _, err := parseCheckpoints([]byte(data)); err == nil {
+			t.Errorf("%q: expected an error", data)
+		}
+	}
+}
+
+func TestIsPhysical(t *testing.T) {
+	for input, want := range map[string]bool{
+		"XBSTCK01\x00\x00rest": true,
+		"-- MySQL dump":        false,
+		"":                     false,
+	} {
+		got, err := isPhysical(bufio.NewReader(strings.NewReader(input)))
+		if err != nil || got != want {
+			t.Errorf("%q: got %v, %v", input, got, err)
+		}
+	}
+}
+
+func TestXtrabackupArgs(t *testing.T) {
+	opts := &database.BackupOptions{Incremental: true, Parallel: 4, Metadata: map[string]string{BaseLSNKey: "1612840"}}
+	args, err := xtrabackupArgs(opts, "/work/client.cnf", "/work", "/work/lsn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--defaults-extra-file=/work/client.cnf --backup --stream=xbstream --target-dir=/work/target --extra-lsndir=/work/lsn --incremental-lsn=1612840 --parallel=4"
+	if got := strings.Join(args, " "); got != want {
+		t.Errorf("args = %s", got)
+	}
+	opts.Metadata[BaseLSNKey] = "latest"
+	if _, err := xtrabackupArgs(opts, "/work/client.cnf", "/work", "/work/lsn"); err == nil {
+		t.Error("accepted a base LSN that is not a number")
+	}
+}
+
+func TestBuildDSNSkipsBackupMethod(t *testing.T) {
+	dsn := NewMySQLDriver().buildDSN(&database.ConnectionConfig{
+		Host: "db-1", Port: 3306, Username: "backup", ConnectionTimeout: time.Second,
+		Options: map[string]string{"backup_method": MethodPhysical},
+	})
+	if strings.Contains(dsn, "backup_method") {
+		t.Errorf("dsn = %s", dsn)
+	}
+	err := NewMySQLDriver().Connect(context.Background(), &database.ConnectionConfig{Options: map[string]string{"backup_method": "snapshot"}})
+	if err == nil || !strings.Contains(err.Error(), "snapshot") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCheckPrepare(t *testing.T) {
+	for _, opts := range []*database.RestoreOptions{
+		{},
+		{Metadata: map[string]string{"restore_dir": "/restore"}, Tables: []string{"shop.orders"}},
+		{Metadata: map[string]string{"restore_dir": "/restore", "apply_log_only": "yes"}},
+	} {
+		if err := checkPrepare(opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+	if err := checkPrepare(&database.RestoreOptions{Metadata: map[string]string{"restore_dir": "/restore"}}); err != nil {
+		t.Error(err)
+	}
+}
+
+// fakeTools puts xbstream and xtrabackup scripts first in PATH: xbstream
+// extracts its input, minus the archive header, as the checkpoints file and
+// xtrabackup logs its arguments
+func fakeTools(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+	bin := t.TempDir()
+	log := filepath.Join(bin, "xtrabackup.log")
+	scripts := map[string]string{
+		"xbstream":   "#!/bin/sh\ntail -c +9 > \"$3/xtrabackup_checkpoints\"\n",
+		"xtrabackup": "#!/bin/sh\necho \"$@\" >> " + log + "\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func archive(checkpoints string) *strings.Reader {
+	return strings.NewReader(string(xbstreamMagic) + checkpoints)
+}
+
+func TestPrepareIncrementalChain(t *testing.T) {
+	log := fakeTools(t)
+	ctx := context.Background()
+	target := filepath.Join(t.TempDir(), "restore")
+	opts := &database.RestoreOptions{Metadata: map[string]string{"restore_dir": target, "apply_log_only": "true"}}
+
+	if err := prepare(ctx, opts, archive("backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 100\n")); err != nil {
+		t.Fatal(err)
+	}
+	// The fake prepare leaves the full backup's checkpoints, ending at 100
+	err := prepare(ctx, opts, archive("backup_type = incremental\nfrom_lsn = 90\nto_lsn = 200\n"))
+	if err == nil || !strings.Contains(err.Error(), "apply the backups in order") {
+		t.Errorf("out of order incremental: got %v", err)
+	}
+	err = prepare(ctx, opts, archive("backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 300\n"))
+	if err == nil || !strings.Contains(err.Error(), "empty directory") {
+		t.Errorf("second full backup: got %v", err)
+	}
+	// The last backup of the chain is prepared for startup
+	delete(opts.Metadata, "apply_log_only")
+	if err := prepare(ctx, opts, archive("backup_type = incremental\nfrom_lsn = 100\nto_lsn = 200\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(calls) != 2 || calls[0] != "--prepare --target-dir="+target+" --apply-log-only" ||
+		!strings.HasPrefix(calls[1], "--prepare --target-dir="+target+" --incremental-dir=") {
+		t.Errorf("xtrabackup calls: %q", calls)
+	}
+
+	// Nothing more applies once the rollback phase ran
+	os.WriteFile(filepath.Join(target, checkpointsFile), []byte("backup_type = full-prepared\nfrom_lsn = 0\nto_lsn = 200\n"), 0o600)
+	err = prepare(ctx, opts, archive("backup_type = incremental\nfrom_lsn = 200\nto_lsn = 300\n"))
+	if err == nil || !strings.Contains(err.Error(), "apply_log_only") {
+		t.Errorf("incremental on a backup prepared for startup: got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package neo4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+)
+
+// neo4jAdmin returns the path of neo4j-admin
+func neo4jAdmin() (string, error) {
+	path, err := exec.LookPath("neo4j-admin")
+	if err != nil {
+		return "", errors.New("neo4j-admin is not installed")
+	}
+	return path, nil
+}
+
+// runAdmin runs a neo4j-admin database subcommand. The neo4j_home and
+// neo4j_conf options point it at the installation whose data it works on.
+func (d *Neo4jDriver) runAdmin(ctx context.Context, args ...string) error {
+	tool, err := neo4jAdmin()
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, tool, append([]string{"database"}, args...)...)
+	cmd.Env = os.Environ()
+	if home := d.config.Options["neo4j_home"]; home != "" {
+		cmd.Env = append(cmd.Env, "NEO4J_HOME="+home)
+	}
+	if conf := d.config.Options["neo4j_conf"]; conf != "" {
+		cmd.Env = append(cmd.Env, "NEO4J_CONF="+conf)
+	}
+	run := telemetry.StartCommand(ctx, cmd)
+	output, err := cmd.CombinedOutput()
+	run.End(err, -1)
+	if err != nil {
+		return fmt.Errorf("neo4j-admin database %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// adminVersion returns the version neo4j-admin reports
+func adminVersion(ctx context.Context) (string, error) {
+	tool, err := neo4jAdmin()
+	if err != nil {
+		return "", err
+	}
+	output, err := exec.CommandContext(ctx, tool, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("neo4j-admin --version failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
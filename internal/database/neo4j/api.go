@@ -0,0 +1,183 @@
+package neo4j
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// client runs Cypher through the HTTP API (port 7474)
+type client struct {
+	base     string
+	username string
+	password string
+	hc       *http.Client
+}
+
+// newClient builds a client of the server in config
+func newClient(config *database.ConnectionConfig) (*client, error) {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := config.ClientTLS()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		scheme, transport.TLSClientConfig = "https", tlsConfig
+	}
+	timeout := config.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	transport.DialContext = (&net.Dialer{Timeout: timeout}).DialContext
+	return &client{
+		base:     scheme + "://" + net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		username: config.Username,
+		password: config.Password,
+		hc:       &http.Client{Transport: transport},
+	}, nil
+}
+
+// server is what the discovery document says of the server
+type server struct {
+	Version string `json:"neo4j_version"`
+	Edition string `json:"neo4j_edition"` // community or enterprise
+}
+
+// discover reads the discovery document, which needs no authentication
+func (c *client) discover(ctx context.Context) (*server, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET / returned %s", resp.Status)
+	}
+	var s server
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid discovery document: %w", err)
+	}
+	if s.Version == "" {
+		return nil, errors.New("not a Neo4j server")
+	}
+	return &s, nil
+}
+
+// query runs one statement against db in its own transaction and returns
+// the rows
+func (c *client) query(ctx context.Context, db, statement string) ([][]json.RawMessage, error) {
+	body, err := json.Marshal(map[string]any{
+		"statements": []map[string]string{{"statement": statement}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/db/"+url.PathEscape(db)+"/tx/commit", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errors.New("authentication failed")
+	}
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("query failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var out struct {
+		Results []struct {
+			Data []struct {
+				Row []json.RawMessage `json:"row"`
+			} `json:"data"`
+		} `json:"results"`
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid query response: %w", err)
+	}
+	if len(out.Errors) > 0 {
+		return nil, fmt.Errorf("%s: %s", out.Errors[0].Code, out.Errors[0].Message)
+	}
+	var rows [][]json.RawMessage
+	for _, r := range out.Results {
+		for _, d := range r.Data {
+			rows = append(rows, d.Row)
+		}
+	}
+	return rows, nil
+}
+
+// dbInfo is a database of SHOW DATABASES
+type dbInfo struct {
+	Name   string
+	Type   string // standard, system or composite
+	Status string
+}
+
+// databases lists the databases. Clusters list a database once per server
+// hosting it; it is returned once.
+func (c *client) databases(ctx context.Context) ([]dbInfo, error) {
+	rows, err := c.query(ctx, "system", "SHOW DATABASES YIELD name, type, currentStatus")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(rows))
+	var dbs []dbInfo
+	for _, row := range rows {
+		if len(row) < 3 {
+			continue
+		}
+		var db dbInfo
+		_ = json.Unmarshal(row[0], &db.Name)
+		_ = json.Unmarshal(row[1], &db.Type)
+		_ = json.Unmarshal(row[2], &db.Status)
+		if db.Name == "" || seen[db.Name] {
+			continue
+		}
+		seen[db.Name] = true
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
+// admin runs an administration command, e.g. STOP DATABASE, on the system
+// database
+func (c *client) admin(ctx context.Context, command, db string) error {
+	_, err := c.query(ctx, "system", command+" "+quoteName(db)+" WAIT")
+	return err
+}
+
+// quoteName quotes a database name for Cypher
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
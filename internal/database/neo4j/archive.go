@@ -0,0 +1,118 @@
+package neo4j
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// ManifestFormat identifies the archives written by this driver
+const ManifestFormat = "neo4j-backup/v1"
+
+// What an archive holds, one file per database
+const (
+	// KindDump archives hold neo4j-admin database dump files, restored
+	// with neo4j-admin database load
+	KindDump = "dump"
+	// KindBackup archives hold the full backup artifacts of neo4j-admin
+	// database backup, restored with neo4j-admin database restore
+	KindBackup = "backup"
+)
+
+const databasesDir = "databases/"
+
+// Manifest describes an archive. It is its first entry, so restores know
+// what they are restoring before any data.
+type Manifest struct {
+	Format    string    `json:"format"`
+	Kind      string    `json:"kind"`
+	Databases []string  `json:"databases"`
+	Version   string    `json:"version,omitempty"`
+	Edition   string    `json:"edition,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// extension returns the file extension of the kind's files
+func (m *Manifest) extension() string {
+	if m.Kind == KindBackup {
+		return ".backup"
+	}
+	return ".dump"
+}
+
+// entry returns the archive entry of a database
+func (m *Manifest) entry(db string) string {
+	return databasesDir + db + m.extension()
+}
+
+// writeArchive writes the manifest, then the file of each database in
+// files, to w. It returns the size of each file.
+func writeArchive(w io.Writer, m *Manifest, files map[string]string) (map[string]int64, error) {
+	tw := tar.NewWriter(w)
+	if err := database.WriteManifest(tw, m); err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64, len(m.Databases))
+	for _, db := range m.Databases {
+		n, err := writeFile(tw, m.entry(db), files[db])
+		if err != nil {
+			return nil, err
+		}
+		sizes[db] = n
+	}
+	return sizes, tw.Close()
+}
+
+// writeFile writes file as the entry name
+func writeFile(tw *tar.Writer, name, file string) (int64, error) {
+	f, err := os.Open(file) // #nosec G304 -- spool file of this backup
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := tw.WriteHeader(header(name, info.Size())); err != nil {
+		return 0, err
+	}
+	return io.Copy(tw, f)
+}
+
+func header(name string, size int64) *tar.Header {
+	return &tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+}
+
+// validate checks the kind of the archive m describes
+func (m *Manifest) validate() error {
+	if m.Kind != KindDump && m.Kind != KindBackup {
+		return fmt.Errorf("unsupported backup kind %q", m.Kind)
+	}
+	return nil
+}
+
+// entryDatabase returns the database of an archive entry
+func (m *Manifest) entryDatabase(name string) (string, bool) {
+	db, ok := strings.CutPrefix(name, databasesDir)
+	if !ok {
+		return "", false
+	}
+	db, ok = strings.CutSuffix(db, m.extension())
+	if !ok || db == "" || db != path.Base(db) || db == ".." {
+		return "", false
+	}
+	return db, true
+}
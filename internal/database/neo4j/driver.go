@@ -0,0 +1,657 @@
+// Package neo4j provides the Neo4j database driver, for Neo4j 5. Backups
+// are taken with neo4j-admin, in one of two modes set by the mode option:
+//
+//   - online (the default) takes full backups of a running Enterprise
+//     server with neo4j-admin database backup, from its backup port
+//     (backup_address, host:6362 by default). The server is reached over
+//     the HTTP API (port 7474) to list databases and to stop, start and
+//     create them around restores.
+//   - offline dumps databases with neo4j-admin database dump. The database
+//     must not be running, which on Community means stopping the server;
+//     db-backup then runs on the Neo4j host and never talks to the server.
+//
+// Community has no online backups, so connecting to a Community server in
+// online mode fails and asks for offline mode.
+//
+// A backup is a tar archive: a manifest, then the dump or backup artifact
+// of each database. Restores run neo4j-admin database load or restore on
+// the Neo4j host, so db-backup must run there; neo4j_home and neo4j_conf
+// point neo4j-admin at the installation. A backup of one database can be
+// restored under another name with the restore database.
+package neo4j
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Modes of the mode option
+const (
+	ModeOnline  = "online"
+	ModeOffline = "offline"
+)
+
+// defaultBackupPort is the port Enterprise serves online backups on
+const defaultBackupPort = "6362"
+
+// Neo4jDriver implements the database.Driver interface for Neo4j
+type Neo4jDriver struct {
+	client *client // nil in offline mode
+	server *server
+	config *database.ConnectionConfig
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeNeo4j, func() database.Driver {
+		return NewNeo4jDriver()
+	})
+}
+
+// NewNeo4jDriver creates a new Neo4j driver instance
+func NewNeo4jDriver() *Neo4jDriver {
+	return &Neo4jDriver{}
+}
+
+// Connect checks the server can be backed up online, or in offline mode
+// that neo4j-admin is installed
+func (d *Neo4jDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	switch config.Options["mode"] {
+	case ModeOffline:
+		if _, err := neo4jAdmin(); err != nil {
+			return pkgErrors.ErrDatabaseConnection(err)
+		}
+		d.config = config
+		return nil
+	case "", ModeOnline:
+	default:
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("unknown mode %q, use online or offline", config.Options["mode"]))
+	}
+
+	c, err := newClient(config)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	s, err := c.discover(ctx)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if s.Edition != "enterprise" {
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("the %s edition has no online backups: stop the server and set the mode option to offline to take dumps", s.Edition))
+	}
+	if _, err := c.databases(ctx); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.client, d.server, d.config = c, s, config
+	return nil
+}
+
+// Disconnect releases idle connections
+func (d *Neo4jDriver) Disconnect() error {
+	if d.client != nil {
+		d.client.hc.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Ping tests the connection
+func (d *Neo4jDriver) Ping(ctx context.Context) error {
+	if d.config == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	if d.client == nil {
+		_, err := neo4jAdmin()
+		return err
+	}
+	_, err := d.client.discover(ctx)
+	return err
+}
+
+// Backup writes an archive of the databases to opts.OutputPath
+func (d *Neo4jDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	m, tables, err := d.backup(ctx, opts, output)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = m.Version
+	result.Size = output.Written()
+	result.Checksum = output.Sum()
+	result.Tables = tables
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"neo4j_backup_kind": m.Kind})
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// StreamBackup streams an archive of the databases to writer
+func (d *Neo4jDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	_, _, err := d.backup(ctx, opts, writer)
+	return err
+}
+
+// backup runs neo4j-admin for each database into a spool directory, then
+// writes the archive to w. neo4j-admin writes files, and tar needs their
+// sizes up front.
+func (d *Neo4jDriver) backup(ctx context.Context, opts *database.BackupOptions, w io.Writer) (*Manifest, []database.TableInfo, error) {
+	names, err := d.backupNames(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	version, err := d.GetVersion(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	spool, err := os.MkdirTemp("", "neo4j-backup-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(spool)
+
+	m := &Manifest{
+		Format:    ManifestFormat,
+		Kind:      KindDump,
+		Databases: names,
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+	}
+	if d.client != nil {
+		m.Kind, m.Edition = KindBackup, d.server.Edition
+	}
+	files := make(map[string]string, len(names))
+	for i, db := range names {
+		var file string
+		if m.Kind == KindBackup {
+			file, err = d.backupOnline(ctx, db, filepath.Join(spool, fmt.Sprint(i)))
+		} else {
+			file, err = d.dump(ctx, db, spool)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		files[db] = file
+	}
+
+	sizes, err := writeArchive(w, m, files)
+	if err != nil {
+		return nil, nil, err
+	}
+	tables := make([]database.TableInfo, 0, len(names))
+	for _, db := range names {
+		tables = append(tables, database.TableInfo{Name: db, DataSize: sizes[db]})
+	}
+	return m, tables, nil
+}
+
+// backupOnline takes a full backup of db into dir and returns the backup
+// artifact
+func (d *Neo4jDriver) backupOnline(ctx context.Context, db, dir string) (string, error) {
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return "", err
+	}
+	from := d.config.Options["backup_address"]
+	if from == "" {
+		from = net.JoinHostPort(d.config.Host, defaultBackupPort)
+	}
+	if err := d.runAdmin(ctx, "backup", "--from="+from, "--to-path="+dir, "--type=FULL", db); err != nil {
+		return "", err
+	}
+	artifacts, err := filepath.Glob(filepath.Join(dir, "*.backup"))
+	if err != nil {
+		return "", err
+	}
+	if len(artifacts) != 1 {
+		return "", fmt.Errorf("neo4j-admin wrote %d backup artifacts for %s, expected 1", len(artifacts), db)
+	}
+	return artifacts[0], nil
+}
+
+// dump dumps db into dir and returns the dump file
+func (d *Neo4jDriver) dump(ctx context.Context, db, dir string) (string, error) {
+	if err := d.runAdmin(ctx, "dump", "--to-path="+dir, db); err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, db+".dump")
+	if _, err := os.Stat(file); err != nil {
+		return "", fmt.Errorf("neo4j-admin wrote no dump of %s: %w", db, err)
+	}
+	return file, nil
+}
+
+// backupNames returns the databases to back up: those named, or every
+// user database
+func (d *Neo4jDriver) backupNames(ctx context.Context, opts *database.BackupOptions) ([]string, error) {
+	switch {
+	case len(opts.Databases) > 0 && !opts.AllDatabases:
+		return opts.Databases, nil
+	case opts.Database != "" && !opts.AllDatabases:
+		return []string{opts.Database}, nil
+	}
+	names, err := d.GetDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no databases to back up")
+	}
+	return names, nil
+}
+
+// GetBackupSize returns the size of the databases' store and transaction
+// log directories, read from the data directory
+func (d *Neo4jDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	data, err := d.dataDir()
+	if err != nil {
+		return 0, err
+	}
+	names, err := d.backupNames(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, db := range names {
+		for _, dir := range []string{"databases", "transactions"} {
+			size, err := dirSize(filepath.Join(data, dir, db))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return 0, err
+			}
+			total += size
+		}
+	}
+	return total, nil
+}
+
+// Restore restores the databases of an archive
+func (d *Neo4jDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+	fail := func(err error) (*database.RestoreResult, error) {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	restored, err := d.restore(ctx, opts, f)
+	result.RestoredTables = restored
+	if err != nil {
+		return fail(err)
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+	return result, nil
+}
+
+// StreamRestore restores the databases of an archive read from reader
+func (d *Neo4jDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	_, err := d.restore(ctx, opts, reader)
+	return err
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *Neo4jDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer f.Close()
+	m, err := database.ReadManifest(tar.NewReader(f), ManifestFormat, (*Manifest).validate)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	p, err := planRestore(m, opts)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if err := d.checkTargets(ctx, p, opts.DropExisting); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if _, err := neo4jAdmin(); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	return nil
+}
+
+// restorePlan maps the databases of an archive to restore to their
+// targets
+type restorePlan struct {
+	targets map[string]string // by database in the archive
+}
+
+// planRestore decides what to restore: the restore database when the
+// archive holds it, the only database of the archive under the restore
+// database's name, or every database of the archive. The source_database
+// metadata picks the database of an archive of several to rename.
+func planRestore(m *Manifest, opts *database.RestoreOptions) (*restorePlan, error) {
+	switch {
+	case len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0:
+		return nil, errors.New("backups restore whole databases, labels cannot be chosen")
+	case opts.PointInTime != nil:
+		return nil, errors.New("point-in-time restores are not supported for neo4j")
+	case len(m.Databases) == 0:
+		return nil, errors.New("the backup holds no databases")
+	}
+
+	p := &restorePlan{targets: make(map[string]string)}
+	source := opts.Metadata["source_database"]
+	switch {
+	case source != "":
+		if !slices.Contains(m.Databases, source) {
+			return nil, fmt.Errorf("the backup holds no database %s (it holds %s)", source, strings.Join(m.Databases, ", "))
+		}
+	case opts.Target() == "" || slices.Contains(m.Databases, opts.Database):
+		source = opts.Database
+	case len(m.Databases) == 1:
+		source = m.Databases[0]
+	default:
+		return nil, fmt.Errorf("the backup holds %s: set the source_database metadata to restore one of them as %s",
+			strings.Join(m.Databases, ", "), opts.Target())
+	}
+
+	if source == "" {
+		for _, db := range m.Databases {
+			p.targets[db] = db
+		}
+		return p, nil
+	}
+	target := opts.Target()
+	if target == "" {
+		target = source
+	}
+	if !validName(target) {
+		return nil, fmt.Errorf("invalid database name %q", target)
+	}
+	p.targets[source] = target
+	return p, nil
+}
+
+// validName reports whether name can be a database name; it also names
+// the spool file of its restore
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// checkTargets fails when a target database exists and is not to be
+// replaced. Offline, neo4j-admin does the check itself.
+func (d *Neo4jDriver) checkTargets(ctx context.Context, p *restorePlan, drop bool) error {
+	if d.client == nil || drop {
+		return nil
+	}
+	existing, err := d.existing(ctx)
+	if err != nil {
+		return err
+	}
+	for _, target := range p.targets {
+		if existing[target] {
+			return fmt.Errorf("database %s already exists, use --drop-existing to replace it", target)
+		}
+	}
+	return nil
+}
+
+// existing returns the databases of the server
+func (d *Neo4jDriver) existing(ctx context.Context) (map[string]bool, error) {
+	dbs, err := d.client.databases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(dbs))
+	for _, db := range dbs {
+		existing[db.Name] = true
+	}
+	return existing, nil
+}
+
+// restore reads the archive from r and restores the planned databases one
+// at a time, each spooled to disk for neo4j-admin
+func (d *Neo4jDriver) restore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) ([]string, error) {
+	tr := tar.NewReader(r)
+	m, err := database.ReadManifest(tr, ManifestFormat, (*Manifest).validate)
+	if err != nil {
+		return nil, err
+	}
+	p, err := planRestore(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkTargets(ctx, p, opts.DropExisting); err != nil {
+		return nil, err
+	}
+	var existing map[string]bool
+	if d.client != nil {
+		if existing, err = d.existing(ctx); err != nil {
+			return nil, err
+		}
+	}
+	spool, err := os.MkdirTemp("", "neo4j-restore-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(spool)
+
+	var restored []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restored, err
+		}
+		db, ok := m.entryDatabase(hdr.Name)
+		if !ok {
+			return restored, fmt.Errorf("unexpected archive entry %s", hdr.Name)
+		}
+		target, ok := p.targets[db]
+		if !ok {
+			continue
+		}
+		if err := d.restoreDatabase(ctx, m, target, tr, spool, existing[target], opts.DropExisting); err != nil {
+			return restored, fmt.Errorf("%s: %w", target, err)
+		}
+		restored = append(restored, target)
+	}
+	if len(restored) < len(p.targets) {
+		return restored, fmt.Errorf("the backup is missing %d of the databases to restore", len(p.targets)-len(restored))
+	}
+	return restored, nil
+}
+
+// restoreDatabase restores the artifact read from r as target. Online, an
+// existing database is stopped first and started again after, and a new
+// one is created once its store is in place.
+func (d *Neo4jDriver) restoreDatabase(ctx context.Context, m *Manifest, target string, r io.Reader, spool string, exists, drop bool) error {
+	// neo4j-admin database load reads <database>.dump from a directory
+	file := filepath.Join(spool, target+m.extension())
+	if err := database.ExtractFile(file, r, 0o600); err != nil {
+		return err
+	}
+	defer os.Remove(file)
+
+	if exists {
+		if err := d.client.admin(ctx, "STOP DATABASE", target); err != nil {
+			return err
+		}
+	}
+	args := []string{"load", "--from-path=" + spool}
+	if m.Kind == KindBackup {
+		args = []string{"restore", "--from-path=" + file}
+	}
+	if drop {
+		args = append(args, "--overwrite-destination=true")
+	}
+	if err := d.runAdmin(ctx, append(args, target)...); err != nil {
+		if exists {
+			return fmt.Errorf("%w (the database was left stopped)", err)
+		}
+		return err
+	}
+
+	switch {
+	case d.client == nil:
+		return nil
+	case exists:
+		return d.client.admin(ctx, "START DATABASE", target)
+	default:
+		return d.client.admin(ctx, "CREATE DATABASE", target)
+	}
+}
+
+// GetDatabases returns the user databases: online from SHOW DATABASES,
+// offline from the data directory
+func (d *Neo4jDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	var names []string
+	if d.client != nil {
+		dbs, err := d.client.databases(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, db := range dbs {
+			if db.Type != "system" && db.Type != "composite" {
+				names = append(names, db.Name)
+			}
+		}
+	} else {
+		data, err := d.dataDir()
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(filepath.Join(data, "databases"))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() && e.Name() != "system" {
+				names = append(names, e.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetTables returns the node labels of a database; nothing offline
+func (d *Neo4jDriver) GetTables(ctx context.Context, database string) ([]string, error) {
+	if d.client == nil {
+		return nil, nil
+	}
+	rows, err := d.client.query(ctx, database, "CALL db.labels() YIELD label RETURN label ORDER BY label")
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0, len(rows))
+	for _, row := range rows {
+		var label string
+		if len(row) > 0 && json.Unmarshal(row[0], &label) == nil {
+			labels = append(labels, label)
+		}
+	}
+	return labels, nil
+}
+
+// GetTableSize is not supported, labels share the store files
+func (d *Neo4jDriver) GetTableSize(ctx context.Context, database, table string) (int64, error) {
+	return 0, pkgErrors.New(pkgErrors.ErrorTypeDatabase, "neo4j does not size labels")
+}
+
+// GetVersion returns the version of the server, or offline of neo4j-admin
+func (d *Neo4jDriver) GetVersion(ctx context.Context) (string, error) {
+	if d.client != nil {
+		return d.server.Version, nil
+	}
+	return adminVersion(ctx)
+}
+
+// GetType returns the database type
+func (d *Neo4jDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeNeo4j
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *Neo4jDriver) SupportsIncremental() bool {
+	return false // every backup is full
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *Neo4jDriver) SupportsPITR() bool {
+	return false
+}
+
+// dataDir returns the data directory: the data_dir option, else data
+// under neo4j_home
+func (d *Neo4jDriver) dataDir() (string, error) {
+	if dir := d.config.Options["data_dir"]; dir != "" {
+		return dir, nil
+	}
+	if home := d.config.Options["neo4j_home"]; home != "" {
+		return filepath.Join(home, "data"), nil
+	}
+	return "", errors.New("set the neo4j_home or data_dir option to read the data directory")
+}
+
+// dirSize returns the size of the files under dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.Type().IsRegular() {
+			info, err := e.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,297 @@
+package neo4j
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// fakeAdmin installs a neo4j-admin that writes a file for dump and backup,
+// and appends its arguments, then the file it loads or restores, to the
+// returned log
+func fakeAdmin(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake neo4j-admin is a shell script")
+	}
+	bin := t.TempDir()
+	log := filepath.Join(t.TempDir(), "admin.log")
+	script := `#!/bin/sh
+echo "$@" >> ` + log + `
+for a in "$@"; do
+  case $a in
+    --to-path=*) to=${a#--to-path=} ;;
+    --from-path=*) from=${a#--from-path=} ;;
+  esac
+  db=$a
+done
+case $2 in
+  dump) echo "dump of $db" > "$to/$db.dump" ;;
+  backup) echo "backup of $db" > "$to/$db-2025-01-01T00-00-00.backup" ;;
+  load) cat "$from/$db.dump" >> ` + log + ` ;;
+  restore) cat "$from" >> ` + log + ` ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "neo4j-admin"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+// fakeServer answers the discovery document and the Cypher the driver
+// runs, recording administration commands
+type fakeServer struct {
+	mu       sync.Mutex
+	edition  string
+	commands []string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/" {
+		fmt.Fprintf(w, `{"neo4j_version":"5.20.0","neo4j_edition":%q}`, f.edition)
+		return
+	}
+	if user, pass, _ := r.BasicAuth(); user != "neo4j" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Statements []struct {
+			Statement string `json:"statement"`
+		} `json:"statements"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	statement := req.Statements[0].Statement
+	switch {
+	case strings.HasPrefix(statement, "SHOW DATABASES"):
+		// A cluster lists a database once per server
+		fmt.Fprint(w, `{"results":[{"columns":["name","type","currentStatus"],"data":[
+			{"row":["neo4j","standard","online"]},{"row":["neo4j","standard","online"]},
+			{"row":["movies","standard","online"]},{"row":["system","system","online"]},
+			{"row":["all","composite","online"]}]}],"errors":[]}`)
+	case strings.HasPrefix(statement, "CALL db.labels"):
+		fmt.Fprint(w, `{"results":[{"columns":["label"],"data":[{"row":["Movie"]},{"row":["Person"]}]}],"errors":[]}`)
+	case strings.HasSuffix(statement, " WAIT"):
+		f.commands = append(f.commands, statement)
+		fmt.Fprint(w, `{"results":[{"columns":[],"data":[]}],"errors":[]}`)
+	default:
+		fmt.Fprint(w, `{"results":[],"errors":[{"code":"Neo.ClientError.Statement.SyntaxError","message":"unexpected"}]}`)
+	}
+}
+
+func connectOnline(t *testing.T, f *fakeServer) (*Neo4jDriver, error) {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	p, _ := strconv.Atoi(port)
+	d := NewNeo4jDriver()
+	err := d.Connect(context.Background(), &database.ConnectionConfig{
+		Host: host, Port: p, Username: "neo4j", Password: "secret",
+	})
+	return d, err
+}
+
+// entries returns the entries of the archive at path, in order
+func entries(t *testing.T, path string) ([]string, map[string]string) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	contents := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		names = append(names, hdr.Name)
+		contents[hdr.Name] = string(data)
+	}
+}
+
+func readLog(t *testing.T, log string) string {
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCommunityNeedsOfflineMode(t *testing.T) {
+	_, err := connectOnline(t, &fakeServer{edition: "community"})
+	if err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestOnlineBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	log := fakeAdmin(t)
+	f := &fakeServer{edition: "enterprise"}
+	d, err := connectOnline(t, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbs, err := d.GetDatabases(ctx)
+	if err != nil || strings.Join(dbs, ",") != "movies,neo4j" {
+		t.Errorf("databases: got %v, %v", dbs, err)
+	}
+	if labels, err := d.GetTables(ctx, "movies"); err != nil || strings.Join(labels, ",") != "Movie,Person" {
+		t.Errorf("labels: got %v, %v", labels, err)
+	}
+
+	out := filepath.Join(t.TempDir(), "neo4j.tar")
+	result, err := d.Backup(ctx, &database.BackupOptions{AllDatabases: true, OutputPath: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DatabaseVersion != "5.20.0" || result.Metadata["neo4j_backup_kind"] != KindBackup || len(result.Tables) != 2 {
+		t.Errorf("got %+v", result)
+	}
+	names, contents := entries(t, out)
+	if strings.Join(names, ",") != "manifest.json,databases/movies.backup,databases/neo4j.backup" {
+		t.Fatalf("archive: got %v", names)
+	}
+	if contents["databases/movies.backup"] != "backup of movies\n" {
+		t.Errorf("got %q", contents["databases/movies.backup"])
+	}
+	if !strings.Contains(readLog(t, log), "database backup --from="+d.config.Host+":6362") {
+		t.Errorf("admin log:\n%s", readLog(t, log))
+	}
+
+	// An existing database is only replaced with DropExisting
+	opts := &database.RestoreOptions{SourceBackup: out, Database: "movies"}
+	if err := d.ValidateRestore(ctx, opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("got %v", err)
+	}
+	opts.DropExisting = true
+	restore, err := d.Restore(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(restore.RestoredTables, ",") != "movies" {
+		t.Errorf("restored %v", restore.RestoredTables)
+	}
+	if strings.Join(f.commands, ";") != "STOP DATABASE `movies` WAIT;START DATABASE `movies` WAIT" {
+		t.Errorf("commands: got %v", f.commands)
+	}
+	if got := readLog(t, log); !strings.Contains(got, "--overwrite-destination=true movies\nbackup of movies\n") {
+		t.Errorf("admin log:\n%s", got)
+	}
+
+	// Under a new name, the database is created once restored
+	f.commands = nil
+	opts = &database.RestoreOptions{SourceBackup: out, Database: "movies_copy", Metadata: map[string]string{"source_database": "movies"}}
+	if _, err := d.Restore(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(f.commands, ";") != "CREATE DATABASE `movies_copy` WAIT" {
+		t.Errorf("commands: got %v", f.commands)
+	}
+}
+
+func TestOfflineDumpAndLoad(t *testing.T) {
+	ctx := context.Background()
+	log := fakeAdmin(t)
+	home := t.TempDir()
+	for _, db := range []string{"neo4j", "system"} {
+		if err := os.MkdirAll(filepath.Join(home, "data", "databases", db), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(home, "data", "databases", "neo4j", "neostore"), make([]byte, 100), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The version comes from neo4j-admin --version, logged by the fake
+	d := NewNeo4jDriver()
+	if err := d.Connect(ctx, &database.ConnectionConfig{Options: map[string]string{"mode": ModeOffline, "neo4j_home": home}}); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := d.GetBackupSize(ctx, &database.BackupOptions{}); err != nil || size != 100 {
+		t.Errorf("size: got %d, %v", size, err)
+	}
+
+	out := filepath.Join(t.TempDir(), "neo4j.tar")
+	result, err := d.Backup(ctx, &database.BackupOptions{OutputPath: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Metadata["neo4j_backup_kind"] != KindDump || len(result.Tables) != 1 || result.Tables[0].Name != "neo4j" {
+		t.Errorf("got %+v", result)
+	}
+	_, contents := entries(t, out)
+	if contents["databases/neo4j.dump"] != "dump of neo4j\n" {
+		t.Fatalf("archive: got %v", contents)
+	}
+
+	// The only database is loaded under the restore name
+	if _, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: out, Database: "graph"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLog(t, log); !strings.Contains(got, "database load --from-path=") || !strings.Contains(got, " graph\ndump of neo4j\n") {
+		t.Errorf("admin log:\n%s", got)
+	}
+}
+
+func TestPlanRestore(t *testing.T) {
+	m := &Manifest{Kind: KindDump, Databases: []string{"movies", "neo4j"}}
+	for _, tt := range []struct {
+		name     string
+		opts     database.RestoreOptions
+		targets  string
+		wantFail bool
+	}{
+		{name: "every database", targets: "movies=movies,neo4j=neo4j"},
+		{name: "one database", opts: database.RestoreOptions{Database: "neo4j"}, targets: "neo4j=neo4j"},
+		{name: "renamed", opts: database.RestoreOptions{Database: "copy", Metadata: map[string]string{"source_database": "movies"}}, targets: "movies=copy"},
+		{name: "ambiguous rename", opts: database.RestoreOptions{Database: "copy"}, wantFail: true},
+		{name: "missing source", opts: database.RestoreOptions{Metadata: map[string]string{"source_database": "films"}}, wantFail: true},
+		{name: "path in name", opts: database.RestoreOptions{Database: "../x", Metadata: map[string]string{"source_database": "movies"}}, wantFail: true},
+		{name: "labels", opts: database.RestoreOptions{Tables: []string{"Movie"}}, wantFail: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := planRestore(m, &tt.opts)
+			if tt.wantFail {
+				if err == nil {
+					t.Errorf("got %+v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, db := range m.Databases {
+				if target, ok := p.targets[db]; ok {
+					got = append(got, db+"="+target)
+				}
+			}
+			if strings.Join(got, ",") != tt.targets {
+				t.Errorf("got %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package oracle
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// ManifestFormat identifies the archives written by this driver
+const ManifestFormat = "oracle-datapump/v1"
+
+// Data Pump modes of an export
+const (
+	// ModeSchema exports whole schemas (SCHEMAS=)
+	ModeSchema = "schema"
+	// ModeTable exports chosen tables (TABLES=)
+	ModeTable = "table"
+)
+
+const (
+	dumpDir  = "dumpfiles/"
+	logEntry = "export.log"
+)
+
+// Manifest describes an archive. It is its first entry, so restores know
+// what they are restoring before any data.
+type Manifest struct {
+	Format    string    `json:"format"`
+	Mode      string    `json:"mode"`
+	Schemas   []string  `json:"schemas"`
+	Tables    []string  `json:"tables,omitempty"` // SCHEMA.TABLE, in table mode
+	Files     []string  `json:"files"`            // dump file set, by %U suffix
+	Version   string    `json:"version,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// writeArchive writes the manifest, the dump files and the export log to
+// w. files holds the path of each dump file of m.Files, in order.
+func writeArchive(w io.Writer, m *Manifest, files []string, log string) (int64, error) {
+	tw := tar.NewWriter(w)
+	if err := database.WriteManifest(tw, m); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for i, name := range m.Files {
+		n, err := writeFile(tw, dumpDir+name, files[i])
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	// The log only helps diagnose an export, so a missing one is not an
+	// error
+	if _, err := os.Stat(log); err == nil {
+		if _, err := writeFile(tw, logEntry, log); err != nil {
+			return 0, err
+		}
+	}
+	return total, tw.Close()
+}
+
+// writeFile writes file as the entry name
+func writeFile(tw *tar.Writer, name, file string) (int64, error) {
+	f, err := os.Open(file) // #nosec G304 -- dump file of this backup
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := tw.WriteHeader(header(name, info.Size())); err != nil {
+		return 0, err
+	}
+	return io.Copy(tw, f)
+}
+
+func header(name string, size int64) *tar.Header {
+	return &tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+}
+
+// validate checks the export mode of the archive m describes
+func (m *Manifest) validate() error {
+	if m.Mode != ModeSchema && m.Mode != ModeTable {
+		return fmt.Errorf("unsupported export mode %q", m.Mode)
+	}
+	return nil
+}
+
+// entryFile returns the dump file of an archive entry, false for other
+// entries
+func entryFile(name string) (string, bool) {
+	file, ok := strings.CutPrefix(name, dumpDir)
+	if !ok || file == "" || file != path.Base(file) || file == ".." {
+		return "", false
+	}
+	return file, true
+}
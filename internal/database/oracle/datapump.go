@@ -0,0 +1,147 @@
+package oracle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+)
+
+// defaultDirectory is the DIRECTORY object used when the driver manages
+// none: the one every database is created with
+const defaultDirectory = "DATA_PUMP_DIR"
+
+// exitWarnings is the exit status of Data Pump jobs that completed with
+// errors, e.g. an impdp that found a user already there
+const exitWarnings = 5
+
+// directory is the DIRECTORY object a Data Pump job reads and writes its
+// files in
+type directory struct {
+	name    string
+	path    string // on the database server
+	local   string // the same directory, as db-backup reaches it
+	managed bool   // created for the job, dropped after it
+}
+
+// file returns the local path of a file of the directory
+func (dir *directory) file(name string) string {
+	return filepath.Join(dir.local, name)
+}
+
+// openDirectory returns the directory of a job. With the directory_path
+// option, a DIRECTORY object named after the job is created on that path
+// and dropped by release; otherwise the directory option names an
+// existing object, DATA_PUMP_DIR by default. Either way, db-backup reads
+// and writes the dump files directly, at the local_directory option when
+// the server's path is mounted elsewhere on this host.
+func (d *OracleDriver) openDirectory(ctx context.Context, job string) (*directory, error) {
+	dir := &directory{local: d.config.Options["local_directory"]}
+	if path := d.config.Options["directory_path"]; path != "" {
+		dir.name, dir.path, dir.managed = "DBBACKUP_"+strings.ToUpper(job), path, true
+		if err := d.exec(ctx, "CREATE DIRECTORY "+quoteIdent(dir.name)+" AS "+quoteLiteral(path)); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %w", dir.name, err)
+		}
+	} else {
+		dir.name = strings.ToUpper(d.config.Options["directory"])
+		if dir.name == "" {
+			dir.name = defaultDirectory
+		}
+		rows, err := d.query(ctx, "SELECT directory_path FROM all_directories WHERE directory_name = "+quoteLiteral(dir.name))
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("directory %s does not exist or is not granted to %s", dir.name, d.config.Username)
+		}
+		dir.path = strings.Join(rows[0], fieldSeparator)
+	}
+	if dir.local == "" {
+		dir.local = dir.path
+	}
+	if info, err := os.Stat(dir.local); err != nil || !info.IsDir() {
+		d.release(context.WithoutCancel(ctx), dir)
+		return nil, fmt.Errorf("directory %s (%s) is not reachable from this host: set local_directory to where it is mounted", dir.name, dir.path)
+	}
+	return dir, nil
+}
+
+// release drops a directory created for a job
+func (d *OracleDriver) release(ctx context.Context, dir *directory) {
+	if dir.managed {
+		_ = d.exec(ctx, "DROP DIRECTORY "+quoteIdent(dir.name))
+	}
+}
+
+// runDataPump runs expdp or impdp with params. The logon and the
+// parameters go in a parameter file readable by the current user only,
+// which the tools take without quoting trouble from a shell.
+func (d *OracleDriver) runDataPump(ctx context.Context, name string, params []string) (warned bool, err error) {
+	path, err := tool(name)
+	if err != nil {
+		return false, err
+	}
+	parfile, err := os.CreateTemp("", name+"-*.par")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(parfile.Name())
+	content := "USERID='" + d.logon() + "'\n" + strings.Join(params, "\n") + "\n"
+	if _, err := parfile.WriteString(content); err != nil {
+		parfile.Close()
+		return false, err
+	}
+	if err := parfile.Close(); err != nil {
+		return false, err
+	}
+
+	cmd := exec.CommandContext(ctx, path, "PARFILE="+parfile.Name())
+	run := telemetry.StartCommand(ctx, cmd)
+	output, err := cmd.CombinedOutput()
+	run.End(err, -1)
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == exitWarnings {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s failed: %w: %s", name, err, tail(output))
+	}
+	return false, nil
+}
+
+// parallelParams returns the PARALLEL parameter for n workers, none for
+// one
+func parallelParams(n int) []string {
+	if n <= 1 {
+		return nil
+	}
+	return []string{"PARALLEL=" + strconv.Itoa(n)}
+}
+
+// excludeParams returns the EXCLUDE parameter leaving out tables
+func excludeParams(tables []string) []string {
+	if len(tables) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(tables))
+	for i, t := range tables {
+		quoted[i] = quoteLiteral(canonical(t))
+	}
+	return []string{`EXCLUDE=TABLE:"IN (` + strings.Join(quoted, ",") + `)"`}
+}
+
+// tail returns the last lines of a tool's output, where Data Pump reports
+// why a job failed
+func tail(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > 10 {
+		lines = lines[len(lines)-10:]
+	}
+	return strings.Join(lines, "\n")
+}
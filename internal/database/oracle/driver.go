@@ -0,0 +1,675 @@
+// Package oracle provides the Oracle Database driver. Backups are Data
+// Pump exports taken with expdp and restored with impdp; sqlplus runs the
+// few queries the driver needs, so the Oracle client tools must be
+// installed, and no Oracle library is linked in.
+//
+// Databases are schemas: a backup exports the schemas named, or every
+// schema not maintained by Oracle, or with tables only those tables
+// (SCHEMA.TABLE, or TABLE in the backup database's schema). Names are
+// upper-cased, as Oracle stores unquoted identifiers. The backup's
+// parallelism sets Data Pump's PARALLEL, writing as many dump files.
+//
+// Data Pump writes its files on the database server, in a DIRECTORY
+// object. With the directory_path option the driver creates one on that
+// path for each job and drops it afterwards, which needs the CREATE ANY
+// DIRECTORY privilege; otherwise it uses the existing object named by the
+// directory option, DATA_PUMP_DIR by default. db-backup reads and writes
+// the dump files there itself, so it must run on the server or reach the
+// directory over a shared mount, set with local_directory when mounted at
+// another path.
+//
+// The server is reached with the connection string, or at host and port
+// with the service_name option. A backup is a tar archive: a manifest,
+// the dump file set and the export log.
+package oracle
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// OracleDriver implements the database.Driver interface for Oracle
+type OracleDriver struct {
+	config     *database.ConnectionConfig
+	identifier string
+	version    string
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeOracle, func() database.Driver {
+		return NewOracleDriver()
+	})
+}
+
+// NewOracleDriver creates a new Oracle driver instance
+func NewOracleDriver() *OracleDriver {
+	return &OracleDriver{}
+}
+
+// Connect logs on with sqlplus and reads the server version
+func (d *OracleDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	identifier, err := connectIdentifier(config)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if strings.Contains(config.Password, `"`) {
+		return pkgErrors.ErrDatabaseConnection(errors.New("oracle passwords cannot contain double quotes"))
+	}
+	d.config, d.identifier = config, identifier
+	version, err := d.queryVersion(ctx)
+	if err != nil {
+		d.config = nil
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.version = version
+	return nil
+}
+
+// Disconnect does nothing: every query runs its own sqlplus session
+func (d *OracleDriver) Disconnect() error {
+	return nil
+}
+
+// Ping tests the connection
+func (d *OracleDriver) Ping(ctx context.Context) error {
+	if d.config == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	return d.exec(ctx, "SELECT 1 FROM dual")
+}
+
+// Backup writes an archive of a Data Pump export to opts.OutputPath
+func (d *OracleDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	m, tables, err := d.backup(ctx, opts, output)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = m.Version
+	result.Size = output.Written()
+	result.Checksum = output.Sum()
+	result.Tables = tables
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"oracle_export_mode": m.Mode})
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// StreamBackup streams an archive of a Data Pump export to writer
+func (d *OracleDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	_, _, err := d.backup(ctx, opts, writer)
+	return err
+}
+
+// backup exports into the job's directory, then writes the archive of the
+// dump files to w and removes them
+func (d *OracleDriver) backup(ctx context.Context, opts *database.BackupOptions, w io.Writer) (*Manifest, []database.TableInfo, error) {
+	m := &Manifest{
+		Format:    ManifestFormat,
+		Mode:      ModeSchema,
+		Version:   d.version,
+		CreatedAt: time.Now().UTC(),
+	}
+	params := []string{"FLASHBACK_TIME=SYSTIMESTAMP"} // consistent across schemas
+	if len(opts.Tables) > 0 {
+		m.Mode, m.Tables = ModeTable, qualify(opts.Tables, d.defaultSchema(opts.Database))
+		for _, t := range m.Tables {
+			if schema, _, _ := strings.Cut(t, "."); !slices.Contains(m.Schemas, schema) {
+				m.Schemas = append(m.Schemas, schema)
+			}
+		}
+		params = append(params, "TABLES="+strings.Join(m.Tables, ","))
+	} else {
+		schemas, err := d.backupSchemas(ctx, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.Schemas = schemas
+		params = append(params, "SCHEMAS="+strings.Join(schemas, ","))
+		params = append(params, excludeParams(opts.ExcludeTables)...)
+	}
+	tables, err := d.tableInfos(ctx, m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	job := jobName()
+	dir, err := d.openDirectory(ctx, job)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer d.release(context.WithoutCancel(ctx), dir)
+	prefix := "dbbackup_" + strings.ToLower(job)
+	log := dir.file(prefix + ".log")
+	defer os.Remove(log)
+	params = append(params,
+		"DIRECTORY="+dir.name,
+		"DUMPFILE="+prefix+"_%U.dmp",
+		"LOGFILE="+prefix+".log",
+	)
+	params = append(params, parallelParams(opts.Parallel)...)
+
+	warned, err := d.runDataPump(ctx, "expdp", params)
+	files, _ := filepath.Glob(dir.file(prefix + "_*.dmp"))
+	sort.Strings(files)
+	for _, file := range files {
+		defer os.Remove(file)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if warned {
+		data, _ := os.ReadFile(log) // #nosec G304 -- log of this export
+		return nil, nil, fmt.Errorf("expdp completed with errors: %s", tail(data))
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("expdp wrote no dump files in %s", dir.local)
+	}
+	for _, file := range files {
+		m.Files = append(m.Files, strings.TrimPrefix(filepath.Base(file), prefix+"_"))
+	}
+	if _, err := writeArchive(w, m, files, log); err != nil {
+		return nil, nil, err
+	}
+	return m, tables, nil
+}
+
+// backupSchemas returns the schemas to export: those named, or every
+// schema not maintained by Oracle
+func (d *OracleDriver) backupSchemas(ctx context.Context, opts *database.BackupOptions) ([]string, error) {
+	switch {
+	case len(opts.Databases) > 0 && !opts.AllDatabases:
+		return canonicalAll(opts.Databases), nil
+	case opts.Database != "" && !opts.AllDatabases:
+		return []string{canonical(opts.Database)}, nil
+	}
+	schemas, err := d.GetDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(schemas) == 0 {
+		return nil, errors.New("no schemas to back up")
+	}
+	return schemas, nil
+}
+
+// tableInfos returns the tables of an export, with the row counts of
+// their last statistics
+func (d *OracleDriver) tableInfos(ctx context.Context, m *Manifest) ([]database.TableInfo, error) {
+	rows, err := d.query(ctx, "SELECT owner || '|' || table_name || '|' || NVL(num_rows, 0) FROM all_tables WHERE owner IN ("+literals(m.Schemas)+") ORDER BY owner, table_name")
+	if err != nil {
+		return nil, err
+	}
+	var tables []database.TableInfo
+	for _, row := range rows {
+		if len(row) < 3 {
+			continue
+		}
+		name := row[0] + "." + row[1]
+		if m.Mode == ModeTable && !slices.Contains(m.Tables, name) {
+			continue
+		}
+		count, _ := strconv.ParseInt(row[2], 10, 64)
+		tables = append(tables, database.TableInfo{Name: name, RowCount: count})
+	}
+	return tables, nil
+}
+
+// GetBackupSize returns the size of the segments of the schemas or tables
+// to export, from dba_segments
+func (d *OracleDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	if len(opts.Tables) > 0 {
+		var total int64
+		for _, t := range qualify(opts.Tables, d.defaultSchema(opts.Database)) {
+			schema, table, _ := strings.Cut(t, ".")
+			size, err := d.GetTableSize(ctx, schema, table)
+			if err != nil {
+				return 0, err
+			}
+			total += size
+		}
+		return total, nil
+	}
+	schemas, err := d.backupSchemas(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	return d.segmentBytes(ctx, "owner IN ("+literals(schemas)+")")
+}
+
+// Restore imports the dump file set of an archive
+func (d *OracleDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+	fail := func(err error) (*database.RestoreResult, error) {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	restored, err := d.restore(ctx, opts, f)
+	if err != nil {
+		return fail(err)
+	}
+	result.RestoredTables = restored
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+	return result, nil
+}
+
+// StreamRestore imports the dump file set of an archive read from reader
+func (d *OracleDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	_, err := d.restore(ctx, opts, reader)
+	return err
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *OracleDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer f.Close()
+	m, err := database.ReadManifest(tar.NewReader(f), ManifestFormat, (*Manifest).validate)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	p, err := planRestore(m, opts)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if err := d.checkTargets(ctx, p, opts.DropExisting); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if _, err := tool("impdp"); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	return nil
+}
+
+// restorePlan is what an import restores
+type restorePlan struct {
+	schemas []string          // in the archive; all of them when empty
+	remap   map[string]string // target of a renamed schema
+	tables  []string          // SCHEMA.TABLE in the archive; all when empty
+}
+
+// target returns the schema a schema of the archive is restored to
+func (p *restorePlan) target(schema string) string {
+	if t, ok := p.remap[schema]; ok {
+		return t
+	}
+	return schema
+}
+
+// planRestore decides what to import: the restore database when the
+// archive holds that schema, the only schema of the archive under the
+// restore database's name, or every schema of the archive. The
+// source_database metadata picks the schema of an archive of several to
+// rename. Tables narrow the import to tables of one schema.
+func planRestore(m *Manifest, opts *database.RestoreOptions) (*restorePlan, error) {
+	switch {
+	case opts.PointInTime != nil:
+		return nil, errors.New("point-in-time restores are not supported for oracle")
+	case len(m.Schemas) == 0 || len(m.Files) == 0:
+		return nil, errors.New("the backup holds no schemas")
+	}
+
+	p := &restorePlan{remap: make(map[string]string)}
+	name, target := canonical(opts.Database), canonical(opts.Target())
+	source := canonical(opts.Metadata["source_database"])
+	switch {
+	case source != "":
+		if !slices.Contains(m.Schemas, source) {
+			return nil, fmt.Errorf("the backup holds no schema %s (it holds %s)", source, strings.Join(m.Schemas, ", "))
+		}
+	case target == "" || slices.Contains(m.Schemas, name):
+		source = name
+	case len(m.Schemas) == 1:
+		source = m.Schemas[0]
+	default:
+		return nil, fmt.Errorf("the backup holds %s: set the source_database metadata to restore one of them as %s",
+			strings.Join(m.Schemas, ", "), target)
+	}
+	if source != "" {
+		p.schemas = []string{source}
+		if target != "" && target != source {
+			p.remap[source] = target
+		}
+	}
+
+	if len(opts.Tables) > 0 {
+		if source == "" && len(m.Schemas) > 1 {
+			return nil, errors.New("tables are restored from one schema: set the restore database")
+		}
+		if source == "" {
+			source = m.Schemas[0]
+		}
+		p.tables = qualify(opts.Tables, source)
+		for _, t := range p.tables {
+			schema, _, _ := strings.Cut(t, ".")
+			if schema != source {
+				return nil, fmt.Errorf("table %s is not in schema %s", t, source)
+			}
+			if m.Mode == ModeTable && !slices.Contains(m.Tables, t) {
+				return nil, fmt.Errorf("the backup holds no table %s", t)
+			}
+		}
+	}
+	return p, nil
+}
+
+// checkTargets fails when tables the import would create already exist
+// and are not to be replaced
+func (d *OracleDriver) checkTargets(ctx context.Context, p *restorePlan, drop bool) error {
+	if drop || len(p.schemas) == 0 {
+		return nil
+	}
+	targets := make([]string, len(p.schemas))
+	for i, s := range p.schemas {
+		targets[i] = p.target(s)
+	}
+	rows, err := d.query(ctx, "SELECT owner || '.' || table_name FROM all_tables WHERE owner IN ("+literals(targets)+") ORDER BY 1")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		existing := row[0]
+		if len(p.tables) > 0 {
+			schema, table, _ := strings.Cut(existing, ".")
+			wanted := false
+			for _, t := range p.tables {
+				source, name, _ := strings.Cut(t, ".")
+				wanted = wanted || (p.target(source) == schema && name == table)
+			}
+			if !wanted {
+				continue
+			}
+		}
+		return fmt.Errorf("table %s already exists, use --drop-existing to replace it", existing)
+	}
+	return nil
+}
+
+// restore extracts the dump file set from r into a directory and imports
+// it with impdp
+func (d *OracleDriver) restore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) ([]string, error) {
+	tr := tar.NewReader(r)
+	m, err := database.ReadManifest(tr, ManifestFormat, (*Manifest).validate)
+	if err != nil {
+		return nil, err
+	}
+	p, err := planRestore(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkTargets(ctx, p, opts.DropExisting); err != nil {
+		return nil, err
+	}
+
+	job := jobName()
+	dir, err := d.openDirectory(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	defer d.release(context.WithoutCancel(ctx), dir)
+	prefix := "dbrestore_" + strings.ToLower(job)
+	defer os.Remove(dir.file(prefix + ".log"))
+	extracted := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		file, ok := entryFile(hdr.Name)
+		if !ok {
+			continue // the export log
+		}
+		path := dir.file(prefix + "_" + file)
+		defer os.Remove(path)
+		// The database server reads the files as its own user
+		if err := database.ExtractFile(path, tr, 0o644); err != nil {
+			return nil, err
+		}
+		extracted++
+	}
+	if extracted < len(m.Files) {
+		return nil, fmt.Errorf("the backup is missing %d of its %d dump files", len(m.Files)-extracted, len(m.Files))
+	}
+
+	params := []string{
+		"DIRECTORY=" + dir.name,
+		"DUMPFILE=" + prefix + "_%U.dmp",
+		"LOGFILE=" + prefix + ".log",
+	}
+	switch {
+	case len(p.tables) > 0:
+		params = append(params, "TABLES="+strings.Join(p.tables, ","))
+	case len(p.schemas) > 0 && m.Mode == ModeSchema:
+		params = append(params, "SCHEMAS="+strings.Join(p.schemas, ","))
+	case len(p.schemas) > 0:
+		var tables []string
+		for _, t := range m.Tables {
+			if schema, _, _ := strings.Cut(t, "."); slices.Contains(p.schemas, schema) {
+				tables = append(tables, t)
+			}
+		}
+		params = append(params, "TABLES="+strings.Join(tables, ","))
+	}
+	for source, target := range p.remap {
+		params = append(params, "REMAP_SCHEMA="+source+":"+target)
+	}
+	if opts.DropExisting {
+		params = append(params, "TABLE_EXISTS_ACTION=REPLACE")
+	}
+	params = append(params, excludeParams(opts.ExcludeTables)...)
+	params = append(params, parallelParams(opts.Parallel)...)
+
+	// impdp also exits with warnings for objects it could not create
+	// because they exist, e.g. the user of an existing schema
+	if _, err := d.runDataPump(ctx, "impdp", params); err != nil {
+		return nil, err
+	}
+	return p.restored(m), nil
+}
+
+// restored returns what an import restored: the target tables, or the
+// target schemas
+func (p *restorePlan) restored(m *Manifest) []string {
+	var restored []string
+	if len(p.tables) > 0 {
+		for _, t := range p.tables {
+			schema, table, _ := strings.Cut(t, ".")
+			restored = append(restored, p.target(schema)+"."+table)
+		}
+		return restored
+	}
+	schemas := p.schemas
+	if len(schemas) == 0 {
+		schemas = m.Schemas
+	}
+	for _, s := range schemas {
+		restored = append(restored, p.target(s))
+	}
+	return restored
+}
+
+// GetDatabases returns the schemas not maintained by Oracle
+func (d *OracleDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	rows, err := d.query(ctx, "SELECT username FROM all_users WHERE oracle_maintained = 'N' ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	return firstColumn(rows), nil
+}
+
+// GetTables returns the tables of a schema
+func (d *OracleDriver) GetTables(ctx context.Context, database string) ([]string, error) {
+	rows, err := d.query(ctx, "SELECT table_name FROM all_tables WHERE owner = "+quoteLiteral(canonical(database))+" ORDER BY table_name")
+	if err != nil {
+		return nil, err
+	}
+	return firstColumn(rows), nil
+}
+
+// GetTableSize returns the size of a table's segments, from dba_segments
+func (d *OracleDriver) GetTableSize(ctx context.Context, database, table string) (int64, error) {
+	return d.segmentBytes(ctx, "owner = "+quoteLiteral(canonical(database))+" AND segment_name = "+quoteLiteral(canonical(table)))
+}
+
+// segmentBytes returns the size of the segments matching where
+func (d *OracleDriver) segmentBytes(ctx context.Context, where string) (int64, error) {
+	rows, err := d.query(ctx, "SELECT NVL(SUM(bytes), 0) FROM dba_segments WHERE "+where)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(rows[0][0], 10, 64)
+}
+
+// GetVersion returns the version of the server
+func (d *OracleDriver) GetVersion(ctx context.Context) (string, error) {
+	if d.version != "" {
+		return d.version, nil
+	}
+	return d.queryVersion(ctx)
+}
+
+// queryVersion reads the version of the server
+func (d *OracleDriver) queryVersion(ctx context.Context) (string, error) {
+	rows, err := d.query(ctx, "SELECT version FROM product_component_version WHERE product LIKE 'Oracle Database%' AND ROWNUM = 1")
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", errors.New("not an Oracle database")
+	}
+	return rows[0][0], nil
+}
+
+// GetType returns the database type
+func (d *OracleDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeOracle
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *OracleDriver) SupportsIncremental() bool {
+	return false // Data Pump exports are always full
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *OracleDriver) SupportsPITR() bool {
+	return false
+}
+
+// defaultSchema returns the schema of unqualified tables: the backup
+// database, else the user's own
+func (d *OracleDriver) defaultSchema(db string) string {
+	if db != "" {
+		return canonical(db)
+	}
+	return canonical(d.config.Username)
+}
+
+// jobName returns a name unique to a Data Pump job, short enough for the
+// 30 characters of identifiers before Oracle 12.2
+func jobName() string {
+	return strings.ToUpper(strconv.FormatInt(time.Now().UnixNano(), 36))
+}
+
+// canonical returns an identifier as Oracle stores it unquoted
+func canonical(name string) string {
+	return strings.ToUpper(strings.TrimSpace(name))
+}
+
+func canonicalAll(names []string) []string {
+	out := make([]string, len(names))
+	for i, n := range names {
+		out[i] = canonical(n)
+	}
+	return out
+}
+
+// qualify returns tables as SCHEMA.TABLE, in schema when unqualified
+func qualify(tables []string, schema string) []string {
+	out := make([]string, len(tables))
+	for i, t := range tables {
+		t = canonical(t)
+		if !strings.Contains(t, ".") {
+			t = schema + "." + t
+		}
+		out[i] = t
+	}
+	return out
+}
+
+// literals returns names as a list of string literals
+func literals(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quoteLiteral(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// firstColumn returns the first column of rows
+func firstColumn(rows [][]string) []string {
+	out := make([]string, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, row[0])
+	}
+	return out
+}
@@ -0,0 +1,272 @@
+package oracle
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// fakeTools installs sqlplus, expdp and impdp scripts working on dir, the
+// DATA_PUMP_DIR of the fake server. sqlplus answers the driver's queries
+// and lists the tables in the existing file as those already there; the
+// Data Pump tools append their parameter files, and impdp the dump files
+// it reads, to the returned log.
+func fakeTools(t *testing.T, dir string) (log, existing string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake Oracle tools are shell scripts")
+	}
+	bin := t.TempDir()
+	log = filepath.Join(t.TempDir(), "tools.log")
+	existing = filepath.Join(t.TempDir(), "existing")
+	if err := os.WriteFile(existing, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[string]string{
+		"sqlplus": `#!/bin/sh
+script=$(cat)
+echo "$script" | grep -v CONNECT >> ` + log + `
+case $script in
+  *'"wrong"'*) echo "ORA-01017: invalid username/password; logon denied" ;;
+  *product_component_version*) echo "19.0.0.0.0" ;;
+  *all_users*) printf 'HR\nSALES\n' ;;
+  *all_directories*) echo "` + dir + `" ;;
+  *num_rows*) printf 'HR|EMPLOYEES|107\nHR|JOBS|19\n' ;;
+  *"'.' || table_name"*) cat ` + existing + ` ;;
+  *dba_segments*) echo 65536 ;;
+esac
+`,
+		"expdp": `#!/bin/sh
+par=${1#PARFILE=}
+grep -v USERID "$par" >> ` + log + `
+n=1
+while read -r line; do
+  case $line in
+    DUMPFILE=*) file=${line#DUMPFILE=} ;;
+    LOGFILE=*) logfile=${line#LOGFILE=} ;;
+    PARALLEL=*) n=${line#PARALLEL=} ;;
+  esac
+done < "$par"
+i=1
+while [ $i -le $n ]; do
+  u=$(printf '%02d' $i)
+  echo "dump $u" > "` + dir + `/$(echo "$file" | sed "s/%U/$u/")"
+  i=$((i+1))
+done
+echo "Job successfully completed" > "` + dir + `/$logfile"
+`,
+		"impdp": `#!/bin/sh
+par=${1#PARFILE=}
+grep -v USERID "$par" >> ` + log + `
+file=$(sed -n 's/^DUMPFILE=//p' "$par" | sed 's/%U/*/')
+cat ` + dir + `/$file >> ` + log + `
+`,
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log, existing
+}
+
+func connect(t *testing.T, password string, options map[string]string) (*OracleDriver, error) {
+	if options == nil {
+		options = map[string]string{}
+	}
+	options["service_name"] = "ORCLPDB1"
+	d := NewOracleDriver()
+	err := d.Connect(context.Background(), &database.ConnectionConfig{
+		Host: "db", Port: 1521, Username: "system", Password: password, Options: options,
+	})
+	return d, err
+}
+
+func readLog(t *testing.T, log string) string {
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// entries returns the entries of the archive at path
+func entries(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	contents := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		contents[hdr.Name] = string(data)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	fakeTools(t, t.TempDir())
+	if _, err := connect(t, "wrong", nil); err == nil || !strings.Contains(err.Error(), "ORA-01017") {
+		t.Errorf("got %v", err)
+	}
+	d, err := connect(t, "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.identifier != "//db:1521/ORCLPDB1" {
+		t.Errorf("identifier: got %s", d.identifier)
+	}
+	if v, _ := d.GetVersion(context.Background()); v != "19.0.0.0.0" {
+		t.Errorf("version: got %s", v)
+	}
+	if _, err := connectIdentifier(&database.ConnectionConfig{Host: "db", Port: 1521}); err == nil {
+		t.Error("connected without a service name")
+	}
+}
+
+func TestParallelSchemaBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	log, existing := fakeTools(t, dir)
+	d, err := connect(t, "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "hr.tar")
+	result, err := d.Backup(ctx, &database.BackupOptions{Database: "hr", Parallel: 2, ExcludeTables: []string{"jobs"}, OutputPath: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Metadata["oracle_export_mode"] != ModeSchema || len(result.Tables) != 2 || result.Tables[0].RowCount != 107 {
+		t.Errorf("got %+v", result)
+	}
+	got := readLog(t, log)
+	for _, want := range []string{"SCHEMAS=HR\n", "PARALLEL=2\n", "DIRECTORY=DATA_PUMP_DIR\n", `EXCLUDE=TABLE:"IN ('JOBS')"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	contents := entries(t, out)
+	if contents["dumpfiles/01.dmp"] != "dump 01\n" || contents["dumpfiles/02.dmp"] != "dump 02\n" || contents[logEntry] == "" {
+		t.Errorf("archive: got %v", contents)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("left %d files in the directory", len(files))
+	}
+
+	// Existing tables are only replaced with DropExisting
+	if err := os.WriteFile(existing, []byte("HR.EMPLOYEES\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := &database.RestoreOptions{SourceBackup: out, Database: "hr", Parallel: 4}
+	if err := d.ValidateRestore(ctx, opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("got %v", err)
+	}
+	opts.DropExisting = true
+	restore, err := d.Restore(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(restore.RestoredTables, ",") != "HR" {
+		t.Errorf("restored %v", restore.RestoredTables)
+	}
+	got = readLog(t, log)
+	for _, want := range []string{"TABLE_EXISTS_ACTION=REPLACE\n", "PARALLEL=4\n", "dump 01\ndump 02\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	// The only schema is restored under another name
+	if err := os.WriteFile(existing, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	restore, err = d.Restore(ctx, &database.RestoreOptions{SourceBackup: out, Database: "hr_copy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(restore.RestoredTables, ",") != "HR_COPY" || !strings.Contains(readLog(t, log), "REMAP_SCHEMA=HR:HR_COPY\n") {
+		t.Errorf("restored %v, log:\n%s", restore.RestoredTables, readLog(t, log))
+	}
+}
+
+func TestTableBackupInManagedDirectory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	log, _ := fakeTools(t, dir)
+	d, err := connect(t, "secret", map[string]string{"directory_path": dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "tables.tar")
+	result, err := d.Backup(ctx, &database.BackupOptions{Database: "hr", Tables: []string{"employees", "sales.orders"}, OutputPath: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Metadata["oracle_export_mode"] != ModeTable || len(result.Tables) != 1 || result.Tables[0].Name != "HR.EMPLOYEES" {
+		t.Errorf("got %+v", result)
+	}
+	got := readLog(t, log)
+	for _, want := range []string{"TABLES=HR.EMPLOYEES,SALES.ORDERS\n", `CREATE DIRECTORY "DBBACKUP_`, `AS '` + dir + `'`, `DROP DIRECTORY "DBBACKUP_`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "PARALLEL") {
+		t.Errorf("one worker asked for PARALLEL:\n%s", got)
+	}
+}
+
+func TestPlanRestore(t *testing.T) {
+	m := &Manifest{Mode: ModeSchema, Schemas: []string{"HR", "SALES"}, Files: []string{"01.dmp"}}
+	for _, tt := range []struct {
+		name     string
+		opts     database.RestoreOptions
+		restored string
+		wantFail bool
+	}{
+		{name: "every schema", restored: "HR,SALES"},
+		{name: "one schema", opts: database.RestoreOptions{Database: "sales"}, restored: "SALES"},
+		{name: "renamed", opts: database.RestoreOptions{Database: "hr2", Metadata: map[string]string{"source_database": "hr"}}, restored: "HR2"},
+		{name: "tables", opts: database.RestoreOptions{Database: "hr", Tables: []string{"employees"}}, restored: "HR.EMPLOYEES"},
+		{name: "renamed tables", opts: database.RestoreOptions{Database: "hr2", Tables: []string{"hr.jobs"}, Metadata: map[string]string{"source_database": "hr"}}, restored: "HR2.JOBS"},
+		{name: "target database", opts: database.RestoreOptions{Database: "hr", TargetDatabase: "hr_verify"}, restored: "HR_VERIFY"},
+		{name: "ambiguous rename", opts: database.RestoreOptions{Database: "copy"}, wantFail: true},
+		{name: "ambiguous target", opts: database.RestoreOptions{TargetDatabase: "copy"}, wantFail: true},
+		{name: "missing source", opts: database.RestoreOptions{Metadata: map[string]string{"source_database": "crm"}}, wantFail: true},
+		{name: "tables of several schemas", opts: database.RestoreOptions{Tables: []string{"hr.jobs"}}, wantFail: true},
+		{name: "table of another schema", opts: database.RestoreOptions{Database: "hr", Tables: []string{"sales.orders"}}, wantFail: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := planRestore(m, &tt.opts)
+			if tt.wantFail {
+				if err == nil {
+					t.Errorf("got %+v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(p.restored(m), ","); got != tt.restored {
+				t.Errorf("got %s", got)
+			}
+		})
+	}
+}
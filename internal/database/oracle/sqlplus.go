@@ -0,0 +1,114 @@
+package oracle
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+)
+
+// fieldSeparator separates the columns of the rows queries print
+const fieldSeparator = "|"
+
+// tool returns the path of an Oracle client tool
+func tool(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s is not installed", name)
+	}
+	return path, nil
+}
+
+// connectIdentifier returns what follows @ in a logon: the connection
+// string when set (a TNS alias or an Easy Connect string), else the Easy
+// Connect string of host, port and the service_name option, over TCPS
+// when ssl_mode asks for TLS
+func connectIdentifier(config *database.ConnectionConfig) (string, error) {
+	if config.ConnectionString != "" {
+		return config.ConnectionString, nil
+	}
+	service := config.Options["service_name"]
+	if service == "" {
+		return "", errors.New("set the service_name option or a connection string")
+	}
+	address := "//" + net.JoinHostPort(config.Host, strconv.Itoa(config.Port)) + "/" + service
+	// Certificates for TCPS come from the client's wallet, as configured
+	// in its sqlnet.ora
+	if config.TLSMode() != database.SSLDisable {
+		address = "tcps:" + address
+	}
+	return address, nil
+}
+
+// logon returns the credentials and connect identifier as sqlplus and
+// Data Pump take them. The password is quoted so it may hold any
+// character but the double quote.
+func (d *OracleDriver) logon() string {
+	return d.config.Username + `/"` + d.config.Password + `"@` + d.identifier
+}
+
+// query runs statement in sqlplus and returns its rows, split into
+// columns on fieldSeparator. The logon is written to sqlplus' standard
+// input, never to its arguments, where other users could read it.
+func (d *OracleDriver) query(ctx context.Context, statement string) ([][]string, error) {
+	sqlplus, err := tool("sqlplus")
+	if err != nil {
+		return nil, err
+	}
+	script := strings.Join([]string{
+		"WHENEVER OSERROR EXIT FAILURE",
+		"WHENEVER SQLERROR EXIT FAILURE",
+		"SET HEADING OFF FEEDBACK OFF PAGESIZE 0 LINESIZE 32767 TRIMOUT ON TAB OFF VERIFY OFF ECHO OFF",
+		"CONNECT " + d.logon(),
+		statement + ";",
+		"EXIT",
+	}, "\n") + "\n"
+	cmd := exec.CommandContext(ctx, sqlplus, "-S", "-L", "/nolog")
+	cmd.Stdin = strings.NewReader(script)
+	run := telemetry.StartCommand(ctx, cmd)
+	output, err := cmd.CombinedOutput()
+	run.End(err, -1)
+
+	var rows [][]string
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		// Failed logons do not trip WHENEVER SQLERROR, so errors are read
+		// from the output too
+		if strings.HasPrefix(line, "ORA-") || strings.HasPrefix(line, "SP2-") {
+			return nil, errors.New(line)
+		}
+		if line == "" {
+			continue
+		}
+		rows = append(rows, strings.Split(line, fieldSeparator))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqlplus failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return rows, nil
+}
+
+// exec runs a statement that returns no rows
+func (d *OracleDriver) exec(ctx context.Context, statement string) error {
+	_, err := d.query(ctx, statement)
+	return err
+}
+
+// quoteLiteral quotes a string literal
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteIdent quotes an identifier
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,239 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/pluginrpc"
+)
+
+// Driver is the host side of a plugin. It implements database.Driver by
+// starting the plugin on Connect and stopping it on Disconnect.
+type Driver struct {
+	path             string
+	dbType           database.DatabaseType
+	handshakeTimeout time.Duration
+
+	proc *pluginrpc.Process
+	conn *grpc.ClientConn
+	info Info
+}
+
+// NewDriver creates a driver served by the plugin executable at path
+func NewDriver(path string, dbType database.DatabaseType, handshakeTimeout time.Duration) *Driver {
+	return &Driver{path: path, dbType: dbType, handshakeTimeout: handshakeTimeout}
+}
+
+// Connect starts the plugin and connects it to the database
+func (d *Driver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	if d.conn != nil {
+		return d.error("already connected", nil)
+	}
+	proc, err := protocol.Start(d.path, d.handshakeTimeout)
+	if err != nil {
+		return d.error("failed to start plugin", err)
+	}
+	conn, err := proc.Dial()
+	if err != nil {
+		proc.Stop()
+		return d.error("failed to dial plugin", err)
+	}
+	d.proc, d.conn = proc, conn
+
+	var info Info
+	if err := d.invoke(ctx, methodConnect, config, &info); err != nil {
+		d.close()
+		return err
+	}
+	d.info = info
+	return nil
+}
+
+// Disconnect disconnects the plugin from the database and stops it
+func (d *Driver) Disconnect() error {
+	if d.conn == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginrpc.StopTimeout)
+	defer cancel()
+	err := d.invoke(ctx, methodDisconnect, &done{}, &done{})
+	d.close()
+	return err
+}
+
+// close drops the connection and stops the plugin
+func (d *Driver) close() {
+	d.conn.Close()
+	d.proc.Stop()
+	d.conn, d.proc = nil, nil
+}
+
+// Ping checks the database connection
+func (d *Driver) Ping(ctx context.Context) error {
+	return d.invoke(ctx, methodPing, &done{}, &done{})
+}
+
+// Backup runs a backup to a file
+func (d *Driver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	var reply backupReply
+	if err := d.invoke(ctx, methodBackup, opts, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Result != nil && reply.Error != "" {
+		reply.Result.Error = errors.New(reply.Error)
+	}
+	return reply.Result, nil
+}
+
+// StreamBackup streams a dump into writer
+func (d *Driver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	if d.conn == nil {
+		return d.error("not connected", nil)
+	}
+	stream, err := d.conn.NewStream(ctx, &serviceDesc.Streams[0], methodStreamBackup)
+	if err != nil {
+		return d.status(err)
+	}
+	if err := stream.SendMsg(opts); err != nil {
+		return d.status(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return d.status(err)
+	}
+	r := recvChunks(nil, stream.RecvMsg)
+	if _, err := io.Copy(writer, r); err != nil {
+		return d.status(err)
+	}
+	return nil
+}
+
+// GetBackupSize estimates the size of a backup
+func (d *Driver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	var reply sizeReply
+	err := d.invoke(ctx, methodGetBackupSize, opts, &reply)
+	return reply.Size, err
+}
+
+// Restore restores a backup from a file
+func (d *Driver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	var reply restoreReply
+	if err := d.invoke(ctx, methodRestore, opts, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Result != nil && reply.Error != "" {
+		reply.Result.Error = errors.New(reply.Error)
+	}
+	return reply.Result, nil
+}
+
+// StreamRestore streams a dump from reader into the database
+func (d *Driver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	if d.conn == nil {
+		return d.error("not connected", nil)
+	}
+	stream, err := d.conn.NewStream(ctx, &serviceDesc.Streams[1], methodStreamRestore)
+	if err != nil {
+		return d.status(err)
+	}
+	if err := stream.SendMsg(&Chunk{Options: opts}); err != nil && err != io.EOF {
+		return d.status(err)
+	}
+
+	// A send fails with io.EOF once the plugin has given up on the
+	// restore; its error is then returned by RecvMsg
+	w := sendChunks(stream.SendMsg)
+	_, copyErr := io.Copy(w, reader)
+	if copyErr == nil {
+		copyErr = w.Flush()
+	}
+	if copyErr != nil && copyErr != io.EOF {
+		return d.error("failed to send dump", copyErr)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return d.status(err)
+	}
+	if err := stream.RecvMsg(&done{}); err != nil {
+		return d.status(err)
+	}
+	return nil
+}
+
+// ValidateRestore checks that a restore can run
+func (d *Driver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	return d.invoke(ctx, methodValidateRestore, opts, &done{})
+}
+
+// GetDatabases lists the databases on the server
+func (d *Driver) GetDatabases(ctx context.Context) ([]string, error) {
+	var reply namesReply
+	err := d.invoke(ctx, methodGetDatabases, &done{}, &reply)
+	return reply.Names, err
+}
+
+// GetTables lists the tables of a database
+func (d *Driver) GetTables(ctx context.Context, db string) ([]string, error) {
+	var reply namesReply
+	err := d.invoke(ctx, methodGetTables, &tablesRequest{Database: db}, &reply)
+	return reply.Names, err
+}
+
+// GetTableSize returns the size of a table
+func (d *Driver) GetTableSize(ctx context.Context, db, table string) (int64, error) {
+	var reply sizeReply
+	err := d.invoke(ctx, methodGetTableSize, &tableSizeRequest{Database: db, Table: table}, &reply)
+	return reply.Size, err
+}
+
+// GetVersion returns the database server version
+func (d *Driver) GetVersion(ctx context.Context) (string, error) {
+	var reply versionReply
+	err := d.invoke(ctx, methodGetVersion, &done{}, &reply)
+	return reply.Version, err
+}
+
+// GetType returns the database type the plugin is registered for
+func (d *Driver) GetType() database.DatabaseType {
+	return d.dbType
+}
+
+// SupportsIncremental reports whether the plugin supports incremental
+// backups. It is false until connected.
+func (d *Driver) SupportsIncremental() bool {
+	return d.info.Incremental
+}
+
+// SupportsPITR reports whether the plugin supports point-in-time recovery.
+// It is false until connected.
+func (d *Driver) SupportsPITR() bool {
+	return d.info.PITR
+}
+
+// invoke makes a unary call to the plugin
+func (d *Driver) invoke(ctx context.Context, method string, req, reply any) error {
+	if d.conn == nil {
+		return d.error("not connected", nil)
+	}
+	if err := d.conn.Invoke(ctx, method, req, reply); err != nil {
+		return d.status(err)
+	}
+	return nil
+}
+
+// status turns the status of a failed call back into the plugin's error
+// message
+func (d *Driver) status(err error) error {
+	if s, ok := status.FromError(err); ok {
+		return d.error(s.Message(), nil)
+	}
+	return d.error("plugin call failed", err)
+}
+
+func (d *Driver) error(msg string, err error) error {
+	return &database.DriverError{Type: d.dbType, Message: msg, Err: err}
+}
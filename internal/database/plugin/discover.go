@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/pluginrpc"
+)
+
+// Prefix names plugin executables: dbbackup-driver-<type>, plus .exe on
+// Windows, serves the database type <type>
+const Prefix = "dbbackup-driver-"
+
+// discovered holds the types registered by Discover, which may run again
+// when the configuration is reloaded
+var (
+	discoveredMu sync.Mutex
+	discovered   = map[database.DatabaseType]bool{}
+)
+
+// Find returns the plugin executables in dir by database type. A missing
+// directory holds no plugins.
+func Find(dir string) (map[database.DatabaseType]string, error) {
+	found, err := pluginrpc.Find(dir, Prefix)
+	if err != nil {
+		return nil, err
+	}
+	plugins := make(map[database.DatabaseType]string, len(found))
+	for name, path := range found {
+		plugins[database.DatabaseType(name)] = path
+	}
+	return plugins, nil
+}
+
+// Discover registers a driver for every plugin in dir and returns their
+// database types. Built-in drivers cannot be replaced by plugins.
+func Discover(dir string, handshakeTimeout time.Duration) ([]database.DatabaseType, error) {
+	plugins, err := Find(dir)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]database.DatabaseType, 0, len(plugins))
+	for dbType := range plugins {
+		types = append(types, dbType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	discoveredMu.Lock()
+	defer discoveredMu.Unlock()
+	for _, dbType := range types {
+		if database.IsRegistered(dbType) && !discovered[dbType] {
+			return nil, fmt.Errorf("plugin %s: %s has a built-in driver", plugins[dbType], dbType)
+		}
+	}
+	for _, dbType := range types {
+		path := plugins[dbType]
+		database.RegisterDriver(dbType, func() database.Driver {
+			return NewDriver(path, dbType, handshakeTimeout)
+		})
+		discovered[dbType] = true
+	}
+	return types, nil
+}
@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// TestMain serves fakeDriver when the test binary is started as a plugin
+func TestMain(m *testing.M) {
+	if os.Getenv(CookieKey) == CookieValue {
+		Serve(func() database.Driver { return &fakeDriver{} })
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeDriver dumps a fixed number of bytes of its database name and
+// restores by hashing what it receives
+type fakeDriver struct {
+	config *database.ConnectionConfig
+}
+
+func (d *fakeDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	if config.Password != "secret" {
+		return errors.New("authentication failed")
+	}
+	d.config = config
+	return nil
+}
+
+func (d *fakeDriver) Disconnect() error              { return nil }
+func (d *fakeDriver) Ping(ctx context.Context) error { return nil }
+
+func (d *fakeDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	return &database.BackupResult{ID: "bk-1", Status: database.BackupStatusFailed, Error: errors.New("disk full")}, nil
+}
+
+func (d *fakeDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, w io.Writer) error {
+	_, err := w.Write(bytes.Repeat([]byte(opts.Database), 1<<20))
+	return err
+}
+
+func (d *fakeDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	return int64(len(opts.Database)) << 20, nil
+}
+
+func (d *fakeDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	return nil, errors.New("not supported")
+}
+
+// StreamRestore fails unless it receives what StreamBackup dumps
+func (d *fakeDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	want := sha256.Sum256(bytes.Repeat([]byte(opts.Database), 1<<20))
+	if got := hex.EncodeToString(h.Sum(nil)); got != hex.EncodeToString(want[:]) {
+		return fmt.Errorf("restored %s, want %x", got, want)
+	}
+	return nil
+}
+
+func (d *fakeDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	if !opts.DropExisting {
+		return errors.New("database is not empty")
+	}
+	return nil
+}
+
+func (d *fakeDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	return []string{d.config.Database}, nil
+}
+
+func (d *fakeDriver) GetTables(ctx context.Context, db string) ([]string, error) {
+	return []string{db + ".orders"}, nil
+}
+
+func (d *fakeDriver) GetTableSize(ctx context.Context, db, table string) (int64, error) {
+	return 42, nil
+}
+
+func (d *fakeDriver) GetVersion(ctx context.Context) (string, error) { return "acme 1.0", nil }
+func (d *fakeDriver) GetType() database.DatabaseType                 { return "acme" }
+func (d *fakeDriver) SupportsIncremental() bool                      { return true }
+func (d *fakeDriver) SupportsPITR() bool                             { return false }
+
+// installPlugin links the test binary into a plugins directory as the
+// driver of dbType
+func installPlugin(t *testing.T, dbType string) string {
+	t.Helper()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(self, filepath.Join(dir, Prefix+dbType)); err != nil {
+		t.Skipf("cannot link the test binary: %v", err)
+	}
+	return dir
+}
+
+func TestPlugin(t *testing.T) {
+	dir := installPlugin(t, "acme")
+	types, err := Discover(dir, 10*time.Second)
+	if err != nil || len(types) != 1 || types[0] != "acme" {
+		t.Fatalf("Discover() = %v, %v", types, err)
+	}
+	driver, err := database.CreateDriver("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := driver.Connect(ctx, &database.ConnectionConfig{Database: "db", Password: "wrong"}); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Fatalf("Connect() with the wrong password = %v", err)
+	}
+	if err := driver.Connect(ctx, &database.ConnectionConfig{Database: "db", Password: "secret"}); err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+	defer driver.Disconnect()
+	if !driver.SupportsIncremental() || driver.SupportsPITR() || driver.GetType() != "acme" {
+		t.Errorf("capabilities = %v, %v, %s", driver.SupportsIncremental(), driver.SupportsPITR(), driver.GetType())
+	}
+
+	version, err := driver.GetVersion(ctx)
+	if err != nil || version != "acme 1.0" {
+		t.Errorf("GetVersion() = %q, %v", version, err)
+	}
+	tables, err := driver.GetTables(ctx, "db")
+	if err != nil || len(tables) != 1 || tables[0] != "db.orders" {
+		t.Errorf("GetTables() = %v, %v", tables, err)
+	}
+	result, err := driver.Backup(ctx, &database.BackupOptions{})
+	if err != nil || result.ID != "bk-1" || result.Error == nil || result.Error.Error() != "disk full" {
+		t.Errorf("Backup() = %+v, %v", result, err)
+	}
+
+	// Dumps span several chunks in both directions
+	var dump bytes.Buffer
+	if err := driver.StreamBackup(ctx, &database.BackupOptions{Database: "abc"}, &dump); err != nil || dump.Len() != 3<<20 {
+		t.Fatalf("StreamBackup() = %d bytes, %v", dump.Len(), err)
+	}
+	if err := driver.ValidateRestore(ctx, &database.RestoreOptions{}); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("ValidateRestore() = %v", err)
+	}
+	restore := &database.RestoreOptions{Database: "abc", DropExisting: true}
+	if err := driver.StreamRestore(ctx, restore, bytes.NewReader(dump.Bytes())); err != nil {
+		t.Errorf("StreamRestore() = %v", err)
+	}
+	if err := driver.StreamRestore(ctx, restore, strings.NewReader("truncated")); err == nil || !strings.Contains(err.Error(), "want") {
+		t.Errorf("StreamRestore() of another dump = %v", err)
+	}
+
+	if err := driver.Disconnect(); err != nil {
+		t.Errorf("Disconnect() = %v", err)
+	}
+	if _, err := driver.GetVersion(ctx); err == nil {
+		t.Error("GetVersion() after Disconnect succeeded")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := installPlugin(t, "postgres_fork")
+	os.WriteFile(filepath.Join(dir, Prefix+"notes"), []byte("not executable"), 0o644)
+	os.WriteFile(filepath.Join(dir, Prefix+"Bad-Name"), nil, 0o755)
+	os.Mkdir(filepath.Join(dir, Prefix+"dir"), 0o755)
+
+	plugins, err := Find(dir)
+	if err != nil || len(plugins) != 1 || plugins["postgres_fork"] == "" {
+		t.Fatalf("Find() = %v, %v", plugins, err)
+	}
+	if plugins, err := Find(filepath.Join(dir, "missing")); err != nil || len(plugins) != 0 {
+		t.Errorf("Find() of a missing directory = %v, %v", plugins, err)
+	}
+
+	// Built-in drivers win over plugins
+	database.RegisterDriver("builtin", func() database.Driver { return &fakeDriver{} })
+	dir = installPlugin(t, "builtin")
+	if _, err := Discover(dir, time.Second); err == nil || !strings.Contains(err.Error(), "built-in driver") {
+		t.Errorf("Discover() of a built-in type = %v", err)
+	}
+}
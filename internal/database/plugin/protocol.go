@@ -0,0 +1,150 @@
+// Package plugin runs database drivers shipped as separate executables.
+// A plugin is started for every driver instance and serves it over gRPC on
+// a unix socket, so drivers for proprietary databases can be built and
+// released outside this repository and crash without taking the host
+// down. The transport is the one of every db-backup plugin, see
+// internal/pluginrpc; a plugin must be built with Serve from
+// pkg/driverplugin.
+package plugin
+
+import (
+	"google.golang.org/grpc"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/pluginrpc"
+)
+
+// Handshake values. A plugin started without the cookie refuses to run,
+// and the host refuses plugins speaking another protocol version.
+const (
+	CookieKey       = pluginrpc.CookieKey
+	CookieValue     = "9d5b1d3c0e7f4a8b6c2e1f0a3b4c5d6e"
+	ProtocolVersion = 1
+)
+
+// protocol is the plugin protocol of drivers
+var protocol = pluginrpc.Protocol{Kind: "driver", Cookie: CookieValue, Version: ProtocolVersion}
+
+// serviceName is the gRPC service plugins serve
+const serviceName = "dbbackup.driver.v1.Driver"
+
+// Full method names
+const (
+	methodConnect         = "/" + serviceName + "/Connect"
+	methodDisconnect      = "/" + serviceName + "/Disconnect"
+	methodPing            = "/" + serviceName + "/Ping"
+	methodBackup          = "/" + serviceName + "/Backup"
+	methodGetBackupSize   = "/" + serviceName + "/GetBackupSize"
+	methodRestore         = "/" + serviceName + "/Restore"
+	methodValidateRestore = "/" + serviceName + "/ValidateRestore"
+	methodGetDatabases    = "/" + serviceName + "/GetDatabases"
+	methodGetTables       = "/" + serviceName + "/GetTables"
+	methodGetTableSize    = "/" + serviceName + "/GetTableSize"
+	methodGetVersion      = "/" + serviceName + "/GetVersion"
+	methodStreamBackup    = "/" + serviceName + "/StreamBackup"
+	methodStreamRestore   = "/" + serviceName + "/StreamRestore"
+)
+
+// Info describes the driver a plugin serves, returned by Connect
+type Info struct {
+	Type        database.DatabaseType
+	Incremental bool
+	PITR        bool
+}
+
+// done answers calls that return nothing
+type done = pluginrpc.Done
+
+// Request and reply messages of calls whose arguments or results are not
+// driver types
+type (
+	backupReply struct {
+		Result *database.BackupResult
+		Error  string
+	}
+	restoreReply struct {
+		Result *database.RestoreResult
+		Error  string
+	}
+	tablesRequest struct {
+		Database string
+	}
+	tableSizeRequest struct {
+		Database string
+		Table    string
+	}
+	sizeReply struct {
+		Size int64
+	}
+	namesReply struct {
+		Names []string
+	}
+	versionReply struct {
+		Version string
+	}
+)
+
+// Chunk carries dump data. The first chunk of a StreamRestore carries the
+// restore options.
+type Chunk struct {
+	Options *database.RestoreOptions
+	Data    []byte
+}
+
+// serviceDesc describes the driver service: one unary call per method of
+// database.Driver, StreamBackup streaming a dump to the host and
+// StreamRestore streaming one to the plugin
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		pluginrpc.Unary("Connect", (*server).connect),
+		pluginrpc.Unary("Disconnect", (*server).disconnect),
+		pluginrpc.Unary("Ping", (*server).ping),
+		pluginrpc.Unary("Backup", (*server).backup),
+		pluginrpc.Unary("GetBackupSize", (*server).getBackupSize),
+		pluginrpc.Unary("Restore", (*server).restore),
+		pluginrpc.Unary("ValidateRestore", (*server).validateRestore),
+		pluginrpc.Unary("GetDatabases", (*server).getDatabases),
+		pluginrpc.Unary("GetTables", (*server).getTables),
+		pluginrpc.Unary("GetTableSize", (*server).getTableSize),
+		pluginrpc.Unary("GetVersion", (*server).getVersion),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamBackup",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				var opts database.BackupOptions
+				if err := stream.RecvMsg(&opts); err != nil {
+					return err
+				}
+				return srv.(*server).streamBackup(&opts, stream)
+			},
+		},
+		{
+			StreamName:    "StreamRestore",
+			ClientStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(*server).streamRestore(stream)
+			},
+		},
+	},
+}
+
+// sendChunks returns a writer sending what is written to it as Chunks
+func sendChunks(send func(any) error) *pluginrpc.ChunkWriter {
+	return pluginrpc.NewChunkWriter(func(data []byte) error {
+		return send(&Chunk{Data: data})
+	})
+}
+
+// recvChunks returns a reader of first and then of the data of the Chunks
+// received until the stream ends
+func recvChunks(first []byte, recv func(any) error) *pluginrpc.ChunkReader {
+	return pluginrpc.NewChunkReader(first, func() ([]byte, error) {
+		var c Chunk
+		err := recv(&c)
+		return c.Data, err
+	})
+}
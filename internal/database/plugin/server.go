@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// server serves one driver instance to the host
+type server struct {
+	driver database.Driver
+}
+
+// Serve runs the plugin side of the protocol for the driver newDriver
+// creates, until the host closes stdin or sends SIGTERM. It exits the
+// process when not started by a host.
+func Serve(newDriver func() database.Driver) {
+	driver := newDriver()
+	err := protocol.Serve(&serviceDesc, &server{driver: driver})
+	driver.Disconnect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plugin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func (s *server) connect(ctx context.Context, cfg *database.ConnectionConfig) (*Info, error) {
+	if err := s.driver.Connect(ctx, cfg); err != nil {
+		return nil, err
+	}
+	return &Info{
+		Type:        s.driver.GetType(),
+		Incremental: s.driver.SupportsIncremental(),
+		PITR:        s.driver.SupportsPITR(),
+	}, nil
+}
+
+func (s *server) disconnect(ctx context.Context, _ *done) (*done, error) {
+	return &done{OK: true}, s.driver.Disconnect()
+}
+
+func (s *server) ping(ctx context.Context, _ *done) (*done, error) {
+	return &done{OK: true}, s.driver.Ping(ctx)
+}
+
+func (s *server) backup(ctx context.Context, opts *database.BackupOptions) (*backupReply, error) {
+	result, err := s.driver.Backup(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	reply := &backupReply{Result: result}
+	// Errors are interfaces, which gob only encodes for registered types
+	if result != nil && result.Error != nil {
+		r := *result
+		reply.Result, reply.Error, r.Error = &r, r.Error.Error(), nil
+	}
+	return reply, nil
+}
+
+func (s *server) getBackupSize(ctx context.Context, opts *database.BackupOptions) (*sizeReply, error) {
+	size, err := s.driver.GetBackupSize(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sizeReply{Size: size}, nil
+}
+
+func (s *server) restore(ctx context.Context, opts *database.RestoreOptions) (*restoreReply, error) {
+	result, err := s.driver.Restore(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	reply := &restoreReply{Result: result}
+	if result != nil && result.Error != nil {
+		r := *result
+		reply.Result, reply.Error, r.Error = &r, r.Error.Error(), nil
+	}
+	return reply, nil
+}
+
+func (s *server) validateRestore(ctx context.Context, opts *database.RestoreOptions) (*done, error) {
+	return &done{OK: true}, s.driver.ValidateRestore(ctx, opts)
+}
+
+func (s *server) getDatabases(ctx context.Context, _ *done) (*namesReply, error) {
+	names, err := s.driver.GetDatabases(ctx)
+	return &namesReply{Names: names}, err
+}
+
+func (s *server) getTables(ctx context.Context, req *tablesRequest) (*namesReply, error) {
+	names, err := s.driver.GetTables(ctx, req.Database)
+	return &namesReply{Names: names}, err
+}
+
+func (s *server) getTableSize(ctx context.Context, req *tableSizeRequest) (*sizeReply, error) {
+	size, err := s.driver.GetTableSize(ctx, req.Database, req.Table)
+	return &sizeReply{Size: size}, err
+}
+
+func (s *server) getVersion(ctx context.Context, _ *done) (*versionReply, error) {
+	version, err := s.driver.GetVersion(ctx)
+	return &versionReply{Version: version}, err
+}
+
+// streamBackup sends the dump as chunks
+func (s *server) streamBackup(opts *database.BackupOptions, stream grpc.ServerStream) error {
+	w := sendChunks(stream.SendMsg)
+	if err := s.driver.StreamBackup(stream.Context(), opts, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// streamRestore feeds the chunks received to the driver
+func (s *server) streamRestore(stream grpc.ServerStream) error {
+	var first Chunk
+	if err := stream.RecvMsg(&first); err != nil {
+		return err
+	}
+	if first.Options == nil {
+		return fmt.Errorf("stream restore sent no options")
+	}
+	r := recvChunks(first.Data, stream.RecvMsg)
+	if err := s.driver.StreamRestore(stream.Context(), first.Options, r); err != nil {
+		return err
+	}
+	return stream.SendMsg(&done{OK: true})
+}
@@ -0,0 +1,451 @@
+package postgres
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Backup methods of the backup_method connection option
+const (
+	MethodLogical  = "logical"
+	MethodPhysical = "physical"
+)
+
+// WAL methods of the wal_method connection option: how pg_basebackup
+// includes the WAL written during the copy
+const (
+	WALStream = "stream" // over a second connection, the default
+	WALFetch  = "fetch"  // at the end, if the server still has it
+	WALNone   = "none"   // not at all, for servers archiving their WAL
+)
+
+// basebackupInfoFile is the first entry of physical backup archives
+const basebackupInfoFile = "basebackup.json"
+
+// basebackupFormat identifies the layout of physical backup archives
+const basebackupFormat = "pg_basebackup/v1"
+
+// basebackupInfo describes a physical backup archive. The archive holds
+// the tar files pg_basebackup wrote: base.tar, pg_wal.tar, one <oid>.tar
+// per tablespace and backup_manifest.
+type basebackupInfo struct {
+	Format        string    `json:"format"`
+	WALMethod     string    `json:"wal_method"`
+	StartLSN      string    `json:"start_lsn,omitempty"`
+	ServerVersion string    `json:"server_version,omitempty"`
+	Files         []string  `json:"files"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// physical reports whether opts are backed up with pg_basebackup
+func (d *PostgreSQLDriver) physical(opts *database.BackupOptions) (bool, error) {
+	if !opts.Physical && d.config.Options["backup_method"] != MethodPhysical {
+		return false, nil
+	}
+	if len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0 {
+		return false, errors.New("physical backups are of the whole cluster, tables cannot be chosen")
+	}
+	return true, nil
+}
+
+// walMethod returns the wal_method connection option
+func (d *PostgreSQLDriver) walMethod() string {
+	if m := d.config.Options["wal_method"]; m != "" {
+		return m
+	}
+	return WALStream
+}
+
+// physicalBackup archives a pg_basebackup of the cluster to opts.OutputPath
+func (d *PostgreSQLDriver) physicalBackup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err).WithMetadata("output_path", opts.OutputPath)
+	}
+
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	defer outputFile.Close()
+	output := stream.NewHashWriter(outputFile)
+	info, err := d.runBasebackup(ctx, filepath.Dir(opts.OutputPath), output)
+	if err != nil {
+		return fail(err)
+	}
+	fileInfo, err := outputFile.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	result.DatabaseVersion = info.ServerVersion
+	result.Tables, _ = d.getTableInfo(ctx, opts.Database)
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Size = fileInfo.Size()
+	result.Checksum = output.Sum()
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{
+		"backup_method":         MethodPhysical,
+		"basebackup_wal_method": info.WALMethod,
+	})
+	if info.StartLSN != "" {
+		result.Metadata = database.WithMetadata(result.Metadata, map[string]string{MetadataWALPosition: info.StartLSN})
+	}
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// runBasebackup runs pg_basebackup in tar format into a working directory
+// under dir, then archives its files to w
+func (d *PostgreSQLDriver) runBasebackup(ctx context.Context, dir string, w io.Writer) (*basebackupInfo, error) {
+	walMethod := d.walMethod()
+	work, err := os.MkdirTemp(dir, "pg_basebackup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	info := &basebackupInfo{Format: basebackupFormat, WALMethod: walMethod, CreatedAt: time.Now().UTC()}
+	info.StartLSN, _ = d.walPosition(ctx)
+	info.ServerVersion, _ = d.GetVersion(ctx)
+
+	cmd := exec.CommandContext(ctx, "pg_basebackup", d.basebackupArgs(work, walMethod)...)
+	cmd.Env = d.commandEnv()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	run := telemetry.StartCommand(ctx, cmd)
+	err = cmd.Run()
+	run.End(err, -1)
+	if err != nil {
+		return nil, fmt.Errorf("pg_basebackup failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err := packBasebackup(ctx, work, info, w); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// basebackupArgs builds pg_basebackup command arguments
+func (d *PostgreSQLDriver) basebackupArgs(target, walMethod string) []string {
+	return []string{
+		"-h", d.host(),
+		"-p", d.port(),
+		"-U", d.config.Username,
+		"-w", // the password comes from PGPASSWORD, never a prompt
+		"-D", target,
+		"-F", "t",
+		"-X", walMethod,
+		"--checkpoint=fast",
+		"--label=db-backup",
+	}
+}
+
+// packBasebackup archives the files pg_basebackup wrote to dir, after the
+// description of the backup
+func packBasebackup(ctx context.Context, dir string, info *basebackupInfo, w io.Writer) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	info.Files = nil
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			info.Files = append(info.Files, e.Name())
+		}
+	}
+	// The data directory first, so extraction creates it before the rest
+	sort.Slice(info.Files, func(i, j int) bool {
+		if info.Files[i] == "base.tar" || info.Files[j] == "base.tar" {
+			return info.Files[i] == "base.tar"
+		}
+		return info.Files[i] < info.Files[j]
+	})
+	if len(info.Files) == 0 || info.Files[0] != "base.tar" {
+		return errors.New("pg_basebackup wrote no base.tar")
+	}
+
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: basebackupInfoFile, Mode: 0o600, Size: int64(len(data)), ModTime: info.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for _, name := range info.Files {
+		if err := addFile(ctx, tw, filepath.Join(dir, name), name); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// addFile copies the file at path into tw as name
+func addFile(ctx context.Context, tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = stream.Copy(ctx, tw, f)
+	return err
+}
+
+// isBasebackup reports whether r starts with a physical backup archive,
+// without consuming it
+func isBasebackup(r *bufio.Reader) (bool, error) {
+	name, err := firstEntry(r)
+	return name == basebackupInfoFile, err
+}
+
+// firstEntry returns the name of the first entry of the tar archive r
+// starts with, without consuming it, or "" when r holds something else
+func firstEntry(r *bufio.Reader) (string, error) {
+	block, err := r.Peek(512)
+	if errors.Is(err, io.EOF) || errors.Is(err, bufio.ErrBufferFull) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if string(block[257:262]) != "ustar" {
+		return "", nil
+	}
+	name, _, _ := bytes.Cut(block[:100], []byte{0})
+	return string(name), nil
+}
+
+// isBasebackupFile reports whether the file at path is a physical backup
+func isBasebackupFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isBasebackup(bufio.NewReader(f))
+}
+
+// checkPhysicalRestore checks opts can be honored by extracting a physical
+// backup
+func checkPhysicalRestore(opts *database.RestoreOptions) error {
+	switch {
+	case opts.Metadata["restore_dir"] == "":
+		return errors.New("physical backups are extracted into a data directory: set the restore_dir metadata")
+	case len(opts.Tables) > 0 || len(opts.ExcludeTables) > 0:
+		return errors.New("physical backups restore the whole cluster, tables cannot be chosen")
+	case opts.Renamed():
+		return fmt.Errorf("physical backups restore the whole cluster: %w", database.ErrRenameUnsupported)
+	case opts.PointInTime != nil && opts.Metadata["wal_dir"] == "":
+		return errors.New("point-in-time restores of physical backups replay the WAL archive: set the wal_dir metadata")
+	}
+	return nil
+}
+
+// restorePhysical extracts a physical backup read from r into the
+// restore_dir metadata, which must be empty. The running server is never
+// touched: start a server on the directory, which recovers to the end of
+// the backup from the WAL it holds, or with wal_method none from the WAL
+// archive its restore_command reads. Tablespaces are extracted next to it,
+// into <restore_dir>_tablespaces/<oid>, and tablespace_map points there.
+func restorePhysical(ctx context.Context, opts *database.RestoreOptions, r io.Reader) error {
+	if err := checkPhysicalRestore(opts); err != nil {
+		return err
+	}
+	target := filepath.Clean(opts.Metadata["restore_dir"])
+	if err := os.MkdirAll(target, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(target)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("restore_dir %s is not empty", target)
+	}
+	// The server refuses data directories others can read
+	if err := os.Chmod(target, 0o700); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil || header.Name != basebackupInfoFile {
+		return errors.New("not a physical backup archive")
+	}
+	var info basebackupInfo
+	if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&info); err != nil || info.Format != basebackupFormat {
+		return errors.New("not a physical backup archive")
+	}
+	tablespaces := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := header.Name
+		switch {
+		case name == "base.tar":
+			err = extractTar(ctx, tr, target)
+		case name == "pg_wal.tar":
+			err = extractTar(ctx, tr, filepath.Join(target, "pg_wal"))
+		case name == "backup_manifest":
+			err = writeFile(ctx, filepath.Join(target, name), tr)
+		case strings.HasSuffix(name, ".tar") && isOID(strings.TrimSuffix(name, ".tar")):
+			oid := strings.TrimSuffix(name, ".tar")
+			dir := filepath.Join(target+"_tablespaces", oid)
+			tablespaces[oid] = dir
+			err = extractTar(ctx, tr, dir)
+		default:
+			err = fmt.Errorf("unexpected file %s in the archive; compressed pg_basebackup output is not supported", name)
+		}
+		if err != nil {
+			return fmt.Errorf("extracting %s: %w", name, err)
+		}
+	}
+	if len(tablespaces) > 0 {
+		return remapTablespaces(filepath.Join(target, "tablespace_map"), tablespaces)
+	}
+	return nil
+}
+
+// extractTar extracts the tar archive read from r into dir, refusing
+// entries that would land outside it
+func extractTar(ctx context.Context, r io.Reader, dir string) error {
+	return extractEntries(ctx, tar.NewReader(r), dir)
+}
+
+// extractEntries extracts the remaining entries of tr into dir
+func extractEntries(ctx context.Context, tr *tar.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(header.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("entry %s is outside the archive", header.Name)
+		}
+		path := filepath.Join(dir, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0o700)
+		case tar.TypeReg:
+			err = writeFile(ctx, path, tr)
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, path)
+		default:
+			// pg_basebackup and pg_dump write nothing else
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeFile writes what r holds to a new file at path, readable by this
+// user only
+func writeFile(ctx context.Context, path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Copy(ctx, f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// remapTablespaces points the tablespaces of a tablespace_map file at the
+// directories they were extracted to
+func remapTablespaces(path string, dirs map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("the backup has tablespaces but no tablespace_map: %w", err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	for i, line := range lines {
+		oid, _, _ := strings.Cut(line, " ")
+		if dir, ok := dirs[oid]; ok {
+			lines[i] = oid + " " + dir
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+}
+
+// isOID reports whether s is a tablespace OID
+func isOID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// restorePhysicalFile extracts the physical backup opts.SourceBackup
+func (d *PostgreSQLDriver) restorePhysicalFile(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return restorePhysical(ctx, opts, f)
+}
@@ -0,0 +1,135 @@
+package postgres
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// tarOf builds a tar archive of files, directories ending in /
+func tarOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		h := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(name, "/") {
+			h.Typeflag, h.Mode, h.Size = tar.TypeDir, 0o700, 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// basebackupDir writes what pg_basebackup -F t leaves in its target
+func basebackupDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"base.tar": tarOf(t, map[string]string{
+			"PG_VERSION":        "16\n",
+			"global/":           "",
+			"global/pg_control": "control",
+			"pg_wal/":           "",
+			"tablespace_map":    "16400 /srv/pg/fast\n",
+		}),
+		"pg_wal.tar":      tarOf(t, map[string]string{"000000010000000000000002": "wal"}),
+		"16400.tar":       tarOf(t, map[string]string{"PG_16_202307071/5/16401": "rows"}),
+		"backup_manifest": []byte(`{"PostgreSQL-Backup-Manifest-Version": 1}`),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBasebackupRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	var archive bytes.Buffer
+	info := &basebackupInfo{Format: basebackupFormat, WALMethod: WALStream, StartLSN: "0/2000028", CreatedAt: time.Now()}
+	if err := packBasebackup(ctx, basebackupDir(t), info, &archive); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(info.Files, ",") != "base.tar,16400.tar,backup_manifest,pg_wal.tar" {
+		t.Errorf("files = %v, want base.tar first", info.Files)
+	}
+
+	physical, err := isBasebackup(bufio.NewReader(bytes.NewReader(archive.Bytes())))
+	if err != nil || !physical {
+		t.Fatalf("isBasebackup = %v, %v", physical, err)
+	}
+	for _, other := range [][]byte{[]byte("PGDMP\x01\x0e"), tarOf(t, map[string]string{"toc.dat": "x"})} {
+		if physical, _ := isBasebackup(bufio.NewReader(bytes.NewReader(other))); physical {
+			t.Errorf("isBasebackup(%.8q) = true", other)
+		}
+	}
+
+	target := filepath.Join(t.TempDir(), "data")
+	opts := &database.RestoreOptions{Metadata: map[string]string{"restore_dir": target}}
+	if err := restorePhysical(ctx, opts, bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"data/PG_VERSION":                                "16\n",
+		"data/global/pg_control":                         "control",
+		"data/pg_wal/000000010000000000000002":           "wal",
+		"data/backup_manifest":                           `{"PostgreSQL-Backup-Manifest-Version": 1}`,
+		"data_tablespaces/16400/PG_16_202307071/5/16401": "rows",
+		"data/tablespace_map":                            "16400 " + target + "_tablespaces/16400\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(filepath.Dir(target), path))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", path, got, err, want)
+		}
+	}
+	if fi, err := os.Stat(target); err != nil || fi.Mode().Perm() != 0o700 {
+		t.Errorf("data directory mode = %v, %v; want 0700", fi.Mode(), err)
+	}
+
+	// The directory now holds a cluster
+	if err := restorePhysical(ctx, opts, bytes.NewReader(archive.Bytes())); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("restore into a used directory: err = %v", err)
+	}
+}
+
+func TestCheckPhysicalRestore(t *testing.T) {
+	at := time.Now()
+	for _, opts := range []*database.RestoreOptions{
+		{},
+		{Metadata: map[string]string{"restore_dir": "/tmp/x"}, Tables: []string{"orders"}},
+		{Metadata: map[string]string{"restore_dir": "/tmp/x"}, PointInTime: &at},
+	} {
+		if err := checkPhysicalRestore(opts); err == nil {
+			t.Errorf("checkPhysicalRestore(%+v) accepted", opts)
+		}
+	}
+}
+
+func TestExtractTarRefusesEscapes(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"../evil", "/etc/evil", "a/../../evil"} {
+		err := extractTar(context.Background(), bytes.NewReader(tarOf(t, map[string]string{name: "x"})), filepath.Join(dir, "data"))
+		if err == nil {
+			t.Errorf("extracted %s", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+		t.Error("wrote outside the directory")
+	}
+}
@@ -0,0 +1,770 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+)
+
+// Change capture settings, in database.CaptureOptions.Options
+const (
+	// OptionPlugin is the output plugin decoding the slot: wal2json
+	// (the default) or pgoutput
+	OptionPlugin = "plugin"
+	// OptionPublication is the publication pgoutput streams the tables of
+	OptionPublication = "publication"
+)
+
+// Output plugins
+const (
+	PluginWal2JSON = "wal2json"
+	PluginPgoutput = "pgoutput"
+)
+
+// MetadataWALPosition is the backup metadata key of the WAL position
+// recorded before the dump started: replaying the changes committed after
+// it brings a restored dump forward
+const MetadataWALPosition = "wal_lsn"
+
+// captureBatch is the most changes read from the slot per query; the
+// server finishes the transaction it stops in
+const captureBatch = 10000
+
+// slotPattern matches the replication slot names PostgreSQL accepts
+var slotPattern = regexp.MustCompile(`^[a-z0-9_]{1,63}$`)
+
+// CaptureChanges streams the transactions decoded from a logical
+// replication slot into a change stream, creating the slot first. The slot
+// is advanced past each batch only once it is synced to disk, so a capture
+// that dies loses nothing; the login needs the REPLICATION attribute and
+// the server wal_level=logical.
+func (d *PostgreSQLDriver) CaptureChanges(ctx context.Context, opts *database.CaptureOptions) error {
+	if d.db == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	if !slotPattern.MatchString(opts.Name) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("invalid replication slot name %q: use lower case letters, digits and underscores", opts.Name))
+	}
+	plugin := opts.Options[OptionPlugin]
+	if plugin == "" {
+		plugin = PluginWal2JSON
+	}
+	publication := opts.Options[OptionPublication]
+	switch plugin {
+	case PluginWal2JSON:
+	case PluginPgoutput:
+		if publication == "" {
+			return pkgErrors.ErrValidationFailed("pgoutput needs a publication, e.g. CREATE PUBLICATION db_backup FOR ALL TABLES")
+		}
+	default:
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("unsupported output plugin %q: use %s or %s", plugin, PluginWal2JSON, PluginPgoutput))
+	}
+
+	interval, size, poll := opts.SegmentInterval, opts.SegmentBytes, opts.PollInterval
+	if interval <= 0 {
+		interval = database.DefaultSegmentInterval
+	}
+	if size <= 0 {
+		size = database.DefaultSegmentBytes
+	}
+	if poll <= 0 {
+		poll = database.DefaultPollInterval
+	}
+
+	options := map[string]string{OptionPlugin: plugin}
+	if publication != "" {
+		options[OptionPublication] = publication
+	}
+	w, err := database.OpenChangeWriter(opts.OutputDir, database.ChangeManifest{
+		DatabaseType: database.DatabaseTypePostgreSQL,
+		Database:     d.config.Database,
+		Name:         opts.Name,
+		Options:      options,
+	})
+	if err != nil {
+		return pkgErrors.ErrDatabaseBackup(err).WithMetadata("output_dir", opts.OutputDir)
+	}
+	defer w.Close()
+
+	if err := d.ensureSlot(ctx, opts.Name, plugin, publication); err != nil {
+		return pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	for {
+		n, err := d.captureBatch(ctx, w, opts.Name, plugin, publication)
+		if ctx.Err() != nil {
+			// The partial segment is carried on by the next capture
+			return nil
+		}
+		if err != nil {
+			return pkgErrors.ErrDatabaseBackup(err).WithMetadata("slot", opts.Name)
+		}
+		if w.Due(interval, size) {
+			seg, err := w.Cut(ctx)
+			if err != nil {
+				return pkgErrors.ErrDatabaseBackup(err).WithMetadata("output_dir", opts.OutputDir)
+			}
+			if opts.OnSegment != nil {
+				if err := opts.OnSegment(*seg); err != nil {
+					return err
+				}
+			}
+		}
+		if n >= captureBatch {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(poll):
+		}
+	}
+}
+
+// ensureSlot creates the logical replication slot name unless it exists,
+// and checks an existing one decodes this database with plugin
+func (d *PostgreSQLDriver) ensureSlot(ctx context.Context, name, plugin, publication string) error {
+	if publication != "" {
+		var found bool
+		err := d.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)`, publication).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("publication %s does not exist: CREATE PUBLICATION %s FOR ALL TABLES", publication, publication)
+		}
+	}
+
+	var slotPlugin, slotDatabase string
+	err := d.db.QueryRowContext(ctx,
+		`SELECT plugin, database FROM pg_replication_slots WHERE slot_name = $1 AND slot_type = 'logical'`,
+		name).Scan(&slotPlugin, &slotDatabase)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = d.db.ExecContext(ctx, `SELECT pg_create_logical_replication_slot($1, $2)`, name, plugin)
+		if err != nil {
+			return fmt.Errorf("creating replication slot %s: %w", name, err)
+		}
+		return nil
+	case err != nil:
+		return err
+	case slotPlugin != plugin:
+		return fmt.Errorf("replication slot %s decodes with %s, not %s", name, slotPlugin, plugin)
+	case slotDatabase != d.config.Database:
+		return fmt.Errorf("replication slot %s belongs to database %s", name, slotDatabase)
+	}
+	return nil
+}
+
+// captureBatch appends the transactions waiting in the slot to w, syncs
+// them and advances the slot past them. It returns the changes read.
+func (d *PostgreSQLDriver) captureBatch(ctx context.Context, w *database.ChangeWriter, slot, plugin, publication string) (int, error) {
+	var rows *sql.Rows
+	var err error
+	if plugin == PluginPgoutput {
+		rows, err = d.db.QueryContext(ctx,
+			`SELECT lsn::text, data FROM pg_logical_slot_peek_binary_changes($1, NULL, $2, 'proto_version', '1', 'publication_names', $3)`,
+			slot, captureBatch, publication)
+	} else {
+		rows, err = d.db.QueryContext(ctx,
+			`SELECT lsn::text, data FROM pg_logical_slot_peek_changes($1, NULL, $2, 'format-version', '2', 'include-xids', '1', 'include-timestamp', '1', 'include-types', '1')`,
+			slot, captureBatch)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var dec decoder = &wal2jsonDecoder{}
+	if plugin == PluginPgoutput {
+		// Each query is a decoding session of its own, which describes
+		// the relations again before their first change
+		dec = &pgoutputDecoder{relations: map[uint32]*relation{}}
+	}
+	last, err := parseLSN(w.Last())
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	var advance string
+	for rows.Next() {
+		var lsn string
+		var data []byte
+		if err := rows.Scan(&lsn, &data); err != nil {
+			return n, err
+		}
+		n++
+		tx, err := dec.decode(lsn, data)
+		if err != nil {
+			return n, fmt.Errorf("decoding change at %s: %w", lsn, err)
+		}
+		if tx == nil {
+			continue
+		}
+		advance = tx.Position
+		pos, err := parseLSN(tx.Position)
+		if err != nil {
+			return n, err
+		}
+		// Skip what a capture that stopped before advancing the slot wrote,
+		// and transactions touching no published table
+		if pos <= last || len(tx.Changes) == 0 {
+			continue
+		}
+		if err := w.Append(tx); err != nil {
+			return n, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if advance == "" {
+		return n, nil
+	}
+	if err := w.Sync(); err != nil {
+		return n, err
+	}
+	_, err = d.db.ExecContext(ctx, `SELECT pg_replication_slot_advance($1, $2::pg_lsn)`, slot, advance)
+	return n, err
+}
+
+// ApplyChanges replays a change stream onto the connected database, one
+// transaction at a time. Inserts of rows that exist are skipped, so a
+// replay may start before the restored dump was taken.
+func (d *PostgreSQLDriver) ApplyChanges(ctx context.Context, opts *database.ApplyChangesOptions) (*database.ApplyChangesResult, error) {
+	if d.db == nil {
+		return nil, pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	m, err := database.ReadChangeManifest(opts.SourceDir)
+	if err != nil {
+		return nil, pkgErrors.ErrDatabaseRestore(err).WithMetadata("source_dir", opts.SourceDir)
+	}
+	if m.DatabaseType != database.DatabaseTypePostgreSQL {
+		return nil, pkgErrors.ErrValidationFailed(fmt.Sprintf("%s holds %s changes", opts.SourceDir, m.DatabaseType))
+	}
+	start, err := parseLSN(opts.StartAfter)
+	if err != nil {
+		return nil, pkgErrors.ErrValidationFailed(err.Error())
+	}
+
+	result := &database.ApplyChangesResult{}
+	errUntil := errors.New("past the target time")
+	_, err = database.ReadChanges(ctx, opts.SourceDir, func(tx *database.ChangeTx) error {
+		pos, err := parseLSN(tx.Position)
+		if err != nil {
+			return err
+		}
+		if pos <= start {
+			result.Skipped++
+			return nil
+		}
+		if opts.Until != nil && tx.Commit.After(*opts.Until) {
+			return errUntil
+		}
+		if err := d.applyTx(ctx, tx); err != nil {
+			return fmt.Errorf("transaction %s at %s: %w", tx.ID, tx.Position, err)
+		}
+		result.Transactions++
+		result.Changes += int64(len(tx.Changes))
+		result.LastPosition, result.LastCommit = tx.Position, tx.Commit
+		return nil
+	})
+	if err != nil && !errors.Is(err, errUntil) {
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("source_dir", opts.SourceDir)
+	}
+	return result, nil
+}
+
+// applyTx applies the changes of tx in one transaction
+func (d *PostgreSQLDriver) applyTx(ctx context.Context, tx *database.ChangeTx) error {
+	sqlTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+	for _, c := range tx.Changes {
+		query, args, err := changeStatement(c)
+		if err != nil {
+			return err
+		}
+		if query == "" {
+			continue
+		}
+		if _, err := sqlTx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("%s on %s: %w", c.Op, c.Table, err)
+		}
+	}
+	return sqlTx.Commit()
+}
+
+// DropCapture drops the replication slot name, if it exists
+func (d *PostgreSQLDriver) DropCapture(ctx context.Context, name string) error {
+	if d.db == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	_, err := d.db.ExecContext(ctx,
+		`SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots WHERE slot_name = $1`, name)
+	return err
+}
+
+// changeStatement returns the statement applying c
+func changeStatement(c database.Change) (string, []any, error) {
+	table := quoteIdent(c.Table)
+	if c.Schema != "" {
+		table = quoteIdent(c.Schema) + "." + table
+	}
+	var args []any
+	param := func(col database.ChangeColumn) string {
+		if col.Value == nil {
+			args = append(args, nil)
+		} else {
+			args = append(args, *col.Value)
+		}
+		return "$" + strconv.Itoa(len(args))
+	}
+	where := func() (string, error) {
+		if len(c.Identity) == 0 {
+			return "", fmt.Errorf("%s on %s has no row identity: set a primary key or REPLICA IDENTITY FULL", c.Op, c.Table)
+		}
+		conds := make([]string, len(c.Identity))
+		for i, col := range c.Identity {
+			if col.Value == nil {
+				conds[i] = quoteIdent(col.Name) + " IS NULL"
+			} else {
+				conds[i] = quoteIdent(col.Name) + " = " + param(col)
+			}
+		}
+		return strings.Join(conds, " AND "), nil
+	}
+
+	switch c.Op {
+	case database.ChangeInsert:
+		names := make([]string, len(c.Columns))
+		values := make([]string, len(c.Columns))
+		for i, col := range c.Columns {
+			names[i], values[i] = quoteIdent(col.Name), param(col)
+		}
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
+			table, strings.Join(names, ", "), strings.Join(values, ", ")), args, nil
+	case database.ChangeUpdate:
+		// Only unchanged TOAST values
+		if len(c.Columns) == 0 {
+			return "", nil, nil
+		}
+		sets := make([]string, len(c.Columns))
+		for i, col := range c.Columns {
+			sets[i] = quoteIdent(col.Name) + " = " + param(col)
+		}
+		cond, err := where()
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(sets, ", "), cond), args, nil
+	case database.ChangeDelete:
+		cond, err := where()
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("DELETE FROM %s WHERE %s", table, cond), args, nil
+	case database.ChangeTruncate:
+		return "TRUNCATE " + table, nil, nil
+	}
+	return "", nil, fmt.Errorf("unknown change %q on %s", c.Op, c.Table)
+}
+
+// walPosition returns the current WAL position, or the replayed one on a
+// standby
+func (d *PostgreSQLDriver) walPosition(ctx context.Context) (string, error) {
+	var lsn string
+	err := d.db.QueryRowContext(ctx,
+		`SELECT (CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END)::text`).Scan(&lsn)
+	return lsn, err
+}
+
+// quoteIdent quotes one identifier
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// parseLSN parses a WAL position such as 16/B374D848; "" is position 0
+func parseLSN(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	hi, lo, ok := strings.Cut(s, "/")
+	if ok {
+		h, err1 := strconv.ParseUint(hi, 16, 32)
+		l, err2 := strconv.ParseUint(lo, 16, 32)
+		if err1 == nil && err2 == nil {
+			return h<<32 | l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid WAL position %q", s)
+}
+
+// decoder assembles the rows read from a slot into transactions
+type decoder interface {
+	// decode consumes the row at lsn and returns the transaction it
+	// commits, if any
+	decode(lsn string, data []byte) (*database.ChangeTx, error)
+}
+
+// wal2jsonDecoder decodes wal2json format version 2, one row per begin,
+// change and commit
+type wal2jsonDecoder struct {
+	tx *database.ChangeTx
+}
+
+// wal2jsonRow is a row of wal2json format version 2
+type wal2jsonRow struct {
+	Action    string           `json:"action"`
+	XID       json.Number      `json:"xid"`
+	Timestamp string           `json:"timestamp"`
+	Schema    string           `json:"schema"`
+	Table     string           `json:"table"`
+	Columns   []wal2jsonColumn `json:"columns"`
+	Identity  []wal2jsonColumn `json:"identity"`
+}
+
+// wal2jsonColumn is a column value, typed as JSON
+type wal2jsonColumn struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (dec *wal2jsonDecoder) decode(lsn string, data []byte) (*database.ChangeTx, error) {
+	var row wal2jsonRow
+	if err := json.Unmarshal(data, &row); err != nil {
+		return nil, err
+	}
+	switch row.Action {
+	case "B":
+		commit, err := parseTimestamp(row.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		dec.tx = &database.ChangeTx{ID: row.XID.String(), Commit: commit}
+		return nil, nil
+	case "C":
+		tx := dec.tx
+		if tx == nil {
+			return nil, errors.New("commit without begin")
+		}
+		dec.tx = nil
+		tx.Position = lsn
+		if commit, err := parseTimestamp(row.Timestamp); err == nil {
+			tx.Commit = commit
+		}
+		return tx, nil
+	case "M":
+		// Logical decoding messages are not row changes
+		return nil, nil
+	}
+	if dec.tx == nil {
+		return nil, fmt.Errorf("%s change outside a transaction", row.Action)
+	}
+	ops := map[string]string{"I": database.ChangeInsert, "U": database.ChangeUpdate, "D": database.ChangeDelete, "T": database.ChangeTruncate}
+	op, ok := ops[row.Action]
+	if !ok {
+		return nil, fmt.Errorf("unknown action %q", row.Action)
+	}
+	c := database.Change{Op: op, Schema: row.Schema, Table: row.Table}
+	var err error
+	if c.Columns, err = wal2jsonColumns(row.Columns); err != nil {
+		return nil, err
+	}
+	if c.Identity, err = wal2jsonColumns(row.Identity); err != nil {
+		return nil, err
+	}
+	dec.tx.Changes = append(dec.tx.Changes, c)
+	return nil, nil
+}
+
+// wal2jsonColumns converts JSON typed values to their text form
+func wal2jsonColumns(cols []wal2jsonColumn) ([]database.ChangeColumn, error) {
+	var out []database.ChangeColumn
+	for _, col := range cols {
+		c := database.ChangeColumn{Name: col.Name, Type: col.Type}
+		switch {
+		case len(col.Value) == 0 || string(col.Value) == "null":
+		case col.Value[0] == '"':
+			var s string
+			if err := json.Unmarshal(col.Value, &s); err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			c.Value = &s
+		default:
+			s := string(col.Value)
+			c.Value = &s
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// parseTimestamp parses a timestamptz as PostgreSQL prints it
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
+
+// relation is a table described by a pgoutput Relation message
+type relation struct {
+	schema, name string
+	columns      []relationColumn
+}
+
+// relationColumn is a column of a relation
+type relationColumn struct {
+	name string
+	key  bool
+}
+
+// pgoutputDecoder decodes protocol version 1 of the pgoutput plugin
+type pgoutputDecoder struct {
+	relations map[uint32]*relation
+	tx        *database.ChangeTx
+}
+
+// pgEpoch is the origin of pgoutput timestamps
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (dec *pgoutputDecoder) decode(lsn string, data []byte) (*database.ChangeTx, error) {
+	m := &message{data: data}
+	kind := m.byte()
+	switch kind {
+	case 'B':
+		m.uint64() // final LSN
+		commit := pgEpoch.Add(time.Duration(m.uint64()) * time.Microsecond)
+		xid := m.uint32()
+		if m.err != nil {
+			return nil, m.err
+		}
+		dec.tx = &database.ChangeTx{ID: strconv.FormatUint(uint64(xid), 10), Commit: commit}
+		return nil, nil
+	case 'C':
+		tx := dec.tx
+		if tx == nil {
+			return nil, errors.New("commit without begin")
+		}
+		dec.tx = nil
+		tx.Position = lsn
+		return tx, nil
+	case 'R':
+		id := m.uint32()
+		rel := &relation{schema: m.string(), name: m.string()}
+		m.byte() // replica identity setting
+		n := int(m.uint16())
+		for i := 0; i < n && m.err == nil; i++ {
+			flags := m.byte()
+			col := relationColumn{name: m.string(), key: flags&1 != 0}
+			m.uint32() // type
+			m.uint32() // type modifier
+			rel.columns = append(rel.columns, col)
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+		dec.relations[id] = rel
+		return nil, nil
+	case 'Y', 'O', 'M':
+		// Types, origins and messages carry no row changes
+		return nil, nil
+	}
+
+	if dec.tx == nil {
+		return nil, fmt.Errorf("%q message outside a transaction", kind)
+	}
+	switch kind {
+	case 'I', 'U', 'D':
+		rel, err := dec.relation(m.uint32())
+		if err != nil {
+			return nil, err
+		}
+		c := database.Change{Schema: rel.schema, Table: rel.name}
+		var old []tupleColumn
+		oldKind := byte(0)
+		next := m.byte()
+		if next == 'K' || next == 'O' {
+			oldKind, old = next, m.tuple()
+			if kind == 'U' {
+				next = m.byte()
+			}
+		}
+		var row []tupleColumn
+		if kind != 'D' {
+			if next != 'N' {
+				return nil, fmt.Errorf("%q message without a new tuple", kind)
+			}
+			row = m.tuple()
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+
+		switch kind {
+		case 'I':
+			c.Op, c.Columns = database.ChangeInsert, rel.row(row, false)
+		case 'U':
+			c.Op, c.Columns = database.ChangeUpdate, rel.row(row, false)
+			c.Identity = rel.row(row, true)
+		case 'D':
+			c.Op = database.ChangeDelete
+		}
+		// The old tuple holds the key when it changed, or every column
+		// under REPLICA IDENTITY FULL
+		switch oldKind {
+		case 'K':
+			c.Identity = rel.row(old, true)
+		case 'O':
+			c.Identity = rel.row(old, false)
+		}
+		dec.tx.Changes = append(dec.tx.Changes, c)
+	case 'T':
+		n := int(m.uint32())
+		m.byte() // CASCADE / RESTART IDENTITY
+		for i := 0; i < n && m.err == nil; i++ {
+			rel, err := dec.relation(m.uint32())
+			if err != nil {
+				return nil, err
+			}
+			dec.tx.Changes = append(dec.tx.Changes, database.Change{Op: database.ChangeTruncate, Schema: rel.schema, Table: rel.name})
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+	default:
+		return nil, fmt.Errorf("unknown message %q", kind)
+	}
+	return nil, nil
+}
+
+// relation returns the relation id, which the server describes before its
+// first change in each session
+func (dec *pgoutputDecoder) relation(id uint32) (*relation, error) {
+	rel, ok := dec.relations[id]
+	if !ok {
+		return nil, fmt.Errorf("change to undescribed relation %d", id)
+	}
+	return rel, nil
+}
+
+// row names the columns of a tuple, leaving out unchanged TOAST values,
+// and only key columns when keys is set
+func (rel *relation) row(tuple []tupleColumn, keys bool) []database.ChangeColumn {
+	var out []database.ChangeColumn
+	for i, v := range tuple {
+		if i >= len(rel.columns) || v.unchanged || (keys && !rel.columns[i].key) {
+			continue
+		}
+		out = append(out, database.ChangeColumn{Name: rel.columns[i].name, Value: v.value})
+	}
+	return out
+}
+
+// tupleColumn is a column of a pgoutput tuple
+type tupleColumn struct {
+	value     *string
+	unchanged bool
+}
+
+// message reads the fields of a pgoutput message, remembering the first
+// error
+type message struct {
+	data []byte
+	err  error
+}
+
+func (m *message) take(n int) []byte {
+	if m.err != nil {
+		return nil
+	}
+	if len(m.data) < n {
+		m.err = errors.New("message cut short")
+		return nil
+	}
+	b := m.data[:n]
+	m.data = m.data[n:]
+	return b
+}
+
+func (m *message) byte() byte {
+	if b := m.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (m *message) uint16() uint16 {
+	if b := m.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (m *message) uint32() uint32 {
+	if b := m.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (m *message) uint64() uint64 {
+	if b := m.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// string reads a NUL-terminated string
+func (m *message) string() string {
+	if m.err != nil {
+		return ""
+	}
+	i := strings.IndexByte(string(m.data), 0)
+	if i < 0 {
+		m.err = errors.New("message cut short")
+		return ""
+	}
+	s := string(m.data[:i])
+	m.data = m.data[i+1:]
+	return s
+}
+
+// tuple reads TupleData: values in text form, NULL or unchanged TOAST
+func (m *message) tuple() []tupleColumn {
+	n := int(m.uint16())
+	cols := make([]tupleColumn, 0, n)
+	for i := 0; i < n && m.err == nil; i++ {
+		switch kind := m.byte(); kind {
+		case 'n':
+			cols = append(cols, tupleColumn{})
+		case 'u':
+			cols = append(cols, tupleColumn{unchanged: true})
+		case 't':
+			s := string(m.take(int(m.uint32())))
+			cols = append(cols, tupleColumn{value: &s})
+		default:
+			if m.err == nil {
+				m.err = fmt.Errorf("unknown tuple value kind %q", kind)
+			}
+		}
+	}
+	return cols
+}
@@ -0,0 +1,180 @@
+package postgres
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func text(s string) *string { return &s }
+
+func TestWal2JSONDecoder(t *testing.T) {
+	dec := &wal2jsonDecoder{}
+	rows := []struct{ lsn, data string }{
+		{"0/16B3748", `{"action":"B","xid":741,"timestamp":"2026-10-01 12:00:00.5+02"}`},
+		{"0/16B3748", `{"action":"I","schema":"public","table":"orders","columns":[{"name":"id","type":"integer","value":7},{"name":"note","type":"text","value":"a \"b\""},{"name":"paid","type":"boolean","value":null}]}`},
+		{"0/16B3800", `{"action":"U","schema":"public","table":"orders","columns":[{"name":"id","type":"integer","value":7},{"name":"paid","type":"boolean","value":true}],"identity":[{"name":"id","type":"integer","value":7}]}`},
+		{"0/16B3900", `{"action":"D","schema":"public","table":"items","identity":[{"name":"id","type":"bigint","value":3}]}`},
+		{"0/16B3A00", `{"action":"C","xid":741,"timestamp":"2026-10-01 12:00:00.5+02"}`},
+	}
+	var tx *database.ChangeTx
+	for i, r := range rows {
+		got, err := dec.decode(r.lsn, []byte(r.data))
+		if err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+		if (got != nil) != (i == len(rows)-1) {
+			t.Fatalf("row %d returned %v", i, got)
+		}
+		tx = got
+	}
+
+	want := &database.ChangeTx{
+		Position: "0/16B3A00",
+		ID:       "741",
+		Commit:   time.Date(2026, 10, 1, 10, 0, 0, 500e6, time.UTC),
+		Changes: []database.Change{
+			{Op: database.ChangeInsert, Schema: "public", Table: "orders", Columns: []database.ChangeColumn{
+				{Name: "id", Type: "integer", Value: text("7")},
+				{Name: "note", Type: "text", Value: text(`a "b"`)},
+				{Name: "paid", Type: "boolean"},
+			}},
+			{Op: database.ChangeUpdate, Schema: "public", Table: "orders",
+				Columns:  []database.ChangeColumn{{Name: "id", Type: "integer", Value: text("7")}, {Name: "paid", Type: "boolean", Value: text("true")}},
+				Identity: []database.ChangeColumn{{Name: "id", Type: "integer", Value: text("7")}}},
+			{Op: database.ChangeDelete, Schema: "public", Table: "items",
+				Identity: []database.ChangeColumn{{Name: "id", Type: "bigint", Value: text("3")}}},
+		},
+	}
+	if !reflect.DeepEqual(tx, want) {
+		t.Errorf("transaction =\n%+v\nwant\n%+v", tx, want)
+	}
+
+	if _, err := (&wal2jsonDecoder{}).decode("0/1", []byte(`{"action":"I","table":"t"}`)); err == nil {
+		t.Error("decoded a change outside a transaction")
+	}
+}
+
+// pgoutput builds protocol messages
+type pgoutput []byte
+
+func (p pgoutput) byte(b byte) pgoutput    { return append(p, b) }
+func (p pgoutput) u16(v uint16) pgoutput   { return binary.BigEndian.AppendUint16(p, v) }
+func (p pgoutput) u32(v uint32) pgoutput   { return binary.BigEndian.AppendUint32(p, v) }
+func (p pgoutput) u64(v uint64) pgoutput   { return binary.BigEndian.AppendUint64(p, v) }
+func (p pgoutput) str(s string) pgoutput   { return append(append(p, s...), 0) }
+func (p pgoutput) value(s string) pgoutput { return append(p.byte('t').u32(uint32(len(s))), s...) }
+func (p pgoutput) tuple(n uint16) pgoutput { return p.byte('N').u16(n) }
+func (p pgoutput) column(flags byte, name string) pgoutput {
+	return p.byte(flags).str(name).u32(23).u32(0xFFFFFFFF)
+}
+
+func TestPgoutputDecoder(t *testing.T) {
+	commit := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	micros := uint64(commit.Sub(pgEpoch) / time.Microsecond)
+
+	messages := []pgoutput{
+		pgoutput{}.byte('B').u64(0x16B3A00).u64(micros).u32(741),
+		pgoutput{}.byte('R').u32(16384).str("public").str("orders").byte('d').u16(3).
+			column(1, "id").column(0, "note").column(0, "doc"),
+		pgoutput{}.byte('I').u32(16384).tuple(3).value("7").byte('n').value("{}"),
+		// The document is TOASTed and unchanged
+		pgoutput{}.byte('U').u32(16384).tuple(3).value("7").value("paid").byte('u'),
+		// The key changed: the old one comes first
+		pgoutput{}.byte('U').u32(16384).byte('K').u16(3).value("7").byte('n').byte('n').tuple(3).value("8").value("paid").byte('u'),
+		pgoutput{}.byte('D').u32(16384).byte('K').u16(3).value("8").byte('n').byte('n'),
+		pgoutput{}.byte('T').u32(1).byte(0).u32(16384),
+		pgoutput{}.byte('C').byte(0).u64(0x16B3A00).u64(0x16B3A80).u64(micros),
+	}
+	dec := &pgoutputDecoder{relations: map[uint32]*relation{}}
+	var tx *database.ChangeTx
+	for i, m := range messages {
+		got, err := dec.decode("0/16B3A80", m)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		tx = got
+	}
+	if tx == nil {
+		t.Fatal("commit returned no transaction")
+	}
+
+	key := func(v string) []database.ChangeColumn { return []database.ChangeColumn{{Name: "id", Value: text(v)}} }
+	want := &database.ChangeTx{
+		Position: "0/16B3A80",
+		ID:       "741",
+		Commit:   commit,
+		Changes: []database.Change{
+			{Op: database.ChangeInsert, Schema: "public", Table: "orders", Columns: []database.ChangeColumn{
+				{Name: "id", Value: text("7")}, {Name: "note"}, {Name: "doc", Value: text("{}")},
+			}},
+			{Op: database.ChangeUpdate, Schema: "public", Table: "orders",
+				Columns: []database.ChangeColumn{{Name: "id", Value: text("7")}, {Name: "note", Value: text("paid")}}, Identity: key("7")},
+			{Op: database.ChangeUpdate, Schema: "public", Table: "orders",
+				Columns: []database.ChangeColumn{{Name: "id", Value: text("8")}, {Name: "note", Value: text("paid")}}, Identity: key("7")},
+			{Op: database.ChangeDelete, Schema: "public", Table: "orders", Identity: key("8")},
+			{Op: database.ChangeTruncate, Schema: "public", Table: "orders"},
+		},
+	}
+	if !reflect.DeepEqual(tx, want) {
+		t.Errorf("transaction =\n%+v\nwant\n%+v", tx, want)
+	}
+
+	dec = &pgoutputDecoder{relations: map[uint32]*relation{}}
+	dec.decode("0/1", messages[0])
+	if _, err := dec.decode("0/1", messages[2]); err == nil || !strings.Contains(err.Error(), "undescribed relation") {
+		t.Errorf("err = %v for a change to an unknown relation", err)
+	}
+	if _, err := dec.decode("0/1", messages[1][:9]); err == nil {
+		t.Error("decoded a truncated message")
+	}
+}
+
+func TestChangeStatement(t *testing.T) {
+	id, name := database.ChangeColumn{Name: "id", Value: text("7")}, database.ChangeColumn{Name: `we"ird`, Value: text("x")}
+	null := database.ChangeColumn{Name: "deleted_at"}
+	for _, tc := range []struct {
+		change database.Change
+		query  string
+		args   []any
+	}{
+		{database.Change{Op: database.ChangeInsert, Schema: "public", Table: "orders", Columns: []database.ChangeColumn{id, name, null}},
+			`INSERT INTO "public"."orders" ("id", "we""ird", "deleted_at") VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, []any{"7", "x", nil}},
+		{database.Change{Op: database.ChangeUpdate, Table: "orders", Columns: []database.ChangeColumn{name}, Identity: []database.ChangeColumn{id, null}},
+			`UPDATE "orders" SET "we""ird" = $1 WHERE "id" = $2 AND "deleted_at" IS NULL`, []any{"x", "7"}},
+		{database.Change{Op: database.ChangeDelete, Schema: "s", Table: "t", Identity: []database.ChangeColumn{id}},
+			`DELETE FROM "s"."t" WHERE "id" = $1`, []any{"7"}},
+		{database.Change{Op: database.ChangeTruncate, Schema: "s", Table: "t"}, `TRUNCATE "s"."t"`, nil},
+		{database.Change{Op: database.ChangeUpdate, Table: "t", Identity: []database.ChangeColumn{id}}, "", nil},
+	} {
+		query, args, err := changeStatement(tc.change)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		if query != tc.query || !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("got %s %v, want %s %v", query, args, tc.query, tc.args)
+		}
+	}
+
+	if _, _, err := changeStatement(database.Change{Op: database.ChangeDelete, Table: "logs"}); err == nil {
+		t.Error("built a delete without a row identity")
+	}
+}
+
+func TestParseLSN(t *testing.T) {
+	for s, want := range map[string]uint64{"": 0, "0/16B3748": 0x16B3748, "16/B374D848": 0x16_B374D848} {
+		if got, err := parseLSN(s); err != nil || got != want {
+			t.Errorf("parseLSN(%q) = %x, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"16B3748", "0/xyz", "1/2/3"} {
+		if _, err := parseLSN(s); err == nil {
+			t.Errorf("parseLSN(%q) succeeded", s)
+		}
+	}
+}
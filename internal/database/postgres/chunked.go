@@ -0,0 +1,171 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+)
+
+// snapshotIDPattern matches the identifiers returned by pg_export_snapshot()
+var snapshotIDPattern = regexp.MustCompile(`^[0-9A-F]+(-[0-9A-F]+)+$`)
+
+// DumpTableChunks exports tables as chunk files read from one exported
+// snapshot, so all chunks see the same data
+func (d *PostgreSQLDriver) DumpTableChunks(ctx context.Context, opts *database.ChunkedDumpOptions) (*database.ChunkManifest, error) {
+	if d.db == nil {
+		return nil, pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	return database.DumpChunks(ctx, d.db, database.DatabaseTypePostgreSQL, chunkDialect{}, opts)
+}
+
+// RestoreTableChunks loads a chunked export into existing tables
+func (d *PostgreSQLDriver) RestoreTableChunks(ctx context.Context, opts *database.ChunkedRestoreOptions) (*database.ChunkManifest, error) {
+	if d.db == nil {
+		return nil, pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	return database.RestoreChunks(ctx, d.db, chunkDialect{}, opts)
+}
+
+// chunkDialect is the PostgreSQL flavour of chunked exports
+type chunkDialect struct{}
+
+func (chunkDialect) QuoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = `"` + strings.ReplaceAll(p, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func (chunkDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (chunkDialect) MaxParams() int {
+	return 65535
+}
+
+func (chunkDialect) PrimaryKey(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	query := `SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = $1::regclass AND i.indisprimary
+		ORDER BY array_position(i.indkey::int2[], a.attnum)`
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+func (chunkDialect) Binary(columnType string) bool {
+	return strings.EqualFold(columnType, "BYTEA")
+}
+
+// Snapshot exports the snapshot of a transaction held open for the whole
+// export and starts every chunk's transaction in it
+func (chunkDialect) Snapshot(ctx context.Context, db *sql.DB) (database.ChunkSnapshot, error) {
+	holder, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	var id string
+	if err := holder.QueryRowContext(ctx, "SELECT pg_export_snapshot()").Scan(&id); err != nil {
+		holder.Rollback()
+		return nil, err
+	}
+	if !snapshotIDPattern.MatchString(id) {
+		holder.Rollback()
+		return nil, fmt.Errorf("unexpected snapshot identifier %q", id)
+	}
+	return &snapshot{db: db, holder: holder, id: id}, nil
+}
+
+// ForeignKeys resolves the tables through the search path, as the chunk
+// queries do
+func (chunkDialect) ForeignKeys(ctx context.Context, db *sql.DB, tables []string) (map[string][]string, error) {
+	oids := make(map[int64]string, len(tables))
+	for _, t := range tables {
+		var oid sql.NullInt64
+		if err := db.QueryRowContext(ctx, "SELECT to_regclass($1)::oid::int8", t).Scan(&oid); err != nil {
+			return nil, err
+		}
+		// A missing table fails its chunks' inserts
+		if oid.Valid {
+			oids[oid.Int64] = t
+		}
+	}
+
+	refs := make(map[string][]string)
+	for oid, t := range oids {
+		rows, err := db.QueryContext(ctx, `SELECT DISTINCT confrelid::int8 FROM pg_constraint
+			WHERE contype = 'f' AND conrelid = $1::int8::oid`, oid)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var ref int64
+			if err := rows.Scan(&ref); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if name, ok := oids[ref]; ok {
+				refs[t] = append(refs[t], name)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// ForeignKeyChecks switches the session to the replica role, in which
+// foreign key triggers do not fire. Setting it takes a superuser, or on
+// PostgreSQL 15 and later the SET privilege on session_replication_role.
+func (chunkDialect) ForeignKeyChecks(ctx context.Context, conn *sql.Conn, on bool) error {
+	role := "replica"
+	if on {
+		role = "DEFAULT"
+	}
+	_, err := conn.ExecContext(ctx, "SET session_replication_role = "+role)
+	return err
+}
+
+type snapshot struct {
+	db     *sql.DB
+	holder *sql.Tx
+	id     string
+}
+
+func (s *snapshot) Begin(ctx context.Context) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "SET TRANSACTION SNAPSHOT '"+s.id+"'"); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to join snapshot: %w", err)
+	}
+	return tx, nil
+}
+
+func (s *snapshot) Close() error {
+	return s.holder.Rollback()
+}
@@ -2,6 +2,7 @@
 package postgres
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
 	"fmt"
@@ -13,7 +14,9 @@ import (
 
 	_ "github.com/lib/pq"
 	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
 	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
 	"github.com/sanskarpan/db-backup/pkg/utils"
 	"github.com/sanskarpan/db-backup/pkg/validation"
 )
@@ -23,6 +26,7 @@ type PostgreSQLDriver struct {
 	db          *sql.DB
 	config      *database.ConnectionConfig
 	pitrManager *PITRManager
+	pgDump      pgDumpLookup
 }
 
 func init() {
@@ -38,6 +42,27 @@ func NewPostgreSQLDriver() *PostgreSQLDriver {
 
 // Connect establishes a connection to the PostgreSQL database
 func (d *PostgreSQLDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	if config.Role != "" {
+		if err := validation.ValidateRoleName(config.Role); err != nil {
+			return pkgErrors.ErrDatabaseConnection(fmt.Errorf("invalid role %q: %w", config.Role, err))
+		}
+	}
+	switch config.Options["backup_method"] {
+	case "", MethodLogical, MethodPhysical:
+	default:
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("backup_method %q is not logical or physical", config.Options["backup_method"]))
+	}
+	switch config.Options["wal_method"] {
+	case "", WALStream, WALFetch, WALNone:
+	default:
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("wal_method %q is not stream, fetch or none", config.Options["wal_method"]))
+	}
+	switch config.Options["dump_format"] {
+	case "", FormatCustom, FormatDirectory, FormatNative:
+	default:
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("dump_format %q is not custom, directory or native", config.Options["dump_format"]))
+	}
+
 	// Build connection string
 	connStr := d.buildConnectionString(config)
 
@@ -85,12 +110,34 @@ func (d *PostgreSQLDriver) Ping(ctx context.Context) error {
 	return d.db.PingContext(ctx)
 }
 
-// Backup creates a backup of the PostgreSQL database
+// Backup creates a backup of the PostgreSQL database: a pg_dump of it, or
+// a pg_basebackup of the whole cluster when opts.Physical or the
+// backup_method connection option asks for one. The dump_format
+// connection option directory dumps tables in parallel, and native dumps
+// without pg_dump, as is done when it is not installed. Several databases
+// are dumped into one archive, in one snapshot with opts.ConsistentBackup.
 func (d *PostgreSQLDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	physical, err := d.physical(opts)
+	if err != nil {
+		return &database.BackupResult{Status: database.BackupStatusFailed, Error: err}, pkgErrors.ErrDatabaseBackup(err)
+	}
+	if physical {
+		return d.physicalBackup(ctx, opts)
+	}
+	if multiDatabase(opts) {
+		return d.databasesBackup(ctx, opts)
+	}
+	if d.directoryFormat(opts) {
+		return d.directoryBackup(ctx, opts)
+	}
+	if d.nativeDump() {
+		return d.nativeBackup(ctx, opts)
+	}
+
 	result := &database.BackupResult{
 		ID:        utils.GenerateBackupID(),
 		StartTime: time.Now(),
-		Metadata:  opts.Metadata,
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
 		Status:    database.BackupStatusInProgress,
 	}
 
@@ -102,11 +149,19 @@ func (d *PostgreSQLDriver) Backup(ctx context.Context, opts *database.BackupOpti
 		return result, pkgErrors.ErrDatabaseBackup(err)
 	}
 
+	// Record where the WAL stood, so changes captured from a replication
+	// slot can be replayed from there onto a restore of this dump
+	walLSN, _ := d.walPosition(ctx)
+
 	// Create pg_dump command
 	cmd := exec.CommandContext(ctx, "pg_dump", args...)
 
 	// Set password via environment variable
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", d.config.Password))
+	cmd.Env = d.commandEnv()
+
+	// Trace the run; the span records the exit code and dump size
+	run := telemetry.StartCommand(ctx, cmd)
+	defer func() { run.End(result.Error, result.Size) }()
 
 	// Create output file
 	outputFile, err := os.Create(opts.OutputPath)
@@ -117,8 +172,9 @@ func (d *PostgreSQLDriver) Backup(ctx context.Context, opts *database.BackupOpti
 	}
 	defer outputFile.Close()
 
-	// Set command output to file
-	cmd.Stdout = outputFile
+	// Set command output to file, hashing the dump as it is written
+	output := stream.NewHashWriter(outputFile)
+	cmd.Stdout = output
 
 	// Capture stderr for errors
 	stderrPipe, err := cmd.StderrPipe()
@@ -166,8 +222,12 @@ func (d *PostgreSQLDriver) Backup(ctx context.Context, opts *database.BackupOpti
 	result.EndTime = time.Now()
 	result.Duration = result.EndTime.Sub(result.StartTime)
 	result.Size = fileInfo.Size()
+	result.Checksum = output.Sum()
 	result.DatabaseVersion = version
 	result.Tables = tables
+	if walLSN != "" {
+		result.Metadata = database.WithMetadata(result.Metadata, map[string]string{MetadataWALPosition: walLSN})
+	}
 	result.Status = database.BackupStatusSuccess
 
 	return result, nil
@@ -175,16 +235,40 @@ func (d *PostgreSQLDriver) Backup(ctx context.Context, opts *database.BackupOpti
 
 // StreamBackup streams a backup to the provided writer
 func (d *PostgreSQLDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	physical, err := d.physical(opts)
+	if err != nil {
+		return pkgErrors.ErrDatabaseBackup(err)
+	}
+	if physical {
+		_, err := d.runBasebackup(ctx, os.TempDir(), writer)
+		return err
+	}
+	if multiDatabase(opts) {
+		_, err := d.runDatabasesDump(ctx, opts, os.TempDir(), writer)
+		return err
+	}
+	if d.directoryFormat(opts) {
+		_, err := d.runDirectoryDump(ctx, opts, os.TempDir(), writer)
+		return err
+	}
+	if d.nativeDump() {
+		_, err := d.runNativeDump(ctx, opts, os.TempDir(), writer)
+		return err
+	}
+
 	args, err := d.buildPgDumpArgs(opts)
 	if err != nil {
 		return pkgErrors.ErrDatabaseBackup(err)
 	}
 
 	cmd := exec.CommandContext(ctx, "pg_dump", args...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", d.config.Password))
-	cmd.Stdout = writer
+	cmd.Env = d.commandEnv()
+	run := telemetry.StartCommand(ctx, cmd)
+	cmd.Stdout = run.Count(writer)
 
-	return cmd.Run()
+	err = cmd.Run()
+	run.End(err, run.Counted())
+	return err
 }
 
 // GetBackupSize estimates the size of a backup
@@ -220,6 +304,81 @@ func (d *PostgreSQLDriver) Restore(ctx context.Context, opts *database.RestoreOp
 		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
 	}
 
+	// Physical backups are extracted into a data directory instead
+	if physical, err := isBasebackupFile(opts.SourceBackup); err != nil || physical {
+		if err == nil {
+			err = d.restorePhysicalFile(ctx, opts)
+		}
+		if err != nil {
+			result.Status = database.RestoreStatusFailed
+			result.Error = err
+			return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+		}
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		result.Status = database.RestoreStatusSuccess
+		return result, nil
+	}
+
+	// Multi-database dumps are restored a database at a time
+	if multi, err := isDatabasesDumpFile(opts.SourceBackup); err != nil || multi {
+		if err == nil {
+			err = d.restoreDatabasesDumpFile(ctx, opts)
+		}
+		if err != nil {
+			result.Status = database.RestoreStatusFailed
+			result.Error = err
+			return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+		}
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		result.Status = database.RestoreStatusSuccess
+		return result, nil
+	}
+
+	// Other dumps hold no database name and are restored into the target
+	// database as they are
+	if opts.Renamed() {
+		var err error
+		if opts, err = d.retarget(ctx, opts); err != nil {
+			result.Status = database.RestoreStatusFailed
+			result.Error = err
+			return result, pkgErrors.ErrDatabaseRestore(err)
+		}
+	}
+
+	// Directory-format dumps are restored a part at a time
+	if directory, err := isDirectoryDumpFile(opts.SourceBackup); err != nil || directory {
+		if err == nil {
+			err = d.restoreDirectoryDumpFile(ctx, opts)
+		}
+		if err != nil {
+			result.Status = database.RestoreStatusFailed
+			result.Error = err
+			return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+		}
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		result.Status = database.RestoreStatusSuccess
+		return result, nil
+	}
+
+	// Dumps taken without pg_dump are restored without psql
+	if native, err := isNativeDumpFile(opts.SourceBackup); err != nil || native {
+		if err == nil {
+			err = d.restoreNativeDumpFile(ctx, opts)
+		}
+		if err != nil {
+			result.Status = database.RestoreStatusFailed
+			result.Error = err
+			return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+		}
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		result.Status = database.RestoreStatusSuccess
+		return result, nil
+	}
+
 	// Build pg_restore or psql command
 	var args []string
 	var err error
@@ -241,7 +400,10 @@ func (d *PostgreSQLDriver) Restore(ctx context.Context, opts *database.RestoreOp
 
 	// Create command
 	cmd := exec.CommandContext(ctx, cmdName, args...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", d.config.Password))
+	cmd.Env = d.commandEnv()
+
+	run := telemetry.StartCommand(ctx, cmd)
+	defer func() { run.End(result.Error, -1) }()
 
 	// For SQL dumps, read from file
 	if cmdName == "psql" {
@@ -290,6 +452,13 @@ func (d *PostgreSQLDriver) restoreWithPITR(ctx context.Context, opts *database.R
 		Status:    database.RestoreStatusInProgress,
 	}
 
+	// Recovery replays the WAL of the whole cluster
+	if opts.Renamed() {
+		result.Status = database.RestoreStatusFailed
+		result.Error = fmt.Errorf("point-in-time restores recover the whole cluster: %w", database.ErrRenameUnsupported)
+		return result, result.Error
+	}
+
 	// Extract WAL directory and data directory from metadata
 	walDir, ok := opts.Metadata["wal_dir"]
 	if !ok || walDir == "" {
@@ -298,6 +467,22 @@ func (d *PostgreSQLDriver) restoreWithPITR(ctx context.Context, opts *database.R
 		return result, result.Error
 	}
 
+	// Physical backups are extracted to replay the archive themselves
+	if physical, err := isBasebackupFile(opts.SourceBackup); err != nil || physical {
+		if err == nil {
+			err = d.recoverPhysical(ctx, opts, walDir)
+		}
+		if err != nil {
+			result.Status = database.RestoreStatusFailed
+			result.Error = err
+			return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+		}
+		result.EndTime = time.Now()
+		result.Duration = result.EndTime.Sub(result.StartTime)
+		result.Status = database.RestoreStatusSuccess
+		return result, nil
+	}
+
 	dataDir, ok := opts.Metadata["data_dir"]
 	if !ok || dataDir == "" {
 		result.Status = database.RestoreStatusFailed
@@ -331,16 +516,54 @@ func (d *PostgreSQLDriver) restoreWithPITR(ctx context.Context, opts *database.R
 
 // StreamRestore restores from a reader
 func (d *PostgreSQLDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	br := bufio.NewReader(reader)
+	physical, err := isBasebackup(br)
+	if err != nil {
+		return pkgErrors.ErrDatabaseRestore(err)
+	}
+	if physical {
+		return restorePhysical(ctx, opts, br)
+	}
+	multi, err := isDatabasesDump(br)
+	if err != nil {
+		return pkgErrors.ErrDatabaseRestore(err)
+	}
+	if multi {
+		return d.restoreDatabasesDump(ctx, opts, br)
+	}
+	if opts.Renamed() {
+		if opts, err = d.retarget(ctx, opts); err != nil {
+			return pkgErrors.ErrDatabaseRestore(err)
+		}
+	}
+	directory, err := isDirectoryDump(br)
+	if err != nil {
+		return pkgErrors.ErrDatabaseRestore(err)
+	}
+	if directory {
+		return d.restoreDirectoryDump(ctx, opts, br)
+	}
+	native, err := isNativeDump(br)
+	if err != nil {
+		return pkgErrors.ErrDatabaseRestore(err)
+	}
+	if native {
+		return d.restoreNativeDump(ctx, opts, br)
+	}
+
 	args, err := d.buildPsqlArgs(opts)
 	if err != nil {
 		return pkgErrors.ErrDatabaseRestore(err)
 	}
 
 	cmd := exec.CommandContext(ctx, "psql", args...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", d.config.Password))
-	cmd.Stdin = reader
+	cmd.Env = d.commandEnv()
+	cmd.Stdin = br
 
-	return cmd.Run()
+	run := telemetry.StartCommand(ctx, cmd)
+	err = cmd.Run()
+	run.End(err, -1)
+	return err
 }
 
 // ValidateRestore validates that a restore can be performed
@@ -350,6 +573,36 @@ func (d *PostgreSQLDriver) ValidateRestore(ctx context.Context, opts *database.R
 		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
 	}
 
+	// Physical backups are extracted without the server
+	physical, err := isBasebackupFile(opts.SourceBackup)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if physical {
+		if err := checkPhysicalRestore(opts); err != nil {
+			return pkgErrors.ErrValidationFailed(err.Error())
+		}
+		return nil
+	}
+	directory, err := isDirectoryDumpFile(opts.SourceBackup)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if directory {
+		if err := checkDirectoryRestore(opts); err != nil {
+			return pkgErrors.ErrValidationFailed(err.Error())
+		}
+	}
+	native, err := isNativeDumpFile(opts.SourceBackup)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if native {
+		if err := checkNativeRestore(opts); err != nil {
+			return pkgErrors.ErrValidationFailed(err.Error())
+		}
+	}
+
 	// Check database connection
 	if err := d.Ping(ctx); err != nil {
 		return pkgErrors.ErrValidationFailed("database connection failed")
@@ -437,27 +690,78 @@ func (d *PostgreSQLDriver) buildConnectionString(config *database.ConnectionConf
 		return config.ConnectionString
 	}
 
-	sslMode := config.SSLMode
-	if sslMode == "" {
-		sslMode = "disable"
-	}
-
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d",
-		config.Host,
-		config.Port,
+	host, port := hostPort(config)
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d",
+		host,
+		port,
 		config.Username,
 		config.Password,
 		config.Database,
-		sslMode,
+		sslMode(config),
 		int(config.ConnectionTimeout.Seconds()),
 	)
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for _, f := range sslFiles(config) {
+		connStr += fmt.Sprintf(" %s='%s'", f.param, quote.Replace(f.path))
+	}
+	if config.Role != "" {
+		connStr += fmt.Sprintf(" options='-c role=%s'", config.Role)
+	}
+	return connStr
+}
+
+// commandEnv returns the environment of the PostgreSQL client tools: the
+// password and, when configured, the role to assume after login and the
+// SSL settings
+func (d *PostgreSQLDriver) commandEnv() []string {
+	env := append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", d.config.Password))
+	if d.config.Role != "" {
+		env = append(env, "PGOPTIONS=-c role="+d.config.Role)
+	}
+	if d.config.SSLMode != "" || len(sslFiles(d.config)) > 0 {
+		env = append(env, "PGSSLMODE="+sslMode(d.config))
+	}
+	for _, f := range sslFiles(d.config) {
+		env = append(env, f.env+"="+f.path)
+	}
+	return env
+}
+
+// sslMode returns the sslmode of config, passed on as is since libpq
+// understands modes of its own, such as prefer
+func sslMode(config *database.ConnectionConfig) string {
+	if config.SSLMode != "" {
+		return config.SSLMode
+	}
+	return config.TLSMode()
+}
+
+// sslFile is a certificate or key file of the connection, with its
+// connection parameter and environment variable
+type sslFile struct {
+	param, env, path string
+}
+
+// sslFiles returns the certificate and key files of config that are set
+func sslFiles(config *database.ConnectionConfig) []sslFile {
+	var files []sslFile
+	for _, f := range []sslFile{
+		{"sslrootcert", "PGSSLROOTCERT", config.SSLCA},
+		{"sslcert", "PGSSLCERT", config.SSLCert},
+		{"sslkey", "PGSSLKEY", config.SSLKey},
+	} {
+		if f.path != "" {
+			files = append(files, f)
+		}
+	}
+	return files
 }
 
 // buildPgDumpArgs builds pg_dump command arguments
 func (d *PostgreSQLDriver) buildPgDumpArgs(opts *database.BackupOptions) ([]string, error) {
 	args := []string{
-		"-h", d.config.Host,
-		"-p", fmt.Sprintf("%d", d.config.Port),
+		"-h", d.host(),
+		"-p", d.port(),
 		"-U", d.config.Username,
 		"-F", "c", // Custom format for better compression and parallel restore
 		"-v",      // Verbose
@@ -465,10 +769,6 @@ func (d *PostgreSQLDriver) buildPgDumpArgs(opts *database.BackupOptions) ([]stri
 		"--no-acl",
 	}
 
-	if opts.Parallel > 1 {
-		args = append(args, "-j", fmt.Sprintf("%d", opts.Parallel))
-	}
-
 	if opts.ConsistentBackup {
 		args = append(args, "--serializable-deferrable")
 	}
@@ -516,8 +816,8 @@ func (d *PostgreSQLDriver) buildRestoreArgs(opts *database.RestoreOptions) ([]st
 	}
 
 	args := []string{
-		"-h", d.config.Host,
-		"-p", fmt.Sprintf("%d", d.config.Port),
+		"-h", d.host(),
+		"-p", d.port(),
 		"-U", d.config.Username,
 		"-d", opts.Database,
 		"-v",
@@ -558,8 +858,8 @@ func (d *PostgreSQLDriver) buildPsqlArgs(opts *database.RestoreOptions) ([]strin
 	}
 
 	args := []string{
-		"-h", d.config.Host,
-		"-p", fmt.Sprintf("%d", d.config.Port),
+		"-h", d.host(),
+		"-p", d.port(),
 		"-U", d.config.Username,
 		"-d", opts.Database,
 	}
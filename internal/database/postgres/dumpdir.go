@@ -0,0 +1,464 @@
+package postgres
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// Dump formats of the dump_format connection option
+const (
+	FormatCustom    = "custom"    // one pg_dump -F c stream, the default for serial dumps with pg_dump installed
+	FormatDirectory = "directory" // pg_dump -F d per table, in parallel; the default for parallel dumps
+	FormatNative    = "native"    // read with SELECT, without pg_dump
+)
+
+// dumpInfoFile is the first entry of directory-format dump archives
+const dumpInfoFile = "pgdump.json"
+
+// dumpFormat identifies the layout of directory-format dump archives
+const dumpFormat = "pg_dump-directory/v1"
+
+// Parts of a directory-format dump archive, each a pg_dump -F d directory
+const (
+	schemaPart = "schema" // the definitions, restored before and after the data
+	dataPart   = "data"   // one directory per table
+	restPart   = "rest"   // the other data: sequences and large objects
+)
+
+// dumpInfo describes a directory-format dump archive
+type dumpInfo struct {
+	Format   string `json:"format"`
+	Database string `json:"database"`
+	// Snapshot is the exported snapshot every part was dumped from
+	Snapshot  string      `json:"snapshot"`
+	Tables    []dumpTable `json:"tables"`
+	Rest      bool        `json:"rest"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// dumpTable is a table of a directory-format dump, with its data under
+// data/<Dir>
+type dumpTable struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"` // pg_total_relation_size when dumped
+	Dir    string `json:"dir"`
+}
+
+func (t dumpTable) size() int64 { return t.Size }
+
+// directoryFormat reports whether dumps are taken in directory format:
+// when the dump_format connection option asks for it, or by default when
+// the dump runs on several workers, which a single custom-format stream
+// cannot use
+func (d *PostgreSQLDriver) directoryFormat(opts *database.BackupOptions) bool {
+	switch d.config.Options["dump_format"] {
+	case FormatDirectory:
+		return true
+	case "":
+		return opts.Parallel > 1 && d.hasPgDump()
+	}
+	return false
+}
+
+// directoryBackup archives a directory-format dump to opts.OutputPath
+func (d *PostgreSQLDriver) directoryBackup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err).WithMetadata("output_path", opts.OutputPath)
+	}
+
+	walLSN, _ := d.walPosition(ctx)
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	defer outputFile.Close()
+	output := stream.NewHashWriter(outputFile)
+	if _, err := d.runDirectoryDump(ctx, opts, filepath.Dir(opts.OutputPath), output); err != nil {
+		return fail(err)
+	}
+	fileInfo, err := outputFile.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	result.DatabaseVersion, _ = d.GetVersion(ctx)
+	result.Tables, _ = d.getTableInfo(ctx, opts.Database)
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Size = fileInfo.Size()
+	result.Checksum = output.Sum()
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"dump_format": FormatDirectory})
+	if walLSN != "" {
+		result.Metadata = database.WithMetadata(result.Metadata, map[string]string{MetadataWALPosition: walLSN})
+	}
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// runDirectoryDump dumps opts.Database in parts under a working directory
+// in dir, then archives them to w. Each table is dumped by its own
+// pg_dump, on opts.Parallel workers, largest table first so the biggest
+// one does not run alone at the end; the schema and the remaining data
+// are dumped alongside. Every pg_dump reads a snapshot exported by a
+// transaction held open meanwhile, so the parts are consistent with each
+// other.
+func (d *PostgreSQLDriver) runDirectoryDump(ctx context.Context, opts *database.BackupOptions, dir string, w io.Writer) (*dumpInfo, error) {
+	if opts.Database == "" {
+		return nil, errors.New("directory-format dumps are of one database, none was given")
+	}
+	if err := validation.ValidateDatabaseName(opts.Database); err != nil {
+		return nil, fmt.Errorf("invalid database name %q: %w", opts.Database, err)
+	}
+	work, err := os.MkdirTemp(dir, "pg_dump-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	// Snapshots can only be imported in the database they were taken in
+	db := d.db
+	if opts.Database != d.config.Database {
+		cfg := *d.config
+		cfg.Database = opts.Database
+		other, err := sql.Open("postgres", d.buildConnectionString(&cfg))
+		if err != nil {
+			return nil, err
+		}
+		defer other.Close()
+		db = other
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	info := &dumpInfo{Format: dumpFormat, Database: opts.Database, CreatedAt: time.Now().UTC()}
+	if err := tx.QueryRowContext(ctx, "SELECT pg_export_snapshot()").Scan(&info.Snapshot); err != nil {
+		return nil, fmt.Errorf("exporting a snapshot: %w", err)
+	}
+	if info.Tables, err = dumpTables(ctx, tx, opts); err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	for i := range info.Tables {
+		info.Tables[i].Dir = fmt.Sprintf("%04d", i+1)
+	}
+
+	base, err := d.dumpPartArgs(opts, info.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	// Table selection leaves out what the selected tables do not own
+	info.Rest = len(opts.Tables) == 0
+
+	parts := []dumpTable{{Dir: schemaPart, Size: -1}}
+	for _, t := range info.Tables {
+		t.Dir = filepath.Join(dataPart, t.Dir)
+		parts = append(parts, t)
+	}
+	if info.Rest {
+		parts = append(parts, dumpTable{Dir: restPart, Size: -1})
+	}
+	err = database.LargestFirst(ctx, parts, dumpTable.size, max(1, opts.Parallel), func(ctx context.Context, part dumpTable) error {
+		args := append([]string{}, base...)
+		switch part.Dir {
+		case schemaPart:
+			args = append(args, "--schema-only")
+			args = append(args, tableArgs(opts)...)
+		case restPart:
+			args = append(args, "--data-only")
+			for _, t := range info.Tables {
+				args = append(args, "-T", quoteIdent(t.Schema)+"."+quoteIdent(t.Name))
+			}
+			args = append(args, excludeArgs(opts)...)
+		default:
+			args = append(args, "--data-only", "-t", quoteIdent(part.Schema)+"."+quoteIdent(part.Name))
+		}
+		args = append(args, "-f", filepath.Join(work, part.Dir), opts.Database)
+		return d.runTool(ctx, "pg_dump", args)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := packDirectoryDump(ctx, work, info, w); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// dumpPartArgs builds the pg_dump arguments shared by the parts of a
+// directory-format dump
+func (d *PostgreSQLDriver) dumpPartArgs(opts *database.BackupOptions, snapshot string) ([]string, error) {
+	for _, table := range append(append([]string{}, opts.Tables...), opts.ExcludeTables...) {
+		if err := validation.ValidateTableName(table); err != nil {
+			return nil, fmt.Errorf("invalid table name %q: %w", table, err)
+		}
+	}
+	return []string{
+		"-h", d.host(),
+		"-p", d.port(),
+		"-U", d.config.Username,
+		"-w",
+		"-F", "d",
+		"-Z", "0", // the archive is compressed as a whole
+		"--no-owner",
+		"--no-acl",
+		"--snapshot=" + snapshot,
+	}, nil
+}
+
+// tableArgs selects the tables of opts for pg_dump
+func tableArgs(opts *database.BackupOptions) []string {
+	var args []string
+	for _, table := range opts.Tables {
+		args = append(args, "-t", table)
+	}
+	return append(args, excludeArgs(opts)...)
+}
+
+// excludeArgs leaves the excluded tables of opts out of pg_dump
+func excludeArgs(opts *database.BackupOptions) []string {
+	var args []string
+	for _, table := range opts.ExcludeTables {
+		args = append(args, "-T", table)
+	}
+	return args
+}
+
+// dumpTables lists the tables whose data a directory-format dump takes
+// one by one, with their size. Extension members are left to the rest
+// part, which dumps the data of those the extension asks for.
+func dumpTables(ctx context.Context, tx *sql.Tx, opts *database.BackupOptions) ([]dumpTable, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT n.nspname, c.relname, pg_total_relation_size(c.oid)
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'r'
+		  AND n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend e
+		                  WHERE e.classid = 'pg_class'::regclass AND e.objid = c.oid AND e.deptype = 'e')
+		ORDER BY 1, 2`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []dumpTable
+	for rows.Next() {
+		var t dumpTable
+		if err := rows.Scan(&t.Schema, &t.Name, &t.Size); err != nil {
+			return nil, err
+		}
+		if selected(t, opts.Tables, true) && !selected(t, opts.ExcludeTables, false) {
+			tables = append(tables, t)
+		}
+	}
+	return tables, rows.Err()
+}
+
+// selected reports whether t is one of names, given as table or
+// schema.table; an empty list selects everything when all is set
+func selected(t dumpTable, names []string, all bool) bool {
+	if len(names) == 0 {
+		return all
+	}
+	for _, name := range names {
+		if name == t.Name || name == t.Schema+"."+t.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// runTool runs a PostgreSQL client tool, failing with what it printed
+func (d *PostgreSQLDriver) runTool(ctx context.Context, name string, args []string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = d.commandEnv()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	run := telemetry.StartCommand(ctx, cmd)
+	err := cmd.Run()
+	run.End(err, -1)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// packDirectoryDump archives the parts under dir after the description of
+// the dump
+func packDirectoryDump(ctx context.Context, dir string, info *dumpInfo, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: dumpInfoFile, Mode: 0o600, Size: int64(len(data)), ModTime: info.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	err = filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || !e.Type().IsRegular() {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return addFile(ctx, tw, path, filepath.ToSlash(name))
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// isDirectoryDump reports whether r starts with a directory-format dump
+// archive, without consuming it
+func isDirectoryDump(r *bufio.Reader) (bool, error) {
+	name, err := firstEntry(r)
+	return name == dumpInfoFile, err
+}
+
+// isDirectoryDumpFile reports whether the file at path is a
+// directory-format dump
+func isDirectoryDumpFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isDirectoryDump(bufio.NewReader(f))
+}
+
+// checkDirectoryRestore checks opts can be honored by a directory-format
+// dump
+func checkDirectoryRestore(opts *database.RestoreOptions) error {
+	if len(opts.Tables) > 0 {
+		return errors.New("directory-format dumps are restored whole, tables cannot be chosen")
+	}
+	if opts.Database != "" {
+		if err := validation.ValidateDatabaseName(opts.Database); err != nil {
+			return fmt.Errorf("invalid database name %q: %w", opts.Database, err)
+		}
+	}
+	return nil
+}
+
+// restoreDirectoryDump restores the directory-format dump read from r:
+// the definitions of tables first, then their data on opts.Parallel
+// workers, largest table first, then the remaining data, and last the
+// indexes, constraints and triggers, which would slow the loads down.
+func (d *PostgreSQLDriver) restoreDirectoryDump(ctx context.Context, opts *database.RestoreOptions, r io.Reader) error {
+	if err := checkDirectoryRestore(opts); err != nil {
+		return err
+	}
+	work, err := os.MkdirTemp("", "pg_restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	info, err := extractDirectoryDump(ctx, r, work)
+	if err != nil {
+		return err
+	}
+
+	base := []string{
+		"-h", d.host(),
+		"-p", d.port(),
+		"-U", d.config.Username,
+		"-w",
+		"-d", opts.Database,
+		"--no-owner",
+		"--no-acl",
+	}
+	section := func(name string) []string {
+		return append(append([]string{}, base...), "--section="+name)
+	}
+	pre := section("pre-data")
+	if opts.DropExisting {
+		pre = append(pre, "--clean", "--if-exists")
+	}
+	if err := d.runTool(ctx, "pg_restore", append(pre, filepath.Join(work, schemaPart))); err != nil {
+		return err
+	}
+	data := append(append([]string{}, base...), "--data-only")
+	err = database.LargestFirst(ctx, info.Tables, dumpTable.size, max(1, opts.Parallel), func(ctx context.Context, t dumpTable) error {
+		return d.runTool(ctx, "pg_restore", append(data[:len(data):len(data)], filepath.Join(work, dataPart, filepath.Base(t.Dir))))
+	})
+	if err != nil {
+		return err
+	}
+	if info.Rest {
+		if err := d.runTool(ctx, "pg_restore", append(data, filepath.Join(work, restPart))); err != nil {
+			return err
+		}
+	}
+	return d.runTool(ctx, "pg_restore", append(section("post-data"), filepath.Join(work, schemaPart)))
+}
+
+// extractDirectoryDump extracts the parts of the directory-format dump
+// read from r into dir and returns its description
+func extractDirectoryDump(ctx context.Context, r io.Reader, dir string) (*dumpInfo, error) {
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil || header.Name != dumpInfoFile {
+		return nil, errors.New("not a directory-format dump archive")
+	}
+	var info dumpInfo
+	if err := json.NewDecoder(io.LimitReader(tr, 16<<20)).Decode(&info); err != nil || info.Format != dumpFormat {
+		return nil, errors.New("not a directory-format dump archive")
+	}
+	if err := extractEntries(ctx, tr, dir); err != nil {
+		return nil, fmt.Errorf("extracting the dump: %w", err)
+	}
+	return &info, nil
+}
+
+// restoreDirectoryDumpFile restores the directory-format dump
+// opts.SourceBackup
+func (d *PostgreSQLDriver) restoreDirectoryDumpFile(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.restoreDirectoryDump(ctx, opts, f)
+}
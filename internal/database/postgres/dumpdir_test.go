@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestDirectoryDumpRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	work := t.TempDir()
+	files := map[string]string{
+		"schema/toc.dat":     "schema toc",
+		"data/0001/toc.dat":  "orders toc",
+		"data/0001/3101.dat": "orders rows",
+		"data/0002/toc.dat":  "items toc",
+		"rest/toc.dat":       "sequences",
+	}
+	for name, data := range files {
+		path := filepath.Join(work, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0o700)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info := &dumpInfo{
+		Format:    dumpFormat,
+		Database:  "shop",
+		Snapshot:  "00000003-0000001B-1",
+		Tables:    []dumpTable{{Schema: "public", Name: "orders", Size: 9 << 30, Dir: "0001"}, {Schema: "public", Name: "items", Size: 1 << 20, Dir: "0002"}},
+		Rest:      true,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	var archive bytes.Buffer
+	if err := packDirectoryDump(ctx, work, info, &archive); err != nil {
+		t.Fatal(err)
+	}
+	if directory, err := isDirectoryDump(bufio.NewReader(bytes.NewReader(archive.Bytes()))); err != nil || !directory {
+		t.Fatalf("isDirectoryDump = %v, %v", directory, err)
+	}
+	if physical, _ := isBasebackup(bufio.NewReader(bytes.NewReader(archive.Bytes()))); physical {
+		t.Error("taken for a physical backup")
+	}
+
+	out := t.TempDir()
+	got, err := extractDirectoryDump(ctx, bytes.NewReader(archive.Bytes()), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, info) {
+		t.Errorf("info = %+v, want %+v", got, info)
+	}
+	for name, want := range files {
+		data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, dumpInfoFile)); !os.IsNotExist(err) {
+		t.Error("the description was extracted with the parts")
+	}
+
+	var other bytes.Buffer
+	packBasebackup(ctx, basebackupDir(t), &basebackupInfo{Format: basebackupFormat}, &other)
+	if _, err := extractDirectoryDump(ctx, &other, t.TempDir()); err == nil {
+		t.Error("extracted a physical backup as a dump")
+	}
+}
+
+func TestDumpPartArgs(t *testing.T) {
+	d := &PostgreSQLDriver{config: &database.ConnectionConfig{Host: "db1", Port: 5432, Username: "backup"}}
+	opts := &database.BackupOptions{Database: "shop", Tables: []string{"public.orders"}, ExcludeTables: []string{"audit_log"}}
+	args, err := d.dumpPartArgs(opts, "00000003-0000001B-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(args, " "); got != "-h db1 -p 5432 -U backup -w -F d -Z 0 --no-owner --no-acl --snapshot=00000003-0000001B-1" {
+		t.Errorf("args = %s", got)
+	}
+	if got := strings.Join(tableArgs(opts), " "); got != "-t public.orders -T audit_log" {
+		t.Errorf("table args = %s", got)
+	}
+	opts.ExcludeTables = []string{"x; rm -rf /"}
+	if _, err := d.dumpPartArgs(opts, "s"); err == nil {
+		t.Error("accepted an invalid table name")
+	}
+}
+
+func TestSelected(t *testing.T) {
+	orders := dumpTable{Schema: "sales", Name: "orders"}
+	for _, tc := range []struct {
+		names []string
+		all   bool
+		want  bool
+	}{
+		{nil, true, true},
+		{nil, false, false},
+		{[]string{"orders"}, true, true},
+		{[]string{"sales.orders"}, false, true},
+		{[]string{"public.orders", "items"}, true, false},
+	} {
+		if got := selected(orders, tc.names, tc.all); got != tc.want {
+			t.Errorf("selected(%v, %v) = %v", tc.names, tc.all, got)
+		}
+	}
+	if err := checkDirectoryRestore(&database.RestoreOptions{Tables: []string{"orders"}}); err == nil {
+		t.Error("accepted a table selection")
+	}
+}
+
+func TestDirectoryFormat(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "pg_dump"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	d := &PostgreSQLDriver{config: &database.ConnectionConfig{Host: "db1", Port: 5432, Username: "backup", Options: map[string]string{}}}
+	serial, parallel := &database.BackupOptions{Database: "shop"}, &database.BackupOptions{Database: "shop", Parallel: 4}
+	if d.directoryFormat(serial) || !d.directoryFormat(parallel) {
+		t.Error("parallel dumps not taken in directory format by default")
+	}
+	d.config.Options["dump_format"] = FormatCustom
+	if d.directoryFormat(parallel) {
+		t.Error("dump_format custom taken in directory format")
+	}
+	args, err := d.buildPgDumpArgs(parallel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.Join(args, " "), "-j") {
+		t.Errorf("custom-format dump run with -j: %v", args)
+	}
+
+	// pg_dump is looked up once per driver
+	t.Setenv("PATH", t.TempDir())
+	d = &PostgreSQLDriver{config: &database.ConnectionConfig{Options: map[string]string{}}}
+	if d.directoryFormat(parallel) {
+		t.Error("directory format chosen without pg_dump")
+	}
+}
@@ -0,0 +1,414 @@
+package postgres
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// databasesInfoFile is the first entry of multi-database dump archives
+const databasesInfoFile = "pgdatabases.json"
+
+// databasesFormat identifies the layout of multi-database dump archives
+const databasesFormat = "pg_dump-databases/v1"
+
+// snapshotAttempts bounds the tries at catching every database in the same
+// snapshot
+const snapshotAttempts = 20
+
+// databasesInfo describes a multi-database dump archive
+type databasesInfo struct {
+	Format    string         `json:"format"`
+	Databases []databaseDump `json:"databases"`
+	// Snapshot is the cluster snapshot (txid_current_snapshot) every
+	// database was dumped in, empty when each was dumped in its own
+	Snapshot  string    `json:"snapshot,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// databaseDump is the pg_dump -F c dump of one database, in File
+type databaseDump struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	Size int64  `json:"size"` // pg_database_size when dumped
+}
+
+func (db databaseDump) size() int64 { return db.Size }
+
+// multiDatabase reports whether opts selects several databases
+func multiDatabase(opts *database.BackupOptions) bool {
+	return opts.AllDatabases || len(opts.Databases) > 0
+}
+
+// databasesBackup archives a dump of each selected database to
+// opts.OutputPath
+func (d *PostgreSQLDriver) databasesBackup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err).WithMetadata("output_path", opts.OutputPath)
+	}
+
+	walLSN, _ := d.walPosition(ctx)
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	defer outputFile.Close()
+	output := stream.NewHashWriter(outputFile)
+	info, err := d.runDatabasesDump(ctx, opts, filepath.Dir(opts.OutputPath), output)
+	if err != nil {
+		return fail(err)
+	}
+	fileInfo, err := outputFile.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	names := make([]string, len(info.Databases))
+	for i, db := range info.Databases {
+		names[i] = db.Name
+	}
+	result.DatabaseVersion, _ = d.GetVersion(ctx)
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Size = fileInfo.Size()
+	result.Checksum = output.Sum()
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"databases": strings.Join(names, ",")})
+	if info.Snapshot != "" {
+		result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"snapshot": info.Snapshot})
+	}
+	if walLSN != "" {
+		result.Metadata = database.WithMetadata(result.Metadata, map[string]string{MetadataWALPosition: walLSN})
+	}
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// runDatabasesDump dumps the selected databases under a working directory
+// in dir, on opts.Parallel workers, largest first, then archives them to
+// w. With opts.ConsistentBackup every database is dumped in the same
+// snapshot of the cluster, so data spread over several databases is
+// consistent across them.
+func (d *PostgreSQLDriver) runDatabasesDump(ctx context.Context, opts *database.BackupOptions, dir string, w io.Writer) (*databasesInfo, error) {
+	names := opts.Databases
+	if opts.AllDatabases {
+		all, err := d.GetDatabases(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing databases: %w", err)
+		}
+		names = all
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no database to dump")
+	}
+	info := &databasesInfo{Format: databasesFormat, CreatedAt: time.Now().UTC()}
+	for i, name := range names {
+		if err := validation.ValidateDatabaseName(name); err != nil {
+			return nil, fmt.Errorf("invalid database name %q: %w", name, err)
+		}
+		db := databaseDump{Name: name, File: fmt.Sprintf("%04d.dump", i+1)}
+		if err := d.db.QueryRowContext(ctx, "SELECT pg_database_size($1)", name).Scan(&db.Size); err != nil {
+			return nil, fmt.Errorf("database %s: %w", name, err)
+		}
+		info.Databases = append(info.Databases, db)
+	}
+
+	var snapshots map[string]string
+	if opts.ConsistentBackup {
+		held, err := d.snapshotDatabases(ctx, names)
+		if err != nil {
+			return nil, err
+		}
+		defer held.Close()
+		info.Snapshot, snapshots = held.snapshot, held.exported
+	}
+
+	work, err := os.MkdirTemp(dir, "pg_dump-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	// pg_dump -j needs the directory format; the databases are dumped in
+	// parallel instead
+	err = database.LargestFirst(ctx, info.Databases, databaseDump.size, max(1, opts.Parallel), func(ctx context.Context, db databaseDump) error {
+		dbOpts := *opts
+		dbOpts.Database, dbOpts.Parallel, dbOpts.ConsistentBackup = "", 0, false
+		args, err := d.buildPgDumpArgs(&dbOpts)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-f", filepath.Join(work, db.File))
+		if id := snapshots[db.Name]; id != "" {
+			args = append(args, "--snapshot="+id)
+		}
+		if err := d.runTool(ctx, "pg_dump", append(args, db.Name)); err != nil {
+			return fmt.Errorf("database %s: %w", db.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := packDatabasesDump(ctx, work, info, w); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// heldSnapshots are the transactions holding the snapshot every database
+// is dumped in open
+type heldSnapshots struct {
+	snapshot string            // txid_current_snapshot of all of them
+	exported map[string]string // pg_export_snapshot by database
+	close    []func() error
+}
+
+// Close ends the transactions and their connections
+func (h *heldSnapshots) Close() error {
+	var err error
+	for i := len(h.close) - 1; i >= 0; i-- {
+		if cerr := h.close[i](); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// snapshotDatabases starts a read-only repeatable read transaction in each
+// database and exports its snapshot. Exported snapshots cannot cross
+// databases, but transaction IDs are shared by the whole cluster: when the
+// transactions all see the same transaction IDs as committed, they see
+// the same state of the cluster. The snapshots are taken together and
+// retried until they match, which takes a moment without commits.
+func (d *PostgreSQLDriver) snapshotDatabases(ctx context.Context, names []string) (*heldSnapshots, error) {
+	for attempt := 1; ; attempt++ {
+		held, err := d.trySnapshotDatabases(ctx, names)
+		if err != nil {
+			return nil, err
+		}
+		if held != nil {
+			return held, nil
+		}
+		if attempt == snapshotAttempts {
+			return nil, fmt.Errorf("transactions kept committing while the snapshots of %d databases were taken, %d times over; retry at a quieter time or take a physical backup", len(names), snapshotAttempts)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * 50 * time.Millisecond):
+		}
+	}
+}
+
+// trySnapshotDatabases takes the snapshots once, returning nil when they
+// differ
+func (d *PostgreSQLDriver) trySnapshotDatabases(ctx context.Context, names []string) (*heldSnapshots, error) {
+	held := &heldSnapshots{exported: make(map[string]string, len(names))}
+	txs := make([]*sql.Tx, len(names))
+	for i, name := range names {
+		db := d.db
+		if name != d.config.Database {
+			cfg := *d.config
+			cfg.Database = name
+			other, err := sql.Open("postgres", d.buildConnectionString(&cfg))
+			if err != nil {
+				held.Close()
+				return nil, err
+			}
+			held.close = append(held.close, other.Close)
+			db = other
+		}
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			held.Close()
+			return nil, fmt.Errorf("database %s: %w", name, err)
+		}
+		held.close = append(held.close, conn.Close)
+		// The snapshot is taken by the first query, below
+		tx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			held.Close()
+			return nil, fmt.Errorf("database %s: %w", name, err)
+		}
+		held.close = append(held.close, tx.Rollback)
+		txs[i] = tx
+	}
+
+	snapshots := make([]string, len(names))
+	exported := make([]string, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i := range txs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = txs[i].QueryRowContext(ctx, "SELECT pg_export_snapshot(), txid_current_snapshot()::text").Scan(&exported[i], &snapshots[i])
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		held.Close()
+		return nil, fmt.Errorf("exporting snapshots: %w", err)
+	}
+	if slices.ContainsFunc(snapshots, func(s string) bool { return s != snapshots[0] }) {
+		held.Close()
+		return nil, nil
+	}
+	for i, name := range names {
+		if !snapshotIDPattern.MatchString(exported[i]) {
+			held.Close()
+			return nil, fmt.Errorf("unexpected snapshot identifier %q", exported[i])
+		}
+		held.exported[name] = exported[i]
+	}
+	held.snapshot = snapshots[0]
+	return held, nil
+}
+
+// packDatabasesDump archives the dumps under dir after the description of
+// the archive
+func packDatabasesDump(ctx context.Context, dir string, info *databasesInfo, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: databasesInfoFile, Mode: 0o600, Size: int64(len(data)), ModTime: info.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for _, db := range info.Databases {
+		if err := addFile(ctx, tw, filepath.Join(dir, db.File), db.File); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// isDatabasesDump reports whether r starts with a multi-database dump
+// archive, without consuming it
+func isDatabasesDump(r *bufio.Reader) (bool, error) {
+	name, err := firstEntry(r)
+	return name == databasesInfoFile, err
+}
+
+// isDatabasesDumpFile reports whether the file at path is a multi-database
+// dump
+func isDatabasesDumpFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isDatabasesDump(bufio.NewReader(f))
+}
+
+// extractDatabasesDump extracts the dumps of the multi-database archive
+// read from r into dir and returns its description
+func extractDatabasesDump(ctx context.Context, r io.Reader, dir string) (*databasesInfo, error) {
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil || header.Name != databasesInfoFile {
+		return nil, errors.New("not a multi-database dump archive")
+	}
+	var info databasesInfo
+	if err := json.NewDecoder(io.LimitReader(tr, 16<<20)).Decode(&info); err != nil || info.Format != databasesFormat {
+		return nil, errors.New("not a multi-database dump archive")
+	}
+	if err := extractEntries(ctx, tr, dir); err != nil {
+		return nil, fmt.Errorf("extracting the dumps: %w", err)
+	}
+	return &info, nil
+}
+
+// restoreDatabasesDump restores each database of the multi-database dump
+// read from r into the database of the same name, created when missing,
+// or only opts.Database when set, under opts.TargetDatabase when set
+func (d *PostgreSQLDriver) restoreDatabasesDump(ctx context.Context, opts *database.RestoreOptions, r io.Reader) error {
+	work, err := os.MkdirTemp("", "pg_restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	info, err := extractDatabasesDump(ctx, r, work)
+	if err != nil {
+		return err
+	}
+
+	dbs := info.Databases
+	switch {
+	case opts.Database != "":
+		i := slices.IndexFunc(dbs, func(db databaseDump) bool { return db.Name == opts.Database })
+		if i < 0 {
+			return fmt.Errorf("the backup holds no database %s", opts.Database)
+		}
+		dbs = dbs[i : i+1]
+	case opts.TargetDatabase != "" && len(dbs) > 1:
+		return fmt.Errorf("the backup holds %d databases: choose the one restored as %s", len(dbs), opts.TargetDatabase)
+	}
+	for _, db := range dbs {
+		if err := validation.ValidateDatabaseName(db.Name); err != nil {
+			return fmt.Errorf("invalid database name %q in backup: %w", db.Name, err)
+		}
+		target := db.Name
+		if opts.TargetDatabase != "" {
+			target = opts.TargetDatabase
+		}
+		if err := d.ensureDatabase(ctx, target); err != nil {
+			return err
+		}
+		dbOpts := *opts
+		dbOpts.Database, dbOpts.SourceBackup = target, filepath.Join(work, filepath.Base(db.File))
+		args, err := d.buildRestoreArgs(&dbOpts)
+		if err != nil {
+			return err
+		}
+		if opts.DropExisting {
+			args = append([]string{"--if-exists"}, args...)
+		}
+		if err := d.runTool(ctx, "pg_restore", args); err != nil {
+			return fmt.Errorf("database %s: %w", db.Name, err)
+		}
+	}
+	return nil
+}
+
+// restoreDatabasesDumpFile restores the multi-database dump in
+// opts.SourceBackup
+func (d *PostgreSQLDriver) restoreDatabasesDumpFile(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.restoreDatabasesDump(ctx, opts, f)
+}
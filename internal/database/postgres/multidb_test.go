@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestDatabasesDumpRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	work := t.TempDir()
+	info := &databasesInfo{
+		Format: databasesFormat,
+		Databases: []databaseDump{
+			{Name: "orders", File: "0001.dump", Size: 8 << 30},
+			{Name: "billing", File: "0002.dump", Size: 1 << 30},
+		},
+		Snapshot:  "7521:7530:7521,7524",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	for _, db := range info.Databases {
+		os.WriteFile(filepath.Join(work, db.File), []byte("PGDMP "+db.Name), 0o600)
+	}
+
+	var archive bytes.Buffer
+	if err := packDatabasesDump(ctx, work, info, &archive); err != nil {
+		t.Fatal(err)
+	}
+	if multi, err := isDatabasesDump(bufio.NewReader(bytes.NewReader(archive.Bytes()))); err != nil || !multi {
+		t.Fatalf("isDatabasesDump = %v, %v", multi, err)
+	}
+	if directory, _ := isDirectoryDump(bufio.NewReader(bytes.NewReader(archive.Bytes()))); directory {
+		t.Error("taken for a directory-format dump")
+	}
+
+	out := t.TempDir()
+	got, err := extractDatabasesDump(ctx, bytes.NewReader(archive.Bytes()), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, info) {
+		t.Errorf("info = %+v, want %+v", got, info)
+	}
+	for _, db := range info.Databases {
+		if data, _ := os.ReadFile(filepath.Join(out, db.File)); string(data) != "PGDMP "+db.Name {
+			t.Errorf("%s = %q", db.File, data)
+		}
+	}
+
+	d := &PostgreSQLDriver{config: &database.ConnectionConfig{}}
+	err = d.restoreDatabasesDump(ctx, &database.RestoreOptions{Database: "crm"}, bytes.NewReader(archive.Bytes()))
+	if err == nil || !strings.Contains(err.Error(), "no database crm") {
+		t.Errorf("err = %v restoring a database the backup lacks", err)
+	}
+}
+
+func TestMultiDatabase(t *testing.T) {
+	for _, tc := range []struct {
+		opts database.BackupOptions
+		want bool
+	}{
+		{database.BackupOptions{Database: "orders"}, false},
+		{database.BackupOptions{Databases: []string{"orders", "billing"}}, true},
+		{database.BackupOptions{AllDatabases: true}, true},
+	} {
+		if got := multiDatabase(&tc.opts); got != tc.want {
+			t.Errorf("multiDatabase(%+v) = %v", tc.opts, got)
+		}
+	}
+}
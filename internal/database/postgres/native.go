@@ -0,0 +1,861 @@
+package postgres
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// nativeInfoFile is the first entry of dump archives taken without pg_dump
+const nativeInfoFile = "pgnative.json"
+
+// nativeFormat identifies the layout of dump archives taken without pg_dump
+const nativeFormat = "pg-native/v1"
+
+// nativeInfo describes a dump taken without pg_dump. The definitions are
+// SQL statements rebuilt from the catalogs; the archive holds the data of
+// each table after it, in the text format of COPY.
+type nativeInfo struct {
+	Format        string `json:"format"`
+	Database      string `json:"database"`
+	ServerVersion string `json:"server_version,omitempty"`
+	// Drop removes the dumped objects, ahead of restores dropping the
+	// existing ones
+	Drop []string `json:"drop"`
+	// PreData creates what the data is loaded into, PostData the
+	// constraints, indexes, views and triggers once it is in
+	PreData   []string      `json:"pre_data"`
+	Tables    []nativeTable `json:"tables"`
+	PostData  []string      `json:"post_data"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// nativeTable is a table of a dump taken without pg_dump
+type nativeTable struct {
+	Schema   string   `json:"schema"`
+	Name     string   `json:"name"`
+	Columns  []string `json:"columns"` // those loaded, generated ones are computed
+	Size     int64    `json:"size"`    // pg_total_relation_size when dumped
+	File     string   `json:"file"`
+	Rows     int64    `json:"rows"`
+	Checksum string   `json:"checksum"`
+}
+
+func (t nativeTable) size() int64 { return t.Size }
+
+func (t nativeTable) qualified() string { return quoteIdent(t.Schema) + "." + quoteIdent(t.Name) }
+
+// nativeColumn is a column definition read from pg_attribute
+type nativeColumn struct {
+	Name      string
+	Type      string
+	NotNull   bool
+	Default   string // the generation expression of generated columns
+	Identity  string // a(lways) or d(efault)
+	Generated string // s(tored) or v(irtual)
+	Collation string // when not the type's
+}
+
+// definition returns the column as written in CREATE TABLE
+func (c nativeColumn) definition() string {
+	def := quoteIdent(c.Name) + " " + c.Type
+	if c.Collation != "" {
+		def += " COLLATE " + c.Collation
+	}
+	switch {
+	case c.Generated == "s":
+		def += " GENERATED ALWAYS AS (" + c.Default + ") STORED"
+	case c.Generated == "v":
+		def += " GENERATED ALWAYS AS (" + c.Default + ") VIRTUAL"
+	case c.Identity == "a":
+		def += " GENERATED ALWAYS AS IDENTITY"
+	case c.Identity == "d":
+		def += " GENERATED BY DEFAULT AS IDENTITY"
+	case c.Default != "":
+		def += " DEFAULT " + c.Default
+	}
+	if c.NotNull && c.Identity == "" {
+		def += " NOT NULL"
+	}
+	return def
+}
+
+// nativeDump reports whether dumps are taken without pg_dump: when the
+// dump_format connection option asks for it, or by default when pg_dump
+// is not installed, as in a container image without the client tools
+func (d *PostgreSQLDriver) nativeDump() bool {
+	switch d.config.Options["dump_format"] {
+	case FormatNative:
+		return true
+	case "":
+		return !d.hasPgDump()
+	}
+	return false
+}
+
+// pgDumpLookup remembers whether pg_dump is installed, looked up once per
+// driver
+type pgDumpLookup struct {
+	once  sync.Once
+	found bool
+}
+
+// hasPgDump reports whether pg_dump is on the PATH
+func (d *PostgreSQLDriver) hasPgDump() bool {
+	d.pgDump.once.Do(func() {
+		_, err := exec.LookPath("pg_dump")
+		d.pgDump.found = err == nil
+	})
+	return d.pgDump.found
+}
+
+// nativeBackup archives a dump taken without pg_dump to opts.OutputPath
+func (d *PostgreSQLDriver) nativeBackup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err).WithMetadata("output_path", opts.OutputPath)
+	}
+
+	walLSN, _ := d.walPosition(ctx)
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	defer outputFile.Close()
+	output := stream.NewHashWriter(outputFile)
+	info, err := d.runNativeDump(ctx, opts, filepath.Dir(opts.OutputPath), output)
+	if err != nil {
+		return fail(err)
+	}
+	fileInfo, err := outputFile.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	result.DatabaseVersion = info.ServerVersion
+	result.Tables, _ = d.getTableInfo(ctx, opts.Database)
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Size = fileInfo.Size()
+	result.Checksum = output.Sum()
+	result.Metadata = database.WithMetadata(result.Metadata, map[string]string{"dump_format": FormatNative})
+	if walLSN != "" {
+		result.Metadata = database.WithMetadata(result.Metadata, map[string]string{MetadataWALPosition: walLSN})
+	}
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// runNativeDump dumps opts.Database without pg_dump under a working
+// directory in dir, then archives it to w. The definitions are read from
+// the catalogs and the tables with SELECT, on opts.Parallel workers,
+// largest first, all in one exported snapshot.
+func (d *PostgreSQLDriver) runNativeDump(ctx context.Context, opts *database.BackupOptions, dir string, w io.Writer) (*nativeInfo, error) {
+	if opts.Database == "" {
+		return nil, errors.New("dumps without pg_dump are of one database, none was given")
+	}
+	if err := validation.ValidateDatabaseName(opts.Database); err != nil {
+		return nil, fmt.Errorf("invalid database name %q: %w", opts.Database, err)
+	}
+	for _, table := range append(append([]string{}, opts.Tables...), opts.ExcludeTables...) {
+		if err := validation.ValidateTableName(table); err != nil {
+			return nil, fmt.Errorf("invalid table name %q: %w", table, err)
+		}
+	}
+	work, err := os.MkdirTemp(dir, "pg_native-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	db, closeDB, err := d.databaseDB(opts.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDB()
+	snap, err := chunkDialect{}.Snapshot(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("exporting a snapshot: %w", err)
+	}
+	defer snap.Close()
+	tx, err := snap.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info, err := nativeSchema(ctx, tx, opts)
+	tx.Rollback()
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]*nativeTable, len(info.Tables))
+	for i := range info.Tables {
+		info.Tables[i].File = fmt.Sprintf("data/%04d.copy", i+1)
+		tables[i] = &info.Tables[i]
+	}
+	if err := os.MkdirAll(filepath.Join(work, "data"), 0o700); err != nil {
+		return nil, err
+	}
+	err = database.LargestFirst(ctx, tables, (*nativeTable).size, max(1, opts.Parallel), func(ctx context.Context, t *nativeTable) error {
+		tx, err := snap.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := copyOut(ctx, tx, t, filepath.Join(work, t.File)); err != nil {
+			return fmt.Errorf("dumping %s: %w", t.qualified(), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := packNativeDump(ctx, work, info, w); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// databaseDB returns a connection pool to the database name, the driver's
+// own when it is connected to it, and the function releasing it
+func (d *PostgreSQLDriver) databaseDB(name string) (*sql.DB, func() error, error) {
+	if name == "" || name == d.config.Database {
+		return d.db, func() error { return nil }, nil
+	}
+	cfg := *d.config
+	cfg.Database = name
+	db, err := sql.Open("postgres", d.buildConnectionString(&cfg))
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, db.Close, nil
+}
+
+// nativeSchema reads the definitions of what opts dumps from the
+// catalogs. Like pg_dump -t, a table selection dumps the tables with their
+// sequences, constraints, indexes and triggers only. Objects the
+// statements cannot be rebuilt for fail the dump rather than go missing
+// from it.
+func nativeSchema(ctx context.Context, tx *sql.Tx, opts *database.BackupOptions) (*nativeInfo, error) {
+	info := &nativeInfo{Format: nativeFormat, Database: opts.Database, CreatedAt: time.Now().UTC()}
+	if err := tx.QueryRowContext(ctx, "SHOW server_version").Scan(&info.ServerVersion); err != nil {
+		return nil, err
+	}
+	tables, err := dumpTables(ctx, tx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	whole := len(opts.Tables) == 0
+	dumped := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		dumped[t.Schema+"."+t.Name] = true
+	}
+	if err := checkNative(ctx, tx, opts, dumped, whole); err != nil {
+		return nil, err
+	}
+
+	// Function bodies refer to tables created after them
+	info.PreData = append(info.PreData, "SET check_function_bodies = false")
+	var views, sequences, functions, types []string
+	if whole {
+		err := query(ctx, tx, `SELECT n.nspname FROM pg_namespace n
+			WHERE n.nspname NOT IN ('public', 'information_schema') AND n.nspname !~ '^pg_'
+			  AND NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.classid = 'pg_namespace'::regclass AND e.objid = n.oid AND e.deptype = 'e')
+			ORDER BY 1`, nil, func(scan func(...any) error) error {
+			var schema string
+			if err := scan(&schema); err != nil {
+				return err
+			}
+			info.PreData = append(info.PreData, "CREATE SCHEMA IF NOT EXISTS "+quoteIdent(schema))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading schemas: %w", err)
+		}
+		err = query(ctx, tx, `SELECT e.extname, n.nspname FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+			WHERE e.extname <> 'plpgsql' ORDER BY 1`, nil, func(scan func(...any) error) error {
+			var name, schema string
+			if err := scan(&name, &schema); err != nil {
+				return err
+			}
+			info.PreData = append(info.PreData, fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s WITH SCHEMA %s", quoteIdent(name), quoteIdent(schema)))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading extensions: %w", err)
+		}
+		err = query(ctx, tx, `SELECT n.nspname, t.typname, string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder)
+			FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace JOIN pg_enum e ON e.enumtypid = t.oid
+			WHERE n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+			  AND NOT EXISTS (SELECT 1 FROM pg_depend x WHERE x.classid = 'pg_type'::regclass AND x.objid = t.oid AND x.deptype = 'e')
+			GROUP BY 1, 2 ORDER BY 1, 2`, nil, func(scan func(...any) error) error {
+			var schema, name, labels string
+			if err := scan(&schema, &name, &labels); err != nil {
+				return err
+			}
+			qualified := quoteIdent(schema) + "." + quoteIdent(name)
+			info.PreData = append(info.PreData, "CREATE TYPE "+qualified+" AS ENUM ("+labels+")")
+			types = append(types, "DROP TYPE IF EXISTS "+qualified+" CASCADE")
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading types: %w", err)
+		}
+	}
+
+	// Sequences, the values last: identity sequences come with their
+	// columns, serial ones are tied to theirs after the data. The last
+	// value is NULL until nextval is first called.
+	err = query(ctx, tx, `SELECT n.nspname, c.relname, format_type(s.seqtypid, NULL), s.seqstart, s.seqincrement, s.seqmin, s.seqmax, s.seqcache, s.seqcycle,
+			pg_sequence_last_value(c.oid), COALESCE(dn.nspname, ''), COALESCE(dc.relname, ''), COALESCE(a.attname, ''), COALESCE(dep.deptype::text, '')
+		FROM pg_sequence s
+		JOIN pg_class c ON c.oid = s.seqrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_depend dep ON dep.classid = 'pg_class'::regclass AND dep.objid = c.oid
+		  AND dep.refclassid = 'pg_class'::regclass AND dep.deptype IN ('a', 'i')
+		LEFT JOIN pg_class dc ON dc.oid = dep.refobjid
+		LEFT JOIN pg_namespace dn ON dn.oid = dc.relnamespace
+		LEFT JOIN pg_attribute a ON a.attrelid = dep.refobjid AND a.attnum = dep.refobjsubid
+		WHERE n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.classid = 'pg_class'::regclass AND e.objid = c.oid AND e.deptype = 'e')
+		ORDER BY 1, 2`, nil, func(scan func(...any) error) error {
+		var schema, name, typ, ownerSchema, owner, column, deptype string
+		var start, increment, minValue, maxValue, cache int64
+		var cycle bool
+		var last sql.NullInt64
+		if err := scan(&schema, &name, &typ, &start, &increment, &minValue, &maxValue, &cache, &cycle, &last, &ownerSchema, &owner, &column, &deptype); err != nil {
+			return err
+		}
+		if owner == "" && !whole || owner != "" && !dumped[ownerSchema+"."+owner] {
+			return nil
+		}
+		qualified := quoteIdent(schema) + "." + quoteIdent(name)
+		value, called := start, last.Valid
+		if called {
+			value = last.Int64
+		}
+		ownerTable := quoteIdent(ownerSchema) + "." + quoteIdent(owner)
+		if deptype == "i" {
+			info.PostData = append(info.PostData, fmt.Sprintf("SELECT pg_catalog.setval(pg_catalog.pg_get_serial_sequence(%s, %s), %d, %t)",
+				quoteLiteral(ownerTable), quoteLiteral(column), value, called))
+			return nil
+		}
+		cycleOption := "NO CYCLE"
+		if cycle {
+			cycleOption = "CYCLE"
+		}
+		info.PreData = append(info.PreData, fmt.Sprintf("CREATE SEQUENCE %s AS %s START WITH %d INCREMENT BY %d MINVALUE %d MAXVALUE %d CACHE %d %s",
+			qualified, typ, start, increment, minValue, maxValue, cache, cycleOption))
+		info.PostData = append(info.PostData, fmt.Sprintf("SELECT pg_catalog.setval(%s, %d, %t)", quoteLiteral(qualified), value, called))
+		if deptype == "a" {
+			info.PostData = append(info.PostData, fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s.%s", qualified, ownerTable, quoteIdent(column)))
+		}
+		sequences = append(sequences, "DROP SEQUENCE IF EXISTS "+qualified+" CASCADE")
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading sequences: %w", err)
+	}
+
+	if whole {
+		err := query(ctx, tx, `SELECT n.nspname, p.proname, pg_get_function_identity_arguments(p.oid), p.prokind::text, pg_get_functiondef(p.oid)
+			FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE p.prokind IN ('f', 'p') AND n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+			  AND NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.classid = 'pg_proc'::regclass AND e.objid = p.oid AND e.deptype = 'e')
+			ORDER BY 1, 2, 3`, nil, func(scan func(...any) error) error {
+			var schema, name, args, kind, def string
+			if err := scan(&schema, &name, &args, &kind, &def); err != nil {
+				return err
+			}
+			info.PreData = append(info.PreData, strings.TrimSpace(def))
+			object := "FUNCTION"
+			if kind == "p" {
+				object = "PROCEDURE"
+			}
+			functions = append(functions, fmt.Sprintf("DROP %s IF EXISTS %s.%s(%s) CASCADE", object, quoteIdent(schema), quoteIdent(name), args))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading functions: %w", err)
+		}
+	}
+
+	var dropTables []string
+	for _, t := range tables {
+		nt := nativeTable{Schema: t.Schema, Name: t.Name, Size: t.Size}
+		var defs []string
+		err := query(ctx, tx, `SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+				COALESCE(pg_get_expr(ad.adbin, ad.adrelid), ''), a.attidentity::text, a.attgenerated::text,
+				COALESCE((SELECT quote_ident(cn.nspname) || '.' || quote_ident(co.collname)
+				          FROM pg_collation co JOIN pg_namespace cn ON cn.oid = co.collnamespace
+				          WHERE co.oid = a.attcollation AND a.attcollation <> ty.typcollation), '')
+			FROM pg_attribute a
+			JOIN pg_type ty ON ty.oid = a.atttypid
+			LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+			WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+			ORDER BY a.attnum`, []any{nt.qualified()}, func(scan func(...any) error) error {
+			var c nativeColumn
+			if err := scan(&c.Name, &c.Type, &c.NotNull, &c.Default, &c.Identity, &c.Generated, &c.Collation); err != nil {
+				return err
+			}
+			defs = append(defs, c.definition())
+			if c.Generated == "" {
+				nt.Columns = append(nt.Columns, c.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading the columns of %s: %w", nt.qualified(), err)
+		}
+		info.PreData = append(info.PreData, createTable(nt.qualified(), defs))
+		info.Tables = append(info.Tables, nt)
+		dropTables = append(dropTables, "DROP TABLE IF EXISTS "+nt.qualified()+" CASCADE")
+	}
+
+	// Foreign keys last, once the keys they reference exist
+	var foreignKeys []string
+	err = query(ctx, tx, `SELECT n.nspname, c.relname, co.conname, co.contype::text, pg_get_constraintdef(co.oid)
+		FROM pg_constraint co JOIN pg_class c ON c.oid = co.conrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE co.contype IN ('p', 'u', 'c', 'f', 'x') AND co.conislocal AND c.relkind = 'r'
+		ORDER BY 1, 2, 3`, nil, func(scan func(...any) error) error {
+		var schema, table, name, kind, def string
+		if err := scan(&schema, &table, &name, &kind, &def); err != nil {
+			return err
+		}
+		if !dumped[schema+"."+table] {
+			return nil
+		}
+		statement := fmt.Sprintf("ALTER TABLE ONLY %s.%s ADD CONSTRAINT %s %s", quoteIdent(schema), quoteIdent(table), quoteIdent(name), def)
+		if kind == "f" {
+			foreignKeys = append(foreignKeys, statement)
+		} else {
+			info.PostData = append(info.PostData, statement)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading constraints: %w", err)
+	}
+	err = query(ctx, tx, `SELECT n.nspname, c.relname, pg_get_indexdef(i.indexrelid)
+		FROM pg_index i JOIN pg_class c ON c.oid = i.indrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'r'
+		  AND NOT EXISTS (SELECT 1 FROM pg_constraint co WHERE co.conindid = i.indexrelid AND co.contype IN ('p', 'u', 'x'))
+		ORDER BY 1, 2, i.indexrelid`, nil, func(scan func(...any) error) error {
+		var schema, table, def string
+		if err := scan(&schema, &table, &def); err != nil {
+			return err
+		}
+		if dumped[schema+"."+table] {
+			info.PostData = append(info.PostData, def)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading indexes: %w", err)
+	}
+	info.PostData = append(info.PostData, foreignKeys...)
+
+	// Views in creation order, which puts them after those they select from
+	if whole {
+		err := query(ctx, tx, `SELECT n.nspname, c.relname, pg_get_viewdef(c.oid)
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind = 'v' AND n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+			  AND NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.classid = 'pg_class'::regclass AND e.objid = c.oid AND e.deptype = 'e')
+			ORDER BY c.oid`, nil, func(scan func(...any) error) error {
+			var schema, name, def string
+			if err := scan(&schema, &name, &def); err != nil {
+				return err
+			}
+			qualified := quoteIdent(schema) + "." + quoteIdent(name)
+			info.PostData = append(info.PostData, "CREATE VIEW "+qualified+" AS\n"+strings.TrimSuffix(strings.TrimSpace(def), ";"))
+			views = append(views, "DROP VIEW IF EXISTS "+qualified+" CASCADE")
+			dumped[schema+"."+name] = true
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading views: %w", err)
+		}
+	}
+	err = query(ctx, tx, `SELECT n.nspname, c.relname, pg_get_triggerdef(t.oid)
+		FROM pg_trigger t JOIN pg_class c ON c.oid = t.tgrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE NOT t.tgisinternal
+		ORDER BY 1, 2, t.tgname`, nil, func(scan func(...any) error) error {
+		var schema, table, def string
+		if err := scan(&schema, &table, &def); err != nil {
+			return err
+		}
+		if dumped[schema+"."+table] {
+			info.PostData = append(info.PostData, def)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading triggers: %w", err)
+	}
+
+	for _, drops := range [][]string{views, dropTables, sequences, functions, types} {
+		info.Drop = append(info.Drop, drops...)
+	}
+	return info, nil
+}
+
+// checkNative fails for the objects of a dump nativeSchema cannot rebuild:
+// partitioned, inherited, foreign tables and materialized views among
+// those dumped, and with the whole database domains, composite and range
+// types and aggregates
+func checkNative(ctx context.Context, tx *sql.Tx, opts *database.BackupOptions, dumped map[string]bool, whole bool) error {
+	var unsupported []string
+	err := query(ctx, tx, `SELECT n.nspname, c.relname, c.relkind::text,
+			c.relispartition OR EXISTS (SELECT 1 FROM pg_inherits i WHERE i.inhrelid = c.oid OR i.inhparent = c.oid)
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'm', 'f') AND n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.classid = 'pg_class'::regclass AND e.objid = c.oid AND e.deptype = 'e')
+		ORDER BY 1, 2`, nil, func(scan func(...any) error) error {
+		var t dumpTable
+		var kind string
+		var inherited bool
+		if err := scan(&t.Schema, &t.Name, &kind, &inherited); err != nil {
+			return err
+		}
+		if kind == "r" && !inherited {
+			return nil
+		}
+		if dumped[t.Schema+"."+t.Name] || selected(t, opts.Tables, true) && !selected(t, opts.ExcludeTables, false) {
+			unsupported = append(unsupported, t.Schema+"."+t.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("listing tables: %w", err)
+	}
+	if whole {
+		err := query(ctx, tx, `SELECT format_type(t.oid, NULL) FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+			WHERE (t.typtype IN ('d', 'r') OR t.typtype = 'c' AND (SELECT c.relkind FROM pg_class c WHERE c.oid = t.typrelid) = 'c')
+			  AND n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+			  AND NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.classid = 'pg_type'::regclass AND e.objid = t.oid AND e.deptype = 'e')
+			UNION ALL
+			SELECT p.oid::regprocedure::text FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE p.prokind = 'a' AND n.nspname <> 'information_schema' AND n.nspname !~ '^pg_'
+			  AND NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.classid = 'pg_proc'::regclass AND e.objid = p.oid AND e.deptype = 'e')
+			ORDER BY 1`, nil, func(scan func(...any) error) error {
+			var name string
+			if err := scan(&name); err != nil {
+				return err
+			}
+			unsupported = append(unsupported, name)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("listing types: %w", err)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%s cannot be dumped without pg_dump: install it, or leave the tables out", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// query runs a catalog query in tx, calling fn for each row with the
+// function scanning it
+func query(ctx context.Context, tx *sql.Tx, q string, args []any, fn func(scan func(...any) error) error) error {
+	rows, err := tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// createTable returns the CREATE TABLE statement of table with the column
+// definitions defs
+func createTable(table string, defs []string) string {
+	return "CREATE TABLE " + table + " (\n    " + strings.Join(defs, ",\n    ") + "\n)"
+}
+
+// quoteLiteral quotes s as an SQL string literal
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// copyOut writes the rows of t to path in the text format of COPY. Every
+// value is read as text, the form COPY writes and reads.
+func copyOut(ctx context.Context, tx *sql.Tx, t *nativeTable, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hw := stream.NewHashWriter(f)
+	w := bufio.NewWriterSize(hw, stream.Default().BufferSize())
+
+	// A table without columns to load keeps an empty file
+	if len(t.Columns) > 0 {
+		cols := make([]string, len(t.Columns))
+		for i, c := range t.Columns {
+			cols[i] = quoteIdent(c) + "::text"
+		}
+		rows, err := tx.QueryContext(ctx, "SELECT "+strings.Join(cols, ", ")+" FROM ONLY "+t.qualified())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		values := make([]sql.RawBytes, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				return err
+			}
+			database.WriteCopyRow(w, values)
+			t.Rows++
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	t.Checksum = hw.Sum()
+	return f.Close()
+}
+
+// copyIn loads the rows of t from path with COPY FROM STDIN in one
+// transaction, checking them against the description of the dump
+func copyIn(ctx context.Context, db *sql.DB, t nativeTable, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hw := stream.NewHashWriter(nil)
+	r := bufio.NewReaderSize(io.TeeReader(f, hw), stream.Default().BufferSize())
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var rows int64
+	if len(t.Columns) > 0 {
+		stmt, err := tx.PrepareContext(ctx, pq.CopyInSchema(t.Schema, t.Name, t.Columns...))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		args := make([]any, len(t.Columns))
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(line) > 0 {
+				values, perr := database.ParseCopyRow(line)
+				if perr == nil && len(values) != len(args) {
+					perr = fmt.Errorf("%d values for %d columns", len(values), len(args))
+				}
+				if perr != nil {
+					return fmt.Errorf("row %d: %w", rows+1, perr)
+				}
+				for i, v := range values {
+					args[i] = nil
+					if v != nil {
+						args[i] = string(v)
+					}
+				}
+				if _, err := stmt.ExecContext(ctx, args...); err != nil {
+					return err
+				}
+				rows++
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			return err
+		}
+	} else if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	if rows != t.Rows || hw.Sum() != t.Checksum {
+		return fmt.Errorf("data does not match the dump (%d rows read, %d expected)", rows, t.Rows)
+	}
+	return tx.Commit()
+}
+
+// packNativeDump archives the table data under dir after the description
+// of the dump
+func packNativeDump(ctx context.Context, dir string, info *nativeInfo, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: nativeInfoFile, Mode: 0o600, Size: int64(len(data)), ModTime: info.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for _, t := range info.Tables {
+		if err := addFile(ctx, tw, filepath.Join(dir, t.File), t.File); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// isNativeDump reports whether r starts with a dump taken without
+// pg_dump, without consuming it
+func isNativeDump(r *bufio.Reader) (bool, error) {
+	name, err := firstEntry(r)
+	return name == nativeInfoFile, err
+}
+
+// isNativeDumpFile reports whether the file at path is a dump taken
+// without pg_dump
+func isNativeDumpFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isNativeDump(bufio.NewReader(f))
+}
+
+// checkNativeRestore checks opts can be honored by a dump taken without
+// pg_dump
+func checkNativeRestore(opts *database.RestoreOptions) error {
+	if len(opts.Tables) > 0 {
+		return errors.New("dumps taken without pg_dump are restored whole, tables cannot be chosen")
+	}
+	if opts.Database != "" {
+		if err := validation.ValidateDatabaseName(opts.Database); err != nil {
+			return fmt.Errorf("invalid database name %q: %w", opts.Database, err)
+		}
+	}
+	return nil
+}
+
+// restoreNativeDump restores the dump taken without pg_dump read from r,
+// without the client tools either: the definitions, then the data of the
+// tables on opts.Parallel workers, largest first, then the constraints,
+// indexes, views and triggers. opts.DropExisting drops the dumped objects
+// first.
+func (d *PostgreSQLDriver) restoreNativeDump(ctx context.Context, opts *database.RestoreOptions, r io.Reader) error {
+	if err := checkNativeRestore(opts); err != nil {
+		return err
+	}
+	work, err := os.MkdirTemp("", "pg_native-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	info, err := extractNativeDump(ctx, r, work)
+	if err != nil {
+		return err
+	}
+
+	db, closeDB, err := d.databaseDB(opts.Database)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	run := func(statements []string) error {
+		for _, statement := range statements {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				first, _, _ := strings.Cut(statement, "\n")
+				return fmt.Errorf("%s: %w", first, err)
+			}
+		}
+		return nil
+	}
+
+	if opts.DropExisting {
+		if err := run(info.Drop); err != nil {
+			return err
+		}
+	}
+	if err := run(info.PreData); err != nil {
+		return err
+	}
+	err = database.LargestFirst(ctx, info.Tables, nativeTable.size, max(1, opts.Parallel), func(ctx context.Context, t nativeTable) error {
+		if err := copyIn(ctx, db, t, filepath.Join(work, t.File)); err != nil {
+			return fmt.Errorf("loading %s: %w", t.qualified(), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return run(info.PostData)
+}
+
+// extractNativeDump extracts the table data of the dump taken without
+// pg_dump read from r into dir and returns its description
+func extractNativeDump(ctx context.Context, r io.Reader, dir string) (*nativeInfo, error) {
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil || header.Name != nativeInfoFile {
+		return nil, errors.New("not a dump archive taken without pg_dump")
+	}
+	var info nativeInfo
+	if err := json.NewDecoder(io.LimitReader(tr, 256<<20)).Decode(&info); err != nil || info.Format != nativeFormat {
+		return nil, errors.New("not a dump archive taken without pg_dump")
+	}
+	if err := extractEntries(ctx, tr, dir); err != nil {
+		return nil, fmt.Errorf("extracting the dump: %w", err)
+	}
+	return &info, nil
+}
+
+// restoreNativeDumpFile restores the dump taken without pg_dump in
+// opts.SourceBackup
+func (d *PostgreSQLDriver) restoreNativeDumpFile(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.restoreNativeDump(ctx, opts, f)
+}
@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestNativeColumnDefinition(t *testing.T) {
+	for c, want := range map[nativeColumn]string{
+		{Name: "id", Type: "bigint", NotNull: true, Identity: "a"}:                                    `"id" bigint GENERATED ALWAYS AS IDENTITY`,
+		{Name: "total", Type: "numeric(12,2)", NotNull: true, Default: "0"}:                           `"total" numeric(12,2) DEFAULT 0 NOT NULL`,
+		{Name: "Name", Type: "text", Collation: `pg_catalog."C"`}:                                     `"Name" text COLLATE pg_catalog."C"`,
+		{Name: "net", Type: "numeric", Default: "(total - tax)", Generated: "s"}:                      `"net" numeric GENERATED ALWAYS AS ((total - tax)) STORED`,
+		{Name: "seq", Type: "integer", NotNull: true, Default: "nextval('s'::regclass)"}:              `"seq" integer DEFAULT nextval('s'::regclass) NOT NULL`,
+		{Name: "ref", Type: "integer", NotNull: true, Identity: "d", Default: "ignored for identity"}: `"ref" integer GENERATED BY DEFAULT AS IDENTITY`,
+	} {
+		if got := c.definition(); got != want {
+			t.Errorf("definition = %s, want %s", got, want)
+		}
+	}
+	if got := createTable(`public.orders`, []string{"id bigint", "total numeric"}); got != "CREATE TABLE public.orders (\n    id bigint,\n    total numeric\n)" {
+		t.Errorf("create table = %q", got)
+	}
+	if got := quoteLiteral(`public."O'Brien"`); got != `'public."O''Brien"'` {
+		t.Errorf("literal = %s", got)
+	}
+}
+
+func TestNativeDumpRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	work := t.TempDir()
+	os.MkdirAll(filepath.Join(work, "data"), 0o700)
+	files := map[string]string{
+		"data/0001.copy": "1\t9.50\n2\t\\N\n",
+		"data/0002.copy": "",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(work, filepath.FromSlash(name)), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info := &nativeInfo{
+		Format:   nativeFormat,
+		Database: "shop",
+		Drop:     []string{"DROP TABLE IF EXISTS public.orders CASCADE"},
+		PreData:  []string{"SET check_function_bodies = false", "CREATE TABLE public.orders (\n    id bigint,\n    total numeric\n)"},
+		Tables: []nativeTable{
+			{Schema: "public", Name: "orders", Columns: []string{"id", "total"}, Size: 8192, File: "data/0001.copy", Rows: 2},
+			{Schema: "public", Name: "empty", File: "data/0002.copy"},
+		},
+		PostData:  []string{"ALTER TABLE ONLY public.orders ADD CONSTRAINT orders_pkey PRIMARY KEY (id)"},
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	var archive bytes.Buffer
+	if err := packNativeDump(ctx, work, info, &archive); err != nil {
+		t.Fatal(err)
+	}
+	if native, err := isNativeDump(bufio.NewReader(bytes.NewReader(archive.Bytes()))); err != nil || !native {
+		t.Fatalf("isNativeDump = %v, %v", native, err)
+	}
+	if directory, _ := isDirectoryDump(bufio.NewReader(bytes.NewReader(archive.Bytes()))); directory {
+		t.Error("taken for a directory-format dump")
+	}
+
+	out := t.TempDir()
+	got, err := extractNativeDump(ctx, bytes.NewReader(archive.Bytes()), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, info) {
+		t.Errorf("info = %+v, want %+v", got, info)
+	}
+	for name, want := range files {
+		data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+
+	var other bytes.Buffer
+	packDirectoryDump(ctx, t.TempDir(), &dumpInfo{Format: dumpFormat}, &other)
+	if _, err := extractNativeDump(ctx, &other, t.TempDir()); err == nil {
+		t.Error("extracted a directory-format dump as a native one")
+	}
+}
+
+func TestNativeDump(t *testing.T) {
+	d := &PostgreSQLDriver{config: &database.ConnectionConfig{Options: map[string]string{"dump_format": FormatNative}}}
+	if !d.nativeDump() {
+		t.Error("dump_format native uses pg_dump")
+	}
+	d.config.Options["dump_format"] = FormatCustom
+	if d.nativeDump() {
+		t.Error("dump_format custom dumps without pg_dump")
+	}
+	d.config.Options["dump_format"] = ""
+	t.Setenv("PATH", t.TempDir())
+	if !d.nativeDump() {
+		t.Error("used pg_dump, which is not installed")
+	}
+
+	if err := checkNativeRestore(&database.RestoreOptions{Tables: []string{"orders"}}); err == nil {
+		t.Error("accepted a table selection")
+	}
+	if err := checkNativeRestore(&database.RestoreOptions{Database: "shop; DROP"}); err == nil {
+		t.Error("accepted an invalid database name")
+	}
+}
@@ -0,0 +1,39 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// retarget returns opts restoring into opts.TargetDatabase, created when
+// missing. Dumps of one database are taken without --create and name no
+// database, so they are restored under another name as they are.
+func (d *PostgreSQLDriver) retarget(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreOptions, error) {
+	if err := d.ensureDatabase(ctx, opts.TargetDatabase); err != nil {
+		return nil, err
+	}
+	retargeted := *opts
+	retargeted.Database = opts.TargetDatabase
+	return &retargeted, nil
+}
+
+// ensureDatabase creates the database name unless it exists
+func (d *PostgreSQLDriver) ensureDatabase(ctx context.Context, name string) error {
+	if err := validation.ValidateDatabaseName(name); err != nil {
+		return fmt.Errorf("invalid database name %q: %w", name, err)
+	}
+	var exists bool
+	if err := d.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", name).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if _, err := d.db.ExecContext(ctx, "CREATE DATABASE "+quoteIdent(name)); err != nil {
+		return fmt.Errorf("creating database %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package postgres
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// DumpSchema writes the schema of the databases of opts to w, with
+// pg_dump --schema-only, or read from the catalogs when dumps are taken
+// without pg_dump
+func (d *PostgreSQLDriver) DumpSchema(ctx context.Context, opts *database.BackupOptions, w io.Writer) error {
+	names := []string{opts.Database}
+	if multiDatabase(opts) {
+		names = opts.Databases
+		if opts.AllDatabases {
+			all, err := d.GetDatabases(ctx)
+			if err != nil {
+				return fmt.Errorf("listing databases: %w", err)
+			}
+			names = all
+		}
+	}
+	for _, table := range append(append([]string{}, opts.Tables...), opts.ExcludeTables...) {
+		if err := validation.ValidateTableName(table); err != nil {
+			return fmt.Errorf("invalid table name %q: %w", table, err)
+		}
+	}
+
+	native := d.nativeDump()
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		if name == "" {
+			name = d.config.Database
+		}
+		if err := validation.ValidateDatabaseName(name); err != nil {
+			return fmt.Errorf("invalid database name %q: %w", name, err)
+		}
+		if len(names) > 1 {
+			fmt.Fprintf(bw, "\n--\n-- Database %s\n--\n", name)
+		}
+		dbOpts := *opts
+		dbOpts.Database = name
+		var err error
+		if native {
+			err = d.nativeSchemaSQL(ctx, &dbOpts, bw)
+		} else {
+			err = d.pgDumpSchema(ctx, &dbOpts, bw)
+		}
+		if err != nil {
+			return fmt.Errorf("dumping the schema of %s: %w", name, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// pgDumpSchema writes the output of pg_dump --schema-only to w, less the
+// \restrict lines, whose key changes with every run
+func (d *PostgreSQLDriver) pgDumpSchema(ctx context.Context, opts *database.BackupOptions, w io.Writer) error {
+	dir, err := os.MkdirTemp("", "pg_schema-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "schema.sql")
+	args := []string{
+		"-h", d.host(),
+		"-p", d.port(),
+		"-U", d.config.Username,
+		"-w",
+		"--schema-only",
+		"--no-owner",
+		"--no-acl",
+		"-f", path,
+	}
+	args = append(append(args, tableArgs(opts)...), opts.Database)
+	if err := d.runTool(ctx, "pg_dump", args); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if !strings.HasPrefix(line, `\restrict `) && !strings.HasPrefix(line, `\unrestrict `) {
+			if _, werr := io.WriteString(w, line); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// nativeSchemaSQL writes the definitions a dump taken without pg_dump
+// holds, before and after its data, to w as SQL
+func (d *PostgreSQLDriver) nativeSchemaSQL(ctx context.Context, opts *database.BackupOptions, w io.Writer) error {
+	db, closeDB, err := d.databaseDB(opts.Database)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+	snap, err := chunkDialect{}.Snapshot(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+	tx, err := snap.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	info, err := nativeSchema(ctx, tx, opts)
+	if err != nil {
+		return err
+	}
+	for _, statement := range append(info.PreData, info.PostData...) {
+		if strings.HasPrefix(statement, "SELECT pg_catalog.setval(") {
+			continue // sequence values are data
+		}
+		if _, err := fmt.Fprintf(w, "\n%s;\n", statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
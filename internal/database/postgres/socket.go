@@ -0,0 +1,40 @@
+package postgres
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// socketFile matches the name of a PostgreSQL socket file, which ends in
+// the port the server listens on
+var socketFile = regexp.MustCompile(`^\.s\.PGSQL\.(\d+)$`)
+
+// hostPort returns the host and port to connect to. libpq takes a unix
+// socket as the directory holding it plus the port, so a socket file is
+// split into the two.
+func hostPort(config *database.ConnectionConfig) (string, int) {
+	socket := config.UnixSocket()
+	if socket == "" {
+		return config.Host, config.Port
+	}
+	if m := socketFile.FindStringSubmatch(filepath.Base(socket)); m != nil {
+		port, _ := strconv.Atoi(m[1])
+		return filepath.Dir(socket), port
+	}
+	return socket, config.Port
+}
+
+// host returns the -h argument of the client tools
+func (d *PostgreSQLDriver) host() string {
+	host, _ := hostPort(d.config)
+	return host
+}
+
+// port returns the -p argument of the client tools
+func (d *PostgreSQLDriver) port() string {
+	_, port := hostPort(d.config)
+	return strconv.Itoa(port)
+}
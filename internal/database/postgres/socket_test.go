@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestUnixSocket(t *testing.T) {
+	tests := []struct {
+		host     string
+		wantHost string
+		wantPort string
+	}{
+		{"db-1", "db-1", "5432"},
+		{"/var/run/postgresql", "/var/run/postgresql", "5432"},
+		{"/tmp/.s.PGSQL.5433", "/tmp", "5433"},
+	}
+	for _, tt := range tests {
+		d := NewPostgreSQLDriver()
+		d.config = &database.ConnectionConfig{Host: tt.host, Port: 5432, Username: "backup", Database: "orders"}
+		if d.host() != tt.wantHost || d.port() != tt.wantPort {
+			t.Errorf("%s: host %s, port %s, want %s and %s", tt.host, d.host(), d.port(), tt.wantHost, tt.wantPort)
+		}
+		want := "host=" + tt.wantHost + " port=" + tt.wantPort + " "
+		if connStr := d.buildConnectionString(d.config); !strings.HasPrefix(connStr, want) {
+			t.Errorf("%s: connection string %s", tt.host, connStr)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestConnectionStringTLS(t *testing.T) {
+	d := NewPostgreSQLDriver()
+	config := &database.ConnectionConfig{Host: "db-1", Port: 5432, Username: "backup", Database: "orders"}
+	if connStr := d.buildConnectionString(config); !strings.Contains(connStr, "sslmode=disable") || strings.Contains(connStr, "sslrootcert") {
+		t.Errorf("unencrypted: %s", connStr)
+	}
+
+	config.SSLCA = "/tls/ca.pem"
+	config.SSLCert = "/tls/client.pem"
+	config.SSLKey = `/tls/it's.key`
+	connStr := d.buildConnectionString(config)
+	for _, want := range []string{"sslmode=verify-full", " sslrootcert='/tls/ca.pem'", " sslcert='/tls/client.pem'", ` sslkey='/tls/it\'s.key'`} {
+		if !strings.Contains(connStr, want) {
+			t.Errorf("%s lacks %s", connStr, want)
+		}
+	}
+
+	config.SSLMode = "prefer"
+	if connStr := d.buildConnectionString(config); !strings.Contains(connStr, "sslmode=prefer") {
+		t.Errorf("libpq mode not passed on: %s", connStr)
+	}
+}
+
+func TestCommandEnvTLS(t *testing.T) {
+	d := &PostgreSQLDriver{config: &database.ConnectionConfig{Password: "secret"}}
+	if env := d.commandEnv()[len(os.Environ()):]; !slices.Equal(env, []string{"PGPASSWORD=secret"}) {
+		t.Errorf("environment without SSL settings = %q", env)
+	}
+
+	d.config.SSLMode = "require"
+	d.config.SSLCert = "/tls/client.pem"
+	env := d.commandEnv()[len(os.Environ()):]
+	for _, want := range []string{"PGSSLMODE=require", "PGSSLCERT=/tls/client.pem"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("environment lacks %s", want)
+		}
+	}
+	if slices.ContainsFunc(env, func(v string) bool { return strings.HasPrefix(v, "PGSSLROOTCERT=") }) {
+		t.Error("PGSSLROOTCERT set without a CA certificate")
+	}
+}
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/pitr"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+)
+
+// ArchiveLogs streams the WAL of the cluster into an archive with
+// pg_receivewal, for point-in-time restores of physical backups. The slot
+// archive option names the replication slot to stream from.
+func (d *PostgreSQLDriver) ArchiveLogs(ctx context.Context, opts *database.ArchiveOptions) error {
+	if d.db == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	var server string
+	if err := d.db.QueryRowContext(ctx, "SELECT system_identifier FROM pg_control_system()").Scan(&server); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	receiver := &pitr.Receiver{Config: d.config, Server: server, Env: d.commandEnv()}
+	return receiver.Run(ctx, opts)
+}
+
+// recoverPhysical extracts the physical backup opts.SourceBackup into the
+// restore_dir metadata and sets it up to replay the WAL archive in walDir
+// up to opts.PointInTime once a server is started on it. The restore_command
+// metadata replaces the command fetching WAL from the archive.
+func (d *PostgreSQLDriver) recoverPhysical(ctx context.Context, opts *database.RestoreOptions, walDir string) error {
+	if err := checkPhysicalRestore(opts); err != nil {
+		return err
+	}
+	// Refuse before extracting, which leaves the directory in use
+	if err := pitr.CheckTarget(walDir, *opts.PointInTime); err != nil {
+		return err
+	}
+	if err := d.restorePhysicalFile(ctx, opts); err != nil {
+		return err
+	}
+	return pitr.PrepareRecovery(opts.Metadata["restore_dir"], pitr.RecoveryOptions{
+		ArchiveDir:     walDir,
+		Target:         *opts.PointInTime,
+		RestoreCommand: opts.Metadata["restore_command"],
+	})
+}
@@ -0,0 +1,527 @@
+// Package redis provides Redis database driver implementation. Backups are
+// RDB snapshots, which always hold every logical database: by default they
+// are fetched over the replication protocol with `redis-cli --rdb`, which
+// works remotely; the bgsave dump method instead triggers BGSAVE and copies
+// the dump file, for servers that refuse SYNC but whose data directory is
+// reachable. Restores put the snapshot in the server's data directory and
+// reload it, so they run on the Redis host.
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Dump methods, set with the dump_method connection option
+const (
+	DumpMethodSync   = "sync"   // redis-cli --rdb over the replication protocol
+	DumpMethodBGSave = "bgsave" // BGSAVE, then copy the dump file
+)
+
+// bgsavePoll is how often a running BGSAVE is checked
+const bgsavePoll = 500 * time.Millisecond
+
+// rdbHeader matches the magic string and version RDB files start with
+var rdbHeader = regexp.MustCompile(`^REDIS[0-9]{4}$`)
+
+// RedisDriver implements the database.Driver interface for Redis
+type RedisDriver struct {
+	conn   *conn
+	config *database.ConnectionConfig
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeRedis, func() database.Driver {
+		return NewRedisDriver()
+	})
+}
+
+// NewRedisDriver creates a new Redis driver instance
+func NewRedisDriver() *RedisDriver {
+	return &RedisDriver{}
+}
+
+// Connect establishes a connection to the Redis server
+func (d *RedisDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	switch method := config.Options["dump_method"]; method {
+	case "", DumpMethodSync, DumpMethodBGSave:
+	default:
+		return pkgErrors.ErrDatabaseConnection(fmt.Errorf("invalid dump_method %q (must be sync|bgsave)", method))
+	}
+
+	c, err := dial(ctx, config)
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if _, err := c.str(ctx, "PING"); err != nil {
+		c.Close()
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+
+	d.conn = c
+	d.config = config
+	return nil
+}
+
+// Disconnect closes the connection
+func (d *RedisDriver) Disconnect() error {
+	if d.conn != nil {
+		return d.conn.Close()
+	}
+	return nil
+}
+
+// Ping tests the connection
+func (d *RedisDriver) Ping(ctx context.Context) error {
+	if d.conn == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	_, err := d.conn.str(ctx, "PING")
+	return err
+}
+
+// Backup writes an RDB snapshot of the server to opts.OutputPath
+func (d *RedisDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	// Databases are listed before the dump so the result says what it holds
+	tables, _ := d.keyspace(ctx)
+
+	if d.dumpMethod() == DumpMethodBGSave {
+		outputFile, err := os.Create(opts.OutputPath)
+		if err != nil {
+			return fail(err)
+		}
+		output := stream.NewHashWriter(outputFile)
+		err = d.bgsave(ctx, output)
+		if closeErr := outputFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fail(err)
+		}
+		result.Size = output.Written()
+		result.Checksum = output.Sum()
+	} else {
+		cmd := exec.CommandContext(ctx, "redis-cli", append(d.cliArgs(), "--rdb", opts.OutputPath)...)
+		cmd.Env = d.commandEnv()
+
+		// Trace the run; the span records the exit code and dump size
+		run := telemetry.StartCommand(ctx, cmd)
+		defer func() { run.End(result.Error, result.Size) }()
+
+		if output, err := cmd.CombinedOutput(); err != nil {
+			result.Status = database.BackupStatusFailed
+			result.Error = err
+			return result, pkgErrors.ErrDatabaseBackup(err).WithMetadata("stderr", string(output))
+		}
+		sum, size, err := stream.HashFile(ctx, opts.OutputPath)
+		if err != nil {
+			return fail(err)
+		}
+		result.Size = size
+		result.Checksum = sum
+	}
+
+	if err := checkRDB(opts.OutputPath); err != nil {
+		return fail(err)
+	}
+
+	version, _ := d.GetVersion(ctx)
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = version
+	result.Tables = tables
+	result.Status = database.BackupStatusSuccess
+
+	return result, nil
+}
+
+// StreamBackup streams an RDB snapshot to the provided writer. The sync
+// method needs redis-cli 7 or later, which writes snapshots to stdout.
+func (d *RedisDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	if d.dumpMethod() == DumpMethodBGSave {
+		return d.bgsave(ctx, writer)
+	}
+
+	cmd := exec.CommandContext(ctx, "redis-cli", append(d.cliArgs(), "--rdb", "-")...)
+	cmd.Env = d.commandEnv()
+	run := telemetry.StartCommand(ctx, cmd)
+	cmd.Stdout = run.Count(writer)
+
+	err := cmd.Run()
+	run.End(err, run.Counted())
+	return err
+}
+
+// GetBackupSize estimates the size of a backup from the memory used by the
+// dataset; RDB files are usually smaller
+func (d *RedisDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	fields, err := d.conn.info(ctx, "memory")
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(fields["used_memory_dataset"], 10, 64)
+	if err != nil {
+		return strconv.ParseInt(fields["used_memory"], 10, 64)
+	}
+	return size, nil
+}
+
+// Restore replaces the server's whole dataset with an RDB snapshot. The
+// snapshot is moved into the data directory and loaded with DEBUG RELOAD
+// NOSAVE, which Redis 7 only accepts when enable-debug-command allows it.
+func (d *RedisDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+
+	file, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+	defer file.Close()
+
+	if err := d.StreamRestore(ctx, opts, file); err != nil {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err)
+	}
+
+	tables, _ := d.keyspace(ctx)
+	for _, t := range tables {
+		result.RestoredTables = append(result.RestoredTables, t.Name)
+		result.RowsRestored += t.RowCount
+	}
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+
+	return result, nil
+}
+
+// StreamRestore replaces the server's dataset with an RDB snapshot read
+// from reader. Non-empty servers are only overwritten with DropExisting.
+func (d *RedisDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	if opts.Renamed() {
+		return fmt.Errorf("snapshots replace the whole dataset: %w", database.ErrRenameUnsupported)
+	}
+	if !opts.DropExisting {
+		tables, err := d.keyspace(ctx)
+		if err != nil {
+			return err
+		}
+		if len(tables) > 0 {
+			return errors.New("the server holds keys and a snapshot replaces all of them: restore with drop existing to overwrite them")
+		}
+	}
+
+	dir, dbfile, err := d.dataFile(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The snapshot is written next to the dump file and renamed over it,
+	// so the server never sees a partial file
+	tmp, err := os.CreateTemp(dir, "temp-db-backup-*.rdb")
+	if err != nil {
+		return fmt.Errorf("failed to write to the data directory %s: %w", dir, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := checkRDB(tmp.Name()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dbfile); err != nil {
+		return err
+	}
+
+	if _, err := d.conn.str(ctx, "DEBUG", "RELOAD", "NOSAVE"); err != nil {
+		return fmt.Errorf("failed to load %s (%w): allow DEBUG with enable-debug-command local, "+
+			"or stop Redis with SHUTDOWN NOSAVE and start it again to load it", dbfile, err)
+	}
+
+	// The append-only file still holds the old dataset and would win at
+	// the next start
+	if aof, _ := d.conn.configGet(ctx, "appendonly"); aof == "yes" {
+		if _, err := d.conn.str(ctx, "BGREWRITEAOF"); err != nil {
+			return fmt.Errorf("snapshot loaded, but rewriting the append-only file failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *RedisDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	if _, err := os.Stat(opts.SourceBackup); os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err := checkRDB(opts.SourceBackup); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if err := d.Ping(ctx); err != nil {
+		return pkgErrors.ErrValidationFailed("database connection failed")
+	}
+	if _, _, err := d.dataFile(ctx); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	return nil
+}
+
+// GetDatabases returns the logical database indexes, "0" to "15" with the
+// default configuration. Servers that refuse CONFIG report those with keys.
+func (d *RedisDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	if value, err := d.conn.configGet(ctx, "databases"); err == nil {
+		n, err := strconv.Atoi(value)
+		if err == nil && n > 0 {
+			databases := make([]string, n)
+			for i := range databases {
+				databases[i] = strconv.Itoa(i)
+			}
+			return databases, nil
+		}
+	}
+
+	tables, err := d.keyspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	databases := make([]string, len(tables))
+	for i, t := range tables {
+		databases[i] = strings.TrimPrefix(t.Name, "db")
+	}
+	return databases, nil
+}
+
+// GetTables returns nothing: Redis databases hold keys, not tables
+func (d *RedisDriver) GetTables(ctx context.Context, database string) ([]string, error) {
+	return nil, nil
+}
+
+// GetTableSize is not supported, Redis has no tables
+func (d *RedisDriver) GetTableSize(ctx context.Context, database, table string) (int64, error) {
+	return 0, pkgErrors.New(pkgErrors.ErrorTypeDatabase, "redis has no tables")
+}
+
+// GetVersion returns the Redis server version
+func (d *RedisDriver) GetVersion(ctx context.Context) (string, error) {
+	fields, err := d.conn.info(ctx, "server")
+	if err != nil {
+		return "", err
+	}
+	return fields["redis_version"], nil
+}
+
+// GetType returns the database type
+func (d *RedisDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeRedis
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *RedisDriver) SupportsIncremental() bool {
+	return false // RDB snapshots are always full
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *RedisDriver) SupportsPITR() bool {
+	return false
+}
+
+// dumpMethod returns the configured dump method
+func (d *RedisDriver) dumpMethod() string {
+	if d.config.Options["dump_method"] == DumpMethodBGSave {
+		return DumpMethodBGSave
+	}
+	return DumpMethodSync
+}
+
+// cliArgs builds the redis-cli connection arguments. The password is
+// passed in the environment, see commandEnv.
+func (d *RedisDriver) cliArgs() []string {
+	args := []string{"-h", d.config.Host, "-p", strconv.Itoa(d.config.Port)}
+	if d.config.Username != "" {
+		args = append(args, "--user", d.config.Username)
+	}
+	if mode := d.config.TLSMode(); mode != database.SSLDisable {
+		args = append(args, "--tls")
+		if mode == database.SSLRequire {
+			args = append(args, "--insecure")
+		}
+		if d.config.SSLCA != "" {
+			args = append(args, "--cacert", d.config.SSLCA)
+		}
+		if d.config.SSLCert != "" {
+			args = append(args, "--cert", d.config.SSLCert)
+			key := d.config.SSLKey
+			if key == "" {
+				key = d.config.SSLCert
+			}
+			args = append(args, "--key", key)
+		}
+		args = append(args, "--sni", d.config.Host)
+	}
+	return args
+}
+
+// commandEnv keeps the password out of the process list
+func (d *RedisDriver) commandEnv() []string {
+	env := os.Environ()
+	if d.config.Password != "" {
+		env = append(env, "REDISCLI_AUTH="+d.config.Password)
+	}
+	return env
+}
+
+// bgsave triggers BGSAVE, waits for it and copies the dump file to w
+func (d *RedisDriver) bgsave(ctx context.Context, w io.Writer) error {
+	_, dbfile, err := d.dataFile(ctx)
+	if err != nil {
+		return err
+	}
+
+	// A save already running may predate the backup, so it is waited for
+	// and a new one started
+	for {
+		_, err := d.conn.str(ctx, "BGSAVE")
+		if err == nil {
+			break
+		}
+		var replyErr Error
+		if !errors.As(err, &replyErr) || !strings.Contains(string(replyErr), "in progress") {
+			return fmt.Errorf("BGSAVE failed: %w", err)
+		}
+		if _, err := d.waitSave(ctx); err != nil {
+			return err
+		}
+	}
+	status, err := d.waitSave(ctx)
+	if err != nil {
+		return err
+	}
+	if status != "ok" {
+		return fmt.Errorf("BGSAVE failed: rdb_last_bgsave_status is %s, see the Redis log", status)
+	}
+
+	f, err := os.Open(dbfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// waitSave waits until no BGSAVE runs and returns the status of the last
+func (d *RedisDriver) waitSave(ctx context.Context) (string, error) {
+	ticker := time.NewTicker(bgsavePoll)
+	defer ticker.Stop()
+	for {
+		fields, err := d.conn.info(ctx, "persistence")
+		if err != nil {
+			return "", err
+		}
+		if fields["rdb_bgsave_in_progress"] == "0" {
+			return fields["rdb_last_bgsave_status"], nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// dataFile returns the server's data directory and RDB file, which must be
+// reachable from here
+func (d *RedisDriver) dataFile(ctx context.Context) (dir, file string, err error) {
+	if dir, err = d.conn.configGet(ctx, "dir"); err != nil {
+		return "", "", fmt.Errorf("failed to read the data directory: %w", err)
+	}
+	name, err := d.conn.configGet(ctx, "dbfilename")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read the dump file name: %w", err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", "", fmt.Errorf("the data directory %s is not reachable from this host: run db-backup on the Redis host", dir)
+	}
+	return dir, filepath.Join(dir, name), nil
+}
+
+// keyspace returns the logical databases holding keys as tables named db0,
+// db1, ... with their key counts
+func (d *RedisDriver) keyspace(ctx context.Context) ([]database.TableInfo, error) {
+	fields, err := d.conn.info(ctx, "keyspace")
+	if err != nil {
+		return nil, err
+	}
+	var tables []database.TableInfo
+	for name, value := range fields {
+		if !strings.HasPrefix(name, "db") {
+			continue
+		}
+		// keys=12,expires=0,avg_ttl=0
+		info := database.TableInfo{Name: name}
+		for _, kv := range strings.Split(value, ",") {
+			if k, v, ok := strings.Cut(kv, "="); ok && k == "keys" {
+				info.RowCount, _ = strconv.ParseInt(v, 10, 64)
+			}
+		}
+		tables = append(tables, info)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimPrefix(tables[i].Name, "db"))
+		b, _ := strconv.Atoi(strings.TrimPrefix(tables[j].Name, "db"))
+		return a < b
+	})
+	return tables, nil
+}
+
+// checkRDB verifies a file starts like an RDB snapshot
+func checkRDB(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(f, header); err != nil || !rdbHeader.Match(header) {
+		return fmt.Errorf("%s is not an RDB snapshot", path)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := map[string]any{
+		"+OK\r\n":                            "OK",
+		":42\r\n":                            int64(42),
+		"$5\r\nhello\r\n":                    "hello",
+		"$-1\r\n":                            nil,
+		"*2\r\n$3\r\ndir\r\n$5\r\n/data\r\n": []any{"dir", "/data"},
+	}
+	for in, want := range tests {
+		got, err := readReply(bufio.NewReader(strings.NewReader(in)))
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%q = %#v, want %#v", in, got, want)
+		}
+	}
+
+	_, err := readReply(bufio.NewReader(strings.NewReader("-NOAUTH Authentication required.\r\n")))
+	var replyErr Error
+	if !errors.As(err, &replyErr) || !strings.HasPrefix(string(replyErr), "NOAUTH") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// fakeRedis answers commands from a table of replies, keyed by the
+// command line joined with spaces
+func fakeRedis(t *testing.T, replies map[string]string) *database.ConnectionConfig {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					reply, err := readReply(r)
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, a := range reply.([]any) {
+						args = append(args, a.(string))
+					}
+					out, ok := replies[strings.Join(args, " ")]
+					if !ok {
+						out = "-ERR unknown command\r\n"
+					}
+					c.Write([]byte(out))
+				}
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &database.ConnectionConfig{Host: host, Port: p, Username: "backup", Password: "s3cret"}
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func TestDriverMetadata(t *testing.T) {
+	info := "# Keyspace\r\ndb10:keys=3,expires=0,avg_ttl=0\r\ndb0:keys=12,expires=1,avg_ttl=0\r\n"
+	config := fakeRedis(t, map[string]string{
+		"AUTH backup s3cret":   "+OK\r\n",
+		"PING":                 "+PONG\r\n",
+		"CONFIG GET databases": "*2\r\n" + bulk("databases") + bulk("3"),
+		"INFO keyspace":        bulk(info),
+		"INFO server":          bulk("# Server\r\nredis_version:7.2.4\r\n"),
+		"INFO memory":          bulk("used_memory:2048\r\nused_memory_dataset:1024\r\n"),
+	})
+
+	ctx := context.Background()
+	d := NewRedisDriver()
+	if err := d.Connect(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Disconnect()
+
+	databases, err := d.GetDatabases(ctx)
+	if err != nil || strings.Join(databases, ",") != "0,1,2" {
+		t.Errorf("databases = %v, %v", databases, err)
+	}
+	tables, err := d.keyspace(ctx)
+	if err != nil || len(tables) != 2 || tables[0].Name != "db0" || tables[0].RowCount != 12 || tables[1].Name != "db10" {
+		t.Errorf("keyspace = %+v, %v", tables, err)
+	}
+	if version, _ := d.GetVersion(ctx); version != "7.2.4" {
+		t.Errorf("version = %q", version)
+	}
+	if size, _ := d.GetBackupSize(ctx, &database.BackupOptions{}); size != 1024 {
+		t.Errorf("size = %d", size)
+	}
+
+	// Restores never silently replace a server holding keys
+	err = d.StreamRestore(ctx, &database.RestoreOptions{}, strings.NewReader("REDIS0011"))
+	if err == nil || !strings.Contains(err.Error(), "drop existing") {
+		t.Errorf("restore over keys: %v", err)
+	}
+}
+
+func TestConnectRejectsWrongPassword(t *testing.T) {
+	config := fakeRedis(t, map[string]string{
+		"AUTH backup s3cret": "-WRONGPASS invalid username-password pair\r\n",
+	})
+	if err := NewRedisDriver().Connect(context.Background(), config); err == nil {
+		t.Fatal("connected with a wrong password")
+	}
+}
+
+func TestCheckRDB(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "dump.rdb")
+	os.WriteFile(good, []byte("REDIS0011\xfa\x09redis-ver"), 0600)
+	if err := checkRDB(good); err != nil {
+		t.Error(err)
+	}
+	bad := filepath.Join(dir, "dump.sql")
+	os.WriteFile(bad, []byte("-- PostgreSQL database dump"), 0600)
+	if err := checkRDB(bad); err == nil {
+		t.Error("accepted a SQL dump")
+	}
+}
+
+func TestCLIArgs(t *testing.T) {
+	d := &RedisDriver{config: &database.ConnectionConfig{
+		Host: "cache-1", Port: 6380, Username: "backup", Password: "s3cret",
+		SSLMode: "verify-full", SSLCA: "/etc/ssl/redis-ca.pem", SSLCert: "/etc/ssl/backup.pem",
+	}}
+	got := strings.Join(d.cliArgs(), " ")
+	want := "-h cache-1 -p 6380 --user backup --tls --cacert /etc/ssl/redis-ca.pem --cert /etc/ssl/backup.pem --key /etc/ssl/backup.pem --sni cache-1"
+	if got != want {
+		t.Errorf("args = %s, want %s", got, want)
+	}
+	if strings.Contains(got, "s3cret") {
+		t.Error("password in the arguments")
+	}
+
+	d.config = &database.ConnectionConfig{Host: "cache-1", Port: 6380, SSLMode: "require"}
+	got = strings.Join(d.cliArgs(), " ")
+	if want := "-h cache-1 -p 6380 --tls --insecure --sni cache-1"; got != want {
+		t.Errorf("args = %s, want %s", got, want)
+	}
+}
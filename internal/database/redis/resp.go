@@ -0,0 +1,217 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// Error is an error reply from the server, e.g. "NOAUTH Authentication
+// required."
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// conn is a connection speaking RESP2, enough of the protocol for the
+// administrative commands the driver sends. Commands are serialized.
+type conn struct {
+	mu sync.Mutex
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// dial connects and authenticates with the connection settings
+func dial(ctx context.Context, config *database.ConnectionConfig) (*conn, error) {
+	timeout := config.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+
+	tlsConfig, err := config.ClientTLS()
+	if err != nil {
+		return nil, err
+	}
+	var nc net.Conn
+	if tlsConfig != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{nc: nc, r: bufio.NewReader(nc)}
+	if config.Password != "" {
+		args := []string{"AUTH", config.Password}
+		if config.Username != "" {
+			args = []string{"AUTH", config.Username, config.Password}
+		}
+		if _, err := c.do(ctx, args...); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// Close closes the connection
+func (c *conn) Close() error {
+	return c.nc.Close()
+}
+
+// do sends a command and reads its reply: a string for simple and bulk
+// strings, nil for null replies, int64 for integers and []any for arrays.
+// Error replies are returned as Error.
+func (c *conn) do(ctx context.Context, args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.nc.SetDeadline(deadline)
+	} else {
+		c.nc.SetDeadline(time.Time{})
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.nc, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// str sends a command whose reply is a string
+func (c *conn) str(ctx context.Context, args ...string) (string, error) {
+	reply, err := c.do(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("%s: unexpected reply %T", args[0], reply)
+}
+
+// int sends a command whose reply is an integer
+func (c *conn) int(ctx context.Context, args ...string) (int64, error) {
+	reply, err := c.do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%s: unexpected reply %T", args[0], reply)
+	}
+	return n, nil
+}
+
+// configGet returns a configuration parameter
+func (c *conn) configGet(ctx context.Context, name string) (string, error) {
+	reply, err := c.do(ctx, "CONFIG", "GET", name)
+	if err != nil {
+		return "", err
+	}
+	values, _ := reply.([]any)
+	if len(values) < 2 {
+		return "", fmt.Errorf("unknown configuration parameter %q", name)
+	}
+	value, _ := values[1].(string)
+	return value, nil
+}
+
+// info returns the fields of an INFO section
+func (c *conn) info(ctx context.Context, section string) (map[string]string, error) {
+	text, err := c.str(ctx, "INFO", section)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return fields, nil
+}
+
+// readReply reads one RESP2 reply
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	payload := line[1:]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, Error(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			// Errors inside arrays are values, not failures of the command
+			item, err := readReply(r)
+			var replyErr Error
+			if err != nil && !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			if err != nil {
+				item = replyErr
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply type %q", line[0])
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// LargestFirst calls fn for each item on up to workers goroutines,
+// starting the largest items first: a big table dumped last would keep
+// one worker busy long after the others ran out of work. It returns the
+// first error, after the calls already started have returned; items not
+// yet started are skipped.
+func LargestFirst[T any](ctx context.Context, items []T, size func(T) int64, workers int, fn func(context.Context, T) error) error {
+	ordered := make([]T, len(items))
+	copy(ordered, items)
+	sort.SliceStable(ordered, func(i, j int) bool { return size(ordered[i]) > size(ordered[j]) })
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := make(chan T)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for range max(1, min(workers, len(ordered))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := fn(ctx, item); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+feed:
+	for _, item := range ordered {
+		select {
+		case queue <- item:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLargestFirst(t *testing.T) {
+	sizes := map[string]int64{"small": 1, "huge": 900, "medium": 50, "big": 400, "tiny": 0}
+	names := []string{"small", "huge", "medium", "big", "tiny"}
+
+	var mu sync.Mutex
+	var started []string
+	err := LargestFirst(context.Background(), names, func(n string) int64 { return sizes[n] }, 1, func(_ context.Context, n string) error {
+		mu.Lock()
+		started = append(started, n)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"huge", "big", "medium", "small", "tiny"}; !reflect.DeepEqual(started, want) {
+		t.Errorf("started %v, want %v", started, want)
+	}
+	if names[0] != "small" {
+		t.Error("reordered the caller's items")
+	}
+
+	// Three items can only finish once three workers run at once
+	var running atomic.Int32
+	release := make(chan struct{})
+	err = LargestFirst(context.Background(), names, func(n string) int64 { return sizes[n] }, 3, func(context.Context, string) error {
+		if running.Add(1) == 3 {
+			close(release)
+		}
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLargestFirstStopsAtError(t *testing.T) {
+	boom := errors.New("boom")
+	var calls atomic.Int32
+	err := LargestFirst(context.Background(), []int64{5, 4, 3, 2, 1}, func(n int64) int64 { return n }, 1, func(_ context.Context, n int64) error {
+		calls.Add(1)
+		if n == 4 {
+			return boom
+		}
+		return nil
+	})
+	if !errors.Is(err, boom) || calls.Load() != 2 {
+		t.Errorf("err = %v after %d calls, want boom after 2", err, calls.Load())
+	}
+	if err := LargestFirst(context.Background(), nil, func(int64) int64 { return 0 }, 4, func(context.Context, int64) error { return nil }); err != nil {
+		t.Errorf("no items: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+package database
+
+import (
+	"context"
+	"io"
+)
+
+// SchemaDumper is implemented by drivers that can write the schema of a
+// database without its data. Backups take such a snapshot first, so the
+// schema history can be browsed and compared without restoring data.
+type SchemaDumper interface {
+	// DumpSchema writes the definitions of what opts backs up to w as
+	// SQL. The output only changes when the schema does: it holds no
+	// timestamps, row counts or other values that differ between runs.
+	DumpSchema(ctx context.Context, opts *BackupOptions, w io.Writer) error
+}
@@ -0,0 +1,13 @@
+package database
+
+import "strings"
+
+// UnixSocket returns the unix socket of the connection, "" over TCP. A Host
+// that is an absolute path is taken as the socket: the socket file for
+// MySQL, the directory holding it or the file itself for PostgreSQL.
+func (c *ConnectionConfig) UnixSocket() string {
+	if strings.HasPrefix(c.Host, "/") {
+		return c.Host
+	}
+	return ""
+}
@@ -0,0 +1,170 @@
+package tidb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+)
+
+var (
+	// summaryPattern finds the summary BR logs when a task succeeds, e.g.
+	// ["Full Backup success summary"] [total-ranges=20] ... [Size=41117]
+	summaryPattern = regexp.MustCompile(`\["[^"]* success summary"\]((?: \[[^\]=]+=[^\]]*\])*)`)
+	// fieldPattern finds the fields of a summary
+	fieldPattern = regexp.MustCompile(`\[([^\]=]+)=([^\]]*)\]`)
+	// sizePattern parses the sizes BR prints, e.g. 41.1kB or 1.2GiB
+	sizePattern = regexp.MustCompile(`^([0-9.]+)\s*([kKMGTP]i?)?B$`)
+)
+
+// summary is what BR reports of a successful task
+type summary map[string]string
+
+// br returns the path of br
+func br() (string, error) {
+	path, err := exec.LookPath("br")
+	if err != nil {
+		return "", errors.New("br is not installed")
+	}
+	return path, nil
+}
+
+// run runs a br command against the cluster and returns the summary of
+// the task. BR prints it to its log file, and to the terminal in recent
+// releases, so both are searched.
+func (d *TiDBDriver) run(ctx context.Context, args ...string) (summary, error) {
+	tool, err := br()
+	if err != nil {
+		return nil, err
+	}
+	work, err := os.MkdirTemp("", "br-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+	logFile := filepath.Join(work, "br.log")
+
+	args = append(append(args, d.clusterArgs()...), "--log-file", logFile)
+	cmd := exec.CommandContext(ctx, tool, args...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	run := telemetry.StartCommand(ctx, cmd)
+	err = cmd.Run()
+	run.End(err, -1)
+	if err != nil {
+		return nil, fmt.Errorf("br %s failed: %w: %s", strings.Join(args[:2], " "), err, tail(output.Bytes()))
+	}
+	log, _ := os.ReadFile(logFile)
+	if s := parseSummary(output.Bytes()); s != nil {
+		return s, nil
+	}
+	if s := parseSummary(log); s != nil {
+		return s, nil
+	}
+	return summary{}, nil
+}
+
+// clusterArgs returns the arguments pointing br at the cluster's PD, with
+// the TLS files of the ca, cert and key options
+func (d *TiDBDriver) clusterArgs() []string {
+	args := []string{"--pd", d.pd}
+	for _, key := range []string{"ca", "cert", "key"} {
+		if v := d.config.Options[key]; v != "" {
+			args = append(args, "--"+key, v)
+		}
+	}
+	return args
+}
+
+// backupTS returns the BackupTS of the backup at uri, for BR releases
+// that do not report it in their summary
+func (d *TiDBDriver) backupTS(ctx context.Context, uri string) (string, error) {
+	tool, err := br()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, tool, "validate", "decode", "--field", "end-version", "--storage", uri)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("br validate decode failed: %w: %s", err, tail(stderr.Bytes()))
+	}
+	// The value is the last line, after any log lines
+	lines := strings.Fields(string(output))
+	if len(lines) == 0 {
+		return "", errors.New("br reported no BackupTS")
+	}
+	ts := lines[len(lines)-1]
+	if _, err := strconv.ParseUint(ts, 10, 64); err != nil {
+		return "", fmt.Errorf("br reported BackupTS %q", ts)
+	}
+	return ts, nil
+}
+
+// parseSummary returns the fields of the last success summary in output,
+// nil when there is none
+func parseSummary(output []byte) summary {
+	matches := summaryPattern.FindAllSubmatch(output, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	s := summary{}
+	for _, field := range fieldPattern.FindAllSubmatch(matches[len(matches)-1][1], -1) {
+		s[string(field[1])] = string(field[2])
+	}
+	return s
+}
+
+// size returns the size of the backup: Size in bytes, or the rounded
+// backup-data-size of releases without it
+func (s summary) size() int64 {
+	if n, err := strconv.ParseInt(s["Size"], 10, 64); err == nil {
+		return n
+	}
+	return parseSize(s["backup-data-size(after-compressed)"])
+}
+
+// kvs returns the number of key-value pairs backed up
+func (s summary) kvs() int64 {
+	n, _ := strconv.ParseInt(s["total-kv"], 10, 64)
+	return n
+}
+
+// parseSize parses a size such as 41.1kB or 1.2GiB, 0 when it is not one
+func parseSize(s string) int64 {
+	m := sizePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0
+	}
+	unit := 1000.0
+	if strings.HasSuffix(m[2], "i") {
+		unit = 1024
+	}
+	for i := strings.Index("KMGTP", strings.ToUpper(strings.TrimSuffix(m[2], "i"))); m[2] != "" && i >= 0; i-- {
+		n *= unit
+	}
+	return int64(n)
+}
+
+// tail returns the last lines of a command's output, for error messages
+func tail(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > 5 {
+		lines = lines[len(lines)-5:]
+	}
+	return strings.Join(lines, "\n")
+}
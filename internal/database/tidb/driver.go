@@ -0,0 +1,661 @@
+// Package tidb provides the TiDB database driver. Backups are physical,
+// taken with BR: every TiKV node writes its share of the data straight to
+// the storage option, any URI BR can write to (s3://, gcs://, azure://,
+// or local:// on storage every node mounts) with its credentials in the
+// query string, so nothing passes through this host. Each backup gets a
+// directory of its own there; what db-backup stores is a small JSON
+// manifest naming it, and restores run br restore from the same place.
+//
+// BR reaches the cluster through PD, at the pd option or port 2379 of the
+// host; the ca, cert and key options are its TLS files. Metadata is read
+// over the MySQL protocol on port 4000.
+//
+// Incremental backups hold the changes since the BackupTS of the backup
+// they build on, given as the tidb_base_ts metadata, which must still be
+// within the cluster's GC life time. They are restored onto that backup,
+// restored first.
+package tidb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+	pkgErrors "github.com/sanskarpan/db-backup/pkg/errors"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Backup metadata of incremental backups: BaseTSKey holds the BackupTS of
+// the backup an incremental one builds on, which backups record as
+// BackupTSKey.
+const (
+	BaseTSKey   = "tidb_base_ts"
+	BackupTSKey = "tidb_backup_ts"
+)
+
+// DefaultPDPort is the client port of PD
+const DefaultPDPort = 2379
+
+// sqlDriver is the database/sql driver connections are opened with
+var sqlDriver = "mysql"
+
+// systemDatabases are never listed as user databases
+var systemDatabases = map[string]bool{
+	"information_schema": true, "metrics_schema": true, "performance_schema": true, "mysql": true, "sys": true,
+}
+
+// versionPattern finds the release in VERSION(), e.g. "8.0.11-TiDB-v7.5.0"
+var versionPattern = regexp.MustCompile(`TiDB-v(\S+)`)
+
+// TiDBDriver implements the database.Driver interface for TiDB
+type TiDBDriver struct {
+	db      *sql.DB
+	config  *database.ConnectionConfig
+	pd      string
+	storage string
+}
+
+func init() {
+	database.RegisterDriver(database.DatabaseTypeTiDB, func() database.Driver {
+		return NewTiDBDriver()
+	})
+}
+
+// NewTiDBDriver creates a new TiDB driver instance
+func NewTiDBDriver() *TiDBDriver {
+	return &TiDBDriver{}
+}
+
+// Connect connects to the cluster and checks br is installed
+func (d *TiDBDriver) Connect(ctx context.Context, config *database.ConnectionConfig) error {
+	storage := config.Options["storage"]
+	if storage == "" {
+		return pkgErrors.ErrDatabaseConnection(errors.New("set the storage option to where BR writes backups, e.g. s3://bucket/tidb"))
+	}
+	if _, _, err := backupURI(storage, ""); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if _, err := br(); err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	pd := config.Options["pd"]
+	if pd == "" {
+		pd = net.JoinHostPort(config.Host, strconv.Itoa(DefaultPDPort))
+	}
+
+	db, err := sql.Open(sqlDriver, buildDSN(config))
+	if err != nil {
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	if config.MaxConnections > 0 {
+		db.SetMaxOpenConns(config.MaxConnections)
+	} else {
+		db.SetMaxOpenConns(4)
+	}
+	db.SetConnMaxLifetime(time.Hour)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return pkgErrors.ErrDatabaseConnection(err)
+	}
+	d.db, d.config, d.pd, d.storage = db, config, pd, storage
+	return nil
+}
+
+// Disconnect closes the connection
+func (d *TiDBDriver) Disconnect() error {
+	if d.db != nil {
+		return d.db.Close()
+	}
+	return nil
+}
+
+// Ping tests the connection
+func (d *TiDBDriver) Ping(ctx context.Context) error {
+	if d.db == nil {
+		return pkgErrors.New(pkgErrors.ErrorTypeDatabase, "not connected to database")
+	}
+	return d.db.PingContext(ctx)
+}
+
+// Backup runs a BR backup and writes its manifest to opts.OutputPath.
+// opts.Incremental with the tidb_base_ts metadata backs up the changes
+// since that backup; without it the backup is full.
+func (d *TiDBDriver) Backup(ctx context.Context, opts *database.BackupOptions) (*database.BackupResult, error) {
+	result := &database.BackupResult{
+		ID:        utils.GenerateBackupID(),
+		StartTime: time.Now(),
+		Metadata:  telemetry.TraceMetadata(ctx, opts.Metadata),
+		Status:    database.BackupStatusInProgress,
+	}
+	fail := func(err error) (*database.BackupResult, error) {
+		result.Status = database.BackupStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseBackup(err)
+	}
+
+	m, err := d.backup(ctx, opts)
+	if err != nil {
+		return fail(err)
+	}
+	outputFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return fail(err)
+	}
+	output := stream.NewHashWriter(outputFile)
+	err = writeManifest(output, m)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.DatabaseVersion = m.Version
+	result.Size = m.Size
+	result.Checksum = output.Sum()
+	values := map[string]string{
+		"tidb_backup_kind": m.Kind,
+		"tidb_location":    m.Location,
+		BackupTSKey:        m.BackupTS,
+	}
+	if m.BaseTS != "" {
+		values[BaseTSKey] = m.BaseTS
+	}
+	result.Metadata = database.WithMetadata(result.Metadata, values)
+	result.Status = database.BackupStatusSuccess
+	return result, nil
+}
+
+// StreamBackup runs a BR backup and writes its manifest to writer
+func (d *TiDBDriver) StreamBackup(ctx context.Context, opts *database.BackupOptions, writer io.Writer) error {
+	m, err := d.backup(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return writeManifest(writer, m)
+}
+
+// GetBackupSize returns the size TiDB estimates for the tables backed up,
+// before BR compresses them
+func (d *TiDBDriver) GetBackupSize(ctx context.Context, opts *database.BackupOptions) (int64, error) {
+	m, err := d.spec(opts)
+	if err != nil {
+		return 0, err
+	}
+	databases := m.Databases
+	switch {
+	case len(m.Tables) > 0:
+		databases = nil
+	case m.All:
+		if databases, err = d.GetDatabases(ctx); err != nil {
+			return 0, err
+		}
+	}
+	var size int64
+	for _, db := range databases {
+		var n int64
+		if err := d.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.TABLES WHERE table_schema = ?", db).Scan(&n); err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	for _, t := range m.Tables {
+		db, table, _ := strings.Cut(t, ".")
+		n, err := d.GetTableSize(ctx, db, table)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	for _, t := range m.Exclude {
+		db, table, _ := strings.Cut(t, ".")
+		if n, err := d.GetTableSize(ctx, db, table); err == nil {
+			size -= n
+		}
+	}
+	return max(size, 0), nil
+}
+
+// Restore restores the backup whose manifest is opts.SourceBackup
+func (d *TiDBDriver) Restore(ctx context.Context, opts *database.RestoreOptions) (*database.RestoreResult, error) {
+	result := &database.RestoreResult{
+		StartTime: time.Now(),
+		Status:    database.RestoreStatusInProgress,
+	}
+	fail := func(err error) (*database.RestoreResult, error) {
+		result.Status = database.RestoreStatusFailed
+		result.Error = err
+		return result, pkgErrors.ErrDatabaseRestore(err).WithMetadata("backup_file", opts.SourceBackup)
+	}
+
+	f, err := os.Open(opts.SourceBackup)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	restored, kvs, err := d.restore(ctx, opts, f)
+	if err != nil {
+		return fail(err)
+	}
+	result.RestoredTables = restored
+	result.RowsRestored = kvs
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+	result.Status = database.RestoreStatusSuccess
+	return result, nil
+}
+
+// StreamRestore restores the backup whose manifest is read from reader
+func (d *TiDBDriver) StreamRestore(ctx context.Context, opts *database.RestoreOptions, reader io.Reader) error {
+	_, _, err := d.restore(ctx, opts, reader)
+	return err
+}
+
+// ValidateRestore validates that a restore can be performed
+func (d *TiDBDriver) ValidateRestore(ctx context.Context, opts *database.RestoreOptions) error {
+	f, err := os.Open(opts.SourceBackup)
+	if os.IsNotExist(err) {
+		return pkgErrors.ErrValidationFailed(fmt.Sprintf("backup file not found: %s", opts.SourceBackup))
+	}
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	defer f.Close()
+	m, err := readManifest(f)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if _, err := d.location(m); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	p, err := planRestore(m, opts)
+	if err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	if err := d.checkTargets(ctx, m, p, opts.DropExisting); err != nil {
+		return pkgErrors.ErrValidationFailed(err.Error())
+	}
+	return nil
+}
+
+// GetDatabases returns the user databases
+func (d *TiDBDriver) GetDatabases(ctx context.Context) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT schema_name FROM information_schema.SCHEMATA ORDER BY schema_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var databases []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if !systemDatabases[strings.ToLower(name)] {
+			databases = append(databases, name)
+		}
+	}
+	return databases, rows.Err()
+}
+
+// GetTables returns the tables of a database
+func (d *TiDBDriver) GetTables(ctx context.Context, db string) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT table_name FROM information_schema.TABLES WHERE table_schema = ? AND table_type = 'BASE TABLE' ORDER BY table_name", db)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// GetTableSize returns the size TiDB estimates for a table
+func (d *TiDBDriver) GetTableSize(ctx context.Context, db, table string) (int64, error) {
+	var size int64
+	err := d.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.TABLES WHERE table_schema = ? AND table_name = ?", db, table).Scan(&size)
+	return size, err
+}
+
+// GetVersion returns the TiDB release, e.g. 7.5.0
+func (d *TiDBDriver) GetVersion(ctx context.Context) (string, error) {
+	var version string
+	if err := d.db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return "", err
+	}
+	if m := versionPattern.FindStringSubmatch(version); m != nil {
+		return m[1], nil
+	}
+	return version, nil
+}
+
+// GetType returns the database type
+func (d *TiDBDriver) GetType() database.DatabaseType {
+	return database.DatabaseTypeTiDB
+}
+
+// SupportsIncremental returns whether incremental backups are supported
+func (d *TiDBDriver) SupportsIncremental() bool {
+	return true // br backup --lastbackupts
+}
+
+// SupportsPITR returns whether point-in-time recovery is supported
+func (d *TiDBDriver) SupportsPITR() bool {
+	return false
+}
+
+// backup runs br backup of what opts name and returns its manifest
+func (d *TiDBDriver) backup(ctx context.Context, opts *database.BackupOptions) (*Manifest, error) {
+	m, err := d.spec(opts)
+	if err != nil {
+		return nil, err
+	}
+	if m.All {
+		// Recorded so restores know what they replace
+		if m.Databases, err = d.GetDatabases(ctx); err != nil {
+			return nil, err
+		}
+	}
+	m.Kind = KindFull
+	if base := opts.Metadata[BaseTSKey]; opts.Incremental && base != "" {
+		if _, err := strconv.ParseUint(base, 10, 64); err != nil {
+			return nil, fmt.Errorf("%s %q is not a TSO", BaseTSKey, base)
+		}
+		m.Kind, m.BaseTS = KindIncremental, base
+	}
+	m.CreatedAt = time.Now().UTC()
+	m.Path = m.Kind + "-" + m.CreatedAt.Format("20060102T150405.000Z")
+	uri, err := d.location(m)
+	if err != nil {
+		return nil, err
+	}
+	m.Version, _ = d.GetVersion(ctx)
+
+	args := []string{"backup", "full", "--storage", uri}
+	for _, f := range m.filters() {
+		args = append(args, "--filter", f)
+	}
+	if m.BaseTS != "" {
+		args = append(args, "--lastbackupts", m.BaseTS)
+	}
+	if opts.Parallel > 0 {
+		args = append(args, "--concurrency", strconv.Itoa(opts.Parallel))
+	}
+	s, err := d.run(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	m.Size, m.KVs = s.size(), s.kvs()
+	if m.BackupTS = s["BackupTS"]; m.BackupTS == "" {
+		if m.BackupTS, err = d.backupTS(ctx, uri); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// spec returns a manifest of what opts back up, with tables qualified by
+// their database
+func (d *TiDBDriver) spec(opts *database.BackupOptions) (*Manifest, error) {
+	m := &Manifest{Format: ManifestFormat}
+	switch {
+	case opts.AllDatabases:
+		m.All = true
+	case len(opts.Databases) > 0:
+		m.Databases = opts.Databases
+	case opts.Database != "":
+		m.Databases = []string{opts.Database}
+	default:
+		return nil, errors.New("no database to back up")
+	}
+	source := ""
+	if len(m.Databases) == 1 {
+		source = m.Databases[0]
+	}
+	var err error
+	if m.Tables, err = qualify(source, opts.Tables); err != nil {
+		return nil, err
+	}
+	if m.Exclude, err = qualify(source, opts.ExcludeTables); err != nil {
+		return nil, err
+	}
+	m.Tables = slices.DeleteFunc(m.Tables, func(t string) bool { return slices.Contains(m.Exclude, t) })
+	if len(opts.Tables) > 0 {
+		if len(m.Tables) == 0 {
+			return nil, errors.New("every table is excluded")
+		}
+		m.Exclude = nil
+	}
+	return m, nil
+}
+
+// location returns the URI of m's backup under the storage option,
+// checking it is where the backup was taken to
+func (d *TiDBDriver) location(m *Manifest) (string, error) {
+	uri, location, err := backupURI(d.storage, m.Path)
+	if err != nil {
+		return "", err
+	}
+	if m.Location == "" {
+		m.Location = location
+	} else if m.Location != location {
+		return "", fmt.Errorf("the backup is in %s, but the storage option points at %s", m.Location, redactURI(d.storage))
+	}
+	return uri, nil
+}
+
+// restorePlan is what a restore brings back
+type restorePlan struct {
+	all       bool     // the whole backup restored
+	databases []string // whole databases restored
+	tables    []string // or tables, database.table
+	exclude   []string // less these tables
+}
+
+// filters returns BR's table filters selecting what p restores
+func (p *restorePlan) filters() []string {
+	return (&Manifest{All: p.all, Databases: p.databases, Tables: p.tables, Exclude: p.exclude}).filters()
+}
+
+// restored returns the names of what p restores
+func (p *restorePlan) restored() []string {
+	if len(p.tables) > 0 {
+		return p.tables
+	}
+	return p.databases
+}
+
+// planRestore decides what to restore: the whole backup, the restore
+// database of it, or tables of it. BR restores databases under their own
+// names only.
+func planRestore(m *Manifest, opts *database.RestoreOptions) (*restorePlan, error) {
+	if opts.PointInTime != nil {
+		return nil, errors.New("point-in-time restores are not supported for tidb, restore the backup taken at the time instead")
+	}
+	if opts.Renamed() {
+		return nil, fmt.Errorf("br restores databases under their own names: %w", database.ErrRenameUnsupported)
+	}
+	if m.Kind == KindIncremental && opts.DropExisting {
+		return nil, fmt.Errorf("an incremental backup is restored onto the backup taken at %s, which --drop-existing would remove", m.BaseTS)
+	}
+	p := &restorePlan{all: m.All, databases: m.Databases, tables: m.Tables, exclude: m.Exclude}
+	source := ""
+	if len(m.Databases) == 1 {
+		source = m.Databases[0]
+	}
+	if opts.Database != "" {
+		if len(m.Databases) > 0 && !slices.Contains(m.Databases, opts.Database) {
+			return nil, fmt.Errorf("the backup holds no database %s, and br restores databases under their own names only", opts.Database)
+		}
+		source = opts.Database
+		p.all, p.databases = false, []string{opts.Database}
+		p.tables = slices.DeleteFunc(slices.Clone(m.Tables), func(t string) bool {
+			return !strings.HasPrefix(t, opts.Database+".")
+		})
+		if len(m.Tables) > 0 && len(p.tables) == 0 {
+			return nil, fmt.Errorf("the backup holds no table of database %s", opts.Database)
+		}
+	}
+
+	var err error
+	if len(opts.Tables) > 0 {
+		if p.tables, err = qualify(source, opts.Tables); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.ExcludeTables) > 0 {
+		exclude, err := qualify(source, opts.ExcludeTables)
+		if err != nil {
+			return nil, err
+		}
+		p.exclude = append(slices.Clone(p.exclude), exclude...)
+	}
+	if len(p.tables) > 0 {
+		p.tables = slices.DeleteFunc(slices.Clone(p.tables), func(t string) bool { return slices.Contains(p.exclude, t) })
+		if len(p.tables) == 0 {
+			return nil, errors.New("every table is excluded")
+		}
+		p.all, p.exclude = false, nil
+	}
+	return p, nil
+}
+
+// checkTargets fails when a full backup would be restored over tables
+// that exist and are not to be dropped. Incremental backups are restored
+// over the tables of the backup they build on.
+func (d *TiDBDriver) checkTargets(ctx context.Context, m *Manifest, p *restorePlan, drop bool) error {
+	if drop || m.Kind == KindIncremental {
+		return nil
+	}
+	for _, t := range p.tables {
+		db, table, _ := strings.Cut(t, ".")
+		tables, err := d.GetTables(ctx, db)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(tables, table) {
+			return fmt.Errorf("table %s already exists, use --drop-existing to replace it", t)
+		}
+	}
+	if len(p.tables) > 0 {
+		return nil
+	}
+	for _, db := range p.databases {
+		tables, err := d.GetTables(ctx, db)
+		if err != nil {
+			return err
+		}
+		if len(tables) > 0 {
+			return fmt.Errorf("database %s already exists, use --drop-existing to replace it", db)
+		}
+	}
+	return nil
+}
+
+// restore runs br restore of the manifest read from r and returns what it
+// restored, and the key-value pairs BR reports
+func (d *TiDBDriver) restore(ctx context.Context, opts *database.RestoreOptions, r io.Reader) ([]string, int64, error) {
+	m, err := readManifest(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	uri, err := d.location(m)
+	if err != nil {
+		return nil, 0, err
+	}
+	p, err := planRestore(m, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := d.checkTargets(ctx, m, p, opts.DropExisting); err != nil {
+		return nil, 0, err
+	}
+
+	// BR refuses tables that exist, so replacing them means dropping them
+	// first
+	if opts.DropExisting {
+		for _, stmt := range drops(p) {
+			if _, err := d.db.ExecContext(ctx, stmt); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	args := []string{"restore", "full", "--storage", uri}
+	for _, f := range p.filters() {
+		args = append(args, "--filter", f)
+	}
+	if opts.Parallel > 0 {
+		args = append(args, "--concurrency", strconv.Itoa(opts.Parallel))
+	}
+	s, err := d.run(ctx, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return p.restored(), s.kvs(), nil
+}
+
+// drops returns the statements dropping what a restore of p recreates
+func drops(p *restorePlan) []string {
+	var stmts []string
+	if len(p.tables) > 0 {
+		for _, t := range p.tables {
+			db, table, _ := strings.Cut(t, ".")
+			stmts = append(stmts, "DROP TABLE IF EXISTS "+ident(db)+"."+ident(table))
+		}
+		return stmts
+	}
+	for _, db := range p.databases {
+		stmts = append(stmts, "DROP DATABASE IF EXISTS "+ident(db))
+	}
+	return stmts
+}
+
+// ident quotes an identifier
+func ident(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
+
+// buildDSN builds a go-sql-driver DSN. TLS follows the SSL mode: require
+// encrypts without verifying the server, verify-ca and verify-full verify
+// it against the system roots.
+func buildDSN(config *database.ConnectionConfig) string {
+	if config.ConnectionString != "" {
+		return config.ConnectionString
+	}
+	c := mysql.NewConfig()
+	c.User = config.Username
+	c.Passwd = config.Password
+	c.Net = "tcp"
+	c.Addr = net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	c.DBName = config.Database
+	c.Timeout = config.ConnectionTimeout
+	switch config.SSLMode {
+	case "require":
+		c.TLSConfig = "skip-verify"
+	case "verify-ca", "verify-full":
+		c.TLSConfig = "true"
+	}
+	return c.FormatDSN()
+}
@@ -0,0 +1,152 @@
+package tidb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ManifestFormat identifies the manifests written by this driver
+const ManifestFormat = "tidb-br/v1"
+
+// Backup kinds recorded in manifests
+const (
+	KindFull        = "full"
+	KindIncremental = "incremental"
+)
+
+// Manifest describes a BR backup. It is the artifact db-backup stores.
+type Manifest struct {
+	Format string `json:"format"`
+	// Location is where BR wrote the backup, without credentials
+	Location string `json:"location"`
+	// Path is the directory of the backup under the storage option
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// BaseTS is the BackupTS of the backup an incremental one holds the
+	// changes since; it is restored onto that backup
+	BaseTS string `json:"base_ts,omitempty"`
+	// BackupTS is the TSO the backup is consistent at
+	BackupTS string `json:"backup_ts"`
+	// All is set for backups of every database, which Databases lists as
+	// of the backup
+	All       bool      `json:"all,omitempty"`
+	Databases []string  `json:"databases,omitempty"`
+	Tables    []string  `json:"tables,omitempty"`  // database.table
+	Exclude   []string  `json:"exclude,omitempty"` // database.table
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+	KVs       int64     `json:"kvs,omitempty"`
+}
+
+// filters returns BR's table filters selecting what m backs up
+func (m *Manifest) filters() []string {
+	var filters []string
+	switch {
+	case m.All:
+		if len(m.Exclude) > 0 {
+			// Exclusions need a rule they are the exceptions to
+			filters = append(filters, "*.*")
+		}
+	case len(m.Tables) > 0:
+		for _, t := range m.Tables {
+			filters = append(filters, filterName(t))
+		}
+	case len(m.Databases) > 0:
+		for _, db := range m.Databases {
+			filters = append(filters, escapeFilter(db)+".*")
+		}
+	}
+	for _, t := range m.Exclude {
+		filters = append(filters, "!"+filterName(t))
+	}
+	return filters
+}
+
+// filterName returns the filter matching the table database.table
+func filterName(table string) string {
+	db, name, _ := strings.Cut(table, ".")
+	return escapeFilter(db) + "." + escapeFilter(name)
+}
+
+// escapeFilter escapes the wildcards and quotes of table filters in a name
+func escapeFilter(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`\*?[]!.'"`+"`", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// backupURI returns the URI of path under the storage option, and the
+// same without credentials
+func backupURI(storage, path string) (uri, location string, err error) {
+	u, err := url.Parse(storage)
+	if err != nil || u.Scheme == "" {
+		return "", "", fmt.Errorf("storage %q is not a URI", redactURI(storage))
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path
+	u.RawPath = ""
+	uri = u.String()
+	u.User = nil
+	u.RawQuery = ""
+	return uri, u.String(), nil
+}
+
+// redactURI drops the query, which holds the credentials of cloud
+// storage, and any password from a URI
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "<invalid URI>"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
+
+// qualify prefixes table names without a database with db, the only
+// database of a backup, or "" when there are several
+func qualify(db string, tables []string) ([]string, error) {
+	qualified := make([]string, 0, len(tables))
+	for _, t := range tables {
+		if !strings.Contains(t, ".") {
+			if db == "" {
+				return nil, fmt.Errorf("table %s: name tables database.table when there are several databases", t)
+			}
+			t = db + "." + t
+		}
+		qualified = append(qualified, t)
+	}
+	return qualified, nil
+}
+
+// writeManifest writes m as indented JSON
+func writeManifest(w io.Writer, m *Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// readManifest reads a manifest, refusing other artifacts
+func readManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(r, 1<<20)).Decode(&m); err != nil || m.Format != ManifestFormat {
+		return nil, errors.New("not a TiDB BR backup manifest")
+	}
+	if m.Path == "" || m.BackupTS == "" {
+		return nil, errors.New("the manifest names no backup location")
+	}
+	if strings.Contains(m.Path, "..") || strings.HasPrefix(m.Path, "/") {
+		return nil, fmt.Errorf("invalid backup path %q", m.Path)
+	}
+	return &m, nil
+}
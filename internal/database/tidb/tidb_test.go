@@ -0,0 +1,343 @@
+package tidb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// summaryLine is what the fake br prints when a task succeeds
+const summaryLine = `[2024/01/10 08:50:53.417 +00:00] [INFO] [collector.go:77] ["Full Backup success summary"] [total-ranges=20] [ranges-succeed=20] [ranges-failed=0] [total-take=4.8s] [BackupTS=446969473431117826] [total-kv=1000] [total-kv-size=100.5kB] [average-speed=20.9kB/s] [backup-data-size(after-compressed)=41.1kB] [Size=41117]`
+
+// fakeCluster answers the statements the driver sends and records those
+// that change something
+type fakeCluster struct {
+	mu         sync.Mutex
+	tables     map[string][]string
+	statements []string
+}
+
+var fake *fakeCluster
+
+func init() {
+	sql.Register("tidb-fake", fakeDriver{})
+	sqlDriver = "tidb-fake"
+}
+
+func (f *fakeCluster) query(stmt string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case stmt == "SELECT VERSION()":
+		return []string{"version"}, [][]driver.Value{{"8.0.11-TiDB-v7.5.0"}}, nil
+	case strings.HasPrefix(stmt, "SELECT schema_name FROM information_schema.SCHEMATA"):
+		return []string{"schema_name"}, [][]driver.Value{{"INFORMATION_SCHEMA"}, {"mysql"}, {"shop"}, {"test"}}, nil
+	case strings.HasPrefix(stmt, "SELECT table_name FROM information_schema.TABLES"):
+		var rows [][]driver.Value
+		for _, t := range f.tables[fmt.Sprint(args[0].Value)] {
+			rows = append(rows, []driver.Value{t})
+		}
+		return []string{"table_name"}, rows, nil
+	case strings.HasPrefix(stmt, "SELECT COALESCE(SUM(data_length + index_length), 0)"):
+		return []string{"size"}, [][]driver.Value{{int64(1000)}}, nil
+	case strings.HasPrefix(stmt, "DROP "):
+		f.statements = append(f.statements, stmt)
+		return nil, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected statement %q", stmt)
+}
+
+func (f *fakeCluster) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stmts := f.statements
+	f.statements = nil
+	return stmts
+}
+
+// fakeBR installs a br that logs its arguments, one command per line, and
+// prints summary. It returns the log.
+func fakeBR(t *testing.T, summary string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake br is a shell script")
+	}
+	bin := t.TempDir()
+	log := filepath.Join(bin, "br.log")
+	script := "#!/bin/sh\necho \"$@\" >> \"$BR_LOG\"\n" +
+		"if [ \"$1\" = validate ]; then echo 446969473431117800; exit 0; fi\n" +
+		"echo '" + summary + "'\n"
+	if err := os.WriteFile(filepath.Join(bin, "br"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("BR_LOG", log)
+	return log
+}
+
+// commands returns the br commands logged, less the --log-file argument
+func commands(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(log)
+	var cmds []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if i := strings.Index(line, " --log-file "); i >= 0 {
+			line = line[:i]
+		}
+		cmds = append(cmds, line)
+	}
+	return cmds
+}
+
+func connect(t *testing.T, options map[string]string) *TiDBDriver {
+	t.Helper()
+	fake = &fakeCluster{tables: map[string][]string{"shop": {"orders", "customers"}}}
+	d := NewTiDBDriver()
+	if err := d.Connect(context.Background(), &database.ConnectionConfig{
+		Host: "tidb-1", Port: 4000, Username: "root", Options: options,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Disconnect() })
+	return d
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	log := fakeBR(t, summaryLine)
+	ctx := context.Background()
+	d := connect(t, map[string]string{"storage": "s3://backups/tidb?access-key=AKIA&secret-access-key=secret"})
+	dir := t.TempDir()
+
+	full, err := d.Backup(ctx, &database.BackupOptions{Database: "shop", OutputPath: filepath.Join(dir, "full.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds := commands(t, log)
+	prefix := "backup full --storage s3://backups/tidb/full-"
+	if len(cmds) != 1 || !strings.HasPrefix(cmds[0], prefix) ||
+		!strings.HasSuffix(cmds[0], "?access-key=AKIA&secret-access-key=secret --filter shop.* --pd tidb-1:2379") {
+		t.Fatalf("full backup ran %q", cmds)
+	}
+	if full.Size != 41117 || full.DatabaseVersion != "7.5.0" || full.Metadata[BackupTSKey] != "446969473431117826" ||
+		full.Metadata["tidb_backup_kind"] != KindFull || !strings.HasPrefix(full.Metadata["tidb_location"], "s3://backups/tidb/full-") {
+		t.Errorf("full backup = %+v", full)
+	}
+
+	// The next one holds the changes since
+	incr, err := d.Backup(ctx, &database.BackupOptions{
+		Database:    "shop",
+		Incremental: true,
+		Metadata:    map[string]string{BaseTSKey: full.Metadata[BackupTSKey]},
+		OutputPath:  filepath.Join(dir, "incr.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds = commands(t, log)
+	if len(cmds) != 1 || !strings.Contains(cmds[0], "/tidb/incremental-") ||
+		!strings.HasSuffix(cmds[0], " --filter shop.* --lastbackupts 446969473431117826 --pd tidb-1:2379") {
+		t.Fatalf("incremental backup ran %q", cmds)
+	}
+	if incr.Metadata["tidb_backup_kind"] != KindIncremental || incr.Metadata[BaseTSKey] != "446969473431117826" {
+		t.Errorf("incremental backup = %+v", incr)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "incr.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("the manifest holds the storage credentials:\n%s", data)
+	}
+
+	// The database exists: replaced only when asked to
+	err = d.ValidateRestore(ctx, &database.RestoreOptions{SourceBackup: filepath.Join(dir, "full.json")})
+	if err == nil || !strings.Contains(err.Error(), "database shop already exists, use --drop-existing") {
+		t.Errorf("restore over shop: %v", err)
+	}
+	result, err := d.Restore(ctx, &database.RestoreOptions{SourceBackup: filepath.Join(dir, "full.json"), DropExisting: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmts := fake.take(); strings.Join(stmts, "\n") != "DROP DATABASE IF EXISTS `shop`" {
+		t.Errorf("restore ran %q", stmts)
+	}
+	cmds = commands(t, log)
+	if len(cmds) != 1 || !strings.HasPrefix(cmds[0], "restore full --storage s3://backups/tidb/full-") ||
+		!strings.HasSuffix(cmds[0], " --filter shop.* --pd tidb-1:2379") {
+		t.Errorf("restore ran %q", cmds)
+	}
+	if strings.Join(result.RestoredTables, ",") != "shop" || result.RowsRestored != 1000 {
+		t.Errorf("restore = %+v", result)
+	}
+
+	// The incremental backup goes on top of it, tables and all
+	if err := d.StreamRestore(ctx, &database.RestoreOptions{Tables: []string{"orders"}}, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := commands(t, log); len(cmds) != 1 || !strings.Contains(cmds[0], "/tidb/incremental-") ||
+		!strings.HasSuffix(cmds[0], " --filter shop.orders --pd tidb-1:2379") {
+		t.Errorf("incremental restore ran %q", cmds)
+	}
+	err = d.StreamRestore(ctx, &database.RestoreOptions{DropExisting: true}, bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "onto the backup taken at 446969473431117826") {
+		t.Errorf("incremental restore dropping the tables: %v", err)
+	}
+}
+
+func TestBackupFilters(t *testing.T) {
+	log := fakeBR(t, summaryLine)
+	d := connect(t, map[string]string{"storage": "local:///mnt/backups", "pd": "pd-1:2379,pd-2:2379", "ca": "/certs/ca.pem"})
+	for _, tc := range []struct {
+		opts *database.BackupOptions
+		want string
+	}{
+		{&database.BackupOptions{AllDatabases: true}, ""},
+		{&database.BackupOptions{AllDatabases: true, ExcludeTables: []string{"shop.audit"}}, " --filter *.* --filter !shop.audit"},
+		{&database.BackupOptions{Database: "shop", Tables: []string{"orders", "customers"}, ExcludeTables: []string{"customers"}}, " --filter shop.orders"},
+		{&database.BackupOptions{Databases: []string{"shop", "my.db"}}, ` --filter shop.* --filter my\.db.*`},
+	} {
+		if err := d.StreamBackup(context.Background(), tc.opts, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		cmds := commands(t, log)
+		if len(cmds) != 1 || !strings.HasSuffix(cmds[0], tc.want+" --pd pd-1:2379,pd-2:2379 --ca /certs/ca.pem") {
+			t.Errorf("%+v: ran %q, want filters %q", tc.opts, cmds, tc.want)
+		}
+	}
+}
+
+func TestBackupTSFromStorage(t *testing.T) {
+	// Releases without BackupTS and Size in their summary
+	log := fakeBR(t, `["Full Backup success summary"] [total-ranges=20] [total-kv=10] [backup-data-size(after-compressed)=1.5MB]`)
+	d := connect(t, map[string]string{"storage": "gcs://backups/tidb"})
+	var out bytes.Buffer
+	if err := d.StreamBackup(context.Background(), &database.BackupOptions{Database: "shop"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readManifest(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.BackupTS != "446969473431117800" || m.Size != 1500000 || m.KVs != 10 {
+		t.Errorf("manifest = %+v", m)
+	}
+	if cmds := commands(t, log); len(cmds) != 2 || !strings.HasPrefix(cmds[1], "validate decode --field end-version --storage gcs://backups/tidb/full-") {
+		t.Errorf("ran %q", cmds)
+	}
+}
+
+func TestPlanRestore(t *testing.T) {
+	m := &Manifest{All: true, Databases: []string{"shop", "test"}, Kind: KindFull}
+	for _, tc := range []struct {
+		opts    database.RestoreOptions
+		filters string
+		err     string
+	}{
+		{database.RestoreOptions{}, "", ""},
+		{database.RestoreOptions{Database: "test"}, "test.*", ""},
+		{database.RestoreOptions{Database: "shop", ExcludeTables: []string{"audit"}}, `shop.* !shop.audit`, ""},
+		{database.RestoreOptions{Tables: []string{"shop.orders"}}, "shop.orders", ""},
+		{database.RestoreOptions{Tables: []string{"orders"}}, "", "name tables database.table"},
+		{database.RestoreOptions{Database: "shop_copy"}, "", "br restores databases under their own names only"},
+		{database.RestoreOptions{Database: "shop", TargetDatabase: "shop_copy"}, "", database.ErrRenameUnsupported.Error()},
+	} {
+		p, err := planRestore(m, &tc.opts)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%+v: got %v, want %q", tc.opts, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(p.filters(), " "); got != tc.filters {
+			t.Errorf("%+v: filters %q, want %q", tc.opts, got, tc.filters)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{"41.1kB": 41100, "2GiB": 2 << 30, "512B": 512, "": 0, "lots": 0} {
+		if got := parseSize(s); got != want {
+			t.Errorf("parseSize(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestRestoreFromAnotherStorage(t *testing.T) {
+	fakeBR(t, summaryLine)
+	var out bytes.Buffer
+	if err := connect(t, map[string]string{"storage": "s3://backups/tidb"}).StreamBackup(context.Background(), &database.BackupOptions{Database: "shop"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	other := connect(t, map[string]string{"storage": "s3://elsewhere/tidb?access-key=x"})
+	err := other.StreamRestore(context.Background(), &database.RestoreOptions{DropExisting: true}, &out)
+	if err == nil || !strings.Contains(err.Error(), "the storage option points at s3://elsewhere/tidb") {
+		t.Errorf("restore from another storage: %v", err)
+	}
+}
+
+func TestDSN(t *testing.T) {
+	got := buildDSN(&database.ConnectionConfig{Host: "tidb-1", Port: 4000, Username: "root", Password: "p@ss", SSLMode: "require"})
+	want := "root:p@ss@tcp(tidb-1:4000)/?tls=skip-verify"
+	if got != want {
+		t.Errorf("buildDSN() = %s, want %s", got, want)
+	}
+}
+
+// fakeDriver is a database/sql driver answering from fake
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (fakeConn) QueryContext(_ context.Context, stmt string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows, err := fake.query(stmt, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+func (fakeConn) ExecContext(_ context.Context, stmt string, args []driver.NamedValue) (driver.Result, error) {
+	_, _, err := fake.query(stmt, args)
+	return driver.RowsAffected(0), err
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
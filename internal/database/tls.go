@@ -0,0 +1,104 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SSL modes, as PostgreSQL's sslmode names them
+const (
+	SSLDisable    = "disable"
+	SSLRequire    = "require"
+	SSLVerifyCA   = "verify-ca"
+	SSLVerifyFull = "verify-full"
+)
+
+// TLSMode returns the SSL mode of the connection. Without SSLMode it is
+// verify-full when a CA certificate is given, require with only a client
+// certificate and disable otherwise; require with a CA certificate
+// verifies the server's chain like verify-ca, as libpq does. MySQL's mode
+// names are understood too.
+func (c *ConnectionConfig) TLSMode() string {
+	mode := strings.ToLower(strings.ReplaceAll(c.SSLMode, "_", "-"))
+	switch mode {
+	case "":
+		switch {
+		case c.SSLCA != "":
+			return SSLVerifyFull
+		case c.SSLCert != "":
+			return SSLRequire
+		}
+		return SSLDisable
+	case "disable", "disabled", "false":
+		return SSLDisable
+	case "verify-ca":
+		return SSLVerifyCA
+	case "verify-full", "verify-identity":
+		return SSLVerifyFull
+	}
+	if c.SSLCA != "" {
+		return SSLVerifyCA
+	}
+	return SSLRequire
+}
+
+// ClientTLS builds the TLS settings of the connection, nil when it is not
+// encrypted. The server is verified against SSLCA, the system pool when
+// unset, and SSLCert and SSLKey are presented as the client certificate;
+// SSLKey may be left empty when SSLCert holds the key too.
+func (c *ConnectionConfig) ClientTLS() (*tls.Config, error) {
+	mode := c.TLSMode()
+	if mode == SSLDisable {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.UnixSocket() == "" {
+		tlsConfig.ServerName = c.Host
+	}
+	if c.SSLCA != "" {
+		pem, err := os.ReadFile(c.SSLCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", c.SSLCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.SSLCert != "" {
+		key := c.SSLKey
+		if key == "" {
+			key = c.SSLCert
+		}
+		cert, err := tls.LoadX509KeyPair(c.SSLCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch mode {
+	case SSLRequire:
+		tlsConfig.InsecureSkipVerify = true
+	case SSLVerifyCA:
+		// The chain is checked, the host name is not
+		tlsConfig.InsecureSkipVerify = true
+		roots := tlsConfig.RootCAs
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("server sent no certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return tlsConfig, nil
+}
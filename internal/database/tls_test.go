@@ -0,0 +1,58 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ConnectionConfig
+		want   string
+	}{
+		{"unset", ConnectionConfig{}, SSLDisable},
+		{"unset with CA", ConnectionConfig{SSLCA: "ca.pem"}, SSLVerifyFull},
+		{"unset with client certificate", ConnectionConfig{SSLCert: "client.pem"}, SSLRequire},
+		{"disabled", ConnectionConfig{SSLMode: "DISABLED", SSLCA: "ca.pem"}, SSLDisable},
+		{"require", ConnectionConfig{SSLMode: "require"}, SSLRequire},
+		{"require with CA", ConnectionConfig{SSLMode: "require", SSLCA: "ca.pem"}, SSLVerifyCA},
+		{"prefer", ConnectionConfig{SSLMode: "prefer"}, SSLRequire},
+		{"mysql verify_identity", ConnectionConfig{SSLMode: "VERIFY_IDENTITY"}, SSLVerifyFull},
+		{"verify-ca", ConnectionConfig{SSLMode: "verify-ca"}, SSLVerifyCA},
+	}
+	for _, tt := range tests {
+		if got := tt.config.TLSMode(); got != tt.want {
+			t.Errorf("%s: TLSMode() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	tlsConfig, err := (&ConnectionConfig{SSLMode: "disable"}).ClientTLS()
+	if err != nil || tlsConfig != nil {
+		t.Fatalf("disable: got %v, %v", tlsConfig, err)
+	}
+
+	tlsConfig, err = (&ConnectionConfig{Host: "db-1", SSLMode: "require"}).ClientTLS()
+	if err != nil || !tlsConfig.InsecureSkipVerify || tlsConfig.VerifyConnection != nil {
+		t.Errorf("require: got %+v, %v", tlsConfig, err)
+	}
+
+	tlsConfig, err = (&ConnectionConfig{Host: "db-1", SSLMode: "verify-full"}).ClientTLS()
+	if err != nil || tlsConfig.InsecureSkipVerify || tlsConfig.ServerName != "db-1" {
+		t.Errorf("verify-full: got %+v, %v", tlsConfig, err)
+	}
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ConnectionConfig{SSLCA: notPEM}).ClientTLS(); err == nil {
+		t.Error("accepted a CA file without certificates")
+	}
+	if _, err := (&ConnectionConfig{SSLCert: filepath.Join(t.TempDir(), "missing.pem")}).ClientTLS(); err == nil {
+		t.Error("accepted a missing client certificate")
+	}
+}
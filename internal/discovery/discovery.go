@@ -0,0 +1,188 @@
+// Package discovery enrolls the databases of servers into backup schedules
+// as they are created. Each configured server is listed with the backup
+// login of its profile, and a database no schedule backs up yet gets one
+// on the server's cron, tagged so database defaults and quotas apply.
+// The schedules on the API server are the only state: a database is left
+// alone once any schedule of the profile backs it up, so schedules edited
+// or disabled by hand are never recreated.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/pkg/client"
+)
+
+// TagServer is the tag naming the discovery server of an enrolled
+// database's backups
+const TagServer = "discovered_from"
+
+// Statuses of a discovered database
+const (
+	StatusEnrolled  = "enrolled"  // a schedule was created
+	StatusScheduled = "scheduled" // a schedule already backs it up
+	StatusExcluded  = "excluded"  // matched by no include or by an exclude pattern
+)
+
+// Lister lists the databases of a server with the backup login of profile
+type Lister func(ctx context.Context, profile string) ([]string, error)
+
+// API is the part of the server API enrolling databases, implemented by
+// *client.Client
+type API interface {
+	ListSchedules(ctx context.Context) ([]client.Schedule, error)
+	CreateSchedule(ctx context.Context, req client.ScheduleRequest) (*client.Schedule, error)
+}
+
+// Enrollment is a database found on a server
+type Enrollment struct {
+	Server   string `json:"server"`
+	Database string `json:"database"`
+	Schedule string `json:"schedule,omitempty"`
+	Status   string `json:"status"`
+}
+
+// Discoverer enrolls the databases of the configured servers
+type Discoverer struct {
+	config config.DiscoveryConfig
+	list   Lister
+	api    API
+}
+
+// New creates a discoverer of the servers of cfg
+func New(cfg config.DiscoveryConfig, list Lister, api API) *Discoverer {
+	return &Discoverer{config: cfg, list: list, api: api}
+}
+
+// ScheduleName returns the name of the schedule enrolling database from
+// server
+func ScheduleName(server, database string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, server+"-"+strings.TrimLeft(database, "/."))
+	return "discovered-" + strings.Trim(name, "-")
+}
+
+// Discover lists the databases of every server and creates the schedules
+// of those not backed up yet, unless dryRun. A server that cannot be
+// listed does not stop the others; its error is returned with the
+// enrollments of the rest.
+func (d *Discoverer) Discover(ctx context.Context, dryRun bool) ([]Enrollment, error) {
+	schedules, err := d.api.ListSchedules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+
+	names := make([]string, 0, len(d.config.Servers))
+	for name := range d.config.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var enrollments []Enrollment
+	var errs []error
+	for _, name := range names {
+		server := d.config.Servers[name]
+		databases, err := d.list(ctx, server.Profile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list the databases of %s: %w", name, err))
+			continue
+		}
+		sort.Strings(databases)
+
+		for _, db := range databases {
+			e := Enrollment{Server: name, Database: db, Schedule: ScheduleName(name, db)}
+			switch existing := scheduleOf(schedules, server.Profile, db, e.Schedule); {
+			case !server.Enrolls(db):
+				e.Status, e.Schedule = StatusExcluded, ""
+			case existing != nil:
+				e.Status, e.Schedule = StatusScheduled, existing.Name
+			default:
+				e.Status = StatusEnrolled
+				if !dryRun {
+					created, err := d.api.CreateSchedule(ctx, scheduleRequest(name, server, db, e.Schedule))
+					if err != nil {
+						errs = append(errs, fmt.Errorf("failed to enroll %s from %s: %w", db, name, err))
+						continue
+					}
+					schedules = append(schedules, *created)
+				}
+			}
+			enrollments = append(enrollments, e)
+		}
+	}
+	return enrollments, errors.Join(errs...)
+}
+
+// Run discovers every interval until the context is cancelled, handing
+// the new enrollments to enrolled. Errors are handed to failed and the
+// next discovery still runs, as a server may be down for a while.
+func (d *Discoverer) Run(ctx context.Context, enrolled func(context.Context, []Enrollment), failed func(error)) error {
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		enrollments, err := d.Discover(ctx, false)
+		if err != nil {
+			failed(err)
+		}
+		var created []Enrollment
+		for _, e := range enrollments {
+			if e.Status == StatusEnrolled {
+				created = append(created, e)
+			}
+		}
+		if len(created) > 0 {
+			enrolled(ctx, created)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduleOf returns the schedule backing up database with profile, or
+// named name
+func scheduleOf(schedules []client.Schedule, profile, database, name string) *client.Schedule {
+	for i, s := range schedules {
+		if s.Name == name || (strings.EqualFold(s.Backup.Profile, profile) && s.Backup.Database == database) {
+			return &schedules[i]
+		}
+	}
+	return nil
+}
+
+// scheduleRequest returns the schedule enrolling database from server
+func scheduleRequest(name string, server config.DiscoveryServer, database, schedule string) client.ScheduleRequest {
+	tags := make(map[string]string, len(server.Tags)+1)
+	for k, v := range server.Tags {
+		tags[k] = v
+	}
+	tags[TagServer] = name
+	return client.ScheduleRequest{
+		Name:     schedule,
+		Cron:     server.Cron,
+		Timezone: server.Timezone,
+		Enabled:  true,
+		Backup: client.BackupRequest{
+			Profile:  server.Profile,
+			Database: database,
+			Tags:     tags,
+		},
+	}
+}
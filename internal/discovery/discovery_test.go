@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/pkg/client"
+)
+
+type fakeAPI struct {
+	schedules []client.Schedule
+	created   []client.ScheduleRequest
+}
+
+func (f *fakeAPI) ListSchedules(ctx context.Context) ([]client.Schedule, error) {
+	return f.schedules, nil
+}
+
+func (f *fakeAPI) CreateSchedule(ctx context.Context, req client.ScheduleRequest) (*client.Schedule, error) {
+	f.created = append(f.created, req)
+	s := client.Schedule{ID: req.Name, Name: req.Name, Cron: req.Cron, Enabled: req.Enabled, Backup: req.Backup}
+	f.schedules = append(f.schedules, s)
+	return &s, nil
+}
+
+func testConfig() config.DiscoveryConfig {
+	return config.DiscoveryConfig{
+		Servers: map[string]config.DiscoveryServer{
+			"main": {
+				Profile: "orders",
+				Exclude: []string{"test_*"},
+				Cron:    "0 2 * * *",
+				Tags:    map[string]string{"tier": "standard"},
+			},
+			"down": {Profile: "legacy", Cron: "0 3 * * *"},
+		},
+	}
+}
+
+func testLister(ctx context.Context, profile string) ([]string, error) {
+	if profile == "legacy" {
+		return nil, errors.New("connection refused")
+	}
+	return []string{"orders", "billing", "test_orders", "Reports"}, nil
+}
+
+func TestDiscover(t *testing.T) {
+	api := &fakeAPI{schedules: []client.Schedule{
+		{Name: "nightly-orders", Backup: client.BackupRequest{Profile: "ORDERS", Database: "orders"}, Enabled: false},
+	}}
+	d := New(testConfig(), testLister, api)
+
+	enrollments, err := d.Discover(context.Background(), false)
+	if err == nil {
+		t.Error("listing error of the down server not returned")
+	}
+	want := map[string]string{
+		"Reports":     StatusEnrolled,
+		"billing":     StatusEnrolled,
+		"orders":      StatusScheduled,
+		"test_orders": StatusExcluded,
+	}
+	if len(enrollments) != len(want) {
+		t.Fatalf("enrollments = %+v", enrollments)
+	}
+	for _, e := range enrollments {
+		if want[e.Database] != e.Status {
+			t.Errorf("%s: status %s, want %s", e.Database, e.Status, want[e.Database])
+		}
+	}
+	if enrollments[2].Schedule != "nightly-orders" {
+		t.Errorf("existing schedule = %q", enrollments[2].Schedule)
+	}
+
+	if len(api.created) != 2 {
+		t.Fatalf("created = %+v", api.created)
+	}
+	req := api.created[0]
+	if req.Name != "discovered-main-reports" || req.Cron != "0 2 * * *" || !req.Enabled || req.Backup.Profile != "orders" || req.Backup.Database != "Reports" {
+		t.Errorf("schedule = %+v", req)
+	}
+	if req.Backup.Tags["tier"] != "standard" || req.Backup.Tags[TagServer] != "main" {
+		t.Errorf("tags = %v", req.Backup.Tags)
+	}
+
+	// The second discovery finds the schedules it created
+	enrollments, _ = d.Discover(context.Background(), false)
+	for _, e := range enrollments {
+		if e.Status == StatusEnrolled {
+			t.Errorf("%s enrolled twice", e.Database)
+		}
+	}
+}
+
+func TestDiscoverDryRun(t *testing.T) {
+	api := &fakeAPI{}
+	enrollments, _ := New(testConfig(), testLister, api).Discover(context.Background(), true)
+	if len(api.created) != 0 {
+		t.Errorf("dry run created %+v", api.created)
+	}
+	var enrolled int
+	for _, e := range enrollments {
+		if e.Status == StatusEnrolled {
+			enrolled++
+		}
+	}
+	if enrolled != 3 {
+		t.Errorf("enrollments = %+v", enrollments)
+	}
+}
+
+func TestScheduleName(t *testing.T) {
+	tests := map[[2]string]string{
+		{"main", "orders"}:               "discovered-main-orders",
+		{"EU West", "Orders_2024"}:       "discovered-eu-west-orders-2024",
+		{"files", "/var/lib/app/app.db"}: "discovered-files-var-lib-app-app-db",
+	}
+	for in, want := range tests {
+		if got := ScheduleName(in[0], in[1]); got != want {
+			t.Errorf("ScheduleName(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
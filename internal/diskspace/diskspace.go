@@ -0,0 +1,246 @@
+// Package diskspace watches free space on the temp directory and local
+// storage and removes temp files left behind by crashed jobs, so low disk
+// space is reported before a dump fails halfway with ENOSPC
+package diskspace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Usage is the space on the filesystem holding a path
+type Usage struct {
+	Path  string `json:"path"`
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"` // available to unprivileged users
+}
+
+// FreePercent is the free share of the filesystem
+func (u Usage) FreePercent() float64 {
+	if u.Total == 0 {
+		return 0
+	}
+	return float64(u.Free) / float64(u.Total) * 100
+}
+
+// Status is the usage of one watched path against the thresholds
+type Status struct {
+	Usage
+	FreePercent float64 `json:"free_percent"`
+	Low         bool    `json:"low"`
+	Reason      string  `json:"reason,omitempty"`
+	Err         string  `json:"error,omitempty"`
+}
+
+// Removed is a temp entry deleted, or that would be deleted, by Cleanup
+type Removed struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Watchdog checks the temp directory, local storage and any extra paths
+type Watchdog struct {
+	config   config.DiskWatchdogConfig
+	tempDir  string
+	paths    []string
+	minFree  uint64
+	mu       sync.Mutex
+	lowPaths map[string]bool
+}
+
+// New creates a watchdog for the backup temp directory, the local storage
+// path when local storage is enabled, and the configured extra paths
+func New(cfg *config.Config) (*Watchdog, error) {
+	wcfg := cfg.Backup.DiskWatchdog
+	if wcfg.Interval <= 0 {
+		wcfg.Interval = time.Minute
+	}
+
+	w := &Watchdog{config: wcfg, tempDir: cfg.Backup.TempDirectory, lowPaths: make(map[string]bool)}
+	if wcfg.MinFreeSpace != "" {
+		n, err := utils.ParseBytes(wcfg.MinFreeSpace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid disk_watchdog.min_free_space: %w", err)
+		}
+		w.minFree = uint64(n)
+	}
+
+	candidates := []string{cfg.Backup.TempDirectory}
+	if cfg.Storage.Providers.Local.Enabled {
+		candidates = append(candidates, cfg.Storage.Providers.Local.Path)
+	}
+	candidates = append(candidates, wcfg.Paths...)
+	for _, p := range candidates {
+		if p != "" && !utils.Contains(w.paths, p) {
+			w.paths = append(w.paths, p)
+		}
+	}
+	return w, nil
+}
+
+// Status reports the free space of every watched path
+func (w *Watchdog) Status() []Status {
+	statuses := make([]Status, 0, len(w.paths))
+	for _, p := range w.paths {
+		statuses = append(statuses, w.status(p))
+	}
+	return statuses
+}
+
+func (w *Watchdog) status(path string) Status {
+	s := Status{Usage: Usage{Path: path}}
+	u, err := DiskUsage(path)
+	if err != nil {
+		s.Err = err.Error()
+		return s
+	}
+	s.Usage = u
+	s.FreePercent = u.FreePercent()
+
+	switch {
+	case w.config.MinFreePercent > 0 && s.FreePercent < w.config.MinFreePercent:
+		s.Low = true
+		s.Reason = fmt.Sprintf("%.1f%% free, below %g%%", s.FreePercent, w.config.MinFreePercent)
+	case w.minFree > 0 && u.Free < w.minFree:
+		s.Low = true
+		s.Reason = fmt.Sprintf("%s free, below %s", utils.FormatBytes(int64(u.Free)), utils.FormatBytes(int64(w.minFree)))
+	}
+	return s
+}
+
+// Cleanup removes entries in the temp directory that nothing has written to
+// for TempMaxAge. Directories count as touched when any file inside them
+// is, so long-running jobs that keep writing are left alone. With dryRun
+// the entries are only reported.
+func (w *Watchdog) Cleanup(now time.Time, dryRun bool) ([]Removed, error) {
+	if w.tempDir == "" || w.config.TempMaxAge <= 0 {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(w.tempDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read temp directory: %w", err)
+	}
+
+	cutoff := now.Add(-w.config.TempMaxAge)
+	var removed []Removed
+	for _, e := range entries {
+		path := filepath.Join(w.tempDir, e.Name())
+		modTime, size, err := newestModTime(path)
+		if err != nil || modTime.After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(path); err != nil {
+				return removed, fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+		}
+		removed = append(removed, Removed{Path: path, Size: size, ModTime: modTime})
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Path < removed[j].Path })
+	return removed, nil
+}
+
+// Check runs a cleanup and returns alerts for paths that became low on
+// space, or recovered, since the previous check. Each low-space episode is
+// alerted once.
+func (w *Watchdog) Check(now time.Time) ([]*notification.Notification, []Removed, error) {
+	removed, err := w.Cleanup(now, false)
+	if err != nil {
+		return nil, removed, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var alerts []*notification.Notification
+	for _, s := range w.Status() {
+		if s.Err != "" || s.Low == w.lowPaths[s.Path] {
+			continue
+		}
+		w.lowPaths[s.Path] = s.Low
+		alerts = append(alerts, Alert(s, now))
+	}
+	return alerts, removed, nil
+}
+
+// Run checks every interval until the context is cancelled, handing alerts
+// to send. Delivery failures are reported through onError and do not stop
+// the watchdog.
+func (w *Watchdog) Run(ctx context.Context, send func(context.Context, *notification.Notification) error, onError func(error)) {
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		alerts, _, err := w.Check(time.Now())
+		if err != nil {
+			onError(err)
+		}
+		for _, n := range alerts {
+			if err := send(ctx, n); err != nil {
+				onError(err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Alert describes a low-space or recovered path for the notifiers
+func Alert(s Status, now time.Time) *notification.Notification {
+	if !s.Low {
+		return &notification.Notification{
+			Event:     notification.EventSuccess,
+			Title:     fmt.Sprintf("Disk space recovered on %s", s.Path),
+			Message:   fmt.Sprintf("%s free (%.1f%%)", utils.FormatBytes(int64(s.Free)), s.FreePercent),
+			Timestamp: now,
+		}
+	}
+	return &notification.Notification{
+		Event: notification.EventWarning,
+		Title: fmt.Sprintf("Low disk space on %s", s.Path),
+		Message: fmt.Sprintf("%s of %s free: %s. Backups writing here may fail with \"no space left on device\".",
+			utils.FormatBytes(int64(s.Free)), utils.FormatBytes(int64(s.Total)), s.Reason),
+		Timestamp: now,
+	}
+}
+
+// newestModTime returns the newest modification time and the total size
+// of a file or directory tree
+func newestModTime(path string) (time.Time, int64, error) {
+	var newest time.Time
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		if !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return newest, size, err
+}
@@ -0,0 +1,154 @@
+package diskspace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+func newWatchdog(t *testing.T, tempDir string, wcfg config.DiskWatchdogConfig) *Watchdog {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Backup.TempDirectory = tempDir
+	cfg.Backup.DiskWatchdog = wcfg
+	w, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return w
+}
+
+// touch creates a file and sets its modification time
+func touch(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("partial dump"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	tests := []struct {
+		name        string
+		dryRun      bool
+		wantRemoved []string
+		wantKept    []string
+	}{
+		{
+			name:        "removes abandoned entries",
+			wantRemoved: []string{"crashed.sql.gz", "stale-job"},
+			wantKept:    []string{"fresh.sql", "active-job"},
+		},
+		{
+			name:        "dry run keeps everything",
+			dryRun:      true,
+			wantRemoved: []string{"crashed.sql.gz", "stale-job"},
+			wantKept:    []string{"crashed.sql.gz", "stale-job", "fresh.sql", "active-job"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			touch(t, filepath.Join(dir, "crashed.sql.gz"), old)
+			touch(t, filepath.Join(dir, "stale-job", "chunk-0001"), old)
+			touch(t, filepath.Join(dir, "fresh.sql"), now)
+			// A directory with an old file is still in use while
+			// anything inside it is being written
+			touch(t, filepath.Join(dir, "active-job", "chunk-0001"), old)
+			touch(t, filepath.Join(dir, "active-job", "chunk-0002"), now)
+			for _, sub := range []string{"stale-job", "active-job"} {
+				if err := os.Chtimes(filepath.Join(dir, sub), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w := newWatchdog(t, dir, config.DiskWatchdogConfig{TempMaxAge: 24 * time.Hour})
+			removed, err := w.Cleanup(now, tt.dryRun)
+			if err != nil {
+				t.Fatalf("Cleanup() error = %v", err)
+			}
+
+			if len(removed) != len(tt.wantRemoved) {
+				t.Fatalf("removed %d entries, want %d: %v", len(removed), len(tt.wantRemoved), removed)
+			}
+			for i, name := range tt.wantRemoved {
+				if removed[i].Path != filepath.Join(dir, name) {
+					t.Errorf("removed[%d] = %s, want %s", i, removed[i].Path, name)
+				}
+			}
+			for _, name := range tt.wantKept {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("%s should be kept: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckAlertsOncePerEpisode(t *testing.T) {
+	dir := t.TempDir()
+	// No filesystem has an exabyte free
+	w := newWatchdog(t, dir, config.DiskWatchdogConfig{MinFreeSpace: "1000000TB"})
+
+	alerts, _, err := w.Check(time.Now())
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].Event != notification.EventWarning {
+		t.Fatalf("first check alerts = %v, want one warning", alerts)
+	}
+
+	alerts, _, _ = w.Check(time.Now())
+	if len(alerts) != 0 {
+		t.Errorf("repeated check alerts = %v, want none", alerts)
+	}
+
+	// Lowering the threshold clears the episode
+	w.minFree = 1
+	alerts, _, _ = w.Check(time.Now())
+	if len(alerts) != 1 || alerts[0].Event != notification.EventSuccess {
+		t.Errorf("recovery alerts = %v, want one success", alerts)
+	}
+}
+
+func TestStatusThresholds(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		config  config.DiskWatchdogConfig
+		wantLow bool
+	}{
+		{"no thresholds", config.DiskWatchdogConfig{}, false},
+		{"percent unreachable", config.DiskWatchdogConfig{MinFreePercent: 100.5}, true},
+		{"bytes unreachable", config.DiskWatchdogConfig{MinFreeSpace: "1000000TB"}, true},
+		{"bytes satisfied", config.DiskWatchdogConfig{MinFreeSpace: "1B"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statuses := newWatchdog(t, dir, tt.config).Status()
+			if len(statuses) != 1 {
+				t.Fatalf("got %d statuses, want 1", len(statuses))
+			}
+			s := statuses[0]
+			if s.Err != "" {
+				t.Fatalf("status error = %s", s.Err)
+			}
+			if s.Low != tt.wantLow {
+				t.Errorf("Low = %v (%s), want %v", s.Low, s.Reason, tt.wantLow)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package diskspace
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// DiskUsage reports the space on the filesystem holding path
+func DiskUsage(path string) (Usage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return Usage{}, fmt.Errorf("failed to stat filesystem of %s: %w", path, err)
+	}
+	bsize := uint64(st.Bsize)
+	return Usage{
+		Path:  path,
+		Total: uint64(st.Blocks) * bsize,
+		Free:  uint64(st.Bavail) * bsize,
+	}, nil
+}
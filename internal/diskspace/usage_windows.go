@@ -0,0 +1,22 @@
+//go:build windows
+
+package diskspace
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// DiskUsage reports the space on the volume holding path
+func DiskUsage(path string) (Usage, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return Usage{}, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, &totalFree); err != nil {
+		return Usage{}, fmt.Errorf("failed to query free space of %s: %w", path, err)
+	}
+	return Usage{Path: path, Total: total, Free: free}, nil
+}
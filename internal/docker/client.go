@@ -0,0 +1,258 @@
+// Package docker finds database containers through the Docker API and
+// dumps them with `docker exec`, running the dump tools shipped in the
+// database image. Compose stacks can be backed up this way without
+// publishing their database ports or installing client tools on the host.
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/execdump"
+)
+
+// DefaultHost is the Docker daemon socket used when neither the
+// configuration nor DOCKER_HOST name one
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// ErrNotFound is returned for containers the daemon does not know
+var ErrNotFound = errors.New("container not found")
+
+// Client is a minimal client of the Docker Engine API, covering what
+// discovery and exec dumps need
+type Client struct {
+	base   string
+	client *http.Client
+}
+
+// NewClient creates a client of the daemon at host, a unix:// socket or a
+// tcp:// address. An empty host uses DOCKER_HOST, then DefaultHost.
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = DefaultHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		// The host part of the URL is ignored by the dialer
+		return newClient("http://docker", &http.Client{Transport: transport}), nil
+	case "tcp", "http":
+		return newClient("http://"+u.Host, &http.Client{}), nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q: use unix:// or tcp://", host)
+	}
+}
+
+func newClient(base string, client *http.Client) *Client {
+	return &Client{base: strings.TrimRight(base, "/"), client: client}
+}
+
+// container is the part of a container inspection discovery reads
+type container struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Env    []string          `json:"Env"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	State struct {
+		Running bool `json:"Running"`
+	} `json:"State"`
+}
+
+// list returns the IDs of the running containers carrying all of labels,
+// each "key" or "key=value"
+func (c *Client) list(ctx context.Context, labels []string) ([]string, error) {
+	filters := map[string][]string{"status": {"running"}}
+	if len(labels) > 0 {
+		filters["label"] = labels
+	}
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+	var out []struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/containers/json?filters="+url.QueryEscape(string(data)), nil, &out); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(out))
+	for i, ct := range out {
+		ids[i] = ct.ID
+	}
+	return ids, nil
+}
+
+// inspect returns a container by ID or name
+func (c *Client) inspect(ctx context.Context, id string) (*container, error) {
+	var ct container
+	if err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", nil, &ct); err != nil {
+		return nil, err
+	}
+	return &ct, nil
+}
+
+// Exec runs cmd in a container with env added to its environment, writing
+// its stdout to stdout. A non-zero exit is returned as an
+// *execdump.ExitError with the end of stderr. Cancelling ctx closes the
+// stream; the process is stopped by the daemon once its output has nowhere
+// to go.
+func (c *Client) Exec(ctx context.Context, id string, cmd, env []string, stdout io.Writer) error {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/exec", map[string]any{
+		"Cmd":          cmd,
+		"Env":          env,
+		"AttachStdout": true,
+		"AttachStderr": true,
+	}, &created)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.send(ctx, http.MethodPost, "/exec/"+created.ID+"/start", map[string]any{"Detach": false, "Tty": false})
+	if err != nil {
+		return err
+	}
+	stderr := &execdump.Stderr{}
+	err = demux(resp.Body, stdout, stderr)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	// The stream can end a moment before the daemon records the exit code
+	var state struct {
+		Running  bool `json:"Running"`
+		ExitCode int  `json:"ExitCode"`
+	}
+	for attempt := 0; ; attempt++ {
+		if err := c.do(ctx, http.MethodGet, "/exec/"+created.ID+"/json", nil, &state); err != nil {
+			return err
+		}
+		if !state.Running || attempt == 50 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if state.Running {
+		return fmt.Errorf("%s still running after its output ended", cmd[0])
+	}
+	if state.ExitCode != 0 {
+		return &execdump.ExitError{Code: state.ExitCode, Stderr: stderr.String()}
+	}
+	return nil
+}
+
+// demux splits a multiplexed exec stream into stdout and stderr. Each
+// frame is a header of the stream (1 stdout, 2 stderr), three zero bytes
+// and a big-endian payload length, followed by the payload.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read exec stream: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		var w io.Writer
+		switch header[0] {
+		case 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			w = io.Discard
+		}
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return fmt.Errorf("failed to read exec stream: %w", err)
+		}
+	}
+}
+
+// do sends a request and decodes a successful response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends a request and returns a successful response
+func (c *Client) send(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker: %w", err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	// Docker errors are an object with a message
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	if resp.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/containers/") {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, apiErr.Message)
+	}
+	return nil, fmt.Errorf("docker: %s %s: %s: %s", method, strings.SplitN(path, "?", 2)[0], resp.Status, apiErr.Message)
+}
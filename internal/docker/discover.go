@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/execdump"
+)
+
+// Compose labels naming the project and service of a container
+const (
+	composeProject = "com.docker.compose.project"
+	composeService = "com.docker.compose.service"
+)
+
+// Target is a database container and the settings its dumps run with
+type Target struct {
+	ID                string `json:"id" yaml:"id"`
+	Name              string `json:"name" yaml:"name"` // project/service for compose containers
+	Image             string `json:"image" yaml:"image"`
+	execdump.Settings `yaml:",inline"`
+}
+
+// Discover returns the running database containers carrying all of
+// labels, sorted by name. Containers whose image is not a known database
+// are skipped unless labelled with execdump.LabelType.
+func (c *Client) Discover(ctx context.Context, labels []string) ([]Target, error) {
+	ids, err := c.list(ctx, labels)
+	if err != nil {
+		return nil, err
+	}
+	var targets []Target
+	for _, id := range ids {
+		ct, err := c.inspect(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			// Removed since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := target(ct); ok {
+			targets = append(targets, t)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	return targets, nil
+}
+
+// Find returns the database container named name: a discovered target
+// name, a container name or an ID
+func (c *Client) Find(ctx context.Context, labels []string, name string) (Target, error) {
+	targets, err := c.Discover(ctx, labels)
+	if err != nil {
+		return Target{}, err
+	}
+	for _, t := range targets {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	ct, err := c.inspect(ctx, name)
+	if err != nil {
+		return Target{}, err
+	}
+	t, ok := target(ct)
+	if !ok {
+		return Target{}, fmt.Errorf("container %s is not a known database image; label it %s", name, execdump.LabelType)
+	}
+	if !ct.State.Running {
+		return Target{}, fmt.Errorf("container %s is not running", name)
+	}
+	return t, nil
+}
+
+// target derives the dump settings of a container
+func target(ct *container) (Target, bool) {
+	env := make(map[string]string, len(ct.Config.Env))
+	for _, kv := range ct.Config.Env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	s, ok := execdump.Detect(ct.Config.Image, ct.Config.Labels, env)
+	if !ok {
+		return Target{}, false
+	}
+	t := Target{
+		ID:       ct.ID,
+		Name:     strings.TrimPrefix(ct.Name, "/"),
+		Image:    ct.Config.Image,
+		Settings: s,
+	}
+	if p, s := ct.Config.Labels[composeProject], ct.Config.Labels[composeService]; p != "" && s != "" {
+		t.Name = p + "/" + s
+	}
+	return t, true
+}
+
+// Dump runs the dump tool of the target's image inside its container and
+// writes the dump to w
+func (c *Client) Dump(ctx context.Context, t Target, opts execdump.Options, w io.Writer) error {
+	cmd, env, err := execdump.Command(t.Settings, opts)
+	if err != nil {
+		return err
+	}
+	if err := c.Exec(ctx, t.ID, cmd, env, w); err != nil {
+		return fmt.Errorf("%s in %s failed: %w", cmd[0], t.Name, err)
+	}
+	return nil
+}
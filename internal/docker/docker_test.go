@@ -0,0 +1,267 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/execdump"
+)
+
+// fakeDaemon serves the Docker API endpoints the client uses
+type fakeDaemon struct {
+	containers map[string]*container
+
+	mu    sync.Mutex
+	execs []execCall
+	// exec output and exit code
+	stdout, stderr string
+	exitCode       int
+}
+
+type execCall struct {
+	Container string
+	Cmd, Env  []string
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/containers/json":
+		var filters map[string][]string
+		json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		var out []map[string]string
+		for id, ct := range d.containers {
+			matches := ct.State.Running
+			for _, l := range filters["label"] {
+				k, v, hasValue := strings.Cut(l, "=")
+				got, ok := ct.Config.Labels[k]
+				matches = matches && ok && (!hasValue || got == v)
+			}
+			if matches {
+				out = append(out, map[string]string{"Id": id})
+			}
+		}
+		json.NewEncoder(w).Encode(out)
+	case parts[0] == "containers" && len(parts) == 3 && parts[2] == "json":
+		ct, ok := d.containers[parts[1]]
+		if !ok {
+			for _, c := range d.containers {
+				if c.Name == "/"+parts[1] {
+					ct, ok = c, true
+				}
+			}
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No such container: " + parts[1]})
+			return
+		}
+		json.NewEncoder(w).Encode(ct)
+	case parts[0] == "containers" && len(parts) == 3 && parts[2] == "exec":
+		var body struct{ Cmd, Env []string }
+		json.NewDecoder(r.Body).Decode(&body)
+		d.mu.Lock()
+		d.execs = append(d.execs, execCall{parts[1], body.Cmd, body.Env})
+		d.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"Id": "exec1"})
+	case r.URL.Path == "/exec/exec1/start":
+		w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+		frame := func(stream byte, data string) {
+			header := make([]byte, 8)
+			header[0] = stream
+			binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+			w.Write(header)
+			w.Write([]byte(data))
+		}
+		// Interleaved, as the daemon sends them
+		half := len(d.stdout) / 2
+		frame(1, d.stdout[:half])
+		frame(2, d.stderr)
+		frame(1, d.stdout[half:])
+	case r.URL.Path == "/exec/exec1/json":
+		json.NewEncoder(w).Encode(map[string]any{"Running": false, "ExitCode": d.exitCode})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newContainer(id, name, image string, env []string, labels map[string]string) *container {
+	ct := &container{ID: id, Name: "/" + name}
+	ct.Config.Image = image
+	ct.Config.Env = env
+	ct.Config.Labels = labels
+	ct.State.Running = true
+	return ct
+}
+
+// startDaemon serves d on a unix socket and returns a client of it
+func startDaemon(t *testing.T, d *fakeDaemon) *Client {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "docker.sock")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: d}
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Close() })
+
+	c, err := NewClient("unix://" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDiscover(t *testing.T) {
+	d := &fakeDaemon{containers: map[string]*container{
+		"pg": newContainer("pg", "shop-db-1", "postgres:16-alpine",
+			[]string{"POSTGRES_PASSWORD=secret", "POSTGRES_DB=shop"},
+			map[string]string{composeProject: "shop", composeService: "db"}),
+		"my": newContainer("my", "orders-mysql", "docker.io/library/mysql:8.4",
+			[]string{"MYSQL_ROOT_PASSWORD=rootpw", "MYSQL_USER=app", "MYSQL_PASSWORD=apppw", "MYSQL_DATABASE=orders"}, nil),
+		"maria": newContainer("maria", "wiki", "mariadb:11",
+			[]string{"MARIADB_USER=wiki", "MARIADB_PASSWORD=wikipw", "MARIADB_DATABASE=wiki"}, nil),
+		"mongo": newContainer("mongo", "events", "mongo:7",
+			[]string{"MONGO_INITDB_ROOT_USERNAME=admin", "MONGO_INITDB_ROOT_PASSWORD=mongopw"}, nil),
+		"bitnami": newContainer("bitnami", "legacy", "bitnami/postgresql:15",
+			[]string{"POSTGRESQL_USERNAME=legacy", "POSTGRESQL_PASSWORD=pw", "DB_SECRET=labelled"},
+			map[string]string{execdump.LabelDatabase: "archive", execdump.LabelPasswordEnv: "DB_SECRET"}),
+		"custom": newContainer("custom", "warehouse", "registry.internal/warehouse-pg:3",
+			[]string{"POSTGRES_USER=wh"}, map[string]string{execdump.LabelType: "postgres"}),
+		"skipped": newContainer("skipped", "scratch", "postgres:16", nil, map[string]string{execdump.LabelEnable: "false"}),
+		"web":     newContainer("web", "shop-web-1", "nginx:1.27", nil, nil),
+	}}
+	c := startDaemon(t, d)
+
+	targets, err := c.Discover(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tg := range targets {
+		names = append(names, tg.Name)
+	}
+	if want := []string{"events", "legacy", "orders-mysql", "shop/db", "warehouse", "wiki"}; !slices.Equal(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+
+	byName := make(map[string]Target)
+	for _, tg := range targets {
+		byName[tg.Name] = tg
+	}
+	tests := []struct {
+		name                    string
+		typ, user, password, db string
+	}{
+		{"shop/db", execdump.TypePostgres, "postgres", "secret", "shop"},
+		{"orders-mysql", execdump.TypeMySQL, "root", "rootpw", "orders"},
+		{"wiki", execdump.TypeMariaDB, "wiki", "wikipw", "wiki"},
+		{"events", execdump.TypeMongoDB, "admin", "mongopw", ""},
+		{"legacy", execdump.TypePostgres, "legacy", "labelled", "archive"},
+		{"warehouse", execdump.TypePostgres, "wh", "", "wh"},
+	}
+	for _, tt := range tests {
+		got := byName[tt.name]
+		if got.Type != tt.typ || got.User != tt.user || got.Password != tt.password || got.Database != tt.db {
+			t.Errorf("%s = %+v, want type %s user %s password %s database %s", tt.name, got, tt.typ, tt.user, tt.password, tt.db)
+		}
+	}
+
+	// Only labelled containers
+	targets, err = c.Discover(context.Background(), []string{composeProject + "=shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets[0].Name != "shop/db" {
+		t.Errorf("labelled targets = %+v", targets)
+	}
+}
+
+func TestFind(t *testing.T) {
+	d := &fakeDaemon{containers: map[string]*container{
+		"pg":  newContainer("pg", "shop-db-1", "postgres:16", nil, map[string]string{composeProject: "shop", composeService: "db"}),
+		"web": newContainer("web", "shop-web-1", "nginx:1.27", nil, nil),
+	}}
+	c := startDaemon(t, d)
+	ctx := context.Background()
+
+	for _, name := range []string{"shop/db", "shop-db-1", "pg"} {
+		if tg, err := c.Find(ctx, nil, name); err != nil || tg.ID != "pg" {
+			t.Errorf("Find(%q) = %+v, %v", name, tg, err)
+		}
+	}
+	if _, err := c.Find(ctx, nil, "shop-web-1"); err == nil || !strings.Contains(err.Error(), execdump.LabelType) {
+		t.Errorf("Find(web) err = %v, want not a database", err)
+	}
+	if _, err := c.Find(ctx, nil, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Find(missing) err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	d := &fakeDaemon{
+		containers: map[string]*container{
+			"pg": newContainer("pg", "shop-db-1", "postgres:16", []string{"POSTGRES_PASSWORD=secret", "POSTGRES_DB=shop"}, nil),
+		},
+		stdout: "PGDMP custom format dump",
+		stderr: "pg_dump: dumping contents of table public.orders\n",
+	}
+	c := startDaemon(t, d)
+	ctx := context.Background()
+	tg, err := c.Find(ctx, nil, "shop-db-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := c.Dump(ctx, tg, execdump.Options{Tables: []string{"orders"}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != d.stdout {
+		t.Errorf("dump = %q, want stdout only", out.String())
+	}
+	call := d.execs[0]
+	if call.Container != "pg" || call.Cmd[0] != "pg_dump" || call.Cmd[len(call.Cmd)-1] != "shop" || !slices.Contains(call.Cmd, "orders") {
+		t.Errorf("exec = %+v", call)
+	}
+	if !slices.Equal(call.Env, []string{"PGPASSWORD=secret"}) {
+		t.Errorf("env = %v, want the password in PGPASSWORD", call.Env)
+	}
+	if strings.Contains(strings.Join(call.Cmd, " "), "secret") {
+		t.Errorf("password on the command line: %v", call.Cmd)
+	}
+
+	d.exitCode, d.stderr = 1, "pg_dump: error: connection refused\n"
+	err = c.Dump(ctx, tg, execdump.Options{}, &out)
+	var exitErr *execdump.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 || !strings.Contains(exitErr.Stderr, "connection refused") {
+		t.Errorf("err = %v, want exit code 1 with stderr", err)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	for _, host := range []string{"unix:///var/run/docker.sock", "tcp://127.0.0.1:2375"} {
+		if _, err := NewClient(host); err != nil {
+			t.Errorf("NewClient(%q): %v", host, err)
+		}
+	}
+	if _, err := NewClient("ssh://user@host"); err == nil {
+		t.Error("ssh host accepted")
+	}
+	t.Setenv("DOCKER_HOST", "tcp://docker.internal:2375")
+	c, err := NewClient("")
+	if err != nil || c.base != "http://docker.internal:2375" {
+		t.Errorf("DOCKER_HOST client = %+v, %v", c, err)
+	}
+}
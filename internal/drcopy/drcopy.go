@@ -0,0 +1,268 @@
+// Package drcopy replicates backups to a second region or account for
+// disaster recovery. Each job copies completed backups to its own bucket,
+// with credentials of its own so a compromise of the primary account does
+// not reach the copies, and deletes them once its own retention passes.
+// The copies of each backup are tracked, so the catalog and the API tell
+// which backups survive the loss of the primary region.
+package drcopy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// Target is the bucket a job copies to
+type Target interface {
+	// Put copies the file at path to the object name, locked until
+	// retainUntil unless it is zero
+	Put(ctx context.Context, name, path string, retainUntil time.Time) error
+	Delete(ctx context.Context, name string) error
+	String() string
+}
+
+// S3Target copies to an S3 bucket
+type S3Target struct {
+	sink   *audit.S3Sink
+	prefix string
+}
+
+// Put implements Target
+func (t *S3Target) Put(ctx context.Context, name, file string, retainUntil time.Time) error {
+	return t.sink.PutFile(ctx, path.Join(t.prefix, name), file, retainUntil)
+}
+
+// Delete implements Target
+func (t *S3Target) Delete(ctx context.Context, name string) error {
+	return t.sink.Delete(ctx, path.Join(t.prefix, name))
+}
+
+func (t *S3Target) String() string {
+	return t.sink.String()
+}
+
+// Job is a configured copy job
+type Job struct {
+	Name      string
+	Bucket    string
+	Region    string
+	Target    Target
+	Retention time.Duration // 0 keeps copies
+	Lock      bool          // copies are locked until they expire
+	Databases []string      // empty for all
+}
+
+// NewJob creates the job called name
+func NewJob(name string, cfg config.DRJobConfig) (*Job, error) {
+	sink, err := audit.NewS3Sink(cfg.S3, cfg.LockMode)
+	if err != nil {
+		return nil, fmt.Errorf("dr job %s: %w", name, err)
+	}
+	return &Job{
+		Name:      name,
+		Bucket:    cfg.S3.Bucket,
+		Region:    cfg.S3.Region,
+		Target:    &S3Target{sink: sink, prefix: cfg.Prefix},
+		Retention: time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		Lock:      cfg.LockMode != "",
+		Databases: cfg.Databases,
+	}, nil
+}
+
+// Jobs creates every configured job, sorted by name
+func Jobs(cfg config.DRConfig) ([]*Job, error) {
+	names := make([]string, 0, len(cfg.Jobs))
+	for name := range cfg.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	jobs := make([]*Job, 0, len(names))
+	for _, name := range names {
+		j, err := NewJob(name, cfg.Jobs[name])
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Holds reports whether the job copies backups of database
+func (j *Job) Holds(database string) bool {
+	if len(j.Databases) == 0 {
+		return true
+	}
+	for _, db := range j.Databases {
+		if db == database {
+			return true
+		}
+	}
+	return false
+}
+
+// Backup is a completed backup to copy
+type Backup struct {
+	ID        string
+	Database  string
+	Checksum  string    // SHA-256 recorded in the catalog; empty skips the check
+	CreatedAt time.Time // retention counts from here
+}
+
+// Source makes the artifact of a backup available as a local file,
+// returning a function that releases it once copied
+type Source func(ctx context.Context, b Backup) (path string, release func(), err error)
+
+// Result is the outcome of copying or expiring one backup
+type Result struct {
+	Backup  Backup
+	Copy    *Copy // nil when skipped, or when interrupted
+	Skipped bool  // already copied, or older than the job's retention
+	Expired bool  // the copy was deleted
+	Err     error
+}
+
+// Replicator runs copy jobs and records the copies
+type Replicator struct {
+	Store  *Store
+	Source Source
+	now    func() time.Time
+}
+
+// NewReplicator creates a replicator reading artifacts from source
+func NewReplicator(store *Store, source Source) *Replicator {
+	return &Replicator{Store: store, Source: source, now: time.Now}
+}
+
+// Copy copies the backups the job holds and has not copied yet, passing
+// each result to report. Backups the job's retention would already have
+// deleted are skipped. A failed copy is recorded and reported without
+// stopping the others, and retried on the next run.
+func (r *Replicator) Copy(ctx context.Context, j *Job, backups []Backup, report func(Result)) error {
+	for _, b := range backups {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !j.Holds(b.Database) {
+			continue
+		}
+		report(r.copy(ctx, j, b))
+	}
+	return nil
+}
+
+func (r *Replicator) copy(ctx context.Context, j *Job, b Backup) Result {
+	res := Result{Backup: b}
+	existing, err := r.Store.Get(b.ID, j.Name)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if existing != nil && existing.Status != StatusFailed {
+		res.Skipped = true
+		return res
+	}
+
+	now := r.now().UTC()
+	c := Copy{
+		BackupID: b.ID,
+		Database: b.Database,
+		Job:      j.Name,
+		Bucket:   j.Bucket,
+		Region:   j.Region,
+		Status:   StatusCopied,
+		Attempts: 1,
+		CopiedAt: now,
+	}
+	if j.Retention > 0 {
+		created := b.CreatedAt
+		if created.IsZero() {
+			created = now
+		}
+		expires := created.Add(j.Retention).UTC()
+		if !expires.After(now) {
+			res.Skipped = true
+			return res
+		}
+		c.ExpiresAt = &expires
+	}
+
+	res.Err = r.put(ctx, j, b, &c)
+	if res.Err != nil && ctx.Err() != nil {
+		// Interrupted, not failed
+		return res
+	}
+	if res.Err != nil {
+		c.Status, c.Error = StatusFailed, res.Err.Error()
+	}
+	if err := r.Store.Record(c); err != nil && res.Err == nil {
+		res.Err = err
+	}
+	res.Copy = &c
+	return res
+}
+
+// put copies the artifact of b, filling in c
+func (r *Replicator) put(ctx context.Context, j *Job, b Backup, c *Copy) error {
+	src, release, err := r.Source(ctx, b)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// A corrupt artifact copied now is a corrupt recovery point later
+	sum, size, err := stream.HashFile(ctx, src)
+	if err != nil {
+		return err
+	}
+	if b.Checksum != "" && sum != b.Checksum {
+		return fmt.Errorf("the artifact does not match the checksum in the catalog")
+	}
+
+	c.Object = ObjectName(b, src)
+	var retainUntil time.Time
+	if j.Lock && c.ExpiresAt != nil {
+		retainUntil = *c.ExpiresAt
+	}
+	if err := j.Target.Put(ctx, c.Object, src, retainUntil); err != nil {
+		return err
+	}
+	c.Size, c.Checksum = size, sum
+	return nil
+}
+
+// Expire deletes the job's copies whose retention has passed, passing
+// each result to report. A failed delete is reported and retried on the
+// next run.
+func (r *Replicator) Expire(ctx context.Context, j *Job, report func(Result)) error {
+	due, err := r.Store.Due(j.Name, r.now())
+	if err != nil {
+		return err
+	}
+	for _, c := range due {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		res := Result{Backup: Backup{ID: c.BackupID, Database: c.Database}}
+		if res.Err = j.Target.Delete(ctx, c.Object); res.Err == nil {
+			c.Status, c.Attempts = StatusExpired, 0
+			res.Err = r.Store.Record(c)
+			res.Expired = res.Err == nil
+		}
+		res.Copy = &c
+		report(res)
+	}
+	return nil
+}
+
+// ObjectName is where the artifact at src of a backup is copied to:
+// <database>/<backup ID>/<file name>
+func ObjectName(b Backup, src string) string {
+	return path.Join(b.Database, b.ID, filepath.Base(src))
+}
@@ -0,0 +1,199 @@
+package drcopy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// memTarget keeps copies in memory
+type memTarget struct {
+	objects map[string][]byte
+	locked  map[string]time.Time
+	fail    error
+}
+
+func newMemTarget() *memTarget {
+	return &memTarget{objects: map[string][]byte{}, locked: map[string]time.Time{}}
+}
+
+func (m *memTarget) Put(_ context.Context, name, path string, retainUntil time.Time) error {
+	if m.fail != nil {
+		return m.fail
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m.objects[name] = data
+	if !retainUntil.IsZero() {
+		m.locked[name] = retainUntil
+	}
+	return nil
+}
+
+func (m *memTarget) Delete(_ context.Context, name string) error {
+	if m.fail != nil {
+		return m.fail
+	}
+	delete(m.objects, name)
+	return nil
+}
+
+func (m *memTarget) String() string { return "mem" }
+
+type fixture struct {
+	r      *Replicator
+	job    *Job
+	target *memTarget
+	now    time.Time
+	backup Backup
+}
+
+func newFixture(t *testing.T) *fixture {
+	dir := t.TempDir()
+	artifact := filepath.Join(dir, "orders.sql.gz")
+	if err := os.WriteFile(artifact, []byte("dump"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("dump"))
+	source := func(context.Context, Backup) (string, func(), error) {
+		return artifact, func() {}, nil
+	}
+	f := &fixture{
+		target: newMemTarget(),
+		now:    time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC),
+	}
+	f.r = NewReplicator(NewStore(config.DRConfig{StateFile: filepath.Join(dir, "dr.json")}), source)
+	f.r.now = func() time.Time { return f.now }
+	f.job = &Job{Name: "usw2", Bucket: "dr", Region: "us-west-2", Target: f.target, Retention: 7 * 24 * time.Hour}
+	f.backup = Backup{ID: "b1", Database: "orders", Checksum: hex.EncodeToString(sum[:]), CreatedAt: f.now.Add(-24 * time.Hour)}
+	return f
+}
+
+func (f *fixture) copy(t *testing.T, backups ...Backup) []Result {
+	var results []Result
+	if err := f.r.Copy(context.Background(), f.job, backups, func(r Result) { results = append(results, r) }); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestCopy(t *testing.T) {
+	f := newFixture(t)
+	results := f.copy(t, f.backup)
+	if len(results) != 1 || results[0].Err != nil || results[0].Copy == nil {
+		t.Fatalf("got %+v", results)
+	}
+	c := results[0].Copy
+	if c.Object != "orders/b1/orders.sql.gz" || string(f.target.objects[c.Object]) != "dump" || c.Size != 4 {
+		t.Errorf("got %+v", c)
+	}
+	if want := f.backup.CreatedAt.Add(f.job.Retention); c.ExpiresAt == nil || !c.ExpiresAt.Equal(want) {
+		t.Errorf("expires at %v, want %v", c.ExpiresAt, want)
+	}
+	if len(f.target.locked) != 0 {
+		t.Error("copy locked without lock_mode")
+	}
+
+	// Copied once only
+	if results := f.copy(t, f.backup); len(results) != 1 || !results[0].Skipped {
+		t.Errorf("second run: got %+v", results)
+	}
+	copies, err := f.r.Store.Copies("b1")
+	if err != nil || len(copies) != 1 || copies[0].Status != StatusCopied || copies[0].Region != "us-west-2" {
+		t.Errorf("copies: got %+v, %v", copies, err)
+	}
+}
+
+func TestCopyRetriesFailures(t *testing.T) {
+	f := newFixture(t)
+	f.target.fail = errors.New("access denied")
+	f.copy(t, f.backup)
+	results := f.copy(t, f.backup)
+	if len(results) != 1 || results[0].Err == nil || results[0].Copy.Status != StatusFailed {
+		t.Fatalf("got %+v", results)
+	}
+	c, _ := f.r.Store.Get("b1", "usw2")
+	if c.Attempts != 2 || c.Error != "access denied" {
+		t.Errorf("got %+v", c)
+	}
+
+	f.target.fail = nil
+	f.copy(t, f.backup)
+	c, _ = f.r.Store.Get("b1", "usw2")
+	if c.Status != StatusCopied || c.Attempts != 3 || c.Error != "" {
+		t.Errorf("got %+v", c)
+	}
+}
+
+func TestCopySkips(t *testing.T) {
+	f := newFixture(t)
+	f.job.Databases = []string{"billing"}
+	if results := f.copy(t, f.backup); len(results) != 0 {
+		t.Errorf("copied a database the job does not hold: %+v", results)
+	}
+
+	f.job.Databases = nil
+	old := f.backup
+	old.CreatedAt = f.now.Add(-8 * 24 * time.Hour)
+	if results := f.copy(t, old); len(results) != 1 || !results[0].Skipped {
+		t.Errorf("copied a backup past retention: %+v", results)
+	}
+
+	corrupt := f.backup
+	corrupt.Checksum = "00"
+	if results := f.copy(t, corrupt); results[0].Err == nil || len(f.target.objects) != 0 {
+		t.Errorf("copied a corrupt artifact: %+v", results)
+	}
+}
+
+func TestCopyLocked(t *testing.T) {
+	f := newFixture(t)
+	f.job.Lock = true
+	c := f.copy(t, f.backup)[0].Copy
+	if until := f.target.locked[c.Object]; !until.Equal(*c.ExpiresAt) {
+		t.Errorf("locked until %v, want %v", until, c.ExpiresAt)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	f := newFixture(t)
+	f.copy(t, f.backup)
+
+	var results []Result
+	report := func(r Result) { results = append(results, r) }
+	if err := f.r.Expire(context.Background(), f.job, report); err != nil || len(results) != 0 {
+		t.Fatalf("expired early: %+v, %v", results, err)
+	}
+
+	f.now = f.now.Add(7 * 24 * time.Hour)
+	f.target.fail = errors.New("unavailable")
+	if err := f.r.Expire(context.Background(), f.job, report); err != nil || len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("failed delete: %+v, %v", results, err)
+	}
+
+	results = nil
+	f.target.fail = nil
+	if err := f.r.Expire(context.Background(), f.job, report); err != nil || len(results) != 1 || !results[0].Expired {
+		t.Fatalf("got %+v, %v", results, err)
+	}
+	if len(f.target.objects) != 0 {
+		t.Error("object not deleted")
+	}
+	c, _ := f.r.Store.Get("b1", "usw2")
+	if c.Status != StatusExpired {
+		t.Errorf("got %+v", c)
+	}
+	// An expired copy is not made again
+	if results := f.copy(t, f.backup); len(results) != 1 || !results[0].Skipped {
+		t.Errorf("got %+v", results)
+	}
+}
@@ -0,0 +1,170 @@
+package drcopy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Copy statuses
+const (
+	StatusCopied  = "copied"
+	StatusFailed  = "failed"
+	StatusExpired = "expired" // deleted once the job's retention passed
+)
+
+// Copy is a backup copied by a DR job, or the last failed attempt at it
+type Copy struct {
+	BackupID  string     `json:"backup_id"`
+	Database  string     `json:"database"`
+	Job       string     `json:"job"`
+	Bucket    string     `json:"bucket"`
+	Region    string     `json:"region"`
+	Object    string     `json:"object,omitempty"`
+	Status    string     `json:"status"`
+	Size      int64      `json:"size,omitempty"`
+	Checksum  string     `json:"checksum,omitempty"`
+	Attempts  int        `json:"attempts"`
+	CopiedAt  time.Time  `json:"copied_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// state is the persisted copy status, by backup ID then job
+type state struct {
+	Copies map[string]map[string]*Copy `json:"copies"`
+}
+
+// Store keeps the DR copy status of each backup in a state file shared by
+// CLI runs and the API server
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore creates a store
+func NewStore(cfg config.DRConfig) *Store {
+	return &Store{path: cfg.StateFile}
+}
+
+// Record stores the outcome of a copy. A failure never replaces a
+// successful copy by the same job; repeated failures count the attempts.
+func (s *Store) Record(c Copy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+	copies := st.Copies[c.BackupID]
+	if copies == nil {
+		copies = make(map[string]*Copy)
+		st.Copies[c.BackupID] = copies
+	}
+	if existing, ok := copies[c.Job]; ok {
+		if existing.Status != StatusFailed && c.Status == StatusFailed {
+			return nil
+		}
+		c.Attempts += existing.Attempts
+	}
+	copies[c.Job] = &c
+	return s.save(st)
+}
+
+// Get returns the copy of a backup by a job, nil when there is none
+func (s *Store) Get(backupID, job string) (*Copy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return st.Copies[backupID][job], nil
+}
+
+// Copies returns the copies of a backup, newest first
+func (s *Store) Copies(backupID string) ([]Copy, error) {
+	return s.filter(func(c *Copy) bool { return c.BackupID == backupID })
+}
+
+// List returns every copy, newest first
+func (s *Store) List() ([]Copy, error) {
+	return s.filter(func(*Copy) bool { return true })
+}
+
+// Due returns the copies by a job whose retention has passed at now
+func (s *Store) Due(job string, now time.Time) ([]Copy, error) {
+	return s.filter(func(c *Copy) bool {
+		return c.Job == job && c.Status == StatusCopied && c.ExpiresAt != nil && !c.ExpiresAt.After(now)
+	})
+}
+
+func (s *Store) filter(keep func(*Copy) bool) ([]Copy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	var out []Copy
+	for _, copies := range st.Copies {
+		for _, c := range copies {
+			if keep(c) {
+				out = append(out, *c)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CopiedAt.Equal(out[j].CopiedAt) {
+			return out[i].CopiedAt.After(out[j].CopiedAt)
+		}
+		return out[i].Job < out[j].Job
+	})
+	return out, nil
+}
+
+func (s *Store) load() (*state, error) {
+	st := &state{Copies: make(map[string]map[string]*Copy)}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DR copy state: %w", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse DR copy state: %w", err)
+	}
+	if st.Copies == nil {
+		st.Copies = make(map[string]map[string]*Copy)
+	}
+	return st, nil
+}
+
+// save writes the state atomically
+func (s *Store) save(st *state) error {
+	if s.path == "" {
+		return fmt.Errorf("dr.state_file is not configured")
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal DR copy state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0750); err != nil {
+		return fmt.Errorf("failed to create DR copy state directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write DR copy state: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
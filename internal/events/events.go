@@ -0,0 +1,152 @@
+// Package events publishes structured backup, restore and alert events to
+// external event buses (Kafka, NATS, SNS) for downstream consumers
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Type identifies the kind of event
+type Type string
+
+const (
+	TypeBackupStarted    Type = "backup.started"
+	TypeBackupCompleted  Type = "backup.completed"
+	TypeBackupFailed     Type = "backup.failed"
+	TypeRestoreStarted   Type = "restore.started"
+	TypeRestoreCompleted Type = "restore.completed"
+	TypeRestoreFailed    Type = "restore.failed"
+	TypeAlertRaised      Type = "alert.raised"
+)
+
+// specVersion is the CloudEvents spec version the envelope follows
+const specVersion = "1.0"
+
+// Event is a structured event envelope, shaped after CloudEvents so
+// consumers can use off-the-shelf tooling
+type Event struct {
+	SpecVersion string                 `json:"specversion"`
+	ID          string                 `json:"id"`
+	Type        Type                   `json:"type"`
+	Source      string                 `json:"source"`
+	Subject     string                 `json:"subject,omitempty"`
+	Time        time.Time              `json:"time"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+// New creates an event with a generated ID and the current time. The
+// subject is typically the database name.
+func New(eventType Type, subject string, data map[string]interface{}) *Event {
+	id, err := utils.GenerateID("evt")
+	if err != nil {
+		id = fmt.Sprintf("evt_%d", time.Now().UnixNano())
+	}
+	return &Event{
+		SpecVersion: specVersion,
+		ID:          id,
+		Type:        eventType,
+		Subject:     subject,
+		Time:        time.Now().UTC(),
+		Data:        data,
+	}
+}
+
+// NewAlert creates an alert.raised event from the notification announcing
+// an alert. Source names what raised it, e.g. freshness, lockout or
+// ransomware.
+func NewAlert(source string, n *notification.Notification) *Event {
+	data := map[string]interface{}{
+		"alert":   source,
+		"title":   n.Title,
+		"message": n.Message,
+	}
+	if n.Severity != "" {
+		data["severity"] = n.Severity
+	}
+	if n.BackupID != "" {
+		data["backup_id"] = n.BackupID
+	}
+	if len(n.Fields) > 0 {
+		data["fields"] = n.Fields
+	}
+	return New(TypeAlertRaised, n.Database, data)
+}
+
+// Publisher is implemented by every event bus backend
+type Publisher interface {
+	// Name returns the backend name
+	Name() string
+	// Publish delivers a single event
+	Publish(ctx context.Context, event *Event) error
+	// Close releases any connections held by the publisher
+	Close() error
+}
+
+// Bus fans events out to every configured publisher
+type Bus struct {
+	source     string
+	types      []string
+	publishers []Publisher
+}
+
+// NewBus creates a bus. Events whose type does not match one of the type
+// patterns (exact or prefix like "backup.*") are dropped; an empty list
+// accepts everything.
+func NewBus(source string, types []string, publishers ...Publisher) *Bus {
+	return &Bus{source: source, types: types, publishers: publishers}
+}
+
+// Publish sends an event to every publisher, returning the combined errors
+func (b *Bus) Publish(ctx context.Context, event *Event) error {
+	if b == nil || len(b.publishers) == 0 || !b.accepts(event.Type) {
+		return nil
+	}
+	if event.Source == "" {
+		event.Source = b.source
+	}
+
+	var errs []error
+	for _, p := range b.publishers {
+		if err := p.Publish(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every publisher
+func (b *Bus) Close() error {
+	if b == nil {
+		return nil
+	}
+	var errs []error
+	for _, p := range b.publishers {
+		if err := p.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// accepts reports whether the bus is configured to publish an event type
+func (b *Bus) accepts(eventType Type) bool {
+	if len(b.types) == 0 {
+		return true
+	}
+	for _, pattern := range b.types {
+		if pattern == "*" || pattern == string(eventType) {
+			return true
+		}
+		if strings.HasSuffix(pattern, ".*") && strings.HasPrefix(string(eventType), strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+func TestNewAlert(t *testing.T) {
+	e := NewAlert("ransomware", &notification.Notification{
+		Title:    "Backup of orders deviates from its baseline",
+		Database: "orders",
+		BackupID: "b1",
+		Fields:   map[string]string{"Entropy": "7.99"},
+	})
+	if e.Type != TypeAlertRaised || e.Subject != "orders" {
+		t.Fatalf("NewAlert() = %s about %q", e.Type, e.Subject)
+	}
+	if e.Data["alert"] != "ransomware" || e.Data["backup_id"] != "b1" || e.Data["severity"] != nil {
+		t.Errorf("Data = %v", e.Data)
+	}
+	if !NewBus("db-backup", []string{"alert.*"}).accepts(e.Type) {
+		t.Error("alert.* does not accept the event")
+	}
+}
@@ -0,0 +1,50 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// NewBusFromConfig builds a bus with a publisher for every enabled backend.
+// A disabled configuration yields an empty bus that drops all events.
+func NewBusFromConfig(ctx context.Context, cfg config.EventsConfig) (*Bus, error) {
+	source := cfg.Source
+	if source == "" {
+		host, _ := os.Hostname()
+		source = "db-backup/" + host
+	}
+	if !cfg.Enabled {
+		return NewBus(source, cfg.Types), nil
+	}
+
+	var publishers []Publisher
+	if cfg.Kafka.Enabled {
+		p, err := NewKafkaPublisher(cfg.Kafka)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, p)
+	}
+	if cfg.NATS.Enabled {
+		p, err := NewNATSPublisher(cfg.NATS)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, p)
+	}
+	if cfg.SNS.Enabled {
+		p, err := NewSNSPublisher(ctx, cfg.SNS)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, p)
+	}
+	if len(publishers) == 0 {
+		return nil, fmt.Errorf("events enabled but no publisher (kafka, nats, sns) is enabled")
+	}
+
+	return NewBus(source, cfg.Types, publishers...), nil
+}
@@ -0,0 +1,82 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func TestKafkaPublish(t *testing.T) {
+	type request struct {
+		method, path, contentType, accept, user, pass string
+		body                                          string
+	}
+	received := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		user, pass, _ := r.BasicAuth()
+		received <- request{r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), r.Header.Get("Accept"), user, pass, string(body)}
+		io.WriteString(w, `{"offsets":[{"partition":0,"offset":42}]}`)
+	}))
+	defer srv.Close()
+
+	p, err := NewKafkaPublisher(config.KafkaEventsConfig{RestProxyURL: srv.URL + "/", Topic: "db backups", Username: "producer", Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &Event{SpecVersion: "1.0", ID: "evt_1", Type: TypeBackupCompleted, Subject: "orders", Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Data: map[string]interface{}{"size": 1024}}
+	if err := p.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-received
+	value, _ := json.Marshal(event)
+	want := request{
+		method: http.MethodPost, path: "/topics/db%20backups",
+		contentType: "application/vnd.kafka.json.v2+json", accept: "application/vnd.kafka.v2+json",
+		user: "producer", pass: "pw",
+		// Keyed by database so its events stay on one partition, in order
+		body: `{"records":[{"key":"orders","value":` + string(value) + `}]}`,
+	}
+	if got != want {
+		t.Errorf("request\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestKafkaPublishErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		err      string
+	}{
+		{"proxy error", http.StatusNotFound, `{"error_code":40401,"message":"Topic not found."}`, "status 404"},
+		{"record error", http.StatusOK, `{"offsets":[{"partition":null,"offset":null,"error_code":2,"error":"Record too large"}]}`, "Record too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.response)
+			}))
+			defer srv.Close()
+
+			p, _ := NewKafkaPublisher(config.KafkaEventsConfig{RestProxyURL: srv.URL, Topic: "backups"})
+			err := p.Publish(context.Background(), New(TypeBackupFailed, "orders", nil))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := NewKafkaPublisher(config.KafkaEventsConfig{RestProxyURL: "http://proxy"}); err == nil {
+		t.Error("publisher without a topic")
+	}
+}
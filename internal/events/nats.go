@@ -0,0 +1,202 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/security/cryptopolicy"
+)
+
+const (
+	natsDialTimeout = 5 * time.Second
+	natsIOTimeout   = 10 * time.Second
+	natsDefaultPort = "4222"
+)
+
+// NATSPublisher publishes events to NATS subjects using the core NATS text
+// protocol. Events go to "<subject_prefix>.<event type>", e.g.
+// "dbbackup.backup.completed".
+type NATSPublisher struct {
+	config config.NATSEventsConfig
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewNATSPublisher creates a new NATS publisher. The connection is
+// established lazily on first publish.
+func NewNATSPublisher(cfg config.NATSEventsConfig) (*NATSPublisher, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("nats: url is required")
+	}
+	if cfg.SubjectPrefix == "" {
+		cfg.SubjectPrefix = "dbbackup"
+	}
+	return &NATSPublisher{config: cfg}, nil
+}
+
+// Name returns the backend name
+func (n *NATSPublisher) Name() string {
+	return "nats"
+}
+
+// Publish sends the event and waits for the server to acknowledge the
+// round trip, so protocol errors surface to the caller
+func (n *NATSPublisher) Publish(ctx context.Context, event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	subject := n.config.SubjectPrefix + "." + string(event.Type)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Retry once on a stale connection
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = n.ensureConnected(ctx); err != nil {
+			return err
+		}
+		if err = n.publish(subject, payload); err == nil {
+			return nil
+		}
+		n.closeLocked()
+	}
+	return err
+}
+
+// Close closes the connection
+func (n *NATSPublisher) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.closeLocked()
+}
+
+func (n *NATSPublisher) closeLocked() error {
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	n.reader = nil
+	return err
+}
+
+// ensureConnected dials the server and performs the CONNECT handshake
+func (n *NATSPublisher) ensureConnected(ctx context.Context) error {
+	if n.conn != nil {
+		return nil
+	}
+
+	u, err := url.Parse(n.config.URL)
+	if err != nil {
+		return fmt.Errorf("nats: invalid url: %w", err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+
+	dialer := &net.Dialer{Timeout: natsDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("nats: failed to connect: %w", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(natsIOTimeout))
+	reader := bufio.NewReader(conn)
+
+	// Server greets with INFO {...}
+	info, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting: %q", strings.TrimSpace(info))
+	}
+
+	if u.Scheme == "tls" || strings.Contains(info, `"tls_required":true`) {
+		tlsCfg := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		cryptopolicy.HardenTLS(tlsCfg)
+		tlsConn := tls.Client(conn, tlsCfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return fmt.Errorf("nats: tls handshake failed: %w", err)
+		}
+		conn = tlsConn
+		reader = bufio.NewReader(conn)
+	}
+
+	connectOpts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "db-backup",
+		"lang":     "go",
+		"protocol": 1,
+	}
+	switch {
+	case n.config.Token != "":
+		connectOpts["auth_token"] = n.config.Token
+	case n.config.Username != "":
+		connectOpts["user"] = n.config.Username
+		connectOpts["pass"] = n.config.Password
+	case u.User != nil:
+		connectOpts["user"] = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			connectOpts["pass"] = p
+		}
+	}
+	opts, _ := json.Marshal(connectOpts)
+
+	n.conn = conn
+	n.reader = reader
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", opts); err != nil {
+		n.closeLocked()
+		return fmt.Errorf("nats: connect failed: %w", err)
+	}
+	if err := n.pingPong(); err != nil {
+		n.closeLocked()
+		return err
+	}
+	return nil
+}
+
+// publish writes a PUB frame and confirms it with PING/PONG
+func (n *NATSPublisher) publish(subject string, payload []byte) error {
+	_ = n.conn.SetDeadline(time.Now().Add(natsIOTimeout))
+	if _, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\n", subject, len(payload), payload); err != nil {
+		return fmt.Errorf("nats: publish failed: %w", err)
+	}
+	return n.pingPong()
+}
+
+// pingPong flushes pending frames and reads until PONG, surfacing -ERR
+func (n *NATSPublisher) pingPong() error {
+	if _, err := fmt.Fprint(n.conn, "PING\r\n"); err != nil {
+		return fmt.Errorf("nats: ping failed: %w", err)
+	}
+	for {
+		line, err := n.reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("nats: read failed: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := fmt.Fprint(n.conn, "PONG\r\n"); err != nil {
+				return fmt.Errorf("nats: pong failed: %w", err)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// natsServer accepts connections on a local port and runs serve on each,
+// returning the address to put in a nats:// URL
+func natsServer(t *testing.T, serve func(conn net.Conn, r *bufio.Reader)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				serve(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// natsTranscript greets with info, answers every PING and sends what the
+// client wrote on done once it hangs up
+func natsTranscript(info string, done chan<- string) func(net.Conn, *bufio.Reader) {
+	return func(conn net.Conn, r *bufio.Reader) {
+		io.WriteString(conn, "INFO "+info+"\r\n")
+		var sb strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			sb.WriteString(line)
+			if err != nil {
+				done <- sb.String()
+				return
+			}
+			if line == "PING\r\n" {
+				io.WriteString(conn, "PONG\r\n")
+			}
+		}
+	}
+}
+
+func TestNATSPublishWire(t *testing.T) {
+	event := &Event{SpecVersion: "1.0", ID: "evt_1", Type: TypeBackupCompleted, Subject: "orders", Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	payload, _ := json.Marshal(event)
+
+	tests := []struct {
+		name    string
+		url     func(addr string) string
+		cfg     config.NATSEventsConfig
+		connect string
+	}{
+		{
+			"token", func(addr string) string { return "nats://" + addr },
+			config.NATSEventsConfig{Token: "s3cret", SubjectPrefix: "ops"},
+			`{"auth_token":"s3cret","lang":"go","name":"db-backup","pedantic":false,"protocol":1,"verbose":false}`,
+		},
+		{
+			"user in config", func(addr string) string { return "nats://" + addr },
+			config.NATSEventsConfig{Username: "backup", Password: "pw", SubjectPrefix: "ops"},
+			`{"lang":"go","name":"db-backup","pass":"pw","pedantic":false,"protocol":1,"user":"backup","verbose":false}`,
+		},
+		{
+			"user in url", func(addr string) string { return "nats://u:p@" + addr },
+			config.NATSEventsConfig{SubjectPrefix: "ops"},
+			`{"lang":"go","name":"db-backup","pass":"p","pedantic":false,"protocol":1,"user":"u","verbose":false}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan string, 1)
+			addr := natsServer(t, natsTranscript(`{"server_id":"test"}`, done))
+			tt.cfg.URL = tt.url(addr)
+			p, err := NewNATSPublisher(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Publish(context.Background(), event); err != nil {
+				t.Fatal(err)
+			}
+			p.Close()
+
+			want := "CONNECT " + tt.connect + "\r\nPING\r\n" +
+				"PUB ops.backup.completed " + strconv.Itoa(len(payload)) + "\r\n" + string(payload) + "\r\nPING\r\n"
+			if got := <-done; got != want {
+				t.Errorf("client wrote\n%q\nwant\n%q", got, want)
+			}
+		})
+	}
+}
+
+func TestNATSServerPing(t *testing.T) {
+	done := make(chan string, 1)
+	addr := natsServer(t, func(conn net.Conn, r *bufio.Reader) {
+		io.WriteString(conn, "INFO {}\r\n")
+		var sb strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			sb.WriteString(line)
+			if err != nil {
+				done <- sb.String()
+				return
+			}
+			// The server pings too; the client answers before its PONG
+			if line == "PING\r\n" {
+				io.WriteString(conn, "PING\r\n+OK\r\nPONG\r\n")
+			}
+		}
+	})
+	p, _ := NewNATSPublisher(config.NATSEventsConfig{URL: "nats://" + addr})
+	if err := p.Publish(context.Background(), New(TypeBackupStarted, "orders", nil)); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if got := <-done; strings.Count(got, "PONG\r\n") != 2 {
+		t.Errorf("client did not answer the server's pings:\n%q", got)
+	}
+}
+
+func TestNATSErrors(t *testing.T) {
+	var conns atomic.Int32
+	addr := natsServer(t, func(conn net.Conn, r *bufio.Reader) {
+		conns.Add(1)
+		io.WriteString(conn, "INFO {}\r\n")
+		pings := 0
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "PING\r\n" {
+				if pings++; pings == 1 {
+					io.WriteString(conn, "PONG\r\n")
+				} else {
+					io.WriteString(conn, "-ERR 'Permissions Violation for Publish to dbbackup.backup.failed'\r\n")
+				}
+			}
+		}
+	})
+	p, _ := NewNATSPublisher(config.NATSEventsConfig{URL: "nats://" + addr})
+	defer p.Close()
+	err := p.Publish(context.Background(), New(TypeBackupFailed, "orders", nil))
+	if err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Fatalf("error = %v", err)
+	}
+	// The publish is retried once on a new connection
+	if n := conns.Load(); n != 2 {
+		t.Errorf("%d connections, want 2", n)
+	}
+
+	greeting := natsServer(t, func(conn net.Conn, r *bufio.Reader) {
+		io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n")
+	})
+	p, _ = NewNATSPublisher(config.NATSEventsConfig{URL: "nats://" + greeting})
+	if err := p.Publish(context.Background(), New(TypeBackupFailed, "orders", nil)); err == nil || !strings.Contains(err.Error(), "unexpected greeting") {
+		t.Errorf("not a NATS server: %v", err)
+	}
+
+	if _, err := NewNATSPublisher(config.NATSEventsConfig{}); err == nil {
+		t.Error("publisher without a url")
+	}
+}
+
+func TestNATSTLSUpgrade(t *testing.T) {
+	tests := []struct {
+		name, scheme, info string
+	}{
+		{"required by the server", "nats", `{"tls_required":true}`},
+		{"tls url", "tls", `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := make(chan byte, 1)
+			addr := natsServer(t, func(conn net.Conn, r *bufio.Reader) {
+				io.WriteString(conn, "INFO "+tt.info+"\r\n")
+				// The client's next bytes are a TLS handshake record, not CONNECT
+				b, err := r.ReadByte()
+				if err != nil {
+					b = 0
+				}
+				first <- b
+			})
+			p, _ := NewNATSPublisher(config.NATSEventsConfig{URL: tt.scheme + "://" + addr})
+			err := p.Publish(context.Background(), New(TypeBackupStarted, "orders", nil))
+			if b := <-first; b != 0x16 {
+				t.Errorf("client sent %#x after INFO, want a TLS handshake", b)
+			}
+			if err == nil || !strings.Contains(err.Error(), "tls handshake failed") {
+				t.Errorf("error = %v", err)
+			}
+		})
+	}
+}
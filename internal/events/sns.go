@@ -0,0 +1,142 @@
+package events
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// SNSPublisher publishes events to an Amazon SNS topic using the SNS query
+// API signed with SigV4
+type SNSPublisher struct {
+	config      config.SNSEventsConfig
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	client      *http.Client
+	endpoint    string
+}
+
+// NewSNSPublisher creates a new SNS publisher. Static keys from config are
+// used when set, otherwise the default AWS credential chain applies.
+func NewSNSPublisher(ctx context.Context, cfg config.SNSEventsConfig) (*SNSPublisher, error) {
+	if cfg.TopicARN == "" || cfg.Region == "" {
+		return nil, fmt.Errorf("sns: topic_arn and region are required")
+	}
+
+	var creds aws.CredentialsProvider
+	if cfg.AccessKey != "" {
+		creds = credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, "")
+	} else {
+		awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.Region))
+		if err != nil {
+			return nil, fmt.Errorf("sns: failed to load AWS credentials: %w", err)
+		}
+		creds = awsCfg.Credentials
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://sns.%s.amazonaws.com/", cfg.Region)
+	}
+
+	return &SNSPublisher{
+		config:      cfg,
+		credentials: creds,
+		signer:      v4.NewSigner(),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		endpoint:    endpoint,
+	}, nil
+}
+
+// Name returns the backend name
+func (s *SNSPublisher) Name() string {
+	return "sns"
+}
+
+// Publish sends the event as the message body, with the event type as a
+// message attribute so subscriptions can filter on it
+func (s *SNSPublisher) Publish(ctx context.Context, event *Event) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	form := url.Values{}
+	form.Set("Action", "Publish")
+	form.Set("Version", "2010-03-31")
+	form.Set("TopicArn", s.config.TopicARN)
+	form.Set("Message", string(message))
+	form.Set("Subject", truncateSubject(fmt.Sprintf("db-backup %s %s", event.Type, event.Subject)))
+	form.Set("MessageAttributes.entry.1.Name", "event_type")
+	form.Set("MessageAttributes.entry.1.Value.DataType", "String")
+	form.Set("MessageAttributes.entry.1.Value.StringValue", string(event.Type))
+	if event.Subject != "" {
+		form.Set("MessageAttributes.entry.2.Name", "database")
+		form.Set("MessageAttributes.entry.2.Value.DataType", "String")
+		form.Set("MessageAttributes.entry.2.Value.StringValue", event.Subject)
+	}
+
+	// FIFO topics require a group and deduplication ID
+	if strings.HasSuffix(s.config.TopicARN, ".fifo") {
+		group := event.Subject
+		if group == "" {
+			group = "db-backup"
+		}
+		form.Set("MessageGroupId", group)
+		form.Set("MessageDeduplicationId", event.ID)
+	}
+
+	body := form.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	creds, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256([]byte(body))
+	if err := s.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "sns", s.config.Region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("publish request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("publish failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// Close is a no-op for the HTTP based publisher
+func (s *SNSPublisher) Close() error {
+	return nil
+}
+
+// truncateSubject keeps the SNS subject within its 100 character limit
+func truncateSubject(s string) string {
+	if len(s) <= 100 {
+		return s
+	}
+	return s[:97] + "..."
+}
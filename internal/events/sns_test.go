@@ -0,0 +1,159 @@
+package events
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// snsRequest is what the test server received
+type snsRequest struct {
+	form      url.Values
+	signature string // recomputed from the request, empty if it matches
+	err       string
+}
+
+// verifySigV4 signs the request received again with the credentials and
+// the time it was signed at, and reports a mismatch
+func verifySigV4(r *http.Request, body []byte, creds aws.Credentials, region string) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/") ||
+		!strings.Contains(auth, "/"+region+"/sns/aws4_request") {
+		return "authorization " + auth
+	}
+	signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "x-amz-date: " + err.Error()
+	}
+
+	// Only the headers the client signed, the transport adds others
+	signed := auth[strings.Index(auth, "SignedHeaders=")+len("SignedHeaders="):]
+	signed = signed[:strings.Index(signed, ",")]
+	req, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), strings.NewReader(string(body)))
+	for _, h := range strings.Split(signed, ";") {
+		if h != "host" {
+			req.Header.Set(h, r.Header.Get(h))
+		}
+	}
+	hash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(context.Background(), creds, req, hex.EncodeToString(hash[:]), "sns", region, signedAt); err != nil {
+		return err.Error()
+	}
+	if got := req.Header.Get("Authorization"); got != auth {
+		return "signed " + auth + ", want " + got
+	}
+	return ""
+}
+
+func snsServer(t *testing.T, status int, response string) (*httptest.Server, <-chan snsRequest) {
+	t.Helper()
+	received := make(chan snsRequest, 1)
+	creds := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := snsRequest{signature: verifySigV4(r, body, creds, "eu-west-1")}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded; charset=utf-8" {
+			req.err = r.Method + " " + r.Header.Get("Content-Type")
+		}
+		req.form, _ = url.ParseQuery(string(body))
+		received <- req
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func newTestSNS(t *testing.T, endpoint, topic string) *SNSPublisher {
+	t.Helper()
+	p, err := NewSNSPublisher(context.Background(), config.SNSEventsConfig{
+		Region: "eu-west-1", TopicARN: topic, AccessKey: "AKIDEXAMPLE", SecretKey: "secret", Endpoint: endpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSNSPublish(t *testing.T) {
+	srv, received := snsServer(t, http.StatusOK, "<PublishResponse/>")
+	topic := "arn:aws:sns:eu-west-1:123456789012:backups"
+	event := &Event{SpecVersion: "1.0", ID: "evt_1", Type: TypeBackupCompleted, Subject: "orders", Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := newTestSNS(t, srv.URL+"/", topic).Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-received
+	if req.signature != "" || req.err != "" {
+		t.Fatalf("bad request: %s %s", req.signature, req.err)
+	}
+	message, _ := json.Marshal(event)
+	want := url.Values{
+		"Action":                         {"Publish"},
+		"Version":                        {"2010-03-31"},
+		"TopicArn":                       {topic},
+		"Message":                        {string(message)},
+		"Subject":                        {"db-backup backup.completed orders"},
+		"MessageAttributes.entry.1.Name": {"event_type"},
+		"MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"MessageAttributes.entry.1.Value.StringValue": {"backup.completed"},
+		"MessageAttributes.entry.2.Name":              {"database"},
+		"MessageAttributes.entry.2.Value.DataType":    {"String"},
+		"MessageAttributes.entry.2.Value.StringValue": {"orders"},
+	}
+	if req.form.Encode() != want.Encode() {
+		t.Errorf("form\n%s\nwant\n%s", req.form.Encode(), want.Encode())
+	}
+}
+
+func TestSNSPublishFIFO(t *testing.T) {
+	srv, received := snsServer(t, http.StatusOK, "")
+	p := newTestSNS(t, srv.URL+"/", "arn:aws:sns:eu-west-1:123456789012:backups.fifo")
+
+	event := New(TypeBackupStarted, "", nil)
+	if err := p.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	req := <-received
+	if req.form.Get("MessageGroupId") != "db-backup" || req.form.Get("MessageDeduplicationId") != event.ID {
+		t.Errorf("fifo fields %v", req.form)
+	}
+	if req.form.Has("MessageAttributes.entry.2.Name") {
+		t.Error("database attribute without a subject")
+	}
+
+	long := New(TypeBackupStarted, strings.Repeat("d", 200), nil)
+	p.Publish(context.Background(), long)
+	req = <-received
+	if s := req.form.Get("Subject"); len(s) != 100 || !strings.HasSuffix(s, "...") {
+		t.Errorf("subject %q not truncated to 100 characters", s)
+	}
+	if req.form.Get("MessageGroupId") != long.Subject {
+		t.Error("fifo group is not the database")
+	}
+}
+
+func TestSNSPublishError(t *testing.T) {
+	srv, received := snsServer(t, http.StatusForbidden, "<Error><Code>AuthorizationError</Code></Error>\n")
+	err := newTestSNS(t, srv.URL+"/", "arn:aws:sns:eu-west-1:123456789012:backups").Publish(context.Background(), New(TypeBackupFailed, "orders", nil))
+	<-received
+	if err == nil || !strings.Contains(err.Error(), "status 403") || !strings.Contains(err.Error(), "AuthorizationError") {
+		t.Errorf("error = %v", err)
+	}
+
+	if _, err := NewSNSPublisher(context.Background(), config.SNSEventsConfig{TopicARN: "arn"}); err == nil {
+		t.Error("publisher without a region")
+	}
+}
@@ -0,0 +1,356 @@
+// Package execdump builds the dump commands run inside database
+// containers, by docker exec or a Kubernetes pod exec, with the dump tools
+// shipped in the database image. Settings are derived from the
+// environment variables the official and Bitnami images are configured
+// with, so containers can be dumped without any configuration of their own.
+package execdump
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/pkg/validation"
+)
+
+// Database types of containers. MariaDB is dumped like MySQL with the
+// tools its newer images ship instead.
+const (
+	TypePostgres = "postgres"
+	TypeMySQL    = "mysql"
+	TypeMariaDB  = "mariadb"
+	TypeMongoDB  = "mongodb"
+)
+
+// Labels, or Kubernetes annotations, that override what is derived from
+// the image and environment. Passwords are never taken from them:
+// LabelPasswordEnv names the container variable that holds one.
+const (
+	LabelEnable      = "db-backup.enable" // "false" skips the container
+	LabelType        = "db-backup.type"   // postgres, mysql, mariadb or mongodb
+	LabelDatabase    = "db-backup.database"
+	LabelUser        = "db-backup.user"
+	LabelPasswordEnv = "db-backup.password-env"
+)
+
+// images maps image names, without registry, namespace or tag, to the
+// database they run. Names are matched exactly so sidecars such as
+// postgres-exporter are not mistaken for databases.
+var images = map[string]string{
+	"postgres":                 TypePostgres,
+	"postgresql":               TypePostgres,
+	"postgresql-repmgr":        TypePostgres,
+	"postgis":                  TypePostgres,
+	"timescaledb":              TypePostgres,
+	"timescaledb-ha":           TypePostgres,
+	"mysql":                    TypeMySQL,
+	"mysql-server":             TypeMySQL,
+	"percona":                  TypeMySQL,
+	"percona-server":           TypeMySQL,
+	"percona-xtradb-cluster":   TypeMySQL,
+	"mariadb":                  TypeMariaDB,
+	"mariadb-galera":           TypeMariaDB,
+	"mongo":                    TypeMongoDB,
+	"mongodb":                  TypeMongoDB,
+	"mongodb-community-server": TypeMongoDB,
+	"percona-server-mongodb":   TypeMongoDB,
+}
+
+// imageEnv lists the image variables settings are read from, in order of
+// preference; official images first, then Bitnami's
+var imageEnv = map[string]struct{ user, password, database []string }{
+	TypePostgres: {
+		user:     []string{"POSTGRES_USER", "POSTGRESQL_USERNAME"},
+		password: []string{"POSTGRES_PASSWORD", "POSTGRESQL_PASSWORD"},
+		database: []string{"POSTGRES_DB", "POSTGRESQL_DATABASE"},
+	},
+	// The root login is preferred: it can dump every table and routine
+	TypeMySQL: {
+		password: []string{"MYSQL_ROOT_PASSWORD", "MYSQL_PASSWORD"},
+		user:     []string{"MYSQL_USER"},
+		database: []string{"MYSQL_DATABASE"},
+	},
+	TypeMariaDB: {
+		password: []string{"MARIADB_ROOT_PASSWORD", "MYSQL_ROOT_PASSWORD", "MARIADB_PASSWORD", "MYSQL_PASSWORD"},
+		user:     []string{"MARIADB_USER", "MYSQL_USER"},
+		database: []string{"MARIADB_DATABASE", "MYSQL_DATABASE"},
+	},
+	TypeMongoDB: {
+		user:     []string{"MONGO_INITDB_ROOT_USERNAME", "MONGODB_ROOT_USER"},
+		password: []string{"MONGO_INITDB_ROOT_PASSWORD", "MONGODB_ROOT_PASSWORD"},
+		database: []string{"MONGO_INITDB_DATABASE", "MONGODB_DATABASE"},
+	},
+}
+
+// rootPasswords are the variables that make the MySQL root login usable
+var rootPasswords = []string{"MYSQL_ROOT_PASSWORD", "MARIADB_ROOT_PASSWORD"}
+
+// Settings are what a dump inside a container runs with
+type Settings struct {
+	Type     string `json:"type" yaml:"type"`
+	Database string `json:"database,omitempty" yaml:"database,omitempty"`
+	User     string `json:"user,omitempty" yaml:"user,omitempty"`
+	// Password is known when the container environment could be read;
+	// PasswordEnv names the container variable holding it, which a shell
+	// in the container reads when the value is kept in a secret.
+	Password    string `json:"-" yaml:"-"`
+	PasswordEnv string `json:"password_env,omitempty" yaml:"password_env,omitempty"`
+}
+
+// Dialect is the database type the rest of the tool knows the dumps as
+func (s Settings) Dialect() string {
+	if s.Type == TypeMariaDB {
+		return TypeMySQL
+	}
+	return s.Type
+}
+
+// ImageType returns the database an image runs, or "" for other images
+func ImageType(image string) string {
+	name, _, _ := strings.Cut(image, "@")
+	name = path.Base(name)
+	name, _, _ = strings.Cut(name, ":")
+	return images[name]
+}
+
+// Detect derives the settings of a container from its image, labels and
+// environment. env holds the container's variables; those set from a
+// secret whose value cannot be read are present with an empty value.
+// It reports false for containers that are not databases or are labelled
+// to be skipped.
+func Detect(image string, labels, env map[string]string) (Settings, bool) {
+	if strings.EqualFold(labels[LabelEnable], "false") {
+		return Settings{}, false
+	}
+	typ := strings.ToLower(labels[LabelType])
+	if typ == "" {
+		typ = ImageType(image)
+	}
+	vars, ok := imageEnv[typ]
+	if !ok {
+		return Settings{}, false
+	}
+
+	first := func(keys []string) string {
+		for _, k := range keys {
+			if v := env[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	s := Settings{
+		Type:     typ,
+		User:     first(vars.user),
+		Database: first(vars.database),
+	}
+	for _, k := range vars.password {
+		if v, ok := env[k]; ok {
+			s.Password, s.PasswordEnv = v, k
+			break
+		}
+	}
+
+	switch typ {
+	case TypePostgres:
+		if s.User == "" {
+			s.User = "postgres"
+		}
+		if s.Database == "" {
+			// The image creates a database named after the user
+			s.Database = s.User
+		}
+	case TypeMySQL, TypeMariaDB:
+		root := s.User == ""
+		for _, k := range rootPasswords {
+			if _, ok := env[k]; ok {
+				root = true
+			}
+		}
+		if root {
+			s.User = "root"
+		}
+	}
+
+	if v := labels[LabelDatabase]; v != "" {
+		s.Database = v
+	}
+	if v := labels[LabelUser]; v != "" {
+		s.User = v
+	}
+	if v := labels[LabelPasswordEnv]; v != "" {
+		s.Password, s.PasswordEnv = env[v], v
+	}
+	return s, true
+}
+
+// Options selects what a dump contains
+type Options struct {
+	Database      string // defaults to the settings'
+	Tables        []string
+	ExcludeTables []string
+}
+
+// envName matches the variable names a shell can expand
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Command returns the dump command of s and the variables to add to its
+// environment. Passwords go in the environment rather than on the command
+// line where the tool allows it. The dump is written to stdout:
+// pg_dump custom format, mysqldump SQL or a gzipped mongodump archive.
+func Command(s Settings, opts Options) ([]string, []string, error) {
+	cmd, err := command(s, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.Password == "" {
+		return cmd, nil, nil
+	}
+	if v := passwordVar(s.Type); v != "" {
+		return cmd, []string{v + "=" + s.Password}, nil
+	}
+	if s.User == "" {
+		return cmd, nil, nil
+	}
+	// mongodump only takes the password as an argument
+	return append(cmd, "--password="+s.Password), nil, nil
+}
+
+// ShellCommand returns the dump command of s for execs that cannot set
+// the environment: sh reads the password from the container's own
+// PasswordEnv variable, so it never leaves the container.
+func ShellCommand(s Settings, opts Options) ([]string, error) {
+	cmd, err := command(s, opts)
+	if err != nil {
+		return nil, err
+	}
+	v := passwordVar(s.Type)
+	if s.PasswordEnv == "" || (v == "" && s.User == "") {
+		return cmd, nil
+	}
+	if !envName.MatchString(s.PasswordEnv) {
+		return nil, fmt.Errorf("invalid password variable %q", s.PasswordEnv)
+	}
+	// The command is passed as arguments, so nothing in it is parsed by sh
+	script := `exec "$@" --password="$` + s.PasswordEnv + `"`
+	if v != "" {
+		script = v + `="$` + s.PasswordEnv + `" exec "$@"`
+	}
+	return append([]string{"sh", "-c", script, "sh"}, cmd...), nil
+}
+
+// passwordVar is the variable the dump tool of typ reads a password from
+func passwordVar(typ string) string {
+	switch typ {
+	case TypePostgres:
+		return "PGPASSWORD"
+	case TypeMySQL, TypeMariaDB:
+		return "MYSQL_PWD"
+	}
+	return ""
+}
+
+// command builds the dump command of s without its password
+func command(s Settings, opts Options) ([]string, error) {
+	db := opts.Database
+	if db == "" {
+		db = s.Database
+	}
+	if db != "" {
+		if err := validation.ValidateDatabaseName(db); err != nil {
+			return nil, fmt.Errorf("invalid database name %q: %w", db, err)
+		}
+	}
+	for _, table := range append(append([]string{}, opts.Tables...), opts.ExcludeTables...) {
+		if err := validation.ValidateTableName(table); err != nil {
+			return nil, fmt.Errorf("invalid table name %q: %w", table, err)
+		}
+	}
+	if strings.HasPrefix(s.User, "-") {
+		return nil, fmt.Errorf("invalid user %q", s.User)
+	}
+
+	switch s.Type {
+	case TypePostgres:
+		if db == "" {
+			return nil, errors.New("no database to dump")
+		}
+		// Connects over the local socket, which the images trust
+		cmd := []string{"pg_dump", "--username=" + s.User, "--no-password", "-F", "c", "--no-owner", "--no-acl"}
+		for _, table := range opts.Tables {
+			cmd = append(cmd, "-t", table)
+		}
+		for _, table := range opts.ExcludeTables {
+			cmd = append(cmd, "-T", table)
+		}
+		return append(cmd, db), nil
+	case TypeMySQL, TypeMariaDB:
+		if db == "" {
+			return nil, errors.New("no database to dump")
+		}
+		tool := "mysqldump"
+		if s.Type == TypeMariaDB {
+			// MariaDB 11 images no longer ship the mysql names
+			tool = "mariadb-dump"
+		}
+		cmd := []string{tool, "--user=" + s.User, "--single-transaction", "--routines", "--triggers", "--events", "--skip-lock-tables", db}
+		cmd = append(cmd, opts.Tables...)
+		for _, table := range opts.ExcludeTables {
+			cmd = append(cmd, fmt.Sprintf("--ignore-table=%s.%s", db, table))
+		}
+		return cmd, nil
+	case TypeMongoDB:
+		if len(opts.Tables) > 1 {
+			return nil, errors.New("mongodump dumps one collection at a time")
+		}
+		cmd := []string{"mongodump", "--archive", "--gzip"}
+		if s.User != "" {
+			cmd = append(cmd, "--username="+s.User, "--authenticationDatabase=admin")
+		}
+		if db != "" {
+			cmd = append(cmd, "--db="+db)
+		}
+		for _, table := range opts.Tables {
+			cmd = append(cmd, "--collection="+table)
+		}
+		for _, table := range opts.ExcludeTables {
+			cmd = append(cmd, "--excludeCollection="+table)
+		}
+		return cmd, nil
+	}
+	return nil, fmt.Errorf("unsupported database type %q", s.Type)
+}
+
+// ExitError is returned for dump commands that ran but failed
+type ExitError struct {
+	Code   int
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+	return fmt.Sprintf("exit code %d: %s", e.Code, e.Stderr)
+}
+
+// maxStderr bounds the stderr kept for errors
+const maxStderr = 64 << 10
+
+// Stderr keeps the end of a command's stderr for its ExitError
+type Stderr struct {
+	buf []byte
+}
+
+func (b *Stderr) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > maxStderr {
+		b.buf = b.buf[len(b.buf)-maxStderr:]
+	}
+	return len(p), nil
+}
+
+// String returns the kept stderr without surrounding whitespace
+func (b *Stderr) String() string { return strings.TrimSpace(string(b.buf)) }
@@ -0,0 +1,149 @@
+package execdump
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImageType(t *testing.T) {
+	tests := map[string]string{
+		"postgres":                              TypePostgres,
+		"postgis/postgis:16-3.4":                TypePostgres,
+		"timescale/timescaledb:latest-pg16":     TypePostgres,
+		"ghcr.io/cloudnative-pg/postgresql:16":  TypePostgres,
+		"ghcr.io/acme/mysql:8@sha256:abc":       TypeMySQL,
+		"percona/percona-server:8.0":            TypeMySQL,
+		"mariadb:11":                            TypeMariaDB,
+		"bitnami/mongodb:7.0":                   TypeMongoDB,
+		"percona/percona-server-mongodb:7.0":    TypeMongoDB,
+		"prometheuscommunity/postgres-exporter": "",
+		"redis:7":                               "",
+		"localhost:5000/nginx":                  "",
+	}
+	for image, want := range tests {
+		if got := ImageType(image); got != want {
+			t.Errorf("ImageType(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name   string
+		image  string
+		labels map[string]string
+		env    map[string]string
+		want   Settings
+	}{
+		{
+			name:  "postgres defaults",
+			image: "postgres:16",
+			env:   map[string]string{"POSTGRES_PASSWORD": "secret"},
+			want:  Settings{Type: TypePostgres, User: "postgres", Database: "postgres", Password: "secret", PasswordEnv: "POSTGRES_PASSWORD"},
+		},
+		{
+			name:  "mysql prefers root",
+			image: "mysql:8.4",
+			env:   map[string]string{"MYSQL_ROOT_PASSWORD": "rootpw", "MYSQL_USER": "app", "MYSQL_PASSWORD": "apppw", "MYSQL_DATABASE": "orders"},
+			want:  Settings{Type: TypeMySQL, User: "root", Database: "orders", Password: "rootpw", PasswordEnv: "MYSQL_ROOT_PASSWORD"},
+		},
+		{
+			name:  "mariadb user login",
+			image: "mariadb:11",
+			env:   map[string]string{"MARIADB_USER": "wiki", "MARIADB_PASSWORD": "wikipw", "MARIADB_DATABASE": "wiki"},
+			want:  Settings{Type: TypeMariaDB, User: "wiki", Database: "wiki", Password: "wikipw", PasswordEnv: "MARIADB_PASSWORD"},
+		},
+		{
+			// As seen in a pod spec: the value is in a secret
+			name:  "password from a secret",
+			image: "mysql:8.4",
+			env:   map[string]string{"MYSQL_ROOT_PASSWORD": "", "MYSQL_DATABASE": "orders"},
+			want:  Settings{Type: TypeMySQL, User: "root", Database: "orders", PasswordEnv: "MYSQL_ROOT_PASSWORD"},
+		},
+		{
+			name:   "label overrides",
+			image:  "registry.internal/warehouse:3",
+			labels: map[string]string{LabelType: "postgres", LabelDatabase: "archive", LabelUser: "wh", LabelPasswordEnv: "DB_SECRET"},
+			env:    map[string]string{"DB_SECRET": "pw"},
+			want:   Settings{Type: TypePostgres, User: "wh", Database: "archive", Password: "pw", PasswordEnv: "DB_SECRET"},
+		},
+	}
+	for _, tt := range tests {
+		got, ok := Detect(tt.image, tt.labels, tt.env)
+		if !ok || got != tt.want {
+			t.Errorf("%s: Detect() = %+v, %v, want %+v", tt.name, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := Detect("postgres:16", map[string]string{LabelEnable: "false"}, nil); ok {
+		t.Error("disabled container detected")
+	}
+	if _, ok := Detect("nginx:1.27", nil, nil); ok {
+		t.Error("nginx detected as a database")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	mysql := Settings{Type: TypeMySQL, User: "root", Password: "pw", Database: "orders"}
+	cmd, env, err := Command(mysql, Options{ExcludeTables: []string{"audit"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd[0] != "mysqldump" || !slices.Contains(cmd, "--ignore-table=orders.audit") || !slices.Equal(env, []string{"MYSQL_PWD=pw"}) {
+		t.Errorf("mysql = %v %v", cmd, env)
+	}
+
+	cmd, env, _ = Command(Settings{Type: TypePostgres, User: "postgres", Password: "pw", Database: "shop"}, Options{Tables: []string{"orders"}})
+	if cmd[0] != "pg_dump" || cmd[len(cmd)-1] != "shop" || !slices.Contains(cmd, "orders") || !slices.Equal(env, []string{"PGPASSWORD=pw"}) {
+		t.Errorf("postgres = %v %v", cmd, env)
+	}
+
+	cmd, _, _ = Command(Settings{Type: TypeMariaDB, User: "root", Database: "wiki"}, Options{})
+	if cmd[0] != "mariadb-dump" {
+		t.Errorf("mariadb tool = %s", cmd[0])
+	}
+
+	cmd, _, _ = Command(Settings{Type: TypeMongoDB, User: "admin", Password: "pw"}, Options{Database: "events"})
+	if !slices.Contains(cmd, "--archive") || !slices.Contains(cmd, "--db=events") || !slices.Contains(cmd, "--password=pw") {
+		t.Errorf("mongodb = %v", cmd)
+	}
+
+	if _, _, err := Command(mysql, Options{Database: "orders; rm -rf /"}); err == nil {
+		t.Error("invalid database name accepted")
+	}
+	if _, _, err := Command(mysql, Options{Tables: []string{"--all-databases"}}); err == nil {
+		t.Error("table name starting with a dash accepted")
+	}
+	if _, _, err := Command(Settings{Type: TypeMySQL, User: "--host=evil", Database: "orders"}, Options{}); err == nil {
+		t.Error("user starting with a dash accepted")
+	}
+	if _, _, err := Command(Settings{Type: TypePostgres, User: "postgres"}, Options{}); err == nil {
+		t.Error("postgres dump without a database accepted")
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	cmd, err := ShellCommand(Settings{Type: TypeMySQL, User: "root", Database: "orders", PasswordEnv: "MYSQL_ROOT_PASSWORD"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sh", "-c", `MYSQL_PWD="$MYSQL_ROOT_PASSWORD" exec "$@"`, "sh", "mysqldump"}; !slices.Equal(cmd[:5], want) {
+		t.Errorf("mysql = %q", cmd)
+	}
+
+	cmd, _ = ShellCommand(Settings{Type: TypeMongoDB, User: "admin", PasswordEnv: "MONGO_INITDB_ROOT_PASSWORD"}, Options{})
+	if cmd[2] != `exec "$@" --password="$MONGO_INITDB_ROOT_PASSWORD"` {
+		t.Errorf("mongodb script = %q", cmd[2])
+	}
+
+	// Known passwords are not put on the command line either
+	cmd, _ = ShellCommand(Settings{Type: TypePostgres, User: "postgres", Database: "shop", Password: "secret"}, Options{})
+	if cmd[0] != "pg_dump" || strings.Contains(strings.Join(cmd, " "), "secret") {
+		t.Errorf("postgres = %q", cmd)
+	}
+
+	if _, err := ShellCommand(Settings{Type: TypePostgres, User: "postgres", Database: "shop", PasswordEnv: "X; reboot"}, Options{}); err == nil {
+		t.Error("invalid variable name accepted")
+	}
+}
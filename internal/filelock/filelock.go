@@ -0,0 +1,58 @@
+// Package filelock takes advisory locks on files, so the read-modify-write
+// cycles of state files shared by several processes on one host, such as
+// concurrent CLI runs and the scheduler, do not interleave. Locks are held
+// per open file: two goroutines of one process taking the same lock also
+// exclude each other.
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errLocked is returned by lockFile when it may not wait and another
+// holder has the lock
+var errLocked = errors.New("file is locked")
+
+// Lock takes an exclusive lock on the file at path, created when missing,
+// waiting while another holder has it, and returns the function releasing
+// it. The file is only used for locking and is left in place.
+func Lock(path string) (unlock func() error, err error) {
+	unlock, _, err = lock(path, true, true)
+	return unlock, err
+}
+
+// RLock takes a shared lock on the file at path like Lock: any number of
+// holders may share it, but not with an exclusive holder
+func RLock(path string) (unlock func() error, err error) {
+	unlock, _, err = lock(path, false, true)
+	return unlock, err
+}
+
+// TryLock takes an exclusive lock on the file at path like Lock, but
+// reports false instead of waiting when another holder has it
+func TryLock(path string) (unlock func() error, ok bool, err error) {
+	return lock(path, true, false)
+}
+
+func lock(path string, exclusive, wait bool) (func() error, bool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600) // #nosec G304 -- lock file next to the state it guards
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(f, exclusive, wait); err != nil {
+		f.Close()
+		if errors.Is(err, errLocked) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return func() error {
+		err := unlockFile(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, true, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package filelock
+
+import "os"
+
+// Platforms without file locks only get the guarantees of a single process
+
+func lockFile(f *os.File, exclusive, wait bool) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
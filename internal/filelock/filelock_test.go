@@ -0,0 +1,66 @@
+package filelock
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestLockSerializes(t *testing.T) {
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "counter")
+	lockPath := filepath.Join(dir, "counter.lock")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := Lock(lockPath)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer unlock()
+			data, _ := os.ReadFile(counter)
+			n, _ := strconv.Atoi(string(data))
+			if err := os.WriteFile(counter, []byte(strconv.Itoa(n+1)), 0600); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if data, _ := os.ReadFile(counter); string(data) != "20" {
+		t.Errorf("counter = %s, want 20", data)
+	}
+}
+
+func TestSharedLocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifact.lock")
+	r1, err := RLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := RLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := TryLock(path); ok || err != nil {
+		t.Fatalf("TryLock() with shared holders = %v, %v; want false", ok, err)
+	}
+	r1()
+	if _, ok, _ := TryLock(path); ok {
+		t.Fatal("TryLock() succeeded while a shared holder remains")
+	}
+	r2()
+	unlock, ok, err := TryLock(path)
+	if !ok || err != nil {
+		t.Fatalf("TryLock() without holders = %v, %v; want true", ok, err)
+	}
+	if _, ok, _ := TryLock(path); ok {
+		t.Error("TryLock() succeeded twice")
+	}
+	unlock()
+}
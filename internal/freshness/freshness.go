@@ -0,0 +1,365 @@
+// Package freshness is a dead-man's switch for backups. It learns how
+// often each database is backed up from the backup catalog, or takes the
+// configured interval, and alerts when a database goes longer than that
+// without a successful backup. It looks only at the backups that exist,
+// never at the schedules, so a schedule that was disabled, deleted or
+// misconfigured is caught just like a failing one.
+package freshness
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+// DefaultExpected is the expected interval for databases without one of
+// their own or enough history to learn it
+const DefaultExpected = "default"
+
+// Backup is a successful backup in the catalog
+type Backup struct {
+	Database string
+	Time     time.Time
+}
+
+// Source lists the successful backups of the catalog
+type Source func(ctx context.Context) ([]Backup, error)
+
+const (
+	// window is how many recent intervals the expected one is learned from
+	window = 10
+	// minGap ignores backups taken in the same run, or retried right away
+	minGap = time.Minute
+)
+
+// Status is the freshness of one database
+type Status struct {
+	Database   string        `json:"database"`
+	LastBackup time.Time     `json:"last_backup,omitempty"` // newest successful backup
+	Age        time.Duration `json:"-"`
+	Expected   time.Duration `json:"-"` // 0 when neither learned nor configured
+	Learned    bool          `json:"learned"`
+	Deadline   time.Time     `json:"deadline,omitempty"` // stale once it passes
+	Stale      bool          `json:"stale"`
+	Backups    int           `json:"backups"`
+	AlertedAt  time.Time     `json:"alerted_at,omitempty"` // last alert while stale
+}
+
+// HasBackup reports whether the database was ever backed up
+func (s Status) HasBackup() bool {
+	return !s.LastBackup.IsZero()
+}
+
+// MarshalJSON reports durations in seconds for dashboards
+func (s Status) MarshalJSON() ([]byte, error) {
+	type plain Status
+	out := struct {
+		plain
+		LastBackup      *time.Time `json:"last_backup,omitempty"`
+		AgeSeconds      *float64   `json:"age_seconds,omitempty"`
+		ExpectedSeconds float64    `json:"expected_interval_seconds,omitempty"`
+		Deadline        *time.Time `json:"deadline,omitempty"`
+		AlertedAt       *time.Time `json:"alerted_at,omitempty"`
+	}{
+		plain:           plain(s),
+		ExpectedSeconds: s.Expected.Seconds(),
+	}
+	if s.HasBackup() {
+		age := s.Age.Seconds()
+		out.LastBackup = &s.LastBackup
+		out.AgeSeconds = &age
+	}
+	if !s.Deadline.IsZero() {
+		out.Deadline = &s.Deadline
+	}
+	if !s.AlertedAt.IsZero() {
+		out.AlertedAt = &s.AlertedAt
+	}
+	return json.Marshal(out)
+}
+
+// Alert is a database going stale, still being stale after the repeat
+// interval, or being backed up again
+type Alert struct {
+	Status    Status
+	Recovered bool
+	Reminder  bool
+}
+
+// Notification describes the alert for the notifiers
+func (a Alert) Notification() *notification.Notification {
+	s := a.Status
+	n := &notification.Notification{
+		Event:     notification.EventWarning,
+		Database:  s.Database,
+		Severity:  "critical",
+		Timestamp: time.Now(),
+	}
+
+	source := "configured"
+	if s.Learned {
+		source = "learned from the catalog"
+	}
+	switch {
+	case a.Recovered:
+		n.Event, n.Severity = notification.EventSuccess, ""
+		n.Title = fmt.Sprintf("%s is being backed up again", s.Database)
+		n.Message = fmt.Sprintf("Last successful backup %s ago, expected every %s (%s)", formatDuration(s.Age), formatDuration(s.Expected), source)
+	case !s.HasBackup():
+		n.Title = fmt.Sprintf("%s has never been backed up", s.Database)
+		n.Message = fmt.Sprintf("No successful backup in the catalog, expected every %s (%s)", formatDuration(s.Expected), source)
+	default:
+		n.Title = fmt.Sprintf("No backup of %s for %s", s.Database, formatDuration(s.Age))
+		if a.Reminder {
+			n.Title = "Still " + strings.ToLower(n.Title[:1]) + n.Title[1:]
+		}
+		n.Message = fmt.Sprintf("Last successful backup at %s, expected every %s (%s). Check that its schedule is enabled and succeeding.",
+			s.LastBackup.UTC().Format(time.RFC3339), formatDuration(s.Expected), source)
+	}
+	return n
+}
+
+// databaseState is the persisted alert state for one database
+type databaseState struct {
+	Stale     bool      `json:"stale"`
+	AlertedAt time.Time `json:"alerted_at,omitempty"`
+}
+
+// Watchdog checks the freshness of every database in the catalog, keeping
+// what it alerted on in a state file so each change is alerted once
+type Watchdog struct {
+	config config.FreshnessConfig
+	source Source
+	mu     sync.Mutex
+}
+
+// New creates a watchdog of the backups source lists
+func New(cfg config.FreshnessConfig, source Source) *Watchdog {
+	if cfg.Tolerance < 1 {
+		cfg.Tolerance = 1.5
+	}
+	if cfg.MinSamples < 2 {
+		cfg.MinSamples = 4
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = 5 * time.Minute
+	}
+	return &Watchdog{config: cfg, source: source}
+}
+
+// Status reports every database in the catalog or with an expected
+// interval of its own, sorted by name
+func (w *Watchdog) Status(ctx context.Context, now time.Time) ([]Status, error) {
+	backups, err := w.source(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	return w.statuses(backups, state, now), nil
+}
+
+// Check returns the databases that went stale or were backed up again
+// since the last check, and reminders for those stale for longer than the
+// repeat interval
+func (w *Watchdog) Check(ctx context.Context, now time.Time) ([]Alert, error) {
+	backups, err := w.source(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []Alert
+	for _, s := range w.statuses(backups, state, now) {
+		st := state[s.Database]
+		if st == nil {
+			st = &databaseState{}
+			state[s.Database] = st
+		}
+		switch {
+		case s.Stale && !st.Stale:
+			alerts = append(alerts, Alert{Status: s})
+			st.Stale, st.AlertedAt = true, now
+		case s.Stale && w.config.RepeatInterval > 0 && now.Sub(st.AlertedAt) >= w.config.RepeatInterval:
+			alerts = append(alerts, Alert{Status: s, Reminder: true})
+			st.AlertedAt = now
+		case !s.Stale && st.Stale:
+			alerts = append(alerts, Alert{Status: s, Recovered: true})
+			st.Stale, st.AlertedAt = false, time.Time{}
+		}
+	}
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+	return alerts, w.save(state)
+}
+
+// Run checks every check interval until the context is cancelled, handing
+// the alerts to send
+func (w *Watchdog) Run(ctx context.Context, send func(context.Context, *notification.Notification) error) error {
+	ticker := time.NewTicker(w.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		alerts, err := w.Check(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, a := range alerts {
+			if err := send(ctx, a.Notification()); err != nil {
+				return fmt.Errorf("failed to send freshness alert for %s: %w", a.Status.Database, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// expected returns the configured interval of a database. Viper lowercases
+// map keys, so the lookup is case-insensitive.
+func (w *Watchdog) expected(database string) (time.Duration, bool) {
+	if d, ok := w.config.Expected[database]; ok {
+		return d, true
+	}
+	d, ok := w.config.Expected[strings.ToLower(database)]
+	return d, ok
+}
+
+func (w *Watchdog) statuses(backups []Backup, state map[string]*databaseState, now time.Time) []Status {
+	times := make(map[string][]time.Time)
+	known := make(map[string]bool)
+	for _, b := range backups {
+		times[b.Database] = append(times[b.Database], b.Time)
+		known[strings.ToLower(b.Database)] = true
+	}
+	for name := range w.config.Expected {
+		if name != DefaultExpected && !known[strings.ToLower(name)] {
+			times[name] = nil
+		}
+	}
+
+	statuses := make([]Status, 0, len(times))
+	for name, t := range times {
+		s := w.status(name, t, now)
+		if st := state[name]; st != nil && st.Stale {
+			s.AlertedAt = st.AlertedAt
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Database < statuses[j].Database })
+	return statuses
+}
+
+func (w *Watchdog) status(name string, times []time.Time, now time.Time) Status {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	s := Status{Database: name, Backups: len(times)}
+	if len(times) > 0 {
+		s.LastBackup = times[len(times)-1]
+		s.Age = now.Sub(s.LastBackup)
+	}
+
+	// A configured interval wins over the learned one, which wins over
+	// the default
+	if d, ok := w.expected(name); ok {
+		s.Expected = d
+	} else if d := learn(times, w.config.MinSamples); d > 0 {
+		s.Expected, s.Learned = d, true
+	} else {
+		s.Expected = w.config.Expected[DefaultExpected]
+	}
+	if s.Expected <= 0 {
+		return s
+	}
+
+	allowed := time.Duration(float64(s.Expected) * w.config.Tolerance)
+	if !s.HasBackup() {
+		// Only databases expected by name can be missing from the catalog
+		s.Stale = true
+		return s
+	}
+	s.Deadline = s.LastBackup.Add(allowed)
+	s.Stale = now.After(s.Deadline)
+	return s
+}
+
+// learn returns the median interval between the recent backups, 0 with
+// fewer than minSamples of them
+func learn(times []time.Time, minSamples int) time.Duration {
+	var gaps []time.Duration
+	for i := len(times) - 1; i > 0 && len(gaps) < window; i-- {
+		if gap := times[i].Sub(times[i-1]); gap >= minGap {
+			gaps = append(gaps, gap)
+		}
+	}
+	if len(gaps) < minSamples-1 {
+		return 0
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	return gaps[len(gaps)/2]
+}
+
+func (w *Watchdog) load() (map[string]*databaseState, error) {
+	state := make(map[string]*databaseState)
+	data, err := os.ReadFile(w.config.StateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read freshness state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse freshness state: %w", err)
+	}
+	return state, nil
+}
+
+// save writes the state atomically
+func (w *Watchdog) save(state map[string]*databaseState) error {
+	if w.config.StateFile == "" {
+		return fmt.Errorf("freshness state_file is not configured")
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal freshness state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(w.config.StateFile), 0750); err != nil {
+		return fmt.Errorf("failed to create freshness state directory: %w", err)
+	}
+	tmp := w.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write freshness state: %w", err)
+	}
+	return os.Rename(tmp, w.config.StateFile)
+}
+
+// formatDuration rounds a duration for messages
+func formatDuration(d time.Duration) string {
+	if d >= time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	return d.Round(time.Second).String()
+}
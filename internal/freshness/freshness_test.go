@@ -0,0 +1,122 @@
+package freshness
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+var now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// every returns n backups of db taken every interval, the newest age ago
+func every(db string, n int, interval, age time.Duration) []Backup {
+	var out []Backup
+	for i := 0; i < n; i++ {
+		out = append(out, Backup{Database: db, Time: now.Add(-age - time.Duration(i)*interval)})
+	}
+	return out
+}
+
+func newTestWatchdog(t *testing.T, backups *[]Backup) *Watchdog {
+	t.Helper()
+	return New(config.FreshnessConfig{
+		StateFile:      filepath.Join(t.TempDir(), "freshness.json"),
+		Tolerance:      1.5,
+		MinSamples:     4,
+		RepeatInterval: 24 * time.Hour,
+		Expected:       map[string]time.Duration{"default": 24 * time.Hour, "billing": time.Hour},
+	}, func(context.Context) ([]Backup, error) { return *backups, nil })
+}
+
+func TestStatus(t *testing.T) {
+	var backups []Backup
+	backups = append(backups, every("orders", 6, 6*time.Hour, 8*time.Hour)...)  // learned 6h, due after 9h
+	backups = append(backups, every("events", 6, 6*time.Hour, 10*time.Hour)...) // learned 6h, overdue
+	backups = append(backups, every("users", 3, time.Hour, 40*time.Hour)...)    // too few to learn, default 24h
+	w := newTestWatchdog(t, &backups)
+
+	statuses, err := w.Status(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		database string
+		expected time.Duration
+		learned  bool
+		stale    bool
+	}{
+		{"billing", time.Hour, false, true}, // configured, never backed up
+		{"events", 6 * time.Hour, true, true},
+		{"orders", 6 * time.Hour, true, false},
+		{"users", 24 * time.Hour, false, true},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("got %d statuses, want %d", len(statuses), len(want))
+	}
+	for i, tt := range want {
+		s := statuses[i]
+		if s.Database != tt.database || s.Expected != tt.expected || s.Learned != tt.learned || s.Stale != tt.stale {
+			t.Errorf("status %d = %+v, want %+v", i, s, tt)
+		}
+	}
+	if d := statuses[2].Deadline; !d.Equal(now.Add(time.Hour)) {
+		t.Errorf("orders deadline = %s", d)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	backups := every("orders", 6, 6*time.Hour, 8*time.Hour)
+	w := newTestWatchdog(t, &backups)
+	w.config.Expected = nil
+	ctx := context.Background()
+
+	check := func(at time.Time) []Alert {
+		t.Helper()
+		alerts, err := w.Check(ctx, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return alerts
+	}
+
+	if alerts := check(now); len(alerts) != 0 {
+		t.Fatalf("fresh database alerted: %+v", alerts)
+	}
+	alerts := check(now.Add(2 * time.Hour))
+	if len(alerts) != 1 || alerts[0].Recovered || alerts[0].Reminder {
+		t.Fatalf("alerts = %+v, want one stale alert", alerts)
+	}
+	if n := alerts[0].Notification(); n.Event != notification.EventWarning || n.Severity != "critical" {
+		t.Errorf("notification = %+v", n)
+	}
+	if alerts := check(now.Add(3 * time.Hour)); len(alerts) != 0 {
+		t.Errorf("stale database alerted again: %+v", alerts)
+	}
+	if alerts := check(now.Add(27 * time.Hour)); len(alerts) != 1 || !alerts[0].Reminder {
+		t.Errorf("alerts = %+v, want a reminder", alerts)
+	}
+
+	backups = append(backups, Backup{Database: "orders", Time: now.Add(28 * time.Hour)})
+	alerts = check(now.Add(28*time.Hour + time.Minute))
+	if len(alerts) != 1 || !alerts[0].Recovered {
+		t.Fatalf("alerts = %+v, want a recovery", alerts)
+	}
+	if n := alerts[0].Notification(); n.Event != notification.EventSuccess {
+		t.Errorf("notification = %+v", n)
+	}
+}
+
+func TestLearn(t *testing.T) {
+	times := []time.Time{now, now.Add(time.Hour), now.Add(time.Hour + time.Second), now.Add(2 * time.Hour), now.Add(5 * time.Hour)}
+	// Gaps of 1h, 1h and 3h; the retry a second later is ignored
+	if got := learn(times, 4); got != time.Hour {
+		t.Errorf("learn() = %s, want 1h", got)
+	}
+	if got := learn(times, 5); got != 0 {
+		t.Errorf("learn() with too few samples = %s, want 0", got)
+	}
+}
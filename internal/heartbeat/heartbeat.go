@@ -0,0 +1,223 @@
+// Package heartbeat pings external dead-man's-switch monitors such as
+// healthchecks.io and Cronitor when scheduled backups start, succeed or
+// fail, so a backup that silently never runs is still detected
+package heartbeat
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Supported providers
+const (
+	ProviderHealthchecks = "healthchecks"
+	ProviderCronitor     = "cronitor"
+	ProviderGeneric      = "generic"
+)
+
+// DefaultCheck is used for runs without a schedule name, or whose schedule
+// has no check of its own
+const DefaultCheck = "default"
+
+// maxBodySize caps the log excerpt sent with a ping
+const maxBodySize = 10 * 1024
+
+// State is the run state reported by a ping
+type State string
+
+const (
+	StateStart   State = "start"
+	StateSuccess State = "success"
+	StateFailure State = "failure"
+)
+
+// Pinger reports backup runs to the monitor configured for each schedule
+type Pinger struct {
+	config config.HeartbeatConfig
+	client *http.Client
+}
+
+// New creates a pinger
+func New(cfg config.HeartbeatConfig) *Pinger {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &Pinger{config: cfg, client: &http.Client{Timeout: timeout}}
+}
+
+// Run is a single monitored execution. Start, Success and Failure pings of
+// one run share a run ID so the monitor can measure its duration.
+type Run struct {
+	pinger *Pinger
+	check  config.HeartbeatCheck
+	name   string
+	runID  string
+}
+
+// Begin starts a monitored run for a schedule and sends the start ping. It
+// returns nil when no check is configured; a nil run ignores every call.
+func (p *Pinger) Begin(ctx context.Context, schedule string) (*Run, error) {
+	if p == nil || !p.config.Enabled {
+		return nil, nil
+	}
+	name := schedule
+	check, ok := p.config.Checks[name]
+	if !ok {
+		name = DefaultCheck
+		if check, ok = p.config.Checks[name]; !ok {
+			return nil, nil
+		}
+	}
+
+	r := &Run{pinger: p, check: check, name: name, runID: newRunID()}
+	return r, r.ping(ctx, StateStart, "")
+}
+
+// Success reports a successful run
+func (r *Run) Success(ctx context.Context, message string) error {
+	if r == nil {
+		return nil
+	}
+	return r.ping(ctx, StateSuccess, message)
+}
+
+// Failure reports a failed run with the error as the ping body
+func (r *Run) Failure(ctx context.Context, message string) error {
+	if r == nil {
+		return nil
+	}
+	return r.ping(ctx, StateFailure, message)
+}
+
+// ping sends one ping, retrying transient errors a few times since a lost
+// success ping would page someone for a backup that actually ran
+func (r *Run) ping(ctx context.Context, state State, body string) error {
+	pingURL, err := PingURL(r.check, state, r.runID)
+	if err != nil || pingURL == "" {
+		return err
+	}
+	if len(body) > maxBodySize {
+		body = body[:maxBodySize]
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+		if lastErr = r.send(ctx, pingURL, body); lastErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("heartbeat %s: %s ping failed: %w", r.name, state, lastErr)
+}
+
+func (r *Run) send(ctx context.Context, pingURL, body string) error {
+	method := http.MethodGet
+	var reader io.Reader
+	if body != "" {
+		method = http.MethodPost
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, pingURL, reader)
+	if err != nil {
+		return err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	resp, err := r.pinger.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// PingURL builds the provider specific URL for a run state. An empty URL
+// means the state is not reported for this check.
+func PingURL(check config.HeartbeatCheck, state State, runID string) (string, error) {
+	switch check.Provider {
+	case ProviderHealthchecks, "":
+		if check.URL == "" {
+			return "", fmt.Errorf("healthchecks: url is required")
+		}
+		// https://hc-ping.com/<uuid>[/start|/fail]?rid=<run id>
+		base := strings.TrimRight(check.URL, "/")
+		switch state {
+		case StateStart:
+			base += "/start"
+		case StateFailure:
+			base += "/fail"
+		}
+		if runID == "" {
+			return base, nil
+		}
+		return withQuery(base, "rid", runID)
+
+	case ProviderCronitor:
+		if check.URL == "" {
+			return "", fmt.Errorf("cronitor: url is required")
+		}
+		// https://cronitor.link/p/<api key>/<monitor>?state=run|complete|fail&series=<run id>
+		cronitorState := map[State]string{StateStart: "run", StateSuccess: "complete", StateFailure: "fail"}[state]
+		u, err := withQuery(check.URL, "state", cronitorState)
+		if err != nil {
+			return "", err
+		}
+		return withQuery(u, "series", runID)
+
+	case ProviderGeneric:
+		switch state {
+		case StateStart:
+			return check.StartURL, nil
+		case StateSuccess:
+			return check.SuccessURL, nil
+		default:
+			return check.FailureURL, nil
+		}
+	}
+	return "", fmt.Errorf("unknown heartbeat provider %q", check.Provider)
+}
+
+func withQuery(rawURL, key, value string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid ping url: %w", err)
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// newRunID returns a random UUIDv4, the format healthchecks.io expects for rid
+func newRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,44 @@
+package heartbeat
+
+import (
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func TestPingURL(t *testing.T) {
+	hc := config.HeartbeatCheck{Provider: "healthchecks", URL: "https://hc-ping.com/abc/"}
+	cronitor := config.HeartbeatCheck{Provider: "cronitor", URL: "https://cronitor.link/p/key/nightly"}
+	generic := config.HeartbeatCheck{Provider: "generic", SuccessURL: "https://example.com/ok"}
+
+	tests := []struct {
+		name  string
+		check config.HeartbeatCheck
+		state State
+		want  string
+	}{
+		{"healthchecks start", hc, StateStart, "https://hc-ping.com/abc/start?rid=r1"},
+		{"healthchecks success", hc, StateSuccess, "https://hc-ping.com/abc?rid=r1"},
+		{"healthchecks failure", hc, StateFailure, "https://hc-ping.com/abc/fail?rid=r1"},
+		{"cronitor start", cronitor, StateStart, "https://cronitor.link/p/key/nightly?series=r1&state=run"},
+		{"cronitor failure", cronitor, StateFailure, "https://cronitor.link/p/key/nightly?series=r1&state=fail"},
+		{"generic success", generic, StateSuccess, "https://example.com/ok"},
+		{"generic start not configured", generic, StateStart, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PingURL(tt.check, tt.state, "r1")
+			if err != nil {
+				t.Fatalf("PingURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("PingURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := PingURL(config.HeartbeatCheck{Provider: "nagios"}, StateStart, "r1"); err == nil {
+		t.Error("PingURL() with unknown provider should fail")
+	}
+}
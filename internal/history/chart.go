@@ -0,0 +1,94 @@
+package history
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// metricTitles labels the chart of each metric
+var metricTitles = map[string]string{
+	MetricSize:     "Backup size",
+	MetricDuration: "Duration",
+	MetricRatio:    "Compression ratio (compressed / raw)",
+}
+
+// FormatValue renders a metric value for display
+func FormatValue(metric string, v float64) string {
+	switch metric {
+	case MetricSize:
+		return utils.FormatBytes(int64(v))
+	case MetricDuration:
+		return utils.FormatDuration(time.Duration(v * float64(time.Second)))
+	case MetricRatio:
+		return fmt.Sprintf("%.1f%%", v*100)
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+// RenderASCII draws one horizontal bar chart per metric, one row per run,
+// marking trend breaks with "!" and a note on the expected value
+func RenderASCII(w io.Writer, r *Report, width int) {
+	if width < 10 {
+		width = 10
+	}
+	fmt.Fprintf(w, "History of %s: %d run(s)", r.Database, len(r.Points))
+	if len(r.Points) > 0 {
+		fmt.Fprintf(w, " from %s to %s",
+			r.Points[0].Time.Format("2006-01-02"), r.Points[len(r.Points)-1].Time.Format("2006-01-02"))
+	}
+	fmt.Fprintln(w)
+
+	for _, metric := range r.Metrics() {
+		s := r.Summaries[metric]
+		fmt.Fprintf(w, "\n%s\n", metricTitles[metric])
+		fmt.Fprintln(w, strings.Repeat("─", width+42))
+
+		for i, p := range r.Points {
+			v := p.Value(metric)
+			if v <= 0 {
+				continue
+			}
+			bar := 0
+			if s.Max > 0 {
+				bar = int(math.Round(v / s.Max * float64(width)))
+			}
+			mark := " "
+			note := ""
+			if b, ok := r.BreakAt(metric, i); ok {
+				mark = "!"
+				note = fmt.Sprintf("  %+.0f%% vs %s", b.Change*100, FormatValue(metric, b.Expected))
+			}
+			fmt.Fprintf(w, "%s %s %-*s %10s%s\n",
+				p.Time.Format("2006-01-02 15:04"), mark, width, strings.Repeat("█", bar),
+				FormatValue(metric, v), note)
+		}
+
+		fmt.Fprintf(w, "min %s  max %s  mean %s  trend %s/day\n",
+			FormatValue(metric, s.Min), FormatValue(metric, s.Max), FormatValue(metric, s.Mean),
+			formatTrend(metric, s.PerDay))
+	}
+
+	if r.ProjectedSize30d > 0 {
+		fmt.Fprintf(w, "\nProjected size in 30 days: %s\n", utils.FormatBytes(r.ProjectedSize30d))
+	}
+	if len(r.Breaks) > 0 {
+		fmt.Fprintf(w, "\n%d trend break(s) marked with \"!\"\n", len(r.Breaks))
+	}
+}
+
+// formatTrend renders a signed per-day trend
+func formatTrend(metric string, perDay float64) string {
+	sign := "+"
+	if perDay < 0 {
+		sign = "-"
+	}
+	if metric == MetricRatio {
+		return fmt.Sprintf("%s%.2f pp", sign, math.Abs(perDay)*100)
+	}
+	return sign + FormatValue(metric, math.Abs(perDay))
+}
@@ -0,0 +1,226 @@
+// Package history analyses the run history of a database's backups: how
+// size, duration and compression ratio develop over time, how fast the
+// data grows, and which runs break the trend. It backs capacity planning
+// and spots regressions such as a table that suddenly stopped compressing.
+package history
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Metrics analysed for every run
+const (
+	MetricSize     = "size"
+	MetricDuration = "duration"
+	MetricRatio    = "compression_ratio"
+)
+
+// Point is one successful backup run
+type Point struct {
+	Time           time.Time     `json:"time"`
+	BackupID       string        `json:"backup_id"`
+	Size           int64         `json:"size"`
+	CompressedSize int64         `json:"compressed_size,omitempty"`
+	Duration       time.Duration `json:"duration"`
+}
+
+// Ratio is the compressed size as a fraction of the raw size; 1 means no
+// compression. It is 0 when either size is unknown.
+func (p Point) Ratio() float64 {
+	if p.Size <= 0 || p.CompressedSize <= 0 {
+		return 0
+	}
+	return float64(p.CompressedSize) / float64(p.Size)
+}
+
+// Value returns the point's value for a metric; durations are in seconds
+func (p Point) Value(metric string) float64 {
+	switch metric {
+	case MetricSize:
+		return float64(p.Size)
+	case MetricDuration:
+		return p.Duration.Seconds()
+	case MetricRatio:
+		return p.Ratio()
+	}
+	return 0
+}
+
+// Options tune trend-break detection
+type Options struct {
+	// Window is the number of preceding runs a run is compared with
+	Window int
+	// Threshold is how many standard deviations from the window mean
+	// count as a break
+	Threshold float64
+	// MinChange is the minimum relative change from the window mean, so
+	// tiny deviations in very stable series are not flagged
+	MinChange float64
+}
+
+// DefaultOptions compares each run with the previous week of daily runs
+func DefaultOptions() Options {
+	return Options{Window: 7, Threshold: 3, MinChange: 0.2}
+}
+
+// Summary describes one metric over the analysed period
+type Summary struct {
+	Metric string  `json:"metric"`
+	First  float64 `json:"first"`
+	Last   float64 `json:"last"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	// PerDay is the least-squares trend in units per day
+	PerDay float64 `json:"per_day"`
+}
+
+// Break is a run whose value departs from the preceding runs
+type Break struct {
+	Metric   string    `json:"metric"`
+	Index    int       `json:"index"`
+	Time     time.Time `json:"time"`
+	BackupID string    `json:"backup_id"`
+	Value    float64   `json:"value"`
+	Expected float64   `json:"expected"`
+	Change   float64   `json:"change"` // relative to Expected
+}
+
+// Report is the analysed history of one database
+type Report struct {
+	Database  string             `json:"database"`
+	Points    []Point            `json:"points"`
+	Summaries map[string]Summary `json:"summaries"`
+	Breaks    []Break            `json:"breaks,omitempty"`
+	// ProjectedSize30d extrapolates the size trend 30 days past the last run
+	ProjectedSize30d int64 `json:"projected_size_30d,omitempty"`
+}
+
+// Metrics lists the metrics that have data, in display order
+func (r *Report) Metrics() []string {
+	var out []string
+	for _, m := range []string{MetricSize, MetricDuration, MetricRatio} {
+		if _, ok := r.Summaries[m]; ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// BreakAt returns the break of a metric at a point, if any
+func (r *Report) BreakAt(metric string, index int) (Break, bool) {
+	for _, b := range r.Breaks {
+		if b.Metric == metric && b.Index == index {
+			return b, true
+		}
+	}
+	return Break{}, false
+}
+
+// Analyze sorts the points by time and summarises every metric
+func Analyze(database string, points []Point, opts Options) *Report {
+	if opts.Window < 2 {
+		opts.Window = 2
+	}
+	points = append([]Point(nil), points...)
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+
+	r := &Report{Database: database, Points: points, Summaries: make(map[string]Summary)}
+	if len(points) == 0 {
+		return r
+	}
+
+	for _, metric := range []string{MetricSize, MetricDuration, MetricRatio} {
+		var idx []int
+		for i, p := range points {
+			if p.Value(metric) > 0 {
+				idx = append(idx, i)
+			}
+		}
+		if len(idx) == 0 {
+			continue
+		}
+		r.Summaries[metric] = summarize(metric, points, idx)
+		r.Breaks = append(r.Breaks, detectBreaks(metric, points, idx, opts)...)
+	}
+	sort.SliceStable(r.Breaks, func(i, j int) bool { return r.Breaks[i].Index < r.Breaks[j].Index })
+
+	if s, ok := r.Summaries[MetricSize]; ok && s.PerDay != 0 {
+		projected := s.Last + s.PerDay*30
+		if projected > 0 {
+			r.ProjectedSize30d = int64(projected)
+		}
+	}
+	return r
+}
+
+// summarize computes the statistics of the points at idx
+func summarize(metric string, points []Point, idx []int) Summary {
+	first := points[idx[0]].Value(metric)
+	s := Summary{
+		Metric: metric,
+		First:  first,
+		Last:   points[idx[len(idx)-1]].Value(metric),
+		Min:    first,
+		Max:    first,
+	}
+
+	origin := points[idx[0]].Time
+	var sum, sumX, sumY, sumXY, sumXX float64
+	for _, i := range idx {
+		v := points[i].Value(metric)
+		x := points[i].Time.Sub(origin).Hours() / 24
+		sum += v
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+		sumX += x
+		sumY += v
+		sumXY += x * v
+		sumXX += x * x
+	}
+	n := float64(len(idx))
+	s.Mean = sum / n
+	if denom := n*sumXX - sumX*sumX; n > 1 && denom != 0 {
+		s.PerDay = (n*sumXY - sumX*sumY) / denom
+	}
+	return s
+}
+
+// detectBreaks flags points that deviate from the window of runs before
+// them by more than Threshold standard deviations and MinChange
+func detectBreaks(metric string, points []Point, idx []int, opts Options) []Break {
+	var breaks []Break
+	for k := opts.Window; k < len(idx); k++ {
+		var mean float64
+		for _, i := range idx[k-opts.Window : k] {
+			mean += points[i].Value(metric)
+		}
+		mean /= float64(opts.Window)
+
+		var variance float64
+		for _, i := range idx[k-opts.Window : k] {
+			d := points[i].Value(metric) - mean
+			variance += d * d
+		}
+		stddev := math.Sqrt(variance / float64(opts.Window))
+
+		p := points[idx[k]]
+		v := p.Value(metric)
+		change := (v - mean) / mean
+		if math.Abs(change) < opts.MinChange || math.Abs(v-mean) <= opts.Threshold*stddev {
+			continue
+		}
+		breaks = append(breaks, Break{
+			Metric:   metric,
+			Index:    idx[k],
+			Time:     p.Time,
+			BackupID: p.BackupID,
+			Value:    v,
+			Expected: mean,
+			Change:   change,
+		})
+	}
+	return breaks
+}
@@ -0,0 +1,114 @@
+package history
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// daily builds one run per day with the given sizes, a 50% compression
+// ratio and a duration of one second per MiB
+func daily(sizes ...int64) []Point {
+	start := time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)
+	points := make([]Point, len(sizes))
+	for i, size := range sizes {
+		points[i] = Point{
+			Time:           start.AddDate(0, 0, i),
+			BackupID:       "bkp_" + string(rune('a'+i)),
+			Size:           size,
+			CompressedSize: size / 2,
+			Duration:       time.Duration(size>>20) * time.Second,
+		}
+	}
+	return points
+}
+
+func TestAnalyzeBreaks(t *testing.T) {
+	const mib = 1 << 20
+
+	tests := []struct {
+		name       string
+		points     []Point
+		wantBreaks map[string][]int // metric -> point indexes
+	}{
+		{
+			name:       "steady growth",
+			points:     daily(100*mib, 101*mib, 102*mib, 103*mib, 104*mib, 105*mib, 106*mib, 107*mib, 108*mib),
+			wantBreaks: map[string][]int{},
+		},
+		{
+			name:   "sudden jump",
+			points: daily(100*mib, 101*mib, 100*mib, 102*mib, 101*mib, 100*mib, 101*mib, 250*mib),
+			wantBreaks: map[string][]int{
+				MetricSize:     {7},
+				MetricDuration: {7},
+			},
+		},
+		{
+			name:       "too few runs",
+			points:     daily(100*mib, 400*mib),
+			wantBreaks: map[string][]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Window = 5
+			r := Analyze("orders", tt.points, opts)
+
+			got := make(map[string][]int)
+			for _, b := range r.Breaks {
+				got[b.Metric] = append(got[b.Metric], b.Index)
+			}
+			if len(got) != len(tt.wantBreaks) {
+				t.Fatalf("breaks = %v, want %v", got, tt.wantBreaks)
+			}
+			for metric, want := range tt.wantBreaks {
+				if len(got[metric]) != len(want) || got[metric][0] != want[0] {
+					t.Errorf("%s breaks = %v, want %v", metric, got[metric], want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzeTrend(t *testing.T) {
+	const mib = 1 << 20
+	// Shuffled input is sorted by time
+	points := daily(100*mib, 110*mib, 120*mib, 130*mib)
+	points[0], points[3] = points[3], points[0]
+
+	r := Analyze("orders", points, DefaultOptions())
+	size := r.Summaries[MetricSize]
+	if math.Abs(size.PerDay-10*mib) > 1 {
+		t.Errorf("size trend = %.0f/day, want %d", size.PerDay, 10*mib)
+	}
+	if size.First != 100*mib || size.Last != 130*mib {
+		t.Errorf("first/last = %.0f/%.0f", size.First, size.Last)
+	}
+	if want := int64(430 * mib); r.ProjectedSize30d != want {
+		t.Errorf("projected = %d, want %d", r.ProjectedSize30d, want)
+	}
+	if ratio := r.Summaries[MetricRatio]; ratio.Mean != 0.5 {
+		t.Errorf("ratio mean = %v, want 0.5", ratio.Mean)
+	}
+}
+
+func TestRenderASCIIMarksBreaks(t *testing.T) {
+	const mib = 1 << 20
+	opts := DefaultOptions()
+	opts.Window = 5
+	r := Analyze("orders", daily(100*mib, 101*mib, 100*mib, 102*mib, 101*mib, 100*mib, 101*mib, 250*mib), opts)
+
+	var buf bytes.Buffer
+	RenderASCII(&buf, r, 20)
+	out := buf.String()
+	if !strings.Contains(out, "2026-01-08 02:00 !") {
+		t.Errorf("break not marked:\n%s", out)
+	}
+	if !strings.Contains(out, "Compression ratio") {
+		t.Errorf("missing ratio chart:\n%s", out)
+	}
+}
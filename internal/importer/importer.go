@@ -0,0 +1,265 @@
+// Package importer finds backups taken by other tools, so a site adopting
+// db-backup keeps its existing backups restorable and under retention. It
+// recognizes pgBackRest repositories, wal-g storage and mysqldump files in
+// a directory or a bucket, and infers from each the engine, database,
+// timestamps, sizes and a checksum without copying it anywhere.
+//
+// Physical backups (pgBackRest, wal-g) are described by the tool's own
+// metadata and identified by the checksum of their manifest or sentinel;
+// logical dumps are read in full, decompressed on the fly, so their
+// checksum covers every byte and a dump missing its completion trailer is
+// reported as truncated instead of imported.
+package importer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	storageplugin "github.com/sanskarpan/db-backup/internal/storage/plugin"
+)
+
+// Formats of the artifacts Scan recognizes
+const (
+	FormatPgBackRest = "pgbackrest"
+	FormatWalG       = "wal-g"
+	FormatMySQLDump  = "mysqldump"
+)
+
+// Artifact is a backup found by Scan
+type Artifact struct {
+	Format      string    `json:"format"`
+	Engine      string    `json:"engine"` // database type: postgres or mysql
+	Database    string    `json:"database"`
+	Location    string    `json:"location"`        // the dump, or the directory of a physical backup
+	Label       string    `json:"label,omitempty"` // the tool's name of the backup
+	Type        string    `json:"type,omitempty"`  // full, diff, incr or delta for physical backups
+	Prior       string    `json:"prior,omitempty"` // label of the backup an incremental one builds on
+	Version     string    `json:"version,omitempty"`
+	Compression string    `json:"compression,omitempty"`
+	Size        int64     `json:"size"`
+	StoredSize  int64     `json:"stored_size,omitempty"` // in the repository, once compressed
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Checksum    string    `json:"checksum"` // hex SHA-256 of the dump, or of the backup's manifest
+}
+
+// ID returns the catalog ID of the artifact. It is derived from where the
+// artifact is stored, so importing it again updates its entry instead of
+// adding another.
+func (a *Artifact) ID(source string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + a.Format + "\x00" + a.Location))
+	return "import-" + hex.EncodeToString(sum[:8])
+}
+
+// Problem is an artifact Scan recognized but could not import
+type Problem struct {
+	Location string `json:"location"`
+	Error    string `json:"error"`
+}
+
+// Object is a file in a Source
+type Object struct {
+	Key     string // slash separated, relative to the source
+	Size    int64
+	ModTime time.Time
+}
+
+// Source holds the artifacts to scan
+type Source interface {
+	// Name is the storage type recorded in the catalog, e.g. "local"
+	Name() string
+	List(ctx context.Context) ([]Object, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Location returns where the object at key is stored, e.g. its path
+	Location(key string) string
+}
+
+// Scan returns the artifacts in src, oldest first, and those recognized but
+// unreadable. Files of no known format are ignored.
+func Scan(ctx context.Context, src Source) ([]*Artifact, []Problem, error) {
+	objects, err := src.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	byKey := make(map[string]Object, len(objects))
+	for _, o := range objects {
+		byKey[o.Key] = o
+	}
+
+	s := &scan{ctx: ctx, src: src, objects: byKey}
+	// Physical backups first, so the files inside them are not taken for
+	// dumps
+	for _, o := range objects {
+		switch {
+		case path.Base(o.Key) == "backup.info" && path.Base(path.Dir(path.Dir(o.Key))) == "backup":
+			s.run(o.Key, s.pgBackRest)
+		case strings.HasSuffix(o.Key, walgSentinelSuffix) && path.Base(path.Dir(o.Key)) == walgBackupDir:
+			s.run(o.Key, s.walG)
+		}
+	}
+	for _, o := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		if !s.claimed(o.Key) && isDumpName(o.Key) {
+			s.run(o.Key, s.mysqlDump)
+		}
+	}
+
+	sort.SliceStable(s.artifacts, func(i, j int) bool {
+		return s.artifacts[i].StartTime.Before(s.artifacts[j].StartTime)
+	})
+	return s.artifacts, s.problems, ctx.Err()
+}
+
+// scan is the state of one Scan
+type scan struct {
+	ctx       context.Context
+	src       Source
+	objects   map[string]Object
+	roots     []string // directories of the physical backup trees found
+	artifacts []*Artifact
+	problems  []Problem
+}
+
+// run parses the artifacts key describes, recording a failure as a
+// Problem
+func (s *scan) run(key string, parse func(key string) ([]*Artifact, error)) {
+	artifacts, err := parse(key)
+	if err != nil {
+		s.problems = append(s.problems, Problem{Location: key, Error: err.Error()})
+	}
+	s.artifacts = append(s.artifacts, artifacts...)
+}
+
+// claim marks the files under dir as part of a physical backup tree
+func (s *scan) claim(dir string) {
+	s.roots = append(s.roots, dir+"/")
+}
+
+func (s *scan) claimed(key string) bool {
+	for _, root := range s.roots {
+		if strings.HasPrefix(key, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// read returns the contents of a small metadata file
+func (s *scan) read(key string) ([]byte, error) {
+	r, err := s.src.Open(s.ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// Metadata files are kilobytes; a manifest of a large cluster a few MB
+	return io.ReadAll(io.LimitReader(r, 64<<20))
+}
+
+// checksum returns the hex SHA-256 of a file
+func (s *scan) checksum(key string) (string, error) {
+	r, err := s.src.Open(s.ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dir is a Source of the files under a local directory
+type dir string
+
+// Dir returns the Source of the files under root
+func Dir(root string) Source {
+	return dir(root)
+}
+
+func (d dir) Name() string { return "local" }
+
+func (d dir) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(string(d), func(name string, e os.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(string(d), name)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (d dir) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(key))) // #nosec G304 -- file listed under the scanned directory
+}
+
+func (d dir) Location(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+// provider is a Source of the objects under a prefix of a plugin provider
+type provider struct {
+	name   string
+	p      storageplugin.Provider
+	prefix string
+}
+
+// Provider returns the Source of the objects under prefix in p, a storage
+// provider served by a plugin and configured as name
+func Provider(name string, p storageplugin.Provider, prefix string) Source {
+	return &provider{name: name, p: p, prefix: prefix}
+}
+
+func (p *provider) Name() string { return p.name }
+
+func (p *provider) List(ctx context.Context) ([]Object, error) {
+	listed, err := p.p.List(ctx, p.prefix)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]Object, 0, len(listed))
+	for _, o := range listed {
+		objects = append(objects, Object{Key: strings.TrimPrefix(o.Key, p.prefix), Size: o.Size, ModTime: o.ModTime})
+	}
+	return objects, nil
+}
+
+func (p *provider) Location(key string) string {
+	return p.prefix + key
+}
+
+// Open streams the object from the plugin as it is read
+func (p *provider) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(p.p.Download(ctx, p.prefix+key, pw))
+	}()
+	return pr, nil
+}
@@ -0,0 +1,160 @@
+package importer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const backupInfo = `[backrest]
+backrest-format=5
+backrest-version="2.49"
+
+[backup:current]
+20240101-120000F={"backrest-format":5,"backup-info-repo-size":2369186,"backup-info-size":20162900,"backup-prior":null,"backup-timestamp-start":1704110400,"backup-timestamp-stop":1704110410,"backup-type":"full","db-id":1}
+20240101-120000F_20240102-120000I={"backrest-format":5,"backup-info-repo-size":1024,"backup-info-size":8192,"backup-prior":"20240101-120000F","backup-timestamp-start":1704196800,"backup-timestamp-stop":1704196805,"backup-type":"incr","db-id":1}
+20240103-120000F={"backrest-format":5,"backup-info-repo-size":1,"backup-info-size":1,"backup-prior":null,"backup-timestamp-start":1704283200,"backup-timestamp-stop":1704283210,"backup-type":"full","db-id":1}
+
+[db:history]
+1={"db-catalog-version":202209061,"db-control-version":1300,"db-system-id":7311478217356447862,"db-version":"15"}
+`
+
+const dump = "-- MySQL dump 10.13  Distrib 8.0.35, for Linux (x86_64)\n" +
+	"--\n" +
+	"-- Host: db1    Database: shop\n" +
+	"-- ------------------------------------------------------\n" +
+	"-- Server version\t8.0.35\n\n" +
+	"INSERT INTO `orders` VALUES (1,'a'),(2,'b');\n\n" +
+	"-- Dump completed on 2024-01-05  3:04:05\n"
+
+func write(t *testing.T, root, name, data string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gz(data string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(data))
+	zw.Close()
+	return buf.String()
+}
+
+func sum(data string) string {
+	s := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(s[:])
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	write(t, root, "pgbackrest/backup/main/backup.info", backupInfo)
+	write(t, root, "pgbackrest/backup/main/backup.info.copy", backupInfo)
+	write(t, root, "pgbackrest/backup/main/20240101-120000F/backup.manifest", "[backup]\n")
+	write(t, root, "pgbackrest/backup/main/20240101-120000F_20240102-120000I/backup.manifest.copy", "[backup]\nincr\n")
+	write(t, root, "pgbackrest/backup/main/20240101-120000F/pg_data/dump.sql", dump)
+
+	write(t, root, "walg/cluster1/basebackups_005/base_000000010000000000000002_backup_stop_sentinel.json",
+		`{"PgVersion":150004,"UncompressedSize":100,"CompressedSize":40}`)
+	write(t, root, "walg/cluster1/basebackups_005/base_000000010000000000000002/metadata.json",
+		`{"start_time":"2024-01-04T10:00:00.5Z","finish_time":"2024-01-04T10:05:00Z","hostname":"pg1","pg_version":150004}`)
+	write(t, root, "walg/mysql/basebackups_005/stream_20240106T000000Z_backup_stop_sentinel.json",
+		`{"BinLogStart":"binlog.000001","StartLocalTime":"2024-01-06T00:00:00Z","StopLocalTime":"2024-01-06T00:10:00Z","UncompressedSize":5,"Hostname":"mysql1","ServerVersion":"8.0.35"}`)
+
+	write(t, root, "dumps/shop.sql.gz", gz(dump))
+	write(t, root, "dumps/all.sql", strings.Replace(dump, "Database: shop", "Database: ", 1)+
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `a``b` /*!40100 DEFAULT CHARACTER SET utf8mb4 */;\n"+
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `users`;\n-- Dump completed\n")
+	write(t, root, "dumps/truncated.sql", dump[:strings.Index(dump, "-- Dump completed")])
+	write(t, root, "dumps/pg.sql", "-- PostgreSQL database dump\n")
+	write(t, root, "notes.txt", "-- MySQL dump\n")
+
+	artifacts, problems, err := Scan(context.Background(), Dir(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byLabel := map[string]*Artifact{}
+	for _, a := range artifacts {
+		byLabel[a.Format+":"+a.Label+filepath.Base(a.Location)] = a
+	}
+	if len(artifacts) != 6 {
+		for _, a := range artifacts {
+			t.Logf("%+v", a)
+		}
+		t.Fatalf("Scan() found %d artifacts", len(artifacts))
+	}
+
+	full := byLabel["pgbackrest:20240101-120000F20240101-120000F"]
+	if full == nil || full.Database != "main" || full.Engine != "postgres" || full.Version != "15" || full.Size != 20162900 ||
+		full.StoredSize != 2369186 || !full.StartTime.Equal(time.Unix(1704110400, 0)) || full.Checksum != sum("[backup]\n") ||
+		full.Location != filepath.Join(root, "pgbackrest/backup/main/20240101-120000F") {
+		t.Errorf("pgBackRest full backup = %+v", full)
+	}
+	incr := byLabel["pgbackrest:20240101-120000F_20240102-120000I20240101-120000F_20240102-120000I"]
+	if incr == nil || incr.Type != "incr" || incr.Prior != "20240101-120000F" || incr.Checksum != sum("[backup]\nincr\n") {
+		t.Errorf("pgBackRest incremental backup = %+v", incr)
+	}
+
+	pg := byLabel["wal-g:base_000000010000000000000002base_000000010000000000000002"]
+	if pg == nil || pg.Engine != "postgres" || pg.Database != "pg1" || pg.Version != "15.4" || pg.Size != 100 ||
+		!pg.EndTime.Equal(time.Date(2024, 1, 4, 10, 5, 0, 0, time.UTC)) {
+		t.Errorf("wal-g PostgreSQL backup = %+v", pg)
+	}
+	my := byLabel["wal-g:stream_20240106T000000Zstream_20240106T000000Z"]
+	if my == nil || my.Engine != "mysql" || my.Database != "mysql1" || my.Version != "8.0.35" {
+		t.Errorf("wal-g MySQL backup = %+v", my)
+	}
+
+	shop := byLabel["mysqldump:shop.sql.gz"]
+	wantEnd := time.Date(2024, 1, 5, 3, 4, 5, 0, time.Local)
+	if shop == nil || shop.Database != "shop" || shop.Compression != "gzip" || shop.Size != int64(len(dump)) ||
+		shop.Checksum != sum(gz(dump)) || !shop.EndTime.Equal(wantEnd) || shop.Version != "8.0.35" {
+		t.Errorf("mysqldump = %+v", shop)
+	}
+	all := byLabel["mysqldump:all.sql"]
+	if all == nil || all.Database != "a`b,users" || all.Compression != "" || all.StoredSize != 0 {
+		t.Errorf("mysqldump --all-databases = %+v", all)
+	}
+
+	// Oldest first
+	for i := 1; i < len(artifacts); i++ {
+		if artifacts[i].StartTime.Before(artifacts[i-1].StartTime) {
+			t.Errorf("artifact %d is older than %d", i, i-1)
+		}
+	}
+
+	if len(problems) != 2 {
+		t.Fatalf("problems = %+v", problems)
+	}
+	for _, p := range problems {
+		switch {
+		case strings.HasSuffix(p.Location, "backup.info"):
+			if !strings.Contains(p.Error, "20240103-120000F: no backup.manifest") {
+				t.Errorf("problem = %+v", p)
+			}
+		case strings.HasSuffix(p.Location, "truncated.sql"):
+			if !strings.Contains(p.Error, "truncated") {
+				t.Errorf("problem = %+v", p)
+			}
+		default:
+			t.Errorf("problem = %+v", p)
+		}
+	}
+
+	// IDs are stable and differ by source
+	if full.ID("local") != full.ID("local") || full.ID("local") == full.ID("tape") || full.ID("local") == incr.ID("local") {
+		t.Error("ID() is not derived from the location")
+	}
+}
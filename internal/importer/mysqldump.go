@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// dumpSuffixes are the names of SQL dumps, compressed or not
+var dumpSuffixes = []string{".sql", ".sql.gz", ".sql.zst", ".sql.lz4", ".dump", ".dump.gz"}
+
+// isDumpName reports whether key may be a SQL dump
+func isDumpName(key string) bool {
+	for _, suffix := range dumpSuffixes {
+		if strings.HasSuffix(strings.ToLower(key), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// -- Host: db1    Database: shop
+	dumpHost = regexp.MustCompile(`^-- Host: (\S*)\s+Database: (.*)$`)
+	// -- Server version	8.0.35
+	dumpServer = regexp.MustCompile(`^-- Server version\s+(\S+)`)
+	// CREATE DATABASE /*!32312 IF NOT EXISTS*/ `shop` ...
+	dumpCreateDB = regexp.MustCompile("^CREATE DATABASE .*?`((?:[^`]|``)+)`")
+	// -- Dump completed on 2024-01-01 12:00:00, with --skip-dump-date
+	// without the date
+	dumpCompleted = regexp.MustCompile(`^-- Dump completed(?: on (\d{4}-\d{2}-\d{2} +\d{1,2}:\d{2}:\d{2}))?`)
+)
+
+// mysqlDump reads the file at key in full and returns it when it is a
+// complete mysqldump or mariadb-dump. Files of another kind are skipped.
+// mysqldump records when it completed in the server's local time zone,
+// taken as this host's.
+func (s *scan) mysqlDump(key string) ([]*Artifact, error) {
+	r, err := s.src.Open(s.ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	hw := stream.NewHashWriter(nil)
+	data, codec, err := stream.Decompress(io.TeeReader(r, hw))
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	counted := &countingReader{r: data}
+	br := bufio.NewReaderSize(counted, 64<<10)
+	first, err := readLine(br)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !strings.HasPrefix(first, "-- MySQL dump") && !strings.HasPrefix(first, "-- MariaDB dump") {
+		return nil, nil
+	}
+
+	a := &Artifact{
+		Format:   FormatMySQLDump,
+		Engine:   "mysql",
+		Location: s.src.Location(key),
+	}
+	if codec != "none" {
+		a.Compression = codec
+	}
+	var databases []string
+	completed := false
+	for {
+		line, err := readLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dump: %w", err)
+		}
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "-- ") && !strings.HasPrefix(line, "CREATE DATABASE") {
+			completed = false
+			continue
+		}
+		if m := dumpHost.FindStringSubmatch(line); m != nil {
+			a.Database = strings.TrimSpace(m[2])
+		} else if m := dumpServer.FindStringSubmatch(line); m != nil {
+			a.Version = m[1]
+		} else if m := dumpCreateDB.FindStringSubmatch(line); m != nil {
+			databases = append(databases, strings.ReplaceAll(m[1], "``", "`"))
+		} else if m := dumpCompleted.FindStringSubmatch(line); m != nil {
+			completed = true
+			if m[1] != "" {
+				if t, err := time.ParseInLocation("2006-01-02 15:04:05", strings.Join(strings.Fields(m[1]), " "), time.Local); err == nil {
+					a.EndTime = t.UTC()
+				}
+			}
+		}
+	}
+	// Hash what follows the compressed stream too
+	if _, err := stream.Copy(s.ctx, hw, r); err != nil {
+		return nil, err
+	}
+	// The trailer is the last line mysqldump writes
+	if !completed {
+		return nil, fmt.Errorf("no \"-- Dump completed\" trailer, the dump is truncated")
+	}
+	if a.Database == "" {
+		// --all-databases and --databases dumps name no database in the
+		// header
+		a.Database = strings.Join(databases, ",")
+	}
+	if a.EndTime.IsZero() {
+		a.EndTime = s.objects[key].ModTime.UTC()
+	}
+	// Only the completion is recorded
+	a.StartTime = a.EndTime
+	a.Size = counted.n
+	if codec != "none" {
+		a.StoredSize = hw.Written()
+	}
+	a.Checksum = hw.Sum()
+	return []*Artifact{a}, nil
+}
+
+// readLine returns the next line of br. Only the first 4KB of longer lines,
+// such as extended INSERTs, are returned.
+func readLine(br *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := br.ReadLine()
+		if err != nil {
+			return string(line), err
+		}
+		if len(line) < 4096 {
+			line = append(line, chunk[:min(len(chunk), 4096-len(line))]...)
+		}
+		if !isPrefix {
+			return string(bytes.TrimRight(line, "\r")), nil
+		}
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
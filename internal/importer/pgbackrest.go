@@ -0,0 +1,133 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pgBackRestBackup is a backup of the [backup:current] section of a
+// stanza's backup.info
+type pgBackRestBackup struct {
+	Prior          *string `json:"backup-prior"`
+	RepoSize       int64   `json:"backup-info-repo-size"`
+	Size           int64   `json:"backup-info-size"`
+	TimestampStart int64   `json:"backup-timestamp-start"`
+	TimestampStop  int64   `json:"backup-timestamp-stop"`
+	Type           string  `json:"backup-type"`
+	DBID           int     `json:"db-id"`
+}
+
+// pgBackRestDB is a cluster of the [db:history] section
+type pgBackRestDB struct {
+	Version string `json:"db-version"`
+}
+
+// pgBackRest returns the backups of the stanza whose backup.info is at
+// key, repo/backup/<stanza>/backup.info. Each backup is the directory
+// named after its label next to it, identified by the checksum of its
+// backup.manifest.
+func (s *scan) pgBackRest(key string) ([]*Artifact, error) {
+	stanzaDir := path.Dir(key)
+	s.claim(stanzaDir)
+	data, err := s.read(key)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := parseINI(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup.info: %w", err)
+	}
+
+	clusters := map[string]pgBackRestDB{}
+	for id, value := range sections["db:history"] {
+		var db pgBackRestDB
+		if err := json.Unmarshal([]byte(value), &db); err == nil {
+			clusters[id] = db
+		}
+	}
+	labels := make([]string, 0, len(sections["backup:current"]))
+	for label := range sections["backup:current"] {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var artifacts []*Artifact
+	var problems []string
+	for _, label := range labels {
+		var b pgBackRestBackup
+		if err := json.Unmarshal([]byte(sections["backup:current"][label]), &b); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", label, err))
+			continue
+		}
+		dir := stanzaDir + "/" + label
+		manifest := dir + "/backup.manifest"
+		if _, ok := s.objects[manifest]; !ok {
+			// The copy pgBackRest writes next to every manifest
+			manifest += ".copy"
+		}
+		if _, ok := s.objects[manifest]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: no backup.manifest, the backup is incomplete or expired", label))
+			continue
+		}
+		sum, err := s.checksum(manifest)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", label, err))
+			continue
+		}
+
+		a := &Artifact{
+			Format:     FormatPgBackRest,
+			Engine:     "postgres",
+			Database:   path.Base(stanzaDir),
+			Location:   s.src.Location(dir),
+			Label:      label,
+			Type:       b.Type,
+			Version:    clusters[strconv.Itoa(b.DBID)].Version,
+			Size:       b.Size,
+			StoredSize: b.RepoSize,
+			StartTime:  time.Unix(b.TimestampStart, 0).UTC(),
+			EndTime:    time.Unix(b.TimestampStop, 0).UTC(),
+			Checksum:   sum,
+		}
+		if b.Prior != nil {
+			a.Prior = *b.Prior
+		}
+		artifacts = append(artifacts, a)
+	}
+	if len(problems) > 0 {
+		return artifacts, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return artifacts, nil
+}
+
+// parseINI parses pgBackRest's info and manifest files: [section] headers
+// and key=value lines, values being JSON
+func parseINI(data []byte) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{}
+	var current map[string]string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			current = map[string]string{}
+			sections[line[1:len(line)-1]] = current
+		default:
+			k, v, ok := strings.Cut(line, "=")
+			if !ok || current == nil {
+				return nil, fmt.Errorf("line %d: %q", n, line)
+			}
+			current[k] = v
+		}
+	}
+	return sections, sc.Err()
+}
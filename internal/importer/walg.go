@@ -0,0 +1,119 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// wal-g keeps every backup in <prefix>/basebackups_005: a directory named
+// after the backup and, written once the backup completed, a sentinel
+// <name>_backup_stop_sentinel.json
+const (
+	walgBackupDir      = "basebackups_005"
+	walgSentinelSuffix = "_backup_stop_sentinel.json"
+)
+
+// walgSentinel holds the fields of PostgreSQL and MySQL sentinels
+type walgSentinel struct {
+	// PostgreSQL
+	DeltaFrom *string `json:"DeltaFrom"`
+	PgVersion int     `json:"PgVersion"`
+	// MySQL
+	BinLogStart    string    `json:"BinLogStart"`
+	StartLocalTime time.Time `json:"StartLocalTime"`
+	StopLocalTime  time.Time `json:"StopLocalTime"`
+	ServerVersion  string    `json:"ServerVersion"`
+	Hostname       string    `json:"Hostname"`
+	// Both
+	UncompressedSize int64 `json:"UncompressedSize"`
+	CompressedSize   int64 `json:"CompressedSize"`
+}
+
+// walgMetadata is the metadata.json of a PostgreSQL backup
+type walgMetadata struct {
+	StartTime        time.Time `json:"start_time"`
+	FinishTime       time.Time `json:"finish_time"`
+	Hostname         string    `json:"hostname"`
+	PgVersion        int       `json:"pg_version"`
+	UncompressedSize int64     `json:"uncompressed_size"`
+	CompressedSize   int64     `json:"compressed_size"`
+}
+
+// walG returns the backup whose sentinel is at key, identified by the
+// sentinel's checksum
+func (s *scan) walG(key string) ([]*Artifact, error) {
+	name := strings.TrimSuffix(path.Base(key), walgSentinelSuffix)
+	dir := path.Dir(key) + "/" + name
+	s.claim(path.Dir(key))
+
+	data, err := s.read(key)
+	if err != nil {
+		return nil, err
+	}
+	var sentinel walgSentinel
+	if err := json.Unmarshal(data, &sentinel); err != nil {
+		return nil, fmt.Errorf("invalid sentinel: %w", err)
+	}
+	sum, err := s.checksum(key)
+	if err != nil {
+		return nil, err
+	}
+	a := &Artifact{
+		Format:     FormatWalG,
+		Location:   s.src.Location(dir),
+		Label:      name,
+		Type:       "full",
+		Size:       sentinel.UncompressedSize,
+		StoredSize: sentinel.CompressedSize,
+		Checksum:   sum,
+	}
+
+	if sentinel.BinLogStart != "" {
+		a.Engine = "mysql"
+		a.Database = sentinel.Hostname
+		a.Version = sentinel.ServerVersion
+		a.StartTime, a.EndTime = sentinel.StartLocalTime.UTC(), sentinel.StopLocalTime.UTC()
+	} else {
+		a.Engine = "postgres"
+		a.Version = pgVersion(sentinel.PgVersion)
+		if sentinel.DeltaFrom != nil {
+			a.Type, a.Prior = "delta", *sentinel.DeltaFrom
+		}
+		// Times and the host are only in metadata.json
+		data, err := s.read(dir + "/metadata.json")
+		if err != nil {
+			return nil, fmt.Errorf("%s: no metadata.json: %w", name, err)
+		}
+		var m walgMetadata
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("%s: invalid metadata.json: %w", name, err)
+		}
+		a.Database = m.Hostname
+		a.StartTime, a.EndTime = m.StartTime.UTC(), m.FinishTime.UTC()
+		if a.Size == 0 {
+			a.Size, a.StoredSize = m.UncompressedSize, m.CompressedSize
+		}
+		if a.Version == "" {
+			a.Version = pgVersion(m.PgVersion)
+		}
+	}
+	if prefix := path.Dir(path.Dir(key)); a.Database == "" && prefix != "." {
+		// The storage prefix usually names the cluster
+		a.Database = path.Base(prefix)
+	}
+	return []*Artifact{a}, nil
+}
+
+// pgVersion formats a server_version_num, e.g. 150004 as 15.4
+func pgVersion(num int) string {
+	switch {
+	case num == 0:
+		return ""
+	case num >= 100000:
+		return fmt.Sprintf("%d.%d", num/10000, num%10000)
+	}
+	return fmt.Sprintf("%d.%d.%d", num/10000, num/100%100, num%100)
+}
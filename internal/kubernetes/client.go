@@ -0,0 +1,328 @@
+// Package kubernetes backs up databases running in Kubernetes pods. Dumps
+// run the tools of the database image through a pod exec, so no database
+// port has to be exposed outside the cluster, and the volumes of the
+// database can be captured alongside as CSI VolumeSnapshots, a physical
+// copy that restores in minutes where a dump would take hours.
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Paths mounted into every pod with a service account
+const (
+	serviceAccountDir       = "/var/run/secrets/kubernetes.io/serviceaccount"
+	serviceAccountToken     = serviceAccountDir + "/token"
+	serviceAccountCA        = serviceAccountDir + "/ca.crt"
+	serviceAccountNamespace = serviceAccountDir + "/namespace"
+)
+
+// ErrNotFound is returned for objects the API server does not know
+var ErrNotFound = errors.New("not found")
+
+// Client is a minimal client of the Kubernetes API, covering what pod
+// discovery, exec dumps and volume snapshots need
+type Client struct {
+	server    string
+	namespace string // of the kubeconfig context or service account
+	tls       *tls.Config
+	token     string
+	tokenFile string
+	client    *http.Client
+}
+
+// Connect creates a client from kubeconfig, using the named context or the
+// current one. Without a kubeconfig, the service account of the pod is
+// used when running in a cluster, then $KUBECONFIG and ~/.kube/config.
+func Connect(kubeconfig, context string) (*Client, error) {
+	if kubeconfig == "" {
+		if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			return InCluster()
+		}
+		kubeconfig = os.Getenv("KUBECONFIG")
+		if i := strings.IndexRune(kubeconfig, os.PathListSeparator); i >= 0 {
+			// Merged kubeconfigs are not supported: use the first
+			kubeconfig = kubeconfig[:i]
+		}
+	}
+	if kubeconfig == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("no kubeconfig: %w", err)
+		}
+		kubeconfig = filepath.Join(home, ".kube", "config")
+	}
+	return FromKubeconfig(kubeconfig, context)
+}
+
+// InCluster creates a client from the service account of the pod
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	ca, err := os.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", serviceAccountCA)
+	}
+	c := newClient("https://"+net.JoinHostPort(host, port), &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	c.tokenFile = serviceAccountToken
+	if ns, err := os.ReadFile(serviceAccountNamespace); err == nil {
+		c.namespace = strings.TrimSpace(string(ns))
+	}
+	return c, nil
+}
+
+// kubeconfig is the part of a kubeconfig file the client understands
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TLSServerName            string `yaml:"tls-server-name"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string    `yaml:"token"`
+			TokenFile             string    `yaml:"tokenFile"`
+			ClientCertificate     string    `yaml:"client-certificate"`
+			ClientCertificateData string    `yaml:"client-certificate-data"`
+			ClientKey             string    `yaml:"client-key"`
+			ClientKeyData         string    `yaml:"client-key-data"`
+			Exec                  yaml.Node `yaml:"exec"`
+			AuthProvider          yaml.Node `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// FromKubeconfig creates a client from a kubeconfig file. Bearer tokens
+// and client certificates are supported; exec and auth-provider plugins
+// are not, use a service account token for those clusters.
+func FromKubeconfig(path, contextName string) (*Client, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- kubeconfig chosen by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+	// Relative paths in a kubeconfig are relative to the file
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	var clusterName, userName, namespace string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			clusterName, userName, namespace, found = c.Context.Cluster, c.Context.User, c.Context.Namespace, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in %s", contextName, path)
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	var server string
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		server = c.Cluster.Server
+		tlsCfg.ServerName = c.Cluster.TLSServerName
+		tlsCfg.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify // #nosec G402 -- requested by the kubeconfig
+		ca, err := pemData(c.Cluster.CertificateAuthorityData, resolve(c.Cluster.CertificateAuthority))
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", clusterName, err)
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("cluster %s: no certificates in its certificate authority", clusterName)
+			}
+			tlsCfg.RootCAs = pool
+		}
+	}
+	if server == "" {
+		return nil, fmt.Errorf("cluster %q of context %q not found in %s", clusterName, contextName, path)
+	}
+
+	c := newClient(server, tlsCfg)
+	c.namespace = namespace
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if !u.User.Exec.IsZero() || !u.User.AuthProvider.IsZero() {
+			return nil, fmt.Errorf("user %s: exec and auth-provider credentials are not supported, use a token", userName)
+		}
+		c.token, c.tokenFile = u.User.Token, resolve(u.User.TokenFile)
+		cert, err := pemData(u.User.ClientCertificateData, resolve(u.User.ClientCertificate))
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", userName, err)
+		}
+		key, err := pemData(u.User.ClientKeyData, resolve(u.User.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", userName, err)
+		}
+		if cert != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("user %s: invalid client certificate: %w", userName, err)
+			}
+			tlsCfg.Certificates = []tls.Certificate{pair}
+		}
+	}
+	return c, nil
+}
+
+// pemData returns base64 inline data, or the contents of file
+func pemData(inline, file string) ([]byte, error) {
+	if inline != "" {
+		data, err := base64.StdEncoding.DecodeString(inline)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 data: %w", err)
+		}
+		return data, nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(file) // #nosec G304 -- path from the kubeconfig
+}
+
+func newClient(server string, tlsCfg *tls.Config) *Client {
+	return &Client{
+		server: strings.TrimRight(server, "/"),
+		tls:    tlsCfg,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsCfg, Proxy: http.ProxyFromEnvironment},
+		},
+	}
+}
+
+// Namespace is the namespace of the kubeconfig context or, in a cluster,
+// of the pod; "default" when neither names one
+func (c *Client) Namespace() string {
+	if c.namespace == "" {
+		return "default"
+	}
+	return c.namespace
+}
+
+// authorization returns the Authorization header value, if any. Token
+// files are re-read on each request so rotated tokens are picked up.
+func (c *Client) authorization() (string, error) {
+	token := c.token
+	if c.tokenFile != "" {
+		data, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return "", nil
+	}
+	return "Bearer " + token, nil
+}
+
+// do sends a request and decodes a successful response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	auth, err := c.authorization()
+	if err != nil {
+		return err
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return statusError(method, path, resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// statusError turns an error response, a Status object with a message,
+// into an error
+func statusError(method, path string, resp *http.Response) error {
+	var status struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &status) != nil || status.Message == "" {
+		status.Message = strings.TrimSpace(string(data))
+	}
+	path, _, _ = strings.Cut(path, "?")
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, status.Message)
+	}
+	return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, status.Message)
+}
@@ -0,0 +1,179 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/sanskarpan/db-backup/internal/execdump"
+)
+
+// AnnotationContainer picks the database container of a pod running
+// several known database images, e.g. with a replication sidecar
+const AnnotationContainer = "db-backup.container"
+
+// Target is a database container of a pod and the settings its dumps run
+// with
+type Target struct {
+	Namespace         string   `json:"namespace" yaml:"namespace"`
+	Pod               string   `json:"pod" yaml:"pod"`
+	Container         string   `json:"container" yaml:"container"`
+	Image             string   `json:"image" yaml:"image"`
+	PVCs              []string `json:"pvcs,omitempty" yaml:"pvcs,omitempty"` // claims mounted by the container
+	execdump.Settings `yaml:",inline"`
+}
+
+// Name is the namespace/pod name of the target
+func (t Target) Name() string { return t.Namespace + "/" + t.Pod }
+
+// pod is the part of a Pod object discovery reads
+type pod struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Containers []struct {
+			Name  string `json:"name"`
+			Image string `json:"image"`
+			Env   []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"` // empty for valueFrom
+			} `json:"env"`
+			VolumeMounts []struct {
+				Name string `json:"name"`
+			} `json:"volumeMounts"`
+		} `json:"containers"`
+		Volumes []struct {
+			Name                  string `json:"name"`
+			PersistentVolumeClaim *struct {
+				ClaimName string `json:"claimName"`
+			} `json:"persistentVolumeClaim"`
+		} `json:"volumes"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+// Discover returns the database containers of the running pods matching
+// selector, a label selector, in namespace or in every namespace when it
+// is empty, sorted by name. Only the env of the pod spec is read: a
+// password set through envFrom is found by annotating the pod with
+// execdump.LabelPasswordEnv.
+func (c *Client) Discover(ctx context.Context, namespace, selector string) ([]Target, error) {
+	path := "/api/v1/pods"
+	if namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods"
+	}
+	q := url.Values{"fieldSelector": {"status.phase=Running"}}
+	if selector != "" {
+		q.Set("labelSelector", selector)
+	}
+	var list struct {
+		Items []pod `json:"items"`
+	}
+	if err := c.do(ctx, http.MethodGet, path+"?"+q.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+	var targets []Target
+	for i := range list.Items {
+		if t, ok := target(&list.Items[i]); ok {
+			targets = append(targets, t)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name() < targets[j].Name() })
+	return targets, nil
+}
+
+// Find returns the database container of a pod, the one named container
+// when it is set
+func (c *Client) Find(ctx context.Context, namespace, name, container string) (Target, error) {
+	var p pod
+	if err := c.do(ctx, http.MethodGet, "/api/v1/namespaces/"+url.PathEscape(namespace)+"/pods/"+url.PathEscape(name), nil, &p); err != nil {
+		return Target{}, err
+	}
+	if container != "" {
+		if p.Metadata.Annotations == nil {
+			p.Metadata.Annotations = map[string]string{}
+		}
+		p.Metadata.Annotations[AnnotationContainer] = container
+	}
+	t, ok := target(&p)
+	if !ok {
+		return Target{}, fmt.Errorf("pod %s/%s runs no known database image; annotate it %s", namespace, name, execdump.LabelType)
+	}
+	if p.Status.Phase != "Running" {
+		return Target{}, fmt.Errorf("pod %s/%s is %s", namespace, name, p.Status.Phase)
+	}
+	return t, nil
+}
+
+// target derives the dump settings of the database container of a pod.
+// Annotations take precedence over labels, whose values are restricted
+// to a narrower syntax.
+func target(p *pod) (Target, bool) {
+	labels := make(map[string]string, len(p.Metadata.Labels)+len(p.Metadata.Annotations))
+	for k, v := range p.Metadata.Labels {
+		labels[k] = v
+	}
+	for k, v := range p.Metadata.Annotations {
+		labels[k] = v
+	}
+
+	claims := make(map[string]string)
+	for _, v := range p.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			claims[v.Name] = v.PersistentVolumeClaim.ClaimName
+		}
+	}
+
+	want := labels[AnnotationContainer]
+	for _, ct := range p.Spec.Containers {
+		if want != "" && ct.Name != want {
+			continue
+		}
+		// Variables set from a secret or field are known by name only
+		env := make(map[string]string, len(ct.Env))
+		for _, e := range ct.Env {
+			env[e.Name] = e.Value
+		}
+		s, ok := execdump.Detect(ct.Image, labels, env)
+		if !ok {
+			continue
+		}
+		t := Target{
+			Namespace: p.Metadata.Namespace,
+			Pod:       p.Metadata.Name,
+			Container: ct.Name,
+			Image:     ct.Image,
+			Settings:  s,
+		}
+		for _, m := range ct.VolumeMounts {
+			if claim, ok := claims[m.Name]; ok {
+				t.PVCs = append(t.PVCs, claim)
+			}
+		}
+		return t, true
+	}
+	return Target{}, false
+}
+
+// Dump runs the dump tool of the target's image inside its container and
+// writes the dump to w. Passwords are read by a shell in the container
+// from its own environment, so secrets never pass through this process.
+func (c *Client) Dump(ctx context.Context, t Target, opts execdump.Options, w io.Writer) error {
+	cmd, err := execdump.ShellCommand(t.Settings, opts)
+	if err != nil {
+		return err
+	}
+	if err := c.Exec(ctx, t.Namespace, t.Pod, t.Container, cmd, w); err != nil {
+		return fmt.Errorf("dump of %s failed: %w", t.Name(), err)
+	}
+	return nil
+}
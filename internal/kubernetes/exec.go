@@ -0,0 +1,155 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sanskarpan/db-backup/internal/execdump"
+)
+
+// execProtocol is the streaming protocol of pod execs: binary messages
+// whose first byte is the channel of the payload that follows
+const execProtocol = "v4.channel.k8s.io"
+
+// Channels of the exec protocol
+const (
+	channelStdout = 1
+	channelStderr = 2
+	channelStatus = 3 // a Status object once the command has ended
+)
+
+// execStatus is the Status object sent on the status channel
+type execStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Details struct {
+		Causes []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"causes"`
+	} `json:"details"`
+}
+
+// Exec runs cmd in a container of a pod, writing its stdout to stdout. A
+// non-zero exit is returned as an *execdump.ExitError with the end of
+// stderr. Cancelling ctx closes the stream, which ends the command.
+func (c *Client) Exec(ctx context.Context, namespace, pod, container string, cmd []string, stdout io.Writer) error {
+	u, err := url.Parse(c.server)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods/" + url.PathEscape(pod) + "/exec"
+	q := url.Values{"stdout": {"true"}, "stderr": {"true"}, "command": cmd}
+	if container != "" {
+		q.Set("container", container)
+	}
+	u.RawQuery = q.Encode()
+
+	header := http.Header{}
+	auth, err := c.authorization()
+	if err != nil {
+		return err
+	}
+	if auth != "" {
+		header.Set("Authorization", auth)
+	}
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		TLSClientConfig:  c.tls,
+		HandshakeTimeout: c.client.Timeout,
+		Subprotocols:     []string{execProtocol},
+	}
+	conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			return statusError(http.MethodGet, u.Path, resp)
+		}
+		return fmt.Errorf("exec in %s/%s: %w", namespace, pod, err)
+	}
+	defer conn.Close()
+
+	// Reads block until the command writes or ends: closing the
+	// connection is the only way to interrupt them
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	stderr := &execdump.Stderr{}
+	var status *execStatus
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if status == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return fmt.Errorf("exec stream of %s/%s: %w", namespace, pod, err)
+			}
+			break
+		}
+		if len(data) == 0 {
+			continue
+		}
+		switch data[0] {
+		case channelStdout:
+			if _, err := stdout.Write(data[1:]); err != nil {
+				return err
+			}
+		case channelStderr:
+			_, _ = stderr.Write(data[1:])
+		case channelStatus:
+			status = &execStatus{}
+			if err := json.Unmarshal(data[1:], status); err != nil {
+				return fmt.Errorf("invalid exec status: %w", err)
+			}
+		}
+	}
+	if status == nil {
+		return errors.New("exec stream ended without a status")
+	}
+	return status.err(stderr.String())
+}
+
+// err returns the error reported by an exec status, if any
+func (s *execStatus) err(stderr string) error {
+	if s.Status == "Success" {
+		return nil
+	}
+	if s.Reason == "NonZeroExitCode" {
+		for _, cause := range s.Details.Causes {
+			if cause.Reason != "ExitCode" {
+				continue
+			}
+			if code, err := strconv.Atoi(cause.Message); err == nil {
+				return &execdump.ExitError{Code: code, Stderr: stderr}
+			}
+		}
+	}
+	if stderr != "" {
+		return fmt.Errorf("%s: %s", s.Message, stderr)
+	}
+	return errors.New(s.Message)
+}
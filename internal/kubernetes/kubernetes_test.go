@@ -0,0 +1,317 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sanskarpan/db-backup/internal/execdump"
+)
+
+// fakeCluster serves the API endpoints the client uses
+type fakeCluster struct {
+	pods []map[string]any
+
+	mu        sync.Mutex
+	execs     []execCall
+	snapshots []map[string]any
+	auth      []string
+	// exec output and exit code
+	stdout, stderr string
+	exitCode       int
+}
+
+type execCall struct {
+	Path      string
+	Container string
+	Command   []string
+}
+
+var upgrader = websocket.Upgrader{Subprotocols: []string{execProtocol}}
+
+func (f *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	f.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/exec"):
+		f.mu.Lock()
+		f.execs = append(f.execs, execCall{path, r.URL.Query().Get("container"), r.URL.Query()["command"]})
+		f.mu.Unlock()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		send := func(channel byte, data []byte) {
+			conn.WriteMessage(websocket.BinaryMessage, append([]byte{channel}, data...))
+		}
+		// Split stdout across messages like the kubelet does
+		for i := 0; i < len(f.stdout); i += 4 {
+			send(channelStdout, []byte(f.stdout[i:min(i+4, len(f.stdout))]))
+		}
+		send(channelStderr, []byte(f.stderr))
+		status := map[string]any{"status": "Success"}
+		if f.exitCode != 0 {
+			status = map[string]any{
+				"status":  "Failure",
+				"reason":  "NonZeroExitCode",
+				"message": "command terminated with non-zero exit code",
+				"details": map[string]any{"causes": []map[string]string{{"reason": "ExitCode", "message": "2"}}},
+			}
+		}
+		data, _ := json.Marshal(status)
+		send(channelStatus, data)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	case path == "/api/v1/pods" || strings.HasSuffix(path, "/pods"):
+		var items []map[string]any
+		for _, p := range f.pods {
+			ns := p["metadata"].(map[string]any)["namespace"]
+			if path == "/api/v1/pods" || path == "/api/v1/namespaces/"+ns.(string)+"/pods" {
+				items = append(items, p)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"items": items})
+	case strings.Contains(path, "/pods/"):
+		for _, p := range f.pods {
+			meta := p["metadata"].(map[string]any)
+			if path == "/api/v1/namespaces/"+meta["namespace"].(string)+"/pods/"+meta["name"].(string) {
+				json.NewEncoder(w).Encode(p)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"kind": "Status", "message": "pods not found"})
+	case strings.HasSuffix(path, "/volumesnapshots") && r.Method == http.MethodPost:
+		var obj map[string]any
+		json.NewDecoder(r.Body).Decode(&obj)
+		f.mu.Lock()
+		f.snapshots = append(f.snapshots, obj)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(obj)
+	case strings.Contains(path, "/volumesnapshots/"):
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": map[string]any{"readyToUse": true, "restoreSize": "10Gi", "boundVolumeSnapshotContentName": "snapcontent-1"},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeCluster(t *testing.T) (*fakeCluster, *Client) {
+	t.Helper()
+	f := &fakeCluster{pods: []map[string]any{
+		testPod("shop", "db-0", map[string]any{
+			"containers": []map[string]any{
+				{"name": "metrics", "image": "prometheuscommunity/postgres-exporter"},
+				{
+					"name":  "postgres",
+					"image": "postgres:16",
+					"env": []map[string]any{
+						{"name": "POSTGRES_DB", "value": "shop"},
+						{"name": "POSTGRES_PASSWORD", "valueFrom": map[string]any{"secretKeyRef": map[string]string{"name": "db", "key": "password"}}},
+					},
+					"volumeMounts": []map[string]string{{"name": "data"}, {"name": "config"}},
+				},
+			},
+			"volumes": []map[string]any{
+				{"name": "data", "persistentVolumeClaim": map[string]string{"claimName": "data-db-0"}},
+				{"name": "config", "configMap": map[string]string{"name": "pg"}},
+			},
+		}),
+		testPod("web", "nginx-1", map[string]any{
+			"containers": []map[string]any{{"name": "nginx", "image": "nginx:1.27"}},
+		}),
+	}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	c := newClient(srv.URL, nil)
+	c.token = "t0ken"
+	return f, c
+}
+
+func testPod(namespace, name string, spec map[string]any) map[string]any {
+	return map[string]any{
+		"metadata": map[string]any{"namespace": namespace, "name": name},
+		"spec":     spec,
+		"status":   map[string]any{"phase": "Running"},
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	_, c := newFakeCluster(t)
+	targets, err := c.Discover(context.Background(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("targets = %+v", targets)
+	}
+	want := Target{
+		Namespace: "shop",
+		Pod:       "db-0",
+		Container: "postgres",
+		Image:     "postgres:16",
+		PVCs:      []string{"data-db-0"},
+		Settings:  execdump.Settings{Type: execdump.TypePostgres, User: "postgres", Database: "shop", PasswordEnv: "POSTGRES_PASSWORD"},
+	}
+	got := targets[0]
+	if got.Name() != "shop/db-0" || got.Container != want.Container || got.Settings != want.Settings || !slices.Equal(got.PVCs, want.PVCs) {
+		t.Errorf("target = %+v, want %+v", got, want)
+	}
+
+	if targets, _ := c.Discover(context.Background(), "web", ""); len(targets) != 0 {
+		t.Errorf("web targets = %+v", targets)
+	}
+	if _, err := c.Find(context.Background(), "shop", "db-1", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Find(missing) = %v, want ErrNotFound", err)
+	}
+	if _, err := c.Find(context.Background(), "web", "nginx-1", ""); err == nil {
+		t.Error("nginx pod found as a database")
+	}
+	if _, err := c.Find(context.Background(), "shop", "db-0", "metrics"); err == nil {
+		t.Error("exporter container found as a database")
+	}
+}
+
+func TestDump(t *testing.T) {
+	f, c := newFakeCluster(t)
+	f.stdout = "PGDMP custom format"
+	target, err := c.Find(context.Background(), "shop", "db-0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.Dump(context.Background(), target, execdump.Options{Tables: []string{"orders"}}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != f.stdout {
+		t.Errorf("dump = %q", buf.String())
+	}
+
+	call := f.execs[0]
+	if call.Path != "/api/v1/namespaces/shop/pods/db-0/exec" || call.Container != "postgres" {
+		t.Errorf("exec = %+v", call)
+	}
+	// The password is read from the container's own environment
+	if !slices.Equal(call.Command[:3], []string{"sh", "-c", `PGPASSWORD="$POSTGRES_PASSWORD" exec "$@"`}) || call.Command[4] != "pg_dump" {
+		t.Errorf("command = %q", call.Command)
+	}
+	if f.auth[0] != "Bearer t0ken" {
+		t.Errorf("authorization = %q", f.auth[0])
+	}
+}
+
+func TestDumpExitCode(t *testing.T) {
+	f, c := newFakeCluster(t)
+	f.stderr = "pg_dump: error: connection failed\n"
+	f.exitCode = 2
+	target, err := c.Find(context.Background(), "shop", "db-0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Dump(context.Background(), target, execdump.Options{}, &bytes.Buffer{})
+	var exitErr *execdump.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 || exitErr.Stderr != "pg_dump: error: connection failed" {
+		t.Fatalf("Dump() = %v, want exit code 2", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	f, c := newFakeCluster(t)
+	target, err := c.Find(context.Background(), "shop", "db-0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := c.Snapshot(context.Background(), target, SnapshotOptions{Class: "csi-snapclass", DumpSHA256: "abc123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].PVC != "data-db-0" || !strings.HasPrefix(snapshots[0].Name, "data-db-0-") {
+		t.Fatalf("snapshots = %+v", snapshots)
+	}
+
+	obj := f.snapshots[0]
+	spec := obj["spec"].(map[string]any)
+	meta := obj["metadata"].(map[string]any)
+	if spec["volumeSnapshotClassName"] != "csi-snapclass" || spec["source"].(map[string]any)["persistentVolumeClaimName"] != "data-db-0" {
+		t.Errorf("spec = %v", spec)
+	}
+	if meta["annotations"].(map[string]any)[AnnotationDumpSHA256] != "abc123" {
+		t.Errorf("metadata = %v", meta)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WaitReady(ctx, snapshots, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if !snapshots[0].ReadyToUse || snapshots[0].RestoreSize != "10Gi" || snapshots[0].Content != "snapcontent-1" {
+		t.Errorf("snapshot = %+v", snapshots[0])
+	}
+}
+
+func TestFromKubeconfig(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600)
+	kubeconfig := filepath.Join(dir, "config")
+	os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443/
+    insecure-skip-tls-verify: true
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: dev
+  context: {cluster: dev, user: dev, namespace: shop}
+- name: prod
+  context: {cluster: prod, user: sso}
+users:
+- name: dev
+  user:
+    tokenFile: token
+- name: sso
+  user:
+    exec:
+      command: aws
+`), 0600)
+
+	c, err := FromKubeconfig(kubeconfig, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.server != "https://dev.example.com:6443" || c.Namespace() != "shop" || !c.tls.InsecureSkipVerify {
+		t.Errorf("client = %+v", c)
+	}
+	// Relative to the kubeconfig, and re-read for rotated tokens
+	if auth, err := c.authorization(); err != nil || auth != "Bearer file-token" {
+		t.Errorf("authorization = %q, %v", auth, err)
+	}
+
+	if _, err := FromKubeconfig(kubeconfig, "prod"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("exec user = %v, want unsupported", err)
+	}
+	if _, err := FromKubeconfig(kubeconfig, "staging"); err == nil {
+		t.Error("missing context accepted")
+	}
+}
@@ -0,0 +1,126 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Label and annotations of the VolumeSnapshots created for a target. Pod
+// names can exceed what a label value allows, so they are annotations.
+const (
+	LabelManagedBy       = "app.kubernetes.io/managed-by"
+	AnnotationPod        = "db-backup.io/pod"
+	AnnotationDumpSHA256 = "db-backup.io/dump-sha256"
+)
+
+// snapshotAPI is the path of the CSI snapshot API group
+const snapshotAPI = "/apis/snapshot.storage.k8s.io/v1"
+
+// Snapshot is a CSI VolumeSnapshot of a volume of a target
+type Snapshot struct {
+	Namespace   string    `json:"namespace" yaml:"namespace"`
+	Name        string    `json:"name" yaml:"name"`
+	PVC         string    `json:"pvc" yaml:"pvc"`
+	CreatedAt   time.Time `json:"created_at" yaml:"created_at"`
+	ReadyToUse  bool      `json:"ready_to_use" yaml:"ready_to_use"`
+	RestoreSize string    `json:"restore_size,omitempty" yaml:"restore_size,omitempty"`
+	Content     string    `json:"content,omitempty" yaml:"content,omitempty"` // bound VolumeSnapshotContent
+}
+
+// SnapshotOptions configures the VolumeSnapshots of a target
+type SnapshotOptions struct {
+	Class      string // VolumeSnapshotClass, the cluster default when empty
+	DumpSHA256 string // recorded on each snapshot to link it to its dump
+}
+
+// volumeSnapshot is the part of a VolumeSnapshot object read back
+type volumeSnapshot struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Status *struct {
+		ReadyToUse                     *bool  `json:"readyToUse"`
+		RestoreSize                    string `json:"restoreSize"`
+		BoundVolumeSnapshotContentName string `json:"boundVolumeSnapshotContentName"`
+		Error                          *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"status"`
+}
+
+// Snapshot creates a VolumeSnapshot of each claim the target's container
+// mounts. Snapshots of a running database are crash consistent: they
+// complement dumps, restoring like a database after a power loss.
+func (c *Client) Snapshot(ctx context.Context, t Target, opts SnapshotOptions) ([]Snapshot, error) {
+	now := time.Now().UTC()
+	snapshots := make([]Snapshot, 0, len(t.PVCs))
+	for _, claim := range t.PVCs {
+		// Object names are at most 253 characters
+		prefix := claim
+		if len(prefix) > 237 {
+			prefix = prefix[:237]
+		}
+		name := prefix + "-" + now.Format("20060102-150405")
+		spec := map[string]any{
+			"source": map[string]any{"persistentVolumeClaimName": claim},
+		}
+		if opts.Class != "" {
+			spec["volumeSnapshotClassName"] = opts.Class
+		}
+		annotations := map[string]string{AnnotationPod: t.Pod}
+		if opts.DumpSHA256 != "" {
+			annotations[AnnotationDumpSHA256] = opts.DumpSHA256
+		}
+		metadata := map[string]any{
+			"name":        name,
+			"labels":      map[string]string{LabelManagedBy: "db-backup"},
+			"annotations": annotations,
+		}
+		obj := map[string]any{
+			"apiVersion": "snapshot.storage.k8s.io/v1",
+			"kind":       "VolumeSnapshot",
+			"metadata":   metadata,
+			"spec":       spec,
+		}
+		if err := c.do(ctx, http.MethodPost, snapshotAPI+"/namespaces/"+url.PathEscape(t.Namespace)+"/volumesnapshots", obj, nil); err != nil {
+			return snapshots, fmt.Errorf("failed to snapshot %s: %w", claim, err)
+		}
+		snapshots = append(snapshots, Snapshot{Namespace: t.Namespace, Name: name, PVC: claim, CreatedAt: now})
+	}
+	return snapshots, nil
+}
+
+// WaitReady polls snapshots until all are ready to use, one fails or ctx
+// is done, updating them in place
+func (c *Client) WaitReady(ctx context.Context, snapshots []Snapshot, interval time.Duration) error {
+	for i := range snapshots {
+		s := &snapshots[i]
+		for !s.ReadyToUse {
+			var vs volumeSnapshot
+			path := snapshotAPI + "/namespaces/" + url.PathEscape(s.Namespace) + "/volumesnapshots/" + url.PathEscape(s.Name)
+			if err := c.do(ctx, http.MethodGet, path, nil, &vs); err != nil {
+				return err
+			}
+			if st := vs.Status; st != nil {
+				if st.Error != nil && st.Error.Message != "" {
+					return fmt.Errorf("snapshot %s of %s failed: %s", s.Name, s.PVC, st.Error.Message)
+				}
+				s.ReadyToUse = st.ReadyToUse != nil && *st.ReadyToUse
+				s.RestoreSize = st.RestoreSize
+				s.Content = st.BoundVolumeSnapshotContentName
+			}
+			if s.ReadyToUse {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("snapshot %s of %s not ready: %w", s.Name, s.PVC, ctx.Err())
+			case <-time.After(interval):
+			}
+		}
+	}
+	return nil
+}
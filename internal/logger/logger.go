@@ -10,6 +10,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/sanskarpan/db-backup/pkg/redact"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
@@ -34,6 +35,7 @@ type FileConfig struct {
 // Logger wraps zerolog.Logger
 type Logger struct {
 	logger zerolog.Logger
+	writer io.Writer
 }
 
 // New creates a new logger instance
@@ -68,6 +70,9 @@ func New(config Config) *Logger {
 		}
 	}
 
+	// Scrub credentials from every record before it is written
+	writer = redact.NewWriter(writer)
+
 	// Create logger
 	logger := zerolog.New(writer).
 		Level(level).
@@ -76,7 +81,7 @@ func New(config Config) *Logger {
 		Caller().
 		Logger()
 
-	return &Logger{logger: logger}
+	return &Logger{logger: logger, writer: writer}
 }
 
 // createFileWriter creates a file writer with rotation
@@ -127,7 +132,17 @@ func (l *Logger) WithContext(fields map[string]interface{}) *Logger {
 	for k, v := range fields {
 		ctx = ctx.Interface(k, v)
 	}
-	return &Logger{logger: ctx.Logger()}
+	return &Logger{logger: ctx.Logger(), writer: l.writer}
+}
+
+// WithOutput returns a logger that also writes every record, as JSON, to w
+// (for example the OpenTelemetry log exporter)
+func (l *Logger) WithOutput(w io.Writer) *Logger {
+	if w == nil {
+		return l
+	}
+	writer := zerolog.MultiLevelWriter(l.writer, redact.NewWriter(w))
+	return &Logger{logger: l.logger.Output(writer), writer: writer}
 }
 
 // Debug logs a debug message
@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CatalogEntry is one backup recorded in the catalog
+type CatalogEntry struct {
+	Database       string
+	Type           string
+	Success        bool
+	Size           int64
+	CompressedSize int64
+	StartedAt      time.Time
+	FinishedAt     time.Time
+}
+
+// CatalogStatus summarises the catalog entries of one database
+type CatalogStatus struct {
+	Database       string
+	Type           string
+	Successes      int
+	Failures       int
+	LastSuccess    time.Time // when the newest successful backup finished
+	LastFailure    time.Time
+	Size           int64 // of the newest successful backup
+	CompressedSize int64
+	StoredSize     int64 // of every successful backup
+	// Verification is only exported when tracked. The newest successful
+	// backup is verified when a backup at least as new was.
+	VerificationTracked bool
+	LastVerified        time.Time // time of the newest verified backup
+	Verified            bool
+}
+
+// CatalogFunc reads the catalog summary of every database
+type CatalogFunc func() ([]CatalogStatus, error)
+
+// SummarizeCatalog summarises entries per database, sorted by name.
+// lastVerified holds the time of each database's newest verified backup;
+// nil means verification is not tracked.
+func SummarizeCatalog(entries []CatalogEntry, lastVerified map[string]time.Time) []CatalogStatus {
+	byDatabase := make(map[string]*CatalogStatus)
+	newest := make(map[string]time.Time) // start of the newest successful backup
+	for _, e := range entries {
+		s := byDatabase[e.Database]
+		if s == nil {
+			s = &CatalogStatus{Database: e.Database, Type: e.Type}
+			byDatabase[e.Database] = s
+		}
+		finished := e.FinishedAt
+		if finished.IsZero() {
+			finished = e.StartedAt
+		}
+		if !e.Success {
+			s.Failures++
+			if finished.After(s.LastFailure) {
+				s.LastFailure = finished
+			}
+			continue
+		}
+		s.Successes++
+		s.StoredSize += storedSize(e)
+		if finished.After(s.LastSuccess) {
+			s.LastSuccess = finished
+			s.Size = e.Size
+			s.CompressedSize = e.CompressedSize
+			s.Type = e.Type
+			newest[e.Database] = e.StartedAt
+		}
+	}
+	if lastVerified != nil {
+		for name, at := range lastVerified {
+			if byDatabase[name] == nil {
+				byDatabase[name] = &CatalogStatus{Database: name}
+			}
+			byDatabase[name].LastVerified = at
+		}
+		for name, s := range byDatabase {
+			s.VerificationTracked = true
+			s.Verified = s.Successes > 0 && !s.LastVerified.IsZero() && !s.LastVerified.Before(newest[name])
+		}
+	}
+
+	statuses := make([]CatalogStatus, 0, len(byDatabase))
+	for _, s := range byDatabase {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Database < statuses[j].Database })
+	return statuses
+}
+
+// storedSize is what a backup takes up in storage
+func storedSize(e CatalogEntry) int64 {
+	if e.CompressedSize > 0 {
+		return e.CompressedSize
+	}
+	return e.Size
+}
+
+// WatchCatalog exports the catalog summary, read at scrape time
+func (m *Metrics) WatchCatalog(status CatalogFunc) error {
+	return m.registry.Register(&catalogCollector{status: status, now: time.Now})
+}
+
+var (
+	catalogBackupsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "catalog", "backups"),
+		"Backups recorded in the catalog, by outcome.",
+		[]string{"database", "type", "status"}, nil,
+	)
+	catalogLastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "catalog", "last_success_timestamp_seconds"),
+		"Unix time the newest successful backup in the catalog finished.",
+		[]string{"database", "type"}, nil,
+	)
+	catalogLastSuccessAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "catalog", "last_success_age_seconds"),
+		"Time since the newest successful backup in the catalog finished.",
+		[]string{"database", "type"}, nil,
+	)
+	catalogLastFailureDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "catalog", "last_failure_timestamp_seconds"),
+		"Unix time the newest failed backup in the catalog finished.",
+		[]string{"database", "type"}, nil,
+	)
+	catalogSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "catalog", "backup_size_bytes"),
+		"Uncompressed size of the newest successful backup.",
+		[]string{"database", "type"}, nil,
+	)
+	catalogCompressedSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "catalog", "backup_compressed_size_bytes"),
+		"Stored size of the newest successful backup.",
+		[]string{"database", "type"}, nil,
+	)
+	catalogStoredSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "catalog", "stored_bytes"),
+		"Stored size of every successful backup in the catalog.",
+		[]string{"database", "type"}, nil,
+	)
+	catalogVerificationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "catalog", "verification_status"),
+		"Whether the newest successful backup is verified (1) or not (0).",
+		[]string{"database"}, nil,
+	)
+	catalogLastVerifiedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "catalog", "last_verified_backup_timestamp_seconds"),
+		"Unix time of the newest verified backup.",
+		[]string{"database"}, nil,
+	)
+)
+
+// catalogCollector reads the catalog summary on every scrape
+type catalogCollector struct {
+	status CatalogFunc
+	now    func() time.Time
+}
+
+func (c *catalogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- catalogBackupsDesc
+	ch <- catalogLastSuccessDesc
+	ch <- catalogLastSuccessAgeDesc
+	ch <- catalogLastFailureDesc
+	ch <- catalogSizeDesc
+	ch <- catalogCompressedSizeDesc
+	ch <- catalogStoredSizeDesc
+	ch <- catalogVerificationDesc
+	ch <- catalogLastVerifiedDesc
+}
+
+func (c *catalogCollector) Collect(ch chan<- prometheus.Metric) {
+	statuses, err := c.status()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(catalogBackupsDesc, err)
+		return
+	}
+	now := c.now()
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+	for _, s := range statuses {
+		if s.Successes > 0 || s.Failures > 0 {
+			gauge(catalogBackupsDesc, float64(s.Successes), s.Database, s.Type, StatusSuccess)
+			gauge(catalogBackupsDesc, float64(s.Failures), s.Database, s.Type, StatusFailure)
+		}
+		if !s.LastSuccess.IsZero() {
+			gauge(catalogLastSuccessDesc, float64(s.LastSuccess.Unix()), s.Database, s.Type)
+			gauge(catalogLastSuccessAgeDesc, now.Sub(s.LastSuccess).Seconds(), s.Database, s.Type)
+			gauge(catalogSizeDesc, float64(s.Size), s.Database, s.Type)
+			gauge(catalogStoredSizeDesc, float64(s.StoredSize), s.Database, s.Type)
+			if s.CompressedSize > 0 {
+				gauge(catalogCompressedSizeDesc, float64(s.CompressedSize), s.Database, s.Type)
+			}
+		}
+		if !s.LastFailure.IsZero() {
+			gauge(catalogLastFailureDesc, float64(s.LastFailure.Unix()), s.Database, s.Type)
+		}
+		if s.VerificationTracked {
+			gauge(catalogVerificationDesc, boolValue(s.Verified), s.Database)
+			if !s.LastVerified.IsZero() {
+				gauge(catalogLastVerifiedDesc, float64(s.LastVerified.Unix()), s.Database)
+			}
+		}
+	}
+}
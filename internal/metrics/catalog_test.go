@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSummarizeCatalog(t *testing.T) {
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	entries := []CatalogEntry{
+		{Database: "orders", Type: "postgres", Success: true, Size: 100, CompressedSize: 40, StartedAt: day, FinishedAt: day.Add(time.Hour)},
+		{Database: "orders", Type: "postgres", Success: true, Size: 120, CompressedSize: 50, StartedAt: day.Add(24 * time.Hour), FinishedAt: day.Add(25 * time.Hour)},
+		{Database: "orders", Type: "postgres", Success: false, StartedAt: day.Add(48 * time.Hour)},
+		{Database: "billing", Type: "mysql", Success: true, Size: 10, StartedAt: day, FinishedAt: day.Add(time.Minute)},
+	}
+
+	statuses := SummarizeCatalog(entries, nil)
+	if len(statuses) != 2 || statuses[0].Database != "billing" || statuses[1].Database != "orders" {
+		t.Fatalf("got %+v", statuses)
+	}
+	orders := statuses[1]
+	if orders.Successes != 2 || orders.Failures != 1 {
+		t.Errorf("counts: got %d/%d", orders.Successes, orders.Failures)
+	}
+	if !orders.LastSuccess.Equal(day.Add(25*time.Hour)) || orders.Size != 120 || orders.CompressedSize != 50 {
+		t.Errorf("newest success: got %+v", orders)
+	}
+	if !orders.LastFailure.Equal(day.Add(48 * time.Hour)) {
+		t.Errorf("last failure: got %v", orders.LastFailure)
+	}
+	if orders.StoredSize != 90 || statuses[0].StoredSize != 10 {
+		t.Errorf("stored size: got %d and %d", orders.StoredSize, statuses[0].StoredSize)
+	}
+	if orders.VerificationTracked {
+		t.Error("verification tracked without verifications")
+	}
+
+	// Only the older orders backup is verified
+	statuses = SummarizeCatalog(entries, map[string]time.Time{
+		"orders":    day.Add(30 * time.Minute),
+		"billing":   day.Add(30 * time.Second),
+		"inventory": day,
+	})
+	want := map[string]bool{"billing": true, "inventory": false, "orders": false}
+	if len(statuses) != len(want) {
+		t.Fatalf("got %+v", statuses)
+	}
+	for _, s := range statuses {
+		if !s.VerificationTracked || s.Verified != want[s.Database] {
+			t.Errorf("%s: tracked %v, verified %v", s.Database, s.VerificationTracked, s.Verified)
+		}
+	}
+}
+
+func TestCatalogCollector(t *testing.T) {
+	now := time.Unix(1800000000, 0)
+	c := &catalogCollector{now: func() time.Time { return now }, status: func() ([]CatalogStatus, error) {
+		return []CatalogStatus{{
+			Database:            "orders",
+			Type:                "postgres",
+			Successes:           2,
+			LastSuccess:         now.Add(-time.Hour),
+			Size:                120,
+			StoredSize:          90,
+			VerificationTracked: true,
+		}}, nil
+	}}
+
+	want := `
+# HELP dbbackup_catalog_backups Backups recorded in the catalog, by outcome.
+# TYPE dbbackup_catalog_backups gauge
+dbbackup_catalog_backups{database="orders",status="failure",type="postgres"} 0
+dbbackup_catalog_backups{database="orders",status="success",type="postgres"} 2
+# HELP dbbackup_catalog_last_success_age_seconds Time since the newest successful backup in the catalog finished.
+# TYPE dbbackup_catalog_last_success_age_seconds gauge
+dbbackup_catalog_last_success_age_seconds{database="orders",type="postgres"} 3600
+# HELP dbbackup_catalog_verification_status Whether the newest successful backup is verified (1) or not (0).
+# TYPE dbbackup_catalog_verification_status gauge
+dbbackup_catalog_verification_status{database="orders"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want),
+		"dbbackup_catalog_backups", "dbbackup_catalog_last_success_age_seconds", "dbbackup_catalog_verification_status"); err != nil {
+		t.Error(err)
+	}
+	// No compressed size was recorded, and nothing was ever verified
+	if n := testutil.CollectAndCount(c, "dbbackup_catalog_backup_compressed_size_bytes", "dbbackup_catalog_last_verified_backup_timestamp_seconds"); n != 0 {
+		t.Errorf("got %d unexpected metrics", n)
+	}
+
+	c.status = func() ([]CatalogStatus, error) { return nil, errors.New("metadata directory unreadable") }
+	if _, err := testutil.CollectAndLint(c); err == nil {
+		t.Error("expected the catalog error")
+	}
+}
@@ -0,0 +1,382 @@
+// Package metrics exposes backup health as Prometheus metrics, so alert
+// rules such as "no successful backup in 24h" reduce to a single expression:
+//
+//	time() - dbbackup_last_success_timestamp_seconds > 86400
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+const namespace = "dbbackup"
+
+// Backup outcomes used as the status label
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// BackupResult describes one finished backup run
+type BackupResult struct {
+	Database       string
+	Type           string
+	Success        bool
+	Duration       time.Duration
+	Size           int64
+	CompressedSize int64
+	FinishedAt     time.Time
+}
+
+// Recorder is implemented by every metrics backend
+type Recorder interface {
+	RecordBackup(r BackupResult)
+	RecordVerification(database string, ok bool, at time.Time)
+	RecordScheduleRun(schedule string, planned, started time.Time)
+}
+
+// QueueDepthFunc reports the number of pending and dead-lettered
+// notification deliveries
+type QueueDepthFunc func() (pending, dead int, err error)
+
+// RecoveryStatus is the recovery posture of one database. Zero objectives
+// are not exported.
+type RecoveryStatus struct {
+	Database         string
+	HasRecoveryPoint bool
+	PointAge         time.Duration
+	RPO              time.Duration
+	RPOBreached      bool
+	TimeEstimate     time.Duration
+	RTO              time.Duration
+	RTOBreached      bool
+}
+
+// RecoveryFunc reports the recovery posture of every tracked database
+type RecoveryFunc func() ([]RecoveryStatus, error)
+
+// Metrics holds the db-backup collectors in a dedicated registry. Go runtime
+// and process metrics live in a separate registry so they are served but
+// never pushed.
+type Metrics struct {
+	registry *prometheus.Registry
+	runtime  *prometheus.Registry
+
+	backupsTotal        *prometheus.CounterVec
+	lastSuccess         *prometheus.GaugeVec
+	lastFailure         *prometheus.GaugeVec
+	lastStatus          *prometheus.GaugeVec
+	duration            *prometheus.HistogramVec
+	size                *prometheus.GaugeVec
+	compressedSize      *prometheus.GaugeVec
+	verificationStatus  *prometheus.GaugeVec
+	lastVerification    *prometheus.GaugeVec
+	schedulerLag        *prometheus.GaugeVec
+	scheduleLastStarted *prometheus.GaugeVec
+}
+
+// New creates and registers the metrics
+func New() *Metrics {
+	dbLabels := []string{"database", "type"}
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		runtime:  prometheus.NewRegistry(),
+		backupsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backups_total",
+			Help:      "Backup runs by outcome.",
+		}, []string{"database", "type", "status"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful backup.",
+		}, dbLabels),
+		lastFailure: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_failure_timestamp_seconds",
+			Help:      "Unix time of the last failed backup.",
+		}, dbLabels),
+		lastStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_backup_success",
+			Help:      "Whether the last backup succeeded (1) or failed (0).",
+		}, dbLabels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backup_duration_seconds",
+			Help:      "Backup run duration.",
+			// 1s up to ~9h
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		}, []string{"database", "type", "status"}),
+		size: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backup_size_bytes",
+			Help:      "Uncompressed size of the last successful backup.",
+		}, dbLabels),
+		compressedSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backup_compressed_size_bytes",
+			Help:      "Stored size of the last successful backup.",
+		}, dbLabels),
+		verificationStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "verification_status",
+			Help:      "Whether the last backup verification passed (1) or failed (0).",
+		}, []string{"database"}),
+		lastVerification: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_verification_timestamp_seconds",
+			Help:      "Unix time of the last backup verification.",
+		}, []string{"database"}),
+		schedulerLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "scheduler_lag_seconds",
+			Help:      "Delay between a schedule's planned and actual start.",
+		}, []string{"schedule"}),
+		scheduleLastStarted: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "schedule_last_run_timestamp_seconds",
+			Help:      "Unix time a schedule last started a backup.",
+		}, []string{"schedule"}),
+	}
+
+	m.runtime.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	m.registry.MustRegister(
+		m.backupsTotal,
+		m.lastSuccess,
+		m.lastFailure,
+		m.lastStatus,
+		m.duration,
+		m.size,
+		m.compressedSize,
+		m.verificationStatus,
+		m.lastVerification,
+		m.schedulerLag,
+		m.scheduleLastStarted,
+	)
+	return m
+}
+
+// Registry returns the registry holding every db-backup metric
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the db-backup and runtime metrics in the Prometheus
+// exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(prometheus.Gatherers{m.registry, m.runtime}, promhttp.HandlerOpts{})
+}
+
+// RecordBackup records the outcome of a backup run
+func (m *Metrics) RecordBackup(r BackupResult) {
+	if r.FinishedAt.IsZero() {
+		r.FinishedAt = time.Now()
+	}
+	status := StatusFailure
+	if r.Success {
+		status = StatusSuccess
+	}
+	ts := float64(r.FinishedAt.Unix())
+
+	m.backupsTotal.WithLabelValues(r.Database, r.Type, status).Inc()
+	m.duration.WithLabelValues(r.Database, r.Type, status).Observe(r.Duration.Seconds())
+	if !r.Success {
+		m.lastFailure.WithLabelValues(r.Database, r.Type).Set(ts)
+		m.lastStatus.WithLabelValues(r.Database, r.Type).Set(0)
+		return
+	}
+	m.lastSuccess.WithLabelValues(r.Database, r.Type).Set(ts)
+	m.lastStatus.WithLabelValues(r.Database, r.Type).Set(1)
+	m.size.WithLabelValues(r.Database, r.Type).Set(float64(r.Size))
+	if r.CompressedSize > 0 {
+		m.compressedSize.WithLabelValues(r.Database, r.Type).Set(float64(r.CompressedSize))
+	}
+}
+
+// RecordVerification records the result of verifying a backup
+func (m *Metrics) RecordVerification(database string, ok bool, at time.Time) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	status := 0.0
+	if ok {
+		status = 1
+	}
+	m.verificationStatus.WithLabelValues(database).Set(status)
+	m.lastVerification.WithLabelValues(database).Set(float64(at.Unix()))
+}
+
+// RecordScheduleRun records that a schedule planned for the given time
+// started now
+func (m *Metrics) RecordScheduleRun(schedule string, planned, started time.Time) {
+	lag := started.Sub(planned)
+	if lag < 0 {
+		lag = 0
+	}
+	m.schedulerLag.WithLabelValues(schedule).Set(lag.Seconds())
+	m.scheduleLastStarted.WithLabelValues(schedule).Set(float64(started.Unix()))
+}
+
+// WatchQueue exports the notification queue depth, read at scrape time
+func (m *Metrics) WatchQueue(depth QueueDepthFunc) error {
+	return m.registry.Register(&queueCollector{depth: depth})
+}
+
+var queueDepthDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "notification", "queue_depth"),
+	"Notification deliveries waiting in the queue, by state.",
+	[]string{"state"}, nil,
+)
+
+// queueCollector reads the queue depth on every scrape
+type queueCollector struct {
+	depth QueueDepthFunc
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	pending, dead, err := c.depth()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(queueDepthDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(pending), "pending")
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(dead), "dead")
+}
+
+// WatchRecovery exports RPO/RTO status, read at scrape time
+func (m *Metrics) WatchRecovery(status RecoveryFunc) error {
+	return m.registry.Register(&recoveryCollector{status: status})
+}
+
+var (
+	recoveryPointAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "recovery_point_age_seconds"),
+		"Time since the newest usable backup.",
+		[]string{"database"}, nil,
+	)
+	rpoObjectiveDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "rpo_objective_seconds"),
+		"Configured recovery point objective.",
+		[]string{"database"}, nil,
+	)
+	rpoBreachedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "rpo_breached"),
+		"Whether the recovery point objective is breached (1) or met (0).",
+		[]string{"database"}, nil,
+	)
+	rtoEstimateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "rto_estimate_seconds"),
+		"Estimated restore time from recent restore rehearsals.",
+		[]string{"database"}, nil,
+	)
+	rtoObjectiveDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "rto_objective_seconds"),
+		"Configured recovery time objective.",
+		[]string{"database"}, nil,
+	)
+	rtoBreachedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "rto_breached"),
+		"Whether the recovery time objective is breached (1) or met (0).",
+		[]string{"database"}, nil,
+	)
+)
+
+// recoveryCollector reads the RPO/RTO status on every scrape
+type recoveryCollector struct {
+	status RecoveryFunc
+}
+
+func (c *recoveryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- recoveryPointAgeDesc
+	ch <- rpoObjectiveDesc
+	ch <- rpoBreachedDesc
+	ch <- rtoEstimateDesc
+	ch <- rtoObjectiveDesc
+	ch <- rtoBreachedDesc
+}
+
+func (c *recoveryCollector) Collect(ch chan<- prometheus.Metric) {
+	statuses, err := c.status()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(recoveryPointAgeDesc, err)
+		return
+	}
+	gauge := func(desc *prometheus.Desc, v float64, db string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, db)
+	}
+	for _, s := range statuses {
+		if s.HasRecoveryPoint {
+			gauge(recoveryPointAgeDesc, s.PointAge.Seconds(), s.Database)
+		}
+		if s.RPO > 0 {
+			gauge(rpoObjectiveDesc, s.RPO.Seconds(), s.Database)
+			gauge(rpoBreachedDesc, boolValue(s.RPOBreached), s.Database)
+		}
+		if s.TimeEstimate > 0 {
+			gauge(rtoEstimateDesc, s.TimeEstimate.Seconds(), s.Database)
+		}
+		if s.RTO > 0 {
+			gauge(rtoObjectiveDesc, s.RTO.Seconds(), s.Database)
+			gauge(rtoBreachedDesc, boolValue(s.RTOBreached), s.Database)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Serve exposes the metrics on the configured port and path until the
+// context is cancelled
+func (m *Metrics) Serve(ctx context.Context, cfg config.PrometheusConfig) error {
+	path := cfg.Path
+	if path == "" {
+		path = "/metrics"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, m.Handler())
+
+	srv := &http.Server{
+		Addr:              net.JoinHostPort("", strconv.Itoa(cfg.Port)),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("metrics server failed: %w", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
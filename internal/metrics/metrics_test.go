@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordBackup(t *testing.T) {
+	m := New()
+	first := time.Unix(1700000000, 0)
+	m.RecordBackup(BackupResult{
+		Database: "orders", Type: "postgres", Success: true, Duration: 3 * time.Second,
+		Size: 2048, CompressedSize: 512, FinishedAt: first,
+	})
+
+	want := `
+# HELP dbbackup_backups_total Backup runs by outcome.
+# TYPE dbbackup_backups_total counter
+dbbackup_backups_total{database="orders",status="success",type="postgres"} 1
+# HELP dbbackup_last_success_timestamp_seconds Unix time of the last successful backup.
+# TYPE dbbackup_last_success_timestamp_seconds gauge
+dbbackup_last_success_timestamp_seconds{database="orders",type="postgres"} 1.7e+09
+# HELP dbbackup_last_backup_success Whether the last backup succeeded (1) or failed (0).
+# TYPE dbbackup_last_backup_success gauge
+dbbackup_last_backup_success{database="orders",type="postgres"} 1
+# HELP dbbackup_backup_size_bytes Uncompressed size of the last successful backup.
+# TYPE dbbackup_backup_size_bytes gauge
+dbbackup_backup_size_bytes{database="orders",type="postgres"} 2048
+# HELP dbbackup_backup_compressed_size_bytes Stored size of the last successful backup.
+# TYPE dbbackup_backup_compressed_size_bytes gauge
+dbbackup_backup_compressed_size_bytes{database="orders",type="postgres"} 512
+`
+	names := []string{
+		"dbbackup_backups_total",
+		"dbbackup_last_success_timestamp_seconds",
+		"dbbackup_last_failure_timestamp_seconds",
+		"dbbackup_last_backup_success",
+		"dbbackup_backup_size_bytes",
+		"dbbackup_backup_compressed_size_bytes",
+	}
+	if err := testutil.GatherAndCompare(m.Registry(), strings.NewReader(want), names...); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failure keeps the last success and its sizes, and flips the status
+	m.RecordBackup(BackupResult{
+		Database: "orders", Type: "postgres", Duration: time.Second, Size: 1, FinishedAt: first.Add(time.Hour),
+	})
+	want = `
+# HELP dbbackup_backups_total Backup runs by outcome.
+# TYPE dbbackup_backups_total counter
+dbbackup_backups_total{database="orders",status="failure",type="postgres"} 1
+dbbackup_backups_total{database="orders",status="success",type="postgres"} 1
+# HELP dbbackup_last_success_timestamp_seconds Unix time of the last successful backup.
+# TYPE dbbackup_last_success_timestamp_seconds gauge
+dbbackup_last_success_timestamp_seconds{database="orders",type="postgres"} 1.7e+09
+# HELP dbbackup_last_failure_timestamp_seconds Unix time of the last failed backup.
+# TYPE dbbackup_last_failure_timestamp_seconds gauge
+dbbackup_last_failure_timestamp_seconds{database="orders",type="postgres"} 1.7000036e+09
+# HELP dbbackup_last_backup_success Whether the last backup succeeded (1) or failed (0).
+# TYPE dbbackup_last_backup_success gauge
+dbbackup_last_backup_success{database="orders",type="postgres"} 0
+# HELP dbbackup_backup_size_bytes Uncompressed size of the last successful backup.
+# TYPE dbbackup_backup_size_bytes gauge
+dbbackup_backup_size_bytes{database="orders",type="postgres"} 2048
+# HELP dbbackup_backup_compressed_size_bytes Stored size of the last successful backup.
+# TYPE dbbackup_backup_compressed_size_bytes gauge
+dbbackup_backup_compressed_size_bytes{database="orders",type="postgres"} 512
+`
+	if err := testutil.GatherAndCompare(m.Registry(), strings.NewReader(want), names...); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testutil.CollectAndCount(m.duration, "dbbackup_backup_duration_seconds"); n != 2 {
+		t.Errorf("%d duration series, want one per status", n)
+	}
+}
+
+func TestRecordVerificationAndSchedule(t *testing.T) {
+	m := New()
+	at := time.Unix(1700000000, 0)
+	m.RecordVerification("orders", false, at)
+	m.RecordScheduleRun("nightly", at, at.Add(90*time.Second))
+	// A run started early has no lag
+	m.RecordScheduleRun("hourly", at, at.Add(-time.Second))
+
+	verification := `
+# HELP dbbackup_verification_status Whether the last backup verification passed (1) or failed (0).
+# TYPE dbbackup_verification_status gauge
+dbbackup_verification_status{database="orders"} 0
+`
+	if err := testutil.CollectAndCompare(m.verificationStatus, strings.NewReader(verification)); err != nil {
+		t.Fatal(err)
+	}
+	lag := `
+# HELP dbbackup_scheduler_lag_seconds Delay between a schedule's planned and actual start.
+# TYPE dbbackup_scheduler_lag_seconds gauge
+dbbackup_scheduler_lag_seconds{schedule="hourly"} 0
+dbbackup_scheduler_lag_seconds{schedule="nightly"} 90
+`
+	if err := testutil.CollectAndCompare(m.schedulerLag, strings.NewReader(lag)); err != nil {
+		t.Fatal(err)
+	}
+
+	m.RecordVerification("orders", true, at)
+	if v := testutil.ToFloat64(m.verificationStatus.WithLabelValues("orders")); v != 1 {
+		t.Errorf("verification status %v after a pass", v)
+	}
+}
+
+func TestWatchQueue(t *testing.T) {
+	m := New()
+	var depthErr error
+	if err := m.WatchQueue(func() (int, int, error) { return 3, 1, depthErr }); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+# HELP dbbackup_notification_queue_depth Notification deliveries waiting in the queue, by state.
+# TYPE dbbackup_notification_queue_depth gauge
+dbbackup_notification_queue_depth{state="dead"} 1
+dbbackup_notification_queue_depth{state="pending"} 3
+`
+	if err := testutil.GatherAndCompare(m.Registry(), strings.NewReader(want), "dbbackup_notification_queue_depth"); err != nil {
+		t.Fatal(err)
+	}
+
+	depthErr = errors.New("queue locked")
+	if _, err := m.Registry().Gather(); err == nil || !strings.Contains(err.Error(), "queue locked") {
+		t.Errorf("gather error = %v", err)
+	}
+}
+
+func TestWatchRecovery(t *testing.T) {
+	m := New()
+	err := m.WatchRecovery(func() ([]RecoveryStatus, error) {
+		return []RecoveryStatus{
+			{Database: "orders", HasRecoveryPoint: true, PointAge: time.Hour, RPO: 30 * time.Minute, RPOBreached: true,
+				TimeEstimate: 10 * time.Minute, RTO: time.Hour},
+			// No backup yet and no objectives: nothing to export
+			{Database: "users"},
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+# HELP dbbackup_recovery_point_age_seconds Time since the newest usable backup.
+# TYPE dbbackup_recovery_point_age_seconds gauge
+dbbackup_recovery_point_age_seconds{database="orders"} 3600
+# HELP dbbackup_rpo_breached Whether the recovery point objective is breached (1) or met (0).
+# TYPE dbbackup_rpo_breached gauge
+dbbackup_rpo_breached{database="orders"} 1
+# HELP dbbackup_rpo_objective_seconds Configured recovery point objective.
+# TYPE dbbackup_rpo_objective_seconds gauge
+dbbackup_rpo_objective_seconds{database="orders"} 1800
+# HELP dbbackup_rto_breached Whether the recovery time objective is breached (1) or met (0).
+# TYPE dbbackup_rto_breached gauge
+dbbackup_rto_breached{database="orders"} 0
+# HELP dbbackup_rto_estimate_seconds Estimated restore time from recent restore rehearsals.
+# TYPE dbbackup_rto_estimate_seconds gauge
+dbbackup_rto_estimate_seconds{database="orders"} 600
+# HELP dbbackup_rto_objective_seconds Configured recovery time objective.
+# TYPE dbbackup_rto_objective_seconds gauge
+dbbackup_rto_objective_seconds{database="orders"} 3600
+`
+	if err := testutil.GatherAndCompare(m.Registry(), strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
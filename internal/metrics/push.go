@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Push sends the db-backup metrics to a Pushgateway. It uses POST
+// semantics, so only metric families present in this run replace those
+// already stored under the grouping key: a failed run updates the failure
+// metrics but keeps the last success timestamp pushed by an earlier run.
+// Runtime metrics are never pushed.
+//
+// A grouping label the metrics carry with the same value, such as the
+// database, is dropped from the pushed series: the Pushgateway rejects the
+// duplicate and adds the grouping labels back itself.
+func (m *Metrics) Push(ctx context.Context, cfg config.PushgatewayConfig, grouping map[string]string) error {
+	if cfg.URL == "" {
+		return fmt.Errorf("pushgateway url is not configured")
+	}
+	job := cfg.Job
+	if job == "" {
+		job = "db-backup"
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	labels := make(map[string]string, len(cfg.Grouping)+len(grouping))
+	for k, v := range cfg.Grouping {
+		labels[k] = v
+	}
+	for k, v := range grouping {
+		labels[k] = v
+	}
+
+	pusher := push.New(cfg.URL, job).
+		Gatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			mfs, err := m.registry.Gather()
+			if err != nil {
+				return nil, err
+			}
+			for _, mf := range mfs {
+				for _, metric := range mf.Metric {
+					metric.Label = withoutGrouping(metric.Label, labels)
+				}
+			}
+			return mfs, nil
+		})).
+		Client(&http.Client{Timeout: timeout})
+	for k, v := range labels {
+		pusher = pusher.Grouping(k, v)
+	}
+	if cfg.Username != "" {
+		pusher = pusher.BasicAuth(cfg.Username, cfg.Password)
+	}
+
+	if err := pusher.AddContext(ctx); err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	return nil
+}
+
+// withoutGrouping drops the labels that repeat a grouping label. A label
+// with a different value is kept, so the push fails rather than relabel it.
+func withoutGrouping(pairs []*dto.LabelPair, grouping map[string]string) []*dto.LabelPair {
+	kept := pairs[:0]
+	for _, p := range pairs {
+		if v, ok := grouping[p.GetName()]; ok && v == p.GetValue() {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
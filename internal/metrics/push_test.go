@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func TestPush(t *testing.T) {
+	type request struct {
+		method, path, user, pass string
+		families                 map[string]*dto.MetricFamily
+	}
+	received := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		req := request{method: r.Method, path: r.URL.EscapedPath(), user: user, pass: pass, families: map[string]*dto.MetricFamily{}}
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			mf := &dto.MetricFamily{}
+			if err := dec.Decode(mf); err != nil {
+				break
+			}
+			req.families[mf.GetName()] = mf
+		}
+		received <- req
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	m := New()
+	m.RecordBackup(BackupResult{Database: "orders", Type: "postgres", Duration: time.Second, FinishedAt: time.Unix(1700000000, 0)})
+	cfg := config.PushgatewayConfig{
+		URL: srv.URL, Grouping: map[string]string{"instance": "host-1"}, Username: "push", Password: "pw",
+	}
+	if err := m.Push(context.Background(), cfg, map[string]string{"database": "orders"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-received
+	if got.method != http.MethodPost || got.user != "push" || got.pass != "pw" {
+		t.Errorf("%s as %s:%s, want POST with basic auth", got.method, got.user, got.pass)
+	}
+	// The grouping labels follow the job in no particular order
+	parts := strings.Split(strings.TrimPrefix(got.path, "/metrics/"), "/")
+	if len(parts) != 6 || parts[0] != "job" || parts[1] != "db-backup" {
+		t.Fatalf("pushed to %s", got.path)
+	}
+	grouping := map[string]string{}
+	for i := 2; i < len(parts); i += 2 {
+		grouping[parts[i]] = parts[i+1]
+	}
+	if want := map[string]string{"instance": "host-1", "database": "orders"}; !reflect.DeepEqual(grouping, want) {
+		t.Errorf("grouping %v, want %v", grouping, want)
+	}
+
+	// Only the families of this run, never the runtime metrics
+	var names []string
+	for name := range got.families {
+		names = append(names, name)
+		if strings.HasPrefix(name, "go_") || strings.HasPrefix(name, "process_") {
+			t.Errorf("pushed runtime metric %s", name)
+		}
+	}
+	if _, ok := got.families["dbbackup_last_success_timestamp_seconds"]; ok {
+		t.Error("a failed run pushed a last success timestamp")
+	}
+	total, ok := got.families["dbbackup_backups_total"]
+	if !ok {
+		t.Fatalf("pushed %v", names)
+	}
+	metric := total.GetMetric()[0]
+	labels := map[string]string{}
+	for _, l := range metric.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	// The database comes back from the grouping key
+	if want := map[string]string{"type": "postgres", "status": "failure"}; !reflect.DeepEqual(labels, want) || metric.GetCounter().GetValue() != 1 {
+		t.Errorf("backups_total %v = %v", labels, metric.GetCounter().GetValue())
+	}
+	if ts := got.families["dbbackup_last_failure_timestamp_seconds"].GetMetric()[0].GetGauge().GetValue(); ts != 1700000000 {
+		t.Errorf("last failure %v", ts)
+	}
+}
+
+func TestPushErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "pushed metrics are invalid", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	m := New()
+	m.RecordBackup(BackupResult{Database: "orders", Type: "postgres", Success: true})
+	err := m.Push(context.Background(), config.PushgatewayConfig{URL: srv.URL, Job: "nightly"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("error = %v", err)
+	}
+	// A grouping label must not relabel the metrics
+	err = m.Push(context.Background(), config.PushgatewayConfig{URL: srv.URL}, map[string]string{"database": "users"})
+	if err == nil || !strings.Contains(err.Error(), "already contains grouping label database") {
+		t.Errorf("error = %v", err)
+	}
+	if err := m.Push(context.Background(), config.PushgatewayConfig{}, nil); err == nil {
+		t.Error("pushed without a url")
+	}
+}
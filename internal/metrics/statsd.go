@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// StatsD flavors
+const (
+	FlavorDogStatsD = "dogstatsd"
+	FlavorStatsD    = "statsd"
+)
+
+// defaultMaxPacketSize keeps datagrams below a typical Ethernet MTU
+const defaultMaxPacketSize = 1432
+
+var (
+	// statsdUnsafe matches characters that are not allowed in metric names
+	statsdUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.\-]`)
+	// tagEscaper removes DogStatsD tag separators from tag values
+	tagEscaper = strings.NewReplacer(",", "_", "|", "_")
+)
+
+var (
+	_ Recorder = (*StatsD)(nil)
+	_ Recorder = (*Metrics)(nil)
+)
+
+// StatsD emits metrics over UDP to a StatsD or DogStatsD agent. Lines are
+// buffered and sent in packets of at most max_packet_size bytes; call
+// Close to flush.
+type StatsD struct {
+	config config.StatsDConfig
+	conn   net.Conn
+	mu     sync.Mutex
+	buf    bytes.Buffer
+}
+
+// NewStatsD creates a StatsD emitter. UDP is connectionless, so an
+// unreachable agent only shows up as dropped packets.
+func NewStatsD(cfg config.StatsDConfig) (*StatsD, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("statsd address is not configured")
+	}
+	if cfg.Flavor == "" {
+		cfg.Flavor = FlavorDogStatsD
+	}
+	if cfg.MaxPacketSize <= 0 {
+		cfg.MaxPacketSize = defaultMaxPacketSize
+	}
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to statsd at %s: %w", cfg.Address, err)
+	}
+	return &StatsD{config: cfg, conn: conn}, nil
+}
+
+// RecordBackup records the outcome of a backup run
+func (s *StatsD) RecordBackup(r BackupResult) {
+	if r.FinishedAt.IsZero() {
+		r.FinishedAt = time.Now()
+	}
+	status := StatusFailure
+	if r.Success {
+		status = StatusSuccess
+	}
+	tags := []string{"database:" + r.Database, "type:" + r.Type}
+	withStatus := append(append([]string(nil), tags...), "status:"+status)
+
+	s.send("backup.runs", "1", "c", withStatus)
+	s.send("backup.duration", strconv.FormatInt(r.Duration.Milliseconds(), 10), "ms", withStatus)
+	if !r.Success {
+		s.send("backup.last_failure", strconv.FormatInt(r.FinishedAt.Unix(), 10), "g", tags)
+		s.send("backup.last_status", "0", "g", tags)
+		return
+	}
+	s.send("backup.last_success", strconv.FormatInt(r.FinishedAt.Unix(), 10), "g", tags)
+	s.send("backup.last_status", "1", "g", tags)
+	s.send("backup.size", strconv.FormatInt(r.Size, 10), "g", tags)
+	if r.CompressedSize > 0 {
+		s.send("backup.compressed_size", strconv.FormatInt(r.CompressedSize, 10), "g", tags)
+	}
+}
+
+// RecordVerification records the result of verifying a backup
+func (s *StatsD) RecordVerification(database string, ok bool, at time.Time) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	status := "0"
+	if ok {
+		status = "1"
+	}
+	tags := []string{"database:" + database}
+	s.send("verification.status", status, "g", tags)
+	s.send("verification.last_run", strconv.FormatInt(at.Unix(), 10), "g", tags)
+}
+
+// RecordScheduleRun records that a schedule planned for the given time
+// started now
+func (s *StatsD) RecordScheduleRun(schedule string, planned, started time.Time) {
+	lag := started.Sub(planned)
+	if lag < 0 {
+		lag = 0
+	}
+	s.send("scheduler.lag", strconv.FormatInt(lag.Milliseconds(), 10), "ms", []string{"schedule:" + schedule})
+}
+
+// Close flushes buffered metrics and closes the socket
+func (s *StatsD) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.flushLocked()
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// send buffers one metric line, flushing first if it would overflow the
+// packet
+func (s *StatsD) send(name, value, kind string, tags []string) {
+	line := s.format(name, value, kind, tags)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buf.Len() > 0 && s.buf.Len()+1+len(line) > s.config.MaxPacketSize {
+		_ = s.flushLocked()
+	}
+	if s.buf.Len() > 0 {
+		s.buf.WriteByte('\n')
+	}
+	s.buf.WriteString(line)
+}
+
+func (s *StatsD) flushLocked() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(s.buf.Bytes())
+	s.buf.Reset()
+	if err != nil {
+		return fmt.Errorf("failed to send statsd packet: %w", err)
+	}
+	return nil
+}
+
+// format renders a line. DogStatsD gets "name:value|type|#k:v,..."; plain
+// StatsD has no tags, so their values are appended to the name instead.
+func (s *StatsD) format(name, value, kind string, tags []string) string {
+	parts := []string{}
+	if s.config.Prefix != "" {
+		parts = append(parts, s.config.Prefix)
+	}
+	parts = append(parts, name)
+
+	if s.config.Flavor == FlavorStatsD {
+		for _, tag := range tags {
+			if _, v, ok := strings.Cut(tag, ":"); ok && v != "" {
+				parts = append(parts, statsdUnsafe.ReplaceAllString(v, "_"))
+			}
+		}
+		return fmt.Sprintf("%s:%s|%s", strings.Join(parts, "."), value, kind)
+	}
+
+	line := fmt.Sprintf("%s:%s|%s", strings.Join(parts, "."), value, kind)
+	all := append(append([]string(nil), s.config.Tags...), tags...)
+	for i, tag := range all {
+		all[i] = tagEscaper.Replace(tag)
+	}
+	if len(all) > 0 {
+		line += "|#" + strings.Join(all, ",")
+	}
+	return line
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func TestStatsDFormat(t *testing.T) {
+	tests := []struct {
+		flavor string
+		want   []string
+	}{
+		{FlavorDogStatsD, []string{
+			"dbbackup.backup.runs:1|c|#env:prod,database:orders,type:postgres,status:success",
+			"dbbackup.backup.duration:3000|ms|#env:prod,database:orders,type:postgres,status:success",
+			"dbbackup.backup.size:100|g|#env:prod,database:orders,type:postgres",
+		}},
+		{FlavorStatsD, []string{
+			"dbbackup.backup.runs.orders.postgres.success:1|c",
+			"dbbackup.backup.duration.orders.postgres.success:3000|ms",
+			"dbbackup.backup.size.orders.postgres:100|g",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+
+			s, err := NewStatsD(config.StatsDConfig{
+				Address: pc.LocalAddr().String(),
+				Prefix:  "dbbackup",
+				Flavor:  tt.flavor,
+				Tags:    []string{"env:prod"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.RecordBackup(BackupResult{Database: "orders", Type: "postgres", Success: true, Duration: 3 * time.Second, Size: 100})
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			buf := make([]byte, 2048)
+			_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(string(buf[:n]), "\n")
+			for _, want := range tt.want {
+				found := false
+				for _, line := range lines {
+					if line == want {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("missing %q in packet:\n%s", want, buf[:n])
+				}
+			}
+		})
+	}
+}
+
+func TestStatsDSplitsPackets(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := NewStatsD(config.StatsDConfig{Address: pc.LocalAddr().String(), MaxPacketSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RecordBackup(BackupResult{Database: "orders", Type: "postgres", Success: true, Size: 1})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	packets := 0
+	for {
+		_ = pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		packets++
+		if n > 64 && strings.Contains(string(buf[:n]), "\n") {
+			t.Errorf("packet with several lines exceeds the limit: %q", buf[:n])
+		}
+	}
+	if packets < 2 {
+		t.Errorf("expected metrics split over several packets, got %d", packets)
+	}
+}
@@ -0,0 +1,456 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/filelock"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// DigestNotifier batches notifications into a spool file and emails a
+// single summary on a daily or weekly schedule. Send only records the
+// notification; Flush delivers the digest when it is due. The spool is
+// locked while it is read and written, so CLI runs and the API server
+// recording into it at once lose no entries.
+type DigestNotifier struct {
+	config config.DigestConfig
+	email  *EmailNotifier
+	mu     sync.Mutex
+}
+
+// digestState is the on-disk spool
+type digestState struct {
+	LastSent      time.Time       `json:"last_sent"`
+	PreviousBytes int64           `json:"previous_bytes"`
+	Entries       []*Notification `json:"entries"`
+	Expirations   []Expiration    `json:"expirations,omitempty"`
+}
+
+// Expiration tracks a backup's retention deadline across digests
+type Expiration struct {
+	BackupID  string    `json:"backup_id"`
+	Database  string    `json:"database"`
+	Size      int64     `json:"size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DatabaseSummary aggregates the digest period for one database
+type DatabaseSummary struct {
+	Database     string `json:"database"`
+	DatabaseType string `json:"database_type,omitempty"`
+	Backups      int    `json:"backups"`
+	Failures     int    `json:"failures"`
+	Bytes        int64  `json:"bytes"`
+}
+
+// DigestReport is the summary of every event recorded in a digest period
+type DigestReport struct {
+	From          time.Time         `json:"from"`
+	To            time.Time         `json:"to"`
+	Total         int               `json:"total"`
+	Succeeded     int               `json:"succeeded"`
+	Failed        int               `json:"failed"`
+	Warnings      int               `json:"warnings"`
+	TotalBytes    int64             `json:"total_bytes"`
+	PreviousBytes int64             `json:"previous_bytes"`
+	Databases     []DatabaseSummary `json:"databases"`
+	Failures      []*Notification   `json:"failures,omitempty"`
+	Expiring      []Expiration      `json:"expiring,omitempty"`
+}
+
+// Growth returns the change in backed-up bytes relative to the previous digest
+func (r *DigestReport) Growth() int64 {
+	return r.TotalBytes - r.PreviousBytes
+}
+
+// NewDigestNotifier creates a digest notifier delivering through the email channel
+func NewDigestNotifier(cfg config.EmailConfig) *DigestNotifier {
+	return &DigestNotifier{config: cfg.Digest, email: NewEmailNotifier(cfg)}
+}
+
+// Name returns the channel name. The digest replaces the email channel, so
+// it shares its name for routing and queueing.
+func (d *DigestNotifier) Name() string {
+	return "email"
+}
+
+// Send records the notification for the next digest
+func (d *DigestNotifier) Send(ctx context.Context, n *Notification) error {
+	unlock, err := d.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := d.load()
+	if err != nil {
+		return err
+	}
+	if state.LastSent.IsZero() {
+		// The first period starts with the first recorded event
+		state.LastSent = n.timestampOrNow()
+	}
+	state.Entries = append(state.Entries, n)
+	if n.ExpiresAt != nil && n.Event == EventSuccess {
+		state.Expirations = append(state.Expirations, Expiration{
+			BackupID:  n.BackupID,
+			Database:  n.Database,
+			Size:      n.Size,
+			ExpiresAt: *n.ExpiresAt,
+		})
+	}
+	return d.save(state)
+}
+
+// Due reports whether a scheduled digest slot has passed since the last digest
+func (d *DigestNotifier) Due(now time.Time) (bool, error) {
+	unlock, err := d.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	state, err := d.load()
+	if err != nil {
+		return false, err
+	}
+	return d.due(state, now)
+}
+
+func (d *DigestNotifier) due(state *digestState, now time.Time) (bool, error) {
+	if state.LastSent.IsZero() {
+		return false, nil
+	}
+	slot, err := lastSlot(d.config, now)
+	if err != nil {
+		return false, err
+	}
+	return state.LastSent.Before(slot), nil
+}
+
+// Preview builds the report for the pending period without sending it
+func (d *DigestNotifier) Preview(now time.Time) (*DigestReport, error) {
+	unlock, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	state, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	return buildDigestReport(state, now, d.config.ExpirationWindow), nil
+}
+
+// Flush emails the digest if it is due, or unconditionally when force is
+// set, and starts a new period. It returns nil when nothing was sent.
+func (d *DigestNotifier) Flush(ctx context.Context, now time.Time, force bool) (*DigestReport, error) {
+	// Held while the digest is sent, so two processes never both send it
+	unlock, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	state, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	if !force {
+		due, err := d.due(state, now)
+		if err != nil || !due {
+			return nil, err
+		}
+	}
+	report := buildDigestReport(state, now, d.config.ExpirationWindow)
+
+	subject := d.config.Subject
+	if subject == "" {
+		subject = fmt.Sprintf("[db-backup] %s digest: %d succeeded, %d failed",
+			digestPeriodName(d.config.Frequency), report.Succeeded, report.Failed)
+	}
+	if err := d.email.sendHTML(ctx, subject, report.HTML()); err != nil {
+		return nil, err
+	}
+
+	// Keep the spool only for what is still relevant to future digests
+	kept := state.Expirations[:0]
+	for _, e := range state.Expirations {
+		if e.ExpiresAt.After(now) {
+			kept = append(kept, e)
+		}
+	}
+	state.Expirations = kept
+	state.Entries = nil
+	state.LastSent = now
+	state.PreviousBytes = report.TotalBytes
+
+	if err := d.save(state); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// buildDigestReport summarises the spooled entries up to now
+func buildDigestReport(state *digestState, now time.Time, window time.Duration) *DigestReport {
+	report := &DigestReport{
+		From:          state.LastSent,
+		To:            now,
+		PreviousBytes: state.PreviousBytes,
+	}
+	if report.From.IsZero() {
+		report.From = now
+	}
+
+	byDB := make(map[string]*DatabaseSummary)
+	for _, n := range state.Entries {
+		report.Total++
+		s, ok := byDB[n.Database]
+		if !ok {
+			s = &DatabaseSummary{Database: n.Database, DatabaseType: n.DatabaseType}
+			byDB[n.Database] = s
+		}
+
+		switch n.Event {
+		case EventSuccess:
+			report.Succeeded++
+			report.TotalBytes += n.Size
+			s.Backups++
+			s.Bytes += n.Size
+		case EventFailure:
+			report.Failed++
+			s.Failures++
+			report.Failures = append(report.Failures, n)
+		case EventWarning:
+			report.Warnings++
+		}
+	}
+
+	for _, s := range byDB {
+		report.Databases = append(report.Databases, *s)
+	}
+	sort.Slice(report.Databases, func(i, j int) bool {
+		return report.Databases[i].Database < report.Databases[j].Database
+	})
+
+	for _, e := range state.Expirations {
+		if e.ExpiresAt.After(now) && !e.ExpiresAt.After(now.Add(window)) {
+			report.Expiring = append(report.Expiring, e)
+		}
+	}
+	sort.Slice(report.Expiring, func(i, j int) bool {
+		return report.Expiring[i].ExpiresAt.Before(report.Expiring[j].ExpiresAt)
+	})
+
+	return report
+}
+
+// HTML renders the report as an HTML email body
+func (r *DigestReport) HTML() string {
+	var b strings.Builder
+	b.WriteString(`<html><body style="font-family:sans-serif">`)
+	fmt.Fprintf(&b, "<h2>Backup digest</h2><p>%s &ndash; %s</p>",
+		r.From.Format("2006-01-02 15:04"), r.To.Format("2006-01-02 15:04"))
+
+	if r.Total == 0 {
+		b.WriteString("<p><b>No backup events were recorded in this period.</b></p>")
+	}
+
+	b.WriteString(`<table cellpadding="4" style="border-collapse:collapse">`)
+	digestRow(&b, "Events", fmt.Sprintf("%d", r.Total))
+	digestRow(&b, "Succeeded", fmt.Sprintf("%s %d", eventEmoji(EventSuccess), r.Succeeded))
+	digestRow(&b, "Failed", fmt.Sprintf("%s %d", eventEmoji(EventFailure), r.Failed))
+	if r.Warnings > 0 {
+		digestRow(&b, "Warnings", fmt.Sprintf("%s %d", eventEmoji(EventWarning), r.Warnings))
+	}
+	digestRow(&b, "Data backed up", utils.FormatBytes(r.TotalBytes))
+	digestRow(&b, "Storage growth", formatGrowth(r.Growth(), r.PreviousBytes))
+	b.WriteString("</table>")
+
+	if len(r.Databases) > 0 {
+		b.WriteString(`<h3>Databases</h3><table cellpadding="4" border="1" style="border-collapse:collapse">`)
+		b.WriteString("<tr><th>Database</th><th>Type</th><th>Backups</th><th>Failures</th><th>Size</th></tr>")
+		for _, s := range r.Databases {
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td><td>%d</td><td>%d</td><td>%s</td></tr>",
+				html.EscapeString(s.Database), html.EscapeString(s.DatabaseType),
+				s.Backups, s.Failures, utils.FormatBytes(s.Bytes))
+		}
+		b.WriteString("</table>")
+	}
+
+	if len(r.Failures) > 0 {
+		b.WriteString("<h3>Failures</h3><ul>")
+		for _, n := range r.Failures {
+			fmt.Fprintf(&b, "<li><b>%s</b> %s &ndash; %s</li>",
+				html.EscapeString(n.Database), n.timestampOrNow().Format("2006-01-02 15:04"),
+				html.EscapeString(n.Message))
+		}
+		b.WriteString("</ul>")
+	}
+
+	if len(r.Expiring) > 0 {
+		b.WriteString("<h3>Upcoming expirations</h3><ul>")
+		for _, e := range r.Expiring {
+			fmt.Fprintf(&b, "<li><code>%s</code> (%s, %s) expires %s</li>",
+				html.EscapeString(e.BackupID), html.EscapeString(e.Database),
+				utils.FormatBytes(e.Size), e.ExpiresAt.Format("2006-01-02 15:04"))
+		}
+		b.WriteString("</ul>")
+	}
+
+	b.WriteString("</body></html>")
+	return b.String()
+}
+
+func digestRow(b *strings.Builder, label, value string) {
+	fmt.Fprintf(b, "<tr><th align=\"left\">%s</th><td>%s</td></tr>", html.EscapeString(label), html.EscapeString(value))
+}
+
+// formatGrowth renders a signed byte delta with its percentage
+func formatGrowth(delta, previous int64) string {
+	sign := "+"
+	abs := delta
+	if delta < 0 {
+		sign = "-"
+		abs = -delta
+	}
+	if previous == 0 {
+		return sign + utils.FormatBytes(abs)
+	}
+	return fmt.Sprintf("%s%s (%s%.1f%%)", sign, utils.FormatBytes(abs), sign, float64(abs)/float64(previous)*100)
+}
+
+func digestPeriodName(frequency string) string {
+	if strings.EqualFold(frequency, "weekly") {
+		return "Weekly"
+	}
+	return "Daily"
+}
+
+// lastSlot returns the most recent scheduled send time at or before now
+func lastSlot(cfg config.DigestConfig, now time.Time) (time.Time, error) {
+	hour, minute, err := ParseSendAt(cfg.SendAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	slot := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+
+	if strings.EqualFold(cfg.Frequency, "weekly") {
+		weekday, err := ParseWeekday(cfg.Weekday)
+		if err != nil {
+			return time.Time{}, err
+		}
+		slot = slot.AddDate(0, 0, -((int(slot.Weekday()) - int(weekday) + 7) % 7))
+		if slot.After(now) {
+			slot = slot.AddDate(0, 0, -7)
+		}
+		return slot, nil
+	}
+
+	if slot.After(now) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+	return slot, nil
+}
+
+// ParseSendAt parses a digest send_at time in HH:MM format
+func ParseSendAt(s string) (hour, minute int, err error) {
+	if s == "" {
+		return 8, 0, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid send_at %q (use HH:MM)", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// ParseWeekday parses a weekday name such as "monday" or "mon"
+func ParseWeekday(s string) (time.Weekday, error) {
+	if s == "" {
+		return time.Monday, nil
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if strings.EqualFold(s, name) || strings.EqualFold(s, name[:3]) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+// lock takes the spool's lock, shared with every process using the spool,
+// and returns the function releasing it
+func (d *DigestNotifier) lock() (func() error, error) {
+	d.mu.Lock()
+	if d.config.SpoolFile == "" {
+		// Nothing to share; save reports the missing spool
+		return func() error { d.mu.Unlock(); return nil }, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(d.config.SpoolFile), 0750); err != nil {
+		d.mu.Unlock()
+		return nil, fmt.Errorf("failed to create digest spool directory: %w", err)
+	}
+	unlock, err := filelock.Lock(d.config.SpoolFile + ".lock")
+	if err != nil {
+		d.mu.Unlock()
+		return nil, err
+	}
+	return func() error {
+		defer d.mu.Unlock()
+		return unlock()
+	}, nil
+}
+
+func (d *DigestNotifier) load() (*digestState, error) {
+	state := &digestState{}
+	data, err := os.ReadFile(d.config.SpoolFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read digest spool: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse digest spool: %w", err)
+	}
+	return state, nil
+}
+
+// save writes the spool atomically so a crash never leaves it truncated
+func (d *DigestNotifier) save(state *digestState) error {
+	if d.config.SpoolFile == "" {
+		return fmt.Errorf("digest spool_file is not configured")
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal digest spool: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.config.SpoolFile), filepath.Base(d.config.SpoolFile)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write digest spool: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.config.SpoolFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write digest spool: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package notification
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func TestLastSlot(t *testing.T) {
+	// 2024-06-12 is a Wednesday
+	now := time.Date(2024, 6, 12, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		cfg  config.DigestConfig
+		want time.Time
+	}{
+		{"daily after send time", config.DigestConfig{Frequency: "daily", SendAt: "08:00"}, time.Date(2024, 6, 12, 8, 0, 0, 0, time.UTC)},
+		{"daily before send time", config.DigestConfig{Frequency: "daily", SendAt: "11:00"}, time.Date(2024, 6, 11, 11, 0, 0, 0, time.UTC)},
+		{"weekly earlier in week", config.DigestConfig{Frequency: "weekly", SendAt: "08:00", Weekday: "monday"}, time.Date(2024, 6, 10, 8, 0, 0, 0, time.UTC)},
+		{"weekly same day later", config.DigestConfig{Frequency: "weekly", SendAt: "12:00", Weekday: "wed"}, time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC)},
+		{"weekly later in week", config.DigestConfig{Frequency: "weekly", SendAt: "08:00", Weekday: "friday"}, time.Date(2024, 6, 7, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lastSlot(tt.cfg, now)
+			if err != nil {
+				t.Fatalf("lastSlot() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("lastSlot() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestReport(t *testing.T) {
+	now := time.Date(2024, 6, 12, 8, 0, 0, 0, time.UTC)
+	soon := now.Add(24 * time.Hour)
+	later := now.Add(30 * 24 * time.Hour)
+
+	d := NewDigestNotifier(config.EmailConfig{Digest: config.DigestConfig{
+		Frequency: "daily",
+		SendAt:    "08:00",
+		SpoolFile: filepath.Join(t.TempDir(), "digest.json"),
+	}})
+
+	entries := []*Notification{
+		{Event: EventSuccess, Database: "orders", Size: 1000, BackupID: "b1", ExpiresAt: &soon, Timestamp: now.Add(-20 * time.Hour)},
+		{Event: EventSuccess, Database: "users", Size: 500, BackupID: "b2", ExpiresAt: &later, Timestamp: now.Add(-10 * time.Hour)},
+		{Event: EventFailure, Database: "orders", Message: "connection refused", Timestamp: now.Add(-5 * time.Hour)},
+	}
+	for _, n := range entries {
+		if err := d.Send(context.Background(), n); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	due, err := d.Due(now.Add(-time.Hour))
+	if err != nil || due {
+		t.Errorf("Due() before slot = %v, %v; want false", due, err)
+	}
+	due, err = d.Due(now)
+	if err != nil || !due {
+		t.Errorf("Due() at slot = %v, %v; want true", due, err)
+	}
+
+	report, err := d.Preview(now)
+	if err != nil {
+		t.Fatalf("Preview() error = %v", err)
+	}
+	if report.Total != 3 || report.Succeeded != 2 || report.Failed != 1 {
+		t.Errorf("counts = %d/%d/%d, want 3/2/1", report.Total, report.Succeeded, report.Failed)
+	}
+	if report.TotalBytes != 1500 {
+		t.Errorf("TotalBytes = %d, want 1500", report.TotalBytes)
+	}
+	if len(report.Databases) != 2 || report.Databases[0].Database != "orders" || report.Databases[0].Failures != 1 {
+		t.Errorf("Databases = %+v", report.Databases)
+	}
+	if len(report.Expiring) != 0 {
+		t.Errorf("Expiring with zero window = %+v, want none", report.Expiring)
+	}
+
+	d.config.ExpirationWindow = 72 * time.Hour
+	report, _ = d.Preview(now)
+	if len(report.Expiring) != 1 || report.Expiring[0].BackupID != "b1" {
+		t.Errorf("Expiring = %+v, want only b1", report.Expiring)
+	}
+}
+
+func TestDigestSpoolSharedByProcesses(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.EmailConfig{Digest: config.DigestConfig{Frequency: "daily", SpoolFile: filepath.Join(dir, "digest.json")}}
+	// Two notifiers on one spool stand for a CLI run and the API server
+	notifiers := []*DigestNotifier{NewDigestNotifier(cfg), NewDigestNotifier(cfg)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, d := range notifiers {
+			wg.Add(1)
+			go func(d *DigestNotifier) {
+				defer wg.Done()
+				if err := d.Send(context.Background(), &Notification{Event: EventSuccess, Database: "orders"}); err != nil {
+					t.Error(err)
+				}
+			}(d)
+		}
+	}
+	wg.Wait()
+
+	report, err := notifiers[0].Preview(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 40 {
+		t.Errorf("spool holds %d entries, want 40", report.Total)
+	}
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		if name := f.Name(); name != "digest.json" && name != "digest.json.lock" {
+			t.Errorf("left %s behind", name)
+		}
+	}
+}
+
+func TestFormatGrowth(t *testing.T) {
+	tests := []struct {
+		delta, previous int64
+		want            string
+	}{
+		{1024, 0, "+1.00 KB"},
+		{512, 1024, "+512 B (+50.0%)"},
+		{-512, 1024, "-512 B (-50.0%)"},
+	}
+	for _, tt := range tests {
+		if got := formatGrowth(tt.delta, tt.previous); got != tt.want {
+			t.Errorf("formatGrowth(%d, %d) = %q, want %q", tt.delta, tt.previous, got, tt.want)
+		}
+	}
+}
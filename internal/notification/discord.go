@@ -0,0 +1,125 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Discord embed colors
+const (
+	discordColorSuccess = 0x2ECC71
+	discordColorFailure = 0xE74C3C
+	discordColorWarning = 0xF1C40F
+	discordColorInfo    = 0x3498DB
+
+	// Discord rejects embeds with more than 25 fields, or over these
+	// lengths in characters
+	discordMaxFields      = 25
+	discordMaxTitle       = 256
+	discordMaxDescription = 4096
+	discordMaxFieldName   = 256
+	discordMaxFieldValue  = 1024
+	discordMaxEmbed       = 6000
+)
+
+// DiscordNotifier posts notifications to a Discord webhook
+type DiscordNotifier struct {
+	config config.DiscordConfig
+	client *http.Client
+}
+
+// NewDiscordNotifier creates a new Discord notifier
+func NewDiscordNotifier(cfg config.DiscordConfig) *DiscordNotifier {
+	return &DiscordNotifier{config: cfg, client: newHTTPClient()}
+}
+
+type discordPayload struct {
+	Username  string         `json:"username,omitempty"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	Content   string         `json:"content,omitempty"`
+	Embeds    []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// Name returns the channel name
+func (d *DiscordNotifier) Name() string {
+	return "discord"
+}
+
+// Send posts the notification as a Discord embed
+func (d *DiscordNotifier) Send(ctx context.Context, n *Notification) error {
+	if d.config.WebhookURL == "" {
+		return fmt.Errorf("discord: webhook_url is not configured")
+	}
+
+	embed := discordEmbed{
+		Title:     truncateText(eventEmoji(n.Event)+" "+n.Title, discordMaxTitle),
+		Color:     discordColor(n.Event),
+		Timestamp: n.timestampOrNow().UTC().Format(time.RFC3339),
+	}
+	size := utf8.RuneCountInString(embed.Title)
+	for _, f := range n.summaryFields() {
+		if len(embed.Fields) == discordMaxFields {
+			break
+		}
+		field := discordField{Name: truncateText(f[0], discordMaxFieldName), Value: truncateText(f[1], discordMaxFieldValue), Inline: true}
+		fieldSize := utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+		// The whole embed has a limit too: fields that would go over it
+		// are left out, and the description gets what remains
+		if size+fieldSize > discordMaxEmbed {
+			break
+		}
+		size += fieldSize
+		embed.Fields = append(embed.Fields, field)
+	}
+	embed.Description = truncateText(n.Message, min(discordMaxDescription, discordMaxEmbed-size))
+
+	payload := discordPayload{
+		Username:  d.config.Username,
+		AvatarURL: d.config.AvatarURL,
+		Embeds:    []discordEmbed{embed},
+	}
+	if n.Event == EventFailure && d.config.MentionOnFailure != "" {
+		payload.Content = d.config.MentionOnFailure
+	}
+	if len(n.Mentions) > 0 {
+		payload.Content = strings.TrimSpace(payload.Content + " " + n.mentionText())
+	}
+
+	if err := postJSON(ctx, d.client, d.config.WebhookURL, payload, nil); err != nil {
+		return fmt.Errorf("discord: %w", err)
+	}
+	return nil
+}
+
+func discordColor(event EventType) int {
+	switch event {
+	case EventSuccess:
+		return discordColorSuccess
+	case EventFailure:
+		return discordColorFailure
+	case EventWarning:
+		return discordColorWarning
+	default:
+		return discordColorInfo
+	}
+}
@@ -0,0 +1,122 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// chatServer records the bodies posted to it and answers with status
+func chatServer(t *testing.T, status int) (*httptest.Server, *[]string) {
+	t.Helper()
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		bodies = append(bodies, r.URL.Path+" "+string(body))
+		w.WriteHeader(status)
+		io.WriteString(w, `{"ok":false,"description":"Bad Request: chat not found"}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &bodies
+}
+
+func TestDiscordPayload(t *testing.T) {
+	srv, bodies := chatServer(t, http.StatusNoContent)
+	d := NewDiscordNotifier(config.DiscordConfig{
+		WebhookURL: srv.URL + "/api/webhooks/1/token", Username: "db-backup", MentionOnFailure: "<@&42>",
+	})
+	n := &Notification{
+		Event: EventFailure, Title: "Backup of orders failed", Message: "pg_dump: connection refused",
+		Database: "orders", DatabaseType: "postgres", FailureCount: 3, Mentions: []string{"@oncall"},
+		Fields:    map[string]string{"host": "db-1"},
+		Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := d.Send(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+
+	want := discordPayload{
+		Username: "db-backup",
+		Content:  "<@&42> @oncall",
+		Embeds: []discordEmbed{{
+			Title:       "❌ Backup of orders failed",
+			Description: "pg_dump: connection refused",
+			Color:       discordColorFailure,
+			Fields: []discordField{
+				{Name: "Database", Value: "orders", Inline: true},
+				{Name: "Type", Value: "postgres", Inline: true},
+				{Name: "Consecutive failures", Value: "3", Inline: true},
+				{Name: "host", Value: "db-1", Inline: true},
+			},
+			Timestamp: "2026-01-02T03:04:05Z",
+		}},
+	}
+	var got discordPayload
+	if len(*bodies) != 1 || !strings.HasPrefix((*bodies)[0], "/api/webhooks/1/token ") {
+		t.Fatalf("posted %v", *bodies)
+	}
+	if err := json.Unmarshal([]byte(strings.SplitN((*bodies)[0], " ", 2)[1]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("payload\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDiscordTruncates(t *testing.T) {
+	srv, bodies := chatServer(t, http.StatusNoContent)
+	d := NewDiscordNotifier(config.DiscordConfig{WebhookURL: srv.URL})
+	fields := map[string]string{}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		fields[k] = strings.Repeat("v", 2000)
+	}
+	n := &Notification{Event: EventWarning, Title: strings.Repeat("t", 300), Message: strings.Repeat("é", 5000), Fields: fields}
+	if err := d.Send(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload discordPayload
+	if err := json.Unmarshal([]byte(strings.SplitN((*bodies)[0], " ", 2)[1]), &payload); err != nil {
+		t.Fatal(err)
+	}
+	embed := payload.Embeds[0]
+	size := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description)
+	if utf8.RuneCountInString(embed.Title) != discordMaxTitle || !strings.HasSuffix(embed.Title, "…") {
+		t.Errorf("title of %d characters", utf8.RuneCountInString(embed.Title))
+	}
+	if len(embed.Fields) != 5 {
+		t.Errorf("%d fields, the sixth does not fit", len(embed.Fields))
+	}
+	for _, f := range embed.Fields {
+		if utf8.RuneCountInString(f.Value) != discordMaxFieldValue {
+			t.Errorf("field %s of %d characters", f.Name, utf8.RuneCountInString(f.Value))
+		}
+		size += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+	if size > discordMaxEmbed || embed.Description == "" || !utf8.ValidString(embed.Description) {
+		t.Errorf("embed of %d characters, description of %d", size, utf8.RuneCountInString(embed.Description))
+	}
+}
+
+func TestDiscordErrors(t *testing.T) {
+	srv, _ := chatServer(t, http.StatusBadRequest)
+	err := NewDiscordNotifier(config.DiscordConfig{WebhookURL: srv.URL}).Send(context.Background(), &Notification{Event: EventSuccess, Title: "ok"})
+	if err == nil || !strings.Contains(err.Error(), "discord: unexpected status 400") {
+		t.Errorf("error = %v", err)
+	}
+	if err := NewDiscordNotifier(config.DiscordConfig{}).Send(context.Background(), &Notification{}); err == nil {
+		t.Error("sent without a webhook url")
+	}
+}
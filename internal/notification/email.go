@@ -0,0 +1,520 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/security/cryptopolicy"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// SMTP transport security modes
+const (
+	EmailTLSStartTLS = "starttls"
+	EmailTLSImplicit = "tls"
+	EmailTLSNone     = "none"
+)
+
+// SMTP authentication methods
+const (
+	EmailAuthPlain   = "plain"
+	EmailAuthLogin   = "login"
+	EmailAuthXOAuth2 = "xoauth2"
+	EmailAuthNone    = "none"
+)
+
+// EmailNotifier sends one HTML email per notification over SMTP
+type EmailNotifier struct {
+	config config.EmailConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewEmailNotifier creates a new email notifier
+func NewEmailNotifier(cfg config.EmailConfig) *EmailNotifier {
+	return &EmailNotifier{config: cfg, client: newHTTPClient()}
+}
+
+// Name returns the channel name
+func (e *EmailNotifier) Name() string {
+	return "email"
+}
+
+// Send emails the notification to every configured recipient, attaching
+// the files whose kind is listed in the attach setting
+func (e *EmailNotifier) Send(ctx context.Context, n *Notification) error {
+	subject := fmt.Sprintf("[db-backup] %s", n.Title)
+	if n.Severity != "" {
+		subject = fmt.Sprintf("[db-backup] [%s] %s", strings.ToUpper(n.Severity), n.Title)
+	}
+	return e.send(ctx, subject, formatEmailHTML(n), e.selectAttachments(n.Attachments))
+}
+
+// sendHTML delivers an HTML message without attachments
+func (e *EmailNotifier) sendHTML(ctx context.Context, subject, body string) error {
+	return e.send(ctx, subject, body, nil)
+}
+
+func (e *EmailNotifier) send(ctx context.Context, subject, body string, attachments []Attachment) error {
+	if e.config.SMTPHost == "" || e.config.From == "" || len(e.config.To) == 0 {
+		return fmt.Errorf("email: smtp_host, from and to are required")
+	}
+
+	msg, err := buildMessage(e.config.From, e.config.To, subject, body, attachments)
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+
+	auth, err := e.auth(ctx)
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+
+	// net/smtp has no context support; run it in the background so a
+	// cancelled context still returns promptly
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- e.deliver(ctx, auth, msg)
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("email: %w", ctx.Err())
+	}
+}
+
+// deliver runs one SMTP transaction. With implicit TLS the connection is
+// encrypted from the start (usually port 465); with STARTTLS it is upgraded
+// after EHLO and the upgrade is mandatory.
+func (e *EmailNotifier) deliver(ctx context.Context, auth smtp.Auth, msg []byte) error {
+	host := e.config.SMTPHost
+	addr := net.JoinHostPort(host, strconv.Itoa(e.config.SMTPPort))
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: e.config.InsecureSkipVerify, // #nosec G402 -- opt-in for self-signed relays
+		MinVersion:         tls.VersionTLS12,
+	}
+	cryptopolicy.HardenTLS(tlsConfig)
+
+	dialer := &net.Dialer{Timeout: defaultHTTPTimeout}
+	var conn net.Conn
+	var err error
+	mode := e.tlsMode()
+	if mode == EmailTLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Minute)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp handshake failed: %w", err)
+	}
+	defer c.Close()
+
+	if mode == EmailTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server %s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("server %s does not support authentication", addr)
+		}
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	if err := c.Mail(e.config.From); err != nil {
+		return err
+	}
+	for _, to := range e.config.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// tlsMode returns the configured mode, defaulting to implicit TLS on port
+// 465 and STARTTLS everywhere else
+func (e *EmailNotifier) tlsMode() string {
+	if e.config.TLSMode != "" {
+		return strings.ToLower(e.config.TLSMode)
+	}
+	if e.config.SMTPPort == 465 {
+		return EmailTLSImplicit
+	}
+	return EmailTLSStartTLS
+}
+
+// auth returns the SMTP authentication mechanism. Without an explicit
+// method, PLAIN is used when a username is set.
+func (e *EmailNotifier) auth(ctx context.Context) (smtp.Auth, error) {
+	method := strings.ToLower(e.config.AuthMethod)
+	if method == "" {
+		if e.config.Username == "" {
+			return nil, nil
+		}
+		method = EmailAuthPlain
+	}
+
+	switch method {
+	case EmailAuthNone:
+		return nil, nil
+	case EmailAuthPlain:
+		return smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.SMTPHost), nil
+	case EmailAuthLogin:
+		return &loginAuth{username: e.config.Username, password: e.config.Password}, nil
+	case EmailAuthXOAuth2:
+		token, err := e.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		user := e.config.Username
+		if user == "" {
+			user = e.config.From
+		}
+		return &xoauth2Auth{username: user, token: token}, nil
+	default:
+		return nil, fmt.Errorf("unsupported auth_method %q", e.config.AuthMethod)
+	}
+}
+
+// accessToken returns the configured static token, or exchanges the
+// refresh token for an access token and caches it until shortly before it
+// expires
+func (e *EmailNotifier) accessToken(ctx context.Context) (string, error) {
+	o := e.config.OAuth2
+	if o.RefreshToken == "" {
+		if o.AccessToken == "" {
+			return "", fmt.Errorf("xoauth2 requires oauth2.access_token or oauth2.refresh_token")
+		}
+		return o.AccessToken, nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.token != "" && time.Now().Before(e.tokenExpiry) {
+		return e.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", o.RefreshToken)
+	form.Set("client_id", o.ClientID)
+	if o.ClientSecret != "" {
+		form.Set("client_secret", o.ClientSecret)
+	}
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", fmt.Errorf("token response did not contain an access_token")
+	}
+
+	expiresIn := time.Duration(tok.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = time.Hour
+	}
+	e.token = tok.AccessToken
+	e.tokenExpiry = time.Now().Add(expiresIn - time.Minute)
+	return e.token, nil
+}
+
+// selectAttachments keeps the attachments whose kind is enabled, dropping
+// any that exceed max_attachment_size
+func (e *EmailNotifier) selectAttachments(attachments []Attachment) []Attachment {
+	if len(e.config.Attach) == 0 || len(attachments) == 0 {
+		return nil
+	}
+	var selected []Attachment
+	for _, a := range attachments {
+		if !utils.Contains(e.config.Attach, a.Kind) {
+			continue
+		}
+		if e.config.MaxAttachmentSize > 0 && a.size() > e.config.MaxAttachmentSize {
+			continue
+		}
+		selected = append(selected, a)
+	}
+	return selected
+}
+
+// size returns the attachment size, or 0 when the file cannot be read
+func (a Attachment) size() int64 {
+	if a.Data != nil {
+		return int64(len(a.Data))
+	}
+	info, err := os.Stat(a.Path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// content returns the attachment bytes
+func (a Attachment) content() ([]byte, error) {
+	if a.Data != nil || a.Path == "" {
+		return a.Data, nil
+	}
+	data, err := os.ReadFile(a.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment %s: %w", a.Path, err)
+	}
+	return data, nil
+}
+
+// loginAuth implements the LOGIN mechanism still required by some
+// Office 365 and Exchange relays
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+// xoauth2Auth implements the XOAUTH2 mechanism used by Gmail and Office 365
+type xoauth2Auth struct {
+	username, token string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// The server sent a JSON error; an empty reply completes the
+		// exchange so the real failure is reported
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// buildMessage assembles an RFC 5322 message with an HTML body. With
+// attachments the message becomes multipart/mixed. The body is
+// quoted-printable so digests with long rows stay within SMTP's 998-octet
+// line limit.
+func buildMessage(from string, to []string, subject, body string, attachments []Attachment) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(attachments) == 0 {
+		b.WriteString("Content-Type: text/html; charset=\"utf-8\"\r\n")
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+		b.WriteString("\r\n")
+		if err := writeQuotedPrintable(&b, body); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\r\n", mw.Boundary())
+	b.WriteString("\r\n")
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/html; charset="utf-8"`},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(part, body); err != nil {
+		return nil, err
+	}
+
+	for _, a := range attachments {
+		data, err := a.content()
+		if err != nil {
+			return nil, err
+		}
+		filename := a.Filename
+		if filename == "" {
+			filename = filepath.Base(a.Path)
+		}
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(filename))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64Lines(part, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeQuotedPrintable writes text quoted-printable per RFC 2045
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, text); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64Lines writes base64 wrapped at 76 characters per RFC 2045
+func writeBase64Lines(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := io.WriteString(w, encoded+"\r\n")
+	return err
+}
+
+// formatEmailHTML renders a single notification as an HTML email body
+func formatEmailHTML(n *Notification) string {
+	var b strings.Builder
+	b.WriteString(`<html><body style="font-family:sans-serif">`)
+	fmt.Fprintf(&b, "<h2>%s %s</h2>", eventEmoji(n.Event), html.EscapeString(n.Title))
+	if n.Message != "" {
+		fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(n.Message))
+	}
+
+	fields := n.summaryFields()
+	if n.Size > 0 {
+		fields = append(fields, [2]string{"Size", utils.FormatBytes(n.Size)})
+	}
+	if len(fields) > 0 {
+		b.WriteString(`<table cellpadding="4" style="border-collapse:collapse">`)
+		for _, f := range fields {
+			fmt.Fprintf(&b, "<tr><th align=\"left\">%s</th><td><code>%s</code></td></tr>",
+				html.EscapeString(f[0]), html.EscapeString(f[1]))
+		}
+		b.WriteString("</table>")
+	}
+	fmt.Fprintf(&b, "<p style=\"color:#888\">%s</p>", n.timestampOrNow().Format(time.RFC1123))
+	b.WriteString("</body></html>")
+	return b.String()
+}
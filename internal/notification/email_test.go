@@ -0,0 +1,140 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func TestSelectAttachments(t *testing.T) {
+	manifest := Attachment{Kind: AttachmentManifest, Filename: "m.json", Data: []byte(`{"id":"b1"}`)}
+	report := Attachment{Kind: AttachmentVerificationReport, Filename: "r.txt", Data: bytes.Repeat([]byte("x"), 100)}
+
+	tests := []struct {
+		name    string
+		attach  []string
+		maxSize int64
+		want    []string
+	}{
+		{"none enabled", nil, 0, nil},
+		{"manifest only", []string{AttachmentManifest}, 0, []string{"m.json"}},
+		{"both", []string{AttachmentManifest, AttachmentVerificationReport}, 0, []string{"m.json", "r.txt"}},
+		{"size limit", []string{AttachmentManifest, AttachmentVerificationReport}, 50, []string{"m.json"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEmailNotifier(config.EmailConfig{Attach: tt.attach, MaxAttachmentSize: tt.maxSize})
+			var got []string
+			for _, a := range e.selectAttachments([]Attachment{manifest, report}) {
+				got = append(got, a.Filename)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMessageLongBody(t *testing.T) {
+	// One table row per database, rendered on a single line, well past the
+	// 998-octet SMTP line limit
+	body := "<table>" + strings.Repeat("<tr><td>orders</td><td>1.2 GB</td><td>ok ✓</td></tr>", 100) + "</table>"
+
+	for _, attachments := range [][]Attachment{nil, {{Filename: "m.json", Data: []byte("{}")}}} {
+		raw, err := buildMessage("from@example.com", []string{"to@example.com"}, "Digest", body, attachments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(raw), "\r\n") {
+			if len(line) > 998 {
+				t.Fatalf("line of %d octets", len(line))
+			}
+		}
+
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		if attachments == nil {
+			if enc := msg.Header.Get("Content-Transfer-Encoding"); enc != "quoted-printable" {
+				t.Fatalf("Content-Transfer-Encoding = %q", enc)
+			}
+			got, err = io.ReadAll(quotedprintable.NewReader(msg.Body))
+		} else {
+			_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			// The reader decodes quoted-printable parts
+			part, perr := multipart.NewReader(msg.Body, params["boundary"]).NextPart()
+			if perr != nil {
+				t.Fatal(perr)
+			}
+			got, err = io.ReadAll(part)
+		}
+		if err != nil || string(got) != body {
+			t.Errorf("decoded body differs (attachments: %d, err: %v)", len(attachments), err)
+		}
+	}
+}
+
+func TestBuildMessageWithAttachment(t *testing.T) {
+	data := []byte(`{"id":"b1","database":"orders"}`)
+	raw, err := buildMessage("from@example.com", []string{"to@example.com"}, "Backup done", "<p>ok</p>",
+		[]Attachment{{Kind: AttachmentManifest, Filename: "b1-manifest.json", Data: data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type = %q, %v", mediaType, err)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	body, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html, _ := io.ReadAll(body); string(html) != "<p>ok</p>" {
+		t.Errorf("body = %q", html)
+	}
+
+	att, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.FileName() != "b1-manifest.json" {
+		t.Errorf("filename = %q", att.FileName())
+	}
+	if ct := att.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("attachment content type = %q", ct)
+	}
+	encoded, _ := io.ReadAll(att)
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("attachment = %q, %v", decoded, err)
+	}
+}
+
+func TestXOAuth2Auth(t *testing.T) {
+	a := &xoauth2Auth{username: "ops@example.com", token: "tok"}
+	mech, resp, err := a.Start(&smtp.ServerInfo{Name: "smtp.office365.com", TLS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mech != "XOAUTH2" || string(resp) != "user=ops@example.com\x01auth=Bearer tok\x01\x01" {
+		t.Errorf("got %s %q", mech, resp)
+	}
+}
@@ -0,0 +1,168 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/filelock"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Escalator counts consecutive failures per database and schedule and
+// escalates notifications once a configured threshold is reached. Counts
+// are persisted so they survive between CLI runs, and updated under a file
+// lock so concurrent runs and the scheduler never lose a failure.
+type Escalator struct {
+	config config.EscalationConfig
+	levels []config.EscalationLevel
+}
+
+// failureStreak is the persisted state for one database/schedule
+type failureStreak struct {
+	Count     int       `json:"count"`
+	Escalated bool      `json:"escalated"`
+	Channels  []string  `json:"channels,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+// Escalation describes how a notification was escalated
+type Escalation struct {
+	Level    int
+	Failures int
+	Channels []string
+	Severity string
+	Mentions []string
+}
+
+// NewEscalator creates an escalator. When several levels are reached, the
+// one with the highest threshold applies.
+func NewEscalator(cfg config.EscalationConfig) *Escalator {
+	levels := append([]config.EscalationLevel(nil), cfg.Levels...)
+	sort.Slice(levels, func(i, j int) bool { return levels[i].After < levels[j].After })
+	return &Escalator{config: cfg, levels: levels}
+}
+
+// Apply updates the failure streak for the notification's database and
+// schedule and, when a level is reached, sets its severity, mentions and
+// failure count. The returned escalation (nil when none applies) lists the
+// extra channels to notify. A success after an escalation is sent to the
+// escalated channels too, so incidents opened there get resolved.
+func (e *Escalator) Apply(n *Notification) (*Escalation, error) {
+	if e.config.StateFile == "" {
+		return nil, fmt.Errorf("escalation state_file is not configured")
+	}
+	if err := os.MkdirAll(filepath.Dir(e.config.StateFile), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create escalation state directory: %w", err)
+	}
+	unlock, err := filelock.Lock(e.config.StateFile + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	state, err := e.load()
+	if err != nil {
+		return nil, err
+	}
+	key := streakKey(n)
+
+	switch n.Event {
+	case EventSuccess:
+		streak, ok := state[key]
+		if !ok {
+			return nil, nil
+		}
+		delete(state, key)
+		if err := e.save(state); err != nil {
+			return nil, err
+		}
+		if !streak.Escalated {
+			return nil, nil
+		}
+		return &Escalation{Failures: streak.Count, Channels: streak.Channels}, nil
+
+	case EventFailure:
+		streak, ok := state[key]
+		if !ok {
+			streak = &failureStreak{Since: n.timestampOrNow()}
+			state[key] = streak
+		}
+		streak.Count++
+		n.FailureCount = streak.Count
+
+		var esc *Escalation
+		for i, level := range e.levels {
+			if level.After > 0 && streak.Count >= level.After {
+				esc = &Escalation{
+					Level:    i + 1,
+					Failures: streak.Count,
+					Channels: level.Channels,
+					Severity: level.Severity,
+					Mentions: level.Mentions,
+				}
+			}
+		}
+		if esc != nil {
+			streak.Escalated = true
+			for _, ch := range esc.Channels {
+				if !utils.Contains(streak.Channels, ch) {
+					streak.Channels = append(streak.Channels, ch)
+				}
+			}
+			n.Severity = esc.Severity
+			n.Mentions = esc.Mentions
+		}
+		if err := e.save(state); err != nil {
+			return nil, err
+		}
+		return esc, nil
+	}
+
+	return nil, nil
+}
+
+// streakKey identifies a failure streak
+func streakKey(n *Notification) string {
+	return n.Database + "|" + n.Schedule
+}
+
+func (e *Escalator) load() (map[string]*failureStreak, error) {
+	state := make(map[string]*failureStreak)
+	data, err := os.ReadFile(e.config.StateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read escalation state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse escalation state: %w", err)
+	}
+	return state, nil
+}
+
+// save writes the state atomically, through a temporary file of its own
+func (e *Escalator) save(state map[string]*failureStreak) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal escalation state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(e.config.StateFile), filepath.Base(e.config.StateFile)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write escalation state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write escalation state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write escalation state: %w", err)
+	}
+	return os.Rename(tmp.Name(), e.config.StateFile)
+}
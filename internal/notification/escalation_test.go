@@ -0,0 +1,150 @@
+package notification
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func newTestEscalator(t *testing.T) (*Escalator, string) {
+	t.Helper()
+	stateFile := filepath.Join(t.TempDir(), "state", "escalation.json")
+	return NewEscalator(config.EscalationConfig{
+		Enabled:   true,
+		StateFile: stateFile,
+		Levels: []config.EscalationLevel{
+			{After: 5, Channels: []string{"pagerduty"}, Severity: "critical", Mentions: []string{"@oncall"}},
+			{After: 3, Channels: []string{"slack-oncall"}, Severity: "error"},
+		},
+	}), stateFile
+}
+
+func failure(db string) *Notification {
+	return &Notification{Event: EventFailure, Database: db, Schedule: "nightly"}
+}
+
+func TestEscalatorStreak(t *testing.T) {
+	e, _ := newTestEscalator(t)
+
+	for i := 1; i <= 6; i++ {
+		n := failure("orders")
+		esc, err := e.Apply(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.FailureCount != i {
+			t.Fatalf("failure %d counted as %d", i, n.FailureCount)
+		}
+		switch {
+		case i < 3:
+			if esc != nil {
+				t.Fatalf("failure %d escalated: %+v", i, esc)
+			}
+		case i < 5:
+			if esc == nil || esc.Level != 1 || n.Severity != "error" || !reflect.DeepEqual(esc.Channels, []string{"slack-oncall"}) {
+				t.Fatalf("failure %d: escalation %+v, severity %q", i, esc, n.Severity)
+			}
+		default:
+			if esc == nil || esc.Level != 2 || n.Severity != "critical" || !reflect.DeepEqual(n.Mentions, []string{"@oncall"}) {
+				t.Fatalf("failure %d: escalation %+v, severity %q", i, esc, n.Severity)
+			}
+		}
+	}
+
+	// Other databases and schedules keep streaks of their own
+	other := &Notification{Event: EventFailure, Database: "orders", Schedule: "hourly"}
+	if esc, _ := e.Apply(other); esc != nil || other.FailureCount != 1 {
+		t.Fatalf("other schedule: escalation %+v, count %d", esc, other.FailureCount)
+	}
+}
+
+func TestEscalatorReset(t *testing.T) {
+	e, _ := newTestEscalator(t)
+
+	// A success without a streak goes nowhere extra
+	if esc, err := e.Apply(&Notification{Event: EventSuccess, Database: "orders", Schedule: "nightly"}); esc != nil || err != nil {
+		t.Fatalf("success without failures: %+v, %v", esc, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		e.Apply(failure("orders"))
+	}
+	esc, err := e.Apply(&Notification{Event: EventSuccess, Database: "orders", Schedule: "nightly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The recovery reaches every channel the incident was escalated to
+	if esc == nil || esc.Failures != 5 || !reflect.DeepEqual(esc.Channels, []string{"slack-oncall", "pagerduty"}) {
+		t.Fatalf("recovery = %+v", esc)
+	}
+
+	n := failure("orders")
+	if esc, _ := e.Apply(n); esc != nil || n.FailureCount != 1 {
+		t.Fatalf("streak not reset: escalation %+v, count %d", esc, n.FailureCount)
+	}
+
+	// A success before any escalation only resets the streak
+	if esc, _ := e.Apply(&Notification{Event: EventSuccess, Database: "orders", Schedule: "nightly"}); esc != nil {
+		t.Fatalf("success before escalation = %+v", esc)
+	}
+}
+
+func TestEscalatorPersists(t *testing.T) {
+	e, stateFile := newTestEscalator(t)
+	e.Apply(failure("orders"))
+	e.Apply(failure("orders"))
+
+	// Another run reads the streak back
+	again := NewEscalator(e.config)
+	n := failure("orders")
+	if esc, err := again.Apply(n); err != nil || esc == nil || n.FailureCount != 3 {
+		t.Fatalf("third failure in a new run: escalation %+v, count %d, %v", esc, n.FailureCount, err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(stateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, de := range entries {
+		if filepath.Ext(de.Name()) == ".tmp" {
+			t.Errorf("temporary file %s left behind", de.Name())
+		}
+	}
+
+	if _, err := NewEscalator(config.EscalationConfig{}).Apply(failure("orders")); err == nil {
+		t.Error("applied without a state file")
+	}
+}
+
+func TestEscalatorConcurrentRuns(t *testing.T) {
+	e, _ := newTestEscalator(t)
+	const runs = 100
+
+	// One escalator per run, as separate CLI processes have
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, err := NewEscalator(e.config).Apply(failure("orders")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	n := failure("orders")
+	if _, err := e.Apply(n); err != nil {
+		t.Fatal(err)
+	}
+	if n.FailureCount != runs+1 {
+		t.Fatalf("lost failures: count %d, want %d", n.FailureCount, runs+1)
+	}
+}
@@ -0,0 +1,140 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Manager fans notifications out to the enabled channels selected by the
+// routing rules
+type Manager struct {
+	notifiers []Notifier
+	digest    *DigestNotifier
+	queue     *Queue
+	router    *Router
+	escalator *Escalator
+}
+
+// NewManager builds the enabled channels from configuration. When the email
+// digest is enabled, email notifications are spooled for the digest instead
+// of being sent immediately.
+func NewManager(cfg config.NotificationConfig) *Manager {
+	m := &Manager{}
+
+	if cfg.Slack.Enabled {
+		m.notifiers = append(m.notifiers, WithFilter(NewSlackNotifier(cfg.Slack), cfg.Slack.NotifyOn))
+	}
+	if cfg.Email.Enabled {
+		if cfg.Email.Digest.Enabled {
+			m.digest = NewDigestNotifier(cfg.Email)
+			m.notifiers = append(m.notifiers, m.digest)
+		} else {
+			m.notifiers = append(m.notifiers, NewEmailNotifier(cfg.Email))
+		}
+	}
+	if cfg.Webhook.Enabled {
+		m.notifiers = append(m.notifiers, WithFilter(NewWebhookNotifier(cfg.Webhook), cfg.Webhook.NotifyOn))
+	}
+	if cfg.Discord.Enabled {
+		m.notifiers = append(m.notifiers, WithFilter(NewDiscordNotifier(cfg.Discord), cfg.Discord.NotifyOn))
+	}
+	if cfg.Telegram.Enabled {
+		m.notifiers = append(m.notifiers, WithFilter(NewTelegramNotifier(cfg.Telegram), cfg.Telegram.NotifyOn))
+	}
+	if cfg.PagerDuty.Enabled {
+		m.notifiers = append(m.notifiers, WithFilter(NewPagerDutyNotifier(cfg.PagerDuty), cfg.PagerDuty.NotifyOn))
+	}
+	if cfg.Routing.Enabled {
+		m.router = NewRouter(cfg.Routing)
+	}
+	if cfg.Escalation.Enabled {
+		m.escalator = NewEscalator(cfg.Escalation)
+	}
+
+	return m
+}
+
+// Notifiers returns the configured channels
+func (m *Manager) Notifiers() []Notifier {
+	return m.notifiers
+}
+
+// Digest returns the email digest channel, or nil when digests are disabled
+func (m *Manager) Digest() *DigestNotifier {
+	return m.digest
+}
+
+// SetQueue routes notifications through a persistent delivery queue
+// instead of sending them inline
+func (m *Manager) SetQueue(q *Queue) {
+	m.queue = q
+}
+
+// Queue returns the delivery queue, or nil when sending inline
+func (m *Manager) Queue() *Queue {
+	return m.queue
+}
+
+// Channels returns the names of the channels a notification is routed to
+func (m *Manager) Channels(n *Notification) []string {
+	var routed []string
+	if m.router != nil {
+		routed = m.router.Route(n)
+	}
+
+	var names []string
+	for _, notifier := range m.notifiers {
+		if routed == nil || utils.Contains(routed, notifier.Name()) {
+			names = append(names, notifier.Name())
+		}
+	}
+	return names
+}
+
+// Send delivers a notification to its routed and escalated channels,
+// returning the combined errors. With a queue configured the notification
+// is only enqueued.
+func (m *Manager) Send(ctx context.Context, n *Notification) error {
+	var errs []error
+	channels := m.Channels(n)
+
+	// Escalation adds channels on top of the routed ones
+	if m.escalator != nil {
+		esc, err := m.escalator.Apply(n)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("escalation: %w", err))
+		}
+		if esc != nil {
+			for _, notifier := range m.notifiers {
+				name := notifier.Name()
+				if utils.Contains(esc.Channels, name) && !utils.Contains(channels, name) {
+					channels = append(channels, name)
+				}
+			}
+		}
+	}
+	if len(channels) == 0 {
+		return errors.Join(errs...)
+	}
+
+	if m.queue != nil {
+		if err := m.queue.Enqueue(ctx, n, channels...); err != nil {
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+
+	for _, notifier := range m.notifiers {
+		if !utils.Contains(channels, notifier.Name()) {
+			continue
+		}
+		if err := notifier.Send(ctx, n); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", notifier.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,216 @@
+// Package notification delivers backup and restore events to chat, email
+// and webhook channels
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// EventType represents the kind of event being notified
+type EventType string
+
+const (
+	EventSuccess EventType = "success"
+	EventFailure EventType = "failure"
+	EventWarning EventType = "warning"
+)
+
+// defaultHTTPTimeout bounds every outbound notification request
+const defaultHTTPTimeout = 15 * time.Second
+
+// Notification is a single message delivered to one or more channels
+type Notification struct {
+	Event        EventType         `json:"event"`
+	Title        string            `json:"title"`
+	Message      string            `json:"message"`
+	Database     string            `json:"database,omitempty"`
+	DatabaseType string            `json:"database_type,omitempty"`
+	BackupID     string            `json:"backup_id,omitempty"`
+	Schedule     string            `json:"schedule,omitempty"`
+	Severity     string            `json:"severity,omitempty"`
+	Mentions     []string          `json:"mentions,omitempty"`
+	FailureCount int               `json:"failure_count,omitempty"`
+	Size         int64             `json:"size,omitempty"`
+	ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Fields       map[string]string `json:"fields,omitempty"`
+	Attachments  []Attachment      `json:"attachments,omitempty"`
+	Timestamp    time.Time         `json:"timestamp"`
+}
+
+// Attachment kinds that channels can opt in to
+const (
+	AttachmentVerificationReport = "verification_report"
+	AttachmentManifest           = "manifest"
+)
+
+// Attachment is a file offered alongside a notification. Content is either
+// inline or read from Path at delivery time. Only channels that support
+// attachments (email) use them.
+type Attachment struct {
+	Kind        string `json:"kind"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+// Notifier is implemented by every notification channel
+type Notifier interface {
+	// Name returns the channel name (e.g. "slack", "discord")
+	Name() string
+	// Send delivers a notification
+	Send(ctx context.Context, n *Notification) error
+}
+
+// ShouldNotify reports whether an event passes a notify_on filter. An empty
+// filter accepts every event.
+func ShouldNotify(notifyOn []string, event EventType) bool {
+	if len(notifyOn) == 0 {
+		return true
+	}
+	for _, e := range notifyOn {
+		if strings.EqualFold(e, string(event)) || e == "*" || strings.EqualFold(e, "all") {
+			return true
+		}
+	}
+	return false
+}
+
+// filtered wraps a notifier with notify_on event filtering
+type filtered struct {
+	Notifier
+	notifyOn []string
+}
+
+// WithFilter returns a notifier that silently drops events not listed in notifyOn
+func WithFilter(n Notifier, notifyOn []string) Notifier {
+	return &filtered{Notifier: n, notifyOn: notifyOn}
+}
+
+// Send delivers the notification if its event passes the filter
+func (f *filtered) Send(ctx context.Context, n *Notification) error {
+	if !ShouldNotify(f.notifyOn, n.Event) {
+		return nil
+	}
+	return f.Notifier.Send(ctx, n)
+}
+
+// eventEmoji returns a short marker used by chat channels
+func eventEmoji(event EventType) string {
+	switch event {
+	case EventSuccess:
+		return "✅"
+	case EventFailure:
+		return "❌"
+	case EventWarning:
+		return "⚠️"
+	default:
+		return "ℹ️"
+	}
+}
+
+// mentionText returns the escalation mentions as a single line
+func (n *Notification) mentionText() string {
+	return strings.Join(n.Mentions, " ")
+}
+
+// truncateText cuts s to at most limit characters, marking the cut with
+// an ellipsis. Chat APIs reject messages over their length limits, and a
+// failure's error output can be long.
+func truncateText(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	if limit <= 0 {
+		return ""
+	}
+	return string([]rune(s)[:limit-1]) + "…"
+}
+
+// timestampOrNow returns the notification timestamp, defaulting to now
+func (n *Notification) timestampOrNow() time.Time {
+	if n.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return n.Timestamp
+}
+
+// summaryFields returns the standard fields shown by chat channels, in display order
+func (n *Notification) summaryFields() [][2]string {
+	var fields [][2]string
+	if n.Database != "" {
+		fields = append(fields, [2]string{"Database", n.Database})
+	}
+	if n.DatabaseType != "" {
+		fields = append(fields, [2]string{"Type", n.DatabaseType})
+	}
+	if n.BackupID != "" {
+		fields = append(fields, [2]string{"Backup ID", n.BackupID})
+	}
+	if n.FailureCount > 1 {
+		fields = append(fields, [2]string{"Consecutive failures", fmt.Sprintf("%d", n.FailureCount)})
+	}
+	for _, k := range sortedKeys(n.Fields) {
+		fields = append(fields, [2]string{k, n.Fields[k]})
+	}
+	return fields
+}
+
+// postJSON sends a JSON payload and treats any non-2xx response as an error
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}, headers map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	// Receivers that trace requests join the run's trace
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+
+	return nil
+}
+
+// newHTTPClient returns the HTTP client used by notifiers
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: defaultHTTPTimeout}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,110 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// defaultPagerDutyURL is the Events API v2 endpoint
+const defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyNotifier raises PagerDuty incidents through the Events API v2.
+// Incidents are deduplicated per database, so a later successful backup
+// resolves the incident opened by an earlier failure.
+type PagerDutyNotifier struct {
+	config config.PagerDutyConfig
+	client *http.Client
+}
+
+// NewPagerDutyNotifier creates a new PagerDuty notifier
+func NewPagerDutyNotifier(cfg config.PagerDutyConfig) *PagerDutyNotifier {
+	return &PagerDutyNotifier{config: cfg, client: newHTTPClient()}
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key,omitempty"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp"`
+	Component     string            `json:"component,omitempty"`
+	Class         string            `json:"class,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// Name returns the channel name
+func (p *PagerDutyNotifier) Name() string {
+	return "pagerduty"
+}
+
+// Send triggers an incident for failures and warnings, and resolves the
+// database's open incident on success
+func (p *PagerDutyNotifier) Send(ctx context.Context, n *Notification) error {
+	if p.config.RoutingKey == "" {
+		return fmt.Errorf("pagerduty: routing_key is not configured")
+	}
+
+	apiURL := p.config.APIURL
+	if apiURL == "" {
+		apiURL = defaultPagerDutyURL
+	}
+
+	event := pagerDutyEvent{
+		RoutingKey: p.config.RoutingKey,
+		DedupKey:   "db-backup/" + n.Database,
+	}
+
+	if n.Event == EventSuccess {
+		if !p.config.ResolveOnSuccess {
+			return nil
+		}
+		event.EventAction = "resolve"
+	} else {
+		source, _ := os.Hostname()
+		details := map[string]string{"message": n.Message}
+		for _, f := range n.summaryFields() {
+			details[f[0]] = f[1]
+		}
+		event.EventAction = "trigger"
+		event.Payload = &pagerDutyPayload{
+			Summary:       n.Title,
+			Source:        source,
+			Severity:      p.severity(n),
+			Timestamp:     n.timestampOrNow().UTC().Format(time.RFC3339),
+			Component:     n.Database,
+			Class:         n.DatabaseType,
+			CustomDetails: details,
+		}
+	}
+
+	if err := postJSON(ctx, p.client, apiURL, event, nil); err != nil {
+		return fmt.Errorf("pagerduty: %w", err)
+	}
+	return nil
+}
+
+// severity maps a notification to a PagerDuty severity, honouring any
+// escalated severity
+func (p *PagerDutyNotifier) severity(n *Notification) string {
+	if n.Severity != "" {
+		return n.Severity
+	}
+	if n.Event == EventWarning {
+		return "warning"
+	}
+	if p.config.Severity != "" {
+		return p.config.Severity
+	}
+	return "critical"
+}
@@ -0,0 +1,455 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+const (
+	queuePendingDir = "pending"
+	queueSendingDir = "sending"
+	queueDeadDir    = "dead"
+)
+
+// staleClaim is how long a delivery may stay claimed before it is taken to
+// belong to a process that died while sending it and is queued again
+const staleClaim = 10 * time.Minute
+
+var (
+	// ErrDeliveryNotFound is returned when a delivery ID does not exist
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	// ErrInvalidDeliveryID is returned for malformed delivery IDs
+	ErrInvalidDeliveryID = errors.New("invalid delivery ID")
+)
+
+// Delivery is one notification addressed to one channel. Deliveries are
+// tracked per channel so a Slack outage never causes duplicate emails.
+type Delivery struct {
+	ID           string        `json:"id"`
+	Channel      string        `json:"channel"`
+	Notification *Notification `json:"notification"`
+	Attempts     int           `json:"attempts"`
+	NextAttempt  time.Time     `json:"next_attempt"`
+	LastError    string        `json:"last_error,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+// Queue is a file-backed delivery queue that survives restarts. Each
+// delivery is a JSON file under pending/; deliveries that exhaust their
+// attempts are moved to dead/ for inspection and manual retry. A delivery
+// is claimed by renaming it into sending/ before it is sent, so the API
+// server and CLI runs sharing the directory never send it twice.
+type Queue struct {
+	config    config.NotificationQueueConfig
+	notifiers map[string]Notifier
+	mu        sync.Mutex
+	wake      chan struct{}
+}
+
+// NewQueue creates a queue delivering to the given channels
+func NewQueue(cfg config.NotificationQueueConfig, notifiers []Notifier) (*Queue, error) {
+	if cfg.Directory == "" {
+		return nil, fmt.Errorf("notification queue directory is not configured")
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 30 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 15 * time.Second
+	}
+
+	for _, dir := range []string{queuePendingDir, queueSendingDir, queueDeadDir} {
+		if err := os.MkdirAll(filepath.Join(cfg.Directory, dir), 0750); err != nil {
+			return nil, fmt.Errorf("failed to create notification queue directory: %w", err)
+		}
+	}
+
+	q := &Queue{
+		config:    cfg,
+		notifiers: make(map[string]Notifier, len(notifiers)),
+		wake:      make(chan struct{}, 1),
+	}
+	for _, n := range notifiers {
+		q.notifiers[n.Name()] = n
+	}
+	return q, nil
+}
+
+// Enqueue persists one delivery per channel; delivery happens in Run or
+// ProcessDue. With no channels given, every configured channel is used.
+func (q *Queue) Enqueue(ctx context.Context, n *Notification, channels ...string) error {
+	if n.Timestamp.IsZero() {
+		n.Timestamp = time.Now()
+	}
+	if len(channels) == 0 {
+		for name := range q.notifiers {
+			channels = append(channels, name)
+		}
+	}
+
+	q.mu.Lock()
+	now := time.Now()
+	for _, name := range channels {
+		if _, ok := q.notifiers[name]; !ok {
+			continue
+		}
+		id, err := utils.GenerateID("ntf")
+		if err != nil {
+			q.mu.Unlock()
+			return fmt.Errorf("failed to generate delivery ID: %w", err)
+		}
+		d := &Delivery{
+			ID:           id,
+			Channel:      name,
+			Notification: n,
+			NextAttempt:  now,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		if err := q.write(queuePendingDir, d); err != nil {
+			q.mu.Unlock()
+			return err
+		}
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers queued notifications until the context is cancelled
+func (q *Queue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.ProcessDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// ProcessDue attempts every delivery whose retry time has come and returns
+// how many were delivered
+func (q *Queue) ProcessDue(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	err := q.requeueStale()
+	var pending []*Delivery
+	if err == nil {
+		pending, err = q.list(queuePendingDir)
+	}
+	q.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	now := time.Now()
+	for _, d := range pending {
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		if d.NextAttempt.After(now) {
+			continue
+		}
+		ok, err := q.deliver(ctx, d.ID)
+		if err != nil {
+			return delivered, err
+		}
+		if ok {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// deliver claims the pending delivery id and attempts it, reporting
+// whether it was sent. Deliveries claimed by another process are skipped.
+func (q *Queue) deliver(ctx context.Context, id string) (bool, error) {
+	d, err := q.claim(id)
+	if errors.Is(err, ErrDeliveryNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	notifier, ok := q.notifiers[d.Channel]
+	if !ok {
+		d.LastError = fmt.Sprintf("channel %q is no longer configured", d.Channel)
+		return false, q.settle(queueDeadDir, d)
+	}
+
+	d.Attempts++
+	d.UpdatedAt = time.Now()
+	// The send runs unlocked: a slow channel must not hold up Enqueue
+	if err := notifier.Send(ctx, d.Notification); err != nil {
+		d.LastError = err.Error()
+		if d.Attempts >= q.config.MaxAttempts {
+			return false, q.settle(queueDeadDir, d)
+		}
+		d.NextAttempt = d.UpdatedAt.Add(q.backoff(d.Attempts))
+		return false, q.settle(queuePendingDir, d)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return true, q.remove(queueSendingDir, d.ID)
+}
+
+// claim moves the pending delivery id into sending/ and returns it, or
+// ErrDeliveryNotFound when another process claimed or finished it first.
+// The file is touched before the rename so its age in sending/ is the age
+// of the claim.
+func (q *Queue) claim(id string) (*Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	from := q.path(queuePendingDir, id)
+	now := time.Now()
+	if err := os.Chtimes(from, now, now); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrDeliveryNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to claim delivery: %w", err)
+	}
+	if err := os.Rename(from, q.path(queueSendingDir, id)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrDeliveryNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to claim delivery: %w", err)
+	}
+	return q.read(queueSendingDir, id)
+}
+
+// settle stores a claimed delivery in dir and releases the claim
+func (q *Queue) settle(dir string, d *Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.write(dir, d); err != nil {
+		return err
+	}
+	return q.remove(queueSendingDir, d.ID)
+}
+
+// requeueStale returns deliveries claimed longer than staleClaim ago to
+// pending/
+func (q *Queue) requeueStale() error {
+	entries, err := os.ReadDir(filepath.Join(q.config.Directory, queueSendingDir))
+	if err != nil {
+		return fmt.Errorf("failed to read notification queue: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < staleClaim {
+			continue
+		}
+		id := strings.TrimSuffix(e.Name(), ".json")
+		err = os.Rename(q.path(queueSendingDir, id), q.path(queuePendingDir, id))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to requeue delivery: %w", err)
+		}
+	}
+	return nil
+}
+
+// Pending returns deliveries awaiting their next attempt or being sent,
+// oldest first
+func (q *Queue) Pending() ([]*Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.list(queuePendingDir, queueSendingDir)
+}
+
+// DeadLetters returns deliveries that exhausted their attempts, oldest first
+func (q *Queue) DeadLetters() ([]*Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.list(queueDeadDir)
+}
+
+// Depth returns the number of pending and dead-lettered deliveries without
+// reading them, for cheap polling by metrics
+func (q *Queue) Depth() (pending, dead int, err error) {
+	if pending, err = q.count(queuePendingDir); err != nil {
+		return 0, 0, err
+	}
+	sending, err := q.count(queueSendingDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	pending += sending
+	if dead, err = q.count(queueDeadDir); err != nil {
+		return 0, 0, err
+	}
+	return pending, dead, nil
+}
+
+// DeadLetter returns a single dead-lettered delivery
+func (q *Queue) DeadLetter(id string) (*Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.read(queueDeadDir, id)
+}
+
+// Retry moves a dead-lettered delivery back to the queue with a fresh
+// attempt budget
+func (q *Queue) Retry(id string) error {
+	q.mu.Lock()
+	d, err := q.read(queueDeadDir, id)
+	if err != nil {
+		q.mu.Unlock()
+		return err
+	}
+	d.Attempts = 0
+	d.NextAttempt = time.Now()
+	d.UpdatedAt = d.NextAttempt
+	if err := q.write(queuePendingDir, d); err != nil {
+		q.mu.Unlock()
+		return err
+	}
+	err = q.remove(queueDeadDir, id)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// Discard permanently deletes a dead-lettered delivery
+func (q *Queue) Discard(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, err := q.read(queueDeadDir, id); err != nil {
+		return err
+	}
+	return q.remove(queueDeadDir, id)
+}
+
+// backoff returns the exponential delay before the next attempt
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.config.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= q.config.MaxBackoff {
+			return q.config.MaxBackoff
+		}
+	}
+	return delay
+}
+
+func (q *Queue) path(dir, id string) string {
+	return filepath.Join(q.config.Directory, dir, id+".json")
+}
+
+// write stores a delivery atomically
+func (q *Queue) write(dir string, d *Delivery) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery: %w", err)
+	}
+	path := q.path(dir, d.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write delivery: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func (q *Queue) read(dir, id string) (*Delivery, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDeliveryID, id)
+	}
+	data, err := os.ReadFile(q.path(dir, id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrDeliveryNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read delivery: %w", err)
+	}
+	d := &Delivery{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("failed to parse delivery %s: %w", id, err)
+	}
+	return d, nil
+}
+
+func (q *Queue) remove(dir, id string) error {
+	if err := os.Remove(q.path(dir, id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove delivery: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue) count(dir string) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(q.config.Directory, dir))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read notification queue: %w", err)
+	}
+	n := 0
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// list returns the deliveries in dirs. Deliveries moved by another
+// process while they are read are left out.
+func (q *Queue) list(dirs ...string) ([]*Delivery, error) {
+	var deliveries []*Delivery
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(filepath.Join(q.config.Directory, dir))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read notification queue: %w", err)
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+				continue
+			}
+			d, err := q.read(dir, strings.TrimSuffix(e.Name(), ".json"))
+			if errors.Is(err, ErrDeliveryNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+	return deliveries, nil
+}
@@ -0,0 +1,203 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+type fakeNotifier struct {
+	name  string
+	fail  bool
+	calls int
+}
+
+func (f *fakeNotifier) Name() string { return f.name }
+
+func (f *fakeNotifier) Send(ctx context.Context, n *Notification) error {
+	f.calls++
+	if f.fail {
+		return errors.New("service unavailable")
+	}
+	return nil
+}
+
+func TestQueueRetryAndDeadLetter(t *testing.T) {
+	ok := &fakeNotifier{name: "ok"}
+	broken := &fakeNotifier{name: "broken", fail: true}
+
+	q, err := NewQueue(config.NotificationQueueConfig{
+		Directory:      t.TempDir(),
+		MaxAttempts:    2,
+		InitialBackoff: time.Nanosecond,
+		MaxBackoff:     time.Nanosecond,
+	}, []Notifier{ok, broken})
+	if err != nil {
+		t.Fatalf("NewQueue() error = %v", err)
+	}
+
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, &Notification{Event: EventFailure, Title: "Backup failed"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	delivered, err := q.ProcessDue(ctx)
+	if err != nil || delivered != 1 {
+		t.Fatalf("first ProcessDue() = %d, %v; want 1 delivered", delivered, err)
+	}
+	pending, _ := q.Pending()
+	if len(pending) != 1 || pending[0].Channel != "broken" || pending[0].Attempts != 1 {
+		t.Fatalf("pending after first attempt = %+v", pending)
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := q.ProcessDue(ctx); err != nil {
+		t.Fatalf("second ProcessDue() error = %v", err)
+	}
+	pending, _ = q.Pending()
+	dead, _ := q.DeadLetters()
+	if len(pending) != 0 || len(dead) != 1 {
+		t.Fatalf("after max attempts: %d pending, %d dead; want 0, 1", len(pending), len(dead))
+	}
+	if dead[0].LastError != "service unavailable" {
+		t.Errorf("LastError = %q", dead[0].LastError)
+	}
+	if ok.calls != 1 {
+		t.Errorf("healthy channel called %d times, want 1", ok.calls)
+	}
+
+	broken.fail = false
+	if err := q.Retry(dead[0].ID); err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	if delivered, _ := q.ProcessDue(ctx); delivered != 1 {
+		t.Errorf("ProcessDue() after retry delivered %d, want 1", delivered)
+	}
+
+	if err := q.Retry("missing"); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("Retry(missing) error = %v, want ErrDeliveryNotFound", err)
+	}
+	if err := q.Discard("../etc"); !errors.Is(err, ErrInvalidDeliveryID) {
+		t.Errorf("Discard(../etc) error = %v, want ErrInvalidDeliveryID", err)
+	}
+}
+
+// blockingNotifier counts sends and holds each until release is closed
+type blockingNotifier struct {
+	sent    atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingNotifier) Name() string { return "slow" }
+
+func (b *blockingNotifier) Send(ctx context.Context, n *Notification) error {
+	b.sent.Add(1)
+	select {
+	case b.started <- struct{}{}:
+	default:
+	}
+	<-b.release
+	return nil
+}
+
+func TestQueueClaimsAcrossProcesses(t *testing.T) {
+	dir := t.TempDir()
+	slow := &blockingNotifier{started: make(chan struct{}, 1), release: make(chan struct{})}
+	cfg := config.NotificationQueueConfig{Directory: dir, MaxAttempts: 3}
+	// Two queues on one directory stand for the API server and a CLI run
+	a, err := NewQueue(cfg, []Notifier{slow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewQueue(cfg, []Notifier{slow})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err := a.Enqueue(ctx, &Notification{Title: "Backup failed"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var delivered atomic.Int32
+	for _, q := range []*Queue{a, b} {
+		wg.Add(1)
+		go func(q *Queue) {
+			defer wg.Done()
+			n, err := q.ProcessDue(ctx)
+			if err != nil {
+				t.Error(err)
+			}
+			delivered.Add(int32(n))
+		}(q)
+	}
+
+	<-slow.started
+	enqueued := make(chan error, 1)
+	go func() { enqueued <- a.Enqueue(ctx, &Notification{Title: "Backup completed"}) }()
+	select {
+	case err := <-enqueued:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Enqueue() blocked while a delivery was being sent")
+	}
+	close(slow.release)
+	wg.Wait()
+
+	// The delivery enqueued during the run may or may not have been sent
+	if sent := slow.sent.Load(); sent != delivered.Load() || sent < 5 || sent > 6 {
+		t.Errorf("%d sends for %d deliveries, want each of the 5 sent once", sent, delivered.Load())
+	}
+}
+
+func TestQueueRequeuesStaleClaims(t *testing.T) {
+	ok := &fakeNotifier{name: "ok"}
+	q, err := NewQueue(config.NotificationQueueConfig{Directory: t.TempDir(), MaxAttempts: 3}, []Notifier{ok})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, &Notification{Title: "Backup failed"}); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := q.Pending()
+	d, err := q.claim(pending[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh claim belongs to a live process
+	if n, _ := q.ProcessDue(ctx); n != 0 || ok.calls != 0 {
+		t.Fatalf("ProcessDue() sent a claimed delivery")
+	}
+	old := time.Now().Add(-2 * staleClaim)
+	if err := os.Chtimes(filepath.Join(q.config.Directory, queueSendingDir, d.ID+".json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.ProcessDue(ctx); n != 1 || err != nil {
+		t.Errorf("ProcessDue() after the claim went stale = %d, %v; want 1 delivered", n, err)
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	q := &Queue{config: config.NotificationQueueConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := q.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package notification
+
+import (
+	"path"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Router selects the channels a notification is delivered to, based on
+// database, database type, schedule, tag and event matching rules
+type Router struct {
+	config config.RoutingConfig
+}
+
+// NewRouter creates a router from routing configuration
+func NewRouter(cfg config.RoutingConfig) *Router {
+	return &Router{config: cfg}
+}
+
+// Route returns the channel names for a notification. A nil result means
+// every configured channel.
+func (r *Router) Route(n *Notification) []string {
+	var channels []string
+	matched := false
+
+	for _, rule := range r.config.Rules {
+		if !ruleMatches(rule, n) {
+			continue
+		}
+		matched = true
+		channels = utils.RemoveDuplicates(append(channels, rule.Channels...))
+		if !rule.Continue {
+			break
+		}
+	}
+
+	if !matched {
+		if len(r.config.DefaultChannels) == 0 {
+			return nil
+		}
+		return r.config.DefaultChannels
+	}
+	// A matching rule with no channels deliberately silences the notification
+	return channels
+}
+
+// ruleMatches reports whether every populated match field of a rule matches
+func ruleMatches(rule config.RoutingRule, n *Notification) bool {
+	if !matchAny(rule.Databases, n.Database) ||
+		!matchAny(rule.DatabaseTypes, n.DatabaseType) ||
+		!matchAny(rule.Schedules, n.Schedule) {
+		return false
+	}
+	if len(rule.Events) > 0 && !ShouldNotify(rule.Events, n.Event) {
+		return false
+	}
+	for key, pattern := range rule.Tags {
+		value, ok := n.Tags[key]
+		if !ok || !globMatch(pattern, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether value matches one of the glob patterns. An empty
+// pattern list matches everything.
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if globMatch(p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches shell-style patterns case-insensitively; malformed
+// patterns fall back to an exact comparison
+func globMatch(pattern, value string) bool {
+	pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+	ok, err := path.Match(pattern, value)
+	if err != nil {
+		return pattern == value
+	}
+	return ok
+}
@@ -0,0 +1,60 @@
+package notification
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func TestRouterRoute(t *testing.T) {
+	router := NewRouter(config.RoutingConfig{
+		DefaultChannels: []string{"slack"},
+		Rules: []config.RoutingRule{
+			{Name: "prod-failures", Databases: []string{"prod-*"}, Events: []string{"failure"}, Channels: []string{"pagerduty", "slack"}, Continue: true},
+			{Name: "prod", Tags: map[string]string{"env": "prod*"}, Channels: []string{"slack", "email"}},
+			{Name: "nightly", Schedules: []string{"nightly"}, DatabaseTypes: []string{"mongodb"}, Channels: []string{"telegram"}},
+			{Name: "muted", Databases: []string{"scratch"}},
+		},
+	})
+
+	tests := []struct {
+		name string
+		n    *Notification
+		want []string
+	}{
+		{
+			name: "prod failure continues into tag rule",
+			n:    &Notification{Event: EventFailure, Database: "prod-orders", Tags: map[string]string{"env": "production"}},
+			want: []string{"pagerduty", "slack", "email"},
+		},
+		{
+			name: "prod success skips failure rule",
+			n:    &Notification{Event: EventSuccess, Database: "prod-orders", Tags: map[string]string{"env": "production"}},
+			want: []string{"slack", "email"},
+		},
+		{
+			name: "schedule and type",
+			n:    &Notification{Event: EventSuccess, Database: "events", DatabaseType: "mongodb", Schedule: "nightly"},
+			want: []string{"telegram"},
+		},
+		{
+			name: "no match uses defaults",
+			n:    &Notification{Event: EventFailure, Database: "dev-api"},
+			want: []string{"slack"},
+		},
+		{
+			name: "rule without channels silences",
+			n:    &Notification{Event: EventFailure, Database: "scratch"},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := router.Route(tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Route() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// Slack attachment colors
+const (
+	slackColorSuccess = "good"
+	slackColorFailure = "danger"
+	slackColorWarning = "warning"
+)
+
+// SlackNotifier posts notifications to a Slack incoming webhook
+type SlackNotifier struct {
+	config config.SlackConfig
+	client *http.Client
+}
+
+// NewSlackNotifier creates a new Slack notifier
+func NewSlackNotifier(cfg config.SlackConfig) *SlackNotifier {
+	return &SlackNotifier{config: cfg, client: newHTTPClient()}
+}
+
+type slackPayload struct {
+	Channel     string            `json:"channel,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color,omitempty"`
+	Text   string       `json:"text,omitempty"`
+	Fields []slackField `json:"fields,omitempty"`
+	Ts     int64        `json:"ts"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// Name returns the channel name
+func (s *SlackNotifier) Name() string {
+	return "slack"
+}
+
+// Send posts the notification as a Slack message with an attachment
+func (s *SlackNotifier) Send(ctx context.Context, n *Notification) error {
+	if s.config.WebhookURL == "" {
+		return fmt.Errorf("slack: webhook_url is not configured")
+	}
+
+	attachment := slackAttachment{
+		Color: slackColor(n.Event),
+		Text:  n.Message,
+		Ts:    n.timestampOrNow().Unix(),
+	}
+	for _, f := range n.summaryFields() {
+		attachment.Fields = append(attachment.Fields, slackField{Title: f[0], Value: f[1], Short: true})
+	}
+
+	payload := slackPayload{
+		Channel:     s.config.Channel,
+		Text:        fmt.Sprintf("%s *%s*", eventEmoji(n.Event), n.Title),
+		Attachments: []slackAttachment{attachment},
+	}
+	if len(n.Mentions) > 0 {
+		payload.Text = n.mentionText() + " " + payload.Text
+	}
+
+	if err := postJSON(ctx, s.client, s.config.WebhookURL, payload, nil); err != nil {
+		return fmt.Errorf("slack: %w", err)
+	}
+	return nil
+}
+
+func slackColor(event EventType) string {
+	switch event {
+	case EventSuccess:
+		return slackColorSuccess
+	case EventFailure:
+		return slackColorFailure
+	case EventWarning:
+		return slackColorWarning
+	default:
+		return ""
+	}
+}
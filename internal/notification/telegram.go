@@ -0,0 +1,112 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// defaultTelegramAPIURL is the public Bot API endpoint
+const defaultTelegramAPIURL = "https://api.telegram.org"
+
+// telegramMaxText is the longest message text sendMessage takes
+const telegramMaxText = 4096
+
+// TelegramNotifier sends notifications through a Telegram bot
+type TelegramNotifier struct {
+	config config.TelegramConfig
+	client *http.Client
+}
+
+// NewTelegramNotifier creates a new Telegram notifier
+func NewTelegramNotifier(cfg config.TelegramConfig) *TelegramNotifier {
+	return &TelegramNotifier{config: cfg, client: newHTTPClient()}
+}
+
+type telegramMessage struct {
+	ChatID              string `json:"chat_id"`
+	Text                string `json:"text"`
+	ParseMode           string `json:"parse_mode"`
+	DisableNotification bool   `json:"disable_notification,omitempty"`
+	MessageThreadID     int    `json:"message_thread_id,omitempty"`
+}
+
+// Name returns the channel name
+func (t *TelegramNotifier) Name() string {
+	return "telegram"
+}
+
+// Send posts the notification with the Bot API sendMessage method
+func (t *TelegramNotifier) Send(ctx context.Context, n *Notification) error {
+	if t.config.BotToken == "" || t.config.ChatID == "" {
+		return fmt.Errorf("telegram: bot_token and chat_id are required")
+	}
+
+	apiURL := t.config.APIURL
+	if apiURL == "" {
+		apiURL = defaultTelegramAPIURL
+	}
+	url := fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimRight(apiURL, "/"), t.config.BotToken)
+
+	msg := telegramMessage{
+		ChatID:          t.config.ChatID,
+		Text:            formatTelegramText(n),
+		ParseMode:       "HTML",
+		MessageThreadID: t.config.ThreadID,
+		// Only failures and warnings should wake anyone up
+		DisableNotification: t.config.SilentSuccess && n.Event == EventSuccess,
+	}
+
+	if err := postJSON(ctx, t.client, url, msg, nil); err != nil {
+		// Never leak the bot token through error messages
+		return fmt.Errorf("telegram: %s", strings.ReplaceAll(err.Error(), t.config.BotToken, "****"))
+	}
+	return nil
+}
+
+// formatTelegramText renders a notification as Telegram HTML, cutting
+// the message when the text would be too long. Telegram counts the text
+// without its markup, so measuring the HTML keeps within the limit.
+func formatTelegramText(n *Notification) string {
+	text := renderTelegramText(n, html.EscapeString(n.Message))
+	if utf8.RuneCountInString(text) <= telegramMaxText {
+		return text
+	}
+	// The message is cut, never the summary, and between escapes
+	budget := telegramMaxText - utf8.RuneCountInString(renderTelegramText(n, "…"))
+	var b strings.Builder
+	for _, r := range n.Message {
+		escaped := html.EscapeString(string(r))
+		if budget -= utf8.RuneCountInString(escaped); budget < 0 {
+			break
+		}
+		b.WriteString(escaped)
+	}
+	return renderTelegramText(n, b.String()+"…")
+}
+
+// renderTelegramText renders a notification with message, already
+// escaped, as its body
+func renderTelegramText(n *Notification, message string) string {
+	var b strings.Builder
+	if len(n.Mentions) > 0 {
+		fmt.Fprintf(&b, "%s\n", html.EscapeString(n.mentionText()))
+	}
+	fmt.Fprintf(&b, "%s <b>%s</b>\n", eventEmoji(n.Event), html.EscapeString(n.Title))
+	if message != "" {
+		fmt.Fprintf(&b, "%s\n", message)
+	}
+	fields := n.summaryFields()
+	if len(fields) > 0 {
+		b.WriteString("\n")
+		for _, f := range fields {
+			fmt.Fprintf(&b, "<b>%s:</b> <code>%s</code>\n", html.EscapeString(f[0]), html.EscapeString(f[1]))
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
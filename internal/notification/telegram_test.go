@@ -0,0 +1,109 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func TestTelegramPayload(t *testing.T) {
+	srv, bodies := chatServer(t, http.StatusOK)
+	tg := NewTelegramNotifier(config.TelegramConfig{
+		BotToken: "123:ABC", ChatID: "-100200", ThreadID: 7, APIURL: srv.URL + "/", SilentSuccess: true,
+	})
+
+	if err := tg.Send(context.Background(), &Notification{
+		Event: EventFailure, Title: "Backup <orders> failed", Message: "exit status 1 & more",
+		Database: "orders", Mentions: []string{"@oncall"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tg.Send(context.Background(), &Notification{Event: EventSuccess, Title: "Backup done"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`{"chat_id":"-100200","text":"@oncall\n❌ <b>Backup &lt;orders&gt; failed</b>\n` +
+			`exit status 1 &amp; more\n\n<b>Database:</b> <code>orders</code>",` +
+			`"parse_mode":"HTML","message_thread_id":7}`,
+		// Successes do not ring
+		`{"chat_id":"-100200","text":"✅ <b>Backup done</b>",` +
+			`"parse_mode":"HTML","disable_notification":true,"message_thread_id":7}`,
+	}
+	if len(*bodies) != len(want) {
+		t.Fatalf("posted %v", *bodies)
+	}
+	for i, body := range *bodies {
+		path, payload, _ := strings.Cut(body, " ")
+		if path != "/bot123:ABC/sendMessage" {
+			t.Errorf("posted to %s", path)
+		}
+		var got, wanted telegramMessage
+		json.Unmarshal([]byte(payload), &got)
+		json.Unmarshal([]byte(want[i]), &wanted)
+		if got != wanted {
+			t.Errorf("message\n%+v\nwant\n%+v", got, wanted)
+		}
+	}
+}
+
+func TestTelegramTruncates(t *testing.T) {
+	srv, bodies := chatServer(t, http.StatusOK)
+	tg := NewTelegramNotifier(config.TelegramConfig{BotToken: "t", ChatID: "1", APIURL: srv.URL})
+	// Escaping makes the HTML of the message over three times as long
+	n := &Notification{Event: EventFailure, Title: "Backup failed", Message: strings.Repeat("<é&", 3000), Database: "orders"}
+	if err := tg.Send(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg telegramMessage
+	if err := json.Unmarshal([]byte(strings.SplitN((*bodies)[0], " ", 2)[1]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if l := utf8.RuneCountInString(msg.Text); l > telegramMaxText {
+		t.Errorf("text of %d characters", l)
+	}
+	// The summary is kept whole, only the message is cut, between entities
+	body, summary, ok := strings.Cut(msg.Text, "…\n\n")
+	if !ok || summary != "<b>Database:</b> <code>orders</code>" {
+		t.Fatalf("summary %q", summary)
+	}
+	if !strings.HasSuffix(body, ";") && !strings.HasSuffix(body, "é") {
+		t.Errorf("message cut inside an entity: %q", body[len(body)-10:])
+	}
+	if l := utf8.RuneCountInString(msg.Text); l < telegramMaxText-10 {
+		t.Errorf("text cut to %d characters", l)
+	}
+
+	short := &Notification{Event: EventSuccess, Title: "ok", Message: "done"}
+	if got := formatTelegramText(short); got != "✅ <b>ok</b>\ndone" {
+		t.Errorf("short text cut: %q", got)
+	}
+}
+
+func TestTelegramErrors(t *testing.T) {
+	srv, _ := chatServer(t, http.StatusBadRequest)
+	tg := NewTelegramNotifier(config.TelegramConfig{BotToken: "123:SECRET", ChatID: "1", APIURL: srv.URL + "/bot123:SECRET"})
+	err := tg.Send(context.Background(), &Notification{Event: EventFailure, Title: "failed"})
+	if err == nil || !strings.Contains(err.Error(), "unexpected status 400") || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("error = %v", err)
+	}
+	if strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("bot token in the error: %v", err)
+	}
+
+	// A failed request names the URL, and with it the token
+	tg = NewTelegramNotifier(config.TelegramConfig{BotToken: "123:SECRET", ChatID: "1", APIURL: "http://127.0.0.1:1"})
+	if err := tg.Send(context.Background(), &Notification{Event: EventFailure}); err == nil || strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("unreachable API: %v", err)
+	}
+
+	if err := NewTelegramNotifier(config.TelegramConfig{BotToken: "t"}).Send(context.Background(), &Notification{}); err == nil {
+		t.Error("sent without a chat id")
+	}
+}
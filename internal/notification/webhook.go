@@ -0,0 +1,248 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Headers sent with every webhook request
+const (
+	webhookTimestampHeader = "X-DBBackup-Timestamp"
+	webhookEventHeader     = "X-DBBackup-Event"
+	defaultSignatureHeader = "X-DBBackup-Signature"
+)
+
+// WebhookNotifier posts notifications to a generic HTTP endpoint. The body
+// is the notification as JSON, or the output of a configured template.
+type WebhookNotifier struct {
+	config   config.WebhookConfig
+	client   *http.Client
+	template *template.Template
+	// templateErr is reported on every send so a broken template surfaces
+	// in the queue's dead letters rather than silently sending defaults
+	templateErr error
+}
+
+// NewWebhookNotifier creates a webhook notifier, parsing the payload
+// template if one is configured
+func NewWebhookNotifier(cfg config.WebhookConfig) *WebhookNotifier {
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	if cfg.SignatureHeader == "" {
+		cfg.SignatureHeader = defaultSignatureHeader
+	}
+	if cfg.Retry.MaxAttempts < 1 {
+		cfg.Retry.MaxAttempts = 1
+	}
+	if cfg.Retry.InitialBackoff <= 0 {
+		cfg.Retry.InitialBackoff = 2 * time.Second
+	}
+	if cfg.Retry.MaxBackoff < cfg.Retry.InitialBackoff {
+		cfg.Retry.MaxBackoff = cfg.Retry.InitialBackoff
+	}
+
+	w := &WebhookNotifier{config: cfg, client: newHTTPClient()}
+	if cfg.Timeout > 0 {
+		w.client.Timeout = cfg.Timeout
+	}
+
+	text := cfg.Template
+	if cfg.TemplateFile != "" {
+		data, err := os.ReadFile(cfg.TemplateFile)
+		if err != nil {
+			w.templateErr = fmt.Errorf("failed to read template: %w", err)
+			return w
+		}
+		text = string(data)
+	}
+	if text != "" {
+		w.template, w.templateErr = template.New("webhook").Funcs(webhookTemplateFuncs).Parse(text)
+		if w.templateErr != nil {
+			w.templateErr = fmt.Errorf("invalid template: %w", w.templateErr)
+		}
+	}
+	return w
+}
+
+// webhookTemplateFuncs are available in payload templates. Use json to
+// embed values so strings are escaped correctly, e.g. {"text": {{json .Title}}}.
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"rfc3339": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+}
+
+// Name returns the channel name
+func (w *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Send delivers the notification, retrying network errors, 429 and 5xx
+// responses according to the retry policy
+func (w *WebhookNotifier) Send(ctx context.Context, n *Notification) error {
+	if w.config.URL == "" {
+		return fmt.Errorf("webhook: url is not configured")
+	}
+
+	body, err := w.payload(n)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= w.config.Retry.MaxAttempts; attempt++ {
+		hint, err := w.post(ctx, n, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if hint < 0 || attempt == w.config.Retry.MaxAttempts {
+			break
+		}
+
+		delay := w.backoff(attempt)
+		if hint > delay {
+			delay = hint
+		}
+		if delay > w.config.Retry.MaxBackoff {
+			delay = w.config.Retry.MaxBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("webhook: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+	return fmt.Errorf("webhook: %w", lastErr)
+}
+
+// payload renders the request body
+func (w *WebhookNotifier) payload(n *Notification) ([]byte, error) {
+	if w.templateErr != nil {
+		return nil, w.templateErr
+	}
+	if w.template == nil {
+		body, err := json.Marshal(n)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, n); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template did not produce valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends one request. The returned duration is negative when the error
+// is permanent, otherwise it is the server's Retry-After hint (or zero).
+func (w *WebhookNotifier) post(ctx context.Context, n *Notification, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, w.config.Method, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, string(n.Event))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	for k, v := range w.config.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.config.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhookTimestampHeader, ts)
+		req.Header.Set(w.config.SignatureHeader, "sha256="+SignWebhook(w.config.Secret, ts, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, fmt.Errorf("request failed: %w", err)
+		}
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if w.succeeded(resp.StatusCode) {
+		return 0, nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return retryAfter(resp.Header.Get("Retry-After")), err
+	}
+	return -1, err
+}
+
+// succeeded reports whether a status code counts as delivered
+func (w *WebhookNotifier) succeeded(status int) bool {
+	if len(w.config.SuccessCodes) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, code := range w.config.SuccessCodes {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the exponential delay after the given attempt
+func (w *WebhookNotifier) backoff(attempt int) time.Duration {
+	delay := w.config.Retry.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= w.config.Retry.MaxBackoff {
+			return w.config.Retry.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// retryAfter parses a Retry-After header given in seconds
+func retryAfter(header string) time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>". Receivers
+// recompute it with the shared secret and the X-DBBackup-Timestamp header,
+// and should reject stale timestamps to prevent replays.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,126 @@
+package notification
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWebhookSignsTemplatedPayload(t *testing.T) {
+	var gotBody []byte
+	var gotSig, gotTS string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSig = r.Header.Get("X-Signature")
+		gotTS = r.Header.Get(webhookTimestampHeader)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	w := NewWebhookNotifier(config.WebhookConfig{
+		URL:             srv.URL,
+		Secret:          "s3cret",
+		SignatureHeader: "X-Signature",
+		Template:        `{"text": {{json .Title}}, "db": {{json .Database}}}`,
+	})
+	err := w.Send(context.Background(), &Notification{Event: EventFailure, Title: `Backup "orders" failed`, Database: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `{"text": "Backup \"orders\" failed", "db": "orders"}`; string(gotBody) != want {
+		t.Errorf("body = %s, want %s", gotBody, want)
+	}
+	if want := "sha256=" + SignWebhook("s3cret", gotTS, gotBody); gotSig != want {
+		t.Errorf("signature = %q, want %q", gotSig, want)
+	}
+}
+
+func TestWebhookRetryAndSuccessCodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		successCodes []int
+		wantErr      bool
+		wantCalls    int32
+	}{
+		{"2xx by default", []int{http.StatusNoContent}, nil, false, 1},
+		{"retries 5xx", []int{http.StatusBadGateway, http.StatusOK}, nil, false, 2},
+		{"retries 429", []int{http.StatusTooManyRequests, http.StatusOK}, nil, false, 2},
+		{"4xx is permanent", []int{http.StatusBadRequest, http.StatusOK}, nil, true, 1},
+		{"gives up after max attempts", []int{500, 500, 500, 500}, nil, true, 3},
+		{"custom success code", []int{http.StatusFound}, []int{http.StatusFound}, false, 1},
+		{"2xx not listed", []int{http.StatusOK}, []int{http.StatusCreated}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&calls, 1) - 1
+				w.WriteHeader(tt.statuses[i])
+			}))
+			defer srv.Close()
+
+			w := NewWebhookNotifier(config.WebhookConfig{
+				URL:          srv.URL,
+				SuccessCodes: tt.successCodes,
+				Retry:        config.WebhookRetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			})
+			err := w.Send(context.Background(), &Notification{Event: EventSuccess, Title: "ok"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWebhookInvalidTemplate(t *testing.T) {
+	w := NewWebhookNotifier(config.WebhookConfig{URL: "http://localhost", Template: `{"text": {{.Title}}`})
+	if err := w.Send(context.Background(), &Notification{Title: "not json"}); err == nil {
+		t.Fatal("expected error for template producing invalid JSON")
+	}
+}
+
+func TestNotifiersPropagateTrace(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(prev)
+
+	var parents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parents = append(parents, r.Header.Get("Traceparent"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	ctx, span := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test").Start(context.Background(), "backup")
+	defer span.End()
+
+	n := &Notification{Event: EventFailure, Title: "Backup of orders failed"}
+	if err := NewWebhookNotifier(config.WebhookConfig{URL: srv.URL}).Send(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDiscordNotifier(config.DiscordConfig{WebhookURL: srv.URL}).Send(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := span.SpanContext()
+	want := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+	if len(parents) != 2 || parents[0] != want || parents[1] != want {
+		t.Errorf("traceparent %q, want %q", parents, want)
+	}
+}
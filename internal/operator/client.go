@@ -0,0 +1,37 @@
+package operator
+
+import (
+	"net/http"
+
+	"github.com/sanskarpan/db-backup/pkg/client"
+)
+
+// ErrNotFound is returned when the server has no such backup or schedule
+var ErrNotFound = client.ErrNotFound
+
+// Statuses of backups and restores reported by the server
+const (
+	StatusPending   = client.StatusPending
+	StatusRunning   = client.StatusRunning
+	StatusCompleted = client.StatusCompleted
+	StatusFailed    = client.StatusFailed
+)
+
+// Client calls the db-backup server API
+type Client = client.Client
+
+// Requests and resources of the server API
+type (
+	BackupRequest   = client.BackupRequest
+	Backup          = client.Backup
+	ScheduleRequest = client.ScheduleRequest
+	Schedule        = client.Schedule
+	RestoreRequest  = client.RestoreRequest
+	Restore         = client.Restore
+)
+
+// NewClient creates a client of the server at baseURL, e.g.
+// http://db-backup:8080, authenticating with an API key
+func NewClient(baseURL, apiKey string, hc *http.Client) *Client {
+	return client.New(baseURL, client.WithAPIKey(apiKey), client.WithHTTPClient(hc), client.WithUserAgent("db-backup-operator"))
+}
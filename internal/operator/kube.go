@@ -0,0 +1,198 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Paths mounted into every pod with a service account
+const (
+	serviceAccountDir   = "/var/run/secrets/kubernetes.io/serviceaccount"
+	serviceAccountToken = serviceAccountDir + "/token"
+	serviceAccountCA    = serviceAccountDir + "/ca.crt"
+)
+
+// eventComponent is the source of the events the operator records
+const eventComponent = "db-backup-operator"
+
+// ErrConflict is returned when an object changed since it was read
+var ErrConflict = errors.New("object was modified")
+
+// Kube is a minimal client of the Kubernetes API, covering what the
+// operator needs: listing custom resources, patching them and recording
+// events.
+type Kube struct {
+	server    string
+	tokenFile string
+	client    *http.Client
+}
+
+// NewKube creates a client of the API server at server, authenticating
+// with the bearer token read from tokenFile on each request so rotated
+// tokens are picked up. An empty tokenFile sends no token.
+func NewKube(server, tokenFile string, client *http.Client) *Kube {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Kube{server: strings.TrimRight(server, "/"), tokenFile: tokenFile, client: client}
+}
+
+// InCluster creates a client from the service account of the pod
+func InCluster() (*Kube, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	ca, err := os.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", serviceAccountCA)
+	}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}
+	return NewKube("https://"+net.JoinHostPort(host, port), serviceAccountToken, client), nil
+}
+
+// resourcePath is the path of a custom resource collection or object;
+// an empty namespace means all namespaces
+func resourcePath(resource, namespace, name string) string {
+	p := "/apis/" + Group + "/" + Version
+	if namespace != "" {
+		p += "/namespaces/" + url.PathEscape(namespace)
+	}
+	p += "/" + resource
+	if name != "" {
+		p += "/" + url.PathEscape(name)
+	}
+	return p
+}
+
+// List decodes the items of a custom resource into items, a pointer to a
+// slice
+func (k *Kube) List(ctx context.Context, resource, namespace string, items any) error {
+	var list struct {
+		Items json.RawMessage `json:"items"`
+	}
+	if err := k.do(ctx, http.MethodGet, resourcePath(resource, namespace, ""), "", nil, &list); err != nil {
+		return err
+	}
+	if len(list.Items) == 0 || string(list.Items) == "null" {
+		return nil
+	}
+	return json.Unmarshal(list.Items, items)
+}
+
+// Patch applies a JSON merge patch to an object, or to its status when
+// status is set. A resourceVersion in the patch makes it fail with
+// ErrConflict if the object changed.
+func (k *Kube) Patch(ctx context.Context, resource string, meta ObjectMeta, status bool, patch any) error {
+	path := resourcePath(resource, meta.Namespace, meta.Name)
+	if status {
+		path += "/status"
+	}
+	return k.do(ctx, http.MethodPatch, path, "application/merge-patch+json", patch, nil)
+}
+
+// Event records a core/v1 event about an object
+func (k *Kube) Event(ctx context.Context, kind string, meta ObjectMeta, warning bool, reason, message string) error {
+	typ := "Normal"
+	if warning {
+		typ = "Warning"
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	event := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]any{
+			"generateName": meta.Name + ".",
+			"namespace":    meta.Namespace,
+		},
+		"involvedObject": map[string]any{
+			"apiVersion":      APIVersion,
+			"kind":            kind,
+			"name":            meta.Name,
+			"namespace":       meta.Namespace,
+			"uid":             meta.UID,
+			"resourceVersion": meta.ResourceVersion,
+		},
+		"reason":         reason,
+		"message":        message,
+		"type":           typ,
+		"source":         map[string]any{"component": eventComponent},
+		"firstTimestamp": now,
+		"lastTimestamp":  now,
+		"count":          1,
+	}
+	return k.do(ctx, http.MethodPost, "/api/v1/namespaces/"+url.PathEscape(meta.Namespace)+"/events", "application/json", event, nil)
+}
+
+// do sends a request and decodes a successful response into out
+func (k *Kube) do(ctx context.Context, method, path, contentType string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		// Kubernetes errors are a Status object with a message
+		var status struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		if resp.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%s %s: %w: %s", method, path, ErrConflict, status.Message)
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, status.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,522 @@
+// Package operator reconciles DatabaseBackup, BackupSchedule and
+// DatabaseRestore custom resources against the db-backup server API, so
+// platform teams can manage backups declaratively. Resources are polled
+// at a fixed interval rather than watched: backups run for minutes to
+// hours, and polling keeps the operator free of client libraries.
+//
+// Progress is reported in each resource's status, with a Ready condition
+// and a phase, and as Kubernetes events on every transition.
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/logger"
+)
+
+// Defaults of Options
+const (
+	DefaultInterval       = 30 * time.Second
+	DefaultRestoreTimeout = 6 * time.Hour
+)
+
+// cluster is the part of Kube the operator uses
+type cluster interface {
+	List(ctx context.Context, resource, namespace string, items any) error
+	Patch(ctx context.Context, resource string, meta ObjectMeta, status bool, patch any) error
+	Event(ctx context.Context, kind string, meta ObjectMeta, warning bool, reason, message string) error
+}
+
+// server is the part of Client the operator uses
+type server interface {
+	CreateBackup(ctx context.Context, req BackupRequest) (*Backup, error)
+	GetBackup(ctx context.Context, id string) (*Backup, error)
+	RestoreBackup(ctx context.Context, id string, req RestoreRequest) (*Restore, error)
+	CreateSchedule(ctx context.Context, req ScheduleRequest) (*Schedule, error)
+	GetSchedule(ctx context.Context, id string) (*Schedule, error)
+	UpdateSchedule(ctx context.Context, id string, req ScheduleRequest) (*Schedule, error)
+	DeleteSchedule(ctx context.Context, id string) error
+}
+
+// Options tunes the operator
+type Options struct {
+	// Namespace limits the operator to one namespace; empty watches all
+	Namespace string
+	// Interval between reconcile passes
+	Interval time.Duration
+	// RestoreTimeout bounds a single restore
+	RestoreTimeout time.Duration
+}
+
+// Operator reconciles the custom resources
+type Operator struct {
+	kube    cluster
+	api     server
+	options Options
+	logger  *logger.Logger
+	now     func() time.Time
+
+	// Restores running in this process, by resource UID. A restore marked
+	// running that is not in here was cut short by a restart.
+	mu       sync.Mutex
+	restores map[string]bool
+	running  sync.WaitGroup
+}
+
+// New creates an operator
+func New(kube *Kube, api *Client, opts Options, log *logger.Logger) *Operator {
+	return newOperator(kube, api, opts, log)
+}
+
+func newOperator(kube cluster, api server, opts Options, log *logger.Logger) *Operator {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.RestoreTimeout <= 0 {
+		opts.RestoreTimeout = DefaultRestoreTimeout
+	}
+	return &Operator{
+		kube:     kube,
+		api:      api,
+		options:  opts,
+		logger:   log,
+		now:      time.Now,
+		restores: make(map[string]bool),
+	}
+}
+
+// Run reconciles every interval until ctx is cancelled, then waits for
+// running restores to stop
+func (o *Operator) Run(ctx context.Context) error {
+	o.logger.Info("Operator started", map[string]interface{}{
+		"namespace": o.options.Namespace,
+		"interval":  o.options.Interval.String(),
+	})
+	defer o.running.Wait()
+
+	ticker := time.NewTicker(o.options.Interval)
+	defer ticker.Stop()
+	for {
+		if err := o.Reconcile(ctx); err != nil && ctx.Err() == nil {
+			o.logger.Error("Reconcile failed", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile makes one pass over all resources. Restores started by the
+// pass keep running in the background.
+func (o *Operator) Reconcile(ctx context.Context) error {
+	var errs []error
+	ns := o.options.Namespace
+
+	var backups []DatabaseBackup
+	if err := o.kube.List(ctx, ResourceBackups, ns, &backups); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list %s: %w", ResourceBackups, err))
+	}
+	for i := range backups {
+		if err := o.reconcileBackup(ctx, &backups[i]); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s/%s: %w", KindBackup, backups[i].Metadata.Namespace, backups[i].Metadata.Name, err))
+		}
+	}
+
+	var schedules []BackupSchedule
+	if err := o.kube.List(ctx, ResourceSchedules, ns, &schedules); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list %s: %w", ResourceSchedules, err))
+	}
+	for i := range schedules {
+		if err := o.reconcileSchedule(ctx, &schedules[i]); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s/%s: %w", KindSchedule, schedules[i].Metadata.Namespace, schedules[i].Metadata.Name, err))
+		}
+	}
+
+	var restores []DatabaseRestore
+	if err := o.kube.List(ctx, ResourceRestores, ns, &restores); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list %s: %w", ResourceRestores, err))
+	}
+	byName := make(map[string]*DatabaseBackup, len(backups))
+	for i := range backups {
+		byName[backups[i].Metadata.Namespace+"/"+backups[i].Metadata.Name] = &backups[i]
+	}
+	for i := range restores {
+		if err := o.reconcileRestore(ctx, &restores[i], byName); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s/%s: %w", KindRestore, restores[i].Metadata.Namespace, restores[i].Metadata.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// serverName is the name of the backup or schedule created for a resource
+func serverName(meta ObjectMeta) string {
+	return "k8s-" + meta.Namespace + "-" + meta.Name
+}
+
+// backupRequest builds the server request for a backup spec, tagged with
+// the resource it comes from
+func backupRequest(kind string, meta ObjectMeta, spec BackupSpec) BackupRequest {
+	tags := make(map[string]string, len(spec.Tags)+3)
+	for k, v := range spec.Tags {
+		tags[k] = v
+	}
+	tags["k8s.kind"] = kind
+	tags["k8s.namespace"] = meta.Namespace
+	tags["k8s.name"] = meta.Name
+	return BackupRequest{
+		Name:        serverName(meta),
+		Profile:     spec.Profile,
+		Type:        spec.Type,
+		Database:    spec.Database,
+		Compression: spec.Compression,
+		Storage:     spec.Storage,
+		Tags:        tags,
+	}
+}
+
+// reconcileBackup starts the backup of a new resource and follows it
+// until it completes or fails
+func (o *Operator) reconcileBackup(ctx context.Context, b *DatabaseBackup) error {
+	if b.Metadata.DeletionTimestamp != nil || b.Status.Phase == PhaseCompleted || b.Status.Phase == PhaseFailed {
+		return nil
+	}
+	status := b.Status
+	status.Conditions = slices.Clone(b.Status.Conditions)
+	now := o.now()
+
+	var backup *Backup
+	var err error
+	if status.BackupID == "" {
+		backup, err = o.api.CreateBackup(ctx, backupRequest(KindBackup, b.Metadata, b.Spec))
+		if err != nil {
+			// Retried on the next pass: the server may be briefly unavailable
+			status.Phase = PhasePending
+			status.Conditions = setCondition(status.Conditions, Condition{
+				Type: ConditionReady, Status: ConditionFalse, ObservedGeneration: b.Metadata.Generation,
+				Reason: "CreateFailed", Message: err.Error(),
+			}, now)
+			o.transition(ctx, KindBackup, b.Metadata, b.Status.Conditions, status.Conditions)
+			if perr := o.patchStatus(ctx, ResourceBackups, b.Metadata, b.Status, status); perr != nil {
+				return perr
+			}
+			b.Status = status
+			return err
+		}
+		status.BackupID = backup.ID
+		status.StartedAt = &now
+	} else {
+		backup, err = o.api.GetBackup(ctx, status.BackupID)
+		if errors.Is(err, ErrNotFound) {
+			backup = &Backup{ID: status.BackupID, Status: StatusFailed, Error: "backup no longer exists on the server"}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	cond := Condition{Type: ConditionReady, ObservedGeneration: b.Metadata.Generation}
+	switch backup.Status {
+	case StatusCompleted:
+		status.Phase = PhaseCompleted
+		status.Size = backup.Size
+		status.CompletedAt = backup.CompletedAt
+		if status.CompletedAt == nil {
+			status.CompletedAt = &now
+		}
+		cond.Status, cond.Reason, cond.Message = ConditionTrue, "BackupCompleted", fmt.Sprintf("Backup %s completed", backup.ID)
+	case StatusFailed:
+		status.Phase = PhaseFailed
+		status.CompletedAt = &now
+		cond.Status, cond.Reason, cond.Message = ConditionFalse, "BackupFailed", backup.Error
+	default:
+		status.Phase = PhaseRunning
+		cond.Status, cond.Reason, cond.Message = ConditionFalse, "BackupRunning", fmt.Sprintf("Backup %s is running", backup.ID)
+	}
+	status.Conditions = setCondition(status.Conditions, cond, now)
+
+	o.transition(ctx, KindBackup, b.Metadata, b.Status.Conditions, status.Conditions)
+	if err := o.patchStatus(ctx, ResourceBackups, b.Metadata, b.Status, status); err != nil {
+		return err
+	}
+	b.Status = status
+	return nil
+}
+
+// scheduleRequest builds the server request for a schedule spec
+func scheduleRequest(s *BackupSchedule) ScheduleRequest {
+	return ScheduleRequest{
+		Name:     serverName(s.Metadata),
+		Cron:     s.Spec.Cron,
+		Timezone: s.Spec.Timezone,
+		Enabled:  !s.Spec.Suspend,
+		Backup:   backupRequest(KindSchedule, s.Metadata, s.Spec.Backup),
+	}
+}
+
+// reconcileSchedule creates, updates and deletes the server-side schedule
+// of a resource. A finalizer keeps the resource until the schedule is
+// gone, and a schedule deleted on the server is recreated.
+func (o *Operator) reconcileSchedule(ctx context.Context, s *BackupSchedule) error {
+	meta := s.Metadata
+	hasFinalizer := slices.Contains(meta.Finalizers, Finalizer)
+
+	if meta.DeletionTimestamp != nil {
+		if !hasFinalizer {
+			return nil
+		}
+		if id := s.Status.ScheduleID; id != "" {
+			if err := o.api.DeleteSchedule(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+				o.event(ctx, KindSchedule, meta, true, "DeleteFailed", err.Error())
+				return err
+			}
+		}
+		rest := slices.DeleteFunc(slices.Clone(meta.Finalizers), func(f string) bool { return f == Finalizer })
+		return o.kube.Patch(ctx, ResourceSchedules, meta, false, map[string]any{
+			"metadata": map[string]any{"finalizers": rest, "resourceVersion": meta.ResourceVersion},
+		})
+	}
+	if !hasFinalizer {
+		finalizers := append(slices.Clone(meta.Finalizers), Finalizer)
+		if err := o.kube.Patch(ctx, ResourceSchedules, meta, false, map[string]any{
+			"metadata": map[string]any{"finalizers": finalizers, "resourceVersion": meta.ResourceVersion},
+		}); err != nil {
+			return err
+		}
+	}
+
+	status := s.Status
+	status.Conditions = slices.Clone(s.Status.Conditions)
+	now := o.now()
+	req := scheduleRequest(s)
+
+	var err error
+	var reason string
+	if status.ScheduleID == "" {
+		reason = "ScheduleCreated"
+	} else {
+		var current *Schedule
+		current, err = o.api.GetSchedule(ctx, status.ScheduleID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			reason, err = "ScheduleRecreated", nil
+		case err != nil:
+		case status.ObservedGeneration != meta.Generation || current.Enabled != req.Enabled:
+			reason = "ScheduleUpdated"
+		}
+	}
+
+	var schedule *Schedule
+	switch {
+	case err != nil:
+	case reason == "ScheduleCreated" || reason == "ScheduleRecreated":
+		schedule, err = o.api.CreateSchedule(ctx, req)
+	case reason == "ScheduleUpdated":
+		schedule, err = o.api.UpdateSchedule(ctx, status.ScheduleID, req)
+	}
+
+	cond := Condition{Type: ConditionReady, ObservedGeneration: meta.Generation}
+	if err != nil {
+		cond.Status, cond.Reason, cond.Message = ConditionFalse, "SyncFailed", err.Error()
+	} else {
+		if schedule != nil {
+			status.ScheduleID = schedule.ID
+		}
+		status.ObservedGeneration = meta.Generation
+		cond.Status, cond.Reason = ConditionTrue, "Scheduled"
+		cond.Message = fmt.Sprintf("Schedule %s runs on %q", status.ScheduleID, s.Spec.Cron)
+		if s.Spec.Suspend {
+			cond.Reason, cond.Message = "Suspended", fmt.Sprintf("Schedule %s is suspended", status.ScheduleID)
+		}
+		if reason != "" {
+			o.event(ctx, KindSchedule, meta, reason == "ScheduleRecreated", reason, cond.Message)
+		}
+	}
+	status.Conditions = setCondition(status.Conditions, cond, now)
+
+	if err != nil || reason == "" {
+		o.transition(ctx, KindSchedule, meta, s.Status.Conditions, status.Conditions)
+	}
+	if perr := o.patchStatus(ctx, ResourceSchedules, meta, s.Status, status); perr != nil {
+		return errors.Join(err, perr)
+	}
+	s.Status = status
+	return err
+}
+
+// reconcileRestore starts the restore of a new resource once its backup
+// is known. The restore runs in the background; a restore found running
+// that this process did not start was interrupted by a restart and is
+// failed rather than run twice.
+func (o *Operator) reconcileRestore(ctx context.Context, r *DatabaseRestore, backups map[string]*DatabaseBackup) error {
+	meta := r.Metadata
+	if meta.DeletionTimestamp != nil || r.Status.Phase == PhaseCompleted || r.Status.Phase == PhaseFailed {
+		return nil
+	}
+	o.mu.Lock()
+	inFlight := o.restores[meta.UID]
+	o.mu.Unlock()
+	if inFlight {
+		return nil
+	}
+
+	status := r.Status
+	status.Conditions = slices.Clone(r.Status.Conditions)
+	now := o.now()
+	cond := Condition{Type: ConditionReady, Status: ConditionFalse, ObservedGeneration: meta.Generation}
+
+	update := func() error {
+		status.Conditions = setCondition(status.Conditions, cond, now)
+		o.transition(ctx, KindRestore, meta, r.Status.Conditions, status.Conditions)
+		if err := o.patchStatus(ctx, ResourceRestores, meta, r.Status, status); err != nil {
+			return err
+		}
+		r.Status = status
+		return nil
+	}
+	fail := func(reason, message string) error {
+		status.Phase, status.CompletedAt = PhaseFailed, &now
+		cond.Reason, cond.Message = reason, message
+		return update()
+	}
+
+	if status.Phase == PhaseRunning {
+		return fail("Interrupted", "The operator restarted while the restore was running; check the target database before retrying")
+	}
+
+	backupID := r.Spec.BackupID
+	switch {
+	case backupID != "" && r.Spec.BackupRef != "":
+		return fail("InvalidSpec", "Set only one of backupID and backupRef")
+	case backupID == "" && r.Spec.BackupRef == "":
+		return fail("InvalidSpec", "One of backupID and backupRef is required")
+	case backupID == "":
+		ref, ok := backups[meta.Namespace+"/"+r.Spec.BackupRef]
+		switch {
+		case !ok:
+			status.Phase = PhasePending
+			cond.Reason, cond.Message = "WaitingForBackup", fmt.Sprintf("%s %q not found", KindBackup, r.Spec.BackupRef)
+			return update()
+		case ref.Status.Phase == PhaseFailed:
+			return fail("BackupFailed", fmt.Sprintf("%s %q failed", KindBackup, r.Spec.BackupRef))
+		case ref.Status.Phase != PhaseCompleted:
+			status.Phase = PhasePending
+			cond.Reason, cond.Message = "WaitingForBackup", fmt.Sprintf("%s %q has not completed", KindBackup, r.Spec.BackupRef)
+			return update()
+		}
+		backupID = ref.Status.BackupID
+	}
+
+	// Record the restore as running before starting it, so a restart
+	// cannot run it twice
+	status.Phase, status.BackupID, status.StartedAt = PhaseRunning, backupID, &now
+	cond.Reason, cond.Message = "RestoreRunning", fmt.Sprintf("Restoring backup %s", backupID)
+	if err := update(); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.restores[meta.UID] = true
+	o.mu.Unlock()
+	o.running.Add(1)
+	go func() {
+		defer o.running.Done()
+		defer func() {
+			o.mu.Lock()
+			delete(o.restores, meta.UID)
+			o.mu.Unlock()
+		}()
+		o.runRestore(ctx, meta, r.Spec, status, backupID)
+	}()
+	return nil
+}
+
+// runRestore restores a backup and records the outcome
+func (o *Operator) runRestore(ctx context.Context, meta ObjectMeta, spec RestoreSpec, status RestoreStatus, backupID string) {
+	rctx, cancel := context.WithTimeout(ctx, o.options.RestoreTimeout)
+	restore, err := o.api.RestoreBackup(rctx, backupID, RestoreRequest{Profile: spec.Profile, TargetDatabase: spec.TargetDatabase})
+	cancel()
+	if ctx.Err() != nil {
+		// Shutting down: the next run reports the restore as interrupted
+		return
+	}
+
+	previous := status
+	status.Conditions = slices.Clone(previous.Conditions)
+	now := o.now()
+	status.CompletedAt = &now
+	cond := Condition{Type: ConditionReady, ObservedGeneration: meta.Generation}
+	switch {
+	case err != nil:
+		status.Phase = PhaseFailed
+		cond.Status, cond.Reason, cond.Message = ConditionFalse, "RestoreFailed", err.Error()
+	case restore.Status == StatusFailed:
+		status.Phase = PhaseFailed
+		cond.Status, cond.Reason, cond.Message = ConditionFalse, "RestoreFailed", restore.Error
+	default:
+		status.Phase = PhaseCompleted
+		cond.Status, cond.Reason, cond.Message = ConditionTrue, "RestoreCompleted", fmt.Sprintf("Restored backup %s", backupID)
+	}
+	status.Conditions = setCondition(status.Conditions, cond, now)
+
+	o.transition(ctx, KindRestore, meta, previous.Conditions, status.Conditions)
+	if err := o.patchStatus(ctx, ResourceRestores, meta, previous, status); err != nil {
+		o.logger.Error("Failed to record restore outcome", err, map[string]interface{}{
+			"namespace": meta.Namespace,
+			"name":      meta.Name,
+			"phase":     status.Phase,
+		})
+	}
+}
+
+// patchStatus writes status when it differs from old
+func (o *Operator) patchStatus(ctx context.Context, resource string, meta ObjectMeta, old, status any) error {
+	before, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+	after, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	if string(before) == string(after) {
+		return nil
+	}
+	return o.kube.Patch(ctx, resource, meta, true, map[string]any{"status": json.RawMessage(after)})
+}
+
+// transition records an event when the Ready condition changes reason,
+// as a warning when it turns false for anything but progress
+func (o *Operator) transition(ctx context.Context, kind string, meta ObjectMeta, before, after []Condition) {
+	next := findCondition(after, ConditionReady)
+	if next == nil {
+		return
+	}
+	if prev := findCondition(before, ConditionReady); prev != nil && prev.Reason == next.Reason && prev.Message == next.Message {
+		return
+	}
+	warning := next.Status == ConditionFalse && !slices.Contains(progressReasons, next.Reason)
+	o.event(ctx, kind, meta, warning, next.Reason, next.Message)
+}
+
+// progressReasons are Ready=False reasons that are not problems
+var progressReasons = []string{"BackupRunning", "RestoreRunning", "WaitingForBackup"}
+
+// event records an event, logging failures: events are informational
+func (o *Operator) event(ctx context.Context, kind string, meta ObjectMeta, warning bool, reason, message string) {
+	if err := o.kube.Event(ctx, kind, meta, warning, reason, message); err != nil {
+		o.logger.Warn("Failed to record event", map[string]interface{}{
+			"kind":      kind,
+			"namespace": meta.Namespace,
+			"name":      meta.Name,
+			"reason":    reason,
+			"error":     err.Error(),
+		})
+	}
+}
@@ -0,0 +1,427 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/logger"
+)
+
+// fakeCluster keeps objects as JSON and applies merge patches like the API
+// server does
+type fakeCluster struct {
+	mu      sync.Mutex
+	objects map[string]map[string]map[string]any // resource, namespace/name
+	events  []string                             // kind/name reason
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{objects: map[string]map[string]map[string]any{}}
+}
+
+func (f *fakeCluster) add(t *testing.T, resource string, obj any) {
+	t.Helper()
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	meta := m["metadata"].(map[string]any)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects[resource] == nil {
+		f.objects[resource] = map[string]map[string]any{}
+	}
+	f.objects[resource][fmt.Sprint(meta["namespace"], "/", meta["name"])] = m
+}
+
+func (f *fakeCluster) get(t *testing.T, resource, name string, out any) {
+	t.Helper()
+	f.mu.Lock()
+	obj, ok := f.objects[resource]["default/"+name]
+	f.mu.Unlock()
+	if !ok {
+		t.Fatalf("%s %s does not exist", resource, name)
+	}
+	data, _ := json.Marshal(obj)
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *fakeCluster) List(ctx context.Context, resource, namespace string, items any) error {
+	f.mu.Lock()
+	var list []map[string]any
+	for _, obj := range f.objects[resource] {
+		list = append(list, obj)
+	}
+	data, _ := json.Marshal(list)
+	f.mu.Unlock()
+	return json.Unmarshal(data, items)
+}
+
+func (f *fakeCluster) Patch(ctx context.Context, resource string, meta ObjectMeta, status bool, patch any) error {
+	data, _ := json.Marshal(patch)
+	var p map[string]any
+	json.Unmarshal(data, &p)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := meta.Namespace + "/" + meta.Name
+	obj, ok := f.objects[resource][key]
+	if !ok {
+		return errors.New("not found")
+	}
+	merge(obj, p)
+	// Like the API server, drop objects being deleted once their
+	// finalizers are gone
+	m := obj["metadata"].(map[string]any)
+	if fins, _ := m["finalizers"].([]any); m["deletionTimestamp"] != nil && len(fins) == 0 {
+		delete(f.objects[resource], key)
+	}
+	return nil
+}
+
+// merge applies a JSON merge patch
+func merge(dst, patch map[string]any) {
+	for k, v := range patch {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		if pm, ok := v.(map[string]any); ok {
+			if dm, ok := dst[k].(map[string]any); ok {
+				merge(dm, pm)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+func (f *fakeCluster) Event(ctx context.Context, kind string, meta ObjectMeta, warning bool, reason, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, kind+"/"+meta.Name+" "+reason)
+	return nil
+}
+
+func (f *fakeCluster) hasEvent(e string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, got := range f.events {
+		if got == e {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeServer records calls to the db-backup API
+type fakeServer struct {
+	mu        sync.Mutex
+	backups   map[string]*Backup
+	schedules map[string]*ScheduleRequest
+	restores  []string
+	release   chan struct{} // restores wait on it when set
+	nextID    int
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{backups: map[string]*Backup{}, schedules: map[string]*ScheduleRequest{}}
+}
+
+func (s *fakeServer) id(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%d", prefix, s.nextID)
+}
+
+func (s *fakeServer) CreateBackup(ctx context.Context, req BackupRequest) (*Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &Backup{ID: s.id("bk"), Status: StatusRunning}
+	s.backups[b.ID] = b
+	out := *b
+	return &out, nil
+}
+
+func (s *fakeServer) GetBackup(ctx context.Context, id string) (*Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.backups[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	out := *b
+	return &out, nil
+}
+
+func (s *fakeServer) setBackup(id, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backups[id].Status = status
+	s.backups[id].Size = 1024
+}
+
+func (s *fakeServer) RestoreBackup(ctx context.Context, id string, req RestoreRequest) (*Restore, error) {
+	if s.release != nil {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restores = append(s.restores, id+"->"+req.TargetDatabase)
+	return &Restore{ID: s.id("rs"), Status: StatusCompleted}, nil
+}
+
+func (s *fakeServer) CreateSchedule(ctx context.Context, req ScheduleRequest) (*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.id("sc")
+	s.schedules[id] = &req
+	return &Schedule{ID: id, Name: req.Name, Enabled: req.Enabled}, nil
+}
+
+func (s *fakeServer) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.schedules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &Schedule{ID: id, Name: req.Name, Enabled: req.Enabled}, nil
+}
+
+func (s *fakeServer) UpdateSchedule(ctx context.Context, id string, req ScheduleRequest) (*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.schedules[id]; !ok {
+		return nil, ErrNotFound
+	}
+	s.schedules[id] = &req
+	return &Schedule{ID: id, Name: req.Name, Enabled: req.Enabled}, nil
+}
+
+func (s *fakeServer) DeleteSchedule(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.schedules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.schedules, id)
+	return nil
+}
+
+func newTestOperator(kube *fakeCluster, api *fakeServer) *Operator {
+	return newOperator(kube, api, Options{}, logger.New(logger.Config{Level: "error"}).WithOutput(io.Discard))
+}
+
+func meta(name string, generation int64) ObjectMeta {
+	return ObjectMeta{Name: name, Namespace: "default", UID: "uid-" + name, Generation: generation}
+}
+
+func TestBackupLifecycle(t *testing.T) {
+	kube, api := newFakeCluster(), newFakeServer()
+	op := newTestOperator(kube, api)
+	ctx := context.Background()
+
+	kube.add(t, ResourceBackups, DatabaseBackup{Metadata: meta("nightly", 1), Spec: BackupSpec{Profile: "orders", Database: "orders"}})
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var b DatabaseBackup
+	kube.get(t, ResourceBackups, "nightly", &b)
+	if b.Status.Phase != PhaseRunning || b.Status.BackupID == "" || b.Status.StartedAt == nil {
+		t.Fatalf("status after start = %+v", b.Status)
+	}
+	if !kube.hasEvent("DatabaseBackup/nightly BackupRunning") {
+		t.Fatalf("events = %v", kube.events)
+	}
+
+	// Nothing changes while the backup runs
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.backups) != 1 {
+		t.Fatalf("%d backups started, want 1", len(api.backups))
+	}
+
+	api.setBackup(b.Status.BackupID, StatusCompleted)
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	kube.get(t, ResourceBackups, "nightly", &b)
+	ready := findCondition(b.Status.Conditions, ConditionReady)
+	if b.Status.Phase != PhaseCompleted || b.Status.Size != 1024 || ready == nil || ready.Status != ConditionTrue {
+		t.Fatalf("status after completion = %+v", b.Status)
+	}
+	if !kube.hasEvent("DatabaseBackup/nightly BackupCompleted") {
+		t.Fatalf("events = %v", kube.events)
+	}
+}
+
+func TestScheduleSyncAndDeletion(t *testing.T) {
+	kube, api := newFakeCluster(), newFakeServer()
+	op := newTestOperator(kube, api)
+	ctx := context.Background()
+
+	s := BackupSchedule{Metadata: meta("hourly", 1), Spec: ScheduleSpec{Cron: "0 * * * *", Backup: BackupSpec{Profile: "orders"}}}
+	kube.add(t, ResourceSchedules, s)
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	kube.get(t, ResourceSchedules, "hourly", &s)
+	id := s.Status.ScheduleID
+	if id == "" || len(s.Metadata.Finalizers) != 1 || s.Status.ObservedGeneration != 1 {
+		t.Fatalf("schedule after create: %+v", s)
+	}
+	if req := api.schedules[id]; req.Name != "k8s-default-hourly" || !req.Enabled || req.Backup.Tags["k8s.kind"] != KindSchedule {
+		t.Fatalf("server schedule = %+v", req)
+	}
+
+	// A spec change updates the same schedule
+	s.Metadata.Generation = 2
+	s.Spec.Suspend = true
+	kube.add(t, ResourceSchedules, s)
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	kube.get(t, ResourceSchedules, "hourly", &s)
+	if s.Status.ScheduleID != id || api.schedules[id].Enabled || findCondition(s.Status.Conditions, ConditionReady).Reason != "Suspended" {
+		t.Fatalf("schedule after update: %+v, server %+v", s.Status, api.schedules[id])
+	}
+
+	// A schedule deleted on the server is recreated
+	api.DeleteSchedule(ctx, id)
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	kube.get(t, ResourceSchedules, "hourly", &s)
+	if s.Status.ScheduleID == id || len(api.schedules) != 1 || !kube.hasEvent("BackupSchedule/hourly ScheduleRecreated") {
+		t.Fatalf("schedule not recreated: %+v, events %v", s.Status, kube.events)
+	}
+
+	// Deleting the resource deletes the schedule and releases the finalizer
+	now := time.Now()
+	s.Metadata.DeletionTimestamp = &now
+	kube.add(t, ResourceSchedules, s)
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.schedules) != 0 || len(kube.objects[ResourceSchedules]) != 0 {
+		t.Fatalf("left %d server schedules and %d resources", len(api.schedules), len(kube.objects[ResourceSchedules]))
+	}
+}
+
+func TestRestoreWaitsForBackupRef(t *testing.T) {
+	kube, api := newFakeCluster(), newFakeServer()
+	op := newTestOperator(kube, api)
+	ctx := context.Background()
+
+	kube.add(t, ResourceBackups, DatabaseBackup{Metadata: meta("nightly", 1), Spec: BackupSpec{Profile: "orders"}})
+	kube.add(t, ResourceRestores, DatabaseRestore{Metadata: meta("drill", 1), Spec: RestoreSpec{BackupRef: "nightly", TargetDatabase: "orders_drill"}})
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var r DatabaseRestore
+	kube.get(t, ResourceRestores, "drill", &r)
+	if r.Status.Phase != PhasePending || findCondition(r.Status.Conditions, ConditionReady).Reason != "WaitingForBackup" {
+		t.Fatalf("restore before backup completed: %+v", r.Status)
+	}
+
+	var b DatabaseBackup
+	kube.get(t, ResourceBackups, "nightly", &b)
+	api.setBackup(b.Status.BackupID, StatusCompleted)
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	op.running.Wait()
+
+	kube.get(t, ResourceRestores, "drill", &r)
+	if r.Status.Phase != PhaseCompleted || r.Status.BackupID != b.Status.BackupID || r.Status.CompletedAt == nil {
+		t.Fatalf("restore status = %+v", r.Status)
+	}
+	if len(api.restores) != 1 || api.restores[0] != b.Status.BackupID+"->orders_drill" {
+		t.Fatalf("restores = %v", api.restores)
+	}
+	if !kube.hasEvent("DatabaseRestore/drill RestoreCompleted") {
+		t.Fatalf("events = %v", kube.events)
+	}
+}
+
+func TestRestoreRunsOnce(t *testing.T) {
+	kube, api := newFakeCluster(), newFakeServer()
+	api.release = make(chan struct{})
+	op := newTestOperator(kube, api)
+	ctx := context.Background()
+
+	kube.add(t, ResourceRestores, DatabaseRestore{Metadata: meta("drill", 1), Spec: RestoreSpec{BackupID: "bk-7"}})
+	for i := 0; i < 3; i++ {
+		if err := op.Reconcile(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(api.release)
+	op.running.Wait()
+	if len(api.restores) != 1 {
+		t.Fatalf("restore ran %d times", len(api.restores))
+	}
+
+	// A restore left running by another process was interrupted
+	kube.add(t, ResourceRestores, DatabaseRestore{Metadata: meta("stale", 1), Spec: RestoreSpec{BackupID: "bk-7"}, Status: RestoreStatus{Phase: PhaseRunning}})
+	if err := op.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var r DatabaseRestore
+	kube.get(t, ResourceRestores, "stale", &r)
+	if r.Status.Phase != PhaseFailed || findCondition(r.Status.Conditions, ConditionReady).Reason != "Interrupted" || len(api.restores) != 1 {
+		t.Fatalf("stale restore: %+v", r.Status)
+	}
+}
+
+func TestClientDecodesResponses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/backups/bk-1":
+			fmt.Fprint(w, `{"success":true,"data":{"id":"bk-1","status":"completed","size":42}}`)
+		case "/api/v1/schedules":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid cron expression","message":"Invalid schedule"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL+"/", "key", nil)
+	ctx := context.Background()
+	b, err := c.GetBackup(ctx, "bk-1")
+	if err != nil || b.Status != StatusCompleted || b.Size != 42 {
+		t.Fatalf("GetBackup() = %+v, %v", b, err)
+	}
+	if _, err := c.GetBackup(ctx, "bk-2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetBackup() of a missing backup = %v", err)
+	}
+	if _, err := c.CreateSchedule(ctx, ScheduleRequest{}); err == nil || err.Error() != "POST /schedules: Invalid schedule: invalid cron expression" {
+		t.Fatalf("CreateSchedule() = %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package operator
+
+import (
+	"time"
+)
+
+// API group and version of the custom resources
+const (
+	Group      = "dbbackup.io"
+	Version    = "v1alpha1"
+	APIVersion = Group + "/" + Version
+)
+
+// Resource plurals, as served by the Kubernetes API
+const (
+	ResourceBackups   = "databasebackups"
+	ResourceSchedules = "backupschedules"
+	ResourceRestores  = "databaserestores"
+)
+
+// Kinds of the custom resources
+const (
+	KindBackup   = "DatabaseBackup"
+	KindSchedule = "BackupSchedule"
+	KindRestore  = "DatabaseRestore"
+)
+
+// Finalizer keeps a BackupSchedule until its server-side schedule is deleted
+const Finalizer = Group + "/schedule"
+
+// Phases of backups and restores
+const (
+	PhasePending   = "Pending"
+	PhaseRunning   = "Running"
+	PhaseCompleted = "Completed"
+	PhaseFailed    = "Failed"
+)
+
+// ConditionReady is the condition type set on every resource
+const ConditionReady = "Ready"
+
+// Condition statuses
+const (
+	ConditionTrue    = "True"
+	ConditionFalse   = "False"
+	ConditionUnknown = "Unknown"
+)
+
+// ObjectMeta is the part of Kubernetes object metadata the operator uses
+type ObjectMeta struct {
+	Name              string     `json:"name"`
+	Namespace         string     `json:"namespace,omitempty"`
+	UID               string     `json:"uid,omitempty"`
+	ResourceVersion   string     `json:"resourceVersion,omitempty"`
+	Generation        int64      `json:"generation,omitempty"`
+	DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
+	Finalizers        []string   `json:"finalizers,omitempty"`
+}
+
+// Condition mirrors metav1.Condition
+type Condition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	ObservedGeneration int64     `json:"observedGeneration,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+	Reason             string    `json:"reason"`
+	Message            string    `json:"message"`
+}
+
+// BackupSpec describes a backup. Credentials never appear in resources:
+// Profile names a connection profile configured on the server.
+type BackupSpec struct {
+	Profile     string            `json:"profile,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Database    string            `json:"database,omitempty"`
+	Compression string            `json:"compression,omitempty"`
+	Storage     string            `json:"storage,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// DatabaseBackup requests one backup. The spec is read once: editing it
+// after the backup started has no effect.
+type DatabaseBackup struct {
+	Metadata ObjectMeta   `json:"metadata"`
+	Spec     BackupSpec   `json:"spec"`
+	Status   BackupStatus `json:"status,omitempty"`
+}
+
+// BackupStatus reports a DatabaseBackup
+type BackupStatus struct {
+	Phase       string      `json:"phase,omitempty"`
+	BackupID    string      `json:"backupID,omitempty"`
+	Size        int64       `json:"size,omitempty"`
+	StartedAt   *time.Time  `json:"startedAt,omitempty"`
+	CompletedAt *time.Time  `json:"completedAt,omitempty"`
+	Conditions  []Condition `json:"conditions,omitempty"`
+}
+
+// ScheduleSpec describes a recurring backup
+type ScheduleSpec struct {
+	Cron     string     `json:"cron"`
+	Timezone string     `json:"timezone,omitempty"`
+	Suspend  bool       `json:"suspend,omitempty"`
+	Backup   BackupSpec `json:"backup"`
+}
+
+// BackupSchedule keeps a server-side schedule in line with its spec
+type BackupSchedule struct {
+	Metadata ObjectMeta     `json:"metadata"`
+	Spec     ScheduleSpec   `json:"spec"`
+	Status   ScheduleStatus `json:"status,omitempty"`
+}
+
+// ScheduleStatus reports a BackupSchedule
+type ScheduleStatus struct {
+	ScheduleID         string      `json:"scheduleID,omitempty"`
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	Conditions         []Condition `json:"conditions,omitempty"`
+}
+
+// RestoreSpec describes a restore. The backup is given by server ID or by
+// the name of a completed DatabaseBackup in the same namespace.
+type RestoreSpec struct {
+	BackupID       string `json:"backupID,omitempty"`
+	BackupRef      string `json:"backupRef,omitempty"`
+	Profile        string `json:"profile,omitempty"`
+	TargetDatabase string `json:"targetDatabase,omitempty"`
+}
+
+// DatabaseRestore requests one restore
+type DatabaseRestore struct {
+	Metadata ObjectMeta    `json:"metadata"`
+	Spec     RestoreSpec   `json:"spec"`
+	Status   RestoreStatus `json:"status,omitempty"`
+}
+
+// RestoreStatus reports a DatabaseRestore
+type RestoreStatus struct {
+	Phase       string      `json:"phase,omitempty"`
+	BackupID    string      `json:"backupID,omitempty"`
+	StartedAt   *time.Time  `json:"startedAt,omitempty"`
+	CompletedAt *time.Time  `json:"completedAt,omitempty"`
+	Conditions  []Condition `json:"conditions,omitempty"`
+}
+
+// setCondition adds or updates a condition, keeping its transition time
+// while the status is unchanged
+func setCondition(conditions []Condition, c Condition, now time.Time) []Condition {
+	c.LastTransitionTime = now
+	for i := range conditions {
+		if conditions[i].Type != c.Type {
+			continue
+		}
+		if conditions[i].Status == c.Status {
+			c.LastTransitionTime = conditions[i].LastTransitionTime
+		}
+		conditions[i] = c
+		return conditions
+	}
+	return append(conditions, c)
+}
+
+// findCondition returns the condition of the given type, nil when unset
+func findCondition(conditions []Condition, typ string) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == typ {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package pitr keeps the PostgreSQL WAL archive that point-in-time
+// restores replay. WAL reaches the archive either from pg_receivewal,
+// which streams it over a replication connection, or from the server's
+// archive_command handing over each segment it completes. Every segment
+// is listed in the archive manifest with its checksum; restore_command
+// checks it before handing a segment back to a recovering server, and a
+// restored data directory is set up to replay up to a target time.
+package pitr
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// walName matches the files of a WAL archive: segments, pg_receivewal's
+// partial segment, timeline history and backup history files
+var walName = regexp.MustCompile(`^([0-9A-F]{24}(\.partial|\.[0-9A-F]{8}\.backup)?|[0-9A-F]{8}\.history)$`)
+
+// CheckWALName refuses names that are not WAL archive files, and so could
+// not stay inside the archive directory
+func CheckWALName(name string) error {
+	if !walName.MatchString(name) {
+		return fmt.Errorf("%q is not a WAL file name", name)
+	}
+	return nil
+}
+
+// SystemIdentifier reads the system identifier of the cluster in dataDir
+// from its control file. Every WAL file carries it, so it tells clusters
+// apart even when they share a host and port.
+func SystemIdentifier(dataDir string) (string, error) {
+	f, err := os.Open(filepath.Join(dataDir, "global", "pg_control"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var id uint64
+	// The control file is written in the byte order of the server
+	if err := binary.Read(f, binary.NativeEndian, &id); err != nil {
+		return "", fmt.Errorf("reading pg_control: %w", err)
+	}
+	return strconv.FormatUint(id, 10), nil
+}
+
+// ArchiveWAL is the archive_command helper: it copies the WAL file at
+// path, as passed for %p, into the archive in dir and lists it in the
+// manifest. The server runs archive_command from its data directory, the
+// parent of pg_wal, and only recycles a file once the command succeeded,
+// so the copy is synced before it is listed. Archiving a file again with
+// the same content succeeds, as the server expects after a crash; with
+// other content it fails, so an archive is never overwritten.
+func ArchiveWAL(ctx context.Context, path, dir string) error {
+	name := filepath.Base(path)
+	if err := CheckWALName(name); err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	server, err := SystemIdentifier(filepath.Dir(filepath.Dir(abs)))
+	if err != nil {
+		return fmt.Errorf("identifying the cluster: %w", err)
+	}
+	archive, err := database.OpenLogArchive(dir, database.LogManifest{
+		DatabaseType: database.DatabaseTypePostgreSQL,
+		Server:       server,
+	})
+	if err != nil {
+		return err
+	}
+
+	if listed, ok := archive.Lookup(name); ok {
+		checksum, _, err := stream.HashFile(ctx, path)
+		if err != nil {
+			return err
+		}
+		if checksum != listed.Checksum {
+			return fmt.Errorf("%s is already archived with other content", name)
+		}
+		return nil
+	}
+	if err := copyFile(ctx, path, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	_, err = archive.Add(ctx, name)
+	return err
+}
+
+// RestoreWAL is the restore_command helper: it copies the WAL file name
+// from the archive in dir to dest, as passed for %f and %p. Listed files
+// are checked against their checksum first. A segment pg_receivewal was
+// still writing is handed out from its partial file, which is padded to
+// the full segment size, so recovery can reach the last change streamed.
+// It returns an error wrapping os.ErrNotExist for files the archive lacks,
+// which tells the server the archive ends there.
+func RestoreWAL(ctx context.Context, dir, name, dest string) error {
+	if err := CheckWALName(name); err != nil {
+		return err
+	}
+	m, err := database.ReadLogManifest(dir)
+	if err != nil {
+		return err
+	}
+	src := filepath.Join(dir, name)
+	if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) {
+		src += ".partial"
+	}
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("%s is not archived: %w", name, err)
+	}
+	for _, f := range m.Files {
+		if f.Name != filepath.Base(src) {
+			continue
+		}
+		checksum, _, err := stream.HashFile(ctx, src)
+		if err != nil {
+			return err
+		}
+		if checksum != f.Checksum {
+			return fmt.Errorf("%s: checksum mismatch", f.Name)
+		}
+	}
+	return copyFile(ctx, src, dest)
+}
+
+// copyFile copies src to dest through a temporary file, synced before it
+// is renamed into place
+func copyFile(ctx context.Context, src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = stream.Copy(ctx, out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(dest))
+}
+
+// syncDir makes the entries of dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
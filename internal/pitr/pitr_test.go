@@ -0,0 +1,156 @@
+package pitr
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+const (
+	seg1 = "000000010000000000000001"
+	seg2 = "000000010000000000000002"
+)
+
+// dataDir creates a data directory of the cluster id with WAL files
+func dataDir(t *testing.T, id uint64, wal map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "global"), 0o700)
+	os.MkdirAll(filepath.Join(dir, "pg_wal"), 0o700)
+	control := binary.NativeEndian.AppendUint64(nil, id)
+	if err := os.WriteFile(filepath.Join(dir, "global", "pg_control"), append(control, make([]byte, 64)...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "PG_VERSION"), []byte("16\n"), 0o600)
+	for name, data := range wal {
+		os.WriteFile(filepath.Join(dir, "pg_wal", name), []byte(data), 0o600)
+	}
+	return dir
+}
+
+func TestArchiveAndRestoreWAL(t *testing.T) {
+	ctx := context.Background()
+	data := dataDir(t, 7301284561927351234, map[string]string{seg1: "wal one", seg2: "wal two"})
+	archive := filepath.Join(t.TempDir(), "wal")
+
+	for _, name := range []string{seg1, seg2, seg1} {
+		if err := ArchiveWAL(ctx, filepath.Join(data, "pg_wal", name), archive); err != nil {
+			t.Fatalf("archiving %s: %v", name, err)
+		}
+	}
+	m, err := database.ReadLogManifest(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Server != "7301284561927351234" || len(m.Files) != 2 || m.Checkpoint.File != seg2 {
+		t.Errorf("manifest = %+v", m)
+	}
+
+	// A segment recycled with other content must not replace the archived one
+	os.WriteFile(filepath.Join(data, "pg_wal", seg1), []byte("recycled"), 0o600)
+	if err := ArchiveWAL(ctx, filepath.Join(data, "pg_wal", seg1), archive); err == nil {
+		t.Error("archived other content over a segment")
+	}
+	other := dataDir(t, 42, map[string]string{seg2: "x"})
+	if err := ArchiveWAL(ctx, filepath.Join(other, "pg_wal", seg2), archive); err == nil {
+		t.Error("archived the WAL of another cluster")
+	}
+	if err := ArchiveWAL(ctx, filepath.Join(data, "pg_wal", "archive_status"), archive); err == nil {
+		t.Error("archived a file that is not WAL")
+	}
+
+	dest := filepath.Join(t.TempDir(), "RECOVERYXLOG")
+	if err := RestoreWAL(ctx, archive, seg2, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "wal two" {
+		t.Errorf("restored %q", got)
+	}
+	if err := RestoreWAL(ctx, archive, "000000010000000000000003", dest); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v for a segment past the archive", err)
+	}
+	// pg_receivewal leaves the segment it was writing partial
+	os.WriteFile(filepath.Join(archive, "000000010000000000000003.partial"), []byte("wal three"), 0o600)
+	if err := RestoreWAL(ctx, archive, "000000010000000000000003", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "wal three" {
+		t.Errorf("restored %q from the partial segment", got)
+	}
+	os.WriteFile(filepath.Join(archive, seg1), []byte("bit rot"), 0o600)
+	if err := RestoreWAL(ctx, archive, seg1, dest); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("err = %v for a damaged segment", err)
+	}
+	if err := RestoreWAL(ctx, archive, "../"+seg2, dest); err == nil {
+		t.Error("restored a file outside the archive")
+	}
+}
+
+func TestPrepareRecovery(t *testing.T) {
+	ctx := context.Background()
+	data := dataDir(t, 1, map[string]string{seg1: "wal"})
+	archive := t.TempDir()
+	if err := ArchiveWAL(ctx, filepath.Join(data, "pg_wal", seg1), archive); err != nil {
+		t.Fatal(err)
+	}
+	restored := dataDir(t, 1, nil)
+
+	if err := PrepareRecovery(restored, RecoveryOptions{ArchiveDir: archive, Target: time.Now().Add(time.Hour)}); err == nil || !strings.Contains(err.Error(), "before the target") {
+		t.Errorf("err = %v for a target past the archive", err)
+	}
+	if _, err := os.Stat(filepath.Join(restored, "recovery.signal")); !os.IsNotExist(err) {
+		t.Error("set up a recovery that cannot reach its target")
+	}
+
+	target := time.Date(2026, 10, 15, 9, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	err := PrepareRecovery(restored, RecoveryOptions{ArchiveDir: archive, Target: target, RestoreCommand: "cp /it's/%f %p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, _ := os.ReadFile(filepath.Join(restored, "postgresql.auto.conf"))
+	for _, want := range []string{
+		`restore_command = 'cp /it''s/%f %p'`,
+		`recovery_target_time = '2026-10-15 07:30:00+00'`,
+		`recovery_target_action = 'promote'`,
+	} {
+		if !strings.Contains(string(conf), want) {
+			t.Errorf("postgresql.auto.conf lacks %s:\n%s", want, conf)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(restored, "recovery.signal")); err != nil {
+		t.Error(err)
+	}
+
+	command, err := RestoreCommand("/backups/wal it's")
+	if err != nil || !strings.HasSuffix(command, ` pitr restore-wal %f %p --archive '/backups/wal it'\''s'`) {
+		t.Errorf("RestoreCommand = %s, %v", command, err)
+	}
+}
+
+func TestCurrentWAL(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{seg1, seg2 + ".partial", "00000001.history", database.LogManifestFile, "zz.tmp"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o600)
+	}
+	if current, err := currentWAL(dir); err != nil || current != seg2+".partial" {
+		t.Errorf("current = %q, %v", current, err)
+	}
+}
+
+func TestReceiverArgs(t *testing.T) {
+	r := &Receiver{Config: &database.ConnectionConfig{Host: "db1", Port: 5432, Username: "replicator"}}
+	args := strings.Join(r.args("/backups/wal", "db_backup"), " ")
+	if args != "-h db1 -p 5432 -U replicator -w --no-loop -D /backups/wal --slot=db_backup" {
+		t.Errorf("args = %s", args)
+	}
+	if err := r.Run(context.Background(), &database.ArchiveOptions{OutputDir: t.TempDir(), StartFile: seg1}); err == nil {
+		t.Error("accepted a start file")
+	}
+}
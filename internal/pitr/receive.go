@@ -0,0 +1,189 @@
+package pitr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/telemetry"
+)
+
+// OptionSlot is the archive option naming the replication slot
+// pg_receivewal streams from. The server keeps the WAL a slot has not
+// received, so nothing is lost while the receiver is down, at the price of
+// the disk it fills meanwhile.
+const OptionSlot = "slot"
+
+// reconnectDelay is the wait before reconnecting after the server dropped
+// the replication connection
+const reconnectDelay = 5 * time.Second
+
+// Receiver streams the WAL of a cluster into an archive with pg_receivewal
+type Receiver struct {
+	Config *database.ConnectionConfig
+	// Server is the system identifier of the cluster
+	Server string
+	// Env is the environment of pg_receivewal, holding the password
+	Env []string
+}
+
+// Run streams WAL into opts.OutputDir until ctx ends. Each checkpoint
+// lists the segments pg_receivewal has completed; the one it is writing
+// keeps the .partial suffix until then. pg_receivewal carries on from the
+// last segment in the directory, or else from the slot.
+func (r *Receiver) Run(ctx context.Context, opts *database.ArchiveOptions) error {
+	if opts.OutputDir == "" {
+		return errors.New("an output directory is required to archive WAL")
+	}
+	if opts.StartFile != "" {
+		return errors.New("pg_receivewal starts from the archive, the slot or the current WAL position; a start file cannot be chosen")
+	}
+	slot := opts.Options[OptionSlot]
+	archive, err := database.OpenLogArchive(opts.OutputDir, database.LogManifest{
+		DatabaseType: database.DatabaseTypePostgreSQL,
+		Server:       r.Server,
+	})
+	if err != nil {
+		return err
+	}
+	interval := opts.CheckpointInterval
+	if interval <= 0 {
+		interval = database.DefaultCheckpointInterval
+	}
+
+	if slot != "" {
+		cmd := exec.CommandContext(ctx, "pg_receivewal", append(r.args(archive.Dir(), slot), "--create-slot", "--if-not-exists")...)
+		cmd.Env = r.Env
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("creating slot %s failed: %w: %s", slot, err, strings.TrimSpace(string(out)))
+		}
+	}
+	for {
+		progressed, err := r.stream(ctx, archive, slot, interval, opts.OnCheckpoint)
+		if ctx.Err() != nil {
+			return err
+		}
+		// A connection that never got anywhere will not get further
+		var exit *exec.ExitError
+		if !progressed || !errors.As(err, &exit) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// stream runs pg_receivewal until it exits or ctx ends, checkpointing the
+// archive every interval and once more at the end. It reports whether the
+// archive moved on.
+func (r *Receiver) stream(ctx context.Context, archive *database.LogArchive, slot string, interval time.Duration, onCheckpoint func(database.LogCheckpoint) error) (bool, error) {
+	var before database.LogCheckpoint
+	if cp := archive.Manifest().Checkpoint; cp != nil {
+		before = *cp
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, "pg_receivewal", r.args(archive.Dir(), slot)...)
+	cmd.Env = r.Env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	run := telemetry.StartCommand(ctx, cmd)
+	if err := cmd.Start(); err != nil {
+		run.End(err, -1)
+		return false, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var err error
+	for stopped := false; !stopped; {
+		select {
+		case err = <-done:
+			stopped = true
+		case <-ticker.C:
+			if err = checkpoint(ctx, archive, onCheckpoint); err != nil {
+				cancel()
+				<-done
+				run.End(err, -1)
+				return false, err
+			}
+		}
+	}
+	run.End(err, -1)
+
+	// The last checkpoint has to land even when ctx ended the stream
+	if cerr := checkpoint(context.WithoutCancel(ctx), archive, onCheckpoint); cerr != nil {
+		return false, cerr
+	}
+	cp := archive.Manifest().Checkpoint
+	progressed := cp != nil && (cp.File != before.File || cp.Size != before.Size)
+	if ctx.Err() != nil {
+		return progressed, nil
+	}
+	if err == nil {
+		err = errors.New("exited")
+	}
+	return progressed, fmt.Errorf("pg_receivewal failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+}
+
+// args builds the pg_receivewal arguments streaming into dir
+func (r *Receiver) args(dir, slot string) []string {
+	args := []string{
+		"-h", r.Config.Host,
+		"-p", fmt.Sprintf("%d", r.Config.Port),
+		"-U", r.Config.Username,
+		"-w",        // the password comes from PGPASSWORD, never a prompt
+		"--no-loop", // reconnections are retried here, after a checkpoint
+		"-D", dir,
+	}
+	if slot != "" {
+		args = append(args, "--slot="+slot)
+	}
+	return args
+}
+
+// checkpoint checkpoints the archive at the WAL file being written, if any
+func checkpoint(ctx context.Context, archive *database.LogArchive, onCheckpoint func(database.LogCheckpoint) error) error {
+	current, err := currentWAL(archive.Dir())
+	if err != nil || current == "" {
+		return err
+	}
+	cp, err := archive.Checkpoint(ctx, current)
+	if err != nil {
+		return fmt.Errorf("checkpoint failed: %w", err)
+	}
+	if onCheckpoint != nil {
+		return onCheckpoint(*cp)
+	}
+	return nil
+}
+
+// currentWAL returns the last WAL file in dir, the one being written, or
+// "" before the first one appears. Between segments it is the last one
+// completed, listed once the next one starts.
+func currentWAL(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var current string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && walName.MatchString(name) && name > current {
+			current = name
+		}
+	}
+	return current, nil
+}
@@ -0,0 +1,104 @@
+package pitr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// RecoveryOptions holds the options of a point-in-time recovery
+type RecoveryOptions struct {
+	// ArchiveDir is the WAL archive replayed from
+	ArchiveDir string
+	// Target is the time recovery stops at: the last transaction replayed
+	// is the last one committed at or before it
+	Target time.Time
+	// RestoreCommand fetches WAL from the archive; db-backup pitr
+	// restore-wal by default
+	RestoreCommand string
+}
+
+// PrepareRecovery sets up the restored data directory dataDir to replay
+// the WAL archive up to opts.Target when a server is started on it, then
+// promote itself. The archive must reach the target: a server that runs
+// out of WAL before it would stop short, at an earlier point than asked.
+func PrepareRecovery(dataDir string, opts RecoveryOptions) error {
+	if _, err := os.Stat(filepath.Join(dataDir, "PG_VERSION")); err != nil {
+		return fmt.Errorf("%s is not a PostgreSQL data directory: %w", dataDir, err)
+	}
+	if err := CheckTarget(opts.ArchiveDir, opts.Target); err != nil {
+		return err
+	}
+
+	command := opts.RestoreCommand
+	if command == "" {
+		var err error
+		if command, err = RestoreCommand(opts.ArchiveDir); err != nil {
+			return err
+		}
+	}
+	settings := fmt.Sprintf("\n# Point-in-time recovery, set up by db-backup\nrestore_command = %s\nrecovery_target_time = %s\nrecovery_target_action = 'promote'\n",
+		confQuote(command), confQuote(opts.Target.UTC().Format("2006-01-02 15:04:05.999999-07")))
+
+	f, err := os.OpenFile(filepath.Join(dataDir, "postgresql.auto.conf"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(settings); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// The server enters targeted recovery when it finds recovery.signal
+	return os.WriteFile(filepath.Join(dataDir, "recovery.signal"), nil, 0o600)
+}
+
+// CheckTarget checks the WAL archive in dir reaches target
+func CheckTarget(dir string, target time.Time) error {
+	m, err := database.ReadLogManifest(dir)
+	if err != nil {
+		return fmt.Errorf("reading the WAL archive: %w", err)
+	}
+	if m.DatabaseType != database.DatabaseTypePostgreSQL {
+		return fmt.Errorf("%s holds the log of %s, not WAL", dir, m.DatabaseType)
+	}
+	if reached := m.ArchivedUntil(); reached.Before(target) {
+		return fmt.Errorf("the WAL archive reaches %s, before the target %s", reached.Format(time.RFC3339), target.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RestoreCommand returns the restore_command running this executable's
+// restore-wal helper on the archive in dir
+func RestoreCommand(dir string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s pitr restore-wal %%f %%p --archive %s", shellQuote(exe), shellQuote(abs)), nil
+}
+
+// confQuote quotes s as a postgresql.conf string
+func confQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// shellQuote quotes s for the shell the server runs restore_command with
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("/._-+:=", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
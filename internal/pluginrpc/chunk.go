@@ -0,0 +1,67 @@
+package pluginrpc
+
+// ChunkWriter sends what is written to it in messages of up to ChunkSize
+// bytes. Flush sends what is buffered once writing is done.
+type ChunkWriter struct {
+	send func(data []byte) error
+	buf  []byte
+}
+
+// NewChunkWriter creates a writer sending its data with send
+func NewChunkWriter(send func(data []byte) error) *ChunkWriter {
+	return &ChunkWriter{send: send}
+}
+
+func (w *ChunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, ChunkSize)
+		}
+		n := min(len(p), ChunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p, written = p[n:], written+n
+		if len(w.buf) == ChunkSize {
+			if err := w.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush sends the buffered data
+func (w *ChunkWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.send(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// ChunkReader reads the data of the messages received until the stream
+// ends
+type ChunkReader struct {
+	recv func() ([]byte, error)
+	buf  []byte
+}
+
+// NewChunkReader creates a reader of first, the data of a message already
+// received, and then of the messages recv returns
+func NewChunkReader(first []byte, recv func() ([]byte, error)) *ChunkReader {
+	return &ChunkReader{recv: recv, buf: first}
+}
+
+func (r *ChunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		data, err := r.recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,128 @@
+// Package pluginrpc is the transport shared by db-backup's plugins, the
+// database drivers and storage providers shipped as separate executables.
+//
+// The protocol follows hashicorp/go-plugin: the host starts the plugin with
+// a magic cookie in its environment, the plugin answers with one handshake
+// line on stdout,
+//
+//	CORE-PROTOCOL-VERSION|APP-PROTOCOL-VERSION|NETWORK|ADDRESS|grpc
+//
+// and then serves gRPC at ADDRESS until the host closes its stdin.
+// Messages are gob encoded like the agent protocol's, so plugins must be
+// built with the Serve functions of pkg/driverplugin or pkg/storageplugin.
+package pluginrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// CookieKey is the environment variable holding the magic cookie. A plugin
+// started without its kind's cookie refuses to run.
+const CookieKey = "DBBACKUP_PLUGIN_MAGIC_COOKIE"
+
+// coreVersion is the version of the handshake itself
+const coreVersion = 1
+
+// ChunkSize is the most data sent in one stream message
+const ChunkSize = 1 << 20
+
+// Protocol identifies a kind of plugin. The host refuses plugins speaking
+// another version of it.
+type Protocol struct {
+	Kind    string // what the plugin serves, e.g. "driver", in messages
+	Cookie  string // value of CookieKey the plugin is started with
+	Version int
+}
+
+// Codec encodes messages with gob
+type Codec struct{}
+
+func (Codec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode %T: %w", v, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (Codec) Unmarshal(data []byte, v any) error {
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %T: %w", v, err)
+	}
+	return nil
+}
+
+func (Codec) Name() string { return "gob" }
+
+// Done answers calls that return nothing and is the request of calls that
+// take nothing. gob cannot encode a struct without exported fields.
+type Done struct {
+	OK bool
+}
+
+// Unary describes a call taking a Req and returning a Resp, served by fn
+// on the server registered with the service, of type S
+func Unary[S, Req, Resp any](name string, fn func(s S, ctx context.Context, req *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return fn(srv.(S), ctx, req)
+		},
+	}
+}
+
+// nameRe is what the name after a plugin's prefix may look like
+var nameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Find returns the executables in dir named prefix<name>, plus .exe on
+// Windows, by name. A missing directory holds no plugins.
+func Find(dir, prefix string) (map[string]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	plugins := map[string]string{}
+	for _, e := range entries {
+		file := e.Name()
+		if runtime.GOOS == "windows" {
+			if !strings.HasSuffix(file, ".exe") {
+				continue
+			}
+			file = strings.TrimSuffix(file, ".exe")
+		}
+		name, ok := strings.CutPrefix(file, prefix)
+		if !ok || !nameRe.MatchString(name) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		plugins[name] = path
+	}
+	return plugins, nil
+}
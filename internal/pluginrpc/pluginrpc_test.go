@@ -0,0 +1,72 @@
+package pluginrpc
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	for line, ok := range map[string]bool{
+		"1|1|unix|/tmp/p.sock|grpc\n": true,
+		"1|2|unix|/tmp/p.sock|grpc\n": false,
+		"2|1|unix|/tmp/p.sock|grpc\n": false,
+		"1|1|unix|/tmp/p.sock|netrpc": false,
+		"1|1|pipe|p|grpc":             false,
+		"hello":                       false,
+	} {
+		proc := Process{protocol: Protocol{Kind: "test", Version: 1}}
+		if err := proc.handshake(line); (err == nil) != ok {
+			t.Errorf("handshake(%q) = %v", line, err)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	var chunks [][]byte
+	w := NewChunkWriter(func(data []byte) error {
+		chunks = append(chunks, bytes.Clone(data))
+		return nil
+	})
+	data := bytes.Repeat([]byte("0123456789"), ChunkSize/4)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 || len(chunks[0]) != ChunkSize || len(chunks[2]) != len(data)-2*ChunkSize {
+		t.Fatalf("sent %d chunks", len(chunks))
+	}
+
+	r := NewChunkReader(chunks[0], func() ([]byte, error) {
+		chunks = chunks[1:]
+		if len(chunks) == 0 {
+			return nil, io.EOF
+		}
+		return chunks[0], nil
+	})
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes, %v", len(got), err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "dbbackup-test-acme"), nil, 0o755)
+	os.WriteFile(filepath.Join(dir, "dbbackup-test-notes"), []byte("not executable"), 0o644)
+	os.WriteFile(filepath.Join(dir, "dbbackup-test-Bad-Name"), nil, 0o755)
+	os.WriteFile(filepath.Join(dir, "dbbackup-other-acme"), nil, 0o755)
+	os.Mkdir(filepath.Join(dir, "dbbackup-test-dir"), 0o755)
+
+	plugins, err := Find(dir, "dbbackup-test-")
+	if err != nil || len(plugins) != 1 || plugins["acme"] == "" {
+		t.Errorf("Find() = %v, %v", plugins, err)
+	}
+	if plugins, err := Find(filepath.Join(dir, "missing"), "dbbackup-test-"); err != nil || len(plugins) != 0 {
+		t.Errorf("Find() of a missing directory = %v, %v", plugins, err)
+	}
+}
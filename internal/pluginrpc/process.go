@@ -0,0 +1,138 @@
+package pluginrpc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// StopTimeout bounds how long a plugin gets to exit after its stdin is
+// closed before it is killed
+const StopTimeout = 5 * time.Second
+
+// Process is a running plugin
+type Process struct {
+	protocol Protocol
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	exited   chan struct{}
+	network  string
+	addr     string
+}
+
+// Start runs the plugin at path and waits up to timeout for its handshake.
+// What the plugin prints after the handshake goes to stderr, so it cannot
+// mix with the host's output.
+func (p Protocol) Start(path string, timeout time.Duration) (*Process, error) {
+	cmd := exec.Command(path) // #nosec G204 -- executable from the plugins directory
+	cmd.Env = append(os.Environ(), CookieKey+"="+p.Cookie)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// Wait closes pipes made by StdoutPipe, which could cut off the
+	// handshake of a plugin that exits right after it
+	stdout, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+	proc := &Process{protocol: p, cmd: cmd, stdin: stdin, exited: make(chan struct{})}
+	go func() {
+		_ = cmd.Wait()
+		close(proc.exited)
+	}()
+
+	lines := make(chan string, 1)
+	go func() {
+		defer stdout.Close()
+		br := bufio.NewReader(stdout)
+		line, _ := br.ReadString('\n')
+		lines <- line
+		_, _ = io.Copy(os.Stderr, br)
+	}()
+
+	select {
+	case line := <-lines:
+		if line == "" {
+			<-proc.exited
+			return nil, fmt.Errorf("plugin exited before the handshake: %v", cmd.ProcessState)
+		}
+		if err := proc.handshake(line); err != nil {
+			proc.Stop()
+			return nil, err
+		}
+		return proc, nil
+	case <-proc.exited:
+		return nil, fmt.Errorf("plugin exited before the handshake: %v", cmd.ProcessState)
+	case <-time.After(timeout):
+		proc.Stop()
+		return nil, fmt.Errorf("no handshake from plugin within %s", timeout)
+	}
+}
+
+// handshake parses the plugin's handshake line
+func (proc *Process) handshake(line string) error {
+	parts := strings.Split(strings.TrimSpace(line), "|")
+	if len(parts) != 5 {
+		return fmt.Errorf("invalid handshake %q", strings.TrimSpace(line))
+	}
+	if v, err := strconv.Atoi(parts[0]); err != nil || v != coreVersion {
+		return fmt.Errorf("unsupported core protocol version %q", parts[0])
+	}
+	if v, err := strconv.Atoi(parts[1]); err != nil || v != proc.protocol.Version {
+		return fmt.Errorf("plugin speaks %s protocol version %s, want %d", proc.protocol.Kind, parts[1], proc.protocol.Version)
+	}
+	switch parts[2] {
+	case "unix", "tcp":
+	default:
+		return fmt.Errorf("unsupported plugin network %q", parts[2])
+	}
+	if parts[4] != "grpc" {
+		return fmt.Errorf("unsupported plugin protocol %q", parts[4])
+	}
+	proc.network, proc.addr = parts[2], parts[3]
+	return nil
+}
+
+// Dial connects to the plugin's gRPC server. Calls carry the caller's trace
+// context.
+func (proc *Process) Dial() (*grpc.ClientConn, error) {
+	return grpc.NewClient("passthrough:///"+proc.addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, proc.network, proc.addr)
+		}),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(Codec{})),
+		grpc.WithStatsHandler(traceHandler{client: true}),
+	)
+}
+
+// Stop closes the plugin's stdin, which asks it to exit, and kills it if it
+// is still running after StopTimeout
+func (proc *Process) Stop() {
+	proc.stdin.Close()
+	select {
+	case <-proc.exited:
+	case <-time.After(StopTimeout):
+		_ = proc.cmd.Process.Kill()
+		<-proc.exited
+	}
+}
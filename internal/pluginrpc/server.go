@@ -0,0 +1,69 @@
+package pluginrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"google.golang.org/grpc"
+)
+
+// Serve runs the plugin side of the protocol, serving desc with impl until
+// the host closes stdin or sends SIGTERM. It exits the process when not
+// started by a host.
+func (p Protocol) Serve(desc *grpc.ServiceDesc, impl any) error {
+	if os.Getenv(CookieKey) != p.Cookie {
+		fmt.Fprintf(os.Stderr, "This binary is a db-backup %s plugin. It is started by db-backup\n"+
+			"when found in plugins.directory and cannot be run directly.\n", p.Kind)
+		os.Exit(1)
+	}
+	// Interrupts from the terminal reach the whole process group; the host
+	// handles them and stops the plugin
+	signal.Ignore(os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	return p.serve(ctx, desc, impl, os.Stdin, os.Stdout)
+}
+
+// serve listens on a socket in a private directory, writes the handshake
+// to out and serves desc until ctx is done or in reaches EOF
+func (p Protocol) serve(ctx context.Context, desc *grpc.ServiceDesc, impl any, in io.Reader, out io.Writer) error {
+	dir, err := os.MkdirTemp("", "dbbackup-plugin-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "plugin.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	s := grpc.NewServer(grpc.ForceServerCodec(Codec{}), grpc.StatsHandler(traceHandler{}))
+	s.RegisterService(desc, impl)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		_, _ = io.Copy(io.Discard, in)
+		cancel()
+	}()
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	if _, err := fmt.Fprintf(out, "%d|%d|unix|%s|grpc\n", coreVersion, p.Version, socket); err != nil {
+		return err
+	}
+	if err := s.Serve(lis); err != nil && err != grpc.ErrServerStopped {
+		return err
+	}
+	return nil
+}
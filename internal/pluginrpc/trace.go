@@ -0,0 +1,61 @@
+package pluginrpc
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+)
+
+// propagator carries the host's trace context to the plugin. It is fixed
+// rather than the global one since a plugin never sets up telemetry.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// traceHandler injects the caller's trace context into the metadata of
+// every call on the host and extracts it into the handler's context in the
+// plugin, so spans a plugin starts join the backup's trace
+type traceHandler struct {
+	client bool
+}
+
+func (h traceHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	if h.client {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		propagator.Inject(ctx, metadataCarrier(md))
+		return metadata.NewOutgoingContext(ctx, md)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return propagator.Extract(ctx, metadataCarrier(md))
+}
+
+func (traceHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (traceHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (traceHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// metadataCarrier adapts gRPC metadata to a propagation carrier
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,177 @@
+// Package quota enforces the storage quotas of tenants and databases. A
+// quota caps the stored bytes and the number of backups counted against
+// it; usage is summed over the catalog when a backup starts, so a backup
+// is refused once a limit it counts against is reached and warned about
+// as usage nears one.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// Usage states
+const (
+	StatusOK       = "ok"
+	StatusWarning  = "warning"
+	StatusExceeded = "exceeded"
+)
+
+// ErrExceeded is returned when a backup would go beyond a limit
+var ErrExceeded = errors.New("storage quota exceeded")
+
+// Backup is a backup counted against the quotas
+type Backup struct {
+	ID           string
+	Database     string
+	DatabaseType string
+	Size         int64 // stored bytes
+	Tags         map[string]string
+}
+
+// Source lists the backups in the catalog
+type Source func(ctx context.Context) ([]Backup, error)
+
+// Usage is how much of one limit is used
+type Usage struct {
+	Name           string  `json:"name"`
+	Backups        int     `json:"backups"`
+	Bytes          int64   `json:"bytes"`
+	MaxBackups     int     `json:"max_backups,omitempty"`
+	MaxBytes       int64   `json:"max_bytes,omitempty"`
+	BackupsPercent float64 `json:"backups_percent,omitempty"`
+	BytesPercent   float64 `json:"bytes_percent,omitempty"`
+	Status         string  `json:"status"`
+}
+
+// Percent is the larger of the used shares of the limit
+func (u Usage) Percent() float64 {
+	return max(u.BackupsPercent, u.BytesPercent)
+}
+
+// String describes the usage, e.g. "acme: 412.0 GB of 500.0 GB, 180 of 200 backups"
+func (u Usage) String() string {
+	var parts []string
+	if u.MaxBytes > 0 {
+		parts = append(parts, fmt.Sprintf("%s of %s", utils.FormatBytes(u.Bytes), utils.FormatBytes(u.MaxBytes)))
+	}
+	if u.MaxBackups > 0 {
+		parts = append(parts, fmt.Sprintf("%d of %d backups", u.Backups, u.MaxBackups))
+	}
+	return u.Name + ": " + strings.Join(parts, ", ")
+}
+
+// limit is a configured limit with its size parsed
+type limit struct {
+	config.QuotaLimit
+	maxBytes int64
+}
+
+// Manager sums the usage of the configured limits
+type Manager struct {
+	warnPercent float64
+	limits      []limit
+	source      Source
+}
+
+// New creates a manager of the limits of cfg, counting the backups source
+// lists
+func New(cfg config.QuotaConfig, source Source) (*Manager, error) {
+	m := &Manager{warnPercent: cfg.WarnPercent, source: source}
+	if m.warnPercent <= 0 || m.warnPercent > 100 {
+		m.warnPercent = 80
+	}
+	for _, l := range cfg.Limits {
+		var maxBytes int64
+		if l.MaxSize != "" {
+			n, err := utils.ParseBytes(l.MaxSize)
+			if err != nil {
+				return nil, fmt.Errorf("quota %s: %w", l.Name, err)
+			}
+			maxBytes = n
+		}
+		m.limits = append(m.limits, limit{QuotaLimit: l, maxBytes: maxBytes})
+	}
+	return m, nil
+}
+
+// Enabled reports whether any limit is configured
+func (m *Manager) Enabled() bool {
+	return len(m.limits) > 0
+}
+
+// Usage reports the usage of every limit, in configuration order
+func (m *Manager) Usage(ctx context.Context) ([]Usage, error) {
+	backups, err := m.source(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	usages := make([]Usage, 0, len(m.limits))
+	for _, l := range m.limits {
+		usages = append(usages, m.usage(l, backups))
+	}
+	return usages, nil
+}
+
+// Check reports the usage of the limits a new backup of database db of
+// type dbType, tagged with tags, counts against. It fails with ErrExceeded
+// when one of them is already reached; limits past warn_percent have the
+// warning status.
+func (m *Manager) Check(ctx context.Context, db, dbType string, tags map[string]string) ([]Usage, error) {
+	var matched []limit
+	for _, l := range m.limits {
+		if l.Matches(db, dbType, tags) {
+			matched = append(matched, l)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, nil
+	}
+	backups, err := m.source(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	usages := make([]Usage, 0, len(matched))
+	var exceeded []string
+	for _, l := range matched {
+		u := m.usage(l, backups)
+		usages = append(usages, u)
+		if u.Status == StatusExceeded {
+			exceeded = append(exceeded, u.String())
+		}
+	}
+	if len(exceeded) > 0 {
+		return usages, fmt.Errorf("%w: %s", ErrExceeded, strings.Join(exceeded, "; "))
+	}
+	return usages, nil
+}
+
+// usage sums the backups counted against l
+func (m *Manager) usage(l limit, backups []Backup) Usage {
+	u := Usage{Name: l.Name, MaxBackups: l.MaxBackups, MaxBytes: l.maxBytes, Status: StatusOK}
+	for _, b := range backups {
+		if l.Matches(b.Database, b.DatabaseType, b.Tags) {
+			u.Backups++
+			u.Bytes += b.Size
+		}
+	}
+	if u.MaxBackups > 0 {
+		u.BackupsPercent = float64(u.Backups) / float64(u.MaxBackups) * 100
+	}
+	if u.MaxBytes > 0 {
+		u.BytesPercent = float64(u.Bytes) / float64(u.MaxBytes) * 100
+	}
+	switch {
+	case u.MaxBackups > 0 && u.Backups >= u.MaxBackups, u.MaxBytes > 0 && u.Bytes >= u.MaxBytes:
+		u.Status = StatusExceeded
+	case u.Percent() >= m.warnPercent:
+		u.Status = StatusWarning
+	}
+	return u
+}
@@ -0,0 +1,82 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func newTestManager(t *testing.T, backups []Backup) *Manager {
+	t.Helper()
+	m, err := New(config.QuotaConfig{
+		WarnPercent: 80,
+		Limits: []config.QuotaLimit{
+			{Name: "acme", Tags: map[string]string{"tenant": "acme"}, MaxSize: "1KB"},
+			{Name: "orders", Databases: []string{"orders*"}, MaxBackups: 3},
+		},
+	}, func(context.Context) ([]Backup, error) { return backups, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestUsage(t *testing.T) {
+	acme := map[string]string{"tenant": "acme"}
+	m := newTestManager(t, []Backup{
+		{ID: "1", Database: "orders", DatabaseType: "postgres", Size: 300, Tags: acme},
+		{ID: "2", Database: "orders_eu", DatabaseType: "postgres", Size: 300},
+		{ID: "3", Database: "billing", DatabaseType: "mysql", Size: 600, Tags: acme},
+	})
+
+	usages, err := m.Usage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 2 {
+		t.Fatalf("got %d usages, want 2", len(usages))
+	}
+	if u := usages[0]; u.Name != "acme" || u.Backups != 2 || u.Bytes != 900 || u.MaxBytes != 1024 || u.Status != StatusWarning {
+		t.Errorf("acme usage = %+v", u)
+	}
+	if u := usages[1]; u.Name != "orders" || u.Backups != 2 || u.BackupsPercent < 66 || u.Status != StatusOK {
+		t.Errorf("orders usage = %+v", u)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	acme := map[string]string{"tenant": "acme"}
+	m := newTestManager(t, []Backup{
+		{ID: "1", Database: "orders", Size: 100},
+		{ID: "2", Database: "orders", Size: 100},
+		{ID: "3", Database: "orders", Size: 100},
+		{ID: "4", Database: "billing", Size: 500, Tags: acme},
+	})
+	ctx := context.Background()
+
+	usages, err := m.Check(ctx, "billing", "mysql", acme)
+	if err != nil {
+		t.Fatalf("billing: %v", err)
+	}
+	if len(usages) != 1 || usages[0].Status != StatusOK {
+		t.Errorf("billing usages = %+v", usages)
+	}
+
+	if _, err := m.Check(ctx, "ORDERS", "postgres", nil); !errors.Is(err, ErrExceeded) {
+		t.Errorf("orders: got %v, want ErrExceeded", err)
+	}
+
+	usages, err = m.Check(ctx, "inventory", "postgres", nil)
+	if err != nil || usages != nil {
+		t.Errorf("unmatched database: got %+v, %v", usages, err)
+	}
+}
+
+func TestNewRejectsBadSize(t *testing.T) {
+	_, err := New(config.QuotaConfig{Limits: []config.QuotaLimit{{Name: "x", MaxSize: "lots"}}}, nil)
+	if err == nil {
+		t.Fatal("want an error for an unparsable max_size")
+	}
+}
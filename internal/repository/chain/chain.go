@@ -0,0 +1,478 @@
+// Package chain links the entries of the file repository's metadata
+// directory in a hash chain, so editing or deleting a backup's metadata
+// behind the repository's back is detectable. Every save of an entry
+// appends a link holding the SHA-256 of the entry's file and the hash of
+// the previous link. The head of the chain is anchored periodically on
+// write-once storage (see Anchor); truncating or rewriting the chain
+// itself then no longer matches what was anchored.
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/audit"
+	"github.com/sanskarpan/db-backup/internal/filelock"
+)
+
+// FileName is the chain file, kept in the metadata directory
+const FileName = ".chain.jsonl"
+
+// lockFileName serialises changes to the chain across processes. It is
+// not the chain itself, which is opened again to be appended to.
+const lockFileName = ".chain.lock"
+
+// anchorStateFile remembers the last anchored link. Like the chain, it
+// does not end in .json, so it is not taken for an entry.
+const anchorStateFile = ".chain.anchor"
+
+// Link operations
+const (
+	OpAdded   = "added"
+	OpUpdated = "updated"
+	OpRemoved = "removed"
+)
+
+// genesis is the predecessor hash of the first link
+const genesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// maxLinkSize bounds a single line of the chain
+const maxLinkSize = 64 * 1024
+
+// ErrBroken is returned when the hash chain does not verify
+var ErrBroken = errors.New("catalog hash chain is broken")
+
+// Link records one change to the catalog
+type Link struct {
+	Seq      int64     `json:"seq"`
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	BackupID string    `json:"backup_id"`
+	Digest   string    `json:"digest,omitempty"` // SHA-256 of the metadata file; empty when removed
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash,omitempty"`
+}
+
+// computeHash hashes the link without its own hash; PrevHash is part of
+// the input, which links the chain
+func (l Link) computeHash() (string, error) {
+	l.Hash = ""
+	data, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Chain is the hash chain of one metadata directory. Every Chain of the
+// directory, in this process or another, takes the same file lock around
+// reading and appending, so concurrent saves never link to the same head.
+type Chain struct {
+	dir string
+}
+
+// Open returns the chain of the metadata directory dir
+func Open(dir string) *Chain {
+	return &Chain{dir: dir}
+}
+
+// Path returns the chain file
+func (c *Chain) Path() string {
+	return filepath.Join(c.dir, FileName)
+}
+
+// lock takes the chain's file lock. A metadata directory that does not
+// exist yet holds no chain to guard.
+func (c *Chain) lock() (func() error, error) {
+	if _, err := os.Stat(c.dir); errors.Is(err, os.ErrNotExist) {
+		return func() error { return nil }, nil
+	}
+	return filelock.Lock(filepath.Join(c.dir, lockFileName))
+}
+
+// Record links the current state of a backup's metadata: added or updated
+// when its file exists, removed when it no longer does. Nothing is
+// appended when the chain already holds that state, and the last link of
+// the backup is returned.
+func (c *Chain) Record(backupID string) (Link, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return Link{}, err
+	}
+	defer unlock()
+
+	links, err := c.links()
+	if err != nil {
+		return Link{}, err
+	}
+	state := latest(links)
+	digest, err := c.digest(backupID)
+	if err != nil {
+		return Link{}, err
+	}
+
+	op := OpAdded
+	prev, known := state[backupID]
+	switch {
+	case known && prev.Digest == digest:
+		return prev, nil
+	case digest == "" && !known:
+		return Link{}, fmt.Errorf("backup %s is not in the catalog", backupID)
+	case digest == "":
+		op = OpRemoved
+	case known:
+		op = OpUpdated
+	}
+	return c.append(links, op, backupID, digest)
+}
+
+// Adopt adds every entry of the metadata directory that is not in the
+// chain yet, as after enabling the chain on an existing catalog, and
+// returns the links added. Entries the chain holds are left alone, so a
+// modified entry keeps failing verification.
+func (c *Chain) Adopt() ([]Link, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	links, err := c.links()
+	if err != nil {
+		return nil, err
+	}
+	state := latest(links)
+	ids, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+	var added []Link
+	for _, id := range ids {
+		if _, ok := state[id]; ok {
+			continue
+		}
+		digest, err := c.digest(id)
+		if err != nil || digest == "" {
+			continue
+		}
+		l, err := c.append(links, OpAdded, id, digest)
+		if err != nil {
+			return added, err
+		}
+		links = append(links, l)
+		added = append(added, l)
+	}
+	return added, nil
+}
+
+// Links returns every link in order
+func (c *Chain) Links() ([]Link, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return c.links()
+}
+
+// Head returns the newest link, or a zero link for an empty chain
+func (c *Chain) Head() (Link, error) {
+	links, err := c.Links()
+	if err != nil || len(links) == 0 {
+		return Link{}, err
+	}
+	return links[len(links)-1], nil
+}
+
+// Problem is a catalog entry that does not match the chain
+type Problem struct {
+	BackupID string `json:"backup_id"`
+	Problem  string `json:"problem"` // modified, deleted or unchained
+}
+
+// Report is the outcome of a verification
+type Report struct {
+	Links    int64     `json:"links"`
+	Entries  int       `json:"entries"` // backups the chain holds
+	Head     Link      `json:"head"`
+	Problems []Problem `json:"problems,omitempty"`
+}
+
+// Verify walks the chain, checking every hash and link, then compares the
+// state it records with the metadata directory. A broken chain fails with
+// an error wrapping ErrBroken; entries modified, deleted or added outside
+// the chain are listed in the report.
+func (c *Chain) Verify() (*Report, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	links, err := c.links()
+	if err != nil {
+		return nil, err
+	}
+	prev := Link{Hash: genesis}
+	for _, l := range links {
+		if err := verifyLink(prev, l); err != nil {
+			return nil, err
+		}
+		prev = l
+	}
+	r := &Report{Links: int64(len(links))}
+	if len(links) > 0 {
+		r.Head = links[len(links)-1]
+	}
+
+	state := latest(links)
+	ids, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		present[id] = true
+		l, ok := state[id]
+		if !ok || l.Op == OpRemoved {
+			r.Problems = append(r.Problems, Problem{id, "unchained"})
+			continue
+		}
+		digest, err := c.digest(id)
+		if err != nil {
+			return nil, err
+		}
+		if digest != l.Digest {
+			r.Problems = append(r.Problems, Problem{id, "modified"})
+		}
+	}
+	for id, l := range state {
+		if l.Op == OpRemoved {
+			continue
+		}
+		r.Entries++
+		if !present[id] {
+			r.Problems = append(r.Problems, Problem{id, "deleted"})
+		}
+	}
+	sort.Slice(r.Problems, func(i, j int) bool { return r.Problems[i].BackupID < r.Problems[j].BackupID })
+	return r, nil
+}
+
+// Anchor is the head of the chain as stored on write-once storage
+type Anchor struct {
+	Seq        int64     `json:"seq"`
+	Hash       string    `json:"hash"`
+	AnchoredAt time.Time `json:"anchored_at"`
+	Host       string    `json:"host"`
+}
+
+// Anchor stores the head of the chain in sink under prefix, retained until
+// retainUntil. It returns nil when the chain is empty or its head was
+// anchored already.
+func (c *Chain) Anchor(ctx context.Context, sink audit.Sink, prefix string, retainUntil, now time.Time) (*Anchor, string, error) {
+	head, err := c.Head()
+	if err != nil {
+		return nil, "", err
+	}
+	if head.Seq == 0 {
+		return nil, "", nil
+	}
+	var last Anchor
+	statePath := filepath.Join(c.dir, anchorStateFile)
+	if data, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(data, &last); err != nil {
+			return nil, "", fmt.Errorf("failed to parse catalog anchor state: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("failed to read catalog anchor state: %w", err)
+	}
+	if last.Seq == head.Seq && last.Hash == head.Hash {
+		return nil, "", nil
+	}
+	// The chain is only anchored when it still extends the last anchor
+	if last.Seq > 0 {
+		if err := c.VerifyAnchor(last); err != nil {
+			return nil, "", err
+		}
+	}
+
+	host, _ := os.Hostname()
+	a := &Anchor{Seq: head.Seq, Hash: head.Hash, AnchoredAt: now.UTC(), Host: host}
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	name := fmt.Sprintf("%s%s/catalog-%012d.json", prefix, now.UTC().Format("2006/01/02"), head.Seq)
+	if err := sink.Put(ctx, name, data, retainUntil); err != nil {
+		return nil, "", fmt.Errorf("failed to store catalog anchor: %w", err)
+	}
+	if err := writeFile(statePath, data); err != nil {
+		return nil, "", fmt.Errorf("failed to write catalog anchor state: %w", err)
+	}
+	return a, name, nil
+}
+
+// VerifyAnchor checks that the chain still holds the anchored link
+func (c *Chain) VerifyAnchor(a Anchor) error {
+	links, err := c.Links()
+	if err != nil {
+		return err
+	}
+	if a.Seq < 1 || a.Seq > int64(len(links)) {
+		return fmt.Errorf("%w: link %d anchored at %s is missing", ErrBroken, a.Seq, a.AnchoredAt.Format(time.RFC3339))
+	}
+	if l := links[a.Seq-1]; l.Seq != a.Seq || l.Hash != a.Hash {
+		return fmt.Errorf("%w: link %d differs from its anchor of %s", ErrBroken, a.Seq, a.AnchoredAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// verifyLink checks that l is intact and directly follows prev
+func verifyLink(prev, l Link) error {
+	if l.Seq != prev.Seq+1 {
+		return fmt.Errorf("%w: link %d follows link %d", ErrBroken, l.Seq, prev.Seq)
+	}
+	if l.PrevHash != prev.Hash {
+		return fmt.Errorf("%w: link %d does not link to link %d", ErrBroken, l.Seq, prev.Seq)
+	}
+	hash, err := l.computeHash()
+	if err != nil {
+		return err
+	}
+	if hash != l.Hash {
+		return fmt.Errorf("%w: link %d was modified", ErrBroken, l.Seq)
+	}
+	return nil
+}
+
+// latest returns the last link of each backup
+func latest(links []Link) map[string]Link {
+	state := make(map[string]Link)
+	for _, l := range links {
+		state[l.BackupID] = l
+	}
+	return state
+}
+
+// append writes a link after links. The caller holds the lock.
+func (c *Chain) append(links []Link, op, backupID, digest string) (Link, error) {
+	l := Link{Seq: 1, Time: time.Now().UTC(), Op: op, BackupID: backupID, Digest: digest, PrevHash: genesis}
+	if len(links) > 0 {
+		last := links[len(links)-1]
+		l.Seq, l.PrevHash = last.Seq+1, last.Hash
+	}
+	var err error
+	if l.Hash, err = l.computeHash(); err != nil {
+		return Link{}, err
+	}
+	line, err := json.Marshal(l)
+	if err != nil {
+		return Link{}, err
+	}
+	f, err := os.OpenFile(c.Path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return Link{}, fmt.Errorf("failed to open catalog chain: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return Link{}, fmt.Errorf("failed to write catalog chain: %w", err)
+	}
+	return l, f.Sync()
+}
+
+// links reads the chain. The caller holds the lock.
+func (c *Chain) links() ([]Link, error) {
+	f, err := os.Open(c.Path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog chain: %w", err)
+	}
+	defer f.Close()
+	return readLinks(f)
+}
+
+func readLinks(r io.Reader) ([]Link, error) {
+	var links []Link
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4*1024), maxLinkSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var l Link
+		if err := json.Unmarshal(data, &l); err != nil {
+			return nil, fmt.Errorf("%w: line %d is not a link: %v", ErrBroken, line, err)
+		}
+		links = append(links, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read catalog chain: %w", err)
+	}
+	return links, nil
+}
+
+// entries lists the backup IDs of the metadata files in the directory
+func (c *Chain) entries() ([]string, error) {
+	des, err := os.ReadDir(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, de := range des {
+		name := de.Name()
+		// Dot files are the repository's own, like its index
+		if de.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, ".json"))
+	}
+	return ids, nil
+}
+
+// digest returns the SHA-256 of a backup's metadata file, or "" when there
+// is none
+func (c *Chain) digest(backupID string) (string, error) {
+	if backupID == "" || strings.ContainsAny(backupID, `/\`) || strings.HasPrefix(backupID, ".") {
+		return "", fmt.Errorf("invalid backup ID %q", backupID)
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, backupID+".json")) // #nosec G304 -- entry of the configured metadata directory
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read metadata of %s: %w", backupID, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// writeFile writes data to path atomically
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,191 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/audit"
+)
+
+func writeEntry(t *testing.T, dir, id, data string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, id+".json"), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentRecord(t *testing.T) {
+	dir := t.TempDir()
+	const n = 100
+	for i := 0; i < n; i++ {
+		writeEntry(t, dir, fmt.Sprintf("b%d", i), fmt.Sprintf(`{"id":"b%d"}`, i))
+	}
+
+	// One chain per save, as every CLI run opens its own, all started at once
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, err := Open(dir).Record(fmt.Sprintf("b%d", i)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	r, err := Open(dir).Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Links != n || len(r.Problems) != 0 {
+		t.Fatalf("report = %+v", r)
+	}
+}
+
+func TestRecordAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	c := Open(dir)
+	writeEntry(t, dir, "b1", `{"id":"b1","size":10}`)
+	writeEntry(t, dir, "b2", `{"id":"b2","size":20}`)
+
+	for _, id := range []string{"b1", "b2", "b2"} {
+		if _, err := c.Record(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEntry(t, dir, "b1", `{"id":"b1","size":10,"tags":{"verified":"true"}}`)
+	if l, err := c.Record("b1"); err != nil || l.Op != OpUpdated || l.Seq != 3 {
+		t.Fatalf("update = %+v, %v", l, err)
+	}
+	os.Remove(filepath.Join(dir, "b2.json"))
+	if l, err := c.Record("b2"); err != nil || l.Op != OpRemoved || l.Digest != "" {
+		t.Fatalf("removal = %+v, %v", l, err)
+	}
+	if _, err := c.Record("b9"); err == nil {
+		t.Error("recorded a backup that is not in the catalog")
+	}
+	if _, err := c.Record("../b1"); err == nil {
+		t.Error("recorded a path outside the catalog")
+	}
+
+	r, err := c.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Links != 4 || r.Entries != 1 || r.Head.Seq != 4 || len(r.Problems) != 0 {
+		t.Errorf("report = %+v", r)
+	}
+
+	// Changes behind the repository's back
+	writeEntry(t, dir, "b1", `{"id":"b1","size":1}`)
+	writeEntry(t, dir, "b3", `{"id":"b3"}`)
+	writeEntry(t, dir, "b4", `{"id":"b4"}`)
+	c.Record("b4")
+	os.Remove(filepath.Join(dir, "b4.json"))
+	os.WriteFile(filepath.Join(dir, ".index.json"), []byte(`{}`), 0600)
+	r, err = c.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Problem{{"b1", "modified"}, {"b3", "unchained"}, {"b4", "deleted"}}
+	if len(r.Problems) != len(want) {
+		t.Fatalf("problems = %+v", r.Problems)
+	}
+	for i := range want {
+		if r.Problems[i] != want[i] {
+			t.Errorf("problem %d = %+v, want %+v", i, r.Problems[i], want[i])
+		}
+	}
+
+	added, err := c.Adopt()
+	if err != nil || len(added) != 1 || added[0].BackupID != "b3" {
+		t.Errorf("adopted %+v, %v", added, err)
+	}
+}
+
+func TestVerifyBrokenChain(t *testing.T) {
+	for name, edit := range map[string]func(lines []string) []string{
+		"modified": func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"b2"`, `"b9"`, 1)
+			return lines
+		},
+		"removed":   func(lines []string) []string { return append(lines[:1], lines[2:]...) },
+		"reordered": func(lines []string) []string { lines[0], lines[1] = lines[1], lines[0]; return lines },
+		"garbage":   func(lines []string) []string { return append(lines, "{") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := Open(dir)
+			for _, id := range []string{"b1", "b2", "b3"} {
+				writeEntry(t, dir, id, `{"id":"`+id+`"}`)
+				if _, err := c.Record(id); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data, _ := os.ReadFile(c.Path())
+			lines := edit(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			os.WriteFile(c.Path(), []byte(strings.Join(lines, "\n")+"\n"), 0600)
+			if _, err := c.Verify(); !errors.Is(err, ErrBroken) {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestAnchor(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	sink := &audit.DirSink{Dir: t.TempDir()}
+	c := Open(dir)
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	if a, _, err := c.Anchor(ctx, sink, "catalog/", now, now); err != nil || a != nil {
+		t.Fatalf("anchored an empty chain: %+v, %v", a, err)
+	}
+	writeEntry(t, dir, "b1", `{"id":"b1"}`)
+	writeEntry(t, dir, "b2", `{"id":"b2"}`)
+	c.Record("b1")
+	c.Record("b2")
+
+	a, name, err := c.Anchor(ctx, sink, "catalog/", now, now)
+	if err != nil || a == nil || a.Seq != 2 {
+		t.Fatalf("anchor = %+v, %v", a, err)
+	}
+	if name != "catalog/2026/10/15/catalog-000000000002.json" {
+		t.Errorf("name = %s", name)
+	}
+	if _, err := os.Stat(filepath.Join(sink.Dir, filepath.FromSlash(name))); err != nil {
+		t.Error(err)
+	}
+	if again, _, err := c.Anchor(ctx, sink, "catalog/", now, now); err != nil || again != nil {
+		t.Errorf("anchored the same head twice: %+v, %v", again, err)
+	}
+	if err := c.VerifyAnchor(*a); err != nil {
+		t.Error(err)
+	}
+
+	// A chain rebuilt from scratch no longer matches the anchor
+	os.Remove(c.Path())
+	c.Record("b2")
+	if err := c.VerifyAnchor(*a); !errors.Is(err, ErrBroken) {
+		t.Errorf("got %v", err)
+	}
+	c.Record("b1")
+	if err := c.VerifyAnchor(*a); !errors.Is(err, ErrBroken) {
+		t.Errorf("got %v", err)
+	}
+	if _, _, err := c.Anchor(ctx, sink, "catalog/", now, now.Add(time.Hour)); !errors.Is(err, ErrBroken) {
+		t.Errorf("anchored a rewritten chain: %v", err)
+	}
+}
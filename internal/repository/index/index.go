@@ -0,0 +1,307 @@
+// Package index keeps a compact on-disk index over the file repository's
+// metadata directory, so listing, filtering and summarising backups reads
+// one file instead of unmarshalling every backup's metadata JSON. Each
+// entry remembers the size and modification time of the file it came from;
+// Refresh only stats the directory and re-reads the files that changed,
+// so the index never has to be invalidated by hand and is rebuilt from
+// scratch when it is missing or unreadable.
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileName is the index file, kept in the metadata directory
+const FileName = ".index.json"
+
+// version is bumped when the index layout changes, discarding old indexes
+const version = 1
+
+// Entry is the part of a backup's metadata needed to list and summarise it
+type Entry struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name,omitempty"`
+	Database       string            `json:"database"`
+	DatabaseType   string            `json:"database_type"`
+	StorageType    string            `json:"storage_type,omitempty"`
+	Status         string            `json:"status,omitempty"`
+	Size           int64             `json:"size"`
+	CompressedSize int64             `json:"compressed_size,omitempty"`
+	StartTime      time.Time         `json:"start_time"`
+	Tags           map[string]string `json:"tags,omitempty"`
+}
+
+// DecodeFunc extracts an index entry from a metadata file's contents
+type DecodeFunc func(data []byte) (Entry, error)
+
+// record is an entry with the state of the file it was read from
+type record struct {
+	Entry
+	File    string    `json:"file"`
+	ModTime time.Time `json:"mod_time"`
+	Bytes   int64     `json:"bytes"`
+}
+
+type indexFile struct {
+	Version int       `json:"version"`
+	Records []*record `json:"records"`
+}
+
+// Index is the index of one metadata directory. It is safe for concurrent
+// use; several processes sharing a directory may each rewrite the index,
+// which only costs the loser a refresh on its next use.
+type Index struct {
+	dir    string
+	decode DecodeFunc
+
+	mu      sync.RWMutex
+	records map[string]*record // by file name
+}
+
+// Open loads the index of dir, starting empty when there is none yet.
+// Call Refresh to bring it up to date with the directory.
+func Open(dir string, decode DecodeFunc) (*Index, error) {
+	if decode == nil {
+		return nil, fmt.Errorf("index needs a decode function")
+	}
+	idx := &Index{dir: dir, decode: decode, records: make(map[string]*record)}
+
+	data, err := os.ReadFile(filepath.Join(dir, FileName)) // #nosec G304 -- index in the configured metadata directory
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, err
+	}
+	var f indexFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Version != version {
+		// A damaged or outdated index is rebuilt by the next refresh
+		return idx, nil
+	}
+	for _, r := range f.Records {
+		idx.records[r.File] = r
+	}
+	return idx, nil
+}
+
+// Refresh re-reads the metadata files added or changed since the index was
+// written, drops entries of deleted files and saves the index if anything
+// changed. Files that fail to decode are left out and retried next time.
+func (idx *Index) Refresh() (changed int, err error) {
+	dirEntries, err := os.ReadDir(idx.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	seen := make(map[string]bool, len(dirEntries))
+	for _, de := range dirEntries {
+		name := de.Name()
+		if de.IsDir() || name == FileName || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+		seen[name] = true
+		if r, ok := idx.records[name]; ok && r.Bytes == info.Size() && r.ModTime.Equal(info.ModTime()) {
+			continue
+		}
+
+		changed++
+		data, err := os.ReadFile(filepath.Join(idx.dir, name)) // #nosec G304 -- file listed from the metadata directory
+		if err != nil {
+			delete(idx.records, name)
+			continue
+		}
+		entry, err := idx.decode(data)
+		if err != nil {
+			delete(idx.records, name)
+			continue
+		}
+		idx.records[name] = &record{Entry: entry, File: name, ModTime: info.ModTime(), Bytes: info.Size()}
+	}
+	for name := range idx.records {
+		if !seen[name] {
+			delete(idx.records, name)
+			changed++
+		}
+	}
+
+	if changed > 0 {
+		if err := idx.save(); err != nil {
+			return changed, fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+	return changed, nil
+}
+
+// save writes the index through a temporary file so readers never see a
+// partial one. The caller holds the lock.
+func (idx *Index) save() error {
+	f := indexFile{Version: version, Records: make([]*record, 0, len(idx.records))}
+	for _, r := range idx.records {
+		f.Records = append(f.Records, r)
+	}
+	sort.Slice(f.Records, func(i, j int) bool { return f.Records[i].File < f.Records[j].File })
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(idx.dir, FileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(idx.dir, FileName))
+}
+
+// Len returns the number of indexed backups
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.records)
+}
+
+// Filter selects and orders entries. Empty fields match everything.
+type Filter struct {
+	Database     string
+	DatabaseType string
+	StorageType  string
+	Status       string
+	From         *time.Time
+	To           *time.Time
+	Tags         map[string]string
+	Limit        int
+	SortBy       string // date (default), size or name
+	SortOrder    string // desc (default) or asc
+}
+
+func (f *Filter) match(e *Entry) bool {
+	switch {
+	case f.Database != "" && e.Database != f.Database,
+		f.DatabaseType != "" && !strings.EqualFold(e.DatabaseType, f.DatabaseType),
+		f.StorageType != "" && !strings.EqualFold(e.StorageType, f.StorageType),
+		f.Status != "" && !strings.EqualFold(e.Status, f.Status),
+		f.From != nil && e.StartTime.Before(*f.From),
+		f.To != nil && e.StartTime.After(*f.To):
+		return false
+	}
+	for k, v := range f.Tags {
+		if e.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// List returns the entries matching filter, sorted and limited. A nil
+// filter returns every entry, newest first.
+func (idx *Index) List(filter *Filter) []Entry {
+	if filter == nil {
+		filter = &Filter{}
+	}
+
+	idx.mu.RLock()
+	entries := make([]Entry, 0, len(idx.records))
+	for _, r := range idx.records {
+		if filter.match(&r.Entry) {
+			entries = append(entries, r.Entry)
+		}
+	}
+	idx.mu.RUnlock()
+
+	var less func(a, b *Entry) bool
+	switch strings.ToLower(filter.SortBy) {
+	case "size":
+		less = func(a, b *Entry) bool { return a.Size < b.Size }
+	case "name":
+		less = func(a, b *Entry) bool { return a.Name < b.Name }
+	default:
+		less = func(a, b *Entry) bool { return a.StartTime.Before(b.StartTime) }
+	}
+	asc := strings.EqualFold(filter.SortOrder, "asc")
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if !less(a, b) && !less(b, a) {
+			// Ties in ID order keep listings stable between runs
+			return a.ID < b.ID
+		}
+		return less(a, b) == asc
+	})
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries
+}
+
+// Stats summarises a set of backups
+type Stats struct {
+	Count          int              `json:"count"`
+	TotalSize      int64            `json:"total_size"`
+	CompressedSize int64            `json:"compressed_size"`
+	Oldest         time.Time        `json:"oldest,omitempty"`
+	Newest         time.Time        `json:"newest,omitempty"`
+	ByDatabase     map[string]int   `json:"by_database"`
+	ByType         map[string]int   `json:"by_type"`
+	ByStatus       map[string]int   `json:"by_status"`
+	SizeByDatabase map[string]int64 `json:"size_by_database"`
+}
+
+// Stats summarises the entries matching filter, ignoring its limit
+func (idx *Index) Stats(filter *Filter) Stats {
+	s := Stats{
+		ByDatabase:     make(map[string]int),
+		ByType:         make(map[string]int),
+		ByStatus:       make(map[string]int),
+		SizeByDatabase: make(map[string]int64),
+	}
+	if filter == nil {
+		filter = &Filter{}
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for _, r := range idx.records {
+		e := &r.Entry
+		if !filter.match(e) {
+			continue
+		}
+		s.Count++
+		s.TotalSize += e.Size
+		s.CompressedSize += e.CompressedSize
+		if s.Oldest.IsZero() || e.StartTime.Before(s.Oldest) {
+			s.Oldest = e.StartTime
+		}
+		if e.StartTime.After(s.Newest) {
+			s.Newest = e.StartTime
+		}
+		s.ByDatabase[e.Database]++
+		s.ByType[e.DatabaseType]++
+		if e.Status != "" {
+			s.ByStatus[e.Status]++
+		}
+		s.SizeByDatabase[e.Database] += e.Size
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newDecoder counts decode calls to show which files were re-read
+func newDecoder(decodes *int) DecodeFunc {
+	return func(data []byte) (Entry, error) {
+		*decodes++
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return e, err
+		}
+		if e.ID == "" {
+			return e, errors.New("no id")
+		}
+		return e, nil
+	}
+}
+
+func writeMeta(t *testing.T, dir string, e Entry) {
+	t.Helper()
+	data, _ := json.Marshal(e)
+	if err := os.WriteFile(filepath.Join(dir, e.ID+".json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshOnlyRereadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for i, db := range []string{"orders", "orders", "users"} {
+		writeMeta(t, dir, Entry{ID: string(rune('a' + i)), Database: db, DatabaseType: "postgres", Size: int64(i+1) * 100, StartTime: base.Add(time.Duration(i) * time.Hour)})
+	}
+
+	var decodes int
+	idx, err := Open(dir, newDecoder(&decodes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := idx.Refresh(); err != nil || n != 3 || decodes != 3 {
+		t.Fatalf("first Refresh() = %d, %v with %d decodes", n, err, decodes)
+	}
+
+	// A new process loads the saved index and reads nothing
+	decodes = 0
+	idx, _ = Open(dir, newDecoder(&decodes))
+	if idx.Len() != 3 {
+		t.Fatalf("reopened index has %d entries", idx.Len())
+	}
+	if n, _ := idx.Refresh(); n != 0 || decodes != 0 {
+		t.Fatalf("unchanged Refresh() = %d with %d decodes", n, decodes)
+	}
+
+	// One file changed, one removed, one added
+	writeMeta(t, dir, Entry{ID: "a", Database: "orders", DatabaseType: "postgres", Size: 999, StartTime: base})
+	os.Chtimes(filepath.Join(dir, "a.json"), base, base.Add(time.Minute))
+	os.Remove(filepath.Join(dir, "b.json"))
+	writeMeta(t, dir, Entry{ID: "d", Database: "users", DatabaseType: "mysql", Size: 50, StartTime: base.Add(5 * time.Hour)})
+	if n, _ := idx.Refresh(); n != 3 || decodes != 2 {
+		t.Fatalf("Refresh() after changes = %d with %d decodes", n, decodes)
+	}
+	if got := idx.List(&Filter{Database: "orders"}); len(got) != 1 || got[0].Size != 999 {
+		t.Fatalf("orders entries = %+v", got)
+	}
+}
+
+func TestBrokenFilesAreSkippedAndIndexRebuilt(t *testing.T) {
+	dir := t.TempDir()
+	writeMeta(t, dir, Entry{ID: "a", Database: "orders", StartTime: base})
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0600)
+	os.WriteFile(filepath.Join(dir, FileName), []byte("not json"), 0600)
+
+	var decodes int
+	idx, err := Open(dir, newDecoder(&decodes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Len() != 1 {
+		t.Fatalf("Len() = %d, want the one valid file", idx.Len())
+	}
+}
+
+func TestListFiltersAndSorts(t *testing.T) {
+	dir := t.TempDir()
+	entries := []Entry{
+		{ID: "1", Name: "c", Database: "orders", DatabaseType: "postgres", StorageType: "s3", Size: 300, StartTime: base, Tags: map[string]string{"env": "prod"}},
+		{ID: "2", Name: "a", Database: "orders", DatabaseType: "postgres", StorageType: "local", Size: 100, StartTime: base.Add(time.Hour), Tags: map[string]string{"env": "dev"}},
+		{ID: "3", Name: "b", Database: "users", DatabaseType: "mysql", StorageType: "s3", Size: 200, StartTime: base.Add(2 * time.Hour), Tags: map[string]string{"env": "prod"}},
+	}
+	for _, e := range entries {
+		writeMeta(t, dir, e)
+	}
+	var decodes int
+	idx, _ := Open(dir, newDecoder(&decodes))
+	idx.Refresh()
+
+	ids := func(es []Entry) (s string) {
+		for _, e := range es {
+			s += e.ID
+		}
+		return s
+	}
+	from := base.Add(30 * time.Minute)
+	cases := []struct {
+		filter *Filter
+		want   string
+	}{
+		{nil, "321"},
+		{&Filter{SortOrder: "asc"}, "123"},
+		{&Filter{SortBy: "size"}, "132"},
+		{&Filter{SortBy: "name", SortOrder: "asc"}, "231"},
+		{&Filter{DatabaseType: "POSTGRES"}, "21"},
+		{&Filter{StorageType: "s3", Limit: 1}, "3"},
+		{&Filter{From: &from}, "32"},
+		{&Filter{Tags: map[string]string{"env": "prod"}}, "31"},
+	}
+	for _, c := range cases {
+		if got := ids(idx.List(c.filter)); got != c.want {
+			t.Errorf("List(%+v) = %s, want %s", c.filter, got, c.want)
+		}
+	}
+
+	s := idx.Stats(&Filter{StorageType: "s3"})
+	if s.Count != 2 || s.TotalSize != 500 || s.ByDatabase["users"] != 1 || !s.Oldest.Equal(base) || !s.Newest.Equal(base.Add(2*time.Hour)) {
+		t.Fatalf("Stats() = %+v", s)
+	}
+}
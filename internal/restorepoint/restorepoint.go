@@ -0,0 +1,124 @@
+// Package restorepoint picks the backups that restore a database as it
+// was at a given moment: the newest full backup taken before it, the
+// incremental backups built on that one up to the moment, and the span of
+// transaction log to replay on top of the last of them.
+package restorepoint
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+// Tags marking an incremental backup and the backup it builds on. Backups
+// without TagType are full backups.
+const (
+	TagType = "backup_type" // full or incremental
+	TagBase = "base_backup" // ID of the backup an incremental one builds on
+)
+
+// TypeIncremental is the TagType of incremental backups
+const TypeIncremental = "incremental"
+
+// ErrNoBackup is returned when no full backup was taken before the moment
+var ErrNoBackup = errors.New("no full backup before the requested time")
+
+// Backup is a completed backup of the catalog
+type Backup struct {
+	ID          string
+	Database    string
+	Time        time.Time // when the backup was taken, its point in time
+	Incremental bool
+	Base        string // backup an incremental one builds on
+}
+
+// Plan is what restores a database as of a moment
+type Plan struct {
+	AsOf time.Time
+	// Backups are restored in order: a full backup, then the incremental
+	// backups built on it
+	Backups []Backup
+	// ReplayFrom is where the transaction log is replayed from, up to AsOf;
+	// zero when the last backup was taken at AsOf itself
+	ReplayFrom time.Time
+}
+
+// NeedsLogs reports whether the transaction log has to be replayed after
+// the backups
+func (p *Plan) NeedsLogs() bool {
+	return !p.ReplayFrom.IsZero()
+}
+
+// Last returns the last backup restored
+func (p *Plan) Last() Backup {
+	return p.Backups[len(p.Backups)-1]
+}
+
+// Select picks the backups of database restoring it as of asOf from
+// backups, which may hold those of other databases too
+func Select(backups []Backup, database string, asOf time.Time) (*Plan, error) {
+	var candidates []Backup
+	for _, b := range backups {
+		if b.Database == database && !b.Time.After(asOf) {
+			candidates = append(candidates, b)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Time.After(candidates[j].Time) })
+
+	plan := &Plan{AsOf: asOf}
+	for _, b := range candidates {
+		if !b.Incremental {
+			plan.Backups = []Backup{b}
+			break
+		}
+	}
+	if len(plan.Backups) == 0 {
+		return nil, fmt.Errorf("%w of %s (%s)", ErrNoBackup, database, asOf.UTC().Format(time.RFC3339))
+	}
+
+	// Follow the chain: the newest incremental backup built on the last
+	// one, until none is
+	for {
+		last := plan.Last()
+		next := -1
+		for i, b := range candidates {
+			if b.Incremental && b.Base == last.ID && b.Time.After(last.Time) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		plan.Backups = append(plan.Backups, candidates[next])
+	}
+
+	if asOf.After(plan.Last().Time) {
+		plan.ReplayFrom = plan.Last().Time
+	}
+	return plan, nil
+}
+
+// CheckLogs checks the log archive described by m holds the log of a
+// dbType server and reaches the plan's AsOf. Whether it goes back far
+// enough only shows when it is replayed: archives may start from files
+// the server held before they were created.
+func (p *Plan) CheckLogs(m *database.LogManifest, dbType database.DatabaseType) error {
+	logType := m.DatabaseType
+	if logType == database.DatabaseTypeMariaDB {
+		logType = database.DatabaseTypeMySQL
+	}
+	if dbType == database.DatabaseTypeMariaDB {
+		dbType = database.DatabaseTypeMySQL
+	}
+	if logType != dbType {
+		return fmt.Errorf("the log archive holds the log of %s, not %s", m.DatabaseType, dbType)
+	}
+	if until := m.ArchivedUntil(); until.Before(p.AsOf) {
+		return fmt.Errorf("the log archive reaches %s, before %s", until.UTC().Format(time.RFC3339), p.AsOf.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package restorepoint
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/database"
+)
+
+var day0 = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func at(hours int) time.Time {
+	return day0.Add(time.Duration(hours) * time.Hour)
+}
+
+func ids(p *Plan) []string {
+	var out []string
+	for _, b := range p.Backups {
+		out = append(out, b.ID)
+	}
+	return out
+}
+
+func TestSelect(t *testing.T) {
+	backups := []Backup{
+		{ID: "full-1", Database: "orders", Time: at(0)},
+		{ID: "inc-1a", Database: "orders", Time: at(6), Incremental: true, Base: "full-1"},
+		{ID: "inc-1b", Database: "orders", Time: at(12), Incremental: true, Base: "inc-1a"},
+		{ID: "full-2", Database: "orders", Time: at(24)},
+		{ID: "inc-2a", Database: "orders", Time: at(30), Incremental: true, Base: "full-2"},
+		{ID: "users", Database: "users", Time: at(20)},
+	}
+
+	tests := []struct {
+		name       string
+		asOf       time.Time
+		want       []string
+		replayFrom time.Time
+	}{
+		{"chain up to the moment", at(13), []string{"full-1", "inc-1a", "inc-1b"}, at(12)},
+		{"incremental after the moment skipped", at(7), []string{"full-1", "inc-1a"}, at(6)},
+		{"newest full backup", at(25), []string{"full-2"}, at(24)},
+		{"exactly at a backup", at(30), []string{"full-2", "inc-2a"}, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := Select(backups, "orders", tt.asOf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ids(plan)
+			if len(got) != len(tt.want) {
+				t.Fatalf("backups = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("backups = %v, want %v", got, tt.want)
+				}
+			}
+			if !plan.ReplayFrom.Equal(tt.replayFrom) || plan.NeedsLogs() != !tt.replayFrom.IsZero() {
+				t.Errorf("replay from %s, want %s", plan.ReplayFrom, tt.replayFrom)
+			}
+		})
+	}
+
+	if _, err := Select(backups, "orders", at(-1)); !errors.Is(err, ErrNoBackup) {
+		t.Errorf("before the first backup: %v, want ErrNoBackup", err)
+	}
+}
+
+func TestCheckLogs(t *testing.T) {
+	plan := &Plan{AsOf: at(13), Backups: []Backup{{ID: "full-1", Time: at(12)}}, ReplayFrom: at(12)}
+	m := &database.LogManifest{
+		DatabaseType: database.DatabaseTypeMySQL,
+		CreatedAt:    at(0),
+		Files:        []database.LogFile{{Name: "binlog.000001", ArchivedAt: at(10)}},
+		Checkpoint:   &database.LogCheckpoint{File: "binlog.000002", Time: at(14)},
+	}
+	if err := plan.CheckLogs(m, database.DatabaseTypeMariaDB); err != nil {
+		t.Errorf("covering archive: %v", err)
+	}
+	if err := plan.CheckLogs(m, database.DatabaseTypePostgreSQL); err == nil {
+		t.Error("binary log accepted for PostgreSQL")
+	}
+	m.Checkpoint = nil
+	if err := plan.CheckLogs(m, database.DatabaseTypeMySQL); err == nil {
+		t.Error("archive ending before the target accepted")
+	}
+}
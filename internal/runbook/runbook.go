@@ -0,0 +1,313 @@
+// Package runbook writes the recovery runbook of a database: where the
+// artifacts of its newest recovery point live, the keys and logins a
+// restore needs, the exact commands to run and how long restore
+// rehearsals took. Runbooks are rendered as JSON for tools and Markdown
+// for whoever is on call, and kept in a directory that stays readable
+// when the API server is down.
+package runbook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/restorepoint"
+)
+
+// Backup is a completed backup of the catalog
+type Backup struct {
+	restorepoint.Backup
+	Storage  string // storage provider
+	Location string
+	Size     int64
+	Checksum string
+}
+
+// Input is what a runbook is generated from
+type Input struct {
+	Database     string
+	DatabaseType string
+	// Backups are the completed backups of the catalog, which may hold
+	// those of other databases too
+	Backups []Backup
+	// Copies are the other locations holding a backup, by backup ID
+	Copies map[string][]string
+	// Profile is the connection profile restoring the database, empty
+	// without one
+	Profile     string
+	RestoreUser string
+	// EncryptionKey says where the key decrypting the artifacts is kept,
+	// empty when they are not encrypted
+	EncryptionKey string
+	// Estimate is the slowest recent restore rehearsal, from Rehearsals
+	// of them
+	Estimate   time.Duration
+	Rehearsals int
+	RTO        time.Duration
+	Now        time.Time
+}
+
+// Artifact is a backup restored by the runbook
+type Artifact struct {
+	BackupID    string    `json:"backup_id"`
+	Incremental bool      `json:"incremental,omitempty"`
+	TakenAt     time.Time `json:"taken_at"`
+	Storage     string    `json:"storage,omitempty"`
+	Location    string    `json:"location"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum,omitempty"`
+	Copies      []string  `json:"copies,omitempty"`
+}
+
+// Key is a secret a restore needs. Only where it is kept is recorded,
+// never the secret itself.
+type Key struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Purpose string `json:"purpose"`
+}
+
+// Step is one step of the recovery, with the command running it if any
+type Step struct {
+	Title    string `json:"title"`
+	Command  string `json:"command,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// Runbook is the recovery runbook of one database
+type Runbook struct {
+	Database      string        `json:"database"`
+	DatabaseType  string        `json:"database_type,omitempty"`
+	GeneratedAt   time.Time     `json:"generated_at"`
+	RecoveryPoint time.Time     `json:"recovery_point"` // restorable without replaying the log
+	Artifacts     []Artifact    `json:"artifacts"`
+	Keys          []Key         `json:"keys"`
+	Steps         []Step        `json:"steps"`
+	Estimate      time.Duration `json:"-"` // 0 without rehearsals
+	Rehearsals    int           `json:"rehearsals"`
+	RTO           time.Duration `json:"-"`
+}
+
+// MarshalJSON reports durations in seconds for tools
+func (r Runbook) MarshalJSON() ([]byte, error) {
+	type plain Runbook
+	return json.Marshal(struct {
+		plain
+		EstimateSeconds float64 `json:"expected_duration_seconds,omitempty"`
+		RTOSeconds      float64 `json:"rto_objective_seconds,omitempty"`
+	}{plain(r), r.Estimate.Seconds(), r.RTO.Seconds()})
+}
+
+// UnmarshalJSON reads what MarshalJSON writes
+func (r *Runbook) UnmarshalJSON(data []byte) error {
+	type plain Runbook
+	var in struct {
+		plain
+		EstimateSeconds float64 `json:"expected_duration_seconds"`
+		RTOSeconds      float64 `json:"rto_objective_seconds"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*r = Runbook(in.plain)
+	r.Estimate = time.Duration(in.EstimateSeconds * float64(time.Second))
+	r.RTO = time.Duration(in.RTOSeconds * float64(time.Second))
+	return nil
+}
+
+// pitrTypes replay a log archive up to a moment after the last backup
+var pitrTypes = map[string]string{
+	"postgres": "the WAL archive (db-backup pitr receive)",
+	"mysql":    "the binary log archive (db-backup binlog stream)",
+	"mongodb":  "the oplog archive (db-backup oplog stream)",
+}
+
+// Generate writes the runbook restoring in.Database to its newest
+// recovery point
+func Generate(in Input) (*Runbook, error) {
+	if in.Now.IsZero() {
+		in.Now = time.Now()
+	}
+	points := make([]restorepoint.Backup, 0, len(in.Backups))
+	byID := make(map[string]Backup, len(in.Backups))
+	for _, b := range in.Backups {
+		points = append(points, b.Backup)
+		byID[b.ID] = b
+	}
+	plan, err := restorepoint.Select(points, in.Database, in.Now)
+	if err != nil {
+		return nil, err
+	}
+
+	rb := &Runbook{
+		Database:      in.Database,
+		DatabaseType:  in.DatabaseType,
+		GeneratedAt:   in.Now.UTC(),
+		RecoveryPoint: plan.Last().Time.UTC(),
+		Estimate:      in.Estimate,
+		Rehearsals:    in.Rehearsals,
+		RTO:           in.RTO,
+	}
+	for _, p := range plan.Backups {
+		b := byID[p.ID]
+		rb.Artifacts = append(rb.Artifacts, Artifact{
+			BackupID:    b.ID,
+			Incremental: b.Incremental,
+			TakenAt:     b.Time.UTC(),
+			Storage:     b.Storage,
+			Location:    b.Location,
+			Size:        b.Size,
+			Checksum:    b.Checksum,
+			Copies:      in.Copies[b.ID],
+		})
+	}
+
+	profile := in.Profile
+	if profile == "" {
+		profile = "<profile>"
+	}
+	if in.Profile != "" {
+		rb.Keys = append(rb.Keys, Key{
+			Name:    "restore login",
+			Source:  fmt.Sprintf("connections.%s.restore (user %s)", strings.ToLower(in.Profile), in.RestoreUser),
+			Purpose: "connects to the server the database is restored on",
+		})
+	}
+	if in.EncryptionKey != "" {
+		rb.Keys = append(rb.Keys, Key{
+			Name:    "encryption key",
+			Source:  in.EncryptionKey,
+			Purpose: "decrypts the artifacts",
+		})
+	}
+
+	restore := []string{"db-backup", "restore", "--database", in.Database}
+	if in.Profile != in.Database {
+		restore = append(restore, "--profile", profile)
+	}
+	asOf := rb.RecoveryPoint.Format(time.RFC3339)
+
+	if in.Profile == "" {
+		rb.Steps = append(rb.Steps, Step{Title: fmt.Sprintf("Add a connection profile with a restore login for %s", in.Database)})
+	}
+	rb.Steps = append(rb.Steps,
+		Step{
+			Title:   "Check the restore login",
+			Command: command("db-backup", "connections", "test", profile, "--restore"),
+		},
+		Step{
+			Title:   "Review the backups restored",
+			Command: command(append(restore, "--as-of", asOf, "--dry-run")...),
+		},
+		Step{
+			Title:   fmt.Sprintf("Restore the newest recovery point, %s", asOf),
+			Command: command(append(restore, "--as-of", asOf)...),
+		},
+	)
+	if archive, ok := pitrTypes[in.DatabaseType]; ok {
+		rb.Steps = append(rb.Steps, Step{
+			Title:    "Or roll forward to a later moment by replaying " + archive,
+			Command:  command(append(restore, "--as-of", "<time>", "--log-dir", "<log archive>")...),
+			Optional: true,
+		})
+	}
+	rb.Steps = append(rb.Steps, Step{
+		Title:   "Record how long the restore took for the next estimate",
+		Command: command("db-backup", "sla", "rehearsal", in.Database, "--duration", "<duration>"),
+	})
+	return rb, nil
+}
+
+// WriteMarkdown renders the runbook for people
+func (r *Runbook) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Recovery runbook: %s\n\n", r.Database)
+	if r.DatabaseType != "" {
+		fmt.Fprintf(&b, "- Database type: %s\n", r.DatabaseType)
+	}
+	fmt.Fprintf(&b, "- Newest recovery point: %s\n", r.RecoveryPoint.Format(time.RFC3339))
+	switch {
+	case r.Rehearsals > 0:
+		fmt.Fprintf(&b, "- Expected duration: %s (slowest of %d restore rehearsals)\n", formatDuration(r.Estimate), r.Rehearsals)
+	default:
+		b.WriteString("- Expected duration: unknown, no restore rehearsal recorded\n")
+	}
+	if r.RTO > 0 {
+		fmt.Fprintf(&b, "- Recovery time objective: %s\n", formatDuration(r.RTO))
+	}
+	fmt.Fprintf(&b, "- Generated: %s\n", r.GeneratedAt.Format(time.RFC3339))
+
+	b.WriteString("\n## Artifacts\n\nRestored in this order:\n\n")
+	for i, a := range r.Artifacts {
+		kind := "full"
+		if a.Incremental {
+			kind = "incremental"
+		}
+		fmt.Fprintf(&b, "%d. `%s` (%s, taken %s, %d bytes)\n", i+1, a.BackupID, kind, a.TakenAt.Format(time.RFC3339), a.Size)
+		storage := ""
+		if a.Storage != "" {
+			storage = " on " + a.Storage
+		}
+		fmt.Fprintf(&b, "   - Location%s: `%s`\n", storage, a.Location)
+		if a.Checksum != "" {
+			fmt.Fprintf(&b, "   - SHA-256: `%s`\n", a.Checksum)
+		}
+		for _, c := range a.Copies {
+			fmt.Fprintf(&b, "   - Copy: `%s`\n", c)
+		}
+	}
+
+	b.WriteString("\n## Keys and logins\n\n")
+	if len(r.Keys) == 0 {
+		b.WriteString("None recorded.\n")
+	}
+	for _, k := range r.Keys {
+		fmt.Fprintf(&b, "- %s: %s, %s\n", k.Name, k.Source, k.Purpose)
+	}
+
+	b.WriteString("\n## Steps\n")
+	for i, s := range r.Steps {
+		title := s.Title
+		if s.Optional {
+			title += " (optional)"
+		}
+		fmt.Fprintf(&b, "\n%d. %s\n", i+1, title)
+		if s.Command != "" {
+			fmt.Fprintf(&b, "\n   ```sh\n   %s\n   ```\n", s.Command)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// command joins args into a shell command line. Placeholders in angle
+// brackets are left for the reader to fill in.
+func command(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "<") && strings.HasSuffix(arg, ">") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes a string for POSIX shells when it contains special characters
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`;&|<>(){}*?[]#~!%") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// formatDuration rounds a duration for people
+func formatDuration(d time.Duration) string {
+	if d >= time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,140 @@
+package runbook
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/restorepoint"
+)
+
+var now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testInput() Input {
+	backup := func(id string, age time.Duration, base string) Backup {
+		return Backup{
+			Backup: restorepoint.Backup{
+				ID:          id,
+				Database:    "orders",
+				Time:        now.Add(-age),
+				Incremental: base != "",
+				Base:        base,
+			},
+			Storage:  "s3",
+			Location: "backups/" + id + ".sql.zst",
+			Size:     1 << 20,
+			Checksum: "abc123",
+		}
+	}
+	return Input{
+		Database:     "orders",
+		DatabaseType: "postgres",
+		Backups: []Backup{
+			backup("full-old", 48*time.Hour, ""),
+			backup("full", 24*time.Hour, ""),
+			backup("inc", 6*time.Hour, "full"),
+		},
+		Copies:        map[string][]string{"full": {"s3://dr-bucket/full.sql.zst (eu-west-1)"}},
+		Profile:       "orders",
+		RestoreUser:   "restorer",
+		EncryptionKey: "/etc/db-backup/backup.key",
+		Estimate:      14 * time.Minute,
+		Rehearsals:    3,
+		RTO:           30 * time.Minute,
+		Now:           now,
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	rb, err := Generate(testInput())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rb.Artifacts) != 2 || rb.Artifacts[0].BackupID != "full" || !rb.Artifacts[1].Incremental {
+		t.Fatalf("artifacts = %+v", rb.Artifacts)
+	}
+	if len(rb.Artifacts[0].Copies) != 1 {
+		t.Errorf("DR copy missing: %+v", rb.Artifacts[0])
+	}
+	if !rb.RecoveryPoint.Equal(now.Add(-6 * time.Hour)) {
+		t.Errorf("recovery point = %s", rb.RecoveryPoint)
+	}
+	if len(rb.Keys) != 2 {
+		t.Errorf("keys = %+v", rb.Keys)
+	}
+
+	restore := "db-backup restore --database orders --as-of 2026-03-01T06:00:00Z"
+	var found, rollForward bool
+	for _, s := range rb.Steps {
+		found = found || s.Command == restore
+		rollForward = rollForward || (s.Optional && strings.Contains(s.Command, "--log-dir <log archive>"))
+	}
+	if !found || !rollForward {
+		t.Errorf("steps = %+v", rb.Steps)
+	}
+
+	var md bytes.Buffer
+	if err := rb.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Recovery runbook: orders", "14m0s (slowest of 3 restore rehearsals)", "backups/inc.sql.zst", restore} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("markdown misses %q:\n%s", want, md.String())
+		}
+	}
+}
+
+func TestGenerateWithoutProfile(t *testing.T) {
+	in := testInput()
+	in.Profile, in.DatabaseType = "", "redis"
+	rb, err := Generate(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range rb.Steps {
+		if s.Optional {
+			t.Errorf("roll forward offered for redis: %+v", s)
+		}
+		if strings.HasPrefix(s.Command, "db-backup restore") && !strings.Contains(s.Command, "--profile <profile>") {
+			t.Errorf("restore without a profile placeholder: %s", s.Command)
+		}
+	}
+
+	in.Database = "users"
+	if _, err := Generate(in); !errors.Is(err, restorepoint.ErrNoBackup) {
+		t.Errorf("database without backups: %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(t.TempDir())
+	in := testInput()
+	in.Database = "/var/lib/app/orders.db"
+	for i := range in.Backups {
+		in.Backups[i].Database = in.Database
+	}
+	rb, err := Generate(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(rb); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(in.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Database != in.Database || got.Estimate != 14*time.Minute || got.RTO != 30*time.Minute || len(got.Artifacts) != 2 {
+		t.Errorf("runbook = %+v", got)
+	}
+	list, err := store.List()
+	if err != nil || len(list) != 1 {
+		t.Fatalf("list = %v, %v", list, err)
+	}
+	if _, err := store.Get("users"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing runbook: %v", err)
+	}
+}
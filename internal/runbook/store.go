@@ -0,0 +1,105 @@
+package runbook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned for databases without a runbook
+var ErrNotFound = errors.New("runbook not found")
+
+// Store keeps the runbooks in a directory, as <database>.json and
+// <database>.md
+type Store struct {
+	dir string
+}
+
+// NewStore creates a store of the runbooks in dir
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// fileName returns the file name of a database's runbook without its
+// extension. Database names may be paths, as those of SQLite databases.
+func fileName(database string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, strings.TrimLeft(database, "/."))
+}
+
+// Save writes both renderings of the runbook, replacing the previous ones
+func (s *Store) Save(rb *Runbook) error {
+	data, err := json.MarshalIndent(rb, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal runbook: %w", err)
+	}
+	var md bytes.Buffer
+	if err := rb.WriteMarkdown(&md); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		return fmt.Errorf("failed to create runbook directory: %w", err)
+	}
+	base := filepath.Join(s.dir, fileName(rb.Database))
+	if err := writeFile(base+".json", data); err != nil {
+		return err
+	}
+	return writeFile(base+".md", md.Bytes())
+}
+
+// Get returns the runbook of a database
+func (s *Store) Get(database string) (*Runbook, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, fileName(database)+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, database)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runbook: %w", err)
+	}
+	var rb Runbook
+	if err := json.Unmarshal(data, &rb); err != nil {
+		return nil, fmt.Errorf("failed to parse runbook of %s: %w", database, err)
+	}
+	return &rb, nil
+}
+
+// List returns every runbook, sorted by database
+func (s *Store) List() ([]*Runbook, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	runbooks := make([]*Runbook, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read runbook: %w", err)
+		}
+		var rb Runbook
+		if err := json.Unmarshal(data, &rb); err != nil {
+			return nil, fmt.Errorf("failed to parse runbook %s: %w", filepath.Base(path), err)
+		}
+		runbooks = append(runbooks, &rb)
+	}
+	sort.Slice(runbooks, func(i, j int) bool { return runbooks[i].Database < runbooks[j].Database })
+	return runbooks, nil
+}
+
+// writeFile writes a file atomically
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write runbook: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
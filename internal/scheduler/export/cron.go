@@ -0,0 +1,211 @@
+package export
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cronField describes the bounds of a single cron field
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	monthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+	weekdayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+	systemdWeekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+	cronFields = []cronField{
+		{name: "minute", min: 0, max: 59},
+		{name: "hour", min: 0, max: 23},
+		{name: "day-of-month", min: 1, max: 31},
+		{name: "month", min: 1, max: 12, names: monthNames},
+		{name: "day-of-week", min: 0, max: 7, names: weekdayNames},
+	}
+
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// cronSpec is a parsed cron expression; a nil field means "every value"
+type cronSpec struct {
+	fields [5][]int
+}
+
+// parseCron parses a standard 5-field cron expression or macro
+func parseCron(expr string) (*cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if expanded, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = expanded
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(parts))
+	}
+
+	spec := &cronSpec{}
+	for i, part := range parts {
+		values, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+		spec.fields[i] = values
+	}
+
+	// Day-of-week 7 is an alias for Sunday
+	if dow := spec.fields[4]; dow != nil {
+		spec.fields[4] = normalizeWeekdays(dow)
+	}
+
+	return spec, nil
+}
+
+// parseCronField expands a cron field into the sorted list of matching values
+func parseCronField(field string, def cronField) ([]int, error) {
+	if field == "*" {
+		return nil, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(item, "/"); idx >= 0 {
+			s, err := strconv.Atoi(item[idx+1:])
+			if err != nil || s < 1 {
+				return nil, fmt.Errorf("%s: invalid step in %q", def.name, item)
+			}
+			step = s
+			item = item[:idx]
+		}
+
+		lo, hi := def.min, def.max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if lo, err = cronValue(bounds[0], def); err != nil {
+				return nil, err
+			}
+			if hi, err = cronValue(bounds[1], def); err != nil {
+				return nil, err
+			}
+			if lo > hi {
+				return nil, fmt.Errorf("%s: invalid range %q", def.name, item)
+			}
+		default:
+			v, err := cronValue(item, def)
+			if err != nil {
+				return nil, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			seen[v] = true
+		}
+	}
+
+	values := make([]int, 0, len(seen))
+	for v := def.min; v <= def.max; v++ {
+		if seen[v] {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// cronValue parses a single numeric or named cron value
+func cronValue(s string, def cronField) (int, error) {
+	if v, ok := def.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < def.min || v > def.max {
+		return 0, fmt.Errorf("%s: value %q out of range %d-%d", def.name, s, def.min, def.max)
+	}
+	return v, nil
+}
+
+// normalizeWeekdays maps 7 to 0 and removes duplicates
+func normalizeWeekdays(days []int) []int {
+	seen := make(map[int]bool)
+	out := make([]int, 0, len(days))
+	for _, d := range days {
+		d %= 7
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	if len(out) == 7 {
+		return nil
+	}
+	sort.Ints(out)
+	return out
+}
+
+// CronToOnCalendar converts a cron expression into a systemd OnCalendar
+// specification
+func CronToOnCalendar(expr string) (string, error) {
+	spec, err := parseCron(expr)
+	if err != nil {
+		return "", err
+	}
+
+	dom, dow := spec.fields[2], spec.fields[4]
+	if dom != nil && dow != nil {
+		// cron matches either field, systemd requires both
+		return "", fmt.Errorf("cron expression %q restricts both day-of-month and day-of-week, which systemd cannot represent", expr)
+	}
+
+	calendar := fmt.Sprintf("*-%s-%s %s:%s:00",
+		joinValues(spec.fields[3], "%02d"),
+		joinValues(dom, "%02d"),
+		joinValues(spec.fields[1], "%02d"),
+		joinValues(spec.fields[0], "%02d"),
+	)
+
+	if dow != nil {
+		names := make([]string, 0, len(dow))
+		for _, d := range dow {
+			names = append(names, systemdWeekdays[d])
+		}
+		calendar = strings.Join(names, ",") + " " + calendar
+	}
+
+	return calendar, nil
+}
+
+// joinValues renders a field's values, or "*" for every value
+func joinValues(values []int, format string) string {
+	if values == nil {
+		return "*"
+	}
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, fmt.Sprintf(format, v))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,67 @@
+package export
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCronToOnCalendar(t *testing.T) {
+	tests := []struct {
+		name    string
+		cron    string
+		want    string
+		wantErr bool
+	}{
+		{"daily at 2am", "0 2 * * *", "*-*-* 02:00:00", false},
+		{"every 15 minutes", "*/15 * * * *", "*-*-* *:00,15,30,45:00", false},
+		{"weekdays", "30 1 * * 1-5", "Mon,Tue,Wed,Thu,Fri *-*-* 01:30:00", false},
+		{"sunday as 7", "0 3 * * 7", "Sun *-*-* 03:00:00", false},
+		{"named month", "0 0 1 jan,jul *", "*-01,07-01 00:00:00", false},
+		{"macro", "@daily", "*-*-* 00:00:00", false},
+		{"dom and dow", "0 0 1 * 1", "", true},
+		{"too few fields", "0 2 * *", "", true},
+		{"out of range", "0 24 * * *", "", true},
+		{"bad step", "*/0 * * * *", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CronToOnCalendar(tt.cron)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CronToOnCalendar(%q) error = %v, wantErr %v", tt.cron, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CronToOnCalendar(%q) = %q, want %q", tt.cron, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderCron(t *testing.T) {
+	job := &Job{
+		Name: "nightly",
+		Cron: "0 2 * * *",
+		Args: []string{"backup", "--type", "postgres", "--tags", "env=prod"},
+		User: "dbbackup",
+	}
+
+	artifacts, err := Render(job, FormatCron)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if len(artifacts) != 1 {
+		t.Fatalf("expected 1 artifact, got %d", len(artifacts))
+	}
+
+	content := artifacts[0].Content
+	if !strings.Contains(content, "0 2 * * * dbbackup /usr/local/bin/db-backup backup --type postgres --tags env=prod") {
+		t.Errorf("unexpected crontab content:\n%s", content)
+	}
+}
+
+func TestRenderRejectsInvalidName(t *testing.T) {
+	job := &Job{Name: "Nightly Backup", Cron: "0 2 * * *", Args: []string{"backup"}}
+	if _, err := Render(job, FormatSystemd); err == nil {
+		t.Error("expected error for invalid schedule name")
+	}
+}
@@ -0,0 +1,342 @@
+// Package export renders backup schedules as OS-level scheduling artifacts
+// (systemd units, crontab entries and Kubernetes CronJobs)
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format represents an artifact output format
+type Format string
+
+const (
+	FormatSystemd    Format = "systemd"
+	FormatCron       Format = "cron"
+	FormatK8sCronJob Format = "k8s-cronjob"
+)
+
+const (
+	defaultBinary      = "/usr/local/bin/db-backup"
+	defaultImage       = "db-backup:latest"
+	defaultNamespace   = "default"
+	defaultLogDir      = "/var/log/db-backup"
+	artifactNamePrefix = "db-backup-"
+)
+
+// jobNameRegex restricts job names to characters valid in unit and k8s names
+var jobNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Job describes a scheduled db-backup invocation
+type Job struct {
+	Name      string            // Schedule name, used for unit and resource names
+	Cron      string            // Standard 5-field cron expression or @macro
+	Binary    string            // Path to the db-backup binary (systemd/cron)
+	Args      []string          // Arguments passed to db-backup (e.g. backup --type mysql ...)
+	User      string            // User to run as (systemd/cron)
+	EnvFile   string            // Environment file with credentials (systemd/cron)
+	Env       map[string]string // Extra environment variables
+	Image     string            // Container image (k8s)
+	Namespace string            // Kubernetes namespace (k8s)
+	Secret    string            // Kubernetes secret exposed as environment (k8s)
+	ConfigMap string            // Kubernetes config map mounted at /config (k8s)
+	Timezone  string            // IANA timezone for the schedule
+}
+
+// Artifact is a rendered file
+type Artifact struct {
+	Filename string
+	Content  string
+}
+
+// Render renders a job in the requested format
+func Render(job *Job, format Format) ([]Artifact, error) {
+	if err := validateJob(job); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatSystemd:
+		return renderSystemd(job)
+	case FormatCron:
+		return renderCron(job)
+	case FormatK8sCronJob:
+		return renderCronJob(job)
+	default:
+		return nil, fmt.Errorf("unsupported format: %s (must be systemd|cron|k8s-cronjob)", format)
+	}
+}
+
+// validateJob checks that the job can be rendered
+func validateJob(job *Job) error {
+	if !jobNameRegex.MatchString(job.Name) {
+		return fmt.Errorf("invalid schedule name %q (lowercase letters, digits and hyphens only)", job.Name)
+	}
+	if _, err := parseCron(job.Cron); err != nil {
+		return err
+	}
+	if len(job.Args) == 0 {
+		return fmt.Errorf("no db-backup arguments given for schedule %q", job.Name)
+	}
+	return nil
+}
+
+func renderSystemd(job *Job) ([]Artifact, error) {
+	calendar, err := CronToOnCalendar(job.Cron)
+	if err != nil {
+		return nil, err
+	}
+	if job.Timezone != "" {
+		calendar += " " + job.Timezone
+	}
+
+	unit := artifactNamePrefix + job.Name
+
+	var service strings.Builder
+	fmt.Fprintf(&service, "[Unit]\n")
+	fmt.Fprintf(&service, "Description=db-backup schedule %s\n", job.Name)
+	fmt.Fprintf(&service, "Wants=network-online.target\n")
+	fmt.Fprintf(&service, "After=network-online.target\n\n")
+	fmt.Fprintf(&service, "[Service]\n")
+	fmt.Fprintf(&service, "Type=oneshot\n")
+	if job.User != "" {
+		fmt.Fprintf(&service, "User=%s\n", job.User)
+	}
+	if job.EnvFile != "" {
+		fmt.Fprintf(&service, "EnvironmentFile=%s\n", job.EnvFile)
+	}
+	for _, k := range sortedKeys(job.Env) {
+		fmt.Fprintf(&service, "Environment=%s\n", shellQuote(k+"="+job.Env[k]))
+	}
+	fmt.Fprintf(&service, "ExecStart=%s\n", commandLine(job))
+	fmt.Fprintf(&service, "Nice=10\n")
+	fmt.Fprintf(&service, "IOSchedulingClass=best-effort\n")
+	fmt.Fprintf(&service, "IOSchedulingPriority=7\n")
+
+	var timer strings.Builder
+	fmt.Fprintf(&timer, "[Unit]\n")
+	fmt.Fprintf(&timer, "Description=Timer for db-backup schedule %s (%s)\n\n", job.Name, job.Cron)
+	fmt.Fprintf(&timer, "[Timer]\n")
+	fmt.Fprintf(&timer, "OnCalendar=%s\n", calendar)
+	fmt.Fprintf(&timer, "Persistent=true\n")
+	fmt.Fprintf(&timer, "RandomizedDelaySec=60\n")
+	fmt.Fprintf(&timer, "Unit=%s.service\n\n", unit)
+	fmt.Fprintf(&timer, "[Install]\n")
+	fmt.Fprintf(&timer, "WantedBy=timers.target\n")
+
+	return []Artifact{
+		{Filename: unit + ".service", Content: service.String()},
+		{Filename: unit + ".timer", Content: timer.String()},
+	}, nil
+}
+
+func renderCron(job *Job) ([]Artifact, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# db-backup schedule %s\n", job.Name)
+	if job.Timezone != "" {
+		fmt.Fprintf(&b, "CRON_TZ=%s\n", job.Timezone)
+	}
+	for _, k := range sortedKeys(job.Env) {
+		fmt.Fprintf(&b, "%s=%s\n", k, job.Env[k])
+	}
+
+	command := commandLine(job)
+	if job.EnvFile != "" {
+		command = fmt.Sprintf("set -a; . %s; set +a; %s", shellQuote(job.EnvFile), command)
+	}
+	command = fmt.Sprintf("%s >> %s/%s.log 2>&1", command, defaultLogDir, job.Name)
+
+	// Lines in /etc/cron.d carry a user field; user crontabs do not
+	if job.User != "" {
+		fmt.Fprintf(&b, "%s %s %s\n", job.Cron, job.User, escapeCronPercent(command))
+	} else {
+		fmt.Fprintf(&b, "%s %s\n", job.Cron, escapeCronPercent(command))
+	}
+
+	return []Artifact{{Filename: artifactNamePrefix + job.Name, Content: b.String()}}, nil
+}
+
+// Kubernetes manifest types, kept minimal and ordered for stable output
+
+type k8sCronJob struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   k8sMetadata    `yaml:"metadata"`
+	Spec       k8sCronJobSpec `yaml:"spec"`
+}
+
+type k8sMetadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type k8sCronJobSpec struct {
+	Schedule                   string         `yaml:"schedule"`
+	TimeZone                   string         `yaml:"timeZone,omitempty"`
+	ConcurrencyPolicy          string         `yaml:"concurrencyPolicy"`
+	SuccessfulJobsHistoryLimit int            `yaml:"successfulJobsHistoryLimit"`
+	FailedJobsHistoryLimit     int            `yaml:"failedJobsHistoryLimit"`
+	JobTemplate                k8sJobTemplate `yaml:"jobTemplate"`
+}
+
+type k8sJobTemplate struct {
+	Spec k8sJobSpec `yaml:"spec"`
+}
+
+type k8sJobSpec struct {
+	BackoffLimit int            `yaml:"backoffLimit"`
+	Template     k8sPodTemplate `yaml:"template"`
+}
+
+type k8sPodTemplate struct {
+	Metadata k8sMetadata `yaml:"metadata"`
+	Spec     k8sPodSpec  `yaml:"spec"`
+}
+
+type k8sPodSpec struct {
+	RestartPolicy string         `yaml:"restartPolicy"`
+	Containers    []k8sContainer `yaml:"containers"`
+	Volumes       []k8sVolume    `yaml:"volumes,omitempty"`
+}
+
+type k8sContainer struct {
+	Name         string           `yaml:"name"`
+	Image        string           `yaml:"image"`
+	Args         []string         `yaml:"args"`
+	Env          []k8sEnvVar      `yaml:"env,omitempty"`
+	EnvFrom      []k8sEnvFrom     `yaml:"envFrom,omitempty"`
+	VolumeMounts []k8sVolumeMount `yaml:"volumeMounts,omitempty"`
+}
+
+type k8sEnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type k8sEnvFrom struct {
+	SecretRef k8sNameRef `yaml:"secretRef"`
+}
+
+type k8sNameRef struct {
+	Name string `yaml:"name"`
+}
+
+type k8sVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	ReadOnly  bool   `yaml:"readOnly"`
+}
+
+type k8sVolume struct {
+	Name      string     `yaml:"name"`
+	ConfigMap k8sNameRef `yaml:"configMap"`
+}
+
+func renderCronJob(job *Job) ([]Artifact, error) {
+	name := artifactNamePrefix + job.Name
+	labels := map[string]string{
+		"app.kubernetes.io/name":      "db-backup",
+		"app.kubernetes.io/component": "schedule",
+		"db-backup/schedule":          job.Name,
+	}
+
+	container := k8sContainer{
+		Name:  "db-backup",
+		Image: valueOr(job.Image, defaultImage),
+		Args:  job.Args,
+	}
+	for _, k := range sortedKeys(job.Env) {
+		container.Env = append(container.Env, k8sEnvVar{Name: k, Value: job.Env[k]})
+	}
+	if job.Secret != "" {
+		container.EnvFrom = []k8sEnvFrom{{SecretRef: k8sNameRef{Name: job.Secret}}}
+	}
+
+	pod := k8sPodSpec{RestartPolicy: "Never", Containers: []k8sContainer{container}}
+	if job.ConfigMap != "" {
+		pod.Containers[0].VolumeMounts = []k8sVolumeMount{{Name: "config", MountPath: "/config", ReadOnly: true}}
+		pod.Volumes = []k8sVolume{{Name: "config", ConfigMap: k8sNameRef{Name: job.ConfigMap}}}
+	}
+
+	manifest := k8sCronJob{
+		APIVersion: "batch/v1",
+		Kind:       "CronJob",
+		Metadata: k8sMetadata{
+			Name:      name,
+			Namespace: valueOr(job.Namespace, defaultNamespace),
+			Labels:    labels,
+		},
+		Spec: k8sCronJobSpec{
+			Schedule:                   job.Cron,
+			TimeZone:                   job.Timezone,
+			ConcurrencyPolicy:          "Forbid",
+			SuccessfulJobsHistoryLimit: 3,
+			FailedJobsHistoryLimit:     3,
+			JobTemplate: k8sJobTemplate{
+				Spec: k8sJobSpec{
+					BackoffLimit: 0,
+					Template: k8sPodTemplate{
+						Metadata: k8sMetadata{Labels: labels},
+						Spec:     pod,
+					},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(manifest); err != nil {
+		return nil, fmt.Errorf("failed to render CronJob manifest: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to render CronJob manifest: %w", err)
+	}
+
+	return []Artifact{{Filename: name + ".yaml", Content: buf.String()}}, nil
+}
+
+// commandLine builds the shell-quoted db-backup invocation
+func commandLine(job *Job) string {
+	parts := []string{shellQuote(valueOr(job.Binary, defaultBinary))}
+	for _, arg := range job.Args {
+		parts = append(parts, shellQuote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// shellQuote quotes a string for POSIX shells when it contains special characters
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`;&|<>(){}*?[]#~!%") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// escapeCronPercent escapes % which cron treats as a newline
+func escapeCronPercent(s string) string {
+	return strings.ReplaceAll(s, "%", `\%`)
+}
+
+func valueOr(v, fallback string) string {
+	if v == "" {
+		return fallback
+	}
+	return v
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
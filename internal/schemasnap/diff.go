@@ -0,0 +1,153 @@
+package schemasnap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+// Diff returns the changes from the DDL from to the DDL to as a unified
+// diff of their lines, or "" when they are the same
+func Diff(fromName, toName string, from, to []byte) string {
+	a, b := splitLines(string(from)), splitLines(string(to))
+	ops := diffLines(a, b)
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change and the hunk around it
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		lo := max(0, start-diffContext)
+		hi := start
+		for unchanged := 0; hi < len(ops) && unchanged <= 2*diffContext; hi++ {
+			if ops[hi].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		// Trailing context is at most diffContext lines
+		for hi > start && ops[hi-1].kind == ' ' && countTrailing(ops[:hi]) > diffContext {
+			hi--
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		hunk := ops[lo:hi]
+		aStart, bStart := ops[lo].a, ops[lo].b
+		aLen, bLen := 0, 0
+		for _, op := range hunk {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		for _, op := range hunk {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		start = hi
+	}
+	return out.String()
+}
+
+// diffOp is a line kept (' '), removed ('-') or added ('+'), with the
+// indexes of the lines of both sides it comes before
+type diffOp struct {
+	kind byte
+	line string
+	a, b int
+}
+
+// diffLines returns the edit script turning a into b, from their longest
+// common subsequence of lines
+func diffLines(a, b []string) []diffOp {
+	// The common head and tail need no table
+	head := 0
+	for head < len(a) && head < len(b) && a[head] == b[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(a)-head && tail < len(b)-head && a[len(a)-1-tail] == b[len(b)-1-tail] {
+		tail++
+	}
+	ma, mb := a[head:len(a)-tail], b[head:len(b)-tail]
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// ma[i:] and mb[j:]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for i := 0; i < head; i++ {
+		ops = append(ops, diffOp{' ', a[i], i, i})
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			ops = append(ops, diffOp{' ', ma[i], head + i, head + j})
+			i++
+			j++
+		case i < len(ma) && (j == len(mb) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', ma[i], head + i, head + j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', mb[j], head + i, head + j})
+			j++
+		}
+	}
+	for k := 0; k < tail; k++ {
+		ops = append(ops, diffOp{' ', a[len(a)-tail+k], len(a) - tail + k, len(b) - tail + k})
+	}
+	return ops
+}
+
+// countTrailing counts the unchanged lines ending ops
+func countTrailing(ops []diffOp) int {
+	n := 0
+	for n < len(ops) && ops[len(ops)-1-n].kind == ' ' {
+		n++
+	}
+	return n
+}
+
+// hunkRange formats the lines of one side of a hunk, 1-based
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
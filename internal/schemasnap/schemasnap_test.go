@@ -0,0 +1,129 @@
+package schemasnap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreHistory(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+	v1 := []byte("CREATE TABLE orders (id int);\n")
+	v2 := []byte("CREATE TABLE orders (id int, total numeric);\n")
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, ddl := range [][]byte{v1, v1, v2} {
+		e, err := s.Save(Entry{
+			BackupID:     []string{"b1", "b2", "b3"}[i],
+			DatabaseType: "postgresql",
+			Database:     "shop",
+			CreatedAt:    day.AddDate(0, 0, 2-i), // saved newest first
+		}, ddl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Checksum != Checksum(ddl) || e.Size != int64(len(ddl)) {
+			t.Errorf("entry = %+v", e)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "postgresql", "shop", "*.sql"))
+	if len(files) != 2 {
+		t.Errorf("unchanged schemas stored apart: %v", files)
+	}
+
+	history, err := s.History("postgresql", "shop")
+	if err != nil || len(history) != 3 {
+		t.Fatalf("history = %+v, %v", history, err)
+	}
+	if history[0].BackupID != "b3" || history[2].BackupID != "b1" {
+		t.Errorf("history is not oldest first: %+v", history)
+	}
+	if other, _ := s.History("mysql", "shop"); len(other) != 0 {
+		t.Errorf("mysql history = %+v", other)
+	}
+	if _, err := s.Save(Entry{BackupID: "m1", DatabaseType: "mysql", Database: "shop", CreatedAt: day.AddDate(0, 0, 1).Add(time.Hour)}, v1); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := s.History("", "shop"); err != nil || len(all) != 4 || all[2].BackupID != "m1" {
+		t.Errorf("history of every type = %+v, %v", all, err)
+	}
+	if none, err := NewStore(filepath.Join(dir, "missing")).History("", "shop"); err != nil || len(none) != 0 {
+		t.Errorf("history of an empty store = %+v, %v", none, err)
+	}
+
+	e, err := s.Find("postgresql", "shop", "b3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.Read(e); err != nil || string(data) != string(v2) {
+		t.Errorf("read %q, %v", data, err)
+	}
+	if _, err := s.Find("postgresql", "shop", "b9"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v", err)
+	}
+
+	// Saving a backup again replaces its snapshot
+	if _, err := s.Save(Entry{BackupID: "b3", DatabaseType: "postgresql", Database: "shop", CreatedAt: day}, v1); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := s.History("postgresql", "shop"); len(history) != 3 || history[0].Checksum != Checksum(v1) {
+		t.Errorf("history = %+v", history)
+	}
+
+	os.WriteFile(filepath.Join(dir, "postgresql", "shop", Checksum(v2)+".sql"), v1, 0o600)
+	if _, err := s.Read(e); err == nil {
+		t.Error("read a snapshot that does not match its checksum")
+	}
+}
+
+func TestDatabaseDir(t *testing.T) {
+	s := NewStore("/snapshots")
+	for db, want := range map[string]string{
+		"shop":            "shop",
+		"/var/lib/app.db": "%2Fvar%2Flib%2Fapp.db",
+		`C:\data\app.db`:  "C%3A%5Cdata%5Capp.db",
+		"..":              "%2E%2E",
+	} {
+		if got := s.databaseDir("sqlite", db); got != filepath.Join("/snapshots", "sqlite", want) {
+			t.Errorf("%s: dir = %s, want %s", db, got, want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nm\nn\n"
+	want := `--- b1
++++ b2
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -9,5 +9,5 @@
+ i
+ j
+ k
+-l
+ m
++n
+`
+	if got := Diff("b1", "b2", []byte(from), []byte(to)); got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+	if got := Diff("b1", "b2", []byte(from), []byte(from)); got != "" {
+		t.Errorf("diff of the same schema = %q", got)
+	}
+	if got := Diff("b1", "b2", nil, []byte("x\n")); got != "--- b1\n+++ b2\n@@ -0,0 +1 @@\n+x\n" {
+		t.Errorf("diff from nothing = %q", got)
+	}
+	if !strings.HasPrefix(Diff("b1", "b2", []byte("x\ny\n"), nil), "--- b1\n+++ b2\n@@ -1,2 +0,0 @@\n-x\n-y\n") {
+		t.Error("diff to nothing")
+	}
+}
@@ -0,0 +1,213 @@
+// Package schemasnap keeps the schema-only snapshots taken before each
+// backup, so the schema history of a database can be browsed and compared
+// without restoring data.
+package schemasnap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagChecksum is the backup tag holding the checksum of the schema
+// snapshot taken with the backup
+const TagChecksum = "schema_checksum"
+
+// indexFile lists the snapshots of a database
+const indexFile = "index.json"
+
+// ErrNotFound is returned for a backup without a schema snapshot
+var ErrNotFound = errors.New("schema snapshot not found")
+
+// Entry is the schema snapshot of a backup
+type Entry struct {
+	BackupID     string    `json:"backup_id"`
+	DatabaseType string    `json:"database_type"`
+	Database     string    `json:"database"`
+	Checksum     string    `json:"checksum"`
+	Size         int64     `json:"size"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Store keeps the snapshots under a directory, one per database type and
+// database. Backups of an unchanged schema share the file of its DDL.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewStore creates a store under dir
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Checksum returns the checksum snapshots of ddl are stored under
+func Checksum(ddl []byte) string {
+	sum := sha256.Sum256(ddl)
+	return hex.EncodeToString(sum[:])
+}
+
+// Save stores ddl as the snapshot of e.BackupID, replacing an earlier one
+// of the backup, and returns the entry recorded for it
+func (s *Store) Save(e Entry, ddl []byte) (Entry, error) {
+	if e.BackupID == "" || e.Database == "" {
+		return e, errors.New("a schema snapshot needs a backup ID and a database")
+	}
+	e.Checksum = Checksum(ddl)
+	e.Size = int64(len(ddl))
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.databaseDir(e.DatabaseType, e.Database)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return e, fmt.Errorf("failed to create schema snapshot directory: %w", err)
+	}
+	path := filepath.Join(dir, e.Checksum+".sql")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := writeFile(path, ddl); err != nil {
+			return e, fmt.Errorf("failed to write schema snapshot: %w", err)
+		}
+	}
+
+	entries, err := s.load(dir)
+	if err != nil {
+		return e, err
+	}
+	replaced := false
+	for i := range entries {
+		if entries[i].BackupID == e.BackupID {
+			entries[i], replaced = e, true
+		}
+	}
+	if !replaced {
+		entries = append(entries, e)
+	}
+	sortEntries(entries)
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return e, fmt.Errorf("failed to marshal schema snapshot index: %w", err)
+	}
+	if err := writeFile(filepath.Join(dir, indexFile), data); err != nil {
+		return e, fmt.Errorf("failed to write schema snapshot index: %w", err)
+	}
+	return e, nil
+}
+
+// History returns the snapshots of a database, oldest first. An empty
+// dbType returns those of the databases of that name of every type.
+func (s *Store) History(dbType, database string) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dbType != "" {
+		return s.load(s.databaseDir(dbType, database))
+	}
+
+	types, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema snapshot directory: %w", err)
+	}
+	var all []Entry
+	for _, t := range types {
+		if !t.IsDir() {
+			continue
+		}
+		name, err := url.PathUnescape(t.Name())
+		if err != nil {
+			continue
+		}
+		entries, err := s.load(s.databaseDir(name, database))
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, entries...)
+	}
+	sortEntries(all)
+	return all, nil
+}
+
+// Find returns the snapshot of a backup of a database, of any type when
+// dbType is empty
+func (s *Store) Find(dbType, database, backupID string) (Entry, error) {
+	entries, err := s.History(dbType, database)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, e := range entries {
+		if e.BackupID == backupID {
+			return e, nil
+		}
+	}
+	return Entry{}, fmt.Errorf("%w for backup %s of %s", ErrNotFound, backupID, database)
+}
+
+// Read returns the DDL of a snapshot
+func (s *Store) Read(e Entry) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.databaseDir(e.DatabaseType, e.Database), e.Checksum+".sql"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema snapshot of backup %s: %w", e.BackupID, err)
+	}
+	if Checksum(data) != e.Checksum {
+		return nil, fmt.Errorf("schema snapshot of backup %s does not match its checksum", e.BackupID)
+	}
+	return data, nil
+}
+
+// databaseDir is the directory of the snapshots of a database. Names are
+// escaped to one path element each, SQLite paths included.
+func (s *Store) databaseDir(dbType, database string) string {
+	escape := func(name string) string {
+		name = strings.ReplaceAll(url.PathEscape(name), ":", "%3A")
+		if strings.Trim(name, ".") == "" {
+			name = strings.ReplaceAll(name, ".", "%2E")
+		}
+		return name
+	}
+	return filepath.Join(s.dir, escape(dbType), escape(database))
+}
+
+func (s *Store) load(dir string) ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema snapshot index: %w", err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse schema snapshot index: %w", err)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func sortEntries(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+}
+
+// writeFile writes data to path atomically
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,126 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// AWSOptions configures the AWS Secrets Manager resolver. Credentials come
+// from the default AWS chain (environment, shared config, instance role).
+type AWSOptions struct {
+	// Region is used for secret names; ARNs carry their own region
+	Region string
+	// Endpoint overrides the regional endpoint, e.g. for a VPC endpoint
+	Endpoint string
+	Client   *http.Client
+}
+
+// awsResolver calls GetSecretValue on the Secrets Manager JSON API signed
+// with SigV4. References are a secret name or ARN, optionally with
+// "#field" to pick a key of a JSON secret.
+type awsResolver struct {
+	opts   AWSOptions
+	signer *v4.Signer
+}
+
+// NewAWSResolver creates a resolver for "aws-sm:" references
+func NewAWSResolver(opts AWSOptions) Resolver {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &awsResolver{opts: opts, signer: v4.NewSigner()}
+}
+
+// Resolve fetches the current version of a secret
+func (a *awsResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	id, field := splitField(ref)
+
+	region := a.opts.Region
+	if arnRegion := regionFromARN(id); arnRegion != "" {
+		region = arnRegion
+	}
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		loadOpts = append(loadOpts, awsconfig.WithRegion(region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return "", fmt.Errorf("aws-sm: failed to load AWS credentials: %w", err)
+	}
+	if region == "" {
+		region = awsCfg.Region
+	}
+	if region == "" {
+		return "", fmt.Errorf("aws-sm: region not configured for secret %s", id)
+	}
+
+	endpoint := a.opts.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", region)
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("aws-sm: failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	creds, err := awsCfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("aws-sm: failed to retrieve AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := a.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "secretsmanager", region, time.Now()); err != nil {
+		return "", fmt.Errorf("aws-sm: failed to sign request: %w", err)
+	}
+
+	resp, err := a.opts.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("aws-sm: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("aws-sm: GetSecretValue failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var result struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary []byte `json:"SecretBinary"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("aws-sm: failed to decode response: %w", err)
+	}
+	secret := result.SecretString
+	if secret == "" {
+		secret = string(result.SecretBinary)
+	}
+	return jsonField(secret, field)
+}
+
+// regionFromARN returns the region of a Secrets Manager ARN
+// (arn:aws:secretsmanager:<region>:<account>:secret:<name>)
+func regionFromARN(id string) string {
+	parts := strings.SplitN(id, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[3]
+}
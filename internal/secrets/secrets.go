@@ -0,0 +1,200 @@
+// Package secrets resolves references to credentials kept outside the
+// configuration file, such as
+//
+//	password: vault:secret/db#password
+//	password: aws-sm:arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf#password
+//	password: file:/run/secrets/dbpass
+//	password: env:DB_PASSWORD
+//
+// A reference is "<scheme>:<location>", optionally followed by "#<field>"
+// to pick one field of a JSON or key/value secret. Resolvers for further
+// schemes can be registered with Register.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Resolver fetches the secret a reference points to. It receives the
+// reference without its scheme prefix.
+type Resolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// ResolverFunc adapts a function to the Resolver interface
+type ResolverFunc func(ctx context.Context, ref string) (string, error)
+
+// Resolve calls f
+func (f ResolverFunc) Resolve(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// Registry maps reference schemes to resolvers
+type Registry struct {
+	mu        sync.RWMutex
+	resolvers map[string]Resolver
+}
+
+// NewRegistry creates a registry with the built-in file, env, vault and
+// aws-sm resolvers. Vault and AWS are configured from their usual
+// environment variables until registered again with explicit options.
+func NewRegistry() *Registry {
+	r := NewLocalRegistry()
+	r.Register("vault", NewVaultResolver(VaultOptions{}))
+	r.Register("aws-sm", NewAWSResolver(AWSOptions{}))
+	return r
+}
+
+// NewLocalRegistry creates a registry with only the file and env
+// resolvers, for values that configure the remote backends themselves
+func NewLocalRegistry() *Registry {
+	r := &Registry{resolvers: make(map[string]Resolver)}
+	r.Register("file", ResolverFunc(resolveFile))
+	r.Register("env", ResolverFunc(resolveEnv))
+	return r
+}
+
+// Register adds or replaces the resolver of a scheme
+func (r *Registry) Register(scheme string, resolver Resolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolvers[strings.ToLower(scheme)] = resolver
+}
+
+// Schemes lists the registered schemes
+func (r *Registry) Schemes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schemes := make([]string, 0, len(r.resolvers))
+	for s := range r.resolvers {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// IsReference reports whether value starts with a registered scheme
+func (r *Registry) IsReference(value string) bool {
+	_, _, ok := r.lookup(value)
+	return ok
+}
+
+// Resolve returns the secret value references, or value itself when it is
+// not a reference
+func (r *Registry) Resolve(ctx context.Context, value string) (string, bool, error) {
+	resolver, ref, ok := r.lookup(value)
+	if !ok {
+		return value, false, nil
+	}
+	secret, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return "", true, err
+	}
+	return secret, true, nil
+}
+
+func (r *Registry) lookup(value string) (Resolver, string, bool) {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok || ref == "" {
+		return nil, "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	resolver, ok := r.resolvers[strings.ToLower(scheme)]
+	return resolver, ref, ok
+}
+
+var defaultRegistry = NewRegistry()
+
+// Default returns the registry configuration values are resolved with
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Register adds or replaces a resolver in the default registry
+func Register(scheme string, resolver Resolver) {
+	defaultRegistry.Register(scheme, resolver)
+}
+
+// splitField separates the "#field" suffix of a reference
+func splitField(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// pickField returns one field of a key/value secret. Without a field name
+// the secret must hold exactly one value.
+func pickField(data map[string]interface{}, field string) (string, error) {
+	if field == "" {
+		if len(data) != 1 {
+			keys := make([]string, 0, len(data))
+			for k := range data {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return "", fmt.Errorf("secret has fields %s, select one with #<field>", strings.Join(keys, ", "))
+		}
+		for k := range data {
+			field = k
+		}
+	}
+
+	v, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("secret field %q is empty", field)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// jsonField picks a field of a secret stored as a JSON object, or returns
+// the raw text when no field is requested
+func jsonField(raw, field string) (string, error) {
+	if field == "" {
+		return raw, nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, cannot select #%s", field)
+	}
+	return pickField(data, field)
+}
+
+// resolveFile reads a secret file such as a Docker or Kubernetes secret
+// mount; the trailing newline most tools write is dropped
+func resolveFile(_ context.Context, ref string) (string, error) {
+	path, field := splitField(ref)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return jsonField(strings.TrimRight(string(data), "\r\n"), field)
+}
+
+// resolveEnv reads an environment variable
+func resolveEnv(_ context.Context, ref string) (string, error) {
+	name, field := splitField(ref)
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return jsonField(value, field)
+}
@@ -0,0 +1,103 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistryResolve(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "dbpass")
+	if err := os.WriteFile(plain, []byte("s3cr3t-from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	structured := filepath.Join(dir, "db.json")
+	if err := os.WriteFile(structured, []byte(`{"username":"backup","password":"from-json","port":5432}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_DB_PASSWORD", "s3cr3t-from-env")
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/db":
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"from-vault","username":"backup"},"metadata":{"version":3}}}`))
+		case "/v1/secret/data/single":
+			_, _ = w.Write([]byte(`{"data":{"data":{"token":"only-value"}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+
+	reg := NewRegistry()
+	reg.Register("vault", NewVaultResolver(VaultOptions{Address: vault.URL, Token: "root-token"}))
+	reg.Register("static", ResolverFunc(func(_ context.Context, ref string) (string, error) {
+		return "static-" + ref, nil
+	}))
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantRef bool
+		wantErr bool
+	}{
+		{name: "plain value", value: "changeme", want: "changeme"},
+		{name: "url is not a reference", value: "https://hooks.example.com/x", want: "https://hooks.example.com/x"},
+		{name: "file", value: "file:" + plain, want: "s3cr3t-from-file", wantRef: true},
+		{name: "file json field", value: "file:" + structured + "#password", want: "from-json", wantRef: true},
+		{name: "file non-string field", value: "file:" + structured + "#port", want: "5432", wantRef: true},
+		{name: "missing file", value: "file:" + filepath.Join(dir, "nope"), wantRef: true, wantErr: true},
+		{name: "env", value: "env:TEST_DB_PASSWORD", want: "s3cr3t-from-env", wantRef: true},
+		{name: "unset env", value: "env:TEST_DB_PASSWORD_UNSET", wantRef: true, wantErr: true},
+		{name: "vault field", value: "vault:secret/db#password", want: "from-vault", wantRef: true},
+		{name: "vault single field", value: "vault:secret/single", want: "only-value", wantRef: true},
+		{name: "vault ambiguous field", value: "vault:secret/db", wantRef: true, wantErr: true},
+		{name: "vault missing secret", value: "vault:secret/nope#password", wantRef: true, wantErr: true},
+		{name: "custom scheme", value: "static:abc", want: "static-abc", wantRef: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, isRef, err := reg.Resolve(context.Background(), tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if isRef != tt.wantRef {
+				t.Errorf("Resolve(%q) reference = %v, want %v", tt.value, isRef, tt.wantRef)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalRegistryHasNoRemoteBackends(t *testing.T) {
+	reg := NewLocalRegistry()
+	if reg.IsReference("vault:secret/db#password") {
+		t.Error("local registry resolves vault references")
+	}
+	if !reg.IsReference("env:HOME") {
+		t.Error("local registry does not resolve env references")
+	}
+}
+
+func TestRegionFromARN(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:secretsmanager:eu-west-1:123456789012:secret:db-AbCdEf": "eu-west-1",
+		"prod/db": "",
+	}
+	for id, want := range tests {
+		if got := regionFromARN(id); got != want {
+			t.Errorf("regionFromARN(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
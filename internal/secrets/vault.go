@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultOptions configures the HashiCorp Vault resolver. Empty fields fall
+// back to VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE.
+type VaultOptions struct {
+	Address   string
+	Token     string
+	Namespace string
+	// KVVersion is the version of the key/value engine (default 2)
+	KVVersion int
+	Client    *http.Client
+}
+
+// vaultResolver reads secrets from a Vault key/value engine over its HTTP
+// API. References look like "secret/db#password": the mount, the secret
+// path and the field.
+type vaultResolver struct {
+	opts VaultOptions
+}
+
+// NewVaultResolver creates a resolver for "vault:" references
+func NewVaultResolver(opts VaultOptions) Resolver {
+	if opts.KVVersion == 0 {
+		opts.KVVersion = 2
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &vaultResolver{opts: opts}
+}
+
+// Resolve reads one field of a Vault secret
+func (v *vaultResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	path, field := splitField(ref)
+	path = strings.Trim(path, "/")
+
+	address := firstNonEmpty(v.opts.Address, os.Getenv("VAULT_ADDR"))
+	if address == "" {
+		return "", fmt.Errorf("vault address not configured (security.secrets.vault.address or VAULT_ADDR)")
+	}
+	token := firstNonEmpty(v.opts.Token, os.Getenv("VAULT_TOKEN"))
+	namespace := firstNonEmpty(v.opts.Namespace, os.Getenv("VAULT_NAMESPACE"))
+
+	// KV v2 serves secret data below <mount>/data/
+	if v.opts.KVVersion == 2 {
+		if mount, rest, ok := strings.Cut(path, "/"); ok && !strings.HasPrefix(rest, "data/") {
+			path = mount + "/data/" + rest
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(address, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create vault request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := v.opts.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("vault returned status %d for %s: %s", resp.StatusCode, path, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	data := result.Data
+	if v.opts.KVVersion == 2 {
+		inner, ok := data["data"].(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("vault secret %s has no data", path)
+		}
+		data = inner
+	}
+	return pickField(data, field)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
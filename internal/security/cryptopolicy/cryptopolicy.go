@@ -0,0 +1,135 @@
+// Package cryptopolicy restricts the cryptography db-backup uses. In the
+// default policy any supported algorithm may be configured; the fips policy
+// only allows FIPS 140-approved algorithms (AES-256-GCM, SHA-2, HMAC-SHA-2,
+// TLS 1.2+ with AES-GCM suites on NIST curves) so weaker configurations are
+// refused at validation time.
+//
+// The policy is process-wide, like Go's own FIPS 140 mode: it is set once
+// when the configuration is loaded and consulted wherever TLS is set up.
+package cryptopolicy
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Policies
+const (
+	// Default allows every supported algorithm
+	Default = "default"
+	// FIPS allows FIPS 140-approved algorithms only
+	FIPS = "fips"
+)
+
+// approvedCiphers are the backup encryption algorithms allowed under the
+// fips policy
+var approvedCiphers = []string{"aes-256-gcm"}
+
+// fipsSuites are the TLS 1.2 suites approved by SP 800-52r2 that Go
+// implements; TLS 1.3 suites are not configurable and are all AES-GCM in
+// Go's FIPS 140 mode
+var fipsSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+}
+
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+var current atomic.Value
+
+// Valid reports whether name is a known policy; empty means Default
+func Valid(name string) bool {
+	switch normalize(name) {
+	case Default, FIPS:
+		return true
+	}
+	return false
+}
+
+func normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return Default
+	}
+	return name
+}
+
+// Set makes name the process-wide policy
+func Set(name string) error {
+	if !Valid(name) {
+		return fmt.Errorf("unknown crypto policy %q (want default or fips)", name)
+	}
+	current.Store(normalize(name))
+	return nil
+}
+
+// Current returns the process-wide policy
+func Current() string {
+	if name, ok := current.Load().(string); ok {
+		return name
+	}
+	return Default
+}
+
+// Strict reports whether the process runs under the fips policy
+func Strict() bool {
+	return Current() == FIPS
+}
+
+// ModuleEnabled reports whether Go's FIPS 140 cryptographic module is in
+// use, i.e. the process runs with GODEBUG=fips140=on or only
+func ModuleEnabled() bool {
+	return fips140.Enabled()
+}
+
+// CheckCipher returns an error when policy does not allow the backup
+// encryption algorithm
+func CheckCipher(policy, algorithm string) error {
+	if normalize(policy) != FIPS || contains(approvedCiphers, strings.ToLower(algorithm)) {
+		return nil
+	}
+	return fmt.Errorf("%s is not FIPS-approved, use %s", algorithm, strings.Join(approvedCiphers, " or "))
+}
+
+// HardenTLS restricts a TLS configuration to approved versions, suites and
+// curves under the current policy
+func HardenTLS(cfg *tls.Config) {
+	if !Strict() {
+		return
+	}
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	cfg.CipherSuites = fipsSuites
+	cfg.CurvePreferences = fipsCurves
+}
+
+// Tags records the crypto policy a backup was taken under, for its
+// metadata. It returns nil under the default policy.
+func Tags(policy string, encrypted bool, algorithm string) map[string]string {
+	if normalize(policy) != FIPS {
+		return nil
+	}
+	tags := map[string]string{"crypto_policy": FIPS}
+	if ModuleEnabled() {
+		tags["crypto_module"] = "go-fips140"
+	}
+	if encrypted {
+		tags["crypto_cipher"] = strings.ToLower(algorithm)
+	}
+	return tags
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
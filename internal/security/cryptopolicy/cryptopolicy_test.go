@@ -0,0 +1,68 @@
+package cryptopolicy
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestCheckCipher(t *testing.T) {
+	tests := []struct {
+		policy, algorithm string
+		wantErr           bool
+	}{
+		{"", "chacha20-poly1305", false},
+		{Default, "chacha20-poly1305", false},
+		{FIPS, "aes-256-gcm", false},
+		{"FIPS", "AES-256-GCM", false},
+		{FIPS, "chacha20-poly1305", true},
+	}
+	for _, tt := range tests {
+		if err := CheckCipher(tt.policy, tt.algorithm); (err != nil) != tt.wantErr {
+			t.Errorf("CheckCipher(%q, %q) error = %v, wantErr %v", tt.policy, tt.algorithm, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSetAndHardenTLS(t *testing.T) {
+	t.Cleanup(func() { _ = Set(Default) })
+
+	if err := Set("fips-ish"); err == nil {
+		t.Error("Set() accepted an unknown policy")
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS10}
+	HardenTLS(cfg)
+	if cfg.CipherSuites != nil || cfg.MinVersion != tls.VersionTLS10 {
+		t.Error("HardenTLS() changed the config under the default policy")
+	}
+
+	if err := Set(FIPS); err != nil {
+		t.Fatal(err)
+	}
+	if !Strict() {
+		t.Fatal("Strict() = false after Set(fips)")
+	}
+	HardenTLS(cfg)
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.CipherSuites) == 0 || len(cfg.CurvePreferences) == 0 {
+		t.Errorf("HardenTLS() = %+v", cfg)
+	}
+	for _, id := range cfg.CipherSuites {
+		if name := tls.CipherSuiteName(id); !strings.Contains(name, "_AES_") || !strings.Contains(name, "_GCM_") {
+			t.Errorf("HardenTLS() allows %s", name)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	if tags := Tags(Default, true, "aes-256-gcm"); tags != nil {
+		t.Errorf("Tags(default) = %v, want nil", tags)
+	}
+	tags := Tags(FIPS, true, "AES-256-GCM")
+	if tags["crypto_policy"] != FIPS || tags["crypto_cipher"] != "aes-256-gcm" {
+		t.Errorf("Tags(fips) = %v", tags)
+	}
+	if _, ok := Tags(FIPS, false, "aes-256-gcm")["crypto_cipher"]; ok {
+		t.Error("Tags() records a cipher for an unencrypted backup")
+	}
+}
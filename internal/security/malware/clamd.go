@@ -0,0 +1,181 @@
+package malware
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of the INSTREAM chunks sent to clamd
+const clamdChunkSize = 64 << 10
+
+// Clamd scans files by streaming them to a clamd daemon with the INSTREAM
+// command, so the daemon needs no access to the backup directory
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd creates a clamd scanner. The address is a unix socket
+// ("unix:///run/clamav/clamd.ctl" or just the path) or a TCP address
+// ("tcp://clamav:3310" or "clamav:3310").
+func NewClamd(address string, timeout time.Duration) (*Clamd, error) {
+	c := &Clamd{timeout: timeout}
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		c.network, c.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		c.network, c.address = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "/"):
+		c.network, c.address = "unix", address
+	default:
+		c.network, c.address = "tcp", address
+	}
+	if c.address == "" {
+		return nil, errors.New("clamd address is empty")
+	}
+	if c.timeout <= 0 {
+		c.timeout = 10 * time.Minute
+	}
+	return c, nil
+}
+
+// Name implements Scanner
+func (c *Clamd) Name() string {
+	return "clamav"
+}
+
+// Ping checks that the daemon is reachable
+func (c *Clamd) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply to PING: %q", reply)
+	}
+	return nil
+}
+
+// ScanFile implements Scanner
+func (c *Clamd) ScanFile(ctx context.Context, path string) ([]string, error) {
+	f, err := os.Open(path) // #nosec G304 -- artifact path from backup metadata
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.ScanStream(ctx, f)
+}
+
+// ScanStream scans everything read from r
+func (c *Clamd) ScanStream(ctx context.Context, r io.Reader) ([]string, error) {
+	reply, err := c.command(ctx, "INSTREAM", r)
+	if err != nil {
+		return nil, err
+	}
+	return parseClamdReply(reply)
+}
+
+// command sends a null-terminated command, streams r as INSTREAM chunks
+// when given, and returns the reply
+func (c *Clamd) command(ctx context.Context, cmd string, r io.Reader) (string, error) {
+	d := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("clamd: failed to connect to %s: %w", c.address, err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+	// Unblock reads and writes when the context is cancelled
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := io.WriteString(conn, "z"+cmd+"\x00"); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	if r != nil {
+		if err := writeChunks(conn, r); err != nil {
+			// clamd closes the stream early when the size limit is hit and
+			// explains why in its reply
+			if reply, rerr := readReply(conn); rerr == nil && reply != "" {
+				return reply, nil
+			}
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", fmt.Errorf("clamd: %w", err)
+		}
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("clamd: failed to read reply: %w", err)
+	}
+	return reply, nil
+}
+
+// writeChunks sends r as length-prefixed chunks ending with a zero length
+func writeChunks(w io.Writer, r io.Reader) error {
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func readReply(r io.Reader) (string, error) {
+	reply, err := bufio.NewReader(r).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+// parseClamdReply turns "stream: OK" or "stream: Eicar-Signature FOUND"
+// into threats, and "... ERROR" replies into an error
+func parseClamdReply(reply string) ([]string, error) {
+	_, result, ok := strings.Cut(reply, ": ")
+	if !ok {
+		result = reply
+	}
+	switch {
+	case result == "OK":
+		return nil, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return []string{strings.TrimSuffix(result, " FOUND")}, nil
+	case strings.HasSuffix(result, " ERROR"):
+		return nil, fmt.Errorf("clamd: %s", strings.TrimSuffix(result, " ERROR"))
+	}
+	return nil, fmt.Errorf("clamd: unexpected reply %q", reply)
+}
@@ -0,0 +1,246 @@
+// Package malware scans backup artifacts for malware with ClamAV (through
+// the clamd daemon) and YARA rules (through the yara command line tool).
+// A dump that carries a web shell, a macro dropper stored in a BLOB column
+// or a known ransomware note is flagged before it is trusted as a recovery
+// point.
+package malware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+// Scanner checks one file
+type Scanner interface {
+	// Name identifies the scanner in results, e.g. "clamav"
+	Name() string
+	// ScanFile returns the threats found in the file; none means clean
+	ScanFile(ctx context.Context, path string) ([]string, error)
+}
+
+// Finding is the verdict of one scanner
+type Finding struct {
+	Scanner string   `json:"scanner"`
+	Threats []string `json:"threats,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Result is the scan of one artifact by every configured scanner
+type Result struct {
+	BackupID  string        `json:"backup_id,omitempty"`
+	Database  string        `json:"database,omitempty"`
+	Path      string        `json:"path"`
+	ScannedAt time.Time     `json:"scanned_at"`
+	Duration  time.Duration `json:"duration"`
+	Findings  []Finding     `json:"findings"`
+}
+
+// Infected reports whether any scanner found a threat
+func (r Result) Infected() bool {
+	return len(r.Threats()) > 0
+}
+
+// Threats lists every threat found, prefixed with the scanner name
+func (r Result) Threats() []string {
+	var out []string
+	for _, f := range r.Findings {
+		for _, t := range f.Threats {
+			out = append(out, f.Scanner+":"+t)
+		}
+	}
+	return out
+}
+
+// Err joins the errors of scanners that could not complete
+func (r Result) Err() error {
+	var errs []error
+	for _, f := range r.Findings {
+		if f.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", f.Scanner, f.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status summarises the result as clean, infected or error
+func (r Result) Status() string {
+	switch {
+	case r.Infected():
+		return "infected"
+	case r.Err() != nil:
+		return "error"
+	default:
+		return "clean"
+	}
+}
+
+// Notification raises a threat alert for an infected artifact
+func (r Result) Notification() *notification.Notification {
+	threats := r.Threats()
+	return &notification.Notification{
+		Event:    notification.EventFailure,
+		Title:    fmt.Sprintf("Malware found in backup of %s", r.Database),
+		Message:  fmt.Sprintf("%d threat(s) found in %s: %s", len(threats), filepath.Base(r.Path), strings.Join(threats, ", ")),
+		Database: r.Database,
+		BackupID: r.BackupID,
+		Severity: "critical",
+		Fields: map[string]string{
+			"Artifact": r.Path,
+			"Scanners": strings.Join(r.scanners(), ", "),
+		},
+		Timestamp: r.ScannedAt,
+	}
+}
+
+func (r Result) scanners() []string {
+	names := make([]string, 0, len(r.Findings))
+	for _, f := range r.Findings {
+		names = append(names, f.Scanner)
+	}
+	return names
+}
+
+// Engine runs the configured scanners and records their results
+type Engine struct {
+	config   config.MalwareConfig
+	scanners []Scanner
+	mu       sync.Mutex
+}
+
+// New creates an engine with the scanners enabled in the configuration
+func New(cfg config.MalwareConfig) (*Engine, error) {
+	e := &Engine{config: cfg}
+	if cfg.Clamd.Address != "" {
+		s, err := NewClamd(cfg.Clamd.Address, cfg.Clamd.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		e.scanners = append(e.scanners, s)
+	}
+	if len(cfg.YARA.Rules) > 0 {
+		e.scanners = append(e.scanners, NewYARA(cfg.YARA.Binary, cfg.YARA.Rules, cfg.YARA.Timeout))
+	}
+	if len(e.scanners) == 0 {
+		return nil, errors.New("no malware scanner configured (set security.malware.clamd.address or security.malware.yara.rules)")
+	}
+	return e, nil
+}
+
+// NewWithScanners creates an engine with explicit scanners
+func NewWithScanners(cfg config.MalwareConfig, scanners ...Scanner) *Engine {
+	return &Engine{config: cfg, scanners: scanners}
+}
+
+// Scan runs every scanner over the file. Scanner failures are recorded in
+// the result rather than returned, so one unreachable daemon does not hide
+// a match by another scanner.
+func (e *Engine) Scan(ctx context.Context, backupID, database, path string) Result {
+	start := time.Now()
+	r := Result{BackupID: backupID, Database: database, Path: path, ScannedAt: start.UTC()}
+	for _, s := range e.scanners {
+		f := Finding{Scanner: s.Name()}
+		threats, err := s.ScanFile(ctx, path)
+		if err != nil {
+			f.Error = err.Error()
+		}
+		f.Threats = threats
+		r.Findings = append(r.Findings, f)
+	}
+	r.Duration = time.Since(start)
+	return r
+}
+
+// Record stores a result, replacing the previous scan of the same backup
+// (or path, for artifacts without a backup ID)
+func (e *Engine) Record(r Result) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	results, err := e.load()
+	if err != nil {
+		return err
+	}
+	results[resultKey(r)] = r
+	return e.save(results)
+}
+
+// Results returns the recorded scans, newest first
+func (e *Engine) Results() ([]Result, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	results, err := e.load()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Result, 0, len(results))
+	for _, r := range results {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ScannedAt.After(out[j].ScannedAt) })
+	return out, nil
+}
+
+// LastScan returns when a backup was last scanned, zero if never
+func (e *Engine) LastScan(backupID string) (time.Time, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	results, err := e.load()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return results[backupID].ScannedAt, nil
+}
+
+func resultKey(r Result) string {
+	if r.BackupID != "" {
+		return r.BackupID
+	}
+	return r.Path
+}
+
+func (e *Engine) load() (map[string]Result, error) {
+	results := make(map[string]Result)
+	data, err := os.ReadFile(e.config.StateFile)
+	if os.IsNotExist(err) {
+		return results, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scan results: %w", err)
+	}
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse scan results: %w", err)
+	}
+	return results, nil
+}
+
+// save writes the results atomically
+func (e *Engine) save(results map[string]Result) error {
+	if e.config.StateFile == "" {
+		return fmt.Errorf("malware state_file is not configured")
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal scan results: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(e.config.StateFile), 0750); err != nil {
+		return fmt.Errorf("failed to create scan results directory: %w", err)
+	}
+	tmp := e.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write scan results: %w", err)
+	}
+	return os.Rename(tmp, e.config.StateFile)
+}
@@ -0,0 +1,242 @@
+package malware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd answers INSTREAM like clamd, reporting the EICAR test string
+// and rejecting streams over maxStream bytes
+func fakeClamd(t *testing.T, maxStream int) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "clamd.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil {
+					return
+				}
+				switch cmd {
+				case "zPING\x00":
+					_, _ = io.WriteString(conn, "PONG\x00")
+				case "zINSTREAM\x00":
+					var data bytes.Buffer
+					for {
+						var size uint32
+						if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+							return
+						}
+						if size == 0 {
+							break
+						}
+						if data.Len()+int(size) > maxStream {
+							_, _ = io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+							return
+						}
+						if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+							return
+						}
+					}
+					if strings.Contains(data.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+						_, _ = io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+						return
+					}
+					_, _ = io.WriteString(conn, "stream: OK\x00")
+				}
+			}(conn)
+		}
+	}()
+	return "unix://" + sock
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClamd(t *testing.T) {
+	addr := fakeClamd(t, 1<<20)
+	c, err := NewClamd(addr, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	// Large enough to span several INSTREAM chunks
+	clean := strings.Repeat("INSERT INTO t VALUES (1);\n", 10000)
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{name: "clean", content: clean},
+		{name: "eicar", content: clean + eicar, want: []string{"Eicar-Test-Signature"}},
+		{name: "too large", content: strings.Repeat("x", 2<<20), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			threats, err := c.ScanFile(context.Background(), writeFile(t, "dump.sql", tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ScanFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(threats, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("threats = %v, want %v", threats, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClamdAddress(t *testing.T) {
+	tests := []struct{ address, network, addr string }{
+		{"unix:///run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+		{"/run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+		{"tcp://clamav:3310", "tcp", "clamav:3310"},
+		{"clamav:3310", "tcp", "clamav:3310"},
+	}
+	for _, tt := range tests {
+		c, err := NewClamd(tt.address, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.network != tt.network || c.address != tt.addr {
+			t.Errorf("NewClamd(%q) = %s %s, want %s %s", tt.address, c.network, c.address, tt.network, tt.addr)
+		}
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply   string
+		want    string
+		wantErr bool
+	}{
+		{reply: "stream: OK"},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", want: "Win.Test.EICAR_HDB-1"},
+		{reply: "INSTREAM size limit exceeded. ERROR", wantErr: true},
+		{reply: "garbage", wantErr: true},
+	}
+	for _, tt := range tests {
+		threats, err := parseClamdReply(tt.reply)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseClamdReply(%q) error = %v", tt.reply, err)
+		}
+		if strings.Join(threats, ",") != tt.want {
+			t.Errorf("parseClamdReply(%q) = %v, want %s", tt.reply, threats, tt.want)
+		}
+	}
+}
+
+func TestYARA(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake yara binary is a shell script")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "yara")
+	// Prints a match for every rules file when the target contains "webshell"
+	script := `#!/bin/sh
+shift
+target=$(eval echo \${$#})
+if grep -q webshell "$target"; then
+  echo "PHP_Webshell [web] $target"
+  echo "PHP_Webshell [web] $target"
+  echo "Suspicious_Eval $target"
+fi
+`
+	if err := os.WriteFile(bin, []byte(script), 0700); err != nil { // #nosec G306 -- test executable
+		t.Fatal(err)
+	}
+
+	y := NewYARA(bin, []string{"/rules/web.yar"}, time.Minute)
+	threats, err := y.ScanFile(context.Background(), writeFile(t, "dump.sql", "INSERT INTO pages VALUES ('<?php webshell ?>');"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(threats, ","); got != "PHP_Webshell,Suspicious_Eval" {
+		t.Errorf("threats = %s", got)
+	}
+
+	threats, err = y.ScanFile(context.Background(), writeFile(t, "dump.sql", "INSERT INTO pages VALUES ('hello');"))
+	if err != nil || len(threats) != 0 {
+		t.Errorf("clean file = %v, %v", threats, err)
+	}
+}
+
+// stubScanner returns fixed threats or an error
+type stubScanner struct {
+	name    string
+	threats []string
+	err     error
+}
+
+func (s stubScanner) Name() string { return s.name }
+
+func (s stubScanner) ScanFile(context.Context, string) ([]string, error) {
+	return s.threats, s.err
+}
+
+func TestEngine(t *testing.T) {
+	cfg := config.MalwareConfig{StateFile: filepath.Join(t.TempDir(), "scans.json")}
+	e := NewWithScanners(cfg,
+		stubScanner{name: "clamav", err: io.ErrUnexpectedEOF},
+		stubScanner{name: "yara", threats: []string{"Ransom_Note"}},
+	)
+
+	r := e.Scan(context.Background(), "b1", "orders", "/backups/b1.sql.zst")
+	if !r.Infected() || r.Status() != "infected" {
+		t.Errorf("result status = %s, want infected despite the clamav error", r.Status())
+	}
+	if r.Err() == nil {
+		t.Error("Err() = nil, want the clamav error")
+	}
+	if got := strings.Join(r.Threats(), ","); got != "yara:Ransom_Note" {
+		t.Errorf("Threats() = %s", got)
+	}
+	if n := r.Notification(); n.Severity != "critical" || n.BackupID != "b1" {
+		t.Errorf("notification = %+v", n)
+	}
+
+	if err := e.Record(r); err != nil {
+		t.Fatal(err)
+	}
+	results, err := e.Results()
+	if err != nil || len(results) != 1 || results[0].BackupID != "b1" {
+		t.Fatalf("Results() = %+v, %v", results, err)
+	}
+	if at, _ := e.LastScan("b1"); at.IsZero() {
+		t.Error("LastScan() is zero after recording")
+	}
+}
@@ -0,0 +1,77 @@
+package malware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// YARA scans files with the yara command line tool. Rules are given as
+// source files; each matching rule is reported as a threat.
+type YARA struct {
+	binary  string
+	rules   []string
+	timeout time.Duration
+}
+
+// NewYARA creates a YARA scanner
+func NewYARA(binary string, rules []string, timeout time.Duration) *YARA {
+	if binary == "" {
+		binary = "yara"
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	return &YARA{binary: binary, rules: rules, timeout: timeout}
+}
+
+// Name implements Scanner
+func (y *YARA) Name() string {
+	return "yara"
+}
+
+// ScanFile implements Scanner
+func (y *YARA) ScanFile(ctx context.Context, path string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, y.timeout)
+	defer cancel()
+
+	// yara [options] RULES_FILE... TARGET
+	args := append([]string{"--no-warnings"}, y.rules...)
+	args = append(args, path)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, y.binary, args...) // #nosec G204 -- binary and rules are operator supplied
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("yara timed out after %s", y.timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("yara: %s", msg)
+		}
+		return nil, fmt.Errorf("yara: %w", err)
+	}
+	return parseYARAOutput(stdout.String()), nil
+}
+
+// parseYARAOutput reads "RuleName [tags] path" lines
+func parseYARAOutput(out string) []string {
+	var rules []string
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		rules = append(rules, fields[0])
+	}
+	return rules
+}
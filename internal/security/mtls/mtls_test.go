@@ -0,0 +1,229 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// testPKI is a throwaway CA with a server certificate, written to dir
+type testPKI struct {
+	t      *testing.T
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	p := &testPKI{t: t, dir: t.TempDir(), serial: 1}
+	p.caKey = newKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(p.serial),
+		Subject:               pkix.Name{CommonName: "test backup CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &p.caKey.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	p.writePEM("ca.pem", "CERTIFICATE", der)
+	return p
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (p *testPKI) writePEM(name, typ string, der []byte) string {
+	p.t.Helper()
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		p.t.Fatal(err)
+	}
+	return path
+}
+
+// issue signs a leaf certificate and writes <name>.pem and <name>-key.pem
+func (p *testPKI) issue(name string, usage x509.ExtKeyUsage) (*x509.Certificate, tls.Certificate) {
+	p.t.Helper()
+	p.serial++
+	key := newKey(p.t)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	certFile := p.writePEM(name+".pem", "CERTIFICATE", der)
+	keyFile := p.writePEM(name+"-key.pem", "EC PRIVATE KEY", keyDER)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, pair
+}
+
+// revoke writes crl.pem revoking the given certificates
+func (p *testPKI) revoke(certs ...*x509.Certificate) string {
+	p.t.Helper()
+	var entries []x509.RevocationListEntry
+	for _, c := range certs {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: c.SerialNumber, RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(time.Now().UnixNano()),
+		ThisUpdate:                time.Now().Add(-time.Minute),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, p.ca, p.caKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return p.writePEM("crl.pem", "X509 CRL", der)
+}
+
+func TestServerConfigClientAuth(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.issue("server", x509.ExtKeyUsageServerAuth)
+	_, agent := pki.issue("agent-1", x509.ExtKeyUsageClientAuth)
+	revokedCert, revoked := pki.issue("agent-2", x509.ExtKeyUsageClientAuth)
+	_, stranger := pki.issue("laptop", x509.ExtKeyUsageClientAuth)
+	crlFile := pki.revoke(revokedCert)
+
+	tests := []struct {
+		name       string
+		clientAuth string
+		allowed    []string
+		clientCert *tls.Certificate
+		wantOK     bool
+	}{
+		{"require with valid cert", ClientAuthRequire, nil, &agent, true},
+		{"require without cert", ClientAuthRequire, nil, nil, false},
+		{"require with revoked cert", ClientAuthRequire, nil, &revoked, false},
+		{"optional without cert", ClientAuthOptional, nil, nil, true},
+		{"optional with revoked cert", ClientAuthOptional, nil, &revoked, false},
+		{"allowed name", ClientAuthRequire, []string{"AGENT-1"}, &agent, true},
+		{"name not allowed", ClientAuthRequire, []string{"agent-1"}, &stranger, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverTLS, err := ServerConfig(config.TLSConfig{
+				Enabled:      true,
+				CertFile:     filepath.Join(pki.dir, "server.pem"),
+				KeyFile:      filepath.Join(pki.dir, "server-key.pem"),
+				ClientAuth:   tt.clientAuth,
+				ClientCAFile: filepath.Join(pki.dir, "ca.pem"),
+				CRLFile:      crlFile,
+				AllowedNames: tt.allowed,
+			})
+			if err != nil {
+				t.Fatalf("ServerConfig() error = %v", err)
+			}
+
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(Identity(r.TLS)))
+			}))
+			srv.TLS = serverTLS
+			srv.StartTLS()
+			defer srv.Close()
+
+			clientTLS, err := ClientConfig(filepath.Join(pki.dir, "ca.pem"), "", "", "server")
+			if err != nil {
+				t.Fatalf("ClientConfig() error = %v", err)
+			}
+			if tt.clientCert != nil {
+				clientTLS.Certificates = []tls.Certificate{*tt.clientCert}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if gotOK := err == nil; gotOK != tt.wantOK {
+				t.Errorf("request succeeded = %v, want %v (err: %v)", gotOK, tt.wantOK, err)
+			}
+		})
+	}
+}
+
+func TestCRLReloadsOnChange(t *testing.T) {
+	pki := newTestPKI(t)
+	cert, _ := pki.issue("agent-1", x509.ExtKeyUsageClientAuth)
+	crlFile := pki.revoke()
+
+	crl, err := NewCRL(crlFile, []*x509.Certificate{pki.ca})
+	if err != nil {
+		t.Fatalf("NewCRL() error = %v", err)
+	}
+	if revoked, err := crl.IsRevoked(cert); err != nil || revoked {
+		t.Fatalf("IsRevoked() = %v, %v before revocation", revoked, err)
+	}
+
+	pki.revoke(cert)
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(crlFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := crl.IsRevoked(cert); err != nil || !revoked {
+		t.Errorf("IsRevoked() = %v, %v after revocation, want true", revoked, err)
+	}
+
+	// An unreadable list fails closed
+	if err := os.Remove(crlFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crl.IsRevoked(cert); err == nil {
+		t.Error("IsRevoked() succeeded with the list missing")
+	}
+}
+
+func TestNewCRLRejectsForeignIssuer(t *testing.T) {
+	pki := newTestPKI(t)
+	other := newTestPKI(t)
+
+	if _, err := NewCRL(other.revoke(), []*x509.Certificate{pki.ca}); err == nil {
+		t.Error("NewCRL() accepted a list signed by an untrusted CA")
+	}
+}
@@ -0,0 +1,316 @@
+// Package quarantine holds backups flagged as suspicious, for example by
+// the ransomware baseline, out of circulation. A quarantined backup is
+// skipped when picking the latest backup to restore and is never pruned,
+// so a possibly encrypted dump neither gets restored by accident nor
+// pushes the last good backup out of retention. It stays quarantined until
+// someone releases it explicitly; every quarantine and release is kept in
+// an append-only audit trail.
+package quarantine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+// Audit actions
+const (
+	ActionQuarantined = "quarantined"
+	ActionReleased    = "released"
+)
+
+var (
+	// ErrNotFound is returned for backups that were never quarantined
+	ErrNotFound = errors.New("backup is not quarantined")
+	// ErrAlreadyReleased is returned when releasing a released backup
+	ErrAlreadyReleased = errors.New("backup was already released")
+	// ErrReasonRequired is returned for a release without a reason when
+	// require_reason is set
+	ErrReasonRequired = errors.New("a reason is required to release a quarantined backup")
+)
+
+// Entry is a backup that was quarantined
+type Entry struct {
+	BackupID      string            `json:"backup_id"`
+	Database      string            `json:"database"`
+	Reason        string            `json:"reason"`
+	Details       map[string]string `json:"details,omitempty"`
+	QuarantinedAt time.Time         `json:"quarantined_at"`
+	QuarantinedBy string            `json:"quarantined_by"`
+	ReleasedAt    *time.Time        `json:"released_at,omitempty"`
+	ReleasedBy    string            `json:"released_by,omitempty"`
+	ReleaseReason string            `json:"release_reason,omitempty"`
+}
+
+// Active reports whether the backup is still quarantined
+func (e Entry) Active() bool {
+	return e.ReleasedAt == nil
+}
+
+// Notification describes the quarantine, or the release, for the notifiers
+func (e Entry) Notification() *notification.Notification {
+	if !e.Active() {
+		return &notification.Notification{
+			Event:     notification.EventSuccess,
+			Title:     fmt.Sprintf("Backup %s of %s released from quarantine", e.BackupID, e.Database),
+			Message:   fmt.Sprintf("Released by %s: %s", e.ReleasedBy, orNone(e.ReleaseReason)),
+			Database:  e.Database,
+			BackupID:  e.BackupID,
+			Timestamp: *e.ReleasedAt,
+		}
+	}
+	return &notification.Notification{
+		Event:    notification.EventWarning,
+		Title:    fmt.Sprintf("Backup %s of %s quarantined", e.BackupID, e.Database),
+		Message:  e.Reason + ". It is excluded from restore-latest and prune until released with \"db-backup security quarantine release " + e.BackupID + "\".",
+		Database: e.Database,
+		BackupID: e.BackupID,
+		Fields:   e.Details,
+		// Timestamp of the quarantine, not of the delivery
+		Timestamp: e.QuarantinedAt,
+	}
+}
+
+// AuditEvent is one quarantine or release
+type AuditEvent struct {
+	At       time.Time `json:"at"`
+	Action   string    `json:"action"`
+	BackupID string    `json:"backup_id"`
+	Database string    `json:"database"`
+	Actor    string    `json:"actor"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// state is the persisted quarantine
+type state struct {
+	Entries map[string]*Entry `json:"entries"`
+	Audit   []AuditEvent      `json:"audit"`
+}
+
+// Store keeps the quarantine in a state file shared by CLI runs and the
+// API server
+type Store struct {
+	config config.QuarantineConfig
+	mu     sync.Mutex
+}
+
+// New creates a store
+func New(cfg config.QuarantineConfig) *Store {
+	return &Store{config: cfg}
+}
+
+// Quarantine takes a backup out of circulation. Quarantining a backup that
+// is already quarantined is a no-op; one that was released is quarantined
+// again. It reports whether the backup was newly quarantined.
+func (s *Store) Quarantine(e Entry, actor string) (bool, error) {
+	if e.BackupID == "" {
+		return false, errors.New("backup ID is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	if existing, ok := st.Entries[e.BackupID]; ok && existing.Active() {
+		return false, nil
+	}
+
+	if e.QuarantinedAt.IsZero() {
+		e.QuarantinedAt = time.Now().UTC()
+	}
+	e.QuarantinedBy = actor
+	e.ReleasedAt, e.ReleasedBy, e.ReleaseReason = nil, "", ""
+	st.Entries[e.BackupID] = &e
+	st.Audit = append(st.Audit, AuditEvent{
+		At:       e.QuarantinedAt,
+		Action:   ActionQuarantined,
+		BackupID: e.BackupID,
+		Database: e.Database,
+		Actor:    actor,
+		Reason:   e.Reason,
+	})
+	return true, s.save(st)
+}
+
+// Release returns a quarantined backup to circulation
+func (s *Store) Release(backupID, actor, reason string) (*Entry, error) {
+	reason = strings.TrimSpace(reason)
+	if s.config.RequireReason && reason == "" {
+		return nil, ErrReasonRequired
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := st.Entries[backupID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !e.Active() {
+		return nil, ErrAlreadyReleased
+	}
+
+	now := time.Now().UTC()
+	e.ReleasedAt, e.ReleasedBy, e.ReleaseReason = &now, actor, reason
+	st.Audit = append(st.Audit, AuditEvent{
+		At:       now,
+		Action:   ActionReleased,
+		BackupID: backupID,
+		Database: e.Database,
+		Actor:    actor,
+		Reason:   reason,
+	})
+	if err := s.save(st); err != nil {
+		return nil, err
+	}
+	released := *e
+	return &released, nil
+}
+
+// Get returns the quarantine entry of a backup
+func (s *Store) Get(backupID string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := st.Entries[backupID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	entry := *e
+	return &entry, nil
+}
+
+// IsQuarantined reports whether a backup is currently quarantined
+func (s *Store) IsQuarantined(backupID string) (bool, error) {
+	e, err := s.Get(backupID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return e.Active(), nil
+}
+
+// Quarantined returns the IDs of every currently quarantined backup, for
+// filtering candidates in restore-latest and prune
+func (s *Store) Quarantined() (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for id, e := range st.Entries {
+		if e.Active() {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// List returns quarantine entries, newest first; released entries are
+// included only when asked for
+func (s *Store) List(includeReleased bool) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	var out []Entry
+	for _, e := range st.Entries {
+		if e.Active() || includeReleased {
+			out = append(out, *e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].QuarantinedAt.After(out[j].QuarantinedAt) })
+	return out, nil
+}
+
+// Audit returns the audit trail in chronological order, limited to one
+// backup unless backupID is empty
+func (s *Store) Audit(backupID string) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if backupID == "" {
+		return st.Audit, nil
+	}
+	var out []AuditEvent
+	for _, ev := range st.Audit {
+		if ev.BackupID == backupID {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+func (s *Store) load() (*state, error) {
+	st := &state{Entries: make(map[string]*Entry)}
+	data, err := os.ReadFile(s.config.StateFile)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quarantine state: %w", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse quarantine state: %w", err)
+	}
+	if st.Entries == nil {
+		st.Entries = make(map[string]*Entry)
+	}
+	return st, nil
+}
+
+// save writes the state atomically
+func (s *Store) save(st *state) error {
+	if s.config.StateFile == "" {
+		return fmt.Errorf("quarantine state_file is not configured")
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantine state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.config.StateFile), 0750); err != nil {
+		return fmt.Errorf("failed to create quarantine state directory: %w", err)
+	}
+	tmp := s.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write quarantine state: %w", err)
+	}
+	return os.Rename(tmp, s.config.StateFile)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "no reason given"
+	}
+	return s
+}
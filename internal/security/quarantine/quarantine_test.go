@@ -0,0 +1,122 @@
+package quarantine
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+func newTestStore(t *testing.T, requireReason bool) *Store {
+	t.Helper()
+	return New(config.QuarantineConfig{
+		Enabled:       true,
+		StateFile:     filepath.Join(t.TempDir(), "quarantine.json"),
+		RequireReason: requireReason,
+	})
+}
+
+func TestQuarantineAndRelease(t *testing.T) {
+	s := newTestStore(t, true)
+
+	added, err := s.Quarantine(Entry{BackupID: "b1", Database: "orders", Reason: "entropy rose to 7.99"}, "ransomware-baseline")
+	if err != nil || !added {
+		t.Fatalf("Quarantine() = %v, %v", added, err)
+	}
+	if added, err = s.Quarantine(Entry{BackupID: "b1", Database: "orders"}, "ransomware-baseline"); err != nil || added {
+		t.Fatalf("second Quarantine() = %v, %v, want no-op", added, err)
+	}
+	if _, err := s.Quarantine(Entry{BackupID: "b2", Database: "users", Reason: "manual"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := s.Quarantined()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ids["b1"] || !ids["b2"] || len(ids) != 2 {
+		t.Errorf("Quarantined() = %v, want b1 and b2", ids)
+	}
+
+	if _, err := s.Release("b1", "alice", " "); !errors.Is(err, ErrReasonRequired) {
+		t.Errorf("Release() without reason error = %v, want ErrReasonRequired", err)
+	}
+	e, err := s.Release("b1", "alice", "source verified clean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Active() || e.ReleasedBy != "alice" {
+		t.Errorf("released entry = %+v", e)
+	}
+	if _, err := s.Release("b1", "alice", "again"); !errors.Is(err, ErrAlreadyReleased) {
+		t.Errorf("second Release() error = %v, want ErrAlreadyReleased", err)
+	}
+	if _, err := s.Release("nope", "alice", "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Release() of unknown backup error = %v, want ErrNotFound", err)
+	}
+
+	if q, err := s.IsQuarantined("b1"); err != nil || q {
+		t.Errorf("IsQuarantined(b1) = %v, %v after release", q, err)
+	}
+	if q, err := s.IsQuarantined("b2"); err != nil || !q {
+		t.Errorf("IsQuarantined(b2) = %v, %v", q, err)
+	}
+
+	active, _ := s.List(false)
+	all, _ := s.List(true)
+	if len(active) != 1 || len(all) != 2 {
+		t.Errorf("List() = %d active, %d total, want 1 and 2", len(active), len(all))
+	}
+
+	// Quarantining a released backup again starts a new episode
+	if added, err = s.Quarantine(Entry{BackupID: "b1", Database: "orders", Reason: "flagged again"}, "ransomware-baseline"); err != nil || !added {
+		t.Fatalf("re-Quarantine() = %v, %v", added, err)
+	}
+
+	audit, err := s.Audit("b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{ActionQuarantined, ActionReleased, ActionQuarantined}
+	if len(audit) != len(want) {
+		t.Fatalf("audit = %+v, want %d events", audit, len(want))
+	}
+	for i, ev := range audit {
+		if ev.Action != want[i] {
+			t.Errorf("audit[%d].Action = %s, want %s", i, ev.Action, want[i])
+		}
+	}
+	if audit[1].Actor != "alice" || audit[1].Reason != "source verified clean" {
+		t.Errorf("release audit = %+v", audit[1])
+	}
+	if all, _ := s.Audit(""); len(all) != 4 {
+		t.Errorf("full audit has %d events, want 4", len(all))
+	}
+}
+
+func TestReleaseWithoutReasonAllowed(t *testing.T) {
+	s := newTestStore(t, false)
+	if _, err := s.Quarantine(Entry{BackupID: "b1", Database: "orders"}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Release("b1", "alice", ""); err != nil {
+		t.Errorf("Release() error = %v", err)
+	}
+}
+
+func TestNotification(t *testing.T) {
+	s := newTestStore(t, false)
+	if _, err := s.Quarantine(Entry{BackupID: "b1", Database: "orders", Reason: "suspicious"}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := s.Get("b1")
+	if n := e.Notification(); n.Event != notification.EventWarning || n.BackupID != "b1" {
+		t.Errorf("quarantine notification = %+v", n)
+	}
+	e, _ = s.Release("b1", "alice", "ok")
+	if n := e.Notification(); n.Event != notification.EventSuccess {
+		t.Errorf("release notification = %+v", n)
+	}
+}
@@ -0,0 +1,440 @@
+package ransomware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// Baseline metrics
+const (
+	MetricEntropy = "entropy"
+	MetricRatio   = "compression_ratio"
+)
+
+// Sample describes one backup artifact
+type Sample struct {
+	At       time.Time `json:"at"`
+	BackupID string    `json:"backup_id,omitempty"`
+	// Entropy is the Shannon entropy of the artifact in bits per byte
+	// (0-8); 0 when it was not measured
+	Entropy float64 `json:"entropy,omitempty"`
+	// Ratio is the compressed size as a fraction of the raw size; 0 when
+	// either size is unknown
+	Ratio float64 `json:"ratio,omitempty"`
+}
+
+// Value returns the sample's value for a metric
+func (s Sample) Value(metric string) float64 {
+	switch metric {
+	case MetricEntropy:
+		return s.Entropy
+	case MetricRatio:
+		return s.Ratio
+	}
+	return 0
+}
+
+// Stats is the learned distribution of one metric
+type Stats struct {
+	Samples int     `json:"samples"`
+	Mean    float64 `json:"mean"`
+	StdDev  float64 `json:"stddev"`
+}
+
+// Baseline is what a database's backups normally look like
+type Baseline struct {
+	Database string    `json:"database"`
+	Entropy  Stats     `json:"entropy"`
+	Ratio    Stats     `json:"compression_ratio"`
+	Updated  time.Time `json:"updated,omitempty"`
+	// Learning is set until enough backups have been seen to judge one
+	Learning bool `json:"learning"`
+	// Flagged counts backups that deviated and were kept out of the
+	// baseline
+	Flagged int `json:"flagged,omitempty"`
+}
+
+// Deviation is a metric that rose beyond the baseline
+type Deviation struct {
+	Metric   string  `json:"metric"`
+	Value    float64 `json:"value"`
+	Expected float64 `json:"expected"`
+	StdDev   float64 `json:"stddev"`
+	// Sigmas is the distance from the mean in standard deviations; 0 when
+	// the baseline never varied
+	Sigmas float64 `json:"sigmas"`
+}
+
+// Verdict is the result of comparing a backup with its baseline
+type Verdict struct {
+	Database   string      `json:"database"`
+	Sample     Sample      `json:"sample"`
+	Baseline   Baseline    `json:"baseline"`
+	Deviations []Deviation `json:"deviations,omitempty"`
+}
+
+// Suspicious reports whether the backup deviates from the baseline
+func (v Verdict) Suspicious() bool {
+	return len(v.Deviations) > 0
+}
+
+// Notification describes a suspicious backup for the notifiers
+func (v Verdict) Notification() *notification.Notification {
+	var parts []string
+	fields := make(map[string]string)
+	for _, d := range v.Deviations {
+		switch d.Metric {
+		case MetricEntropy:
+			parts = append(parts, fmt.Sprintf("entropy %.2f bits/byte, usually %.2f", d.Value, d.Expected))
+			fields["Entropy"] = fmt.Sprintf("%.2f (baseline %.2f ± %.2f)", d.Value, d.Expected, d.StdDev)
+		case MetricRatio:
+			parts = append(parts, fmt.Sprintf("compresses to %.0f%%, usually %.0f%%", d.Value*100, d.Expected*100))
+			fields["Compression ratio"] = fmt.Sprintf("%.1f%% (baseline %.1f%%)", d.Value*100, d.Expected*100)
+		}
+	}
+	fields["Baseline backups"] = fmt.Sprint(max(v.Baseline.Entropy.Samples, v.Baseline.Ratio.Samples))
+
+	at := v.Sample.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return &notification.Notification{
+		Event:     notification.EventWarning,
+		Title:     fmt.Sprintf("Backup of %s deviates from its baseline: possible ransomware encryption", v.Database),
+		Message:   "The backup looks unlike previous backups of this database: " + strings.Join(parts, "; ") + ". Verify the source data before relying on this backup.",
+		Database:  v.Database,
+		BackupID:  v.Sample.BackupID,
+		Fields:    fields,
+		Timestamp: at,
+	}
+}
+
+// baselineState is the persisted state for one database
+type baselineState struct {
+	Samples []Sample `json:"samples,omitempty"`
+	Flagged int      `json:"flagged,omitempty"`
+}
+
+// BaselineStore learns per-database baselines from recent backups and
+// judges new backups against them. Fixed entropy thresholds misfire on
+// dumps that are compressed or encrypted by design; a baseline instead
+// flags a database whose backups suddenly stop looking like they used to,
+// such as a plain SQL dump turning into random bytes.
+type BaselineStore struct {
+	config config.RansomwareBaselineConfig
+	mu     sync.Mutex
+}
+
+// NewBaselineStore creates a store backed by the configured state file
+func NewBaselineStore(cfg config.RansomwareBaselineConfig) *BaselineStore {
+	if cfg.Window < 2 {
+		cfg.Window = 30
+	}
+	if cfg.MinSamples < 2 {
+		cfg.MinSamples = 5
+	}
+	if cfg.MinSamples > cfg.Window {
+		cfg.MinSamples = cfg.Window
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 3
+	}
+	return &BaselineStore{config: cfg}
+}
+
+// Evaluate compares a backup with the database's baseline without
+// learning from it
+func (b *BaselineStore) Evaluate(database string, s Sample) (Verdict, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, err := b.load()
+	if err != nil {
+		return Verdict{}, err
+	}
+	return b.evaluate(database, state[database], s), nil
+}
+
+// Observe evaluates a backup and learns from it. A suspicious backup is
+// kept out of the baseline so an attack in progress cannot teach the
+// baseline that encrypted data is normal.
+func (b *BaselineStore) Observe(database string, s Sample) (Verdict, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, err := b.load()
+	if err != nil {
+		return Verdict{}, err
+	}
+	st := state[database]
+	if st == nil {
+		st = &baselineState{}
+		state[database] = st
+	}
+
+	v := b.evaluate(database, st, s)
+	if v.Suspicious() {
+		st.Flagged++
+	} else {
+		b.learn(st, s)
+	}
+	if err := b.save(state); err != nil {
+		return Verdict{}, err
+	}
+	v.Baseline = b.baseline(database, st)
+	return v, nil
+}
+
+// Learn seeds a database's baseline from historical backups, replacing
+// what was learned before. Only the newest window samples are kept.
+func (b *BaselineStore) Learn(database string, history []Sample) (Baseline, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, err := b.load()
+	if err != nil {
+		return Baseline{}, err
+	}
+	history = append([]Sample(nil), history...)
+	sort.Slice(history, func(i, j int) bool { return history[i].At.Before(history[j].At) })
+
+	st := &baselineState{}
+	for _, s := range history {
+		b.learn(st, s)
+	}
+	state[database] = st
+	if err := b.save(state); err != nil {
+		return Baseline{}, err
+	}
+	return b.baseline(database, st), nil
+}
+
+// Reset forgets a database's baseline so it is learned again
+func (b *BaselineStore) Reset(database string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, err := b.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := state[database]; !ok {
+		return fmt.Errorf("no baseline recorded for %s", database)
+	}
+	delete(state, database)
+	return b.save(state)
+}
+
+// Baselines returns the baseline of every database, sorted by name
+func (b *BaselineStore) Baselines() ([]Baseline, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Baseline, 0, len(state))
+	for name, st := range state {
+		out = append(out, b.baseline(name, st))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Database < out[j].Database })
+	return out, nil
+}
+
+// learn appends a sample, keeping the newest window samples
+func (b *BaselineStore) learn(st *baselineState, s Sample) {
+	if s.Entropy <= 0 && s.Ratio <= 0 {
+		return
+	}
+	if s.At.IsZero() {
+		s.At = time.Now()
+	}
+	st.Samples = append(st.Samples, s)
+	if extra := len(st.Samples) - b.config.Window; extra > 0 {
+		st.Samples = st.Samples[extra:]
+	}
+}
+
+func (b *BaselineStore) baseline(database string, st *baselineState) Baseline {
+	bl := Baseline{Database: database, Learning: true}
+	if st == nil {
+		return bl
+	}
+	bl.Entropy = stats(st.Samples, MetricEntropy)
+	bl.Ratio = stats(st.Samples, MetricRatio)
+	bl.Flagged = st.Flagged
+	bl.Learning = bl.Entropy.Samples < b.config.MinSamples && bl.Ratio.Samples < b.config.MinSamples
+	if n := len(st.Samples); n > 0 {
+		bl.Updated = st.Samples[n-1].At
+	}
+	return bl
+}
+
+// evaluate flags metrics that rose more than Threshold standard deviations
+// above the baseline mean and by at least the configured minimum change.
+// The minimum matters for databases whose backups barely vary: a dump that
+// is always compressed has an entropy of 7.99 ± 0.001, and without a floor
+// a reading of 7.995 would count as an anomaly. Only rises are flagged;
+// encryption makes data more random and less compressible, never less.
+func (b *BaselineStore) evaluate(database string, st *baselineState, s Sample) Verdict {
+	v := Verdict{Database: database, Sample: s, Baseline: b.baseline(database, st)}
+
+	check := func(metric string, st Stats, value, minChange float64) {
+		if value <= 0 || st.Samples < b.config.MinSamples {
+			return
+		}
+		rise := value - st.Mean
+		if rise < minChange || rise <= b.config.Threshold*st.StdDev {
+			return
+		}
+		var sigmas float64
+		if st.StdDev > 0 {
+			sigmas = rise / st.StdDev
+		}
+		v.Deviations = append(v.Deviations, Deviation{
+			Metric:   metric,
+			Value:    value,
+			Expected: st.Mean,
+			StdDev:   st.StdDev,
+			Sigmas:   sigmas,
+		})
+	}
+	check(MetricEntropy, v.Baseline.Entropy, s.Entropy, b.config.MinEntropyChange)
+	// The ratio floor is relative: 0.25 means compressing 25% worse
+	check(MetricRatio, v.Baseline.Ratio, s.Ratio, b.config.MinRatioChange*v.Baseline.Ratio.Mean)
+	return v
+}
+
+// stats computes the mean and population standard deviation of the
+// samples that have a value for the metric
+func stats(samples []Sample, metric string) Stats {
+	var st Stats
+	var sum float64
+	for _, s := range samples {
+		if v := s.Value(metric); v > 0 {
+			sum += v
+			st.Samples++
+		}
+	}
+	if st.Samples == 0 {
+		return st
+	}
+	st.Mean = sum / float64(st.Samples)
+
+	var variance float64
+	for _, s := range samples {
+		if v := s.Value(metric); v > 0 {
+			d := v - st.Mean
+			variance += d * d
+		}
+	}
+	st.StdDev = math.Sqrt(variance / float64(st.Samples))
+	return st
+}
+
+func (b *BaselineStore) load() (map[string]*baselineState, error) {
+	state := make(map[string]*baselineState)
+	data, err := os.ReadFile(b.config.StateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline state: %w", err)
+	}
+	return state, nil
+}
+
+// save writes the state atomically
+func (b *BaselineStore) save(state map[string]*baselineState) error {
+	if b.config.StateFile == "" {
+		return fmt.Errorf("ransomware baseline state_file is not configured")
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal baseline state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(b.config.StateFile), 0750); err != nil {
+		return fmt.Errorf("failed to create baseline state directory: %w", err)
+	}
+	tmp := b.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write baseline state: %w", err)
+	}
+	return os.Rename(tmp, b.config.StateFile)
+}
+
+// EntropyMeter measures the Shannon entropy of the bytes written to it, so
+// it can sit in an io.MultiWriter next to the artifact being written
+type EntropyMeter struct {
+	counts [256]int64
+	total  int64
+}
+
+// Write counts the bytes; it never fails
+func (m *EntropyMeter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		m.counts[c]++
+	}
+	m.total += int64(len(p))
+	return len(p), nil
+}
+
+// Bytes returns the number of bytes measured
+func (m *EntropyMeter) Bytes() int64 {
+	return m.total
+}
+
+// Entropy returns the entropy in bits per byte: about 4.5-5.5 for SQL
+// text, close to 8 for compressed or encrypted data
+func (m *EntropyMeter) Entropy() float64 {
+	if m.total == 0 {
+		return 0
+	}
+	var h float64
+	n := float64(m.total)
+	for _, c := range m.counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// MeasureFile returns the entropy of the first limit bytes of a file, or
+// of the whole file when limit is not positive
+func MeasureFile(path string, limit int64) (float64, error) {
+	f, err := os.Open(path) // #nosec G304 -- artifact path from backup metadata
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit > 0 {
+		r = io.LimitReader(f, limit)
+	}
+	var m EntropyMeter
+	if _, err := stream.Copy(context.Background(), &m, r); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return m.Entropy(), nil
+}
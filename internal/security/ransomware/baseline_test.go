@@ -0,0 +1,204 @@
+package ransomware
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func newTestStore(t *testing.T) *BaselineStore {
+	t.Helper()
+	return NewBaselineStore(config.RansomwareBaselineConfig{
+		StateFile:        filepath.Join(t.TempDir(), "baseline.json"),
+		Window:           10,
+		MinSamples:       3,
+		Threshold:        3,
+		MinEntropyChange: 0.5,
+		MinRatioChange:   0.25,
+	})
+}
+
+// history returns n samples around the given entropy and ratio
+func history(n int, entropy, ratio float64) []Sample {
+	start := time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC)
+	var out []Sample
+	for i := 0; i < n; i++ {
+		jitter := float64(i%3-1) * 0.01
+		out = append(out, Sample{
+			At:       start.Add(time.Duration(i) * 24 * time.Hour),
+			BackupID: fmt.Sprintf("b%d", i),
+			Entropy:  entropy + jitter,
+			Ratio:    ratio + jitter/10,
+		})
+	}
+	return out
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name       string
+		history    []Sample
+		sample     Sample
+		wantMetric []string
+	}{
+		{
+			name:    "plain dump turns into random bytes",
+			history: history(7, 5.1, 0.22),
+			sample:  Sample{Entropy: 7.99, Ratio: 1.0},
+			wantMetric: []string{
+				MetricEntropy, MetricRatio,
+			},
+		},
+		{
+			name:    "normal plain dump",
+			history: history(7, 5.1, 0.22),
+			sample:  Sample{Entropy: 5.12, Ratio: 0.221},
+		},
+		{
+			name:    "already compressed dump stays quiet",
+			history: history(7, 7.98, 0.97),
+			sample:  Sample{Entropy: 7.999, Ratio: 0.99},
+		},
+		{
+			name:       "compression collapses on compressed dump",
+			history:    history(7, 7.98, 0.30),
+			sample:     Sample{Entropy: 7.99, Ratio: 1.0},
+			wantMetric: []string{MetricRatio},
+		},
+		{
+			name:    "entropy drop is not flagged",
+			history: history(7, 7.9, 0.3),
+			sample:  Sample{Entropy: 4.0, Ratio: 0.1},
+		},
+		{
+			name:    "still learning",
+			history: history(2, 5.1, 0.22),
+			sample:  Sample{Entropy: 7.99, Ratio: 1.0},
+		},
+		{
+			name:    "unmeasured entropy",
+			history: history(7, 5.1, 0.22),
+			sample:  Sample{Ratio: 0.23},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			if _, err := store.Learn("orders", tt.history); err != nil {
+				t.Fatal(err)
+			}
+			v, err := store.Evaluate("orders", tt.sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range v.Deviations {
+				got = append(got, d.Metric)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantMetric, ",") {
+				t.Errorf("deviations = %v, want %v", got, tt.wantMetric)
+			}
+		})
+	}
+}
+
+func TestObserveKeepsSuspiciousBackupsOut(t *testing.T) {
+	store := newTestStore(t)
+	for _, s := range history(5, 5.1, 0.22) {
+		if v, err := store.Observe("orders", s); err != nil || v.Suspicious() {
+			t.Fatalf("Observe() = %+v, %v while learning", v, err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		v, err := store.Observe("orders", Sample{Entropy: 7.99, Ratio: 1.0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !v.Suspicious() {
+			t.Fatalf("encrypted backup %d not flagged", i)
+		}
+	}
+
+	baselines, err := store.Baselines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(baselines) != 1 {
+		t.Fatalf("got %d baselines, want 1", len(baselines))
+	}
+	b := baselines[0]
+	if b.Entropy.Samples != 5 || b.Flagged != 3 || b.Learning {
+		t.Errorf("baseline = %+v, want 5 samples, 3 flagged, not learning", b)
+	}
+	if n := b.Entropy.Mean; n < 5 || n > 5.2 {
+		t.Errorf("entropy mean = %.2f, baseline was poisoned", n)
+	}
+
+	if err := store.Reset("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reset("orders"); err == nil {
+		t.Error("Reset() of unknown database succeeded")
+	}
+}
+
+func TestLearnKeepsWindow(t *testing.T) {
+	store := newTestStore(t)
+	b, err := store.Learn("orders", history(25, 5.1, 0.22))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Entropy.Samples != 10 {
+		t.Errorf("samples = %d, want window of 10", b.Entropy.Samples)
+	}
+	if want := time.Date(2025, 1, 25, 2, 0, 0, 0, time.UTC); !b.Updated.Equal(want) {
+		t.Errorf("updated = %s, want newest sample %s", b.Updated, want)
+	}
+}
+
+func TestEntropyMeter(t *testing.T) {
+	random := make([]byte, 1<<16)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	text := []byte(strings.Repeat("INSERT INTO orders VALUES (1, 'widget', 9.99);\n", 1000))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		min, max float64
+	}{
+		{name: "empty", data: nil, min: 0, max: 0},
+		{name: "constant", data: make([]byte, 100), min: 0, max: 0},
+		{name: "sql text", data: text, min: 3.5, max: 5.5},
+		{name: "random", data: random, min: 7.9, max: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m EntropyMeter
+			_, _ = m.Write(tt.data)
+			if h := m.Entropy(); h < tt.min || h > tt.max {
+				t.Errorf("Entropy() = %.3f, want %.1f-%.1f", h, tt.min, tt.max)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "dump.sql")
+	if err := os.WriteFile(path, append(text, random...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := MeasureFile(path, int64(len(text)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h > 5.5 {
+		t.Errorf("MeasureFile() with limit = %.3f, read past the limit", h)
+	}
+}
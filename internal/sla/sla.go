@@ -0,0 +1,399 @@
+// Package sla tracks recovery point and recovery time objectives. The
+// recovery point age of a database is the time since its newest usable
+// backup; the recovery time estimate comes from measured restore
+// rehearsals. Both are compared with the per-database objectives so a
+// breach can be alerted on before it matters.
+package sla
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/notification"
+)
+
+// DefaultObjective is used for databases without objectives of their own
+const DefaultObjective = "default"
+
+// Breach kinds
+const (
+	KindRPO = "rpo"
+	KindRTO = "rto"
+)
+
+// Rehearsal is one measured restore, typically into a scratch database
+type Rehearsal struct {
+	At       time.Time     `json:"at"`
+	Duration time.Duration `json:"duration"`
+	Size     int64         `json:"size,omitempty"`
+	Success  bool          `json:"success"`
+}
+
+// databaseState is the persisted state for one database
+type databaseState struct {
+	LastBackup   time.Time   `json:"last_backup,omitempty"`
+	LastVerified time.Time   `json:"last_verified,omitempty"`
+	Rehearsals   []Rehearsal `json:"rehearsals,omitempty"`
+	RPOBreached  bool        `json:"rpo_breached,omitempty"`
+	RTOBreached  bool        `json:"rto_breached,omitempty"`
+}
+
+// Status is the recovery posture of one database
+type Status struct {
+	Database      string        `json:"database"`
+	RecoveryPoint time.Time     `json:"recovery_point,omitempty"` // newest usable backup
+	PointAge      time.Duration `json:"-"`
+	RPO           time.Duration `json:"-"`
+	RPOBreached   bool          `json:"rpo_breached"`
+	TimeEstimate  time.Duration `json:"-"`
+	RTO           time.Duration `json:"-"`
+	RTOBreached   bool          `json:"rto_breached"`
+	Rehearsals    int           `json:"rehearsals"`
+}
+
+// HasRecoveryPoint reports whether the database has a usable backup at all
+func (s Status) HasRecoveryPoint() bool {
+	return !s.RecoveryPoint.IsZero()
+}
+
+// MarshalJSON reports durations in seconds for dashboards
+func (s Status) MarshalJSON() ([]byte, error) {
+	type plain Status
+	out := struct {
+		plain
+		RecoveryPoint       *time.Time `json:"recovery_point,omitempty"`
+		PointAgeSeconds     *float64   `json:"recovery_point_age_seconds,omitempty"`
+		RPOSeconds          float64    `json:"rpo_objective_seconds,omitempty"`
+		TimeEstimateSeconds float64    `json:"rto_estimate_seconds,omitempty"`
+		RTOSeconds          float64    `json:"rto_objective_seconds,omitempty"`
+	}{
+		plain:               plain(s),
+		RPOSeconds:          s.RPO.Seconds(),
+		TimeEstimateSeconds: s.TimeEstimate.Seconds(),
+		RTOSeconds:          s.RTO.Seconds(),
+	}
+	if s.HasRecoveryPoint() {
+		age := s.PointAge.Seconds()
+		out.RecoveryPoint = &s.RecoveryPoint
+		out.PointAgeSeconds = &age
+	}
+	return json.Marshal(out)
+}
+
+// Breach is a change in whether an objective is met
+type Breach struct {
+	Kind      string
+	Status    Status
+	Recovered bool
+}
+
+// Notification describes the breach, or its recovery, for the notifiers
+func (b Breach) Notification() *notification.Notification {
+	s := b.Status
+	n := &notification.Notification{
+		Event:     notification.EventWarning,
+		Database:  s.Database,
+		Timestamp: time.Now(),
+	}
+
+	switch {
+	case b.Kind == KindRPO && b.Recovered:
+		n.Event = notification.EventSuccess
+		n.Title = fmt.Sprintf("Recovery point objective met again for %s", s.Database)
+		n.Message = fmt.Sprintf("Newest recovery point is %s old (objective %s)", formatDuration(s.PointAge), formatDuration(s.RPO))
+	case b.Kind == KindRPO && !s.HasRecoveryPoint():
+		n.Title = fmt.Sprintf("Recovery point objective breached for %s", s.Database)
+		n.Message = fmt.Sprintf("No usable backup exists (objective %s)", formatDuration(s.RPO))
+	case b.Kind == KindRPO:
+		n.Title = fmt.Sprintf("Recovery point objective breached for %s", s.Database)
+		n.Message = fmt.Sprintf("Newest recovery point is %s old, objective is %s", formatDuration(s.PointAge), formatDuration(s.RPO))
+	case b.Recovered:
+		n.Event = notification.EventSuccess
+		n.Title = fmt.Sprintf("Recovery time objective met again for %s", s.Database)
+		n.Message = fmt.Sprintf("Estimated restore time is %s (objective %s)", formatDuration(s.TimeEstimate), formatDuration(s.RTO))
+	default:
+		n.Title = fmt.Sprintf("Recovery time objective breached for %s", s.Database)
+		n.Message = fmt.Sprintf("Restore rehearsals take up to %s, objective is %s", formatDuration(s.TimeEstimate), formatDuration(s.RTO))
+	}
+	return n
+}
+
+// Tracker records recovery points and restore rehearsals in a state file
+// shared by CLI runs and the API server
+type Tracker struct {
+	config config.SLAConfig
+	mu     sync.Mutex
+}
+
+// New creates a tracker
+func New(cfg config.SLAConfig) *Tracker {
+	if cfg.RehearsalSamples < 1 {
+		cfg.RehearsalSamples = 5
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = 5 * time.Minute
+	}
+	return &Tracker{config: cfg}
+}
+
+// RecordBackup records a successful backup taken at the given time.
+// Verified backups also count as recovery points when verification is
+// required.
+func (t *Tracker) RecordBackup(database string, at time.Time, verified bool) error {
+	return t.update(database, func(st *databaseState) {
+		if at.After(st.LastBackup) {
+			st.LastBackup = at
+		}
+		if verified && at.After(st.LastVerified) {
+			st.LastVerified = at
+		}
+	})
+}
+
+// RecordVerification marks the backup taken at backupTime as verified
+func (t *Tracker) RecordVerification(database string, backupTime time.Time) error {
+	return t.RecordBackup(database, backupTime, true)
+}
+
+// RecordRehearsal records a measured restore. Only the most recent
+// rehearsals are kept.
+func (t *Tracker) RecordRehearsal(database string, r Rehearsal) error {
+	if r.At.IsZero() {
+		r.At = time.Now()
+	}
+	return t.update(database, func(st *databaseState) {
+		st.Rehearsals = append(st.Rehearsals, r)
+		if extra := len(st.Rehearsals) - t.config.RehearsalSamples; extra > 0 {
+			st.Rehearsals = st.Rehearsals[extra:]
+		}
+	})
+}
+
+// Status reports every database with recorded backups or configured
+// objectives, sorted by name
+func (t *Tracker) Status(now time.Time) ([]Status, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	return t.statuses(state, now), nil
+}
+
+// LastVerified returns the time of the newest verified backup of every
+// database with one
+func (t *Tracker) LastVerified() (map[string]time.Time, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	verified := make(map[string]time.Time, len(state))
+	for name, st := range state {
+		if !st.LastVerified.IsZero() {
+			verified[name] = st.LastVerified
+		}
+	}
+	return verified, nil
+}
+
+// Check compares every database with its objectives and returns the
+// breaches that started or ended since the last check. Each breach is
+// reported once, not on every check.
+func (t *Tracker) Check(now time.Time) ([]Breach, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var breaches []Breach
+	for _, s := range t.statuses(state, now) {
+		st := state[s.Database]
+		if st == nil {
+			st = &databaseState{}
+			state[s.Database] = st
+		}
+		if s.RPOBreached != st.RPOBreached {
+			breaches = append(breaches, Breach{Kind: KindRPO, Status: s, Recovered: !s.RPOBreached})
+			st.RPOBreached = s.RPOBreached
+		}
+		if s.RTOBreached != st.RTOBreached {
+			breaches = append(breaches, Breach{Kind: KindRTO, Status: s, Recovered: !s.RTOBreached})
+			st.RTOBreached = s.RTOBreached
+		}
+	}
+	if len(breaches) == 0 {
+		return nil, nil
+	}
+	return breaches, t.save(state)
+}
+
+// Run checks the objectives every check interval until the context is
+// cancelled, handing breaches and recoveries to send
+func (t *Tracker) Run(ctx context.Context, send func(context.Context, *notification.Notification) error) error {
+	ticker := time.NewTicker(t.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		breaches, err := t.Check(time.Now())
+		if err != nil {
+			return err
+		}
+		for _, b := range breaches {
+			if err := send(ctx, b.Notification()); err != nil {
+				return fmt.Errorf("failed to send SLA alert for %s: %w", b.Status.Database, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Objective returns the objectives for a database. Viper lowercases map
+// keys, so the lookup is case-insensitive.
+func (t *Tracker) Objective(database string) config.SLAObjective {
+	if o, ok := t.config.Objectives[database]; ok {
+		return o
+	}
+	if o, ok := t.config.Objectives[strings.ToLower(database)]; ok {
+		return o
+	}
+	return t.config.Objectives[DefaultObjective]
+}
+
+func (t *Tracker) statuses(state map[string]*databaseState, now time.Time) []Status {
+	names := make(map[string]bool, len(state))
+	for name := range state {
+		names[name] = true
+	}
+	for name := range t.config.Objectives {
+		if name != DefaultObjective {
+			names[name] = true
+		}
+	}
+
+	statuses := make([]Status, 0, len(names))
+	for name := range names {
+		st := state[name]
+		if st == nil {
+			st = &databaseState{}
+		}
+		statuses = append(statuses, t.status(name, st, now))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Database < statuses[j].Database })
+	return statuses
+}
+
+func (t *Tracker) status(name string, st *databaseState, now time.Time) Status {
+	objective := t.Objective(name)
+	s := Status{Database: name, RPO: objective.RPO, RTO: objective.RTO}
+
+	s.RecoveryPoint = st.LastVerified
+	if !t.config.RequireVerification && st.LastBackup.After(s.RecoveryPoint) {
+		s.RecoveryPoint = st.LastBackup
+	}
+	if s.HasRecoveryPoint() {
+		s.PointAge = now.Sub(s.RecoveryPoint)
+	}
+	if s.RPO > 0 {
+		s.RPOBreached = !s.HasRecoveryPoint() || s.PointAge > s.RPO
+	}
+
+	// The slowest recent successful rehearsal is a conservative estimate
+	for _, r := range st.Rehearsals {
+		if !r.Success {
+			continue
+		}
+		s.Rehearsals++
+		if r.Duration > s.TimeEstimate {
+			s.TimeEstimate = r.Duration
+		}
+	}
+	if s.RTO > 0 && s.Rehearsals > 0 {
+		s.RTOBreached = s.TimeEstimate > s.RTO
+	}
+	return s
+}
+
+// update applies fn to one database's state and saves it
+func (t *Tracker) update(database string, fn func(*databaseState)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, err := t.load()
+	if err != nil {
+		return err
+	}
+	st, ok := state[database]
+	if !ok {
+		st = &databaseState{}
+		state[database] = st
+	}
+	fn(st)
+	return t.save(state)
+}
+
+func (t *Tracker) load() (map[string]*databaseState, error) {
+	state := make(map[string]*databaseState)
+	data, err := os.ReadFile(t.config.StateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SLA state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse SLA state: %w", err)
+	}
+	return state, nil
+}
+
+// save writes the state atomically
+func (t *Tracker) save(state map[string]*databaseState) error {
+	if t.config.StateFile == "" {
+		return fmt.Errorf("sla state_file is not configured")
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal SLA state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(t.config.StateFile), 0750); err != nil {
+		return fmt.Errorf("failed to create SLA state directory: %w", err)
+	}
+	tmp := t.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write SLA state: %w", err)
+	}
+	return os.Rename(tmp, t.config.StateFile)
+}
+
+// formatDuration rounds a duration for messages
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour:
+		return d.Round(time.Minute).String()
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	default:
+		return d.Round(time.Millisecond).String()
+	}
+}
@@ -0,0 +1,138 @@
+package sla
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+func newTestTracker(t *testing.T, requireVerification bool) *Tracker {
+	t.Helper()
+	return New(config.SLAConfig{
+		StateFile:           filepath.Join(t.TempDir(), "sla.json"),
+		RequireVerification: requireVerification,
+		RehearsalSamples:    3,
+		Objectives: map[string]config.SLAObjective{
+			"default": {RPO: 24 * time.Hour},
+			"orders":  {RPO: time.Hour, RTO: 30 * time.Minute},
+		},
+	})
+}
+
+func TestStatus(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                string
+		requireVerification bool
+		backupAge           time.Duration
+		verifiedAge         time.Duration // zero: never verified
+		rehearsals          []time.Duration
+		wantAge             time.Duration
+		wantRPOBreached     bool
+		wantEstimate        time.Duration
+		wantRTOBreached     bool
+	}{
+		{"fresh backup", false, 10 * time.Minute, 0, nil, 10 * time.Minute, false, 0, false},
+		{"stale backup", false, 2 * time.Hour, 0, nil, 2 * time.Hour, true, 0, false},
+		{"unverified backup ignored", true, 10 * time.Minute, 3 * time.Hour, nil, 3 * time.Hour, true, 0, false},
+		{"never verified", true, 10 * time.Minute, 0, nil, 0, true, 0, false},
+		{"slowest rehearsal wins", false, time.Minute, 0, []time.Duration{10 * time.Minute, 20 * time.Minute}, time.Minute, false, 20 * time.Minute, false},
+		{"only recent rehearsals", false, time.Minute, 0, []time.Duration{time.Hour, 5 * time.Minute, 5 * time.Minute, 5 * time.Minute}, time.Minute, false, 5 * time.Minute, false},
+		{"slow restore", false, time.Minute, 0, []time.Duration{45 * time.Minute}, time.Minute, false, 45 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(t, tt.requireVerification)
+			if err := tr.RecordBackup("orders", now.Add(-tt.backupAge), false); err != nil {
+				t.Fatal(err)
+			}
+			if tt.verifiedAge > 0 {
+				if err := tr.RecordVerification("orders", now.Add(-tt.verifiedAge)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, d := range tt.rehearsals {
+				if err := tr.RecordRehearsal("orders", Rehearsal{At: now, Duration: d, Success: true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			statuses, err := tr.Status(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(statuses) != 1 {
+				t.Fatalf("got %d statuses, want 1", len(statuses))
+			}
+			s := statuses[0]
+			if s.PointAge != tt.wantAge || s.RPOBreached != tt.wantRPOBreached {
+				t.Errorf("point age = %s breached = %v, want %s %v", s.PointAge, s.RPOBreached, tt.wantAge, tt.wantRPOBreached)
+			}
+			if s.TimeEstimate != tt.wantEstimate || s.RTOBreached != tt.wantRTOBreached {
+				t.Errorf("estimate = %s breached = %v, want %s %v", s.TimeEstimate, s.RTOBreached, tt.wantEstimate, tt.wantRTOBreached)
+			}
+		})
+	}
+}
+
+func TestCheckReportsTransitionsOnce(t *testing.T) {
+	tr := newTestTracker(t, false)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := tr.RecordBackup("orders", start, false); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		at      time.Time
+		backup  bool
+		want    int
+		recover bool
+	}{
+		{start.Add(30 * time.Minute), false, 0, false},
+		{start.Add(2 * time.Hour), false, 1, false},
+		{start.Add(3 * time.Hour), false, 0, false},
+		{start.Add(4 * time.Hour), true, 1, true},
+	}
+	for i, step := range steps {
+		if step.backup {
+			if err := tr.RecordBackup("orders", step.at, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		breaches, err := tr.Check(step.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(breaches) != step.want {
+			t.Fatalf("step %d: got %d breaches, want %d", i, len(breaches), step.want)
+		}
+		if step.want > 0 && breaches[0].Recovered != step.recover {
+			t.Errorf("step %d: recovered = %v, want %v", i, breaches[0].Recovered, step.recover)
+		}
+	}
+}
+
+func TestLastVerified(t *testing.T) {
+	tr := newTestTracker(t, false)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := tr.RecordBackup("billing", at, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.RecordVerification("orders", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.RecordVerification("orders", at.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := tr.LastVerified()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verified) != 1 || !verified["orders"].Equal(at) {
+		t.Errorf("got %v", verified)
+	}
+}
@@ -0,0 +1,388 @@
+// Package cache keeps recently downloaded artifacts on local disk, so
+// verifying, inspecting or rehearsing a restore of the same backup again
+// does not fetch it from cold storage each time. The cache is bounded by
+// total size and evicts the least recently used artifacts first; an
+// artifact in use is never evicted, by this process or another sharing the
+// directory: users hold a shared lock on the artifact's lock file, and it
+// is only removed under an exclusive one. Artifacts are stored as fetched,
+// so a cache of decrypted artifacts must live on storage as trusted as the
+// databases themselves.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/filelock"
+)
+
+// File name prefixes of fetches in progress and of lock files
+const (
+	tmpPrefix  = ".tmp-"
+	lockPrefix = ".lock-"
+)
+
+// FetchFunc writes an artifact missing from the cache to w
+type FetchFunc func(ctx context.Context, w io.Writer) error
+
+// Stats reports the cache's use since it was opened
+type Stats struct {
+	Entries   int   `json:"entries"`
+	Size      int64 `json:"size"`
+	MaxSize   int64 `json:"max_size"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+// entry is a cached artifact
+type entry struct {
+	name string // file name, derived from the key
+	size int64
+	refs int
+	elem *list.Element
+}
+
+// fetch is a download in progress, shared by concurrent Gets of one key
+type fetch struct {
+	done chan struct{}
+	err  error
+}
+
+// Cache is a size-bounded LRU of artifacts in one directory
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu       sync.Mutex
+	entries  map[string]*entry // by file name
+	lru      *list.List        // of *entry, most recently used first
+	size     int64
+	fetching map[string]*fetch
+	stats    Stats
+}
+
+// Open opens the cache in dir, picking up the artifacts already there in
+// order of their last use
+func Open(dir string, maxSize int64) (*Cache, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("cache directory is not configured")
+	}
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("cache size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	c := &Cache{
+		dir:      dir,
+		maxSize:  maxSize,
+		entries:  make(map[string]*entry),
+		lru:      list.New(),
+		fetching: make(map[string]*fetch),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		name string
+		size int64
+		used time.Time
+	}
+	var existing []found
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), lockPrefix) {
+			continue
+		}
+		if strings.HasPrefix(f.Name(), tmpPrefix) {
+			// Left by an interrupted fetch, unless another process is at it
+			c.removeTemp(f.Name())
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, found{f.Name(), info.Size(), info.ModTime()})
+	}
+	// Oldest first, so pushing each to the front leaves the newest there
+	sort.Slice(existing, func(i, j int) bool { return existing[i].used.Before(existing[j].used) })
+	for _, f := range existing {
+		e := &entry{name: f.name, size: f.size}
+		e.elem = c.lru.PushFront(e)
+		c.entries[f.name] = e
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// fileName maps a key, e.g. a backup ID, to a file name
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// lockPath returns the path of the lock file of the cache file name
+func (c *Cache) lockPath(name string) string {
+	return filepath.Join(c.dir, lockPrefix+name)
+}
+
+// Get returns the path of the artifact cached under key, fetching it on a
+// miss. The file stays in place until release is called. An artifact
+// larger than the whole cache is fetched to a temporary file that release
+// removes.
+func (c *Cache) Get(ctx context.Context, key string, fetchFn FetchFunc) (path string, release func(), err error) {
+	name := fileName(key)
+	for {
+		c.mu.Lock()
+		if e, ok := c.entries[name]; ok {
+			e.refs++
+			c.lru.MoveToFront(e.elem)
+			c.mu.Unlock()
+			path := filepath.Join(c.dir, name)
+			unlock, err := filelock.RLock(c.lockPath(name))
+			if err == nil {
+				if _, err = os.Stat(path); err != nil {
+					unlock()
+				}
+			}
+			if err != nil {
+				// Evicted by another process: fetch it again
+				c.mu.Lock()
+				e.refs--
+				if c.entries[name] == e {
+					c.forget(e)
+				}
+				c.mu.Unlock()
+				if os.IsNotExist(err) {
+					continue
+				}
+				return "", nil, err
+			}
+			c.mu.Lock()
+			c.stats.Hits++
+			c.mu.Unlock()
+			now := time.Now()
+			// The modification time orders entries when the cache is reopened
+			os.Chtimes(path, now, now)
+			return path, c.releaser(e, unlock), nil
+		}
+		if f, ok := c.fetching[name]; ok {
+			c.mu.Unlock()
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return "", nil, ctx.Err()
+			}
+			if f.err != nil {
+				return "", nil, f.err
+			}
+			continue
+		}
+		f := &fetch{done: make(chan struct{})}
+		c.fetching[name] = f
+		c.stats.Misses++
+		c.mu.Unlock()
+
+		path, release, err = c.fill(ctx, name, fetchFn)
+		c.mu.Lock()
+		delete(c.fetching, name)
+		c.mu.Unlock()
+		f.err = err
+		close(f.done)
+		return path, release, err
+	}
+}
+
+// fill fetches an artifact and adds it to the cache
+func (c *Cache) fill(ctx context.Context, name string, fetchFn FetchFunc) (string, func(), error) {
+	tmp, unlockTmp, err := c.tempFile()
+	if err != nil {
+		return "", nil, err
+	}
+	discard := func() {
+		os.Remove(tmp.Name())
+		c.releaseTemp(tmp.Name(), unlockTmp)
+	}
+	if err := fetchFn(ctx, tmp); err != nil {
+		tmp.Close()
+		discard()
+		return "", nil, err
+	}
+	info, err := tmp.Stat()
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		discard()
+		return "", nil, err
+	}
+
+	if info.Size() > c.maxSize {
+		// Served once, never cached
+		return tmp.Name(), discard, nil
+	}
+
+	// Locked before it appears, so no other process evicts it first
+	unlock, err := filelock.RLock(c.lockPath(name))
+	if err != nil {
+		discard()
+		return "", nil, err
+	}
+	path := filepath.Join(c.dir, name)
+	err = os.Rename(tmp.Name(), path)
+	c.releaseTemp(tmp.Name(), unlockTmp)
+	if err != nil {
+		os.Remove(tmp.Name())
+		unlock()
+		return "", nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &entry{name: name, size: info.Size(), refs: 1}
+	e.elem = c.lru.PushFront(e)
+	c.entries[name] = e
+	c.size += e.size
+	c.evict()
+	return path, c.releaser(e, unlock), nil
+}
+
+// tempFile creates a file to fetch into, holding a shared lock that keeps
+// other processes opening the cache from removing it as left over
+func (c *Cache) tempFile() (*os.File, func() error, error) {
+	for {
+		tmp, err := os.CreateTemp(c.dir, tmpPrefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		unlock, err := filelock.RLock(c.lockPath(filepath.Base(tmp.Name())))
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, nil, err
+		}
+		if _, err := os.Stat(tmp.Name()); err == nil {
+			return tmp, unlock, nil
+		}
+		// Removed by a process opening the cache before it was locked
+		tmp.Close()
+		c.releaseTemp(tmp.Name(), unlock)
+	}
+}
+
+// releaseTemp drops the lock of the temporary file tmp and its lock file
+func (c *Cache) releaseTemp(tmp string, unlock func() error) {
+	os.Remove(c.lockPath(filepath.Base(tmp)))
+	unlock()
+}
+
+// removeTemp removes the temporary file name unless a fetch still holds it
+func (c *Cache) removeTemp(name string) {
+	unlock, ok, err := filelock.TryLock(c.lockPath(name))
+	if err != nil || !ok {
+		return
+	}
+	os.Remove(filepath.Join(c.dir, name))
+	c.releaseTemp(name, unlock)
+}
+
+// releaser returns a function dropping one reference to e and the shared
+// lock taken with it, once
+func (c *Cache) releaser(e *entry, unlock func() error) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			unlock()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			e.refs--
+			c.evict()
+		})
+	}
+}
+
+// evict removes least recently used entries not in use until the cache
+// fits its size. The caller holds the lock.
+func (c *Cache) evict() {
+	for elem := c.lru.Back(); elem != nil && c.size > c.maxSize; {
+		e := elem.Value.(*entry)
+		prev := elem.Prev()
+		if e.refs == 0 && c.remove(e) {
+			c.stats.Evictions++
+		}
+		elem = prev
+	}
+}
+
+// remove deletes an entry and its file unless another process is using
+// it, and reports whether it did. The caller holds the lock.
+func (c *Cache) remove(e *entry) bool {
+	unlock, ok, err := filelock.TryLock(c.lockPath(e.name))
+	if err != nil || !ok {
+		return false
+	}
+	defer unlock()
+	os.Remove(filepath.Join(c.dir, e.name))
+	c.forget(e)
+	return true
+}
+
+// forget drops an entry whose file is gone. The caller holds the lock.
+func (c *Cache) forget(e *entry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.name)
+	c.size -= e.size
+}
+
+// Invalidate drops the artifact cached under key, e.g. after its backup
+// was deleted. It reports whether the artifact was cached; one in use, by
+// this process or another, is left in place and reported as not removed.
+func (c *Cache) Invalidate(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[fileName(key)]
+	if !ok || e.refs > 0 {
+		return false
+	}
+	return c.remove(e)
+}
+
+// Clear removes every artifact not in use by any process and returns the
+// bytes freed
+func (c *Cache) Clear() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var freed int64
+	for _, e := range c.entries {
+		if size := e.size; e.refs == 0 && c.remove(e) {
+			freed += size
+		}
+	}
+	return freed
+}
+
+// Stats returns the cache's current size and counters
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = len(c.entries)
+	s.Size = c.size
+	s.MaxSize = c.maxSize
+	return s
+}
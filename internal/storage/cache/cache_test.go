@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// source serves artifacts of a given size and counts fetches
+type source struct {
+	fetches atomic.Int32
+	delay   time.Duration
+}
+
+func (s *source) fetch(size int) FetchFunc {
+	return func(ctx context.Context, w io.Writer) error {
+		s.fetches.Add(1)
+		time.Sleep(s.delay)
+		_, err := w.Write(bytes.Repeat([]byte("x"), size))
+		return err
+	}
+}
+
+func get(t *testing.T, c *Cache, key string, fetch FetchFunc) string {
+	t.Helper()
+	path, release, err := c.Get(context.Background(), key, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	return path
+}
+
+func TestHitAfterMiss(t *testing.T) {
+	c, _ := Open(t.TempDir(), 1000)
+	src := &source{}
+	p1 := get(t, c, "bk-1", src.fetch(100))
+	p2 := get(t, c, "bk-1", src.fetch(100))
+	if p1 != p2 || src.fetches.Load() != 1 {
+		t.Fatalf("second Get() fetched again (%d fetches)", src.fetches.Load())
+	}
+	if data, _ := os.ReadFile(p1); len(data) != 100 {
+		t.Fatalf("cached file has %d bytes", len(data))
+	}
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 1 || s.Size != 100 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := Open(t.TempDir(), 250)
+	src := &source{}
+	get(t, c, "a", src.fetch(100))
+	get(t, c, "b", src.fetch(100))
+	get(t, c, "a", src.fetch(100)) // a is now the most recent
+	get(t, c, "c", src.fetch(100)) // evicts b
+
+	if s := c.Stats(); s.Entries != 2 || s.Size != 200 || s.Evictions != 1 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+	before := src.fetches.Load()
+	get(t, c, "a", src.fetch(100))
+	if src.fetches.Load() != before {
+		t.Fatal("a was evicted instead of b")
+	}
+}
+
+func TestEntriesInUseAreNotEvicted(t *testing.T) {
+	c, _ := Open(t.TempDir(), 150)
+	src := &source{}
+	path, release, err := c.Get(context.Background(), "a", src.fetch(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, c, "b", src.fetch(100))
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("an artifact in use was evicted")
+	}
+	release()
+	if s := c.Stats(); s.Size > 150 {
+		t.Fatalf("cache over its size after release: %+v", s)
+	}
+}
+
+func TestConcurrentMissesFetchOnce(t *testing.T) {
+	c, _ := Open(t.TempDir(), 1000)
+	src := &source{delay: 20 * time.Millisecond}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, release, err := c.Get(context.Background(), "bk", src.fetch(10))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			release()
+		}()
+	}
+	wg.Wait()
+	if n := src.fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches for one key", n)
+	}
+}
+
+func TestOversizedAndFailedFetches(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := Open(dir, 50)
+	src := &source{}
+	path, release, err := c.Get(context.Background(), "huge", src.fetch(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) || c.Stats().Entries != 0 {
+		t.Fatal("an artifact larger than the cache was kept")
+	}
+
+	_, _, err = c.Get(context.Background(), "bad", func(ctx context.Context, w io.Writer) error {
+		w.Write([]byte("partial"))
+		return errors.New("download failed")
+	})
+	if err == nil {
+		t.Fatal("failed fetch returned no error")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("failed fetch left %d files", len(files))
+	}
+}
+
+func TestReopenKeepsEntriesAndOrder(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := Open(dir, 1000)
+	src := &source{}
+	old := get(t, c, "old", src.fetch(100))
+	get(t, c, "new", src.fetch(100))
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(old, past, past)
+
+	c, err := Open(dir, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Stats(); s.Entries != 1 {
+		t.Fatalf("reopened smaller cache has %d entries", s.Entries)
+	}
+	before := src.fetches.Load()
+	get(t, c, "new", src.fetch(100))
+	if src.fetches.Load() != before {
+		t.Fatal("the most recent entry was evicted on reopen")
+	}
+	if !c.Invalidate("new") || c.Stats().Entries != 0 {
+		t.Fatal("Invalidate() did not remove the entry")
+	}
+}
+
+func TestSharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	// Two caches on one directory stand for two processes
+	a, _ := Open(dir, 1000)
+	src := &source{}
+	path, release, err := a.Get(context.Background(), "bk", src.fetch(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Open(dir, 1000)
+	if freed := b.Clear(); freed != 0 {
+		t.Fatalf("Clear() freed %d bytes of an artifact in use by another cache", freed)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("an artifact in use by another cache was removed")
+	}
+
+	release()
+	if freed := b.Clear(); freed != 100 {
+		t.Fatalf("Clear() freed %d bytes, want 100", freed)
+	}
+	// a still lists the artifact and fetches it again
+	if p := get(t, a, "bk", src.fetch(100)); p != path || src.fetches.Load() != 2 {
+		t.Fatalf("Get() after another cache cleared it fetched %d times", src.fetches.Load())
+	}
+}
@@ -0,0 +1,431 @@
+// Package multipart uploads large artifacts as multipart uploads that
+// survive interruptions. The upload ID and every completed part are
+// journaled on local disk as the upload progresses, so a 5 TB upload cut
+// short by a crash or a network failure resumes after its last completed
+// part instead of starting over. Uploads nobody will resume, and journal
+// entries of uploads that no longer exist, are cleaned up by
+// CollectAbandoned: left alone, a provider keeps billing for the parts of
+// an upload that is never completed.
+package multipart
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+	"github.com/sanskarpan/db-backup/pkg/utils"
+)
+
+// ErrUploadNotFound is returned by providers for an upload ID they do not
+// know, e.g. one aborted or expired provider-side
+var ErrUploadNotFound = errors.New("multipart upload not found")
+
+// maxParts is the most parts S3 accepts in one multipart upload
+const maxParts = 10000
+
+// Provider is the multipart API of a storage backend
+type Provider interface {
+	// Name identifies the provider and bucket in the journal, e.g. "s3:backups"
+	Name() string
+	CreateMultipart(ctx context.Context, key string) (uploadID string, err error)
+	UploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (etag string, err error)
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+	// ListMultipart returns the uploads in progress under prefix
+	ListMultipart(ctx context.Context, prefix string) ([]PendingUpload, error)
+}
+
+// CompletedPart is an uploaded part
+type CompletedPart struct {
+	Number int    `json:"number"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"etag"`
+}
+
+// PendingUpload is an upload in progress provider-side
+type PendingUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// State is the journal entry of an upload in progress
+type State struct {
+	Provider      string          `json:"provider"`
+	Key           string          `json:"key"`
+	UploadID      string          `json:"upload_id"`
+	Source        string          `json:"source"`
+	SourceSize    int64           `json:"source_size"`
+	SourceModTime time.Time       `json:"source_mod_time"`
+	PartSize      int64           `json:"part_size"`
+	Parts         []CompletedPart `json:"parts"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// Uploaded returns the bytes of the completed parts
+func (s *State) Uploaded() int64 {
+	var n int64
+	for _, p := range s.Parts {
+		n += p.Size
+	}
+	return n
+}
+
+// Journal persists upload states, one JSON file per upload
+type Journal struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewJournal opens the journal in dir, creating it if needed
+func NewJournal(dir string) (*Journal, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("upload state directory is not configured")
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create upload state directory: %w", err)
+	}
+	return &Journal{dir: dir}, nil
+}
+
+// path names an upload's file after a hash of provider and key, which
+// may contain slashes
+func (j *Journal) path(provider, key string) string {
+	sum := sha256.Sum256([]byte(provider + "\x00" + key))
+	return filepath.Join(j.dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// Load returns the state of an upload, or nil when there is none
+func (j *Journal) Load(provider, key string) (*State, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.read(j.path(provider, key))
+}
+
+func (j *Journal) read(path string) (*State, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- file in the upload state directory
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload state: %w", err)
+	}
+	s := &State{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse upload state %s: %w", filepath.Base(path), err)
+	}
+	return s, nil
+}
+
+// Save writes the state of an upload
+func (j *Journal) Save(s *State) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload state: %w", err)
+	}
+	path := j.path(s.Provider, s.Key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write upload state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove deletes the state of an upload
+func (j *Journal) Remove(provider, key string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := os.Remove(j.path(provider, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns every journaled upload, oldest first. Unreadable entries
+// are skipped.
+func (j *Journal) List() ([]*State, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	var states []*State
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		s, err := j.read(filepath.Join(j.dir, e.Name()))
+		if err != nil || s == nil {
+			continue
+		}
+		states = append(states, s)
+	}
+	sort.Slice(states, func(a, b int) bool { return states[a].CreatedAt.Before(states[b].CreatedAt) })
+	return states, nil
+}
+
+// Uploader uploads files through a provider, resuming journaled uploads
+type Uploader struct {
+	provider    Provider
+	journal     *Journal
+	partSize    int64
+	concurrency int
+	now         func() time.Time
+}
+
+// NewUploader creates an uploader with the configured part size and
+// concurrency
+func NewUploader(p Provider, j *Journal, cfg config.UploadConfig) (*Uploader, error) {
+	partSize, err := utils.ParseBytes(cfg.PartSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid part size: %w", err)
+	}
+	return &Uploader{
+		provider:    p,
+		journal:     j,
+		partSize:    partSize,
+		concurrency: max(cfg.Concurrency, 1),
+		now:         time.Now,
+	}, nil
+}
+
+// Result describes a finished upload
+type Result struct {
+	UploadID string
+	Parts    int
+	Resumed  int   // parts already uploaded by an earlier attempt
+	Bytes    int64 // uploaded by this attempt
+}
+
+// UploadFile uploads the file at path to key. An interrupted upload of the
+// same unchanged file is resumed; if the file changed, or the provider no
+// longer knows the upload, the old upload is abandoned and a new one
+// started. On failure the journal keeps the progress for the next attempt.
+func (u *Uploader) UploadFile(ctx context.Context, path, key string) (*Result, error) {
+	f, err := os.Open(path) // #nosec G304 -- artifact written by the backup
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Larger files get larger parts so they fit the part limit
+	partSize := max(u.partSize, (info.Size()+maxParts-1)/maxParts)
+
+	state, err := u.journal.Load(u.provider.Name(), key)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil && (state.Source != path || state.SourceSize != info.Size() ||
+		!state.SourceModTime.Equal(info.ModTime()) || state.PartSize != partSize) {
+		u.abandon(ctx, state)
+		state = nil
+	}
+
+	res, err := u.upload(ctx, f, info, path, key, partSize, state)
+	if errors.Is(err, ErrUploadNotFound) && state != nil {
+		// Expired or aborted provider-side: start over
+		u.journal.Remove(u.provider.Name(), key)
+		return u.upload(ctx, f, info, path, key, partSize, nil)
+	}
+	return res, err
+}
+
+func (u *Uploader) upload(ctx context.Context, f io.ReaderAt, info os.FileInfo, path, key string, partSize int64, state *State) (*Result, error) {
+	if state == nil {
+		id, err := u.provider.CreateMultipart(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start multipart upload: %w", err)
+		}
+		now := u.now()
+		state = &State{
+			Provider:      u.provider.Name(),
+			Key:           key,
+			UploadID:      id,
+			Source:        path,
+			SourceSize:    info.Size(),
+			SourceModTime: info.ModTime(),
+			PartSize:      partSize,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := u.journal.Save(state); err != nil {
+			return nil, err
+		}
+	}
+
+	total := int(max((info.Size()+partSize-1)/partSize, 1))
+	done := make(map[int]bool, len(state.Parts))
+	for _, p := range state.Parts {
+		done[p.Number] = true
+	}
+	res := &Result{UploadID: state.UploadID, Parts: total, Resumed: len(done)}
+
+	var todo []int
+	for n := 1; n <= total; n++ {
+		if !done[n] {
+			todo = append(todo, n)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pool := stream.NewPool(int(partSize), partSize*int64(u.concurrency))
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	next := make(chan int)
+	for w := 0; w < u.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				if ctx.Err() != nil {
+					// Handed out as another part failed
+					continue
+				}
+				part, err := u.uploadPart(ctx, pool, f, info.Size(), key, state.UploadID, n, partSize)
+				mu.Lock()
+				if err == nil {
+					state.Parts = append(state.Parts, part)
+					state.UpdatedAt = u.now()
+					res.Bytes += part.Size
+					err = u.journal.Save(state)
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, n := range todo {
+		select {
+		case next <- n:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if firstErr != nil {
+		return res, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	sort.Slice(state.Parts, func(a, b int) bool { return state.Parts[a].Number < state.Parts[b].Number })
+	if err := u.provider.CompleteMultipart(ctx, key, state.UploadID, state.Parts); err != nil {
+		return res, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return res, u.journal.Remove(state.Provider, key)
+}
+
+// uploadPart reads part n of the file into a pooled buffer and uploads it
+func (u *Uploader) uploadPart(ctx context.Context, pool *stream.Pool, f io.ReaderAt, size int64, key, uploadID string, n int, partSize int64) (CompletedPart, error) {
+	buf, err := pool.Get(ctx)
+	if err != nil {
+		return CompletedPart{}, err
+	}
+	defer pool.Put(buf)
+
+	offset := int64(n-1) * partSize
+	length := min(partSize, size-offset)
+	data := (*buf)[:length]
+	if _, err := f.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+		return CompletedPart{}, fmt.Errorf("failed to read part %d: %w", n, err)
+	}
+	etag, err := u.provider.UploadPart(ctx, key, uploadID, n, data)
+	if err != nil {
+		return CompletedPart{}, fmt.Errorf("failed to upload part %d: %w", n, err)
+	}
+	return CompletedPart{Number: n, Size: length, ETag: etag}, nil
+}
+
+// abandon aborts a journaled upload, best effort, and forgets it
+func (u *Uploader) abandon(ctx context.Context, s *State) error {
+	err := u.provider.AbortMultipart(ctx, s.Key, s.UploadID)
+	if errors.Is(err, ErrUploadNotFound) {
+		err = nil
+	}
+	if rerr := u.journal.Remove(s.Provider, s.Key); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// CollectResult lists what CollectAbandoned cleaned up
+type CollectResult struct {
+	Aborted   []PendingUpload // provider-side uploads aborted
+	Forgotten []string        // keys whose journal entries were removed
+}
+
+// CollectAbandoned aborts uploads under prefix that were started more
+// than olderThan ago and have made no journaled progress since, and drops
+// journal entries of this provider that went stale. Uploads unknown to the
+// journal, e.g. from a host that lost its state directory, are aborted
+// once they are older than olderThan.
+func (u *Uploader) CollectAbandoned(ctx context.Context, prefix string, olderThan time.Duration) (*CollectResult, error) {
+	cutoff := u.now().Add(-olderThan)
+	res := &CollectResult{}
+
+	states, err := u.journal.List()
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[string]bool)
+	for _, s := range states {
+		if s.Provider != u.provider.Name() || !strings.HasPrefix(s.Key, prefix) {
+			continue
+		}
+		if s.UpdatedAt.After(cutoff) {
+			active[s.UploadID] = true
+			continue
+		}
+		if err := u.abandon(ctx, s); err != nil {
+			return res, fmt.Errorf("failed to abort upload of %s: %w", s.Key, err)
+		}
+		res.Forgotten = append(res.Forgotten, s.Key)
+	}
+
+	pending, err := u.provider.ListMultipart(ctx, prefix)
+	if err != nil {
+		return res, fmt.Errorf("failed to list multipart uploads: %w", err)
+	}
+	for _, p := range pending {
+		if active[p.UploadID] || p.Initiated.After(cutoff) {
+			continue
+		}
+		if err := u.provider.AbortMultipart(ctx, p.Key, p.UploadID); err != nil && !errors.Is(err, ErrUploadNotFound) {
+			return res, fmt.Errorf("failed to abort upload of %s: %w", p.Key, err)
+		}
+		res.Aborted = append(res.Aborted, p)
+	}
+	return res, nil
+}
@@ -0,0 +1,298 @@
+package multipart
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+)
+
+// fakeProvider keeps uploads in memory and can fail a part once
+type fakeProvider struct {
+	mu        sync.Mutex
+	uploads   map[string]*fakeUpload // by upload ID
+	objects   map[string][]byte
+	nextID    int
+	failPart  int
+	partCalls int
+}
+
+type fakeUpload struct {
+	key       string
+	parts     map[int][]byte
+	initiated time.Time
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{uploads: map[string]*fakeUpload{}, objects: map[string][]byte{}}
+}
+
+func (p *fakeProvider) Name() string { return "fake:bucket" }
+
+func (p *fakeProvider) CreateMultipart(ctx context.Context, key string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	id := fmt.Sprintf("upload-%d", p.nextID)
+	p.uploads[id] = &fakeUpload{key: key, parts: map[int][]byte{}, initiated: time.Now()}
+	return id, nil
+}
+
+func (p *fakeProvider) UploadPart(ctx context.Context, key, id string, n int, data []byte) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partCalls++
+	if n == p.failPart {
+		p.failPart = 0
+		return "", errors.New("connection reset")
+	}
+	u, ok := p.uploads[id]
+	if !ok {
+		return "", ErrUploadNotFound
+	}
+	u.parts[n] = append([]byte(nil), data...)
+	return fmt.Sprintf("etag-%d", n), nil
+}
+
+func (p *fakeProvider) CompleteMultipart(ctx context.Context, key, id string, parts []CompletedPart) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.uploads[id]
+	if !ok {
+		return ErrUploadNotFound
+	}
+	var obj []byte
+	for i, part := range parts {
+		if part.Number != i+1 || part.ETag != fmt.Sprintf("etag-%d", part.Number) {
+			return fmt.Errorf("bad part list %+v", parts)
+		}
+		obj = append(obj, u.parts[part.Number]...)
+	}
+	p.objects[key] = obj
+	delete(p.uploads, id)
+	return nil
+}
+
+func (p *fakeProvider) AbortMultipart(ctx context.Context, key, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.uploads[id]; !ok {
+		return ErrUploadNotFound
+	}
+	delete(p.uploads, id)
+	return nil
+}
+
+func (p *fakeProvider) ListMultipart(ctx context.Context, prefix string) ([]PendingUpload, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []PendingUpload
+	for id, u := range p.uploads {
+		out = append(out, PendingUpload{Key: u.key, UploadID: id, Initiated: u.initiated})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UploadID < out[j].UploadID })
+	return out, nil
+}
+
+func setup(t *testing.T, size int) (*fakeProvider, *Journal, *Uploader, string, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(dir, "dump.sql.zst")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	j, err := NewJournal(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newFakeProvider()
+	u, err := NewUploader(p, j, config.UploadConfig{PartSize: "64KB", Concurrency: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, j, u, path, data
+}
+
+func TestUploadFile(t *testing.T) {
+	p, j, u, path, data := setup(t, 300<<10)
+	res, err := u.UploadFile(context.Background(), path, "db/orders/1.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Parts != 5 || res.Resumed != 0 || res.Bytes != int64(len(data)) {
+		t.Fatalf("UploadFile() = %+v", res)
+	}
+	if !bytes.Equal(p.objects["db/orders/1.zst"], data) {
+		t.Fatal("object does not match the file")
+	}
+	if states, _ := j.List(); len(states) != 0 {
+		t.Fatalf("journal kept %d entries after completion", len(states))
+	}
+}
+
+func TestUploadResumesAfterFailure(t *testing.T) {
+	p, j, u, path, data := setup(t, 640<<10)
+	u.concurrency = 1
+	p.failPart = 6
+
+	if _, err := u.UploadFile(context.Background(), path, "big.zst"); err == nil {
+		t.Fatal("upload succeeded despite the failed part")
+	}
+	state, err := j.Load(p.Name(), "big.zst")
+	if err != nil || state == nil || len(state.Parts) != 5 || state.Uploaded() != 5*64<<10 {
+		t.Fatalf("journal after failure: %+v, %v", state, err)
+	}
+
+	calls := p.partCalls
+	res, err := u.UploadFile(context.Background(), path, "big.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Resumed != 5 || res.UploadID != state.UploadID || p.partCalls-calls != 5 {
+		t.Fatalf("resume = %+v after %d part uploads", res, p.partCalls-calls)
+	}
+	if !bytes.Equal(p.objects["big.zst"], data) {
+		t.Fatal("resumed object does not match the file")
+	}
+}
+
+func TestStream(t *testing.T) {
+	p, j, u, _, data := setup(t, 300<<10)
+	ctx := context.Background()
+	s, err := u.Stream(ctx, "db/orders/2.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written in odd sizes, parts are still cut at the part size
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 10000)
+		if _, err := s.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	res, err := s.Complete(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Parts != 5 || res.Bytes != int64(len(data)) || !bytes.Equal(p.objects["db/orders/2.zst"], data) {
+		t.Fatalf("Complete() = %+v", res)
+	}
+	if states, _ := j.List(); len(states) != 0 {
+		t.Fatalf("journal kept %d entries after completion", len(states))
+	}
+
+	// A failed part fails the writes; the parts uploaded are journaled
+	// until the upload is aborted
+	u.concurrency = 1
+	p.failPart = 3
+	s, err = u.Stream(ctx, "failed.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Write(data)
+	if err == nil {
+		_, err = s.Complete(ctx)
+	}
+	if err == nil {
+		t.Fatal("stream succeeded despite the failed part")
+	}
+	if state, _ := j.Load(p.Name(), "failed.zst"); state == nil || state.Uploaded() >= int64(len(data)) {
+		t.Fatalf("journal after failure: %+v", state)
+	}
+	if err := s.Abort(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := j.Load(p.Name(), "failed.zst"); state != nil || len(p.uploads) != 0 {
+		t.Fatalf("aborted upload left %+v and %d uploads", state, len(p.uploads))
+	}
+}
+
+func TestChangedFileStartsOver(t *testing.T) {
+	p, j, u, path, _ := setup(t, 200<<10)
+	p.failPart = 2
+	u.concurrency = 1
+	u.UploadFile(context.Background(), path, "k")
+	old, _ := j.Load(p.Name(), "k")
+
+	os.WriteFile(path, []byte("rewritten"), 0600)
+	res, err := u.UploadFile(context.Background(), path, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.UploadID == old.UploadID || res.Resumed != 0 {
+		t.Fatalf("changed file resumed the old upload: %+v", res)
+	}
+	if _, ok := p.uploads[old.UploadID]; ok {
+		t.Fatal("the old upload was not aborted")
+	}
+}
+
+func TestExpiredUploadStartsOver(t *testing.T) {
+	p, j, u, path, data := setup(t, 200<<10)
+	p.failPart = 2
+	u.concurrency = 1
+	u.UploadFile(context.Background(), path, "k")
+	old, _ := j.Load(p.Name(), "k")
+	delete(p.uploads, old.UploadID)
+
+	if _, err := u.UploadFile(context.Background(), path, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.objects["k"], data) {
+		t.Fatal("object does not match the file")
+	}
+}
+
+func TestCollectAbandoned(t *testing.T) {
+	p, j, u, path, _ := setup(t, 200<<10)
+	ctx := context.Background()
+
+	// A stale journaled upload, a recent one and an orphan from another host
+	p.failPart = 1
+	u.UploadFile(ctx, path, "db/stale")
+	stale, _ := j.Load(p.Name(), "db/stale")
+	stale.UpdatedAt = time.Now().Add(-100 * time.Hour)
+	j.Save(stale)
+
+	p.failPart = 1
+	u.UploadFile(ctx, path, "db/recent")
+	recent, _ := j.Load(p.Name(), "db/recent")
+	p.uploads[recent.UploadID].initiated = time.Now().Add(-100 * time.Hour)
+
+	orphan, _ := p.CreateMultipart(ctx, "db/orphan")
+	p.uploads[orphan].initiated = time.Now().Add(-100 * time.Hour)
+	young, _ := p.CreateMultipart(ctx, "db/young")
+
+	res, err := u.CollectAbandoned(ctx, "db/", 72*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Forgotten) != 1 || res.Forgotten[0] != "db/stale" {
+		t.Fatalf("Forgotten = %v", res.Forgotten)
+	}
+	if len(res.Aborted) != 1 || res.Aborted[0].UploadID != orphan {
+		t.Fatalf("Aborted = %+v", res.Aborted)
+	}
+	if _, ok := p.uploads[recent.UploadID]; !ok {
+		t.Fatal("an upload with recent progress was aborted")
+	}
+	if _, ok := p.uploads[young]; !ok {
+		t.Fatal("a young upload was aborted")
+	}
+	if s, _ := j.Load(p.Name(), "db/stale"); s != nil {
+		t.Fatal("the stale journal entry was kept")
+	}
+}
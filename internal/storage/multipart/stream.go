@@ -0,0 +1,119 @@
+package multipart
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// Stream is an upload written while the artifact is produced: writes are
+// cut into parts of the configured size, up to the configured concurrency
+// of which are uploaded at once, so the upload overlaps the dump instead
+// of following it. Every uploaded part is journaled as it completes.
+type Stream struct {
+	u     *Uploader
+	cw    *stream.ChunkWriter
+	mu    sync.Mutex
+	state *State
+}
+
+// Stream starts a streamed upload to key. A stream is limited to 10000
+// parts of the configured part size.
+func (u *Uploader) Stream(ctx context.Context, key string) (*Stream, error) {
+	id, err := u.provider.CreateMultipart(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	now := u.now()
+	s := &Stream{u: u, state: &State{
+		Provider:  u.provider.Name(),
+		Key:       key,
+		UploadID:  id,
+		PartSize:  u.partSize,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}}
+	if err := u.journal.Save(s.state); err != nil {
+		u.provider.AbortMultipart(ctx, key, id)
+		return nil, err
+	}
+	s.cw = stream.NewChunkWriter(ctx, int(u.partSize), u.concurrency, s.put)
+	return s, nil
+}
+
+// put uploads and journals one part
+func (s *Stream) put(ctx context.Context, p stream.Part) error {
+	if p.Number > maxParts {
+		return fmt.Errorf("artifact exceeds %d parts of %d bytes", maxParts, s.state.PartSize)
+	}
+	etag, err := s.u.provider.UploadPart(ctx, s.state.Key, s.state.UploadID, p.Number, p.Data)
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", p.Number, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Parts = append(s.state.Parts, CompletedPart{Number: p.Number, Size: int64(len(p.Data)), ETag: etag})
+	s.state.UpdatedAt = s.u.now()
+	return s.u.journal.Save(s.state)
+}
+
+// Write implements io.Writer. It blocks while every part buffer is in
+// flight and fails once an upload failed.
+func (s *Stream) Write(p []byte) (int, error) {
+	return s.cw.Write(p)
+}
+
+// Complete uploads the last part and completes the upload
+func (s *Stream) Complete(ctx context.Context) (*Result, error) {
+	if err := s.cw.Close(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.Slice(s.state.Parts, func(a, b int) bool { return s.state.Parts[a].Number < s.state.Parts[b].Number })
+	if err := s.u.provider.CompleteMultipart(ctx, s.state.Key, s.state.UploadID, s.state.Parts); err != nil {
+		return nil, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	res := &Result{UploadID: s.state.UploadID, Parts: len(s.state.Parts), Bytes: s.cw.Written()}
+	return res, s.u.journal.Remove(s.state.Provider, s.state.Key)
+}
+
+// Resume finishes an upload whose streaming failed from the file at path,
+// the whole artifact the stream was written from. The parts the stream
+// uploaded are kept and only the missing ones are uploaded from the file,
+// as UploadFile resumes an interrupted upload; an artifact that outgrew
+// the part limit is uploaded again in larger parts.
+func (s *Stream) Resume(ctx context.Context, path string) (*Result, error) {
+	s.cw.Abort()
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.state.Source = path
+	s.state.SourceSize = info.Size()
+	s.state.SourceModTime = info.ModTime()
+	err = s.u.journal.Save(s.state)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return s.u.UploadFile(ctx, path, s.state.Key)
+}
+
+// Abort stops the uploads in flight and aborts the upload provider-side,
+// including the one Resume started over with
+func (s *Stream) Abort(ctx context.Context) error {
+	s.cw.Abort()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.u.journal.Load(s.state.Provider, s.state.Key)
+	if err != nil || state == nil {
+		state = s.state
+	}
+	return s.u.abandon(ctx, state)
+}
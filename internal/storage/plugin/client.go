@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sanskarpan/db-backup/internal/pluginrpc"
+)
+
+// Client is the host side of a plugin. It implements Provider by calling
+// the plugin, which runs from Open until Close.
+type Client struct {
+	name string
+	proc *pluginrpc.Process
+	conn *grpc.ClientConn
+}
+
+// Open starts the plugin executable at path and configures it with options.
+// name identifies the provider in errors.
+func Open(ctx context.Context, name, path string, options map[string]string, handshakeTimeout time.Duration) (*Client, error) {
+	proc, err := protocol.Start(path, handshakeTimeout)
+	if err != nil {
+		return nil, &Error{Provider: name, Message: "failed to start plugin", Err: err}
+	}
+	conn, err := proc.Dial()
+	if err != nil {
+		proc.Stop()
+		return nil, &Error{Provider: name, Message: "failed to dial plugin", Err: err}
+	}
+	c := &Client{name: name, proc: proc, conn: conn}
+	if err := c.Configure(ctx, options); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close stops the plugin
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.proc.Stop()
+	c.conn, c.proc = nil, nil
+	return err
+}
+
+// Configure passes options to the plugin
+func (c *Client) Configure(ctx context.Context, options map[string]string) error {
+	return c.invoke(ctx, methodConfigure, &configureRequest{Options: options}, &done{})
+}
+
+// Upload streams r to the plugin, which stores it under key
+func (c *Client) Upload(ctx context.Context, key string, r io.Reader, size int64) error {
+	if c.conn == nil {
+		return c.error("closed", nil)
+	}
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], methodUpload)
+	if err != nil {
+		return c.status(err)
+	}
+	if err := stream.SendMsg(&Chunk{Key: key, Size: size}); err != nil && err != io.EOF {
+		return c.status(err)
+	}
+
+	// A send fails with io.EOF once the plugin has given up on the
+	// upload; its error is then returned by RecvMsg
+	w := sendChunks(stream.SendMsg)
+	_, copyErr := io.Copy(w, r)
+	if copyErr == nil {
+		copyErr = w.Flush()
+	}
+	if copyErr != nil && copyErr != io.EOF {
+		return c.error("failed to send object", copyErr)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return c.status(err)
+	}
+	if err := stream.RecvMsg(&done{}); err != nil {
+		return c.status(err)
+	}
+	return nil
+}
+
+// Download streams the object at key from the plugin into w
+func (c *Client) Download(ctx context.Context, key string, w io.Writer) error {
+	if c.conn == nil {
+		return c.error("closed", nil)
+	}
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[1], methodDownload)
+	if err != nil {
+		return c.status(err)
+	}
+	if err := stream.SendMsg(&keyRequest{Key: key}); err != nil {
+		return c.status(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return c.status(err)
+	}
+	if _, err := io.Copy(w, recvChunks(nil, stream.RecvMsg)); err != nil {
+		return c.status(err)
+	}
+	return nil
+}
+
+// Stat describes the object at key
+func (c *Client) Stat(ctx context.Context, key string) (*Object, error) {
+	var obj Object
+	if err := c.invoke(ctx, methodStat, &keyRequest{Key: key}, &obj); err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// List returns the objects whose key starts with prefix
+func (c *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+	var reply listReply
+	if err := c.invoke(ctx, methodList, &listRequest{Prefix: prefix}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Objects, nil
+}
+
+// Delete removes the object at key
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.invoke(ctx, methodDelete, &keyRequest{Key: key}, &done{})
+}
+
+// invoke makes a unary call to the plugin
+func (c *Client) invoke(ctx context.Context, method string, req, reply any) error {
+	if c.conn == nil {
+		return c.error("closed", nil)
+	}
+	if err := c.conn.Invoke(ctx, method, req, reply); err != nil {
+		return c.status(err)
+	}
+	return nil
+}
+
+// status turns the status of a failed call back into the plugin's error,
+// ErrNotFound for a missing object
+func (c *Client) status(err error) error {
+	if s, ok := status.FromError(err); ok {
+		if s.Code() == codes.NotFound {
+			return c.error(s.Message(), ErrNotFound)
+		}
+		return c.error(s.Message(), nil)
+	}
+	return c.error("plugin call failed", err)
+}
+
+func (c *Client) error(msg string, err error) error {
+	return &Error{Provider: c.name, Message: msg, Err: err}
+}
+
+// Error is a failed call of a plugin
+type Error struct {
+	Provider string
+	Message  string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("storage plugin %s: %s", e.Provider, e.Message)
+	// Plugins returning ErrNotFound wrapped already name it
+	if e.Err != nil && !strings.Contains(e.Message, e.Err.Error()) {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
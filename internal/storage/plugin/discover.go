@@ -0,0 +1,15 @@
+package plugin
+
+import (
+	"github.com/sanskarpan/db-backup/internal/pluginrpc"
+)
+
+// Prefix names plugin executables: dbbackup-storage-<name>, plus .exe on
+// Windows, serves the storage provider <name>
+const Prefix = "dbbackup-storage-"
+
+// Find returns the plugin executables in dir by provider name. A missing
+// directory holds no plugins.
+func Find(dir string) (map[string]string, error) {
+	return pluginrpc.Find(dir, Prefix)
+}
@@ -0,0 +1,216 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/pluginrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestMain serves memProvider when the test binary is started as a plugin
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginrpc.CookieKey) == CookieValue {
+		Serve(&memProvider{objects: map[string][]byte{}})
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// memProvider keeps objects in memory and requires a bucket option
+type memProvider struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+func (p *memProvider) Configure(ctx context.Context, options map[string]string) error {
+	if options["bucket"] == "" {
+		return errors.New("option bucket is required")
+	}
+	p.bucket = options["bucket"]
+	return nil
+}
+
+func (p *memProvider) Upload(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// Objects under trace/ record the trace the upload was made in
+	if strings.HasPrefix(key, "trace/") {
+		data = []byte(trace.SpanContextFromContext(ctx).TraceID().String())
+		size = int64(len(data))
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("received %d bytes of %d", len(data), size)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.objects[key] = data
+	return nil
+}
+
+func (p *memProvider) Download(ctx context.Context, key string, w io.Writer) error {
+	p.mu.Lock()
+	data, ok := p.objects[key]
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s/%s: %w", p.bucket, key, ErrNotFound)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func (p *memProvider) Stat(ctx context.Context, key string) (*Object, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data, ok := p.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &Object{Key: key, Size: int64(len(data)), ModTime: time.Unix(1700000000, 0)}, nil
+}
+
+func (p *memProvider) List(ctx context.Context, prefix string) ([]Object, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var objects []Object
+	for key, data := range p.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (p *memProvider) Delete(ctx context.Context, key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.objects[key]; !ok {
+		return ErrNotFound
+	}
+	delete(p.objects, key)
+	return nil
+}
+
+// installPlugin links the test binary into a plugins directory as the
+// provider name
+func installPlugin(t *testing.T, name string) string {
+	t.Helper()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(self, filepath.Join(dir, Prefix+name)); err != nil {
+		t.Skipf("cannot link the test binary: %v", err)
+	}
+	return dir
+}
+
+func TestPlugin(t *testing.T) {
+	plugins, err := Find(installPlugin(t, "tape"))
+	if err != nil || plugins["tape"] == "" {
+		t.Fatalf("Find() = %v, %v", plugins, err)
+	}
+	ctx := context.Background()
+
+	if _, err := Open(ctx, "tape", plugins["tape"], nil, 10*time.Second); err == nil || !strings.Contains(err.Error(), "bucket is required") {
+		t.Fatalf("Open() without options = %v", err)
+	}
+	c, err := Open(ctx, "tape", plugins["tape"], map[string]string{"bucket": "vault"}, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	defer c.Close()
+
+	// Objects span several chunks in both directions
+	data := bytes.Repeat([]byte("backup"), 1<<19)
+	if err := c.Upload(ctx, "orders/full.sql.gz", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Upload() = %v", err)
+	}
+	if err := c.Upload(ctx, "orders/short.sql.gz", strings.NewReader("x"), 2); err == nil || !strings.Contains(err.Error(), "received 1 bytes of 2") {
+		t.Errorf("Upload() of a short object = %v", err)
+	}
+	var got bytes.Buffer
+	if err := c.Download(ctx, "orders/full.sql.gz", &got); err != nil || !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("Download() = %d bytes, %v", got.Len(), err)
+	}
+	obj, err := c.Stat(ctx, "orders/full.sql.gz")
+	if err != nil || obj.Size != int64(len(data)) || !obj.ModTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Stat() = %+v, %v", obj, err)
+	}
+	objects, err := c.List(ctx, "orders/")
+	if err != nil || len(objects) != 1 || objects[0].Key != "orders/full.sql.gz" {
+		t.Errorf("List() = %v, %v", objects, err)
+	}
+
+	if err := c.Delete(ctx, "orders/full.sql.gz"); err != nil {
+		t.Errorf("Delete() = %v", err)
+	}
+	if err := c.Download(ctx, "orders/full.sql.gz", io.Discard); !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "vault/orders/full.sql.gz") {
+		t.Errorf("Download() of a deleted object = %v", err)
+	}
+	if _, err := c.Stat(ctx, "orders/full.sql.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() of a deleted object = %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if _, err := c.List(ctx, ""); err == nil {
+		t.Error("List() after Close succeeded")
+	}
+}
+
+func TestPluginTrace(t *testing.T) {
+	plugins, err := Find(installPlugin(t, "tape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Open(context.Background(), "tape", plugins["tape"], map[string]string{"bucket": "vault"}, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	defer c.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	ctx, span := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test").Start(context.Background(), "backup")
+	defer span.End()
+
+	// The upload reaches the plugin in the backup's trace
+	if err := c.Upload(ctx, "trace/orders", strings.NewReader(""), 0); err != nil {
+		t.Fatalf("Upload() = %v", err)
+	}
+	var got bytes.Buffer
+	if err := c.Download(context.Background(), "trace/orders", &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := span.SpanContext().TraceID().String(); got.String() != want {
+		t.Errorf("plugin saw trace %q, want %q", got.String(), want)
+	}
+
+	// Without a span there is nothing to propagate
+	if err := c.Upload(context.Background(), "trace/none", strings.NewReader(""), 0); err != nil {
+		t.Fatal(err)
+	}
+	got.Reset()
+	c.Download(context.Background(), "trace/none", &got)
+	if got.String() != (trace.TraceID{}).String() {
+		t.Errorf("plugin saw trace %q without one", got.String())
+	}
+}
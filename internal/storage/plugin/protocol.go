@@ -0,0 +1,149 @@
+// Package plugin runs storage providers shipped as separate executables,
+// so niche targets such as tape gateways or proprietary object stores can
+// be built and released outside this repository. A plugin serves one
+// Provider over the transport of every db-backup plugin, see
+// internal/pluginrpc, and must be built with Serve from pkg/storageplugin.
+//
+// Unlike driver plugins, which are started per connection, a provider
+// plugin is started by Open and serves every call until Close.
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/sanskarpan/db-backup/internal/pluginrpc"
+)
+
+// Handshake values. A plugin started without the cookie refuses to run,
+// and the host refuses plugins speaking another protocol version.
+const (
+	CookieValue     = "4f1e8a2c7b3d9e0f5a6b1c8d2e7f3a9b"
+	ProtocolVersion = 1
+)
+
+// protocol is the plugin protocol of storage providers
+var protocol = pluginrpc.Protocol{Kind: "storage provider", Cookie: CookieValue, Version: ProtocolVersion}
+
+// ErrNotFound is returned for a key the provider does not hold. Providers
+// return it, or an error wrapping it, from Download, Stat and Delete.
+var ErrNotFound = errors.New("object not found")
+
+// Provider is a storage provider served by a plugin. Keys are slash
+// separated paths such as "orders/2024/01/orders-20240101.sql.gz".
+type Provider interface {
+	// Configure passes the provider's options from the configuration. It
+	// is the first call a plugin receives.
+	Configure(ctx context.Context, options map[string]string) error
+	// Upload stores what r holds under key, replacing any object there.
+	// size is -1 when not known in advance.
+	Upload(ctx context.Context, key string, r io.Reader, size int64) error
+	// Download writes the object at key to w
+	Download(ctx context.Context, key string, w io.Writer) error
+	Stat(ctx context.Context, key string) (*Object, error)
+	// List returns the objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Object describes a stored object
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// serviceName is the gRPC service plugins serve
+const serviceName = "dbbackup.storage.v1.Provider"
+
+// Full method names
+const (
+	methodConfigure = "/" + serviceName + "/Configure"
+	methodStat      = "/" + serviceName + "/Stat"
+	methodList      = "/" + serviceName + "/List"
+	methodDelete    = "/" + serviceName + "/Delete"
+	methodUpload    = "/" + serviceName + "/Upload"
+	methodDownload  = "/" + serviceName + "/Download"
+)
+
+// done answers calls that return nothing
+type done = pluginrpc.Done
+
+// Request and reply messages
+type (
+	configureRequest struct {
+		Options map[string]string
+	}
+	keyRequest struct {
+		Key string
+	}
+	listRequest struct {
+		Prefix string
+	}
+	listReply struct {
+		Objects []Object
+	}
+)
+
+// Chunk carries object data. The first chunk of an Upload carries its key
+// and size.
+type Chunk struct {
+	Key  string
+	Size int64
+	Data []byte
+}
+
+// serviceDesc describes the provider service: one unary call per method of
+// Provider, Upload streaming an object to the plugin and Download
+// streaming one to the host
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		pluginrpc.Unary("Configure", (*server).configure),
+		pluginrpc.Unary("Stat", (*server).stat),
+		pluginrpc.Unary("List", (*server).list),
+		pluginrpc.Unary("Delete", (*server).delete),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Upload",
+			ClientStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(*server).upload(stream)
+			},
+		},
+		{
+			StreamName:    "Download",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				var req keyRequest
+				if err := stream.RecvMsg(&req); err != nil {
+					return err
+				}
+				return srv.(*server).download(&req, stream)
+			},
+		},
+	},
+}
+
+// sendChunks returns a writer sending what is written to it as Chunks
+func sendChunks(send func(any) error) *pluginrpc.ChunkWriter {
+	return pluginrpc.NewChunkWriter(func(data []byte) error {
+		return send(&Chunk{Data: data})
+	})
+}
+
+// recvChunks returns a reader of first and then of the data of the Chunks
+// received until the stream ends
+func recvChunks(first []byte, recv func(any) error) *pluginrpc.ChunkReader {
+	return pluginrpc.NewChunkReader(first, func() ([]byte, error) {
+		var c Chunk
+		err := recv(&c)
+		return c.Data, err
+	})
+}
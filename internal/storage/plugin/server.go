@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// server serves a provider to the host
+type server struct {
+	provider Provider
+}
+
+// Serve runs the plugin side of the protocol for provider, until the host
+// closes stdin or sends SIGTERM. It exits the process when not started by
+// a host.
+func Serve(provider Provider) {
+	if err := protocol.Serve(&serviceDesc, &server{provider: provider}); err != nil {
+		fmt.Fprintf(os.Stderr, "plugin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// failed returns err as the status of a call, keeping ErrNotFound
+// recognizable on the host
+func failed(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return err
+}
+
+func (s *server) configure(ctx context.Context, req *configureRequest) (*done, error) {
+	return &done{OK: true}, failed(s.provider.Configure(ctx, req.Options))
+}
+
+func (s *server) stat(ctx context.Context, req *keyRequest) (*Object, error) {
+	obj, err := s.provider.Stat(ctx, req.Key)
+	if err != nil {
+		return nil, failed(err)
+	}
+	return obj, nil
+}
+
+func (s *server) list(ctx context.Context, req *listRequest) (*listReply, error) {
+	objects, err := s.provider.List(ctx, req.Prefix)
+	return &listReply{Objects: objects}, failed(err)
+}
+
+func (s *server) delete(ctx context.Context, req *keyRequest) (*done, error) {
+	return &done{OK: true}, failed(s.provider.Delete(ctx, req.Key))
+}
+
+// upload feeds the chunks received to the provider
+func (s *server) upload(stream grpc.ServerStream) error {
+	var first Chunk
+	if err := stream.RecvMsg(&first); err != nil {
+		return err
+	}
+	if first.Key == "" {
+		return fmt.Errorf("upload sent no key")
+	}
+	r := recvChunks(first.Data, stream.RecvMsg)
+	if err := s.provider.Upload(stream.Context(), first.Key, r, first.Size); err != nil {
+		return failed(err)
+	}
+	return stream.SendMsg(&done{OK: true})
+}
+
+// download sends the object as chunks
+func (s *server) download(req *keyRequest, stream grpc.ServerStream) error {
+	w := sendChunks(stream.SendMsg)
+	if err := s.provider.Download(stream.Context(), req.Key, w); err != nil {
+		return failed(err)
+	}
+	return w.Flush()
+}
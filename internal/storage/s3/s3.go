@@ -0,0 +1,163 @@
+// Package s3 uploads artifacts to Amazon S3, or an S3-compatible object
+// store, as multipart uploads, so they can be streamed while the dump runs
+// and resumed part by part after an interruption, and downloads them again
+// for verification and restores.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/storage/multipart"
+)
+
+// Provider is the multipart API of an S3 bucket
+type Provider struct {
+	client *s3.Client
+	bucket string
+}
+
+// New creates the provider of cfg. Static keys from cfg are used when
+// set, otherwise the default AWS credential chain applies.
+func New(ctx context.Context, cfg config.S3Config) (*Provider, error) {
+	if cfg.Bucket == "" || cfg.Region == "" {
+		return nil, fmt.Errorf("s3: bucket and region are required")
+	}
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(cfg.Region)}
+	if cfg.AccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, "")))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("s3: failed to load AWS credentials: %w", err)
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+	})
+	return &Provider{client: client, bucket: cfg.Bucket}, nil
+}
+
+// Name identifies the provider and bucket
+func (p *Provider) Name() string {
+	return "s3:" + p.bucket
+}
+
+// CreateMultipart starts a multipart upload to key
+func (p *Provider) CreateMultipart(ctx context.Context, key string) (string, error) {
+	out, err := p.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+// UploadPart uploads part number of an upload and returns its ETag
+func (p *Provider) UploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (string, error) {
+	out, err := p.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(p.bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(int32(number)), // #nosec G115 -- at most 10000 parts
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return "", uploadError(err)
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// CompleteMultipart assembles the object from parts
+func (p *Provider) CompleteMultipart(ctx context.Context, key, uploadID string, parts []multipart.CompletedPart) error {
+	completed := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = types.CompletedPart{
+			PartNumber: aws.Int32(int32(part.Number)), // #nosec G115 -- at most 10000 parts
+			ETag:       aws.String(part.ETag),
+		}
+	}
+	_, err := p.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(p.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return uploadError(err)
+}
+
+// AbortMultipart aborts an upload, freeing its parts
+func (p *Provider) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	_, err := p.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(p.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return uploadError(err)
+}
+
+// ListMultipart returns the uploads in progress under prefix
+func (p *Provider) ListMultipart(ctx context.Context, prefix string) ([]multipart.PendingUpload, error) {
+	in := &s3.ListMultipartUploadsInput{Bucket: aws.String(p.bucket), Prefix: aws.String(prefix)}
+	var uploads []multipart.PendingUpload
+	for {
+		out, err := p.client.ListMultipartUploads(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range out.Uploads {
+			uploads = append(uploads, multipart.PendingUpload{
+				Key:       aws.ToString(u.Key),
+				UploadID:  aws.ToString(u.UploadId),
+				Initiated: aws.ToTime(u.Initiated),
+			})
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return uploads, nil
+		}
+		in.KeyMarker, in.UploadIdMarker = out.NextKeyMarker, out.NextUploadIdMarker
+	}
+}
+
+// Download writes the object at key to w
+func (p *Provider) Download(ctx context.Context, key string, w io.Writer) error {
+	out, err := p.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("s3: failed to download %s: %w", key, err)
+	}
+	defer out.Body.Close()
+	if _, err := io.Copy(w, out.Body); err != nil {
+		return fmt.Errorf("s3: failed to download %s: %w", key, err)
+	}
+	return nil
+}
+
+// uploadError reports an upload S3 does not know, aborted or expired, as
+// multipart.ErrUploadNotFound
+func uploadError(err error) error {
+	var notFound *types.NoSuchUpload
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &notFound) || (errors.As(err, &coded) && strings.EqualFold(coded.ErrorCode(), "NoSuchUpload")) {
+		return fmt.Errorf("%w: %v", multipart.ErrUploadNotFound, err)
+	}
+	return err
+}
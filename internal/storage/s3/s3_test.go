@@ -0,0 +1,261 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/storage/multipart"
+)
+
+// fakeS3 serves the multipart API of one bucket from memory
+type fakeS3 struct {
+	mu       sync.Mutex
+	uploads  map[string]*fakeUpload // by upload ID
+	objects  map[string][]byte
+	nextID   int
+	failPart int // fails the upload of this part once
+	parts    int // part uploads received
+}
+
+type fakeUpload struct {
+	key   string
+	parts map[int][]byte
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *Provider) {
+	t.Helper()
+	f := &fakeS3{uploads: map[string]*fakeUpload{}, objects: map[string][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	p, err := New(context.Background(), config.S3Config{
+		Region: "us-east-1", Bucket: "backups", AccessKey: "AKID", SecretKey: "secret",
+		Endpoint: srv.URL, UsePathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, p
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/backups/")
+	q := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	id := q.Get("uploadId")
+	u := f.uploads[id]
+	if id != "" && u == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<Error><Code>NoSuchUpload</Code><Message>The specified upload does not exist.</Message></Error>`)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.nextID++
+		id = fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = &fakeUpload{key: key, parts: map[int][]byte{}}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>backups</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, id)
+	case r.Method == http.MethodPut && id != "":
+		f.parts++
+		var n int
+		fmt.Sscan(q.Get("partNumber"), &n)
+		if n == f.failPart {
+			f.failPart = 0
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Error><Code>BadDigest</Code><Message>The Content-MD5 you specified did not match what we received.</Message></Error>`)
+			return
+		}
+		u.parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, n))
+	case r.Method == http.MethodPost && id != "":
+		var req struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var obj []byte
+		for i, p := range req.Parts {
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf(`"etag-%d"`, p.PartNumber) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `<Error><Code>InvalidPartOrder</Code></Error>`)
+				return
+			}
+			obj = append(obj, u.parts[p.PartNumber]...)
+		}
+		f.objects[key] = obj
+		delete(f.uploads, id)
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>backups</Bucket><Key>%s</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodDelete && id != "":
+		delete(f.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && q.Has("uploads"):
+		ids := make([]string, 0, len(f.uploads))
+		for id := range f.uploads {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		fmt.Fprint(w, `<ListMultipartUploadsResult><Bucket>backups</Bucket><IsTruncated>false</IsTruncated>`)
+		for _, id := range ids {
+			if strings.HasPrefix(f.uploads[id].key, q.Get("prefix")) {
+				fmt.Fprintf(w, `<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>2026-01-02T03:04:05.000Z</Initiated></Upload>`, f.uploads[id].key, id)
+			}
+		}
+		fmt.Fprint(w, `</ListMultipartUploadsResult>`)
+	case r.Method == http.MethodGet && f.objects[key] != nil:
+		w.Write(f.objects[key])
+	default:
+		http.Error(w, "unexpected "+r.Method+" "+r.URL.String(), http.StatusNotImplemented)
+	}
+}
+
+// sent returns the part uploads received
+func (f *fakeS3) sent() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.parts
+}
+
+func uploader(t *testing.T, p *Provider, concurrency int) (*multipart.Uploader, *multipart.Journal) {
+	t.Helper()
+	j, err := multipart.NewJournal(filepath.Join(t.TempDir(), "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := multipart.NewUploader(p, j, config.UploadConfig{PartSize: "64KB", Concurrency: concurrency})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u, j
+}
+
+func TestStreamedUpload(t *testing.T) {
+	f, p := newFakeS3(t)
+	u, j := uploader(t, p, 3)
+	data := bytes.Repeat([]byte("orders;"), 50000)
+
+	s, err := u.Stream(context.Background(), "db/orders/1.dump.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Complete(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Parts != 6 || !bytes.Equal(f.objects["db/orders/1.dump.zst"], data) {
+		t.Fatalf("Complete() = %+v, object of %d bytes", res, len(f.objects["db/orders/1.dump.zst"]))
+	}
+	if states, _ := j.List(); len(states) != 0 || len(f.uploads) != 0 {
+		t.Fatalf("left %d journal entries and %d uploads", len(states), len(f.uploads))
+	}
+	if p.Name() != "s3:backups" {
+		t.Errorf("Name() = %s", p.Name())
+	}
+
+	var got bytes.Buffer
+	if err := p.Download(context.Background(), "db/orders/1.dump.zst", &got); err != nil || !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("Download() = %d bytes, %v", got.Len(), err)
+	}
+	if err := p.Download(context.Background(), "db/orders/missing", io.Discard); err == nil {
+		t.Error("Download() of a missing object succeeded")
+	}
+}
+
+func TestResumedUpload(t *testing.T) {
+	f, p := newFakeS3(t)
+	// One part in flight at a time, so none is cut short by the failure
+	u, j := uploader(t, p, 1)
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("orders;"), 50000)
+	path := filepath.Join(t.TempDir(), "1.dump.zst")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f.failPart = 4
+	s, err := u.Stream(ctx, "db/orders/1.dump.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write(data)
+	if _, err := s.Complete(ctx); err == nil || !strings.Contains(err.Error(), "failed to upload part 4") {
+		t.Fatalf("Complete() = %v", err)
+	}
+	states, _ := j.List()
+	if len(states) != 1 || len(states[0].Parts) == 0 {
+		t.Fatalf("journal holds %+v", states)
+	}
+
+	// Only the parts missing from the upload are sent again
+	uploaded := f.sent()
+	res, err := s.Resume(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := f.sent() - uploaded; res.Parts != 6 || res.Resumed != len(states[0].Parts) || sent != res.Parts-res.Resumed {
+		t.Errorf("Resume() = %+v after %d parts, sent %d", res, len(states[0].Parts), sent)
+	}
+	if !bytes.Equal(f.objects["db/orders/1.dump.zst"], data) {
+		t.Errorf("object of %d bytes", len(f.objects["db/orders/1.dump.zst"]))
+	}
+	if states, _ := j.List(); len(states) != 0 || len(f.uploads) != 0 {
+		t.Errorf("left %d journal entries and %d uploads", len(states), len(f.uploads))
+	}
+}
+
+func TestUploadNotFound(t *testing.T) {
+	f, p := newFakeS3(t)
+	ctx := context.Background()
+	id, err := p.CreateMultipart(ctx, "db/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.CreateMultipart(ctx, "other/b"); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := p.ListMultipart(ctx, "db/")
+	if err != nil || len(pending) != 1 || pending[0].UploadID != id || pending[0].Key != "db/a" ||
+		!pending[0].Initiated.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("ListMultipart() = %+v, %v", pending, err)
+	}
+
+	if err := p.AbortMultipart(ctx, "db/a", id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.UploadPart(ctx, "db/a", id, 1, []byte("x")); !errors.Is(err, multipart.ErrUploadNotFound) {
+		t.Errorf("UploadPart() of an aborted upload = %v", err)
+	}
+	if err := p.AbortMultipart(ctx, "db/a", id); !errors.Is(err, multipart.ErrUploadNotFound) {
+		t.Errorf("AbortMultipart() of an aborted upload = %v", err)
+	}
+	if len(f.uploads) != 1 {
+		t.Errorf("%d uploads left", len(f.uploads))
+	}
+}
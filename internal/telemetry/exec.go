@@ -0,0 +1,153 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sanskarpan/db-backup/pkg/redact"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Metadata keys holding the trace context of a backup or restore job
+const (
+	MetadataTraceID = "trace_id"
+	MetadataSpanID  = "span_id"
+)
+
+// tracer uses the global provider so drivers need no Telemetry handle;
+// Setup installs the provider, otherwise spans are no-ops
+func tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(instrumentationName)
+}
+
+// Command traces one run of an external tool such as pg_dump or
+// mysqldump. Stderr is never recorded since tools echo connection details
+// into it.
+type Command struct {
+	span  trace.Span
+	cmd   *exec.Cmd
+	start time.Time
+	bytes atomic.Int64
+}
+
+// StartCommand starts a span for cmd, named after the executable. Call it
+// before cmd is started and End once it has exited.
+func StartCommand(ctx context.Context, cmd *exec.Cmd, attrs ...attribute.KeyValue) *Command {
+	name := filepath.Base(cmd.Path)
+	attrs = append(attrs,
+		attribute.String("process.executable.name", name),
+		attribute.String("process.command_line", redact.String(strings.Join(cmd.Args, " "))),
+	)
+	_, span := tracer().Start(ctx, "exec "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return &Command{span: span, cmd: cmd, start: time.Now()}
+}
+
+// Count wraps the tool's output so the bytes it produces are recorded
+func (c *Command) Count(w io.Writer) io.Writer {
+	return &countingWriter{w: w, n: &c.bytes}
+}
+
+// Counted returns the bytes written through Count
+func (c *Command) Counted() int64 {
+	return c.bytes.Load()
+}
+
+// End records the exit code, the size of the tool's output and any error,
+// then ends the span. A negative size is not recorded.
+func (c *Command) End(err error, size int64) {
+	if c.cmd.ProcessState != nil {
+		c.span.SetAttributes(attribute.Int("process.exit.code", c.cmd.ProcessState.ExitCode()))
+	}
+	if size >= 0 {
+		c.span.SetAttributes(attribute.Int64("process.output.bytes", size))
+	}
+	c.span.SetAttributes(attribute.Float64("process.duration_seconds", time.Since(c.start).Seconds()))
+	if err != nil {
+		c.span.RecordError(err)
+		c.span.SetStatus(codes.Error, redact.String(err.Error()))
+	}
+	c.span.End()
+}
+
+// Stages traces the consecutive stages of one job (dump, compress,
+// encrypt, upload, ...) as child spans of the job span, so a slow backup
+// can be dissected stage by stage
+type Stages struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	current string
+	span    trace.Span
+}
+
+// NewStages traces stages under the span in ctx
+func NewStages(ctx context.Context) *Stages {
+	return &Stages{ctx: ctx}
+}
+
+// Enter ends the running stage span, if any, and starts one for stage.
+// Repeated calls for the running stage, e.g. from progress updates, are
+// ignored.
+func (s *Stages) Enter(stage string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stage == s.current {
+		return
+	}
+	if s.span != nil {
+		s.span.End()
+	}
+	s.current = stage
+	_, s.span = tracer().Start(s.ctx, stage, trace.WithAttributes(attribute.String("backup.stage", stage)))
+}
+
+// End ends the running stage span
+func (s *Stages) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.span != nil {
+		s.span.End()
+		s.span = nil
+	}
+	s.current = ""
+}
+
+// TraceMetadata returns a copy of metadata with the trace and span IDs of
+// the active span added, so stored job metadata links back to the trace.
+// Without an active span metadata is returned unchanged.
+func TraceMetadata(ctx context.Context, metadata map[string]string) map[string]string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return metadata
+	}
+	out := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		out[k] = v
+	}
+	out[MetadataTraceID] = sc.TraceID().String()
+	out[MetadataSpanID] = sc.SpanID().String()
+	return out
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
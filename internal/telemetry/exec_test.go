@@ -0,0 +1,114 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a global tracer provider recording every span
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return recorder
+}
+
+func spanAttrs(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range s.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestBackupTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	ctx, root := tracer().Start(context.Background(), "backup")
+
+	stages := NewStages(ctx)
+	stages.Enter("dump")
+	stages.Enter("dump")
+	cmd := exec.Command("sh", "-c", "printf dump; exit 3", "postgres://admin:hunter2@db/orders")
+	run := StartCommand(ctx, cmd, attribute.String("db.name", "orders"))
+	cmd.Stdout = run.Count(io.Discard)
+	err := cmd.Run()
+	run.End(err, run.Counted())
+	stages.Enter("upload")
+	stages.End()
+
+	metadata := TraceMetadata(ctx, map[string]string{"database": "orders"})
+	root.End()
+
+	spans := recorder.Ended()
+	names := make([]string, len(spans))
+	for i, s := range spans {
+		names[i] = s.Name()
+		if s.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("span %s is not in the backup's trace", s.Name())
+		}
+		if s.Name() != "backup" && s.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("span %s is not a child of the backup span", s.Name())
+		}
+	}
+	if got, want := strings.Join(names, ","), "exec sh,dump,upload,backup"; got != want {
+		t.Fatalf("spans %s, want %s", got, want)
+	}
+
+	tool := spans[0]
+	attrs := spanAttrs(tool)
+	if tool.SpanKind() != trace.SpanKindClient || tool.Status().Code != codes.Error {
+		t.Errorf("exec span %v with status %v", tool.SpanKind(), tool.Status())
+	}
+	if attrs["process.exit.code"].AsInt64() != 3 || attrs["process.output.bytes"].AsInt64() != 4 || attrs["db.name"].AsString() != "orders" {
+		t.Errorf("exec attributes %v", attrs)
+	}
+	if line := attrs["process.command_line"].AsString(); line == "" || strings.Contains(line, "hunter2") {
+		t.Errorf("command line %q", line)
+	}
+	if stage := spanAttrs(spans[1])["backup.stage"].AsString(); stage != "dump" {
+		t.Errorf("stage attribute %q", stage)
+	}
+
+	if metadata["trace_id"] != root.SpanContext().TraceID().String() ||
+		metadata["span_id"] != root.SpanContext().SpanID().String() || metadata["database"] != "orders" {
+		t.Errorf("metadata %v", metadata)
+	}
+}
+
+func TestTraceMetadataWithoutSpan(t *testing.T) {
+	metadata := map[string]string{"database": "orders"}
+	if got := TraceMetadata(context.Background(), metadata); len(got) != 1 {
+		t.Errorf("metadata %v", got)
+	}
+	if SpanFields(context.Background()) != nil {
+		t.Error("span fields without a span")
+	}
+}
+
+func TestCommandWithoutExit(t *testing.T) {
+	recorder := recordSpans(t)
+	cmd := exec.Command("/nonexistent/pg_dump")
+	run := StartCommand(context.Background(), cmd)
+	run.End(errors.New("exec: not found"), -1)
+
+	attrs := spanAttrs(recorder.Ended()[0])
+	if _, ok := attrs["process.exit.code"]; ok {
+		t.Error("exit code of a tool that never ran")
+	}
+	if _, ok := attrs["process.output.bytes"]; ok {
+		t.Error("negative size recorded")
+	}
+}
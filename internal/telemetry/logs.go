@@ -0,0 +1,159 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// zerolog field names with special meaning
+const (
+	fieldLevel   = "level"
+	fieldMessage = "message"
+	fieldTime    = "time"
+	fieldTraceID = "trace_id"
+	fieldSpanID  = "span_id"
+)
+
+// SpanFields returns the trace and span IDs of the active span in ctx as
+// log fields, for use with logger.WithContext. The log exporter turns them
+// back into the record's trace context.
+func SpanFields(ctx context.Context) map[string]interface{} {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return map[string]interface{}{
+		fieldTraceID: sc.TraceID().String(),
+		fieldSpanID:  sc.SpanID().String(),
+	}
+}
+
+// logWriter converts zerolog JSON records into OpenTelemetry log records,
+// using the trace_id and span_id fields as the record's trace context
+type logWriter struct {
+	logger   otellog.Logger
+	minLevel otellog.Severity
+}
+
+func newLogWriter(logger otellog.Logger, level string) *logWriter {
+	minLevel := severity(level)
+	if minLevel == otellog.SeverityUndefined {
+		minLevel = otellog.SeverityInfo
+	}
+	return &logWriter{logger: logger, minLevel: minLevel}
+}
+
+// Write exports one zerolog record. Malformed records are dropped rather
+// than failing the primary log output.
+func (w *logWriter) Write(p []byte) (int, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return len(p), nil
+	}
+
+	levelText, _ := fields[fieldLevel].(string)
+	sev := severity(levelText)
+	if sev < w.minLevel {
+		return len(p), nil
+	}
+
+	var rec otellog.Record
+	rec.SetSeverity(sev)
+	rec.SetSeverityText(levelText)
+	rec.SetObservedTimestamp(time.Now())
+	if msg, ok := fields[fieldMessage].(string); ok {
+		rec.SetBody(otellog.StringValue(msg))
+	}
+	if ts, ok := fields[fieldTime].(string); ok {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			rec.SetTimestamp(t)
+		}
+	}
+
+	ctx := context.Background()
+	if sc, ok := spanContext(fields); ok {
+		ctx = trace.ContextWithSpanContext(ctx, sc)
+	}
+
+	for k, v := range fields {
+		switch k {
+		case fieldLevel, fieldMessage, fieldTime, fieldTraceID, fieldSpanID:
+			continue
+		}
+		rec.AddAttributes(otellog.KeyValue{Key: k, Value: logValue(v)})
+	}
+
+	w.logger.Emit(ctx, rec)
+	return len(p), nil
+}
+
+// spanContext rebuilds the span context recorded by SpanFields
+func spanContext(fields map[string]interface{}) (trace.SpanContext, bool) {
+	traceHex, _ := fields[fieldTraceID].(string)
+	spanHex, _ := fields[fieldSpanID].(string)
+	if traceHex == "" || spanHex == "" {
+		return trace.SpanContext{}, false
+	}
+	traceID, err := trace.TraceIDFromHex(traceHex)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	spanID, err := trace.SpanIDFromHex(spanHex)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+	return sc, sc.IsValid()
+}
+
+// logValue converts a decoded JSON value into a log attribute value
+func logValue(v interface{}) otellog.Value {
+	switch val := v.(type) {
+	case string:
+		return otellog.StringValue(val)
+	case bool:
+		return otellog.BoolValue(val)
+	case float64:
+		if val == float64(int64(val)) {
+			return otellog.Int64Value(int64(val))
+		}
+		return otellog.Float64Value(val)
+	case nil:
+		return otellog.Value{}
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return otellog.StringValue(fmt.Sprint(val))
+		}
+		return otellog.StringValue(string(data))
+	}
+}
+
+// severity maps zerolog level names to OpenTelemetry severities
+func severity(level string) otellog.Severity {
+	switch level {
+	case "trace":
+		return otellog.SeverityTrace
+	case "debug":
+		return otellog.SeverityDebug
+	case "info":
+		return otellog.SeverityInfo
+	case "warn":
+		return otellog.SeverityWarn
+	case "error":
+		return otellog.SeverityError
+	case "fatal", "panic":
+		return otellog.SeverityFatal
+	default:
+		return otellog.SeverityUndefined
+	}
+}
@@ -0,0 +1,71 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// instruments mirror the Prometheus backup metrics for OTLP backends
+type instruments struct {
+	backups     metric.Int64Counter
+	duration    metric.Float64Histogram
+	size        metric.Int64Histogram
+	lastSuccess metric.Int64Gauge
+}
+
+func newInstruments(meter metric.Meter) (*instruments, error) {
+	var i instruments
+	var err error
+	if i.backups, err = meter.Int64Counter("dbbackup.backups",
+		metric.WithDescription("Backup runs by outcome.")); err != nil {
+		return nil, fmt.Errorf("failed to create backups counter: %w", err)
+	}
+	if i.duration, err = meter.Float64Histogram("dbbackup.backup.duration",
+		metric.WithDescription("Backup run duration."),
+		metric.WithUnit("s")); err != nil {
+		return nil, fmt.Errorf("failed to create duration histogram: %w", err)
+	}
+	if i.size, err = meter.Int64Histogram("dbbackup.backup.size",
+		metric.WithDescription("Uncompressed size of successful backups."),
+		metric.WithUnit("By")); err != nil {
+		return nil, fmt.Errorf("failed to create size histogram: %w", err)
+	}
+	if i.lastSuccess, err = meter.Int64Gauge("dbbackup.backup.last_success",
+		metric.WithDescription("Unix time of the last successful backup."),
+		metric.WithUnit("s")); err != nil {
+		return nil, fmt.Errorf("failed to create last success gauge: %w", err)
+	}
+	return &i, nil
+}
+
+// RecordBackup records a finished backup run. It is a no-op unless OTLP
+// metrics are enabled.
+func (t *Telemetry) RecordBackup(ctx context.Context, r metrics.BackupResult) {
+	if t == nil || t.instruments == nil {
+		return
+	}
+	if r.FinishedAt.IsZero() {
+		r.FinishedAt = time.Now()
+	}
+	status := metrics.StatusFailure
+	if r.Success {
+		status = metrics.StatusSuccess
+	}
+	db := attribute.NewSet(
+		attribute.String("db.name", r.Database),
+		attribute.String("db.system", r.Type),
+	)
+	withStatus := metric.WithAttributes(append(db.ToSlice(), attribute.String("status", status))...)
+
+	t.instruments.backups.Add(ctx, 1, withStatus)
+	t.instruments.duration.Record(ctx, r.Duration.Seconds(), withStatus)
+	if r.Success {
+		t.instruments.size.Record(ctx, r.Size, metric.WithAttributeSet(db))
+		t.instruments.lastSuccess.Record(ctx, r.FinishedAt.Unix(), metric.WithAttributeSet(db))
+	}
+}
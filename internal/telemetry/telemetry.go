@@ -0,0 +1,252 @@
+// Package telemetry exports traces, metrics and logs over OTLP. Every
+// signal carries the same resource attributes (service, environment and,
+// for CLI runs, the database being backed up) so they can be joined in the
+// observability backend.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies db-backup as the source of spans,
+// metrics and logs
+const instrumentationName = "github.com/sanskarpan/db-backup"
+
+// Telemetry owns the OpenTelemetry providers. A nil *Telemetry is valid and
+// does nothing, so callers need no checks when telemetry is disabled.
+type Telemetry struct {
+	tracerProvider *sdktrace.TracerProvider
+	meterProvider  *sdkmetric.MeterProvider
+	loggerProvider *sdklog.LoggerProvider
+	instruments    *instruments
+	logLevel       string
+}
+
+// Setup builds the providers enabled in cfg and installs them as the
+// global OpenTelemetry providers. extra attributes are added to the
+// resource, e.g. the database of a one-shot CLI run. It returns nil when
+// no signal is enabled.
+func Setup(ctx context.Context, cfg config.TracingConfig, version string, extra ...attribute.KeyValue) (*Telemetry, error) {
+	tracesOn := cfg.Enabled
+	if !tracesOn && !cfg.OTLP.Metrics.Enabled && !cfg.OTLP.Logs.Enabled {
+		return nil, nil
+	}
+	if tracesOn && cfg.Provider != "" && cfg.Provider != "otlp" {
+		return nil, fmt.Errorf("tracing provider %q is not supported, use otlp (Jaeger and Zipkin both accept OTLP)", cfg.Provider)
+	}
+
+	res, err := newResource(ctx, cfg, version, extra)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Telemetry{logLevel: cfg.OTLP.Logs.Level}
+	if tracesOn {
+		exp, err := otlptracegrpc.New(ctx, traceOptions(cfg.OTLP)...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		var batch []sdktrace.BatchSpanProcessorOption
+		if cfg.BatchTimeout > 0 {
+			batch = append(batch, sdktrace.WithBatchTimeout(cfg.BatchTimeout))
+		}
+		if cfg.MaxQueueSize > 0 {
+			batch = append(batch, sdktrace.WithMaxQueueSize(cfg.MaxQueueSize))
+		}
+		t.tracerProvider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exp, batch...),
+			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sampler(cfg.Sampling)),
+		)
+		otel.SetTracerProvider(t.tracerProvider)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{}, propagation.Baggage{},
+		))
+	}
+
+	if cfg.OTLP.Metrics.Enabled {
+		exp, err := otlpmetricgrpc.New(ctx, metricOptions(cfg.OTLP)...)
+		if err != nil {
+			_ = t.Shutdown(ctx)
+			return nil, fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+		}
+		var reader []sdkmetric.PeriodicReaderOption
+		if cfg.OTLP.Metrics.Interval > 0 {
+			reader = append(reader, sdkmetric.WithInterval(cfg.OTLP.Metrics.Interval))
+		}
+		t.meterProvider = sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp, reader...)),
+			sdkmetric.WithResource(res),
+		)
+		otel.SetMeterProvider(t.meterProvider)
+		if t.instruments, err = newInstruments(t.meterProvider.Meter(instrumentationName)); err != nil {
+			_ = t.Shutdown(ctx)
+			return nil, err
+		}
+	}
+
+	if cfg.OTLP.Logs.Enabled {
+		exp, err := otlploggrpc.New(ctx, logOptions(cfg.OTLP)...)
+		if err != nil {
+			_ = t.Shutdown(ctx)
+			return nil, fmt.Errorf("failed to create OTLP log exporter: %w", err)
+		}
+		t.loggerProvider = sdklog.NewLoggerProvider(
+			sdklog.WithProcessor(sdklog.NewBatchProcessor(exp)),
+			sdklog.WithResource(res),
+		)
+	}
+
+	return t, nil
+}
+
+// Tracer returns a tracer for db-backup spans
+func (t *Telemetry) Tracer() trace.Tracer {
+	if t == nil || t.tracerProvider == nil {
+		return otel.GetTracerProvider().Tracer(instrumentationName)
+	}
+	return t.tracerProvider.Tracer(instrumentationName)
+}
+
+// LogWriter returns a writer that exports zerolog JSON records over OTLP,
+// or nil when log export is disabled
+func (t *Telemetry) LogWriter() io.Writer {
+	if t == nil || t.loggerProvider == nil {
+		return nil
+	}
+	return newLogWriter(t.loggerProvider.Logger(instrumentationName), t.logLevel)
+}
+
+// Shutdown flushes and stops every provider. CLI runs must call it before
+// exiting or buffered telemetry is lost.
+func (t *Telemetry) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	var errs []error
+	if t.tracerProvider != nil {
+		errs = append(errs, t.tracerProvider.Shutdown(ctx))
+	}
+	if t.meterProvider != nil {
+		errs = append(errs, t.meterProvider.Shutdown(ctx))
+	}
+	if t.loggerProvider != nil {
+		errs = append(errs, t.loggerProvider.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// newResource describes this process. Attributes from config override the
+// detected defaults.
+func newResource(ctx context.Context, cfg config.TracingConfig, version string, extra []attribute.KeyValue) (*resource.Resource, error) {
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "db-backup"
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", serviceName),
+		attribute.String("service.version", version),
+	}
+	if cfg.Environment != "" {
+		attrs = append(attrs, attribute.String("deployment.environment", cfg.Environment))
+	}
+	attrs = append(attrs, extra...)
+	for k, v := range cfg.ResourceAttributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithProcessPID(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attrs...),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return nil, fmt.Errorf("failed to build telemetry resource: %w", err)
+	}
+	return res, nil
+}
+
+// sampler maps the sampling config onto a parent-based sampler so a
+// sampling decision made upstream is respected
+func sampler(cfg config.SamplingConfig) sdktrace.Sampler {
+	switch cfg.Type {
+	case "never":
+		return sdktrace.NeverSample()
+	case "probability":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Rate))
+	default:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+}
+
+// hasScheme reports whether the endpoint is a URL rather than host:port
+func hasScheme(endpoint string) bool {
+	return strings.Contains(endpoint, "://")
+}
+
+func traceOptions(cfg config.OTLPConfig) []otlptracegrpc.Option {
+	var opts []otlptracegrpc.Option
+	if hasScheme(cfg.Endpoint) {
+		opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+	} else {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(cfg.Headers))
+	}
+	return opts
+}
+
+func metricOptions(cfg config.OTLPConfig) []otlpmetricgrpc.Option {
+	var opts []otlpmetricgrpc.Option
+	if hasScheme(cfg.Endpoint) {
+		opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint))
+	} else {
+		opts = append(opts, otlpmetricgrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlpmetricgrpc.WithHeaders(cfg.Headers))
+	}
+	return opts
+}
+
+func logOptions(cfg config.OTLPConfig) []otlploggrpc.Option {
+	var opts []otlploggrpc.Option
+	if hasScheme(cfg.Endpoint) {
+		opts = append(opts, otlploggrpc.WithEndpointURL(cfg.Endpoint))
+	} else {
+		opts = append(opts, otlploggrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlploggrpc.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlploggrpc.WithHeaders(cfg.Headers))
+	}
+	return opts
+}
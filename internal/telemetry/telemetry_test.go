@@ -0,0 +1,196 @@
+package telemetry
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/config"
+	"github.com/sanskarpan/db-backup/internal/metrics"
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// collector is an OTLP gRPC endpoint that records what it receives
+type collector struct {
+	coltrace.UnimplementedTraceServiceServer
+	colmetrics.UnimplementedMetricsServiceServer
+	collogs.UnimplementedLogsServiceServer
+
+	mu      sync.Mutex
+	spans   []string
+	metrics []string
+	logs    []string
+	headers []string
+}
+
+func (c *collector) header(ctx context.Context) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.headers = append(c.headers, md.Get("x-api-key")...)
+}
+
+func (c *collector) Export(ctx context.Context, req *coltrace.ExportTraceServiceRequest) (*coltrace.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(ctx)
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				c.spans = append(c.spans, s.Name)
+			}
+		}
+	}
+	return &coltrace.ExportTraceServiceResponse{}, nil
+}
+
+// metricsService and logsService adapt the collector to the services
+// whose Export methods clash with the trace service's
+type metricsService struct{ *collector }
+
+func (m metricsService) Export(ctx context.Context, req *colmetrics.ExportMetricsServiceRequest) (*colmetrics.ExportMetricsServiceResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.header(ctx)
+	for _, rm := range req.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, metric := range sm.Metrics {
+				m.metrics = append(m.metrics, metric.Name)
+			}
+		}
+	}
+	return &colmetrics.ExportMetricsServiceResponse{}, nil
+}
+
+type logsService struct{ *collector }
+
+func (l logsService) Export(ctx context.Context, req *collogs.ExportLogsServiceRequest) (*collogs.ExportLogsServiceResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.header(ctx)
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			for _, r := range sl.LogRecords {
+				l.logs = append(l.logs, r.Body.GetStringValue())
+			}
+		}
+	}
+	return &collogs.ExportLogsServiceResponse{}, nil
+}
+
+// startCollector serves the OTLP services on a local port
+func startCollector(t *testing.T) (*collector, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &collector{}
+	srv := grpc.NewServer()
+	coltrace.RegisterTraceServiceServer(srv, c)
+	colmetrics.RegisterMetricsServiceServer(srv, metricsService{c})
+	collogs.RegisterLogsServiceServer(srv, logsService{c})
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	return c, ln.Addr().String()
+}
+
+func TestSetupSignals(t *testing.T) {
+	_, addr := startCollector(t)
+	otlp := config.OTLPConfig{Endpoint: addr, Insecure: true}
+	tests := []struct {
+		name                  string
+		cfg                   config.TracingConfig
+		traces, metrics, logs bool
+	}{
+		{"traces", config.TracingConfig{Enabled: true, OTLP: otlp}, true, false, false},
+		{"metrics only", config.TracingConfig{OTLP: withSignals(otlp, true, false)}, false, true, false},
+		{"logs only", config.TracingConfig{OTLP: withSignals(otlp, false, true)}, false, false, true},
+		{"everything", config.TracingConfig{Enabled: true, Provider: "otlp", OTLP: withSignals(otlp, true, true)}, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tel, err := Setup(context.Background(), tt.cfg, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tel.Shutdown(context.Background())
+			if got := tel.tracerProvider != nil; got != tt.traces {
+				t.Errorf("trace exporter %v, want %v", got, tt.traces)
+			}
+			if got := tel.meterProvider != nil && tel.instruments != nil; got != tt.metrics {
+				t.Errorf("metric exporter %v, want %v", got, tt.metrics)
+			}
+			if got := tel.LogWriter() != nil; got != tt.logs {
+				t.Errorf("log exporter %v, want %v", got, tt.logs)
+			}
+		})
+	}
+}
+
+func withSignals(cfg config.OTLPConfig, metrics, logs bool) config.OTLPConfig {
+	cfg.Metrics.Enabled = metrics
+	cfg.Logs.Enabled = logs
+	return cfg
+}
+
+func TestSetupDisabled(t *testing.T) {
+	tel, err := Setup(context.Background(), config.TracingConfig{}, "test")
+	if tel != nil || err != nil {
+		t.Fatalf("Setup = %v, %v with nothing enabled", tel, err)
+	}
+	// A nil Telemetry does nothing
+	tel.RecordBackup(context.Background(), metrics.BackupResult{})
+	if tel.LogWriter() != nil || tel.Shutdown(context.Background()) != nil || tel.Tracer() == nil {
+		t.Error("nil telemetry is not a no-op")
+	}
+
+	_, err = Setup(context.Background(), config.TracingConfig{Enabled: true, Provider: "jaeger"}, "test")
+	if err == nil || !strings.Contains(err.Error(), `"jaeger" is not supported`) {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestShutdownFlushes(t *testing.T) {
+	c, addr := startCollector(t)
+	otlp := withSignals(config.OTLPConfig{
+		Endpoint: "http://" + addr, Insecure: true, Headers: map[string]string{"x-api-key": "k"},
+	}, true, true)
+	// Long intervals: nothing is exported before Shutdown
+	otlp.Metrics.Interval = time.Hour
+	tel, err := Setup(context.Background(), config.TracingConfig{Enabled: true, BatchTimeout: time.Hour, OTLP: otlp}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := tel.Tracer().Start(context.Background(), "backup orders")
+	span.End()
+	tel.RecordBackup(context.Background(), metrics.BackupResult{Database: "orders", Type: "postgres", Success: true, Size: 1})
+	tel.LogWriter().Write([]byte(`{"level":"info","message":"backup completed"}`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tel.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.spans) != 1 || c.spans[0] != "backup orders" {
+		t.Errorf("spans %v", c.spans)
+	}
+	if !strings.Contains(strings.Join(c.metrics, ","), "dbbackup.backups") {
+		t.Errorf("metrics %v", c.metrics)
+	}
+	if len(c.logs) != 1 || c.logs[0] != "backup completed" {
+		t.Errorf("logs %v", c.logs)
+	}
+	if len(c.headers) != 3 {
+		t.Errorf("headers sent with %d of 3 exports", len(c.headers))
+	}
+}
@@ -0,0 +1,214 @@
+// Package vss copies files that are open for writing, such as SQLite
+// databases, from a Volume Shadow Copy on Windows. A shadow copy freezes
+// the whole volume at one instant, so a database and its journal are read
+// as they were together rather than while the application changes them.
+//
+// Shadow copies are created and removed through the Win32_ShadowCopy WMI
+// class, which requires an elevated process.
+package vss
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrUnsupported is returned on systems without Volume Shadow Copy
+var ErrUnsupported = errors.New("volume shadow copies are only available on Windows")
+
+// Modes of backup.vss
+const (
+	ModeAuto   = "auto"   // use a shadow copy when possible, else read the live file
+	ModeAlways = "always" // fail when no shadow copy can be made
+	ModeNever  = "never"
+)
+
+// Shadow is a shadow copy of a volume
+type Shadow struct {
+	ID           string // {GUID} of the shadow copy
+	Volume       string // volume root it was taken of, e.g. C:\
+	DeviceObject string // \\?\GLOBALROOT\Device\HarddiskVolumeShadowCopyN
+}
+
+// Path returns where file, an absolute path on the shadow's volume, is
+// found in the shadow copy
+func (s *Shadow) Path(file string) (string, error) {
+	vol, rest, err := splitVolume(file)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(vol, s.Volume) {
+		return "", fmt.Errorf("%s is not on volume %s", file, s.Volume)
+	}
+	return s.DeviceObject + `\` + rest, nil
+}
+
+// drivePath matches absolute paths on a drive letter
+var drivePath = regexp.MustCompile(`^([A-Za-z]):[\\/](.*)$`)
+
+// splitVolume splits an absolute drive path into its volume root and the
+// path below it. Network shares cannot be shadowed from the client.
+func splitVolume(file string) (string, string, error) {
+	m := drivePath.FindStringSubmatch(file)
+	if m == nil {
+		return "", "", fmt.Errorf("%s is not an absolute path on a local drive", file)
+	}
+	return strings.ToUpper(m[1]) + `:\`, strings.ReplaceAll(m[2], "/", `\`), nil
+}
+
+// runner runs a PowerShell script and returns its output
+type runner func(ctx context.Context, script string) ([]byte, error)
+
+// Client creates and deletes shadow copies
+type Client struct {
+	run runner
+}
+
+// NewClient returns a client using PowerShell's CIM cmdlets
+func NewClient() *Client {
+	return &Client{run: powershell}
+}
+
+// Available reports whether shadow copies can be made on this system
+func Available() bool { return supported }
+
+// createReturnCodes explains the failures of Win32_ShadowCopy.Create
+var createReturnCodes = map[int]string{
+	1:  "access denied, run as administrator",
+	2:  "invalid argument",
+	3:  "volume not found",
+	4:  "volume not supported",
+	5:  "unsupported shadow copy context",
+	6:  "insufficient storage",
+	7:  "volume is in use",
+	8:  "maximum number of shadow copies reached",
+	9:  "another shadow copy operation is in progress",
+	10: "shadow copy provider vetoed the operation",
+	11: "shadow copy provider not registered",
+	12: "shadow copy provider failure",
+}
+
+// shadowID matches the {GUID} of a shadow copy
+var shadowID = regexp.MustCompile(`^\{[0-9A-Fa-f]{8}(-[0-9A-Fa-f]{4}){3}-[0-9A-Fa-f]{12}\}$`)
+
+// Create takes a shadow copy of volume, a drive root such as C:\. It must
+// be deleted once read.
+func (c *Client) Create(ctx context.Context, volume string) (*Shadow, error) {
+	vol, rest, err := splitVolume(volume)
+	if err != nil || rest != "" {
+		return nil, fmt.Errorf("invalid volume %q: use a drive root such as C:\\", volume)
+	}
+	// The volume is validated above, so it is safe to quote
+	script := `$ErrorActionPreference = 'Stop'
+$r = Invoke-CimMethod -ClassName Win32_ShadowCopy -MethodName Create -Arguments @{Volume='` + vol + `'; Context='ClientAccessible'}
+$s = $null
+if ($r.ReturnValue -eq 0) { $s = Get-CimInstance -ClassName Win32_ShadowCopy -Filter "ID='$($r.ShadowID)'" }
+@{ReturnValue=[int]$r.ReturnValue; ID=$s.ID; DeviceObject=$s.DeviceObject} | ConvertTo-Json -Compress`
+	out, err := c.run(ctx, script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shadow copy of %s: %w", vol, err)
+	}
+	var res struct {
+		ReturnValue  int
+		ID           string
+		DeviceObject string
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, fmt.Errorf("unexpected output creating shadow copy of %s: %q", vol, strings.TrimSpace(string(out)))
+	}
+	if res.ReturnValue != 0 {
+		reason, ok := createReturnCodes[res.ReturnValue]
+		if !ok {
+			reason = "unknown error"
+		}
+		return nil, fmt.Errorf("failed to create shadow copy of %s: %s (code %d)", vol, reason, res.ReturnValue)
+	}
+	if !shadowID.MatchString(res.ID) || res.DeviceObject == "" {
+		return nil, fmt.Errorf("shadow copy of %s not found after it was created", vol)
+	}
+	return &Shadow{ID: res.ID, Volume: vol, DeviceObject: res.DeviceObject}, nil
+}
+
+// Delete removes a shadow copy
+func (c *Client) Delete(ctx context.Context, s *Shadow) error {
+	if !shadowID.MatchString(s.ID) {
+		return fmt.Errorf("invalid shadow copy ID %q", s.ID)
+	}
+	script := `$ErrorActionPreference = 'Stop'
+Get-CimInstance -ClassName Win32_ShadowCopy -Filter "ID='` + s.ID + `'" | Remove-CimInstance`
+	if _, err := c.run(ctx, script); err != nil {
+		return fmt.Errorf("failed to delete shadow copy %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+// SQLiteFiles returns a SQLite database and the journals that must be
+// copied with it for the copy to hold every committed transaction
+func SQLiteFiles(db string) []string {
+	return []string{db, db + "-wal", db + "-journal"}
+}
+
+// Copy copies files from one shadow copy of their volume into dir and
+// returns the paths of the copies, in order. Files other than the first
+// that do not exist are skipped, with an empty path. All files must be on
+// the same volume.
+func (c *Client) Copy(ctx context.Context, dir string, files ...string) (copies []string, err error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	vol, _, err := splitVolume(files[0])
+	if err != nil {
+		return nil, err
+	}
+	shadow, err := c.Create(ctx, vol)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// The shadow copy holds space on the volume: remove it even when
+		// the backup was cancelled
+		if derr := c.Delete(context.WithoutCancel(ctx), shadow); derr != nil && err == nil {
+			err = derr
+		}
+	}()
+
+	copies = make([]string, len(files))
+	for i, file := range files {
+		src, err := shadow.Path(file)
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(dir, filepath.Base(file))
+		if err := copyFile(src, dst); err != nil {
+			if i > 0 && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to copy %s from shadow copy: %w", file, err)
+		}
+		copies[i] = dst
+	}
+	return copies, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src) // #nosec G304 -- path inside the shadow copy of a configured file
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600) // #nosec G304 -- in the temp directory
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
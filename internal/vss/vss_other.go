@@ -0,0 +1,11 @@
+//go:build !windows
+
+package vss
+
+import "context"
+
+const supported = false
+
+func powershell(context.Context, string) ([]byte, error) {
+	return nil, ErrUnsupported
+}
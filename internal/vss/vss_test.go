@@ -0,0 +1,115 @@
+package vss
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testID = "{0C2E2F32-6A4B-4C2B-9E0A-3D1A5C7B9F11}"
+
+// fakeRunner answers the create and delete scripts
+type fakeRunner struct {
+	scripts []string
+	create  string // JSON output of the create script
+}
+
+func (f *fakeRunner) run(_ context.Context, script string) ([]byte, error) {
+	f.scripts = append(f.scripts, script)
+	if strings.Contains(script, "-MethodName Create") {
+		return []byte(f.create), nil
+	}
+	return nil, nil
+}
+
+func TestShadowPath(t *testing.T) {
+	s := &Shadow{ID: testID, Volume: `C:\`, DeviceObject: `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy4`}
+	got, err := s.Path(`c:\ProgramData\app/data.db`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy4\ProgramData\app\data.db`; got != want {
+		t.Errorf("Path() = %s, want %s", got, want)
+	}
+	if _, err := s.Path(`D:\data.db`); err == nil {
+		t.Error("path on another volume accepted")
+	}
+	if _, err := s.Path(`\\fileserver\share\data.db`); err == nil {
+		t.Error("network share accepted")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	f := &fakeRunner{create: `{"ReturnValue":0,"ID":"` + testID + `","DeviceObject":"\\\\?\\GLOBALROOT\\Device\\HarddiskVolumeShadowCopy7"}`}
+	c := &Client{run: f.run}
+	s, err := c.Create(context.Background(), `d:\`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID != testID || s.Volume != `D:\` || s.DeviceObject != `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy7` {
+		t.Errorf("shadow = %+v", s)
+	}
+	if !strings.Contains(f.scripts[0], `Volume='D:\'`) {
+		t.Errorf("script = %s", f.scripts[0])
+	}
+
+	if err := c.Delete(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(f.scripts[1], "ID='"+testID+"'") || !strings.Contains(f.scripts[1], "Remove-CimInstance") {
+		t.Errorf("delete script = %s", f.scripts[1])
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	f := &fakeRunner{create: `{"ReturnValue":1,"ID":null,"DeviceObject":null}`}
+	c := &Client{run: f.run}
+	if _, err := c.Create(context.Background(), `C:\`); err == nil || !strings.Contains(err.Error(), "run as administrator") {
+		t.Errorf("Create() = %v, want access denied", err)
+	}
+
+	// Nothing that could be injected into the scripts is accepted
+	for _, volume := range []string{`C:\Windows`, `C:\'; Remove-Item x; '`, `\\server\share\`} {
+		if _, err := c.Create(context.Background(), volume); err == nil {
+			t.Errorf("Create(%q) accepted", volume)
+		}
+	}
+	if err := c.Delete(context.Background(), &Shadow{ID: "x' or 1=1"}); err == nil {
+		t.Error("invalid shadow ID accepted")
+	}
+	if len(f.scripts) != 1 {
+		t.Errorf("%d scripts run, want 1", len(f.scripts))
+	}
+}
+
+func TestCopy(t *testing.T) {
+	// A directory stands in for the shadow's device object. Paths in it are
+	// joined with backslashes, which are plain characters off Windows.
+	device := filepath.Join(t.TempDir(), "shadow")
+	os.MkdirAll(device+`\app`, 0700)
+	os.WriteFile(device+`\app\data.db`, []byte("SQLite format 3"), 0600)
+	os.WriteFile(device+`\app\data.db-wal`, []byte("wal"), 0600)
+
+	f := &fakeRunner{create: `{"ReturnValue":0,"ID":"` + testID + `","DeviceObject":` + jsonString(device) + `}`}
+	c := &Client{run: f.run}
+	dir := t.TempDir()
+	copies, err := c.Copy(context.Background(), dir, SQLiteFiles(`C:/app/data.db`)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copies[0] != filepath.Join(dir, "data.db") || copies[1] != filepath.Join(dir, "data.db-wal") || copies[2] != "" {
+		t.Errorf("copies = %q", copies)
+	}
+	if data, _ := os.ReadFile(copies[0]); string(data) != "SQLite format 3" {
+		t.Errorf("copy = %q", data)
+	}
+	if len(f.scripts) != 2 {
+		t.Errorf("shadow copy not deleted: %d scripts run", len(f.scripts))
+	}
+}
+
+func jsonString(s string) string {
+	return `"` + strings.ReplaceAll(s, `\`, `\\`) + `"`
+}
@@ -0,0 +1,59 @@
+// Package winservice installs db-backup as a Windows service and runs it
+// under the service control manager, the Windows counterpart of the
+// systemd units `db-backup schedule export` writes. Long-running commands
+// such as `agent` or `disk watch` are run this way; stopping the service
+// cancels their context like SIGTERM does elsewhere.
+package winservice
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// ErrUnsupported is returned on systems without a service control manager
+var ErrUnsupported = errors.New("windows services are only available on Windows")
+
+// Start types of a service
+const (
+	StartAuto    = "auto"    // at boot
+	StartDelayed = "delayed" // shortly after boot, once other auto services are up
+	StartManual  = "manual"
+)
+
+// StopTimeout bounds how long a stopping service waits for its command to
+// return before reporting itself stopped
+const StopTimeout = 30 * time.Second
+
+// serviceName restricts names to what sc.exe and the registry accept
+// without quoting
+var serviceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,79}$`)
+
+// Config describes a service to install
+type Config struct {
+	Name        string
+	DisplayName string
+	Description string
+	Executable  string   // absolute path of db-backup.exe
+	Args        []string // arguments the service manager starts it with
+	StartType   string   // auto, delayed or manual
+	Account     string   // e.g. NT AUTHORITY\NetworkService; LocalSystem when empty
+	Password    string   // for accounts other than the built-in ones
+}
+
+// Validate checks that a service can be installed from c
+func (c *Config) Validate() error {
+	if !serviceName.MatchString(c.Name) {
+		return fmt.Errorf("invalid service name %q: letters, digits, '.', '_' and '-' only", c.Name)
+	}
+	if c.Executable == "" {
+		return errors.New("no executable to run")
+	}
+	switch c.StartType {
+	case StartAuto, StartDelayed, StartManual:
+	default:
+		return fmt.Errorf("invalid start type %q (must be auto|delayed|manual)", c.StartType)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package winservice
+
+import "context"
+
+// IsService reports whether the process was started by the service
+// control manager, never outside Windows
+func IsService() (bool, error) { return false, nil }
+
+// Install registers a service
+func Install(Config) error { return ErrUnsupported }
+
+// Remove unregisters a service
+func Remove(context.Context, string) error { return ErrUnsupported }
+
+// Start starts an installed service
+func Start(string) error { return ErrUnsupported }
+
+// Stop stops a service
+func Stop(context.Context, string) error { return ErrUnsupported }
+
+// Status returns the state of a service
+func Status(string) (string, error) { return "", ErrUnsupported }
+
+// Run runs fn as a service
+func Run(string, func(ctx context.Context) error) error { return ErrUnsupported }
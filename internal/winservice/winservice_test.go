@@ -0,0 +1,25 @@
+package winservice
+
+import "testing"
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Name: "db-backup-agent", Executable: `C:\Program Files\db-backup\db-backup.exe`, StartType: StartAuto}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(c *Config){
+		"empty name":      func(c *Config) { c.Name = "" },
+		"name with space": func(c *Config) { c.Name = "db backup" },
+		"name with slash": func(c *Config) { c.Name = `db\backup` },
+		"no executable":   func(c *Config) { c.Executable = "" },
+		"bad start type":  func(c *Config) { c.StartType = "boot" },
+	}
+	for name, mutate := range tests {
+		c := valid
+		mutate(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
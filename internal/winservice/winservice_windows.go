@@ -0,0 +1,196 @@
+//go:build windows
+
+package winservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// IsService reports whether the process was started by the service
+// control manager
+func IsService() (bool, error) {
+	return svc.IsWindowsService()
+}
+
+// Install registers a service. It restarts a minute after failing, like
+// Restart=on-failure in the exported systemd units.
+func Install(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(c.Name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", c.Name)
+	}
+	cfg := mgr.Config{
+		DisplayName:      c.DisplayName,
+		Description:      c.Description,
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: c.StartType == StartDelayed,
+		ServiceStartName: c.Account,
+		Password:         c.Password,
+	}
+	if c.StartType == StartManual {
+		cfg.StartType = mgr.StartManual
+	}
+	s, err := m.CreateService(c.Name, c.Executable, cfg, c.Args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service %s: %w", c.Name, err)
+	}
+	defer s.Close()
+
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: time.Minute}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, uint32((24 * time.Hour).Seconds())); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to set recovery actions of %s: %w", c.Name, err)
+	}
+	// Also restart when the command returns an error rather than crashing
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to set recovery actions of %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+// Remove unregisters a service, stopping it first if it runs
+func Remove(ctx context.Context, name string) error {
+	if err := Stop(ctx, name); err != nil && !errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		return err
+	}
+	return withService(name, func(s *mgr.Service) error {
+		return s.Delete()
+	})
+}
+
+// Start starts an installed service
+func Start(name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		return s.Start()
+	})
+}
+
+// Stop stops a service and waits until it has stopped or ctx is done
+func Stop(ctx context.Context, name string) error {
+	return withService(name, func(s *mgr.Service) error {
+		status, err := s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		for status.State != svc.Stopped {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("service %s did not stop: %w", name, ctx.Err())
+			case <-time.After(500 * time.Millisecond):
+			}
+			if status, err = s.Query(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status returns the state of a service: running, stopped, ...
+func Status(name string) (string, error) {
+	var state string
+	err := withService(name, func(s *mgr.Service) error {
+		status, err := s.Query()
+		if err != nil {
+			return err
+		}
+		state = stateNames[status.State]
+		return nil
+	})
+	return state, err
+}
+
+var stateNames = map[svc.State]string{
+	svc.Stopped:         "stopped",
+	svc.StartPending:    "starting",
+	svc.StopPending:     "stopping",
+	svc.Running:         "running",
+	svc.ContinuePending: "resuming",
+	svc.PausePending:    "pausing",
+	svc.Paused:          "paused",
+}
+
+func withService(name string, fn func(*mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	defer s.Close()
+	if err := fn(s); err != nil {
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	return nil
+}
+
+// Run runs fn as the service name until it returns or the service is
+// stopped, which cancels its context. It must be called from a process
+// started by the service control manager.
+func Run(name string, fn func(ctx context.Context) error) error {
+	h := &handler{run: fn}
+	if err := svc.Run(name, h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+// handler reports the state of fn to the service control manager
+type handler struct {
+	run func(ctx context.Context) error
+	err error
+}
+
+func (h *handler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- h.run(ctx) }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case h.err = <-done:
+			if h.err != nil {
+				// A service-specific exit code triggers the recovery actions
+				return true, 1
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(StopTimeout.Milliseconds())}
+				cancel()
+				select {
+				case h.err = <-done:
+				case <-time.After(StopTimeout):
+					h.err = fmt.Errorf("command did not return within %s of the stop request", StopTimeout)
+				}
+				return false, 0
+			}
+		}
+	}
+}
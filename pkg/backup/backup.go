@@ -0,0 +1,385 @@
+// Package backup embeds db-backup in other Go programs. It takes backups,
+// restores and verifies them without the CLI, its configuration file or
+// its metadata repository: everything is passed in as a Database and
+// functional options.
+//
+//	db := backup.Database{Type: "postgres", Host: "localhost", Username: "app", Password: pw, Name: "orders"}
+//	res, err := backup.CreateBackup(ctx, db, backup.WithCompression("zstd", 3), backup.WithTempDir("/var/backups"))
+//	...
+//	err = backup.Restore(ctx, res.Path, db, backup.WithChecksum(res.Checksum), backup.WithDropExisting())
+//
+// Every built-in database driver is registered by importing the package;
+// LoadPlugins adds the drivers of plugins.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sanskarpan/db-backup/internal/backup/pipeline"
+	"github.com/sanskarpan/db-backup/internal/backup/runner"
+	"github.com/sanskarpan/db-backup/internal/database"
+	_ "github.com/sanskarpan/db-backup/internal/database/clickhouse"
+	_ "github.com/sanskarpan/db-backup/internal/database/cockroachdb"
+	_ "github.com/sanskarpan/db-backup/internal/database/couchbase"
+	_ "github.com/sanskarpan/db-backup/internal/database/dynamodb"
+	_ "github.com/sanskarpan/db-backup/internal/database/etcd"
+	_ "github.com/sanskarpan/db-backup/internal/database/filedb"
+	_ "github.com/sanskarpan/db-backup/internal/database/influxdb"
+	_ "github.com/sanskarpan/db-backup/internal/database/mariadb"
+	_ "github.com/sanskarpan/db-backup/internal/database/mongodb"
+	_ "github.com/sanskarpan/db-backup/internal/database/mysql"
+	_ "github.com/sanskarpan/db-backup/internal/database/neo4j"
+	_ "github.com/sanskarpan/db-backup/internal/database/oracle"
+	"github.com/sanskarpan/db-backup/internal/database/plugin"
+	_ "github.com/sanskarpan/db-backup/internal/database/postgres"
+	_ "github.com/sanskarpan/db-backup/internal/database/redis"
+	_ "github.com/sanskarpan/db-backup/internal/database/sqlite"
+	_ "github.com/sanskarpan/db-backup/internal/database/tidb"
+	"github.com/sanskarpan/db-backup/pkg/stream"
+)
+
+// Database is the database to back up or restore into
+type Database struct {
+	Type         string // mysql, postgres, mongodb, sqlite, redis, clickhouse, cockroachdb, mariadb, etcd, influxdb, neo4j, oracle, duckdb, couchbase, tidb, dynamodb or a plugin's type
+	Host         string
+	Port         int // default port of the type when 0
+	Username     string
+	Password     string
+	Name         string // database name, or the file of a SQLite database
+	Databases    []string
+	AllDatabases bool
+	SSLMode      string            // used by Restore
+	Options      map[string]string // driver options of Restore, e.g. dump_method for Redis
+}
+
+// Progress reports a backup's progress
+type Progress struct {
+	Stage      string
+	Percentage float64
+	Message    string
+}
+
+// Result describes a backup taken by CreateBackup
+type Result struct {
+	ID             string
+	Name           string
+	Database       string
+	DatabaseType   string
+	Path           string // the artifact, to pass to Restore and Verify
+	Size           int64
+	CompressedSize int64
+	Tables         int
+	Checksum       string // hex SHA-256 of the artifact
+	StartTime      time.Time
+	Duration       time.Duration
+}
+
+// Option configures CreateBackup, Restore or Verify. Options that do not
+// apply to an operation are ignored by it.
+type Option func(*options)
+
+type options struct {
+	compression      string
+	compressionLevel int
+	encryptionKey    string
+	tempDir          string
+	parallel         int
+	tables           []string
+	excludeTables    []string
+	name             string
+	tags             map[string]string
+	progress         func(Progress)
+	dropExisting     bool
+	checksum         string
+	targetDatabase   string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		compression: "gzip",
+		tempDir:     os.TempDir(),
+		parallel:    1,
+		tags:        map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCompression compresses backups with codec (gzip, zstd, lz4 or none)
+// at level, or the codec's default level when level is 0. Backups are
+// compressed with gzip by default.
+func WithCompression(codec string, level int) Option {
+	return func(o *options) {
+		o.compression = strings.ToLower(codec)
+		o.compressionLevel = level
+	}
+}
+
+// WithEncryptionKey encrypts backups with key, the path of a file holding
+// it or the key itself as base64:<key> or hex:<key>, and decrypts the
+// artifacts restored with it
+func WithEncryptionKey(key string) Option {
+	return func(o *options) { o.encryptionKey = key }
+}
+
+// WithTempDir writes backups and temporary files under dir instead of the
+// system temporary directory
+func WithTempDir(dir string) Option {
+	return func(o *options) { o.tempDir = dir }
+}
+
+// WithParallelism runs up to n operations at once
+func WithParallelism(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.parallel = n
+		}
+	}
+}
+
+// WithTables limits a backup or restore to tables, leaving out exclude
+func WithTables(tables, exclude []string) Option {
+	return func(o *options) {
+		o.tables = tables
+		o.excludeTables = exclude
+	}
+}
+
+// WithName names a backup
+func WithName(name string) Option {
+	return func(o *options) { o.name = name }
+}
+
+// WithTags adds tags to a backup
+func WithTags(tags map[string]string) Option {
+	return func(o *options) {
+		for k, v := range tags {
+			o.tags[k] = v
+		}
+	}
+}
+
+// WithProgress calls fn as a backup progresses
+func WithProgress(fn func(Progress)) Option {
+	return func(o *options) { o.progress = fn }
+}
+
+// WithDropExisting lets Restore replace objects that already exist in the
+// target database
+func WithDropExisting() Option {
+	return func(o *options) { o.dropExisting = true }
+}
+
+// WithChecksum makes Restore and Verify fail unless the artifact's hex
+// SHA-256 is sum, as reported in Result.Checksum
+func WithChecksum(sum string) Option {
+	return func(o *options) { o.checksum = strings.ToLower(sum) }
+}
+
+// WithTargetDatabase makes Restore restore the database db.Name of the
+// backup as name, created when missing, e.g. a backup of prod as
+// prod_verify on the same server
+func WithTargetDatabase(name string) Option {
+	return func(o *options) { o.targetDatabase = name }
+}
+
+// CreateBackup backs up db into a new artifact under the temporary
+// directory
+func CreateBackup(ctx context.Context, db Database, opts ...Option) (*Result, error) {
+	o := newOptions(opts)
+	dbType, err := databaseType(db.Type)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := compressionType(o.compression)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &runner.Job{
+		Connection: connection(db, dbType),
+		Options: database.BackupOptions{
+			Database:         db.Name,
+			Databases:        db.Databases,
+			AllDatabases:     db.AllDatabases,
+			Tables:           o.tables,
+			ExcludeTables:    o.excludeTables,
+			ConsistentBackup: true,
+			Compression:      database.CompressionNone, // compressed by the pipeline
+			Parallel:         o.parallel,
+		},
+		Name:             o.name,
+		Tags:             o.tags,
+		Compression:      string(compression),
+		CompressionLevel: o.compressionLevel,
+	}
+	if o.encryptionKey != "" {
+		if job.EncryptionKey, err = stream.ReadKey(o.encryptionKey); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := runner.New(runner.Options{
+		TempDirectory: o.tempDir,
+		OnStage: func(_ *runner.Job, stage string) {
+			if o.progress == nil {
+				return
+			}
+			done := slices.Index(pipeline.StageOrder, stage)
+			o.progress(Progress{
+				Stage:      stage,
+				Percentage: float64(done) / float64(len(pipeline.StageOrder)) * 100,
+				Message:    fmt.Sprintf("%s %s", stage, db.Name),
+			})
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.Backup(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		ID:             b.ID,
+		Name:           b.Name,
+		Database:       b.Database,
+		DatabaseType:   string(b.DatabaseType),
+		Path:           b.Path,
+		Size:           b.Size,
+		CompressedSize: b.CompressedSize,
+		Tables:         len(b.Tables),
+		Checksum:       b.Checksum,
+		StartTime:      b.StartTime,
+		Duration:       b.EndTime.Sub(b.StartTime),
+	}, nil
+}
+
+// LoadPlugins registers the driver plugins in dir, executables named
+// dbbackup-driver-<type> built with pkg/driverplugin, and returns the
+// database types they add
+func LoadPlugins(dir string) ([]string, error) {
+	found, err := plugin.Discover(dir, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, len(found))
+	for i, t := range found {
+		types[i] = string(t)
+	}
+	return types, nil
+}
+
+// databaseType parses the name of a database type
+func databaseType(name string) (database.DatabaseType, error) {
+	switch strings.ToLower(name) {
+	case "mysql":
+		return database.DatabaseTypeMySQL, nil
+	case "postgres", "postgresql":
+		return database.DatabaseTypePostgreSQL, nil
+	case "mongodb", "mongo":
+		return database.DatabaseTypeMongoDB, nil
+	case "sqlite":
+		return database.DatabaseTypeSQLite, nil
+	case "redis":
+		return database.DatabaseTypeRedis, nil
+	case "clickhouse":
+		return database.DatabaseTypeClickHouse, nil
+	case "cockroachdb", "cockroach":
+		return database.DatabaseTypeCockroachDB, nil
+	case "mariadb":
+		return database.DatabaseTypeMariaDB, nil
+	case "etcd":
+		return database.DatabaseTypeEtcd, nil
+	case "influxdb":
+		return database.DatabaseTypeInfluxDB, nil
+	case "neo4j":
+		return database.DatabaseTypeNeo4j, nil
+	case "oracle":
+		return database.DatabaseTypeOracle, nil
+	case "duckdb":
+		return database.DatabaseTypeDuckDB, nil
+	case "couchbase":
+		return database.DatabaseTypeCouchbase, nil
+	case "tidb":
+		return database.DatabaseTypeTiDB, nil
+	case "dynamodb":
+		return database.DatabaseTypeDynamoDB, nil
+	}
+	// Types served by plugins loaded with LoadPlugins
+	if database.IsRegistered(database.DatabaseType(name)) {
+		return database.DatabaseType(name), nil
+	}
+	return "", fmt.Errorf("unsupported database type: %q", name)
+}
+
+// compressionType parses the name of a codec
+func compressionType(name string) (database.CompressionType, error) {
+	switch name {
+	case "gzip", "gz":
+		return database.CompressionGzip, nil
+	case "zstd":
+		return database.CompressionZstd, nil
+	case "lz4":
+		return database.CompressionLZ4, nil
+	case "none", "":
+		return database.CompressionNone, nil
+	}
+	return "", fmt.Errorf("unsupported compression: %q", name)
+}
+
+// port returns db's port, or the default port of its type
+func port(db Database) int {
+	if db.Port != 0 {
+		return db.Port
+	}
+	switch strings.ToLower(db.Type) {
+	case "mysql", "mariadb":
+		return 3306
+	case "postgres", "postgresql":
+		return 5432
+	case "mongodb", "mongo":
+		return 27017
+	case "redis":
+		return 6379
+	case "clickhouse":
+		return 8123
+	case "cockroachdb", "cockroach":
+		return 26257
+	case "etcd":
+		return 2379
+	case "influxdb":
+		return 8086
+	case "neo4j":
+		return 7474
+	case "oracle":
+		return 1521
+	case "couchbase":
+		return 8091
+	case "tidb":
+		return 4000
+	}
+	return 0
+}
+
+// connection returns the driver configuration of db
+func connection(db Database, dbType database.DatabaseType) *database.ConnectionConfig {
+	return &database.ConnectionConfig{
+		Type:              dbType,
+		Host:              db.Host,
+		Port:              port(db),
+		Username:          db.Username,
+		Password:          db.Password,
+		Database:          db.Name,
+		SSLMode:           db.SSLMode,
+		Options:           db.Options,
+		ConnectionTimeout: 30 * time.Second,
+	}
+}
@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+func writeArtifact(t *testing.T, codec string, data []byte) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch codec {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = zw
+	case "lz4":
+		w = lz4.NewWriter(&buf)
+	default:
+		buf.Write(data)
+	}
+	if w != nil {
+		w.Write(data)
+		w.Close()
+	}
+	path := filepath.Join(t.TempDir(), "backup")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return path, hex.EncodeToString(sum[:])
+}
+
+func TestVerify(t *testing.T) {
+	data := bytes.Repeat([]byte("INSERT INTO orders VALUES (1);\n"), 1000)
+	for _, codec := range []string{"gzip", "zstd", "lz4", "none"} {
+		t.Run(codec, func(t *testing.T) {
+			path, sum := writeArtifact(t, codec, data)
+			v, err := Verify(context.Background(), path, WithChecksum(strings.ToUpper(sum)))
+			if err != nil {
+				t.Fatalf("Verify() = %v", err)
+			}
+			if v.Compression != codec || v.DataSize != int64(len(data)) || v.Checksum != sum {
+				t.Errorf("Verify() = %+v", v)
+			}
+		})
+	}
+}
+
+func TestVerifyFailures(t *testing.T) {
+	ctx := context.Background()
+	path, _ := writeArtifact(t, "gzip", bytes.Repeat([]byte("x"), 4096))
+	if _, err := Verify(ctx, path, WithChecksum("00")); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Verify() with the wrong checksum = %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-8], 0o600)
+	if _, err := Verify(ctx, path); err == nil {
+		t.Error("Verify() of a truncated artifact succeeded")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	o := newOptions([]Option{
+		WithCompression("ZSTD", 3),
+		WithTags(map[string]string{"env": "prod"}),
+		WithTags(map[string]string{"team": "orders"}),
+		WithParallelism(0),
+	})
+	if o.compression != "zstd" || o.compressionLevel != 3 || o.parallel != 1 || len(o.tags) != 2 {
+		t.Errorf("options = %+v", o)
+	}
+	if _, err := compressionType("brotli"); err == nil {
+		t.Error("compressionType() accepted an unknown codec")
+	}
+	if got := port(Database{Type: "postgresql"}); got != 5432 {
+		t.Errorf("port() = %d", got)
+	}
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Statuses of backups and restores
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// DefaultPollInterval is how often WaitBackup checks a backup
+const DefaultPollInterval = 5 * time.Second
+
+// BackupRequest starts a backup. Credentials never travel in requests:
+// Profile names a connection profile configured on the server.
+type BackupRequest struct {
+	Name        string            `json:"name,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Database    string            `json:"database,omitempty"`
+	Compression string            `json:"compression,omitempty"`
+	Storage     string            `json:"storage,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// Backup is a backup known to the server
+type Backup struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name,omitempty"`
+	Database    string            `json:"database,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Size        int64             `json:"size,omitempty"`
+	Checksum    string            `json:"checksum,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	StartedAt   *time.Time        `json:"started_at,omitempty"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+}
+
+// Done reports whether the backup has completed or failed
+func (b *Backup) Done() bool {
+	return b.Status == StatusCompleted || b.Status == StatusFailed
+}
+
+// ListBackupsOptions filters and pages ListBackups
+type ListBackupsOptions struct {
+	Database string
+	Status   string
+	Limit    int
+	Offset   int
+}
+
+// RestoreRequest restores a backup
+type RestoreRequest struct {
+	Profile        string `json:"profile,omitempty"`
+	TargetDatabase string `json:"target_database,omitempty"`
+}
+
+// Restore is the outcome of a restore
+type Restore struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Progress reports how much of a transfer is done. Total is -1 when the
+// server does not say.
+type Progress struct {
+	Done  int64
+	Total int64
+}
+
+// CreateBackup starts a backup
+func (c *Client) CreateBackup(ctx context.Context, req BackupRequest) (*Backup, error) {
+	var b Backup
+	if err := c.Do(ctx, http.MethodPost, "/backups", req, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetBackup returns a backup
+func (c *Client) GetBackup(ctx context.Context, id string) (*Backup, error) {
+	var b Backup
+	if err := c.Do(ctx, http.MethodGet, "/backups/"+url.PathEscape(id), nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ListBackups lists backups, newest first
+func (c *Client) ListBackups(ctx context.Context, opts ListBackupsOptions) ([]Backup, error) {
+	q := url.Values{}
+	if opts.Database != "" {
+		q.Set("database", opts.Database)
+	}
+	if opts.Status != "" {
+		q.Set("status", opts.Status)
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		q.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	path := "/backups"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var list struct {
+		Backups []Backup `json:"backups"`
+	}
+	if err := c.Do(ctx, http.MethodGet, path, nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Backups, nil
+}
+
+// DRCopy is a copy of a backup made by a disaster recovery copy job
+type DRCopy struct {
+	Job       string     `json:"job"`
+	Bucket    string     `json:"bucket"`
+	Region    string     `json:"region"`
+	Object    string     `json:"object,omitempty"`
+	Status    string     `json:"status"` // copied, failed or expired
+	Size      int64      `json:"size,omitempty"`
+	Attempts  int        `json:"attempts"`
+	CopiedAt  time.Time  `json:"copied_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// BackupDRCopies returns the disaster recovery copies of a backup
+func (c *Client) BackupDRCopies(ctx context.Context, id string) ([]DRCopy, error) {
+	var r struct {
+		Copies []DRCopy `json:"copies"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/backups/"+url.PathEscape(id)+"/dr", nil, &r); err != nil {
+		return nil, err
+	}
+	return r.Copies, nil
+}
+
+// DeleteBackup deletes a backup
+func (c *Client) DeleteBackup(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/backups/"+url.PathEscape(id), nil, nil)
+}
+
+// WaitBackup polls a backup every interval, DefaultPollInterval when 0,
+// until it completes or fails, calling onUpdate with each state if not
+// nil. A failed backup is returned with an error carrying its message.
+func (c *Client) WaitBackup(ctx context.Context, id string, interval time.Duration, onUpdate func(*Backup)) (*Backup, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b, err := c.GetBackup(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if onUpdate != nil {
+			onUpdate(b)
+		}
+		switch b.Status {
+		case StatusCompleted:
+			return b, nil
+		case StatusFailed:
+			return b, fmt.Errorf("backup %s failed: %s", id, b.Error)
+		}
+		select {
+		case <-ctx.Done():
+			return b, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RestoreBackup restores a backup and waits for the restore to finish
+func (c *Client) RestoreBackup(ctx context.Context, id string, req RestoreRequest) (*Restore, error) {
+	var r Restore
+	if err := c.Do(ctx, http.MethodPost, "/backups/"+url.PathEscape(id)+"/restore", req, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// DownloadBackup streams a backup's artifact to w, as stored: compressed
+// and encrypted if it was. onProgress, if not nil, is called as data
+// arrives. It returns the number of bytes written.
+func (c *Client) DownloadBackup(ctx context.Context, id string, w io.Writer, onProgress func(Progress)) (int64, error) {
+	path := "/backups/" + url.PathEscape(id) + "/download"
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if onProgress != nil {
+		w = &progressWriter{w: w, total: resp.ContentLength, report: onProgress}
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("GET %s: %w", path, err)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return n, fmt.Errorf("GET %s: got %d of %d bytes", path, n, resp.ContentLength)
+	}
+	return n, nil
+}
+
+// progressWriter reports the bytes written through it
+type progressWriter struct {
+	w      io.Writer
+	done   int64
+	total  int64
+	report func(Progress)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	p.report(Progress{Done: p.done, Total: p.total})
+	return n, err
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// SearchRequest searches the catalog of backed up databases, tables and
+// columns. Query uses the server's query syntax, see GET
+// /catalog/query-examples.
+type SearchRequest struct {
+	Query  string            `json:"query"`
+	Filter map[string]string `json:"filter,omitempty"` // e.g. {"database_type": "postgres"}
+	Limit  int               `json:"limit,omitempty"`
+	Offset int               `json:"offset,omitempty"`
+}
+
+// SearchResult is a page of catalog hits. Hits are the server's catalog
+// documents, which differ by kind of object.
+type SearchResult struct {
+	Total int64            `json:"total"`
+	Hits  []map[string]any `json:"hits"`
+}
+
+// SearchCatalog searches the catalog
+func (c *Client) SearchCatalog(ctx context.Context, req SearchRequest) (*SearchResult, error) {
+	var r SearchResult
+	if err := c.Do(ctx, http.MethodPost, "/catalog/search", req, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// SuggestCatalog completes a partial name from the catalog
+func (c *Client) SuggestCatalog(ctx context.Context, prefix string, limit int) ([]string, error) {
+	q := url.Values{"q": {prefix}}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var r struct {
+		Suggestions []string `json:"suggestions"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/catalog/suggest?"+q.Encode(), nil, &r); err != nil {
+		return nil, err
+	}
+	return r.Suggestions, nil
+}
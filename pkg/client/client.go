@@ -0,0 +1,322 @@
+// Package client is the Go client of the db-backup server's REST API, for
+// services that trigger backups, manage schedules or search the catalog
+// without shelling out to the CLI:
+//
+//	c := client.New("https://db-backup.internal:8080", client.WithAPIKey(key))
+//	b, err := c.CreateBackup(ctx, client.BackupRequest{Profile: "orders"})
+//	if err == nil {
+//		b, err = c.WaitBackup(ctx, b.ID, 0, func(b *client.Backup) { log.Println(b.Status) })
+//	}
+//
+// Requests are retried with backoff when the server is unavailable or
+// rate-limits them; errors returned by the server are *APIError.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound matches errors for resources the server does not have
+var ErrNotFound = errors.New("not found")
+
+// Defaults of a client
+const (
+	DefaultTimeout    = 30 * time.Second
+	DefaultRetries    = 3
+	DefaultRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 10 * time.Second
+)
+
+// APIError is an error response of the server
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string // e.g. "400 Bad Request"
+	Message    string // what failed, e.g. "Invalid schedule"
+	Err        string // why, e.g. "invalid cron expression"
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == http.StatusNotFound && e.Message == "" {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.Path, ErrNotFound)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("%s %s: %s: %s", e.Method, e.Path, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.Path, e.Status, e.Err)
+}
+
+// Is makes 404 responses match ErrNotFound
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// Client calls the db-backup server API. It is safe for concurrent use.
+type Client struct {
+	base       string
+	httpClient *http.Client
+	userAgent  string
+	retries    int
+	retryDelay time.Duration
+
+	mu    sync.RWMutex
+	token string
+}
+
+// Option configures a client
+type Option func(*Client)
+
+// WithAPIKey authenticates with an API key, or a token from Login
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.token = key }
+}
+
+// WithHTTPClient sends requests through hc, e.g. one with client
+// certificates for servers that require them
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		if hc != nil {
+			c.httpClient = hc
+		}
+	}
+}
+
+// WithRetries retries failed requests up to n times, waiting delay before
+// the first retry and doubling it after each. n = 0 disables retries.
+func WithRetries(n int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.retries = n
+		c.retryDelay = delay
+	}
+}
+
+// WithUserAgent sets the User-Agent of requests
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New creates a client of the server at baseURL, e.g.
+// http://db-backup:8080
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		base:       strings.TrimRight(baseURL, "/") + "/api/v1",
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+		userAgent:  "db-backup-client",
+		retries:    DefaultRetries,
+		retryDelay: DefaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetToken replaces the API key or token requests authenticate with
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+// Session is the token issued by Login
+type Session struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SessionID string    `json:"session_id,omitempty"`
+	User      struct {
+		Username string   `json:"username"`
+		Role     string   `json:"role"`
+		Groups   []string `json:"groups,omitempty"`
+	} `json:"user"`
+}
+
+// Login exchanges a username and password for a token, which later
+// requests of the client authenticate with
+func (c *Client) Login(ctx context.Context, username, password string) (*Session, error) {
+	var s Session
+	req := map[string]string{"username": username, "password": password}
+	if err := c.Do(ctx, http.MethodPost, "/auth/login", req, &s); err != nil {
+		return nil, err
+	}
+	c.SetToken(s.Token)
+	return &s, nil
+}
+
+// Logout revokes the client's token
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.Do(ctx, http.MethodPost, "/auth/logout", nil, nil); err != nil {
+		return err
+	}
+	c.SetToken("")
+	return nil
+}
+
+// Version returns the server's version information
+func (c *Client) Version(ctx context.Context) (map[string]any, error) {
+	var v map[string]any
+	if err := c.Do(ctx, http.MethodGet, "/version", nil, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Do sends a request to path under /api/v1 and decodes the data of a
+// successful response into out, for endpoints the client has no method
+// for. body is sent as JSON unless nil.
+func (c *Client) Do(ctx context.Context, method, path string, body, out any) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("%s %s: invalid response: %w", method, path, err)
+	}
+	if len(envelope.Data) == 0 {
+		return fmt.Errorf("%s %s: response has no data", method, path)
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+// send sends a request, retrying while it may succeed later, and returns a
+// successful response. Error responses are returned as *APIError.
+func (c *Client) send(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, method, path, data, body != nil)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		wait := delay
+		retry := attempt < c.retries
+		if err != nil {
+			// The server may have acted on a request that failed in
+			// flight, so only requests safe to repeat are
+			retry = retry && idempotent(method) && ctx.Err() == nil
+		} else {
+			apiErr := responseError(method, path, resp)
+			if retry = retry && retryable(method, resp.StatusCode); retry {
+				if after := retryAfter(resp); after > 0 {
+					wait = after
+				}
+			}
+			err = apiErr
+		}
+		if !retry {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// attempt sends a request once
+func (c *Client) attempt(ctx context.Context, method, path string, data []byte, hasBody bool) (*http.Response, error) {
+	var reader io.Reader
+	if hasBody {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if hasBody {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.mu.RLock()
+	token := c.token
+	c.mu.RUnlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.httpClient.Do(req)
+}
+
+// responseError reads an error response, closing its body
+func responseError(method, path string, resp *http.Response) *APIError {
+	defer resp.Body.Close()
+	e := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+	var body struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) != nil || body.Error == "" {
+		body.Error = strings.TrimSpace(string(data))
+	}
+	e.Err, e.Message = body.Error, body.Message
+	return e
+}
+
+// idempotent reports whether repeating a request has no further effect
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether a status may go away: rate limits and an
+// unavailable server refused the request, so any request is retried;
+// gateway errors only for idempotent requests
+func retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
+
+// retryAfter returns the delay a Retry-After header asks for
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return min(time.Duration(secs)*time.Second, maxRetryDelay)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return min(time.Until(t), maxRetryDelay)
+	}
+	return 0
+}
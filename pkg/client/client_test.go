@@ -0,0 +1,143 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, h http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return New(srv.URL+"/", append([]Option{WithRetries(3, time.Millisecond)}, opts...)...)
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"data":{"id":"bk-1","status":"running"}}`)
+	})
+	b, err := c.CreateBackup(context.Background(), BackupRequest{Profile: "orders"})
+	if err != nil || b.ID != "bk-1" || calls.Load() != 3 {
+		t.Fatalf("CreateBackup() = %+v, %v after %d calls", b, err, calls.Load())
+	}
+
+	// A gateway error may hide a backup that started, so POSTs are not
+	// repeated
+	calls.Store(0)
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	if _, err := c.CreateBackup(context.Background(), BackupRequest{}); err == nil || calls.Load() != 1 {
+		t.Fatalf("CreateBackup() = %v after %d calls", err, calls.Load())
+	}
+	if _, err := c.GetBackup(context.Background(), "bk-1"); err == nil || calls.Load() != 5 {
+		t.Fatalf("GetBackup() = %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/schedules" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid cron expression","message":"Invalid schedule"}`)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"backup not found"}`)
+	})
+	ctx := context.Background()
+	if _, err := c.GetBackup(ctx, "bk-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBackup() of a missing backup = %v", err)
+	}
+	_, err := c.CreateSchedule(ctx, ScheduleRequest{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || errors.Is(err, ErrNotFound) {
+		t.Fatalf("CreateSchedule() = %v", err)
+	}
+	if err.Error() != "POST /schedules: Invalid schedule: invalid cron expression" {
+		t.Errorf("error = %q", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/auth/login":
+			fmt.Fprint(w, `{"success":true,"data":{"token":"jwt","user":{"username":"ana","role":"operator"}}}`)
+		case "/api/v1/schedules":
+			if r.Header.Get("Authorization") != "Bearer jwt" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"success":true,"data":{"schedules":[{"id":"s1","name":"nightly","enabled":true}],"count":1}}`)
+		}
+	})
+	ctx := context.Background()
+	s, err := c.Login(ctx, "ana", "secret")
+	if err != nil || s.User.Role != "operator" {
+		t.Fatalf("Login() = %+v, %v", s, err)
+	}
+	schedules, err := c.ListSchedules(ctx)
+	if err != nil || len(schedules) != 1 || schedules[0].Name != "nightly" {
+		t.Fatalf("ListSchedules() = %+v, %v", schedules, err)
+	}
+}
+
+func TestWaitBackup(t *testing.T) {
+	var polls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		status := "running"
+		if polls.Add(1) == 3 {
+			status = "completed"
+		}
+		fmt.Fprintf(w, `{"success":true,"data":{"id":"bk-1","status":%q}}`, status)
+	})
+	var seen []string
+	b, err := c.WaitBackup(context.Background(), "bk-1", time.Millisecond, func(b *Backup) { seen = append(seen, b.Status) })
+	if err != nil || !b.Done() || strings.Join(seen, ",") != "running,running,completed" {
+		t.Fatalf("WaitBackup() = %+v, %v, updates %v", b, err, seen)
+	}
+
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success":true,"data":{"id":"bk-2","status":"failed","error":"disk full"}}`)
+	})
+	if _, err := c.WaitBackup(context.Background(), "bk-2", time.Millisecond, nil); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("WaitBackup() of a failed backup = %v", err)
+	}
+}
+
+func TestDownloadBackup(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 100<<10)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/backups/bk-1/download" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Write(data)
+	})
+	var buf bytes.Buffer
+	var last Progress
+	n, err := c.DownloadBackup(context.Background(), "bk-1", &buf, func(p Progress) { last = p })
+	if err != nil || n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("DownloadBackup() = %d, %v", n, err)
+	}
+	if last.Done != n || last.Total != n {
+		t.Errorf("last progress = %+v", last)
+	}
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ScheduleRequest creates or replaces a schedule
+type ScheduleRequest struct {
+	Name     string        `json:"name"`
+	Cron     string        `json:"cron"`
+	Timezone string        `json:"timezone,omitempty"`
+	Enabled  bool          `json:"enabled"`
+	Backup   BackupRequest `json:"backup"`
+}
+
+// Schedule is a schedule known to the server
+type Schedule struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Cron     string        `json:"cron,omitempty"`
+	Timezone string        `json:"timezone,omitempty"`
+	Enabled  bool          `json:"enabled"`
+	Backup   BackupRequest `json:"backup,omitempty"`
+	NextRun  *time.Time    `json:"next_run,omitempty"`
+	LastRun  *time.Time    `json:"last_run,omitempty"`
+}
+
+// ListSchedules lists the schedules
+func (c *Client) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	var list struct {
+		Schedules []Schedule `json:"schedules"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/schedules", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Schedules, nil
+}
+
+// CreateSchedule creates a schedule
+func (c *Client) CreateSchedule(ctx context.Context, req ScheduleRequest) (*Schedule, error) {
+	var s Schedule
+	if err := c.Do(ctx, http.MethodPost, "/schedules", req, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSchedule returns a schedule
+func (c *Client) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	var s Schedule
+	if err := c.Do(ctx, http.MethodGet, "/schedules/"+url.PathEscape(id), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// UpdateSchedule replaces a schedule
+func (c *Client) UpdateSchedule(ctx context.Context, id string, req ScheduleRequest) (*Schedule, error) {
+	var s Schedule
+	if err := c.Do(ctx, http.MethodPut, "/schedules/"+url.PathEscape(id), req, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// DeleteSchedule deletes a schedule
+func (c *Client) DeleteSchedule(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/schedules/"+url.PathEscape(id), nil, nil)
+}
+
+// EnableSchedule resumes a schedule
+func (c *Client) EnableSchedule(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodPost, "/schedules/"+url.PathEscape(id)+"/enable", nil, nil)
+}
+
+// DisableSchedule pauses a schedule
+func (c *Client) DisableSchedule(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodPost, "/schedules/"+url.PathEscape(id)+"/disable", nil, nil)
+}
+
+// RunSchedule starts a backup of a schedule now
+func (c *Client) RunSchedule(ctx context.Context, id string) (*Backup, error) {
+	var b Backup
+	if err := c.Do(ctx, http.MethodPost, "/schedules/"+url.PathEscape(id)+"/run", nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
@@ -0,0 +1,60 @@
+// Package driverplugin builds database drivers for db-backup as separate
+// executables, so drivers for databases db-backup does not support can be
+// shipped without forking it. A plugin's main serves one driver:
+//
+//	func main() {
+//		driverplugin.Serve(func() driverplugin.Driver { return &acmeDriver{} })
+//	}
+//
+// Installed as dbbackup-driver-<type> (dbbackup-driver-<type>.exe on
+// Windows) in plugins.directory, the plugin is found at startup and backs
+// up connections of type <type>. db-backup starts it for every connection
+// and stops it on Disconnect. Anything a plugin prints to stdout or stderr
+// ends up on db-backup's stderr.
+package driverplugin
+
+import (
+	"github.com/sanskarpan/db-backup/internal/database"
+	"github.com/sanskarpan/db-backup/internal/database/plugin"
+)
+
+// The driver interface and the types it uses
+type (
+	Driver           = database.Driver
+	DatabaseType     = database.DatabaseType
+	ConnectionConfig = database.ConnectionConfig
+	BackupOptions    = database.BackupOptions
+	RestoreOptions   = database.RestoreOptions
+	BackupResult     = database.BackupResult
+	RestoreResult    = database.RestoreResult
+	TableInfo        = database.TableInfo
+	BackupStatus     = database.BackupStatus
+	RestoreStatus    = database.RestoreStatus
+	CompressionType  = database.CompressionType
+)
+
+// Statuses of backup and restore results
+const (
+	BackupStatusSuccess  = database.BackupStatusSuccess
+	BackupStatusFailed   = database.BackupStatusFailed
+	RestoreStatusSuccess = database.RestoreStatusSuccess
+	RestoreStatusFailed  = database.RestoreStatusFailed
+)
+
+// Compressions requested in BackupOptions
+const (
+	CompressionNone = database.CompressionNone
+	CompressionGzip = database.CompressionGzip
+	CompressionZstd = database.CompressionZstd
+	CompressionLZ4  = database.CompressionLZ4
+)
+
+// ProtocolVersion is the version of the plugin protocol Serve speaks.
+// db-backup refuses plugins built for another version.
+const ProtocolVersion = plugin.ProtocolVersion
+
+// Serve serves the driver newDriver creates to db-backup until it stops the
+// plugin. It exits when the executable is not started by db-backup.
+func Serve(newDriver func() Driver) {
+	plugin.Serve(newDriver)
+}
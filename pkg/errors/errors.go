@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"runtime"
+
+	"github.com/sanskarpan/db-backup/pkg/redact"
 )
 
 // ErrorType represents the category of error
@@ -44,12 +46,13 @@ type BackupError struct {
 	Metadata   map[string]interface{}
 }
 
-// Error implements the error interface
+// Error implements the error interface. Credentials in the message or the
+// wrapped error (e.g. a connection string echoed by a driver) are redacted.
 func (e *BackupError) Error() string {
 	if e.Err != nil {
-		return fmt.Sprintf("[%s] %s: %v", e.Type, e.Message, e.Err)
+		return redact.String(fmt.Sprintf("[%s] %s: %v", e.Type, e.Message, e.Err))
 	}
-	return fmt.Sprintf("[%s] %s", e.Type, e.Message)
+	return redact.String(fmt.Sprintf("[%s] %s", e.Type, e.Message))
 }
 
 // Unwrap returns the wrapped error
@@ -90,12 +93,13 @@ func Wrap(err error, errType ErrorType, message string) *BackupError {
 	}
 }
 
-// WithMetadata adds metadata to the error
+// WithMetadata adds metadata to the error. Secret values are redacted
+// before they are stored.
 func (e *BackupError) WithMetadata(key string, value interface{}) *BackupError {
 	if e.Metadata == nil {
 		e.Metadata = make(map[string]interface{})
 	}
-	e.Metadata[key] = value
+	e.Metadata[key] = redact.Default().Value(key, value)
 	return e
 }
 
@@ -123,7 +127,7 @@ func captureStackTrace() string {
 			break
 		}
 	}
-	return stackTrace
+	return redact.String(stackTrace)
 }
 
 // Common error constructors
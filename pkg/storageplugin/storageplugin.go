@@ -0,0 +1,41 @@
+// Package storageplugin builds storage providers for db-backup as separate
+// executables, so targets db-backup does not support, such as tape
+// gateways or proprietary object stores, can be added without forking it.
+// A plugin's main serves one provider:
+//
+//	func main() {
+//		storageplugin.Serve(&acmeProvider{})
+//	}
+//
+// Installed as dbbackup-storage-<name> (dbbackup-storage-<name>.exe on
+// Windows) in plugins.directory and enabled under
+// storage.providers.plugins.<name>, the plugin is started by db-backup,
+// receives the provider's options through Configure and serves uploads,
+// downloads and listings until db-backup stops it. Anything a plugin
+// prints to stdout or stderr ends up on db-backup's stderr. See
+// examples/storage-plugin for a complete provider.
+package storageplugin
+
+import (
+	"github.com/sanskarpan/db-backup/internal/storage/plugin"
+)
+
+// The provider interface and the types it uses
+type (
+	Provider = plugin.Provider
+	Object   = plugin.Object
+)
+
+// ErrNotFound is returned, possibly wrapped, for a key the provider does
+// not hold. db-backup tells it apart from other failures.
+var ErrNotFound = plugin.ErrNotFound
+
+// ProtocolVersion is the version of the plugin protocol Serve speaks.
+// db-backup refuses plugins built for another version.
+const ProtocolVersion = plugin.ProtocolVersion
+
+// Serve serves provider to db-backup until it stops the plugin. It exits
+// when the executable is not started by db-backup.
+func Serve(provider Provider) {
+	plugin.Serve(provider)
+}
@@ -0,0 +1,176 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned by writes to a closed ChunkWriter
+var ErrClosed = errors.New("chunk writer is closed")
+
+// Part is one chunk of a stream handed to a PartFunc
+type Part struct {
+	Number int   // 1-based, as multipart upload APIs number parts
+	Offset int64 // of the first byte within the stream
+	Data   []byte
+}
+
+// PartFunc uploads one part. Data is only valid until it returns.
+type PartFunc func(ctx context.Context, part Part) error
+
+// ChunkWriter splits a stream into fixed-size parts and uploads up to
+// concurrency parts at once while the stream is still being written, so a
+// dump and its upload overlap instead of running one after the other. A
+// write blocks while every part buffer is in flight, which bounds memory
+// to concurrency+1 parts.
+type ChunkWriter struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	pool   *Pool
+	sem    chan struct{}
+	put    PartFunc
+
+	buf     *[]byte
+	n       int
+	parts   int
+	written int64
+	closed  bool
+
+	wg  sync.WaitGroup
+	mu  sync.Mutex
+	err error
+}
+
+// NewChunkWriter uploads what is written to it as partSize parts with put,
+// running up to concurrency uploads at once
+func NewChunkWriter(ctx context.Context, partSize, concurrency int, put PartFunc) *ChunkWriter {
+	concurrency = max(concurrency, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	return &ChunkWriter{
+		ctx:    ctx,
+		cancel: cancel,
+		pool:   NewPool(partSize, int64(partSize)*int64(concurrency+1)),
+		sem:    make(chan struct{}, concurrency),
+		put:    put,
+	}
+}
+
+// Write implements io.Writer
+func (w *ChunkWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	var written int
+	for len(p) > 0 {
+		if err := w.failure(); err != nil {
+			return written, err
+		}
+		if w.buf == nil {
+			buf, err := w.pool.Get(w.ctx)
+			if err != nil {
+				return written, w.firstError(err)
+			}
+			w.buf = buf
+		}
+		n := copy((*w.buf)[w.n:], p)
+		w.n += n
+		written += n
+		p = p[n:]
+		if w.n == len(*w.buf) {
+			w.dispatch()
+		}
+	}
+	return written, nil
+}
+
+// Close uploads the last part and waits for every upload to finish. It
+// returns the first upload error. A stream with no data is uploaded as one
+// empty part.
+func (w *ChunkWriter) Close() error {
+	if w.closed {
+		return w.failure()
+	}
+	w.closed = true
+	if w.n > 0 || w.parts == 0 {
+		if w.buf == nil {
+			buf, err := w.pool.Get(w.ctx)
+			if err != nil {
+				w.wg.Wait()
+				w.cancel()
+				return w.firstError(err)
+			}
+			w.buf = buf
+		}
+		w.dispatch()
+	}
+	w.wg.Wait()
+	w.cancel()
+	return w.failure()
+}
+
+// Abort stops the uploads in flight and discards unwritten data. It waits
+// for the running uploads to return.
+func (w *ChunkWriter) Abort() {
+	w.closed = true
+	w.cancel()
+	w.wg.Wait()
+	if w.buf != nil {
+		w.pool.Put(w.buf)
+		w.buf = nil
+	}
+}
+
+// Parts returns the number of parts handed to the PartFunc so far
+func (w *ChunkWriter) Parts() int {
+	return w.parts
+}
+
+// Written returns the number of bytes dispatched as parts so far
+func (w *ChunkWriter) Written() int64 {
+	return w.written
+}
+
+// dispatch uploads the current buffer in the background
+func (w *ChunkWriter) dispatch() {
+	w.parts++
+	part := Part{Number: w.parts, Offset: w.written, Data: (*w.buf)[:w.n]}
+	buf := w.buf
+	w.written += int64(w.n)
+	w.buf, w.n = nil, 0
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer w.pool.Put(buf)
+		select {
+		case w.sem <- struct{}{}:
+			defer func() { <-w.sem }()
+		case <-w.ctx.Done():
+			w.firstError(w.ctx.Err())
+			return
+		}
+		if err := w.put(w.ctx, part); err != nil {
+			w.firstError(err)
+		}
+	}()
+}
+
+// firstError records err if it is the first failure and cancels the other
+// uploads. It returns the first failure.
+func (w *ChunkWriter) firstError(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+	return w.err
+}
+
+// failure returns the first upload error
+func (w *ChunkWriter) failure() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
@@ -0,0 +1,162 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recorder collects uploaded parts and the highest number of concurrent
+// uploads
+type recorder struct {
+	mu      sync.Mutex
+	parts   map[int][]byte
+	offsets map[int]int64
+	active  atomic.Int32
+	peak    atomic.Int32
+	delay   time.Duration
+	fail    int
+}
+
+func (r *recorder) put(ctx context.Context, p Part) error {
+	n := r.active.Add(1)
+	defer r.active.Add(-1)
+	for {
+		peak := r.peak.Load()
+		if n <= peak || r.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if p.Number == r.fail {
+		return errors.New("upload rejected")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.parts[p.Number] = append([]byte(nil), p.Data...)
+	r.offsets[p.Number] = p.Offset
+	return nil
+}
+
+func (r *recorder) assembled() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	numbers := make([]int, 0, len(r.parts))
+	for n := range r.parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	var out []byte
+	for _, n := range numbers {
+		out = append(out, r.parts[n]...)
+	}
+	return out
+}
+
+func newRecorder(delay time.Duration) *recorder {
+	return &recorder{parts: map[int][]byte{}, offsets: map[int]int64{}, delay: delay}
+}
+
+func TestChunkWriterUploadsInParallel(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096+7) // 16 full 4KB parts and a short one
+	rec := newRecorder(10 * time.Millisecond)
+
+	w := NewChunkWriter(context.Background(), 4<<10, 3, rec.put)
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Parts() != 17 || w.Written() != int64(len(data)) {
+		t.Fatalf("Parts() = %d, Written() = %d", w.Parts(), w.Written())
+	}
+	if !bytes.Equal(rec.assembled(), data) {
+		t.Fatal("parts do not reassemble to the stream")
+	}
+	if rec.offsets[2] != 4<<10 {
+		t.Fatalf("offset of part 2 = %d", rec.offsets[2])
+	}
+	if peak := rec.peak.Load(); peak < 2 || peak > 3 {
+		t.Fatalf("peak concurrent uploads = %d, want 2-3", peak)
+	}
+}
+
+func TestChunkWriterOverlapsWrites(t *testing.T) {
+	started := make(chan struct{}, 1)
+	w := NewChunkWriter(context.Background(), 4<<10, 2, func(ctx context.Context, p Part) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	if _, err := w.Write(make([]byte, 4<<10)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the first part was not uploaded before Close")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChunkWriterFailure(t *testing.T) {
+	rec := newRecorder(time.Millisecond)
+	rec.fail = 2
+
+	w := NewChunkWriter(context.Background(), 4<<10, 2, rec.put)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = w.Write(make([]byte, 4<<10))
+	}
+	if cerr := w.Close(); cerr == nil || cerr.Error() != "upload rejected" {
+		t.Fatalf("Close() = %v, want the upload error", cerr)
+	}
+	if _, err := w.Write([]byte("x")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Write() after Close() = %v", err)
+	}
+}
+
+func TestChunkWriterEmptyStream(t *testing.T) {
+	rec := newRecorder(0)
+	w := NewChunkWriter(context.Background(), 4<<10, 2, rec.put)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Parts() != 1 || len(rec.parts[1]) != 0 {
+		t.Fatalf("Parts() = %d, want one empty part", w.Parts())
+	}
+}
+
+func TestChunkWriterAbort(t *testing.T) {
+	rec := newRecorder(time.Hour)
+	w := NewChunkWriter(context.Background(), 4<<10, 2, rec.put)
+	if _, err := w.Write(make([]byte, 8<<10)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		w.Abort()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Abort() did not stop the uploads in flight")
+	}
+}
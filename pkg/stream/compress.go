@@ -0,0 +1,49 @@
+package stream
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compress returns a writer compressing into w with codec (gzip, zstd, lz4
+// or none) at level (1-9, 0 for the codec's default), which Decompress
+// detects when the stream is read back. Close flushes the stream; it does
+// not close w.
+func Compress(w io.Writer, codec string, level int) (io.WriteCloser, error) {
+	if level != 0 {
+		level = min(max(level, 1), 9)
+	}
+	switch codec {
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case "zstd":
+		opts := []zstd.EOption{}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	case "lz4":
+		zw := lz4.NewWriter(w)
+		if level != 0 {
+			if err := zw.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(1 << (8 + level)))); err != nil {
+				return nil, err
+			}
+		}
+		return zw, nil
+	case "none", "":
+		return nopCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", codec)
+}
+
+// nopCloser adds a Close that does nothing to a writer
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Leading bytes of compressed streams
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicLZ4  = []byte{0x04, 0x22, 0x4d, 0x18}
+)
+
+// Decompress returns a reader of the data in r and the name of the codec
+// it was compressed with (gzip, zstd, lz4 or none), detected from its
+// leading bytes
+func Decompress(r io.Reader) (io.ReadCloser, string, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+	switch {
+	case bytes.HasPrefix(head, magicGzip):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, "", err
+		}
+		return zr, "gzip", nil
+	case bytes.HasPrefix(head, magicZstd):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, "", err
+		}
+		return zr.IOReadCloser(), "zstd", nil
+	case bytes.HasPrefix(head, magicLZ4):
+		return io.NopCloser(lz4.NewReader(br)), "lz4", nil
+	}
+	return io.NopCloser(br), "none", nil
+}
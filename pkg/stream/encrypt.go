@@ -0,0 +1,500 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Encrypted artifacts are a header followed by chunks of plaintext sealed
+// one at a time, so they are decrypted as they are read and any range of
+// them can be decrypted on its own:
+//
+//	magic (8) | cipher (1) | chunk size (4) | salt (32)
+//	chunk 0 | chunk 1 | ... | final chunk
+//
+// Every chunk but the final one holds chunk size bytes of plaintext and
+// the final one fewer, possibly none, each followed by its tag. The chunk
+// key is derived from the master key and the salt, which is random, so the
+// nonce of a chunk is its sequence number and a flag set on the final
+// chunk: chunks reordered, dropped or appended, and streams cut short, all
+// fail to authenticate. The header is authenticated with every chunk.
+
+// Ciphers encrypted artifacts are sealed with
+const (
+	CipherAES256GCM        = "aes-256-gcm"
+	CipherChaCha20Poly1305 = "chacha20-poly1305"
+)
+
+// DefaultChunkSize is the plaintext sealed under one nonce
+const DefaultChunkSize = 64 << 10
+
+const (
+	encryptHeaderSize = 8 + 1 + 4 + 32
+	tagSize           = 16
+	maxChunkSize      = 16 << 20
+	minKeySize        = 32
+)
+
+var magicEncrypted = []byte("DBBKENC1")
+
+var cipherIDs = map[string]byte{CipherAES256GCM: 1, CipherChaCha20Poly1305: 2}
+
+// Errors reading encrypted artifacts
+var (
+	ErrNotEncrypted = errors.New("not an encrypted artifact")
+	ErrTampered     = errors.New("encrypted artifact failed authentication")
+	ErrTruncated    = errors.New("encrypted artifact is truncated")
+)
+
+// IsEncrypted reports whether head, the leading bytes of an artifact, start
+// an encrypted one
+func IsEncrypted(head []byte) bool {
+	return bytes.HasPrefix(head, magicEncrypted)
+}
+
+// ReadKey returns the master key of keyOrPath: a key given inline as
+// base64:<key> or hex:<key>, or else the path of a file holding the key in
+// either form or as is. A path that cannot be read is an error rather than
+// taken for the key, so a mistyped or moved key file never encrypts with
+// its own name. Keys are at least 32 bytes.
+func ReadKey(keyOrPath string) ([]byte, error) {
+	if IsInlineKey(keyOrPath) {
+		return ParseKey(keyOrPath)
+	}
+	data, err := os.ReadFile(keyOrPath) // #nosec G304 -- configured key file
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+	}
+	return ParseKey(strings.TrimSpace(string(data)))
+}
+
+// ParseKey returns the master key of key material such as the contents
+// of a key file or a secret: base64:<key> and hex:<key> are decoded and
+// anything else is the key as is. Keys are at least 32 bytes.
+func ParseKey(material string) ([]byte, error) {
+	key := []byte(material)
+	if encoded, ok := strings.CutPrefix(material, "base64:"); ok {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 encryption key: %w", err)
+		}
+		key = decoded
+	} else if encoded, ok := strings.CutPrefix(material, "hex:"); ok {
+		decoded, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex encryption key: %w", err)
+		}
+		key = decoded
+	}
+	if len(key) < minKeySize {
+		return nil, fmt.Errorf("encryption key is %d bytes, at least %d are required", len(key), minKeySize)
+	}
+	return key, nil
+}
+
+// IsInlineKey reports whether s is a key given inline, base64:<key> or
+// hex:<key>, rather than the path of a key file
+func IsInlineKey(s string) bool {
+	return strings.HasPrefix(s, "base64:") || strings.HasPrefix(s, "hex:")
+}
+
+// sealer seals and opens the chunks of one artifact
+type sealer struct {
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int
+}
+
+func newSealer(key, header []byte) (*sealer, error) {
+	if len(key) < minKeySize {
+		return nil, fmt.Errorf("encryption key is %d bytes, at least %d are required", len(key), minKeySize)
+	}
+	var name string
+	for n, id := range cipherIDs {
+		if id == header[8] {
+			name = n
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("unknown cipher %d", header[8])
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[9:13]))
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+
+	chunkKey, err := hkdf.Key(sha256.New, key, header[13:encryptHeaderSize], "db-backup chunk key "+name, 32)
+	if err != nil {
+		return nil, err
+	}
+	var aead cipher.AEAD
+	switch name {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(chunkKey)
+		if err != nil {
+			return nil, err
+		}
+		aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	case CipherChaCha20Poly1305:
+		aead, err = chacha20poly1305.New(chunkKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &sealer{aead: aead, header: header, chunkSize: chunkSize}, nil
+}
+
+// nonceFor returns the nonce of chunk seq. It is built afresh on every
+// call, as DecryptReaderAt opens chunks from concurrent ReadAt calls.
+func (s *sealer) nonceFor(seq uint64, final bool) []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], seq)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func (s *sealer) seal(dst, plaintext []byte, seq uint64, final bool) []byte {
+	return s.aead.Seal(dst, s.nonceFor(seq, final), plaintext, s.header)
+}
+
+func (s *sealer) open(dst, chunk []byte, seq uint64, final bool) ([]byte, error) {
+	out, err := s.aead.Open(dst, s.nonceFor(seq, final), chunk, s.header)
+	if err != nil {
+		return nil, fmt.Errorf("%w at chunk %d", ErrTampered, seq)
+	}
+	return out, nil
+}
+
+// EncryptWriter encrypts what is written to it in chunks. Close seals the
+// final chunk and must be called for the artifact to decrypt.
+type EncryptWriter struct {
+	w      io.Writer
+	s      *sealer
+	buf    []byte
+	out    []byte
+	seq    uint64
+	closed bool
+}
+
+// NewEncryptWriter returns a writer encrypting to w with the master key
+// under cipherName (aes-256-gcm or chacha20-poly1305), in chunks of
+// chunkSize bytes of plaintext, or DefaultChunkSize when 0
+func NewEncryptWriter(w io.Writer, key []byte, cipherName string, chunkSize int) (*EncryptWriter, error) {
+	id, ok := cipherIDs[strings.ToLower(cipherName)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cipher %q", cipherName)
+	}
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize < 0 || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+
+	header := make([]byte, encryptHeaderSize)
+	copy(header, magicEncrypted)
+	header[8] = id
+	binary.BigEndian.PutUint32(header[9:13], uint32(chunkSize)) // #nosec G115 -- bounded above
+	if _, err := rand.Read(header[13:]); err != nil {
+		return nil, err
+	}
+	s, err := newSealer(key, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &EncryptWriter{
+		w:   w,
+		s:   s,
+		buf: make([]byte, 0, chunkSize),
+		out: make([]byte, 0, chunkSize+tagSize),
+	}, nil
+}
+
+// Write buffers p, sealing each chunk as it fills
+func (e *EncryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, ErrClosed
+	}
+	var n int
+	for len(p) > 0 {
+		c := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+		if len(e.buf) == cap(e.buf) {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (e *EncryptWriter) flush(final bool) error {
+	e.out = e.s.seal(e.out[:0], e.buf, e.seq, final)
+	e.buf = e.buf[:0]
+	e.seq++
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// Close seals the final chunk. It does not close the underlying writer.
+func (e *EncryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+// readHeader reads and checks the header of an encrypted artifact
+func readHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, encryptHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	if !IsEncrypted(header) {
+		return nil, ErrNotEncrypted
+	}
+	return header, nil
+}
+
+// DecryptReader decrypts an encrypted artifact as it is read. Each chunk is
+// authenticated before any of it is returned, so tampering is reported at
+// the first chunk affected rather than after the whole artifact was read.
+type DecryptReader struct {
+	r     io.Reader
+	s     *sealer
+	chunk []byte
+	plain []byte
+	off   int
+	seq   uint64
+	done  bool
+	err   error
+}
+
+// NewDecryptReader returns a reader of the artifact read from r decrypted
+// with the master key
+func NewDecryptReader(r io.Reader, key []byte) (*DecryptReader, error) {
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newSealer(key, header)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptReader{
+		r:     r,
+		s:     s,
+		chunk: make([]byte, s.chunkSize+tagSize),
+		plain: make([]byte, 0, s.chunkSize),
+	}, nil
+}
+
+func (d *DecryptReader) Read(p []byte) (int, error) {
+	for d.off == len(d.plain) {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.plain[d.off:])
+	d.off += n
+	return n, nil
+}
+
+// next reads and opens the next chunk. Only the final chunk is short.
+func (d *DecryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	final := false
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF):
+		return ErrTruncated
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		if n < tagSize {
+			return ErrTruncated
+		}
+		final = true
+	default:
+		return err
+	}
+	d.plain, d.off = d.plain[:0], 0
+	plain, err := d.s.open(d.plain, d.chunk[:n], d.seq, final)
+	if err != nil {
+		return err
+	}
+	d.plain = plain
+	d.seq++
+	d.done = final
+	return nil
+}
+
+// DecryptReaderAt decrypts ranges of an encrypted artifact without reading
+// what comes before them, so part of a backup can be restored on its own
+type DecryptReaderAt struct {
+	r      io.ReaderAt
+	s      *sealer
+	chunks int64
+	size   int64
+}
+
+// NewDecryptReaderAt returns a reader of the size bytes long artifact r
+// decrypted with the master key
+func NewDecryptReaderAt(r io.ReaderAt, size int64, key []byte) (*DecryptReaderAt, error) {
+	header, err := readHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	s, err := newSealer(key, header)
+	if err != nil {
+		return nil, err
+	}
+	stride := int64(s.chunkSize + tagSize)
+	body := size - encryptHeaderSize
+	chunks := (body + stride - 1) / stride
+	last := body - (chunks-1)*stride
+	if chunks == 0 || last < tagSize || last == stride {
+		return nil, ErrTruncated
+	}
+	return &DecryptReaderAt{
+		r:      r,
+		s:      s,
+		chunks: chunks,
+		size:   (chunks-1)*int64(s.chunkSize) + last - tagSize,
+	}, nil
+}
+
+// Size returns the size of the decrypted artifact
+func (d *DecryptReaderAt) Size() int64 {
+	return d.size
+}
+
+// ReadAt decrypts len(p) bytes from off, authenticating every chunk they
+// span
+func (d *DecryptReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	chunkSize := int64(d.s.chunkSize)
+	stride := chunkSize + tagSize
+	chunk := make([]byte, stride)
+	var plain []byte
+	var n int
+	for n < len(p) && off < d.size {
+		seq := off / chunkSize
+		final := seq == d.chunks-1
+		length := stride
+		if final {
+			length = d.size - seq*chunkSize + tagSize
+		}
+		if _, err := d.r.ReadAt(chunk[:length], encryptHeaderSize+seq*stride); err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, ErrTruncated
+			}
+			return n, err
+		}
+		var err error
+		plain, err = d.s.open(plain[:0], chunk[:length], uint64(seq), final) // #nosec G115 -- non-negative
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], plain[off-seq*chunkSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Decrypt returns a reader of r decrypted with the master key when it is an
+// encrypted artifact, and r itself otherwise. key is only read for
+// encrypted artifacts, which fail without one.
+func Decrypt(r io.Reader, key func() ([]byte, error)) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(magicEncrypted))
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	if !IsEncrypted(head) {
+		return br, false, nil
+	}
+	k, err := key()
+	if err != nil {
+		return nil, true, err
+	}
+	dr, err := NewDecryptReader(br, k)
+	if err != nil {
+		return nil, true, err
+	}
+	return dr, true, nil
+}
+
+// DecryptLegacy decrypts an artifact in the whole-file format encrypted
+// artifacts were written in before they were sealed in chunks: a 12-byte
+// nonce followed by the whole artifact sealed with AES-256-GCM. A master
+// key of 32 bytes was used as the AES key and any other hashed into one
+// with SHA-256. The format has no header to detect it by, and the
+// artifact is authenticated as a whole, so it is read into memory.
+func DecryptLegacy(r io.Reader, key []byte) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	const nonceSize = 12
+	if len(data) < nonceSize+tagSize {
+		return nil, ErrTruncated
+	}
+	keys := [][]byte{}
+	if len(key) == 32 {
+		keys = append(keys, key)
+	}
+	sum := sha256.Sum256(key)
+	keys = append(keys, sum[:])
+	for _, k := range keys {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if plain, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], nil); err == nil {
+			return bytes.NewReader(plain), nil
+		}
+	}
+	return nil, ErrTampered
+}
@@ -0,0 +1,247 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte("k"), 32)
+
+func encrypt(t *testing.T, plain []byte, cipherName string, chunkSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, testKey, cipherName, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, cipherName := range []string{CipherAES256GCM, CipherChaCha20Poly1305} {
+		for _, size := range []int{0, 1, 99, 100, 101, 1000} {
+			plain := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+			sealed := encrypt(t, plain, cipherName, 100)
+			if !IsEncrypted(sealed) {
+				t.Fatalf("%s/%d: not detected as encrypted", cipherName, size)
+			}
+
+			r, err := NewDecryptReader(bytes.NewReader(sealed), testKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s/%d: %v", cipherName, size, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("%s/%d: round trip mismatch", cipherName, size)
+			}
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	plain := bytes.Repeat([]byte("x"), 350)
+	sealed := encrypt(t, plain, CipherAES256GCM, 100)
+
+	tests := map[string][]byte{
+		"flipped byte":   append([]byte{}, sealed...),
+		"truncated":      sealed[:encryptHeaderSize+2*(100+tagSize)],
+		"last chunk cut": sealed[:len(sealed)-1],
+		"chunks swapped": append(append(append([]byte{}, sealed[:encryptHeaderSize]...),
+			sealed[encryptHeaderSize+116:encryptHeaderSize+232]...),
+			sealed[encryptHeaderSize:encryptHeaderSize+116]...),
+	}
+	tests["flipped byte"][encryptHeaderSize+150] ^= 1
+
+	for name, data := range tests {
+		r, err := NewDecryptReader(bytes.NewReader(data), testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); !errors.Is(err, ErrTampered) && !errors.Is(err, ErrTruncated) {
+			t.Errorf("%s: error = %v", name, err)
+		}
+	}
+
+	// Tampering is found at the chunk it is in, before the rest is read
+	r, _ := NewDecryptReader(bytes.NewReader(tests["flipped byte"]), testKey)
+	got, err := io.ReadAll(r)
+	if !errors.Is(err, ErrTampered) || len(got) != 100 {
+		t.Errorf("read %d bytes before %v", len(got), err)
+	}
+
+	other := bytes.Repeat([]byte("o"), 32)
+	r, _ = NewDecryptReader(bytes.NewReader(sealed), other)
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrTampered) {
+		t.Errorf("wrong key: error = %v", err)
+	}
+}
+
+func TestDecryptReaderAt(t *testing.T) {
+	plain := make([]byte, 1234)
+	for i := range plain {
+		plain[i] = byte(i)
+	}
+	sealed := encrypt(t, plain, CipherChaCha20Poly1305, 100)
+
+	r, err := NewDecryptReaderAt(bytes.NewReader(sealed), int64(len(sealed)), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(plain)) {
+		t.Fatalf("size = %d, want %d", r.Size(), len(plain))
+	}
+	for _, rng := range [][2]int{{0, 10}, {95, 110}, {150, 450}, {1200, 1234}} {
+		p := make([]byte, rng[1]-rng[0])
+		if _, err := r.ReadAt(p, int64(rng[0])); err != nil {
+			t.Fatalf("ReadAt(%v): %v", rng, err)
+		}
+		if !bytes.Equal(p, plain[rng[0]:rng[1]]) {
+			t.Errorf("ReadAt(%v) mismatch", rng)
+		}
+	}
+	if n, err := r.ReadAt(make([]byte, 10), 1230); n != 4 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v", n, err)
+	}
+
+	// ReadAt is safe for concurrent use, as io.ReaderAt requires
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(off int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				p := make([]byte, 150)
+				if _, err := r.ReadAt(p, int64(off)); err != nil || !bytes.Equal(p, plain[off:off+150]) {
+					t.Errorf("concurrent ReadAt(%d): %v", off, err)
+					return
+				}
+			}
+		}(i * 130)
+	}
+	wg.Wait()
+
+	// Only the chunks read are authenticated
+	sealed[encryptHeaderSize+5] ^= 1
+	if _, err := r.ReadAt(make([]byte, 10), 500); err != nil {
+		t.Errorf("ReadAt of an untouched chunk: %v", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 0); !errors.Is(err, ErrTampered) {
+		t.Errorf("ReadAt of a tampered chunk: %v", err)
+	}
+
+	// A cut final chunk fails when it is read
+	cut, err := NewDecryptReaderAt(bytes.NewReader(sealed), int64(len(sealed)-20), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cut.ReadAt(make([]byte, 5), 1205); !errors.Is(err, ErrTampered) {
+		t.Errorf("ReadAt of a cut final chunk: %v", err)
+	}
+	if _, err := NewDecryptReaderAt(bytes.NewReader(sealed), encryptHeaderSize+100+tagSize, testKey); !errors.Is(err, ErrTruncated) {
+		t.Errorf("artifact cut at a chunk boundary: %v", err)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	noKey := func() ([]byte, error) { return nil, errors.New("no key") }
+	r, encrypted, err := Decrypt(bytes.NewReader([]byte("plain dump")), noKey)
+	if err != nil || encrypted {
+		t.Fatalf("plain artifact: encrypted %v, %v", encrypted, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "plain dump" {
+		t.Errorf("plain artifact read %q", got)
+	}
+
+	sealed := encrypt(t, []byte("secret dump"), CipherAES256GCM, 0)
+	if _, _, err := Decrypt(bytes.NewReader(sealed), noKey); err == nil {
+		t.Error("encrypted artifact read without a key")
+	}
+	r, encrypted, err = Decrypt(bytes.NewReader(sealed), func() ([]byte, error) { return testKey, nil })
+	if err != nil || !encrypted {
+		t.Fatalf("encrypted artifact: encrypted %v, %v", encrypted, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "secret dump" {
+		t.Errorf("encrypted artifact read %q", got)
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	// Whole-file artifacts sealed with the key itself and with its hash
+	hashed := sha256.Sum256([]byte("short key"))
+	for _, tc := range []struct{ key, aesKey []byte }{
+		{testKey, testKey},
+		{[]byte("short key"), hashed[:]},
+	} {
+		block, _ := aes.NewCipher(tc.aesKey)
+		aead, _ := cipher.NewGCM(block)
+		nonce := bytes.Repeat([]byte{7}, aead.NonceSize())
+		sealed := aead.Seal(nonce, nonce, []byte("legacy dump"), nil)
+
+		r, err := DecryptLegacy(bytes.NewReader(sealed), tc.key)
+		if err != nil {
+			t.Fatalf("key of %d bytes: %v", len(tc.key), err)
+		}
+		if got, _ := io.ReadAll(r); string(got) != "legacy dump" {
+			t.Errorf("key of %d bytes read %q", len(tc.key), got)
+		}
+		sealed[len(sealed)-1] ^= 1
+		if _, err := DecryptLegacy(bytes.NewReader(sealed), tc.key); !errors.Is(err, ErrTampered) {
+			t.Errorf("tampered artifact: %v", err)
+		}
+	}
+	if _, err := DecryptLegacy(bytes.NewReader([]byte("short")), testKey); !errors.Is(err, ErrTruncated) {
+		t.Errorf("truncated artifact: %v", err)
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup.key")
+	os.WriteFile(path, append(testKey, '\n'), 0600)
+	encoded := filepath.Join(dir, "encoded.key")
+	os.WriteFile(encoded, []byte("base64:"+base64.StdEncoding.EncodeToString(testKey)), 0600)
+
+	for _, keyOrPath := range []string{
+		path,
+		encoded,
+		"base64:" + base64.StdEncoding.EncodeToString(testKey),
+		"hex:" + hex.EncodeToString(testKey),
+	} {
+		if key, err := ReadKey(keyOrPath); err != nil || !bytes.Equal(key, testKey) {
+			t.Errorf("ReadKey(%s) = %q, %v", keyOrPath, key, err)
+		}
+	}
+	// A path that does not exist is never taken for the key
+	for _, keyOrPath := range []string{
+		filepath.Join(dir, "missing-key-file-with-a-long-name.key"),
+		string(testKey),
+		"hex:" + hex.EncodeToString(testKey[:16]),
+		"base64:not base64",
+	} {
+		if key, err := ReadKey(keyOrPath); err == nil {
+			t.Errorf("ReadKey(%s) = %q", keyOrPath, key)
+		}
+	}
+	if key, err := ParseKey(string(testKey)); err != nil || !bytes.Equal(key, testKey) {
+		t.Errorf("ParseKey() = %q, %v", key, err)
+	}
+}
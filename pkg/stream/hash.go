@@ -0,0 +1,63 @@
+package stream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// HashWriter passes writes through to an underlying writer while computing
+// their SHA-256, so an artifact's checksum is known once it is written
+// without reading it back
+type HashWriter struct {
+	w       io.Writer
+	h       hash.Hash
+	written int64
+}
+
+// NewHashWriter hashes everything written to w. A nil w only hashes.
+func NewHashWriter(w io.Writer) *HashWriter {
+	if w == nil {
+		w = io.Discard
+	}
+	return &HashWriter{w: w, h: sha256.New()}
+}
+
+// Write implements io.Writer. Only the bytes accepted by the underlying
+// writer are hashed.
+func (hw *HashWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[:n])
+	hw.written += int64(n)
+	return n, err
+}
+
+// Sum returns the hex-encoded SHA-256 of the bytes written so far
+func (hw *HashWriter) Sum() string {
+	return hex.EncodeToString(hw.h.Sum(nil))
+}
+
+// Written returns the number of bytes written so far
+func (hw *HashWriter) Written() int64 {
+	return hw.written
+}
+
+// HashFile returns the hex-encoded SHA-256 and size of a file, read through
+// a buffer from the shared pool
+func HashFile(ctx context.Context, path string) (string, int64, error) {
+	f, err := os.Open(path) // #nosec G304 -- artifact path from backup metadata
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	hw := NewHashWriter(nil)
+	if _, err := Copy(ctx, hw, f); err != nil {
+		return "", 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hw.Sum(), hw.Written(), nil
+}
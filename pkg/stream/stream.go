@@ -0,0 +1,146 @@
+// Package stream moves backup artifacts through fixed-size buffers taken
+// from a bounded pool, so copying, hashing, measuring and uploading an
+// artifact uses the same memory whatever its size. The pool caps the bytes
+// held by all buffers together: once the limit is reached, Get blocks until
+// another copy returns its buffer. The package-level functions use a shared
+// pool sized from backup.buffer_size and backup.max_memory at startup.
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Defaults used until Configure is called
+const (
+	DefaultBufferSize = 1 << 20
+	DefaultMaxMemory  = 256 << 20
+)
+
+// minBufferSize keeps buffers large enough for efficient reads from pipes
+// and files
+const minBufferSize = 4 << 10
+
+// Pool hands out buffers of one size, never more than its memory limit
+// allows at a time
+type Pool struct {
+	size  int
+	slots chan struct{}
+	free  sync.Pool
+}
+
+// NewPool creates a pool of bufferSize buffers holding at most maxMemory
+// bytes. At least one buffer is always available.
+func NewPool(bufferSize int, maxMemory int64) *Pool {
+	n := max(maxMemory/int64(bufferSize), 1)
+	p := &Pool{size: bufferSize, slots: make(chan struct{}, n)}
+	p.free.New = func() any {
+		b := make([]byte, p.size)
+		return &b
+	}
+	return p
+}
+
+// BufferSize is the size of the buffers handed out
+func (p *Pool) BufferSize() int {
+	return p.size
+}
+
+// MaxMemory is the most memory the pool's buffers hold at once
+func (p *Pool) MaxMemory() int64 {
+	return int64(cap(p.slots)) * int64(p.size)
+}
+
+// InUse is the memory held by buffers currently handed out
+func (p *Pool) InUse() int64 {
+	return int64(len(p.slots)) * int64(p.size)
+}
+
+// Get returns a buffer, waiting for one to be returned when the memory
+// limit is reached. The buffer must be given back with Put.
+func (p *Pool) Get(ctx context.Context) (*[]byte, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return p.free.Get().(*[]byte), nil
+}
+
+// Put returns a buffer taken with Get
+func (p *Pool) Put(b *[]byte) {
+	if b == nil || len(*b) != p.size {
+		return
+	}
+	p.free.Put(b)
+	<-p.slots
+}
+
+// Copy copies src to dst through a buffer from the pool, stopping early
+// when ctx is cancelled
+func (p *Pool) Copy(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf, err := p.Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer p.Put(buf)
+
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, rerr := src.Read(*buf)
+		if n > 0 {
+			w, werr := dst.Write((*buf)[:n])
+			written += int64(w)
+			if werr != nil {
+				return written, werr
+			}
+			if w != n {
+				return written, io.ErrShortWrite
+			}
+		}
+		if errors.Is(rerr, io.EOF) {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
+
+var (
+	mu  sync.RWMutex
+	std = NewPool(DefaultBufferSize, DefaultMaxMemory)
+)
+
+// Configure replaces the shared pool. Buffers handed out by the previous
+// pool stay valid and are dropped when returned.
+func Configure(bufferSize int, maxMemory int64) error {
+	if bufferSize < minBufferSize {
+		return fmt.Errorf("buffer size must be at least %d bytes", minBufferSize)
+	}
+	if maxMemory < int64(bufferSize) {
+		return fmt.Errorf("max memory must be at least the buffer size")
+	}
+	mu.Lock()
+	std = NewPool(bufferSize, maxMemory)
+	mu.Unlock()
+	return nil
+}
+
+// Default returns the shared pool
+func Default() *Pool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return std
+}
+
+// Copy copies src to dst through a buffer from the shared pool
+func Copy(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	return Default().Copy(ctx, dst, src)
+}
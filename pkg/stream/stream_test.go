@@ -0,0 +1,129 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoolBoundsMemory(t *testing.T) {
+	p := NewPool(8<<10, 20<<10)
+	if p.MaxMemory() != 16<<10 {
+		t.Fatalf("MaxMemory() = %d, want two buffers", p.MaxMemory())
+	}
+
+	ctx := context.Background()
+	a, _ := p.Get(ctx)
+	b, _ := p.Get(ctx)
+	if p.InUse() != 16<<10 {
+		t.Fatalf("InUse() = %d", p.InUse())
+	}
+
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Get(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get() beyond the limit = %v, want it to block", err)
+	}
+
+	p.Put(a)
+	c, err := p.Get(ctx)
+	if err != nil || len(*c) != 8<<10 {
+		t.Fatalf("Get() after Put() = %v", err)
+	}
+	p.Put(b)
+	p.Put(c)
+	if p.InUse() != 0 {
+		t.Fatalf("InUse() = %d after returning every buffer", p.InUse())
+	}
+}
+
+func TestCopyAndHash(t *testing.T) {
+	data := bytes.Repeat([]byte("backup-data "), 100_000)
+	want := sha256.Sum256(data)
+
+	var out bytes.Buffer
+	hw := NewHashWriter(&out)
+	n, err := NewPool(minBufferSize, minBufferSize).Copy(context.Background(), hw, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || hw.Written() != n || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("copied %d of %d bytes", n, len(data))
+	}
+	if hw.Sum() != hex.EncodeToString(want[:]) {
+		t.Fatalf("Sum() = %s", hw.Sum())
+	}
+
+	path := filepath.Join(t.TempDir(), "dump.sql")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum, size, err := HashFile(context.Background(), path)
+	if err != nil || sum != hw.Sum() || size != n {
+		t.Fatalf("HashFile() = %s, %d, %v", sum, size, err)
+	}
+}
+
+func TestCopyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Copy(ctx, &bytes.Buffer{}, strings.NewReader("data")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Copy() = %v, want context.Canceled", err)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	defer Configure(DefaultBufferSize, DefaultMaxMemory)
+
+	if err := Configure(1024, 1<<20); err == nil {
+		t.Fatal("accepted a buffer below the minimum")
+	}
+	if err := Configure(1<<20, 512<<10); err == nil {
+		t.Fatal("accepted max memory below the buffer size")
+	}
+	if err := Configure(64<<10, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if p := Default(); p.BufferSize() != 64<<10 || p.MaxMemory() != 1<<20 {
+		t.Fatalf("Default() = %d/%d", p.BufferSize(), p.MaxMemory())
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("INSERT INTO orders VALUES (1, 'widget');\n"), 10_000)
+	for _, codec := range []string{"gzip", "zstd", "lz4", "none"} {
+		for _, level := range []int{0, 1, 9} {
+			var out bytes.Buffer
+			w, err := Compress(&out, codec, level)
+			if err != nil {
+				t.Fatalf("%s/%d: %v", codec, level, err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, detected, err := Decompress(&out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, data) || detected != codec {
+				t.Errorf("%s/%d: read back %d bytes as %s, %v", codec, level, len(got), detected, err)
+			}
+		}
+	}
+	if _, err := Compress(io.Discard, "brotli", 0); err == nil {
+		t.Error("unknown codec accepted")
+	}
+}
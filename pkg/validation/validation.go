@@ -72,6 +72,29 @@ func ValidateTableName(name string) error {
 	return nil
 }
 
+// ValidateRoleName validates a database role name, which is passed to
+// client tools and SET ROLE statements
+func ValidateRoleName(name string) error {
+	if name == "" {
+		return fmt.Errorf("role name cannot be empty")
+	}
+
+	if len(name) > 63 {
+		return fmt.Errorf("role name too long (max 63 characters)")
+	}
+
+	// Reject names starting with dash (could be interpreted as flags)
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("role name cannot start with dash")
+	}
+
+	if !DatabaseNameRegex.MatchString(name) {
+		return fmt.Errorf("role name contains invalid characters (only alphanumeric, underscore, and hyphen allowed)")
+	}
+
+	return nil
+}
+
 // ValidateBackupID validates a backup ID to prevent path traversal
 func ValidateBackupID(id string) error {
 	if id == "" {
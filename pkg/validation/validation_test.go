@@ -60,6 +60,31 @@ func TestValidateTableName(t *testing.T) {
 	}
 }
 
+func TestValidateRoleName(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"valid simple", "backup_reader", false},
+		{"valid with hyphen", "restore-writer", false},
+		{"empty", "", true},
+		{"starts with dash", "-role", true},
+		{"contains space", "db owner", true},
+		{"statement injection", "r; DROP ROLE admin", true},
+		{"too long", string(make([]byte, 64)), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRoleName(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRoleName(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestValidateBackupID(t *testing.T) {
 	tests := []struct {
 		name    string